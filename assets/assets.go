@@ -0,0 +1,141 @@
+// Package assets provides the web templates and the static files embedded inside the binary.
+//
+// The assets are embedded by generating the assets_data.go file before building:
+//
+//	go generate ./assets
+//
+// If the file is not generated, no asset is embedded and the web templates and the
+// static files are loaded from the configured paths only.
+package assets
+
+//go:generate go run gen.go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddedFile is a gzip compressed file embedded inside the binary
+type embeddedFile struct {
+	name       string
+	modTime    time.Time
+	compressed string
+	once       sync.Once
+	data       []byte
+	err        error
+}
+
+func (f *embeddedFile) getData() ([]byte, error) {
+	f.once.Do(func() {
+		var r *gzip.Reader
+		r, f.err = gzip.NewReader(strings.NewReader(f.compressed))
+		if f.err != nil {
+			return
+		}
+		defer r.Close()
+		f.data, f.err = ioutil.ReadAll(r)
+	})
+	return f.data, f.err
+}
+
+// files contains the embedded assets, the key is the slash separated path relative
+// to the repository root, for example "templates/base.html".
+// It is populated by the generated assets_data.go file
+var files map[string]*embeddedFile
+
+// IsEmbedded returns true if the assets are embedded inside the binary
+func IsEmbedded() bool {
+	return len(files) > 0
+}
+
+// ReadFile returns the contents of the embedded asset with the given slash separated
+// path, for example "templates/base.html"
+func ReadFile(name string) ([]byte, error) {
+	f, ok := files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return f.getData()
+}
+
+// FileSystem returns an http.FileSystem that serves the files embedded inside the given
+// directory, for example "static". Directory listings are not supported
+func FileSystem(dir string) http.FileSystem {
+	return embeddedFileSystem{dir: path.Clean(dir)}
+}
+
+type embeddedFileSystem struct {
+	dir string
+}
+
+func (fs embeddedFileSystem) Open(name string) (http.File, error) {
+	f, ok := files[path.Join(fs.dir, path.Clean("/"+name))]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	data, err := f.getData()
+	if err != nil {
+		return nil, err
+	}
+	return &httpFile{
+		Reader: bytes.NewReader(data),
+		file:   f,
+		size:   int64(len(data)),
+	}, nil
+}
+
+// httpFile implements http.File for an embedded asset
+type httpFile struct {
+	*bytes.Reader
+	file *embeddedFile
+	size int64
+}
+
+func (f *httpFile) Close() error {
+	return nil
+}
+
+func (f *httpFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *httpFile) Stat() (os.FileInfo, error) {
+	return f, nil
+}
+
+// Name returns the base name of the file
+func (f *httpFile) Name() string {
+	return path.Base(f.file.name)
+}
+
+// Size returns the file size
+func (f *httpFile) Size() int64 {
+	return f.size
+}
+
+// Mode returns the file mode bits
+func (f *httpFile) Mode() os.FileMode {
+	return 0444
+}
+
+// ModTime returns the modification time for the embedded file
+func (f *httpFile) ModTime() time.Time {
+	return f.file.modTime
+}
+
+// IsDir returns false, only regular files are embedded
+func (f *httpFile) IsDir() bool {
+	return false
+}
+
+// Sys returns nil
+func (f *httpFile) Sys() interface{} {
+	return nil
+}
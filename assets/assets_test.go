@@ -0,0 +1,90 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func getCompressedData(t *testing.T, data string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("unable to compress data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to compress data: %v", err)
+	}
+	return buf.String()
+}
+
+func TestEmbeddedFiles(t *testing.T) {
+	oldFiles := files
+	defer func() {
+		files = oldFiles
+	}()
+	files = nil
+	if IsEmbedded() {
+		t.Error("no asset is embedded")
+	}
+	modTime := time.Unix(1600000000, 0)
+	files = map[string]*embeddedFile{
+		"templates/base.html": {
+			name:       "templates/base.html",
+			modTime:    modTime,
+			compressed: getCompressedData(t, "base template"),
+		},
+		"static/css/style.css": {
+			name:       "static/css/style.css",
+			modTime:    modTime,
+			compressed: getCompressedData(t, "body {}"),
+		},
+		"static/invalid.css": {
+			name:       "static/invalid.css",
+			modTime:    modTime,
+			compressed: "invalid gzip data",
+		},
+	}
+	if !IsEmbedded() {
+		t.Error("the assets must be embedded")
+	}
+	data, err := ReadFile("templates/base.html")
+	if err != nil || string(data) != "base template" {
+		t.Errorf("unexpected embedded template: %#v, err: %v", string(data), err)
+	}
+	_, err = ReadFile("templates/missing.html")
+	if !os.IsNotExist(err) {
+		t.Errorf("unexpected error for a missing asset: %v", err)
+	}
+	fs := FileSystem("static")
+	f, err := fs.Open("/css/style.css")
+	if err != nil {
+		t.Fatalf("unable to open the embedded file: %v", err)
+	}
+	defer f.Close()
+	data, err = ioutil.ReadAll(f)
+	if err != nil || string(data) != "body {}" {
+		t.Errorf("unexpected embedded file: %#v, err: %v", string(data), err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("unable to stat the embedded file: %v", err)
+	}
+	if info.Name() != "style.css" || info.Size() != 7 || !info.ModTime().Equal(modTime) || info.IsDir() {
+		t.Errorf("unexpected file info: %+v", info)
+	}
+	if _, err = f.Readdir(-1); err == nil {
+		t.Error("directory listing must fail")
+	}
+	_, err = fs.Open("/../templates/base.html")
+	if !os.IsNotExist(err) {
+		t.Errorf("files outside the directory must not be served: %v", err)
+	}
+	_, err = fs.Open("/invalid.css")
+	if err == nil {
+		t.Error("opening an invalid embedded file must fail")
+	}
+}
@@ -0,0 +1,93 @@
+// +build ignore
+
+// This program generates assets_data.go embedding the web templates and the static files.
+// It is invoked by running "go generate" inside the assets directory
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const outputFile = "assets_data.go"
+
+// the embedded directories, relative to the repository root
+var assetsDirs = []string{"templates", "static"}
+
+type asset struct {
+	name    string
+	modTime int64
+	data    []byte
+}
+
+func main() {
+	var assets []asset
+	for _, dir := range assetsDirs {
+		root := filepath.Join("..", dir)
+		err := filepath.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			contents, err := ioutil.ReadFile(walkedPath)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel("..", walkedPath)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			if err != nil {
+				return err
+			}
+			if _, err = w.Write(contents); err != nil {
+				return err
+			}
+			if err = w.Close(); err != nil {
+				return err
+			}
+			assets = append(assets, asset{
+				name:    filepath.ToSlash(rel),
+				modTime: info.ModTime().Unix(),
+				data:    buf.Bytes(),
+			})
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("unable to read the assets inside %#v: %v", root, err)
+		}
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].name < assets[j].name
+	})
+
+	var src bytes.Buffer
+	src.WriteString("// Code generated by gen.go; DO NOT EDIT.\n\n")
+	src.WriteString("package assets\n\nimport \"time\"\n\nfunc init() {\n")
+	src.WriteString("files = map[string]*embeddedFile{\n")
+	for _, a := range assets {
+		fmt.Fprintf(&src, "%q: {\nname: %q,\nmodTime: time.Unix(%d, 0),\ncompressed: %s,\n},\n", a.name, a.name,
+			a.modTime, strconv.Quote(string(a.data)))
+	}
+	src.WriteString("}\n}\n")
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("unable to format the generated source: %v", err)
+	}
+	if err = ioutil.WriteFile(outputFile, formatted, 0644); err != nil {
+		log.Fatalf("unable to write %#v: %v", outputFile, err)
+	}
+	log.Printf("%v assets embedded inside %#v", len(assets), outputFile)
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	rotateKeysBatchSize   int
+	rotateKeysStartOffset int
+	masterKeyOutputPath   string
+
+	maintenanceCmd = &cobra.Command{
+		Use:   "maintenance",
+		Short: "Maintenance commands for the configured data provider and master keys",
+	}
+
+	generateMasterKeyCmd = &cobra.Command{
+		Use:   "generate-master-key",
+		Short: "Generate a new master key",
+		Long: `This command generates a random master key and saves it to the given path. If a key provider is configured
+inside the "kms" section of the configuration file the master key is wrapped, before saving it, using the
+configured key management service, so the plain master key is never stored on disk.
+An existing file is never overwritten.
+
+Usage example:
+
+sftpgo maintenance generate-master-key --output master.key
+
+The generated file can be used as "master_key_path". Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			if len(masterKeyOutputPath) == 0 {
+				logger.ErrorToConsole("Please set the path to the file to create using --output")
+				os.Exit(1)
+			}
+			configDir = utils.CleanDirInput(configDir)
+			config.LoadConfig(configDir, configFile)
+			httpConfig := config.GetHTTPConfig()
+			httpConfig.Initialize(configDir)
+			kmsConfig := config.GetKMSConfig()
+			if err := kmsConfig.GenerateMasterKey(configDir, masterKeyOutputPath); err != nil {
+				logger.ErrorToConsole("Unable to generate the master key: %v", err)
+				os.Exit(1)
+			}
+			if len(kmsConfig.KeyProvider) > 0 {
+				logger.InfoToConsole("Master key wrapped using %#v saved to %#v", kmsConfig.KeyProvider, masterKeyOutputPath)
+			} else {
+				logger.InfoToConsole("Master key saved to %#v", masterKeyOutputPath)
+			}
+		},
+	}
+
+	rotateKeysCmd = &cobra.Command{
+		Use:   "rotate-keys",
+		Short: "Re-encrypt the stored secrets with the current master key",
+		Long: `This command re-encrypts, with the configured master key, the secrets for the users, their virtual folders
+and the user templates, such as the cloud storage credentials, that are encrypted with an old master key or
+without a master key.
+
+To rotate the master key set the new key in "master_key_path" and add the previous one to "old_master_key_paths"
+inside the "kms" section of the configuration file, restart SFTPGo and then run:
+
+sftpgo maintenance rotate-keys
+
+When the command completes without errors the old master key can be removed from the configuration.
+The users and the templates already processed are skipped, so the command can be interrupted and executed
+again at any time. The user templates are processed after the users. The progress is reported after each
+batch of users, you can resume from the last reported offset using --start-offset.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = utils.CleanDirInput(configDir)
+			config.LoadConfig(configDir, configFile)
+			// the HTTP clients are used by the key providers
+			httpConfig := config.GetHTTPConfig()
+			httpConfig.Initialize(configDir)
+			if err := config.GetKMSConfig().Initialize(configDir); err != nil {
+				logger.ErrorToConsole("Unable to initialize the master keys: %v", err)
+				os.Exit(1)
+			}
+			if !kms.IsMasterKeyEnabled() {
+				logger.ErrorToConsole("No master key configured, please set \"master_key_path\" inside the \"kms\" section")
+				os.Exit(1)
+			}
+			if rotateKeysBatchSize <= 0 || rotateKeysStartOffset < 0 {
+				logger.ErrorToConsole("Invalid batch size or start offset")
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			logger.DebugToConsole("Initializing provider: %#v config file: %#v", providerConf.Driver, viper.ConfigFileUsed())
+			if err := dataprovider.Initialize(providerConf, configDir); err != nil {
+				logger.ErrorToConsole("Unable to initialize data provider: %v", err)
+				os.Exit(1)
+			}
+			if !rotateSecrets(dataprovider.GetProvider()) {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+// rotateSecrets re-encrypts the secrets for all the users and the user templates and returns false on errors
+func rotateSecrets(p dataprovider.Provider) bool {
+	offset := rotateKeysStartOffset
+	processed := 0
+	updated := 0
+	secrets := 0
+	failed := 0
+	for {
+		users, err := dataprovider.GetUsers(p, rotateKeysBatchSize, offset, "ASC", "")
+		if err != nil {
+			logger.ErrorToConsole("Unable to get the users, offset: %v, error: %v", offset, err)
+			return false
+		}
+		if len(users) == 0 {
+			break
+		}
+		for _, user := range users {
+			rotated, err := dataprovider.RotateUserSecrets(p, user.Username)
+			if err != nil {
+				logger.WarnToConsole("Unable to re-encrypt the secrets for user %#v: %v", user.Username, err)
+				failed++
+			} else if rotated > 0 {
+				updated++
+				secrets += rotated
+			}
+			processed++
+		}
+		offset += len(users)
+		logger.InfoToConsole("Processed users: %v, updated: %v, re-encrypted secrets: %v, errors: %v, next offset: %v",
+			processed, updated, secrets, failed, offset)
+		if len(users) < rotateKeysBatchSize {
+			break
+		}
+	}
+	templates, err := dataprovider.GetUserTemplates(p)
+	if err != nil {
+		logger.ErrorToConsole("Unable to get the user templates: %v", err)
+		return false
+	}
+	updated = 0
+	secrets = 0
+	for _, template := range templates {
+		rotated, err := dataprovider.RotateUserTemplateSecrets(p, template.Name)
+		if err != nil {
+			logger.WarnToConsole("Unable to re-encrypt the secrets for user template %#v: %v", template.Name, err)
+			failed++
+		} else if rotated > 0 {
+			updated++
+			secrets += rotated
+		}
+	}
+	logger.InfoToConsole("Processed user templates: %v, updated: %v, re-encrypted secrets: %v", len(templates),
+		updated, secrets)
+	if err := dataprovider.Flush(); err != nil {
+		logger.ErrorToConsole("Unable to save the data provider pending changes: %v", err)
+		return false
+	}
+	if failed > 0 {
+		logger.WarnToConsole("Secrets rotation completed with errors, please fix them and execute the command again")
+		return false
+	}
+	logger.InfoToConsole("Secrets rotation completed")
+	return true
+}
+
+func init() {
+	rotateKeysCmd.Flags().IntVar(&rotateKeysBatchSize, "batch-size", 100, "Number of users to process for each batch")
+	rotateKeysCmd.Flags().IntVar(&rotateKeysStartOffset, "start-offset", 0, "Number of users to skip, for example "+
+		"the last offset reported by an interrupted execution")
+	addConfigFlags(rotateKeysCmd)
+	maintenanceCmd.AddCommand(rotateKeysCmd)
+
+	generateMasterKeyCmd.Flags().StringVarP(&masterKeyOutputPath, "output", "o", "", "Path to the file to create, "+
+		"an absolute path or a path relative to the config dir. Required")
+	addConfigFlags(generateMasterKeyCmd)
+	maintenanceCmd.AddCommand(generateMasterKeyCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+}
@@ -39,11 +39,39 @@ var (
 	portableS3KeyPrefix          string
 	portableS3ULPartSize         int
 	portableS3ULConcurrency      int
+	portableS3DLPartSize         int
+	portableS3DLConcurrency      int
 	portableGCSBucket            string
 	portableGCSCredentialsFile   string
 	portableGCSAutoCredentials   int
 	portableGCSStorageClass      string
 	portableGCSKeyPrefix         string
+	portableGCSDLPartSize        int
+	portableGCSDLConcurrency     int
+	portableCryptPassphrase      string
+	portableWebDAVEndpoint       string
+	portableWebDAVUsername       string
+	portableWebDAVPassword       string
+	portableWebDAVBearerToken    string
+	portableWebDAVRootPath       string
+	portableHDFSEndpoint         string
+	portableHDFSUsername         string
+	portableHDFSDelegationToken  string
+	portableHDFSRootPath         string
+	portableGDriveFolderID       string
+	portableGDriveCredsFile      string
+	portableGDriveSubject        string
+	portableGDriveClientID       string
+	portableGDriveClientSecret   string
+	portableGDriveRefreshToken   string
+	portableGDriveEndpoint       string
+	portableDropboxRootPath      string
+	portableDropboxAccessToken   string
+	portableDropboxRefreshToken  string
+	portableDropboxAppKey        string
+	portableDropboxAppSecret     string
+	portableDropboxChunkSize     int
+	portableDropboxEndpoint      string
 	portableCmd                  = &cobra.Command{
 		Use:   "portable",
 		Short: "Serve a single directory",
@@ -55,7 +83,7 @@ Please take a look at the usage below to customize the serving parameters`,
 		Run: func(cmd *cobra.Command, args []string) {
 			portableDir := directoryToServe
 			if !filepath.IsAbs(portableDir) {
-				if portableFsProvider == 0 {
+				if portableFsProvider == 0 || portableFsProvider == 3 {
 					portableDir, _ = filepath.Abs(portableDir)
 				} else {
 					portableDir = os.TempDir()
@@ -82,6 +110,24 @@ Please take a look at the usage below to customize the serving parameters`,
 				portableGCSCredentials = base64.StdEncoding.EncodeToString(creds)
 				portableGCSAutoCredentials = 0
 			}
+			portableGDriveCredentials := ""
+			if portableFsProvider == 6 && len(portableGDriveCredsFile) > 0 {
+				fi, err := os.Stat(portableGDriveCredsFile)
+				if err != nil {
+					fmt.Printf("Invalid Google Drive credentials file: %v\n", err)
+					return
+				}
+				if fi.Size() > 1048576 {
+					fmt.Printf("Invalid Google Drive credentials file: %#v is too big %v/1048576 bytes\n",
+						portableGDriveCredsFile, fi.Size())
+					return
+				}
+				creds, err := ioutil.ReadFile(portableGDriveCredsFile)
+				if err != nil {
+					fmt.Printf("Unable to read credentials file: %v\n", err)
+				}
+				portableGDriveCredentials = string(creds)
+			}
 			service := service.Service{
 				ConfigDir:     filepath.Clean(defaultConfigDir),
 				ConfigFile:    defaultConfigName,
@@ -104,15 +150,17 @@ Please take a look at the usage below to customize the serving parameters`,
 					FsConfig: dataprovider.Filesystem{
 						Provider: portableFsProvider,
 						S3Config: vfs.S3FsConfig{
-							Bucket:            portableS3Bucket,
-							Region:            portableS3Region,
-							AccessKey:         portableS3AccessKey,
-							AccessSecret:      portableS3AccessSecret,
-							Endpoint:          portableS3Endpoint,
-							StorageClass:      portableS3StorageClass,
-							KeyPrefix:         portableS3KeyPrefix,
-							UploadPartSize:    int64(portableS3ULPartSize),
-							UploadConcurrency: portableS3ULConcurrency,
+							Bucket:              portableS3Bucket,
+							Region:              portableS3Region,
+							AccessKey:           portableS3AccessKey,
+							AccessSecret:        portableS3AccessSecret,
+							Endpoint:            portableS3Endpoint,
+							StorageClass:        portableS3StorageClass,
+							KeyPrefix:           portableS3KeyPrefix,
+							UploadPartSize:      int64(portableS3ULPartSize),
+							UploadConcurrency:   portableS3ULConcurrency,
+							DownloadPartSize:    int64(portableS3DLPartSize),
+							DownloadConcurrency: portableS3DLConcurrency,
 						},
 						GCSConfig: vfs.GCSFsConfig{
 							Bucket:               portableGCSBucket,
@@ -120,6 +168,42 @@ Please take a look at the usage below to customize the serving parameters`,
 							AutomaticCredentials: portableGCSAutoCredentials,
 							StorageClass:         portableGCSStorageClass,
 							KeyPrefix:            portableGCSKeyPrefix,
+							DownloadPartSize:     int64(portableGCSDLPartSize),
+							DownloadConcurrency:  portableGCSDLConcurrency,
+						},
+						CryptConfig: vfs.CryptFsConfig{
+							Passphrase: portableCryptPassphrase,
+						},
+						WebDAVConfig: vfs.WebDAVFsConfig{
+							Endpoint:    portableWebDAVEndpoint,
+							Username:    portableWebDAVUsername,
+							Password:    portableWebDAVPassword,
+							BearerToken: portableWebDAVBearerToken,
+							RootPath:    portableWebDAVRootPath,
+						},
+						HDFSConfig: vfs.HDFSFsConfig{
+							Endpoint:        portableHDFSEndpoint,
+							Username:        portableHDFSUsername,
+							DelegationToken: portableHDFSDelegationToken,
+							RootPath:        portableHDFSRootPath,
+						},
+						GoogleDriveConfig: vfs.GoogleDriveFsConfig{
+							FolderID:     portableGDriveFolderID,
+							Credentials:  portableGDriveCredentials,
+							Subject:      portableGDriveSubject,
+							ClientID:     portableGDriveClientID,
+							ClientSecret: portableGDriveClientSecret,
+							RefreshToken: portableGDriveRefreshToken,
+							Endpoint:     portableGDriveEndpoint,
+						},
+						DropboxConfig: vfs.DropboxFsConfig{
+							RootPath:        portableDropboxRootPath,
+							AccessToken:     portableDropboxAccessToken,
+							RefreshToken:    portableDropboxRefreshToken,
+							AppKey:          portableDropboxAppKey,
+							AppSecret:       portableDropboxAppSecret,
+							UploadChunkSize: int64(portableDropboxChunkSize),
+							Endpoint:        portableDropboxEndpoint,
 						},
 					},
 					Filters: dataprovider.UserFilters{
@@ -156,7 +240,7 @@ func init() {
 	portableCmd.Flags().BoolVarP(&portableAdvertiseCredentials, "advertise-credentials", "C", false,
 		"If the SFTP service is advertised via multicast DNS, this flag allows to put username/password inside the advertised TXT record")
 	portableCmd.Flags().IntVarP(&portableFsProvider, "fs-provider", "f", 0, "0 means local filesystem, 1 Amazon S3 compatible, "+
-		"2 Google Cloud Storage")
+		"2 Google Cloud Storage, 3 encrypted local filesystem, 4 remote WebDAV server, 5 HDFS, 6 Google Drive, 7 Dropbox")
 	portableCmd.Flags().StringVar(&portableS3Bucket, "s3-bucket", "", "")
 	portableCmd.Flags().StringVar(&portableS3Region, "s3-region", "", "")
 	portableCmd.Flags().StringVar(&portableS3AccessKey, "s3-access-key", "", "")
@@ -167,6 +251,8 @@ func init() {
 		"identified by this prefix and its contents")
 	portableCmd.Flags().IntVar(&portableS3ULPartSize, "s3-upload-part-size", 5, "The buffer size for multipart uploads (MB)")
 	portableCmd.Flags().IntVar(&portableS3ULConcurrency, "s3-upload-concurrency", 2, "How many parts are uploaded in parallel")
+	portableCmd.Flags().IntVar(&portableS3DLPartSize, "s3-download-part-size", 5, "The buffer size for multipart downloads (MB)")
+	portableCmd.Flags().IntVar(&portableS3DLConcurrency, "s3-download-concurrency", 5, "How many parts are downloaded in parallel")
 	portableCmd.Flags().StringVar(&portableGCSBucket, "gcs-bucket", "", "")
 	portableCmd.Flags().StringVar(&portableGCSStorageClass, "gcs-storage-class", "", "")
 	portableCmd.Flags().StringVar(&portableGCSKeyPrefix, "gcs-key-prefix", "", "Allows to restrict access to the virtual folder "+
@@ -174,6 +260,45 @@ func init() {
 	portableCmd.Flags().StringVar(&portableGCSCredentialsFile, "gcs-credentials-file", "", "Google Cloud Storage JSON credentials file")
 	portableCmd.Flags().IntVar(&portableGCSAutoCredentials, "gcs-automatic-credentials", 1, "0 means explicit credentials using a JSON "+
 		"credentials file, 1 automatic")
+	portableCmd.Flags().IntVar(&portableGCSDLPartSize, "gcs-download-part-size", 5, "The size of each range request for parallel "+
+		"downloads (MB)")
+	portableCmd.Flags().IntVar(&portableGCSDLConcurrency, "gcs-download-concurrency", 1, "How many parts are downloaded in "+
+		"parallel, 1 means sequential downloads")
+	portableCmd.Flags().StringVar(&portableCryptPassphrase, "crypt-passphrase", "", "Passphrase used to derive the file "+
+		"encryption keys for the encrypted local filesystem")
+	portableCmd.Flags().StringVar(&portableWebDAVEndpoint, "webdav-endpoint", "", "http or https URL for the remote WebDAV server")
+	portableCmd.Flags().StringVar(&portableWebDAVUsername, "webdav-username", "", "")
+	portableCmd.Flags().StringVar(&portableWebDAVPassword, "webdav-password", "", "")
+	portableCmd.Flags().StringVar(&portableWebDAVBearerToken, "webdav-bearer-token", "", "")
+	portableCmd.Flags().StringVar(&portableWebDAVRootPath, "webdav-root-path", "", "Allows to restrict access to the "+
+		"virtual folder identified by this path and its contents")
+	portableCmd.Flags().StringVar(&portableHDFSEndpoint, "hdfs-endpoint", "", "http or https WebHDFS base URL, for example "+
+		"http://namenode:9870/webhdfs/v1")
+	portableCmd.Flags().StringVar(&portableHDFSUsername, "hdfs-username", "", "Hadoop user for simple authentication")
+	portableCmd.Flags().StringVar(&portableHDFSDelegationToken, "hdfs-delegation-token", "", "")
+	portableCmd.Flags().StringVar(&portableHDFSRootPath, "hdfs-root-path", "", "Allows to restrict access to the "+
+		"HDFS directory identified by this path and its contents")
+	portableCmd.Flags().StringVar(&portableGDriveFolderID, "gdrive-folder-id", "", "ID of the Drive folder to serve. "+
+		"Empty means the root folder of My Drive")
+	portableCmd.Flags().StringVar(&portableGDriveCredsFile, "gdrive-credentials-file", "", "Service account JSON key file")
+	portableCmd.Flags().StringVar(&portableGDriveSubject, "gdrive-subject", "", "User to impersonate using the "+
+		"domain-wide delegation")
+	portableCmd.Flags().StringVar(&portableGDriveClientID, "gdrive-client-id", "", "")
+	portableCmd.Flags().StringVar(&portableGDriveClientSecret, "gdrive-client-secret", "", "")
+	portableCmd.Flags().StringVar(&portableGDriveRefreshToken, "gdrive-refresh-token", "", "OAuth 2.0 refresh token, "+
+		"it cannot be used together with a service account")
+	portableCmd.Flags().StringVar(&portableGDriveEndpoint, "gdrive-endpoint", "", "Leave empty to use the Google APIs")
+	portableCmd.Flags().StringVar(&portableDropboxRootPath, "dropbox-root-path", "", "Allows to restrict access to the "+
+		"Dropbox folder identified by this path and its contents")
+	portableCmd.Flags().StringVar(&portableDropboxAccessToken, "dropbox-access-token", "", "Long-lived OAuth 2.0 "+
+		"access token, it cannot be used together with a refresh token")
+	portableCmd.Flags().StringVar(&portableDropboxRefreshToken, "dropbox-refresh-token", "", "OAuth 2.0 refresh token")
+	portableCmd.Flags().StringVar(&portableDropboxAppKey, "dropbox-app-key", "", "Required to use a refresh token")
+	portableCmd.Flags().StringVar(&portableDropboxAppSecret, "dropbox-app-secret", "", "Not required for refresh "+
+		"tokens obtained using PKCE")
+	portableCmd.Flags().IntVar(&portableDropboxChunkSize, "dropbox-upload-chunk-size", 0, "The chunk size for "+
+		"upload sessions as MB. Zero means the default (8 MB)")
+	portableCmd.Flags().StringVar(&portableDropboxEndpoint, "dropbox-endpoint", "", "Leave empty to use the Dropbox APIs")
 	rootCmd.AddCommand(portableCmd)
 }
 
@@ -63,7 +63,7 @@ var (
 func init() {
 	version := utils.GetAppVersion()
 	rootCmd.Flags().BoolP("version", "v", false, "")
-	rootCmd.Version = version.GetVersionAsString()
+	rootCmd.Version = version.GetVersionAsString() + "\nFeatures: " + version.GetFeaturesAsString()
 	rootCmd.SetVersionTemplate(`{{printf "SFTPGo version: "}}{{printf "%s" .Version}}
 `)
 }
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	supportBundleTimeFormat = "20060102T150405"
+	// the log tail is searched inside this amount of bytes at the end of the log file
+	maxLogTailSize = 10485760 // 10MB
+	// timeout for the listeners connection checks
+	listenerCheckTimeout = 2 * time.Second
+)
+
+var (
+	supportBundleOutputPath string
+	supportBundleLogLines   int
+
+	supportBundleCmd = &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Create a sanitized archive to attach to the bug reports",
+		Long: `This command creates a tar.gz archive with the details needed to investigate an issue, so a single
+artifact can be attached to a bug report. The archive contains:
+
+- the version, the build details and the compiled-in features
+- the effective configuration, including the environment variables overrides
+- the configuration settings that differ from the defaults
+- the data provider availability and schema version
+- the status of the configured listeners, checked connecting to them
+- the last lines of the log file
+
+The passwords, the secrets and the connection strings are redacted. The logs are included unchanged, so
+they can contain usernames and IP addresses: review the archive before sharing it.
+An existing file is never overwritten.
+
+Usage example:
+
+sftpgo support-bundle --output support.tar.gz
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			if supportBundleLogLines < 0 {
+				logger.ErrorToConsole("Invalid number of log lines")
+				os.Exit(1)
+			}
+			configDir = utils.CleanDirInput(configDir)
+			config.LoadConfig(configDir, configFile)
+			outputPath := supportBundleOutputPath
+			if len(outputPath) == 0 {
+				outputPath = fmt.Sprintf("sftpgo-support-%v.tar.gz", time.Now().UTC().Format(supportBundleTimeFormat))
+			}
+			if err := createSupportBundle(outputPath); err != nil {
+				logger.ErrorToConsole("Unable to create the support bundle: %v", err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Support bundle saved to %#v", outputPath)
+		},
+	}
+)
+
+type supportBundleInfo struct {
+	Version        utils.VersionInfo `json:"version"`
+	GoVersion      string            `json:"go_version"`
+	OS             string            `json:"os"`
+	Arch           string            `json:"arch"`
+	ConfigFileUsed string            `json:"config_file_used"`
+	CreatedAt      string            `json:"created_at"`
+}
+
+type supportBundleProvider struct {
+	Driver        string `json:"driver"`
+	Available     bool   `json:"available"`
+	Error         string `json:"error,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	SchemaError   string `json:"schema_error,omitempty"`
+}
+
+type supportBundleListener struct {
+	Service   string `json:"service"`
+	Address   string `json:"address"`
+	Listening bool   `json:"listening"`
+	Error     string `json:"error,omitempty"`
+}
+
+func createSupportBundle(outputPath string) error {
+	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = writeSupportBundle(file)
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(outputPath)
+	}
+	return err
+}
+
+func writeSupportBundle(w io.Writer) error {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	now := time.Now()
+
+	info := supportBundleInfo{
+		Version:        utils.GetAppVersion(),
+		GoVersion:      runtime.Version(),
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		ConfigFileUsed: viper.ConfigFileUsed(),
+		CreatedAt:      now.UTC().Format(time.RFC3339),
+	}
+	if err := addSupportBundleJSON(tw, "info.json", info, now); err != nil {
+		return err
+	}
+	conf, err := config.GetRedactedConfig()
+	if err != nil {
+		return err
+	}
+	if err = addSupportBundleJSON(tw, "config.json", conf, now); err != nil {
+		return err
+	}
+	changes, err := config.GetChangedConfig()
+	if err != nil {
+		return err
+	}
+	if err = addSupportBundleJSON(tw, "config_changes.json", changes, now); err != nil {
+		return err
+	}
+	if err = addSupportBundleJSON(tw, "provider.json", getSupportBundleProvider(), now); err != nil {
+		return err
+	}
+	if err = addSupportBundleJSON(tw, "listeners.json", getSupportBundleListeners(), now); err != nil {
+		return err
+	}
+	if err = addSupportBundleLogs(tw, now); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+func getSupportBundleProvider() supportBundleProvider {
+	providerConf := config.GetProviderConf()
+	result := supportBundleProvider{
+		Driver: providerConf.Driver,
+	}
+	logger.DebugToConsole("Initializing provider: %#v config file: %#v", providerConf.Driver, viper.ConfigFileUsed())
+	if err := dataprovider.Initialize(providerConf, configDir); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	p := dataprovider.GetProvider()
+	defer dataprovider.Close(p)
+
+	if err := dataprovider.GetProviderStatus(p); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Available = true
+	version, err := dataprovider.GetSchemaVersion(p)
+	if err != nil {
+		result.SchemaError = err.Error()
+	} else {
+		result.SchemaVersion = version
+	}
+	return result
+}
+
+// getSupportBundleListeners checks the configured listeners connecting to them,
+// the listeners bound to all the interfaces are checked using the loopback address
+func getSupportBundleListeners() []supportBundleListener {
+	var result []supportBundleListener
+	addListener := func(service, address string, port int) {
+		if port <= 0 {
+			return
+		}
+		switch address {
+		case "", "0.0.0.0":
+			address = "127.0.0.1"
+		case "::":
+			address = "::1"
+		}
+		listener := supportBundleListener{
+			Service: service,
+			Address: net.JoinHostPort(address, strconv.Itoa(port)),
+		}
+		conn, err := net.DialTimeout("tcp", listener.Address, listenerCheckTimeout)
+		if err != nil {
+			listener.Error = err.Error()
+		} else {
+			conn.Close()
+			listener.Listening = true
+		}
+		result = append(result, listener)
+	}
+	sftpdConf := config.GetSFTPDConfig()
+	addListener("sftp", sftpdConf.BindAddress, sftpdConf.BindPort)
+	httpdConf := config.GetHTTPDConfig()
+	addListener("http", httpdConf.BindAddress, httpdConf.BindPort)
+	addListener("grpc", httpdConf.GRPCBindAddress, httpdConf.GRPCBindPort)
+	ldapdConf := config.GetLDAPDConfig()
+	addListener("ldap", ldapdConf.BindAddress, ldapdConf.BindPort)
+	return result
+}
+
+func addSupportBundleLogs(tw *tar.Writer, modTime time.Time) error {
+	if supportBundleLogLines == 0 || !utils.IsFileInputValid(logFilePath) {
+		return nil
+	}
+	logPath := logFilePath
+	if !filepath.IsAbs(logPath) {
+		logPath = filepath.Join(configDir, logPath)
+	}
+	data, err := getLogTail(logPath, supportBundleLogLines)
+	if err != nil {
+		// the log file could be missing, for example if the logs are written to the standard output
+		logger.WarnToConsole("Unable to read the log file %#v, the logs are not included: %v", logPath, err)
+		return nil
+	}
+	return addSupportBundleEntry(tw, "logs/"+filepath.Base(logPath), data, modTime)
+}
+
+// getLogTail returns the last lines of the given log file
+func getLogTail(logPath string, lines int) ([]byte, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - maxLogTailSize
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxLogTailSize))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		// skip the first partial line
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			data = data[idx+1:]
+		}
+	}
+	data = bytes.TrimRight(data, "\n")
+	count := 0
+	for idx := len(data) - 1; idx >= 0; idx-- {
+		if data[idx] == '\n' {
+			count++
+			if count == lines {
+				data = data[idx+1:]
+				break
+			}
+		}
+	}
+	if len(data) > 0 {
+		data = append(data, '\n')
+	}
+	return data, nil
+}
+
+func addSupportBundleJSON(tw *tar.Writer, name string, value interface{}, modTime time.Time) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return addSupportBundleEntry(tw, name, data, modTime)
+}
+
+func addSupportBundleEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     0600,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func init() {
+	supportBundleCmd.Flags().StringVarP(&supportBundleOutputPath, "output", "o", "", "Path to the archive to create. "+
+		"If empty a file named \"sftpgo-support-<timestamp>.tar.gz\" is created inside the current directory")
+	supportBundleCmd.Flags().IntVar(&supportBundleLogLines, "log-lines", 1000, "Number of log lines to include, "+
+		"0 means no logs")
+	addConfigFlags(supportBundleCmd)
+
+	viper.SetDefault(logFilePathKey, defaultLogFile)
+	viper.BindEnv(logFilePathKey, "SFTPGO_LOG_FILE_PATH")
+	supportBundleCmd.Flags().StringVarP(&logFilePath, logFilePathFlag, "l", viper.GetString(logFilePathKey),
+		"Location of the log file to include, the same used for the serve command. A relative path is resolved "+
+			"against the config dir. This flag can be set using SFTPGO_LOG_FILE_PATH env var too.")
+	viper.BindPFlag(logFilePathKey, supportBundleCmd.Flags().Lookup(logFilePathFlag))
+	rootCmd.AddCommand(supportBundleCmd)
+}
@@ -12,9 +12,13 @@ import (
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/httpd"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/ldapd"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 	"github.com/spf13/viper"
 )
 
@@ -38,6 +42,9 @@ type globalConfig struct {
 	ProviderConf dataprovider.Config `json:"data_provider" mapstructure:"data_provider"`
 	HTTPDConfig  httpd.Conf          `json:"httpd" mapstructure:"httpd"`
 	HTTPConfig   httpclient.Config   `json:"http" mapstructure:"http"`
+	Plugins      []plugin.Config     `json:"plugins" mapstructure:"plugins"`
+	KMSConfig    kms.Config          `json:"kms" mapstructure:"kms"`
+	LDAPD        ldapd.Configuration `json:"ldapd" mapstructure:"ldapd"`
 }
 
 func init() {
@@ -63,23 +70,88 @@ func init() {
 			LoginBannerFile:         "",
 			EnabledSSHCommands:      sftpd.GetDefaultSSHCommands(),
 			KeyboardInteractiveHook: "",
-			ProxyProtocol:           0,
-			ProxyAllowed:            []string{},
+			FirstLogin: sftpd.FirstLoginConfig{
+				Enabled:        false,
+				TermsOfUseFile: "",
+			},
+			ProxyProtocol:          0,
+			ProxyAllowed:           []string{},
+			DisconnectOnUserChange: false,
+			DisconnectGracePeriod:  30,
+			WindowsACL: vfs.WindowsACLConfig{
+				Owner:         "",
+				Group:         "",
+				HonorDenyACLs: false,
+			},
+			PreserveXattrs: false,
+			SlowTransfers: sftpd.SlowTransfersConfig{
+				MinSpeed: 0,
+				Period:   60,
+			},
+			DiskCache: vfs.DiskCacheConfig{
+				Path:        "",
+				MaxSize:     0,
+				MaxFileSize: 0,
+			},
+			UploadJournal: vfs.UploadJournalConfig{
+				Path:   "",
+				MaxAge: 24,
+			},
+			FsTimeouts: vfs.FsTimeoutsConfig{
+				Local:       vfs.OperationTimeouts{},
+				S3:          vfs.OperationTimeouts{},
+				GCS:         vfs.OperationTimeouts{},
+				WebDAV:      vfs.OperationTimeouts{},
+				HDFS:        vfs.OperationTimeouts{},
+				GoogleDrive: vfs.OperationTimeouts{},
+				Dropbox:     vfs.OperationTimeouts{},
+			},
+			NetworkFs: vfs.NetworkFsConfig{
+				Enabled:      false,
+				StaleRetries: 3,
+				RetryDelay:   100,
+				DirectIO:     false,
+				RenameMode:   vfs.RenameModeNative,
+			},
+			AllowedIP:          []string{},
+			DeniedIP:           []string{},
+			RevokedKeysFile:    "",
+			DeniedLoginMethods: []string{},
+			Bindings:           []sftpd.Binding{},
+			Receipts: sftpd.ReceiptsConfig{
+				ExecuteOn: []string{},
+				Path:      "receipts",
+			},
+			Defender: sftpd.DefenderConfig{
+				Enabled:         false,
+				BanTime:         30,
+				ObservationTime: 30,
+				Threshold:       15,
+				ScoreValid:      1,
+				ScoreInvalid:    2,
+			},
 		},
 		ProviderConf: dataprovider.Config{
-			Driver:           "sqlite",
-			Name:             "sftpgo.db",
-			Host:             "",
-			Port:             5432,
-			Username:         "",
-			Password:         "",
-			ConnectionString: "",
-			UsersTable:       "users",
-			ManageUsers:      1,
-			SSLMode:          0,
-			TrackQuota:       1,
-			PoolSize:         0,
-			UsersBaseDir:     "",
+			Driver:                      "sqlite",
+			Name:                        "sftpgo.db",
+			Host:                        "",
+			Port:                        5432,
+			Username:                    "",
+			Password:                    "",
+			ConnectionString:            "",
+			ReadReplicaConnectionString: "",
+			UsersTable:                  "users",
+			ManageUsers:                 1,
+			SSLMode:                     0,
+			RootCert:                    "",
+			ClientCert:                  "",
+			ClientKey:                   "",
+			SQLiteKeyFile:               "",
+			TrackQuota:                  1,
+			QuotaSizeMode:               0,
+			QuotaScanWorkers:            0,
+			PoolSize:                    0,
+			UsersBaseDir:                "",
 			Actions: dataprovider.Actions{
 				ExecuteOn:           []string{},
 				Command:             "",
@@ -89,6 +161,70 @@ func init() {
 			ExternalAuthScope: 0,
 			CredentialsPath:   "credentials",
 			PreLoginHook:      "",
+			PasswordHashing: dataprovider.PasswordHashing{
+				Algo:                dataprovider.HashingAlgoArgon2ID,
+				BcryptCost:          10,
+				UpgradeLegacyHashes: true,
+			},
+			PasswordBreachCheck: dataprovider.PasswordBreachCheck{
+				Mode:           0,
+				APIURL:         "",
+				HashesFile:     "",
+				MinOccurrences: 1,
+				CacheTTL:       60,
+				RejectOnError:  false,
+			},
+			UsersCache: dataprovider.UsersCacheConfig{
+				TTL:                0,
+				Size:               1000,
+				OutageMaxStaleness: 0,
+				OutageReadOnly:     false,
+			},
+			S3Tenants: dataprovider.S3TenantsConfig{
+				KeyPrefix:       "",
+				CreatePrefix:    false,
+				PolicyFile:      "",
+				VerifyIsolation: false,
+			},
+			LDAP: dataprovider.LDAPConfig{
+				URL:                "",
+				StartTLS:           false,
+				SkipTLSVerify:      false,
+				BindDN:             "",
+				BindPassword:       "",
+				BaseDN:             "",
+				SearchFilter:       "",
+				DefaultPermissions: []string{dataprovider.PermAny},
+			},
+			IPListFeeds: []dataprovider.IPListFeed{},
+			MigrationTarget: dataprovider.MigrationTarget{
+				Driver:           "",
+				Name:             "",
+				Host:             "",
+				Port:             0,
+				Username:         "",
+				Password:         "",
+				SSLMode:          0,
+				RootCert:         "",
+				ClientCert:       "",
+				ClientKey:        "",
+				SQLiteKeyFile:    "",
+				ConnectionString: "",
+				PoolSize:         0,
+			},
+			MemoryPersistence: dataprovider.MemoryPersistence{
+				Enabled:  false,
+				Interval: 0,
+			},
+			PlanPropagation: dataprovider.PlanPropagationConfig{
+				UsersPerSecond: 100,
+			},
+			InactivityPolicy: dataprovider.InactivityPolicy{
+				InactiveDays:  0,
+				GraceDays:     0,
+				Action:        dataprovider.InactivityActionDisable,
+				CheckInterval: 60,
+			},
 		},
 		HTTPDConfig: httpd.Conf{
 			BindPort:           8080,
@@ -97,14 +233,89 @@ func init() {
 			StaticFilesPath:    "static",
 			BackupsPath:        "backups",
 			AuthUserFile:       "",
+			AuditorUsers:       []string{},
+			AdminScopes:        []httpd.AdminScope{},
 			CertificateFile:    "",
 			CertificateKeyFile: "",
+			GRPCBindPort:       0,
+			GRPCBindAddress:    "127.0.0.1",
+			StaleFilesReport: httpd.StaleFilesReportConfig{
+				Interval:      0,
+				OlderThanDays: 90,
+				TopFiles:      10,
+				ReportsPath:   "reports",
+			},
+			DuplicatesScan: httpd.DuplicatesScanConfig{
+				Bandwidth: 0,
+			},
+			SyncAPI: httpd.SyncAPIConfig{
+				Enabled:                false,
+				PresignedURLs:          false,
+				PresignedURLExpiration: 300,
+			},
+			CustomRoutes: []httpd.CustomRoute{},
+			Offboarding: httpd.OffboardingConfig{
+				ArchiveBucket: httpd.OffboardingBucketConfig{
+					Provider:        0,
+					Bucket:          "",
+					KeyPrefix:       "",
+					Region:          "",
+					AccessKey:       "",
+					AccessSecret:    "",
+					Endpoint:        "",
+					StorageClass:    "",
+					CredentialsFile: "",
+				},
+			},
+			AdminEvents: httpd.AdminEventsConfig{
+				Webhooks:            []httpd.AdminWebhook{},
+				LoginNotifyInterval: 60,
+			},
+			UIPreferences: httpd.UIPreferencesConfig{
+				StoragePath: "ui_preferences",
+			},
 		},
 		HTTPConfig: httpclient.Config{
 			Timeout:        20,
 			CACertificates: nil,
 		},
+		Plugins: []plugin.Config{},
+		KMSConfig: kms.Config{
+			MasterKeyPath:     "",
+			OldMasterKeyPaths: []string{},
+			KeyProvider:       "",
+			AWS: kms.AWSKMSConfig{
+				KeyID:        "",
+				Region:       "",
+				AccessKey:    "",
+				AccessSecret: "",
+				Endpoint:     "",
+			},
+			GCP: kms.GCPKMSConfig{
+				KeyName:         "",
+				CredentialsFile: "",
+			},
+			Azure: kms.AzureKeyVaultConfig{
+				VaultURL:      "",
+				KeyName:       "",
+				KeyVersion:    "",
+				TenantID:      "",
+				ClientID:      "",
+				ClientSecret:  "",
+				AuthorityHost: "",
+			},
+		},
+		LDAPD: ldapd.Configuration{
+			BindPort:           0,
+			BindAddress:        "127.0.0.1",
+			BaseDN:             "ou=users,dc=sftpgo",
+			BindDN:             "",
+			BindPassword:       "",
+			CertificateFile:    "",
+			CertificateKeyFile: "",
+		},
 	}
+	defaultConfig, _ = toGenericMap(globalConf)
 
 	viper.SetEnvPrefix(configEnvPrefix)
 	replacer := strings.NewReplacer(".", "__")
@@ -124,6 +335,16 @@ func SetSFTPDConfig(config sftpd.Configuration) {
 	globalConf.SFTPD = config
 }
 
+// GetLDAPDConfig returns the configuration for the read only LDAP server
+func GetLDAPDConfig() ldapd.Configuration {
+	return globalConf.LDAPD
+}
+
+// SetLDAPDConfig sets the configuration for the read only LDAP server
+func SetLDAPDConfig(config ldapd.Configuration) {
+	globalConf.LDAPD = config
+}
+
 // GetHTTPDConfig returns the configuration for the HTTP server
 func GetHTTPDConfig() httpd.Conf {
 	return globalConf.HTTPDConfig
@@ -149,9 +370,31 @@ func GetHTTPConfig() httpclient.Config {
 	return globalConf.HTTPConfig
 }
 
+// GetPluginsConfig returns the configuration for the plugins
+func GetPluginsConfig() []plugin.Config {
+	return globalConf.Plugins
+}
+
+// SetPluginsConfig sets the configuration for the plugins
+func SetPluginsConfig(config []plugin.Config) {
+	globalConf.Plugins = config
+}
+
+// GetKMSConfig returns the configuration for the master keys
+func GetKMSConfig() kms.Config {
+	return globalConf.KMSConfig
+}
+
+// SetKMSConfig sets the configuration for the master keys
+func SetKMSConfig(config kms.Config) {
+	globalConf.KMSConfig = config
+}
+
 func getRedactedGlobalConf() globalConfig {
 	conf := globalConf
 	conf.ProviderConf.Password = "[redacted]"
+	conf.KMSConfig.AWS.AccessSecret = "[redacted]"
+	conf.KMSConfig.Azure.ClientSecret = "[redacted]"
 	return conf
 }
 
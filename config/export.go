@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const redactedValue = "[redacted]"
+
+var (
+	// the default configuration, it is used to find the changed settings
+	defaultConfig map[string]interface{}
+	// the settings containing the listed words are considered secrets
+	secretKeyWords = []string{"password", "secret", "token"}
+	// these settings can embed credentials, for example a database password
+	secretKeys = []string{"access_key", "connection_string", "read_replica_connection_string"}
+)
+
+// GetRedactedConfig returns the effective configuration as a generic map, the secrets are redacted.
+// The keys are the same used inside the configuration file
+func GetRedactedConfig() (map[string]interface{}, error) {
+	conf, err := toGenericMap(globalConf)
+	if err != nil {
+		return nil, err
+	}
+	redactSecrets(conf)
+	return conf, nil
+}
+
+// GetChangedConfig returns the effective configuration settings that differ from the defaults,
+// the secrets are redacted. The settings changed using the environment variables are included
+func GetChangedConfig() (map[string]interface{}, error) {
+	conf, err := toGenericMap(globalConf)
+	if err != nil {
+		return nil, err
+	}
+	changed := getChangedSettings(conf, defaultConfig)
+	redactSecrets(changed)
+	return changed, nil
+}
+
+func toGenericMap(conf globalConfig) (map[string]interface{}, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&result)
+	return result, err
+}
+
+// getChangedSettings returns the settings inside conf that are missing or different inside defaults.
+// Nested objects are compared recursively while lists are compared as a whole
+func getChangedSettings(conf, defaults map[string]interface{}) map[string]interface{} {
+	changed := make(map[string]interface{})
+	for key, value := range conf {
+		defaultValue, ok := defaults[key]
+		if !ok {
+			changed[key] = value
+			continue
+		}
+		if nested, isMap := value.(map[string]interface{}); isMap {
+			if defaultNested, isDefaultMap := defaultValue.(map[string]interface{}); isDefaultMap {
+				if nestedChanges := getChangedSettings(nested, defaultNested); len(nestedChanges) > 0 {
+					changed[key] = nestedChanges
+				}
+				continue
+			}
+		}
+		if !reflect.DeepEqual(value, defaultValue) && !(isEmptyList(value) && isEmptyList(defaultValue)) {
+			changed[key] = value
+		}
+	}
+	return changed
+}
+
+// isEmptyList returns true for null values and empty lists, they are equivalent inside the configuration
+func isEmptyList(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	list, ok := value.([]interface{})
+	return ok && len(list) == 0
+}
+
+// redactSecrets replaces, in place, the values for the secret settings and the credentials embedded
+// inside the URLs. Empty values are preserved, so it is still possible to know if a secret is set
+func redactSecrets(conf map[string]interface{}) {
+	for key, value := range conf {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			redactSecrets(v)
+		case []interface{}:
+			for _, item := range v {
+				if nested, ok := item.(map[string]interface{}); ok {
+					redactSecrets(nested)
+				}
+			}
+		case string:
+			if len(v) == 0 {
+				continue
+			}
+			if isSecretKey(key) {
+				conf[key] = redactedValue
+			} else {
+				conf[key] = redactURLCredentials(v)
+			}
+		}
+	}
+}
+
+func isSecretKey(key string) bool {
+	if utils.IsStringInSlice(key, secretKeys) {
+		return true
+	}
+	for _, word := range secretKeyWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactURLCredentials(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		// the brackets would be escaped inside the URL
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+		return u.String()
+	}
+	return value
+}
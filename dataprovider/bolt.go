@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/drakkan/sftpgo/logger"
@@ -20,13 +22,38 @@ const (
 var (
 	usersBucket      = []byte("users")
 	usersIDIdxBucket = []byte("users_id_idx")
+	ipListsBucket    = []byte("ip_lists")
+	plansBucket      = []byte("plans")
+	templatesBucket  = []byte("user_templates")
+	foldersBucket    = []byte("folders")
 	dbVersionBucket  = []byte("db_version")
 	dbVersionKey     = []byte("version")
 )
 
-// BoltProvider auth provider for bolt key/value store
+// BoltProvider auth provider for bolt key/value store.
+// The database file is locked by a single process, so the decoded users are cached in memory
+// and the logins don't need to decode the users again. The reads use read-only transactions
+// that run concurrently with the writes, the frequent writes, such as the quota and the last
+// login updates, are batched
 type BoltProvider struct {
 	dbHandle *bolt.DB
+	cache    *boltUsersCache
+}
+
+type boltCachedUser struct {
+	user User
+	txID int
+}
+
+// boltUsersCache caches the users by username. A read-only transaction sees the changes
+// committed up to its ID, so a user read before a change is never cached
+type boltUsersCache struct {
+	sync.RWMutex
+	// the users read before this transaction ID could miss a change and they cannot be cached
+	minTxID int
+	users   map[string]boltCachedUser
+	// ID of the last write transaction for the changed users
+	changes map[string]int
 }
 
 type compatUserV2 struct {
@@ -51,6 +78,95 @@ type compatUserV2 struct {
 	Status            int      `json:"status"`
 }
 
+func init() {
+	utils.AddFeature("+bolt")
+}
+
+func newBoltUsersCache() *boltUsersCache {
+	return &boltUsersCache{
+		users:   make(map[string]boltCachedUser),
+		changes: make(map[string]int),
+	}
+}
+
+func (c *boltUsersCache) get(username string) (User, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.users[username]
+	if !ok {
+		return User{}, false
+	}
+	return cached.user.getACopy(), true
+}
+
+// add caches the given user read inside the transaction with the given ID, if no change
+// was committed after the read
+func (c *boltUsersCache) add(user User, txID int) {
+	c.Lock()
+	defer c.Unlock()
+
+	if txID < c.minTxID || c.changes[user.Username] > txID {
+		return
+	}
+	if cached, ok := c.users[user.Username]; ok && cached.txID > txID {
+		return
+	}
+	c.users[user.Username] = boltCachedUser{
+		user: user.getACopy(),
+		txID: txID,
+	}
+}
+
+// update caches the given user written inside the write transaction with the given ID
+func (c *boltUsersCache) update(user User, txID int) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.changes[user.Username] < txID {
+		c.changes[user.Username] = txID
+	}
+	if cached, ok := c.users[user.Username]; ok && cached.txID >= txID {
+		if cached.txID == txID {
+			// the user was changed more than once inside a batched transaction and
+			// we don't know the last change
+			delete(c.users, user.Username)
+		}
+		return
+	}
+	c.users[user.Username] = boltCachedUser{
+		user: user.getACopy(),
+		txID: txID,
+	}
+}
+
+func (c *boltUsersCache) invalidate(username string, txID int) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.users, username)
+	if c.changes[username] < txID {
+		c.changes[username] = txID
+	}
+}
+
+// invalidateAll removes all the cached users, it is used if several users are changed
+// inside the write transaction with the given ID
+func (c *boltUsersCache) invalidateAll(txID int) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.minTxID < txID {
+		c.minTxID = txID
+	}
+	c.users = make(map[string]boltCachedUser)
+	for username, changeTxID := range c.changes {
+		if changeTxID <= c.minTxID {
+			delete(c.changes, username)
+		}
+	}
+}
+
 func initializeBoltProvider(basePath string) error {
 	var err error
 	logSender = fmt.Sprintf("dataprovider_%v", BoltDataProviderName)
@@ -83,6 +199,38 @@ func initializeBoltProvider(basePath string) error {
 			providerLog(logger.LevelWarn, "error creating username idx bucket: %v", err)
 			return err
 		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(ipListsBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating IP lists bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(plansBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating plans bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(templatesBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating user templates bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(foldersBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating folders bucket: %v", err)
+			return err
+		}
 		err = dbHandle.Update(func(tx *bolt.Tx) error {
 			_, e := tx.CreateBucketIfNotExists(dbVersionBucket)
 			return e
@@ -91,7 +239,10 @@ func initializeBoltProvider(basePath string) error {
 			providerLog(logger.LevelWarn, "error creating database version bucket: %v", err)
 			return err
 		}
-		provider = BoltProvider{dbHandle: dbHandle}
+		provider = BoltProvider{
+			dbHandle: dbHandle,
+			cache:    newBoltUsersCache(),
+		}
 	} else {
 		providerLog(logger.LevelWarn, "error creating bolt key/value store handler: %v", err)
 	}
@@ -152,7 +303,9 @@ func (p BoltProvider) getUserByID(ID int64) (User, error) {
 }
 
 func (p BoltProvider) updateLastLogin(username string) error {
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	var user User
+	var txID int
+	err := p.dbHandle.Batch(func(tx *bolt.Tx) error {
 		bucket, _, err := getBuckets(tx)
 		if err != nil {
 			return err
@@ -161,7 +314,7 @@ func (p BoltProvider) updateLastLogin(username string) error {
 		if u = bucket.Get([]byte(username)); u == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to update last login", username)}
 		}
-		var user User
+		user = User{}
 		err = json.Unmarshal(u, &user)
 		if err != nil {
 			return err
@@ -171,12 +324,51 @@ func (p BoltProvider) updateLastLogin(username string) error {
 		if err != nil {
 			return err
 		}
+		txID = tx.ID()
+		return bucket.Put([]byte(username), buf)
+	})
+	if err == nil {
+		p.cache.update(user, txID)
+	}
+	return err
+}
+
+func (p BoltProvider) updateUserPassword(username, password string) error {
+	var user User
+	var txID int
+	err := p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, _, err := getBuckets(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to update password", username)}
+		}
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		user.Password = password
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		txID = tx.ID()
 		return bucket.Put([]byte(username), buf)
 	})
+	if err == nil {
+		p.cache.update(user, txID)
+	}
+	return err
 }
 
 func (p BoltProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	var user User
+	var txID int
+	// the batched function could run again, alone, if the batch fails, so the user is
+	// decoded from scratch for each run
+	err := p.dbHandle.Batch(func(tx *bolt.Tx) error {
 		bucket, _, err := getBuckets(tx)
 		if err != nil {
 			return err
@@ -185,7 +377,7 @@ func (p BoltProvider) updateQuota(username string, filesAdd int, sizeAdd int64,
 		if u = bucket.Get([]byte(username)); u == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to update quota", username)}
 		}
-		var user User
+		user = User{}
 		err = json.Unmarshal(u, &user)
 		if err != nil {
 			return err
@@ -202,8 +394,13 @@ func (p BoltProvider) updateQuota(username string, filesAdd int, sizeAdd int64,
 		if err != nil {
 			return err
 		}
+		txID = tx.ID()
 		return bucket.Put([]byte(username), buf)
 	})
+	if err == nil {
+		p.cache.update(user, txID)
+	}
+	return err
 }
 
 func (p BoltProvider) getUsedQuota(username string) (int, int64, error) {
@@ -216,7 +413,11 @@ func (p BoltProvider) getUsedQuota(username string) (int, int64, error) {
 }
 
 func (p BoltProvider) userExists(username string) (User, error) {
+	if user, ok := p.cache.get(username); ok {
+		return user, nil
+	}
 	var user User
+	var txID int
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
 		bucket, _, err := getBuckets(tx)
 		if err != nil {
@@ -226,8 +427,12 @@ func (p BoltProvider) userExists(username string) (User, error) {
 		if u == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", username)}
 		}
+		txID = tx.ID()
 		return json.Unmarshal(u, &user)
 	})
+	if err == nil {
+		p.cache.add(user, txID)
+	}
 	return user, err
 }
 
@@ -236,7 +441,8 @@ func (p BoltProvider) addUser(user User) error {
 	if err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	var txID int
+	err = p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, idxBucket, err := getBuckets(tx)
 		if err != nil {
 			return err
@@ -249,6 +455,7 @@ func (p BoltProvider) addUser(user User) error {
 			return err
 		}
 		user.ID = int64(id)
+		setUserCreationFields(&user)
 		buf, err := json.Marshal(user)
 		if err != nil {
 			return err
@@ -258,8 +465,13 @@ func (p BoltProvider) addUser(user User) error {
 		if err != nil {
 			return err
 		}
+		txID = tx.ID()
 		return idxBucket.Put(userIDAsBytes, []byte(user.Username))
 	})
+	if err == nil {
+		p.cache.invalidate(user.Username, txID)
+	}
+	return err
 }
 
 func (p BoltProvider) updateUser(user User) error {
@@ -267,189 +479,888 @@ func (p BoltProvider) updateUser(user User) error {
 	if err != nil {
 		return err
 	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	var txID int
+	err = p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, _, err := getBuckets(tx)
 		if err != nil {
 			return err
 		}
-		if u := bucket.Get([]byte(user.Username)); u == nil {
+		u := bucket.Get([]byte(user.Username))
+		if u == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", user.Username)}
 		}
+		var existing User
+		err = json.Unmarshal(u, &existing)
+		if err != nil {
+			return err
+		}
+		if err = setUserUpdateFields(&user, existing); err != nil {
+			return err
+		}
 		buf, err := json.Marshal(user)
 		if err != nil {
 			return err
 		}
+		txID = tx.ID()
 		return bucket.Put([]byte(user.Username), buf)
 	})
+	if err == nil {
+		p.cache.invalidate(user.Username, txID)
+	}
+	return err
 }
 
 func (p BoltProvider) deleteUser(user User) error {
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+	var userName []byte
+	var txID int
+	err := p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, idxBucket, err := getBuckets(tx)
 		if err != nil {
 			return err
 		}
 		userIDAsBytes := itob(user.ID)
-		userName := idxBucket.Get(userIDAsBytes)
+		userName = idxBucket.Get(userIDAsBytes)
 		if userName == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("user with id %v does not exist", user.ID)}
 		}
+		// the value is valid only during the transaction
+		userName = append([]byte(nil), userName...)
 		err = bucket.Delete(userName)
 		if err != nil {
 			return err
 		}
+		txID = tx.ID()
 		return idxBucket.Delete(userIDAsBytes)
 	})
+	if err == nil {
+		p.cache.invalidate(string(userName), txID)
+	}
+	return err
 }
 
-func (p BoltProvider) dumpUsers() ([]User, error) {
-	users := []User{}
-	var err error
-	err = p.dbHandle.View(func(tx *bolt.Tx) error {
-		bucket, _, err := getBuckets(tx)
+func (p BoltProvider) getIPListEntries() ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getIPListsBucket(tx)
 		if err != nil {
 			return err
 		}
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			var user User
-			err = json.Unmarshal(v, &user)
-			if err != nil {
-				return err
-			}
-			err = addCredentialsToUser(&user)
+			var entry IPListEntry
+			err = json.Unmarshal(v, &entry)
 			if err != nil {
 				return err
 			}
-			users = append(users, user)
+			entries = append(entries, entry)
 		}
-		return err
+		return nil
 	})
-	return users, err
+	return entries, err
 }
 
-func (p BoltProvider) getUserWithUsername(username string) ([]User, error) {
-	users := []User{}
-	var user User
-	user, err := p.userExists(username)
-	if err == nil {
-		users = append(users, HideUserSensitiveData(&user))
-		return users, nil
-	}
-	if _, ok := err.(*RecordNotFoundError); ok {
-		err = nil
-	}
-	return users, err
+func (p BoltProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	var entry IPListEntry
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getIPListsBucket(tx)
+		if err != nil {
+			return err
+		}
+		e := bucket.Get(itob(ID))
+		if e == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", ID)}
+		}
+		return json.Unmarshal(e, &entry)
+	})
+	return entry, err
 }
 
-func (p BoltProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
-	users := []User{}
-	var err error
-	if limit <= 0 {
-		return users, err
+func (p BoltProvider) addIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
 	}
-	if len(username) > 0 {
-		if offset == 0 {
-			return p.getUserWithUsername(username)
+	// the ID is assigned by the provider
+	entry.ID = 0
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getIPListsBucket(tx)
+		if err != nil {
+			return err
 		}
-		return users, err
-	}
-	err = p.dbHandle.View(func(tx *bolt.Tx) error {
-		bucket, _, err := getBuckets(tx)
+		if err = checkBoltIPListEntryIsUnique(bucket, entry); err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
 		if err != nil {
 			return err
 		}
-		cursor := bucket.Cursor()
-		itNum := 0
-		if order == "ASC" {
-			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
-				var user User
-				err = json.Unmarshal(v, &user)
-				if err == nil {
-					users = append(users, HideUserSensitiveData(&user))
-				}
-				if len(users) >= limit {
-					break
-				}
-			}
-		} else {
-			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
-				var user User
-				err = json.Unmarshal(v, &user)
-				if err == nil {
-					users = append(users, HideUserSensitiveData(&user))
-				}
-				if len(users) >= limit {
-					break
-				}
-			}
+		entry.ID = int64(id)
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
 		}
-		return err
+		return bucket.Put(itob(entry.ID), buf)
 	})
-	return users, err
-}
-
-func (p BoltProvider) close() error {
-	return p.dbHandle.Close()
-}
-
-func (p BoltProvider) reloadConfig() error {
-	return nil
-}
-
-// initializeDatabase does nothing, no initilization is needed for bolt provider
-func (p BoltProvider) initializeDatabase() error {
-	return errNoInitRequired
 }
 
-func (p BoltProvider) migrateDatabase() error {
-	dbVersion, err := getBoltDatabaseVersion(p.dbHandle)
+func (p BoltProvider) updateIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
 	if err != nil {
 		return err
 	}
-	if dbVersion.Version == boltDatabaseVersion {
-		providerLog(logger.LevelDebug, "bolt database is updated, current version: %v", dbVersion.Version)
-		return nil
-	}
-	switch dbVersion.Version {
-	case 1:
-		err = updateDatabaseFrom1To2(p.dbHandle)
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getIPListsBucket(tx)
 		if err != nil {
 			return err
 		}
-		return updateDatabaseFrom2To3(p.dbHandle)
-	case 2:
-		return updateDatabaseFrom2To3(p.dbHandle)
-	default:
-		return fmt.Errorf("Database version not handled: %v", dbVersion.Version)
-	}
+		if e := bucket.Get(itob(entry.ID)); e == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", entry.ID)}
+		}
+		if err = checkBoltIPListEntryIsUnique(bucket, entry); err != nil {
+			return err
+		}
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(entry.ID), buf)
+	})
 }
 
-// itob returns an 8-byte big endian representation of v.
-func itob(v int64) []byte {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, uint64(v))
-	return b
+func (p BoltProvider) deleteIPListEntry(entry IPListEntry) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getIPListsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if e := bucket.Get(itob(entry.ID)); e == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", entry.ID)}
+		}
+		return bucket.Delete(itob(entry.ID))
+	})
 }
 
-func getBuckets(tx *bolt.Tx) (*bolt.Bucket, *bolt.Bucket, error) {
-	var err error
-	bucket := tx.Bucket(usersBucket)
-	idxBucket := tx.Bucket(usersIDIdxBucket)
+func (p BoltProvider) getPlans() ([]Plan, error) {
+	plans := []Plan{}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getPlansBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var plan Plan
+			err = json.Unmarshal(v, &plan)
+			if err != nil {
+				return err
+			}
+			plans = append(plans, plan)
+		}
+		return nil
+	})
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Name < plans[j].Name
+	})
+	return plans, err
+}
+
+func (p BoltProvider) getPlanByID(ID int64) (Plan, error) {
+	var plan Plan
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getPlansBucket(tx)
+		if err != nil {
+			return err
+		}
+		plan, err = getBoltPlanByID(bucket, ID)
+		return err
+	})
+	return plan, err
+}
+
+func (p BoltProvider) planExists(name string) (Plan, error) {
+	var plan Plan
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getPlansBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			err = json.Unmarshal(v, &plan)
+			if err != nil {
+				return err
+			}
+			if plan.Name == name {
+				return nil
+			}
+		}
+		return &RecordNotFoundError{err: fmt.Sprintf("plan %#v does not exist", name)}
+	})
+	return plan, err
+}
+
+func (p BoltProvider) addPlan(plan Plan) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getPlansBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var existing Plan
+			err = json.Unmarshal(v, &existing)
+			if err != nil {
+				return err
+			}
+			if existing.Name == plan.Name {
+				return fmt.Errorf("plan %v already exists", plan.Name)
+			}
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		plan.ID = int64(id)
+		buf, err := json.Marshal(plan)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(plan.ID), buf)
+	})
+}
+
+// updatePlan updates the plan and, if applyToUsers is true, all the assigned users inside a single transaction
+func (p BoltProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	var txID int
+	err = p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getPlansBucket(tx)
+		if err != nil {
+			return err
+		}
+		userBucket, _, err := getBuckets(tx)
+		if err != nil {
+			return err
+		}
+		existing, err := getBoltPlanByID(bucket, plan.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Name != plan.Name {
+			return &ValidationError{err: "the plan name cannot be changed"}
+		}
+		var users []User
+		if applyToUsers {
+			cursor := userBucket.Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				var user User
+				err = json.Unmarshal(v, &user)
+				if err != nil {
+					return err
+				}
+				users = append(users, user)
+			}
+			users, err = applyPlanToUsers(plan, users)
+			if err != nil {
+				return err
+			}
+		}
+		for _, user := range users {
+			buf, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+			err = userBucket.Put([]byte(user.Username), buf)
+			if err != nil {
+				return err
+			}
+		}
+		buf, err := json.Marshal(plan)
+		if err != nil {
+			return err
+		}
+		txID = tx.ID()
+		return bucket.Put(itob(plan.ID), buf)
+	})
+	if err == nil {
+		p.cache.invalidateAll(txID)
+	}
+	return err
+}
+
+func (p BoltProvider) deletePlan(plan Plan) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getPlansBucket(tx)
+		if err != nil {
+			return err
+		}
+		userBucket, _, err := getBuckets(tx)
+		if err != nil {
+			return err
+		}
+		if _, err = getBoltPlanByID(bucket, plan.ID); err != nil {
+			return err
+		}
+		numUsers := 0
+		cursor := userBucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var user User
+			err = json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+			if user.Plan == plan.Name {
+				numUsers++
+			}
+		}
+		if numUsers > 0 {
+			return &ValidationError{err: fmt.Sprintf("plan %#v is assigned to %v users and it cannot be deleted",
+				plan.Name, numUsers)}
+		}
+		return bucket.Delete(itob(plan.ID))
+	})
+}
+
+func (p BoltProvider) getUserTemplates() ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var template UserTemplate
+			err = json.Unmarshal(v, &template)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, template)
+		}
+		return nil
+	})
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, err
+}
+
+func (p BoltProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	var template UserTemplate
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		template, err = getBoltUserTemplateByID(bucket, ID)
+		return err
+	})
+	return template, err
+}
+
+func (p BoltProvider) userTemplateExists(name string) (UserTemplate, error) {
+	var template UserTemplate
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			err = json.Unmarshal(v, &template)
+			if err != nil {
+				return err
+			}
+			if template.Name == name {
+				return nil
+			}
+		}
+		return &RecordNotFoundError{err: fmt.Sprintf("user template %#v does not exist", name)}
+	})
+	return template, err
+}
+
+func (p BoltProvider) addUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var existing UserTemplate
+			err = json.Unmarshal(v, &existing)
+			if err != nil {
+				return err
+			}
+			if existing.Name == template.Name {
+				return fmt.Errorf("user template %v already exists", template.Name)
+			}
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		template.ID = int64(id)
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(template.ID), buf)
+	})
+}
+
+func (p BoltProvider) updateUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		existing, err := getBoltUserTemplateByID(bucket, template.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Name != template.Name {
+			return &ValidationError{err: "the user template name cannot be changed"}
+		}
+		buf, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(template.ID), buf)
+	})
+}
+
+func (p BoltProvider) deleteUserTemplate(template UserTemplate) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getUserTemplatesBucket(tx)
+		if err != nil {
+			return err
+		}
+		if _, err = getBoltUserTemplateByID(bucket, template.ID); err != nil {
+			return err
+		}
+		return bucket.Delete(itob(template.ID))
+	})
+}
+
+func (p BoltProvider) getFolders() ([]Folder, error) {
+	folders := []Folder{}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var folder Folder
+			err = json.Unmarshal(v, &folder)
+			if err != nil {
+				return err
+			}
+			folders = append(folders, folder)
+		}
+		return nil
+	})
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders, err
+}
+
+func (p BoltProvider) getFolderByID(ID int64) (Folder, error) {
+	var folder Folder
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		folder, err = getBoltFolderByID(bucket, ID)
+		return err
+	})
+	return folder, err
+}
+
+func (p BoltProvider) folderExists(name string) (Folder, error) {
+	var folder Folder
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		folder, err = getBoltFolderByName(bucket, name)
+		return err
+	})
+	return folder, err
+}
+
+func (p BoltProvider) addFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		if _, err = getBoltFolderByName(bucket, folder.Name); err == nil {
+			return fmt.Errorf("folder %v already exists", folder.Name)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		folder.ID = int64(id)
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(folder.ID), buf)
+	})
+}
+
+func (p BoltProvider) updateFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		existing, err := getBoltFolderByID(bucket, folder.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Name != folder.Name {
+			return &ValidationError{err: "the folder name cannot be changed"}
+		}
+		folder.UsedQuotaSize = existing.UsedQuotaSize
+		folder.UsedQuotaFiles = existing.UsedQuotaFiles
+		folder.LastQuotaUpdate = existing.LastQuotaUpdate
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(folder.ID), buf)
+	})
+}
+
+func (p BoltProvider) deleteFolder(folder Folder) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		if _, err = getBoltFolderByID(bucket, folder.ID); err != nil {
+			return err
+		}
+		return bucket.Delete(itob(folder.ID))
+	})
+}
+
+func (p BoltProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.dbHandle.Batch(func(tx *bolt.Tx) error {
+		bucket, err := getFoldersBucket(tx)
+		if err != nil {
+			return err
+		}
+		folder, err := getBoltFolderByName(bucket, name)
+		if err != nil {
+			return err
+		}
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(folder.ID), buf)
+	})
+}
+
+func (p BoltProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.folderExists(name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for folder %v error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+func (p BoltProvider) dumpUsers() ([]User, error) {
+	users := []User{}
+	var err error
+	err = p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, _, err := getBuckets(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var user User
+			err = json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+			err = addCredentialsToUser(&user)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return err
+	})
+	return users, err
+}
+
+func (p BoltProvider) getUserWithUsername(username string) ([]User, error) {
+	users := []User{}
+	var user User
+	user, err := p.userExists(username)
+	if err == nil {
+		users = append(users, HideUserSensitiveData(&user))
+		return users, nil
+	}
+	if _, ok := err.(*RecordNotFoundError); ok {
+		err = nil
+	}
+	return users, err
+}
+
+func (p BoltProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
+	users := []User{}
+	var err error
+	if limit <= 0 {
+		return users, err
+	}
+	if len(username) > 0 {
+		if offset == 0 {
+			return p.getUserWithUsername(username)
+		}
+		return users, err
+	}
+	err = p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, _, err := getBuckets(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == "ASC" {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var user User
+				err = json.Unmarshal(v, &user)
+				if err == nil {
+					users = append(users, HideUserSensitiveData(&user))
+				}
+				if len(users) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var user User
+				err = json.Unmarshal(v, &user)
+				if err == nil {
+					users = append(users, HideUserSensitiveData(&user))
+				}
+				if len(users) >= limit {
+					break
+				}
+			}
+		}
+		return err
+	})
+	return users, err
+}
+
+func (p BoltProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return getUsersPageFromList(p, UserScope{}, req, cursor)
+}
+
+func (p BoltProvider) close() error {
+	return p.dbHandle.Close()
+}
+
+func (p BoltProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase does nothing, no initilization is needed for bolt provider
+func (p BoltProvider) initializeDatabase() error {
+	return errNoInitRequired
+}
+
+func (p BoltProvider) migrateDatabase() error {
+	dbVersion, err := getBoltDatabaseVersion(p.dbHandle)
+	if err != nil {
+		return err
+	}
+	if dbVersion.Version == boltDatabaseVersion {
+		providerLog(logger.LevelDebug, "bolt database is updated, current version: %v", dbVersion.Version)
+		return nil
+	}
+	switch dbVersion.Version {
+	case 1:
+		err = updateDatabaseFrom1To2(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateDatabaseFrom2To3(p.dbHandle)
+	case 2:
+		return updateDatabaseFrom2To3(p.dbHandle)
+	default:
+		return fmt.Errorf("Database version not handled: %v", dbVersion.Version)
+	}
+}
+
+// itob returns an 8-byte big endian representation of v.
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func getBuckets(tx *bolt.Tx) (*bolt.Bucket, *bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(usersBucket)
+	idxBucket := tx.Bucket(usersIDIdxBucket)
 	if bucket == nil || idxBucket == nil {
 		err = fmt.Errorf("unable to find required buckets, bolt database structure not correcly defined")
 	}
 	return bucket, idxBucket, err
 }
 
+func getIPListsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(ipListsBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find IP lists bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getPlansBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(plansBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find plans bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getBoltPlanByID(bucket *bolt.Bucket, ID int64) (Plan, error) {
+	var plan Plan
+	p := bucket.Get(itob(ID))
+	if p == nil {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+	}
+	err := json.Unmarshal(p, &plan)
+	return plan, err
+}
+
+func getUserTemplatesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(templatesBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find user templates bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getBoltUserTemplateByID(bucket *bolt.Bucket, ID int64) (UserTemplate, error) {
+	var template UserTemplate
+	t := bucket.Get(itob(ID))
+	if t == nil {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+	}
+	err := json.Unmarshal(t, &template)
+	return template, err
+}
+
+func getFoldersBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(foldersBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find folders bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getBoltFolderByID(bucket *bolt.Bucket, ID int64) (Folder, error) {
+	var folder Folder
+	f := bucket.Get(itob(ID))
+	if f == nil {
+		return folder, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+	}
+	err := json.Unmarshal(f, &folder)
+	return folder, err
+}
+
+func getBoltFolderByName(bucket *bolt.Bucket, name string) (Folder, error) {
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var folder Folder
+		err := json.Unmarshal(v, &folder)
+		if err != nil {
+			return folder, err
+		}
+		if folder.Name == name {
+			return folder, nil
+		}
+	}
+	return Folder{}, &RecordNotFoundError{err: fmt.Sprintf("folder %#v does not exist", name)}
+}
+
+// checkBoltIPListEntryIsUnique returns an error if the IP or network for the given
+// entry is already defined inside another entry
+func checkBoltIPListEntryIsUnique(bucket *bolt.Bucket, entry IPListEntry) error {
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var existing IPListEntry
+		err := json.Unmarshal(v, &existing)
+		if err != nil {
+			return err
+		}
+		if existing.IPOrNet == entry.IPOrNet && existing.ID != entry.ID {
+			return fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+		}
+	}
+	return nil
+}
+
 func updateDatabaseFrom1To2(dbHandle *bolt.DB) error {
 	providerLog(logger.LevelInfo, "updating bolt database version: 1 -> 2")
 	usernames, err := getBoltAvailableUsernames(dbHandle)
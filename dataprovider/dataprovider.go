@@ -7,6 +7,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -39,6 +40,7 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
@@ -57,6 +59,14 @@ const (
 	BoltDataProviderName = "bolt"
 	// MemoryDataProviderName name for memory provider
 	MemoryDataProviderName = "memory"
+	// RedisDataProviderName name for Redis key/value store provider
+	RedisDataProviderName = "redis"
+	// HTTPDataProviderName name for the provider that delegates to an external HTTP service
+	HTTPDataProviderName = "http"
+	// DynamoDBDataProviderName name for AWS DynamoDB provider
+	DynamoDBDataProviderName = "dynamodb"
+	// EtcdDataProviderName name for etcd key/value store provider
+	EtcdDataProviderName = "etcd"
 
 	argonPwdPrefix            = "$argon2id$"
 	bcryptPwdPrefix           = "$2a$"
@@ -72,12 +82,22 @@ const (
 	operationAdd              = "add"
 	operationUpdate           = "update"
 	operationDelete           = "delete"
+	operationOffboard         = "offboard"
+)
+
+// Supported algorithms for hashing passwords
+const (
+	// HashingAlgoArgon2ID defines the argon2id password hashing algorithm
+	HashingAlgoArgon2ID = "argon2id"
+	// HashingAlgoBcrypt defines the bcrypt password hashing algorithm
+	HashingAlgoBcrypt = "bcrypt"
 )
 
 var (
 	// SupportedProviders defines the supported data providers
 	SupportedProviders = []string{SQLiteDataProviderName, PGSQLDataProviderName, MySQLDataProviderName,
-		BoltDataProviderName, MemoryDataProviderName}
+		BoltDataProviderName, MemoryDataProviderName, RedisDataProviderName, HTTPDataProviderName,
+		DynamoDBDataProviderName, EtcdDataProviderName}
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermRename, PermDelete,
 		PermCreateDirs, PermCreateSymlinks, PermChmod, PermChown, PermChtimes}
@@ -86,10 +106,13 @@ var (
 		SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt}
 	// SSHMultiStepsLoginMethods defines the supported Multi-Step Authentications
 	SSHMultiStepsLoginMethods = []string{SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt}
-	config                    Config
-	provider                  Provider
-	sqlPlaceholders           []string
-	hashPwdPrefixes           = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
+	// ValidPublicKeyAlgorithms defines the public key algorithms that can be used in the allowed_key_algorithms filter
+	ValidPublicKeyAlgorithms = []string{ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521, ssh.KeyAlgoED25519, ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoSKED25519}
+	config          Config
+	provider        Provider
+	sqlPlaceholders []string
+	hashPwdPrefixes = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
 		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
 	pbkdfPwdPrefixes        = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
 	pbkdfPwdB64SaltPrefixes = []string{pbkdf2SHA256B64SaltPrefix}
@@ -100,6 +123,8 @@ var (
 	errWrongPassword        = errors.New("password does not match")
 	errNoInitRequired       = errors.New("initialization is not required for this data provider")
 	credentialsDirPath      string
+	userActionHooksMutex    sync.RWMutex
+	userActionHooks         []UserActionHook
 )
 
 type schemaVersion struct {
@@ -120,6 +145,45 @@ type Actions struct {
 	HTTPNotificationURL string `json:"http_notification_url" mapstructure:"http_notification_url"`
 }
 
+// PasswordHashing defines the configuration for password hashing
+type PasswordHashing struct {
+	// Algorithm to use to hash the users passwords. Supported values are "argon2id" and "bcrypt".
+	// Empty means "argon2id"
+	Algo string `json:"algo" mapstructure:"algo"`
+	// Cost factor for bcrypt, used if the hashing algorithm is "bcrypt".
+	// Values lower than the minimum allowed cost (4) mean the bcrypt default cost (10)
+	BcryptCost int `json:"bcrypt_cost" mapstructure:"bcrypt_cost"`
+	// If enabled, password hashes created using weaker algorithms (MD5-crypt, SHA512-crypt, pbkdf2),
+	// or bcrypt hashes with a cost lower than the configured one, will be replaced with a hash using
+	// the configured algorithm after a successful login
+	UpgradeLegacyHashes bool `json:"upgrade_legacy_hashes" mapstructure:"upgrade_legacy_hashes"`
+}
+
+// LDAPConfig defines the configuration for the LDAP read-through mode.
+// If enabled, user identity and password verification come from the LDAP server while the
+// SFTPGo specific settings, such as quota, filesystem configuration and filters, are stored
+// inside the configured data provider
+type LDAPConfig struct {
+	// LDAP server URL, for example "ldap://127.0.0.1:389" or "ldaps://ldap.example.com:636".
+	// Leave empty to disable the LDAP read-through mode
+	URL string `json:"url" mapstructure:"url"`
+	// If enabled a StartTLS request is issued after connecting to an "ldap://" URL
+	StartTLS bool `json:"start_tls" mapstructure:"start_tls"`
+	// If enabled the LDAP server certificate is not verified. Use this for testing only
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// DN and password for the account used to search the users. Leave empty for anonymous searches
+	BindDN       string `json:"bind_dn" mapstructure:"bind_dn"`
+	BindPassword string `json:"bind_password" mapstructure:"bind_password"`
+	// Base DN for user searches, for example "ou=people,dc=example,dc=com"
+	BaseDN string `json:"base_dn" mapstructure:"base_dn"`
+	// Filter used to find the user entry. "%s" is replaced with the escaped username,
+	// for example "(&(objectClass=posixAccount)(uid=%s))"
+	SearchFilter string `json:"search_filter" mapstructure:"search_filter"`
+	// Permissions for the root directory assigned to the users added to the data provider at their
+	// first successful login. The home directory is built using the configured users_base_dir
+	DefaultPermissions []string `json:"default_permissions" mapstructure:"default_permissions"`
+}
+
 // Config provider configuration
 type Config struct {
 	// Driver name, must be one of the SupportedProviders
@@ -141,9 +205,26 @@ type Config struct {
 	// 2 set ssl mode to verify-ca for driver postgresql and skip-verify for driver mysql.
 	// 3 set ssl mode to verify-full for driver postgresql and preferred for driver mysql.
 	SSLMode int `json:"sslmode" mapstructure:"sslmode"`
+	// Used for drivers mysql and postgresql. Path to the PEM encoded CA certificate used to verify
+	// the database server certificate, if empty the system root CAs are used.
+	// This can be an absolute path or a path relative to the config dir
+	RootCert string `json:"root_cert" mapstructure:"root_cert"`
+	// Used for drivers mysql and postgresql. Paths to the PEM encoded client certificate and
+	// private key for databases requiring TLS client authentication.
+	// They can be absolute paths or paths relative to the config dir
+	ClientCert string `json:"client_cert" mapstructure:"client_cert"`
+	ClientKey  string `json:"client_key" mapstructure:"client_key"`
+	// Used for driver sqlite. Path to a file containing the passphrase used to encrypt the database
+	// with SQLCipher. SFTPGo must be built with the "sqlcipher" build tag to use this setting.
+	// This can be an absolute path or a path relative to the config dir
+	SQLiteKeyFile string `json:"sqlite_key_file" mapstructure:"sqlite_key_file"`
 	// Custom database connection string.
 	// If not empty this connection string will be used instead of build one using the previous parameters
 	ConnectionString string `json:"connection_string" mapstructure:"connection_string"`
+	// Connection string for a read only replica of the database, for the SQL drivers.
+	// If not empty, the login lookups and the users listings are served by the replica while
+	// the writes and the other reads go to the primary database
+	ReadReplicaConnectionString string `json:"read_replica_connection_string" mapstructure:"read_replica_connection_string"`
 	// Database table for SFTP users
 	UsersTable string `json:"users_table" mapstructure:"users_table"`
 	// Set to 0 to disable users management, 1 to enable
@@ -155,6 +236,16 @@ type Config struct {
 	//    With this configuration the "quota scan" REST API can still be used to periodically update space usage
 	//    for users without quota restrictions
 	TrackQuota int `json:"track_quota" mapstructure:"track_quota"`
+	// Set how the size of the files stored on the local filesystem is computed for quota accounting:
+	// 0, the logical file size is used
+	// 1, the disk space actually allocated for the file is used. Sparse files, such as VM images,
+	//    will be accounted for the blocks they really use. Not supported on Windows, the logical
+	//    size is used there
+	QuotaSizeMode int `json:"quota_size_mode" mapstructure:"quota_size_mode"`
+	// Maximum number of directories scanned concurrently while updating the used quota for
+	// users with a local filesystem. 0 means the number of the available CPUs, 1 disables
+	// concurrent scans
+	QuotaScanWorkers int `json:"quota_scan_workers" mapstructure:"quota_scan_workers"`
 	// Sets the maximum number of open connections for mysql and postgresql driver.
 	// Default 0 (unlimited)
 	PoolSize int `json:"pool_size" mapstructure:"pool_size"`
@@ -250,11 +341,38 @@ type Config struct {
 	// PreLoginHook and ExternalAuthHook are mutally exclusive.
 	// Leave empty to disable.
 	PreLoginHook string `json:"pre_login_hook" mapstructure:"pre_login_hook"`
+	// Password hashing configuration
+	PasswordHashing PasswordHashing `json:"password_hashing" mapstructure:"password_hashing"`
+	// Reject the passwords exposed inside known data breaches
+	PasswordBreachCheck PasswordBreachCheck `json:"password_breach_check" mapstructure:"password_breach_check"`
+	// In-process cache for the users looked up by username, used by the SQL data providers
+	UsersCache UsersCacheConfig `json:"users_cache" mapstructure:"users_cache"`
+	// Helpers for the users sharing an S3 bucket, each one inside its own key prefix
+	S3Tenants S3TenantsConfig `json:"s3_tenants" mapstructure:"s3_tenants"`
+	// LDAP read-through configuration. LDAP, ExternalAuthHook and PreLoginHook are mutually exclusive
+	LDAP LDAPConfig `json:"ldap" mapstructure:"ldap"`
+	// External block lists periodically downloaded, for example threat intelligence feeds.
+	// The downloaded entries are applied as the block list entries
+	IPListFeeds []IPListFeed `json:"ip_list_feeds" mapstructure:"ip_list_feeds"`
+	// Second data provider to migrate to. If configured, the changes are written to both the
+	// data providers while the reads are served by the data provider in use
+	MigrationTarget MigrationTarget `json:"migration_target" mapstructure:"migration_target"`
+	// Persistence for the memory provider, the data are saved to the file configured as name
+	MemoryPersistence MemoryPersistence `json:"memory_persistence" mapstructure:"memory_persistence"`
+	// Background jobs applying an updated plan to the assigned users
+	PlanPropagation PlanPropagationConfig `json:"plan_propagation" mapstructure:"plan_propagation"`
+	// Policy to disable or expire the users that did not log in for a configurable number of days
+	InactivityPolicy InactivityPolicy `json:"inactivity_policy" mapstructure:"inactivity_policy"`
 }
 
 // BackupData defines the structure for the backup/restore files
 type BackupData struct {
-	Users []User `json:"users"`
+	Users         []User         `json:"users"`
+	Plans         []Plan         `json:"plans,omitempty"`
+	UserTemplates []UserTemplate `json:"user_templates,omitempty"`
+	Folders       []Folder       `json:"folders,omitempty"`
+	// only used by the memory provider persistence
+	IPListEntries []IPListEntry `json:"ip_list_entries,omitempty"`
 }
 
 type keyboardAuthHookRequest struct {
@@ -304,6 +422,10 @@ func (e *RecordNotFoundError) Error() string {
 	return fmt.Sprintf("Not found: %s", e.err)
 }
 
+// ErrUserVersionMismatch is returned when updating a user modified after it was read,
+// the user must be reloaded and the changes applied again
+var ErrUserVersionMismatch = errors.New("the user was modified after it was read, reload it and retry")
+
 // GetProvider returns the configured provider
 func GetProvider() Provider {
 	return provider
@@ -325,9 +447,36 @@ type Provider interface {
 	updateUser(user User) error
 	deleteUser(user User) error
 	getUsers(limit int, offset int, order string, username string) ([]User, error)
+	getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error)
 	dumpUsers() ([]User, error)
 	getUserByID(ID int64) (User, error)
 	updateLastLogin(username string) error
+	updateUserPassword(username, password string) error
+	getIPListEntries() ([]IPListEntry, error)
+	getIPListEntryByID(ID int64) (IPListEntry, error)
+	addIPListEntry(entry IPListEntry) error
+	updateIPListEntry(entry IPListEntry) error
+	deleteIPListEntry(entry IPListEntry) error
+	getPlans() ([]Plan, error)
+	getPlanByID(ID int64) (Plan, error)
+	planExists(name string) (Plan, error)
+	addPlan(plan Plan) error
+	updatePlan(plan Plan, applyToUsers bool) error
+	deletePlan(plan Plan) error
+	getUserTemplates() ([]UserTemplate, error)
+	getUserTemplateByID(ID int64) (UserTemplate, error)
+	userTemplateExists(name string) (UserTemplate, error)
+	addUserTemplate(template UserTemplate) error
+	updateUserTemplate(template UserTemplate) error
+	deleteUserTemplate(template UserTemplate) error
+	getFolders() ([]Folder, error)
+	getFolderByID(ID int64) (Folder, error)
+	folderExists(name string) (Folder, error)
+	addFolder(folder Folder) error
+	updateFolder(folder Folder) error
+	deleteFolder(folder Folder) error
+	updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error
+	getUsedFolderQuota(name string) (int, int64, error)
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -345,13 +494,39 @@ func Initialize(cnf Config, basePath string) error {
 	var err error
 	config = cnf
 	sqlPlaceholders = getSQLPlaceholders()
+	vfs.SetUseAllocatedSize(config.QuotaSizeMode == 1)
+	vfs.SetQuotaScanWorkers(config.QuotaScanWorkers)
 
 	if err = validateHooks(); err != nil {
 		return err
 	}
+	if err = validatePasswordHashing(); err != nil {
+		return err
+	}
+	if err = validatePasswordBreachCheck(basePath); err != nil {
+		return err
+	}
+	if err = validateUsersCache(); err != nil {
+		return err
+	}
+	if err = validateS3Tenants(basePath); err != nil {
+		return err
+	}
+	if err = config.LDAP.validate(); err != nil {
+		return err
+	}
+	if err = validateIPListFeeds(); err != nil {
+		return err
+	}
 	if err = validateCredentialsDir(basePath); err != nil {
 		return err
 	}
+	if err = config.MigrationTarget.validate(); err != nil {
+		return err
+	}
+	if err = config.InactivityPolicy.validate(); err != nil {
+		return err
+	}
 	err = createProvider(basePath)
 	if err != nil {
 		return err
@@ -361,7 +536,15 @@ func Initialize(cnf Config, basePath string) error {
 		providerLog(logger.LevelWarn, "database migration error: %v", err)
 		return err
 	}
+	if config.MigrationTarget.isEnabled() {
+		if err = createMigrationTarget(basePath); err != nil {
+			providerLog(logger.LevelWarn, "unable to initialize the migration target: %v", err)
+			return err
+		}
+	}
 	startAvailabilityTimer()
+	startIPListFeedsScheduler()
+	startInactivityPolicyScheduler()
 	return nil
 }
 
@@ -389,12 +572,40 @@ func validateHooks() error {
 	return nil
 }
 
+func validatePasswordHashing() error {
+	switch config.PasswordHashing.Algo {
+	case "", HashingAlgoArgon2ID, HashingAlgoBcrypt:
+	default:
+		return fmt.Errorf("unsupported password hashing algorithm: %#v", config.PasswordHashing.Algo)
+	}
+	if config.PasswordHashing.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %v, max allowed: %v", config.PasswordHashing.BcryptCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
 // InitializeDatabase creates the initial database structure
 func InitializeDatabase(cnf Config, basePath string) error {
 	config = cnf
 	sqlPlaceholders = getSQLPlaceholders()
 
-	if config.Driver == BoltDataProviderName || config.Driver == MemoryDataProviderName {
+	targetInitialized := false
+	if config.MigrationTarget.isEnabled() {
+		if err := config.MigrationTarget.validate(); err != nil {
+			return err
+		}
+		err := initializeMigrationTargetDatabase(basePath)
+		if err != nil && err != errNoInitRequired {
+			return err
+		}
+		targetInitialized = err == nil
+	}
+	if config.Driver == BoltDataProviderName || config.Driver == MemoryDataProviderName ||
+		config.Driver == RedisDataProviderName || config.Driver == HTTPDataProviderName ||
+		config.Driver == EtcdDataProviderName {
+		if targetInitialized {
+			return nil
+		}
 		return errNoInitRequired
 	}
 	err := createProvider(basePath)
@@ -406,6 +617,13 @@ func InitializeDatabase(cnf Config, basePath string) error {
 
 // CheckUserAndPass retrieves the SFTP user with the given username and password if a match is found or an error
 func CheckUserAndPass(p Provider, username string, password string) (User, error) {
+	if config.LDAP.isEnabled() {
+		user, err := doLDAPAuth(p, username, password)
+		if err != nil {
+			return user, err
+		}
+		return user, checkLoginConditions(user)
+	}
 	if len(config.ExternalAuthHook) > 0 && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
 		user, err := doExternalAuth(username, password, nil, "")
 		if err != nil {
@@ -418,13 +636,26 @@ func CheckUserAndPass(p Provider, username string, password string) (User, error
 		if err != nil {
 			return user, err
 		}
-		return checkUserAndPass(user, password)
+		user, err = checkUserAndPass(user, password)
+		if err == nil {
+			upgradePasswordHash(p, &user, password)
+		}
+		return user, err
 	}
-	return p.validateUserAndPass(username, password)
+	user, err := p.validateUserAndPass(username, password)
+	if err == nil {
+		upgradePasswordHash(p, &user, password)
+	}
+	return user, err
 }
 
 // CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
 func CheckUserAndPubKey(p Provider, username string, pubKey []byte) (User, string, error) {
+	if config.LDAP.isEnabled() {
+		if err := config.LDAP.checkUserExists(username); err != nil {
+			return User{}, "", err
+		}
+	}
 	if len(config.ExternalAuthHook) > 0 && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
 		user, err := doExternalAuth(username, "", pubKey, "")
 		if err != nil {
@@ -442,11 +673,43 @@ func CheckUserAndPubKey(p Provider, username string, pubKey []byte) (User, strin
 	return p.validateUserAndPubKey(username, pubKey)
 }
 
+// SimulateUserAndPass checks the given password against the user stored in the data provider.
+// Unlike CheckUserAndPass it has no side effects: the LDAP server, the external authentication
+// and the pre-login hooks are not used and the password hash is never upgraded
+func SimulateUserAndPass(p Provider, username string, password string) (User, error) {
+	if len(password) == 0 {
+		return User{}, errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		return user, err
+	}
+	return checkUserAndPass(user, password)
+}
+
+// SimulateUserAndPubKey checks the given public key against the user stored in the data provider.
+// As SimulateUserAndPass, it has no side effects
+func SimulateUserAndPubKey(p Provider, username string, pubKey []byte) (User, string, error) {
+	if len(pubKey) == 0 {
+		return User{}, "", errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		return user, "", err
+	}
+	return checkUserAndPubKey(user, pubKey)
+}
+
 // CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
 // the authenticated user or an error
 func CheckKeyboardInteractiveAuth(p Provider, username, authHook string, client ssh.KeyboardInteractiveChallenge) (User, error) {
 	var user User
 	var err error
+	if config.LDAP.isEnabled() {
+		if err = config.LDAP.checkUserExists(username); err != nil {
+			return user, err
+		}
+	}
 	if len(config.ExternalAuthHook) > 0 && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
 		user, err = doExternalAuth(username, "", nil, "1")
 	} else if len(config.PreLoginHook) > 0 {
@@ -502,7 +765,20 @@ func AddUser(p Provider, user User) error {
 	if config.ManageUsers == 0 {
 		return &MethodDisabledError{err: manageUsersDisabledError}
 	}
-	err := p.addUser(user)
+	err := applyUserPlan(p, &user)
+	if err != nil {
+		return err
+	}
+	err = applyUserFolders(p, &user)
+	if err != nil {
+		return err
+	}
+	applyS3TenantKeyPrefix(&user, true)
+	err = checkUserPassword(p, &user)
+	if err != nil {
+		return err
+	}
+	err = p.addUser(user)
 	if err == nil {
 		go executeAction(operationAdd, user)
 	}
@@ -515,13 +791,68 @@ func UpdateUser(p Provider, user User) error {
 	if config.ManageUsers == 0 {
 		return &MethodDisabledError{err: manageUsersDisabledError}
 	}
-	err := p.updateUser(user)
+	err := applyUserPlan(p, &user)
+	if err != nil {
+		return err
+	}
+	err = applyUserFolders(p, &user)
+	if err != nil {
+		return err
+	}
+	applyS3TenantKeyPrefix(&user, false)
+	err = checkUserPassword(p, &user)
+	if err != nil {
+		return err
+	}
+	err = p.updateUser(user)
 	if err == nil {
 		go executeAction(operationUpdate, user)
 	}
 	return err
 }
 
+// CompleteFirstLoginActions records the first login actions completed by the given user:
+// the acceptance of the terms of use, if acceptTerms is true, and the change of the initial
+// password, if newPassword is not empty. The updated user is returned
+func CompleteFirstLoginActions(p Provider, username string, acceptTerms bool, newPassword string) (User, error) {
+	if config.ManageUsers == 0 {
+		return User{}, &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		return user, err
+	}
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	if acceptTerms && user.Filters.FirstLogin.IsTermsAcceptancePending() {
+		user.Filters.FirstLogin.TermsAcceptedAt = now
+	}
+	if len(newPassword) > 0 && user.Filters.FirstLogin.IsPasswordChangePending() {
+		if _, err = checkUserAndPass(user, newPassword); err == nil {
+			return user, &ValidationError{err: "the new password must be different from the initial one"}
+		}
+		user.Password = newPassword
+		user.Filters.FirstLogin.PasswordChangedAt = now
+	}
+	if user.HasPendingFirstLoginActions() {
+		return user, errors.New("some first login actions are not completed")
+	}
+	if err = checkUserPassword(p, &user); err != nil {
+		return user, err
+	}
+	err = p.updateUser(user)
+	if err != nil {
+		return user, err
+	}
+	providerLog(logger.LevelInfo, "first login actions completed for user %#v, terms accepted at: %v, password changed at: %v",
+		username, user.Filters.FirstLogin.TermsAcceptedAt, user.Filters.FirstLogin.PasswordChangedAt)
+	// reload the user to get the hashed password
+	user, err = p.userExists(username)
+	if err == nil {
+		go executeAction(operationUpdate, user)
+	}
+	return user, err
+}
+
 // DeleteUser deletes an existing SFTP user.
 // ManageUsers configuration must be set to 1 to enable this method
 func DeleteUser(p Provider, user User) error {
@@ -535,6 +866,46 @@ func DeleteUser(p Provider, user User) error {
 	return err
 }
 
+// GetUserForBackup returns the user with the given username including its hashed password
+// and the filesystem credentials, as for the dumps
+func GetUserForBackup(p Provider, username string) (User, error) {
+	user, err := p.userExists(username)
+	if err != nil {
+		return user, err
+	}
+	err = addCredentialsToUser(&user)
+	return user, err
+}
+
+// OffboardUser disables an existing SFTP user and revokes all its public keys using a single update.
+// The offboard action is executed instead of the update one.
+// ManageUsers configuration must be set to 1 to enable this method
+func OffboardUser(p Provider, user User) (User, error) {
+	if config.ManageUsers == 0 {
+		return user, &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	err := applyUserPlan(p, &user)
+	if err != nil {
+		return user, err
+	}
+	user.Status = 0
+	for i, k := range user.PublicKeys {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			return user, &ValidationError{err: fmt.Sprintf("could not parse key nr. %d: %s", i, err)}
+		}
+		fp := ssh.FingerprintSHA256(pubKey)
+		if !utils.IsStringInSlice(fp, user.Filters.RevokedKeyFingerprints) {
+			user.Filters.RevokedKeyFingerprints = append(user.Filters.RevokedKeyFingerprints, fp)
+		}
+	}
+	err = p.updateUser(user)
+	if err == nil {
+		go executeAction(operationOffboard, user)
+	}
+	return user, err
+}
+
 // DumpUsers returns an array with all users including their hashed password
 func DumpUsers(p Provider) ([]User, error) {
 	return p.dumpUsers()
@@ -544,7 +915,21 @@ func DumpUsers(p Provider) ([]User, error) {
 // Currently only implemented for memory provider, allows to reload the users
 // from the configured file, if defined
 func ReloadConfig() error {
-	return provider.reloadConfig()
+	err := provider.reloadConfig()
+	if err == nil {
+		reloadIPLists(provider)
+	}
+	return err
+}
+
+// Flush saves the pending changes, if any.
+// Currently only implemented for memory provider with persistence enabled,
+// it is called when the service stops
+func Flush() error {
+	if p, ok := provider.(MemoryProvider); ok {
+		return p.saveData()
+	}
+	return nil
 }
 
 // GetUsers returns an array of users respecting limit and offset and filtered by username exact match if not empty
@@ -562,27 +947,61 @@ func GetProviderStatus(p Provider) error {
 	return p.checkAvailability()
 }
 
+// GetSchemaVersion returns the database schema version for the given provider.
+// It is supported for the SQL based and bolt providers, for the other ones a MethodDisabledError is returned
+func GetSchemaVersion(p Provider) (int, error) {
+	var dbVersion schemaVersion
+	var err error
+	switch v := p.(type) {
+	case *dualWriteProvider:
+		return GetSchemaVersion(v.source)
+	case SQLiteProvider:
+		dbVersion, err = sqlCommonGetDatabaseVersion(v.dbHandle)
+	case PGSQLProvider:
+		dbVersion, err = sqlCommonGetDatabaseVersion(v.dbHandle)
+	case MySQLProvider:
+		dbVersion, err = sqlCommonGetDatabaseVersion(v.dbHandle)
+	case BoltProvider:
+		dbVersion, err = getBoltDatabaseVersion(v.dbHandle)
+	default:
+		return 0, &MethodDisabledError{err: "the schema version is not available for the configured data provider"}
+	}
+	return dbVersion.Version, err
+}
+
 // Close releases all provider resources.
 // This method is used in test cases.
 // Closing an uninitialized provider is not supported
 func Close(p Provider) error {
 	availabilityTicker.Stop()
 	availabilityTickerDone <- true
+	stopIPListFeedsScheduler()
+	stopInactivityPolicyScheduler()
 	return p.close()
 }
 
 func createProvider(basePath string) error {
 	var err error
+	resetSQLUsersCaches()
+	resetPreparedStmts()
 	if config.Driver == SQLiteDataProviderName {
 		err = initializeSQLiteProvider(basePath)
 	} else if config.Driver == PGSQLDataProviderName {
-		err = initializePGSQLProvider()
+		err = initializePGSQLProvider(basePath)
 	} else if config.Driver == MySQLDataProviderName {
-		err = initializeMySQLProvider()
+		err = initializeMySQLProvider(basePath)
 	} else if config.Driver == BoltDataProviderName {
 		err = initializeBoltProvider(basePath)
 	} else if config.Driver == MemoryDataProviderName {
 		err = initializeMemoryProvider(basePath)
+	} else if config.Driver == RedisDataProviderName {
+		err = initializeRedisProvider()
+	} else if config.Driver == HTTPDataProviderName {
+		err = initializeHTTPProvider()
+	} else if config.Driver == DynamoDBDataProviderName {
+		err = initializeDynamoDBProvider()
+	} else if config.Driver == EtcdDataProviderName {
+		err = initializeEtcdProvider()
 	} else {
 		err = fmt.Errorf("unsupported data provider: %v", config.Driver)
 	}
@@ -643,6 +1062,28 @@ func validateVirtualFolders(user *User) error {
 		if !path.IsAbs(cleanedVPath) || cleanedVPath == "/" {
 			return &ValidationError{err: fmt.Sprintf("invalid virtual folder %#v", v.VirtualPath)}
 		}
+		if v.IsShared() && v.HasFilesystem() {
+			return &ValidationError{err: fmt.Sprintf("invalid virtual folder %#v: a shared folder cannot have its own filesystem",
+				v.VirtualPath)}
+		}
+		if v.HasFilesystem() {
+			folderFs, err := validateVirtualFolderFilesystem(*v.Filesystem)
+			if err != nil {
+				return &ValidationError{err: fmt.Sprintf("invalid filesystem for virtual folder %#v: %v", v.VirtualPath, err)}
+			}
+			for _, folder := range virtualFolders {
+				if isVirtualDirOverlapped(folder.VirtualPath, cleanedVPath) {
+					return &ValidationError{err: fmt.Sprintf("invalid virtual folder %#v overlaps with virtual folder %#v",
+						v.VirtualPath, folder.VirtualPath)}
+				}
+			}
+			// the mapped path is not used for folders with their own filesystem
+			virtualFolders = append(virtualFolders, vfs.VirtualFolder{
+				VirtualPath: cleanedVPath,
+				Filesystem:  &folderFs,
+			})
+			continue
+		}
 		cleanedMPath := filepath.Clean(v.MappedPath)
 		if !filepath.IsAbs(cleanedMPath) {
 			return &ValidationError{err: fmt.Sprintf("invalid mapped folder %#v", v.MappedPath)}
@@ -654,6 +1095,7 @@ func validateVirtualFolders(user *User) error {
 		virtualFolders = append(virtualFolders, vfs.VirtualFolder{
 			VirtualPath: cleanedVPath,
 			MappedPath:  cleanedMPath,
+			Name:        v.Name,
 		})
 		for k, virtual := range mappedPaths {
 			if isMappedDirOverlapped(k, cleanedMPath) {
@@ -665,12 +1107,53 @@ func validateVirtualFolders(user *User) error {
 					v.VirtualPath, virtual)}
 			}
 		}
+		for _, folder := range virtualFolders {
+			if folder.HasFilesystem() && isVirtualDirOverlapped(folder.VirtualPath, cleanedVPath) {
+				return &ValidationError{err: fmt.Sprintf("invalid virtual folder %#v overlaps with virtual folder %#v",
+					v.VirtualPath, folder.VirtualPath)}
+			}
+		}
 		mappedPaths[cleanedMPath] = cleanedVPath
 	}
 	user.VirtualFolders = virtualFolders
 	return nil
 }
 
+// validateVirtualFolderFilesystem validates the filesystem backend for a virtual folder
+// and returns a copy with the secrets encrypted
+func validateVirtualFolderFilesystem(config vfs.VirtualFolderFilesystem) (vfs.VirtualFolderFilesystem, error) {
+	switch config.Provider {
+	case 1:
+		if err := vfs.ValidateS3FsConfig(&config.S3Config); err != nil {
+			return config, fmt.Errorf("could not validate s3config: %v", err)
+		}
+		accessSecret, err := kms.EncryptIfNeeded(config.S3Config.AccessSecret)
+		if err != nil {
+			return config, fmt.Errorf("could not encrypt s3 access secret: %v", err)
+		}
+		config.S3Config.AccessSecret = accessSecret
+		sessionToken, err := kms.EncryptIfNeeded(config.S3Config.SessionToken)
+		if err != nil {
+			return config, fmt.Errorf("could not encrypt s3 session token: %v", err)
+		}
+		config.S3Config.SessionToken = sessionToken
+		config.GCSConfig = vfs.GCSFsConfig{}
+	case 2:
+		// there is no credentials file for the virtual folders
+		if config.GCSConfig.AutomaticCredentials == 0 {
+			return config, errors.New("only automatic credentials are supported for GCS virtual folders")
+		}
+		config.GCSConfig.Credentials = ""
+		if err := vfs.ValidateGCSFsConfig(&config.GCSConfig, ""); err != nil {
+			return config, fmt.Errorf("could not validate GCS config: %v", err)
+		}
+		config.S3Config = vfs.S3FsConfig{}
+	default:
+		return config, fmt.Errorf("unsupported filesystem provider %v", config.Provider)
+	}
+	return config, nil
+}
+
 func validatePermissions(user *User) error {
 	if len(user.Permissions) == 0 {
 		return &ValidationError{err: "please grant some permissions to this user"}
@@ -760,6 +1243,15 @@ func validateFilters(user *User) error {
 	if len(user.Filters.DeniedLoginMethods) == 0 {
 		user.Filters.DeniedLoginMethods = []string{}
 	}
+	if len(user.Filters.RevokedKeyFingerprints) == 0 {
+		user.Filters.RevokedKeyFingerprints = []string{}
+	}
+	if len(user.Filters.AllowedKeyAlgorithms) == 0 {
+		user.Filters.AllowedKeyAlgorithms = []string{}
+	}
+	if len(user.Filters.AllowedSSHCommands) == 0 {
+		user.Filters.AllowedSSHCommands = []string{}
+	}
 	for _, IPMask := range user.Filters.DeniedIP {
 		_, _, err := net.ParseCIDR(IPMask)
 		if err != nil {
@@ -780,12 +1272,73 @@ func validateFilters(user *User) error {
 			return &ValidationError{err: fmt.Sprintf("invalid login method: %#v", loginMethod)}
 		}
 	}
+	for idx, fp := range user.Filters.RevokedKeyFingerprints {
+		fp = strings.TrimSpace(fp)
+		if !isValidSHA256Fingerprint(fp) {
+			return &ValidationError{err: fmt.Sprintf("invalid revoked key fingerprint: %#v", fp)}
+		}
+		user.Filters.RevokedKeyFingerprints[idx] = fp
+	}
+	for _, algo := range user.Filters.AllowedKeyAlgorithms {
+		if !utils.IsStringInSlice(algo, ValidPublicKeyAlgorithms) {
+			return &ValidationError{err: fmt.Sprintf("invalid public key algorithm: %#v", algo)}
+		}
+	}
+	for idx, command := range user.Filters.AllowedSSHCommands {
+		command = strings.TrimSpace(command)
+		if command == "" || strings.ContainsAny(command, " \t/") {
+			return &ValidationError{err: fmt.Sprintf("invalid SSH command: %#v", user.Filters.AllowedSSHCommands[idx])}
+		}
+		user.Filters.AllowedSSHCommands[idx] = command
+	}
+	if user.Filters.MinRSAKeySize < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid min_rsa_key_size: %v", user.Filters.MinRSAKeySize)}
+	}
+	user.Filters.Tenant = strings.TrimSpace(user.Filters.Tenant)
+	if len(user.Filters.Tenant) > 255 {
+		return &ValidationError{err: "the tenant cannot be longer than 255 characters"}
+	}
+	if user.Filters.FirstLogin.ChangePassword && len(user.Password) == 0 {
+		return &ValidationError{err: "a password is required to force a password change on the first login"}
+	}
+	if user.Filters.FirstLogin.TermsAcceptedAt < 0 || user.Filters.FirstLogin.PasswordChangedAt < 0 {
+		return &ValidationError{err: "invalid first login actions completion timestamps"}
+	}
 	if err := validateFiltersFileExtensions(user); err != nil {
 		return err
 	}
+	return validateFiltersIngestionFolders(user)
+}
+
+func validateFiltersIngestionFolders(user *User) error {
+	var folders []IngestionFolder
+	var folderPaths []string
+	for _, f := range user.Filters.IngestionFolders {
+		cleanedPath := filepath.ToSlash(path.Clean(f.Path))
+		if !path.IsAbs(cleanedPath) {
+			return &ValidationError{err: fmt.Sprintf("invalid path %#v for ingestion folder", f.Path)}
+		}
+		if utils.IsStringInSlice(cleanedPath, folderPaths) {
+			return &ValidationError{err: fmt.Sprintf("duplicate ingestion folder %#v", f.Path)}
+		}
+		f.Path = cleanedPath
+		folders = append(folders, f)
+		folderPaths = append(folderPaths, cleanedPath)
+	}
+	user.Filters.IngestionFolders = folders
 	return nil
 }
 
+// isValidSHA256Fingerprint returns true if fp is a SHA256 fingerprint in the format used by OpenSSH,
+// for example "SHA256:jZ3j1ZFl7Xmc/rFzvoFQfqDCzYVatZ0WjvSaN7ZT4xo"
+func isValidSHA256Fingerprint(fp string) bool {
+	if !strings.HasPrefix(fp, "SHA256:") {
+		return false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fp, "SHA256:"))
+	return err == nil && len(hash) == sha256.Size
+}
+
 func saveGCSCredentials(user *User) error {
 	if user.FsConfig.Provider != 2 {
 		return nil
@@ -793,10 +1346,28 @@ func saveGCSCredentials(user *User) error {
 	if len(user.FsConfig.GCSConfig.Credentials) == 0 {
 		return nil
 	}
-	decoded, err := base64.StdEncoding.DecodeString(user.FsConfig.GCSConfig.Credentials)
+	credentials := user.FsConfig.GCSConfig.Credentials
+	// the users created from a template receive the encrypted template credentials
+	if kms.IsEncrypted(credentials) {
+		var err error
+		credentials, err = kms.Decrypt(credentials)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not decrypt GCS credentials: %v", err)}
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
 	if err != nil {
 		return &ValidationError{err: fmt.Sprintf("could not validate GCS credentials: %v", err)}
 	}
+	// the credentials file is encrypted only if a master key is configured, vfs.ReadGCSCredentials
+	// accepts both the formats
+	if kms.IsMasterKeyEnabled() {
+		encrypted, err := kms.Encrypt(string(decoded))
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt GCS credentials: %v", err)}
+		}
+		decoded = []byte(encrypted)
+	}
 	err = ioutil.WriteFile(user.getGCSCredentialsFilePath(), decoded, 0600)
 	if err != nil {
 		return &ValidationError{err: fmt.Sprintf("could not save GCS credentials: %v", err)}
@@ -811,16 +1382,16 @@ func validateFilesystemConfig(user *User) error {
 		if err != nil {
 			return &ValidationError{err: fmt.Sprintf("could not validate s3config: %v", err)}
 		}
-		if len(user.FsConfig.S3Config.AccessSecret) > 0 {
-			vals := strings.Split(user.FsConfig.S3Config.AccessSecret, "$")
-			if !strings.HasPrefix(user.FsConfig.S3Config.AccessSecret, "$aes$") || len(vals) != 4 {
-				accessSecret, err := utils.EncryptData(user.FsConfig.S3Config.AccessSecret)
-				if err != nil {
-					return &ValidationError{err: fmt.Sprintf("could not encrypt s3 access secret: %v", err)}
-				}
-				user.FsConfig.S3Config.AccessSecret = accessSecret
-			}
+		accessSecret, err := kms.EncryptIfNeeded(user.FsConfig.S3Config.AccessSecret)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt s3 access secret: %v", err)}
+		}
+		user.FsConfig.S3Config.AccessSecret = accessSecret
+		sessionToken, err := kms.EncryptIfNeeded(user.FsConfig.S3Config.SessionToken)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt s3 session token: %v", err)}
 		}
+		user.FsConfig.S3Config.SessionToken = sessionToken
 		return nil
 	} else if user.FsConfig.Provider == 2 {
 		err := vfs.ValidateGCSFsConfig(&user.FsConfig.GCSConfig, user.getGCSCredentialsFilePath())
@@ -828,10 +1399,103 @@ func validateFilesystemConfig(user *User) error {
 			return &ValidationError{err: fmt.Sprintf("could not validate GCS config: %v", err)}
 		}
 		return nil
+	} else if user.FsConfig.Provider == 3 {
+		err := vfs.ValidateCryptFsConfig(&user.FsConfig.CryptConfig)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not validate crypt config: %v", err)}
+		}
+		passphrase, err := kms.EncryptIfNeeded(user.FsConfig.CryptConfig.Passphrase)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt passphrase: %v", err)}
+		}
+		user.FsConfig.CryptConfig.Passphrase = passphrase
+		return nil
+	} else if user.FsConfig.Provider == 4 {
+		err := vfs.ValidateWebDAVFsConfig(&user.FsConfig.WebDAVConfig)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not validate WebDAV config: %v", err)}
+		}
+		password, err := kms.EncryptIfNeeded(user.FsConfig.WebDAVConfig.Password)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt WebDAV password: %v", err)}
+		}
+		user.FsConfig.WebDAVConfig.Password = password
+		bearerToken, err := kms.EncryptIfNeeded(user.FsConfig.WebDAVConfig.BearerToken)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt WebDAV bearer token: %v", err)}
+		}
+		user.FsConfig.WebDAVConfig.BearerToken = bearerToken
+		return nil
+	} else if user.FsConfig.Provider == 5 {
+		err := vfs.ValidateHDFSFsConfig(&user.FsConfig.HDFSConfig)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not validate HDFS config: %v", err)}
+		}
+		delegationToken, err := kms.EncryptIfNeeded(user.FsConfig.HDFSConfig.DelegationToken)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt HDFS delegation token: %v", err)}
+		}
+		user.FsConfig.HDFSConfig.DelegationToken = delegationToken
+		return nil
+	} else if user.FsConfig.Provider == 6 {
+		return validateGoogleDriveFsConfig(user)
+	} else if user.FsConfig.Provider == 7 {
+		return validateDropboxFsConfig(user)
 	}
 	user.FsConfig.Provider = 0
 	user.FsConfig.S3Config = vfs.S3FsConfig{}
 	user.FsConfig.GCSConfig = vfs.GCSFsConfig{}
+	user.FsConfig.CryptConfig = vfs.CryptFsConfig{}
+	user.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
+	user.FsConfig.HDFSConfig = vfs.HDFSFsConfig{}
+	user.FsConfig.GoogleDriveConfig = vfs.GoogleDriveFsConfig{}
+	user.FsConfig.DropboxConfig = vfs.DropboxFsConfig{}
+	return nil
+}
+
+func validateGoogleDriveFsConfig(user *User) error {
+	err := vfs.ValidateGoogleDriveFsConfig(&user.FsConfig.GoogleDriveConfig)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not validate Google Drive config: %v", err)}
+	}
+	credentials, err := kms.EncryptIfNeeded(user.FsConfig.GoogleDriveConfig.Credentials)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not encrypt Google Drive credentials: %v", err)}
+	}
+	user.FsConfig.GoogleDriveConfig.Credentials = credentials
+	clientSecret, err := kms.EncryptIfNeeded(user.FsConfig.GoogleDriveConfig.ClientSecret)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not encrypt Google Drive client secret: %v", err)}
+	}
+	user.FsConfig.GoogleDriveConfig.ClientSecret = clientSecret
+	refreshToken, err := kms.EncryptIfNeeded(user.FsConfig.GoogleDriveConfig.RefreshToken)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not encrypt Google Drive refresh token: %v", err)}
+	}
+	user.FsConfig.GoogleDriveConfig.RefreshToken = refreshToken
+	return nil
+}
+
+func validateDropboxFsConfig(user *User) error {
+	err := vfs.ValidateDropboxFsConfig(&user.FsConfig.DropboxConfig)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not validate Dropbox config: %v", err)}
+	}
+	accessToken, err := kms.EncryptIfNeeded(user.FsConfig.DropboxConfig.AccessToken)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not encrypt Dropbox access token: %v", err)}
+	}
+	user.FsConfig.DropboxConfig.AccessToken = accessToken
+	refreshToken, err := kms.EncryptIfNeeded(user.FsConfig.DropboxConfig.RefreshToken)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not encrypt Dropbox refresh token: %v", err)}
+	}
+	user.FsConfig.DropboxConfig.RefreshToken = refreshToken
+	appSecret, err := kms.EncryptIfNeeded(user.FsConfig.DropboxConfig.AppSecret)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not encrypt Dropbox app secret: %v", err)}
+	}
+	user.FsConfig.DropboxConfig.AppSecret = appSecret
 	return nil
 }
 
@@ -850,7 +1514,7 @@ func validateBaseParams(user *User) error {
 
 func createUserPasswordHash(user *User) error {
 	if len(user.Password) > 0 && !utils.IsStringPrefixInSlice(user.Password, hashPwdPrefixes) {
-		pwd, err := argon2id.CreateHash(user.Password, argon2id.DefaultParams)
+		pwd, err := hashPassword(user.Password)
 		if err != nil {
 			return err
 		}
@@ -859,6 +1523,57 @@ func createUserPasswordHash(user *User) error {
 	return nil
 }
 
+func hashPassword(password string) (string, error) {
+	if config.PasswordHashing.Algo == HashingAlgoBcrypt {
+		pwd, err := bcrypt.GenerateFromPassword([]byte(password), config.PasswordHashing.BcryptCost)
+		return string(pwd), err
+	}
+	return argon2id.CreateHash(password, argon2id.DefaultParams)
+}
+
+func isPasswordHashUpgradeNeeded(hashedPassword string) bool {
+	if !config.PasswordHashing.UpgradeLegacyHashes {
+		return false
+	}
+	if utils.IsStringPrefixInSlice(hashedPassword, unixPwdPrefixes) ||
+		utils.IsStringPrefixInSlice(hashedPassword, pbkdfPwdPrefixes) {
+		return true
+	}
+	if config.PasswordHashing.Algo == HashingAlgoBcrypt && strings.HasPrefix(hashedPassword, bcryptPwdPrefix) {
+		cost, err := bcrypt.Cost([]byte(hashedPassword))
+		if err != nil {
+			return false
+		}
+		requiredCost := config.PasswordHashing.BcryptCost
+		if requiredCost < bcrypt.MinCost {
+			requiredCost = bcrypt.DefaultCost
+		}
+		return cost < requiredCost
+	}
+	return false
+}
+
+// upgradePasswordHash replaces a legacy password hash with a new one using the configured
+// hashing algorithm. It must be called only after a successful password authentication.
+// Errors are logged and ignored: the user is already authenticated
+func upgradePasswordHash(p Provider, user *User, password string) {
+	if !isPasswordHashUpgradeNeeded(user.Password) {
+		return
+	}
+	pwd, err := hashPassword(password)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to upgrade password hash for user %#v: %v", user.Username, err)
+		return
+	}
+	err = p.updateUserPassword(user.Username, pwd)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to save the upgraded password hash for user %#v: %v", user.Username, err)
+		return
+	}
+	providerLog(logger.LevelInfo, "password hash upgraded for user %#v", user.Username)
+	user.Password = pwd
+}
+
 func validateUser(user *User) error {
 	buildUserHomeDir(user)
 	if err := validateBaseParams(user); err != nil {
@@ -891,6 +1606,29 @@ func validateUser(user *User) error {
 	return nil
 }
 
+// setUserCreationFields sets the timestamps and the version for a new user.
+// The creation time is preserved if set, for example restoring a backup
+func setUserCreationFields(user *User) {
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	if user.CreatedAt <= 0 {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	user.Version = 1
+}
+
+// setUserUpdateFields checks the version of the user to update against the stored one
+// and sets the timestamps and the version for the update
+func setUserUpdateFields(user *User, existing User) error {
+	if user.Version > 0 && user.Version != existing.Version {
+		return ErrUserVersionMismatch
+	}
+	user.CreatedAt = existing.CreatedAt
+	user.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	user.Version = existing.Version + 1
+	return nil
+}
+
 func checkLoginConditions(user User) error {
 	if user.Status < 1 {
 		return fmt.Errorf("user %#v is disabled", user.Username)
@@ -956,12 +1694,45 @@ func checkUserAndPubKey(user User, pubKey []byte) (User, string, error) {
 		}
 		if bytes.Equal(storedPubKey.Marshal(), pubKey) {
 			fp := ssh.FingerprintSHA256(storedPubKey)
+			if utils.IsStringInSlice(fp, user.Filters.RevokedKeyFingerprints) {
+				providerLog(logger.LevelWarn, "public key %v for user %#v is revoked", fp, user.Username)
+				return user, "", errors.New("Invalid credentials")
+			}
+			if err := checkPublicKeyRestrictions(user, storedPubKey); err != nil {
+				providerLog(logger.LevelWarn, "public key %v refused for user %#v: %v", fp, user.Username, err)
+				return user, "", errors.New("Invalid credentials")
+			}
 			return user, fp + ":" + comment, nil
 		}
 	}
 	return user, "", errors.New("Invalid credentials")
 }
 
+// checkPublicKeyRestrictions returns an error if the algorithm or the size of the given
+// public key are not allowed for the specified user
+func checkPublicKeyRestrictions(user User, pubKey ssh.PublicKey) error {
+	algo := pubKey.Type()
+	if len(user.Filters.AllowedKeyAlgorithms) > 0 && !utils.IsStringInSlice(algo, user.Filters.AllowedKeyAlgorithms) {
+		return fmt.Errorf("key algorithm %#v is not allowed, allowed algorithms: %v", algo,
+			user.Filters.AllowedKeyAlgorithms)
+	}
+	if user.Filters.MinRSAKeySize > 0 && algo == ssh.KeyAlgoRSA {
+		cryptoPubKey, ok := pubKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return errors.New("unable to get the RSA key size")
+		}
+		rsaPubKey, ok := cryptoPubKey.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok {
+			return errors.New("unable to get the RSA key size")
+		}
+		if rsaPubKey.N.BitLen() < user.Filters.MinRSAKeySize {
+			return fmt.Errorf("RSA key size %v is lower than the minimum allowed: %v", rsaPubKey.N.BitLen(),
+				user.Filters.MinRSAKeySize)
+		}
+	}
+	return nil
+}
+
 func compareUnixPasswordAndHash(user User, password string) (bool, error) {
 	match := false
 	var err error
@@ -1029,13 +1800,48 @@ func comparePbkdf2PasswordAndHash(password, hashedPassword string) (bool, error)
 	return subtle.ConstantTimeCompare(df, expected) == 1, nil
 }
 
+// hideVirtualFoldersSensitiveData hides the secrets for the virtual folders filesystems.
+// The folders are copied since they could be shared with the cached users
+func hideVirtualFoldersSensitiveData(user *User) {
+	virtualFolders := make([]vfs.VirtualFolder, 0, len(user.VirtualFolders))
+	for _, v := range user.VirtualFolders {
+		if v.Filesystem != nil {
+			folderFs := *v.Filesystem
+			folderFs.S3Config.AccessSecret = utils.RemoveDecryptionKey(folderFs.S3Config.AccessSecret)
+			folderFs.S3Config.SessionToken = utils.RemoveDecryptionKey(folderFs.S3Config.SessionToken)
+			v.Filesystem = &folderFs
+		}
+		virtualFolders = append(virtualFolders, v)
+	}
+	user.VirtualFolders = virtualFolders
+}
+
 // HideUserSensitiveData hides user sensitive data
 func HideUserSensitiveData(user *User) User {
 	user.Password = ""
+	hideVirtualFoldersSensitiveData(user)
 	if user.FsConfig.Provider == 1 {
 		user.FsConfig.S3Config.AccessSecret = utils.RemoveDecryptionKey(user.FsConfig.S3Config.AccessSecret)
+		user.FsConfig.S3Config.SessionToken = utils.RemoveDecryptionKey(user.FsConfig.S3Config.SessionToken)
 	} else if user.FsConfig.Provider == 2 {
 		user.FsConfig.GCSConfig.Credentials = ""
+	} else if user.FsConfig.Provider == 3 {
+		user.FsConfig.CryptConfig.Passphrase = utils.RemoveDecryptionKey(user.FsConfig.CryptConfig.Passphrase)
+	} else if user.FsConfig.Provider == 4 {
+		user.FsConfig.WebDAVConfig.Password = utils.RemoveDecryptionKey(user.FsConfig.WebDAVConfig.Password)
+		user.FsConfig.WebDAVConfig.BearerToken = utils.RemoveDecryptionKey(user.FsConfig.WebDAVConfig.BearerToken)
+	} else if user.FsConfig.Provider == 5 {
+		user.FsConfig.HDFSConfig.DelegationToken = utils.RemoveDecryptionKey(user.FsConfig.HDFSConfig.DelegationToken)
+	} else if user.FsConfig.Provider == 6 {
+		config := &user.FsConfig.GoogleDriveConfig
+		config.Credentials = utils.RemoveDecryptionKey(config.Credentials)
+		config.ClientSecret = utils.RemoveDecryptionKey(config.ClientSecret)
+		config.RefreshToken = utils.RemoveDecryptionKey(config.RefreshToken)
+	} else if user.FsConfig.Provider == 7 {
+		config := &user.FsConfig.DropboxConfig
+		config.AccessToken = utils.RemoveDecryptionKey(config.AccessToken)
+		config.RefreshToken = utils.RemoveDecryptionKey(config.RefreshToken)
+		config.AppSecret = utils.RemoveDecryptionKey(config.AppSecret)
 	}
 	return *user
 }
@@ -1047,7 +1853,7 @@ func addCredentialsToUser(user *User) error {
 	if user.FsConfig.GCSConfig.AutomaticCredentials > 0 {
 		return nil
 	}
-	cred, err := ioutil.ReadFile(user.getGCSCredentialsFilePath())
+	cred, err := vfs.ReadGCSCredentials(user.getGCSCredentialsFilePath())
 	if err != nil {
 		return err
 	}
@@ -1080,6 +1886,44 @@ func getSSLMode() string {
 	return ""
 }
 
+func hasSQLTLSFiles() bool {
+	return len(config.RootCert) > 0 || len(config.ClientCert) > 0 || len(config.ClientKey) > 0
+}
+
+// validateSQLTLSFiles checks the TLS certificates configured for the mysql and postgresql
+// drivers and resolves the paths relative to the config dir
+func validateSQLTLSFiles(basePath string) error {
+	if !hasSQLTLSFiles() {
+		return nil
+	}
+	if len(config.ConnectionString) > 0 {
+		return errors.New("root_cert, client_cert and client_key cannot be used together with a custom connection " +
+			"string, add the TLS parameters to the connection string instead")
+	}
+	if config.SSLMode == 0 {
+		return errors.New("root_cert, client_cert and client_key require TLS, sslmode cannot be 0")
+	}
+	if (len(config.ClientCert) == 0) != (len(config.ClientKey) == 0) {
+		return errors.New("client_cert and client_key must be set together")
+	}
+	for _, p := range []*string{&config.RootCert, &config.ClientCert, &config.ClientKey} {
+		if len(*p) == 0 {
+			continue
+		}
+		if !filepath.IsAbs(*p) {
+			*p = filepath.Join(basePath, *p)
+		}
+		fi, err := os.Stat(*p)
+		if err != nil {
+			return fmt.Errorf("invalid TLS file %#v: %v", *p, err)
+		}
+		if fi.IsDir() {
+			return fmt.Errorf("invalid TLS file %#v: it is a directory", *p)
+		}
+	}
+	return nil
+}
+
 func startAvailabilityTimer() {
 	availabilityTickerDone = make(chan bool)
 	checkDataprovider()
@@ -1118,6 +1962,9 @@ func checkDataprovider() {
 	err := provider.checkAvailability()
 	if err != nil {
 		providerLog(logger.LevelWarn, "check availability error: %v", err)
+	} else {
+		// reload the IP lists so changes made by other instances sharing the same provider are applied
+		reloadIPLists(provider)
 	}
 	metrics.UpdateDataProviderAvailability(err)
 }
@@ -1403,6 +2250,10 @@ func executePreLoginHook(username, loginMethod string) (User, error) {
 	u.UsedQuotaFiles = userUsedQuotaFiles
 	u.LastQuotaUpdate = userLastQuotaUpdate
 	u.LastLogin = userLastLogin
+	u.Version = 0
+	if err = checkUserPassword(provider, &u); err != nil {
+		return u, err
+	}
 	if userID == 0 {
 		err = provider.addUser(u)
 	} else {
@@ -1485,12 +2336,19 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.PublicKeys = append(user.PublicKeys, pkey)
 	}
 	u, err := provider.userExists(username)
-	if err == nil {
+	exists := err == nil
+	if exists {
 		user.ID = u.ID
 		user.UsedQuotaSize = u.UsedQuotaSize
 		user.UsedQuotaFiles = u.UsedQuotaFiles
 		user.LastQuotaUpdate = u.LastQuotaUpdate
 		user.LastLogin = u.LastLogin
+		user.Version = 0
+	}
+	if err = checkUserPassword(provider, &user); err != nil {
+		return user, err
+	}
+	if exists {
 		err = provider.updateUser(user)
 	} else {
 		err = provider.addUser(user)
@@ -1518,9 +2376,31 @@ func executeNotificationCommand(operation string, user User) error {
 	return err
 }
 
+// UserActionHook is an in-process hook for the users actions, see RegisterUserActionHook
+type UserActionHook func(operation string, user User)
+
+// RegisterUserActionHook registers a hook executed, inside the SFTPGo process, when a user is
+// added, updated, deleted or offboarded. The hooks are executed even if the operation is not
+// included in execute_on. For the add and update operations the user is read back from the
+// data provider. A hook must not block
+func RegisterUserActionHook(hook UserActionHook) {
+	userActionHooksMutex.Lock()
+	defer userActionHooksMutex.Unlock()
+
+	userActionHooks = append(userActionHooks, hook)
+}
+
+func getUserActionHooks() []UserActionHook {
+	userActionHooksMutex.RLock()
+	defer userActionHooksMutex.RUnlock()
+
+	return userActionHooks
+}
+
 // executed in a goroutine
 func executeAction(operation string, user User) {
-	if !utils.IsStringInSlice(operation, config.Actions.ExecuteOn) {
+	hooks := getUserActionHooks()
+	if len(hooks) == 0 && !utils.IsStringInSlice(operation, config.Actions.ExecuteOn) {
 		return
 	}
 	if operation != operationDelete {
@@ -1531,6 +2411,12 @@ func executeAction(operation string, user User) {
 			return
 		}
 	}
+	for _, hook := range hooks {
+		hook(operation, user.getACopy())
+	}
+	if !utils.IsStringInSlice(operation, config.Actions.ExecuteOn) {
+		return
+	}
 	if len(config.Actions.Command) > 0 && filepath.IsAbs(config.Actions.Command) {
 		// we are in a goroutine but if we have to send an HTTP notification we don't want to wait for the
 		// end of the command
@@ -0,0 +1,189 @@
+package dataprovider
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported sources for the user defaults
+const (
+	UserDefaultsSourcePlan = "plan"
+)
+
+// user fields managed by a plan
+var planUserFields = []string{"max_sessions", "quota_size", "quota_files", "upload_bandwidth", "download_bandwidth",
+	"denied_login_methods"}
+
+// UserFieldDiff defines a user field that deviates from its default value
+type UserFieldDiff struct {
+	// field name as in the user JSON representation
+	Field   string      `json:"field"`
+	Value   interface{} `json:"value"`
+	Default interface{} `json:"default"`
+}
+
+// UserDefaultsDiff defines the user fields that deviate from the defaults
+type UserDefaultsDiff struct {
+	Username string `json:"username"`
+	// the defaults source, for example "plan"
+	Source string `json:"source"`
+	// name for the defaults source, for example the plan name
+	Name string `json:"name"`
+	// the deviating fields, empty if the user is consistent with its defaults
+	Fields []UserFieldDiff `json:"fields"`
+}
+
+// getUserFieldValue returns the value for the given plan managed field
+func (p *Plan) getUserFieldValue(field string) interface{} {
+	switch field {
+	case "max_sessions":
+		return p.MaxSessions
+	case "quota_size":
+		return p.QuotaSize
+	case "quota_files":
+		return p.QuotaFiles
+	case "upload_bandwidth":
+		return p.UploadBandwidth
+	case "download_bandwidth":
+		return p.DownloadBandwidth
+	case "denied_login_methods":
+		return getSortedLoginMethods(p.DeniedLoginMethods)
+	}
+	return nil
+}
+
+// resetUserField replaces the given user field with the plan value
+func (p *Plan) resetUserField(user *User, field string) {
+	switch field {
+	case "max_sessions":
+		user.MaxSessions = p.MaxSessions
+	case "quota_size":
+		user.QuotaSize = p.QuotaSize
+	case "quota_files":
+		user.QuotaFiles = p.QuotaFiles
+	case "upload_bandwidth":
+		user.UploadBandwidth = p.UploadBandwidth
+	case "download_bandwidth":
+		user.DownloadBandwidth = p.DownloadBandwidth
+	case "denied_login_methods":
+		user.Filters.DeniedLoginMethods = make([]string, len(p.DeniedLoginMethods))
+		copy(user.Filters.DeniedLoginMethods, p.DeniedLoginMethods)
+	}
+}
+
+// getDefaultsFieldValue returns the user value for the given field, the returned value can be
+// compared with the default one
+func (u *User) getDefaultsFieldValue(field string) interface{} {
+	switch field {
+	case "max_sessions":
+		return u.MaxSessions
+	case "quota_size":
+		return u.QuotaSize
+	case "quota_files":
+		return u.QuotaFiles
+	case "upload_bandwidth":
+		return u.UploadBandwidth
+	case "download_bandwidth":
+		return u.DownloadBandwidth
+	case "denied_login_methods":
+		return getSortedLoginMethods(u.Filters.DeniedLoginMethods)
+	}
+	return nil
+}
+
+// getUserDiff returns the user fields that deviate from the plan ones
+func (p *Plan) getUserDiff(user *User) []UserFieldDiff {
+	fields := []UserFieldDiff{}
+	for _, field := range planUserFields {
+		value := user.getDefaultsFieldValue(field)
+		defaultValue := p.getUserFieldValue(field)
+		if fmt.Sprint(value) != fmt.Sprint(defaultValue) {
+			fields = append(fields, UserFieldDiff{
+				Field:   field,
+				Value:   value,
+				Default: defaultValue,
+			})
+		}
+	}
+	return fields
+}
+
+// getSortedLoginMethods returns a sorted copy of the given login methods, so they can be compared
+// regardless of their order. A nil slice is returned as an empty one
+func getSortedLoginMethods(methods []string) []string {
+	sorted := make([]string, len(methods))
+	copy(sorted, methods)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// getUserDefaults returns the plan assigned to the given user, it is the source for the user defaults
+func getUserDefaults(p Provider, user *User) (Plan, error) {
+	if len(user.Plan) == 0 {
+		return Plan{}, &ValidationError{err: fmt.Sprintf("user %#v has no defaults, no plan is assigned", user.Username)}
+	}
+	plan, err := p.planExists(user.Plan)
+	if err != nil {
+		if _, ok := err.(*RecordNotFoundError); ok {
+			return plan, &ValidationError{err: fmt.Sprintf("plan %#v does not exist", user.Plan)}
+		}
+		return plan, err
+	}
+	return plan, nil
+}
+
+// GetUserDefaultsDiff returns the fields of the given user that deviate from its defaults.
+// The defaults are defined by the plan assigned to the user
+func GetUserDefaultsDiff(p Provider, user User) (UserDefaultsDiff, error) {
+	diff := UserDefaultsDiff{
+		Username: user.Username,
+		Source:   UserDefaultsSourcePlan,
+		Name:     user.Plan,
+	}
+	plan, err := getUserDefaults(p, &user)
+	if err != nil {
+		return diff, err
+	}
+	diff.Fields = plan.getUserDiff(&user)
+	return diff, nil
+}
+
+// ResetUserFieldsToDefaults replaces the given fields of the given user with the default values.
+// The other fields are not changed, even if they deviate from the defaults. If no field is given,
+// all the deviating fields are reset.
+// ManageUsers configuration must be set to 1 to enable this method
+func ResetUserFieldsToDefaults(p Provider, user User, fields []string) (UserDefaultsDiff, error) {
+	if config.ManageUsers == 0 {
+		return UserDefaultsDiff{}, &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	plan, err := getUserDefaults(p, &user)
+	if err != nil {
+		return UserDefaultsDiff{}, err
+	}
+	for _, field := range fields {
+		if !utils.IsStringInSlice(field, planUserFields) {
+			return UserDefaultsDiff{}, &ValidationError{err: fmt.Sprintf("field %#v cannot be reset to its default, "+
+				"supported fields: %v", field, planUserFields)}
+		}
+	}
+	if len(fields) == 0 {
+		for _, fieldDiff := range plan.getUserDiff(&user) {
+			fields = append(fields, fieldDiff.Field)
+		}
+	}
+	if len(fields) > 0 {
+		for _, field := range fields {
+			plan.resetUserField(&user, field)
+		}
+		if err = p.updateUser(user); err != nil {
+			return UserDefaultsDiff{}, err
+		}
+		providerLog(logger.LevelInfo, "fields %v reset to the defaults from plan %#v for user %#v", fields, plan.Name,
+			user.Username)
+		go executeAction(operationUpdate, user)
+	}
+	return GetUserDefaultsDiff(p, user)
+}
@@ -0,0 +1,1355 @@
+package dataprovider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	dynamoDBDatabaseVersion = 1
+	dynamoDBTimeout         = 30 * time.Second
+	dynamoDBLongTimeout     = 300 * time.Second
+	dynamoDBPartitionKey    = "pk"
+	dynamoDBSortKey         = "sk"
+)
+
+// single table design: every object is stored inside the same table and the partition key
+// identifies the object type. The users are stored inside a single partition sorted by username,
+// so they can be listed using a query instead of a table scan. The other partitions are secondary
+// indexes used to enforce the unique constraints and to get the objects by ID
+const (
+	dynamoDBUsersPartition       = "user"
+	dynamoDBUsersIDIdxPartition  = "user_id"
+	dynamoDBIPListsPartition     = "ip_list"
+	dynamoDBIPListsIdxPartition  = "ip_list_ip"
+	dynamoDBPlansPartition       = "plan"
+	dynamoDBPlansIDIdxPartition  = "plan_id"
+	dynamoDBTemplatesPartition   = "user_template"
+	dynamoDBTemplatesIDPartition = "user_template_id"
+	dynamoDBFoldersPartition     = "folder"
+	dynamoDBFoldersIDPartition   = "folder_id"
+	dynamoDBSequencesPartition   = "sequence"
+	dynamoDBSchemaPartition      = "schema_version"
+	dynamoDBUsersSequence        = "users"
+	dynamoDBIPListsSequence      = "ip_lists"
+	dynamoDBPlansSequence        = "plans"
+	dynamoDBTemplatesSequence    = "user_templates"
+	dynamoDBFoldersSequence      = "folders"
+	dynamoDBItemExistsCondition  = "attribute_exists(#pk)"
+	dynamoDBItemMissingCondition = "attribute_not_exists(#pk)"
+)
+
+var dynamoDBAttributeNameRegex = regexp.MustCompile(`#\w+`)
+
+// DynamoDBProvider auth provider for AWS DynamoDB
+type DynamoDBProvider struct {
+	dbHandle  *dynamodb.DynamoDB
+	tableName string
+}
+
+func init() {
+	utils.AddFeature("+dynamodb")
+}
+
+func initializeDynamoDBProvider() error {
+	logSender = fmt.Sprintf("dataprovider_%v", DynamoDBDataProviderName)
+	if len(config.Name) == 0 {
+		return errors.New("the table name is mandatory for the dynamodb data provider")
+	}
+	awsConfig := aws.NewConfig()
+	if len(config.Host) > 0 {
+		awsConfig.WithRegion(config.Host)
+	}
+	if len(config.Password) > 0 {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.Username, config.Password, "")
+	} else {
+		providerLog(logger.LevelDebug, "no access key provided, using the default AWS credential chain")
+	}
+	if len(config.ConnectionString) > 0 {
+		awsConfig.WithEndpoint(config.ConnectionString)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "error creating dynamodb session: %v", err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "dynamodb provider created, table: %#v, region: %#v, endpoint: %#v",
+		config.Name, config.Host, config.ConnectionString)
+	provider = DynamoDBProvider{dbHandle: dynamodb.New(sess), tableName: config.Name}
+	return nil
+}
+
+func (p DynamoDBProvider) checkAvailability() error {
+	_, err := p.getItem(dynamoDBSchemaPartition, dynamoDBSchemaPartition)
+	return err
+}
+
+func (p DynamoDBProvider) validateUserAndPass(username string, password string) (User, error) {
+	var user User
+	if len(password) == 0 {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(user, password)
+}
+
+func (p DynamoDBProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(user, pubKey)
+}
+
+func (p DynamoDBProvider) getUserByID(ID int64) (User, error) {
+	var user User
+	item, err := p.getItem(dynamoDBUsersIDIdxPartition, strconv.FormatInt(ID, 10))
+	if err != nil {
+		return user, err
+	}
+	if item == nil {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("user with ID %v does not exist", ID)}
+	}
+	username := dynamoDBString(item, "username")
+	user, err = p.userExists(username)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("username %#v and ID: %v does not exist", username, ID)}
+	}
+	return user, err
+}
+
+// updateUserFields runs the given update expression, a RecordNotFoundError is returned if the user does not exist
+func (p DynamoDBProvider) updateUserFields(username, operation, expression string,
+	values map[string]*dynamodb.AttributeValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBTimeout)
+	defer cancel()
+
+	_, err := p.dbHandle.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(p.tableName),
+		Key:                       getDynamoDBKey(dynamoDBUsersPartition, username),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(dynamoDBItemExistsCondition),
+		ExpressionAttributeNames:  getDynamoDBAttributeNames(expression, dynamoDBItemExistsCondition),
+		ExpressionAttributeValues: values,
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to %v", username, operation)}
+	}
+	return err
+}
+
+func (p DynamoDBProvider) updateLastLogin(username string) error {
+	return p.updateUserFields(username, "update last login", "SET #last_login = :last_login",
+		map[string]*dynamodb.AttributeValue{
+			":last_login": getDynamoDBNumber(utils.GetTimeAsMsSinceEpoch(time.Now())),
+		})
+}
+
+func (p DynamoDBProvider) updateUserPassword(username, password string) error {
+	return p.updateUserFields(username, "update password", "SET #password = :password",
+		map[string]*dynamodb.AttributeValue{
+			":password": getDynamoDBString(password),
+		})
+}
+
+func (p DynamoDBProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	expression := "ADD #used_quota_size :size, #used_quota_files :files SET #last_quota_update = :now"
+	if reset {
+		expression = "SET #used_quota_size = :size, #used_quota_files = :files, #last_quota_update = :now"
+	}
+	return p.updateUserFields(username, "update quota", expression, map[string]*dynamodb.AttributeValue{
+		":size":  getDynamoDBNumber(sizeAdd),
+		":files": getDynamoDBNumber(int64(filesAdd)),
+		":now":   getDynamoDBNumber(utils.GetTimeAsMsSinceEpoch(time.Now())),
+	})
+}
+
+func (p DynamoDBProvider) getUsedQuota(username string) (int, int64, error) {
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, err
+}
+
+func (p DynamoDBProvider) userExists(username string) (User, error) {
+	var user User
+	item, err := p.getItem(dynamoDBUsersPartition, username)
+	if err != nil {
+		return user, err
+	}
+	if item == nil {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", username)}
+	}
+	return getUserFromDynamoDBItem(item)
+}
+
+func (p DynamoDBProvider) addUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	user.ID, err = p.nextSequence(dynamoDBUsersSequence)
+	if err != nil {
+		return err
+	}
+	setUserCreationFields(&user)
+	item, err := getDynamoDBUserItem(user)
+	if err != nil {
+		return err
+	}
+	idxItem := getDynamoDBKey(dynamoDBUsersIDIdxPartition, strconv.FormatInt(user.ID, 10))
+	idxItem["username"] = getDynamoDBString(user.Username)
+	err = p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     idxItem,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+	})
+	// the ID is taken from a sequence so the transaction can only fail for a duplicated username
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeTransactionCanceledException) {
+		return fmt.Errorf("username %v already exists", user.Username)
+	}
+	return err
+}
+
+// updateUser updates the user settings, the used quota and the last login are not modified
+func (p DynamoDBProvider) updateUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	existing, err := p.userExists(user.Username)
+	if err != nil {
+		return err
+	}
+	if err = setUserUpdateFields(&user, existing); err != nil {
+		return err
+	}
+	data, err := getDynamoDBUserData(user)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBTimeout)
+	defer cancel()
+
+	// the version attribute is missing for the users added before it was introduced
+	expression := "SET #data = :data, #password = :password, #plan = :plan, #version = :version"
+	condition := dynamoDBItemExistsCondition + " AND (attribute_not_exists(#version) OR #version = :existing_version)"
+	_, err = p.dbHandle.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(p.tableName),
+		Key:                      getDynamoDBKey(dynamoDBUsersPartition, user.Username),
+		UpdateExpression:         aws.String(expression),
+		ConditionExpression:      aws.String(condition),
+		ExpressionAttributeNames: getDynamoDBAttributeNames(expression, condition),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":data":             getDynamoDBString(data),
+			":password":         getDynamoDBString(user.Password),
+			":plan":             getDynamoDBString(user.Plan),
+			":version":          getDynamoDBNumber(user.Version),
+			":existing_version": getDynamoDBNumber(existing.Version),
+		},
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		// deleted or modified after reading it
+		if _, err = p.userExists(user.Username); err != nil {
+			return err
+		}
+		return ErrUserVersionMismatch
+	}
+	return err
+}
+
+func (p DynamoDBProvider) deleteUser(user User) error {
+	existing, err := p.userExists(user.Username)
+	if err != nil {
+		return err
+	}
+	return p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName:                aws.String(p.tableName),
+				Key:                      getDynamoDBKey(dynamoDBUsersPartition, existing.Username),
+				ConditionExpression:      aws.String(dynamoDBItemExistsCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemExistsCondition),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(p.tableName),
+				Key:       getDynamoDBKey(dynamoDBUsersIDIdxPartition, strconv.FormatInt(existing.ID, 10)),
+			},
+		},
+	})
+}
+
+func (p DynamoDBProvider) getIPListEntries() ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	err := p.queryPartition(dynamoDBIPListsPartition, true, func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		var entry IPListEntry
+		err := json.Unmarshal([]byte(dynamoDBString(item, "data")), &entry)
+		if err != nil {
+			return false, err
+		}
+		entries = append(entries, entry)
+		return true, nil
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, err
+}
+
+func (p DynamoDBProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	var entry IPListEntry
+	item, err := p.getItem(dynamoDBIPListsPartition, strconv.FormatInt(ID, 10))
+	if err != nil {
+		return entry, err
+	}
+	if item == nil {
+		return entry, &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", ID)}
+	}
+	err = json.Unmarshal([]byte(dynamoDBString(item, "data")), &entry)
+	return entry, err
+}
+
+func (p DynamoDBProvider) addIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	// the ID is assigned by the provider
+	entry.ID, err = p.nextSequence(dynamoDBIPListsSequence)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	item := getDynamoDBKey(dynamoDBIPListsPartition, strconv.FormatInt(entry.ID, 10))
+	item["data"] = getDynamoDBString(string(buf))
+	idxItem := getDynamoDBKey(dynamoDBIPListsIdxPartition, entry.IPOrNet)
+	idxItem["id"] = getDynamoDBNumber(entry.ID)
+	err = p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     idxItem,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeTransactionCanceledException) {
+		return fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+	}
+	return err
+}
+
+func (p DynamoDBProvider) updateIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	existing, err := p.getIPListEntryByID(entry.ID)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	item := getDynamoDBKey(dynamoDBIPListsPartition, strconv.FormatInt(entry.ID, 10))
+	item["data"] = getDynamoDBString(string(buf))
+	idxItem := getDynamoDBKey(dynamoDBIPListsIdxPartition, entry.IPOrNet)
+	idxItem["id"] = getDynamoDBNumber(entry.ID)
+	idValues := map[string]*dynamodb.AttributeValue{
+		":id": getDynamoDBNumber(entry.ID),
+	}
+	transactItems := []*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String(dynamoDBItemExistsCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemExistsCondition),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:                 aws.String(p.tableName),
+				Item:                      idxItem,
+				ConditionExpression:       aws.String("attribute_not_exists(#pk) OR #id = :id"),
+				ExpressionAttributeNames:  getDynamoDBAttributeNames("attribute_not_exists(#pk) OR #id = :id"),
+				ExpressionAttributeValues: idValues,
+			},
+		},
+	}
+	if existing.IPOrNet != entry.IPOrNet {
+		transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName:                 aws.String(p.tableName),
+				Key:                       getDynamoDBKey(dynamoDBIPListsIdxPartition, existing.IPOrNet),
+				ConditionExpression:       aws.String("#id = :id"),
+				ExpressionAttributeNames:  getDynamoDBAttributeNames("#id = :id"),
+				ExpressionAttributeValues: idValues,
+			},
+		})
+	}
+	err = p.transactWrite(transactItems)
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeTransactionCanceledException) {
+		// the entry was deleted or the IP/network was added or modified concurrently
+		if _, errGet := p.getIPListEntryByID(entry.ID); errGet != nil {
+			return errGet
+		}
+		return fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+	}
+	return err
+}
+
+func (p DynamoDBProvider) deleteIPListEntry(entry IPListEntry) error {
+	existing, err := p.getIPListEntryByID(entry.ID)
+	if err != nil {
+		return err
+	}
+	return p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName:                aws.String(p.tableName),
+				Key:                      getDynamoDBKey(dynamoDBIPListsPartition, strconv.FormatInt(existing.ID, 10)),
+				ConditionExpression:      aws.String(dynamoDBItemExistsCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemExistsCondition),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(p.tableName),
+				Key:       getDynamoDBKey(dynamoDBIPListsIdxPartition, existing.IPOrNet),
+			},
+		},
+	})
+}
+
+func (p DynamoDBProvider) getPlans() ([]Plan, error) {
+	plans := []Plan{}
+	// the plans partition is sorted by name
+	err := p.queryPartition(dynamoDBPlansPartition, true, func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		var plan Plan
+		err := json.Unmarshal([]byte(dynamoDBString(item, "data")), &plan)
+		if err != nil {
+			return false, err
+		}
+		plans = append(plans, plan)
+		return true, nil
+	})
+	return plans, err
+}
+
+func (p DynamoDBProvider) getPlanByID(ID int64) (Plan, error) {
+	var plan Plan
+	item, err := p.getItem(dynamoDBPlansIDIdxPartition, strconv.FormatInt(ID, 10))
+	if err != nil {
+		return plan, err
+	}
+	if item == nil {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+	}
+	plan, err = p.planExists(dynamoDBString(item, "name"))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+	}
+	return plan, err
+}
+
+func (p DynamoDBProvider) planExists(name string) (Plan, error) {
+	var plan Plan
+	item, err := p.getItem(dynamoDBPlansPartition, name)
+	if err != nil {
+		return plan, err
+	}
+	if item == nil {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan %#v does not exist", name)}
+	}
+	err = json.Unmarshal([]byte(dynamoDBString(item, "data")), &plan)
+	return plan, err
+}
+
+func (p DynamoDBProvider) addPlan(plan Plan) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	plan.ID, err = p.nextSequence(dynamoDBPlansSequence)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	item := getDynamoDBKey(dynamoDBPlansPartition, plan.Name)
+	item["data"] = getDynamoDBString(string(buf))
+	idxItem := getDynamoDBKey(dynamoDBPlansIDIdxPartition, strconv.FormatInt(plan.ID, 10))
+	idxItem["name"] = getDynamoDBString(plan.Name)
+	err = p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     idxItem,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeTransactionCanceledException) {
+		return fmt.Errorf("plan %v already exists", plan.Name)
+	}
+	return err
+}
+
+// updatePlan updates the plan and then, if applyToUsers is true, the assigned users. DynamoDB
+// transactions are limited to a few items, so each user is updated separately: if an update
+// fails the plan update can be safely retried
+func (p DynamoDBProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	existing, err := p.getPlanByID(plan.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Name != plan.Name {
+		return &ValidationError{err: "the plan name cannot be changed"}
+	}
+	buf, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	err = p.updateItem(getDynamoDBKey(dynamoDBPlansPartition, plan.Name), "SET #data = :data",
+		map[string]*dynamodb.AttributeValue{
+			":data": getDynamoDBString(string(buf)),
+		})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", plan.ID)}
+	}
+	if err != nil || !applyToUsers {
+		return err
+	}
+	users, err := p.getPlanUsers(plan.Name)
+	if err != nil {
+		return err
+	}
+	users, err = applyPlanToUsers(plan, users)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		data, err := getDynamoDBUserData(user)
+		if err != nil {
+			return err
+		}
+		err = p.updateUserFields(user.Username, "apply plan", "SET #data = :data", map[string]*dynamodb.AttributeValue{
+			":data": getDynamoDBString(data),
+		})
+		if _, ok := err.(*RecordNotFoundError); ok {
+			// deleted after listing
+			continue
+		}
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to apply plan %#v to user %#v: %v", plan.Name, user.Username, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (p DynamoDBProvider) deletePlan(plan Plan) error {
+	existing, err := p.getPlanByID(plan.ID)
+	if err != nil {
+		return err
+	}
+	users, err := p.getPlanUsers(existing.Name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return &ValidationError{err: fmt.Sprintf("plan %#v is assigned to %v users and it cannot be deleted",
+			existing.Name, len(users))}
+	}
+	return p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName:                aws.String(p.tableName),
+				Key:                      getDynamoDBKey(dynamoDBPlansPartition, existing.Name),
+				ConditionExpression:      aws.String(dynamoDBItemExistsCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemExistsCondition),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(p.tableName),
+				Key:       getDynamoDBKey(dynamoDBPlansIDIdxPartition, strconv.FormatInt(existing.ID, 10)),
+			},
+		},
+	})
+}
+
+func (p DynamoDBProvider) getUserTemplates() ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	// the user templates partition is sorted by name
+	err := p.queryPartition(dynamoDBTemplatesPartition, true, func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		var template UserTemplate
+		err := json.Unmarshal([]byte(dynamoDBString(item, "data")), &template)
+		if err != nil {
+			return false, err
+		}
+		templates = append(templates, template)
+		return true, nil
+	})
+	return templates, err
+}
+
+func (p DynamoDBProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	var template UserTemplate
+	item, err := p.getItem(dynamoDBTemplatesIDPartition, strconv.FormatInt(ID, 10))
+	if err != nil {
+		return template, err
+	}
+	if item == nil {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+	}
+	template, err = p.userTemplateExists(dynamoDBString(item, "name"))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+	}
+	return template, err
+}
+
+func (p DynamoDBProvider) userTemplateExists(name string) (UserTemplate, error) {
+	var template UserTemplate
+	item, err := p.getItem(dynamoDBTemplatesPartition, name)
+	if err != nil {
+		return template, err
+	}
+	if item == nil {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template %#v does not exist", name)}
+	}
+	err = json.Unmarshal([]byte(dynamoDBString(item, "data")), &template)
+	return template, err
+}
+
+func (p DynamoDBProvider) addUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	template.ID, err = p.nextSequence(dynamoDBTemplatesSequence)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	item := getDynamoDBKey(dynamoDBTemplatesPartition, template.Name)
+	item["data"] = getDynamoDBString(string(buf))
+	idxItem := getDynamoDBKey(dynamoDBTemplatesIDPartition, strconv.FormatInt(template.ID, 10))
+	idxItem["name"] = getDynamoDBString(template.Name)
+	err = p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     idxItem,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeTransactionCanceledException) {
+		return fmt.Errorf("user template %v already exists", template.Name)
+	}
+	return err
+}
+
+func (p DynamoDBProvider) updateUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	existing, err := p.getUserTemplateByID(template.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Name != template.Name {
+		return &ValidationError{err: "the user template name cannot be changed"}
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	err = p.updateItem(getDynamoDBKey(dynamoDBTemplatesPartition, template.Name), "SET #data = :data",
+		map[string]*dynamodb.AttributeValue{
+			":data": getDynamoDBString(string(buf)),
+		})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", template.ID)}
+	}
+	return err
+}
+
+func (p DynamoDBProvider) deleteUserTemplate(template UserTemplate) error {
+	existing, err := p.getUserTemplateByID(template.ID)
+	if err != nil {
+		return err
+	}
+	return p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName:                aws.String(p.tableName),
+				Key:                      getDynamoDBKey(dynamoDBTemplatesPartition, existing.Name),
+				ConditionExpression:      aws.String(dynamoDBItemExistsCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemExistsCondition),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(p.tableName),
+				Key:       getDynamoDBKey(dynamoDBTemplatesIDPartition, strconv.FormatInt(existing.ID, 10)),
+			},
+		},
+	})
+}
+
+func (p DynamoDBProvider) getFolders() ([]Folder, error) {
+	folders := []Folder{}
+	// the folders partition is sorted by name
+	err := p.queryPartition(dynamoDBFoldersPartition, true, func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		folder, err := getFolderFromDynamoDBItem(item)
+		if err != nil {
+			return false, err
+		}
+		folders = append(folders, folder)
+		return true, nil
+	})
+	return folders, err
+}
+
+func (p DynamoDBProvider) getFolderByID(ID int64) (Folder, error) {
+	var folder Folder
+	item, err := p.getItem(dynamoDBFoldersIDPartition, strconv.FormatInt(ID, 10))
+	if err != nil {
+		return folder, err
+	}
+	if item == nil {
+		return folder, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+	}
+	folder, err = p.folderExists(dynamoDBString(item, "name"))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return folder, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+	}
+	return folder, err
+}
+
+func (p DynamoDBProvider) folderExists(name string) (Folder, error) {
+	item, err := p.getItem(dynamoDBFoldersPartition, name)
+	if err != nil {
+		return Folder{}, err
+	}
+	if item == nil {
+		return Folder{}, &RecordNotFoundError{err: fmt.Sprintf("folder %#v does not exist", name)}
+	}
+	return getFolderFromDynamoDBItem(item)
+}
+
+func (p DynamoDBProvider) addFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	folder.ID, err = p.nextSequence(dynamoDBFoldersSequence)
+	if err != nil {
+		return err
+	}
+	item, err := getDynamoDBFolderItem(folder)
+	if err != nil {
+		return err
+	}
+	idxItem := getDynamoDBKey(dynamoDBFoldersIDPartition, strconv.FormatInt(folder.ID, 10))
+	idxItem["name"] = getDynamoDBString(folder.Name)
+	err = p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     item,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:                aws.String(p.tableName),
+				Item:                     idxItem,
+				ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+			},
+		},
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeTransactionCanceledException) {
+		return fmt.Errorf("folder %v already exists", folder.Name)
+	}
+	return err
+}
+
+// updateFolder updates the folder settings, the used quota is not modified
+func (p DynamoDBProvider) updateFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	existing, err := p.getFolderByID(folder.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Name != folder.Name {
+		return &ValidationError{err: "the folder name cannot be changed"}
+	}
+	data, err := getDynamoDBFolderData(folder)
+	if err != nil {
+		return err
+	}
+	err = p.updateItem(getDynamoDBKey(dynamoDBFoldersPartition, folder.Name), "SET #data = :data",
+		map[string]*dynamodb.AttributeValue{
+			":data": getDynamoDBString(data),
+		})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", folder.ID)}
+	}
+	return err
+}
+
+func (p DynamoDBProvider) deleteFolder(folder Folder) error {
+	existing, err := p.getFolderByID(folder.ID)
+	if err != nil {
+		return err
+	}
+	return p.transactWrite([]*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName:                aws.String(p.tableName),
+				Key:                      getDynamoDBKey(dynamoDBFoldersPartition, existing.Name),
+				ConditionExpression:      aws.String(dynamoDBItemExistsCondition),
+				ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemExistsCondition),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(p.tableName),
+				Key:       getDynamoDBKey(dynamoDBFoldersIDPartition, strconv.FormatInt(existing.ID, 10)),
+			},
+		},
+	})
+}
+
+func (p DynamoDBProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	expression := "ADD #used_quota_size :size, #used_quota_files :files SET #last_quota_update = :now"
+	if reset {
+		expression = "SET #used_quota_size = :size, #used_quota_files = :files, #last_quota_update = :now"
+	}
+	err := p.updateItem(getDynamoDBKey(dynamoDBFoldersPartition, name), expression, map[string]*dynamodb.AttributeValue{
+		":size":  getDynamoDBNumber(sizeAdd),
+		":files": getDynamoDBNumber(int64(filesAdd)),
+		":now":   getDynamoDBNumber(utils.GetTimeAsMsSinceEpoch(time.Now())),
+	})
+	if isDynamoDBErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return &RecordNotFoundError{err: fmt.Sprintf("folder %#v does not exist, unable to update quota", name)}
+	}
+	return err
+}
+
+func (p DynamoDBProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.folderExists(name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for folder %v error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+// getPlanUsers returns the users with the given plan assigned
+func (p DynamoDBProvider) getPlanUsers(name string) ([]User, error) {
+	var users []User
+	err := p.queryPartition(dynamoDBUsersPartition, true, func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		if dynamoDBString(item, "plan") != name {
+			return true, nil
+		}
+		user, err := getUserFromDynamoDBItem(item)
+		if err != nil {
+			return false, err
+		}
+		users = append(users, user)
+		return true, nil
+	})
+	return users, err
+}
+
+func (p DynamoDBProvider) dumpUsers() ([]User, error) {
+	users := []User{}
+	err := p.queryPartition(dynamoDBUsersPartition, true, func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		user, err := getUserFromDynamoDBItem(item)
+		if err != nil {
+			return false, err
+		}
+		err = addCredentialsToUser(&user)
+		if err != nil {
+			return false, err
+		}
+		users = append(users, user)
+		return true, nil
+	})
+	return users, err
+}
+
+func (p DynamoDBProvider) getUserWithUsername(username string) ([]User, error) {
+	users := []User{}
+	var user User
+	user, err := p.userExists(username)
+	if err == nil {
+		users = append(users, HideUserSensitiveData(&user))
+		return users, nil
+	}
+	if _, ok := err.(*RecordNotFoundError); ok {
+		err = nil
+	}
+	return users, err
+}
+
+// getUsers returns the requested page of users. DynamoDB has no offset, the skipped
+// users are read and discarded
+func (p DynamoDBProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
+	users := []User{}
+	var err error
+	if limit <= 0 {
+		return users, err
+	}
+	if len(username) > 0 {
+		if offset == 0 {
+			return p.getUserWithUsername(username)
+		}
+		return users, err
+	}
+	skipped := 0
+	err = p.queryPartition(dynamoDBUsersPartition, order == "ASC", func(item map[string]*dynamodb.AttributeValue) (bool, error) {
+		if skipped < offset {
+			skipped++
+			return true, nil
+		}
+		user, err := getUserFromDynamoDBItem(item)
+		if err != nil {
+			return false, err
+		}
+		users = append(users, HideUserSensitiveData(&user))
+		return len(users) < limit, nil
+	})
+	return users, err
+}
+
+func (p DynamoDBProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return getUsersPageFromList(p, UserScope{}, req, cursor)
+}
+
+func (p DynamoDBProvider) close() error {
+	return nil
+}
+
+func (p DynamoDBProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase creates the table using the on-demand capacity mode
+func (p DynamoDBProvider) initializeDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBLongTimeout)
+	defer cancel()
+
+	_, err := p.dbHandle.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(p.tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String(dynamoDBPartitionKey),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+			{
+				AttributeName: aws.String(dynamoDBSortKey),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String(dynamoDBPartitionKey),
+				KeyType:       aws.String(dynamodb.KeyTypeHash),
+			},
+			{
+				AttributeName: aws.String(dynamoDBSortKey),
+				KeyType:       aws.String(dynamodb.KeyTypeRange),
+			},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+	if err != nil {
+		return err
+	}
+	err = p.dbHandle.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(p.tableName),
+	})
+	if err != nil {
+		return err
+	}
+	item := getDynamoDBKey(dynamoDBSchemaPartition, dynamoDBSchemaPartition)
+	item["version"] = getDynamoDBNumber(dynamoDBDatabaseVersion)
+	_, err = p.dbHandle.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(p.tableName),
+		Item:                     item,
+		ConditionExpression:      aws.String(dynamoDBItemMissingCondition),
+		ExpressionAttributeNames: getDynamoDBAttributeNames(dynamoDBItemMissingCondition),
+	})
+	return err
+}
+
+func (p DynamoDBProvider) migrateDatabase() error {
+	item, err := p.getItem(dynamoDBSchemaPartition, dynamoDBSchemaPartition)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("the schema version for table %#v is missing, please initialize the data provider",
+			p.tableName)
+	}
+	version, err := dynamoDBInt(item, "version")
+	if err != nil {
+		return err
+	}
+	if version == dynamoDBDatabaseVersion {
+		providerLog(logger.LevelDebug, "dynamodb database is updated, current version: %v", version)
+		return nil
+	}
+	return fmt.Errorf("Database version not handled: %v", version)
+}
+
+func (p DynamoDBProvider) nextSequence(name string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBTimeout)
+	defer cancel()
+
+	out, err := p.dbHandle.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(p.tableName),
+		Key:                      getDynamoDBKey(dynamoDBSequencesPartition, name),
+		UpdateExpression:         aws.String("ADD #value :one"),
+		ExpressionAttributeNames: getDynamoDBAttributeNames("ADD #value :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": getDynamoDBNumber(1),
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dynamoDBInt(out.Attributes, "value")
+}
+
+// getItem returns the item with the given key using a strongly consistent read, the returned
+// item is nil if it does not exist
+func (p DynamoDBProvider) getItem(partition, sortKey string) (map[string]*dynamodb.AttributeValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBTimeout)
+	defer cancel()
+
+	out, err := p.dbHandle.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(p.tableName),
+		Key:            getDynamoDBKey(partition, sortKey),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	return out.Item, nil
+}
+
+// updateItem updates an existing item, a ConditionalCheckFailedException is returned if the item does not exist
+func (p DynamoDBProvider) updateItem(key map[string]*dynamodb.AttributeValue, expression string,
+	values map[string]*dynamodb.AttributeValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBTimeout)
+	defer cancel()
+
+	_, err := p.dbHandle.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(p.tableName),
+		Key:                       key,
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(dynamoDBItemExistsCondition),
+		ExpressionAttributeNames:  getDynamoDBAttributeNames(expression, dynamoDBItemExistsCondition),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+func (p DynamoDBProvider) transactWrite(items []*dynamodb.TransactWriteItem) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBTimeout)
+	defer cancel()
+
+	_, err := p.dbHandle.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
+
+// queryPartition calls fn for each item inside the given partition sorted by the sort key,
+// fn returns false to stop the iteration
+func (p DynamoDBProvider) queryPartition(partition string, ascending bool,
+	fn func(item map[string]*dynamodb.AttributeValue) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoDBLongTimeout)
+	defer cancel()
+
+	var err error
+	errPages := p.dbHandle.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(p.tableName),
+		KeyConditionExpression:   aws.String("#pk = :pk"),
+		ExpressionAttributeNames: getDynamoDBAttributeNames("#pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": getDynamoDBString(partition),
+		},
+		ScanIndexForward: aws.Bool(ascending),
+		ConsistentRead:   aws.Bool(true),
+	}, func(out *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range out.Items {
+			var next bool
+			next, err = fn(item)
+			if err != nil || !next {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return errPages
+}
+
+func isDynamoDBErrorCode(err error, code string) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == code
+	}
+	return false
+}
+
+// getDynamoDBAttributeNames returns the attribute names used inside the given expressions.
+// The attribute names are always referenced using placeholders since many common
+// names, such as data, name and value, are reserved words
+func getDynamoDBAttributeNames(expressions ...string) map[string]*string {
+	names := make(map[string]*string)
+	for _, expression := range expressions {
+		for _, placeholder := range dynamoDBAttributeNameRegex.FindAllString(expression, -1) {
+			names[placeholder] = aws.String(strings.TrimPrefix(placeholder, "#"))
+		}
+	}
+	return names
+}
+
+func getDynamoDBKey(partition, sortKey string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		dynamoDBPartitionKey: getDynamoDBString(partition),
+		dynamoDBSortKey:      getDynamoDBString(sortKey),
+	}
+}
+
+func getDynamoDBString(value string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{S: aws.String(value)}
+}
+
+func getDynamoDBNumber(value int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(value, 10))}
+}
+
+func dynamoDBString(item map[string]*dynamodb.AttributeValue, name string) string {
+	if v, ok := item[name]; ok && v != nil {
+		return aws.StringValue(v.S)
+	}
+	return ""
+}
+
+// dynamoDBInt returns the numeric attribute with the given name, 0 if it is missing
+func dynamoDBInt(item map[string]*dynamodb.AttributeValue, name string) (int64, error) {
+	v, ok := item[name]
+	if !ok || v == nil || v.N == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+}
+
+// getDynamoDBUserData returns the user serialized as JSON without the fields stored
+// inside dedicated attributes
+func getDynamoDBUserData(user User) (string, error) {
+	user.ID = 0
+	user.Password = ""
+	user.UsedQuotaSize = 0
+	user.UsedQuotaFiles = 0
+	user.LastQuotaUpdate = 0
+	user.LastLogin = 0
+	buf, err := json.Marshal(user)
+	return string(buf), err
+}
+
+func getDynamoDBUserItem(user User) (map[string]*dynamodb.AttributeValue, error) {
+	data, err := getDynamoDBUserData(user)
+	if err != nil {
+		return nil, err
+	}
+	item := getDynamoDBKey(dynamoDBUsersPartition, user.Username)
+	item["id"] = getDynamoDBNumber(user.ID)
+	item["data"] = getDynamoDBString(data)
+	item["password"] = getDynamoDBString(user.Password)
+	item["plan"] = getDynamoDBString(user.Plan)
+	item["used_quota_size"] = getDynamoDBNumber(user.UsedQuotaSize)
+	item["used_quota_files"] = getDynamoDBNumber(int64(user.UsedQuotaFiles))
+	item["last_quota_update"] = getDynamoDBNumber(user.LastQuotaUpdate)
+	item["last_login"] = getDynamoDBNumber(user.LastLogin)
+	item["version"] = getDynamoDBNumber(user.Version)
+	return item, nil
+}
+
+func getUserFromDynamoDBItem(item map[string]*dynamodb.AttributeValue) (User, error) {
+	var user User
+	err := json.Unmarshal([]byte(dynamoDBString(item, "data")), &user)
+	if err != nil {
+		return user, err
+	}
+	user.Password = dynamoDBString(item, "password")
+	if user.ID, err = dynamoDBInt(item, "id"); err != nil {
+		return user, err
+	}
+	if user.UsedQuotaSize, err = dynamoDBInt(item, "used_quota_size"); err != nil {
+		return user, err
+	}
+	usedFiles, err := dynamoDBInt(item, "used_quota_files")
+	if err != nil {
+		return user, err
+	}
+	user.UsedQuotaFiles = int(usedFiles)
+	if user.LastQuotaUpdate, err = dynamoDBInt(item, "last_quota_update"); err != nil {
+		return user, err
+	}
+	user.LastLogin, err = dynamoDBInt(item, "last_login")
+	return user, err
+}
+
+// getDynamoDBFolderData returns the folder serialized as JSON without the fields stored
+// inside dedicated attributes
+func getDynamoDBFolderData(folder Folder) (string, error) {
+	folder.ID = 0
+	folder.UsedQuotaSize = 0
+	folder.UsedQuotaFiles = 0
+	folder.LastQuotaUpdate = 0
+	buf, err := json.Marshal(folder)
+	return string(buf), err
+}
+
+func getDynamoDBFolderItem(folder Folder) (map[string]*dynamodb.AttributeValue, error) {
+	data, err := getDynamoDBFolderData(folder)
+	if err != nil {
+		return nil, err
+	}
+	item := getDynamoDBKey(dynamoDBFoldersPartition, folder.Name)
+	item["id"] = getDynamoDBNumber(folder.ID)
+	item["data"] = getDynamoDBString(data)
+	item["used_quota_size"] = getDynamoDBNumber(folder.UsedQuotaSize)
+	item["used_quota_files"] = getDynamoDBNumber(int64(folder.UsedQuotaFiles))
+	item["last_quota_update"] = getDynamoDBNumber(folder.LastQuotaUpdate)
+	return item, nil
+}
+
+func getFolderFromDynamoDBItem(item map[string]*dynamodb.AttributeValue) (Folder, error) {
+	var folder Folder
+	err := json.Unmarshal([]byte(dynamoDBString(item, "data")), &folder)
+	if err != nil {
+		return folder, err
+	}
+	if folder.ID, err = dynamoDBInt(item, "id"); err != nil {
+		return folder, err
+	}
+	if folder.UsedQuotaSize, err = dynamoDBInt(item, "used_quota_size"); err != nil {
+		return folder, err
+	}
+	usedFiles, err := dynamoDBInt(item, "used_quota_files")
+	if err != nil {
+		return folder, err
+	}
+	folder.UsedQuotaFiles = int(usedFiles)
+	folder.LastQuotaUpdate, err = dynamoDBInt(item, "last_quota_update")
+	return folder, err
+}
@@ -0,0 +1,516 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type dynamoDBTestItem map[string]*dynamodb.AttributeValue
+
+// dynamoDBTestServer implements the subset of the DynamoDB API used by the dynamodb provider,
+// the expressions supported are the ones used by the provider
+type dynamoDBTestServer struct {
+	*httptest.Server
+	sync.Mutex
+	tableCreated bool
+	items        map[string]dynamoDBTestItem
+	// if true the version of the next updated user is incremented, as if it was concurrently modified
+	modifyNextUpdate bool
+	failing          bool
+}
+
+func newDynamoDBTestServer() *dynamoDBTestServer {
+	s := &dynamoDBTestServer{
+		items: make(map[string]dynamoDBTestItem),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *dynamoDBTestServer) setFailing(failing bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failing = failing
+}
+
+func (s *dynamoDBTestServer) setModifyNextUpdate() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.modifyNextUpdate = true
+}
+
+type dynamoDBTestError struct {
+	code    string
+	message string
+}
+
+func (e *dynamoDBTestError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func (s *dynamoDBTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.sendError(w, &dynamoDBTestError{code: "SerializationException", message: err.Error()})
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if s.failing {
+		s.sendError(w, &dynamoDBTestError{code: "ResourceNotFoundException", message: "injected failure"})
+		return
+	}
+	if !s.tableCreated && operation != "CreateTable" {
+		s.sendError(w, &dynamoDBTestError{code: "ResourceNotFoundException", message: "table not found"})
+		return
+	}
+	var result interface{}
+	switch operation {
+	case "CreateTable":
+		s.tableCreated = true
+		result = map[string]interface{}{"TableDescription": map[string]string{"TableStatus": "ACTIVE"}}
+	case "DescribeTable":
+		result = map[string]interface{}{"Table": map[string]string{"TableStatus": "ACTIVE"}}
+	case "GetItem":
+		var input dynamodb.GetItemInput
+		if err = json.Unmarshal(body, &input); err == nil {
+			result = map[string]interface{}{"Item": encodeDynamoDBTestItem(s.items[getDynamoDBTestKey(input.Key)])}
+		}
+	case "PutItem":
+		var input dynamodb.PutItemInput
+		if err = json.Unmarshal(body, &input); err == nil {
+			err = s.put(input.Item, input.ConditionExpression, input.ExpressionAttributeNames,
+				input.ExpressionAttributeValues)
+			result = map[string]interface{}{}
+		}
+	case "UpdateItem":
+		var input dynamodb.UpdateItemInput
+		if err = json.Unmarshal(body, &input); err == nil {
+			var item dynamoDBTestItem
+			item, err = s.update(&input)
+			result = map[string]interface{}{"Attributes": encodeDynamoDBTestItem(item)}
+		}
+	case "Query":
+		var input dynamodb.QueryInput
+		if err = json.Unmarshal(body, &input); err == nil {
+			result, err = s.query(&input)
+		}
+	case "TransactWriteItems":
+		var input dynamodb.TransactWriteItemsInput
+		if err = json.Unmarshal(body, &input); err == nil {
+			err = s.transactWrite(input.TransactItems)
+			result = map[string]interface{}{}
+		}
+	default:
+		err = &dynamoDBTestError{code: "UnknownOperationException", message: operation}
+	}
+	if err != nil {
+		if _, ok := err.(*dynamoDBTestError); !ok {
+			err = &dynamoDBTestError{code: "ValidationException", message: err.Error()}
+		}
+		s.sendError(w, err.(*dynamoDBTestError))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *dynamoDBTestServer) sendError(w http.ResponseWriter, err *dynamoDBTestError) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"__type":  "com.amazonaws.dynamodb.v20120810#" + err.code,
+		"message": err.message,
+	})
+}
+
+func getDynamoDBTestKey(item dynamoDBTestItem) string {
+	return dynamoDBString(item, dynamoDBPartitionKey) + "/" + dynamoDBString(item, dynamoDBSortKey)
+}
+
+func encodeDynamoDBTestItem(item dynamoDBTestItem) map[string]map[string]string {
+	if item == nil {
+		return nil
+	}
+	result := make(map[string]map[string]string)
+	for name, value := range item {
+		if value.S != nil {
+			result[name] = map[string]string{"S": *value.S}
+		} else if value.N != nil {
+			result[name] = map[string]string{"N": *value.N}
+		}
+	}
+	return result
+}
+
+func copyDynamoDBTestItem(item dynamoDBTestItem) dynamoDBTestItem {
+	result := make(dynamoDBTestItem)
+	for name, value := range item {
+		result[name] = value
+	}
+	return result
+}
+
+func checkDynamoDBTestCondition(item dynamoDBTestItem, condition *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) error {
+	if condition == nil {
+		return nil
+	}
+	expr := strings.NewReplacer("(", " ( ", ")", " ) ").Replace(*condition)
+	e := &dynamoDBTestExpression{
+		tokens: strings.Fields(expr),
+		item:   item,
+		names:  names,
+		values: values,
+	}
+	result, err := e.parseOr()
+	if err != nil {
+		return err
+	}
+	if !result {
+		return &dynamoDBTestError{code: "ConditionalCheckFailedException", message: "the conditional request failed"}
+	}
+	return nil
+}
+
+// dynamoDBTestExpression evaluates the condition expressions with AND, OR, parentheses, equality
+// and the attribute_exists and attribute_not_exists functions
+type dynamoDBTestExpression struct {
+	tokens []string
+	pos    int
+	item   dynamoDBTestItem
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+}
+
+func (e *dynamoDBTestExpression) next() string {
+	if e.pos >= len(e.tokens) {
+		return ""
+	}
+	e.pos++
+	return e.tokens[e.pos-1]
+}
+
+func (e *dynamoDBTestExpression) expect(token string) error {
+	if t := e.next(); t != token {
+		return fmt.Errorf("invalid expression, expected %#v, got %#v", token, t)
+	}
+	return nil
+}
+
+func (e *dynamoDBTestExpression) parseOr() (bool, error) {
+	result, err := e.parseAnd()
+	for err == nil && e.pos < len(e.tokens) && e.tokens[e.pos] == "OR" {
+		e.pos++
+		var right bool
+		right, err = e.parseAnd()
+		result = result || right
+	}
+	return result, err
+}
+
+func (e *dynamoDBTestExpression) parseAnd() (bool, error) {
+	result, err := e.parsePrimary()
+	for err == nil && e.pos < len(e.tokens) && e.tokens[e.pos] == "AND" {
+		e.pos++
+		var right bool
+		right, err = e.parsePrimary()
+		result = result && right
+	}
+	return result, err
+}
+
+func (e *dynamoDBTestExpression) parsePrimary() (bool, error) {
+	token := e.next()
+	switch token {
+	case "(":
+		result, err := e.parseOr()
+		if err == nil {
+			err = e.expect(")")
+		}
+		return result, err
+	case "attribute_exists", "attribute_not_exists":
+		if err := e.expect("("); err != nil {
+			return false, err
+		}
+		name := e.next()
+		if err := e.expect(")"); err != nil {
+			return false, err
+		}
+		_, exists := e.item[aws.StringValue(e.names[name])]
+		return exists == (token == "attribute_exists"), nil
+	}
+	if err := e.expect("="); err != nil {
+		return false, err
+	}
+	left := e.getOperand(token)
+	right := e.getOperand(e.next())
+	if left == nil || right == nil {
+		return false, nil
+	}
+	return aws.StringValue(left.S) == aws.StringValue(right.S) && aws.StringValue(left.N) == aws.StringValue(right.N), nil
+}
+
+func (e *dynamoDBTestExpression) getOperand(token string) *dynamodb.AttributeValue {
+	if strings.HasPrefix(token, "#") {
+		return e.item[aws.StringValue(e.names[token])]
+	}
+	return e.values[token]
+}
+
+// applyDynamoDBTestUpdate applies the SET and ADD clauses of the given update expression
+func applyDynamoDBTestUpdate(item dynamoDBTestItem, expression string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) error {
+	tokens := strings.Fields(strings.Replace(expression, ",", " ", -1))
+	mode := ""
+	for i := 0; i < len(tokens); {
+		if tokens[i] == "SET" || tokens[i] == "ADD" {
+			mode = tokens[i]
+			i++
+			continue
+		}
+		name := aws.StringValue(names[tokens[i]])
+		switch mode {
+		case "SET":
+			if i+2 >= len(tokens) || tokens[i+1] != "=" {
+				return fmt.Errorf("invalid update expression %#v", expression)
+			}
+			item[name] = values[tokens[i+2]]
+			i += 3
+		case "ADD":
+			if i+1 >= len(tokens) {
+				return fmt.Errorf("invalid update expression %#v", expression)
+			}
+			current, err := dynamoDBInt(item, name)
+			if err != nil {
+				return err
+			}
+			increment, err := strconv.ParseInt(aws.StringValue(values[tokens[i+1]].N), 10, 64)
+			if err != nil {
+				return err
+			}
+			item[name] = getDynamoDBNumber(current + increment)
+			i += 2
+		default:
+			return fmt.Errorf("unsupported update expression %#v", expression)
+		}
+	}
+	return nil
+}
+
+func (s *dynamoDBTestServer) put(item dynamoDBTestItem, condition *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue) error {
+	key := getDynamoDBTestKey(item)
+	if err := checkDynamoDBTestCondition(s.items[key], condition, names, values); err != nil {
+		return err
+	}
+	s.items[key] = item
+	return nil
+}
+
+func (s *dynamoDBTestServer) update(input *dynamodb.UpdateItemInput) (dynamoDBTestItem, error) {
+	key := getDynamoDBTestKey(input.Key)
+	existing := s.items[key]
+	if s.modifyNextUpdate && dynamoDBString(input.Key, dynamoDBPartitionKey) == dynamoDBUsersPartition {
+		s.modifyNextUpdate = false
+		version, _ := dynamoDBInt(existing, "version")
+		existing["version"] = getDynamoDBNumber(version + 1)
+	}
+	if err := checkDynamoDBTestCondition(existing, input.ConditionExpression, input.ExpressionAttributeNames,
+		input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	item := copyDynamoDBTestItem(existing)
+	for name, value := range input.Key {
+		item[name] = value
+	}
+	err := applyDynamoDBTestUpdate(item, aws.StringValue(input.UpdateExpression), input.ExpressionAttributeNames,
+		input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	s.items[key] = item
+	return item, nil
+}
+
+func (s *dynamoDBTestServer) query(input *dynamodb.QueryInput) (interface{}, error) {
+	if aws.StringValue(input.KeyConditionExpression) != "#pk = :pk" {
+		return nil, fmt.Errorf("unsupported key condition %#v", aws.StringValue(input.KeyConditionExpression))
+	}
+	partition := aws.StringValue(input.ExpressionAttributeValues[":pk"].S)
+	var items []dynamoDBTestItem
+	for _, item := range s.items {
+		if dynamoDBString(item, dynamoDBPartitionKey) == partition {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if aws.BoolValue(input.ScanIndexForward) {
+			return dynamoDBString(items[i], dynamoDBSortKey) < dynamoDBString(items[j], dynamoDBSortKey)
+		}
+		return dynamoDBString(items[i], dynamoDBSortKey) > dynamoDBString(items[j], dynamoDBSortKey)
+	})
+	encoded := []interface{}{}
+	for _, item := range items {
+		encoded = append(encoded, encodeDynamoDBTestItem(item))
+	}
+	return map[string]interface{}{"Items": encoded, "Count": len(encoded)}, nil
+}
+
+func (s *dynamoDBTestServer) transactWrite(transactItems []*dynamodb.TransactWriteItem) error {
+	// all the conditions are checked before writing
+	for _, transactItem := range transactItems {
+		var err error
+		switch {
+		case transactItem.Put != nil:
+			err = checkDynamoDBTestCondition(s.items[getDynamoDBTestKey(transactItem.Put.Item)],
+				transactItem.Put.ConditionExpression, transactItem.Put.ExpressionAttributeNames,
+				transactItem.Put.ExpressionAttributeValues)
+		case transactItem.Delete != nil:
+			err = checkDynamoDBTestCondition(s.items[getDynamoDBTestKey(transactItem.Delete.Key)],
+				transactItem.Delete.ConditionExpression, transactItem.Delete.ExpressionAttributeNames,
+				transactItem.Delete.ExpressionAttributeValues)
+		case transactItem.ConditionCheck != nil:
+			err = checkDynamoDBTestCondition(s.items[getDynamoDBTestKey(transactItem.ConditionCheck.Key)],
+				transactItem.ConditionCheck.ConditionExpression, transactItem.ConditionCheck.ExpressionAttributeNames,
+				transactItem.ConditionCheck.ExpressionAttributeValues)
+		case transactItem.Update != nil:
+			err = checkDynamoDBTestCondition(s.items[getDynamoDBTestKey(transactItem.Update.Key)],
+				transactItem.Update.ConditionExpression, transactItem.Update.ExpressionAttributeNames,
+				transactItem.Update.ExpressionAttributeValues)
+		}
+		if err != nil {
+			return &dynamoDBTestError{code: "TransactionCanceledException", message: err.Error()}
+		}
+	}
+	for _, transactItem := range transactItems {
+		switch {
+		case transactItem.Put != nil:
+			s.items[getDynamoDBTestKey(transactItem.Put.Item)] = transactItem.Put.Item
+		case transactItem.Delete != nil:
+			delete(s.items, getDynamoDBTestKey(transactItem.Delete.Key))
+		case transactItem.Update != nil:
+			key := getDynamoDBTestKey(transactItem.Update.Key)
+			item := copyDynamoDBTestItem(s.items[key])
+			for name, value := range transactItem.Update.Key {
+				item[name] = value
+			}
+			err := applyDynamoDBTestUpdate(item, aws.StringValue(transactItem.Update.UpdateExpression),
+				transactItem.Update.ExpressionAttributeNames, transactItem.Update.ExpressionAttributeValues)
+			if err != nil {
+				return err
+			}
+			s.items[key] = item
+		}
+	}
+	return nil
+}
+
+func TestDynamoDBProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynamodb")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	server := newDynamoDBTestServer()
+	defer server.Close()
+
+	config = Config{
+		Driver:           DynamoDBDataProviderName,
+		Host:             "us-east-1",
+		Username:         "access_key",
+		Password:         "secret_key",
+		ConnectionString: server.URL,
+	}
+	if err = initializeDynamoDBProvider(); err == nil {
+		t.Error("the table name is mandatory")
+	}
+	config.Name = "sftpgo_test"
+	if err = initializeDynamoDBProvider(); err != nil {
+		t.Fatalf("unable to initialize the dynamodb provider: %v", err)
+	}
+	p := provider
+	if err = p.checkAvailability(); err == nil {
+		t.Error("the availability check must fail if the table does not exist")
+	}
+	if err = p.initializeDatabase(); err != nil {
+		t.Fatalf("unable to initialize the database: %v", err)
+	}
+	if err = p.initializeDatabase(); err == nil {
+		t.Error("the schema version must not be overwritten")
+	}
+	if err = p.migrateDatabase(); err != nil {
+		t.Errorf("unable to migrate the database: %v", err)
+	}
+
+	testProviderUsers(t, p, dir)
+	if err = p.updateUserPassword("another_user", "$2a$10$updated"); err != nil {
+		t.Errorf("unable to update password: %v", err)
+	}
+	checkRecordNotFound(t, "update password for a missing user", p.updateUserPassword("missing_user", "pwd"))
+	user, err := p.userExists("another_user")
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if user.Password != "$2a$10$updated" {
+		t.Errorf("unexpected password: %#v", user.Password)
+	}
+	// the user is modified after being read by the provider
+	server.setModifyNextUpdate()
+	user.MaxSessions = 4
+	if err = p.updateUser(user); err != ErrUserVersionMismatch {
+		t.Errorf("a concurrent update must fail with a version mismatch, got: %v", err)
+	}
+	users, err := p.dumpUsers()
+	if err != nil {
+		t.Errorf("unable to dump users: %v", err)
+	} else if len(users) != 1 || users[0].Username != user.Username || users[0].MaxSessions != 0 {
+		t.Errorf("unexpected users: %+v", users)
+	}
+
+	server.setFailing(true)
+	if err = p.checkAvailability(); err == nil {
+		t.Error("the availability check must fail")
+	}
+	if _, err = p.userExists(user.Username); err == nil {
+		t.Error("getting a user must fail")
+	}
+	if err = p.addUser(getTestUser(dir, "failing_user")); err == nil {
+		t.Error("adding a user must fail")
+	}
+	if err = p.updateQuota(user.Username, 1, 1, false); err == nil {
+		t.Error("updating the quota must fail")
+	}
+	if err = p.deleteUser(user); err == nil {
+		t.Error("deleting a user must fail")
+	}
+	if _, err = p.getUsers(10, 0, "ASC", ""); err == nil {
+		t.Error("getting the users must fail")
+	}
+	server.setFailing(false)
+	checkUsedQuota(t, p, user.Username, 0, 0)
+}
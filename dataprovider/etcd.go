@@ -0,0 +1,1275 @@
+package dataprovider
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	etcdDatabaseVersion    = 1
+	etcdDefaultNamespace   = "sftpgo"
+	etcdDefaultPort        = 2379
+	etcdPageSize           = 500
+	etcdMaxTxnRetries      = 20
+	etcdDialTimeout        = 10 * time.Second
+	etcdRequestTimeout     = 10 * time.Second
+	etcdWatchRetryInterval = 5 * time.Second
+)
+
+var (
+	errEtcdTxnFailed      = errors.New("etcd transaction aborted, a compared key was modified")
+	errEtcdTooManyRetries = errors.New("etcd transaction aborted too many times, the keys are modified concurrently")
+)
+
+// EtcdProvider auth provider for etcd key/value store.
+// Each object is stored as JSON inside a key, all the keys are prefixed with the configured
+// namespace. The users are cached in memory and the cached users are invalidated watching
+// the users keys, so the changes made by other SFTPGo instances are immediately visible
+type EtcdProvider struct {
+	dbHandle  *clientv3.Client
+	namespace string
+	cache     *etcdUsersCache
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+type etcdCachedUser struct {
+	user     User
+	revision int64
+}
+
+// etcdUsersCache is enabled only while the users keys are watched
+type etcdUsersCache struct {
+	sync.RWMutex
+	enabled bool
+	// the users read before this revision could miss a change and they cannot be cached
+	minRevision int64
+	users       map[string]etcdCachedUser
+	// revision of the last change for the users changed while the cache is enabled
+	changes map[string]int64
+}
+
+func init() {
+	utils.AddFeature("+etcd")
+}
+
+func newEtcdUsersCache() *etcdUsersCache {
+	return &etcdUsersCache{
+		users:   make(map[string]etcdCachedUser),
+		changes: make(map[string]int64),
+	}
+}
+
+func (c *etcdUsersCache) get(username string) (User, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if !c.enabled {
+		return User{}, false
+	}
+	cached, ok := c.users[username]
+	if !ok {
+		return User{}, false
+	}
+	return cached.user.getACopy(), true
+}
+
+// add caches the given user read at the given revision, if no change happened after the read
+func (c *etcdUsersCache) add(user User, revision int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.enabled || revision < c.minRevision || c.changes[user.Username] > revision {
+		return
+	}
+	if cached, ok := c.users[user.Username]; ok && cached.revision > revision {
+		return
+	}
+	c.users[user.Username] = etcdCachedUser{
+		user:     user.getACopy(),
+		revision: revision,
+	}
+}
+
+func (c *etcdUsersCache) invalidate(username string, revision int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.users, username)
+	if c.changes[username] < revision {
+		c.changes[username] = revision
+	}
+}
+
+// reset empties the cache, the cache is enabled only if the users keys are watched
+// starting from the given revision
+func (c *etcdUsersCache) reset(enabled bool, revision int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.enabled = enabled
+	c.minRevision = revision
+	c.users = make(map[string]etcdCachedUser)
+	c.changes = make(map[string]int64)
+}
+
+func initializeEtcdProvider() error {
+	logSender = fmt.Sprintf("dataprovider_%v", EtcdDataProviderName)
+	etcdConfig, err := getEtcdConfig()
+	if err != nil {
+		providerLog(logger.LevelWarn, "invalid etcd configuration: %v", err)
+		return err
+	}
+	dbHandle, err := clientv3.New(etcdConfig)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error creating etcd client for endpoints %v: %v", etcdConfig.Endpoints, err)
+		return err
+	}
+	namespace := strings.Trim(config.Name, "/")
+	if len(namespace) == 0 {
+		namespace = etcdDefaultNamespace
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := EtcdProvider{
+		dbHandle:  dbHandle,
+		namespace: namespace + "/",
+		cache:     newEtcdUsersCache(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if err = p.checkAvailability(); err != nil {
+		providerLog(logger.LevelWarn, "error connecting to etcd endpoints %v: %v", etcdConfig.Endpoints, err)
+		cancel()
+		dbHandle.Close()
+		return err
+	}
+	providerLog(logger.LevelDebug, "etcd provider created, endpoints: %v, namespace: %#v", etcdConfig.Endpoints,
+		p.namespace)
+	go p.watchUsers()
+	provider = p
+	return nil
+}
+
+// getEtcdConfig builds the etcd client configuration. The connection string, if any,
+// must be a comma separated list of http:// or https:// endpoints
+func getEtcdConfig() (clientv3.Config, error) {
+	etcdConfig := clientv3.Config{
+		Username:    config.Username,
+		Password:    config.Password,
+		DialTimeout: etcdDialTimeout,
+		// the client logs are not useful for us, the errors are returned and logged by the provider
+		Logger: zap.NewNop(),
+	}
+	useTLS := config.SSLMode > 0
+	if len(config.ConnectionString) > 0 {
+		for _, endpoint := range strings.Split(config.ConnectionString, ",") {
+			endpoint = strings.TrimSuffix(strings.TrimSpace(endpoint), "/")
+			u, err := url.Parse(endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				return etcdConfig, fmt.Errorf("invalid etcd endpoint %#v", endpoint)
+			}
+			if u.Scheme == "https" {
+				useTLS = true
+			}
+			etcdConfig.Endpoints = append(etcdConfig.Endpoints, endpoint)
+		}
+	} else {
+		port := config.Port
+		if port == 0 {
+			port = etcdDefaultPort
+		}
+		scheme := "http"
+		if useTLS {
+			scheme = "https"
+		}
+		etcdConfig.Endpoints = append(etcdConfig.Endpoints,
+			fmt.Sprintf("%v://%v", scheme, net.JoinHostPort(config.Host, strconv.Itoa(port))))
+	}
+	if useTLS {
+		etcdConfig.TLS = &tls.Config{
+			InsecureSkipVerify: config.SSLMode == 2,
+		}
+	}
+	return etcdConfig, nil
+}
+
+func (p EtcdProvider) getUserKey(username string) string {
+	return p.namespace + "users/" + username
+}
+
+func (p EtcdProvider) getUserIDKey(ID int64) string {
+	return p.namespace + "users_id/" + strconv.FormatInt(ID, 10)
+}
+
+func (p EtcdProvider) getIPListEntryKey(ID int64) string {
+	// the IDs are zero padded so the keys are sorted by ID
+	return fmt.Sprintf("%vip_lists/%020d", p.namespace, ID)
+}
+
+func (p EtcdProvider) getIPListIdxKey(ipOrNet string) string {
+	return p.namespace + "ip_lists_idx/" + ipOrNet
+}
+
+func (p EtcdProvider) getPlanKey(name string) string {
+	return p.namespace + "plans/" + name
+}
+
+func (p EtcdProvider) getPlanIDKey(ID int64) string {
+	return p.namespace + "plans_id/" + strconv.FormatInt(ID, 10)
+}
+
+func (p EtcdProvider) getUserTemplateKey(name string) string {
+	return p.namespace + "user_templates/" + name
+}
+
+func (p EtcdProvider) getUserTemplateIDKey(ID int64) string {
+	return p.namespace + "user_templates_id/" + strconv.FormatInt(ID, 10)
+}
+
+func (p EtcdProvider) getFolderKey(name string) string {
+	return p.namespace + "folders/" + name
+}
+
+func (p EtcdProvider) getFolderIDKey(ID int64) string {
+	return p.namespace + "folders_id/" + strconv.FormatInt(ID, 10)
+}
+
+func (p EtcdProvider) getSchemaVersionKey() string {
+	return p.namespace + "schema_version"
+}
+
+// watchUsers invalidates the cached users when they change. If the watch fails the cache
+// is disabled until the watch is established again
+func (p EtcdProvider) watchUsers() {
+	prefix := p.namespace + "users/"
+	for {
+		_, revision, err := p.get(p.getSchemaVersionKey())
+		if err == nil {
+			p.cache.reset(false, revision)
+			err = p.watchPrefix(prefix, revision)
+		}
+		p.cache.reset(false, 0)
+		if p.ctx.Err() != nil {
+			return
+		}
+		providerLog(logger.LevelWarn, "etcd users watch failed, the users cache is disabled: %v", err)
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(etcdWatchRetryInterval):
+		}
+	}
+}
+
+// watchPrefix watches the keys with the given prefix changed after the given revision and
+// enables the users cache once the watch is established. It blocks until the watch fails
+// or the provider is closed
+func (p EtcdProvider) watchPrefix(prefix string, revision int64) error {
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+
+	// the watch is closed, instead of silently waiting, if the etcd cluster has no leader
+	watchChan := p.dbHandle.Watch(clientv3.WithRequireLeader(ctx), prefix, clientv3.WithPrefix(),
+		clientv3.WithRev(revision+1), clientv3.WithCreatedNotify())
+	for resp := range watchChan {
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if resp.Created {
+			p.cache.reset(true, revision)
+			providerLog(logger.LevelDebug, "etcd users watch established, revision: %v", revision)
+		}
+		for _, event := range resp.Events {
+			p.cache.invalidate(strings.TrimPrefix(string(event.Kv.Key), prefix), resp.Header.Revision)
+		}
+	}
+	return errors.New("etcd watch closed")
+}
+
+func (p EtcdProvider) checkAvailability() error {
+	_, _, err := p.get(p.getSchemaVersionKey())
+	return err
+}
+
+func (p EtcdProvider) validateUserAndPass(username string, password string) (User, error) {
+	var user User
+	if len(password) == 0 {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(user, password)
+}
+
+func (p EtcdProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(user, pubKey)
+}
+
+func (p EtcdProvider) getUserByID(ID int64) (User, error) {
+	var user User
+	kv, _, err := p.get(p.getUserIDKey(ID))
+	if err != nil {
+		return user, err
+	}
+	if kv == nil {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("user with ID %v does not exist", ID)}
+	}
+	user, err = p.userExists(string(kv.Value))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("username %#v and ID: %v does not exist",
+			string(kv.Value), ID)}
+	}
+	return user, err
+}
+
+// getUser reads the user bypassing the cache, the returned revision can be used to update it
+func (p EtcdProvider) getUser(username string) (User, int64, error) {
+	var user User
+	kv, revision, err := p.get(p.getUserKey(username))
+	if err != nil {
+		return user, 0, err
+	}
+	if kv == nil {
+		return user, 0, &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", username)}
+	}
+	err = json.Unmarshal(kv.Value, &user)
+	if err == nil {
+		p.cache.add(user, revision)
+	}
+	return user, kv.ModRevision, err
+}
+
+// modifyUser reads the user, applies fn and saves the user if it was not modified concurrently,
+// otherwise the update is retried
+func (p EtcdProvider) modifyUser(username string, fn func(user *User) error) error {
+	return retryEtcdTxn(func() error {
+		user, revision, err := p.getUser(username)
+		if err != nil {
+			return err
+		}
+		if err = fn(&user); err != nil {
+			return err
+		}
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		key := p.getUserKey(username)
+		resp, err := p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpPut(key, string(buf))})
+		if err == nil {
+			p.cache.invalidate(username, resp.Header.Revision)
+		}
+		return err
+	})
+}
+
+func (p EtcdProvider) updateLastLogin(username string) error {
+	return p.modifyUser(username, func(user *User) error {
+		user.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now())
+		return nil
+	})
+}
+
+func (p EtcdProvider) updateUserPassword(username, password string) error {
+	return p.modifyUser(username, func(user *User) error {
+		user.Password = password
+		return nil
+	})
+}
+
+func (p EtcdProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.modifyUser(username, func(user *User) error {
+		if reset {
+			user.UsedQuotaSize = sizeAdd
+			user.UsedQuotaFiles = filesAdd
+		} else {
+			user.UsedQuotaSize += sizeAdd
+			user.UsedQuotaFiles += filesAdd
+		}
+		user.LastQuotaUpdate = utils.GetTimeAsMsSinceEpoch(time.Now())
+		return nil
+	})
+}
+
+func (p EtcdProvider) getUsedQuota(username string) (int, int64, error) {
+	user, _, err := p.getUser(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, err
+}
+
+func (p EtcdProvider) userExists(username string) (User, error) {
+	if user, ok := p.cache.get(username); ok {
+		return user, nil
+	}
+	user, _, err := p.getUser(username)
+	return user, err
+}
+
+func (p EtcdProvider) addUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	user.ID, err = p.nextSequence("users")
+	if err != nil {
+		return err
+	}
+	user.LastQuotaUpdate = 0
+	user.UsedQuotaSize = 0
+	user.UsedQuotaFiles = 0
+	user.LastLogin = 0
+	setUserCreationFields(&user)
+	buf, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	key := p.getUserKey(user.Username)
+	idKey := p.getUserIDKey(user.ID)
+	resp, err := p.txn([]clientv3.Cmp{cmpKeyMissing(key), cmpKeyMissing(idKey)},
+		[]clientv3.Op{clientv3.OpPut(key, string(buf)), clientv3.OpPut(idKey, user.Username)})
+	if err == errEtcdTxnFailed {
+		return fmt.Errorf("username %v already exists", user.Username)
+	}
+	if err == nil {
+		p.cache.invalidate(user.Username, resp.Header.Revision)
+	}
+	return err
+}
+
+// updateUser updates the user settings, the used quota and the last login are not modified
+func (p EtcdProvider) updateUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	err = p.modifyUser(user.Username, func(existing *User) error {
+		// the function runs again if the transaction fails, so the given user is not modified
+		u := user
+		u.ID = existing.ID
+		u.LastQuotaUpdate = existing.LastQuotaUpdate
+		u.UsedQuotaSize = existing.UsedQuotaSize
+		u.UsedQuotaFiles = existing.UsedQuotaFiles
+		u.LastLogin = existing.LastLogin
+		if err := setUserUpdateFields(&u, *existing); err != nil {
+			return err
+		}
+		*existing = u
+		return nil
+	})
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", user.Username)}
+	}
+	return err
+}
+
+func (p EtcdProvider) deleteUser(user User) error {
+	return retryEtcdTxn(func() error {
+		existing, revision, err := p.getUser(user.Username)
+		if err != nil {
+			return err
+		}
+		key := p.getUserKey(existing.Username)
+		resp, err := p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpDelete(key), clientv3.OpDelete(p.getUserIDKey(existing.ID))})
+		if err == nil {
+			p.cache.invalidate(existing.Username, resp.Header.Revision)
+		}
+		return err
+	})
+}
+
+func (p EtcdProvider) getIPListEntries() ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	err := p.iteratePrefix(p.namespace+"ip_lists/", true, func(kv *mvccpb.KeyValue) (bool, error) {
+		var entry IPListEntry
+		err := json.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return false, err
+		}
+		entries = append(entries, entry)
+		return true, nil
+	})
+	return entries, err
+}
+
+func (p EtcdProvider) getIPListEntry(ID int64) (IPListEntry, int64, error) {
+	var entry IPListEntry
+	kv, _, err := p.get(p.getIPListEntryKey(ID))
+	if err != nil {
+		return entry, 0, err
+	}
+	if kv == nil {
+		return entry, 0, &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", ID)}
+	}
+	err = json.Unmarshal(kv.Value, &entry)
+	return entry, kv.ModRevision, err
+}
+
+func (p EtcdProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	entry, _, err := p.getIPListEntry(ID)
+	return entry, err
+}
+
+func (p EtcdProvider) addIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	// the ID is assigned by the provider
+	entry.ID, err = p.nextSequence("ip_lists")
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := p.getIPListEntryKey(entry.ID)
+	idxKey := p.getIPListIdxKey(entry.IPOrNet)
+	_, err = p.txn([]clientv3.Cmp{cmpKeyMissing(key), cmpKeyMissing(idxKey)},
+		[]clientv3.Op{clientv3.OpPut(key, string(buf)), clientv3.OpPut(idxKey, strconv.FormatInt(entry.ID, 10))})
+	if err == errEtcdTxnFailed {
+		return fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+	}
+	return err
+}
+
+func (p EtcdProvider) updateIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return retryEtcdTxn(func() error {
+		existing, revision, err := p.getIPListEntry(entry.ID)
+		if err != nil {
+			return err
+		}
+		key := p.getIPListEntryKey(entry.ID)
+		idxKey := p.getIPListIdxKey(entry.IPOrNet)
+		compare := []clientv3.Cmp{cmpKeyModRevision(key, revision)}
+		ops := []clientv3.Op{clientv3.OpPut(key, string(buf))}
+		if existing.IPOrNet != entry.IPOrNet {
+			idxKV, _, err := p.get(idxKey)
+			if err != nil {
+				return err
+			}
+			if idxKV != nil {
+				return fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+			}
+			compare = append(compare, cmpKeyMissing(idxKey))
+			ops = append(ops, clientv3.OpPut(idxKey, strconv.FormatInt(entry.ID, 10)),
+				clientv3.OpDelete(p.getIPListIdxKey(existing.IPOrNet)))
+		}
+		_, err = p.txn(compare, ops)
+		return err
+	})
+}
+
+func (p EtcdProvider) deleteIPListEntry(entry IPListEntry) error {
+	return retryEtcdTxn(func() error {
+		existing, revision, err := p.getIPListEntry(entry.ID)
+		if err != nil {
+			return err
+		}
+		key := p.getIPListEntryKey(existing.ID)
+		_, err = p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpDelete(key), clientv3.OpDelete(p.getIPListIdxKey(existing.IPOrNet))})
+		return err
+	})
+}
+
+func (p EtcdProvider) getPlans() ([]Plan, error) {
+	plans := []Plan{}
+	// the plans keys are sorted by name
+	err := p.iteratePrefix(p.namespace+"plans/", true, func(kv *mvccpb.KeyValue) (bool, error) {
+		var plan Plan
+		err := json.Unmarshal(kv.Value, &plan)
+		if err != nil {
+			return false, err
+		}
+		plans = append(plans, plan)
+		return true, nil
+	})
+	return plans, err
+}
+
+func (p EtcdProvider) getPlan(name string) (Plan, int64, error) {
+	var plan Plan
+	kv, _, err := p.get(p.getPlanKey(name))
+	if err != nil {
+		return plan, 0, err
+	}
+	if kv == nil {
+		return plan, 0, &RecordNotFoundError{err: fmt.Sprintf("plan %#v does not exist", name)}
+	}
+	err = json.Unmarshal(kv.Value, &plan)
+	return plan, kv.ModRevision, err
+}
+
+func (p EtcdProvider) getPlanByID(ID int64) (Plan, error) {
+	var plan Plan
+	kv, _, err := p.get(p.getPlanIDKey(ID))
+	if err != nil {
+		return plan, err
+	}
+	if kv == nil {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+	}
+	plan, _, err = p.getPlan(string(kv.Value))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+	}
+	return plan, err
+}
+
+func (p EtcdProvider) planExists(name string) (Plan, error) {
+	plan, _, err := p.getPlan(name)
+	return plan, err
+}
+
+func (p EtcdProvider) addPlan(plan Plan) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	plan.ID, err = p.nextSequence("plans")
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	key := p.getPlanKey(plan.Name)
+	idKey := p.getPlanIDKey(plan.ID)
+	_, err = p.txn([]clientv3.Cmp{cmpKeyMissing(key), cmpKeyMissing(idKey)},
+		[]clientv3.Op{clientv3.OpPut(key, string(buf)), clientv3.OpPut(idKey, plan.Name)})
+	if err == errEtcdTxnFailed {
+		return fmt.Errorf("plan %v already exists", plan.Name)
+	}
+	return err
+}
+
+// updatePlan updates the plan and then, if applyToUsers is true, the assigned users. The number
+// of operations inside an etcd transaction is limited, so each user is updated separately: if an
+// update fails the plan update can be safely retried
+func (p EtcdProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	err = retryEtcdTxn(func() error {
+		existing, err := p.getPlanByID(plan.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Name != plan.Name {
+			return &ValidationError{err: "the plan name cannot be changed"}
+		}
+		_, revision, err := p.getPlan(plan.Name)
+		if err != nil {
+			return err
+		}
+		key := p.getPlanKey(plan.Name)
+		_, err = p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpPut(key, string(buf))})
+		return err
+	})
+	if err != nil || !applyToUsers {
+		return err
+	}
+	users, err := p.getPlanUsers(plan.Name)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		err = p.modifyUser(u.Username, func(user *User) error {
+			if user.Plan != plan.Name {
+				return nil
+			}
+			return plan.applyToUser(user)
+		})
+		if _, ok := err.(*RecordNotFoundError); ok {
+			// deleted after listing
+			continue
+		}
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to apply plan %#v to user %#v: %v", plan.Name, u.Username, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (p EtcdProvider) deletePlan(plan Plan) error {
+	existing, err := p.getPlanByID(plan.ID)
+	if err != nil {
+		return err
+	}
+	users, err := p.getPlanUsers(existing.Name)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return &ValidationError{err: fmt.Sprintf("plan %#v is assigned to %v users and it cannot be deleted",
+			existing.Name, len(users))}
+	}
+	_, err = p.txn(nil,
+		[]clientv3.Op{clientv3.OpDelete(p.getPlanKey(existing.Name)), clientv3.OpDelete(p.getPlanIDKey(existing.ID))})
+	return err
+}
+
+func (p EtcdProvider) getUserTemplates() ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	// the user templates keys are sorted by name
+	err := p.iteratePrefix(p.namespace+"user_templates/", true, func(kv *mvccpb.KeyValue) (bool, error) {
+		var template UserTemplate
+		err := json.Unmarshal(kv.Value, &template)
+		if err != nil {
+			return false, err
+		}
+		templates = append(templates, template)
+		return true, nil
+	})
+	return templates, err
+}
+
+func (p EtcdProvider) getUserTemplate(name string) (UserTemplate, int64, error) {
+	var template UserTemplate
+	kv, _, err := p.get(p.getUserTemplateKey(name))
+	if err != nil {
+		return template, 0, err
+	}
+	if kv == nil {
+		return template, 0, &RecordNotFoundError{err: fmt.Sprintf("user template %#v does not exist", name)}
+	}
+	err = json.Unmarshal(kv.Value, &template)
+	return template, kv.ModRevision, err
+}
+
+func (p EtcdProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	var template UserTemplate
+	kv, _, err := p.get(p.getUserTemplateIDKey(ID))
+	if err != nil {
+		return template, err
+	}
+	if kv == nil {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+	}
+	template, _, err = p.getUserTemplate(string(kv.Value))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+	}
+	return template, err
+}
+
+func (p EtcdProvider) userTemplateExists(name string) (UserTemplate, error) {
+	template, _, err := p.getUserTemplate(name)
+	return template, err
+}
+
+func (p EtcdProvider) addUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	template.ID, err = p.nextSequence("user_templates")
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	key := p.getUserTemplateKey(template.Name)
+	idKey := p.getUserTemplateIDKey(template.ID)
+	_, err = p.txn([]clientv3.Cmp{cmpKeyMissing(key), cmpKeyMissing(idKey)},
+		[]clientv3.Op{clientv3.OpPut(key, string(buf)), clientv3.OpPut(idKey, template.Name)})
+	if err == errEtcdTxnFailed {
+		return fmt.Errorf("user template %v already exists", template.Name)
+	}
+	return err
+}
+
+func (p EtcdProvider) updateUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return retryEtcdTxn(func() error {
+		existing, err := p.getUserTemplateByID(template.ID)
+		if err != nil {
+			return err
+		}
+		if existing.Name != template.Name {
+			return &ValidationError{err: "the user template name cannot be changed"}
+		}
+		_, revision, err := p.getUserTemplate(template.Name)
+		if err != nil {
+			return err
+		}
+		key := p.getUserTemplateKey(template.Name)
+		_, err = p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpPut(key, string(buf))})
+		return err
+	})
+}
+
+func (p EtcdProvider) deleteUserTemplate(template UserTemplate) error {
+	existing, err := p.getUserTemplateByID(template.ID)
+	if err != nil {
+		return err
+	}
+	_, err = p.txn(nil,
+		[]clientv3.Op{clientv3.OpDelete(p.getUserTemplateKey(existing.Name)), clientv3.OpDelete(p.getUserTemplateIDKey(existing.ID))})
+	return err
+}
+
+func (p EtcdProvider) getFolders() ([]Folder, error) {
+	folders := []Folder{}
+	// the folders keys are sorted by name
+	err := p.iteratePrefix(p.namespace+"folders/", true, func(kv *mvccpb.KeyValue) (bool, error) {
+		var folder Folder
+		err := json.Unmarshal(kv.Value, &folder)
+		if err != nil {
+			return false, err
+		}
+		folders = append(folders, folder)
+		return true, nil
+	})
+	return folders, err
+}
+
+func (p EtcdProvider) getFolder(name string) (Folder, int64, error) {
+	var folder Folder
+	kv, _, err := p.get(p.getFolderKey(name))
+	if err != nil {
+		return folder, 0, err
+	}
+	if kv == nil {
+		return folder, 0, &RecordNotFoundError{err: fmt.Sprintf("folder %#v does not exist", name)}
+	}
+	err = json.Unmarshal(kv.Value, &folder)
+	return folder, kv.ModRevision, err
+}
+
+func (p EtcdProvider) getFolderByID(ID int64) (Folder, error) {
+	var folder Folder
+	kv, _, err := p.get(p.getFolderIDKey(ID))
+	if err != nil {
+		return folder, err
+	}
+	if kv == nil {
+		return folder, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+	}
+	folder, _, err = p.getFolder(string(kv.Value))
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return folder, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+	}
+	return folder, err
+}
+
+func (p EtcdProvider) folderExists(name string) (Folder, error) {
+	folder, _, err := p.getFolder(name)
+	return folder, err
+}
+
+func (p EtcdProvider) addFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	folder.ID, err = p.nextSequence("folders")
+	if err != nil {
+		return err
+	}
+	folder.UsedQuotaSize = 0
+	folder.UsedQuotaFiles = 0
+	folder.LastQuotaUpdate = 0
+	buf, err := json.Marshal(folder)
+	if err != nil {
+		return err
+	}
+	key := p.getFolderKey(folder.Name)
+	idKey := p.getFolderIDKey(folder.ID)
+	_, err = p.txn([]clientv3.Cmp{cmpKeyMissing(key), cmpKeyMissing(idKey)},
+		[]clientv3.Op{clientv3.OpPut(key, string(buf)), clientv3.OpPut(idKey, folder.Name)})
+	if err == errEtcdTxnFailed {
+		return fmt.Errorf("folder %v already exists", folder.Name)
+	}
+	return err
+}
+
+func (p EtcdProvider) updateFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	existing, err := p.getFolderByID(folder.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Name != folder.Name {
+		return &ValidationError{err: "the folder name cannot be changed"}
+	}
+	return p.modifyFolder(folder.Name, func(f *Folder) error {
+		f.Description = folder.Description
+		f.MappedPath = folder.MappedPath
+		f.QuotaSize = folder.QuotaSize
+		f.QuotaFiles = folder.QuotaFiles
+		return nil
+	})
+}
+
+func (p EtcdProvider) deleteFolder(folder Folder) error {
+	existing, err := p.getFolderByID(folder.ID)
+	if err != nil {
+		return err
+	}
+	_, err = p.txn(nil,
+		[]clientv3.Op{clientv3.OpDelete(p.getFolderKey(existing.Name)), clientv3.OpDelete(p.getFolderIDKey(existing.ID))})
+	return err
+}
+
+func (p EtcdProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.modifyFolder(name, func(folder *Folder) error {
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = utils.GetTimeAsMsSinceEpoch(time.Now())
+		return nil
+	})
+}
+
+func (p EtcdProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, _, err := p.getFolder(name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for folder %v error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+// modifyFolder applies fn to the folder with the given name, the update is retried if the folder
+// is concurrently modified
+func (p EtcdProvider) modifyFolder(name string, fn func(folder *Folder) error) error {
+	return retryEtcdTxn(func() error {
+		folder, revision, err := p.getFolder(name)
+		if err != nil {
+			return err
+		}
+		if err = fn(&folder); err != nil {
+			return err
+		}
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		key := p.getFolderKey(name)
+		_, err = p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpPut(key, string(buf))})
+		return err
+	})
+}
+
+// getPlanUsers returns the users with the given plan assigned
+func (p EtcdProvider) getPlanUsers(name string) ([]User, error) {
+	var users []User
+	err := p.iteratePrefix(p.namespace+"users/", true, func(kv *mvccpb.KeyValue) (bool, error) {
+		var user User
+		err := json.Unmarshal(kv.Value, &user)
+		if err != nil {
+			return false, err
+		}
+		if user.Plan == name {
+			users = append(users, user)
+		}
+		return true, nil
+	})
+	return users, err
+}
+
+func (p EtcdProvider) dumpUsers() ([]User, error) {
+	users := []User{}
+	err := p.iteratePrefix(p.namespace+"users/", true, func(kv *mvccpb.KeyValue) (bool, error) {
+		var user User
+		err := json.Unmarshal(kv.Value, &user)
+		if err != nil {
+			return false, err
+		}
+		err = addCredentialsToUser(&user)
+		if err != nil {
+			return false, err
+		}
+		users = append(users, user)
+		return true, nil
+	})
+	return users, err
+}
+
+func (p EtcdProvider) getUserWithUsername(username string) ([]User, error) {
+	users := []User{}
+	var user User
+	user, err := p.userExists(username)
+	if err == nil {
+		users = append(users, HideUserSensitiveData(&user))
+		return users, nil
+	}
+	if _, ok := err.(*RecordNotFoundError); ok {
+		err = nil
+	}
+	return users, err
+}
+
+// getUsers returns the requested page of users. etcd has no offset, the skipped
+// users are read and discarded
+func (p EtcdProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
+	users := []User{}
+	var err error
+	if limit <= 0 {
+		return users, err
+	}
+	if len(username) > 0 {
+		if offset == 0 {
+			return p.getUserWithUsername(username)
+		}
+		return users, err
+	}
+	skipped := 0
+	err = p.iteratePrefix(p.namespace+"users/", order == "ASC", func(kv *mvccpb.KeyValue) (bool, error) {
+		if skipped < offset {
+			skipped++
+			return true, nil
+		}
+		var user User
+		err := json.Unmarshal(kv.Value, &user)
+		if err != nil {
+			return false, err
+		}
+		users = append(users, HideUserSensitiveData(&user))
+		return len(users) < limit, nil
+	})
+	return users, err
+}
+
+func (p EtcdProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return getUsersPageFromList(p, UserScope{}, req, cursor)
+}
+
+func (p EtcdProvider) close() error {
+	p.cancel()
+	return p.dbHandle.Close()
+}
+
+func (p EtcdProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase does nothing, no initilization is needed for etcd provider
+func (p EtcdProvider) initializeDatabase() error {
+	return errNoInitRequired
+}
+
+func (p EtcdProvider) migrateDatabase() error {
+	// the schema version is set at the first startup
+	key := p.getSchemaVersionKey()
+	_, err := p.txn([]clientv3.Cmp{cmpKeyMissing(key)},
+		[]clientv3.Op{clientv3.OpPut(key, strconv.Itoa(etcdDatabaseVersion))})
+	if err != nil && err != errEtcdTxnFailed {
+		return err
+	}
+	kv, _, err := p.get(key)
+	if err != nil {
+		return err
+	}
+	if kv == nil {
+		return errors.New("the etcd schema version is missing")
+	}
+	version, err := strconv.Atoi(string(kv.Value))
+	if err != nil {
+		return err
+	}
+	if version == etcdDatabaseVersion {
+		providerLog(logger.LevelDebug, "etcd database is updated, current version: %v", version)
+		return nil
+	}
+	return fmt.Errorf("Database version not handled: %v", version)
+}
+
+func (p EtcdProvider) nextSequence(name string) (int64, error) {
+	key := p.namespace + "sequences/" + name
+	var value int64
+	err := retryEtcdTxn(func() error {
+		kv, _, err := p.get(key)
+		if err != nil {
+			return err
+		}
+		var revision int64
+		value = 0
+		if kv != nil {
+			if value, err = strconv.ParseInt(string(kv.Value), 10, 64); err != nil {
+				return err
+			}
+			revision = kv.ModRevision
+		}
+		value++
+		_, err = p.txn([]clientv3.Cmp{cmpKeyModRevision(key, revision)},
+			[]clientv3.Op{clientv3.OpPut(key, strconv.FormatInt(value, 10))})
+		return err
+	})
+	return value, err
+}
+
+// get returns the key, or nil if the key does not exist, and the store revision of the read
+func (p EtcdProvider) get(key string) (*mvccpb.KeyValue, int64, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, etcdRequestTimeout)
+	defer cancel()
+
+	resp, err := p.dbHandle.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, resp.Header.Revision, nil
+	}
+	return resp.Kvs[0], resp.Header.Revision, nil
+}
+
+// getPrefix returns up to limit keys with the given prefix sorted by key. If startKey is not
+// empty the keys are returned starting from it
+func (p EtcdProvider) getPrefix(prefix, startKey string, ascending bool, limit int64) (*clientv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, etcdRequestTimeout)
+	defer cancel()
+
+	key := prefix
+	rangeEnd := clientv3.GetPrefixRangeEnd(prefix)
+	order := clientv3.SortAscend
+	if !ascending {
+		order = clientv3.SortDescend
+	}
+	if len(startKey) > 0 {
+		if ascending {
+			key = startKey
+		} else {
+			// the range end is exclusive
+			rangeEnd = startKey + "\x00"
+		}
+	}
+	return p.dbHandle.Get(ctx, key, clientv3.WithRange(rangeEnd), clientv3.WithLimit(limit),
+		clientv3.WithSort(clientv3.SortByKey, order))
+}
+
+// txn applies the given operations if all the comparisons succeed, errEtcdTxnFailed is
+// returned otherwise
+func (p EtcdProvider) txn(compare []clientv3.Cmp, ops []clientv3.Op) (*clientv3.TxnResponse, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, etcdRequestTimeout)
+	defer cancel()
+
+	resp, err := p.dbHandle.Txn(ctx).If(compare...).Then(ops...).Commit()
+	if err != nil {
+		return resp, err
+	}
+	if !resp.Succeeded {
+		return resp, errEtcdTxnFailed
+	}
+	return resp, nil
+}
+
+// iteratePrefix calls fn for each key with the given prefix, sorted by key, reading a page
+// at a time. fn returns false to stop the iteration
+func (p EtcdProvider) iteratePrefix(prefix string, ascending bool, fn func(kv *mvccpb.KeyValue) (bool, error)) error {
+	startKey := ""
+	for {
+		resp, err := p.getPrefix(prefix, startKey, ascending, etcdPageSize)
+		if err != nil {
+			return err
+		}
+		for idx, kv := range resp.Kvs {
+			if len(startKey) > 0 && idx == 0 && string(kv.Key) == startKey {
+				// the start key is included in the range, it was already returned
+				continue
+			}
+			next, err := fn(kv)
+			if err != nil || !next {
+				return err
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return nil
+		}
+		startKey = string(resp.Kvs[len(resp.Kvs)-1].Key)
+	}
+}
+
+// cmpKeyMissing returns a comparison that succeeds if the given key does not exist
+func cmpKeyMissing(key string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+}
+
+// cmpKeyModRevision returns a comparison that succeeds if the given key was not modified
+// after the given revision. A 0 revision means the key must not exist
+func cmpKeyModRevision(key string, revision int64) clientv3.Cmp {
+	if revision == 0 {
+		return cmpKeyMissing(key)
+	}
+	return clientv3.Compare(clientv3.ModRevision(key), "=", revision)
+}
+
+// retryEtcdTxn calls fn until the transaction it runs is not aborted because of a concurrent change
+func retryEtcdTxn(fn func() error) error {
+	for i := 0; i < etcdMaxTxnRetries; i++ {
+		err := fn()
+		if err != errEtcdTxnFailed {
+			return err
+		}
+	}
+	return errEtcdTooManyRetries
+}
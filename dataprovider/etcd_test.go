@@ -0,0 +1,463 @@
+package dataprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type etcdTestWatcher struct {
+	id       int64
+	key      []byte
+	rangeEnd []byte
+	events   chan *pb.WatchResponse
+}
+
+// etcdTestServer implements the subset of the etcd v3 gRPC API used by the etcd provider
+type etcdTestServer struct {
+	pb.UnimplementedKVServer
+	pb.UnimplementedWatchServer
+	pb.UnimplementedAuthServer
+	server   *grpc.Server
+	listener net.Listener
+	username string
+	password string
+	sync.Mutex
+	kvs         map[string]*mvccpb.KeyValue
+	revision    int64
+	token       int
+	watchers    []*etcdTestWatcher
+	lastWatchID int64
+	// number of transactions to fail as if a compared key was modified
+	failTxns int
+	failing  bool
+}
+
+func newEtcdTestServer(username, password string) (*etcdTestServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &etcdTestServer{
+		server:   grpc.NewServer(),
+		listener: listener,
+		username: username,
+		password: password,
+		kvs:      make(map[string]*mvccpb.KeyValue),
+		revision: 1,
+	}
+	pb.RegisterKVServer(s.server, s)
+	pb.RegisterWatchServer(s.server, s)
+	pb.RegisterAuthServer(s.server, s)
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+func (s *etcdTestServer) getEndpoint() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+func (s *etcdTestServer) close() {
+	s.server.Stop()
+}
+
+func (s *etcdTestServer) setFailing(failing bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failing = failing
+}
+
+func (s *etcdTestServer) setFailTxns(count int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failTxns = count
+}
+
+// expireToken invalidates the issued authentication token
+func (s *etcdTestServer) expireToken() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.token++
+}
+
+func (s *etcdTestServer) getToken() string {
+	return "token" + strconv.Itoa(s.token)
+}
+
+func (s *etcdTestServer) getHeader() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: s.revision}
+}
+
+// checkRequest verifies the authentication token sent with the request metadata
+func (s *etcdTestServer) checkRequest(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(rpctypes.TokenFieldNameGRPC)
+	if len(tokens) != 1 || tokens[0] != s.getToken() {
+		return rpctypes.ErrGRPCInvalidAuthToken
+	}
+	if s.failing {
+		return status.Error(codes.Internal, "injected failure")
+	}
+	return nil
+}
+
+func (s *etcdTestServer) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
+	if req.Name != s.username || req.Password != s.password {
+		return nil, rpctypes.ErrGRPCAuthFailed
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	return &pb.AuthenticateResponse{Header: s.getHeader(), Token: s.getToken()}, nil
+}
+
+func isKeyInEtcdTestRange(key, start, end []byte) bool {
+	if len(end) == 0 {
+		return bytes.Equal(key, start)
+	}
+	if bytes.Compare(key, start) < 0 {
+		return false
+	}
+	return bytes.Equal(end, []byte{0}) || bytes.Compare(key, end) < 0
+}
+
+func (s *etcdTestServer) Range(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if err := s.checkRequest(ctx); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	resp := &pb.RangeResponse{Header: s.getHeader()}
+	for key, kv := range s.kvs {
+		if isKeyInEtcdTestRange([]byte(key), req.Key, req.RangeEnd) {
+			resp.Kvs = append(resp.Kvs, kv)
+		}
+	}
+	sort.Slice(resp.Kvs, func(i, j int) bool {
+		if req.SortOrder == pb.RangeRequest_DESCEND {
+			return bytes.Compare(resp.Kvs[i].Key, resp.Kvs[j].Key) > 0
+		}
+		return bytes.Compare(resp.Kvs[i].Key, resp.Kvs[j].Key) < 0
+	})
+	if req.Limit > 0 && len(resp.Kvs) > int(req.Limit) {
+		resp.Kvs = resp.Kvs[:req.Limit]
+		resp.More = true
+	}
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+func (s *etcdTestServer) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	if err := s.checkRequest(ctx); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if s.failTxns > 0 {
+		s.failTxns--
+		return &pb.TxnResponse{Header: s.getHeader()}, nil
+	}
+	for _, cmp := range req.Compare {
+		kv, ok := s.kvs[string(cmp.Key)]
+		if !ok {
+			kv = &mvccpb.KeyValue{}
+		}
+		switch cmp.Target {
+		case pb.Compare_CREATE:
+			if kv.CreateRevision != cmp.GetCreateRevision() {
+				return &pb.TxnResponse{Header: s.getHeader()}, nil
+			}
+		case pb.Compare_MOD:
+			if kv.ModRevision != cmp.GetModRevision() {
+				return &pb.TxnResponse{Header: s.getHeader()}, nil
+			}
+		}
+	}
+	s.revision++
+	for _, op := range req.Success {
+		if put := op.GetRequestPut(); put != nil {
+			s.put(put.Key, put.Value)
+		}
+		if deleteRange := op.GetRequestDeleteRange(); deleteRange != nil {
+			s.delete(deleteRange.Key)
+		}
+	}
+	return &pb.TxnResponse{Header: s.getHeader(), Succeeded: true}, nil
+}
+
+// put stores the given key at the current revision and notifies the watchers, it must be called
+// while holding the lock
+func (s *etcdTestServer) put(key, value []byte) {
+	kv := &mvccpb.KeyValue{Key: key, CreateRevision: s.revision}
+	if existing, ok := s.kvs[string(key)]; ok {
+		kv.CreateRevision = existing.CreateRevision
+	}
+	kv.Value = value
+	kv.ModRevision = s.revision
+	s.kvs[string(key)] = kv
+	s.notify(&mvccpb.Event{Type: mvccpb.PUT, Kv: kv})
+}
+
+func (s *etcdTestServer) delete(key []byte) {
+	if _, ok := s.kvs[string(key)]; !ok {
+		return
+	}
+	delete(s.kvs, string(key))
+	s.notify(&mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: key, ModRevision: s.revision}})
+}
+
+func (s *etcdTestServer) notify(event *mvccpb.Event) {
+	for _, watcher := range s.watchers {
+		if isKeyInEtcdTestRange(event.Kv.Key, watcher.key, watcher.rangeEnd) {
+			watcher.events <- &pb.WatchResponse{
+				Header:  s.getHeader(),
+				WatchId: watcher.id,
+				Events:  []*mvccpb.Event{event},
+			}
+		}
+	}
+}
+
+// putExternal simulates a change made by another SFTPGo instance
+func (s *etcdTestServer) putExternal(key string, value []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.revision++
+	s.put([]byte(key), value)
+}
+
+func (s *etcdTestServer) addWatcher(req *pb.WatchCreateRequest, events chan *pb.WatchResponse) *etcdTestWatcher {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastWatchID++
+	watcher := &etcdTestWatcher{
+		id:       s.lastWatchID,
+		key:      req.Key,
+		rangeEnd: req.RangeEnd,
+		events:   events,
+	}
+	s.watchers = append(s.watchers, watcher)
+	events <- &pb.WatchResponse{Header: s.getHeader(), WatchId: watcher.id, Created: true}
+	return watcher
+}
+
+func (s *etcdTestServer) removeWatcher(watcher *etcdTestWatcher) {
+	s.Lock()
+	defer s.Unlock()
+
+	for idx, w := range s.watchers {
+		if w == watcher {
+			s.watchers = append(s.watchers[:idx], s.watchers[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (s *etcdTestServer) Watch(stream pb.Watch_WatchServer) error {
+	if err := s.checkRequest(stream.Context()); err != nil {
+		return err
+	}
+	requests := make(chan *pb.WatchRequest)
+	go func() {
+		defer close(requests)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+	events := make(chan *pb.WatchResponse, 100)
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return nil
+			}
+			if createReq := req.GetCreateRequest(); createReq != nil {
+				watcher := s.addWatcher(createReq, events)
+				defer s.removeWatcher(watcher)
+			}
+		case resp := <-events:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func waitForEtcdCondition(t *testing.T, description string, condition func() bool) {
+	for i := 0; i < 500; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("timeout waiting for %v", description)
+}
+
+func TestEtcdProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	server, err := newEtcdTestServer("root", "secret")
+	if err != nil {
+		t.Fatalf("unable to start the etcd test server: %v", err)
+	}
+	defer server.close()
+
+	// the first endpoint is not reachable, the second one must be used
+	config = Config{
+		Driver:           EtcdDataProviderName,
+		ConnectionString: "http://127.0.0.1:1," + server.getEndpoint(),
+		Username:         "root",
+		Password:         "wrong",
+	}
+	if err = initializeEtcdProvider(); err == nil {
+		t.Error("a wrong password must be rejected")
+	}
+	config.Password = "secret"
+	config.Name = "/test_namespace/"
+	if err = initializeEtcdProvider(); err != nil {
+		t.Fatalf("unable to initialize the etcd provider: %v", err)
+	}
+	p, ok := provider.(EtcdProvider)
+	if !ok {
+		t.Fatalf("unexpected provider %T", provider)
+	}
+	defer p.close()
+	if err = p.migrateDatabase(); err != nil {
+		t.Errorf("unable to migrate the database: %v", err)
+	}
+	kv, _, err := p.get("test_namespace/schema_version")
+	if err != nil || kv == nil || string(kv.Value) != strconv.Itoa(etcdDatabaseVersion) {
+		t.Errorf("unexpected schema version: %+v, err: %v", kv, err)
+	}
+	waitForEtcdCondition(t, "the users watch", func() bool {
+		p.cache.RLock()
+		defer p.cache.RUnlock()
+		return p.cache.enabled
+	})
+
+	testProviderUsers(t, p, dir)
+
+	// the cached user is invalidated if modified by another instance
+	user, err := p.userExists("another_user")
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if _, ok := p.cache.get(user.Username); !ok {
+		t.Error("the user must be cached")
+	}
+	user.MaxSessions = 7
+	buf, err := json.Marshal(user)
+	if err != nil {
+		t.Fatalf("unable to marshal user: %v", err)
+	}
+	server.putExternal(p.getUserKey(user.Username), buf)
+	waitForEtcdCondition(t, "the cache invalidation", func() bool {
+		u, err := p.userExists(user.Username)
+		return err == nil && u.MaxSessions == 7
+	})
+
+	if err = p.updateUserPassword(user.Username, "$2a$10$updated"); err != nil {
+		t.Errorf("unable to update password: %v", err)
+	}
+	checkRecordNotFound(t, "update password for a missing user", p.updateUserPassword("missing_user", "pwd"))
+	user, err = p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if user.Password != "$2a$10$updated" {
+		t.Errorf("unexpected password: %#v", user.Password)
+	}
+	// a new token is requested if the current one expires
+	server.expireToken()
+	if err = p.updateQuota(user.Username, 1, 1, false); err != nil {
+		t.Errorf("unable to update quota with an expired token: %v", err)
+	}
+	checkUsedQuota(t, p, user.Username, 1, 1)
+	// a failed transaction is retried
+	server.setFailTxns(2)
+	if err = p.updateQuota(user.Username, 1, 1, false); err != nil {
+		t.Errorf("unable to update quota after failed transactions: %v", err)
+	}
+	checkUsedQuota(t, p, user.Username, 2, 2)
+	server.setFailTxns(etcdMaxTxnRetries)
+	if err = p.updateQuota(user.Username, 1, 1, false); err != errEtcdTooManyRetries {
+		t.Errorf("unexpected error if the transaction always fails: %v", err)
+	}
+	server.setFailTxns(0)
+	checkUsedQuota(t, p, user.Username, 2, 2)
+	users, err := p.dumpUsers()
+	if err != nil {
+		t.Errorf("unable to dump users: %v", err)
+	} else if len(users) != 1 || users[0].Username != user.Username {
+		t.Errorf("unexpected users: %+v", users)
+	}
+
+	server.setFailing(true)
+	if err = p.checkAvailability(); err == nil {
+		t.Error("the availability check must fail")
+	}
+	if _, _, err = p.getUsedQuota(user.Username); err == nil {
+		t.Error("getting the used quota must fail")
+	}
+	if err = p.addUser(getTestUser(dir, "failing_user")); err == nil {
+		t.Error("adding a user must fail")
+	}
+	if err = p.updateQuota(user.Username, 1, 1, false); err == nil {
+		t.Error("updating the quota must fail")
+	}
+	if err = p.deleteUser(user); err == nil {
+		t.Error("deleting a user must fail")
+	}
+	if _, err = p.getUsers(10, 0, "ASC", ""); err == nil {
+		t.Error("getting the users must fail")
+	}
+	server.setFailing(false)
+	checkUsedQuota(t, p, user.Username, 2, 2)
+}
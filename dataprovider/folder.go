@@ -0,0 +1,227 @@
+package dataprovider
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Folder defines a shared folder. Multiple users can reference a shared folder inside
+// their virtual folders, the used quota is tracked once for the shared folder instead
+// of for each user
+type Folder struct {
+	// Database unique identifier
+	ID int64 `json:"id"`
+	// unique folder name, it is referenced by the users virtual folders and it cannot be changed
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// absolute filesystem path for the folder contents
+	MappedPath string `json:"mapped_path"`
+	// Maximum size allowed as bytes. 0 means unlimited
+	QuotaSize int64 `json:"quota_size"`
+	// Maximum number of files allowed. 0 means unlimited
+	QuotaFiles int `json:"quota_files"`
+	// Used quota as bytes
+	UsedQuotaSize int64 `json:"used_quota_size"`
+	// Used quota as number of files
+	UsedQuotaFiles int `json:"used_quota_files"`
+	// Last quota update as unix timestamp in milliseconds
+	LastQuotaUpdate int64 `json:"last_quota_update"`
+	// the usernames referencing the folder, this field is read only
+	Users []string `json:"users,omitempty"`
+}
+
+// HasQuotaRestrictions returns true if there is a quota restriction on number of files or size or both
+func (f *Folder) HasQuotaRestrictions() bool {
+	return f.QuotaFiles > 0 || f.QuotaSize > 0
+}
+
+// GetFolders returns all the defined shared folders, the users referencing each folder are included
+func GetFolders(p Provider) ([]Folder, error) {
+	folders, err := p.getFolders()
+	if err != nil {
+		return folders, err
+	}
+	folderUsers, err := getFoldersUsers(p)
+	if err != nil {
+		return folders, err
+	}
+	for idx := range folders {
+		folders[idx].Users = folderUsers[folders[idx].Name]
+	}
+	return folders, nil
+}
+
+// GetFolderByID returns the shared folder with the given database ID if a match is found or an error.
+// The users referencing the folder are included
+func GetFolderByID(p Provider, ID int64) (Folder, error) {
+	folder, err := p.getFolderByID(ID)
+	if err != nil {
+		return folder, err
+	}
+	folderUsers, err := getFoldersUsers(p)
+	if err != nil {
+		return folder, err
+	}
+	folder.Users = folderUsers[folder.Name]
+	return folder, nil
+}
+
+// FolderExists returns the shared folder with the given name if a match is found or an error.
+// The users referencing the folder are not included
+func FolderExists(p Provider, name string) (Folder, error) {
+	return p.folderExists(name)
+}
+
+// AddFolder adds a new shared folder.
+// ManageUsers configuration must be set to 1 to enable this method
+func AddFolder(p Provider, folder Folder) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.addFolder(folder)
+}
+
+// UpdateFolder updates an existing shared folder, the used quota is not changed.
+// The mapped path cannot be changed while some users reference the folder.
+// ManageUsers configuration must be set to 1 to enable this method
+func UpdateFolder(p Provider, folder Folder) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	existing, err := p.getFolderByID(folder.ID)
+	if err != nil {
+		return err
+	}
+	if filepath.Clean(folder.MappedPath) != existing.MappedPath {
+		folderUsers, err := getFoldersUsers(p)
+		if err != nil {
+			return err
+		}
+		if users := folderUsers[existing.Name]; len(users) > 0 {
+			return &ValidationError{err: fmt.Sprintf("the mapped path for folder %#v cannot be changed, the folder is "+
+				"referenced by %v users", existing.Name, len(users))}
+		}
+	}
+	return p.updateFolder(folder)
+}
+
+// DeleteFolder deletes an existing shared folder, a folder referenced by some users cannot be deleted.
+// ManageUsers configuration must be set to 1 to enable this method
+func DeleteFolder(p Provider, folder Folder) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	folderUsers, err := getFoldersUsers(p)
+	if err != nil {
+		return err
+	}
+	if users := folderUsers[folder.Name]; len(users) > 0 {
+		return &ValidationError{err: fmt.Sprintf("folder %#v is referenced by %v users and it cannot be deleted",
+			folder.Name, len(users))}
+	}
+	return p.deleteFolder(folder)
+}
+
+// UpdateFolderQuota updates the quota for the given shared folder adding filesAdd and sizeAdd.
+// If reset is true filesAdd and sizeAdd indicates the total files and the total size instead of the difference.
+func UpdateFolderQuota(p Provider, name string, filesAdd int, sizeAdd int64, reset bool) error {
+	if config.TrackQuota == 0 {
+		return &MethodDisabledError{err: trackQuotaDisabledError}
+	} else if config.TrackQuota == 2 && !reset {
+		folder, err := p.folderExists(name)
+		if err != nil {
+			return err
+		}
+		if !folder.HasQuotaRestrictions() {
+			return nil
+		}
+	}
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.updateFolderQuota(name, filesAdd, sizeAdd, reset)
+}
+
+// GetUsedFolderQuota returns the used quota for the given shared folder.
+// TrackQuota must be >=1 to enable this method
+func GetUsedFolderQuota(p Provider, name string) (int, int64, error) {
+	if config.TrackQuota == 0 {
+		return 0, 0, &MethodDisabledError{err: trackQuotaDisabledError}
+	}
+	return p.getUsedFolderQuota(name)
+}
+
+// getFoldersUsers returns the usernames referencing each shared folder, the folder name is the key
+func getFoldersUsers(p Provider) (map[string][]string, error) {
+	folderUsers := make(map[string][]string)
+	users, err := p.dumpUsers()
+	if err != nil {
+		return folderUsers, err
+	}
+	for _, user := range users {
+		for _, v := range user.VirtualFolders {
+			if v.IsShared() {
+				folderUsers[v.Name] = append(folderUsers[v.Name], user.Username)
+			}
+		}
+	}
+	return folderUsers, nil
+}
+
+// applyUserFolders replaces the mapped path for the virtual folders referencing a shared folder
+// with the shared folder one. The referenced shared folders must exist
+func applyUserFolders(p Provider, user *User) error {
+	for idx := range user.VirtualFolders {
+		v := &user.VirtualFolders[idx]
+		v.Name = strings.TrimSpace(v.Name)
+		if !v.IsShared() {
+			continue
+		}
+		if v.HasFilesystem() {
+			return &ValidationError{err: fmt.Sprintf("invalid virtual folder %#v: a shared folder cannot have its own filesystem",
+				v.VirtualPath)}
+		}
+		folder, err := p.folderExists(v.Name)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				return &ValidationError{err: fmt.Sprintf("folder %#v does not exist", v.Name)}
+			}
+			return err
+		}
+		v.MappedPath = folder.MappedPath
+		v.Filesystem = nil
+	}
+	return nil
+}
+
+// validateFolder validates the given shared folder, the read only fields are cleared
+func validateFolder(folder *Folder) error {
+	folder.Name = strings.TrimSpace(folder.Name)
+	if len(folder.Name) == 0 {
+		return &ValidationError{err: "name is mandatory"}
+	}
+	if len(folder.Name) > 255 {
+		return &ValidationError{err: "name is too long, max 255 characters"}
+	}
+	if !validPlanNameRegex.MatchString(folder.Name) {
+		return &ValidationError{err: fmt.Sprintf("name %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.",
+			folder.Name)}
+	}
+	if len(folder.Description) > 255 {
+		return &ValidationError{err: "description is too long, max 255 characters"}
+	}
+	if len(folder.MappedPath) == 0 {
+		return &ValidationError{err: "mapped_path is mandatory"}
+	}
+	folder.MappedPath = filepath.Clean(folder.MappedPath)
+	if !filepath.IsAbs(folder.MappedPath) {
+		return &ValidationError{err: fmt.Sprintf("mapped_path must be an absolute path, actual value: %v", folder.MappedPath)}
+	}
+	if folder.QuotaSize < 0 || folder.QuotaFiles < 0 {
+		return &ValidationError{err: "the folder quota limits cannot be negative"}
+	}
+	folder.Users = nil
+	return nil
+}
@@ -0,0 +1,147 @@
+package dataprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// hook types
+const (
+	HookTypeCommand = "command"
+	HookTypeHTTP    = "http"
+)
+
+// the hook output and the HTTP response body are truncated to this size
+const hookTestMaxResponseSize = 4096
+
+// HookTestResult defines the result for a hook executed using a synthetic event
+type HookTestResult struct {
+	// command or http
+	Type string `json:"type"`
+	// command path or notification URL
+	Hook string `json:"hook"`
+	// HTTP response status code, 0 for commands and for failed requests
+	StatusCode int `json:"status_code,omitempty"`
+	// command output or HTTP response body, truncated to 4 KB
+	Response string `json:"response,omitempty"`
+	// execution time as milliseconds
+	Elapsed int64  `json:"elapsed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// GetSyntheticUser returns a user to use inside synthetic hook events.
+// The user is never saved inside the data provider
+func GetSyntheticUser(username string) User {
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{PermAny}
+	return User{
+		Username:    username,
+		HomeDir:     filepath.Join(os.TempDir(), username),
+		Status:      1,
+		Permissions: permissions,
+	}
+}
+
+// TestAction executes the configured actions using a synthetic user event of the given type.
+// The hooks are executed synchronously and even if the event is not included in execute_on
+func TestAction(operation string, user User) ([]HookTestResult, error) {
+	if !utils.IsStringInSlice(operation, []string{operationAdd, operationUpdate, operationDelete, operationOffboard,
+		operationInactivityWarning, operationInactive}) {
+		return nil, &ValidationError{err: fmt.Sprintf("invalid user action %#v", operation)}
+	}
+	results := []HookTestResult{}
+	if len(config.Actions.Command) > 0 {
+		results = append(results, ExecuteCommandHookTest(config.Actions.Command, 15*time.Second,
+			user.getNotificationFieldsAsSlice(operation), user.getNotificationFieldsAsEnvVars(operation)))
+	}
+	if len(config.Actions.HTTPNotificationURL) > 0 {
+		notificationURL := config.Actions.HTTPNotificationURL
+		if u, err := url.Parse(notificationURL); err == nil {
+			q := u.Query()
+			q.Add("action", operation)
+			u.RawQuery = q.Encode()
+			notificationURL = u.String()
+		}
+		HideUserSensitiveData(&user)
+		userAsJSON, err := json.Marshal(user)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, ExecuteHTTPHookTest(notificationURL, userAsJSON))
+	}
+	return results, nil
+}
+
+// ExecuteCommandHookTest executes the given command and returns its combined output.
+// The command must be an absolute path as required for the real hooks
+func ExecuteCommandHookTest(command string, timeout time.Duration, args, env []string) HookTestResult {
+	result := HookTestResult{
+		Type: HookTypeCommand,
+		Hook: command,
+	}
+	if !filepath.IsAbs(command) {
+		result.Error = fmt.Sprintf("invalid command %#v, it must be an absolute path", command)
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), env...)
+	startTime := time.Now()
+	out, err := cmd.CombinedOutput()
+	result.Elapsed = time.Since(startTime).Nanoseconds() / 1000000
+	result.Response = truncateHookResponse(out)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// ExecuteHTTPHookTest posts the given JSON body to the given URL and returns the response
+func ExecuteHTTPHookTest(notificationURL string, body []byte) HookTestResult {
+	result := HookTestResult{
+		Type: HookTypeHTTP,
+		Hook: notificationURL,
+	}
+	u, err := url.Parse(notificationURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	startTime := time.Now()
+	httpClient := httpclient.GetHTTPClient()
+	resp, err := httpClient.Post(u.String(), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		result.Elapsed = time.Since(startTime).Nanoseconds() / 1000000
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, hookTestMaxResponseSize))
+	result.Elapsed = time.Since(startTime).Nanoseconds() / 1000000
+	result.StatusCode = resp.StatusCode
+	result.Response = truncateHookResponse(respBody)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func truncateHookResponse(response []byte) string {
+	if len(response) > hookTestMaxResponseSize {
+		response = response[:hookTestMaxResponseSize]
+	}
+	return string(response)
+}
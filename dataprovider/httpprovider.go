@@ -0,0 +1,545 @@
+package dataprovider
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	// HTTPProviderTimestampHeader is the header with the request time as Unix timestamp, in seconds
+	HTTPProviderTimestampHeader = "X-SFTPGo-Timestamp"
+	// HTTPProviderSignatureHeader is the header with the hex encoded HMAC-SHA256 request signature
+	HTTPProviderSignatureHeader = "X-SFTPGo-Signature"
+	// limit for the responses, the users dump can be quite large
+	httpProviderMaxResponseSize = 256 * 1048576
+)
+
+var errHTTPProviderInvalidCredentials = errors.New("Invalid credentials")
+
+// HTTPProvider auth provider that delegates any operation to an external HTTP service.
+// Each operation is a POST request to the base URL joined with the operation name, for
+// example "https://users.example.com/sftpgo/user_exists", with a JSON body
+type HTTPProvider struct {
+	baseURL string
+	secret  []byte
+}
+
+// httpProviderRequest defines the JSON body for the operations that don't send a user,
+// an IP list entry, a plan, a user template or a folder
+type httpProviderRequest struct {
+	Username       string `json:"username,omitempty"`
+	Password       string `json:"password,omitempty"`
+	PublicKey      string `json:"public_key,omitempty"`
+	ID             int64  `json:"id,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+	Offset         int    `json:"offset,omitempty"`
+	Order          string `json:"order,omitempty"`
+	UsedQuotaSize  int64  `json:"used_quota_size,omitempty"`
+	UsedQuotaFiles int    `json:"used_quota_files,omitempty"`
+	Reset          bool   `json:"reset,omitempty"`
+}
+
+// httpProviderQuota defines the response for the get_used_quota and get_used_folder_quota operations
+type httpProviderQuota struct {
+	UsedQuotaSize  int64 `json:"used_quota_size"`
+	UsedQuotaFiles int   `json:"used_quota_files"`
+}
+
+// httpProviderError defines the JSON body for the error responses, the same format used
+// by the REST API
+type httpProviderError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func initializeHTTPProvider() error {
+	logSender = fmt.Sprintf("dataprovider_%v", HTTPDataProviderName)
+	baseURL, err := getHTTPProviderBaseURL()
+	if err != nil {
+		providerLog(logger.LevelWarn, "invalid http provider configuration: %v", err)
+		return err
+	}
+	if len(config.Password) == 0 {
+		return errors.New("the password, used to sign the requests, is mandatory for the http provider")
+	}
+	p := HTTPProvider{
+		baseURL: baseURL,
+		secret:  []byte(config.Password),
+	}
+	if err = p.checkAvailability(); err != nil {
+		providerLog(logger.LevelWarn, "error connecting to the http provider %#v: %v", baseURL, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "http provider initialized, base URL: %#v", baseURL)
+	provider = p
+	return nil
+}
+
+func getHTTPProviderBaseURL() (string, error) {
+	u, err := url.Parse(config.ConnectionString)
+	if err != nil {
+		return "", fmt.Errorf("invalid http provider URL %#v: %v", config.ConnectionString, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid http provider URL %#v: unsupported scheme %#v", config.ConnectionString, u.Scheme)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// getHTTPProviderSignature returns the hex encoded HMAC-SHA256, computed using the given secret,
+// of the timestamp, the operation and the request body separated by a new line
+func getHTTPProviderSignature(secret []byte, timestamp, operation string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + operation + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyHTTPProviderRequest checks the signature for a request sent by the http provider,
+// the external services written in Go can use it to authenticate the requests. The request
+// timestamp must not differ from the current time more than maxAge
+func VerifyHTTPProviderRequest(secret []byte, operation string, header http.Header, body []byte, maxAge time.Duration) error {
+	timestamp := header.Get(HTTPProviderTimestampHeader)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp %#v", timestamp)
+	}
+	diff := time.Since(time.Unix(ts, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > maxAge {
+		return fmt.Errorf("request timestamp %#v is too old", timestamp)
+	}
+	signature := getHTTPProviderSignature(secret, timestamp, operation, body)
+	if !hmac.Equal([]byte(signature), []byte(header.Get(HTTPProviderSignatureHeader))) {
+		return errors.New("invalid request signature")
+	}
+	return nil
+}
+
+// sendRequest posts the given object as JSON to the given operation and decodes the response
+// inside result, if not nil. The error responses are converted to the data provider errors
+func (p HTTPProvider) sendRequest(operation string, object interface{}, result interface{}) error {
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.baseURL+"/"+operation, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HTTPProviderTimestampHeader, timestamp)
+	req.Header.Set(HTTPProviderSignatureHeader, getHTTPProviderSignature(p.secret, timestamp, operation, body))
+	httpClient := httpclient.GetHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpProviderMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		if result == nil || len(respBody) == 0 {
+			return nil
+		}
+		return json.Unmarshal(respBody, result)
+	case http.StatusNotFound:
+		return &RecordNotFoundError{err: getHTTPProviderErrorMessage(respBody, resp.StatusCode)}
+	case http.StatusBadRequest:
+		return &ValidationError{err: getHTTPProviderErrorMessage(respBody, resp.StatusCode)}
+	case http.StatusUnauthorized:
+		return errHTTPProviderInvalidCredentials
+	case http.StatusConflict:
+		return ErrUserVersionMismatch
+	default:
+		return fmt.Errorf("http provider error for operation %#v: %v", operation,
+			getHTTPProviderErrorMessage(respBody, resp.StatusCode))
+	}
+}
+
+func getHTTPProviderErrorMessage(body []byte, statusCode int) string {
+	var resp httpProviderError
+	if err := json.Unmarshal(body, &resp); err == nil {
+		if len(resp.Error) > 0 {
+			return resp.Error
+		}
+		if len(resp.Message) > 0 {
+			return resp.Message
+		}
+	}
+	return fmt.Sprintf("unexpected status code %v", statusCode)
+}
+
+func (p HTTPProvider) checkAvailability() error {
+	return p.sendRequest("check_availability", httpProviderRequest{}, nil)
+}
+
+func (p HTTPProvider) validateUserAndPass(username string, password string) (User, error) {
+	var user User
+	if len(password) == 0 {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	err := p.sendRequest("validate_user_password", httpProviderRequest{
+		Username: username,
+		Password: password,
+	}, &user)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, err
+	}
+	return user, checkLoginConditions(user)
+}
+
+func (p HTTPProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("Credentials cannot be null or empty")
+	}
+	key, err := ssh.ParsePublicKey(pubKey)
+	if err != nil {
+		return user, "", err
+	}
+	err = p.sendRequest("validate_user_public_key", httpProviderRequest{
+		Username:  username,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}, &user)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, "", err
+	}
+	if err = checkLoginConditions(user); err != nil {
+		return user, "", err
+	}
+	return user, ssh.FingerprintSHA256(key) + ":", nil
+}
+
+func (p HTTPProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	err := p.sendRequest("update_quota", httpProviderRequest{
+		Username:       username,
+		UsedQuotaFiles: filesAdd,
+		UsedQuotaSize:  sizeAdd,
+		Reset:          reset,
+	}, nil)
+	if err == nil {
+		providerLog(logger.LevelDebug, "quota updated for user %v, files increment: %v size increment: %v is reset? %v",
+			username, filesAdd, sizeAdd, reset)
+	} else {
+		providerLog(logger.LevelWarn, "error updating quota for user %v: %v", username, err)
+	}
+	return err
+}
+
+func (p HTTPProvider) getUsedQuota(username string) (int, int64, error) {
+	var quota httpProviderQuota
+	err := p.sendRequest("get_used_quota", httpProviderRequest{Username: username}, &quota)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, err
+	}
+	return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+}
+
+func (p HTTPProvider) updateLastLogin(username string) error {
+	err := p.sendRequest("update_last_login", httpProviderRequest{Username: username}, nil)
+	if err == nil {
+		providerLog(logger.LevelDebug, "last login updated for user %#v", username)
+	} else {
+		providerLog(logger.LevelWarn, "error updating last login for user %#v: %v", username, err)
+	}
+	return err
+}
+
+func (p HTTPProvider) updateUserPassword(username, password string) error {
+	return p.sendRequest("update_user_password", httpProviderRequest{
+		Username: username,
+		Password: password,
+	}, nil)
+}
+
+func (p HTTPProvider) userExists(username string) (User, error) {
+	var user User
+	err := p.sendRequest("user_exists", httpProviderRequest{Username: username}, &user)
+	return user, err
+}
+
+func (p HTTPProvider) getUserByID(ID int64) (User, error) {
+	var user User
+	err := p.sendRequest("get_user_by_id", httpProviderRequest{ID: ID}, &user)
+	return user, err
+}
+
+func (p HTTPProvider) addUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("add_user", user, nil)
+}
+
+func (p HTTPProvider) updateUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("update_user", user, nil)
+}
+
+func (p HTTPProvider) deleteUser(user User) error {
+	return p.sendRequest("delete_user", user, nil)
+}
+
+func (p HTTPProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
+	users := []User{}
+	if limit <= 0 {
+		return users, nil
+	}
+	err := p.sendRequest("get_users", httpProviderRequest{
+		Limit:    limit,
+		Offset:   offset,
+		Order:    order,
+		Username: username,
+	}, &users)
+	for idx := range users {
+		users[idx] = HideUserSensitiveData(&users[idx])
+	}
+	return users, err
+}
+
+func (p HTTPProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return getUsersPageFromList(p, UserScope{}, req, cursor)
+}
+
+func (p HTTPProvider) dumpUsers() ([]User, error) {
+	users := []User{}
+	err := p.sendRequest("dump_users", httpProviderRequest{}, &users)
+	if err != nil {
+		return users, err
+	}
+	for idx := range users {
+		if err = addCredentialsToUser(&users[idx]); err != nil {
+			return users, err
+		}
+	}
+	return users, nil
+}
+
+func (p HTTPProvider) getIPListEntries() ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	err := p.sendRequest("get_ip_list_entries", httpProviderRequest{}, &entries)
+	return entries, err
+}
+
+func (p HTTPProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	var entry IPListEntry
+	err := p.sendRequest("get_ip_list_entry_by_id", httpProviderRequest{ID: ID}, &entry)
+	return entry, err
+}
+
+func (p HTTPProvider) addIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	// the ID is assigned by the external service
+	entry.ID = 0
+	return p.sendRequest("add_ip_list_entry", entry, nil)
+}
+
+func (p HTTPProvider) updateIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("update_ip_list_entry", entry, nil)
+}
+
+func (p HTTPProvider) deleteIPListEntry(entry IPListEntry) error {
+	return p.sendRequest("delete_ip_list_entry", entry, nil)
+}
+
+func (p HTTPProvider) getPlans() ([]Plan, error) {
+	plans := []Plan{}
+	err := p.sendRequest("get_plans", httpProviderRequest{}, &plans)
+	return plans, err
+}
+
+func (p HTTPProvider) getPlanByID(ID int64) (Plan, error) {
+	var plan Plan
+	err := p.sendRequest("get_plan_by_id", httpProviderRequest{ID: ID}, &plan)
+	return plan, err
+}
+
+func (p HTTPProvider) planExists(name string) (Plan, error) {
+	var plan Plan
+	err := p.sendRequest("plan_exists", httpProviderRequest{Name: name}, &plan)
+	return plan, err
+}
+
+func (p HTTPProvider) addPlan(plan Plan) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("add_plan", plan, nil)
+}
+
+// updatePlan updates the plan using the update_plan operation, the external service applies
+// the plan to the assigned users. If applyToUsers is false the update_plan_only operation is
+// used and the assigned users are updated by SFTPGo
+func (p HTTPProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	if !applyToUsers {
+		return p.sendRequest("update_plan_only", plan, nil)
+	}
+	return p.sendRequest("update_plan", plan, nil)
+}
+
+func (p HTTPProvider) deletePlan(plan Plan) error {
+	return p.sendRequest("delete_plan", plan, nil)
+}
+
+func (p HTTPProvider) getUserTemplates() ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	err := p.sendRequest("get_user_templates", httpProviderRequest{}, &templates)
+	return templates, err
+}
+
+func (p HTTPProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	var template UserTemplate
+	err := p.sendRequest("get_user_template_by_id", httpProviderRequest{ID: ID}, &template)
+	return template, err
+}
+
+func (p HTTPProvider) userTemplateExists(name string) (UserTemplate, error) {
+	var template UserTemplate
+	err := p.sendRequest("user_template_exists", httpProviderRequest{Name: name}, &template)
+	return template, err
+}
+
+func (p HTTPProvider) addUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("add_user_template", template, nil)
+}
+
+func (p HTTPProvider) updateUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("update_user_template", template, nil)
+}
+
+func (p HTTPProvider) deleteUserTemplate(template UserTemplate) error {
+	return p.sendRequest("delete_user_template", template, nil)
+}
+
+func (p HTTPProvider) getFolders() ([]Folder, error) {
+	folders := []Folder{}
+	err := p.sendRequest("get_folders", httpProviderRequest{}, &folders)
+	return folders, err
+}
+
+func (p HTTPProvider) getFolderByID(ID int64) (Folder, error) {
+	var folder Folder
+	err := p.sendRequest("get_folder_by_id", httpProviderRequest{ID: ID}, &folder)
+	return folder, err
+}
+
+func (p HTTPProvider) folderExists(name string) (Folder, error) {
+	var folder Folder
+	err := p.sendRequest("folder_exists", httpProviderRequest{Name: name}, &folder)
+	return folder, err
+}
+
+func (p HTTPProvider) addFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("add_folder", folder, nil)
+}
+
+func (p HTTPProvider) updateFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	return p.sendRequest("update_folder", folder, nil)
+}
+
+func (p HTTPProvider) deleteFolder(folder Folder) error {
+	return p.sendRequest("delete_folder", folder, nil)
+}
+
+func (p HTTPProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	err := p.sendRequest("update_folder_quota", httpProviderRequest{
+		Name:           name,
+		UsedQuotaFiles: filesAdd,
+		UsedQuotaSize:  sizeAdd,
+		Reset:          reset,
+	}, nil)
+	if err == nil {
+		providerLog(logger.LevelDebug, "quota updated for folder %v, files increment: %v size increment: %v is reset? %v",
+			name, filesAdd, sizeAdd, reset)
+	} else {
+		providerLog(logger.LevelWarn, "error updating quota for folder %v: %v", name, err)
+	}
+	return err
+}
+
+func (p HTTPProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	var quota httpProviderQuota
+	err := p.sendRequest("get_used_folder_quota", httpProviderRequest{Name: name}, &quota)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for folder %v error: %v", name, err)
+		return 0, 0, err
+	}
+	return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+}
+
+func (p HTTPProvider) close() error {
+	return nil
+}
+
+func (p HTTPProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase does nothing, the external service manages its own storage
+func (p HTTPProvider) initializeDatabase() error {
+	return errNoInitRequired
+}
+
+func (p HTTPProvider) migrateDatabase() error {
+	return nil
+}
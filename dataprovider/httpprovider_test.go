@@ -0,0 +1,186 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+)
+
+// httpProviderTestService is an external users service that stores the users inside a memory provider
+type httpProviderTestService struct {
+	secret  []byte
+	backend Provider
+}
+
+func (s *httpProviderTestService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.URL.Path, "/sftpgo/")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = VerifyHTTPProviderRequest(s.secret, operation, r.Header, body, time.Minute); err != nil {
+		s.sendError(w, http.StatusForbidden, err)
+		return
+	}
+	var req httpProviderRequest
+	var user User
+	if operation == "add_user" || operation == "update_user" || operation == "delete_user" {
+		err = json.Unmarshal(body, &user)
+	} else {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err)
+		return
+	}
+	var result interface{}
+	switch operation {
+	case "check_availability":
+		err = s.backend.checkAvailability()
+	case "validate_user_password":
+		result, err = s.backend.validateUserAndPass(req.Username, req.Password)
+		if _, ok := err.(*RecordNotFoundError); err != nil && !ok {
+			s.sendError(w, http.StatusUnauthorized, err)
+			return
+		}
+	case "user_exists":
+		result, err = s.backend.userExists(req.Username)
+	case "get_user_by_id":
+		result, err = s.backend.getUserByID(req.ID)
+	case "get_users":
+		result, err = s.backend.getUsers(req.Limit, req.Offset, req.Order, req.Username)
+	case "add_user":
+		err = s.backend.addUser(user)
+	case "update_user":
+		err = s.backend.updateUser(user)
+	case "delete_user":
+		err = s.backend.deleteUser(user)
+	case "update_quota":
+		err = s.backend.updateQuota(req.Username, req.UsedQuotaFiles, req.UsedQuotaSize, req.Reset)
+	case "get_used_quota":
+		var quota httpProviderQuota
+		quota.UsedQuotaFiles, quota.UsedQuotaSize, err = s.backend.getUsedQuota(req.Username)
+		result = quota
+	case "update_last_login":
+		err = s.backend.updateLastLogin(req.Username)
+	case "update_user_password":
+		err = s.backend.updateUserPassword(req.Username, req.Password)
+	default:
+		s.sendError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if err != nil {
+		switch err.(type) {
+		case *RecordNotFoundError:
+			s.sendError(w, http.StatusNotFound, err)
+		case *ValidationError:
+			s.sendError(w, http.StatusBadRequest, err)
+		default:
+			if err == ErrUserVersionMismatch {
+				s.sendError(w, http.StatusConflict, err)
+			} else {
+				s.sendError(w, http.StatusBadRequest, err)
+			}
+		}
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *httpProviderTestService) sendError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	if err != nil {
+		json.NewEncoder(w).Encode(httpProviderError{Error: err.Error()})
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpprovider")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	httpclient.Config{Timeout: 10}.Initialize(dir)
+	config = Config{Driver: MemoryDataProviderName}
+	if err = initializeMemoryProvider(dir); err != nil {
+		t.Fatalf("unable to initialize the memory provider: %v", err)
+	}
+	service := &httpProviderTestService{
+		secret:  []byte("shared secret"),
+		backend: provider,
+	}
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	config = Config{
+		Driver:           HTTPDataProviderName,
+		ConnectionString: server.URL + "/sftpgo/",
+		Password:         "wrong secret",
+	}
+	if err = initializeHTTPProvider(); err == nil {
+		t.Error("the requests signed with a wrong secret must be rejected")
+	}
+	config.Password = ""
+	if err = initializeHTTPProvider(); err == nil {
+		t.Error("the secret is mandatory")
+	}
+	config.ConnectionString = "ftp://localhost/sftpgo"
+	config.Password = string(service.secret)
+	if err = initializeHTTPProvider(); err == nil {
+		t.Error("an unsupported URL scheme must fail")
+	}
+	config.ConnectionString = server.URL + "/sftpgo/"
+	if err = initializeHTTPProvider(); err != nil {
+		t.Fatalf("unable to initialize the http provider: %v", err)
+	}
+	p := provider
+	if _, ok := p.(HTTPProvider); !ok {
+		t.Fatalf("unexpected provider %T", p)
+	}
+
+	testProviderUsers(t, p, dir)
+	if err = p.updateUserPassword("another_user", "$2a$10$updated"); err != nil {
+		t.Errorf("unable to update password: %v", err)
+	}
+	user, err := service.backend.userExists("another_user")
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	} else if user.Password != "$2a$10$updated" {
+		t.Errorf("unexpected password: %#v", user.Password)
+	}
+	checkRecordNotFound(t, "update password for a missing user", p.updateUserPassword("missing_user", "pwd"))
+	if _, err = p.getIPListEntries(); err == nil {
+		t.Error("an unexpected status code must be returned as error")
+	}
+
+	server.Close()
+	if err = p.checkAvailability(); err == nil {
+		t.Error("the availability check must fail if the service is not reachable")
+	}
+	if _, err = p.userExists("another_user"); err == nil {
+		t.Error("getting a user must fail if the service is not reachable")
+	}
+	if err = p.updateQuota("another_user", 1, 1, false); err == nil {
+		t.Error("updating the quota must fail if the service is not reachable")
+	}
+}
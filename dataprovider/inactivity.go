@@ -0,0 +1,298 @@
+package dataprovider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported actions for the inactive users
+const (
+	InactivityActionDisable = iota
+	InactivityActionExpire
+)
+
+// actions reported for the users affected by the inactivity policy
+const (
+	InactivityReportWarn    = "warn"
+	InactivityReportDisable = "disable"
+	InactivityReportExpire  = "expire"
+)
+
+const (
+	operationInactivityWarning = "inactivity_warning"
+	operationInactive          = "inactive"
+	msPerDay                   = int64(24 * time.Hour / time.Millisecond)
+)
+
+var (
+	inactivityTicker     *time.Ticker
+	inactivityTickerDone chan bool
+	// the users already warned, the value is the last activity the warning was sent for
+	inactivityWarnings      = make(map[string]int64)
+	inactivityWarningsMutex sync.Mutex
+)
+
+// InactivityPolicy defines the policy for the users that did not log in for a configurable
+// number of days. The inactive users are disabled or expired by a periodic check
+type InactivityPolicy struct {
+	// Users without a login for this number of days are considered inactive. For the users that never
+	// logged in the creation time is used. 0 disables the policy
+	InactiveDays int `json:"inactive_days" mapstructure:"inactive_days"`
+	// The "inactivity_warning" action is executed for the users without a login for
+	// inactive_days - grace_days days. 0 means no warning
+	GraceDays int `json:"grace_days" mapstructure:"grace_days"`
+	// Action for the inactive users:
+	// 0, disable them
+	// 1, set their expiration date to the check time
+	Action int `json:"action" mapstructure:"action"`
+	// Interval between two checks as minutes, default 60
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+}
+
+// InactiveUser defines a user affected by the inactivity policy
+type InactiveUser struct {
+	Username string `json:"username"`
+	// Last login or, for the users that never logged in, creation time as unix timestamp in milliseconds
+	LastActivity int64 `json:"last_activity"`
+	// Number of whole days since the last activity
+	InactiveDays int `json:"inactive_days"`
+	// warn, disable or expire
+	Action string `json:"action"`
+	// Only for the warn action, true if the warning was already sent
+	WarningSent bool `json:"warning_sent,omitempty"`
+}
+
+// InactivityReport defines the changes the inactivity policy would apply at the generation time
+type InactivityReport struct {
+	Policy      InactivityPolicy `json:"policy"`
+	GeneratedAt int64            `json:"generated_at"`
+	Users       []InactiveUser   `json:"users"`
+}
+
+func (p *InactivityPolicy) isEnabled() bool {
+	return p.InactiveDays > 0
+}
+
+func (p *InactivityPolicy) validate() error {
+	if !p.isEnabled() {
+		return nil
+	}
+	if p.GraceDays < 0 || p.GraceDays >= p.InactiveDays {
+		return &ValidationError{err: "inactivity policy: grace_days must be greater than or equal to 0 and lower than inactive_days"}
+	}
+	if p.Action != InactivityActionDisable && p.Action != InactivityActionExpire {
+		return &ValidationError{err: "inactivity policy: invalid action, supported values: 0 disable, 1 expire"}
+	}
+	if p.CheckInterval < 0 {
+		return &ValidationError{err: "inactivity policy: check_interval cannot be negative"}
+	}
+	return nil
+}
+
+// getInactiveUser returns the user details if the policy applies to the given user, nil otherwise.
+// The disabled and the expired users are ignored
+func (p *InactivityPolicy) getInactiveUser(user *User, now int64) *InactiveUser {
+	if user.Status == 0 || (user.ExpirationDate > 0 && user.ExpirationDate <= now) {
+		return nil
+	}
+	lastActivity := user.LastLogin
+	if lastActivity <= 0 {
+		lastActivity = user.CreatedAt
+	}
+	if lastActivity <= 0 {
+		// the users created before the creation time was tracked and never logged in
+		return nil
+	}
+	inactiveDays := int((now - lastActivity) / msPerDay)
+	result := &InactiveUser{
+		Username:     user.Username,
+		LastActivity: lastActivity,
+		InactiveDays: inactiveDays,
+	}
+	switch {
+	case inactiveDays >= p.InactiveDays:
+		if p.Action == InactivityActionExpire {
+			result.Action = InactivityReportExpire
+		} else {
+			result.Action = InactivityReportDisable
+		}
+	case p.GraceDays > 0 && inactiveDays >= p.InactiveDays-p.GraceDays:
+		result.Action = InactivityReportWarn
+		result.WarningSent = isInactivityWarningSent(user.Username, lastActivity)
+	default:
+		return nil
+	}
+	return result
+}
+
+// GetInactivityPolicy returns the configured inactivity policy
+func GetInactivityPolicy() InactivityPolicy {
+	return config.InactivityPolicy
+}
+
+// GetInactivityReport returns the users the given policy would warn, disable or expire now,
+// considering only the users included in the given scope. Nothing is modified
+func GetInactivityReport(p Provider, scope UserScope, policy InactivityPolicy) (InactivityReport, error) {
+	report := InactivityReport{
+		Policy: policy,
+		Users:  []InactiveUser{},
+	}
+	if !policy.isEnabled() {
+		return report, &ValidationError{err: "the inactivity policy is disabled, inactive_days must be greater than 0"}
+	}
+	if err := policy.validate(); err != nil {
+		return report, err
+	}
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	report.GeneratedAt = now
+	err := forEachUser(p, func(user *User) {
+		if !scope.IsUserInScope(user) {
+			return
+		}
+		if inactiveUser := policy.getInactiveUser(user, now); inactiveUser != nil {
+			report.Users = append(report.Users, *inactiveUser)
+		}
+	})
+	return report, err
+}
+
+// forEachUser calls the given function for all the users, the sensitive data are hidden
+func forEachUser(p Provider, fn func(user *User)) error {
+	offset := 0
+	for {
+		users, err := GetUsers(p, scopeQueryLimit, offset, "ASC", "")
+		if err != nil {
+			return err
+		}
+		for idx := range users {
+			fn(&users[idx])
+		}
+		if len(users) < scopeQueryLimit {
+			return nil
+		}
+		offset += len(users)
+	}
+}
+
+func isInactivityWarningSent(username string, lastActivity int64) bool {
+	inactivityWarningsMutex.Lock()
+	defer inactivityWarningsMutex.Unlock()
+
+	sentFor, ok := inactivityWarnings[username]
+	return ok && sentFor == lastActivity
+}
+
+func startInactivityPolicyScheduler() {
+	stopInactivityPolicyScheduler()
+
+	if !config.InactivityPolicy.isEnabled() {
+		return
+	}
+	if config.ManageUsers == 0 {
+		providerLog(logger.LevelWarn, "the inactivity policy is ignored: %v", manageUsersDisabledError)
+		return
+	}
+	interval := config.InactivityPolicy.CheckInterval
+	if interval == 0 {
+		interval = 60
+	}
+	providerLog(logger.LevelInfo, "inactivity policy enabled, inactive days: %v, grace days: %v, action: %v, "+
+		"check interval: %v minutes", config.InactivityPolicy.InactiveDays, config.InactivityPolicy.GraceDays,
+		config.InactivityPolicy.Action, interval)
+	inactivityTicker = time.NewTicker(time.Duration(interval) * time.Minute)
+	inactivityTickerDone = make(chan bool)
+	go func(ticker *time.Ticker, done chan bool) {
+		applyInactivityPolicy(config.InactivityPolicy)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				applyInactivityPolicy(config.InactivityPolicy)
+			}
+		}
+	}(inactivityTicker, inactivityTickerDone)
+}
+
+func stopInactivityPolicyScheduler() {
+	if inactivityTicker != nil {
+		inactivityTicker.Stop()
+		inactivityTickerDone <- true
+		inactivityTicker = nil
+	}
+}
+
+// applyInactivityPolicy warns, disables or expires the inactive users.
+// A warning is sent once for each inactivity period, the sent warnings are not persisted
+// so they could be sent again after a restart
+func applyInactivityPolicy(policy InactivityPolicy) {
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	var inactiveUsers []InactiveUser
+	err := forEachUser(provider, func(user *User) {
+		if inactiveUser := policy.getInactiveUser(user, now); inactiveUser != nil {
+			inactiveUsers = append(inactiveUsers, *inactiveUser)
+		}
+	})
+	if err != nil {
+		providerLog(logger.LevelWarn, "inactivity policy, unable to get the users: %v", err)
+		return
+	}
+	warned := make(map[string]int64)
+	for _, inactiveUser := range inactiveUsers {
+		if inactiveUser.Action == InactivityReportWarn {
+			warned[inactiveUser.Username] = inactiveUser.LastActivity
+			if !inactiveUser.WarningSent {
+				providerLog(logger.LevelInfo, "inactivity policy, warning user %#v, inactive days: %v",
+					inactiveUser.Username, inactiveUser.InactiveDays)
+				go executeAction(operationInactivityWarning, User{Username: inactiveUser.Username})
+			}
+			continue
+		}
+		err = applyInactivityAction(provider, policy, inactiveUser.Username, now)
+		if err != nil {
+			providerLog(logger.LevelWarn, "inactivity policy, unable to apply action %#v to user %#v: %v",
+				inactiveUser.Action, inactiveUser.Username, err)
+		}
+	}
+	inactivityWarningsMutex.Lock()
+	inactivityWarnings = warned
+	inactivityWarningsMutex.Unlock()
+}
+
+// applyInactivityAction disables or expires the user with the given username, if it is still inactive.
+// The inactive action is executed instead of the update one
+func applyInactivityAction(p Provider, policy InactivityPolicy, username string, now int64) error {
+	// the listed users have no password, so the full user is loaded to update it
+	user, err := p.userExists(username)
+	if err != nil {
+		return err
+	}
+	inactiveUser := policy.getInactiveUser(&user, now)
+	if inactiveUser == nil || inactiveUser.Action == InactivityReportWarn {
+		// the user logged in after the check started
+		return nil
+	}
+	if err = applyUserPlan(p, &user); err != nil {
+		return err
+	}
+	switch inactiveUser.Action {
+	case InactivityReportDisable:
+		user.Status = 0
+	case InactivityReportExpire:
+		user.ExpirationDate = now
+	default:
+		return errors.New("unsupported action")
+	}
+	err = p.updateUser(user)
+	if err != nil {
+		return err
+	}
+	providerLog(logger.LevelInfo, "inactivity policy, action %#v applied to user %#v, inactive days: %v",
+		inactiveUser.Action, username, inactiveUser.InactiveDays)
+	go executeAction(operationInactive, user)
+	return nil
+}
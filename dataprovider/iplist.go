@@ -0,0 +1,487 @@
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// Supported IP list types
+const (
+	// IPListTypeSafe connections from the addresses in the safe list are always allowed
+	IPListTypeSafe = iota + 1
+	// IPListTypeBlock connections from the addresses in the block list are refused,
+	// unless they are in the safe list too
+	IPListTypeBlock
+)
+
+var ipLists ipListsCache
+
+// IPListEntry defines an IP address or a network, in CIDR notation, added to the safe
+// list or to the block list.
+// The lists are evaluated before authentication for every service: an address matching
+// an entry in the safe list is always allowed, otherwise an address matching an entry in
+// the block list is refused
+type IPListEntry struct {
+	// Database unique identifier
+	ID int64 `json:"id"`
+	// IP address, for example "192.168.1.1", or network in CIDR notation, for example "10.8.0.0/16"
+	IPOrNet string `json:"ipornet"`
+	// 1 safe list, 2 block list
+	Type int `json:"type"`
+	// optional description
+	Description string `json:"description,omitempty"`
+}
+
+// GetNetwork returns the network defined by the entry, a single IP address is
+// returned as a network with a full mask
+func (e *IPListEntry) GetNetwork() (*net.IPNet, error) {
+	if strings.Contains(e.IPOrNet, "/") {
+		_, ipNet, err := net.ParseCIDR(e.IPOrNet)
+		return ipNet, err
+	}
+	ip := net.ParseIP(e.IPOrNet)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %#v", e.IPOrNet)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// GetTypeAsString returns the list type as a string
+func (e *IPListEntry) GetTypeAsString() string {
+	switch e.Type {
+	case IPListTypeSafe:
+		return "Safe list"
+	case IPListTypeBlock:
+		return "Block list"
+	default:
+		return ""
+	}
+}
+
+type ipListsCache struct {
+	sync.RWMutex
+	safe  []*net.IPNet
+	block []*net.IPNet
+	// external block lists
+	feeds []*ipListFeedCache
+}
+
+func (c *ipListsCache) isBlocked(ip net.IP) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, n := range c.safe {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	for _, n := range c.block {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	now := time.Now()
+	for _, f := range c.feeds {
+		if f.isExpired(now) {
+			continue
+		}
+		for _, n := range f.networks {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setFeedNetworks updates the networks for the given feed after a download attempt.
+// On error the previously downloaded networks are kept until they expire
+func (c *ipListsCache) setFeedNetworks(feed IPListFeed, networks []*net.IPNet, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for _, f := range c.feeds {
+		if f.feed.URL != feed.URL {
+			continue
+		}
+		f.nextFetch = now.Add(time.Duration(feed.Interval) * time.Minute)
+		if err != nil {
+			f.lastError = err.Error()
+			if f.isExpired(now) {
+				f.networks = nil
+			}
+			return
+		}
+		f.networks = networks
+		f.lastUpdate = now
+		f.lastError = ""
+		if feed.TTL > 0 {
+			f.expiresAt = now.Add(time.Duration(feed.TTL) * time.Minute)
+		}
+		return
+	}
+}
+
+func (c *ipListsCache) load(entries []IPListEntry) {
+	var safe, block []*net.IPNet
+	for _, entry := range entries {
+		ipNet, err := entry.GetNetwork()
+		if err != nil {
+			providerLog(logger.LevelWarn, "ignoring invalid IP list entry %#v: %v", entry.IPOrNet, err)
+			continue
+		}
+		if entry.Type == IPListTypeSafe {
+			safe = append(safe, ipNet)
+		} else {
+			block = append(block, ipNet)
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.safe = safe
+	c.block = block
+}
+
+// getMatchingFeeds returns the URLs for the not expired external block lists containing the given IP address
+func (c *ipListsCache) getMatchingFeeds(ip net.IP) []string {
+	c.RLock()
+	defer c.RUnlock()
+
+	feeds := []string{}
+	now := time.Now()
+	for _, f := range c.feeds {
+		if f.isExpired(now) {
+			continue
+		}
+		for _, n := range f.networks {
+			if n.Contains(ip) {
+				feeds = append(feeds, f.feed.URL)
+				break
+			}
+		}
+	}
+	return feeds
+}
+
+// IPListCheckResult defines the IP list entries and the external block lists matching an IP address
+type IPListCheckResult struct {
+	IP string `json:"ip"`
+	// true if the connections from this address are refused
+	Blocked bool `json:"blocked"`
+	// safe list entries containing the address
+	SafeEntries []IPListEntry `json:"safe_entries"`
+	// block list entries containing the address
+	BlockEntries []IPListEntry `json:"block_entries"`
+	// URLs for the external block lists containing the address
+	Feeds []string `json:"feeds"`
+}
+
+// CheckIPAddress returns the IP list entries and the external block lists matching the given IP address
+// and if the connections from this address are refused
+func CheckIPAddress(p Provider, ip string) (IPListCheckResult, error) {
+	result := IPListCheckResult{
+		SafeEntries:  []IPListEntry{},
+		BlockEntries: []IPListEntry{},
+	}
+	parsedIP := net.ParseIP(strings.TrimSpace(ip))
+	if parsedIP == nil {
+		return result, &ValidationError{err: fmt.Sprintf("invalid IP address %#v", ip)}
+	}
+	result.IP = parsedIP.String()
+	entries, err := p.getIPListEntries()
+	if err != nil {
+		return result, err
+	}
+	for _, entry := range entries {
+		ipNet, err := entry.GetNetwork()
+		if err != nil || !ipNet.Contains(parsedIP) {
+			continue
+		}
+		if entry.Type == IPListTypeSafe {
+			result.SafeEntries = append(result.SafeEntries, entry)
+		} else {
+			result.BlockEntries = append(result.BlockEntries, entry)
+		}
+	}
+	result.Feeds = ipLists.getMatchingFeeds(parsedIP)
+	result.Blocked = len(result.SafeEntries) == 0 && (len(result.BlockEntries) > 0 || len(result.Feeds) > 0)
+	return result, nil
+}
+
+// UnblockIPAddress allows the connections from the given IP address.
+// The block list entry for this single address, if any, is removed. If the address is still
+// blocked by a network in the block list or by an external block list it is added to the safe list
+func UnblockIPAddress(p Provider, ip, description string) (IPListCheckResult, error) {
+	result, err := CheckIPAddress(p, ip)
+	if err != nil || !result.Blocked {
+		return result, err
+	}
+	for _, entry := range result.BlockEntries {
+		if entry.IPOrNet != result.IP {
+			continue
+		}
+		if err = p.deleteIPListEntry(entry); err != nil {
+			return result, err
+		}
+		providerLog(logger.LevelInfo, "IP address %#v unblocked, block list entry removed", result.IP)
+	}
+	reloadIPLists(p)
+	result, err = CheckIPAddress(p, result.IP)
+	if err != nil || !result.Blocked {
+		return result, err
+	}
+	err = AddIPListEntry(p, IPListEntry{
+		IPOrNet:     result.IP,
+		Type:        IPListTypeSafe,
+		Description: description,
+	})
+	if err != nil {
+		return result, err
+	}
+	providerLog(logger.LevelInfo, "IP address %#v unblocked, safe list entry added", result.IP)
+	return CheckIPAddress(p, result.IP)
+}
+
+// IsIPBlocked returns true if the given IP address is in the block list and not in the safe list
+func IsIPBlocked(ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	return ipLists.isBlocked(parsedIP)
+}
+
+// GetIPListEntries returns the IP list entries, filtered by type if listType is not 0
+func GetIPListEntries(p Provider, listType int) ([]IPListEntry, error) {
+	entries, err := p.getIPListEntries()
+	if err != nil || listType == 0 {
+		return entries, err
+	}
+	filtered := []IPListEntry{}
+	for _, entry := range entries {
+		if entry.Type == listType {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+// GetIPListEntryByID returns the IP list entry with the given database ID if a match is found or an error
+func GetIPListEntryByID(p Provider, ID int64) (IPListEntry, error) {
+	return p.getIPListEntryByID(ID)
+}
+
+// IPListEntryExists returns the IP list entry for the given IP address or network if a match is found or an error
+func IPListEntryExists(p Provider, ipOrNet string) (IPListEntry, error) {
+	normalized, err := normalizeIPOrNet(ipOrNet)
+	if err != nil {
+		return IPListEntry{}, &ValidationError{err: fmt.Sprintf("invalid ipornet %#v: %v", ipOrNet, err)}
+	}
+	entries, err := p.getIPListEntries()
+	if err != nil {
+		return IPListEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.IPOrNet == normalized {
+			return entry, nil
+		}
+	}
+	return IPListEntry{}, &RecordNotFoundError{err: fmt.Sprintf("IP list entry %v does not exist", normalized)}
+}
+
+// AddIPListEntry adds a new entry to the safe list or to the block list
+func AddIPListEntry(p Provider, entry IPListEntry) error {
+	err := p.addIPListEntry(entry)
+	if err == nil {
+		reloadIPLists(p)
+	}
+	return err
+}
+
+// UpdateIPListEntry updates an existing IP list entry
+func UpdateIPListEntry(p Provider, entry IPListEntry) error {
+	err := p.updateIPListEntry(entry)
+	if err == nil {
+		reloadIPLists(p)
+	}
+	return err
+}
+
+// DeleteIPListEntry deletes an existing IP list entry
+func DeleteIPListEntry(p Provider, entry IPListEntry) error {
+	err := p.deleteIPListEntry(entry)
+	if err == nil {
+		reloadIPLists(p)
+	}
+	return err
+}
+
+// reloadIPLists loads the IP lists from the data provider into the in memory cache
+// used to evaluate the incoming connections
+func reloadIPLists(p Provider) error {
+	entries, err := p.getIPListEntries()
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to load the IP lists: %v", err)
+		return err
+	}
+	ipLists.load(entries)
+	return nil
+}
+
+// normalizeIPOrNet returns the canonical representation for the given IP address or network,
+// so the same network cannot be added twice using different notations
+func normalizeIPOrNet(ipOrNet string) (string, error) {
+	entry := IPListEntry{IPOrNet: strings.TrimSpace(ipOrNet)}
+	ipNet, err := entry.GetNetwork()
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(entry.IPOrNet, "/") {
+		return ipNet.String(), nil
+	}
+	return ipNet.IP.String(), nil
+}
+
+func validateIPListEntry(entry *IPListEntry) error {
+	entry.IPOrNet = strings.TrimSpace(entry.IPOrNet)
+	if len(entry.IPOrNet) == 0 {
+		return &ValidationError{err: "ipornet is mandatory"}
+	}
+	if entry.Type != IPListTypeSafe && entry.Type != IPListTypeBlock {
+		return &ValidationError{err: fmt.Sprintf("invalid list type: %v", entry.Type)}
+	}
+	ipOrNet, err := normalizeIPOrNet(entry.IPOrNet)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("invalid ipornet %#v: %v", entry.IPOrNet, err)}
+	}
+	entry.IPOrNet = ipOrNet
+	if len(entry.Description) > 255 {
+		return &ValidationError{err: "description is too long, max 255 characters"}
+	}
+	return nil
+}
+
+// IPListSet defines a set of IP addresses and networks, in CIDR notation, of the same list type.
+// It is used to import and export the IP lists in bulk
+type IPListSet struct {
+	// 1 safe list, 2 block list
+	Type int `json:"type"`
+	// IP addresses and networks in CIDR notation
+	Entries []string `json:"entries"`
+	// optional description for the imported entries, ignored on export
+	Description string `json:"description,omitempty"`
+}
+
+// IPListImportResult defines the result for a bulk import
+type IPListImportResult struct {
+	// number of new entries
+	Added int `json:"added"`
+	// number of entries already in the safe list or in the block list,
+	// the existing entries are not modified
+	Skipped int `json:"skipped"`
+	// number of entries, of the imported type, removed since they are not included
+	// in the imported set. Entries are removed only in replace mode
+	Removed int `json:"removed"`
+}
+
+// ImportIPListSet adds the entries in the given set to the safe list or to the block list.
+// If replace is true the existing entries of the same type not included in the set are removed.
+// The whole set is validated before making any change
+func ImportIPListSet(p Provider, set IPListSet, replace bool) (IPListImportResult, error) {
+	var result IPListImportResult
+	if set.Type != IPListTypeSafe && set.Type != IPListTypeBlock {
+		return result, &ValidationError{err: fmt.Sprintf("invalid list type: %v", set.Type)}
+	}
+	if len(set.Description) > 255 {
+		return result, &ValidationError{err: "description is too long, max 255 characters"}
+	}
+	var entries []IPListEntry
+	var invalid []string
+	imported := make(map[string]bool)
+	for _, ipOrNet := range set.Entries {
+		entry := IPListEntry{
+			IPOrNet:     ipOrNet,
+			Type:        set.Type,
+			Description: set.Description,
+		}
+		if err := validateIPListEntry(&entry); err != nil {
+			if len(entry.IPOrNet) > 0 {
+				invalid = append(invalid, entry.IPOrNet)
+				continue
+			}
+			return result, err
+		}
+		if imported[entry.IPOrNet] {
+			continue
+		}
+		imported[entry.IPOrNet] = true
+		entries = append(entries, entry)
+	}
+	if len(invalid) > 0 {
+		return result, &ValidationError{err: fmt.Sprintf("invalid ipornet: %v", strings.Join(invalid, ", "))}
+	}
+	existing, err := p.getIPListEntries()
+	if err != nil {
+		return result, err
+	}
+	existingNets := make(map[string]bool)
+	for _, entry := range existing {
+		existingNets[entry.IPOrNet] = true
+	}
+	defer reloadIPLists(p)
+
+	for _, entry := range entries {
+		if existingNets[entry.IPOrNet] {
+			result.Skipped++
+			continue
+		}
+		if err = p.addIPListEntry(entry); err != nil {
+			return result, err
+		}
+		result.Added++
+	}
+	if replace {
+		for _, entry := range existing {
+			if entry.Type != set.Type || imported[entry.IPOrNet] {
+				continue
+			}
+			if err = p.deleteIPListEntry(entry); err != nil {
+				return result, err
+			}
+			result.Removed++
+		}
+	}
+	providerLog(logger.LevelInfo, "IP list set imported, type: %v, added: %v, skipped: %v, removed: %v", set.Type,
+		result.Added, result.Skipped, result.Removed)
+	return result, nil
+}
+
+// ExportIPListSet returns the entries of the given list type as a set
+func ExportIPListSet(p Provider, listType int) (IPListSet, error) {
+	set := IPListSet{
+		Type:    listType,
+		Entries: []string{},
+	}
+	if listType != IPListTypeSafe && listType != IPListTypeBlock {
+		return set, &ValidationError{err: fmt.Sprintf("invalid list type: %v", listType)}
+	}
+	entries, err := GetIPListEntries(p, listType)
+	if err != nil {
+		return set, err
+	}
+	for _, entry := range entries {
+		set.Entries = append(set.Entries, entry.IPOrNet)
+	}
+	return set, nil
+}
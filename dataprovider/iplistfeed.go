@@ -0,0 +1,250 @@
+package dataprovider
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const maxIPListFeedSize = 10485760 // 10 MB
+
+var (
+	ipListFeedsTicker     *time.Ticker
+	ipListFeedsTickerDone chan bool
+)
+
+// IPListFeed defines an external block list, for example a threat intelligence feed,
+// periodically downloaded.
+// The feed must be a text file with an IP address or a network in CIDR notation for each line.
+// Empty lines and lines starting with "#" or ";" are ignored, anything after the address
+// is ignored too, so the common feed formats can be used as they are.
+// The downloaded entries are kept in memory, they are not stored inside the data provider
+type IPListFeed struct {
+	// URL to download the feed from
+	URL string `json:"url" mapstructure:"url"`
+	// Interval between two downloads as minutes
+	Interval int `json:"interval" mapstructure:"interval"`
+	// The downloaded entries are discarded if the feed cannot be downloaded again within this
+	// number of minutes from the last successful download. 0 means they never expire
+	TTL int `json:"ttl" mapstructure:"ttl"`
+}
+
+// IPListFeedStatus defines the status for a configured external block list
+type IPListFeedStatus struct {
+	URL string `json:"url"`
+	// number of entries currently applied
+	NumEntries int `json:"entries"`
+	// last successful download as unix timestamp in milliseconds, 0 if never downloaded
+	LastUpdate int64 `json:"last_update"`
+	// expiration for the downloaded entries as unix timestamp in milliseconds,
+	// 0 means no expiration
+	ExpiresAt int64 `json:"expires_at"`
+	// error for the last download, if any
+	LastError string `json:"last_error,omitempty"`
+}
+
+// GetLastUpdateAsString returns the last successful download formatted as YYYY-MM-DD HH:MM:SS
+func (s *IPListFeedStatus) GetLastUpdateAsString() string {
+	if s.LastUpdate > 0 {
+		t := utils.GetTimeFromMsecSinceEpoch(s.LastUpdate)
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return ""
+}
+
+// GetExpiresAtAsString returns the expiration for the downloaded entries formatted as YYYY-MM-DD HH:MM:SS
+func (s *IPListFeedStatus) GetExpiresAtAsString() string {
+	if s.ExpiresAt > 0 {
+		t := utils.GetTimeFromMsecSinceEpoch(s.ExpiresAt)
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return ""
+}
+
+type ipListFeedCache struct {
+	feed       IPListFeed
+	networks   []*net.IPNet
+	nextFetch  time.Time
+	lastUpdate time.Time
+	expiresAt  time.Time
+	lastError  string
+}
+
+func (f *ipListFeedCache) isExpired(now time.Time) bool {
+	return !f.expiresAt.IsZero() && now.After(f.expiresAt)
+}
+
+func (f *ipListFeedCache) getStatus() IPListFeedStatus {
+	status := IPListFeedStatus{
+		URL:       f.feed.URL,
+		LastError: f.lastError,
+	}
+	if !f.isExpired(time.Now()) {
+		status.NumEntries = len(f.networks)
+	}
+	if !f.lastUpdate.IsZero() {
+		status.LastUpdate = utils.GetTimeAsMsSinceEpoch(f.lastUpdate)
+	}
+	if !f.expiresAt.IsZero() {
+		status.ExpiresAt = utils.GetTimeAsMsSinceEpoch(f.expiresAt)
+	}
+	return status
+}
+
+func (f *IPListFeed) validate() error {
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid IP list feed URL %#v", f.URL)
+	}
+	if f.Interval <= 0 {
+		return fmt.Errorf("invalid interval %v for the IP list feed %#v", f.Interval, f.URL)
+	}
+	if f.TTL < 0 || (f.TTL > 0 && f.TTL < f.Interval) {
+		return fmt.Errorf("invalid ttl %v for the IP list feed %#v, it must be 0 or not less than the interval",
+			f.TTL, f.URL)
+	}
+	return nil
+}
+
+func validateIPListFeeds() error {
+	urls := make(map[string]bool)
+	for idx := range config.IPListFeeds {
+		feed := &config.IPListFeeds[idx]
+		feed.URL = strings.TrimSpace(feed.URL)
+		if err := feed.validate(); err != nil {
+			return err
+		}
+		if urls[feed.URL] {
+			return fmt.Errorf("duplicated IP list feed %#v", feed.URL)
+		}
+		urls[feed.URL] = true
+	}
+	return nil
+}
+
+// GetIPListFeedsStatus returns the status for the configured external block lists
+func GetIPListFeedsStatus() []IPListFeedStatus {
+	ipLists.RLock()
+	defer ipLists.RUnlock()
+
+	status := make([]IPListFeedStatus, 0, len(ipLists.feeds))
+	for _, f := range ipLists.feeds {
+		status = append(status, f.getStatus())
+	}
+	return status
+}
+
+func startIPListFeedsScheduler() {
+	stopIPListFeedsScheduler()
+
+	ipLists.Lock()
+	ipLists.feeds = nil
+	for _, feed := range config.IPListFeeds {
+		ipLists.feeds = append(ipLists.feeds, &ipListFeedCache{feed: feed})
+	}
+	ipLists.Unlock()
+
+	if len(config.IPListFeeds) == 0 {
+		return
+	}
+	providerLog(logger.LevelInfo, "%v IP list feeds configured", len(config.IPListFeeds))
+	ipListFeedsTicker = time.NewTicker(1 * time.Minute)
+	ipListFeedsTickerDone = make(chan bool)
+	go func(ticker *time.Ticker, done chan bool) {
+		updateIPListFeeds()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				updateIPListFeeds()
+			}
+		}
+	}(ipListFeedsTicker, ipListFeedsTickerDone)
+}
+
+func stopIPListFeedsScheduler() {
+	if ipListFeedsTicker != nil {
+		ipListFeedsTicker.Stop()
+		ipListFeedsTickerDone <- true
+		ipListFeedsTicker = nil
+	}
+}
+
+// updateIPListFeeds downloads the feeds whose interval is elapsed
+func updateIPListFeeds() {
+	now := time.Now()
+	var feeds []IPListFeed
+	ipLists.RLock()
+	for _, f := range ipLists.feeds {
+		if !now.Before(f.nextFetch) {
+			feeds = append(feeds, f.feed)
+		}
+	}
+	ipLists.RUnlock()
+
+	for _, feed := range feeds {
+		networks, err := fetchIPListFeed(feed)
+		ipLists.setFeedNetworks(feed, networks, err)
+	}
+}
+
+func fetchIPListFeed(feed IPListFeed) ([]*net.IPNet, error) {
+	httpClient := httpclient.GetHTTPClient()
+	resp, err := httpClient.Get(feed.URL)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to download the IP list feed %#v: %v", feed.URL, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code %v", resp.StatusCode)
+		providerLog(logger.LevelWarn, "unable to download the IP list feed %#v: %v", feed.URL, err)
+		return nil, err
+	}
+	networks, invalid, err := parseIPListFeed(io.LimitReader(resp.Body, maxIPListFeedSize))
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to parse the IP list feed %#v: %v", feed.URL, err)
+		return nil, err
+	}
+	providerLog(logger.LevelDebug, "IP list feed %#v downloaded, entries: %v, invalid lines: %v", feed.URL,
+		len(networks), invalid)
+	return networks, nil
+}
+
+// parseIPListFeed returns the networks defined inside the given feed and the number of invalid lines
+func parseIPListFeed(r io.Reader) ([]*net.IPNet, int, error) {
+	var networks []*net.IPNet
+	invalid := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ';' || c == '#' || c == ','
+		})
+		if len(fields) == 0 {
+			invalid++
+			continue
+		}
+		entry := IPListEntry{IPOrNet: fields[0]}
+		ipNet, err := entry.GetNetwork()
+		if err != nil {
+			invalid++
+			continue
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, invalid, scanner.Err()
+}
@@ -0,0 +1,174 @@
+package dataprovider
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const ldapTimeout = 15 * time.Second
+
+func (c *LDAPConfig) isEnabled() bool {
+	return len(c.URL) > 0
+}
+
+func (c *LDAPConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid LDAP URL %#v: %v", c.URL, err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return fmt.Errorf("invalid LDAP URL %#v: unsupported scheme %#v", c.URL, u.Scheme)
+	}
+	if c.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("LDAP StartTLS cannot be used with an ldaps URL")
+	}
+	if len(c.BaseDN) == 0 {
+		return errors.New("LDAP base_dn is mandatory")
+	}
+	if strings.Count(c.SearchFilter, "%s") != 1 {
+		return fmt.Errorf("invalid LDAP search filter %#v: it must contain exactly one \"%%s\" placeholder", c.SearchFilter)
+	}
+	if len(config.ExternalAuthHook) > 0 || len(config.PreLoginHook) > 0 {
+		return errors.New("LDAP cannot be used together with the external auth hook or the pre-login hook")
+	}
+	for _, p := range c.DefaultPermissions {
+		if !utils.IsStringInSlice(p, ValidPerms) {
+			return fmt.Errorf("invalid LDAP default permission: %#v", p)
+		}
+	}
+	return nil
+}
+
+func (c *LDAPConfig) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.SkipTLSVerify,
+	}
+	conn, err := ldap.DialURL(c.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if c.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if len(c.BindDN) > 0 {
+		err = conn.Bind(c.BindDN, c.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to bind to the LDAP server: %v", err)
+	}
+	return conn, nil
+}
+
+// searchUserDN returns the DN for the entry matching the given username.
+// Exactly one entry must match
+func (c *LDAPConfig) searchUserDN(conn *ldap.Conn, username string) (string, error) {
+	request := ldap.NewSearchRequest(c.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(ldapTimeout/time.Second), false, fmt.Sprintf(c.SearchFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"}, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return "", fmt.Errorf("more than one LDAP entry found for username %#v", username)
+		}
+		return "", err
+	}
+	if len(result.Entries) != 1 {
+		return "", &RecordNotFoundError{err: fmt.Sprintf("LDAP entry for username %#v not found, entries: %v",
+			username, len(result.Entries))}
+	}
+	return result.Entries[0].DN, nil
+}
+
+// checkUserExists returns an error if the given username does not match exactly
+// one LDAP entry or if the LDAP server cannot be contacted
+func (c *LDAPConfig) checkUserExists(username string) error {
+	conn, err := c.connect()
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to connect to the LDAP server: %v", err)
+		return err
+	}
+	defer conn.Close()
+
+	_, err = c.searchUserDN(conn, username)
+	if err != nil {
+		providerLog(logger.LevelDebug, "LDAP search failed for username %#v: %v", username, err)
+		return errors.New("Invalid credentials")
+	}
+	return nil
+}
+
+// authenticate verifies the given credentials binding to the LDAP server as the matching entry
+func (c *LDAPConfig) authenticate(username, password string) error {
+	if len(password) == 0 {
+		return errors.New("Credentials cannot be null or empty")
+	}
+	conn, err := c.connect()
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to connect to the LDAP server: %v", err)
+		return err
+	}
+	defer conn.Close()
+
+	dn, err := c.searchUserDN(conn, username)
+	if err != nil {
+		providerLog(logger.LevelDebug, "LDAP search failed for username %#v: %v", username, err)
+		return errors.New("Invalid credentials")
+	}
+	if err = conn.Bind(dn, password); err != nil {
+		providerLog(logger.LevelDebug, "LDAP bind failed for username %#v, dn %#v: %v", username, dn, err)
+		return errors.New("Invalid credentials")
+	}
+	return nil
+}
+
+// doLDAPAuth verifies the password against the LDAP server and returns the matching local user.
+// If the user does not exist inside the data provider it will be added using the configured
+// default permissions, the password is only verified against the LDAP server
+func doLDAPAuth(p Provider, username, password string) (User, error) {
+	var user User
+	if err := config.LDAP.authenticate(username, password); err != nil {
+		return user, err
+	}
+	user, err := p.userExists(username)
+	if err == nil {
+		return user, nil
+	}
+	if _, ok := err.(*RecordNotFoundError); !ok {
+		return user, err
+	}
+	user = User{
+		Username:    username,
+		Password:    password,
+		Status:      1,
+		Permissions: make(map[string][]string),
+	}
+	user.Permissions["/"] = config.LDAP.DefaultPermissions
+	if err = checkUserPassword(p, &user); err == nil {
+		err = p.addUser(user)
+	}
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to add LDAP user %#v: %v", username, err)
+		return user, err
+	}
+	providerLog(logger.LevelInfo, "user %#v added after the first successful LDAP login", username)
+	return p.userExists(username)
+}
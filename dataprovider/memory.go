@@ -1,6 +1,7 @@
 package dataprovider
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +20,19 @@ var (
 	errMemoryProviderClosed = errors.New("memory provider is closed")
 )
 
+// MemoryPersistence defines how the memory provider saves its data to the file configured as
+// provider name. The saved file uses the same format as the dumpdata REST API, so it can be
+// loaded at startup or on SIGHUP. The IP list entries are saved too
+type MemoryPersistence struct {
+	// If enabled the data are saved when the provider is closed, for example at shutdown,
+	// and periodically if an interval is set. If the file does not exist the provider
+	// starts empty and the file will be created
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Interval in seconds between the periodic saves, the data are written only if modified.
+	// 0 means save on shutdown only
+	Interval int `json:"interval" mapstructure:"interval"`
+}
+
 type memoryProviderHandle struct {
 	isClosed bool
 	// slice with ordered usernames
@@ -27,9 +41,21 @@ type memoryProviderHandle struct {
 	usersIdx map[int64]string
 	// map for users, username is the key
 	users map[string]User
+	// map for IP list entries, the entry ID is the key
+	ipListEntries map[int64]IPListEntry
+	// map for plans, the plan ID is the key
+	plans map[int64]Plan
+	// map for user templates, the template ID is the key
+	userTemplates map[int64]UserTemplate
+	// map for shared folders, the folder ID is the key
+	folders map[int64]Folder
 	// configuration file to use for loading users
 	configFile string
-	lock       *sync.Mutex
+	// the data saved the last time, used to avoid unneeded writes
+	lastSavedData []byte
+	persistTicker *time.Ticker
+	persistDone   chan bool
+	lock          *sync.Mutex
 }
 
 // MemoryProvider auth provider for a memory store
@@ -48,15 +74,138 @@ func initializeMemoryProvider(basePath string) error {
 	}
 	provider = MemoryProvider{
 		dbHandle: &memoryProviderHandle{
-			isClosed:   false,
-			usernames:  []string{},
-			usersIdx:   make(map[int64]string),
-			users:      make(map[string]User),
-			configFile: configFile,
-			lock:       new(sync.Mutex),
+			isClosed:      false,
+			usernames:     []string{},
+			usersIdx:      make(map[int64]string),
+			users:         make(map[string]User),
+			ipListEntries: make(map[int64]IPListEntry),
+			plans:         make(map[int64]Plan),
+			userTemplates: make(map[int64]UserTemplate),
+			folders:       make(map[int64]Folder),
+			configFile:    configFile,
+			lock:          new(sync.Mutex),
 		},
 	}
-	return provider.reloadConfig()
+	if err := validateMemoryPersistence(configFile); err != nil {
+		return err
+	}
+	p := provider.(MemoryProvider)
+	if err := p.reloadConfig(); err != nil {
+		return err
+	}
+	if config.MemoryPersistence.Enabled {
+		reloadIPLists(p)
+	}
+	p.startPersistence()
+	return nil
+}
+
+func validateMemoryPersistence(configFile string) error {
+	if !config.MemoryPersistence.Enabled {
+		return nil
+	}
+	if len(configFile) == 0 {
+		return errors.New("the memory provider persistence requires a file name")
+	}
+	if config.MemoryPersistence.Interval < 0 {
+		return errors.New("the memory provider persistence interval cannot be negative")
+	}
+	return nil
+}
+
+func (p MemoryProvider) startPersistence() {
+	if !config.MemoryPersistence.Enabled || config.MemoryPersistence.Interval == 0 {
+		return
+	}
+	p.dbHandle.persistTicker = time.NewTicker(time.Duration(config.MemoryPersistence.Interval) * time.Second)
+	p.dbHandle.persistDone = make(chan bool)
+	go func(ticker *time.Ticker, done chan bool) {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p.saveData()
+			}
+		}
+	}(p.dbHandle.persistTicker, p.dbHandle.persistDone)
+}
+
+// saveData writes the users, the plans, the user templates, the folders and the IP list entries to the configured file,
+// if they are changed since the last save
+func (p MemoryProvider) saveData() error {
+	if !config.MemoryPersistence.Enabled {
+		return nil
+	}
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	return p.saveDataInternal()
+}
+
+func (p MemoryProvider) saveDataInternal() error {
+	dump := BackupData{
+		Users:         []User{},
+		IPListEntries: []IPListEntry{},
+	}
+	for _, username := range p.dbHandle.usernames {
+		user := p.dbHandle.users[username]
+		if err := addCredentialsToUser(&user); err != nil {
+			providerLog(logger.LevelWarn, "unable to save data to file %#v: %v", p.dbHandle.configFile, err)
+			return err
+		}
+		dump.Users = append(dump.Users, user)
+	}
+	for _, plan := range p.dbHandle.plans {
+		dump.Plans = append(dump.Plans, plan)
+	}
+	sort.Slice(dump.Plans, func(i, j int) bool {
+		return dump.Plans[i].ID < dump.Plans[j].ID
+	})
+	for _, template := range p.dbHandle.userTemplates {
+		dump.UserTemplates = append(dump.UserTemplates, template)
+	}
+	sort.Slice(dump.UserTemplates, func(i, j int) bool {
+		return dump.UserTemplates[i].ID < dump.UserTemplates[j].ID
+	})
+	for _, folder := range p.dbHandle.folders {
+		dump.Folders = append(dump.Folders, folder)
+	}
+	sort.Slice(dump.Folders, func(i, j int) bool {
+		return dump.Folders[i].ID < dump.Folders[j].ID
+	})
+	for _, entry := range p.dbHandle.ipListEntries {
+		dump.IPListEntries = append(dump.IPListEntries, entry)
+	}
+	sort.Slice(dump.IPListEntries, func(i, j int) bool {
+		return dump.IPListEntries[i].ID < dump.IPListEntries[j].ID
+	})
+	data, err := json.Marshal(dump)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to save data to file %#v: %v", p.dbHandle.configFile, err)
+		return err
+	}
+	if bytes.Equal(data, p.dbHandle.lastSavedData) {
+		return nil
+	}
+	// write to a temporary file and rename it, so a crash while saving cannot corrupt the existing data
+	tmpFile := p.dbHandle.configFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err == nil {
+		err = os.Rename(tmpFile, p.dbHandle.configFile)
+	}
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to save data to file %#v: %v", p.dbHandle.configFile, err)
+		os.Remove(tmpFile)
+		return err
+	}
+	p.dbHandle.lastSavedData = data
+	providerLog(logger.LevelDebug, "data saved to file %#v, users: %v, plans: %v, user templates: %v, folders: %v, "+
+		"IP list entries: %v", p.dbHandle.configFile, len(dump.Users), len(dump.Plans), len(dump.UserTemplates),
+		len(dump.Folders), len(dump.IPListEntries))
+	return nil
 }
 
 func (p MemoryProvider) checkAvailability() error {
@@ -74,8 +223,18 @@ func (p MemoryProvider) close() error {
 	if p.dbHandle.isClosed {
 		return errMemoryProviderClosed
 	}
+	if p.dbHandle.persistTicker != nil {
+		p.dbHandle.persistTicker.Stop()
+		// closing the channel does not block if a save is waiting for the lock
+		close(p.dbHandle.persistDone)
+		p.dbHandle.persistTicker = nil
+	}
+	var err error
+	if config.MemoryPersistence.Enabled {
+		err = p.saveDataInternal()
+	}
 	p.dbHandle.isClosed = true
-	return nil
+	return err
 }
 
 func (p MemoryProvider) validateUserAndPass(username string, password string) (User, error) {
@@ -131,6 +290,21 @@ func (p MemoryProvider) updateLastLogin(username string) error {
 	return nil
 }
 
+func (p MemoryProvider) updateUserPassword(username, password string) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	user.Password = password
+	p.dbHandle.users[user.Username] = user
+	return nil
+}
+
 func (p MemoryProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
 	p.dbHandle.lock.Lock()
 	defer p.dbHandle.lock.Unlock()
@@ -183,6 +357,7 @@ func (p MemoryProvider) addUser(user User) error {
 		return fmt.Errorf("username %v already exists", user.Username)
 	}
 	user.ID = p.getNextID()
+	setUserCreationFields(&user)
 	p.dbHandle.users[user.Username] = user
 	p.dbHandle.usersIdx[user.ID] = user.Username
 	p.dbHandle.usernames = append(p.dbHandle.usernames, user.Username)
@@ -200,10 +375,13 @@ func (p MemoryProvider) updateUser(user User) error {
 	if err != nil {
 		return err
 	}
-	_, err = p.userExistsInternal(user.Username)
+	existing, err := p.userExistsInternal(user.Username)
 	if err != nil {
 		return err
 	}
+	if err = setUserUpdateFields(&user, existing); err != nil {
+		return err
+	}
 	p.dbHandle.users[user.Username] = user
 	return nil
 }
@@ -229,6 +407,465 @@ func (p MemoryProvider) deleteUser(user User) error {
 	return nil
 }
 
+func (p MemoryProvider) getIPListEntries() ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return entries, errMemoryProviderClosed
+	}
+	for _, entry := range p.dbHandle.ipListEntries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+func (p MemoryProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return IPListEntry{}, errMemoryProviderClosed
+	}
+	if entry, ok := p.dbHandle.ipListEntries[ID]; ok {
+		return entry, nil
+	}
+	return IPListEntry{}, &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", ID)}
+}
+
+func (p MemoryProvider) addIPListEntry(entry IPListEntry) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	// the ID is assigned by the provider
+	entry.ID = 0
+	if err = p.checkIPListEntryIsUnique(entry); err != nil {
+		return err
+	}
+	entry.ID = 1
+	for id := range p.dbHandle.ipListEntries {
+		if id >= entry.ID {
+			entry.ID = id + 1
+		}
+	}
+	p.dbHandle.ipListEntries[entry.ID] = entry
+	return nil
+}
+
+func (p MemoryProvider) updateIPListEntry(entry IPListEntry) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.dbHandle.ipListEntries[entry.ID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", entry.ID)}
+	}
+	if err = p.checkIPListEntryIsUnique(entry); err != nil {
+		return err
+	}
+	p.dbHandle.ipListEntries[entry.ID] = entry
+	return nil
+}
+
+func (p MemoryProvider) deleteIPListEntry(entry IPListEntry) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.ipListEntries[entry.ID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", entry.ID)}
+	}
+	delete(p.dbHandle.ipListEntries, entry.ID)
+	return nil
+}
+
+func (p MemoryProvider) checkIPListEntryIsUnique(entry IPListEntry) error {
+	for _, existing := range p.dbHandle.ipListEntries {
+		if existing.IPOrNet == entry.IPOrNet && existing.ID != entry.ID {
+			return fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+		}
+	}
+	return nil
+}
+
+func (p MemoryProvider) getPlans() ([]Plan, error) {
+	plans := []Plan{}
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return plans, errMemoryProviderClosed
+	}
+	for _, plan := range p.dbHandle.plans {
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Name < plans[j].Name
+	})
+	return plans, nil
+}
+
+func (p MemoryProvider) getPlanByID(ID int64) (Plan, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return Plan{}, errMemoryProviderClosed
+	}
+	if plan, ok := p.dbHandle.plans[ID]; ok {
+		return plan, nil
+	}
+	return Plan{}, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+}
+
+func (p MemoryProvider) planExists(name string) (Plan, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return Plan{}, errMemoryProviderClosed
+	}
+	for _, plan := range p.dbHandle.plans {
+		if plan.Name == name {
+			return plan, nil
+		}
+	}
+	return Plan{}, &RecordNotFoundError{err: fmt.Sprintf("plan %#v does not exist", name)}
+}
+
+func (p MemoryProvider) addPlan(plan Plan) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	plan.ID = 1
+	for id, existing := range p.dbHandle.plans {
+		if existing.Name == plan.Name {
+			return fmt.Errorf("plan %v already exists", plan.Name)
+		}
+		if id >= plan.ID {
+			plan.ID = id + 1
+		}
+	}
+	p.dbHandle.plans[plan.ID] = plan
+	return nil
+}
+
+// updatePlan updates the plan and, if applyToUsers is true, all the assigned users while holding the lock
+func (p MemoryProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	existing, ok := p.dbHandle.plans[plan.ID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", plan.ID)}
+	}
+	if existing.Name != plan.Name {
+		return &ValidationError{err: "the plan name cannot be changed"}
+	}
+	if applyToUsers {
+		var users []User
+		for _, user := range p.dbHandle.users {
+			users = append(users, user.getACopy())
+		}
+		users, err = applyPlanToUsers(plan, users)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			p.dbHandle.users[user.Username] = user
+		}
+	}
+	p.dbHandle.plans[plan.ID] = plan
+	return nil
+}
+
+func (p MemoryProvider) deletePlan(plan Plan) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.plans[plan.ID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", plan.ID)}
+	}
+	numUsers := 0
+	for _, user := range p.dbHandle.users {
+		if user.Plan == plan.Name {
+			numUsers++
+		}
+	}
+	if numUsers > 0 {
+		return &ValidationError{err: fmt.Sprintf("plan %#v is assigned to %v users and it cannot be deleted", plan.Name, numUsers)}
+	}
+	delete(p.dbHandle.plans, plan.ID)
+	return nil
+}
+
+func (p MemoryProvider) getUserTemplates() ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return templates, errMemoryProviderClosed
+	}
+	for _, template := range p.dbHandle.userTemplates {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+func (p MemoryProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return UserTemplate{}, errMemoryProviderClosed
+	}
+	if template, ok := p.dbHandle.userTemplates[ID]; ok {
+		return template, nil
+	}
+	return UserTemplate{}, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+}
+
+func (p MemoryProvider) userTemplateExists(name string) (UserTemplate, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return UserTemplate{}, errMemoryProviderClosed
+	}
+	for _, template := range p.dbHandle.userTemplates {
+		if template.Name == name {
+			return template, nil
+		}
+	}
+	return UserTemplate{}, &RecordNotFoundError{err: fmt.Sprintf("user template %#v does not exist", name)}
+}
+
+func (p MemoryProvider) addUserTemplate(template UserTemplate) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	template.ID = 1
+	for id, existing := range p.dbHandle.userTemplates {
+		if existing.Name == template.Name {
+			return fmt.Errorf("user template %v already exists", template.Name)
+		}
+		if id >= template.ID {
+			template.ID = id + 1
+		}
+	}
+	p.dbHandle.userTemplates[template.ID] = template
+	return nil
+}
+
+func (p MemoryProvider) updateUserTemplate(template UserTemplate) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	existing, ok := p.dbHandle.userTemplates[template.ID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", template.ID)}
+	}
+	if existing.Name != template.Name {
+		return &ValidationError{err: "the user template name cannot be changed"}
+	}
+	p.dbHandle.userTemplates[template.ID] = template
+	return nil
+}
+
+func (p MemoryProvider) deleteUserTemplate(template UserTemplate) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.userTemplates[template.ID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", template.ID)}
+	}
+	delete(p.dbHandle.userTemplates, template.ID)
+	return nil
+}
+
+func (p MemoryProvider) getFolders() ([]Folder, error) {
+	folders := []Folder{}
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return folders, errMemoryProviderClosed
+	}
+	for _, folder := range p.dbHandle.folders {
+		folders = append(folders, folder)
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders, nil
+}
+
+func (p MemoryProvider) getFolderByID(ID int64) (Folder, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return Folder{}, errMemoryProviderClosed
+	}
+	if folder, ok := p.dbHandle.folders[ID]; ok {
+		return folder, nil
+	}
+	return Folder{}, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+}
+
+func (p MemoryProvider) folderExists(name string) (Folder, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return Folder{}, errMemoryProviderClosed
+	}
+	return p.folderExistsInternal(name)
+}
+
+func (p MemoryProvider) folderExistsInternal(name string) (Folder, error) {
+	for _, folder := range p.dbHandle.folders {
+		if folder.Name == name {
+			return folder, nil
+		}
+	}
+	return Folder{}, &RecordNotFoundError{err: fmt.Sprintf("folder %#v does not exist", name)}
+}
+
+func (p MemoryProvider) addFolder(folder Folder) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	folder.ID = 1
+	for id, existing := range p.dbHandle.folders {
+		if existing.Name == folder.Name {
+			return fmt.Errorf("folder %v already exists", folder.Name)
+		}
+		if id >= folder.ID {
+			folder.ID = id + 1
+		}
+	}
+	p.dbHandle.folders[folder.ID] = folder
+	return nil
+}
+
+func (p MemoryProvider) updateFolder(folder Folder) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	existing, ok := p.dbHandle.folders[folder.ID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", folder.ID)}
+	}
+	if existing.Name != folder.Name {
+		return &ValidationError{err: "the folder name cannot be changed"}
+	}
+	folder.UsedQuotaSize = existing.UsedQuotaSize
+	folder.UsedQuotaFiles = existing.UsedQuotaFiles
+	folder.LastQuotaUpdate = existing.LastQuotaUpdate
+	p.dbHandle.folders[folder.ID] = folder
+	return nil
+}
+
+func (p MemoryProvider) deleteFolder(folder Folder) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.folders[folder.ID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", folder.ID)}
+	}
+	delete(p.dbHandle.folders, folder.ID)
+	return nil
+}
+
+func (p MemoryProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	folder, err := p.folderExistsInternal(name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to update quota for folder %v error: %v", name, err)
+		return err
+	}
+	if reset {
+		folder.UsedQuotaSize = sizeAdd
+		folder.UsedQuotaFiles = filesAdd
+	} else {
+		folder.UsedQuotaSize += sizeAdd
+		folder.UsedQuotaFiles += filesAdd
+	}
+	folder.LastQuotaUpdate = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.folders[folder.ID] = folder
+	return nil
+}
+
+func (p MemoryProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	if p.dbHandle.isClosed {
+		return 0, 0, errMemoryProviderClosed
+	}
+	folder, err := p.folderExistsInternal(name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for folder %v error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
 func (p MemoryProvider) dumpUsers() ([]User, error) {
 	users := []User{}
 	var err error
@@ -298,6 +935,10 @@ func (p MemoryProvider) getUsers(limit int, offset int, order string, username s
 	return users, err
 }
 
+func (p MemoryProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return getUsersPageFromList(p, UserScope{}, req, cursor)
+}
+
 func (p MemoryProvider) userExists(username string) (User, error) {
 	p.dbHandle.lock.Lock()
 	defer p.dbHandle.lock.Unlock()
@@ -332,6 +973,30 @@ func (p MemoryProvider) clearUsers() {
 	p.dbHandle.users = make(map[string]User)
 }
 
+func (p MemoryProvider) clearIPListEntries() {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	p.dbHandle.ipListEntries = make(map[int64]IPListEntry)
+}
+
+func (p MemoryProvider) clearPlans() {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	p.dbHandle.plans = make(map[int64]Plan)
+}
+
+func (p MemoryProvider) clearUserTemplates() {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	p.dbHandle.userTemplates = make(map[int64]UserTemplate)
+}
+
+func (p MemoryProvider) clearFolders() {
+	p.dbHandle.lock.Lock()
+	defer p.dbHandle.lock.Unlock()
+	p.dbHandle.folders = make(map[int64]Folder)
+}
+
 func (p MemoryProvider) reloadConfig() error {
 	if len(p.dbHandle.configFile) == 0 {
 		providerLog(logger.LevelDebug, "no users configuration file defined")
@@ -339,6 +1004,10 @@ func (p MemoryProvider) reloadConfig() error {
 	}
 	providerLog(logger.LevelDebug, "loading users from file: %#v", p.dbHandle.configFile)
 	fi, err := os.Stat(p.dbHandle.configFile)
+	if err != nil && os.IsNotExist(err) && config.MemoryPersistence.Enabled {
+		providerLog(logger.LevelInfo, "users file %#v does not exist, it will be created on save", p.dbHandle.configFile)
+		return nil
+	}
 	if err != nil {
 		providerLog(logger.LevelWarn, "error loading users: %v", err)
 		return err
@@ -364,6 +1033,46 @@ func (p MemoryProvider) reloadConfig() error {
 		providerLog(logger.LevelWarn, "error loading users: %v", err)
 		return err
 	}
+	p.clearPlans()
+	for _, plan := range dump.Plans {
+		err = p.addPlan(plan)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error adding plan %#v: %v", plan.Name, err)
+			return err
+		}
+	}
+	p.clearUserTemplates()
+	for _, template := range dump.UserTemplates {
+		err = p.addUserTemplate(template)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error adding user template %#v: %v", template.Name, err)
+			return err
+		}
+	}
+	p.clearFolders()
+	for _, folder := range dump.Folders {
+		// the persisted file is the only source for the folders quota usage
+		if !config.MemoryPersistence.Enabled {
+			folder.UsedQuotaSize = 0
+			folder.UsedQuotaFiles = 0
+			folder.LastQuotaUpdate = 0
+		}
+		err = p.addFolder(folder)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error adding folder %#v: %v", folder.Name, err)
+			return err
+		}
+	}
+	if config.MemoryPersistence.Enabled {
+		p.clearIPListEntries()
+		for _, entry := range dump.IPListEntries {
+			err = p.addIPListEntry(entry)
+			if err != nil {
+				providerLog(logger.LevelWarn, "error adding IP list entry %#v: %v", entry.IPOrNet, err)
+				return err
+			}
+		}
+	}
 	p.clearUsers()
 	for _, user := range dump.Users {
 		u, err := p.userExists(user.Username)
@@ -372,15 +1081,19 @@ func (p MemoryProvider) reloadConfig() error {
 			user.LastLogin = u.LastLogin
 			user.UsedQuotaSize = u.UsedQuotaSize
 			user.UsedQuotaFiles = u.UsedQuotaFiles
+			user.Version = 0
 			err = p.updateUser(user)
 			if err != nil {
 				providerLog(logger.LevelWarn, "error updating user %#v: %v", user.Username, err)
 				return err
 			}
 		} else {
-			user.LastLogin = 0
-			user.UsedQuotaSize = 0
-			user.UsedQuotaFiles = 0
+			// the persisted file is the only source for quota usage and last login
+			if !config.MemoryPersistence.Enabled {
+				user.LastLogin = 0
+				user.UsedQuotaSize = 0
+				user.UsedQuotaFiles = 0
+			}
 			err = p.addUser(user)
 			if err != nil {
 				providerLog(logger.LevelWarn, "error adding user %#v: %v", user.Username, err)
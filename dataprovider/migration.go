@@ -0,0 +1,927 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// these user fields are updated by each provider using its own clock or sequence,
+// so they are ignored while comparing the users
+// the timestamps and the version are managed by each provider
+var migrationIgnoredUserFields = []string{"id", "last_login", "last_quota_update", "created_at", "updated_at", "version"}
+
+var migrationIgnoredFolderFields = []string{"id", "last_quota_update", "users"}
+
+// MigrationTarget defines a second data provider, with a different driver or database, to migrate
+// the users, the plans, the user templates, the folders and the IP list entries to. While the migration target is configured, the
+// reads are served by the data provider in use and any change is written to both, so the migration
+// target can replace the data provider in use, without downtime, once the migration report is consistent.
+// The users table name and all the other data provider settings are shared with the data provider in use
+type MigrationTarget struct {
+	// Driver name, must be one of the SupportedProviders. Leave empty to disable the migration mode
+	Driver string `json:"driver" mapstructure:"driver"`
+	// Database name. For driver sqlite this can be the database name relative to the config dir
+	// or the absolute path to the SQLite database.
+	Name string `json:"name" mapstructure:"name"`
+	// Database host
+	Host string `json:"host" mapstructure:"host"`
+	// Database port
+	Port int `json:"port" mapstructure:"port"`
+	// Database username
+	Username string `json:"username" mapstructure:"username"`
+	// Database password
+	Password string `json:"password" mapstructure:"password"`
+	// SSL mode, the allowed values are the same as for the data provider in use
+	SSLMode int `json:"sslmode" mapstructure:"sslmode"`
+	// TLS certificates for drivers mysql and postgresql, the paths can be absolute or relative
+	// to the config dir
+	RootCert   string `json:"root_cert" mapstructure:"root_cert"`
+	ClientCert string `json:"client_cert" mapstructure:"client_cert"`
+	ClientKey  string `json:"client_key" mapstructure:"client_key"`
+	// SQLCipher passphrase file for driver sqlite, the path can be absolute or relative to the config dir
+	SQLiteKeyFile string `json:"sqlite_key_file" mapstructure:"sqlite_key_file"`
+	// Custom database connection string
+	ConnectionString string `json:"connection_string" mapstructure:"connection_string"`
+	// Maximum number of open connections for mysql and postgresql driver. 0 means unlimited
+	PoolSize int `json:"pool_size" mapstructure:"pool_size"`
+}
+
+func (t *MigrationTarget) isEnabled() bool {
+	return len(t.Driver) > 0
+}
+
+func (t *MigrationTarget) validate() error {
+	if !t.isEnabled() {
+		return nil
+	}
+	if !utils.IsStringInSlice(t.Driver, SupportedProviders) {
+		return fmt.Errorf("migration target: unsupported data provider: %v", t.Driver)
+	}
+	if t.Driver == config.Driver && t.Name == config.Name && t.Host == config.Host && t.Port == config.Port &&
+		t.ConnectionString == config.ConnectionString {
+		return errors.New("migration target: the data provider in use cannot be the migration target")
+	}
+	// the SQL queries are shared, so the placeholders must be the same
+	if isSQLDriver(t.Driver) && isSQLDriver(config.Driver) &&
+		(t.Driver == PGSQLDataProviderName) != (config.Driver == PGSQLDataProviderName) {
+		return fmt.Errorf("migration target: migrating from %v to %v is not supported, use bolt as intermediate step",
+			config.Driver, t.Driver)
+	}
+	return nil
+}
+
+// applyTo returns a copy of the given configuration using the migration target database
+func (t *MigrationTarget) applyTo(c Config) Config {
+	c.Driver = t.Driver
+	c.Name = t.Name
+	c.Host = t.Host
+	c.Port = t.Port
+	c.Username = t.Username
+	c.Password = t.Password
+	c.SSLMode = t.SSLMode
+	c.RootCert = t.RootCert
+	c.ClientCert = t.ClientCert
+	c.ClientKey = t.ClientKey
+	c.SQLiteKeyFile = t.SQLiteKeyFile
+	c.ConnectionString = t.ConnectionString
+	c.PoolSize = t.PoolSize
+	// the read replica is configured for the database in use only
+	c.ReadReplicaConnectionString = ""
+	return c
+}
+
+func isSQLDriver(driver string) bool {
+	return driver == SQLiteDataProviderName || driver == PGSQLDataProviderName || driver == MySQLDataProviderName
+}
+
+// MigrationObjectsReport compares the objects of a given type stored inside the data provider
+// in use and inside the migration target
+type MigrationObjectsReport struct {
+	// number of objects inside the data provider in use
+	Source int `json:"source"`
+	// number of objects inside the migration target
+	Target int `json:"target"`
+	// objects stored only inside the data provider in use
+	MissingInTarget []string `json:"missing_in_target,omitempty"`
+	// objects stored only inside the migration target
+	MissingInSource []string `json:"missing_in_source,omitempty"`
+	// objects with different contents
+	Different []string `json:"different,omitempty"`
+}
+
+// IsConsistent returns true if the objects are the same inside both data providers
+func (r *MigrationObjectsReport) IsConsistent() bool {
+	return len(r.MissingInTarget) == 0 && len(r.MissingInSource) == 0 && len(r.Different) == 0
+}
+
+// MigrationReport defines the consistency report between the data provider in use and the migration target
+type MigrationReport struct {
+	SourceDriver string `json:"source_driver"`
+	TargetDriver string `json:"target_driver"`
+	// true if users, plans, user templates, folders and IP list entries are the same inside both data providers
+	Consistent bool `json:"consistent"`
+	// number of changes that cannot be written to the migration target since the startup
+	FailedWrites  int64                  `json:"failed_writes"`
+	Users         MigrationObjectsReport `json:"users"`
+	Plans         MigrationObjectsReport `json:"plans"`
+	UserTemplates MigrationObjectsReport `json:"user_templates"`
+	Folders       MigrationObjectsReport `json:"folders"`
+	IPListEntries MigrationObjectsReport `json:"ip_list_entries"`
+}
+
+// dualWriteProvider serves the reads using the source provider and writes any change to both
+// the source and the target provider. The source provider is the reference: an error writing
+// to the target provider is logged and counted but it is not returned to the caller
+type dualWriteProvider struct {
+	// accessed atomically, keep it as first field for the 64 bit alignment
+	failedWrites int64
+	source       Provider
+	target       Provider
+}
+
+// GetMigrationReport compares the data stored inside the data provider in use and inside the
+// migration target. An error is returned if the migration target is not configured
+func GetMigrationReport(p Provider) (MigrationReport, error) {
+	d, ok := p.(*dualWriteProvider)
+	if !ok {
+		return MigrationReport{}, &MethodDisabledError{err: "the migration target is not configured"}
+	}
+	return d.getReport()
+}
+
+// SyncMigrationTarget copies the users, the plans, the user templates, the folders and the IP list entries missing or different
+// inside the migration target and removes the ones that don't exist inside the data provider in use.
+// The returned report is built after the synchronization
+func SyncMigrationTarget(p Provider) (MigrationReport, error) {
+	d, ok := p.(*dualWriteProvider)
+	if !ok {
+		return MigrationReport{}, &MethodDisabledError{err: "the migration target is not configured"}
+	}
+	if err := d.sync(); err != nil {
+		return MigrationReport{}, err
+	}
+	return d.getReport()
+}
+
+// createMigrationTarget initializes the configured migration target and wraps the provider in use
+// with a dual write provider. The initialization functions use the package level configuration and
+// provider, so they are temporarily replaced
+func createMigrationTarget(basePath string) error {
+	source := provider
+	sourceConfig := config
+	defer func() {
+		config = sourceConfig
+	}()
+	config = sourceConfig.MigrationTarget.applyTo(sourceConfig)
+	err := createProvider(basePath)
+	if err != nil {
+		provider = source
+		return fmt.Errorf("migration target: %v", err)
+	}
+	// the bolt migrations update the users using the package level provider
+	target := provider
+	err = target.migrateDatabase()
+	provider = source
+	if err != nil {
+		target.close()
+		return fmt.Errorf("migration target: database migration error: %v", err)
+	}
+	providerLog(logger.LevelInfo, "migration target initialized, driver: %v, the changes to the %v data provider "+
+		"will be written to the migration target too", config.Driver, sourceConfig.Driver)
+	provider = &dualWriteProvider{
+		source: source,
+		target: target,
+	}
+	return nil
+}
+
+// initializeMigrationTargetDatabase creates the database structure for the migration target
+func initializeMigrationTargetDatabase(basePath string) error {
+	source := provider
+	sourceConfig := config
+	defer func() {
+		config = sourceConfig
+		provider = source
+	}()
+	config = sourceConfig.MigrationTarget.applyTo(sourceConfig)
+	if config.Driver == BoltDataProviderName || config.Driver == MemoryDataProviderName ||
+		config.Driver == RedisDataProviderName || config.Driver == HTTPDataProviderName ||
+		config.Driver == EtcdDataProviderName {
+		return errNoInitRequired
+	}
+	err := createProvider(basePath)
+	if err != nil {
+		return fmt.Errorf("migration target: %v", err)
+	}
+	defer provider.close()
+	return provider.initializeDatabase()
+}
+
+func (p *dualWriteProvider) mirror(operation, object string, err error) {
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&p.failedWrites, 1)
+	providerLog(logger.LevelWarn, "migration target: unable to %v %#v: %v", operation, object, err)
+}
+
+func (p *dualWriteProvider) validateUserAndPass(username string, password string) (User, error) {
+	return p.source.validateUserAndPass(username, password)
+}
+
+func (p *dualWriteProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	return p.source.validateUserAndPubKey(username, pubKey)
+}
+
+func (p *dualWriteProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	err := p.source.updateQuota(username, filesAdd, sizeAdd, reset)
+	if err == nil {
+		p.mirror("update quota for user", username, p.target.updateQuota(username, filesAdd, sizeAdd, reset))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getUsedQuota(username string) (int, int64, error) {
+	return p.source.getUsedQuota(username)
+}
+
+func (p *dualWriteProvider) userExists(username string) (User, error) {
+	return p.source.userExists(username)
+}
+
+func (p *dualWriteProvider) addUser(user User) error {
+	err := p.source.addUser(user)
+	if err == nil {
+		p.mirror("add user", user.Username, p.mirrorUser(user.Username))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updateUser(user User) error {
+	err := p.source.updateUser(user)
+	if err == nil {
+		p.mirror("update user", user.Username, p.mirrorUser(user.Username))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) deleteUser(user User) error {
+	err := p.source.deleteUser(user)
+	if err == nil {
+		p.mirror("delete user", user.Username, p.deleteTargetUser(user.Username))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
+	return p.source.getUsers(limit, offset, order, username)
+}
+
+func (p *dualWriteProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return p.source.getUsersPage(req, cursor)
+}
+
+func (p *dualWriteProvider) dumpUsers() ([]User, error) {
+	return p.source.dumpUsers()
+}
+
+func (p *dualWriteProvider) getUserByID(ID int64) (User, error) {
+	return p.source.getUserByID(ID)
+}
+
+func (p *dualWriteProvider) updateLastLogin(username string) error {
+	err := p.source.updateLastLogin(username)
+	if err == nil {
+		p.mirror("update last login for user", username, p.target.updateLastLogin(username))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updateUserPassword(username, password string) error {
+	err := p.source.updateUserPassword(username, password)
+	if err == nil {
+		p.mirror("update password for user", username, p.target.updateUserPassword(username, password))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getIPListEntries() ([]IPListEntry, error) {
+	return p.source.getIPListEntries()
+}
+
+func (p *dualWriteProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	return p.source.getIPListEntryByID(ID)
+}
+
+func (p *dualWriteProvider) addIPListEntry(entry IPListEntry) error {
+	err := p.source.addIPListEntry(entry)
+	if err == nil {
+		p.mirror("add IP list entry", entry.IPOrNet, p.target.addIPListEntry(entry))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updateIPListEntry(entry IPListEntry) error {
+	// the IP or network can be changed, the target entry is searched using the stored one
+	stored, err := p.source.getIPListEntryByID(entry.ID)
+	if err != nil {
+		return err
+	}
+	err = p.source.updateIPListEntry(entry)
+	if err == nil {
+		p.mirror("update IP list entry", entry.IPOrNet, p.saveTargetIPListEntry(stored.IPOrNet, entry))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) deleteIPListEntry(entry IPListEntry) error {
+	stored, err := p.source.getIPListEntryByID(entry.ID)
+	if err != nil {
+		return err
+	}
+	err = p.source.deleteIPListEntry(entry)
+	if err == nil {
+		p.mirror("delete IP list entry", stored.IPOrNet, p.deleteTargetIPListEntry(stored.IPOrNet))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getPlans() ([]Plan, error) {
+	return p.source.getPlans()
+}
+
+func (p *dualWriteProvider) getPlanByID(ID int64) (Plan, error) {
+	return p.source.getPlanByID(ID)
+}
+
+func (p *dualWriteProvider) planExists(name string) (Plan, error) {
+	return p.source.planExists(name)
+}
+
+func (p *dualWriteProvider) addPlan(plan Plan) error {
+	err := p.source.addPlan(plan)
+	if err == nil {
+		p.mirror("add plan", plan.Name, p.target.addPlan(plan))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	err := p.source.updatePlan(plan, applyToUsers)
+	if err == nil {
+		p.mirror("update plan", plan.Name, p.saveTargetPlan(plan, applyToUsers))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) deletePlan(plan Plan) error {
+	stored, err := p.source.getPlanByID(plan.ID)
+	if err != nil {
+		return err
+	}
+	err = p.source.deletePlan(plan)
+	if err == nil {
+		p.mirror("delete plan", stored.Name, p.deleteTargetPlan(stored.Name))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getUserTemplates() ([]UserTemplate, error) {
+	return p.source.getUserTemplates()
+}
+
+func (p *dualWriteProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	return p.source.getUserTemplateByID(ID)
+}
+
+func (p *dualWriteProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return p.source.userTemplateExists(name)
+}
+
+func (p *dualWriteProvider) addUserTemplate(template UserTemplate) error {
+	err := p.source.addUserTemplate(template)
+	if err == nil {
+		p.mirror("add user template", template.Name, p.target.addUserTemplate(template))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updateUserTemplate(template UserTemplate) error {
+	err := p.source.updateUserTemplate(template)
+	if err == nil {
+		p.mirror("update user template", template.Name, p.saveTargetUserTemplate(template))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) deleteUserTemplate(template UserTemplate) error {
+	stored, err := p.source.getUserTemplateByID(template.ID)
+	if err != nil {
+		return err
+	}
+	err = p.source.deleteUserTemplate(template)
+	if err == nil {
+		p.mirror("delete user template", stored.Name, p.deleteTargetUserTemplate(stored.Name))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getFolders() ([]Folder, error) {
+	return p.source.getFolders()
+}
+
+func (p *dualWriteProvider) getFolderByID(ID int64) (Folder, error) {
+	return p.source.getFolderByID(ID)
+}
+
+func (p *dualWriteProvider) folderExists(name string) (Folder, error) {
+	return p.source.folderExists(name)
+}
+
+func (p *dualWriteProvider) addFolder(folder Folder) error {
+	err := p.source.addFolder(folder)
+	if err == nil {
+		p.mirror("add folder", folder.Name, p.mirrorFolder(folder.Name))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updateFolder(folder Folder) error {
+	err := p.source.updateFolder(folder)
+	if err == nil {
+		p.mirror("update folder", folder.Name, p.mirrorFolder(folder.Name))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) deleteFolder(folder Folder) error {
+	stored, err := p.source.getFolderByID(folder.ID)
+	if err != nil {
+		return err
+	}
+	err = p.source.deleteFolder(folder)
+	if err == nil {
+		p.mirror("delete folder", stored.Name, p.deleteTargetFolder(stored.Name))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	err := p.source.updateFolderQuota(name, filesAdd, sizeAdd, reset)
+	if err == nil {
+		p.mirror("update quota for folder", name, p.target.updateFolderQuota(name, filesAdd, sizeAdd, reset))
+	}
+	return err
+}
+
+func (p *dualWriteProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	return p.source.getUsedFolderQuota(name)
+}
+
+func (p *dualWriteProvider) checkAvailability() error {
+	if err := p.target.checkAvailability(); err != nil {
+		providerLog(logger.LevelWarn, "migration target: the data provider is not available: %v", err)
+	}
+	return p.source.checkAvailability()
+}
+
+func (p *dualWriteProvider) close() error {
+	err := p.target.close()
+	if err != nil {
+		providerLog(logger.LevelWarn, "migration target: unable to close the data provider: %v", err)
+	}
+	return p.source.close()
+}
+
+func (p *dualWriteProvider) reloadConfig() error {
+	return p.source.reloadConfig()
+}
+
+func (p *dualWriteProvider) initializeDatabase() error {
+	return p.source.initializeDatabase()
+}
+
+func (p *dualWriteProvider) migrateDatabase() error {
+	return p.source.migrateDatabase()
+}
+
+// the IDs are assigned by each provider, the target objects are searched using their unique names
+
+// mirrorUser writes the user stored inside the source provider to the target provider. The
+// passwords are hashed and the secrets are encrypted by the providers using random salts, so
+// the stored user is used instead of the one sent to the source provider
+func (p *dualWriteProvider) mirrorUser(username string) error {
+	user, err := p.source.userExists(username)
+	if err != nil {
+		return err
+	}
+	return p.saveTargetUser(user)
+}
+
+func (p *dualWriteProvider) saveTargetUser(user User) error {
+	stored, err := p.target.userExists(user.Username)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return p.target.addUser(user)
+	}
+	if err != nil {
+		return err
+	}
+	user.ID = stored.ID
+	// the source provider is authoritative, the target version is not checked
+	user.Version = 0
+	return p.target.updateUser(user)
+}
+
+func (p *dualWriteProvider) deleteTargetUser(username string) error {
+	stored, err := p.target.userExists(username)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.target.deleteUser(stored)
+}
+
+func (p *dualWriteProvider) getTargetIPListEntry(ipOrNet string) (IPListEntry, bool, error) {
+	entries, err := p.target.getIPListEntries()
+	if err != nil {
+		return IPListEntry{}, false, err
+	}
+	for _, e := range entries {
+		if e.IPOrNet == ipOrNet {
+			return e, true, nil
+		}
+	}
+	return IPListEntry{}, false, nil
+}
+
+func (p *dualWriteProvider) saveTargetIPListEntry(ipOrNet string, entry IPListEntry) error {
+	stored, found, err := p.getTargetIPListEntry(ipOrNet)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return p.target.addIPListEntry(entry)
+	}
+	entry.ID = stored.ID
+	return p.target.updateIPListEntry(entry)
+}
+
+func (p *dualWriteProvider) deleteTargetIPListEntry(ipOrNet string) error {
+	stored, found, err := p.getTargetIPListEntry(ipOrNet)
+	if err != nil || !found {
+		return err
+	}
+	return p.target.deleteIPListEntry(stored)
+}
+
+func (p *dualWriteProvider) saveTargetPlan(plan Plan, applyToUsers bool) error {
+	stored, err := p.target.planExists(plan.Name)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return p.target.addPlan(plan)
+	}
+	if err != nil {
+		return err
+	}
+	plan.ID = stored.ID
+	return p.target.updatePlan(plan, applyToUsers)
+}
+
+func (p *dualWriteProvider) deleteTargetPlan(name string) error {
+	stored, err := p.target.planExists(name)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.target.deletePlan(stored)
+}
+
+func (p *dualWriteProvider) saveTargetUserTemplate(template UserTemplate) error {
+	stored, err := p.target.userTemplateExists(template.Name)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return p.target.addUserTemplate(template)
+	}
+	if err != nil {
+		return err
+	}
+	template.ID = stored.ID
+	return p.target.updateUserTemplate(template)
+}
+
+func (p *dualWriteProvider) deleteTargetUserTemplate(name string) error {
+	stored, err := p.target.userTemplateExists(name)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.target.deleteUserTemplate(stored)
+}
+
+// mirrorFolder copies the folder with the given name, as stored inside the data provider
+// in use, to the migration target. The used quota is copied too
+func (p *dualWriteProvider) mirrorFolder(name string) error {
+	folder, err := p.source.folderExists(name)
+	if err != nil {
+		return err
+	}
+	return p.saveTargetFolder(folder)
+}
+
+func (p *dualWriteProvider) saveTargetFolder(folder Folder) error {
+	stored, err := p.target.folderExists(folder.Name)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		err = p.target.addFolder(folder)
+	} else if err == nil {
+		folder.ID = stored.ID
+		err = p.target.updateFolder(folder)
+	}
+	if err != nil {
+		return err
+	}
+	return p.target.updateFolderQuota(folder.Name, folder.UsedQuotaFiles, folder.UsedQuotaSize, true)
+}
+
+func (p *dualWriteProvider) deleteTargetFolder(name string) error {
+	stored, err := p.target.folderExists(name)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.target.deleteFolder(stored)
+}
+
+// migrationObjects contains the comparable representation of the objects stored inside a provider,
+// the key is the object unique name
+type migrationObjects struct {
+	users     map[string]string
+	plans     map[string]string
+	templates map[string]string
+	folders   map[string]string
+	entries   map[string]string
+}
+
+func getMigrationObjects(p Provider) (migrationObjects, error) {
+	objects := migrationObjects{
+		users:     make(map[string]string),
+		plans:     make(map[string]string),
+		templates: make(map[string]string),
+		folders:   make(map[string]string),
+		entries:   make(map[string]string),
+	}
+	users, err := p.dumpUsers()
+	if err != nil {
+		return objects, err
+	}
+	for _, user := range users {
+		if objects.users[user.Username], err = getComparableObject(user, migrationIgnoredUserFields); err != nil {
+			return objects, err
+		}
+	}
+	plans, err := p.getPlans()
+	if err != nil {
+		return objects, err
+	}
+	for _, plan := range plans {
+		if objects.plans[plan.Name], err = getComparableObject(plan, []string{"id"}); err != nil {
+			return objects, err
+		}
+	}
+	templates, err := p.getUserTemplates()
+	if err != nil {
+		return objects, err
+	}
+	for _, template := range templates {
+		if objects.templates[template.Name], err = getComparableObject(template, []string{"id"}); err != nil {
+			return objects, err
+		}
+	}
+	folders, err := p.getFolders()
+	if err != nil {
+		return objects, err
+	}
+	for _, folder := range folders {
+		if objects.folders[folder.Name], err = getComparableObject(folder, migrationIgnoredFolderFields); err != nil {
+			return objects, err
+		}
+	}
+	entries, err := p.getIPListEntries()
+	if err != nil {
+		return objects, err
+	}
+	for _, entry := range entries {
+		if objects.entries[entry.IPOrNet], err = getComparableObject(entry, []string{"id"}); err != nil {
+			return objects, err
+		}
+	}
+	return objects, nil
+}
+
+// getComparableObject returns the JSON representation of the given object without the ignored
+// fields. The empty values are removed too, the SQL providers and the key/value ones can return
+// a null or an empty value for the same field
+func getComparableObject(object interface{}, ignoredFields []string) (string, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range ignoredFields {
+		delete(fields, f)
+	}
+	// the map keys are sorted, so the result can be compared
+	data, err = json.Marshal(removeEmptyValues(fields))
+	return string(data), err
+}
+
+func removeEmptyValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			val = removeEmptyValues(val)
+			if isEmptyValue(val) {
+				delete(v, key)
+			} else {
+				v[key] = val
+			}
+		}
+	case []interface{}:
+		for idx, val := range v {
+			v[idx] = removeEmptyValues(val)
+		}
+	}
+	return value
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func compareMigrationObjects(source, target map[string]string) MigrationObjectsReport {
+	report := MigrationObjectsReport{
+		Source: len(source),
+		Target: len(target),
+	}
+	for name, s := range source {
+		t, ok := target[name]
+		if !ok {
+			report.MissingInTarget = append(report.MissingInTarget, name)
+		} else if s != t {
+			report.Different = append(report.Different, name)
+		}
+	}
+	for name := range target {
+		if _, ok := source[name]; !ok {
+			report.MissingInSource = append(report.MissingInSource, name)
+		}
+	}
+	sort.Strings(report.MissingInTarget)
+	sort.Strings(report.MissingInSource)
+	sort.Strings(report.Different)
+	return report
+}
+
+func (p *dualWriteProvider) getReport() (MigrationReport, error) {
+	report := MigrationReport{
+		SourceDriver: config.Driver,
+		TargetDriver: config.MigrationTarget.Driver,
+		FailedWrites: atomic.LoadInt64(&p.failedWrites),
+	}
+	source, err := getMigrationObjects(p.source)
+	if err != nil {
+		return report, err
+	}
+	target, err := getMigrationObjects(p.target)
+	if err != nil {
+		return report, fmt.Errorf("migration target: %v", err)
+	}
+	report.Users = compareMigrationObjects(source.users, target.users)
+	report.Plans = compareMigrationObjects(source.plans, target.plans)
+	report.UserTemplates = compareMigrationObjects(source.templates, target.templates)
+	report.Folders = compareMigrationObjects(source.folders, target.folders)
+	report.IPListEntries = compareMigrationObjects(source.entries, target.entries)
+	report.Consistent = report.Users.IsConsistent() && report.Plans.IsConsistent() &&
+		report.UserTemplates.IsConsistent() && report.Folders.IsConsistent() && report.IPListEntries.IsConsistent()
+	return report, nil
+}
+
+// sync copies the plans before the users, they can be referenced by the users,
+// and removes the plans after the users
+func (p *dualWriteProvider) sync() error {
+	providerLog(logger.LevelInfo, "migration target: synchronization started")
+	report, err := p.getReport()
+	if err != nil {
+		return err
+	}
+	plans, err := p.source.getPlans()
+	if err != nil {
+		return err
+	}
+	for _, plan := range plans {
+		if utils.IsStringInSlice(plan.Name, report.Plans.MissingInTarget) ||
+			utils.IsStringInSlice(plan.Name, report.Plans.Different) {
+			if err = p.saveTargetPlan(plan, true); err != nil {
+				return fmt.Errorf("migration target: unable to save plan %#v: %v", plan.Name, err)
+			}
+		}
+	}
+	users, err := p.source.dumpUsers()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if utils.IsStringInSlice(user.Username, report.Users.MissingInTarget) ||
+			utils.IsStringInSlice(user.Username, report.Users.Different) {
+			if err = p.saveTargetUser(user); err != nil {
+				return fmt.Errorf("migration target: unable to save user %#v: %v", user.Username, err)
+			}
+		}
+	}
+	for _, username := range report.Users.MissingInSource {
+		if err = p.deleteTargetUser(username); err != nil {
+			return fmt.Errorf("migration target: unable to delete user %#v: %v", username, err)
+		}
+	}
+	for _, name := range report.Plans.MissingInSource {
+		if err = p.deleteTargetPlan(name); err != nil {
+			return fmt.Errorf("migration target: unable to delete plan %#v: %v", name, err)
+		}
+	}
+	templates, err := p.source.getUserTemplates()
+	if err != nil {
+		return err
+	}
+	for _, template := range templates {
+		if utils.IsStringInSlice(template.Name, report.UserTemplates.MissingInTarget) ||
+			utils.IsStringInSlice(template.Name, report.UserTemplates.Different) {
+			if err = p.saveTargetUserTemplate(template); err != nil {
+				return fmt.Errorf("migration target: unable to save user template %#v: %v", template.Name, err)
+			}
+		}
+	}
+	for _, name := range report.UserTemplates.MissingInSource {
+		if err = p.deleteTargetUserTemplate(name); err != nil {
+			return fmt.Errorf("migration target: unable to delete user template %#v: %v", name, err)
+		}
+	}
+	folders, err := p.source.getFolders()
+	if err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		if utils.IsStringInSlice(folder.Name, report.Folders.MissingInTarget) ||
+			utils.IsStringInSlice(folder.Name, report.Folders.Different) {
+			if err = p.saveTargetFolder(folder); err != nil {
+				return fmt.Errorf("migration target: unable to save folder %#v: %v", folder.Name, err)
+			}
+		}
+	}
+	for _, name := range report.Folders.MissingInSource {
+		if err = p.deleteTargetFolder(name); err != nil {
+			return fmt.Errorf("migration target: unable to delete folder %#v: %v", name, err)
+		}
+	}
+	entries, err := p.source.getIPListEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if utils.IsStringInSlice(entry.IPOrNet, report.IPListEntries.MissingInTarget) ||
+			utils.IsStringInSlice(entry.IPOrNet, report.IPListEntries.Different) {
+			if err = p.saveTargetIPListEntry(entry.IPOrNet, entry); err != nil {
+				return fmt.Errorf("migration target: unable to save IP list entry %#v: %v", entry.IPOrNet, err)
+			}
+		}
+	}
+	for _, ipOrNet := range report.IPListEntries.MissingInSource {
+		if err = p.deleteTargetIPListEntry(ipOrNet); err != nil {
+			return fmt.Errorf("migration target: unable to delete IP list entry %#v: %v", ipOrNet, err)
+		}
+	}
+	providerLog(logger.LevelInfo, "migration target: synchronization completed")
+	return nil
+}
@@ -0,0 +1,122 @@
+package dataprovider
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrationDualWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migration")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	config = Config{Driver: MemoryDataProviderName}
+	config.MigrationTarget.Driver = MemoryDataProviderName
+	if err = initializeMemoryProvider(dir); err != nil {
+		t.Fatalf("unable to initialize the memory provider: %v", err)
+	}
+	source := provider
+	if err = initializeMemoryProvider(dir); err != nil {
+		t.Fatalf("unable to initialize the memory provider: %v", err)
+	}
+	target := provider
+	p := &dualWriteProvider{source: source, target: target}
+
+	testProviderUsers(t, p, dir)
+	// the reads are served by the source provider, the target must contain the same users
+	users, err := target.getUsers(10, 0, "ASC", "")
+	if err != nil {
+		t.Errorf("unable to get the target users: %v", err)
+	} else if len(users) != 1 || users[0].Username != "another_user" {
+		t.Errorf("unexpected target users: %+v", users)
+	}
+	user := getTestUser(dir, "mirrored_user")
+	if err = p.addUser(user); err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	if err = p.updateQuota(user.Username, 3, 300, false); err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	checkUsedQuota(t, target, user.Username, 3, 300)
+	if err = p.updateUserPassword(user.Username, "$2a$10$updated"); err != nil {
+		t.Errorf("unable to update password: %v", err)
+	}
+	mirrored, err := target.userExists(user.Username)
+	if err != nil {
+		t.Errorf("unable to get the target user: %v", err)
+	} else if mirrored.Password != "$2a$10$updated" {
+		t.Errorf("the password is not mirrored: %#v", mirrored.Password)
+	}
+	// a user missing inside the target is added on update
+	if err = target.deleteUser(mirrored); err != nil {
+		t.Fatalf("unable to delete the target user: %v", err)
+	}
+	user, err = p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	user.MaxSessions = 2
+	if err = p.updateUser(user); err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	mirrored, err = target.userExists(user.Username)
+	if err != nil {
+		t.Errorf("the updated user must be added to the target: %v", err)
+	} else if mirrored.MaxSessions != 2 {
+		t.Errorf("unexpected target user: %+v", mirrored)
+	}
+	report, err := GetMigrationReport(p)
+	if err != nil {
+		t.Errorf("unable to get migration report: %v", err)
+	} else if !report.Consistent || report.FailedWrites != 0 || report.Users.Source != 2 || report.Users.Target != 2 {
+		t.Errorf("unexpected migration report: %+v", report)
+	}
+
+	// a failed operation is not written to the target
+	if err = p.updateQuota("missing_user", 1, 1, false); err == nil {
+		t.Error("updating the quota for a missing user must fail")
+	}
+	if err = p.addUser(getTestUser(dir, "mirrored_user")); err == nil {
+		t.Error("adding a duplicated user must fail")
+	}
+	if p.failedWrites != 0 {
+		t.Errorf("unexpected failed writes: %v", p.failedWrites)
+	}
+
+	// the target errors are counted but they are not returned
+	if err = target.close(); err != nil {
+		t.Fatalf("unable to close the target provider: %v", err)
+	}
+	if err = p.updateQuota(user.Username, 1, 1, false); err != nil {
+		t.Errorf("a target error must not be returned: %v", err)
+	}
+	if err = p.addUser(getTestUser(dir, "source_only_user")); err != nil {
+		t.Errorf("a target error must not be returned: %v", err)
+	}
+	if err = p.deleteUser(user); err != nil {
+		t.Errorf("a target error must not be returned: %v", err)
+	}
+	if p.failedWrites != 3 {
+		t.Errorf("unexpected failed writes: %v", p.failedWrites)
+	}
+	checkUsedQuota(t, p, "another_user", 0, 0)
+	if _, err = p.userExists("source_only_user"); err != nil {
+		t.Errorf("the source provider must be updated: %v", err)
+	}
+	if _, err = GetMigrationReport(p); err == nil {
+		t.Error("the migration report must fail if the target is not available")
+	}
+	if _, err = GetMigrationReport(source); err == nil {
+		t.Error("the migration report must fail if the migration target is not configured")
+	}
+}
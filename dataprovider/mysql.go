@@ -1,12 +1,18 @@
 package dataprovider
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
 )
 
 const (
@@ -21,22 +27,56 @@ const (
 	mysqlSchemaTableSQL = "CREATE TABLE `schema_version` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `version` integer NOT NULL);"
 	mysqlUsersV2SQL     = "ALTER TABLE `{{users}}` ADD COLUMN `virtual_folders` longtext NULL;"
 	mysqlUsersV3SQL     = "ALTER TABLE `{{users}}` MODIFY `password` longtext NULL;"
+	mysqlV4SQL          = "CREATE TABLE `ip_lists` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`ipornet` varchar(50) NOT NULL UNIQUE, `type` integer NOT NULL, `description` varchar(255) NULL);"
+	mysqlV5SQL = "CREATE TABLE `plans` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`name` varchar(255) NOT NULL UNIQUE, `description` varchar(255) NULL, `max_sessions` integer NOT NULL, " +
+		"`quota_size` bigint NOT NULL, `quota_files` integer NOT NULL, `upload_bandwidth` integer NOT NULL, " +
+		"`download_bandwidth` integer NOT NULL, `fs_providers` longtext NULL, `denied_login_methods` longtext NULL);"
+	mysqlUsersV5SQL      = "ALTER TABLE `{{users}}` ADD COLUMN `plan` varchar(255) NULL;"
+	mysqlUsersV5IndexSQL = "CREATE INDEX `users_plan_idx` ON `{{users}}` (`plan`);"
+	mysqlV6SQL           = "CREATE TABLE `user_templates` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`name` varchar(255) NOT NULL UNIQUE, `description` varchar(255) NULL, `template` longtext NOT NULL);"
+	mysqlV7SQL = "CREATE TABLE `folders` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`name` varchar(255) NOT NULL UNIQUE, `description` varchar(255) NULL, `mapped_path` varchar(512) NOT NULL, " +
+		"`quota_size` bigint NOT NULL, `quota_files` integer NOT NULL, `used_quota_size` bigint NOT NULL, " +
+		"`used_quota_files` integer NOT NULL, `last_quota_update` bigint NOT NULL);"
+	mysqlUsersV8SQL = "ALTER TABLE `{{users}}` ADD COLUMN `additional_info` longtext NULL;"
+	mysqlUsersV9SQL = "ALTER TABLE `{{users}}` ADD COLUMN `created_at` bigint DEFAULT 0 NOT NULL, " +
+		"ADD COLUMN `updated_at` bigint DEFAULT 0 NOT NULL, ADD COLUMN `version` bigint DEFAULT 0 NOT NULL;"
+	mysqlV10SQL = "ALTER TABLE `plans` ADD COLUMN `password_breach_check` integer DEFAULT 0 NOT NULL;"
 )
 
+// the TLS configuration built from the configured certificates is registered with this name.
+// The driver copies the registered configuration when the database handle is created, so
+// the migration target can register its own configuration later
+const mysqlTLSConfigName = "sftpgo"
+
 // MySQLProvider auth provider for MySQL/MariaDB database
 type MySQLProvider struct {
 	dbHandle *sql.DB
 }
 
-func initializeMySQLProvider() error {
+func init() {
+	utils.AddFeature("+mysql")
+}
+
+func initializeMySQLProvider(basePath string) error {
 	var err error
 	logSender = fmt.Sprintf("dataprovider_%v", MySQLDataProviderName)
+	if err = registerMySQLTLSConfig(basePath); err != nil {
+		return err
+	}
 	dbHandle, err := sql.Open("mysql", getMySQLConnectionString(false))
 	if err == nil {
 		providerLog(logger.LevelDebug, "mysql database handle created, connection string: %#v, pool size: %v",
 			getMySQLConnectionString(true), config.PoolSize)
 		dbHandle.SetMaxOpenConns(config.PoolSize)
 		dbHandle.SetConnMaxLifetime(1800 * time.Second)
+		if err = initializeSQLReadReplica("mysql", dbHandle); err != nil {
+			dbHandle.Close()
+			return err
+		}
 		provider = MySQLProvider{dbHandle: dbHandle}
 	} else {
 		providerLog(logger.LevelWarn, "error creating mysql database handler, connection string: %#v, error: %v",
@@ -52,13 +92,54 @@ func getMySQLConnectionString(redactedPwd bool) string {
 			password = "[redacted]"
 		}
 		connectionString = fmt.Sprintf("%v:%v@tcp([%v]:%v)/%v?charset=utf8&interpolateParams=true&timeout=10s&tls=%v&writeTimeout=10s&readTimeout=10s",
-			config.Username, password, config.Host, config.Port, config.Name, getSSLMode())
+			config.Username, password, config.Host, config.Port, config.Name, getMySQLTLSMode())
 	} else {
 		connectionString = config.ConnectionString
 	}
 	return connectionString
 }
 
+func getMySQLTLSMode() string {
+	if hasSQLTLSFiles() {
+		return mysqlTLSConfigName
+	}
+	return getSSLMode()
+}
+
+// registerMySQLTLSConfig registers a TLS configuration using the configured certificates, if any.
+// TLS is always required using a custom configuration, sslmode 2 skips the server certificate
+// verification, the other modes verify it using the configured root CA
+func registerMySQLTLSConfig(basePath string) error {
+	if err := validateSQLTLSFiles(basePath); err != nil {
+		return err
+	}
+	if !hasSQLTLSFiles() {
+		return nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.SSLMode == 2,
+	}
+	if len(config.RootCert) > 0 {
+		rootCert, err := ioutil.ReadFile(config.RootCert)
+		if err != nil {
+			return err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(rootCert) {
+			return fmt.Errorf("unable to load the root CA from %#v: no valid PEM certificate found", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if len(config.ClientCert) > 0 {
+		clientCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return fmt.Errorf("unable to load the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	return mysql.RegisterTLSConfig(mysqlTLSConfigName, tlsConfig)
+}
+
 func (p MySQLProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
@@ -83,6 +164,10 @@ func (p MySQLProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p MySQLProvider) updateUserPassword(username, password string) error {
+	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
+}
+
 func (p MySQLProvider) getUsedQuota(username string) (int, int64, error) {
 	return sqlCommonGetUsedQuota(username, p.dbHandle)
 }
@@ -111,8 +196,112 @@ func (p MySQLProvider) getUsers(limit int, offset int, order string, username st
 	return sqlCommonGetUsers(limit, offset, order, username, p.dbHandle)
 }
 
+func (p MySQLProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return sqlCommonGetUsersPage(req, cursor, p.dbHandle)
+}
+
+func (p MySQLProvider) getIPListEntries() ([]IPListEntry, error) {
+	return sqlCommonGetIPListEntries(p.dbHandle)
+}
+
+func (p MySQLProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	return sqlCommonGetIPListEntryByID(ID, p.dbHandle)
+}
+
+func (p MySQLProvider) addIPListEntry(entry IPListEntry) error {
+	return sqlCommonAddIPListEntry(entry, p.dbHandle)
+}
+
+func (p MySQLProvider) updateIPListEntry(entry IPListEntry) error {
+	return sqlCommonUpdateIPListEntry(entry, p.dbHandle)
+}
+
+func (p MySQLProvider) deleteIPListEntry(entry IPListEntry) error {
+	return sqlCommonDeleteIPListEntry(entry, p.dbHandle)
+}
+
+func (p MySQLProvider) getPlans() ([]Plan, error) {
+	return sqlCommonGetPlans(p.dbHandle)
+}
+
+func (p MySQLProvider) getPlanByID(ID int64) (Plan, error) {
+	return sqlCommonGetPlanByID(ID, p.dbHandle)
+}
+
+func (p MySQLProvider) planExists(name string) (Plan, error) {
+	return sqlCommonCheckPlanExists(name, p.dbHandle)
+}
+
+func (p MySQLProvider) addPlan(plan Plan) error {
+	return sqlCommonAddPlan(plan, p.dbHandle)
+}
+
+func (p MySQLProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	return sqlCommonUpdatePlan(plan, applyToUsers, p.dbHandle)
+}
+
+func (p MySQLProvider) deletePlan(plan Plan) error {
+	return sqlCommonDeletePlan(plan, p.dbHandle)
+}
+
+func (p MySQLProvider) getUserTemplates() ([]UserTemplate, error) {
+	return sqlCommonGetUserTemplates(p.dbHandle)
+}
+
+func (p MySQLProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	return sqlCommonGetUserTemplateByID(ID, p.dbHandle)
+}
+
+func (p MySQLProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return sqlCommonCheckUserTemplateExists(name, p.dbHandle)
+}
+
+func (p MySQLProvider) addUserTemplate(template UserTemplate) error {
+	return sqlCommonAddUserTemplate(template, p.dbHandle)
+}
+
+func (p MySQLProvider) updateUserTemplate(template UserTemplate) error {
+	return sqlCommonUpdateUserTemplate(template, p.dbHandle)
+}
+
+func (p MySQLProvider) deleteUserTemplate(template UserTemplate) error {
+	return sqlCommonDeleteUserTemplate(template, p.dbHandle)
+}
+
+func (p MySQLProvider) getFolders() ([]Folder, error) {
+	return sqlCommonGetFolders(p.dbHandle)
+}
+
+func (p MySQLProvider) getFolderByID(ID int64) (Folder, error) {
+	return sqlCommonGetFolderByID(ID, p.dbHandle)
+}
+
+func (p MySQLProvider) folderExists(name string) (Folder, error) {
+	return sqlCommonCheckFolderExists(name, p.dbHandle)
+}
+
+func (p MySQLProvider) addFolder(folder Folder) error {
+	return sqlCommonAddFolder(folder, p.dbHandle)
+}
+
+func (p MySQLProvider) updateFolder(folder Folder) error {
+	return sqlCommonUpdateFolder(folder, p.dbHandle)
+}
+
+func (p MySQLProvider) deleteFolder(folder Folder) error {
+	return sqlCommonDeleteFolder(folder, p.dbHandle)
+}
+
+func (p MySQLProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p MySQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	return sqlCommonGetUsedFolderQuota(name, p.dbHandle)
+}
+
 func (p MySQLProvider) close() error {
-	return p.dbHandle.Close()
+	return sqlCommonClose(p.dbHandle)
 }
 
 func (p MySQLProvider) reloadConfig() error {
@@ -159,9 +348,163 @@ func (p MySQLProvider) migrateDatabase() error {
 		if err != nil {
 			return err
 		}
-		return updateMySQLDatabaseFrom2To3(p.dbHandle)
+		err = updateMySQLDatabaseFrom2To3(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
 	case 2:
-		return updateMySQLDatabaseFrom2To3(p.dbHandle)
+		err = updateMySQLDatabaseFrom2To3(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 3:
+		err = updateMySQLDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 4:
+		err = updateMySQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 5:
+		err = updateMySQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 6:
+		err = updateMySQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 7:
+		err = updateMySQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 8:
+		err = updateMySQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
+	case 9:
+		return updateMySQLDatabaseFrom9To10(p.dbHandle)
 	default:
 		return fmt.Errorf("Database version not handled: %v", dbVersion.Version)
 	}
@@ -179,6 +522,49 @@ func updateMySQLDatabaseFrom2To3(dbHandle *sql.DB) error {
 	return updateMySQLDatabase(dbHandle, sql, 3)
 }
 
+func updateMySQLDatabaseFrom3To4(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 3 -> 4")
+	return updateMySQLDatabase(dbHandle, mysqlV4SQL, 4)
+}
+
+func updateMySQLDatabaseFrom4To5(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 4 -> 5")
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return err
+	}
+	// MySQL does not allow multiple statements in a single query by default
+	for _, q := range []string{mysqlV5SQL, mysqlUsersV5SQL, mysqlUsersV5IndexSQL} {
+		_, err = tx.Exec(strings.Replace(q, "{{users}}", config.UsersTable, 1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = sqlCommonUpdateDatabaseVersionWithTX(tx, 5)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateMySQLDatabaseFrom5To6(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 5 -> 6")
+	return updateMySQLDatabase(dbHandle, mysqlV6SQL, 6)
+}
+
+func updateMySQLDatabaseFrom6To7(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 6 -> 7")
+	return updateMySQLDatabase(dbHandle, mysqlV7SQL, 7)
+}
+
+func updateMySQLDatabaseFrom7To8(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 7 -> 8")
+	sql := strings.Replace(mysqlUsersV8SQL, "{{users}}", config.UsersTable, 1)
+	return updateMySQLDatabase(dbHandle, sql, 8)
+}
+
 func updateMySQLDatabase(dbHandle *sql.DB, sql string, newVersion int) error {
 	tx, err := dbHandle.Begin()
 	if err != nil {
@@ -196,3 +582,22 @@ func updateMySQLDatabase(dbHandle *sql.DB, sql string, newVersion int) error {
 	}
 	return tx.Commit()
 }
+
+func updateMySQLDatabaseFrom8To9(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
+	sql := strings.ReplaceAll(mysqlUsersV9SQL, "{{users}}", config.UsersTable)
+	_, err := dbHandle.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 9)
+}
+
+func updateMySQLDatabaseFrom9To10(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	_, err := dbHandle.Exec(mysqlV10SQL)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 10)
+}
@@ -0,0 +1,260 @@
+package dataprovider
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported audit actions for user overrides
+const (
+	OverrideActionAdd     = "add"
+	OverrideActionReplace = "replace"
+	OverrideActionDelete  = "delete"
+	OverrideActionExpire  = "expire"
+)
+
+const (
+	overridesLogSender = "userOverrides"
+	// maximum number of audit records kept in memory, the oldest ones are discarded
+	maxOverrideAuditRecords = 1000
+)
+
+var userOverrides = overridesStore{
+	overrides: make(map[string]UserOverride),
+}
+
+// UserOverride defines a temporary override for the quota and bandwidth limits of a user.
+// Only the non nil limits are overridden, the override is automatically removed after
+// its expiration. The overrides are applied to the connections established while they
+// are active and they are never saved inside the data provider, so they don't survive
+// a restart
+type UserOverride struct {
+	// username for the user to override
+	Username string `json:"username"`
+	// quota as size in bytes, 0 means unlimited
+	QuotaSize *int64 `json:"quota_size,omitempty"`
+	// quota as number of files, 0 means unlimited
+	QuotaFiles *int `json:"quota_files,omitempty"`
+	// maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth *int64 `json:"upload_bandwidth,omitempty"`
+	// maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth *int64 `json:"download_bandwidth,omitempty"`
+	// override duration in seconds, it is used to compute the expiration date when the override is added
+	Duration int64 `json:"duration,omitempty"`
+	// expiration date as unix timestamp in milliseconds
+	ExpirationDate int64 `json:"expiration_date"`
+	// optional reason for the override, it is included in the audit records
+	Reason string `json:"reason,omitempty"`
+	// creation date as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+}
+
+// UserOverrideAuditRecord defines an audit record for a change to the user overrides
+type UserOverrideAuditRecord struct {
+	// audit record time as unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// add, replace, delete or expire
+	Action string `json:"action"`
+	// who requested the change, empty for automatic expirations
+	Actor string `json:"actor,omitempty"`
+	// IP address for the client that requested the change, empty for automatic expirations
+	RemoteAddress string       `json:"remote_address,omitempty"`
+	Override      UserOverride `json:"override"`
+}
+
+func (o *UserOverride) isExpired(now int64) bool {
+	return o.ExpirationDate <= now
+}
+
+func (o *UserOverride) validate() error {
+	if len(o.Username) == 0 {
+		return &ValidationError{err: "username is mandatory"}
+	}
+	if o.QuotaSize == nil && o.QuotaFiles == nil && o.UploadBandwidth == nil && o.DownloadBandwidth == nil {
+		return &ValidationError{err: "at least a limit to override is required"}
+	}
+	if (o.QuotaSize != nil && *o.QuotaSize < 0) || (o.QuotaFiles != nil && *o.QuotaFiles < 0) ||
+		(o.UploadBandwidth != nil && *o.UploadBandwidth < 0) || (o.DownloadBandwidth != nil && *o.DownloadBandwidth < 0) {
+		return &ValidationError{err: "the overridden limits cannot be negative"}
+	}
+	if o.Duration <= 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid duration: %v, it must be greater than 0", o.Duration)}
+	}
+	if len(o.Reason) > 255 {
+		return &ValidationError{err: "reason is too long, max 255 characters"}
+	}
+	return nil
+}
+
+// apply applies the override to the given user
+func (o *UserOverride) apply(user *User) {
+	if o.QuotaSize != nil {
+		user.QuotaSize = *o.QuotaSize
+	}
+	if o.QuotaFiles != nil {
+		user.QuotaFiles = *o.QuotaFiles
+	}
+	if o.UploadBandwidth != nil {
+		user.UploadBandwidth = *o.UploadBandwidth
+	}
+	if o.DownloadBandwidth != nil {
+		user.DownloadBandwidth = *o.DownloadBandwidth
+	}
+}
+
+type overridesStore struct {
+	sync.Mutex
+	overrides map[string]UserOverride
+	audit     []UserOverrideAuditRecord
+}
+
+func (s *overridesStore) addAuditRecord(record UserOverrideAuditRecord) {
+	s.audit = append(s.audit, record)
+	if len(s.audit) > maxOverrideAuditRecords {
+		s.audit = s.audit[len(s.audit)-maxOverrideAuditRecords:]
+	}
+	logger.Info(overridesLogSender, "", "user override %v, username: %#v, actor: %#v, remote address: %#v, "+
+		"expiration: %v, reason: %#v", record.Action, record.Override.Username, record.Actor, record.RemoteAddress,
+		record.Override.ExpirationDate, record.Override.Reason)
+}
+
+// removeExpired removes the expired overrides, it must be called with the lock held
+func (s *overridesStore) removeExpired() {
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	for username, o := range s.overrides {
+		if o.isExpired(now) {
+			delete(s.overrides, username)
+			s.addAuditRecord(UserOverrideAuditRecord{
+				Timestamp: o.ExpirationDate,
+				Action:    OverrideActionExpire,
+				Override:  o,
+			})
+		}
+	}
+}
+
+func (s *overridesStore) add(override UserOverride, actor, remoteAddress string) UserOverride {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removeExpired()
+	now := time.Now()
+	override.CreatedAt = utils.GetTimeAsMsSinceEpoch(now)
+	override.ExpirationDate = utils.GetTimeAsMsSinceEpoch(now.Add(time.Duration(override.Duration) * time.Second))
+	action := OverrideActionAdd
+	if _, ok := s.overrides[override.Username]; ok {
+		action = OverrideActionReplace
+	}
+	s.overrides[override.Username] = override
+	s.addAuditRecord(UserOverrideAuditRecord{
+		Timestamp:     override.CreatedAt,
+		Action:        action,
+		Actor:         actor,
+		RemoteAddress: remoteAddress,
+		Override:      override,
+	})
+	return override
+}
+
+func (s *overridesStore) remove(username, actor, remoteAddress string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removeExpired()
+	override, ok := s.overrides[username]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("no active override for username %#v", username)}
+	}
+	delete(s.overrides, username)
+	s.addAuditRecord(UserOverrideAuditRecord{
+		Timestamp:     utils.GetTimeAsMsSinceEpoch(time.Now()),
+		Action:        OverrideActionDelete,
+		Actor:         actor,
+		RemoteAddress: remoteAddress,
+		Override:      override,
+	})
+	return nil
+}
+
+func (s *overridesStore) get(username string) (UserOverride, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removeExpired()
+	override, ok := s.overrides[username]
+	return override, ok
+}
+
+func (s *overridesStore) getAll() []UserOverride {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removeExpired()
+	overrides := []UserOverride{}
+	for _, o := range s.overrides {
+		overrides = append(overrides, o)
+	}
+	return overrides
+}
+
+func (s *overridesStore) getAuditRecords() []UserOverrideAuditRecord {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removeExpired()
+	records := make([]UserOverrideAuditRecord, len(s.audit))
+	copy(records, s.audit)
+	return records
+}
+
+// GetUserOverrides returns the active user overrides
+func GetUserOverrides() []UserOverride {
+	return userOverrides.getAll()
+}
+
+// GetUserOverride returns the active override for the given username if any
+func GetUserOverride(username string) (UserOverride, error) {
+	override, ok := userOverrides.get(username)
+	if !ok {
+		return override, &RecordNotFoundError{err: fmt.Sprintf("no active override for username %#v", username)}
+	}
+	return override, nil
+}
+
+// AddUserOverride adds a temporary override for the limits of an existing user and returns it.
+// An active override for the same user is replaced.
+// actor and remoteAddress identify who requested the override and they are saved in the audit records
+func AddUserOverride(p Provider, override UserOverride, actor, remoteAddress string) (UserOverride, error) {
+	if err := override.validate(); err != nil {
+		return override, err
+	}
+	if _, err := p.userExists(override.Username); err != nil {
+		return override, err
+	}
+	return userOverrides.add(override, actor, remoteAddress), nil
+}
+
+// DeleteUserOverride removes the active override for the given username
+func DeleteUserOverride(username, actor, remoteAddress string) error {
+	return userOverrides.remove(username, actor, remoteAddress)
+}
+
+// GetUserOverridesAuditRecords returns the audit records for the user overrides, oldest first
+func GetUserOverridesAuditRecords() []UserOverrideAuditRecord {
+	return userOverrides.getAuditRecords()
+}
+
+// ApplyUserOverride applies the active override, if any, to the given user.
+// It returns true if an override was applied
+func ApplyUserOverride(user *User) bool {
+	override, ok := userOverrides.get(user.Username)
+	if !ok {
+		return false
+	}
+	override.apply(user)
+	return true
+}
@@ -0,0 +1,335 @@
+package dataprovider
+
+import (
+	"bufio"
+	"crypto/sha1" // the range API uses SHA-1 hashes
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	defaultPasswordBreachAPIURL     = "https://api.pwnedpasswords.com/range/"
+	passwordBreachHashPrefixLen     = 5
+	maxPasswordBreachResponseSize   = 1048576 // 1 MB
+	maxPasswordBreachCacheEntries   = 10000
+	passwordBreachCheckModeDisabled = 0
+	passwordBreachCheckModeAPI      = 1
+	passwordBreachCheckModeOffline  = 2
+)
+
+// password breach check policies for the users assigned to a plan
+const (
+	planPasswordBreachCheckDefault  = 0
+	planPasswordBreachCheckDisabled = 1
+	planPasswordBreachCheckStrict   = 2
+)
+
+var (
+	passwordBreachHashesFilePath string
+	passwordBreachCache          = passwordBreachRangeCache{
+		ranges: make(map[string]passwordBreachRange),
+	}
+)
+
+// PasswordBreachCheck defines the check for the passwords exposed inside known data breaches.
+// The check is done each time a plain text password is set, before updating the data provider,
+// the password hashes restored from a backup or returned by a hook are not checked.
+// A plan can disable the check for the assigned users or reject their passwords if the check
+// cannot be done
+type PasswordBreachCheck struct {
+	// 0 disabled, 1 query the HaveIBeenPwned range API, 2 offline mode: search the password hash
+	// inside a local file, no request leaves the server
+	Mode int `json:"mode" mapstructure:"mode"`
+	// Range API URL, the first 5 characters of the password SHA-1 hash are appended to it.
+	// Only this prefix is sent, the password and its full hash never leave the server.
+	// Empty means the HaveIBeenPwned API
+	APIURL string `json:"api_url" mapstructure:"api_url"`
+	// Local file for the offline mode, it can be an absolute path or a path relative to the
+	// config dir. The expected format is the one of the HaveIBeenPwned downloadable SHA-1
+	// hashes ordered by hash: a line for each hash as "HASH:COUNT", sorted by hash
+	HashesFile string `json:"hashes_file" mapstructure:"hashes_file"`
+	// A password is rejected if it appears inside the known breaches at least this number
+	// of times. 0 means 1
+	MinOccurrences int `json:"min_occurrences" mapstructure:"min_occurrences"`
+	// The range API responses are cached for this number of minutes. 0 disables the cache
+	CacheTTL int `json:"cache_ttl" mapstructure:"cache_ttl"`
+	// If enabled a password is rejected if the check cannot be done, for example if the
+	// range API is not reachable. By default the password is accepted and a warning is logged
+	RejectOnError bool `json:"reject_on_error" mapstructure:"reject_on_error"`
+}
+
+type passwordBreachRange struct {
+	counts    map[string]int
+	expiresAt time.Time
+}
+
+// passwordBreachRangeCache caches the range API responses by hash prefix
+type passwordBreachRangeCache struct {
+	sync.RWMutex
+	ranges map[string]passwordBreachRange
+}
+
+func (c *passwordBreachRangeCache) get(prefix string) (map[string]int, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	r, ok := c.ranges[prefix]
+	if !ok || time.Now().After(r.expiresAt) {
+		return nil, false
+	}
+	return r.counts, true
+}
+
+func (c *passwordBreachRangeCache) add(prefix string, counts map[string]int, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if len(c.ranges) >= maxPasswordBreachCacheEntries {
+		for k, v := range c.ranges {
+			if now.After(v.expiresAt) {
+				delete(c.ranges, k)
+			}
+		}
+		if len(c.ranges) >= maxPasswordBreachCacheEntries {
+			c.ranges = make(map[string]passwordBreachRange)
+		}
+	}
+	c.ranges[prefix] = passwordBreachRange{
+		counts:    counts,
+		expiresAt: now.Add(ttl),
+	}
+}
+
+func validatePasswordBreachCheck(basePath string) error {
+	c := &config.PasswordBreachCheck
+	switch c.Mode {
+	case passwordBreachCheckModeDisabled:
+		return nil
+	case passwordBreachCheckModeAPI:
+		if len(c.APIURL) > 0 {
+			u, err := url.Parse(c.APIURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				return fmt.Errorf("invalid password breach check API URL %#v", c.APIURL)
+			}
+		}
+	case passwordBreachCheckModeOffline:
+		if len(c.HashesFile) == 0 {
+			return errors.New("the hashes file is required for the password breach check offline mode")
+		}
+		passwordBreachHashesFilePath = c.HashesFile
+		if !filepath.IsAbs(passwordBreachHashesFilePath) {
+			passwordBreachHashesFilePath = filepath.Join(basePath, passwordBreachHashesFilePath)
+		}
+		fi, err := os.Stat(passwordBreachHashesFilePath)
+		if err != nil {
+			return fmt.Errorf("invalid password breach check hashes file: %v", err)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("invalid password breach check hashes file %#v, it is not a regular file",
+				passwordBreachHashesFilePath)
+		}
+	default:
+		return fmt.Errorf("invalid password breach check mode: %v", c.Mode)
+	}
+	if c.MinOccurrences < 0 || c.CacheTTL < 0 {
+		return errors.New("the password breach check min occurrences and cache ttl cannot be negative")
+	}
+	return nil
+}
+
+// checkUserPassword checks the plain text password for the given user, if any, against the known
+// data breaches using the policy defined inside the user plan and then hashes it.
+// The check can query the range API and the hashing is CPU intensive, so this method must be
+// called before any data provider update and never while holding a data provider lock
+func checkUserPassword(p Provider, user *User) error {
+	if len(user.Password) == 0 || utils.IsStringPrefixInSlice(user.Password, hashPwdPrefixes) {
+		return nil
+	}
+	policy := planPasswordBreachCheckDefault
+	if len(user.Plan) > 0 {
+		plan, err := p.planExists(user.Plan)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				return &ValidationError{err: fmt.Sprintf("plan %#v does not exist", user.Plan)}
+			}
+			return err
+		}
+		policy = plan.PasswordBreachCheck
+	}
+	if err := checkPasswordBreach(user.Password, policy); err != nil {
+		return err
+	}
+	return createUserPasswordHash(user)
+}
+
+// checkPasswordBreach returns a ValidationError if the given plain text password was found
+// inside the known data breaches. The policy is the one defined inside the user plan
+func checkPasswordBreach(password string, policy int) error {
+	if config.PasswordBreachCheck.Mode == passwordBreachCheckModeDisabled || policy == planPasswordBreachCheckDisabled {
+		return nil
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	var count int
+	var err error
+	if config.PasswordBreachCheck.Mode == passwordBreachCheckModeOffline {
+		count, err = searchPasswordBreachHashesFile(passwordBreachHashesFilePath, hash)
+	} else {
+		count, err = getPasswordBreachCountFromAPI(hash)
+	}
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to check the password against the known data breaches: %v", err)
+		if config.PasswordBreachCheck.RejectOnError || policy == planPasswordBreachCheckStrict {
+			return &ValidationError{err: "unable to check the password against the known data breaches, please retry later"}
+		}
+		return nil
+	}
+	minOccurrences := config.PasswordBreachCheck.MinOccurrences
+	if minOccurrences < 1 {
+		minOccurrences = 1
+	}
+	if count >= minOccurrences {
+		providerLog(logger.LevelDebug, "password rejected, it appears %v times inside the known data breaches", count)
+		return &ValidationError{err: "the password was found inside known data breaches, please choose a different one"}
+	}
+	return nil
+}
+
+func getPasswordBreachCountFromAPI(hash string) (int, error) {
+	prefix, suffix := hash[:passwordBreachHashPrefixLen], hash[passwordBreachHashPrefixLen:]
+	if counts, ok := passwordBreachCache.get(prefix); ok {
+		return counts[suffix], nil
+	}
+	apiURL := config.PasswordBreachCheck.APIURL
+	if len(apiURL) == 0 {
+		apiURL = defaultPasswordBreachAPIURL
+	}
+	req, err := http.NewRequest(http.MethodGet, apiURL+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	// the padding hides the response size, so the prefix cannot be guessed observing the traffic
+	req.Header.Set("Add-Padding", "true")
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected range API status code: %v", resp.StatusCode)
+	}
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxPasswordBreachResponseSize))
+	for scanner.Scan() {
+		hashSuffix, count, err := parsePasswordBreachLine(scanner.Text())
+		if err != nil {
+			return 0, err
+		}
+		// the padding entries have a zero count
+		if count > 0 {
+			counts[strings.ToUpper(hashSuffix)] = count
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	if config.PasswordBreachCheck.CacheTTL > 0 {
+		passwordBreachCache.add(prefix, counts, time.Duration(config.PasswordBreachCheck.CacheTTL)*time.Minute)
+	}
+	return counts[suffix], nil
+}
+
+// searchPasswordBreachHashesFile does a binary search for the given hash inside a file
+// sorted by hash, so only a few lines are read even for very large files
+func searchPasswordBreachHashesFile(name, hash string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// the lines starting inside [low, high) are the candidates, low is always a line start
+	low, high := int64(0), fi.Size()
+	for low < high {
+		mid := low + (high-low)/2
+		start, line, err := readPasswordBreachLine(f, mid, fi.Size())
+		if err != nil {
+			return 0, err
+		}
+		if start >= high {
+			high = mid
+			continue
+		}
+		lineHash, count, err := parsePasswordBreachLine(line)
+		if err != nil {
+			return 0, err
+		}
+		lineHash = strings.ToUpper(lineHash)
+		if lineHash == hash {
+			return count, nil
+		}
+		if lineHash < hash {
+			low = start + int64(len(line)) + 1
+		} else {
+			high = mid
+		}
+	}
+	return 0, nil
+}
+
+// readPasswordBreachLine returns the first line starting at or after the given offset and its start
+func readPasswordBreachLine(f *os.File, offset, size int64) (int64, string, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(f, start, size-start))
+	if offset > 0 {
+		// skip the rest of the line containing the byte before the offset
+		skipped, err := reader.ReadString('\n')
+		start += int64(len(skipped))
+		if err == io.EOF {
+			return start, "", nil
+		}
+		if err != nil {
+			return start, "", err
+		}
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return start, "", err
+	}
+	return start, strings.TrimSuffix(line, "\n"), nil
+}
+
+// parsePasswordBreachLine parses a line in the "HASH:COUNT" format, the count is optional
+func parsePasswordBreachLine(line string) (string, int, error) {
+	line = strings.TrimSpace(line)
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return line, 1, nil
+	}
+	count, err := strconv.Atoi(line[idx+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid line %#v: %v", line, err)
+	}
+	return line[:idx], count, nil
+}
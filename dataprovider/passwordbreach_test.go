@@ -0,0 +1,115 @@
+package dataprovider
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+)
+
+func TestPasswordBreachCheckWithoutProviderLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breach")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	password := "breached password"
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the memory provider uses a global lock, this read blocks if the check is done
+		// while holding it
+		if _, err := provider.getUsers(1, 0, "ASC", ""); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/range/"+hash[:passwordBreachHashPrefixLen] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%v:5\r\n", hash[passwordBreachHashPrefixLen:])
+	}))
+	defer server.Close()
+
+	httpclient.Config{Timeout: 10}.Initialize(dir)
+	config = Config{Driver: MemoryDataProviderName, ManageUsers: 1}
+	config.PasswordBreachCheck.Mode = passwordBreachCheckModeAPI
+	config.PasswordBreachCheck.APIURL = server.URL + "/range/"
+	if err = initializeMemoryProvider(dir); err != nil {
+		t.Fatalf("unable to initialize the memory provider: %v", err)
+	}
+	p := provider
+	if err = p.addPlan(Plan{Name: "unchecked", PasswordBreachCheck: planPasswordBreachCheckDisabled}); err != nil {
+		t.Fatalf("unable to add plan: %v", err)
+	}
+	if err = p.addPlan(Plan{Name: "strict", PasswordBreachCheck: planPasswordBreachCheckStrict}); err != nil {
+		t.Fatalf("unable to add plan: %v", err)
+	}
+
+	user := User{
+		Username:    "breach_user",
+		Password:    password,
+		HomeDir:     filepath.Join(dir, "breach_user"),
+		Status:      1,
+		Permissions: map[string][]string{"/": {PermAny}},
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- AddUser(p, user)
+	}()
+	select {
+	case err = <-errCh:
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("a breached password must be rejected, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the password breach check is done while holding the provider lock")
+	}
+
+	user.Plan = "unchecked"
+	if err = AddUser(p, user); err != nil {
+		t.Errorf("the check must be disabled for the plan users: %v", err)
+	}
+	user, err = p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if user.Password == password {
+		t.Error("the password must be hashed")
+	}
+	// the range API returns an error for this password
+	user.Password = "unchecked password"
+	if err = UpdateUser(p, user); err != nil {
+		t.Errorf("the password must be accepted if the check cannot be done: %v", err)
+	}
+	user, err = p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	user.Plan = "strict"
+	user.Password = "unchecked password"
+	if err = UpdateUser(p, user); err == nil {
+		t.Error("the password must be rejected if the check cannot be done for the strict plan users")
+	}
+	user.Plan = "missing"
+	if err = UpdateUser(p, user); err == nil {
+		t.Error("a missing plan must fail")
+	}
+}
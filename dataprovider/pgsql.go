@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
 )
 
 const (
@@ -19,6 +20,23 @@ const (
 	pgsqlSchemaTableSQL = `CREATE TABLE "schema_version" ("id" serial NOT NULL PRIMARY KEY, "version" integer NOT NULL);`
 	pgsqlUsersV2SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "virtual_folders" text NULL;`
 	pgsqlUsersV3SQL     = `ALTER TABLE "{{users}}" ALTER COLUMN "password" TYPE text USING "password"::text;`
+	pgsqlV4SQL          = `CREATE TABLE "ip_lists" ("id" serial NOT NULL PRIMARY KEY, "ipornet" varchar(50) NOT NULL UNIQUE,
+"type" integer NOT NULL, "description" varchar(255) NULL);`
+	pgsqlV5SQL = `CREATE TABLE "plans" ("id" serial NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(255) NULL, "max_sessions" integer NOT NULL, "quota_size" bigint NOT NULL, "quota_files" integer NOT NULL,
+"upload_bandwidth" integer NOT NULL, "download_bandwidth" integer NOT NULL, "fs_providers" text NULL,
+"denied_login_methods" text NULL);
+ALTER TABLE "{{users}}" ADD COLUMN "plan" varchar(255) NULL;
+CREATE INDEX "users_plan_idx" ON "{{users}}" ("plan");`
+	pgsqlV6SQL = `CREATE TABLE "user_templates" ("id" serial NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(255) NULL, "template" text NOT NULL);`
+	pgsqlV7SQL = `CREATE TABLE "folders" ("id" serial NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(255) NULL, "mapped_path" varchar(512) NOT NULL, "quota_size" bigint NOT NULL, "quota_files" integer NOT NULL,
+"used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL, "last_quota_update" bigint NOT NULL);`
+	pgsqlUsersV8SQL = `ALTER TABLE "{{users}}" ADD COLUMN "additional_info" text NULL;`
+	pgsqlUsersV9SQL = `ALTER TABLE "{{users}}" ADD COLUMN "created_at" bigint DEFAULT 0 NOT NULL,
+ADD COLUMN "updated_at" bigint DEFAULT 0 NOT NULL, ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;`
+	pgsqlV10SQL = `ALTER TABLE "plans" ADD COLUMN "password_breach_check" integer DEFAULT 0 NOT NULL;`
 )
 
 // PGSQLProvider auth provider for PostgreSQL database
@@ -26,14 +44,25 @@ type PGSQLProvider struct {
 	dbHandle *sql.DB
 }
 
-func initializePGSQLProvider() error {
+func init() {
+	utils.AddFeature("+pgsql")
+}
+
+func initializePGSQLProvider(basePath string) error {
 	var err error
 	logSender = fmt.Sprintf("dataprovider_%v", PGSQLDataProviderName)
+	if err = validateSQLTLSFiles(basePath); err != nil {
+		return err
+	}
 	dbHandle, err := sql.Open("postgres", getPGSQLConnectionString(false))
 	if err == nil {
 		providerLog(logger.LevelDebug, "postgres database handle created, connection string: %#v, pool size: %v",
 			getPGSQLConnectionString(true), config.PoolSize)
 		dbHandle.SetMaxOpenConns(config.PoolSize)
+		if err = initializeSQLReadReplica("postgres", dbHandle); err != nil {
+			dbHandle.Close()
+			return err
+		}
 		provider = PGSQLProvider{dbHandle: dbHandle}
 	} else {
 		providerLog(logger.LevelWarn, "error creating postgres database handler, connection string: %#v, error: %v",
@@ -51,12 +80,25 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 		}
 		connectionString = fmt.Sprintf("host='%v' port=%v dbname='%v' user='%v' password='%v' sslmode=%v connect_timeout=10",
 			config.Host, config.Port, config.Name, config.Username, password, getSSLMode())
+		if len(config.RootCert) > 0 {
+			connectionString += fmt.Sprintf(" sslrootcert='%v'", escapePGSQLConnectionValue(config.RootCert))
+		}
+		if len(config.ClientCert) > 0 {
+			connectionString += fmt.Sprintf(" sslcert='%v' sslkey='%v'", escapePGSQLConnectionValue(config.ClientCert),
+				escapePGSQLConnectionValue(config.ClientKey))
+		}
 	} else {
 		connectionString = config.ConnectionString
 	}
 	return connectionString
 }
 
+// escapePGSQLConnectionValue escapes a single quoted connection string value, Windows paths
+// contain backslashes for example
+func escapePGSQLConnectionValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
 func (p PGSQLProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
@@ -81,6 +123,10 @@ func (p PGSQLProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p PGSQLProvider) updateUserPassword(username, password string) error {
+	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
+}
+
 func (p PGSQLProvider) getUsedQuota(username string) (int, int64, error) {
 	return sqlCommonGetUsedQuota(username, p.dbHandle)
 }
@@ -109,8 +155,112 @@ func (p PGSQLProvider) getUsers(limit int, offset int, order string, username st
 	return sqlCommonGetUsers(limit, offset, order, username, p.dbHandle)
 }
 
+func (p PGSQLProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return sqlCommonGetUsersPage(req, cursor, p.dbHandle)
+}
+
+func (p PGSQLProvider) getIPListEntries() ([]IPListEntry, error) {
+	return sqlCommonGetIPListEntries(p.dbHandle)
+}
+
+func (p PGSQLProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	return sqlCommonGetIPListEntryByID(ID, p.dbHandle)
+}
+
+func (p PGSQLProvider) addIPListEntry(entry IPListEntry) error {
+	return sqlCommonAddIPListEntry(entry, p.dbHandle)
+}
+
+func (p PGSQLProvider) updateIPListEntry(entry IPListEntry) error {
+	return sqlCommonUpdateIPListEntry(entry, p.dbHandle)
+}
+
+func (p PGSQLProvider) deleteIPListEntry(entry IPListEntry) error {
+	return sqlCommonDeleteIPListEntry(entry, p.dbHandle)
+}
+
+func (p PGSQLProvider) getPlans() ([]Plan, error) {
+	return sqlCommonGetPlans(p.dbHandle)
+}
+
+func (p PGSQLProvider) getPlanByID(ID int64) (Plan, error) {
+	return sqlCommonGetPlanByID(ID, p.dbHandle)
+}
+
+func (p PGSQLProvider) planExists(name string) (Plan, error) {
+	return sqlCommonCheckPlanExists(name, p.dbHandle)
+}
+
+func (p PGSQLProvider) addPlan(plan Plan) error {
+	return sqlCommonAddPlan(plan, p.dbHandle)
+}
+
+func (p PGSQLProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	return sqlCommonUpdatePlan(plan, applyToUsers, p.dbHandle)
+}
+
+func (p PGSQLProvider) deletePlan(plan Plan) error {
+	return sqlCommonDeletePlan(plan, p.dbHandle)
+}
+
+func (p PGSQLProvider) getUserTemplates() ([]UserTemplate, error) {
+	return sqlCommonGetUserTemplates(p.dbHandle)
+}
+
+func (p PGSQLProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	return sqlCommonGetUserTemplateByID(ID, p.dbHandle)
+}
+
+func (p PGSQLProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return sqlCommonCheckUserTemplateExists(name, p.dbHandle)
+}
+
+func (p PGSQLProvider) addUserTemplate(template UserTemplate) error {
+	return sqlCommonAddUserTemplate(template, p.dbHandle)
+}
+
+func (p PGSQLProvider) updateUserTemplate(template UserTemplate) error {
+	return sqlCommonUpdateUserTemplate(template, p.dbHandle)
+}
+
+func (p PGSQLProvider) deleteUserTemplate(template UserTemplate) error {
+	return sqlCommonDeleteUserTemplate(template, p.dbHandle)
+}
+
+func (p PGSQLProvider) getFolders() ([]Folder, error) {
+	return sqlCommonGetFolders(p.dbHandle)
+}
+
+func (p PGSQLProvider) getFolderByID(ID int64) (Folder, error) {
+	return sqlCommonGetFolderByID(ID, p.dbHandle)
+}
+
+func (p PGSQLProvider) folderExists(name string) (Folder, error) {
+	return sqlCommonCheckFolderExists(name, p.dbHandle)
+}
+
+func (p PGSQLProvider) addFolder(folder Folder) error {
+	return sqlCommonAddFolder(folder, p.dbHandle)
+}
+
+func (p PGSQLProvider) updateFolder(folder Folder) error {
+	return sqlCommonUpdateFolder(folder, p.dbHandle)
+}
+
+func (p PGSQLProvider) deleteFolder(folder Folder) error {
+	return sqlCommonDeleteFolder(folder, p.dbHandle)
+}
+
+func (p PGSQLProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p PGSQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	return sqlCommonGetUsedFolderQuota(name, p.dbHandle)
+}
+
 func (p PGSQLProvider) close() error {
-	return p.dbHandle.Close()
+	return sqlCommonClose(p.dbHandle)
 }
 
 func (p PGSQLProvider) reloadConfig() error {
@@ -157,9 +307,163 @@ func (p PGSQLProvider) migrateDatabase() error {
 		if err != nil {
 			return err
 		}
-		return updatePGSQLDatabaseFrom2To3(p.dbHandle)
+		err = updatePGSQLDatabaseFrom2To3(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
 	case 2:
-		return updatePGSQLDatabaseFrom2To3(p.dbHandle)
+		err = updatePGSQLDatabaseFrom2To3(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 3:
+		err = updatePGSQLDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 4:
+		err = updatePGSQLDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 5:
+		err = updatePGSQLDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 6:
+		err = updatePGSQLDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 7:
+		err = updatePGSQLDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 8:
+		err = updatePGSQLDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
+	case 9:
+		return updatePGSQLDatabaseFrom9To10(p.dbHandle)
 	default:
 		return fmt.Errorf("Database version not handled: %v", dbVersion.Version)
 	}
@@ -177,6 +481,33 @@ func updatePGSQLDatabaseFrom2To3(dbHandle *sql.DB) error {
 	return updatePGSQLDatabase(dbHandle, sql, 3)
 }
 
+func updatePGSQLDatabaseFrom3To4(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 3 -> 4")
+	return updatePGSQLDatabase(dbHandle, pgsqlV4SQL, 4)
+}
+
+func updatePGSQLDatabaseFrom4To5(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 4 -> 5")
+	sql := strings.ReplaceAll(pgsqlV5SQL, "{{users}}", config.UsersTable)
+	return updatePGSQLDatabase(dbHandle, sql, 5)
+}
+
+func updatePGSQLDatabaseFrom5To6(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 5 -> 6")
+	return updatePGSQLDatabase(dbHandle, pgsqlV6SQL, 6)
+}
+
+func updatePGSQLDatabaseFrom6To7(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 6 -> 7")
+	return updatePGSQLDatabase(dbHandle, pgsqlV7SQL, 7)
+}
+
+func updatePGSQLDatabaseFrom7To8(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 7 -> 8")
+	sql := strings.Replace(pgsqlUsersV8SQL, "{{users}}", config.UsersTable, 1)
+	return updatePGSQLDatabase(dbHandle, sql, 8)
+}
+
 func updatePGSQLDatabase(dbHandle *sql.DB, sql string, newVersion int) error {
 	tx, err := dbHandle.Begin()
 	if err != nil {
@@ -194,3 +525,22 @@ func updatePGSQLDatabase(dbHandle *sql.DB, sql string, newVersion int) error {
 	}
 	return tx.Commit()
 }
+
+func updatePGSQLDatabaseFrom8To9(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
+	sql := strings.ReplaceAll(pgsqlUsersV9SQL, "{{users}}", config.UsersTable)
+	_, err := dbHandle.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 9)
+}
+
+func updatePGSQLDatabaseFrom9To10(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	_, err := dbHandle.Exec(pgsqlV10SQL)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 10)
+}
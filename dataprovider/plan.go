@@ -0,0 +1,235 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var validPlanNameRegex = regexp.MustCompile(`^[a-zA-Z0-9-_.]+$`)
+
+// Plan defines a service plan. The limits and the feature toggles defined inside a plan
+// replace the ones of the assigned users, so a plan change updates all of them at once
+type Plan struct {
+	// Database unique identifier
+	ID int64 `json:"id"`
+	// unique plan name, it is referenced by the assigned users and it cannot be changed
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// Maximum concurrent sessions. 0 means unlimited
+	MaxSessions int `json:"max_sessions"`
+	// Maximum size allowed as bytes. 0 means unlimited
+	QuotaSize int64 `json:"quota_size"`
+	// Maximum number of files allowed. 0 means unlimited
+	QuotaFiles int `json:"quota_files"`
+	// Maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth int64 `json:"upload_bandwidth"`
+	// Maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `json:"download_bandwidth"`
+	// only users with one of these filesystem providers can be assigned to the plan.
+	// If null or empty any provider is allowed
+	AllowedFsProviders []int `json:"allowed_fs_providers,omitempty"`
+	// feature toggles: these login methods are not allowed for the assigned users.
+	// If null or empty any available login method is allowed
+	DeniedLoginMethods []string `json:"denied_login_methods,omitempty"`
+	// password breach check policy for the assigned users: 0 the global configuration applies,
+	// 1 the check is disabled, 2 the passwords are rejected if the check cannot be done
+	PasswordBreachCheck int `json:"password_breach_check,omitempty"`
+}
+
+// GetFsProvidersAsJSON returns the allowed filesystem providers as json byte array
+func (p *Plan) GetFsProvidersAsJSON() ([]byte, error) {
+	return json.Marshal(p.AllowedFsProviders)
+}
+
+// GetDeniedLoginMethodsAsJSON returns the denied login methods as json byte array
+func (p *Plan) GetDeniedLoginMethodsAsJSON() ([]byte, error) {
+	return json.Marshal(p.DeniedLoginMethods)
+}
+
+// GetFsProvidersAsString returns the allowed filesystem providers as string
+func (p *Plan) GetFsProvidersAsString() string {
+	var providers []string
+	for _, fsProvider := range p.AllowedFsProviders {
+		switch fsProvider {
+		case LocalFilesystemProvider:
+			providers = append(providers, "Local")
+		case S3FilesystemProvider:
+			providers = append(providers, "S3")
+		case GCSFilesystemProvider:
+			providers = append(providers, "GCS")
+		case CryptedFilesystemProvider:
+			providers = append(providers, "Encrypted local")
+		case WebDAVFilesystemProvider:
+			providers = append(providers, "WebDAV")
+		case HDFSFilesystemProvider:
+			providers = append(providers, "HDFS")
+		case GoogleDriveFilesystemProvider:
+			providers = append(providers, "Google Drive")
+		case DropboxFilesystemProvider:
+			providers = append(providers, "Dropbox")
+		}
+	}
+	if len(providers) == 0 {
+		return "Any"
+	}
+	return strings.Join(providers, ", ")
+}
+
+// isFsProviderAllowed returns true if the given filesystem provider can be used by the plan users
+func (p *Plan) isFsProviderAllowed(fsProvider int) bool {
+	if len(p.AllowedFsProviders) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedFsProviders {
+		if allowed == fsProvider {
+			return true
+		}
+	}
+	return false
+}
+
+// applyToUser replaces the user limits and feature toggles with the plan ones
+func (p *Plan) applyToUser(user *User) error {
+	if !p.isFsProviderAllowed(user.FsConfig.Provider) {
+		return &ValidationError{err: fmt.Sprintf("filesystem provider %v is not allowed for plan %#v, user %#v",
+			user.FsConfig.Provider, p.Name, user.Username)}
+	}
+	user.Plan = p.Name
+	user.MaxSessions = p.MaxSessions
+	user.QuotaSize = p.QuotaSize
+	user.QuotaFiles = p.QuotaFiles
+	user.UploadBandwidth = p.UploadBandwidth
+	user.DownloadBandwidth = p.DownloadBandwidth
+	user.Filters.DeniedLoginMethods = nil
+	if len(p.DeniedLoginMethods) > 0 {
+		user.Filters.DeniedLoginMethods = make([]string, len(p.DeniedLoginMethods))
+		copy(user.Filters.DeniedLoginMethods, p.DeniedLoginMethods)
+	}
+	return nil
+}
+
+// GetPlans returns all the defined plans
+func GetPlans(p Provider) ([]Plan, error) {
+	return p.getPlans()
+}
+
+// GetPlanByID returns the plan with the given database ID if a match is found or an error
+func GetPlanByID(p Provider, ID int64) (Plan, error) {
+	return p.getPlanByID(ID)
+}
+
+// PlanExists returns the plan with the given name if a match is found or an error
+func PlanExists(p Provider, name string) (Plan, error) {
+	return p.planExists(name)
+}
+
+// AddPlan adds a new plan.
+// ManageUsers configuration must be set to 1 to enable this method
+func AddPlan(p Provider, plan Plan) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.addPlan(plan)
+}
+
+// UpdatePlan updates an existing plan. The new limits and feature toggles are applied to
+// all the assigned users inside the same transaction: if a user cannot be updated, for
+// example because its filesystem provider is not allowed anymore, nothing is changed.
+// ManageUsers configuration must be set to 1 to enable this method
+func UpdatePlan(p Provider, plan Plan) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	if isPlanPropagationRunning(plan.Name) {
+		return ErrPlanPropagationInProgress
+	}
+	err := p.updatePlan(plan, true)
+	if err == nil {
+		providerLog(logger.LevelInfo, "plan %#v updated, the assigned users were updated too", plan.Name)
+	}
+	return err
+}
+
+// DeletePlan deletes an existing plan, a plan assigned to some users cannot be deleted.
+// ManageUsers configuration must be set to 1 to enable this method
+func DeletePlan(p Provider, plan Plan) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.deletePlan(plan)
+}
+
+// applyUserPlan applies the plan assigned to the given user, if any
+func applyUserPlan(p Provider, user *User) error {
+	if len(user.Plan) == 0 {
+		return nil
+	}
+	plan, err := p.planExists(user.Plan)
+	if err != nil {
+		if _, ok := err.(*RecordNotFoundError); ok {
+			return &ValidationError{err: fmt.Sprintf("plan %#v does not exist", user.Plan)}
+		}
+		return err
+	}
+	return plan.applyToUser(user)
+}
+
+// applyPlanToUsers applies the given plan to the given users, the users not assigned
+// to the plan are ignored
+func applyPlanToUsers(plan Plan, users []User) ([]User, error) {
+	var updatedUsers []User
+	for _, user := range users {
+		if user.Plan != plan.Name {
+			continue
+		}
+		if err := plan.applyToUser(&user); err != nil {
+			return nil, err
+		}
+		updatedUsers = append(updatedUsers, user)
+	}
+	return updatedUsers, nil
+}
+
+func validatePlan(plan *Plan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if len(plan.Name) == 0 {
+		return &ValidationError{err: "name is mandatory"}
+	}
+	if len(plan.Name) > 255 {
+		return &ValidationError{err: "name is too long, max 255 characters"}
+	}
+	if !validPlanNameRegex.MatchString(plan.Name) {
+		return &ValidationError{err: fmt.Sprintf("name %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.",
+			plan.Name)}
+	}
+	if len(plan.Description) > 255 {
+		return &ValidationError{err: "description is too long, max 255 characters"}
+	}
+	if plan.MaxSessions < 0 || plan.QuotaSize < 0 || plan.QuotaFiles < 0 || plan.UploadBandwidth < 0 ||
+		plan.DownloadBandwidth < 0 {
+		return &ValidationError{err: "the plan limits cannot be negative"}
+	}
+	for _, fsProvider := range plan.AllowedFsProviders {
+		if !isFilesystemProviderSupported(fsProvider) {
+			return &ValidationError{err: fmt.Sprintf("invalid filesystem provider: %v", fsProvider)}
+		}
+	}
+	if plan.PasswordBreachCheck < planPasswordBreachCheckDefault || plan.PasswordBreachCheck > planPasswordBreachCheckStrict {
+		return &ValidationError{err: fmt.Sprintf("invalid password breach check policy: %v", plan.PasswordBreachCheck)}
+	}
+	if len(plan.DeniedLoginMethods) >= len(ValidSSHLoginMethods) {
+		return &ValidationError{err: "invalid denied_login_methods: cannot deny all login methods"}
+	}
+	for _, loginMethod := range plan.DeniedLoginMethods {
+		if !utils.IsStringInSlice(loginMethod, ValidSSHLoginMethods) {
+			return &ValidationError{err: fmt.Sprintf("invalid login method: %#v", loginMethod)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package dataprovider
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// plan propagation status
+const (
+	PlanPropagationRunning   = "running"
+	PlanPropagationCompleted = "completed"
+)
+
+// maximum number of times a user modified while applying a plan is read again
+const planPropagationMaxRetries = 3
+
+// ErrPlanPropagationInProgress is returned when a plan is updated while its previous
+// update is still being applied to the assigned users
+var ErrPlanPropagationInProgress = errors.New("the previous plan update is still being applied to the assigned users")
+
+var (
+	planPropagations     = make(map[string]*PlanPropagation)
+	planPropagationMutex sync.RWMutex
+)
+
+// PlanPropagationConfig defines the configuration for the background jobs applying
+// an updated plan to the assigned users
+type PlanPropagationConfig struct {
+	// Maximum number of users updated per second by each job. 0 means unlimited
+	UsersPerSecond int `json:"users_per_second" mapstructure:"users_per_second"`
+}
+
+// PlanPropagationError defines a user that cannot be updated by a plan propagation
+type PlanPropagationError struct {
+	Username string `json:"username"`
+	Error    string `json:"error"`
+}
+
+// PlanPropagation defines a background job applying an updated plan to the assigned users.
+// Only the last propagation for each plan is kept
+type PlanPropagation struct {
+	PlanName string `json:"plan_name"`
+	Status   string `json:"status"`
+	// start and end time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time,omitempty"`
+	// number of users assigned to the plan when the propagation started
+	TotalUsers int `json:"total_users"`
+	// number of users already processed, updated or not
+	ProcessedUsers int `json:"processed_users"`
+	// the affected users, they are omitted listing the propagations
+	UpdatedUsers []string `json:"updated_users,omitempty"`
+	// users deleted or assigned to another plan after the propagation start
+	SkippedUsers []string               `json:"skipped_users,omitempty"`
+	FailedUsers  []PlanPropagationError `json:"failed_users,omitempty"`
+	// number of updated, skipped and failed users
+	NumUpdatedUsers int `json:"num_updated_users"`
+	NumSkippedUsers int `json:"num_skipped_users"`
+	NumFailedUsers  int `json:"num_failed_users"`
+}
+
+// GetStartTimeAsString returns the propagation start time formatted as YYYY-MM-DD HH:MM:SS
+func (p *PlanPropagation) GetStartTimeAsString() string {
+	return utils.GetTimeFromMsecSinceEpoch(p.StartTime).Format("2006-01-02 15:04:05")
+}
+
+// GetProgress returns the percentage of the processed users
+func (p *PlanPropagation) GetProgress() int {
+	if p.TotalUsers == 0 {
+		return 100
+	}
+	return p.ProcessedUsers * 100 / p.TotalUsers
+}
+
+func (p *PlanPropagation) getACopy(withUsers bool) PlanPropagation {
+	propagation := *p
+	propagation.UpdatedUsers = nil
+	propagation.SkippedUsers = nil
+	propagation.FailedUsers = nil
+	if withUsers {
+		propagation.UpdatedUsers = make([]string, len(p.UpdatedUsers))
+		copy(propagation.UpdatedUsers, p.UpdatedUsers)
+		propagation.SkippedUsers = make([]string, len(p.SkippedUsers))
+		copy(propagation.SkippedUsers, p.SkippedUsers)
+		propagation.FailedUsers = make([]PlanPropagationError, len(p.FailedUsers))
+		copy(propagation.FailedUsers, p.FailedUsers)
+	}
+	return propagation
+}
+
+// GetPlanPropagations returns the running and finished plan propagations, without the
+// affected users, ordered by plan name
+func GetPlanPropagations() []PlanPropagation {
+	planPropagationMutex.RLock()
+	defer planPropagationMutex.RUnlock()
+
+	propagations := make([]PlanPropagation, 0, len(planPropagations))
+	for _, p := range planPropagations {
+		propagations = append(propagations, p.getACopy(false))
+	}
+	sort.Slice(propagations, func(i, j int) bool {
+		return propagations[i].PlanName < propagations[j].PlanName
+	})
+	return propagations
+}
+
+// GetPlanPropagation returns the last propagation, including the affected users, for the
+// plan with the given name. The second return value is false if there is no propagation
+func GetPlanPropagation(planName string) (PlanPropagation, bool) {
+	planPropagationMutex.RLock()
+	defer planPropagationMutex.RUnlock()
+
+	if p, ok := planPropagations[planName]; ok {
+		return p.getACopy(true), true
+	}
+	return PlanPropagation{}, false
+}
+
+func isPlanPropagationRunning(planName string) bool {
+	planPropagationMutex.RLock()
+	defer planPropagationMutex.RUnlock()
+
+	if p, ok := planPropagations[planName]; ok {
+		return p.Status == PlanPropagationRunning
+	}
+	return false
+}
+
+// UpdatePlanAsync updates an existing plan, the new limits and feature toggles are applied to
+// the assigned users by a background job, so a plan assigned to many users can be updated
+// without waiting for all of them. The plan is not updated if it cannot be applied to all
+// the assigned users, as for UpdatePlan. Use GetPlanPropagation to follow the job.
+// ManageUsers configuration must be set to 1 to enable this method
+func UpdatePlanAsync(p Provider, plan Plan) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	planPropagationMutex.Lock()
+	defer planPropagationMutex.Unlock()
+
+	if propagation, ok := planPropagations[plan.Name]; ok && propagation.Status == PlanPropagationRunning {
+		return ErrPlanPropagationInProgress
+	}
+	users, err := p.dumpUsers()
+	if err != nil {
+		return err
+	}
+	users, err = applyPlanToUsers(plan, users)
+	if err != nil {
+		return err
+	}
+	err = p.updatePlan(plan, false)
+	if err != nil {
+		return err
+	}
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	propagation := &PlanPropagation{
+		PlanName:   plan.Name,
+		Status:     PlanPropagationRunning,
+		StartTime:  utils.GetTimeAsMsSinceEpoch(time.Now()),
+		TotalUsers: len(usernames),
+	}
+	planPropagations[plan.Name] = propagation
+	providerLog(logger.LevelInfo, "plan %#v updated, applying it to %v assigned users", plan.Name, len(usernames))
+	go propagation.run(p, plan, usernames)
+	return nil
+}
+
+// run applies the given plan to the given users, one at a time, respecting the configured rate
+func (p *PlanPropagation) run(provider Provider, plan Plan, usernames []string) {
+	var throttle <-chan time.Time
+	if config.PlanPropagation.UsersPerSecond > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(config.PlanPropagation.UsersPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	for idx, username := range usernames {
+		if throttle != nil && idx > 0 {
+			<-throttle
+		}
+		updated, err := applyPlanToUsername(provider, plan, username)
+		planPropagationMutex.Lock()
+		p.ProcessedUsers++
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to apply plan %#v to user %#v: %v", plan.Name, username, err)
+			p.FailedUsers = append(p.FailedUsers, PlanPropagationError{Username: username, Error: err.Error()})
+			p.NumFailedUsers++
+		} else if updated {
+			p.UpdatedUsers = append(p.UpdatedUsers, username)
+			p.NumUpdatedUsers++
+		} else {
+			p.SkippedUsers = append(p.SkippedUsers, username)
+			p.NumSkippedUsers++
+		}
+		planPropagationMutex.Unlock()
+	}
+	planPropagationMutex.Lock()
+	p.Status = PlanPropagationCompleted
+	p.EndTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+	planPropagationMutex.Unlock()
+	providerLog(logger.LevelInfo, "plan %#v applied to the assigned users, updated: %v, skipped: %v, failed: %v",
+		plan.Name, p.NumUpdatedUsers, p.NumSkippedUsers, p.NumFailedUsers)
+}
+
+// applyPlanToUsername applies the given plan to the user with the given username. It returns
+// false if the user does not exist anymore or if it is not assigned to the plan anymore.
+// The user is read again if it is modified while the plan is applied
+func applyPlanToUsername(p Provider, plan Plan, username string) (bool, error) {
+	for i := 0; ; i++ {
+		user, err := p.userExists(username)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				return false, nil
+			}
+			return false, err
+		}
+		if user.Plan != plan.Name {
+			return false, nil
+		}
+		if err = plan.applyToUser(&user); err != nil {
+			return false, err
+		}
+		err = p.updateUser(user)
+		if err == ErrUserVersionMismatch && i < planPropagationMaxRetries {
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+}
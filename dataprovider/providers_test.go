@@ -0,0 +1,174 @@
+package dataprovider
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// bcrypt hash for the password "password"
+const testUserPasswordHash = "$2a$10$NqG4pteUSqv5mrFa7/9xiOdpECqhV2uiRxhq3.88u8CLbu3LoOVMi"
+
+func getTestUser(dir, username string) User {
+	return User{
+		Username:    username,
+		Password:    testUserPasswordHash,
+		HomeDir:     filepath.Join(dir, username),
+		Status:      1,
+		Permissions: map[string][]string{"/": {PermAny}},
+	}
+}
+
+func checkRecordNotFound(t *testing.T, operation string, err error) {
+	if _, ok := err.(*RecordNotFoundError); !ok {
+		t.Errorf("%v: a RecordNotFoundError is expected, got: %v", operation, err)
+	}
+}
+
+func checkUsedQuota(t *testing.T, p Provider, username string, expectedFiles int, expectedSize int64) {
+	files, size, err := p.getUsedQuota(username)
+	if err != nil {
+		t.Errorf("unable to get used quota: %v", err)
+	} else if files != expectedFiles || size != expectedSize {
+		t.Errorf("unexpected used quota, files: %v size: %v, expected files: %v size: %v", files, size,
+			expectedFiles, expectedSize)
+	}
+}
+
+// testProviderUsers checks the users operations for the given provider, it must not contain any user
+func testProviderUsers(t *testing.T, p Provider, dir string) {
+	user := getTestUser(dir, "provider_user")
+	if err := p.addUser(user); err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	if err := p.addUser(user); err == nil {
+		t.Error("adding a duplicated user must fail")
+	}
+	if err := p.addUser(User{Username: "invalid_user"}); err == nil {
+		t.Error("adding an invalid user must fail")
+	}
+	user, err := p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if user.ID <= 0 || user.Password != testUserPasswordHash || user.HomeDir != filepath.Join(dir, user.Username) ||
+		user.Version != 1 {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	userByID, err := p.getUserByID(user.ID)
+	if err != nil {
+		t.Errorf("unable to get user by ID: %v", err)
+	} else if userByID.Username != user.Username {
+		t.Errorf("unexpected user by ID: %#v", userByID.Username)
+	}
+	_, err = p.getUserByID(user.ID + 100)
+	checkRecordNotFound(t, "get missing user by ID", err)
+	_, err = p.userExists("missing_user")
+	checkRecordNotFound(t, "get missing user", err)
+	if _, err = p.validateUserAndPass(user.Username, "password"); err != nil {
+		t.Errorf("unable to validate user password: %v", err)
+	}
+	if _, err = p.validateUserAndPass(user.Username, "wrong password"); err == nil {
+		t.Error("a wrong password must be rejected")
+	}
+
+	if err = p.updateQuota(user.Username, 2, 100, false); err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	if err = p.updateQuota(user.Username, 2, 100, false); err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	checkUsedQuota(t, p, user.Username, 4, 200)
+	if err = p.updateQuota(user.Username, 1, 10, true); err != nil {
+		t.Errorf("unable to reset quota: %v", err)
+	}
+	checkUsedQuota(t, p, user.Username, 1, 10)
+	checkRecordNotFound(t, "update quota for a missing user", p.updateQuota("missing_user", 1, 10, false))
+	_, _, err = p.getUsedQuota("missing_user")
+	checkRecordNotFound(t, "get quota for a missing user", err)
+
+	if err = p.updateLastLogin(user.Username); err != nil {
+		t.Errorf("unable to update last login: %v", err)
+	}
+	checkRecordNotFound(t, "update last login for a missing user", p.updateLastLogin("missing_user"))
+
+	user, err = p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	user.MaxSessions = 3
+	user.QuotaFiles = 100
+	if err = p.updateUser(user); err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	updated, err := p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if updated.MaxSessions != 3 || updated.QuotaFiles != 100 || updated.ID != user.ID || updated.Version != 2 {
+		t.Errorf("unexpected updated user: %+v", updated)
+	}
+	if updated.LastLogin == 0 {
+		t.Error("the last login must be preserved after an update")
+	}
+	checkUsedQuota(t, p, user.Username, 1, 10)
+	// user contains the previous version
+	if err = p.updateUser(user); err != ErrUserVersionMismatch {
+		t.Errorf("updating a stale user must fail with a version mismatch, got: %v", err)
+	}
+	missing := getTestUser(dir, "missing_user")
+	checkRecordNotFound(t, "update a missing user", p.updateUser(missing))
+	if err = p.updateUser(User{Username: user.Username}); err == nil {
+		t.Error("updating an invalid user must fail")
+	}
+
+	if err = p.addUser(getTestUser(dir, "another_user")); err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	users, err := p.getUsers(10, 0, "ASC", "")
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	} else if len(users) != 2 || users[0].Username != "another_user" || users[1].Username != user.Username {
+		t.Errorf("unexpected users: %+v", users)
+	} else if len(users[0].Password) > 0 {
+		t.Error("the users list must not contain the passwords")
+	}
+	users, err = p.getUsers(1, 0, "DESC", "")
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	} else if len(users) != 1 || users[0].Username != user.Username {
+		t.Errorf("unexpected users: %+v", users)
+	}
+	users, err = p.getUsers(10, 1, "ASC", "")
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	} else if len(users) != 1 || users[0].Username != user.Username {
+		t.Errorf("unexpected users: %+v", users)
+	}
+	users, err = p.getUsers(10, 0, "ASC", "another_user")
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	} else if len(users) != 1 || users[0].Username != "another_user" {
+		t.Errorf("unexpected users: %+v", users)
+	}
+	users, err = p.getUsers(10, 0, "ASC", "missing_user")
+	if err != nil || len(users) != 0 {
+		t.Errorf("unexpected users for a missing username: %+v, err: %v", users, err)
+	}
+
+	if err = p.deleteUser(user); err != nil {
+		t.Errorf("unable to delete user: %v", err)
+	}
+	if err = p.deleteUser(user); err == nil {
+		t.Error("deleting a missing user must fail")
+	}
+	_, err = p.userExists(user.Username)
+	checkRecordNotFound(t, "get a deleted user", err)
+	_, err = p.getUserByID(user.ID)
+	checkRecordNotFound(t, "get a deleted user by ID", err)
+	users, err = p.getUsers(10, 0, "ASC", "")
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	} else if len(users) != 1 || users[0].Username != "another_user" {
+		t.Errorf("unexpected users after delete: %+v", users)
+	}
+}
@@ -0,0 +1,1053 @@
+package dataprovider
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	redisDatabaseVersion = 1
+	redisDefaultPort     = 6379
+	redisDialTimeout     = 10 * time.Second
+	redisCommandTimeout  = 30 * time.Second
+	redisMaxTxRetries    = 20
+	redisKeyPrefix       = "sftpgo:"
+	// updates the given hash fields only if the hash exists, returns 0 if the hash does not exist
+	redisUpdateIfExistsScript = `if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+redis.call('HSET', KEYS[1], unpack(ARGV))
+return 1`
+	// updates the used quota only if the user exists, returns 0 if the user does not exist
+	redisUpdateQuotaScript = `if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+if ARGV[1] == '1' then
+  redis.call('HSET', KEYS[1], 'used_quota_size', ARGV[2], 'used_quota_files', ARGV[3])
+else
+  redis.call('HINCRBY', KEYS[1], 'used_quota_size', ARGV[2])
+  redis.call('HINCRBY', KEYS[1], 'used_quota_files', ARGV[3])
+end
+redis.call('HSET', KEYS[1], 'last_quota_update', ARGV[4])
+return 1`
+)
+
+// each user is stored as a hash, the quota and the last login are separate fields so they
+// can be updated atomically without reading the user. The users sorted set is the secondary
+// index used for listing: all the members have the same score, so they are sorted by username
+var (
+	redisUsersKey         = redisKeyPrefix + "users"
+	redisUsersIDIdxKey    = redisKeyPrefix + "users_id_idx"
+	redisUsersSeqKey      = redisKeyPrefix + "users_seq"
+	redisIPListsKey       = redisKeyPrefix + "ip_lists"
+	redisIPListsIdxKey    = redisKeyPrefix + "ip_lists_idx"
+	redisIPListsSeqKey    = redisKeyPrefix + "ip_lists_seq"
+	redisPlansKey         = redisKeyPrefix + "plans"
+	redisPlansIdxKey      = redisKeyPrefix + "plans_idx"
+	redisPlansSeqKey      = redisKeyPrefix + "plans_seq"
+	redisTemplatesKey     = redisKeyPrefix + "user_templates"
+	redisTemplatesIdxKey  = redisKeyPrefix + "user_templates_idx"
+	redisTemplatesSeqKey  = redisKeyPrefix + "user_templates_seq"
+	redisFoldersKey       = redisKeyPrefix + "folders"
+	redisFoldersIdxKey    = redisKeyPrefix + "folders_idx"
+	redisFoldersSeqKey    = redisKeyPrefix + "folders_seq"
+	redisSchemaVersionKey = redisKeyPrefix + "schema_version"
+)
+
+// redisTxCommands queues the commands to execute inside a transaction
+type redisTxCommands func(pipe redis.Pipeliner)
+
+// RedisProvider auth provider for Redis key/value store
+type RedisProvider struct {
+	dbHandle *redis.Client
+}
+
+func init() {
+	utils.AddFeature("+redis")
+}
+
+func initializeRedisProvider() error {
+	logSender = fmt.Sprintf("dataprovider_%v", RedisDataProviderName)
+	options, err := getRedisOptions()
+	if err != nil {
+		providerLog(logger.LevelWarn, "invalid redis configuration: %v", err)
+		return err
+	}
+	dbHandle := redis.NewClient(options)
+	err = dbHandle.Ping().Err()
+	if err != nil {
+		providerLog(logger.LevelWarn, "error connecting to redis server %#v: %v", options.Addr, err)
+		dbHandle.Close()
+		return err
+	}
+	providerLog(logger.LevelDebug, "redis connection pool created, server: %#v, database: %v, pool size: %v",
+		options.Addr, options.DB, options.PoolSize)
+	provider = RedisProvider{dbHandle: dbHandle}
+	return nil
+}
+
+// getRedisOptions builds the client options from the data provider configuration.
+// The connection string, if any, must be a redis:// or a rediss:// URL
+func getRedisOptions() (*redis.Options, error) {
+	var options *redis.Options
+	if len(config.ConnectionString) > 0 {
+		var err error
+		options, err = redis.ParseURL(config.ConnectionString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis connection string: %v", err)
+		}
+	} else {
+		port := config.Port
+		if port == 0 {
+			port = redisDefaultPort
+		}
+		options = &redis.Options{
+			Addr:     net.JoinHostPort(config.Host, strconv.Itoa(port)),
+			Username: config.Username,
+			Password: config.Password,
+		}
+		if len(config.Name) > 0 {
+			db, err := strconv.Atoi(config.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redis database number %#v", config.Name)
+			}
+			options.DB = db
+		}
+	}
+	if config.SSLMode > 0 || options.TLSConfig != nil {
+		host, _, _ := net.SplitHostPort(options.Addr)
+		options.TLSConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: config.SSLMode == 2,
+		}
+	}
+	options.DialTimeout = redisDialTimeout
+	options.ReadTimeout = redisCommandTimeout
+	options.WriteTimeout = redisCommandTimeout
+	if config.PoolSize > 0 {
+		options.PoolSize = config.PoolSize
+	}
+	return options, nil
+}
+
+func getRedisUserKey(username string) string {
+	return redisKeyPrefix + "user:" + username
+}
+
+func (p RedisProvider) checkAvailability() error {
+	return p.dbHandle.Ping().Err()
+}
+
+func (p RedisProvider) validateUserAndPass(username string, password string) (User, error) {
+	var user User
+	if len(password) == 0 {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(user, password)
+}
+
+func (p RedisProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(user, pubKey)
+}
+
+func (p RedisProvider) getUserByID(ID int64) (User, error) {
+	var user User
+	username, err := p.dbHandle.HGet(redisUsersIDIdxKey, strconv.FormatInt(ID, 10)).Result()
+	if err == redis.Nil {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("user with ID %v does not exist", ID)}
+	}
+	if err != nil {
+		return user, err
+	}
+	user, err = getRedisUser(p.dbHandle, username)
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("username %#v and ID: %v does not exist",
+			username, ID)}
+	}
+	return user, err
+}
+
+// updateUserFields updates the given user fields, a RecordNotFoundError is returned if the user does not exist
+func (p RedisProvider) updateUserFields(username, operation string, fields ...interface{}) error {
+	updated, err := p.dbHandle.Eval(redisUpdateIfExistsScript, []string{getRedisUserKey(username)}, fields...).Int64()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to %v", username, operation)}
+	}
+	return nil
+}
+
+func (p RedisProvider) updateLastLogin(username string) error {
+	return p.updateUserFields(username, "update last login", "last_login",
+		utils.GetTimeAsMsSinceEpoch(time.Now()))
+}
+
+func (p RedisProvider) updateUserPassword(username, password string) error {
+	return p.updateUserFields(username, "update password", "password", password)
+}
+
+func (p RedisProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	resetArg := "0"
+	if reset {
+		resetArg = "1"
+	}
+	updated, err := p.dbHandle.Eval(redisUpdateQuotaScript, []string{getRedisUserKey(username)}, resetArg, sizeAdd,
+		filesAdd, utils.GetTimeAsMsSinceEpoch(time.Now())).Int64()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to update quota", username)}
+	}
+	return nil
+}
+
+func (p RedisProvider) getUsedQuota(username string) (int, int64, error) {
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for user %v error: %v", username, err)
+		return 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, err
+}
+
+func (p RedisProvider) userExists(username string) (User, error) {
+	return getRedisUser(p.dbHandle, username)
+}
+
+func (p RedisProvider) addUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	user.ID, err = p.nextSequence(redisUsersSeqKey)
+	if err != nil {
+		return err
+	}
+	setUserCreationFields(&user)
+	data, err := getRedisUserData(user)
+	if err != nil {
+		return err
+	}
+	userKey := getRedisUserKey(user.Username)
+	return p.watch([]string{userKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		exists, err := tx.Exists(userKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists > 0 {
+			return nil, fmt.Errorf("username %v already exists", user.Username)
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(userKey, "id", user.ID, "data", data, "password", user.Password, "used_quota_size", user.UsedQuotaSize,
+				"used_quota_files", user.UsedQuotaFiles, "last_quota_update", user.LastQuotaUpdate, "last_login", user.LastLogin)
+			pipe.ZAdd(redisUsersKey, &redis.Z{Score: 0, Member: user.Username})
+			pipe.HSet(redisUsersIDIdxKey, user.ID, user.Username)
+		}, nil
+	})
+}
+
+// updateUser updates the user settings, the used quota and the last login are not modified
+func (p RedisProvider) updateUser(user User) error {
+	err := validateUser(&user)
+	if err != nil {
+		return err
+	}
+	userKey := getRedisUserKey(user.Username)
+	return p.watch([]string{userKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisUser(tx, user.Username)
+		if err != nil {
+			return nil, err
+		}
+		// the function runs again if the transaction is aborted, so the given user is not modified
+		u := user
+		if err = setUserUpdateFields(&u, existing); err != nil {
+			return nil, err
+		}
+		data, err := getRedisUserData(u)
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(userKey, "data", data, "password", u.Password)
+		}, nil
+	})
+}
+
+func (p RedisProvider) deleteUser(user User) error {
+	userKey := getRedisUserKey(user.Username)
+	return p.watch([]string{userKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		ID, err := tx.HGet(userKey, "id").Result()
+		if err == redis.Nil {
+			return nil, &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", user.Username)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.Del(userKey)
+			pipe.ZRem(redisUsersKey, user.Username)
+			pipe.HDel(redisUsersIDIdxKey, ID)
+		}, nil
+	})
+}
+
+func (p RedisProvider) getIPListEntries() ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	values, err := p.dbHandle.HVals(redisIPListsKey).Result()
+	if err != nil {
+		return entries, err
+	}
+	for _, v := range values {
+		var entry IPListEntry
+		err = json.Unmarshal([]byte(v), &entry)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+func (p RedisProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	return getRedisIPListEntry(p.dbHandle, ID)
+}
+
+func (p RedisProvider) addIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	// the ID is assigned by the provider
+	entry.ID, err = p.nextSequence(redisIPListsSeqKey)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return p.watch([]string{redisIPListsIdxKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		exists, err := tx.HExists(redisIPListsIdxKey, entry.IPOrNet).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisIPListsKey, entry.ID, buf)
+			pipe.HSet(redisIPListsIdxKey, entry.IPOrNet, entry.ID)
+		}, nil
+	})
+}
+
+func (p RedisProvider) updateIPListEntry(entry IPListEntry) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	keys := []string{redisIPListsKey, redisIPListsIdxKey}
+	return p.watch(keys, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisIPListEntry(tx, entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		ID, err := tx.HGet(redisIPListsIdxKey, entry.IPOrNet).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if err == nil && ID != strconv.FormatInt(entry.ID, 10) {
+			return nil, fmt.Errorf("IP list entry %v already exists", entry.IPOrNet)
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisIPListsKey, entry.ID, buf)
+			pipe.HSet(redisIPListsIdxKey, entry.IPOrNet, entry.ID)
+			if existing.IPOrNet != entry.IPOrNet {
+				pipe.HDel(redisIPListsIdxKey, existing.IPOrNet)
+			}
+		}, nil
+	})
+}
+
+func (p RedisProvider) deleteIPListEntry(entry IPListEntry) error {
+	keys := []string{redisIPListsKey, redisIPListsIdxKey}
+	return p.watch(keys, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisIPListEntry(tx, entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HDel(redisIPListsKey, strconv.FormatInt(existing.ID, 10))
+			pipe.HDel(redisIPListsIdxKey, existing.IPOrNet)
+		}, nil
+	})
+}
+
+func (p RedisProvider) getPlans() ([]Plan, error) {
+	plans := []Plan{}
+	values, err := p.dbHandle.HVals(redisPlansKey).Result()
+	if err != nil {
+		return plans, err
+	}
+	for _, v := range values {
+		var plan Plan
+		err = json.Unmarshal([]byte(v), &plan)
+		if err != nil {
+			return plans, err
+		}
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Name < plans[j].Name
+	})
+	return plans, nil
+}
+
+func (p RedisProvider) getPlanByID(ID int64) (Plan, error) {
+	return getRedisPlanByID(p.dbHandle, ID)
+}
+
+func (p RedisProvider) planExists(name string) (Plan, error) {
+	var plan Plan
+	ID, err := p.dbHandle.HGet(redisPlansIdxKey, name).Int64()
+	if err == redis.Nil {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan %#v does not exist", name)}
+	}
+	if err != nil {
+		return plan, err
+	}
+	return getRedisPlanByID(p.dbHandle, ID)
+}
+
+func (p RedisProvider) addPlan(plan Plan) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	plan.ID, err = p.nextSequence(redisPlansSeqKey)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	return p.watch([]string{redisPlansIdxKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		exists, err := tx.HExists(redisPlansIdxKey, plan.Name).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("plan %v already exists", plan.Name)
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisPlansKey, plan.ID, buf)
+			pipe.HSet(redisPlansIdxKey, plan.Name, plan.ID)
+		}, nil
+	})
+}
+
+// updatePlan updates the plan and, if applyToUsers is true, all the assigned users inside a single transaction
+func (p RedisProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	keys := []string{redisPlansKey, redisUsersKey}
+	return p.watch(keys, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisPlanByID(tx, plan.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Name != plan.Name {
+			return nil, &ValidationError{err: "the plan name cannot be changed"}
+		}
+		var users []User
+		if applyToUsers {
+			users, err = getRedisUsersWatched(tx)
+			if err != nil {
+				return nil, err
+			}
+			users, err = applyPlanToUsers(plan, users)
+			if err != nil {
+				return nil, err
+			}
+		}
+		usersData := make(map[string]string)
+		for _, user := range users {
+			data, err := getRedisUserData(user)
+			if err != nil {
+				return nil, err
+			}
+			usersData[getRedisUserKey(user.Username)] = data
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisPlansKey, plan.ID, buf)
+			for userKey, data := range usersData {
+				pipe.HSet(userKey, "data", data)
+			}
+		}, nil
+	})
+}
+
+func (p RedisProvider) deletePlan(plan Plan) error {
+	keys := []string{redisPlansKey, redisUsersKey}
+	return p.watch(keys, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisPlanByID(tx, plan.ID)
+		if err != nil {
+			return nil, err
+		}
+		users, err := getRedisUsersWatched(tx)
+		if err != nil {
+			return nil, err
+		}
+		numUsers := 0
+		for _, user := range users {
+			if user.Plan == existing.Name {
+				numUsers++
+			}
+		}
+		if numUsers > 0 {
+			return nil, &ValidationError{err: fmt.Sprintf("plan %#v is assigned to %v users and it cannot be deleted",
+				existing.Name, numUsers)}
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HDel(redisPlansKey, strconv.FormatInt(existing.ID, 10))
+			pipe.HDel(redisPlansIdxKey, existing.Name)
+		}, nil
+	})
+}
+
+func (p RedisProvider) getUserTemplates() ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	values, err := p.dbHandle.HVals(redisTemplatesKey).Result()
+	if err != nil {
+		return templates, err
+	}
+	for _, v := range values {
+		var template UserTemplate
+		err = json.Unmarshal([]byte(v), &template)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+func (p RedisProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	return getRedisUserTemplateByID(p.dbHandle, ID)
+}
+
+func (p RedisProvider) userTemplateExists(name string) (UserTemplate, error) {
+	var template UserTemplate
+	ID, err := p.dbHandle.HGet(redisTemplatesIdxKey, name).Int64()
+	if err == redis.Nil {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template %#v does not exist", name)}
+	}
+	if err != nil {
+		return template, err
+	}
+	return getRedisUserTemplateByID(p.dbHandle, ID)
+}
+
+func (p RedisProvider) addUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	template.ID, err = p.nextSequence(redisTemplatesSeqKey)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return p.watch([]string{redisTemplatesIdxKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		exists, err := tx.HExists(redisTemplatesIdxKey, template.Name).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("user template %v already exists", template.Name)
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisTemplatesKey, template.ID, buf)
+			pipe.HSet(redisTemplatesIdxKey, template.Name, template.ID)
+		}, nil
+	})
+}
+
+func (p RedisProvider) updateUserTemplate(template UserTemplate) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return p.watch([]string{redisTemplatesKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisUserTemplateByID(tx, template.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Name != template.Name {
+			return nil, &ValidationError{err: "the user template name cannot be changed"}
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisTemplatesKey, template.ID, buf)
+		}, nil
+	})
+}
+
+func (p RedisProvider) deleteUserTemplate(template UserTemplate) error {
+	return p.watch([]string{redisTemplatesKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisUserTemplateByID(tx, template.ID)
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HDel(redisTemplatesKey, strconv.FormatInt(existing.ID, 10))
+			pipe.HDel(redisTemplatesIdxKey, existing.Name)
+		}, nil
+	})
+}
+
+func (p RedisProvider) getFolders() ([]Folder, error) {
+	folders := []Folder{}
+	values, err := p.dbHandle.HVals(redisFoldersKey).Result()
+	if err != nil {
+		return folders, err
+	}
+	for _, v := range values {
+		var folder Folder
+		err = json.Unmarshal([]byte(v), &folder)
+		if err != nil {
+			return folders, err
+		}
+		folders = append(folders, folder)
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders, nil
+}
+
+func (p RedisProvider) getFolderByID(ID int64) (Folder, error) {
+	return getRedisFolderByID(p.dbHandle, ID)
+}
+
+func (p RedisProvider) folderExists(name string) (Folder, error) {
+	return getRedisFolderByName(p.dbHandle, name)
+}
+
+func (p RedisProvider) addFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	folder.ID, err = p.nextSequence(redisFoldersSeqKey)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(folder)
+	if err != nil {
+		return err
+	}
+	return p.watch([]string{redisFoldersIdxKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		exists, err := tx.HExists(redisFoldersIdxKey, folder.Name).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("folder %v already exists", folder.Name)
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisFoldersKey, folder.ID, buf)
+			pipe.HSet(redisFoldersIdxKey, folder.Name, folder.ID)
+		}, nil
+	})
+}
+
+func (p RedisProvider) updateFolder(folder Folder) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	return p.watch([]string{redisFoldersKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisFolderByID(tx, folder.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Name != folder.Name {
+			return nil, &ValidationError{err: "the folder name cannot be changed"}
+		}
+		folder.UsedQuotaSize = existing.UsedQuotaSize
+		folder.UsedQuotaFiles = existing.UsedQuotaFiles
+		folder.LastQuotaUpdate = existing.LastQuotaUpdate
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisFoldersKey, folder.ID, buf)
+		}, nil
+	})
+}
+
+func (p RedisProvider) deleteFolder(folder Folder) error {
+	return p.watch([]string{redisFoldersKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		existing, err := getRedisFolderByID(tx, folder.ID)
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HDel(redisFoldersKey, strconv.FormatInt(existing.ID, 10))
+			pipe.HDel(redisFoldersIdxKey, existing.Name)
+		}, nil
+	})
+}
+
+func (p RedisProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.watch([]string{redisFoldersKey}, func(tx *redis.Tx) (redisTxCommands, error) {
+		folder, err := getRedisFolderByName(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return nil, err
+		}
+		return func(pipe redis.Pipeliner) {
+			pipe.HSet(redisFoldersKey, folder.ID, buf)
+		}, nil
+	})
+}
+
+func (p RedisProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	folder, err := p.folderExists(name)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for folder %v error: %v", name, err)
+		return 0, 0, err
+	}
+	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
+}
+
+func (p RedisProvider) dumpUsers() ([]User, error) {
+	users := []User{}
+	usernames, err := p.dbHandle.ZRange(redisUsersKey, 0, -1).Result()
+	if err != nil {
+		return users, err
+	}
+	for _, username := range usernames {
+		user, err := getRedisUser(p.dbHandle, username)
+		if _, ok := err.(*RecordNotFoundError); ok {
+			// deleted after listing
+			continue
+		}
+		if err != nil {
+			return users, err
+		}
+		err = addCredentialsToUser(&user)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (p RedisProvider) getUserWithUsername(username string) ([]User, error) {
+	users := []User{}
+	var user User
+	user, err := p.userExists(username)
+	if err == nil {
+		users = append(users, HideUserSensitiveData(&user))
+		return users, nil
+	}
+	if _, ok := err.(*RecordNotFoundError); ok {
+		err = nil
+	}
+	return users, err
+}
+
+func (p RedisProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
+	users := []User{}
+	var err error
+	if limit <= 0 {
+		return users, err
+	}
+	if len(username) > 0 {
+		if offset == 0 {
+			return p.getUserWithUsername(username)
+		}
+		return users, err
+	}
+	var usernames []string
+	if order == "ASC" {
+		usernames, err = p.dbHandle.ZRange(redisUsersKey, int64(offset), int64(offset+limit-1)).Result()
+	} else {
+		usernames, err = p.dbHandle.ZRevRange(redisUsersKey, int64(offset), int64(offset+limit-1)).Result()
+	}
+	if err != nil {
+		return users, err
+	}
+	for _, name := range usernames {
+		user, err := getRedisUser(p.dbHandle, name)
+		if err == nil {
+			users = append(users, HideUserSensitiveData(&user))
+		} else if _, ok := err.(*RecordNotFoundError); !ok {
+			return users, err
+		}
+	}
+	return users, nil
+}
+
+func (p RedisProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return getUsersPageFromList(p, UserScope{}, req, cursor)
+}
+
+func (p RedisProvider) close() error {
+	return p.dbHandle.Close()
+}
+
+func (p RedisProvider) reloadConfig() error {
+	return nil
+}
+
+// initializeDatabase does nothing, no initilization is needed for redis provider
+func (p RedisProvider) initializeDatabase() error {
+	return errNoInitRequired
+}
+
+func (p RedisProvider) migrateDatabase() error {
+	// the schema version is set at the first startup
+	err := p.dbHandle.SetNX(redisSchemaVersionKey, redisDatabaseVersion, 0).Err()
+	if err != nil {
+		return err
+	}
+	version, err := p.dbHandle.Get(redisSchemaVersionKey).Int64()
+	if err != nil {
+		return err
+	}
+	if version == redisDatabaseVersion {
+		providerLog(logger.LevelDebug, "redis database is updated, current version: %v", version)
+		return nil
+	}
+	return fmt.Errorf("Database version not handled: %v", version)
+}
+
+func (p RedisProvider) nextSequence(key string) (int64, error) {
+	return p.dbHandle.Incr(key).Result()
+}
+
+// watch runs fn after watching the given keys, fn can watch additional keys and it returns the
+// commands to execute inside the transaction. The transaction is retried if a watched key is
+// modified before the commands are executed
+func (p RedisProvider) watch(keys []string, fn func(tx *redis.Tx) (redisTxCommands, error)) error {
+	for i := 0; i < redisMaxTxRetries; i++ {
+		err := p.dbHandle.Watch(func(tx *redis.Tx) error {
+			commands, err := fn(tx)
+			if err != nil || commands == nil {
+				return err
+			}
+			_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+				commands(pipe)
+				return nil
+			})
+			return err
+		}, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("redis transaction aborted %v times, too many concurrent updates", redisMaxTxRetries)
+}
+
+// getRedisUserData returns the user serialized as JSON without the fields stored
+// inside dedicated hash fields
+func getRedisUserData(user User) (string, error) {
+	user.ID = 0
+	user.Password = ""
+	user.UsedQuotaSize = 0
+	user.UsedQuotaFiles = 0
+	user.LastQuotaUpdate = 0
+	user.LastLogin = 0
+	buf, err := json.Marshal(user)
+	return string(buf), err
+}
+
+func getRedisUser(c redis.Cmdable, username string) (User, error) {
+	var user User
+	fields, err := c.HGetAll(getRedisUserKey(username)).Result()
+	if err != nil {
+		return user, err
+	}
+	if len(fields) == 0 {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", username)}
+	}
+	err = json.Unmarshal([]byte(fields["data"]), &user)
+	if err != nil {
+		return user, err
+	}
+	user.Password = fields["password"]
+	if user.ID, err = getRedisInt(fields["id"]); err != nil {
+		return user, err
+	}
+	if user.UsedQuotaSize, err = getRedisInt(fields["used_quota_size"]); err != nil {
+		return user, err
+	}
+	usedFiles, err := getRedisInt(fields["used_quota_files"])
+	if err != nil {
+		return user, err
+	}
+	user.UsedQuotaFiles = int(usedFiles)
+	if user.LastQuotaUpdate, err = getRedisInt(fields["last_quota_update"]); err != nil {
+		return user, err
+	}
+	user.LastLogin, err = getRedisInt(fields["last_login"])
+	return user, err
+}
+
+// getRedisInt converts a numeric hash field to an int64, missing fields are converted to 0
+func getRedisInt(value string) (int64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// getRedisUsersWatched returns all the users, their keys are watched so a transaction
+// fails if a user is modified after reading it
+func getRedisUsersWatched(tx *redis.Tx) ([]User, error) {
+	var users []User
+	usernames, err := tx.ZRange(redisUsersKey, 0, -1).Result()
+	if err != nil || len(usernames) == 0 {
+		return users, err
+	}
+	var keys []string
+	for _, username := range usernames {
+		keys = append(keys, getRedisUserKey(username))
+	}
+	if err = tx.Watch(keys...).Err(); err != nil {
+		return users, err
+	}
+	for _, username := range usernames {
+		user, err := getRedisUser(tx, username)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func getRedisIPListEntry(c redis.Cmdable, ID int64) (IPListEntry, error) {
+	var entry IPListEntry
+	value, err := c.HGet(redisIPListsKey, strconv.FormatInt(ID, 10)).Result()
+	if err == redis.Nil {
+		return entry, &RecordNotFoundError{err: fmt.Sprintf("IP list entry with ID %v does not exist", ID)}
+	}
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal([]byte(value), &entry)
+	return entry, err
+}
+
+func getRedisPlanByID(c redis.Cmdable, ID int64) (Plan, error) {
+	var plan Plan
+	value, err := c.HGet(redisPlansKey, strconv.FormatInt(ID, 10)).Result()
+	if err == redis.Nil {
+		return plan, &RecordNotFoundError{err: fmt.Sprintf("plan with ID %v does not exist", ID)}
+	}
+	if err != nil {
+		return plan, err
+	}
+	err = json.Unmarshal([]byte(value), &plan)
+	return plan, err
+}
+
+func getRedisUserTemplateByID(c redis.Cmdable, ID int64) (UserTemplate, error) {
+	var template UserTemplate
+	value, err := c.HGet(redisTemplatesKey, strconv.FormatInt(ID, 10)).Result()
+	if err == redis.Nil {
+		return template, &RecordNotFoundError{err: fmt.Sprintf("user template with ID %v does not exist", ID)}
+	}
+	if err != nil {
+		return template, err
+	}
+	err = json.Unmarshal([]byte(value), &template)
+	return template, err
+}
+
+func getRedisFolderByID(c redis.Cmdable, ID int64) (Folder, error) {
+	var folder Folder
+	value, err := c.HGet(redisFoldersKey, strconv.FormatInt(ID, 10)).Result()
+	if err == redis.Nil {
+		return folder, &RecordNotFoundError{err: fmt.Sprintf("folder with ID %v does not exist", ID)}
+	}
+	if err != nil {
+		return folder, err
+	}
+	err = json.Unmarshal([]byte(value), &folder)
+	return folder, err
+}
+
+func getRedisFolderByName(c redis.Cmdable, name string) (Folder, error) {
+	ID, err := c.HGet(redisFoldersIdxKey, name).Int64()
+	if err == redis.Nil {
+		return Folder{}, &RecordNotFoundError{err: fmt.Sprintf("folder %#v does not exist", name)}
+	}
+	if err != nil {
+		return Folder{}, err
+	}
+	return getRedisFolderByID(c, ID)
+}
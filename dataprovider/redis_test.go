@@ -0,0 +1,562 @@
+package dataprovider
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type redisTestStatus string
+
+type redisTestError string
+
+type redisTestNullArray struct{}
+
+// redisTestServer implements the subset of the Redis commands used by the redis provider,
+// the EVAL command supports the provider scripts only
+type redisTestServer struct {
+	listener net.Listener
+	password string
+	sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]bool
+	// incremented each time a key is modified, used for WATCH
+	versions map[string]int64
+	// number of transactions to abort as if a watched key was modified
+	abortExecs int
+	failing    bool
+}
+
+type redisTestConn struct {
+	authenticated bool
+	watched       map[string]int64
+	inMulti       bool
+	queued        [][]string
+}
+
+func newRedisTestServer(t *testing.T, password string) *redisTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	s := &redisTestServer{
+		listener: listener,
+		password: password,
+		strings:  make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+		zsets:    make(map[string]map[string]bool),
+		versions: make(map[string]int64),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *redisTestServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *redisTestServer) close() {
+	s.listener.Close()
+}
+
+func (s *redisTestServer) setFailing(failing bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failing = failing
+}
+
+func (s *redisTestServer) setAbortExecs(count int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.abortExecs = count
+}
+
+func (s *redisTestServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	state := &redisTestConn{authenticated: len(s.password) == 0}
+	for {
+		args, err := readRedisTestCommand(reader)
+		if err != nil {
+			return
+		}
+		writeRedisTestReply(writer, s.handleCommand(state, args))
+		if reader.Buffered() == 0 {
+			if err = writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readRedisTestCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:length]))
+	}
+	return args, nil
+}
+
+func writeRedisTestReply(writer *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case redisTestStatus:
+		fmt.Fprintf(writer, "+%v\r\n", v)
+	case redisTestError:
+		fmt.Fprintf(writer, "-%v\r\n", v)
+	case int64:
+		fmt.Fprintf(writer, ":%v\r\n", v)
+	case string:
+		fmt.Fprintf(writer, "$%v\r\n%v\r\n", len(v), v)
+	case nil:
+		writer.WriteString("$-1\r\n")
+	case redisTestNullArray:
+		writer.WriteString("*-1\r\n")
+	case []interface{}:
+		fmt.Fprintf(writer, "*%v\r\n", len(v))
+		for _, item := range v {
+			writeRedisTestReply(writer, item)
+		}
+	}
+}
+
+func (s *redisTestServer) handleCommand(state *redisTestConn, args []string) interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(args) == 0 {
+		return redisTestError("ERR empty command")
+	}
+	cmd := strings.ToUpper(args[0])
+	if s.failing {
+		return redisTestError("ERR injected failure")
+	}
+	if cmd == "AUTH" {
+		if args[len(args)-1] != s.password {
+			return redisTestError("WRONGPASS invalid password")
+		}
+		state.authenticated = true
+		return redisTestStatus("OK")
+	}
+	if !state.authenticated {
+		return redisTestError("NOAUTH Authentication required")
+	}
+	switch cmd {
+	case "MULTI":
+		state.inMulti = true
+		state.queued = nil
+		return redisTestStatus("OK")
+	case "EXEC":
+		return s.exec(state)
+	case "WATCH":
+		if state.watched == nil {
+			state.watched = make(map[string]int64)
+		}
+		for _, key := range args[1:] {
+			if _, ok := state.watched[key]; !ok {
+				state.watched[key] = s.versions[key]
+			}
+		}
+		return redisTestStatus("OK")
+	case "UNWATCH":
+		state.watched = nil
+		return redisTestStatus("OK")
+	}
+	if state.inMulti {
+		state.queued = append(state.queued, args)
+		return redisTestStatus("QUEUED")
+	}
+	return s.execute(args)
+}
+
+func (s *redisTestServer) exec(state *redisTestConn) interface{} {
+	queued := state.queued
+	watched := state.watched
+	state.inMulti = false
+	state.queued = nil
+	state.watched = nil
+	if s.abortExecs > 0 {
+		s.abortExecs--
+		return redisTestNullArray{}
+	}
+	for key, version := range watched {
+		if s.versions[key] != version {
+			return redisTestNullArray{}
+		}
+	}
+	results := []interface{}{}
+	for _, args := range queued {
+		results = append(results, s.execute(args))
+	}
+	return results
+}
+
+func (s *redisTestServer) touch(key string) {
+	s.versions[key]++
+}
+
+func (s *redisTestServer) exists(key string) bool {
+	if _, ok := s.strings[key]; ok {
+		return true
+	}
+	if _, ok := s.hashes[key]; ok {
+		return true
+	}
+	_, ok := s.zsets[key]
+	return ok
+}
+
+func (s *redisTestServer) hset(key string, fields []string) int64 {
+	hash, ok := s.hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		s.hashes[key] = hash
+	}
+	var added int64
+	for i := 0; i+1 < len(fields); i += 2 {
+		if _, ok := hash[fields[i]]; !ok {
+			added++
+		}
+		hash[fields[i]] = fields[i+1]
+	}
+	s.touch(key)
+	return added
+}
+
+func (s *redisTestServer) hincrby(key, field string, increment string) (int64, error) {
+	incr, err := strconv.ParseInt(increment, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	hash, ok := s.hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		s.hashes[key] = hash
+	}
+	value := int64(0)
+	if len(hash[field]) > 0 {
+		if value, err = strconv.ParseInt(hash[field], 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	value += incr
+	hash[field] = strconv.FormatInt(value, 10)
+	s.touch(key)
+	return value, nil
+}
+
+func (s *redisTestServer) zrange(key, start, stop string, reverse bool) interface{} {
+	members := []string{}
+	for member := range s.zsets[key] {
+		members = append(members, member)
+	}
+	// all the members have the same score, so they are sorted lexicographically
+	sort.Strings(members)
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(members)))
+	}
+	first, _ := strconv.Atoi(start)
+	last, _ := strconv.Atoi(stop)
+	if first < 0 {
+		first += len(members)
+	}
+	if last < 0 {
+		last += len(members)
+	}
+	if last >= len(members) {
+		last = len(members) - 1
+	}
+	result := []interface{}{}
+	for i := first; i <= last && i >= 0; i++ {
+		result = append(result, members[i])
+	}
+	return result
+}
+
+func (s *redisTestServer) execute(args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return redisTestStatus("PONG")
+	case "SELECT":
+		return redisTestStatus("OK")
+	case "EXISTS":
+		var count int64
+		for _, key := range args[1:] {
+			if s.exists(key) {
+				count++
+			}
+		}
+		return count
+	case "GET":
+		if value, ok := s.strings[args[1]]; ok {
+			return value
+		}
+		return nil
+	case "SETNX":
+		if s.exists(args[1]) {
+			return int64(0)
+		}
+		s.strings[args[1]] = args[2]
+		s.touch(args[1])
+		return int64(1)
+	case "INCR":
+		value, _ := strconv.ParseInt(s.strings[args[1]], 10, 64)
+		value++
+		s.strings[args[1]] = strconv.FormatInt(value, 10)
+		s.touch(args[1])
+		return value
+	case "DEL":
+		var count int64
+		for _, key := range args[1:] {
+			if s.exists(key) {
+				count++
+				delete(s.strings, key)
+				delete(s.hashes, key)
+				delete(s.zsets, key)
+				s.touch(key)
+			}
+		}
+		return count
+	case "HSET":
+		return s.hset(args[1], args[2:])
+	case "HGET":
+		if value, ok := s.hashes[args[1]][args[2]]; ok {
+			return value
+		}
+		return nil
+	case "HEXISTS":
+		if _, ok := s.hashes[args[1]][args[2]]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	case "HGETALL":
+		result := []interface{}{}
+		for field, value := range s.hashes[args[1]] {
+			result = append(result, field, value)
+		}
+		return result
+	case "HVALS":
+		result := []interface{}{}
+		for _, value := range s.hashes[args[1]] {
+			result = append(result, value)
+		}
+		return result
+	case "HDEL":
+		var count int64
+		for _, field := range args[2:] {
+			if _, ok := s.hashes[args[1]][field]; ok {
+				delete(s.hashes[args[1]], field)
+				count++
+			}
+		}
+		if len(s.hashes[args[1]]) == 0 {
+			delete(s.hashes, args[1])
+		}
+		s.touch(args[1])
+		return count
+	case "HINCRBY":
+		value, err := s.hincrby(args[1], args[2], args[3])
+		if err != nil {
+			return redisTestError("ERR hash value is not an integer")
+		}
+		return value
+	case "ZADD":
+		zset, ok := s.zsets[args[1]]
+		if !ok {
+			zset = make(map[string]bool)
+			s.zsets[args[1]] = zset
+		}
+		var added int64
+		for i := 3; i < len(args); i += 2 {
+			if !zset[args[i]] {
+				added++
+			}
+			zset[args[i]] = true
+		}
+		s.touch(args[1])
+		return added
+	case "ZREM":
+		var count int64
+		for _, member := range args[2:] {
+			if s.zsets[args[1]][member] {
+				delete(s.zsets[args[1]], member)
+				count++
+			}
+		}
+		if len(s.zsets[args[1]]) == 0 {
+			delete(s.zsets, args[1])
+		}
+		s.touch(args[1])
+		return count
+	case "ZRANGE":
+		return s.zrange(args[1], args[2], args[3], false)
+	case "ZREVRANGE":
+		return s.zrange(args[1], args[2], args[3], true)
+	case "EVAL":
+		return s.eval(args)
+	}
+	return redisTestError(fmt.Sprintf("ERR unknown command '%v'", args[0]))
+}
+
+func (s *redisTestServer) eval(args []string) interface{} {
+	// EVAL script 1 key args...
+	key := args[3]
+	argv := args[4:]
+	if !s.exists(key) {
+		return int64(0)
+	}
+	switch args[1] {
+	case redisUpdateIfExistsScript:
+		s.hset(key, argv)
+	case redisUpdateQuotaScript:
+		if argv[0] == "1" {
+			s.hset(key, []string{"used_quota_size", argv[1], "used_quota_files", argv[2]})
+		} else {
+			s.hincrby(key, "used_quota_size", argv[1])
+			s.hincrby(key, "used_quota_files", argv[2])
+		}
+		s.hset(key, []string{"last_quota_update", argv[3]})
+	default:
+		return redisTestError("NOSCRIPT unsupported script")
+	}
+	return int64(1)
+}
+
+func TestRedisProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	server := newRedisTestServer(t, "secret")
+	defer server.close()
+
+	config = Config{
+		Driver:           RedisDataProviderName,
+		ConnectionString: "redis://:wrong@" + server.listener.Addr().String() + "/1",
+	}
+	if err = initializeRedisProvider(); err == nil {
+		t.Error("a wrong password must be rejected")
+	}
+	config.ConnectionString = "http://" + server.listener.Addr().String()
+	if err = initializeRedisProvider(); err == nil {
+		t.Error("an unsupported connection string scheme must fail")
+	}
+	config.ConnectionString = "redis://:secret@" + server.listener.Addr().String() + "/1"
+	if err = initializeRedisProvider(); err != nil {
+		t.Fatalf("unable to initialize the redis provider: %v", err)
+	}
+	p := provider
+	if err = p.migrateDatabase(); err != nil {
+		t.Errorf("unable to migrate the database: %v", err)
+	}
+	server.Lock()
+	version := server.strings[redisSchemaVersionKey]
+	server.Unlock()
+	if version != strconv.Itoa(redisDatabaseVersion) {
+		t.Errorf("unexpected schema version: %#v", version)
+	}
+
+	testProviderUsers(t, p, dir)
+	if err = p.updateUserPassword("another_user", "$2a$10$updated"); err != nil {
+		t.Errorf("unable to update password: %v", err)
+	}
+	checkRecordNotFound(t, "update password for a missing user", p.updateUserPassword("missing_user", "pwd"))
+	user, err := p.userExists("another_user")
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if user.Password != "$2a$10$updated" {
+		t.Errorf("unexpected password: %#v", user.Password)
+	}
+	// an aborted transaction is retried
+	server.setAbortExecs(2)
+	user.MaxSessions = 5
+	if err = p.updateUser(user); err != nil {
+		t.Errorf("unable to update user after aborted transactions: %v", err)
+	}
+	server.setAbortExecs(redisMaxTxRetries)
+	if err = p.deleteUser(user); err == nil {
+		t.Error("deleting a user must fail if the transaction is always aborted")
+	}
+	server.setAbortExecs(0)
+	users, err := p.dumpUsers()
+	if err != nil {
+		t.Errorf("unable to dump users: %v", err)
+	} else if len(users) != 1 || users[0].MaxSessions != 5 {
+		t.Errorf("unexpected users: %+v", users)
+	}
+
+	server.setFailing(true)
+	if err = p.checkAvailability(); err == nil {
+		t.Error("the availability check must fail")
+	}
+	if _, err = p.userExists(user.Username); err == nil {
+		t.Error("getting a user must fail")
+	}
+	if err = p.addUser(getTestUser(dir, "failing_user")); err == nil {
+		t.Error("adding a user must fail")
+	}
+	if err = p.updateQuota(user.Username, 1, 1, false); err == nil {
+		t.Error("updating the quota must fail")
+	}
+	if _, err = p.getUsers(10, 0, "ASC", ""); err == nil {
+		t.Error("getting the users must fail")
+	}
+	server.setFailing(false)
+	checkUsedQuota(t, p, user.Username, 0, 0)
+
+	if err = p.close(); err != nil {
+		t.Errorf("unable to close the provider: %v", err)
+	}
+	if err = p.checkAvailability(); err == nil {
+		t.Error("a closed provider must fail")
+	}
+}
@@ -0,0 +1,142 @@
+package dataprovider
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const usernamePlaceholder = "%username%"
+
+var s3TenantPolicyTemplate string
+
+// S3TenantsConfig defines the helpers for the users sharing an S3 bucket, each one inside
+// its own key prefix. The helpers are executed when a user with an S3 filesystem is added,
+// or when its bucket, key prefix or access key change, using the REST API.
+// A failed helper does not prevent to save the user, a warning is returned instead
+type S3TenantsConfig struct {
+	// Key prefix for the users added with an S3 filesystem and an empty key prefix, for example
+	// "tenants/%username%/". The "%username%" placeholder, allowed inside the key prefix
+	// of any S3 user, is replaced with the username. Empty means no default key prefix
+	KeyPrefix string `json:"key_prefix" mapstructure:"key_prefix"`
+	// Create the "folder" object for the key prefix, using the default AWS credential chain
+	CreatePrefix bool `json:"create_prefix" mapstructure:"create_prefix"`
+	// Path to a JSON file with a bucket policy statement to add for each key prefix, using the
+	// default AWS credential chain. The "%bucket%", "%prefix%" and "%username%" placeholders are
+	// replaced. This can be an absolute path or a path relative to the config dir. Empty to disable
+	PolicyFile string `json:"policy_file" mapstructure:"policy_file"`
+	// Verify, using the user credentials, that the key prefix can be listed while the bucket
+	// root cannot
+	VerifyIsolation bool `json:"verify_isolation" mapstructure:"verify_isolation"`
+}
+
+func validateS3Tenants(basePath string) error {
+	c := &config.S3Tenants
+	s3TenantPolicyTemplate = ""
+	if len(c.KeyPrefix) > 0 {
+		if strings.HasPrefix(c.KeyPrefix, "/") || !strings.HasSuffix(c.KeyPrefix, "/") {
+			return fmt.Errorf("invalid S3 tenants key prefix %#v, it must end with \"/\" and must not start with \"/\"",
+				c.KeyPrefix)
+		}
+	}
+	if len(c.PolicyFile) == 0 {
+		return nil
+	}
+	policyFile := c.PolicyFile
+	if !filepath.IsAbs(policyFile) {
+		policyFile = filepath.Join(basePath, policyFile)
+	}
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read the S3 tenants policy file: %v", err)
+	}
+	s3TenantPolicyTemplate = string(data)
+	if _, err = getS3TenantPolicyStatement("bucket", "prefix/", "username"); err != nil {
+		s3TenantPolicyTemplate = ""
+		return err
+	}
+	return nil
+}
+
+// getS3TenantPolicyStatement returns the bucket policy statement for the given key prefix
+func getS3TenantPolicyStatement(bucket, keyPrefix, username string) (map[string]interface{}, error) {
+	replacements := []string{"%bucket%", bucket, "%prefix%", keyPrefix, usernamePlaceholder, username}
+	// the values are escaped, so they are safe inside JSON strings
+	for idx := 1; idx < len(replacements); idx += 2 {
+		escaped, err := json.Marshal(replacements[idx])
+		if err != nil {
+			return nil, err
+		}
+		replacements[idx] = strings.Trim(string(escaped), "\"")
+	}
+	var statement map[string]interface{}
+	err := json.Unmarshal([]byte(strings.NewReplacer(replacements...).Replace(s3TenantPolicyTemplate)), &statement)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 tenants policy statement, it must be a JSON object: %v", err)
+	}
+	return statement, nil
+}
+
+// applyS3TenantKeyPrefix sets the default key prefix, if configured, for a new user and
+// replaces the username placeholder
+func applyS3TenantKeyPrefix(user *User, isAdd bool) {
+	if user.FsConfig.Provider != 1 {
+		return
+	}
+	if isAdd && len(user.FsConfig.S3Config.KeyPrefix) == 0 {
+		user.FsConfig.S3Config.KeyPrefix = config.S3Tenants.KeyPrefix
+	}
+	user.FsConfig.S3Config.KeyPrefix = strings.Replace(user.FsConfig.S3Config.KeyPrefix,
+		usernamePlaceholder, user.Username, -1)
+}
+
+// getS3TenantPolicySid returns the bucket policy statement ID for the given user,
+// the statement IDs can contain alphanumeric characters only
+func getS3TenantPolicySid(username string) string {
+	return "SFTPGo" + hex.EncodeToString([]byte(username))
+}
+
+// ApplyS3TenantHelpers executes the configured S3 tenants helpers for the given user,
+// as stored inside the data provider, and returns a warning for each failed helper
+func ApplyS3TenantHelpers(user User) []string {
+	var warnings []string
+	c := config.S3Tenants
+	s3Config := user.FsConfig.S3Config
+	if user.FsConfig.Provider != 1 || len(s3Config.KeyPrefix) == 0 {
+		return warnings
+	}
+	if c.CreatePrefix {
+		if err := vfs.CreateS3KeyPrefix(s3Config); err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to create the key prefix %#v: %v", s3Config.KeyPrefix, err))
+		}
+	}
+	if len(s3TenantPolicyTemplate) > 0 {
+		statement, err := getS3TenantPolicyStatement(s3Config.Bucket, s3Config.KeyPrefix, user.Username)
+		if err == nil {
+			err = vfs.ApplyS3KeyPrefixPolicy(s3Config, getS3TenantPolicySid(user.Username), statement)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to apply the bucket policy for the key prefix %#v: %v",
+				s3Config.KeyPrefix, err))
+		}
+	}
+	if c.VerifyIsolation {
+		err := vfs.CheckS3KeyPrefixIsolation(s3Config)
+		if err == vfs.ErrS3PrefixNotIsolated {
+			warnings = append(warnings, fmt.Sprintf("the key prefix %#v is not isolated: %v", s3Config.KeyPrefix, err))
+		} else if err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to verify the isolation for the key prefix %#v: %v",
+				s3Config.KeyPrefix, err))
+		}
+	}
+	for _, warning := range warnings {
+		providerLog(logger.LevelWarn, "S3 tenants helpers for user %#v: %v", user.Username, warning)
+	}
+	return warnings
+}
@@ -0,0 +1,152 @@
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// page size used to load the users to filter when listing the users in a scope
+const scopeQueryLimit = 500
+
+// UserScope restricts the users an admin can manage to the users with one of the given
+// tenants or with a username starting with one of the given prefixes.
+// An empty scope includes all the users
+type UserScope struct {
+	// the comparison is case insensitive as for the connections filter
+	Tenants          []string `json:"tenants,omitempty" mapstructure:"tenants"`
+	UsernamePrefixes []string `json:"username_prefixes,omitempty" mapstructure:"username_prefixes"`
+}
+
+// IsEmpty returns true if the scope includes all the users
+func (s *UserScope) IsEmpty() bool {
+	return len(s.Tenants) == 0 && len(s.UsernamePrefixes) == 0
+}
+
+// Validate returns an error if the scope contains empty tenants or prefixes
+func (s *UserScope) Validate() error {
+	for _, tenant := range s.Tenants {
+		if len(strings.TrimSpace(tenant)) == 0 {
+			return errors.New("empty tenants are not allowed")
+		}
+	}
+	for _, prefix := range s.UsernamePrefixes {
+		if len(prefix) == 0 {
+			return errors.New("empty username prefixes are not allowed")
+		}
+	}
+	return nil
+}
+
+// IsInScope returns true if a user with the given username and tenant is included in the scope
+func (s *UserScope) IsInScope(username, tenant string) bool {
+	if s.IsEmpty() {
+		return true
+	}
+	for _, t := range s.Tenants {
+		if len(tenant) > 0 && strings.EqualFold(strings.TrimSpace(t), tenant) {
+			return true
+		}
+	}
+	for _, prefix := range s.UsernamePrefixes {
+		if strings.HasPrefix(username, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUserInScope returns true if the given user is included in the scope
+func (s *UserScope) IsUserInScope(user *User) bool {
+	return s.IsInScope(user.Username, user.Filters.Tenant)
+}
+
+// GetUsersInScope returns an array of users respecting limit and offset and filtered by
+// username exact match if not empty. Only the users included in the given scope are returned
+func GetUsersInScope(p Provider, scope UserScope, limit int, offset int, order string, username string) ([]User, error) {
+	if scope.IsEmpty() {
+		return GetUsers(p, limit, offset, order, username)
+	}
+	users := []User{}
+	if limit <= 0 {
+		return users, nil
+	}
+	skipped := 0
+	providerOffset := 0
+	for {
+		batch, err := GetUsers(p, scopeQueryLimit, providerOffset, order, username)
+		if err != nil {
+			return users, err
+		}
+		for _, user := range batch {
+			if !scope.IsUserInScope(&user) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			users = append(users, user)
+			if len(users) >= limit {
+				return users, nil
+			}
+		}
+		if len(batch) < scopeQueryLimit {
+			return users, nil
+		}
+		providerOffset += len(batch)
+	}
+}
+
+// GetUserByIDInScope returns the user with the given database ID if a match is found and
+// the user is included in the given scope
+func GetUserByIDInScope(p Provider, scope UserScope, ID int64) (User, error) {
+	user, err := GetUserByID(p, ID)
+	if err == nil && !scope.IsUserInScope(&user) {
+		return User{}, &RecordNotFoundError{err: fmt.Sprintf("user with ID %v does not exist", ID)}
+	}
+	return user, err
+}
+
+// UserExistsInScope checks if the given SFTP username exists and if it is included in the
+// given scope, returns an error if no match is found
+func UserExistsInScope(p Provider, scope UserScope, username string) (User, error) {
+	user, err := UserExists(p, username)
+	if err == nil && !scope.IsUserInScope(&user) {
+		return User{}, &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist", username)}
+	}
+	return user, err
+}
+
+// AddUserInScope adds a new SFTP user if it is included in the given scope
+func AddUserInScope(p Provider, scope UserScope, user User) error {
+	if !scope.IsUserInScope(&user) {
+		return &ValidationError{err: fmt.Sprintf("the user %#v is outside the allowed scope", user.Username)}
+	}
+	return AddUser(p, user)
+}
+
+// UpdateUserInScope updates an existing SFTP user. Both the stored user and the updated one
+// must be included in the given scope, so a user cannot be moved outside the scope
+func UpdateUserInScope(p Provider, scope UserScope, user User) error {
+	if !scope.IsEmpty() {
+		if _, err := GetUserByIDInScope(p, scope, user.ID); err != nil {
+			return err
+		}
+		if !scope.IsUserInScope(&user) {
+			return &ValidationError{err: fmt.Sprintf("the user %#v cannot be moved outside the allowed scope",
+				user.Username)}
+		}
+	}
+	return UpdateUser(p, user)
+}
+
+// DeleteUserInScope deletes an existing SFTP user if it is included in the given scope
+func DeleteUserInScope(p Provider, scope UserScope, user User) error {
+	if !scope.IsEmpty() {
+		if _, err := GetUserByIDInScope(p, scope, user.ID); err != nil {
+			return err
+		}
+	}
+	return DeleteUser(p, user)
+}
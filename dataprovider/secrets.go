@@ -0,0 +1,157 @@
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// getSecrets returns the encrypted secrets for the user filesystem and for the filesystems
+// of its virtual folders. The GCS credentials are stored inside the data provider only for
+// the user templates, for the users they are saved to a file
+func (u *User) getSecrets() []*string {
+	secrets := []*string{
+		&u.FsConfig.S3Config.AccessSecret,
+		&u.FsConfig.S3Config.SessionToken,
+		&u.FsConfig.GCSConfig.Credentials,
+		&u.FsConfig.CryptConfig.Passphrase,
+		&u.FsConfig.WebDAVConfig.Password,
+		&u.FsConfig.WebDAVConfig.BearerToken,
+		&u.FsConfig.HDFSConfig.DelegationToken,
+		&u.FsConfig.GoogleDriveConfig.Credentials,
+		&u.FsConfig.GoogleDriveConfig.ClientSecret,
+		&u.FsConfig.GoogleDriveConfig.RefreshToken,
+		&u.FsConfig.DropboxConfig.AccessToken,
+		&u.FsConfig.DropboxConfig.RefreshToken,
+		&u.FsConfig.DropboxConfig.AppSecret,
+	}
+	for idx := range u.VirtualFolders {
+		folder := &u.VirtualFolders[idx]
+		if folder.HasFilesystem() {
+			secrets = append(secrets, &folder.Filesystem.S3Config.AccessSecret, &folder.Filesystem.S3Config.SessionToken,
+				&folder.Filesystem.GCSConfig.Credentials)
+		}
+	}
+	return secrets
+}
+
+// rotateGCSCredentials encrypts the GCS credentials file with the current master key
+func (u *User) rotateGCSCredentials() (bool, error) {
+	if u.FsConfig.Provider != 2 || u.FsConfig.GCSConfig.AutomaticCredentials > 0 {
+		return false, nil
+	}
+	credentialsFilePath := u.getGCSCredentialsFilePath()
+	content, err := ioutil.ReadFile(credentialsFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if kms.IsEncryptedWithMasterKey(string(content)) {
+		return false, nil
+	}
+	credentials := string(content)
+	if kms.IsEncrypted(credentials) {
+		credentials, err = kms.Decrypt(credentials)
+		if err != nil {
+			return false, err
+		}
+	}
+	encrypted, err := kms.Encrypt(credentials)
+	if err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(credentialsFilePath, []byte(encrypted), 0600)
+}
+
+// RotateUserSecrets re-encrypts, with the current master key, the secrets for the user with the
+// given username that are encrypted with an old master key or without a master key.
+// It returns the number of re-encrypted secrets. The user is updated inside the data provider
+// only if at least a secret stored inside the data provider changes, so it is safe to call
+// this method again for the users already processed, for example to resume an interrupted rotation
+func RotateUserSecrets(p Provider, username string) (int, error) {
+	if !kms.IsMasterKeyEnabled() {
+		return 0, errors.New("a master key is required to rotate the secrets")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		return 0, err
+	}
+	rotated, err := rotateSecrets(user.getSecrets())
+	if err != nil {
+		return 0, err
+	}
+	// the GCS credentials are not stored inside the data provider
+	gcsRotated, err := user.rotateGCSCredentials()
+	if err != nil {
+		return 0, fmt.Errorf("unable to re-encrypt the GCS credentials: %v", err)
+	}
+	if rotated > 0 {
+		if err = p.updateUser(user); err != nil {
+			return 0, err
+		}
+	}
+	if gcsRotated {
+		rotated++
+	}
+	if rotated > 0 {
+		providerLog(logger.LevelInfo, "secrets re-encrypted for user %#v, secrets: %v", username, rotated)
+	}
+	return rotated, nil
+}
+
+// RotateUserTemplateSecrets re-encrypts, with the current master key, the secrets for the user
+// template with the given name that are encrypted with an old master key or without a master key.
+// The GCS credentials stored in plain text by previous versions are encrypted too.
+// It returns the number of re-encrypted secrets, the template is updated inside the data
+// provider only if at least a secret changes
+func RotateUserTemplateSecrets(p Provider, name string) (int, error) {
+	if !kms.IsMasterKeyEnabled() {
+		return 0, errors.New("a master key is required to rotate the secrets")
+	}
+	template, err := p.userTemplateExists(name)
+	if err != nil {
+		return 0, err
+	}
+	rotated, err := rotateSecrets(template.User.getSecrets())
+	if err != nil {
+		return 0, err
+	}
+	credentials := template.User.FsConfig.GCSConfig.Credentials
+	if len(credentials) > 0 && !kms.IsEncrypted(credentials) {
+		template.User.FsConfig.GCSConfig.Credentials, err = kms.Encrypt(credentials)
+		if err != nil {
+			return 0, fmt.Errorf("unable to encrypt the GCS credentials: %v", err)
+		}
+		rotated++
+	}
+	if rotated > 0 {
+		if err = p.updateUserTemplate(template); err != nil {
+			return 0, err
+		}
+		providerLog(logger.LevelInfo, "secrets re-encrypted for user template %#v, secrets: %v", name, rotated)
+	}
+	return rotated, nil
+}
+
+// rotateSecrets re-encrypts the given secrets with the current master key and returns
+// the number of secrets changed
+func rotateSecrets(secrets []*string) (int, error) {
+	rotated := 0
+	for _, secret := range secrets {
+		value, changed, err := kms.Rotate(*secret)
+		if err != nil {
+			return 0, fmt.Errorf("unable to re-encrypt a secret: %v", err)
+		}
+		if changed {
+			*secret = value
+			rotated++
+		}
+	}
+	return rotated, nil
+}
@@ -0,0 +1,208 @@
+package dataprovider
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func writeMasterKey(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+		t.Fatalf("unable to write master key: %v", err)
+	}
+}
+
+func initializeKMS(t *testing.T, dir string, c kms.Config) {
+	if err := c.Initialize(dir); err != nil {
+		t.Fatalf("unable to initialize kms: %v", err)
+	}
+}
+
+func checkDecryptedSecret(t *testing.T, name, encrypted, expected string) {
+	if !kms.IsEncryptedWithMasterKey(encrypted) {
+		t.Errorf("%v must be encrypted with the master key: %#v", name, encrypted)
+		return
+	}
+	plain, err := kms.Decrypt(encrypted)
+	if err != nil {
+		t.Errorf("unable to decrypt %v: %v", name, err)
+	} else if plain != expected {
+		t.Errorf("unexpected %v: %#v", name, plain)
+	}
+}
+
+func TestRotateSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig := config
+	savedProvider := provider
+	savedCredentialsDirPath := credentialsDirPath
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+		credentialsDirPath = savedCredentialsDirPath
+		initializeKMS(t, dir, kms.Config{})
+	}()
+
+	writeMasterKey(t, dir, "old.key", strings.Repeat("o", 32))
+	writeMasterKey(t, dir, "new.key", strings.Repeat("n", 32))
+	initializeKMS(t, dir, kms.Config{MasterKeyPath: "old.key"})
+
+	config = Config{Driver: MemoryDataProviderName}
+	credentialsDirPath = dir
+	if err = initializeMemoryProvider(dir); err != nil {
+		t.Fatalf("unable to initialize the memory provider: %v", err)
+	}
+	p := provider
+
+	gcsCredentials := `{"type":"service_account"}`
+	user := User{
+		Username:    "rotate_user",
+		Password:    "$2a$10$9vfNUOGmHNLtl8Pjoi1QpOO5bRCxjJp6Ql0S/cOyE7o47Jhbp4n5G",
+		HomeDir:     filepath.Join(dir, "rotate_user"),
+		Status:      1,
+		Permissions: map[string][]string{"/": {PermAny}},
+	}
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/archive",
+		Filesystem: &vfs.VirtualFolderFilesystem{
+			Provider: 1,
+			S3Config: vfs.S3FsConfig{
+				Bucket:       "archive",
+				Region:       "us-east-1",
+				AccessKey:    "folder-access-key",
+				AccessSecret: "folder-access-secret",
+			},
+		},
+	})
+	if err = p.addUser(user); err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	gcsUser := User{
+		Username:    "rotate_gcs_user",
+		Password:    user.Password,
+		HomeDir:     filepath.Join(dir, "rotate_gcs_user"),
+		Status:      1,
+		Permissions: map[string][]string{"/": {PermAny}},
+	}
+	gcsUser.FsConfig.Provider = 2
+	gcsUser.FsConfig.GCSConfig.Bucket = "bucket"
+	gcsUser.FsConfig.GCSConfig.Credentials = base64.StdEncoding.EncodeToString([]byte(gcsCredentials))
+	if err = p.addUser(gcsUser); err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	template := UserTemplate{Name: "rotate_template"}
+	template.User.FsConfig.Provider = 2
+	template.User.FsConfig.GCSConfig.Bucket = "bucket"
+	template.User.FsConfig.GCSConfig.Credentials = base64.StdEncoding.EncodeToString([]byte(gcsCredentials))
+	if err = p.addUserTemplate(template); err != nil {
+		t.Fatalf("unable to add user template: %v", err)
+	}
+	s3Template := UserTemplate{Name: "rotate_s3_template"}
+	s3Template.User.FsConfig.Provider = 1
+	s3Template.User.FsConfig.S3Config = vfs.S3FsConfig{
+		Bucket:       "bucket",
+		Region:       "us-east-1",
+		AccessKey:    "template-access-key",
+		AccessSecret: "template-access-secret",
+	}
+	if err = p.addUserTemplate(s3Template); err != nil {
+		t.Fatalf("unable to add user template: %v", err)
+	}
+	// the folders filesystems accept only automatic GCS credentials, a credential stored
+	// inside a folder is rotated as any other secret
+	folderUser := User{Username: "rotate_folder_user"}
+	folderUser.VirtualFolders = append(folderUser.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/gcs",
+		Filesystem: &vfs.VirtualFolderFilesystem{
+			Provider: 2,
+			GCSConfig: vfs.GCSFsConfig{
+				Bucket: "bucket",
+			},
+		},
+	})
+	folderUser.VirtualFolders[0].Filesystem.GCSConfig.Credentials, err = kms.Encrypt(gcsCredentials)
+	if err != nil {
+		t.Fatalf("unable to encrypt folder credentials: %v", err)
+	}
+
+	initializeKMS(t, dir, kms.Config{MasterKeyPath: "new.key", OldMasterKeyPaths: []string{"old.key"}})
+	for _, username := range []string{user.Username, gcsUser.Username} {
+		rotated, err := RotateUserSecrets(p, username)
+		if err != nil {
+			t.Errorf("unable to rotate user %#v secrets: %v", username, err)
+		} else if rotated != 1 {
+			t.Errorf("unexpected rotated secrets for user %#v: %v", username, rotated)
+		}
+	}
+	for _, name := range []string{template.Name, s3Template.Name} {
+		rotated, err := RotateUserTemplateSecrets(p, name)
+		if err != nil {
+			t.Errorf("unable to rotate user template %#v secrets: %v", name, err)
+		} else if rotated != 1 {
+			t.Errorf("unexpected rotated secrets for user template %#v: %v", name, rotated)
+		}
+	}
+	rotated, err := rotateSecrets(folderUser.getSecrets())
+	if err != nil {
+		t.Errorf("unable to rotate folder secrets: %v", err)
+	} else if rotated != 1 {
+		t.Errorf("unexpected rotated folder secrets: %v", rotated)
+	}
+	// a second rotation must be a no-op
+	rotated, err = RotateUserSecrets(p, gcsUser.Username)
+	if err != nil || rotated != 0 {
+		t.Errorf("unexpected second rotation result, rotated: %v, err: %v", rotated, err)
+	}
+	rotated, err = RotateUserTemplateSecrets(p, template.Name)
+	if err != nil || rotated != 0 {
+		t.Errorf("unexpected second template rotation result, rotated: %v, err: %v", rotated, err)
+	}
+
+	// remove the old key, everything must be readable using the new key only
+	initializeKMS(t, dir, kms.Config{MasterKeyPath: "new.key"})
+	user, err = p.userExists(user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	checkDecryptedSecret(t, "folder access secret", user.VirtualFolders[0].Filesystem.S3Config.AccessSecret,
+		"folder-access-secret")
+	content, err := ioutil.ReadFile(gcsUser.getGCSCredentialsFilePath())
+	if err != nil {
+		t.Errorf("unable to read GCS credentials file: %v", err)
+	} else {
+		checkDecryptedSecret(t, "user GCS credentials", string(content), gcsCredentials)
+	}
+	checkDecryptedSecret(t, "folder GCS credentials", folderUser.VirtualFolders[0].Filesystem.GCSConfig.Credentials,
+		gcsCredentials)
+	template, err = p.userTemplateExists(template.Name)
+	if err != nil {
+		t.Fatalf("unable to get user template: %v", err)
+	}
+	checkDecryptedSecret(t, "template GCS credentials", template.User.FsConfig.GCSConfig.Credentials,
+		base64.StdEncoding.EncodeToString([]byte(gcsCredentials)))
+	s3Template, err = p.userTemplateExists(s3Template.Name)
+	if err != nil {
+		t.Fatalf("unable to get user template: %v", err)
+	}
+	checkDecryptedSecret(t, "template access secret", s3Template.User.FsConfig.S3Config.AccessSecret,
+		"template-access-secret")
+
+	initializeKMS(t, dir, kms.Config{})
+	if _, err = RotateUserSecrets(p, user.Username); err == nil {
+		t.Errorf("rotating the secrets without a master key must fail")
+	}
+	if _, err = RotateUserTemplateSecrets(p, template.Name); err == nil {
+		t.Errorf("rotating the template secrets without a master key must fail")
+	}
+}
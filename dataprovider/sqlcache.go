@@ -0,0 +1,194 @@
+package dataprovider
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// the migration target could be a different SQL database, so there is a cache for each database
+var sqlUsersCaches = struct {
+	sync.Mutex
+	caches map[*sql.DB]*usersLookupCache
+}{
+	caches: make(map[*sql.DB]*usersLookupCache),
+}
+
+// UsersCacheConfig defines an in-process cache for the users looked up by username, for the SQL
+// data providers. The logins for cached users do not read the user from the database. The cache
+// is invalidated when a user is changed using this instance, a change made by another instance
+// sharing the same database is visible after the TTL expires.
+// The cached users can also be used to authenticate the users if the database is unreachable
+type UsersCacheConfig struct {
+	// Time to live, in seconds, for the cached users. 0 disables the cache
+	TTL int `json:"ttl" mapstructure:"ttl"`
+	// Maximum number of cached users. 0 means 1000
+	Size int `json:"size" mapstructure:"size"`
+	// If the database is unreachable, the password and public key logins are served using the
+	// cached users, even if expired, read from the database within this number of seconds.
+	// The users are cached for this fallback even if the TTL is 0. 0 disables the fallback
+	OutageMaxStaleness int `json:"outage_max_staleness" mapstructure:"outage_max_staleness"`
+	// If true the users authenticated using the outage fallback can only list and download files
+	OutageReadOnly bool `json:"outage_read_only" mapstructure:"outage_read_only"`
+}
+
+func validateUsersCache() error {
+	if config.UsersCache.TTL < 0 || config.UsersCache.Size < 0 || config.UsersCache.OutageMaxStaleness < 0 {
+		return errors.New("the users cache ttl, size and outage max staleness cannot be negative")
+	}
+	return nil
+}
+
+// getSQLUsersCache returns the users cache for the given database
+func getSQLUsersCache(dbHandle *sql.DB) *usersLookupCache {
+	sqlUsersCaches.Lock()
+	defer sqlUsersCaches.Unlock()
+
+	c, ok := sqlUsersCaches.caches[dbHandle]
+	if !ok {
+		c = &usersLookupCache{
+			users: make(map[string]cachedUser),
+		}
+		sqlUsersCaches.caches[dbHandle] = c
+	}
+	return c
+}
+
+// resetSQLUsersCaches removes the caches for the previously initialized databases
+func resetSQLUsersCaches() {
+	sqlUsersCaches.Lock()
+	defer sqlUsersCaches.Unlock()
+
+	sqlUsersCaches.caches = make(map[*sql.DB]*usersLookupCache)
+}
+
+type cachedUser struct {
+	user      User
+	cachedAt  time.Time
+	expiresAt time.Time
+}
+
+// isStale returns true if the cached user cannot be used anymore, neither as a fresh user
+// nor for the outage fallback
+func (c *cachedUser) isStale(now time.Time) bool {
+	if now.Before(c.expiresAt) {
+		return false
+	}
+	return now.After(c.cachedAt.Add(time.Duration(config.UsersCache.OutageMaxStaleness) * time.Second))
+}
+
+// usersLookupCache caches the users by username
+type usersLookupCache struct {
+	sync.RWMutex
+	users map[string]cachedUser
+	// incremented for each invalidation, a user read from the database before an
+	// invalidation could be stale so it is not cached
+	generation uint64
+}
+
+func (c *usersLookupCache) isEnabled() bool {
+	return config.UsersCache.TTL > 0 || config.UsersCache.OutageMaxStaleness > 0
+}
+
+func (c *usersLookupCache) getSize() int {
+	if config.UsersCache.Size > 0 {
+		return config.UsersCache.Size
+	}
+	return 1000
+}
+
+// getGeneration must be called before reading the user from the database
+func (c *usersLookupCache) getGeneration() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.generation
+}
+
+func (c *usersLookupCache) get(username string) (User, bool) {
+	if config.UsersCache.TTL <= 0 {
+		return User{}, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.users[username]
+	if !ok || time.Now().After(cached.expiresAt) {
+		return User{}, false
+	}
+	return cached.user.getACopy(), true
+}
+
+// getForOutage returns the cached user, even if expired, if it was read from the database
+// within the outage max staleness. It also returns the time the user was cached
+func (c *usersLookupCache) getForOutage(username string) (User, time.Time, bool) {
+	if config.UsersCache.OutageMaxStaleness <= 0 {
+		return User{}, time.Time{}, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.users[username]
+	if !ok || cached.isStale(time.Now()) {
+		return User{}, time.Time{}, false
+	}
+	return cached.user.getACopy(), cached.cachedAt, true
+}
+
+// add caches the given user if it was not invalidated after the given generation
+func (c *usersLookupCache) add(user User, generation uint64) {
+	if !c.isEnabled() {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	now := time.Now()
+	if _, ok := c.users[user.Username]; !ok && len(c.users) >= c.getSize() {
+		for k, v := range c.users {
+			if v.isStale(now) {
+				delete(c.users, k)
+			}
+		}
+		if len(c.users) >= c.getSize() {
+			c.users = make(map[string]cachedUser)
+		}
+	}
+	c.users[user.Username] = cachedUser{
+		user:      user.getACopy(),
+		cachedAt:  now,
+		expiresAt: now.Add(time.Duration(config.UsersCache.TTL) * time.Second),
+	}
+}
+
+// update applies the given function to the cached user, if any. It is used for the internal
+// updates, such as the last login or the used quota, that do not require to invalidate the user
+func (c *usersLookupCache) update(username string, updateFn func(*User)) {
+	c.Lock()
+	defer c.Unlock()
+
+	if cached, ok := c.users[username]; ok {
+		updateFn(&cached.user)
+		c.users[username] = cached
+	}
+}
+
+func (c *usersLookupCache) remove(username string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	delete(c.users, username)
+}
+
+func (c *usersLookupCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	c.users = make(map[string]cachedUser)
+}
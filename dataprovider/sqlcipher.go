@@ -0,0 +1,12 @@
+// +build sqlcipher
+
+package dataprovider
+
+import "github.com/drakkan/sftpgo/utils"
+
+// the sqlite3 driver is provided by go-sqlcipher, see the main package
+const sqlCipherSupported = true
+
+func init() {
+	utils.AddFeature("+sqlcipher")
+}
@@ -0,0 +1,5 @@
+// +build !sqlcipher
+
+package dataprovider
+
+const sqlCipherSupported = false
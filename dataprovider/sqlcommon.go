@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/drakkan/sftpgo/logger"
@@ -13,22 +14,61 @@ import (
 )
 
 const (
-	sqlDatabaseVersion  = 3
+	sqlDatabaseVersion  = 10
 	initialDBVersionSQL = "INSERT INTO schema_version (version) VALUES (1);"
 )
 
-func getUserByUsername(username string, dbHandle *sql.DB) (User, error) {
+// getUserByUsername returns the user with the given username. The login lookups can be
+// served by the read replica, if configured
+func getUserByUsername(username string, dbHandle *sql.DB, useReadReplica bool) (User, error) {
+	usersCache := getSQLUsersCache(dbHandle)
+	if user, ok := usersCache.get(username); ok {
+		return user, nil
+	}
+	generation := usersCache.getGeneration()
+	readHandle := dbHandle
+	if useReadReplica {
+		readHandle = getSQLReadHandle(dbHandle)
+	}
 	var user User
 	q := getUserByUsernameQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(readHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return user, err
 	}
-	defer stmt.Close()
 
 	row := stmt.QueryRow(username)
-	return getUserFromDbRow(row, nil)
+	user, err = getUserFromDbRow(row, nil)
+	if err == nil {
+		usersCache.add(user, generation)
+	}
+	return user, err
+}
+
+// getLoginUser returns the user trying to login. If the database is unreachable the user is
+// read from the users cache, if the outage fallback is enabled
+func getLoginUser(username string, dbHandle *sql.DB) (User, error) {
+	user, err := getUserByUsername(username, dbHandle, true)
+	if err == nil || config.UsersCache.OutageMaxStaleness <= 0 {
+		return user, err
+	}
+	if _, ok := err.(*RecordNotFoundError); ok {
+		return user, err
+	}
+	if errAvailability := sqlCommonCheckAvailability(dbHandle); errAvailability == nil {
+		return user, err
+	}
+	cachedUser, cachedAt, ok := getSQLUsersCache(dbHandle).getForOutage(username)
+	if !ok {
+		return user, err
+	}
+	if config.UsersCache.OutageReadOnly {
+		cachedUser.Filters.ReadOnly = true
+	}
+	providerLog(logger.LevelWarn, "the database is unreachable, using the user %#v cached at %v, read only: %v, "+
+		"database error: %v", username, cachedAt.Format(time.RFC3339), cachedUser.Filters.ReadOnly, err)
+	return cachedUser, nil
 }
 
 func sqlCommonValidateUserAndPass(username string, password string, dbHandle *sql.DB) (User, error) {
@@ -36,7 +76,7 @@ func sqlCommonValidateUserAndPass(username string, password string, dbHandle *sq
 	if len(password) == 0 {
 		return user, errors.New("Credentials cannot be null or empty")
 	}
-	user, err := getUserByUsername(username, dbHandle)
+	user, err := getLoginUser(username, dbHandle)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
 		return user, err
@@ -49,7 +89,7 @@ func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, dbHandle *sq
 	if len(pubKey) == 0 {
 		return user, "", errors.New("Credentials cannot be null or empty")
 	}
-	user, err := getUserByUsername(username, dbHandle)
+	user, err := getLoginUser(username, dbHandle)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user: %v, error: %v", username, err)
 		return user, "", err
@@ -60,18 +100,23 @@ func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, dbHandle *sq
 func sqlCommonCheckAvailability(dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return dbHandle.PingContext(ctx)
+	if err := dbHandle.PingContext(ctx); err != nil {
+		return err
+	}
+	if readHandle := getSQLReadHandle(dbHandle); readHandle != dbHandle {
+		return readHandle.PingContext(ctx)
+	}
+	return nil
 }
 
 func sqlCommonGetUserByID(ID int64, dbHandle *sql.DB) (User, error) {
 	var user User
 	q := getUserByIDQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return user, err
 	}
-	defer stmt.Close()
 
 	row := stmt.QueryRow(ID)
 	return getUserFromDbRow(row, nil)
@@ -79,14 +124,24 @@ func sqlCommonGetUserByID(ID int64, dbHandle *sql.DB) (User, error) {
 
 func sqlCommonUpdateQuota(username string, filesAdd int, sizeAdd int64, reset bool, dbHandle *sql.DB) error {
 	q := getUpdateQuotaQuery(reset)
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return err
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(sizeAdd, filesAdd, utils.GetTimeAsMsSinceEpoch(time.Now()), username)
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	_, err = stmt.Exec(sizeAdd, filesAdd, now, username)
 	if err == nil {
+		getSQLUsersCache(dbHandle).update(username, func(user *User) {
+			if reset {
+				user.UsedQuotaSize = sizeAdd
+				user.UsedQuotaFiles = filesAdd
+			} else {
+				user.UsedQuotaSize += sizeAdd
+				user.UsedQuotaFiles += filesAdd
+			}
+			user.LastQuotaUpdate = now
+		})
 		providerLog(logger.LevelDebug, "quota updated for user %#v, files increment: %v size increment: %v is reset? %v",
 			username, filesAdd, sizeAdd, reset)
 	} else {
@@ -97,14 +152,17 @@ func sqlCommonUpdateQuota(username string, filesAdd int, sizeAdd int64, reset bo
 
 func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
 	q := getUpdateLastLoginQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return err
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(utils.GetTimeAsMsSinceEpoch(time.Now()), username)
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	_, err = stmt.Exec(now, username)
 	if err == nil {
+		getSQLUsersCache(dbHandle).update(username, func(user *User) {
+			user.LastLogin = now
+		})
 		providerLog(logger.LevelDebug, "last login updated for user %#v", username)
 	} else {
 		providerLog(logger.LevelWarn, "error updating last login for user %#v: %v", username, err)
@@ -112,14 +170,30 @@ func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
 	return err
 }
 
+func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) error {
+	q := getUpdateUserPasswordQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(password, username)
+	getSQLUsersCache(dbHandle).remove(username)
+	if err == nil {
+		providerLog(logger.LevelDebug, "password updated for user %#v", username)
+	} else {
+		providerLog(logger.LevelWarn, "error updating password for user %#v: %v", username, err)
+	}
+	return err
+}
+
 func sqlCommonGetUsedQuota(username string, dbHandle *sql.DB) (int, int64, error) {
 	q := getQuotaQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return 0, 0, err
 	}
-	defer stmt.Close()
 
 	var usedFiles int
 	var usedSize int64
@@ -132,16 +206,7 @@ func sqlCommonGetUsedQuota(username string, dbHandle *sql.DB) (int, int64, error
 }
 
 func sqlCommonCheckUserExists(username string, dbHandle *sql.DB) (User, error) {
-	var user User
-	q := getUserByUsernameQuery()
-	stmt, err := dbHandle.Prepare(q)
-	if err != nil {
-		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
-		return user, err
-	}
-	defer stmt.Close()
-	row := stmt.QueryRow(username)
-	return getUserFromDbRow(row, nil)
+	return getUserByUsername(username, dbHandle, false)
 }
 
 func sqlCommonAddUser(user User, dbHandle *sql.DB) error {
@@ -149,13 +214,13 @@ func sqlCommonAddUser(user User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	setUserCreationFields(&user)
 	q := getAddUserQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return err
 	}
-	defer stmt.Close()
 	permissions, err := user.GetPermissionsAsJSON()
 	if err != nil {
 		return err
@@ -178,7 +243,8 @@ func sqlCommonAddUser(user User, dbHandle *sql.DB) error {
 	}
 	_, err = stmt.Exec(user.Username, user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
 		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status, user.ExpirationDate, string(filters),
-		string(fsConfig), string(virtualFolders))
+		string(fsConfig), string(virtualFolders), user.Plan, user.AdditionalInfo, user.CreatedAt, user.UpdatedAt, user.Version)
+	getSQLUsersCache(dbHandle).remove(user.Username)
 	return err
 }
 
@@ -187,13 +253,19 @@ func sqlCommonUpdateUser(user User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	existing, err := sqlCommonGetUserVersion(user.ID, dbHandle)
+	if err != nil {
+		return err
+	}
+	if err = setUserUpdateFields(&user, existing); err != nil {
+		return err
+	}
 	q := getUpdateUserQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return err
 	}
-	defer stmt.Close()
 	permissions, err := user.GetPermissionsAsJSON()
 	if err != nil {
 		return err
@@ -214,33 +286,62 @@ func sqlCommonUpdateUser(user User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
+	res, err := stmt.Exec(user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
 		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status, user.ExpirationDate,
-		string(filters), string(fsConfig), string(virtualFolders), user.ID)
+		string(filters), string(fsConfig), string(virtualFolders), user.Plan, user.AdditionalInfo, user.UpdatedAt, user.Version,
+		user.ID, existing.Version)
+	getSQLUsersCache(dbHandle).remove(user.Username)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err == nil && affected == 0 {
+		// deleted or modified after reading its version
+		if _, err = sqlCommonGetUserVersion(user.ID, dbHandle); err != nil {
+			return err
+		}
+		return ErrUserVersionMismatch
+	}
 	return err
 }
 
+// sqlCommonGetUserVersion returns the stored user with only the fields needed to check
+// the version populated, the users cache is not used
+func sqlCommonGetUserVersion(id int64, dbHandle *sql.DB) (User, error) {
+	var user User
+	q := getUserVersionQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return user, err
+	}
+	err = stmt.QueryRow(id).Scan(&user.CreatedAt, &user.Version)
+	if err == sql.ErrNoRows {
+		return user, &RecordNotFoundError{err: fmt.Sprintf("user with id %v does not exist", id)}
+	}
+	return user, err
+}
+
 func sqlCommonDeleteUser(user User, dbHandle *sql.DB) error {
 	q := getDeleteUserQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return err
 	}
-	defer stmt.Close()
 	_, err = stmt.Exec(user.ID)
+	getSQLUsersCache(dbHandle).remove(user.Username)
 	return err
 }
 
 func sqlCommonDumpUsers(dbHandle *sql.DB) ([]User, error) {
 	users := []User{}
 	q := getDumpUsersQuery()
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(dbHandle, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return nil, err
 	}
-	defer stmt.Close()
 	rows, err := stmt.Query()
 	if err == nil {
 		defer rows.Close()
@@ -263,12 +364,11 @@ func sqlCommonDumpUsers(dbHandle *sql.DB) ([]User, error) {
 func sqlCommonGetUsers(limit int, offset int, order string, username string, dbHandle *sql.DB) ([]User, error) {
 	users := []User{}
 	q := getUsersQuery(order, username)
-	stmt, err := dbHandle.Prepare(q)
+	stmt, err := getPreparedStmt(getSQLReadHandle(dbHandle), q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
 		return nil, err
 	}
-	defer stmt.Close()
 	var rows *sql.Rows
 	if len(username) > 0 {
 		rows, err = stmt.Query(username, limit, offset)
@@ -290,6 +390,665 @@ func sqlCommonGetUsers(limit int, offset int, order string, username string, dbH
 	return users, err
 }
 
+func sqlCommonGetUsersPage(req UsersPageRequest, cursor *usersCursor, dbHandle *sql.DB) (UsersPage, error) {
+	page := UsersPage{Users: []User{}}
+	readHandle := getSQLReadHandle(dbHandle)
+	conditions := getUsersQueryConditions(req.Username, req.Filter, utils.GetTimeAsMsSinceEpoch(time.Now()))
+	q := getCountUsersQuery(conditions.getWhereClause())
+	stmt, err := getPreparedStmt(readHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return page, err
+	}
+	if err = stmt.QueryRow(conditions.args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+	if req.Limit == 0 {
+		return page, nil
+	}
+	order := req.Order
+	offset := req.Offset
+	if cursor != nil {
+		offset = 0
+		operator := cursor.sqlOperator()
+		conditions.add(fmt.Sprintf("(%v %v %%v OR (%v = %%v AND id %v %%v))", req.OrderBy, operator, req.OrderBy,
+			operator), cursor.sqlArgs()...)
+		if cursor.Backward {
+			// the users before the cursor are selected in reverse order and then reversed
+			if order == "ASC" {
+				order = "DESC"
+			} else {
+				order = "ASC"
+			}
+		}
+	}
+	q = getUsersPageQuery(req.OrderBy, order, conditions.getWhereClause(), len(conditions.args))
+	stmt, err = getPreparedStmt(readHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return page, err
+	}
+	// an additional user is requested to know if there are more users
+	rows, err := stmt.Query(append(conditions.args, req.Limit+1, offset)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := getUserFromDbRow(nil, rows)
+		if err != nil {
+			return page, err
+		}
+		page.Users = append(page.Users, HideUserSensitiveData(&u))
+	}
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+	hasMore := len(page.Users) > req.Limit
+	if hasMore {
+		page.Users = page.Users[:req.Limit]
+	}
+	if cursor != nil && cursor.Backward {
+		for i, j := 0, len(page.Users)-1; i < j; i, j = i+1, j-1 {
+			page.Users[i], page.Users[j] = page.Users[j], page.Users[i]
+		}
+		setUsersPageCursors(&page, req, hasMore, true)
+	} else {
+		setUsersPageCursors(&page, req, cursor != nil || offset > 0, hasMore)
+	}
+	return page, nil
+}
+
+func sqlCommonGetIPListEntries(dbHandle *sql.DB) ([]IPListEntry, error) {
+	entries := []IPListEntry{}
+	q := getIPListEntriesQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	rows, err := stmt.Query()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			entry, err := getIPListEntryFromDbRow(nil, rows)
+			if err != nil {
+				return entries, err
+			}
+			entries = append(entries, entry)
+		}
+		err = rows.Err()
+	}
+	return entries, err
+}
+
+func sqlCommonGetIPListEntryByID(ID int64, dbHandle *sql.DB) (IPListEntry, error) {
+	var entry IPListEntry
+	q := getIPListEntryByIDQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return entry, err
+	}
+	row := stmt.QueryRow(ID)
+	return getIPListEntryFromDbRow(row, nil)
+}
+
+func sqlCommonAddIPListEntry(entry IPListEntry, dbHandle *sql.DB) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	q := getAddIPListEntryQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(entry.IPOrNet, entry.Type, entry.Description)
+	return err
+}
+
+func sqlCommonUpdateIPListEntry(entry IPListEntry, dbHandle *sql.DB) error {
+	err := validateIPListEntry(&entry)
+	if err != nil {
+		return err
+	}
+	q := getUpdateIPListEntryQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(entry.IPOrNet, entry.Type, entry.Description, entry.ID)
+	return err
+}
+
+func sqlCommonDeleteIPListEntry(entry IPListEntry, dbHandle *sql.DB) error {
+	q := getDeleteIPListEntryQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(entry.ID)
+	return err
+}
+
+func getIPListEntryFromDbRow(row *sql.Row, rows *sql.Rows) (IPListEntry, error) {
+	var entry IPListEntry
+	var description sql.NullString
+	var err error
+	if row != nil {
+		err = row.Scan(&entry.ID, &entry.IPOrNet, &entry.Type, &description)
+	} else {
+		err = rows.Scan(&entry.ID, &entry.IPOrNet, &entry.Type, &description)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entry, &RecordNotFoundError{err: err.Error()}
+		}
+		return entry, err
+	}
+	if description.Valid {
+		entry.Description = description.String
+	}
+	return entry, err
+}
+
+func sqlCommonGetPlans(dbHandle *sql.DB) ([]Plan, error) {
+	plans := []Plan{}
+	q := getPlansQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	rows, err := stmt.Query()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			plan, err := getPlanFromDbRow(nil, rows)
+			if err != nil {
+				return plans, err
+			}
+			plans = append(plans, plan)
+		}
+		err = rows.Err()
+	}
+	return plans, err
+}
+
+func sqlCommonGetPlanByID(ID int64, dbHandle *sql.DB) (Plan, error) {
+	var plan Plan
+	q := getPlanByIDQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return plan, err
+	}
+	row := stmt.QueryRow(ID)
+	return getPlanFromDbRow(row, nil)
+}
+
+func sqlCommonCheckPlanExists(name string, dbHandle *sql.DB) (Plan, error) {
+	var plan Plan
+	q := getPlanByNameQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return plan, err
+	}
+	row := stmt.QueryRow(name)
+	return getPlanFromDbRow(row, nil)
+}
+
+func sqlCommonAddPlan(plan Plan, dbHandle *sql.DB) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	fsProviders, err := plan.GetFsProvidersAsJSON()
+	if err != nil {
+		return err
+	}
+	deniedLoginMethods, err := plan.GetDeniedLoginMethodsAsJSON()
+	if err != nil {
+		return err
+	}
+	q := getAddPlanQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(plan.Name, plan.Description, plan.MaxSessions, plan.QuotaSize, plan.QuotaFiles, plan.UploadBandwidth,
+		plan.DownloadBandwidth, string(fsProviders), string(deniedLoginMethods), plan.PasswordBreachCheck)
+	return err
+}
+
+// sqlCommonUpdatePlan updates the plan and, if applyToUsers is true, all the assigned users
+// inside a single transaction
+func sqlCommonUpdatePlan(plan Plan, applyToUsers bool, dbHandle *sql.DB) error {
+	err := validatePlan(&plan)
+	if err != nil {
+		return err
+	}
+	existing, err := sqlCommonGetPlanByID(plan.ID, dbHandle)
+	if err != nil {
+		return err
+	}
+	if existing.Name != plan.Name {
+		return &ValidationError{err: "the plan name cannot be changed"}
+	}
+	fsProviders, err := plan.GetFsProvidersAsJSON()
+	if err != nil {
+		return err
+	}
+	deniedLoginMethods, err := plan.GetDeniedLoginMethodsAsJSON()
+	if err != nil {
+		return err
+	}
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return err
+	}
+	var users []User
+	if applyToUsers {
+		users, err = getUsersWithPlan(plan.Name, tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		users, err = applyPlanToUsers(plan, users)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec(getUpdatePlanQuery(), plan.Description, plan.MaxSessions, plan.QuotaSize, plan.QuotaFiles,
+		plan.UploadBandwidth, plan.DownloadBandwidth, string(fsProviders), string(deniedLoginMethods), plan.PasswordBreachCheck,
+		plan.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, user := range users {
+		filters, err := user.GetFiltersAsJSON()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec(getUpdateUserPlanLimitsQuery(), user.MaxSessions, user.QuotaSize, user.QuotaFiles, user.UploadBandwidth,
+			user.DownloadBandwidth, string(filters), user.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit()
+	// the plan limits are applied to the assigned users
+	getSQLUsersCache(dbHandle).clear()
+	return err
+}
+
+func sqlCommonDeletePlan(plan Plan, dbHandle *sql.DB) error {
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return err
+	}
+	var numUsers int
+	err = tx.QueryRow(getCountUsersWithPlanQuery(), plan.Name).Scan(&numUsers)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if numUsers > 0 {
+		tx.Rollback()
+		return &ValidationError{err: fmt.Sprintf("plan %#v is assigned to %v users and it cannot be deleted", plan.Name, numUsers)}
+	}
+	_, err = tx.Exec(getDeletePlanQuery(), plan.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func getUsersWithPlan(name string, tx *sql.Tx) ([]User, error) {
+	users := []User{}
+	rows, err := tx.Query(getUsersWithPlanQuery(), name)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := getUserFromDbRow(nil, rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func getPlanFromDbRow(row *sql.Row, rows *sql.Rows) (Plan, error) {
+	var plan Plan
+	var description sql.NullString
+	var fsProviders sql.NullString
+	var deniedLoginMethods sql.NullString
+	var err error
+	if row != nil {
+		err = row.Scan(&plan.ID, &plan.Name, &description, &plan.MaxSessions, &plan.QuotaSize, &plan.QuotaFiles,
+			&plan.UploadBandwidth, &plan.DownloadBandwidth, &fsProviders, &deniedLoginMethods, &plan.PasswordBreachCheck)
+	} else {
+		err = rows.Scan(&plan.ID, &plan.Name, &description, &plan.MaxSessions, &plan.QuotaSize, &plan.QuotaFiles,
+			&plan.UploadBandwidth, &plan.DownloadBandwidth, &fsProviders, &deniedLoginMethods, &plan.PasswordBreachCheck)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return plan, &RecordNotFoundError{err: err.Error()}
+		}
+		return plan, err
+	}
+	if description.Valid {
+		plan.Description = description.String
+	}
+	if fsProviders.Valid {
+		var list []int
+		err = json.Unmarshal([]byte(fsProviders.String), &list)
+		if err == nil {
+			plan.AllowedFsProviders = list
+		}
+	}
+	if deniedLoginMethods.Valid {
+		var list []string
+		err = json.Unmarshal([]byte(deniedLoginMethods.String), &list)
+		if err == nil {
+			plan.DeniedLoginMethods = list
+		}
+	}
+	return plan, nil
+}
+
+func sqlCommonGetUserTemplates(dbHandle *sql.DB) ([]UserTemplate, error) {
+	templates := []UserTemplate{}
+	q := getUserTemplatesQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	rows, err := stmt.Query()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			template, err := getUserTemplateFromDbRow(nil, rows)
+			if err != nil {
+				return templates, err
+			}
+			templates = append(templates, template)
+		}
+		err = rows.Err()
+	}
+	return templates, err
+}
+
+func sqlCommonGetUserTemplateByID(ID int64, dbHandle *sql.DB) (UserTemplate, error) {
+	var template UserTemplate
+	q := getUserTemplateByIDQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return template, err
+	}
+	row := stmt.QueryRow(ID)
+	return getUserTemplateFromDbRow(row, nil)
+}
+
+func sqlCommonCheckUserTemplateExists(name string, dbHandle *sql.DB) (UserTemplate, error) {
+	var template UserTemplate
+	q := getUserTemplateByNameQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return template, err
+	}
+	row := stmt.QueryRow(name)
+	return getUserTemplateFromDbRow(row, nil)
+}
+
+func sqlCommonAddUserTemplate(template UserTemplate, dbHandle *sql.DB) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	user, err := json.Marshal(template.User)
+	if err != nil {
+		return err
+	}
+	q := getAddUserTemplateQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(template.Name, template.Description, string(user))
+	return err
+}
+
+func sqlCommonUpdateUserTemplate(template UserTemplate, dbHandle *sql.DB) error {
+	err := validateUserTemplate(&template)
+	if err != nil {
+		return err
+	}
+	existing, err := sqlCommonGetUserTemplateByID(template.ID, dbHandle)
+	if err != nil {
+		return err
+	}
+	if existing.Name != template.Name {
+		return &ValidationError{err: "the user template name cannot be changed"}
+	}
+	user, err := json.Marshal(template.User)
+	if err != nil {
+		return err
+	}
+	q := getUpdateUserTemplateQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(template.Description, string(user), template.ID)
+	return err
+}
+
+func sqlCommonDeleteUserTemplate(template UserTemplate, dbHandle *sql.DB) error {
+	q := getDeleteUserTemplateQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(template.ID)
+	return err
+}
+
+func getUserTemplateFromDbRow(row *sql.Row, rows *sql.Rows) (UserTemplate, error) {
+	var template UserTemplate
+	var description sql.NullString
+	var user string
+	var err error
+	if row != nil {
+		err = row.Scan(&template.ID, &template.Name, &description, &user)
+	} else {
+		err = rows.Scan(&template.ID, &template.Name, &description, &user)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return template, &RecordNotFoundError{err: err.Error()}
+		}
+		return template, err
+	}
+	if description.Valid {
+		template.Description = description.String
+	}
+	err = json.Unmarshal([]byte(user), &template.User)
+	return template, err
+}
+
+func sqlCommonGetFolders(dbHandle *sql.DB) ([]Folder, error) {
+	folders := []Folder{}
+	q := getFoldersQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	rows, err := stmt.Query()
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			folder, err := getFolderFromDbRow(nil, rows)
+			if err != nil {
+				return folders, err
+			}
+			folders = append(folders, folder)
+		}
+		err = rows.Err()
+	}
+	return folders, err
+}
+
+func sqlCommonGetFolderByID(ID int64, dbHandle *sql.DB) (Folder, error) {
+	var folder Folder
+	q := getFolderByIDQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return folder, err
+	}
+	row := stmt.QueryRow(ID)
+	return getFolderFromDbRow(row, nil)
+}
+
+func sqlCommonCheckFolderExists(name string, dbHandle *sql.DB) (Folder, error) {
+	var folder Folder
+	q := getFolderByNameQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return folder, err
+	}
+	row := stmt.QueryRow(name)
+	return getFolderFromDbRow(row, nil)
+}
+
+func sqlCommonAddFolder(folder Folder, dbHandle *sql.DB) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	q := getAddFolderQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(folder.Name, folder.Description, folder.MappedPath, folder.QuotaSize, folder.QuotaFiles)
+	return err
+}
+
+func sqlCommonUpdateFolder(folder Folder, dbHandle *sql.DB) error {
+	err := validateFolder(&folder)
+	if err != nil {
+		return err
+	}
+	existing, err := sqlCommonGetFolderByID(folder.ID, dbHandle)
+	if err != nil {
+		return err
+	}
+	if existing.Name != folder.Name {
+		return &ValidationError{err: "the folder name cannot be changed"}
+	}
+	q := getUpdateFolderQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(folder.Description, folder.MappedPath, folder.QuotaSize, folder.QuotaFiles, folder.ID)
+	return err
+}
+
+func sqlCommonDeleteFolder(folder Folder, dbHandle *sql.DB) error {
+	q := getDeleteFolderQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(folder.ID)
+	return err
+}
+
+func sqlCommonUpdateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool, dbHandle *sql.DB) error {
+	q := getUpdateFolderQuotaQuery(reset)
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	_, err = stmt.Exec(sizeAdd, filesAdd, utils.GetTimeAsMsSinceEpoch(time.Now()), name)
+	if err == nil {
+		providerLog(logger.LevelDebug, "quota updated for folder %#v, files increment: %v size increment: %v is reset? %v",
+			name, filesAdd, sizeAdd, reset)
+	} else {
+		providerLog(logger.LevelWarn, "error updating quota for folder %#v: %v", name, err)
+	}
+	return err
+}
+
+func sqlCommonGetUsedFolderQuota(name string, dbHandle *sql.DB) (int, int64, error) {
+	q := getFolderQuotaQuery()
+	stmt, err := getPreparedStmt(dbHandle, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return 0, 0, err
+	}
+	var usedFiles int
+	var usedSize int64
+	err = stmt.QueryRow(name).Scan(&usedSize, &usedFiles)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error getting quota for folder: %v, error: %v", name, err)
+		return 0, 0, err
+	}
+	return usedFiles, usedSize, err
+}
+
+func getFolderFromDbRow(row *sql.Row, rows *sql.Rows) (Folder, error) {
+	var folder Folder
+	var description sql.NullString
+	var err error
+	if row != nil {
+		err = row.Scan(&folder.ID, &folder.Name, &description, &folder.MappedPath, &folder.QuotaSize, &folder.QuotaFiles,
+			&folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate)
+	} else {
+		err = rows.Scan(&folder.ID, &folder.Name, &description, &folder.MappedPath, &folder.QuotaSize, &folder.QuotaFiles,
+			&folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return folder, &RecordNotFoundError{err: err.Error()}
+		}
+		return folder, err
+	}
+	if description.Valid {
+		folder.Description = description.String
+	}
+	return folder, nil
+}
+
 func updateUserPermissionsFromDb(user *User, permissions string) error {
 	var err error
 	perms := make(map[string][]string)
@@ -317,18 +1076,20 @@ func getUserFromDbRow(row *sql.Row, rows *sql.Rows) (User, error) {
 	var filters sql.NullString
 	var fsConfig sql.NullString
 	var virtualFolders sql.NullString
+	var plan sql.NullString
+	var additionalInfo sql.NullString
 	var err error
 	if row != nil {
 		err = row.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 			&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
 			&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
-			&virtualFolders)
+			&virtualFolders, &plan, &additionalInfo, &user.CreatedAt, &user.UpdatedAt, &user.Version)
 
 	} else {
 		err = rows.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 			&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
 			&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
-			&virtualFolders)
+			&virtualFolders, &plan, &additionalInfo, &user.CreatedAt, &user.UpdatedAt, &user.Version)
 	}
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -339,6 +1100,12 @@ func getUserFromDbRow(row *sql.Row, rows *sql.Rows) (User, error) {
 	if password.Valid {
 		user.Password = password.String
 	}
+	if plan.Valid {
+		user.Plan = plan.String
+	}
+	if additionalInfo.Valid {
+		user.AdditionalInfo = additionalInfo.String
+	}
 	// we can have a empty string or an invalid json in null string
 	// so we do a relaxed test if the field is optional, for example we
 	// populate public keys only if unmarshal does not return an error
@@ -2,7 +2,10 @@ package dataprovider
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"path/filepath"
 	"strings"
 
@@ -34,6 +37,24 @@ INSERT INTO "new__users" ("id", "username", "public_keys", "home_dir", "uid", "g
 "password" FROM "{{users}}";
 DROP TABLE "{{users}}";
 ALTER TABLE "new__users" RENAME TO "{{users}}";`
+	sqliteV4SQL = `CREATE TABLE "ip_lists" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "ipornet" varchar(50) NOT NULL UNIQUE,
+"type" integer NOT NULL, "description" varchar(255) NULL);`
+	sqliteV5SQL = `CREATE TABLE "plans" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(255) NULL, "max_sessions" integer NOT NULL, "quota_size" bigint NOT NULL, "quota_files" integer NOT NULL,
+"upload_bandwidth" integer NOT NULL, "download_bandwidth" integer NOT NULL, "fs_providers" text NULL,
+"denied_login_methods" text NULL);
+ALTER TABLE "{{users}}" ADD COLUMN "plan" varchar(255) NULL;
+CREATE INDEX "users_plan_idx" ON "{{users}}" ("plan");`
+	sqliteV6SQL = `CREATE TABLE "user_templates" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(255) NULL, "template" text NOT NULL);`
+	sqliteV7SQL = `CREATE TABLE "folders" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "name" varchar(255) NOT NULL UNIQUE,
+"description" varchar(255) NULL, "mapped_path" varchar(512) NOT NULL, "quota_size" bigint NOT NULL, "quota_files" integer NOT NULL,
+"used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL, "last_quota_update" bigint NOT NULL);`
+	sqliteUsersV8SQL = `ALTER TABLE "{{users}}" ADD COLUMN "additional_info" text NULL;`
+	sqliteUsersV9SQL = `ALTER TABLE "{{users}}" ADD COLUMN "created_at" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "{{users}}" ADD COLUMN "updated_at" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "{{users}}" ADD COLUMN "version" bigint NOT NULL DEFAULT 0;`
+	sqliteV10SQL = `ALTER TABLE "plans" ADD COLUMN "password_breach_check" integer NOT NULL DEFAULT 0;`
 )
 
 // SQLiteProvider auth provider for SQLite database
@@ -41,6 +62,10 @@ type SQLiteProvider struct {
 	dbHandle *sql.DB
 }
 
+func init() {
+	utils.AddFeature("+sqlite")
+}
+
 func initializeSQLiteProvider(basePath string) error {
 	var err error
 	var connectionString string
@@ -57,18 +82,62 @@ func initializeSQLiteProvider(basePath string) error {
 	} else {
 		connectionString = config.ConnectionString
 	}
+	// the connection string is logged without the encryption key
+	logConnectionString := connectionString
+	if len(config.SQLiteKeyFile) > 0 {
+		if len(config.ConnectionString) > 0 {
+			return errors.New("sqlite_key_file cannot be used together with a custom connection string, add the " +
+				"_pragma_key parameter to the connection string instead")
+		}
+		key, err := loadSQLiteKey(basePath)
+		if err != nil {
+			return err
+		}
+		connectionString += "&_pragma_key=" + url.QueryEscape(key)
+	}
 	dbHandle, err := sql.Open("sqlite3", connectionString)
 	if err == nil {
-		providerLog(logger.LevelDebug, "sqlite database handle created, connection string: %#v", connectionString)
+		providerLog(logger.LevelDebug, "sqlite database handle created, connection string: %#v", logConnectionString)
 		dbHandle.SetMaxOpenConns(1)
+		if err = initializeSQLReadReplica("sqlite3", dbHandle); err != nil {
+			dbHandle.Close()
+			return err
+		}
 		provider = SQLiteProvider{dbHandle: dbHandle}
 	} else {
 		providerLog(logger.LevelWarn, "error creating sqlite database handler, connection string: %#v, error: %v",
-			connectionString, err)
+			logConnectionString, err)
 	}
 	return err
 }
 
+// loadSQLiteKey reads the SQLCipher passphrase from the configured key file
+func loadSQLiteKey(basePath string) (string, error) {
+	if !sqlCipherSupported {
+		return "", errors.New("sqlite_key_file requires SQLCipher support, build SFTPGo with the sqlcipher tag")
+	}
+	keyFile := config.SQLiteKeyFile
+	if !utils.IsFileInputValid(keyFile) {
+		return "", fmt.Errorf("invalid SQLite key file: %#v", keyFile)
+	}
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(basePath, keyFile)
+	}
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the SQLite key file: %v", err)
+	}
+	key := strings.TrimSpace(string(content))
+	if len(key) == 0 {
+		return "", fmt.Errorf("the SQLite key file %#v is empty", keyFile)
+	}
+	// the driver quotes the passphrase with double quotes without escaping it
+	if strings.Contains(key, "\"") {
+		return "", fmt.Errorf("the SQLite key inside %#v cannot contain double quotes", keyFile)
+	}
+	return key, nil
+}
+
 func (p SQLiteProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
@@ -93,6 +162,10 @@ func (p SQLiteProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p SQLiteProvider) updateUserPassword(username, password string) error {
+	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
+}
+
 func (p SQLiteProvider) getUsedQuota(username string) (int, int64, error) {
 	return sqlCommonGetUsedQuota(username, p.dbHandle)
 }
@@ -121,8 +194,112 @@ func (p SQLiteProvider) getUsers(limit int, offset int, order string, username s
 	return sqlCommonGetUsers(limit, offset, order, username, p.dbHandle)
 }
 
+func (p SQLiteProvider) getUsersPage(req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	return sqlCommonGetUsersPage(req, cursor, p.dbHandle)
+}
+
+func (p SQLiteProvider) getIPListEntries() ([]IPListEntry, error) {
+	return sqlCommonGetIPListEntries(p.dbHandle)
+}
+
+func (p SQLiteProvider) getIPListEntryByID(ID int64) (IPListEntry, error) {
+	return sqlCommonGetIPListEntryByID(ID, p.dbHandle)
+}
+
+func (p SQLiteProvider) addIPListEntry(entry IPListEntry) error {
+	return sqlCommonAddIPListEntry(entry, p.dbHandle)
+}
+
+func (p SQLiteProvider) updateIPListEntry(entry IPListEntry) error {
+	return sqlCommonUpdateIPListEntry(entry, p.dbHandle)
+}
+
+func (p SQLiteProvider) deleteIPListEntry(entry IPListEntry) error {
+	return sqlCommonDeleteIPListEntry(entry, p.dbHandle)
+}
+
+func (p SQLiteProvider) getPlans() ([]Plan, error) {
+	return sqlCommonGetPlans(p.dbHandle)
+}
+
+func (p SQLiteProvider) getPlanByID(ID int64) (Plan, error) {
+	return sqlCommonGetPlanByID(ID, p.dbHandle)
+}
+
+func (p SQLiteProvider) planExists(name string) (Plan, error) {
+	return sqlCommonCheckPlanExists(name, p.dbHandle)
+}
+
+func (p SQLiteProvider) addPlan(plan Plan) error {
+	return sqlCommonAddPlan(plan, p.dbHandle)
+}
+
+func (p SQLiteProvider) updatePlan(plan Plan, applyToUsers bool) error {
+	return sqlCommonUpdatePlan(plan, applyToUsers, p.dbHandle)
+}
+
+func (p SQLiteProvider) deletePlan(plan Plan) error {
+	return sqlCommonDeletePlan(plan, p.dbHandle)
+}
+
+func (p SQLiteProvider) getUserTemplates() ([]UserTemplate, error) {
+	return sqlCommonGetUserTemplates(p.dbHandle)
+}
+
+func (p SQLiteProvider) getUserTemplateByID(ID int64) (UserTemplate, error) {
+	return sqlCommonGetUserTemplateByID(ID, p.dbHandle)
+}
+
+func (p SQLiteProvider) userTemplateExists(name string) (UserTemplate, error) {
+	return sqlCommonCheckUserTemplateExists(name, p.dbHandle)
+}
+
+func (p SQLiteProvider) addUserTemplate(template UserTemplate) error {
+	return sqlCommonAddUserTemplate(template, p.dbHandle)
+}
+
+func (p SQLiteProvider) updateUserTemplate(template UserTemplate) error {
+	return sqlCommonUpdateUserTemplate(template, p.dbHandle)
+}
+
+func (p SQLiteProvider) deleteUserTemplate(template UserTemplate) error {
+	return sqlCommonDeleteUserTemplate(template, p.dbHandle)
+}
+
+func (p SQLiteProvider) getFolders() ([]Folder, error) {
+	return sqlCommonGetFolders(p.dbHandle)
+}
+
+func (p SQLiteProvider) getFolderByID(ID int64) (Folder, error) {
+	return sqlCommonGetFolderByID(ID, p.dbHandle)
+}
+
+func (p SQLiteProvider) folderExists(name string) (Folder, error) {
+	return sqlCommonCheckFolderExists(name, p.dbHandle)
+}
+
+func (p SQLiteProvider) addFolder(folder Folder) error {
+	return sqlCommonAddFolder(folder, p.dbHandle)
+}
+
+func (p SQLiteProvider) updateFolder(folder Folder) error {
+	return sqlCommonUpdateFolder(folder, p.dbHandle)
+}
+
+func (p SQLiteProvider) deleteFolder(folder Folder) error {
+	return sqlCommonDeleteFolder(folder, p.dbHandle)
+}
+
+func (p SQLiteProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateFolderQuota(name, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p SQLiteProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	return sqlCommonGetUsedFolderQuota(name, p.dbHandle)
+}
+
 func (p SQLiteProvider) close() error {
-	return p.dbHandle.Close()
+	return sqlCommonClose(p.dbHandle)
 }
 
 func (p SQLiteProvider) reloadConfig() error {
@@ -152,9 +329,163 @@ func (p SQLiteProvider) migrateDatabase() error {
 		if err != nil {
 			return err
 		}
-		return updateSQLiteDatabaseFrom2To3(p.dbHandle)
+		err = updateSQLiteDatabaseFrom2To3(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
 	case 2:
-		return updateSQLiteDatabaseFrom2To3(p.dbHandle)
+		err = updateSQLiteDatabaseFrom2To3(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 3:
+		err = updateSQLiteDatabaseFrom3To4(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 4:
+		err = updateSQLiteDatabaseFrom4To5(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 5:
+		err = updateSQLiteDatabaseFrom5To6(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 6:
+		err = updateSQLiteDatabaseFrom6To7(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 7:
+		err = updateSQLiteDatabaseFrom7To8(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 8:
+		err = updateSQLiteDatabaseFrom8To9(p.dbHandle)
+		if err != nil {
+			return err
+		}
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
+	case 9:
+		return updateSQLiteDatabaseFrom9To10(p.dbHandle)
 	default:
 		return fmt.Errorf("Database version not handled: %v", dbVersion.Version)
 	}
@@ -179,3 +510,69 @@ func updateSQLiteDatabaseFrom2To3(dbHandle *sql.DB) error {
 	}
 	return sqlCommonUpdateDatabaseVersion(dbHandle, 3)
 }
+
+func updateSQLiteDatabaseFrom3To4(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 3 -> 4")
+	_, err := dbHandle.Exec(sqliteV4SQL)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 4)
+}
+
+func updateSQLiteDatabaseFrom4To5(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 4 -> 5")
+	sql := strings.ReplaceAll(sqliteV5SQL, "{{users}}", config.UsersTable)
+	_, err := dbHandle.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 5)
+}
+
+func updateSQLiteDatabaseFrom5To6(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 5 -> 6")
+	_, err := dbHandle.Exec(sqliteV6SQL)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 6)
+}
+
+func updateSQLiteDatabaseFrom6To7(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 6 -> 7")
+	_, err := dbHandle.Exec(sqliteV7SQL)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 7)
+}
+
+func updateSQLiteDatabaseFrom7To8(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 7 -> 8")
+	sql := strings.Replace(sqliteUsersV8SQL, "{{users}}", config.UsersTable, 1)
+	_, err := dbHandle.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 8)
+}
+
+func updateSQLiteDatabaseFrom8To9(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
+	sql := strings.ReplaceAll(sqliteUsersV9SQL, "{{users}}", config.UsersTable)
+	_, err := dbHandle.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 9)
+}
+
+func updateSQLiteDatabaseFrom9To10(dbHandle *sql.DB) error {
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	_, err := dbHandle.Exec(sqliteV10SQL)
+	if err != nil {
+		return err
+	}
+	return sqlCommonUpdateDatabaseVersion(dbHandle, 10)
+}
@@ -1,17 +1,31 @@
 package dataprovider
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 const (
 	selectUserFields = "id,username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,used_quota_size," +
 		"used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,expiration_date,last_login,status,filters,filesystem," +
-		"virtual_folders"
+		"virtual_folders,plan,additional_info,created_at,updated_at,version"
+	selectIPListFields = "id,ipornet,type,description"
+	ipListsTable       = "ip_lists"
+	selectPlanFields   = "id,name,description,max_sessions,quota_size,quota_files,upload_bandwidth,download_bandwidth," +
+		"fs_providers,denied_login_methods,password_breach_check"
+	plansTable               = "plans"
+	selectUserTemplateFields = "id,name,description,template"
+	userTemplatesTable       = "user_templates"
+	selectFolderFields       = "id,name,description,mapped_path,quota_size,quota_files,used_quota_size,used_quota_files," +
+		"last_quota_update"
+	foldersTable = "folders"
 )
 
 func getSQLPlaceholders() []string {
 	var placeholders []string
-	for i := 1; i <= 20; i++ {
-		if config.Driver == PGSQLDataProviderName {
+	for i := 1; i <= 25; i++ {
+		// a migration target with a different SQL dialect is not allowed, see MigrationTarget.validate
+		if config.Driver == PGSQLDataProviderName || config.MigrationTarget.Driver == PGSQLDataProviderName {
 			placeholders = append(placeholders, fmt.Sprintf("$%v", i))
 		} else {
 			placeholders = append(placeholders, "?")
@@ -37,6 +51,82 @@ func getUsersQuery(order string, username string) string {
 		order, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
+// usersQueryConditions builds the conditions for the users queries, the placeholders are numbered
+// in the same order as the arguments
+type usersQueryConditions struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add adds a condition, it must contain a %v verb for each argument
+func (c *usersQueryConditions) add(condition string, args ...interface{}) {
+	placeholders := make([]interface{}, 0, len(args))
+	for idx := range args {
+		placeholders = append(placeholders, sqlPlaceholders[len(c.args)+idx])
+	}
+	c.conditions = append(c.conditions, fmt.Sprintf(condition, placeholders...))
+	c.args = append(c.args, args...)
+}
+
+func (c *usersQueryConditions) getWhereClause() string {
+	if len(c.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.conditions, " AND ")
+}
+
+// getUsersQueryConditions returns the conditions for the given username and filters
+func getUsersQueryConditions(username string, filter UsersFilter, now int64) *usersQueryConditions {
+	c := &usersQueryConditions{}
+	if len(username) > 0 {
+		c.add("username = %v", username)
+	}
+	if filter.Status != nil {
+		c.add("status = %v", *filter.Status)
+	}
+	if filter.ExpiresAfter > 0 || filter.ExpiresBefore > 0 {
+		c.add("expiration_date > %v", 0)
+	}
+	if filter.ExpiresAfter > 0 {
+		c.add("expiration_date >= %v", filter.ExpiresAfter)
+	}
+	if filter.ExpiresBefore > 0 {
+		c.add("expiration_date <= %v", filter.ExpiresBefore)
+	}
+	if filter.FsProvider != nil {
+		// the filesystem is stored as JSON and the provider is always its first field, this works
+		// for all the supported databases without requiring the JSON functions
+		prefix := fmt.Sprintf(`{"provider":%v`, *filter.FsProvider)
+		if *filter.FsProvider == 0 {
+			c.add("(filesystem IS NULL OR filesystem = '' OR filesystem LIKE %v OR filesystem = %v)", prefix+",%",
+				prefix+"}")
+		} else {
+			c.add("(filesystem LIKE %v OR filesystem = %v)", prefix+",%", prefix+"}")
+		}
+	}
+	if filter.MinUsedQuotaSize > 0 {
+		c.add("used_quota_size >= %v", filter.MinUsedQuotaSize)
+	}
+	if filter.MinQuotaUsagePercent > 0 {
+		c.add("quota_size > 0 AND used_quota_size * 100 >= quota_size * %v", filter.MinQuotaUsagePercent)
+	}
+	if filter.LastLoginOlderThanDays > 0 {
+		c.add("last_login < %v", filter.getLastLoginThreshold(now))
+	}
+	return c
+}
+
+func getCountUsersQuery(whereClause string) string {
+	return fmt.Sprintf(`SELECT COUNT(*) FROM %v%v`, config.UsersTable, whereClause)
+}
+
+// getUsersPageQuery returns the query for a users page ordered by the given field and by id.
+// The limit and the offset follow the given number of arguments for the where clause
+func getUsersPageQuery(orderBy, order, whereClause string, numArgs int) string {
+	return fmt.Sprintf(`SELECT %v FROM %v%v ORDER BY %v %v,id %v LIMIT %v OFFSET %v`, selectUserFields,
+		config.UsersTable, whereClause, orderBy, order, order, sqlPlaceholders[numArgs], sqlPlaceholders[numArgs+1])
+}
+
 func getDumpUsersQuery() string {
 	return fmt.Sprintf(`SELECT %v FROM %v`, selectUserFields, config.UsersTable)
 }
@@ -54,6 +144,10 @@ func getUpdateLastLoginQuery() string {
 	return fmt.Sprintf(`UPDATE %v SET last_login = %v WHERE username = %v`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
+func getUpdateUserPasswordQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET password = %v WHERE username = %v`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
 func getQuotaQuery() string {
 	return fmt.Sprintf(`SELECT used_quota_size,used_quota_files FROM %v WHERE username = %v`, config.UsersTable,
 		sqlPlaceholders[0])
@@ -62,20 +156,28 @@ func getQuotaQuery() string {
 func getAddUserQuery() string {
 	return fmt.Sprintf(`INSERT INTO %v (username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,
 		used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,status,last_login,expiration_date,filters,
-		filesystem,virtual_folders)
-		VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,0,0,0,%v,%v,%v,0,%v,%v,%v,%v)`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1],
-		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
-		sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13],
-		sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16])
+		filesystem,virtual_folders,plan,additional_info,created_at,updated_at,version)
+		VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,0,0,0,%v,%v,%v,0,%v,%v,%v,%v,%v,%v,%v,%v,%v)`, config.UsersTable, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12],
+		sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18],
+		sqlPlaceholders[19], sqlPlaceholders[20], sqlPlaceholders[21])
 }
 
+// getUpdateUserQuery returns the query to update a user, the user is updated only if the stored
+// version is the expected one
 func getUpdateUserQuery() string {
 	return fmt.Sprintf(`UPDATE %v SET password=%v,public_keys=%v,home_dir=%v,uid=%v,gid=%v,max_sessions=%v,quota_size=%v,
 		quota_files=%v,permissions=%v,upload_bandwidth=%v,download_bandwidth=%v,status=%v,expiration_date=%v,filters=%v,filesystem=%v,
-		virtual_folders=%v WHERE id = %v`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
-		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15],
-		sqlPlaceholders[16])
+		virtual_folders=%v,plan=%v,additional_info=%v,updated_at=%v,version=%v WHERE id = %v AND version = %v`, config.UsersTable,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11],
+		sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17],
+		sqlPlaceholders[18], sqlPlaceholders[19], sqlPlaceholders[20], sqlPlaceholders[21])
+}
+
+func getUserVersionQuery() string {
+	return fmt.Sprintf(`SELECT created_at,version FROM %v WHERE id = %v`, config.UsersTable, sqlPlaceholders[0])
 }
 
 func getDeleteUserQuery() string {
@@ -89,3 +191,135 @@ func getDatabaseVersionQuery() string {
 func getUpdateDBVersionQuery() string {
 	return fmt.Sprintf(`UPDATE schema_version SET version=%v`, sqlPlaceholders[0])
 }
+
+func getIPListEntriesQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY id ASC`, selectIPListFields, ipListsTable)
+}
+
+func getIPListEntryByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE id = %v`, selectIPListFields, ipListsTable, sqlPlaceholders[0])
+}
+
+func getAddIPListEntryQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (ipornet,type,description) VALUES (%v,%v,%v)`, ipListsTable, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getUpdateIPListEntryQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET ipornet=%v,type=%v,description=%v WHERE id = %v`, ipListsTable, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getDeleteIPListEntryQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, ipListsTable, sqlPlaceholders[0])
+}
+
+func getPlansQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY name ASC`, selectPlanFields, plansTable)
+}
+
+func getPlanByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE id = %v`, selectPlanFields, plansTable, sqlPlaceholders[0])
+}
+
+func getPlanByNameQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE name = %v`, selectPlanFields, plansTable, sqlPlaceholders[0])
+}
+
+func getAddPlanQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (name,description,max_sessions,quota_size,quota_files,upload_bandwidth,download_bandwidth,
+		fs_providers,denied_login_methods,password_breach_check) VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v)`, plansTable,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9])
+}
+
+func getUpdatePlanQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET description=%v,max_sessions=%v,quota_size=%v,quota_files=%v,upload_bandwidth=%v,
+		download_bandwidth=%v,fs_providers=%v,denied_login_methods=%v,password_breach_check=%v WHERE id = %v`, plansTable,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9])
+}
+
+func getDeletePlanQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, plansTable, sqlPlaceholders[0])
+}
+
+func getUsersWithPlanQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE plan = %v`, selectUserFields, config.UsersTable, sqlPlaceholders[0])
+}
+
+func getCountUsersWithPlanQuery() string {
+	return fmt.Sprintf(`SELECT COUNT(*) FROM %v WHERE plan = %v`, config.UsersTable, sqlPlaceholders[0])
+}
+
+func getUpdateUserPlanLimitsQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET max_sessions=%v,quota_size=%v,quota_files=%v,upload_bandwidth=%v,download_bandwidth=%v,
+		filters=%v WHERE id = %v`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+}
+
+func getUserTemplatesQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY name ASC`, selectUserTemplateFields, userTemplatesTable)
+}
+
+func getUserTemplateByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE id = %v`, selectUserTemplateFields, userTemplatesTable, sqlPlaceholders[0])
+}
+
+func getUserTemplateByNameQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE name = %v`, selectUserTemplateFields, userTemplatesTable, sqlPlaceholders[0])
+}
+
+func getAddUserTemplateQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (name,description,template) VALUES (%v,%v,%v)`, userTemplatesTable,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getUpdateUserTemplateQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET description=%v,template=%v WHERE id = %v`, userTemplatesTable, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getDeleteUserTemplateQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, userTemplatesTable, sqlPlaceholders[0])
+}
+
+func getFoldersQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY name ASC`, selectFolderFields, foldersTable)
+}
+
+func getFolderByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE id = %v`, selectFolderFields, foldersTable, sqlPlaceholders[0])
+}
+
+func getFolderByNameQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE name = %v`, selectFolderFields, foldersTable, sqlPlaceholders[0])
+}
+
+func getAddFolderQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (name,description,mapped_path,quota_size,quota_files,used_quota_size,used_quota_files,
+		last_quota_update) VALUES (%v,%v,%v,%v,%v,0,0,0)`, foldersTable, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getUpdateFolderQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET description=%v,mapped_path=%v,quota_size=%v,quota_files=%v WHERE id = %v`, foldersTable,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getDeleteFolderQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, foldersTable, sqlPlaceholders[0])
+}
+
+func getUpdateFolderQuotaQuery(reset bool) string {
+	if reset {
+		return fmt.Sprintf(`UPDATE %v SET used_quota_size = %v,used_quota_files = %v,last_quota_update = %v
+			WHERE name = %v`, foldersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	}
+	return fmt.Sprintf(`UPDATE %v SET used_quota_size = used_quota_size + %v,used_quota_files = used_quota_files + %v,last_quota_update = %v
+		WHERE name = %v`, foldersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getFolderQuotaQuery() string {
+	return fmt.Sprintf(`SELECT used_quota_size,used_quota_files FROM %v WHERE name = %v`, foldersTable, sqlPlaceholders[0])
+}
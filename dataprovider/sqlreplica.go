@@ -0,0 +1,77 @@
+package dataprovider
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// the read replica handles for the initialized databases, the migration target could be
+// a different SQL database
+var sqlReadReplicas = struct {
+	sync.RWMutex
+	handles map[*sql.DB]*sql.DB
+}{
+	handles: make(map[*sql.DB]*sql.DB),
+}
+
+// initializeSQLReadReplica opens the configured read replica, if any, for the given primary database
+func initializeSQLReadReplica(driverName string, dbHandle *sql.DB) error {
+	if len(config.ReadReplicaConnectionString) == 0 {
+		return nil
+	}
+	readHandle, err := sql.Open(driverName, config.ReadReplicaConnectionString)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error creating %v read replica database handler: %v", driverName, err)
+		return err
+	}
+	switch driverName {
+	case "sqlite3":
+		readHandle.SetMaxOpenConns(1)
+	case "mysql":
+		readHandle.SetMaxOpenConns(config.PoolSize)
+		readHandle.SetConnMaxLifetime(1800 * time.Second)
+	default:
+		readHandle.SetMaxOpenConns(config.PoolSize)
+	}
+	providerLog(logger.LevelDebug, "%v read replica database handle created", driverName)
+
+	sqlReadReplicas.Lock()
+	defer sqlReadReplicas.Unlock()
+
+	sqlReadReplicas.handles[dbHandle] = readHandle
+	return nil
+}
+
+// getSQLReadHandle returns the read replica for the given primary database or the primary
+// database itself if no read replica is configured.
+// The read replica is used for the login lookups and the users listings, writes and the
+// other reads go to the primary, so the data read after a write is always up to date
+func getSQLReadHandle(dbHandle *sql.DB) *sql.DB {
+	sqlReadReplicas.RLock()
+	defer sqlReadReplicas.RUnlock()
+
+	if readHandle, ok := sqlReadReplicas.handles[dbHandle]; ok {
+		return readHandle
+	}
+	return dbHandle
+}
+
+// sqlCommonClose closes the given primary database and its read replica, if any
+func sqlCommonClose(dbHandle *sql.DB) error {
+	sqlReadReplicas.Lock()
+	readHandle, ok := sqlReadReplicas.handles[dbHandle]
+	delete(sqlReadReplicas.handles, dbHandle)
+	sqlReadReplicas.Unlock()
+
+	closePreparedStmts(dbHandle)
+	if ok {
+		closePreparedStmts(readHandle)
+		if err := readHandle.Close(); err != nil {
+			providerLog(logger.LevelWarn, "error closing the read replica database handler: %v", err)
+		}
+	}
+	return dbHandle.Close()
+}
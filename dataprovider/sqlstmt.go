@@ -0,0 +1,75 @@
+package dataprovider
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// sqlPreparedStmts caches the prepared statements for each database handle, the read replica
+// has its own handle. The queries are prepared once and reused, a prepared statement is safe
+// for concurrent use and it is prepared again, as needed, on the other pool connections
+var sqlPreparedStmts = struct {
+	sync.RWMutex
+	stmts map[*sql.DB]map[string]*sql.Stmt
+}{
+	stmts: make(map[*sql.DB]map[string]*sql.Stmt),
+}
+
+// getPreparedStmt returns the prepared statement for the given query, preparing it if needed.
+// The returned statement must not be closed
+func getPreparedStmt(dbHandle *sql.DB, q string) (*sql.Stmt, error) {
+	sqlPreparedStmts.RLock()
+	stmt, ok := sqlPreparedStmts.stmts[dbHandle][q]
+	sqlPreparedStmts.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	sqlPreparedStmts.Lock()
+	defer sqlPreparedStmts.Unlock()
+
+	stmts, ok := sqlPreparedStmts.stmts[dbHandle]
+	if !ok {
+		stmts = make(map[string]*sql.Stmt)
+		sqlPreparedStmts.stmts[dbHandle] = stmts
+	}
+	if stmt, ok := stmts[q]; ok {
+		return stmt, nil
+	}
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		return nil, err
+	}
+	stmts[q] = stmt
+	return stmt, nil
+}
+
+// closePreparedStmts closes the prepared statements for the given database handle
+func closePreparedStmts(dbHandle *sql.DB) {
+	sqlPreparedStmts.Lock()
+	stmts := sqlPreparedStmts.stmts[dbHandle]
+	delete(sqlPreparedStmts.stmts, dbHandle)
+	sqlPreparedStmts.Unlock()
+
+	for q, stmt := range stmts {
+		if err := stmt.Close(); err != nil {
+			providerLog(logger.LevelDebug, "error closing the prepared statement for query %#v: %v", q, err)
+		}
+	}
+}
+
+// resetPreparedStmts closes the prepared statements for the previously initialized databases
+func resetPreparedStmts() {
+	sqlPreparedStmts.Lock()
+	handles := make([]*sql.DB, 0, len(sqlPreparedStmts.stmts))
+	for dbHandle := range sqlPreparedStmts.stmts {
+		handles = append(handles, dbHandle)
+	}
+	sqlPreparedStmts.Unlock()
+
+	for _, dbHandle := range handles {
+		closePreparedStmts(dbHandle)
+	}
+}
@@ -0,0 +1,238 @@
+package dataprovider
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// UserTemplate defines a named template for new users. The "%username%" placeholder inside
+// the home dir, the S3 and GCS key prefixes and the virtual folders paths is replaced with
+// the username of each user created from the template
+type UserTemplate struct {
+	// Database unique identifier
+	ID int64 `json:"id"`
+	// unique template name, it cannot be changed
+	Name string `json:"name"`
+	// optional description
+	Description string `json:"description,omitempty"`
+	// the user to use as template. The fields specific to a single user, such as the username,
+	// the credentials, the quota usage and the last login, are ignored
+	User User `json:"user"`
+}
+
+// UserCloneRequest defines the user to create from a template or from an existing user
+type UserCloneRequest struct {
+	Username string `json:"username"`
+	// password or public keys are required
+	Password   string   `json:"password,omitempty"`
+	PublicKeys []string `json:"public_keys,omitempty"`
+}
+
+// GetUser returns a new user built from the template, the placeholders are expanded using
+// the requested username. The returned user is not saved
+func (t *UserTemplate) GetUser(req UserCloneRequest) User {
+	return getUserFromTemplate(t.User, req)
+}
+
+// GetUserTemplates returns all the defined user templates
+func GetUserTemplates(p Provider) ([]UserTemplate, error) {
+	return p.getUserTemplates()
+}
+
+// GetUserTemplateByID returns the user template with the given database ID if a match is found or an error
+func GetUserTemplateByID(p Provider, ID int64) (UserTemplate, error) {
+	return p.getUserTemplateByID(ID)
+}
+
+// UserTemplateExists returns the user template with the given name if a match is found or an error
+func UserTemplateExists(p Provider, name string) (UserTemplate, error) {
+	return p.userTemplateExists(name)
+}
+
+// AddUserTemplate adds a new user template.
+// ManageUsers configuration must be set to 1 to enable this method
+func AddUserTemplate(p Provider, template UserTemplate) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	if err := applyUserFolders(p, &template.User); err != nil {
+		return err
+	}
+	return p.addUserTemplate(template)
+}
+
+// UpdateUserTemplate updates an existing user template, the users already created from the
+// template are not changed.
+// ManageUsers configuration must be set to 1 to enable this method
+func UpdateUserTemplate(p Provider, template UserTemplate) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	if err := applyUserFolders(p, &template.User); err != nil {
+		return err
+	}
+	return p.updateUserTemplate(template)
+}
+
+// DeleteUserTemplate deletes an existing user template.
+// ManageUsers configuration must be set to 1 to enable this method
+func DeleteUserTemplate(p Provider, template UserTemplate) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.deleteUserTemplate(template)
+}
+
+// GetUserClone returns a new user built from the given existing user. The path elements equal
+// to the source username inside the home dir, the key prefixes and the virtual folders paths
+// are replaced with the requested username. The returned user is not saved
+func GetUserClone(source User, req UserCloneRequest) (User, error) {
+	template := source.getACopy()
+	if err := addCredentialsToUser(&template); err != nil {
+		return User{}, err
+	}
+	replaceUsernameWithPlaceholder(&template, source.Username)
+	providerLog(logger.LevelDebug, "cloning user %#v, new username: %#v", source.Username, req.Username)
+	return getUserFromTemplate(template, req), nil
+}
+
+// getTemplateUser returns a copy of the given user without the fields specific to a single user
+func getTemplateUser(user User) User {
+	u := user.getACopy()
+	u.ID = 0
+	u.Username = ""
+	u.Password = ""
+	u.PublicKeys = nil
+	u.UsedQuotaSize = 0
+	u.UsedQuotaFiles = 0
+	u.LastQuotaUpdate = 0
+	u.LastLogin = 0
+	u.AdditionalInfo = ""
+	u.CreatedAt = 0
+	u.UpdatedAt = 0
+	u.Version = 0
+	u.Filters.RevokedKeyFingerprints = nil
+	u.Filters.FirstLogin.TermsAcceptedAt = 0
+	u.Filters.FirstLogin.PasswordChangedAt = 0
+	return u
+}
+
+func getUserFromTemplate(template User, req UserCloneRequest) User {
+	user := getTemplateUser(template)
+	user.Username = req.Username
+	user.Password = req.Password
+	if len(req.PublicKeys) > 0 {
+		user.PublicKeys = make([]string, len(req.PublicKeys))
+		copy(user.PublicKeys, req.PublicKeys)
+	}
+	expandUsernamePlaceholder(&user, req.Username)
+	return user
+}
+
+// expandUsernamePlaceholder replaces the username placeholder inside the home dir, the key
+// prefixes and the virtual folders paths with the given username
+func expandUsernamePlaceholder(user *User, username string) {
+	expand := func(s string) string {
+		return strings.Replace(s, usernamePlaceholder, username, -1)
+	}
+	user.HomeDir = expand(user.HomeDir)
+	user.FsConfig.S3Config.KeyPrefix = expand(user.FsConfig.S3Config.KeyPrefix)
+	user.FsConfig.GCSConfig.KeyPrefix = expand(user.FsConfig.GCSConfig.KeyPrefix)
+	for idx := range user.VirtualFolders {
+		folder := &user.VirtualFolders[idx]
+		folder.VirtualPath = expand(folder.VirtualPath)
+		folder.MappedPath = expand(folder.MappedPath)
+		if folder.Filesystem != nil {
+			folder.Filesystem.S3Config.KeyPrefix = expand(folder.Filesystem.S3Config.KeyPrefix)
+			folder.Filesystem.GCSConfig.KeyPrefix = expand(folder.Filesystem.GCSConfig.KeyPrefix)
+		}
+	}
+}
+
+// replaceUsernameWithPlaceholder replaces the path elements equal to the given username with
+// the username placeholder inside the home dir, the key prefixes and the virtual folders paths
+func replaceUsernameWithPlaceholder(user *User, username string) {
+	if len(username) == 0 {
+		return
+	}
+	osSeparator := string(os.PathSeparator)
+	user.HomeDir = replacePathElement(user.HomeDir, osSeparator, username, usernamePlaceholder)
+	user.FsConfig.S3Config.KeyPrefix = replacePathElement(user.FsConfig.S3Config.KeyPrefix, "/", username,
+		usernamePlaceholder)
+	user.FsConfig.GCSConfig.KeyPrefix = replacePathElement(user.FsConfig.GCSConfig.KeyPrefix, "/", username,
+		usernamePlaceholder)
+	for idx := range user.VirtualFolders {
+		folder := &user.VirtualFolders[idx]
+		folder.VirtualPath = replacePathElement(folder.VirtualPath, "/", username, usernamePlaceholder)
+		folder.MappedPath = replacePathElement(folder.MappedPath, osSeparator, username, usernamePlaceholder)
+		if folder.Filesystem != nil {
+			folder.Filesystem.S3Config.KeyPrefix = replacePathElement(folder.Filesystem.S3Config.KeyPrefix, "/",
+				username, usernamePlaceholder)
+			folder.Filesystem.GCSConfig.KeyPrefix = replacePathElement(folder.Filesystem.GCSConfig.KeyPrefix, "/",
+				username, usernamePlaceholder)
+		}
+	}
+}
+
+// replacePathElement replaces the elements of the given path equal to old with new
+func replacePathElement(p, separator, old, new string) string {
+	elements := strings.Split(p, separator)
+	for idx, element := range elements {
+		if element == old {
+			elements[idx] = new
+		}
+	}
+	return strings.Join(elements, separator)
+}
+
+// validateUserTemplate validates the given template and encrypts the secrets of the template
+// user. The template user is fully validated only when a user is created from the template
+func validateUserTemplate(template *UserTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if len(template.Name) == 0 {
+		return &ValidationError{err: "name is mandatory"}
+	}
+	if len(template.Name) > 255 {
+		return &ValidationError{err: "name is too long, max 255 characters"}
+	}
+	if !validPlanNameRegex.MatchString(template.Name) {
+		return &ValidationError{err: fmt.Sprintf("name %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.",
+			template.Name)}
+	}
+	if len(template.Description) > 255 {
+		return &ValidationError{err: "description is too long, max 255 characters"}
+	}
+	template.User = getTemplateUser(template.User)
+	user := &template.User
+	if len(user.HomeDir) > 0 && !filepath.IsAbs(user.HomeDir) {
+		return &ValidationError{err: fmt.Sprintf("home_dir must be an absolute path, actual value: %v", user.HomeDir)}
+	}
+	if len(user.Permissions) > 0 {
+		if err := validatePermissions(user); err != nil {
+			return err
+		}
+	}
+	if err := validateFilesystemConfig(user); err != nil {
+		return err
+	}
+	if user.FsConfig.Provider == 2 {
+		// the credentials are saved to a file only for the users created from the template
+		credentials, err := kms.EncryptIfNeeded(user.FsConfig.GCSConfig.Credentials)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt GCS credentials: %v", err)}
+		}
+		user.FsConfig.GCSConfig.Credentials = credentials
+	}
+	if err := validateVirtualFolders(user); err != nil {
+		return err
+	}
+	if user.Status < 0 || user.Status > 1 {
+		return &ValidationError{err: fmt.Sprintf("invalid user status: %v", user.Status)}
+	}
+	return nil
+}
@@ -54,6 +54,22 @@ const (
 	SSHLoginMethodKeyAndKeyboardInt   = "publickey+keyboard-interactive"
 )
 
+// Supported filesystem providers
+const (
+	LocalFilesystemProvider = iota
+	S3FilesystemProvider
+	GCSFilesystemProvider
+	CryptedFilesystemProvider
+	WebDAVFilesystemProvider
+	HDFSFilesystemProvider
+	GoogleDriveFilesystemProvider
+	DropboxFilesystemProvider
+)
+
+func isFilesystemProviderSupported(fsProvider int) bool {
+	return fsProvider >= LocalFilesystemProvider && fsProvider <= DropboxFilesystemProvider
+}
+
 // ExtensionsFilter defines filters based on file extensions.
 // These restrictions do not apply to files listing for performance reasons, so
 // a denied file cannot be downloaded/overwritten/renamed but will still be
@@ -77,6 +93,42 @@ type ExtensionsFilter struct {
 	DeniedExtensions []string `json:"denied_extensions,omitempty"`
 }
 
+// IngestionFolder defines a directory optimized for many small uploads and appends,
+// for example log files shipped by many clients.
+// Inside an ingestion folder the quota updates are batched and the upload actions for
+// the same file are coalesced, they are applied/executed periodically
+type IngestionFolder struct {
+	// SFTP/SCP path, the settings apply to its sub directories too
+	Path string `json:"path"`
+	// if enabled, each hour, the files uploaded directly inside this directory before the
+	// current hour are rolled up into a compressed tar archive, one for each hour
+	HourlyRollup bool `json:"hourly_rollup,omitempty"`
+}
+
+// FirstLoginActions defines the actions a user must complete on the first login.
+// The login is allowed only after completing all the required actions.
+// The completion timestamps are set by SFTPGo, clear them to require the actions again
+type FirstLoginActions struct {
+	// the user must accept the configured terms of use
+	AcceptTerms bool `json:"accept_terms,omitempty"`
+	// the user must replace the initial password
+	ChangePassword bool `json:"change_password,omitempty"`
+	// when the terms of use were accepted as unix timestamp in milliseconds, 0 means not accepted
+	TermsAcceptedAt int64 `json:"terms_accepted_at,omitempty"`
+	// when the initial password was changed as unix timestamp in milliseconds, 0 means not changed
+	PasswordChangedAt int64 `json:"password_changed_at,omitempty"`
+}
+
+// IsTermsAcceptancePending returns true if the user must still accept the terms of use
+func (a *FirstLoginActions) IsTermsAcceptancePending() bool {
+	return a.AcceptTerms && a.TermsAcceptedAt == 0
+}
+
+// IsPasswordChangePending returns true if the user must still change the initial password
+func (a *FirstLoginActions) IsPasswordChangePending() bool {
+	return a.ChangePassword && a.PasswordChangedAt == 0
+}
+
 // UserFilters defines additional restrictions for a user
 type UserFilters struct {
 	// only clients connecting from these IP/Mask are allowed.
@@ -89,17 +141,44 @@ type UserFilters struct {
 	// these login methods are not allowed.
 	// If null or empty any available login method is allowed
 	DeniedLoginMethods []string `json:"denied_login_methods,omitempty"`
+	// SHA256 fingerprints, for example "SHA256:jZ3j...", of revoked public keys.
+	// Public key authentication with a revoked key is refused
+	RevokedKeyFingerprints []string `json:"revoked_key_fingerprints,omitempty"`
+	// only public keys using these algorithms are allowed, for example "ssh-ed25519".
+	// If null or empty any supported algorithm is allowed
+	AllowedKeyAlgorithms []string `json:"allowed_key_algorithms,omitempty"`
+	// minimum size, in bits, for RSA public keys. 0 means no restrictions
+	MinRSAKeySize int `json:"min_rsa_key_size,omitempty"`
+	// only these SSH commands, for example "scp", can be executed by the user. The commands
+	// must be enabled in the SFTP server configuration too.
+	// If null or empty any enabled command is allowed
+	AllowedSSHCommands []string `json:"allowed_ssh_commands,omitempty"`
 	// filters based on file extensions.
 	// Please note that these restrictions can be easily bypassed.
 	FileExtensions []ExtensionsFilter `json:"file_extensions,omitempty"`
+	// directories optimized for the ingestion of many small uploads and appends
+	IngestionFolders []IngestionFolder `json:"ingestion_folders,omitempty"`
+	// optional tenant name, the active connections for this user are tagged with it
+	Tenant string `json:"tenant,omitempty"`
+	// if enabled the whole account is read only regardless of the granted permissions:
+	// uploads and any other change to the filesystem are denied
+	ReadOnly bool `json:"read_only,omitempty"`
+	// actions to complete on the first login
+	FirstLogin FirstLoginActions `json:"first_login,omitempty"`
 }
 
 // Filesystem defines cloud storage filesystem details
 type Filesystem struct {
-	// 0 local filesystem, 1 Amazon S3 compatible, 2 Google Cloud Storage
-	Provider  int             `json:"provider"`
-	S3Config  vfs.S3FsConfig  `json:"s3config,omitempty"`
-	GCSConfig vfs.GCSFsConfig `json:"gcsconfig,omitempty"`
+	// 0 local filesystem, 1 Amazon S3 compatible, 2 Google Cloud Storage, 3 encrypted local filesystem,
+	// 4 remote WebDAV server, 5 HDFS, 6 Google Drive, 7 Dropbox
+	Provider          int                     `json:"provider"`
+	S3Config          vfs.S3FsConfig          `json:"s3config,omitempty"`
+	GCSConfig         vfs.GCSFsConfig         `json:"gcsconfig,omitempty"`
+	CryptConfig       vfs.CryptFsConfig       `json:"cryptconfig,omitempty"`
+	WebDAVConfig      vfs.WebDAVFsConfig      `json:"webdavconfig,omitempty"`
+	HDFSConfig        vfs.HDFSFsConfig        `json:"hdfsconfig,omitempty"`
+	GoogleDriveConfig vfs.GoogleDriveFsConfig `json:"gdriveconfig,omitempty"`
+	DropboxConfig     vfs.DropboxFsConfig     `json:"dropboxconfig,omitempty"`
 }
 
 // User defines an SFTP user
@@ -151,18 +230,49 @@ type User struct {
 	Filters UserFilters `json:"filters"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// optional service plan name. The plan limits and feature toggles replace the user ones
+	Plan string `json:"plan"`
+	// free form text field, for example to store billing IDs or contract references.
+	// It is not used by SFTPGo
+	AdditionalInfo string `json:"additional_info"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// Last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+	// Incremented on each update. An update for a version different from the stored one is
+	// rejected, 0 means that the version is not checked
+	Version int64 `json:"version"`
+}
+
+// GetFilesystem returns the filesystem for this user.
+// The filesystem is read only if the user is in read only mode
+func (u *User) GetFilesystem(connectionID string) (vfs.Fs, error) {
+	fs, err := u.getFilesystem(connectionID)
+	if err != nil || !u.Filters.ReadOnly {
+		return fs, err
+	}
+	return vfs.NewReadOnlyFs(fs), nil
 }
 
-// GetFilesystem returns the filesystem for this user
-func (u *User) GetFilesystem(connectionID string) (vfs.Fs, error) {
+func (u *User) getFilesystem(connectionID string) (vfs.Fs, error) {
 	if u.FsConfig.Provider == 1 {
 		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), u.FsConfig.S3Config)
 	} else if u.FsConfig.Provider == 2 {
 		config := u.FsConfig.GCSConfig
 		config.CredentialFile = u.getGCSCredentialsFilePath()
 		return vfs.NewGCSFs(connectionID, u.GetHomeDir(), config)
+	} else if u.FsConfig.Provider == 3 {
+		return vfs.NewCryptFs(connectionID, u.GetHomeDir(), u.FsConfig.CryptConfig)
+	} else if u.FsConfig.Provider == 4 {
+		return vfs.NewWebDAVFs(connectionID, u.GetHomeDir(), u.FsConfig.WebDAVConfig)
+	} else if u.FsConfig.Provider == 5 {
+		return vfs.NewHDFSFs(connectionID, u.GetHomeDir(), u.FsConfig.HDFSConfig)
+	} else if u.FsConfig.Provider == 6 {
+		return vfs.NewGoogleDriveFs(connectionID, u.GetHomeDir(), u.FsConfig.GoogleDriveConfig)
+	} else if u.FsConfig.Provider == 7 {
+		return vfs.NewDropboxFs(connectionID, u.GetHomeDir(), u.FsConfig.DropboxConfig)
 	}
-	return vfs.NewOsFs(connectionID, u.GetHomeDir(), u.VirtualFolders), nil
+	return vfs.NewFoldersFs(connectionID, u.GetHomeDir(), u.VirtualFolders)
 }
 
 // GetPermissionsForPath returns the permissions for the given path.
@@ -225,6 +335,17 @@ func (u *User) IsVirtualFolder(sftpPath string) bool {
 	return false
 }
 
+// GetSharedFolderForPath returns the name of the shared folder that contains the given
+// sftp path, an empty string is returned if the path is not inside a shared folder
+func (u *User) GetSharedFolderForPath(sftpPath string) string {
+	for _, v := range u.VirtualFolders {
+		if v.IsShared() && (sftpPath == v.VirtualPath || strings.HasPrefix(sftpPath, v.VirtualPath+"/")) {
+			return v.Name
+		}
+	}
+	return ""
+}
+
 // HasPerm returns true if the user has the given permission or any permission
 func (u *User) HasPerm(permission, path string) bool {
 	perms := u.GetPermissionsForPath(path)
@@ -266,6 +387,15 @@ func (u *User) IsLoginMethodAllowed(loginMethod string, partialSuccessMethods []
 	return true
 }
 
+// IsSSHCommandAllowed returns true if the user can execute the specified SSH command.
+// The command must be enabled in the SFTP server configuration too
+func (u *User) IsSSHCommandAllowed(command string) bool {
+	if len(u.Filters.AllowedSSHCommands) == 0 {
+		return true
+	}
+	return utils.IsStringInSlice(command, u.Filters.AllowedSSHCommands)
+}
+
 // GetNextAuthMethods returns the list of authentications methods that
 // can continue for multi-step authentication
 func (u *User) GetNextAuthMethods(partialSuccessMethods []string) []string {
@@ -287,6 +417,12 @@ func (u *User) GetNextAuthMethods(partialSuccessMethods []string) []string {
 	return methods
 }
 
+// HasPendingFirstLoginActions returns true if the user must complete some actions,
+// on the first login, before being allowed to login
+func (u *User) HasPendingFirstLoginActions() bool {
+	return u.Filters.FirstLogin.IsTermsAcceptancePending() || u.Filters.FirstLogin.IsPasswordChangePending()
+}
+
 // IsPartialAuth returns true if the specified login method is a step for
 // a multi-step Authentication.
 // We support publickey+password and publickey+keyboard-interactive, so
@@ -350,6 +486,21 @@ func (u *User) IsFileAllowed(sftpPath string) bool {
 	return true
 }
 
+// IsInIngestionFolder returns true if the given SFTP path is inside an ingestion folder
+func (u *User) IsInIngestionFolder(sftpPath string) bool {
+	if len(u.Filters.IngestionFolders) == 0 {
+		return false
+	}
+	for _, dir := range utils.GetDirsForSFTPPath(path.Dir(sftpPath)) {
+		for _, f := range u.Filters.IngestionFolders {
+			if f.Path == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // IsLoginFromAddrAllowed returns true if the login is allowed from the specified remoteAddr.
 // If AllowedIP is defined only the specified IP/Mask can login.
 // If DeniedIP is defined the specified IP/Mask cannot login.
@@ -506,10 +657,24 @@ func (u *User) GetInfoString() string {
 		t := utils.GetTimeFromMsecSinceEpoch(u.LastLogin)
 		result += fmt.Sprintf("Last login: %v ", t.Format("2006-01-02 15:04:05")) // YYYY-MM-DD HH:MM:SS
 	}
+	if u.UpdatedAt > 0 {
+		t := utils.GetTimeFromMsecSinceEpoch(u.UpdatedAt)
+		result += fmt.Sprintf("Updated: %v ", t.Format("2006-01-02 15:04:05"))
+	}
 	if u.FsConfig.Provider == 1 {
 		result += fmt.Sprintf("Storage: S3 ")
 	} else if u.FsConfig.Provider == 2 {
 		result += fmt.Sprintf("Storage: GCS ")
+	} else if u.FsConfig.Provider == 3 {
+		result += fmt.Sprintf("Storage: Encrypted ")
+	} else if u.FsConfig.Provider == 4 {
+		result += fmt.Sprintf("Storage: WebDAV ")
+	} else if u.FsConfig.Provider == 5 {
+		result += fmt.Sprintf("Storage: HDFS ")
+	} else if u.FsConfig.Provider == 6 {
+		result += fmt.Sprintf("Storage: Google Drive ")
+	} else if u.FsConfig.Provider == 7 {
+		result += fmt.Sprintf("Storage: Dropbox ")
 	}
 	if len(u.PublicKeys) > 0 {
 		result += fmt.Sprintf("Public keys: %v ", len(u.PublicKeys))
@@ -529,6 +694,9 @@ func (u *User) GetInfoString() string {
 	if len(u.Filters.AllowedIP) > 0 {
 		result += fmt.Sprintf("Allowed IP/Mask: %v ", len(u.Filters.AllowedIP))
 	}
+	if u.Filters.ReadOnly {
+		result += "Read only "
+	}
 	return result
 }
 
@@ -570,6 +738,12 @@ func (u *User) getACopy() User {
 	copy(pubKeys, u.PublicKeys)
 	virtualFolders := make([]vfs.VirtualFolder, len(u.VirtualFolders))
 	copy(virtualFolders, u.VirtualFolders)
+	for idx, v := range virtualFolders {
+		if v.Filesystem != nil {
+			folderFs := *v.Filesystem
+			virtualFolders[idx].Filesystem = &folderFs
+		}
+	}
 	permissions := make(map[string][]string)
 	for k, v := range u.Permissions {
 		perms := make([]string, len(v))
@@ -583,27 +757,86 @@ func (u *User) getACopy() User {
 	copy(filters.DeniedIP, u.Filters.DeniedIP)
 	filters.DeniedLoginMethods = make([]string, len(u.Filters.DeniedLoginMethods))
 	copy(filters.DeniedLoginMethods, u.Filters.DeniedLoginMethods)
+	filters.RevokedKeyFingerprints = make([]string, len(u.Filters.RevokedKeyFingerprints))
+	copy(filters.RevokedKeyFingerprints, u.Filters.RevokedKeyFingerprints)
+	filters.AllowedKeyAlgorithms = make([]string, len(u.Filters.AllowedKeyAlgorithms))
+	copy(filters.AllowedKeyAlgorithms, u.Filters.AllowedKeyAlgorithms)
+	filters.MinRSAKeySize = u.Filters.MinRSAKeySize
+	filters.AllowedSSHCommands = make([]string, len(u.Filters.AllowedSSHCommands))
+	copy(filters.AllowedSSHCommands, u.Filters.AllowedSSHCommands)
 	filters.FileExtensions = make([]ExtensionsFilter, len(u.Filters.FileExtensions))
 	copy(filters.FileExtensions, u.Filters.FileExtensions)
+	filters.IngestionFolders = make([]IngestionFolder, len(u.Filters.IngestionFolders))
+	copy(filters.IngestionFolders, u.Filters.IngestionFolders)
+	filters.Tenant = u.Filters.Tenant
+	filters.ReadOnly = u.Filters.ReadOnly
+	filters.FirstLogin = u.Filters.FirstLogin
 	fsConfig := Filesystem{
 		Provider: u.FsConfig.Provider,
 		S3Config: vfs.S3FsConfig{
-			Bucket:            u.FsConfig.S3Config.Bucket,
-			Region:            u.FsConfig.S3Config.Region,
-			AccessKey:         u.FsConfig.S3Config.AccessKey,
-			AccessSecret:      u.FsConfig.S3Config.AccessSecret,
-			Endpoint:          u.FsConfig.S3Config.Endpoint,
-			StorageClass:      u.FsConfig.S3Config.StorageClass,
-			KeyPrefix:         u.FsConfig.S3Config.KeyPrefix,
-			UploadPartSize:    u.FsConfig.S3Config.UploadPartSize,
-			UploadConcurrency: u.FsConfig.S3Config.UploadConcurrency,
+			Bucket:              u.FsConfig.S3Config.Bucket,
+			Region:              u.FsConfig.S3Config.Region,
+			AccessKey:           u.FsConfig.S3Config.AccessKey,
+			AccessSecret:        u.FsConfig.S3Config.AccessSecret,
+			SessionToken:        u.FsConfig.S3Config.SessionToken,
+			RoleARN:             u.FsConfig.S3Config.RoleARN,
+			ExternalID:          u.FsConfig.S3Config.ExternalID,
+			Endpoint:            u.FsConfig.S3Config.Endpoint,
+			StorageClass:        u.FsConfig.S3Config.StorageClass,
+			KeyPrefix:           u.FsConfig.S3Config.KeyPrefix,
+			UploadPartSize:      u.FsConfig.S3Config.UploadPartSize,
+			UploadConcurrency:   u.FsConfig.S3Config.UploadConcurrency,
+			StorageClassRules:   vfs.CopyStorageClassRules(u.FsConfig.S3Config.StorageClassRules),
+			ForcePathStyle:      u.FsConfig.S3Config.ForcePathStyle,
+			SkipTLSVerify:       u.FsConfig.S3Config.SkipTLSVerify,
+			CABundle:            u.FsConfig.S3Config.CABundle,
+			DownloadPartSize:    u.FsConfig.S3Config.DownloadPartSize,
+			DownloadConcurrency: u.FsConfig.S3Config.DownloadConcurrency,
 		},
 		GCSConfig: vfs.GCSFsConfig{
 			Bucket:               u.FsConfig.GCSConfig.Bucket,
 			CredentialFile:       u.FsConfig.GCSConfig.CredentialFile,
+			Credentials:          u.FsConfig.GCSConfig.Credentials,
 			AutomaticCredentials: u.FsConfig.GCSConfig.AutomaticCredentials,
 			StorageClass:         u.FsConfig.GCSConfig.StorageClass,
 			KeyPrefix:            u.FsConfig.GCSConfig.KeyPrefix,
+			StorageClassRules:    vfs.CopyStorageClassRules(u.FsConfig.GCSConfig.StorageClassRules),
+			DownloadPartSize:     u.FsConfig.GCSConfig.DownloadPartSize,
+			DownloadConcurrency:  u.FsConfig.GCSConfig.DownloadConcurrency,
+		},
+		CryptConfig: vfs.CryptFsConfig{
+			Passphrase: u.FsConfig.CryptConfig.Passphrase,
+		},
+		WebDAVConfig: vfs.WebDAVFsConfig{
+			Endpoint:    u.FsConfig.WebDAVConfig.Endpoint,
+			Username:    u.FsConfig.WebDAVConfig.Username,
+			Password:    u.FsConfig.WebDAVConfig.Password,
+			BearerToken: u.FsConfig.WebDAVConfig.BearerToken,
+			RootPath:    u.FsConfig.WebDAVConfig.RootPath,
+		},
+		HDFSConfig: vfs.HDFSFsConfig{
+			Endpoint:        u.FsConfig.HDFSConfig.Endpoint,
+			Username:        u.FsConfig.HDFSConfig.Username,
+			DelegationToken: u.FsConfig.HDFSConfig.DelegationToken,
+			RootPath:        u.FsConfig.HDFSConfig.RootPath,
+		},
+		GoogleDriveConfig: vfs.GoogleDriveFsConfig{
+			FolderID:     u.FsConfig.GoogleDriveConfig.FolderID,
+			Credentials:  u.FsConfig.GoogleDriveConfig.Credentials,
+			Subject:      u.FsConfig.GoogleDriveConfig.Subject,
+			ClientID:     u.FsConfig.GoogleDriveConfig.ClientID,
+			ClientSecret: u.FsConfig.GoogleDriveConfig.ClientSecret,
+			RefreshToken: u.FsConfig.GoogleDriveConfig.RefreshToken,
+			Endpoint:     u.FsConfig.GoogleDriveConfig.Endpoint,
+		},
+		DropboxConfig: vfs.DropboxFsConfig{
+			RootPath:        u.FsConfig.DropboxConfig.RootPath,
+			AccessToken:     u.FsConfig.DropboxConfig.AccessToken,
+			RefreshToken:    u.FsConfig.DropboxConfig.RefreshToken,
+			AppKey:          u.FsConfig.DropboxConfig.AppKey,
+			AppSecret:       u.FsConfig.DropboxConfig.AppSecret,
+			UploadChunkSize: u.FsConfig.DropboxConfig.UploadChunkSize,
+			Endpoint:        u.FsConfig.DropboxConfig.Endpoint,
 		},
 	}
 
@@ -630,6 +863,11 @@ func (u *User) getACopy() User {
 		LastLogin:         u.LastLogin,
 		Filters:           filters,
 		FsConfig:          fsConfig,
+		Plan:              u.Plan,
+		AdditionalInfo:    u.AdditionalInfo,
+		CreatedAt:         u.CreatedAt,
+		UpdatedAt:         u.UpdatedAt,
+		Version:           u.Version,
 	}
 }
 
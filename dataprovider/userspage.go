@@ -0,0 +1,352 @@
+package dataprovider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// UsersOrderFields defines the fields that can be used to order the users
+var UsersOrderFields = []string{"username", "id", "last_login", "expiration_date", "used_quota_size", "created_at"}
+
+// UsersPageRequest defines the users page to return.
+// A page can be selected using an offset or a cursor returned for a previous page,
+// not both. A cursor is valid only for the ordering used to generate it
+type UsersPageRequest struct {
+	Limit int
+	// Number of users to skip, ignored if a cursor is set
+	Offset int
+	// ASC or DESC
+	Order string
+	// One of UsersOrderFields, default "username"
+	OrderBy string
+	// Filter by username, exact match
+	Username string
+	Cursor   string
+	Filter   UsersFilter
+}
+
+// UsersFilter defines the optional filters for the users list, a user must match all the set filters.
+// The SQL providers apply the filters inside the database queries
+type UsersFilter struct {
+	// 0 disabled, 1 enabled
+	Status *int
+	// Only the users with an expiration date in the given window are matched, as unix timestamp
+	// in milliseconds. 0 means no limit
+	ExpiresAfter  int64
+	ExpiresBefore int64
+	// Filesystem provider, see Filesystem
+	FsProvider *int
+	// Minimum used quota size as bytes
+	MinUsedQuotaSize int64
+	// Minimum used quota size as percentage of the quota size, the users without a quota size limit are
+	// not matched
+	MinQuotaUsagePercent int
+	// Only the users that never logged in or without a login for the given number of days are matched
+	LastLoginOlderThanDays int
+}
+
+// IsEmpty returns true if no filter is set
+func (f *UsersFilter) IsEmpty() bool {
+	return f.Status == nil && f.ExpiresAfter == 0 && f.ExpiresBefore == 0 && f.FsProvider == nil &&
+		f.MinUsedQuotaSize == 0 && f.MinQuotaUsagePercent == 0 && f.LastLoginOlderThanDays == 0
+}
+
+func (f *UsersFilter) validate() error {
+	if f.Status != nil && *f.Status != 0 && *f.Status != 1 {
+		return &ValidationError{err: fmt.Sprintf("invalid status filter %v, supported values: 0 disabled, 1 enabled",
+			*f.Status)}
+	}
+	if f.ExpiresAfter < 0 || f.ExpiresBefore < 0 || f.MinUsedQuotaSize < 0 || f.MinQuotaUsagePercent < 0 ||
+		f.LastLoginOlderThanDays < 0 {
+		return &ValidationError{err: "the users filters cannot be negative"}
+	}
+	if f.ExpiresBefore > 0 && f.ExpiresAfter > f.ExpiresBefore {
+		return &ValidationError{err: "the expiration window start must be before its end"}
+	}
+	if f.FsProvider != nil && !isFilesystemProviderSupported(*f.FsProvider) {
+		return &ValidationError{err: fmt.Sprintf("invalid filesystem provider filter %v", *f.FsProvider)}
+	}
+	return nil
+}
+
+func (f *UsersFilter) getLastLoginThreshold(now int64) int64 {
+	return now - int64(f.LastLoginOlderThanDays)*msPerDay
+}
+
+// matches returns true if the given user matches all the filters
+func (f *UsersFilter) matches(user *User, now int64) bool {
+	if f.Status != nil && user.Status != *f.Status {
+		return false
+	}
+	if f.ExpiresAfter > 0 || f.ExpiresBefore > 0 {
+		if user.ExpirationDate <= 0 || user.ExpirationDate < f.ExpiresAfter {
+			return false
+		}
+		if f.ExpiresBefore > 0 && user.ExpirationDate > f.ExpiresBefore {
+			return false
+		}
+	}
+	if f.FsProvider != nil && user.FsConfig.Provider != *f.FsProvider {
+		return false
+	}
+	if user.UsedQuotaSize < f.MinUsedQuotaSize {
+		return false
+	}
+	if f.MinQuotaUsagePercent > 0 {
+		if user.QuotaSize <= 0 || user.UsedQuotaSize*100 < user.QuotaSize*int64(f.MinQuotaUsagePercent) {
+			return false
+		}
+	}
+	if f.LastLoginOlderThanDays > 0 && user.LastLogin >= f.getLastLoginThreshold(now) {
+		return false
+	}
+	return true
+}
+
+// UsersPage is a page of users with the pagination metadata
+type UsersPage struct {
+	Users []User
+	// Total number of users matching the request filters
+	Total int
+	// Cursor for the following page, empty if this is the last page
+	NextCursor string
+	// Cursor for the previous page, empty if this is the first page
+	PrevCursor string
+}
+
+// usersCursor identifies the position of a user inside the ordered users list.
+// A backward cursor selects the users before this position
+type usersCursor struct {
+	OrderBy  string `json:"f"`
+	Order    string `json:"o"`
+	Backward bool   `json:"b,omitempty"`
+	Value    int64  `json:"v,omitempty"`
+	Username string `json:"u,omitempty"`
+	ID       int64  `json:"id"`
+}
+
+func newUsersCursor(user *User, orderBy, order string, backward bool) usersCursor {
+	cursor := usersCursor{
+		OrderBy:  orderBy,
+		Order:    order,
+		Backward: backward,
+		ID:       user.ID,
+	}
+	if orderBy == "username" {
+		cursor.Username = user.Username
+	} else {
+		cursor.Value = getUserOrderValue(user, orderBy)
+	}
+	return cursor
+}
+
+func (c *usersCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// compare returns a negative number if the given user comes before the cursor position,
+// zero if it is the cursor user and a positive number if it comes after
+func (c *usersCursor) compare(user *User) int {
+	result := 0
+	if c.OrderBy == "username" {
+		if user.Username < c.Username {
+			result = -1
+		} else if user.Username > c.Username {
+			result = 1
+		}
+	} else {
+		result = compareInt64(getUserOrderValue(user, c.OrderBy), c.Value)
+	}
+	if result == 0 {
+		result = compareInt64(user.ID, c.ID)
+	}
+	if c.Order == "DESC" {
+		return -result
+	}
+	return result
+}
+
+// sqlArgs returns the arguments for the cursor condition inside the SQL queries
+func (c *usersCursor) sqlArgs() []interface{} {
+	var value interface{} = c.Value
+	if c.OrderBy == "username" {
+		value = c.Username
+	}
+	return []interface{}{value, value, c.ID}
+}
+
+// sqlOperator returns the comparison operator selecting the users after, or before for a backward
+// cursor, the cursor position
+func (c *usersCursor) sqlOperator() string {
+	if (c.Order == "ASC") != c.Backward {
+		return ">"
+	}
+	return "<"
+}
+
+func decodeUsersCursor(value string, req *UsersPageRequest) (usersCursor, error) {
+	var cursor usersCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return cursor, &ValidationError{err: "invalid cursor"}
+	}
+	if cursor.OrderBy != req.OrderBy || cursor.Order != req.Order {
+		return cursor, &ValidationError{err: "the cursor does not match the requested ordering"}
+	}
+	return cursor, nil
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func getUserOrderValue(user *User, orderBy string) int64 {
+	switch orderBy {
+	case "id":
+		return user.ID
+	case "last_login":
+		return user.LastLogin
+	case "expiration_date":
+		return user.ExpirationDate
+	case "used_quota_size":
+		return user.UsedQuotaSize
+	case "created_at":
+		return user.CreatedAt
+	default:
+		return 0
+	}
+}
+
+func validateUsersPageRequest(req *UsersPageRequest) error {
+	if len(req.Order) == 0 {
+		req.Order = "ASC"
+	}
+	if len(req.OrderBy) == 0 {
+		req.OrderBy = "username"
+	}
+	if req.Order != "ASC" && req.Order != "DESC" {
+		return &ValidationError{err: fmt.Sprintf("invalid order %#v", req.Order)}
+	}
+	if !utils.IsStringInSlice(req.OrderBy, UsersOrderFields) {
+		return &ValidationError{err: fmt.Sprintf("invalid order field %#v, supported fields: %v", req.OrderBy,
+			UsersOrderFields)}
+	}
+	if req.Limit < 0 || req.Offset < 0 {
+		return &ValidationError{err: "limit and offset cannot be negative"}
+	}
+	if len(req.Cursor) > 0 && req.Offset > 0 {
+		return &ValidationError{err: "offset and cursor cannot be used together"}
+	}
+	return req.Filter.validate()
+}
+
+// GetUsersPage returns a page of users, included in the given scope, with the total number of matching
+// users and the cursors for the adjacent pages.
+// The SQL providers count, order and select the requested page inside the database, the other providers
+// and the requests restricted to a scope need to load all the matching users
+func GetUsersPage(p Provider, scope UserScope, req UsersPageRequest) (UsersPage, error) {
+	if err := validateUsersPageRequest(&req); err != nil {
+		return UsersPage{Users: []User{}}, err
+	}
+	var cursor *usersCursor
+	if len(req.Cursor) > 0 {
+		c, err := decodeUsersCursor(req.Cursor, &req)
+		if err != nil {
+			return UsersPage{Users: []User{}}, err
+		}
+		cursor = &c
+	}
+	if scope.IsEmpty() {
+		return p.getUsersPage(req, cursor)
+	}
+	return getUsersPageFromList(p, scope, req, cursor)
+}
+
+func getUsersPageFromList(p Provider, scope UserScope, req UsersPageRequest, cursor *usersCursor) (UsersPage, error) {
+	page := UsersPage{Users: []User{}}
+	var users []User
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	offset := 0
+	for {
+		batch, err := GetUsers(p, scopeQueryLimit, offset, "ASC", req.Username)
+		if err != nil {
+			return page, err
+		}
+		for _, user := range batch {
+			if scope.IsUserInScope(&user) && req.Filter.matches(&user, now) {
+				users = append(users, user)
+			}
+		}
+		if len(batch) < scopeQueryLimit {
+			break
+		}
+		offset += len(batch)
+	}
+	orderCursor := usersCursor{OrderBy: req.OrderBy, Order: req.Order}
+	sort.Slice(users, func(i, j int) bool {
+		orderCursor.Username = users[j].Username
+		orderCursor.Value = getUserOrderValue(&users[j], req.OrderBy)
+		orderCursor.ID = users[j].ID
+		return orderCursor.compare(&users[i]) < 0
+	})
+	page.Total = len(users)
+	// the page includes the users in the range [start, end)
+	start := req.Offset
+	if start > len(users) {
+		start = len(users)
+	}
+	end := start + req.Limit
+	if cursor != nil {
+		if cursor.Backward {
+			// index of the cursor position, the cursor user is excluded
+			end = sort.Search(len(users), func(i int) bool {
+				return cursor.compare(&users[i]) >= 0
+			})
+			start = end - req.Limit
+			if start < 0 {
+				start = 0
+			}
+		} else {
+			// index of the first user after the cursor position
+			start = sort.Search(len(users), func(i int) bool {
+				return cursor.compare(&users[i]) > 0
+			})
+			end = start + req.Limit
+		}
+	}
+	if end > len(users) {
+		end = len(users)
+	}
+	page.Users = append(page.Users, users[start:end]...)
+	setUsersPageCursors(&page, req, start > 0, end < len(users))
+	return page, nil
+}
+
+func setUsersPageCursors(page *UsersPage, req UsersPageRequest, hasPrev, hasNext bool) {
+	if len(page.Users) == 0 {
+		return
+	}
+	if hasPrev {
+		cursor := newUsersCursor(&page.Users[0], req.OrderBy, req.Order, true)
+		page.PrevCursor = cursor.encode()
+	}
+	if hasNext {
+		cursor := newUsersCursor(&page.Users[len(page.Users)-1], req.OrderBy, req.Order, false)
+		page.NextCursor = cursor.encode()
+	}
+}
@@ -0,0 +1,201 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported admin events
+const (
+	// an admin authenticated, for the REST API, the web admin or the gRPC API
+	AdminEventLogin = "login"
+	// an authentication attempt with invalid admin credentials
+	AdminEventLoginFailed = "login_failed"
+	// the configuration was reloaded, for example after a SIGHUP signal
+	AdminEventConfigReloaded = "config_reloaded"
+)
+
+// the pending admin events are dropped if the webhooks are slower than this limit
+const adminEventsQueueSize = 1000
+
+var (
+	adminEventTypes  = []string{AdminEventLogin, AdminEventLoginFailed, AdminEventConfigReloaded}
+	adminEventsConf  AdminEventsConfig
+	adminEventsQueue chan adminEventNotification
+	adminLoginsMutex sync.Mutex
+	// last notified login for each admin and IP address
+	adminLogins map[string]time.Time
+)
+
+// AdminEventsConfig defines the outbound webhooks for the admin events. The admin events
+// are notified using their own queue, so they are not delayed by the filesystem actions
+type AdminEventsConfig struct {
+	// Webhooks to notify
+	Webhooks []AdminWebhook `json:"webhooks" mapstructure:"webhooks"`
+	// The basic auth credentials are sent with each request, so the logins for the same admin
+	// and IP address are notified once in this interval, as minutes. 0 means each request
+	LoginNotifyInterval int `json:"login_notify_interval" mapstructure:"login_notify_interval"`
+}
+
+// AdminWebhook defines an HTTP URL to notify, using a POST request, for the admin events
+type AdminWebhook struct {
+	URL string `json:"url" mapstructure:"url"`
+	// Valid values are login, login_failed, config_reloaded. Empty means all the events
+	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
+	// Additional HTTP headers, as "name: value", for example an authorization header
+	Headers []string `json:"headers" mapstructure:"headers"`
+}
+
+// AdminEvent defines the JSON body sent to the webhooks
+type AdminEvent struct {
+	Event string `json:"event"`
+	// admin username, empty for the config_reloaded event
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	// HTTP or gRPC
+	Protocol string `json:"protocol,omitempty"`
+	// event time as unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// adminEventNotification is a queued admin event with the webhooks to notify
+type adminEventNotification struct {
+	event    AdminEvent
+	webhooks []AdminWebhook
+}
+
+func (c *AdminEventsConfig) validate() error {
+	for _, webhook := range c.Webhooks {
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("admin events: invalid webhook url %#v", webhook.URL)
+		}
+		for _, event := range webhook.ExecuteOn {
+			if !utils.IsStringInSlice(event, adminEventTypes) {
+				return fmt.Errorf("admin events: invalid event %#v for webhook %#v", event, webhook.URL)
+			}
+		}
+		for _, header := range webhook.Headers {
+			if !strings.Contains(header, ":") {
+				return fmt.Errorf("admin events: invalid header %#v for webhook %#v, the format is \"name: value\"",
+					header, webhook.URL)
+			}
+		}
+	}
+	if c.LoginNotifyInterval < 0 {
+		return fmt.Errorf("admin events: invalid login_notify_interval %v", c.LoginNotifyInterval)
+	}
+	return nil
+}
+
+func (w *AdminWebhook) isEnabledFor(event string) bool {
+	return len(w.ExecuteOn) == 0 || utils.IsStringInSlice(event, w.ExecuteOn)
+}
+
+// startAdminEventsNotifier starts the goroutine that sends the queued admin events to the webhooks
+func startAdminEventsNotifier(c AdminEventsConfig) {
+	adminLoginsMutex.Lock()
+	adminLogins = make(map[string]time.Time)
+	adminLoginsMutex.Unlock()
+	adminEventsConf = c
+	if len(c.Webhooks) == 0 || adminEventsQueue != nil {
+		return
+	}
+	adminEventsQueue = make(chan adminEventNotification, adminEventsQueueSize)
+	go func(queue chan adminEventNotification) {
+		for notification := range queue {
+			sendAdminEvent(notification.event, notification.webhooks)
+		}
+	}(adminEventsQueue)
+}
+
+// NotifyAdminEvent queues the given admin event for the configured webhooks, it does not block.
+// The event timestamp is set if missing
+func NotifyAdminEvent(event AdminEvent) {
+	if adminEventsQueue == nil || len(adminEventsConf.Webhooks) == 0 {
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = utils.GetTimeAsMsSinceEpoch(time.Now())
+	}
+	select {
+	case adminEventsQueue <- adminEventNotification{event: event, webhooks: adminEventsConf.Webhooks}:
+	default:
+		logger.Warn(logSender, "", "admin events queue is full, event %#v for admin %#v dropped", event.Event,
+			event.Username)
+	}
+}
+
+// notifyAdminLogin notifies a successful admin authentication, unless the same admin already
+// authenticated from the same IP address inside the configured interval
+func notifyAdminLogin(username, ip, protocol string) {
+	if len(adminEventsConf.Webhooks) == 0 {
+		return
+	}
+	if adminEventsConf.LoginNotifyInterval > 0 {
+		key := fmt.Sprintf("%v|%v|%v", username, ip, protocol)
+		now := time.Now()
+		adminLoginsMutex.Lock()
+		last, ok := adminLogins[key]
+		if ok && now.Sub(last) < time.Duration(adminEventsConf.LoginNotifyInterval)*time.Minute {
+			adminLoginsMutex.Unlock()
+			return
+		}
+		adminLogins[key] = now
+		// the expired logins are removed, so the map does not grow forever
+		for k, t := range adminLogins {
+			if now.Sub(t) >= time.Duration(adminEventsConf.LoginNotifyInterval)*time.Minute {
+				delete(adminLogins, k)
+			}
+		}
+		adminLoginsMutex.Unlock()
+	}
+	NotifyAdminEvent(AdminEvent{
+		Event:    AdminEventLogin,
+		Username: username,
+		IP:       ip,
+		Protocol: protocol,
+	})
+}
+
+func sendAdminEvent(event AdminEvent, webhooks []AdminWebhook) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to serialize admin event %#v: %v", event.Event, err)
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhook.isEnabledFor(event.Event) {
+			continue
+		}
+		startTime := time.Now()
+		req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBuffer(body))
+		if err != nil {
+			logger.Warn(logSender, "", "unable to notify admin event %#v to URL %v: %v", event.Event, webhook.URL, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for _, header := range webhook.Headers {
+			kv := strings.SplitN(header, ":", 2)
+			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		respCode := 0
+		if err == nil {
+			respCode = resp.StatusCode
+			resp.Body.Close()
+		}
+		logger.Debug(logSender, "", "notified admin event %#v to URL: %v status code: %v, elapsed: %v err: %v",
+			event.Event, webhook.URL, respCode, time.Since(startTime), err)
+	}
+}
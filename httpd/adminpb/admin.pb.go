@@ -0,0 +1,3186 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: httpd/adminpb/admin.proto
+
+package adminpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// DisconnectMode defines if the active connections for a user must be
+// closed after an update or a delete
+type DisconnectMode int32
+
+const (
+	// use the disconnect_on_user_change setting from the SFTP server configuration
+	DisconnectMode_DISCONNECT_DEFAULT DisconnectMode = 0
+	DisconnectMode_DISCONNECT_YES     DisconnectMode = 1
+	DisconnectMode_DISCONNECT_NO      DisconnectMode = 2
+)
+
+var DisconnectMode_name = map[int32]string{
+	0: "DISCONNECT_DEFAULT",
+	1: "DISCONNECT_YES",
+	2: "DISCONNECT_NO",
+}
+
+var DisconnectMode_value = map[string]int32{
+	"DISCONNECT_DEFAULT": 0,
+	"DISCONNECT_YES":     1,
+	"DISCONNECT_NO":      2,
+}
+
+func (x DisconnectMode) String() string {
+	return proto.EnumName(DisconnectMode_name, int32(x))
+}
+
+func (DisconnectMode) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{0}
+}
+
+type ApiResponse struct {
+	Message              string   `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ApiResponse) Reset()         { *m = ApiResponse{} }
+func (m *ApiResponse) String() string { return proto.CompactTextString(m) }
+func (*ApiResponse) ProtoMessage()    {}
+func (*ApiResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{0}
+}
+
+func (m *ApiResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ApiResponse.Unmarshal(m, b)
+}
+func (m *ApiResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ApiResponse.Marshal(b, m, deterministic)
+}
+func (m *ApiResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ApiResponse.Merge(m, src)
+}
+func (m *ApiResponse) XXX_Size() int {
+	return xxx_messageInfo_ApiResponse.Size(m)
+}
+func (m *ApiResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ApiResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ApiResponse proto.InternalMessageInfo
+
+func (m *ApiResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type Permissions struct {
+	// supported values: *, list, download, upload, overwrite, delete, rename,
+	// create_dirs, create_symlinks, chmod, chown, chtimes
+	Permissions          []string `protobuf:"bytes,1,rep,name=permissions,proto3" json:"permissions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Permissions) Reset()         { *m = Permissions{} }
+func (m *Permissions) String() string { return proto.CompactTextString(m) }
+func (*Permissions) ProtoMessage()    {}
+func (*Permissions) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{1}
+}
+
+func (m *Permissions) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Permissions.Unmarshal(m, b)
+}
+func (m *Permissions) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Permissions.Marshal(b, m, deterministic)
+}
+func (m *Permissions) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Permissions.Merge(m, src)
+}
+func (m *Permissions) XXX_Size() int {
+	return xxx_messageInfo_Permissions.Size(m)
+}
+func (m *Permissions) XXX_DiscardUnknown() {
+	xxx_messageInfo_Permissions.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Permissions proto.InternalMessageInfo
+
+func (m *Permissions) GetPermissions() []string {
+	if m != nil {
+		return m.Permissions
+	}
+	return nil
+}
+
+type VirtualFolder struct {
+	VirtualPath string `protobuf:"bytes,1,opt,name=virtual_path,json=virtualPath,proto3" json:"virtual_path,omitempty"`
+	// not set if the virtual folder has its own filesystem backend
+	MappedPath string `protobuf:"bytes,2,opt,name=mapped_path,json=mappedPath,proto3" json:"mapped_path,omitempty"`
+	// optional filesystem backend for the virtual folder, only S3 and GCS are supported
+	Filesystem *Filesystem `protobuf:"bytes,3,opt,name=filesystem,proto3" json:"filesystem,omitempty"`
+	// optional shared folder name, the mapped path is the shared folder one
+	Name                 string   `protobuf:"bytes,4,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *VirtualFolder) Reset()         { *m = VirtualFolder{} }
+func (m *VirtualFolder) String() string { return proto.CompactTextString(m) }
+func (*VirtualFolder) ProtoMessage()    {}
+func (*VirtualFolder) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{2}
+}
+
+func (m *VirtualFolder) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_VirtualFolder.Unmarshal(m, b)
+}
+func (m *VirtualFolder) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_VirtualFolder.Marshal(b, m, deterministic)
+}
+func (m *VirtualFolder) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_VirtualFolder.Merge(m, src)
+}
+func (m *VirtualFolder) XXX_Size() int {
+	return xxx_messageInfo_VirtualFolder.Size(m)
+}
+func (m *VirtualFolder) XXX_DiscardUnknown() {
+	xxx_messageInfo_VirtualFolder.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_VirtualFolder proto.InternalMessageInfo
+
+func (m *VirtualFolder) GetVirtualPath() string {
+	if m != nil {
+		return m.VirtualPath
+	}
+	return ""
+}
+
+func (m *VirtualFolder) GetMappedPath() string {
+	if m != nil {
+		return m.MappedPath
+	}
+	return ""
+}
+
+func (m *VirtualFolder) GetFilesystem() *Filesystem {
+	if m != nil {
+		return m.Filesystem
+	}
+	return nil
+}
+
+func (m *VirtualFolder) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type ExtensionsFilter struct {
+	// SFTP/SCP path, if no other specific filter is defined, the filter apply for sub directories too
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// list of, case insensitive, allowed files extension
+	AllowedExtensions []string `protobuf:"bytes,2,rep,name=allowed_extensions,json=allowedExtensions,proto3" json:"allowed_extensions,omitempty"`
+	// list of, case insensitive, denied files extension
+	DeniedExtensions     []string `protobuf:"bytes,3,rep,name=denied_extensions,json=deniedExtensions,proto3" json:"denied_extensions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExtensionsFilter) Reset()         { *m = ExtensionsFilter{} }
+func (m *ExtensionsFilter) String() string { return proto.CompactTextString(m) }
+func (*ExtensionsFilter) ProtoMessage()    {}
+func (*ExtensionsFilter) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{3}
+}
+
+func (m *ExtensionsFilter) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExtensionsFilter.Unmarshal(m, b)
+}
+func (m *ExtensionsFilter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExtensionsFilter.Marshal(b, m, deterministic)
+}
+func (m *ExtensionsFilter) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExtensionsFilter.Merge(m, src)
+}
+func (m *ExtensionsFilter) XXX_Size() int {
+	return xxx_messageInfo_ExtensionsFilter.Size(m)
+}
+func (m *ExtensionsFilter) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExtensionsFilter.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExtensionsFilter proto.InternalMessageInfo
+
+func (m *ExtensionsFilter) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *ExtensionsFilter) GetAllowedExtensions() []string {
+	if m != nil {
+		return m.AllowedExtensions
+	}
+	return nil
+}
+
+func (m *ExtensionsFilter) GetDeniedExtensions() []string {
+	if m != nil {
+		return m.DeniedExtensions
+	}
+	return nil
+}
+
+type UserFilters struct {
+	// only clients connecting from these IP/Mask are allowed, for example "192.168.1.0/24"
+	AllowedIp []string `protobuf:"bytes,1,rep,name=allowed_ip,json=allowedIp,proto3" json:"allowed_ip,omitempty"`
+	// clients connecting from these IP/Mask are not allowed, for example "10.0.0.0/8"
+	DeniedIp []string `protobuf:"bytes,2,rep,name=denied_ip,json=deniedIp,proto3" json:"denied_ip,omitempty"`
+	// supported values: publickey, password, keyboard-interactive
+	DeniedLoginMethods []string            `protobuf:"bytes,3,rep,name=denied_login_methods,json=deniedLoginMethods,proto3" json:"denied_login_methods,omitempty"`
+	FileExtensions     []*ExtensionsFilter `protobuf:"bytes,4,rep,name=file_extensions,json=fileExtensions,proto3" json:"file_extensions,omitempty"`
+	// SHA256 fingerprints of revoked public keys, for example "SHA256:jZ3j1ZFl7Xmc/rFzvoFQfqDCzYVatZ0WjvSaN7ZT4xo"
+	RevokedKeyFingerprints []string `protobuf:"bytes,5,rep,name=revoked_key_fingerprints,json=revokedKeyFingerprints,proto3" json:"revoked_key_fingerprints,omitempty"`
+	// only public keys using these algorithms are allowed, for example "ssh-ed25519".
+	// If empty any supported algorithm is allowed
+	AllowedKeyAlgorithms []string `protobuf:"bytes,6,rep,name=allowed_key_algorithms,json=allowedKeyAlgorithms,proto3" json:"allowed_key_algorithms,omitempty"`
+	// minimum size, in bits, for RSA public keys. 0 means no restrictions
+	MinRsaKeySize int32 `protobuf:"varint,7,opt,name=min_rsa_key_size,json=minRsaKeySize,proto3" json:"min_rsa_key_size,omitempty"`
+	// directories optimized for the ingestion of many small uploads and appends
+	IngestionFolders []*IngestionFolder `protobuf:"bytes,8,rep,name=ingestion_folders,json=ingestionFolders,proto3" json:"ingestion_folders,omitempty"`
+	// optional tenant name, the active connections for this user are tagged with it
+	Tenant string `protobuf:"bytes,9,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// if true the whole account is read only regardless of the granted permissions
+	ReadOnly bool `protobuf:"varint,10,opt,name=read_only,json=readOnly,proto3" json:"read_only,omitempty"`
+	// only these SSH commands, for example "scp", can be executed. The commands must be enabled
+	// in the SFTP server configuration too. If empty any enabled command is allowed
+	AllowedSshCommands   []string `protobuf:"bytes,11,rep,name=allowed_ssh_commands,json=allowedSshCommands,proto3" json:"allowed_ssh_commands,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UserFilters) Reset()         { *m = UserFilters{} }
+func (m *UserFilters) String() string { return proto.CompactTextString(m) }
+func (*UserFilters) ProtoMessage()    {}
+func (*UserFilters) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{4}
+}
+
+func (m *UserFilters) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UserFilters.Unmarshal(m, b)
+}
+func (m *UserFilters) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UserFilters.Marshal(b, m, deterministic)
+}
+func (m *UserFilters) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UserFilters.Merge(m, src)
+}
+func (m *UserFilters) XXX_Size() int {
+	return xxx_messageInfo_UserFilters.Size(m)
+}
+func (m *UserFilters) XXX_DiscardUnknown() {
+	xxx_messageInfo_UserFilters.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UserFilters proto.InternalMessageInfo
+
+func (m *UserFilters) GetAllowedIp() []string {
+	if m != nil {
+		return m.AllowedIp
+	}
+	return nil
+}
+
+func (m *UserFilters) GetDeniedIp() []string {
+	if m != nil {
+		return m.DeniedIp
+	}
+	return nil
+}
+
+func (m *UserFilters) GetDeniedLoginMethods() []string {
+	if m != nil {
+		return m.DeniedLoginMethods
+	}
+	return nil
+}
+
+func (m *UserFilters) GetFileExtensions() []*ExtensionsFilter {
+	if m != nil {
+		return m.FileExtensions
+	}
+	return nil
+}
+
+func (m *UserFilters) GetRevokedKeyFingerprints() []string {
+	if m != nil {
+		return m.RevokedKeyFingerprints
+	}
+	return nil
+}
+
+func (m *UserFilters) GetAllowedKeyAlgorithms() []string {
+	if m != nil {
+		return m.AllowedKeyAlgorithms
+	}
+	return nil
+}
+
+func (m *UserFilters) GetMinRsaKeySize() int32 {
+	if m != nil {
+		return m.MinRsaKeySize
+	}
+	return 0
+}
+
+func (m *UserFilters) GetIngestionFolders() []*IngestionFolder {
+	if m != nil {
+		return m.IngestionFolders
+	}
+	return nil
+}
+
+func (m *UserFilters) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *UserFilters) GetReadOnly() bool {
+	if m != nil {
+		return m.ReadOnly
+	}
+	return false
+}
+
+func (m *UserFilters) GetAllowedSshCommands() []string {
+	if m != nil {
+		return m.AllowedSshCommands
+	}
+	return nil
+}
+
+type IngestionFolder struct {
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// roll up, each hour, the files uploaded before the current hour into a compressed tar archive
+	HourlyRollup         bool     `protobuf:"varint,2,opt,name=hourly_rollup,json=hourlyRollup,proto3" json:"hourly_rollup,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IngestionFolder) Reset()         { *m = IngestionFolder{} }
+func (m *IngestionFolder) String() string { return proto.CompactTextString(m) }
+func (*IngestionFolder) ProtoMessage()    {}
+func (*IngestionFolder) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{5}
+}
+
+func (m *IngestionFolder) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IngestionFolder.Unmarshal(m, b)
+}
+func (m *IngestionFolder) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IngestionFolder.Marshal(b, m, deterministic)
+}
+func (m *IngestionFolder) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IngestionFolder.Merge(m, src)
+}
+func (m *IngestionFolder) XXX_Size() int {
+	return xxx_messageInfo_IngestionFolder.Size(m)
+}
+func (m *IngestionFolder) XXX_DiscardUnknown() {
+	xxx_messageInfo_IngestionFolder.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IngestionFolder proto.InternalMessageInfo
+
+func (m *IngestionFolder) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *IngestionFolder) GetHourlyRollup() bool {
+	if m != nil {
+		return m.HourlyRollup
+	}
+	return false
+}
+
+type S3Config struct {
+	Bucket    string `protobuf:"bytes,1,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	KeyPrefix string `protobuf:"bytes,2,opt,name=key_prefix,json=keyPrefix,proto3" json:"key_prefix,omitempty"`
+	Region    string `protobuf:"bytes,3,opt,name=region,proto3" json:"region,omitempty"`
+	AccessKey string `protobuf:"bytes,4,opt,name=access_key,json=accessKey,proto3" json:"access_key,omitempty"`
+	// it is returned encrypted
+	AccessSecret string `protobuf:"bytes,5,opt,name=access_secret,json=accessSecret,proto3" json:"access_secret,omitempty"`
+	Endpoint     string `protobuf:"bytes,6,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	StorageClass string `protobuf:"bytes,7,opt,name=storage_class,json=storageClass,proto3" json:"storage_class,omitempty"`
+	// the buffer size, in MB, to use for multipart uploads
+	UploadPartSize int64 `protobuf:"varint,8,opt,name=upload_part_size,json=uploadPartSize,proto3" json:"upload_part_size,omitempty"`
+	// how many parts are uploaded in parallel
+	UploadConcurrency int32 `protobuf:"varint,9,opt,name=upload_concurrency,json=uploadConcurrency,proto3" json:"upload_concurrency,omitempty"`
+	// rules to choose the storage class for each upload, the first matching rule wins
+	StorageClassRules []*StorageClassRule `protobuf:"bytes,10,rep,name=storage_class_rules,json=storageClassRules,proto3" json:"storage_class_rules,omitempty"`
+	// optional session token for temporary credentials, it is returned encrypted
+	SessionToken string `protobuf:"bytes,11,opt,name=session_token,json=sessionToken,proto3" json:"session_token,omitempty"`
+	// ARN of an IAM role to assume using AWS STS
+	RoleArn string `protobuf:"bytes,12,opt,name=role_arn,json=roleArn,proto3" json:"role_arn,omitempty"`
+	// optional external ID to use when assuming the role
+	ExternalId string `protobuf:"bytes,13,opt,name=external_id,json=externalId,proto3" json:"external_id,omitempty"`
+	// use path-style requests, always used if a custom endpoint is set
+	ForcePathStyle bool `protobuf:"varint,14,opt,name=force_path_style,json=forcePathStyle,proto3" json:"force_path_style,omitempty"`
+	// skip the TLS certificate verification, insecure
+	SkipTlsVerify bool `protobuf:"varint,15,opt,name=skip_tls_verify,json=skipTlsVerify,proto3" json:"skip_tls_verify,omitempty"`
+	// PEM encoded CA certificates to trust, in addition to the system ones
+	CaBundle string `protobuf:"bytes,16,opt,name=ca_bundle,json=caBundle,proto3" json:"ca_bundle,omitempty"`
+	// the buffer size, in MB, to use for multipart downloads
+	DownloadPartSize int64 `protobuf:"varint,17,opt,name=download_part_size,json=downloadPartSize,proto3" json:"download_part_size,omitempty"`
+	// how many parts are downloaded in parallel
+	DownloadConcurrency  int32    `protobuf:"varint,18,opt,name=download_concurrency,json=downloadConcurrency,proto3" json:"download_concurrency,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *S3Config) Reset()         { *m = S3Config{} }
+func (m *S3Config) String() string { return proto.CompactTextString(m) }
+func (*S3Config) ProtoMessage()    {}
+func (*S3Config) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{6}
+}
+
+func (m *S3Config) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_S3Config.Unmarshal(m, b)
+}
+func (m *S3Config) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_S3Config.Marshal(b, m, deterministic)
+}
+func (m *S3Config) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_S3Config.Merge(m, src)
+}
+func (m *S3Config) XXX_Size() int {
+	return xxx_messageInfo_S3Config.Size(m)
+}
+func (m *S3Config) XXX_DiscardUnknown() {
+	xxx_messageInfo_S3Config.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_S3Config proto.InternalMessageInfo
+
+func (m *S3Config) GetBucket() string {
+	if m != nil {
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *S3Config) GetKeyPrefix() string {
+	if m != nil {
+		return m.KeyPrefix
+	}
+	return ""
+}
+
+func (m *S3Config) GetRegion() string {
+	if m != nil {
+		return m.Region
+	}
+	return ""
+}
+
+func (m *S3Config) GetAccessKey() string {
+	if m != nil {
+		return m.AccessKey
+	}
+	return ""
+}
+
+func (m *S3Config) GetAccessSecret() string {
+	if m != nil {
+		return m.AccessSecret
+	}
+	return ""
+}
+
+func (m *S3Config) GetEndpoint() string {
+	if m != nil {
+		return m.Endpoint
+	}
+	return ""
+}
+
+func (m *S3Config) GetStorageClass() string {
+	if m != nil {
+		return m.StorageClass
+	}
+	return ""
+}
+
+func (m *S3Config) GetUploadPartSize() int64 {
+	if m != nil {
+		return m.UploadPartSize
+	}
+	return 0
+}
+
+func (m *S3Config) GetUploadConcurrency() int32 {
+	if m != nil {
+		return m.UploadConcurrency
+	}
+	return 0
+}
+
+func (m *S3Config) GetStorageClassRules() []*StorageClassRule {
+	if m != nil {
+		return m.StorageClassRules
+	}
+	return nil
+}
+
+func (m *S3Config) GetSessionToken() string {
+	if m != nil {
+		return m.SessionToken
+	}
+	return ""
+}
+
+func (m *S3Config) GetRoleArn() string {
+	if m != nil {
+		return m.RoleArn
+	}
+	return ""
+}
+
+func (m *S3Config) GetExternalId() string {
+	if m != nil {
+		return m.ExternalId
+	}
+	return ""
+}
+
+func (m *S3Config) GetForcePathStyle() bool {
+	if m != nil {
+		return m.ForcePathStyle
+	}
+	return false
+}
+
+func (m *S3Config) GetSkipTlsVerify() bool {
+	if m != nil {
+		return m.SkipTlsVerify
+	}
+	return false
+}
+
+func (m *S3Config) GetCaBundle() string {
+	if m != nil {
+		return m.CaBundle
+	}
+	return ""
+}
+
+func (m *S3Config) GetDownloadPartSize() int64 {
+	if m != nil {
+		return m.DownloadPartSize
+	}
+	return 0
+}
+
+func (m *S3Config) GetDownloadConcurrency() int32 {
+	if m != nil {
+		return m.DownloadConcurrency
+	}
+	return 0
+}
+
+type StorageClassRule struct {
+	// SFTP path, the rule applies to the files inside this directory and its sub directories
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// case insensitive file extensions, for example ".zip"
+	Extensions []string `protobuf:"bytes,2,rep,name=extensions,proto3" json:"extensions,omitempty"`
+	// minimum file size as bytes
+	MinSize              int64    `protobuf:"varint,3,opt,name=min_size,json=minSize,proto3" json:"min_size,omitempty"`
+	StorageClass         string   `protobuf:"bytes,4,opt,name=storage_class,json=storageClass,proto3" json:"storage_class,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StorageClassRule) Reset()         { *m = StorageClassRule{} }
+func (m *StorageClassRule) String() string { return proto.CompactTextString(m) }
+func (*StorageClassRule) ProtoMessage()    {}
+func (*StorageClassRule) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{7}
+}
+
+func (m *StorageClassRule) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StorageClassRule.Unmarshal(m, b)
+}
+func (m *StorageClassRule) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StorageClassRule.Marshal(b, m, deterministic)
+}
+func (m *StorageClassRule) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StorageClassRule.Merge(m, src)
+}
+func (m *StorageClassRule) XXX_Size() int {
+	return xxx_messageInfo_StorageClassRule.Size(m)
+}
+func (m *StorageClassRule) XXX_DiscardUnknown() {
+	xxx_messageInfo_StorageClassRule.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StorageClassRule proto.InternalMessageInfo
+
+func (m *StorageClassRule) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *StorageClassRule) GetExtensions() []string {
+	if m != nil {
+		return m.Extensions
+	}
+	return nil
+}
+
+func (m *StorageClassRule) GetMinSize() int64 {
+	if m != nil {
+		return m.MinSize
+	}
+	return 0
+}
+
+func (m *StorageClassRule) GetStorageClass() string {
+	if m != nil {
+		return m.StorageClass
+	}
+	return ""
+}
+
+type GCSConfig struct {
+	Bucket    string `protobuf:"bytes,1,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	KeyPrefix string `protobuf:"bytes,2,opt,name=key_prefix,json=keyPrefix,proto3" json:"key_prefix,omitempty"`
+	// Google Cloud Storage JSON credentials base64 encoded, they are returned encrypted
+	Credentials string `protobuf:"bytes,3,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	// 1 means that the credentials are taken from the environment
+	AutomaticCredentials int32  `protobuf:"varint,4,opt,name=automatic_credentials,json=automaticCredentials,proto3" json:"automatic_credentials,omitempty"`
+	StorageClass         string `protobuf:"bytes,5,opt,name=storage_class,json=storageClass,proto3" json:"storage_class,omitempty"`
+	// rules to choose the storage class for each upload, the first matching rule wins
+	StorageClassRules []*StorageClassRule `protobuf:"bytes,6,rep,name=storage_class_rules,json=storageClassRules,proto3" json:"storage_class_rules,omitempty"`
+	// the buffer size, in MB, for each range request used for parallel downloads
+	DownloadPartSize int64 `protobuf:"varint,7,opt,name=download_part_size,json=downloadPartSize,proto3" json:"download_part_size,omitempty"`
+	// how many parts are downloaded in parallel, 0 or 1 means sequential downloads
+	DownloadConcurrency  int32    `protobuf:"varint,8,opt,name=download_concurrency,json=downloadConcurrency,proto3" json:"download_concurrency,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GCSConfig) Reset()         { *m = GCSConfig{} }
+func (m *GCSConfig) String() string { return proto.CompactTextString(m) }
+func (*GCSConfig) ProtoMessage()    {}
+func (*GCSConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{8}
+}
+
+func (m *GCSConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GCSConfig.Unmarshal(m, b)
+}
+func (m *GCSConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GCSConfig.Marshal(b, m, deterministic)
+}
+func (m *GCSConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GCSConfig.Merge(m, src)
+}
+func (m *GCSConfig) XXX_Size() int {
+	return xxx_messageInfo_GCSConfig.Size(m)
+}
+func (m *GCSConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_GCSConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GCSConfig proto.InternalMessageInfo
+
+func (m *GCSConfig) GetBucket() string {
+	if m != nil {
+		return m.Bucket
+	}
+	return ""
+}
+
+func (m *GCSConfig) GetKeyPrefix() string {
+	if m != nil {
+		return m.KeyPrefix
+	}
+	return ""
+}
+
+func (m *GCSConfig) GetCredentials() string {
+	if m != nil {
+		return m.Credentials
+	}
+	return ""
+}
+
+func (m *GCSConfig) GetAutomaticCredentials() int32 {
+	if m != nil {
+		return m.AutomaticCredentials
+	}
+	return 0
+}
+
+func (m *GCSConfig) GetStorageClass() string {
+	if m != nil {
+		return m.StorageClass
+	}
+	return ""
+}
+
+func (m *GCSConfig) GetStorageClassRules() []*StorageClassRule {
+	if m != nil {
+		return m.StorageClassRules
+	}
+	return nil
+}
+
+func (m *GCSConfig) GetDownloadPartSize() int64 {
+	if m != nil {
+		return m.DownloadPartSize
+	}
+	return 0
+}
+
+func (m *GCSConfig) GetDownloadConcurrency() int32 {
+	if m != nil {
+		return m.DownloadConcurrency
+	}
+	return 0
+}
+
+type CryptConfig struct {
+	// passphrase used to derive the file encryption keys, it is returned encrypted
+	Passphrase           string   `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CryptConfig) Reset()         { *m = CryptConfig{} }
+func (m *CryptConfig) String() string { return proto.CompactTextString(m) }
+func (*CryptConfig) ProtoMessage()    {}
+func (*CryptConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{9}
+}
+
+func (m *CryptConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CryptConfig.Unmarshal(m, b)
+}
+func (m *CryptConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CryptConfig.Marshal(b, m, deterministic)
+}
+func (m *CryptConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CryptConfig.Merge(m, src)
+}
+func (m *CryptConfig) XXX_Size() int {
+	return xxx_messageInfo_CryptConfig.Size(m)
+}
+func (m *CryptConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_CryptConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CryptConfig proto.InternalMessageInfo
+
+func (m *CryptConfig) GetPassphrase() string {
+	if m != nil {
+		return m.Passphrase
+	}
+	return ""
+}
+
+type WebDAVConfig struct {
+	Endpoint string `protobuf:"bytes,1,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	// username and password for basic authentication
+	Username string `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	// the password is returned encrypted
+	Password string `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	// token for bearer authentication, it is returned encrypted
+	BearerToken          string   `protobuf:"bytes,4,opt,name=bearer_token,json=bearerToken,proto3" json:"bearer_token,omitempty"`
+	RootPath             string   `protobuf:"bytes,5,opt,name=root_path,json=rootPath,proto3" json:"root_path,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WebDAVConfig) Reset()         { *m = WebDAVConfig{} }
+func (m *WebDAVConfig) String() string { return proto.CompactTextString(m) }
+func (*WebDAVConfig) ProtoMessage()    {}
+func (*WebDAVConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{10}
+}
+
+func (m *WebDAVConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WebDAVConfig.Unmarshal(m, b)
+}
+func (m *WebDAVConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WebDAVConfig.Marshal(b, m, deterministic)
+}
+func (m *WebDAVConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WebDAVConfig.Merge(m, src)
+}
+func (m *WebDAVConfig) XXX_Size() int {
+	return xxx_messageInfo_WebDAVConfig.Size(m)
+}
+func (m *WebDAVConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_WebDAVConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WebDAVConfig proto.InternalMessageInfo
+
+func (m *WebDAVConfig) GetEndpoint() string {
+	if m != nil {
+		return m.Endpoint
+	}
+	return ""
+}
+
+func (m *WebDAVConfig) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *WebDAVConfig) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *WebDAVConfig) GetBearerToken() string {
+	if m != nil {
+		return m.BearerToken
+	}
+	return ""
+}
+
+func (m *WebDAVConfig) GetRootPath() string {
+	if m != nil {
+		return m.RootPath
+	}
+	return ""
+}
+
+type HDFSConfig struct {
+	// WebHDFS base URL, for example http://namenode:9870/webhdfs/v1
+	Endpoint string `protobuf:"bytes,1,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	// Hadoop user for simple authentication
+	Username string `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	// it is returned encrypted
+	DelegationToken      string   `protobuf:"bytes,3,opt,name=delegation_token,json=delegationToken,proto3" json:"delegation_token,omitempty"`
+	RootPath             string   `protobuf:"bytes,4,opt,name=root_path,json=rootPath,proto3" json:"root_path,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HDFSConfig) Reset()         { *m = HDFSConfig{} }
+func (m *HDFSConfig) String() string { return proto.CompactTextString(m) }
+func (*HDFSConfig) ProtoMessage()    {}
+func (*HDFSConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{11}
+}
+
+func (m *HDFSConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HDFSConfig.Unmarshal(m, b)
+}
+func (m *HDFSConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HDFSConfig.Marshal(b, m, deterministic)
+}
+func (m *HDFSConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HDFSConfig.Merge(m, src)
+}
+func (m *HDFSConfig) XXX_Size() int {
+	return xxx_messageInfo_HDFSConfig.Size(m)
+}
+func (m *HDFSConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_HDFSConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HDFSConfig proto.InternalMessageInfo
+
+func (m *HDFSConfig) GetEndpoint() string {
+	if m != nil {
+		return m.Endpoint
+	}
+	return ""
+}
+
+func (m *HDFSConfig) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *HDFSConfig) GetDelegationToken() string {
+	if m != nil {
+		return m.DelegationToken
+	}
+	return ""
+}
+
+func (m *HDFSConfig) GetRootPath() string {
+	if m != nil {
+		return m.RootPath
+	}
+	return ""
+}
+
+type GoogleDriveConfig struct {
+	// empty means the root folder of My Drive
+	FolderId string `protobuf:"bytes,1,opt,name=folder_id,json=folderId,proto3" json:"folder_id,omitempty"`
+	// service account JSON key, it is returned encrypted
+	Credentials string `protobuf:"bytes,2,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	// user to impersonate using the domain-wide delegation
+	Subject  string `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	ClientId string `protobuf:"bytes,4,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// it is returned encrypted
+	ClientSecret string `protobuf:"bytes,5,opt,name=client_secret,json=clientSecret,proto3" json:"client_secret,omitempty"`
+	// it is returned encrypted
+	RefreshToken string `protobuf:"bytes,6,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	// optional, alternative API endpoint
+	Endpoint             string   `protobuf:"bytes,7,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GoogleDriveConfig) Reset()         { *m = GoogleDriveConfig{} }
+func (m *GoogleDriveConfig) String() string { return proto.CompactTextString(m) }
+func (*GoogleDriveConfig) ProtoMessage()    {}
+func (*GoogleDriveConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{12}
+}
+
+func (m *GoogleDriveConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GoogleDriveConfig.Unmarshal(m, b)
+}
+func (m *GoogleDriveConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GoogleDriveConfig.Marshal(b, m, deterministic)
+}
+func (m *GoogleDriveConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GoogleDriveConfig.Merge(m, src)
+}
+func (m *GoogleDriveConfig) XXX_Size() int {
+	return xxx_messageInfo_GoogleDriveConfig.Size(m)
+}
+func (m *GoogleDriveConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_GoogleDriveConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GoogleDriveConfig proto.InternalMessageInfo
+
+func (m *GoogleDriveConfig) GetFolderId() string {
+	if m != nil {
+		return m.FolderId
+	}
+	return ""
+}
+
+func (m *GoogleDriveConfig) GetCredentials() string {
+	if m != nil {
+		return m.Credentials
+	}
+	return ""
+}
+
+func (m *GoogleDriveConfig) GetSubject() string {
+	if m != nil {
+		return m.Subject
+	}
+	return ""
+}
+
+func (m *GoogleDriveConfig) GetClientId() string {
+	if m != nil {
+		return m.ClientId
+	}
+	return ""
+}
+
+func (m *GoogleDriveConfig) GetClientSecret() string {
+	if m != nil {
+		return m.ClientSecret
+	}
+	return ""
+}
+
+func (m *GoogleDriveConfig) GetRefreshToken() string {
+	if m != nil {
+		return m.RefreshToken
+	}
+	return ""
+}
+
+func (m *GoogleDriveConfig) GetEndpoint() string {
+	if m != nil {
+		return m.Endpoint
+	}
+	return ""
+}
+
+type DropboxConfig struct {
+	// it cannot start with "/", empty means the Dropbox root
+	RootPath string `protobuf:"bytes,1,opt,name=root_path,json=rootPath,proto3" json:"root_path,omitempty"`
+	// long-lived access token, it is returned encrypted
+	AccessToken string `protobuf:"bytes,2,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	// it is returned encrypted
+	RefreshToken string `protobuf:"bytes,3,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	AppKey       string `protobuf:"bytes,4,opt,name=app_key,json=appKey,proto3" json:"app_key,omitempty"`
+	// it is returned encrypted
+	AppSecret string `protobuf:"bytes,5,opt,name=app_secret,json=appSecret,proto3" json:"app_secret,omitempty"`
+	// upload session chunk size as MB, 0 means the default
+	UploadChunkSize int64 `protobuf:"varint,6,opt,name=upload_chunk_size,json=uploadChunkSize,proto3" json:"upload_chunk_size,omitempty"`
+	// optional, alternative API endpoint
+	Endpoint             string   `protobuf:"bytes,7,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DropboxConfig) Reset()         { *m = DropboxConfig{} }
+func (m *DropboxConfig) String() string { return proto.CompactTextString(m) }
+func (*DropboxConfig) ProtoMessage()    {}
+func (*DropboxConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{13}
+}
+
+func (m *DropboxConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropboxConfig.Unmarshal(m, b)
+}
+func (m *DropboxConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropboxConfig.Marshal(b, m, deterministic)
+}
+func (m *DropboxConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropboxConfig.Merge(m, src)
+}
+func (m *DropboxConfig) XXX_Size() int {
+	return xxx_messageInfo_DropboxConfig.Size(m)
+}
+func (m *DropboxConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropboxConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DropboxConfig proto.InternalMessageInfo
+
+func (m *DropboxConfig) GetRootPath() string {
+	if m != nil {
+		return m.RootPath
+	}
+	return ""
+}
+
+func (m *DropboxConfig) GetAccessToken() string {
+	if m != nil {
+		return m.AccessToken
+	}
+	return ""
+}
+
+func (m *DropboxConfig) GetRefreshToken() string {
+	if m != nil {
+		return m.RefreshToken
+	}
+	return ""
+}
+
+func (m *DropboxConfig) GetAppKey() string {
+	if m != nil {
+		return m.AppKey
+	}
+	return ""
+}
+
+func (m *DropboxConfig) GetAppSecret() string {
+	if m != nil {
+		return m.AppSecret
+	}
+	return ""
+}
+
+func (m *DropboxConfig) GetUploadChunkSize() int64 {
+	if m != nil {
+		return m.UploadChunkSize
+	}
+	return 0
+}
+
+func (m *DropboxConfig) GetEndpoint() string {
+	if m != nil {
+		return m.Endpoint
+	}
+	return ""
+}
+
+type Filesystem struct {
+	// 0 local filesystem, 1 S3 Compatible Object Storage, 2 Google Cloud Storage, 3 encrypted local filesystem,
+	// 4 remote WebDAV server, 5 HDFS, 6 Google Drive, 7 Dropbox
+	Provider             int32              `protobuf:"varint,1,opt,name=provider,proto3" json:"provider,omitempty"`
+	S3Config             *S3Config          `protobuf:"bytes,2,opt,name=s3config,proto3" json:"s3config,omitempty"`
+	Gcsconfig            *GCSConfig         `protobuf:"bytes,3,opt,name=gcsconfig,proto3" json:"gcsconfig,omitempty"`
+	Cryptconfig          *CryptConfig       `protobuf:"bytes,4,opt,name=cryptconfig,proto3" json:"cryptconfig,omitempty"`
+	Webdavconfig         *WebDAVConfig      `protobuf:"bytes,5,opt,name=webdavconfig,proto3" json:"webdavconfig,omitempty"`
+	Hdfsconfig           *HDFSConfig        `protobuf:"bytes,6,opt,name=hdfsconfig,proto3" json:"hdfsconfig,omitempty"`
+	Gdriveconfig         *GoogleDriveConfig `protobuf:"bytes,7,opt,name=gdriveconfig,proto3" json:"gdriveconfig,omitempty"`
+	Dropboxconfig        *DropboxConfig     `protobuf:"bytes,8,opt,name=dropboxconfig,proto3" json:"dropboxconfig,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *Filesystem) Reset()         { *m = Filesystem{} }
+func (m *Filesystem) String() string { return proto.CompactTextString(m) }
+func (*Filesystem) ProtoMessage()    {}
+func (*Filesystem) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{14}
+}
+
+func (m *Filesystem) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Filesystem.Unmarshal(m, b)
+}
+func (m *Filesystem) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Filesystem.Marshal(b, m, deterministic)
+}
+func (m *Filesystem) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Filesystem.Merge(m, src)
+}
+func (m *Filesystem) XXX_Size() int {
+	return xxx_messageInfo_Filesystem.Size(m)
+}
+func (m *Filesystem) XXX_DiscardUnknown() {
+	xxx_messageInfo_Filesystem.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Filesystem proto.InternalMessageInfo
+
+func (m *Filesystem) GetProvider() int32 {
+	if m != nil {
+		return m.Provider
+	}
+	return 0
+}
+
+func (m *Filesystem) GetS3Config() *S3Config {
+	if m != nil {
+		return m.S3Config
+	}
+	return nil
+}
+
+func (m *Filesystem) GetGcsconfig() *GCSConfig {
+	if m != nil {
+		return m.Gcsconfig
+	}
+	return nil
+}
+
+func (m *Filesystem) GetCryptconfig() *CryptConfig {
+	if m != nil {
+		return m.Cryptconfig
+	}
+	return nil
+}
+
+func (m *Filesystem) GetWebdavconfig() *WebDAVConfig {
+	if m != nil {
+		return m.Webdavconfig
+	}
+	return nil
+}
+
+func (m *Filesystem) GetHdfsconfig() *HDFSConfig {
+	if m != nil {
+		return m.Hdfsconfig
+	}
+	return nil
+}
+
+func (m *Filesystem) GetGdriveconfig() *GoogleDriveConfig {
+	if m != nil {
+		return m.Gdriveconfig
+	}
+	return nil
+}
+
+func (m *Filesystem) GetDropboxconfig() *DropboxConfig {
+	if m != nil {
+		return m.Dropboxconfig
+	}
+	return nil
+}
+
+type User struct {
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// 1 enabled, 0 disabled (login is not allowed)
+	Status   int32  `protobuf:"varint,2,opt,name=status,proto3" json:"status,omitempty"`
+	Username string `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	// expiration date as unix timestamp in milliseconds, 0 means no expiration
+	ExpirationDate int64 `protobuf:"varint,4,opt,name=expiration_date,json=expirationDate,proto3" json:"expiration_date,omitempty"`
+	// password or public keys are mandatory. For security reasons
+	// this field is omitted when you search/get users
+	Password   string   `protobuf:"bytes,5,opt,name=password,proto3" json:"password,omitempty"`
+	PublicKeys []string `protobuf:"bytes,6,rep,name=public_keys,json=publicKeys,proto3" json:"public_keys,omitempty"`
+	HomeDir    string   `protobuf:"bytes,7,opt,name=home_dir,json=homeDir,proto3" json:"home_dir,omitempty"`
+	// the virtual folders are mapped to local paths outside the user home dir
+	VirtualFolders []*VirtualFolder `protobuf:"bytes,8,rep,name=virtual_folders,json=virtualFolders,proto3" json:"virtual_folders,omitempty"`
+	Uid            int32            `protobuf:"varint,9,opt,name=uid,proto3" json:"uid,omitempty"`
+	Gid            int32            `protobuf:"varint,10,opt,name=gid,proto3" json:"gid,omitempty"`
+	// 0 means unlimited
+	MaxSessions int32 `protobuf:"varint,11,opt,name=max_sessions,json=maxSessions,proto3" json:"max_sessions,omitempty"`
+	// quota as size in bytes, 0 means unlimited
+	QuotaSize int64 `protobuf:"varint,12,opt,name=quota_size,json=quotaSize,proto3" json:"quota_size,omitempty"`
+	// quota as number of files, 0 means unlimited
+	QuotaFiles int32 `protobuf:"varint,13,opt,name=quota_files,json=quotaFiles,proto3" json:"quota_files,omitempty"`
+	// hash map with directory as key and an array of permissions as value.
+	// Directories must be absolute paths, permissions for root directory ("/") are required
+	Permissions    map[string]*Permissions `protobuf:"bytes,14,rep,name=permissions,proto3" json:"permissions,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	UsedQuotaSize  int64                   `protobuf:"varint,15,opt,name=used_quota_size,json=usedQuotaSize,proto3" json:"used_quota_size,omitempty"`
+	UsedQuotaFiles int32                   `protobuf:"varint,16,opt,name=used_quota_files,json=usedQuotaFiles,proto3" json:"used_quota_files,omitempty"`
+	// last quota update as unix timestamp in milliseconds
+	LastQuotaUpdate int64 `protobuf:"varint,17,opt,name=last_quota_update,json=lastQuotaUpdate,proto3" json:"last_quota_update,omitempty"`
+	// maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth int64 `protobuf:"varint,18,opt,name=upload_bandwidth,json=uploadBandwidth,proto3" json:"upload_bandwidth,omitempty"`
+	// maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `protobuf:"varint,19,opt,name=download_bandwidth,json=downloadBandwidth,proto3" json:"download_bandwidth,omitempty"`
+	// last user login as unix timestamp in milliseconds
+	LastLogin  int64        `protobuf:"varint,20,opt,name=last_login,json=lastLogin,proto3" json:"last_login,omitempty"`
+	Filters    *UserFilters `protobuf:"bytes,21,opt,name=filters,proto3" json:"filters,omitempty"`
+	Filesystem *Filesystem  `protobuf:"bytes,22,opt,name=filesystem,proto3" json:"filesystem,omitempty"`
+	// optional plan name, the plan limits and denied login methods replace the user ones
+	Plan string `protobuf:"bytes,23,opt,name=plan,proto3" json:"plan,omitempty"`
+	// free form text, for example billing IDs or contract references, it is not used by SFTPGo
+	AdditionalInfo string `protobuf:"bytes,24,opt,name=additional_info,json=additionalInfo,proto3" json:"additional_info,omitempty"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `protobuf:"varint,25,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `protobuf:"varint,26,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// incremented on each update. An update for a version different from the stored one
+	// is rejected with the ABORTED code, 0 means that the version is not checked
+	Version              int64    `protobuf:"varint,27,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *User) Reset()         { *m = User{} }
+func (m *User) String() string { return proto.CompactTextString(m) }
+func (*User) ProtoMessage()    {}
+func (*User) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{15}
+}
+
+func (m *User) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_User.Unmarshal(m, b)
+}
+func (m *User) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_User.Marshal(b, m, deterministic)
+}
+func (m *User) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_User.Merge(m, src)
+}
+func (m *User) XXX_Size() int {
+	return xxx_messageInfo_User.Size(m)
+}
+func (m *User) XXX_DiscardUnknown() {
+	xxx_messageInfo_User.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_User proto.InternalMessageInfo
+
+func (m *User) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *User) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func (m *User) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *User) GetExpirationDate() int64 {
+	if m != nil {
+		return m.ExpirationDate
+	}
+	return 0
+}
+
+func (m *User) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *User) GetPublicKeys() []string {
+	if m != nil {
+		return m.PublicKeys
+	}
+	return nil
+}
+
+func (m *User) GetHomeDir() string {
+	if m != nil {
+		return m.HomeDir
+	}
+	return ""
+}
+
+func (m *User) GetVirtualFolders() []*VirtualFolder {
+	if m != nil {
+		return m.VirtualFolders
+	}
+	return nil
+}
+
+func (m *User) GetUid() int32 {
+	if m != nil {
+		return m.Uid
+	}
+	return 0
+}
+
+func (m *User) GetGid() int32 {
+	if m != nil {
+		return m.Gid
+	}
+	return 0
+}
+
+func (m *User) GetMaxSessions() int32 {
+	if m != nil {
+		return m.MaxSessions
+	}
+	return 0
+}
+
+func (m *User) GetQuotaSize() int64 {
+	if m != nil {
+		return m.QuotaSize
+	}
+	return 0
+}
+
+func (m *User) GetQuotaFiles() int32 {
+	if m != nil {
+		return m.QuotaFiles
+	}
+	return 0
+}
+
+func (m *User) GetPermissions() map[string]*Permissions {
+	if m != nil {
+		return m.Permissions
+	}
+	return nil
+}
+
+func (m *User) GetUsedQuotaSize() int64 {
+	if m != nil {
+		return m.UsedQuotaSize
+	}
+	return 0
+}
+
+func (m *User) GetUsedQuotaFiles() int32 {
+	if m != nil {
+		return m.UsedQuotaFiles
+	}
+	return 0
+}
+
+func (m *User) GetLastQuotaUpdate() int64 {
+	if m != nil {
+		return m.LastQuotaUpdate
+	}
+	return 0
+}
+
+func (m *User) GetUploadBandwidth() int64 {
+	if m != nil {
+		return m.UploadBandwidth
+	}
+	return 0
+}
+
+func (m *User) GetDownloadBandwidth() int64 {
+	if m != nil {
+		return m.DownloadBandwidth
+	}
+	return 0
+}
+
+func (m *User) GetLastLogin() int64 {
+	if m != nil {
+		return m.LastLogin
+	}
+	return 0
+}
+
+func (m *User) GetFilters() *UserFilters {
+	if m != nil {
+		return m.Filters
+	}
+	return nil
+}
+
+func (m *User) GetFilesystem() *Filesystem {
+	if m != nil {
+		return m.Filesystem
+	}
+	return nil
+}
+
+func (m *User) GetPlan() string {
+	if m != nil {
+		return m.Plan
+	}
+	return ""
+}
+
+func (m *User) GetAdditionalInfo() string {
+	if m != nil {
+		return m.AdditionalInfo
+	}
+	return ""
+}
+
+func (m *User) GetCreatedAt() int64 {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return 0
+}
+
+func (m *User) GetUpdatedAt() int64 {
+	if m != nil {
+		return m.UpdatedAt
+	}
+	return 0
+}
+
+func (m *User) GetVersion() int64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type GetUsersRequest struct {
+	// the maximum number of users returned, default 100, max 500
+	Limit  int32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset int32 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	// sort order by username, ASC or DESC, default ASC
+	Order string `protobuf:"bytes,3,opt,name=order,proto3" json:"order,omitempty"`
+	// exact match for the username, empty means any username
+	Username             string   `protobuf:"bytes,4,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetUsersRequest) Reset()         { *m = GetUsersRequest{} }
+func (m *GetUsersRequest) String() string { return proto.CompactTextString(m) }
+func (*GetUsersRequest) ProtoMessage()    {}
+func (*GetUsersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{16}
+}
+
+func (m *GetUsersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetUsersRequest.Unmarshal(m, b)
+}
+func (m *GetUsersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetUsersRequest.Marshal(b, m, deterministic)
+}
+func (m *GetUsersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetUsersRequest.Merge(m, src)
+}
+func (m *GetUsersRequest) XXX_Size() int {
+	return xxx_messageInfo_GetUsersRequest.Size(m)
+}
+func (m *GetUsersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetUsersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetUsersRequest proto.InternalMessageInfo
+
+func (m *GetUsersRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+func (m *GetUsersRequest) GetOffset() int32 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *GetUsersRequest) GetOrder() string {
+	if m != nil {
+		return m.Order
+	}
+	return ""
+}
+
+func (m *GetUsersRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type GetUsersResponse struct {
+	Users                []*User  `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetUsersResponse) Reset()         { *m = GetUsersResponse{} }
+func (m *GetUsersResponse) String() string { return proto.CompactTextString(m) }
+func (*GetUsersResponse) ProtoMessage()    {}
+func (*GetUsersResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{17}
+}
+
+func (m *GetUsersResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetUsersResponse.Unmarshal(m, b)
+}
+func (m *GetUsersResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetUsersResponse.Marshal(b, m, deterministic)
+}
+func (m *GetUsersResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetUsersResponse.Merge(m, src)
+}
+func (m *GetUsersResponse) XXX_Size() int {
+	return xxx_messageInfo_GetUsersResponse.Size(m)
+}
+func (m *GetUsersResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetUsersResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetUsersResponse proto.InternalMessageInfo
+
+func (m *GetUsersResponse) GetUsers() []*User {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+type GetUserRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetUserRequest) Reset()         { *m = GetUserRequest{} }
+func (m *GetUserRequest) String() string { return proto.CompactTextString(m) }
+func (*GetUserRequest) ProtoMessage()    {}
+func (*GetUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{18}
+}
+
+func (m *GetUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetUserRequest.Unmarshal(m, b)
+}
+func (m *GetUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetUserRequest.Marshal(b, m, deterministic)
+}
+func (m *GetUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetUserRequest.Merge(m, src)
+}
+func (m *GetUserRequest) XXX_Size() int {
+	return xxx_messageInfo_GetUserRequest.Size(m)
+}
+func (m *GetUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetUserRequest proto.InternalMessageInfo
+
+func (m *GetUserRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type AddUserRequest struct {
+	User                 *User    `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddUserRequest) Reset()         { *m = AddUserRequest{} }
+func (m *AddUserRequest) String() string { return proto.CompactTextString(m) }
+func (*AddUserRequest) ProtoMessage()    {}
+func (*AddUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{19}
+}
+
+func (m *AddUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddUserRequest.Unmarshal(m, b)
+}
+func (m *AddUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddUserRequest.Marshal(b, m, deterministic)
+}
+func (m *AddUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddUserRequest.Merge(m, src)
+}
+func (m *AddUserRequest) XXX_Size() int {
+	return xxx_messageInfo_AddUserRequest.Size(m)
+}
+func (m *AddUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddUserRequest proto.InternalMessageInfo
+
+func (m *AddUserRequest) GetUser() *User {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type UpdateUserRequest struct {
+	// the user ID must be set
+	User                 *User          `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	Disconnect           DisconnectMode `protobuf:"varint,2,opt,name=disconnect,proto3,enum=sftpgo.admin.DisconnectMode" json:"disconnect,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *UpdateUserRequest) Reset()         { *m = UpdateUserRequest{} }
+func (m *UpdateUserRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateUserRequest) ProtoMessage()    {}
+func (*UpdateUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{20}
+}
+
+func (m *UpdateUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateUserRequest.Unmarshal(m, b)
+}
+func (m *UpdateUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateUserRequest.Marshal(b, m, deterministic)
+}
+func (m *UpdateUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateUserRequest.Merge(m, src)
+}
+func (m *UpdateUserRequest) XXX_Size() int {
+	return xxx_messageInfo_UpdateUserRequest.Size(m)
+}
+func (m *UpdateUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateUserRequest proto.InternalMessageInfo
+
+func (m *UpdateUserRequest) GetUser() *User {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+func (m *UpdateUserRequest) GetDisconnect() DisconnectMode {
+	if m != nil {
+		return m.Disconnect
+	}
+	return DisconnectMode_DISCONNECT_DEFAULT
+}
+
+type DeleteUserRequest struct {
+	Id                   int64          `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Disconnect           DisconnectMode `protobuf:"varint,2,opt,name=disconnect,proto3,enum=sftpgo.admin.DisconnectMode" json:"disconnect,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *DeleteUserRequest) Reset()         { *m = DeleteUserRequest{} }
+func (m *DeleteUserRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteUserRequest) ProtoMessage()    {}
+func (*DeleteUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{21}
+}
+
+func (m *DeleteUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteUserRequest.Unmarshal(m, b)
+}
+func (m *DeleteUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteUserRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteUserRequest.Merge(m, src)
+}
+func (m *DeleteUserRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteUserRequest.Size(m)
+}
+func (m *DeleteUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteUserRequest proto.InternalMessageInfo
+
+func (m *DeleteUserRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *DeleteUserRequest) GetDisconnect() DisconnectMode {
+	if m != nil {
+		return m.Disconnect
+	}
+	return DisconnectMode_DISCONNECT_DEFAULT
+}
+
+type Transfer struct {
+	// upload or download
+	OperationType string `protobuf:"bytes,1,opt,name=operation_type,json=operationType,proto3" json:"operation_type,omitempty"`
+	Path          string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	// start time as unix timestamp in milliseconds
+	StartTime int64 `protobuf:"varint,3,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	// bytes transferred
+	Size int64 `protobuf:"varint,4,opt,name=size,proto3" json:"size,omitempty"`
+	// last transfer activity as unix timestamp in milliseconds
+	LastActivity int64 `protobuf:"varint,5,opt,name=last_activity,json=lastActivity,proto3" json:"last_activity,omitempty"`
+	// true if the transfer is slower than the configured minimum speed
+	Degraded             bool     `protobuf:"varint,6,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Transfer) Reset()         { *m = Transfer{} }
+func (m *Transfer) String() string { return proto.CompactTextString(m) }
+func (*Transfer) ProtoMessage()    {}
+func (*Transfer) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{22}
+}
+
+func (m *Transfer) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Transfer.Unmarshal(m, b)
+}
+func (m *Transfer) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Transfer.Marshal(b, m, deterministic)
+}
+func (m *Transfer) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Transfer.Merge(m, src)
+}
+func (m *Transfer) XXX_Size() int {
+	return xxx_messageInfo_Transfer.Size(m)
+}
+func (m *Transfer) XXX_DiscardUnknown() {
+	xxx_messageInfo_Transfer.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Transfer proto.InternalMessageInfo
+
+func (m *Transfer) GetOperationType() string {
+	if m != nil {
+		return m.OperationType
+	}
+	return ""
+}
+
+func (m *Transfer) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Transfer) GetStartTime() int64 {
+	if m != nil {
+		return m.StartTime
+	}
+	return 0
+}
+
+func (m *Transfer) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *Transfer) GetLastActivity() int64 {
+	if m != nil {
+		return m.LastActivity
+	}
+	return 0
+}
+
+func (m *Transfer) GetDegraded() bool {
+	if m != nil {
+		return m.Degraded
+	}
+	return false
+}
+
+type Connection struct {
+	Username      string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	ConnectionId  string `protobuf:"bytes,2,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	ClientVersion string `protobuf:"bytes,3,opt,name=client_version,json=clientVersion,proto3" json:"client_version,omitempty"`
+	RemoteAddress string `protobuf:"bytes,4,opt,name=remote_address,json=remoteAddress,proto3" json:"remote_address,omitempty"`
+	// connection time as unix timestamp in milliseconds
+	ConnectionTime int64 `protobuf:"varint,5,opt,name=connection_time,json=connectionTime,proto3" json:"connection_time,omitempty"`
+	// last activity as unix timestamp in milliseconds
+	LastActivity int64 `protobuf:"varint,6,opt,name=last_activity,json=lastActivity,proto3" json:"last_activity,omitempty"`
+	// SFTP, SCP or SSH
+	Protocol        string      `protobuf:"bytes,7,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	ActiveTransfers []*Transfer `protobuf:"bytes,8,rep,name=active_transfers,json=activeTransfers,proto3" json:"active_transfers,omitempty"`
+	// for protocol SSH this is the issued command
+	SshCommand string `protobuf:"bytes,9,opt,name=ssh_command,json=sshCommand,proto3" json:"ssh_command,omitempty"`
+	// client software resolved from the client version, for example "WinSCP"
+	ClientSoftware string `protobuf:"bytes,10,opt,name=client_software,json=clientSoftware,proto3" json:"client_software,omitempty"`
+	// tenant configured for the logged in user, if any
+	Tenant string `protobuf:"bytes,11,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// label set by an administrator, if any
+	Label                string   `protobuf:"bytes,12,opt,name=label,proto3" json:"label,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Connection) Reset()         { *m = Connection{} }
+func (m *Connection) String() string { return proto.CompactTextString(m) }
+func (*Connection) ProtoMessage()    {}
+func (*Connection) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{23}
+}
+
+func (m *Connection) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Connection.Unmarshal(m, b)
+}
+func (m *Connection) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Connection.Marshal(b, m, deterministic)
+}
+func (m *Connection) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Connection.Merge(m, src)
+}
+func (m *Connection) XXX_Size() int {
+	return xxx_messageInfo_Connection.Size(m)
+}
+func (m *Connection) XXX_DiscardUnknown() {
+	xxx_messageInfo_Connection.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Connection proto.InternalMessageInfo
+
+func (m *Connection) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *Connection) GetConnectionId() string {
+	if m != nil {
+		return m.ConnectionId
+	}
+	return ""
+}
+
+func (m *Connection) GetClientVersion() string {
+	if m != nil {
+		return m.ClientVersion
+	}
+	return ""
+}
+
+func (m *Connection) GetRemoteAddress() string {
+	if m != nil {
+		return m.RemoteAddress
+	}
+	return ""
+}
+
+func (m *Connection) GetConnectionTime() int64 {
+	if m != nil {
+		return m.ConnectionTime
+	}
+	return 0
+}
+
+func (m *Connection) GetLastActivity() int64 {
+	if m != nil {
+		return m.LastActivity
+	}
+	return 0
+}
+
+func (m *Connection) GetProtocol() string {
+	if m != nil {
+		return m.Protocol
+	}
+	return ""
+}
+
+func (m *Connection) GetActiveTransfers() []*Transfer {
+	if m != nil {
+		return m.ActiveTransfers
+	}
+	return nil
+}
+
+func (m *Connection) GetSshCommand() string {
+	if m != nil {
+		return m.SshCommand
+	}
+	return ""
+}
+
+func (m *Connection) GetClientSoftware() string {
+	if m != nil {
+		return m.ClientSoftware
+	}
+	return ""
+}
+
+func (m *Connection) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *Connection) GetLabel() string {
+	if m != nil {
+		return m.Label
+	}
+	return ""
+}
+
+// empty fields match any value, the comparison is case insensitive
+type GetConnectionsRequest struct {
+	ClientSoftware       string   `protobuf:"bytes,1,opt,name=client_software,json=clientSoftware,proto3" json:"client_software,omitempty"`
+	Tenant               string   `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Label                string   `protobuf:"bytes,3,opt,name=label,proto3" json:"label,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetConnectionsRequest) Reset()         { *m = GetConnectionsRequest{} }
+func (m *GetConnectionsRequest) String() string { return proto.CompactTextString(m) }
+func (*GetConnectionsRequest) ProtoMessage()    {}
+func (*GetConnectionsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{24}
+}
+
+func (m *GetConnectionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetConnectionsRequest.Unmarshal(m, b)
+}
+func (m *GetConnectionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetConnectionsRequest.Marshal(b, m, deterministic)
+}
+func (m *GetConnectionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetConnectionsRequest.Merge(m, src)
+}
+func (m *GetConnectionsRequest) XXX_Size() int {
+	return xxx_messageInfo_GetConnectionsRequest.Size(m)
+}
+func (m *GetConnectionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetConnectionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetConnectionsRequest proto.InternalMessageInfo
+
+func (m *GetConnectionsRequest) GetClientSoftware() string {
+	if m != nil {
+		return m.ClientSoftware
+	}
+	return ""
+}
+
+func (m *GetConnectionsRequest) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *GetConnectionsRequest) GetLabel() string {
+	if m != nil {
+		return m.Label
+	}
+	return ""
+}
+
+type GetConnectionsResponse struct {
+	Connections          []*Connection `protobuf:"bytes,1,rep,name=connections,proto3" json:"connections,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *GetConnectionsResponse) Reset()         { *m = GetConnectionsResponse{} }
+func (m *GetConnectionsResponse) String() string { return proto.CompactTextString(m) }
+func (*GetConnectionsResponse) ProtoMessage()    {}
+func (*GetConnectionsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{25}
+}
+
+func (m *GetConnectionsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetConnectionsResponse.Unmarshal(m, b)
+}
+func (m *GetConnectionsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetConnectionsResponse.Marshal(b, m, deterministic)
+}
+func (m *GetConnectionsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetConnectionsResponse.Merge(m, src)
+}
+func (m *GetConnectionsResponse) XXX_Size() int {
+	return xxx_messageInfo_GetConnectionsResponse.Size(m)
+}
+func (m *GetConnectionsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetConnectionsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetConnectionsResponse proto.InternalMessageInfo
+
+func (m *GetConnectionsResponse) GetConnections() []*Connection {
+	if m != nil {
+		return m.Connections
+	}
+	return nil
+}
+
+type CloseConnectionRequest struct {
+	ConnectionId         string   `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CloseConnectionRequest) Reset()         { *m = CloseConnectionRequest{} }
+func (m *CloseConnectionRequest) String() string { return proto.CompactTextString(m) }
+func (*CloseConnectionRequest) ProtoMessage()    {}
+func (*CloseConnectionRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{26}
+}
+
+func (m *CloseConnectionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CloseConnectionRequest.Unmarshal(m, b)
+}
+func (m *CloseConnectionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CloseConnectionRequest.Marshal(b, m, deterministic)
+}
+func (m *CloseConnectionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CloseConnectionRequest.Merge(m, src)
+}
+func (m *CloseConnectionRequest) XXX_Size() int {
+	return xxx_messageInfo_CloseConnectionRequest.Size(m)
+}
+func (m *CloseConnectionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CloseConnectionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CloseConnectionRequest proto.InternalMessageInfo
+
+func (m *CloseConnectionRequest) GetConnectionId() string {
+	if m != nil {
+		return m.ConnectionId
+	}
+	return ""
+}
+
+type UpdateConnectionLabelRequest struct {
+	ConnectionId string `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	// empty to remove the label
+	Label                string   `protobuf:"bytes,2,opt,name=label,proto3" json:"label,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UpdateConnectionLabelRequest) Reset()         { *m = UpdateConnectionLabelRequest{} }
+func (m *UpdateConnectionLabelRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateConnectionLabelRequest) ProtoMessage()    {}
+func (*UpdateConnectionLabelRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{27}
+}
+
+func (m *UpdateConnectionLabelRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateConnectionLabelRequest.Unmarshal(m, b)
+}
+func (m *UpdateConnectionLabelRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateConnectionLabelRequest.Marshal(b, m, deterministic)
+}
+func (m *UpdateConnectionLabelRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateConnectionLabelRequest.Merge(m, src)
+}
+func (m *UpdateConnectionLabelRequest) XXX_Size() int {
+	return xxx_messageInfo_UpdateConnectionLabelRequest.Size(m)
+}
+func (m *UpdateConnectionLabelRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateConnectionLabelRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateConnectionLabelRequest proto.InternalMessageInfo
+
+func (m *UpdateConnectionLabelRequest) GetConnectionId() string {
+	if m != nil {
+		return m.ConnectionId
+	}
+	return ""
+}
+
+func (m *UpdateConnectionLabelRequest) GetLabel() string {
+	if m != nil {
+		return m.Label
+	}
+	return ""
+}
+
+type QuotaScan struct {
+	Username string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	// scan start time as unix timestamp in milliseconds
+	StartTime            int64    `protobuf:"varint,2,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QuotaScan) Reset()         { *m = QuotaScan{} }
+func (m *QuotaScan) String() string { return proto.CompactTextString(m) }
+func (*QuotaScan) ProtoMessage()    {}
+func (*QuotaScan) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{28}
+}
+
+func (m *QuotaScan) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QuotaScan.Unmarshal(m, b)
+}
+func (m *QuotaScan) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QuotaScan.Marshal(b, m, deterministic)
+}
+func (m *QuotaScan) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QuotaScan.Merge(m, src)
+}
+func (m *QuotaScan) XXX_Size() int {
+	return xxx_messageInfo_QuotaScan.Size(m)
+}
+func (m *QuotaScan) XXX_DiscardUnknown() {
+	xxx_messageInfo_QuotaScan.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QuotaScan proto.InternalMessageInfo
+
+func (m *QuotaScan) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *QuotaScan) GetStartTime() int64 {
+	if m != nil {
+		return m.StartTime
+	}
+	return 0
+}
+
+type GetQuotaScansRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetQuotaScansRequest) Reset()         { *m = GetQuotaScansRequest{} }
+func (m *GetQuotaScansRequest) String() string { return proto.CompactTextString(m) }
+func (*GetQuotaScansRequest) ProtoMessage()    {}
+func (*GetQuotaScansRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{29}
+}
+
+func (m *GetQuotaScansRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetQuotaScansRequest.Unmarshal(m, b)
+}
+func (m *GetQuotaScansRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetQuotaScansRequest.Marshal(b, m, deterministic)
+}
+func (m *GetQuotaScansRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetQuotaScansRequest.Merge(m, src)
+}
+func (m *GetQuotaScansRequest) XXX_Size() int {
+	return xxx_messageInfo_GetQuotaScansRequest.Size(m)
+}
+func (m *GetQuotaScansRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetQuotaScansRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetQuotaScansRequest proto.InternalMessageInfo
+
+type GetQuotaScansResponse struct {
+	Scans                []*QuotaScan `protobuf:"bytes,1,rep,name=scans,proto3" json:"scans,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *GetQuotaScansResponse) Reset()         { *m = GetQuotaScansResponse{} }
+func (m *GetQuotaScansResponse) String() string { return proto.CompactTextString(m) }
+func (*GetQuotaScansResponse) ProtoMessage()    {}
+func (*GetQuotaScansResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{30}
+}
+
+func (m *GetQuotaScansResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetQuotaScansResponse.Unmarshal(m, b)
+}
+func (m *GetQuotaScansResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetQuotaScansResponse.Marshal(b, m, deterministic)
+}
+func (m *GetQuotaScansResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetQuotaScansResponse.Merge(m, src)
+}
+func (m *GetQuotaScansResponse) XXX_Size() int {
+	return xxx_messageInfo_GetQuotaScansResponse.Size(m)
+}
+func (m *GetQuotaScansResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetQuotaScansResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetQuotaScansResponse proto.InternalMessageInfo
+
+func (m *GetQuotaScansResponse) GetScans() []*QuotaScan {
+	if m != nil {
+		return m.Scans
+	}
+	return nil
+}
+
+type StartQuotaScanRequest struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StartQuotaScanRequest) Reset()         { *m = StartQuotaScanRequest{} }
+func (m *StartQuotaScanRequest) String() string { return proto.CompactTextString(m) }
+func (*StartQuotaScanRequest) ProtoMessage()    {}
+func (*StartQuotaScanRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{31}
+}
+
+func (m *StartQuotaScanRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StartQuotaScanRequest.Unmarshal(m, b)
+}
+func (m *StartQuotaScanRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StartQuotaScanRequest.Marshal(b, m, deterministic)
+}
+func (m *StartQuotaScanRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StartQuotaScanRequest.Merge(m, src)
+}
+func (m *StartQuotaScanRequest) XXX_Size() int {
+	return xxx_messageInfo_StartQuotaScanRequest.Size(m)
+}
+func (m *StartQuotaScanRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StartQuotaScanRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StartQuotaScanRequest proto.InternalMessageInfo
+
+func (m *StartQuotaScanRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type DumpDataRequest struct {
+	// path for the backup file relative to the configured backups_path
+	OutputFile           string   `protobuf:"bytes,1,opt,name=output_file,json=outputFile,proto3" json:"output_file,omitempty"`
+	Indent               bool     `protobuf:"varint,2,opt,name=indent,proto3" json:"indent,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DumpDataRequest) Reset()         { *m = DumpDataRequest{} }
+func (m *DumpDataRequest) String() string { return proto.CompactTextString(m) }
+func (*DumpDataRequest) ProtoMessage()    {}
+func (*DumpDataRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{32}
+}
+
+func (m *DumpDataRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DumpDataRequest.Unmarshal(m, b)
+}
+func (m *DumpDataRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DumpDataRequest.Marshal(b, m, deterministic)
+}
+func (m *DumpDataRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DumpDataRequest.Merge(m, src)
+}
+func (m *DumpDataRequest) XXX_Size() int {
+	return xxx_messageInfo_DumpDataRequest.Size(m)
+}
+func (m *DumpDataRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DumpDataRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DumpDataRequest proto.InternalMessageInfo
+
+func (m *DumpDataRequest) GetOutputFile() string {
+	if m != nil {
+		return m.OutputFile
+	}
+	return ""
+}
+
+func (m *DumpDataRequest) GetIndent() bool {
+	if m != nil {
+		return m.Indent
+	}
+	return false
+}
+
+type LoadDataRequest struct {
+	// absolute path for the backup file
+	InputFile string `protobuf:"bytes,1,opt,name=input_file,json=inputFile,proto3" json:"input_file,omitempty"`
+	// 0 no quota scan, 1 always scan quota, 2 scan quota if the user has quota restrictions
+	ScanQuota int32 `protobuf:"varint,2,opt,name=scan_quota,json=scanQuota,proto3" json:"scan_quota,omitempty"`
+	// 0 new users are added, existing users are updated, 1 new users are added, existing users are not modified
+	Mode                 int32    `protobuf:"varint,3,opt,name=mode,proto3" json:"mode,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LoadDataRequest) Reset()         { *m = LoadDataRequest{} }
+func (m *LoadDataRequest) String() string { return proto.CompactTextString(m) }
+func (*LoadDataRequest) ProtoMessage()    {}
+func (*LoadDataRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0e010c956a837cc4, []int{33}
+}
+
+func (m *LoadDataRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LoadDataRequest.Unmarshal(m, b)
+}
+func (m *LoadDataRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LoadDataRequest.Marshal(b, m, deterministic)
+}
+func (m *LoadDataRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LoadDataRequest.Merge(m, src)
+}
+func (m *LoadDataRequest) XXX_Size() int {
+	return xxx_messageInfo_LoadDataRequest.Size(m)
+}
+func (m *LoadDataRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LoadDataRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LoadDataRequest proto.InternalMessageInfo
+
+func (m *LoadDataRequest) GetInputFile() string {
+	if m != nil {
+		return m.InputFile
+	}
+	return ""
+}
+
+func (m *LoadDataRequest) GetScanQuota() int32 {
+	if m != nil {
+		return m.ScanQuota
+	}
+	return 0
+}
+
+func (m *LoadDataRequest) GetMode() int32 {
+	if m != nil {
+		return m.Mode
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("sftpgo.admin.DisconnectMode", DisconnectMode_name, DisconnectMode_value)
+	proto.RegisterType((*ApiResponse)(nil), "sftpgo.admin.ApiResponse")
+	proto.RegisterType((*Permissions)(nil), "sftpgo.admin.Permissions")
+	proto.RegisterType((*VirtualFolder)(nil), "sftpgo.admin.VirtualFolder")
+	proto.RegisterType((*ExtensionsFilter)(nil), "sftpgo.admin.ExtensionsFilter")
+	proto.RegisterType((*UserFilters)(nil), "sftpgo.admin.UserFilters")
+	proto.RegisterType((*IngestionFolder)(nil), "sftpgo.admin.IngestionFolder")
+	proto.RegisterType((*S3Config)(nil), "sftpgo.admin.S3Config")
+	proto.RegisterType((*StorageClassRule)(nil), "sftpgo.admin.StorageClassRule")
+	proto.RegisterType((*GCSConfig)(nil), "sftpgo.admin.GCSConfig")
+	proto.RegisterType((*CryptConfig)(nil), "sftpgo.admin.CryptConfig")
+	proto.RegisterType((*WebDAVConfig)(nil), "sftpgo.admin.WebDAVConfig")
+	proto.RegisterType((*HDFSConfig)(nil), "sftpgo.admin.HDFSConfig")
+	proto.RegisterType((*GoogleDriveConfig)(nil), "sftpgo.admin.GoogleDriveConfig")
+	proto.RegisterType((*DropboxConfig)(nil), "sftpgo.admin.DropboxConfig")
+	proto.RegisterType((*Filesystem)(nil), "sftpgo.admin.Filesystem")
+	proto.RegisterType((*User)(nil), "sftpgo.admin.User")
+	proto.RegisterMapType((map[string]*Permissions)(nil), "sftpgo.admin.User.PermissionsEntry")
+	proto.RegisterType((*GetUsersRequest)(nil), "sftpgo.admin.GetUsersRequest")
+	proto.RegisterType((*GetUsersResponse)(nil), "sftpgo.admin.GetUsersResponse")
+	proto.RegisterType((*GetUserRequest)(nil), "sftpgo.admin.GetUserRequest")
+	proto.RegisterType((*AddUserRequest)(nil), "sftpgo.admin.AddUserRequest")
+	proto.RegisterType((*UpdateUserRequest)(nil), "sftpgo.admin.UpdateUserRequest")
+	proto.RegisterType((*DeleteUserRequest)(nil), "sftpgo.admin.DeleteUserRequest")
+	proto.RegisterType((*Transfer)(nil), "sftpgo.admin.Transfer")
+	proto.RegisterType((*Connection)(nil), "sftpgo.admin.Connection")
+	proto.RegisterType((*GetConnectionsRequest)(nil), "sftpgo.admin.GetConnectionsRequest")
+	proto.RegisterType((*GetConnectionsResponse)(nil), "sftpgo.admin.GetConnectionsResponse")
+	proto.RegisterType((*CloseConnectionRequest)(nil), "sftpgo.admin.CloseConnectionRequest")
+	proto.RegisterType((*UpdateConnectionLabelRequest)(nil), "sftpgo.admin.UpdateConnectionLabelRequest")
+	proto.RegisterType((*QuotaScan)(nil), "sftpgo.admin.QuotaScan")
+	proto.RegisterType((*GetQuotaScansRequest)(nil), "sftpgo.admin.GetQuotaScansRequest")
+	proto.RegisterType((*GetQuotaScansResponse)(nil), "sftpgo.admin.GetQuotaScansResponse")
+	proto.RegisterType((*StartQuotaScanRequest)(nil), "sftpgo.admin.StartQuotaScanRequest")
+	proto.RegisterType((*DumpDataRequest)(nil), "sftpgo.admin.DumpDataRequest")
+	proto.RegisterType((*LoadDataRequest)(nil), "sftpgo.admin.LoadDataRequest")
+}
+
+func init() {
+	proto.RegisterFile("httpd/adminpb/admin.proto", fileDescriptor_0e010c956a837cc4)
+}
+
+var fileDescriptor_0e010c956a837cc4 = []byte{
+	// 2750 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x59, 0xcd, 0x72, 0x1b, 0xc7,
+	0xf1, 0xff, 0x03, 0x20, 0x48, 0xa0, 0x41, 0x02, 0xe0, 0x98, 0xa2, 0x57, 0x94, 0x25, 0xf1, 0xbf,
+	0x4a, 0x6c, 0x46, 0x89, 0xc4, 0x98, 0x4a, 0xaa, 0x54, 0xb6, 0x93, 0x2a, 0x9a, 0x10, 0x65, 0x5a,
+	0xb2, 0xa4, 0x2c, 0x68, 0x25, 0x4e, 0xaa, 0xb2, 0x35, 0xd8, 0x1d, 0x00, 0x13, 0x2e, 0x76, 0xd6,
+	0x33, 0x03, 0x4a, 0xf0, 0x31, 0x87, 0x9c, 0x72, 0xc8, 0x2b, 0x24, 0xb7, 0x3c, 0x40, 0xaa, 0x92,
+	0x5b, 0x9e, 0x21, 0x2f, 0xe2, 0x4b, 0x1e, 0x20, 0x35, 0x1f, 0xfb, 0x09, 0x98, 0x2e, 0x5b, 0x27,
+	0x62, 0x7e, 0xdd, 0x3d, 0xd3, 0xdd, 0xd3, 0x5f, 0xb3, 0x84, 0xeb, 0x53, 0x29, 0x93, 0xf0, 0x10,
+	0x87, 0x33, 0x1a, 0x27, 0x23, 0xf3, 0xf7, 0x7e, 0xc2, 0x99, 0x64, 0x68, 0x53, 0x8c, 0x65, 0x32,
+	0x61, 0xf7, 0x35, 0xe6, 0xbe, 0x07, 0x9d, 0xe3, 0x84, 0x7a, 0x44, 0x24, 0x2c, 0x16, 0x04, 0x39,
+	0xb0, 0x31, 0x23, 0x42, 0xe0, 0x09, 0x71, 0x6a, 0xfb, 0xb5, 0x83, 0xb6, 0x97, 0x2e, 0xdd, 0x43,
+	0xe8, 0xbc, 0x20, 0x7c, 0x46, 0x85, 0xa0, 0x2c, 0x16, 0x68, 0x1f, 0x3a, 0x49, 0xbe, 0x74, 0x6a,
+	0xfb, 0x8d, 0x83, 0xb6, 0x57, 0x84, 0xdc, 0xbf, 0xd6, 0x60, 0xeb, 0x25, 0xe5, 0x72, 0x8e, 0xa3,
+	0x53, 0x16, 0x85, 0x84, 0xa3, 0xff, 0x87, 0xcd, 0x4b, 0x03, 0xf8, 0x09, 0x96, 0x53, 0x7b, 0x42,
+	0xc7, 0x62, 0x2f, 0xb0, 0x9c, 0xa2, 0xdb, 0xd0, 0x99, 0xe1, 0x24, 0x21, 0xa1, 0xe1, 0xa8, 0x6b,
+	0x0e, 0x30, 0x90, 0x66, 0x78, 0x08, 0x30, 0xa6, 0x11, 0x11, 0x0b, 0x21, 0xc9, 0xcc, 0x69, 0xec,
+	0xd7, 0x0e, 0x3a, 0x47, 0xce, 0xfd, 0xa2, 0x49, 0xf7, 0x4f, 0x33, 0xba, 0x57, 0xe0, 0x45, 0x08,
+	0xd6, 0x62, 0x3c, 0x23, 0xce, 0x9a, 0xde, 0x53, 0xff, 0x76, 0xff, 0x58, 0x83, 0xfe, 0xa3, 0xd7,
+	0x92, 0xc4, 0x5a, 0xe5, 0x53, 0x1a, 0x49, 0xc2, 0x15, 0x63, 0x41, 0x3d, 0xfd, 0x1b, 0xdd, 0x03,
+	0x84, 0xa3, 0x88, 0xbd, 0x22, 0xa1, 0x4f, 0x32, 0x7e, 0xa7, 0xae, 0xad, 0xde, 0xb6, 0x94, 0x7c,
+	0x23, 0xf4, 0x63, 0xd8, 0x0e, 0x49, 0x4c, 0xcb, 0xdc, 0x0d, 0xcd, 0xdd, 0x37, 0x84, 0x9c, 0xd9,
+	0xfd, 0xcb, 0x1a, 0x74, 0x3e, 0x17, 0x84, 0x9b, 0xe3, 0x05, 0xba, 0x09, 0x90, 0x9e, 0x45, 0x13,
+	0xeb, 0xd9, 0xb6, 0x45, 0xce, 0x12, 0x74, 0x03, 0xda, 0x76, 0x6f, 0x9a, 0x58, 0x0d, 0x5a, 0x06,
+	0x38, 0x4b, 0xd0, 0x4f, 0x61, 0xc7, 0x12, 0x23, 0x36, 0xa1, 0xb1, 0x3f, 0x23, 0x72, 0xca, 0xc2,
+	0xf4, 0x6c, 0x64, 0x68, 0x4f, 0x15, 0xe9, 0x33, 0x43, 0x41, 0x8f, 0xa1, 0xa7, 0x9c, 0x54, 0x54,
+	0x74, 0x6d, 0xbf, 0x71, 0xd0, 0x39, 0xba, 0x55, 0xf6, 0x6a, 0xd5, 0x4d, 0x5e, 0x57, 0x89, 0x15,
+	0x6c, 0x7e, 0x08, 0x0e, 0x27, 0x97, 0xec, 0x82, 0x84, 0xfe, 0x05, 0x59, 0xf8, 0x63, 0x1a, 0x4f,
+	0x08, 0x4f, 0x38, 0x8d, 0xa5, 0x70, 0x9a, 0xfa, 0xf8, 0x5d, 0x4b, 0x7f, 0x42, 0x16, 0xa7, 0x05,
+	0x2a, 0xfa, 0x19, 0xec, 0xa6, 0x06, 0x2b, 0x49, 0x1c, 0x4d, 0x18, 0xa7, 0x72, 0x3a, 0x13, 0xce,
+	0xba, 0x96, 0xdb, 0xb1, 0xd4, 0x27, 0x64, 0x71, 0x9c, 0xd1, 0xd0, 0x7b, 0xd0, 0x9f, 0xd1, 0xd8,
+	0xe7, 0x02, 0x6b, 0x29, 0x41, 0xbf, 0x22, 0xce, 0xc6, 0x7e, 0xed, 0xa0, 0xe9, 0x6d, 0xcd, 0x68,
+	0xec, 0x09, 0xfc, 0x84, 0x2c, 0x86, 0xf4, 0x2b, 0x82, 0x3e, 0x85, 0x6d, 0x75, 0x9a, 0x90, 0x94,
+	0xc5, 0xfe, 0x58, 0x87, 0xa2, 0x70, 0x5a, 0xda, 0xc6, 0x9b, 0x65, 0x1b, 0xcf, 0x52, 0x36, 0x13,
+	0xb0, 0x5e, 0x9f, 0x96, 0x01, 0x81, 0x76, 0x61, 0x5d, 0x92, 0x18, 0xc7, 0xd2, 0x69, 0xeb, 0xe8,
+	0xb0, 0x2b, 0x75, 0x29, 0x9c, 0xe0, 0xd0, 0x67, 0x71, 0xb4, 0x70, 0x60, 0xbf, 0x76, 0xd0, 0xf2,
+	0x5a, 0x0a, 0x78, 0x1e, 0x47, 0x0b, 0x75, 0x29, 0xa9, 0x7d, 0x42, 0x4c, 0xfd, 0x80, 0xcd, 0x66,
+	0x38, 0x0e, 0x85, 0xd3, 0x31, 0x97, 0x62, 0x69, 0x43, 0x31, 0x3d, 0xb1, 0x14, 0xf7, 0x53, 0xe8,
+	0x55, 0x74, 0x59, 0x19, 0x95, 0x77, 0x60, 0x6b, 0xca, 0xe6, 0x3c, 0x5a, 0xf8, 0x9c, 0x45, 0xd1,
+	0x3c, 0xd1, 0xf9, 0xd2, 0xf2, 0x36, 0x0d, 0xe8, 0x69, 0xcc, 0xfd, 0x67, 0x13, 0x5a, 0xc3, 0x07,
+	0x27, 0x2c, 0x1e, 0xd3, 0x89, 0xd2, 0x7f, 0x34, 0x0f, 0x2e, 0x88, 0xb4, 0xfb, 0xd8, 0x95, 0x8a,
+	0x39, 0xe5, 0xc4, 0x84, 0x93, 0x31, 0x7d, 0x6d, 0xd3, 0xae, 0x7d, 0x41, 0x16, 0x2f, 0x34, 0xa0,
+	0xc4, 0x38, 0x99, 0x50, 0x16, 0xeb, 0x8c, 0x6b, 0x7b, 0x76, 0xa5, 0x43, 0x35, 0x08, 0x88, 0x10,
+	0xea, 0x0a, 0x6c, 0x66, 0xb5, 0x0d, 0xf2, 0x84, 0x2c, 0x94, 0x7e, 0x96, 0x2c, 0x48, 0xc0, 0x89,
+	0x74, 0x9a, 0x9a, 0x63, 0xd3, 0x80, 0x43, 0x8d, 0xa1, 0x3d, 0x68, 0x91, 0x38, 0x4c, 0x18, 0x8d,
+	0xa5, 0xb3, 0xae, 0xe9, 0xd9, 0x5a, 0x6d, 0x20, 0x24, 0xe3, 0x78, 0x42, 0xfc, 0x20, 0xc2, 0x42,
+	0xe8, 0x0b, 0x6e, 0x7b, 0x9b, 0x16, 0x3c, 0x51, 0x18, 0x3a, 0x80, 0xfe, 0x3c, 0x89, 0x18, 0x56,
+	0x35, 0x83, 0x4b, 0x13, 0x08, 0xad, 0xfd, 0xda, 0x41, 0xc3, 0xeb, 0x1a, 0xfc, 0x05, 0xe6, 0x52,
+	0x47, 0xc2, 0x3d, 0x40, 0x96, 0x33, 0x60, 0x71, 0x30, 0xe7, 0x9c, 0xc4, 0xc1, 0x42, 0xdf, 0x64,
+	0xd3, 0xdb, 0x36, 0x94, 0x93, 0x9c, 0x80, 0x9e, 0xc1, 0x5b, 0xa5, 0xd3, 0x7d, 0x3e, 0x8f, 0x88,
+	0x70, 0x60, 0x55, 0x7a, 0x0c, 0x0b, 0x1a, 0x79, 0xf3, 0x88, 0x78, 0xdb, 0xa2, 0x82, 0x08, 0x6d,
+	0x0d, 0xd1, 0xd5, 0xd1, 0x97, 0xec, 0x82, 0xc4, 0x4e, 0xc7, 0x5a, 0x63, 0xc0, 0x73, 0x85, 0xa1,
+	0xeb, 0xd0, 0xe2, 0x2c, 0x22, 0x3e, 0xe6, 0xb1, 0xb3, 0x69, 0x4a, 0xb0, 0x5a, 0x1f, 0xf3, 0x58,
+	0x15, 0x47, 0x95, 0xa5, 0x3c, 0xc6, 0x91, 0x4f, 0x43, 0x67, 0x4b, 0x53, 0x21, 0x85, 0xce, 0x42,
+	0xe5, 0x89, 0x31, 0xe3, 0x01, 0xd1, 0xc5, 0xd3, 0x17, 0x72, 0x11, 0x11, 0xa7, 0xab, 0x43, 0xa2,
+	0xab, 0x71, 0x55, 0x41, 0x87, 0x0a, 0x45, 0xef, 0x42, 0x4f, 0x5c, 0xd0, 0xc4, 0x97, 0x91, 0xf0,
+	0x2f, 0x09, 0xa7, 0xe3, 0x85, 0xd3, 0xd3, 0x8c, 0x5b, 0x0a, 0x3e, 0x8f, 0xc4, 0x4b, 0x0d, 0xaa,
+	0xb8, 0x0e, 0xb0, 0x3f, 0x9a, 0xc7, 0x61, 0x44, 0x9c, 0xbe, 0xb9, 0x9d, 0x00, 0x7f, 0xac, 0xd7,
+	0xe8, 0x27, 0x80, 0x42, 0xf6, 0x2a, 0xae, 0xb8, 0x7e, 0x5b, 0xbb, 0xbe, 0x9f, 0x52, 0x32, 0xe7,
+	0xbf, 0x0f, 0x3b, 0x19, 0x77, 0xd1, 0xfd, 0x48, 0xbb, 0xff, 0xad, 0x94, 0x56, 0xb8, 0x00, 0xf7,
+	0x4f, 0x35, 0xe8, 0x57, 0x1d, 0xbb, 0x32, 0x11, 0x6e, 0x01, 0x2c, 0x95, 0xe5, 0x02, 0xa2, 0x9c,
+	0xaa, 0x6a, 0x85, 0xd6, 0xaf, 0xa1, 0xf5, 0xdb, 0x98, 0xd1, 0x58, 0xab, 0xb5, 0x14, 0x62, 0x6b,
+	0xcb, 0x21, 0xe6, 0x7e, 0x5d, 0x87, 0xf6, 0xe3, 0x93, 0xe1, 0x9b, 0x25, 0xd1, 0x3e, 0x74, 0x02,
+	0x4e, 0x42, 0x12, 0x4b, 0x8a, 0x23, 0x61, 0x33, 0xa9, 0x08, 0xa1, 0x07, 0x70, 0x0d, 0xcf, 0x25,
+	0x9b, 0x61, 0x49, 0x03, 0xbf, 0xc8, 0xbb, 0xa6, 0x7d, 0xb4, 0x93, 0x11, 0x4f, 0x0a, 0x42, 0x4b,
+	0x06, 0x34, 0x57, 0xe4, 0xc8, 0x37, 0x84, 0xf2, 0xfa, 0xf7, 0x0d, 0xe5, 0xd5, 0x57, 0xbf, 0xf1,
+	0x1d, 0xaf, 0xbe, 0xf5, 0xcd, 0x57, 0x7f, 0x0f, 0x3a, 0x27, 0x7c, 0x91, 0x48, 0xeb, 0xf2, 0x5b,
+	0x00, 0x09, 0x16, 0x22, 0x99, 0x72, 0x2c, 0xd2, 0xd1, 0xa4, 0x80, 0xb8, 0x7f, 0xab, 0xc1, 0xe6,
+	0xaf, 0xc9, 0x68, 0x70, 0xfc, 0xd2, 0x0a, 0x14, 0xab, 0x4a, 0xad, 0x52, 0x55, 0xf6, 0xa0, 0x35,
+	0x17, 0x2a, 0x67, 0x66, 0xc4, 0xde, 0x52, 0xb6, 0x56, 0x34, 0xb5, 0xed, 0x2b, 0xc6, 0x43, 0x7b,
+	0x43, 0xd9, 0x5a, 0xcd, 0x2f, 0x23, 0x82, 0x39, 0xe1, 0x36, 0x7d, 0x4d, 0xa4, 0x74, 0x0c, 0x66,
+	0xb2, 0x57, 0xf5, 0x01, 0xc6, 0xa4, 0x99, 0x5e, 0xcc, 0x45, 0xb4, 0x14, 0xa0, 0x32, 0xcf, 0xfd,
+	0x73, 0x0d, 0xe0, 0x93, 0xc1, 0xe9, 0xf0, 0x0d, 0x55, 0xfc, 0x11, 0xf4, 0x43, 0x12, 0x91, 0x09,
+	0x96, 0x79, 0x25, 0x31, 0xaa, 0xf6, 0x72, 0x7c, 0x85, 0x3a, 0x6b, 0x15, 0x75, 0xbe, 0xae, 0xc1,
+	0xf6, 0x63, 0xc6, 0x26, 0x11, 0x19, 0x70, 0x7a, 0x49, 0xac, 0x56, 0x37, 0xa0, 0x6d, 0x7a, 0xa4,
+	0x2a, 0x31, 0x56, 0x2d, 0x03, 0x9c, 0x85, 0xd5, 0x10, 0xae, 0x2f, 0x87, 0xb0, 0x03, 0x1b, 0x62,
+	0x3e, 0xfa, 0x03, 0x09, 0xa4, 0xd5, 0x29, 0x5d, 0xea, 0x52, 0x12, 0x51, 0x12, 0x4b, 0xb5, 0xb1,
+	0xd5, 0xc5, 0x00, 0x67, 0xa1, 0x0a, 0x62, 0x4b, 0x2c, 0x77, 0x0a, 0x03, 0xda, 0x4e, 0x71, 0x07,
+	0xb6, 0x38, 0x19, 0x73, 0x22, 0xa6, 0xd6, 0x6a, 0xd3, 0x2e, 0x36, 0x2d, 0x68, 0x4c, 0x2e, 0x7a,
+	0x75, 0xa3, 0xec, 0x55, 0xf7, 0xbf, 0x35, 0xd8, 0x1a, 0x70, 0x96, 0x8c, 0xd8, 0xeb, 0xdc, 0xda,
+	0xdc, 0x41, 0xb5, 0xb2, 0x83, 0xd4, 0x7d, 0xdb, 0xf6, 0x65, 0x8e, 0xb3, 0xe6, 0x1a, 0xcc, 0x9c,
+	0xb6, 0xa4, 0x52, 0x63, 0x85, 0x4a, 0x6f, 0xc3, 0x06, 0x4e, 0x92, 0x42, 0x8b, 0x5c, 0xc7, 0x49,
+	0xa2, 0xfa, 0xa3, 0x6a, 0x9f, 0x49, 0x52, 0x36, 0xb9, 0x8d, 0x93, 0xc4, 0xda, 0x7b, 0x17, 0xb6,
+	0xd3, 0x76, 0x35, 0x9d, 0xc7, 0x17, 0x26, 0xc7, 0xd6, 0x75, 0x8e, 0xf5, 0x6c, 0xb7, 0x52, 0xb8,
+	0x4e, 0xb1, 0xab, 0xcc, 0xfe, 0x4f, 0x03, 0x20, 0x1f, 0x8a, 0x75, 0x88, 0x73, 0x76, 0x49, 0x43,
+	0xc2, 0xb5, 0xc9, 0x4d, 0x2f, 0x5b, 0xa3, 0x23, 0x68, 0x89, 0x07, 0x81, 0xf6, 0x8d, 0x36, 0xb7,
+	0x73, 0xb4, 0x5b, 0x29, 0x0e, 0x76, 0x92, 0xf0, 0x32, 0x3e, 0xf4, 0x73, 0x68, 0x4f, 0x02, 0x61,
+	0x85, 0xcc, 0x44, 0xfe, 0x76, 0x59, 0x28, 0x2b, 0x9d, 0x5e, 0xce, 0x89, 0x3e, 0x54, 0xb1, 0xb4,
+	0x48, 0xa4, 0x15, 0x5c, 0xd3, 0x82, 0xd7, 0xcb, 0x82, 0x85, 0x12, 0xe0, 0x15, 0xb9, 0xd1, 0x2f,
+	0x61, 0xf3, 0x15, 0x19, 0x85, 0xf8, 0xd2, 0x4a, 0x37, 0xb5, 0xf4, 0x5e, 0x59, 0xba, 0x58, 0x10,
+	0xbc, 0x12, 0xbf, 0x7a, 0x46, 0x4c, 0xc3, 0x71, 0xaa, 0xf4, 0xfa, 0xaa, 0x67, 0x44, 0x9e, 0xa9,
+	0x5e, 0x81, 0x17, 0x9d, 0xc0, 0xe6, 0x24, 0x54, 0xf9, 0x62, 0x65, 0x37, 0xb4, 0xec, 0xed, 0x8a,
+	0xc1, 0xd5, 0xb4, 0xf2, 0x4a, 0x42, 0xe8, 0x18, 0xb6, 0x42, 0x13, 0x87, 0x76, 0x97, 0x96, 0xde,
+	0xe5, 0x46, 0x79, 0x97, 0x52, 0xa8, 0x7a, 0x65, 0x09, 0xf7, 0x1f, 0x2d, 0x58, 0x53, 0xaf, 0x06,
+	0xd4, 0x85, 0xba, 0xcd, 0xd4, 0x86, 0x57, 0xa7, 0xa1, 0xea, 0x4e, 0x42, 0x62, 0x39, 0x37, 0xe9,
+	0xd9, 0xf4, 0xec, 0xaa, 0x54, 0x52, 0x1a, 0x95, 0x92, 0xf2, 0x1e, 0xf4, 0xc8, 0xeb, 0x84, 0x72,
+	0x53, 0x52, 0x42, 0x2c, 0xcd, 0x33, 0xa9, 0xe1, 0x75, 0x73, 0x78, 0x80, 0x65, 0xb9, 0x3c, 0x36,
+	0x2b, 0xe5, 0xf1, 0x36, 0x74, 0x92, 0xf9, 0x28, 0xa2, 0x81, 0x8a, 0xf4, 0x74, 0x76, 0x07, 0x03,
+	0x3d, 0x21, 0x0b, 0xdd, 0x85, 0xa7, 0x6c, 0x46, 0xfc, 0x90, 0x72, 0x1b, 0xa3, 0x1b, 0x6a, 0x3d,
+	0xa0, 0x1c, 0x0d, 0xa0, 0x97, 0x3e, 0x0d, 0xcb, 0x13, 0x7a, 0xc5, 0x25, 0xa5, 0x07, 0xa5, 0xd7,
+	0xbd, 0x2c, 0x2e, 0x05, 0xea, 0x43, 0x63, 0x4e, 0x43, 0x3b, 0xd0, 0xa9, 0x9f, 0x0a, 0x99, 0xd0,
+	0x50, 0x4f, 0xe4, 0x4d, 0x4f, 0xfd, 0x54, 0x49, 0x3d, 0xc3, 0xaf, 0x7d, 0x3b, 0x73, 0x09, 0x3d,
+	0x83, 0x35, 0xbd, 0xce, 0x0c, 0xbf, 0x1e, 0x5a, 0x48, 0xa5, 0xe5, 0x97, 0x73, 0x26, 0xb1, 0x49,
+	0xb8, 0x4d, 0xed, 0x88, 0xb6, 0x46, 0x74, 0xaa, 0xdd, 0x86, 0x8e, 0x21, 0xeb, 0xc7, 0xa5, 0x1e,
+	0xc3, 0x9a, 0x9e, 0x91, 0xd0, 0x59, 0x86, 0x1e, 0x95, 0xdf, 0xc6, 0x5d, 0x6d, 0xc8, 0x9d, 0xb2,
+	0x21, 0xea, 0xea, 0xee, 0x17, 0x1e, 0xd4, 0x8f, 0x62, 0xc9, 0x17, 0xa5, 0x07, 0xb4, 0x9a, 0xd1,
+	0xe6, 0x82, 0x84, 0x7e, 0x41, 0x97, 0x9e, 0xd6, 0x65, 0x4b, 0xc1, 0xbf, 0xca, 0xf4, 0x51, 0xf3,
+	0x6f, 0xce, 0x67, 0x94, 0xea, 0x6b, 0xa5, 0xba, 0x19, 0xa3, 0x51, 0xec, 0x2e, 0x6c, 0x47, 0x58,
+	0x48, 0xcb, 0x39, 0x4f, 0xf4, 0x45, 0x9b, 0x79, 0xad, 0xa7, 0x08, 0x9a, 0xf5, 0x73, 0x0d, 0xab,
+	0x2e, 0x63, 0x8b, 0xcf, 0x08, 0xc7, 0xe1, 0x2b, 0x1a, 0xca, 0xa9, 0x83, 0x8a, 0xb5, 0xe7, 0xe3,
+	0x14, 0x56, 0x63, 0x75, 0xd6, 0xde, 0x73, 0xe6, 0xb7, 0x34, 0xf3, 0x76, 0x4a, 0xc9, 0xd9, 0x6f,
+	0x02, 0x68, 0x2d, 0xf4, 0x0b, 0xd5, 0xd9, 0x31, 0xee, 0x55, 0x88, 0x7e, 0x97, 0xa2, 0x07, 0xb0,
+	0x31, 0x36, 0x2f, 0x61, 0xe7, 0xda, 0xaa, 0x9a, 0x50, 0x78, 0x2a, 0x7b, 0x29, 0x67, 0xe5, 0xb3,
+	0xc0, 0xee, 0x77, 0xfb, 0x2c, 0x90, 0x44, 0x38, 0x76, 0xde, 0xb6, 0xe3, 0x64, 0x84, 0x63, 0x95,
+	0x0e, 0x38, 0x0c, 0xa9, 0x8a, 0x7a, 0x35, 0x6a, 0xc7, 0x63, 0xe6, 0x38, 0x9a, 0xdc, 0xcd, 0xe1,
+	0xb3, 0x78, 0xcc, 0x94, 0x29, 0x01, 0x27, 0x58, 0x92, 0xd0, 0xc7, 0xd2, 0xb9, 0x6e, 0x4c, 0xb1,
+	0xc8, 0xb1, 0x1e, 0x08, 0x8d, 0x93, 0x35, 0x79, 0xcf, 0x90, 0x2d, 0x72, 0x2c, 0x55, 0xaf, 0xbc,
+	0x24, 0x5c, 0x5d, 0xb6, 0x73, 0xc3, 0x0c, 0xa5, 0x76, 0xb9, 0xf7, 0x05, 0xf4, 0xab, 0xb1, 0xa1,
+	0x42, 0x59, 0x75, 0x10, 0xd3, 0xa4, 0xd4, 0x4f, 0x74, 0x08, 0xcd, 0x4b, 0x1c, 0xcd, 0x89, 0x53,
+	0x5f, 0xe5, 0xa7, 0xc2, 0x06, 0x9e, 0xe1, 0xfb, 0xa0, 0xfe, 0xb0, 0xe6, 0x7e, 0x09, 0xbd, 0xc7,
+	0x44, 0x2a, 0x27, 0x0a, 0x8f, 0x7c, 0x39, 0x27, 0x42, 0xa2, 0x1d, 0x68, 0x46, 0x74, 0x46, 0xa5,
+	0xed, 0x06, 0x66, 0xa1, 0xea, 0x08, 0x1b, 0x8f, 0x05, 0x91, 0x69, 0x1d, 0x31, 0x2b, 0xc5, 0xcd,
+	0xb8, 0xea, 0x1d, 0xa6, 0x88, 0x98, 0x45, 0xa9, 0xba, 0xac, 0x95, 0xab, 0x8b, 0xfb, 0x11, 0xf4,
+	0xf3, 0x23, 0xed, 0x87, 0xa6, 0x03, 0x68, 0x2a, 0xba, 0xf9, 0x72, 0xd4, 0x39, 0x42, 0xcb, 0x77,
+	0xec, 0x19, 0x06, 0x77, 0x1f, 0xba, 0x56, 0x3a, 0xd5, 0xb7, 0x52, 0xf1, 0xdc, 0x87, 0xd0, 0x3d,
+	0x0e, 0xc3, 0x22, 0xc7, 0xbb, 0xb0, 0xa6, 0x84, 0x35, 0xcf, 0xea, 0xcd, 0x35, 0xdd, 0x5d, 0xc0,
+	0xb6, 0x09, 0xf7, 0xef, 0x21, 0x8c, 0x3e, 0x02, 0x08, 0xa9, 0x6a, 0x0b, 0x31, 0x09, 0x8c, 0x93,
+	0xba, 0x47, 0xef, 0x54, 0x2a, 0x78, 0x46, 0xff, 0x8c, 0x85, 0xc4, 0x2b, 0xf0, 0xbb, 0x18, 0xb6,
+	0x07, 0x24, 0x22, 0x92, 0x5c, 0x61, 0xd9, 0x1b, 0x1e, 0xf1, 0xaf, 0x1a, 0xb4, 0xce, 0x39, 0x8e,
+	0xc5, 0x98, 0x70, 0xf4, 0x43, 0xe8, 0xb2, 0x84, 0xd8, 0x0a, 0x2f, 0x17, 0x49, 0x3a, 0x45, 0x6f,
+	0x65, 0xe8, 0xf9, 0x22, 0xc9, 0x5f, 0x57, 0xf5, 0xc2, 0xeb, 0xea, 0x26, 0x80, 0x90, 0x6a, 0xc8,
+	0x97, 0x74, 0x96, 0xbe, 0x9f, 0xda, 0x1a, 0x39, 0xa7, 0x33, 0x2d, 0xa2, 0x8b, 0x93, 0xe9, 0x18,
+	0xfa, 0xb7, 0x9a, 0x8b, 0x74, 0x8e, 0xe3, 0x40, 0xd2, 0x4b, 0x2a, 0x17, 0xba, 0x59, 0x34, 0xbc,
+	0x4d, 0x05, 0x1e, 0x5b, 0x4c, 0xc5, 0x4c, 0x48, 0x26, 0x1c, 0x87, 0x24, 0xd4, 0x2d, 0xb8, 0xe5,
+	0x65, 0x6b, 0xf7, 0xdf, 0x0d, 0x80, 0x13, 0x63, 0x07, 0x65, 0x71, 0x29, 0xbc, 0x6a, 0x95, 0xe6,
+	0xa5, 0x66, 0xc7, 0x8c, 0x53, 0x0d, 0x97, 0x75, 0x3b, 0x3b, 0x66, 0xe0, 0x59, 0xa8, 0xcc, 0xb7,
+	0x03, 0x66, 0x9a, 0x72, 0x26, 0x7c, 0xed, 0xd8, 0xf9, 0xd2, 0x80, 0x8a, 0x8d, 0x93, 0x19, 0x93,
+	0xc4, 0xc7, 0x61, 0xc8, 0x49, 0xf6, 0x1c, 0xdc, 0x32, 0xe8, 0xb1, 0x01, 0x55, 0x81, 0x28, 0x1c,
+	0xa9, 0xdd, 0x62, 0x0c, 0xec, 0xe6, 0xb0, 0xf6, 0xcd, 0x92, 0x1f, 0xd6, 0x57, 0xfb, 0x41, 0x7f,
+	0x9a, 0x0d, 0x58, 0x94, 0xce, 0x6e, 0xe9, 0x1a, 0x1d, 0x43, 0x5f, 0xcb, 0x12, 0x5f, 0xda, 0x9b,
+	0x4c, 0x3b, 0x63, 0x65, 0x30, 0x4b, 0x2f, 0xda, 0xeb, 0x19, 0xfe, 0x74, 0x2d, 0x54, 0xbf, 0x2a,
+	0x7c, 0x76, 0xb2, 0x1f, 0xae, 0x40, 0x64, 0x9f, 0x9b, 0xb4, 0x35, 0x76, 0xf8, 0x66, 0x63, 0xf9,
+	0x0a, 0x73, 0xa2, 0x1b, 0x66, 0xdb, 0xb3, 0x2e, 0x1b, 0x5a, 0xb4, 0xf0, 0xf5, 0xab, 0x53, 0xfa,
+	0xfa, 0xa5, 0x0a, 0x08, 0x1e, 0x91, 0xc8, 0x7e, 0xb0, 0x30, 0x0b, 0x37, 0x86, 0x6b, 0x8f, 0x89,
+	0xcc, 0x2f, 0x31, 0xab, 0x37, 0x2b, 0xce, 0xab, 0x7d, 0xcb, 0x79, 0xf5, 0xd5, 0xe7, 0x35, 0x8a,
+	0xe7, 0x9d, 0xc3, 0x6e, 0xf5, 0x3c, 0x5b, 0x6c, 0x3e, 0x80, 0x4e, 0x7e, 0x2f, 0x69, 0xc9, 0xa9,
+	0xb4, 0x87, 0x5c, 0xce, 0x2b, 0x32, 0xbb, 0xbf, 0x80, 0xdd, 0x93, 0x88, 0x09, 0x52, 0xa0, 0x5b,
+	0x33, 0x96, 0xe2, 0xae, 0xb6, 0x1c, 0x77, 0xee, 0x17, 0xf0, 0x8e, 0xa9, 0x30, 0xb9, 0xfc, 0x53,
+	0xa5, 0xed, 0x77, 0xd9, 0x24, 0xb7, 0xb7, 0x5e, 0xb4, 0xf7, 0x14, 0xda, 0x66, 0x08, 0x08, 0xf0,
+	0xd5, 0x09, 0x52, 0xce, 0xdf, 0x7a, 0x25, 0x7f, 0xdd, 0x5d, 0xd8, 0x79, 0x4c, 0x64, 0xb6, 0x55,
+	0x7a, 0x4d, 0xee, 0x29, 0x5c, 0xab, 0xe0, 0xd6, 0x9d, 0xf7, 0xa0, 0x29, 0x02, 0x9c, 0x39, 0xb2,
+	0x32, 0xec, 0x67, 0x02, 0x9e, 0xe1, 0x72, 0x1f, 0xc0, 0xb5, 0xa1, 0x3a, 0x2c, 0x27, 0x58, 0xdb,
+	0xaf, 0xd0, 0x59, 0x7d, 0x01, 0x1d, 0xcc, 0x67, 0xc9, 0x00, 0x4b, 0x9c, 0xb2, 0xdf, 0x86, 0x0e,
+	0x9b, 0xcb, 0x64, 0x2e, 0xf5, 0x8c, 0x63, 0x25, 0xc0, 0x40, 0xaa, 0xb9, 0xab, 0x70, 0xa1, 0x71,
+	0x48, 0x6c, 0xb8, 0xb4, 0x3c, 0xbb, 0x72, 0x03, 0xe8, 0x3d, 0x65, 0x38, 0x2c, 0xee, 0x75, 0x13,
+	0x80, 0xc6, 0x95, 0xad, 0xda, 0x34, 0x4e, 0x77, 0x52, 0x1e, 0x0b, 0x70, 0x6c, 0x06, 0x25, 0xdb,
+	0xff, 0xda, 0x0a, 0xd1, 0x36, 0xa8, 0x8a, 0x37, 0x63, 0xa1, 0x29, 0x85, 0x4d, 0x4f, 0xff, 0xbe,
+	0xfb, 0x1c, 0xba, 0xe5, 0x52, 0x8c, 0x76, 0x01, 0x0d, 0xce, 0x86, 0x27, 0xcf, 0x9f, 0x3d, 0x7b,
+	0x74, 0x72, 0xee, 0x0f, 0x1e, 0x9d, 0x1e, 0x7f, 0xfe, 0xf4, 0xbc, 0xff, 0x7f, 0x08, 0x41, 0xb7,
+	0x80, 0x7f, 0xf1, 0x68, 0xd8, 0xaf, 0xa1, 0x6d, 0xd8, 0x2a, 0x60, 0xcf, 0x9e, 0xf7, 0xeb, 0x47,
+	0x7f, 0xdf, 0x80, 0xe6, 0xb1, 0xf2, 0x28, 0x3a, 0x83, 0x56, 0xda, 0x3f, 0x51, 0xe5, 0x8b, 0x75,
+	0xa5, 0x95, 0xef, 0xdd, 0xfa, 0x26, 0xb2, 0xbd, 0xba, 0x0f, 0x61, 0xc3, 0x62, 0xe8, 0x9d, 0x95,
+	0xac, 0xe9, 0x46, 0x2b, 0xda, 0x9e, 0x12, 0xb6, 0x7d, 0xb6, 0x2a, 0x5c, 0x6e, 0xbf, 0x2b, 0x85,
+	0x3f, 0x01, 0xc8, 0x5b, 0x2d, 0xaa, 0xbc, 0x97, 0x96, 0x9a, 0xf0, 0x5e, 0x65, 0x98, 0x29, 0xfe,
+	0x8f, 0xea, 0x13, 0x80, 0xbc, 0x73, 0x56, 0x77, 0x5a, 0xea, 0xa9, 0x57, 0xed, 0xf4, 0x3b, 0x3d,
+	0x5a, 0x14, 0x2a, 0x06, 0xba, 0xb3, 0xe4, 0x94, 0xe5, 0xfa, 0xb5, 0xf7, 0x83, 0xab, 0x99, 0xec,
+	0xe6, 0x1e, 0xf4, 0x2a, 0x85, 0x03, 0x55, 0x04, 0x57, 0xd7, 0x95, 0xab, 0x14, 0xfe, 0x3d, 0x5c,
+	0x5b, 0x59, 0x4d, 0xd0, 0xdd, 0x55, 0xfe, 0x5c, 0x5d, 0x72, 0xae, 0xda, 0xff, 0x37, 0xb0, 0x55,
+	0x4a, 0x79, 0xe4, 0x2e, 0x99, 0xba, 0x54, 0x27, 0xf6, 0xee, 0x5c, 0xc9, 0x63, 0x77, 0x7e, 0x01,
+	0xdd, 0x72, 0x11, 0xa8, 0xba, 0x7a, 0x65, 0x89, 0xb8, 0x4a, 0xd7, 0x01, 0xb4, 0xd2, 0x0a, 0x51,
+	0xcd, 0x8a, 0x4a, 0xe5, 0xf8, 0x96, 0x5d, 0xd2, 0xda, 0x50, 0xdd, 0xa5, 0x52, 0x33, 0xae, 0xd8,
+	0xe5, 0xe3, 0xf7, 0x7f, 0x7b, 0x38, 0xa1, 0x72, 0x3a, 0x1f, 0xdd, 0x0f, 0xd8, 0xec, 0x30, 0xe4,
+	0xf8, 0xe2, 0x02, 0xc7, 0x87, 0x86, 0xfd, 0xb0, 0xf4, 0xaf, 0xd8, 0x0f, 0xed, 0xdf, 0xd1, 0xba,
+	0x6e, 0xf1, 0x0f, 0xfe, 0x37, 0x00, 0x26, 0x62, 0xa6, 0x60, 0xaa, 0x1d, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AdminClient interface {
+	// GetUsers returns an array with one or more users.
+	// For security reasons hashed passwords are omitted in the response
+	GetUsers(ctx context.Context, in *GetUsersRequest, opts ...grpc.CallOption) (*GetUsersResponse, error)
+	// GetUser returns the user with the given ID
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+	// AddUser adds a new user and returns it
+	AddUser(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*User, error)
+	// UpdateUser updates an existing user, empty permissions and file
+	// extensions filters are not updated
+	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+	// DeleteUser deletes an existing user
+	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+	// GetConnections returns the active users and info about their uploads/downloads
+	GetConnections(ctx context.Context, in *GetConnectionsRequest, opts ...grpc.CallOption) (*GetConnectionsResponse, error)
+	// CloseConnection terminates an active connection
+	CloseConnection(ctx context.Context, in *CloseConnectionRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+	// UpdateConnectionLabel sets a human readable label for an active connection
+	UpdateConnectionLabel(ctx context.Context, in *UpdateConnectionLabelRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+	// GetQuotaScans returns the active quota scans
+	GetQuotaScans(ctx context.Context, in *GetQuotaScansRequest, opts ...grpc.CallOption) (*GetQuotaScansResponse, error)
+	// StartQuotaScan starts a new quota scan for the given user
+	StartQuotaScan(ctx context.Context, in *StartQuotaScanRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+	// DumpData backups users on disk
+	DumpData(ctx context.Context, in *DumpDataRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+	// LoadData restores users from a backup file
+	LoadData(ctx context.Context, in *LoadDataRequest, opts ...grpc.CallOption) (*ApiResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) GetUsers(ctx context.Context, in *GetUsersRequest, opts ...grpc.CallOption) (*GetUsersResponse, error) {
+	out := new(GetUsersResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/GetUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/GetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddUser(ctx context.Context, in *AddUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/AddUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/UpdateUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/DeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetConnections(ctx context.Context, in *GetConnectionsRequest, opts ...grpc.CallOption) (*GetConnectionsResponse, error) {
+	out := new(GetConnectionsResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/GetConnections", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CloseConnection(ctx context.Context, in *CloseConnectionRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/CloseConnection", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) UpdateConnectionLabel(ctx context.Context, in *UpdateConnectionLabelRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/UpdateConnectionLabel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetQuotaScans(ctx context.Context, in *GetQuotaScansRequest, opts ...grpc.CallOption) (*GetQuotaScansResponse, error) {
+	out := new(GetQuotaScansResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/GetQuotaScans", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) StartQuotaScan(ctx context.Context, in *StartQuotaScanRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/StartQuotaScan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DumpData(ctx context.Context, in *DumpDataRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/DumpData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) LoadData(ctx context.Context, in *LoadDataRequest, opts ...grpc.CallOption) (*ApiResponse, error) {
+	out := new(ApiResponse)
+	err := c.cc.Invoke(ctx, "/sftpgo.admin.Admin/LoadData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+type AdminServer interface {
+	// GetUsers returns an array with one or more users.
+	// For security reasons hashed passwords are omitted in the response
+	GetUsers(context.Context, *GetUsersRequest) (*GetUsersResponse, error)
+	// GetUser returns the user with the given ID
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	// AddUser adds a new user and returns it
+	AddUser(context.Context, *AddUserRequest) (*User, error)
+	// UpdateUser updates an existing user, empty permissions and file
+	// extensions filters are not updated
+	UpdateUser(context.Context, *UpdateUserRequest) (*ApiResponse, error)
+	// DeleteUser deletes an existing user
+	DeleteUser(context.Context, *DeleteUserRequest) (*ApiResponse, error)
+	// GetConnections returns the active users and info about their uploads/downloads
+	GetConnections(context.Context, *GetConnectionsRequest) (*GetConnectionsResponse, error)
+	// CloseConnection terminates an active connection
+	CloseConnection(context.Context, *CloseConnectionRequest) (*ApiResponse, error)
+	// UpdateConnectionLabel sets a human readable label for an active connection
+	UpdateConnectionLabel(context.Context, *UpdateConnectionLabelRequest) (*ApiResponse, error)
+	// GetQuotaScans returns the active quota scans
+	GetQuotaScans(context.Context, *GetQuotaScansRequest) (*GetQuotaScansResponse, error)
+	// StartQuotaScan starts a new quota scan for the given user
+	StartQuotaScan(context.Context, *StartQuotaScanRequest) (*ApiResponse, error)
+	// DumpData backups users on disk
+	DumpData(context.Context, *DumpDataRequest) (*ApiResponse, error)
+	// LoadData restores users from a backup file
+	LoadData(context.Context, *LoadDataRequest) (*ApiResponse, error)
+}
+
+// UnimplementedAdminServer can be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (*UnimplementedAdminServer) GetUsers(ctx context.Context, req *GetUsersRequest) (*GetUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsers not implemented")
+}
+func (*UnimplementedAdminServer) GetUser(ctx context.Context, req *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (*UnimplementedAdminServer) AddUser(ctx context.Context, req *AddUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddUser not implemented")
+}
+func (*UnimplementedAdminServer) UpdateUser(ctx context.Context, req *UpdateUserRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateUser not implemented")
+}
+func (*UnimplementedAdminServer) DeleteUser(ctx context.Context, req *DeleteUserRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (*UnimplementedAdminServer) GetConnections(ctx context.Context, req *GetConnectionsRequest) (*GetConnectionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConnections not implemented")
+}
+func (*UnimplementedAdminServer) CloseConnection(ctx context.Context, req *CloseConnectionRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseConnection not implemented")
+}
+func (*UnimplementedAdminServer) UpdateConnectionLabel(ctx context.Context, req *UpdateConnectionLabelRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateConnectionLabel not implemented")
+}
+func (*UnimplementedAdminServer) GetQuotaScans(ctx context.Context, req *GetQuotaScansRequest) (*GetQuotaScansResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuotaScans not implemented")
+}
+func (*UnimplementedAdminServer) StartQuotaScan(ctx context.Context, req *StartQuotaScanRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartQuotaScan not implemented")
+}
+func (*UnimplementedAdminServer) DumpData(ctx context.Context, req *DumpDataRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DumpData not implemented")
+}
+func (*UnimplementedAdminServer) LoadData(ctx context.Context, req *LoadDataRequest) (*ApiResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LoadData not implemented")
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_GetUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/GetUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetUsers(ctx, req.(*GetUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/AddUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddUser(ctx, req.(*AddUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).UpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/UpdateUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).UpdateUser(ctx, req.(*UpdateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/DeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConnectionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/GetConnections",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetConnections(ctx, req.(*GetConnectionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_CloseConnection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseConnectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).CloseConnection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/CloseConnection",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).CloseConnection(ctx, req.(*CloseConnectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_UpdateConnectionLabel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateConnectionLabelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).UpdateConnectionLabel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/UpdateConnectionLabel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).UpdateConnectionLabel(ctx, req.(*UpdateConnectionLabelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetQuotaScans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQuotaScansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetQuotaScans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/GetQuotaScans",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetQuotaScans(ctx, req.(*GetQuotaScansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_StartQuotaScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartQuotaScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).StartQuotaScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/StartQuotaScan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).StartQuotaScan(ctx, req.(*StartQuotaScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DumpData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DumpDataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DumpData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/DumpData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DumpData(ctx, req.(*DumpDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_LoadData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoadDataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).LoadData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sftpgo.admin.Admin/LoadData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).LoadData(ctx, req.(*LoadDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "sftpgo.admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetUsers",
+			Handler:    _Admin_GetUsers_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _Admin_GetUser_Handler,
+		},
+		{
+			MethodName: "AddUser",
+			Handler:    _Admin_AddUser_Handler,
+		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    _Admin_UpdateUser_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _Admin_DeleteUser_Handler,
+		},
+		{
+			MethodName: "GetConnections",
+			Handler:    _Admin_GetConnections_Handler,
+		},
+		{
+			MethodName: "CloseConnection",
+			Handler:    _Admin_CloseConnection_Handler,
+		},
+		{
+			MethodName: "UpdateConnectionLabel",
+			Handler:    _Admin_UpdateConnectionLabel_Handler,
+		},
+		{
+			MethodName: "GetQuotaScans",
+			Handler:    _Admin_GetQuotaScans_Handler,
+		},
+		{
+			MethodName: "StartQuotaScan",
+			Handler:    _Admin_StartQuotaScan_Handler,
+		},
+		{
+			MethodName: "DumpData",
+			Handler:    _Admin_DumpData_Handler,
+		},
+		{
+			MethodName: "LoadData",
+			Handler:    _Admin_LoadData_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "httpd/adminpb/admin.proto",
+}
@@ -0,0 +1,168 @@
+package httpd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+type adminScopeContextKey string
+
+var (
+	// scopes for the admins, the key is the username defined inside the basic auth users file
+	adminScopes      map[string]dataprovider.UserScope
+	adminScopeCtxKey = adminScopeContextKey("admin_scope")
+	// paths that cannot be used by the admins restricted to a scope, they involve all the users
+	scopedAdminDeniedPaths = []string{dumpDataPath, loadDataPath, providerMigrationPath}
+	// paths, including their sub paths, that cannot be used by the admins restricted to a scope.
+	// The hooks tests accept any username, so they cannot be restricted to a scope
+	scopedAdminDeniedPathPrefixes = []string{hooksTestPath}
+	// paths, including their sub paths, for the objects shared by all the users. The admins restricted
+	// to a scope can only read them, a change could affect the users outside the scope
+	scopedAdminReadOnlyPathPrefixes = []string{ipListPath, defenderHostsPath, defenderBanPath, planPath,
+		userTemplatePath, folderPath, folderQuotaScanPath, webIPListPath, webIPListEntryPath, webPlanPath, webFolderPath}
+	scopedAdminDeniedGRPCMethods = []string{"/sftpgo.admin.Admin/DumpData", "/sftpgo.admin.Admin/LoadData"}
+)
+
+// AdminScope restricts an admin, defined inside the basic auth users file, to the users with
+// one of the given tenants or with a username starting with one of the given prefixes
+type AdminScope struct {
+	// admin username
+	Username string `json:"username" mapstructure:"username"`
+	// the admin can manage the users with one of these tenants, the comparison is case insensitive
+	Tenants []string `json:"tenants" mapstructure:"tenants"`
+	// the admin can manage the users with a username starting with one of these prefixes
+	UsernamePrefixes []string `json:"username_prefixes" mapstructure:"username_prefixes"`
+}
+
+func validateAdminScopes(scopes []AdminScope) (map[string]dataprovider.UserScope, error) {
+	result := make(map[string]dataprovider.UserScope)
+	for _, s := range scopes {
+		if len(s.Username) == 0 {
+			return nil, fmt.Errorf("admin scopes: the username is required")
+		}
+		if _, ok := result[s.Username]; ok {
+			return nil, fmt.Errorf("admin scopes: duplicate scope for admin %#v", s.Username)
+		}
+		scope := dataprovider.UserScope{
+			UsernamePrefixes: s.UsernamePrefixes,
+		}
+		for _, tenant := range s.Tenants {
+			scope.Tenants = append(scope.Tenants, strings.TrimSpace(tenant))
+		}
+		if scope.IsEmpty() {
+			return nil, fmt.Errorf("admin scopes: at least a tenant or a username prefix is required for admin %#v",
+				s.Username)
+		}
+		if err := scope.Validate(); err != nil {
+			return nil, fmt.Errorf("admin scopes: invalid scope for admin %#v: %v", s.Username, err)
+		}
+		result[s.Username] = scope
+	}
+	return result, nil
+}
+
+// getScopeForAdmin returns the scope for the given admin, an empty scope means no restrictions
+func getScopeForAdmin(username string) dataprovider.UserScope {
+	return adminScopes[username]
+}
+
+// withAdminScope returns a copy of ctx with the scope for the given admin, if any
+func withAdminScope(ctx context.Context, username string) context.Context {
+	scope := getScopeForAdmin(username)
+	if scope.IsEmpty() {
+		return ctx
+	}
+	return context.WithValue(ctx, adminScopeCtxKey, scope)
+}
+
+// getAdminScope returns the scope for the admin that sent the request, an empty scope
+// means no restrictions
+func getAdminScope(ctx context.Context) dataprovider.UserScope {
+	if scope, ok := ctx.Value(adminScopeCtxKey).(dataprovider.UserScope); ok {
+		return scope
+	}
+	return dataprovider.UserScope{}
+}
+
+// isRequestAllowedForScopedAdmin returns true if the given HTTP request can be served for an
+// admin restricted to a scope
+func isRequestAllowedForScopedAdmin(r *http.Request) bool {
+	if utils.IsStringInSlice(r.URL.Path, scopedAdminDeniedPaths) {
+		return false
+	}
+	if hasPathPrefix(r.URL.Path, scopedAdminDeniedPathPrefixes) {
+		return false
+	}
+	if utils.IsStringInSlice(r.Method, auditorAllowedMethods) {
+		return true
+	}
+	// adding a user from a template is allowed, the new user is checked against the scope
+	if strings.HasPrefix(r.URL.Path, userTemplatePath+"/") && strings.HasSuffix(r.URL.Path, "/user") {
+		return true
+	}
+	return !hasPathPrefix(r.URL.Path, scopedAdminReadOnlyPathPrefixes)
+}
+
+// isUsernameInScope returns true if the user with the given username exists and it is included
+// in the given scope. The users that cannot be loaded are considered outside the scope
+func isUsernameInScope(username string, scope dataprovider.UserScope) bool {
+	if scope.IsEmpty() {
+		return true
+	}
+	_, err := dataprovider.UserExistsInScope(dataProvider, scope, username)
+	return err == nil
+}
+
+// checkUsernameInScope sends the error response and returns false if the user with the given
+// username is not included in the scope for the admin that sent the request. The user is
+// required to exist only for the admins restricted to a scope, so the objects kept after
+// a user is deleted, for example the transfer receipts, remain available for the other admins
+func checkUsernameInScope(w http.ResponseWriter, r *http.Request, username string) bool {
+	scope := getAdminScope(r.Context())
+	if scope.IsEmpty() {
+		return true
+	}
+	_, err := dataprovider.UserExistsInScope(dataProvider, scope, username)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// filterConnectionsByScope returns the connections for the users included in the given scope
+func filterConnectionsByScope(connections []sftpd.ConnectionStatus, scope dataprovider.UserScope) []sftpd.ConnectionStatus {
+	if scope.IsEmpty() {
+		return connections
+	}
+	result := []sftpd.ConnectionStatus{}
+	for _, c := range connections {
+		if scope.IsInScope(c.Username, c.Tenant) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// isConnectionInScope returns true if the connection with the given ID belongs to a user
+// included in the given scope
+func isConnectionInScope(connectionID string, scope dataprovider.UserScope) bool {
+	if scope.IsEmpty() {
+		return true
+	}
+	for _, c := range sftpd.GetConnectionsStats() {
+		if c.ConnectionID == connectionID {
+			return scope.IsInScope(c.Username, c.Tenant)
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getUsersActivity(w http.ResponseWriter, r *http.Request) {
+	scope := getAdminScope(r.Context())
+	activities := []sftpd.UserActivity{}
+	for _, activity := range sftpd.GetUsersActivity() {
+		if isUsernameInScope(activity.Username, scope) {
+			activities = append(activities, activity)
+		}
+	}
+	render.JSON(w, r, activities)
+}
+
+func getUserActivity(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), username)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, sftpd.GetUserActivity(user.Username))
+}
@@ -0,0 +1,266 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/go-chi/render"
+)
+
+// supported actions for the bulk user operations
+const (
+	bulkActionAdd     = "add"
+	bulkActionUpdate  = "update"
+	bulkActionDisable = "disable"
+	bulkActionDelete  = "delete"
+)
+
+// maximum number of operations inside a bulk request
+const maxBulkOperations = 1000
+
+// bulkUserOperation defines an operation inside a bulk request, the users are identified by username.
+// For the add action the user field is the user to add, for the update action it is a JSON merge patch
+// to apply to the existing user. The disable action does not require a user
+type bulkUserOperation struct {
+	Action   string          `json:"action"`
+	Username string          `json:"username"`
+	User     json.RawMessage `json:"user,omitempty"`
+}
+
+// bulkUserResult defines the result for an operation inside a bulk request, the status is the HTTP
+// status code the same operation would return if executed alone
+type bulkUserResult struct {
+	Index    int    `json:"index"`
+	Action   string `json:"action"`
+	Username string `json:"username"`
+	Status   int    `json:"status"`
+	Message  string `json:"message"`
+	Error    string `json:"error"`
+}
+
+func (r *bulkUserResult) setError(err error, status int) {
+	r.Status = status
+	r.Message = ""
+	r.Error = err.Error()
+}
+
+// bulkUsersOperations adds, updates or disables many users in a single request.
+// All the operations are checked before applying them: if an operation is invalid, for example a user
+// to update does not exist, nothing is applied and the request fails. The errors returned saving the
+// users are reported for each operation and do not stop the following operations
+func bulkUsersOperations(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, userBulkBodyLimit)
+	disconnect, err := getDisconnectOption(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	var operations []bulkUserOperation
+	err = render.DecodeJSON(r.Body, &operations)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if len(operations) == 0 || len(operations) > maxBulkOperations {
+		err = fmt.Errorf("the number of operations must be between 1 and %v", maxBulkOperations)
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	scope := getAdminScope(r.Context())
+	users, results, ok := checkBulkUsersOperations(scope, operations)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, results)
+		return
+	}
+	for idx, operation := range operations {
+		result := &results[idx]
+		user := users[idx]
+		switch operation.Action {
+		case bulkActionAdd:
+			err = dataprovider.AddUserInScope(dataProvider, scope, user)
+			result.Message = "User added"
+		case bulkActionUpdate:
+			err = dataprovider.UpdateUserInScope(dataProvider, scope, user)
+			result.Message = "User updated"
+		case bulkActionDisable:
+			err = dataprovider.UpdateUserInScope(dataProvider, scope, user)
+			result.Message = "User disabled"
+		}
+		if err != nil {
+			result.setError(err, getRespStatus(err))
+			continue
+		}
+		result.Status = http.StatusOK
+		if disconnect && operation.Action != bulkActionAdd {
+			disconnectUser(user.Username)
+		}
+	}
+	render.JSON(w, r, results)
+}
+
+// checkBulkUsersOperations checks the given operations and returns the users to save for each of them.
+// The returned boolean is false if at least an operation is invalid
+func checkBulkUsersOperations(scope dataprovider.UserScope, operations []bulkUserOperation) ([]dataprovider.User,
+	[]bulkUserResult, bool) {
+	users := make([]dataprovider.User, len(operations))
+	results := make([]bulkUserResult, len(operations))
+	usernames := make(map[string]bool)
+	isValid := true
+	for idx, operation := range operations {
+		results[idx] = bulkUserResult{
+			Index:    idx,
+			Action:   operation.Action,
+			Username: operation.Username,
+		}
+		user, err := getBulkOperationUser(scope, operation)
+		if err == nil && usernames[user.Username] {
+			err = fmt.Errorf("the user %#v is included in more than one operation", user.Username)
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+				status = http.StatusNotFound
+			}
+			results[idx].setError(err, status)
+			isValid = false
+			continue
+		}
+		usernames[user.Username] = true
+		users[idx] = user
+		results[idx].Username = user.Username
+	}
+	if !isValid {
+		for idx := range results {
+			if len(results[idx].Error) == 0 {
+				results[idx].Status = http.StatusFailedDependency
+				results[idx].Message = "Not applied, at least an operation is invalid"
+			}
+		}
+	}
+	return users, results, isValid
+}
+
+// getBulkOperationUser returns the user to save for the given operation
+func getBulkOperationUser(scope dataprovider.UserScope, operation bulkUserOperation) (dataprovider.User, error) {
+	var user dataprovider.User
+	switch operation.Action {
+	case bulkActionAdd:
+		if err := json.Unmarshal(operation.User, &user); err != nil {
+			return user, fmt.Errorf("invalid user: %v", err)
+		}
+		if len(user.Username) == 0 {
+			user.Username = operation.Username
+		}
+		if len(user.Username) == 0 || (len(operation.Username) > 0 && operation.Username != user.Username) {
+			return user, errors.New("the username is mandatory and it must match the one inside the user")
+		}
+		if _, err := dataprovider.UserExists(dataProvider, user.Username); err == nil {
+			return user, fmt.Errorf("the user %#v already exists", user.Username)
+		}
+		if !scope.IsUserInScope(&user) {
+			return user, fmt.Errorf("the user %#v is outside the allowed scope", user.Username)
+		}
+		return user, nil
+	case bulkActionUpdate, bulkActionDisable:
+		currentUser, err := dataprovider.UserExistsInScope(dataProvider, scope, operation.Username)
+		if err != nil {
+			return user, err
+		}
+		if operation.Action == bulkActionDisable {
+			currentUser.Status = 0
+			return currentUser, nil
+		}
+		var patch map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(operation.User))
+		decoder.UseNumber()
+		if err = decoder.Decode(&patch); err != nil || patch == nil {
+			return user, errors.New("invalid merge patch, a JSON object is expected")
+		}
+		user, err = getPatchedUser(currentUser, patch)
+		if err != nil {
+			return user, fmt.Errorf("unable to apply the merge patch: %v", err)
+		}
+		if user.ID != currentUser.ID || user.Username != currentUser.Username {
+			return user, errors.New("the id and the username cannot be changed")
+		}
+		// the user must not be modified after this check
+		user.Version = currentUser.Version
+		return user, nil
+	default:
+		return user, fmt.Errorf("invalid action %#v, supported actions: %v, %v, %v", operation.Action, bulkActionAdd,
+			bulkActionUpdate, bulkActionDisable)
+	}
+}
+
+// bulkDeleteUsers deletes the users matching the filters set inside the query string, the same supported
+// for the users list. At least a filter is required. With the dry_run query parameter set to true the
+// matching users are returned without deleting them
+func bulkDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	disconnect, err := getDisconnectOption(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	dryRun := false
+	if _, ok := r.URL.Query()["dry_run"]; ok {
+		dryRun, err = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if err != nil {
+			err = errors.New("Invalid dry_run")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	filter, err := getUsersFilterFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if filter.IsEmpty() {
+		err = errors.New("at least a filter is required")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	scope := getAdminScope(r.Context())
+	// the matching users are loaded before deleting them, so the pages are not affected by the deletions
+	var users []dataprovider.User
+	req := dataprovider.UsersPageRequest{Limit: 500, Filter: filter}
+	for {
+		page, err := dataprovider.GetUsersPage(dataProvider, scope, req)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		users = append(users, page.Users...)
+		if len(page.NextCursor) == 0 {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	results := make([]bulkUserResult, 0, len(users))
+	for idx, user := range users {
+		result := bulkUserResult{
+			Index:    idx,
+			Action:   bulkActionDelete,
+			Username: user.Username,
+			Status:   http.StatusOK,
+		}
+		if dryRun {
+			result.Message = "Dry run, the user was not deleted"
+		} else if err = dataprovider.DeleteUserInScope(dataProvider, scope, user); err != nil {
+			result.setError(err, getRespStatus(err))
+		} else {
+			result.Message = "User deleted"
+			if disconnect {
+				disconnectUser(user.Username)
+			}
+		}
+		results = append(results, result)
+	}
+	render.JSON(w, r, results)
+}
@@ -0,0 +1,73 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+type connectionLabel struct {
+	Label string `json:"label"`
+}
+
+// getConnectionTagsFilter returns the tags filter from the query string parameters
+func getConnectionTagsFilter(r *http.Request) sftpd.ConnectionTagsFilter {
+	return sftpd.ConnectionTagsFilter{
+		ClientSoftware: strings.TrimSpace(r.URL.Query().Get("client_software")),
+		Tenant:         strings.TrimSpace(r.URL.Query().Get("tenant")),
+		Label:          strings.TrimSpace(r.URL.Query().Get("label")),
+	}
+}
+
+func getConnections(w http.ResponseWriter, r *http.Request) {
+	connections := sftpd.GetFilteredConnectionsStats(getConnectionTagsFilter(r))
+	render.JSON(w, r, filterConnectionsByScope(connections, getAdminScope(r.Context())))
+}
+
+func updateConnectionLabel(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	connectionID := chi.URLParam(r, "connectionID")
+	var label connectionLabel
+	err := render.DecodeJSON(r.Body, &label)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	label.Label = strings.TrimSpace(label.Label)
+	if !sftpd.IsValidConnectionLabel(label.Label) {
+		sendAPIResponse(w, r, errors.New("the label cannot be longer than 255 characters"), "", http.StatusBadRequest)
+		return
+	}
+	if isConnectionInScope(connectionID, getAdminScope(r.Context())) && sftpd.SetConnectionLabel(connectionID, label.Label) {
+		sendAPIResponse(w, r, nil, "Connection updated", http.StatusOK)
+	} else {
+		sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+	}
+}
+
+// closeUserConnections closes all the active connections for the user set inside the username query
+// parameter. The connections are closed immediately, the in-flight transfers are interrupted
+func closeUserConnections(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	if username == "" {
+		sendAPIResponse(w, r, errors.New("username is mandatory"), "", http.StatusBadRequest)
+		return
+	}
+	numClosed := 0
+	connections := filterConnectionsByScope(sftpd.GetConnectionsStats(), getAdminScope(r.Context()))
+	for _, c := range connections {
+		if c.Username == username && sftpd.CloseActiveConnection(c.ConnectionID) {
+			numClosed++
+		}
+	}
+	if numClosed == 0 {
+		sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+		return
+	}
+	sendAPIResponse(w, r, nil, fmt.Sprintf("Connections closed: %v", numClosed), http.StatusOK)
+}
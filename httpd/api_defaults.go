@@ -0,0 +1,67 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+type userDefaultsResetRequest struct {
+	// fields to reset, empty to reset all the deviating fields
+	Fields []string `json:"fields"`
+}
+
+func getUserDefaultsDiff(w http.ResponseWriter, r *http.Request) {
+	user, ok := getUserForDefaults(w, r)
+	if !ok {
+		return
+	}
+	diff, err := dataprovider.GetUserDefaultsDiff(dataProvider, user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, diff)
+}
+
+func resetUserToDefaults(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	user, ok := getUserForDefaults(w, r)
+	if !ok {
+		return
+	}
+	var req userDefaultsResetRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	diff, err := dataprovider.ResetUserFieldsToDefaults(dataProvider, user, req.Fields)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, diff)
+}
+
+func getUserForDefaults(w http.ResponseWriter, r *http.Request) (dataprovider.User, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid userID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return dataprovider.User{}, false
+	}
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), userID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return user, false
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return user, false
+	}
+	return user, true
+}
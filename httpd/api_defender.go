@@ -0,0 +1,57 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// defenderBanRequest defines a manual ban, the ban time is in minutes and 0 means the configured one
+type defenderBanRequest struct {
+	IP      string `json:"ip"`
+	BanTime int    `json:"ban_time"`
+}
+
+func getDefenderHosts(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, sftpd.GetDefenderHosts())
+}
+
+func getDefenderHost(w http.ResponseWriter, r *http.Request) {
+	entry, err := sftpd.GetDefenderHost(chi.URLParam(r, "ip"))
+	if err == nil {
+		render.JSON(w, r, entry)
+	} else if err == sftpd.ErrDefenderHostNotFound {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+	}
+}
+
+func unbanDefenderHost(w http.ResponseWriter, r *http.Request) {
+	err := sftpd.UnbanDefenderHost(chi.URLParam(r, "ip"))
+	if err == nil {
+		sendAPIResponse(w, r, err, "Host unbanned", http.StatusOK)
+	} else if err == sftpd.ErrDefenderHostNotFound {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+	}
+}
+
+func banDefenderHost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var req defenderBanRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	entry, err := sftpd.BanDefenderHost(req.IP, req.BanTime)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	render.JSON(w, r, entry)
+}
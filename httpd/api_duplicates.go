@@ -0,0 +1,407 @@
+package httpd
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// duplicate files scan status
+const (
+	DuplicatesScanRunning   = "running"
+	DuplicatesScanCompleted = "completed"
+	DuplicatesScanCanceled  = "canceled"
+	DuplicatesScanFailed    = "failed"
+)
+
+const duplicatesScanBufferSize = 32768
+
+var (
+	duplicatesScanConf  DuplicatesScanConfig
+	duplicatesScans     = make(map[string]*DuplicatesScan)
+	duplicatesScanMutex sync.RWMutex
+)
+
+// DuplicatesScanConfig defines the configuration for the duplicate files scans
+type DuplicatesScanConfig struct {
+	// Maximum read bandwidth, as KB/s, for each scan. 0 means unlimited
+	Bandwidth int64 `json:"bandwidth" mapstructure:"bandwidth"`
+}
+
+// DuplicateFileSet defines a set of files with the same contents
+type DuplicateFileSet struct {
+	// size of each file as bytes
+	Size int64 `json:"size"`
+	// SHA256 digest for the file contents
+	Hash string `json:"hash"`
+	// SFTP/SCP paths for the files
+	Files []string `json:"files"`
+}
+
+// DuplicatesScan defines a duplicate files scan for a user.
+// Only the files with the same size are hashed
+type DuplicatesScan struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	// start and end time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time,omitempty"`
+	// number of files found inside the user home dir and virtual folders
+	ScannedFiles int `json:"scanned_files"`
+	// number of files hashed and their size
+	HashedFiles int   `json:"hashed_files"`
+	HashedSize  int64 `json:"hashed_size"`
+	// space that can be reclaimed keeping only a file for each duplicate set
+	WastedSize    int64              `json:"wasted_size"`
+	DuplicateSets []DuplicateFileSet `json:"duplicate_sets,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	cancelFn      context.CancelFunc
+}
+
+// GetStartTimeAsString returns the scan start time formatted as YYYY-MM-DD HH:MM:SS
+func (s *DuplicatesScan) GetStartTimeAsString() string {
+	return utils.GetTimeFromMsecSinceEpoch(s.StartTime).Format(webDateTimeFormat)
+}
+
+// GetEndTimeAsString returns the scan end time formatted as YYYY-MM-DD HH:MM:SS,
+// an empty string if the scan is still running
+func (s *DuplicatesScan) GetEndTimeAsString() string {
+	if s.EndTime > 0 {
+		return utils.GetTimeFromMsecSinceEpoch(s.EndTime).Format(webDateTimeFormat)
+	}
+	return ""
+}
+
+func (s *DuplicatesScan) getACopy(withSets bool) DuplicatesScan {
+	scan := *s
+	scan.cancelFn = nil
+	scan.DuplicateSets = nil
+	if withSets {
+		scan.DuplicateSets = make([]DuplicateFileSet, len(s.DuplicateSets))
+		copy(scan.DuplicateSets, s.DuplicateSets)
+	}
+	return scan
+}
+
+func getDuplicatesScans(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, getDuplicatesScansStatus(getAdminScope(r.Context())))
+}
+
+// getDuplicatesScansStatus returns the running and finished scans for the users in the given
+// scope, without the duplicate sets, ordered by username
+func getDuplicatesScansStatus(scope dataprovider.UserScope) []DuplicatesScan {
+	duplicatesScanMutex.RLock()
+	scans := make([]DuplicatesScan, 0, len(duplicatesScans))
+	for _, s := range duplicatesScans {
+		scans = append(scans, s.getACopy(false))
+	}
+	duplicatesScanMutex.RUnlock()
+
+	if !scope.IsEmpty() {
+		result := make([]DuplicatesScan, 0, len(scans))
+		for _, s := range scans {
+			if isUsernameInScope(s.Username, scope) {
+				result = append(result, s)
+			}
+		}
+		scans = result
+	}
+	sort.Slice(scans, func(i, j int) bool {
+		return scans[i].Username < scans[j].Username
+	})
+	return scans
+}
+
+func getDuplicatesScan(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !checkUsernameInScope(w, r, username) {
+		return
+	}
+	duplicatesScanMutex.RLock()
+	defer duplicatesScanMutex.RUnlock()
+
+	if s, ok := duplicatesScans[username]; ok {
+		render.JSON(w, r, s.getACopy(true))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+}
+
+func startDuplicatesScan(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var u dataprovider.User
+	err := render.DecodeJSON(r.Body, &u)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), u.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	if !startUserDuplicatesScan(user) {
+		sendAPIResponse(w, r, err, "Another scan is already in progress", http.StatusConflict)
+		return
+	}
+	sendAPIResponse(w, r, err, "Scan started", http.StatusCreated)
+}
+
+// startUserDuplicatesScan starts a new scan in background for the given user.
+// Returns false if the user has a scan already running
+func startUserDuplicatesScan(user dataprovider.User) bool {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	scan, ok := addDuplicatesScan(user.Username, cancelFn)
+	if !ok {
+		cancelFn()
+		return false
+	}
+	go doDuplicatesScan(ctx, user, scan)
+	return true
+}
+
+// cancelDuplicatesScan cancels a running scan or removes the results of a finished one
+func cancelDuplicatesScan(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !checkUsernameInScope(w, r, username) {
+		return
+	}
+	found, canceled := cancelUserDuplicatesScan(username)
+	if !found {
+		sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+		return
+	}
+	if canceled {
+		sendAPIResponse(w, r, nil, "Scan canceled", http.StatusOK)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Scan removed", http.StatusOK)
+}
+
+// cancelUserDuplicatesScan cancels the running scan for the given user or removes the results
+// of the finished one. found is false if there is no scan for this user, canceled is true if
+// the scan was running
+func cancelUserDuplicatesScan(username string) (found bool, canceled bool) {
+	duplicatesScanMutex.Lock()
+	defer duplicatesScanMutex.Unlock()
+
+	s, ok := duplicatesScans[username]
+	if !ok {
+		return false, false
+	}
+	if s.Status == DuplicatesScanRunning {
+		s.cancelFn()
+		return true, true
+	}
+	delete(duplicatesScans, username)
+	return true, false
+}
+
+// addDuplicatesScan adds a new running scan for the given user replacing the results of
+// a finished one, if any. Returns false if the user has a scan already running
+func addDuplicatesScan(username string, cancelFn context.CancelFunc) (*DuplicatesScan, bool) {
+	duplicatesScanMutex.Lock()
+	defer duplicatesScanMutex.Unlock()
+
+	if s, ok := duplicatesScans[username]; ok && s.Status == DuplicatesScanRunning {
+		return nil, false
+	}
+	scan := &DuplicatesScan{
+		Username:  username,
+		Status:    DuplicatesScanRunning,
+		StartTime: utils.GetTimeAsMsSinceEpoch(time.Now()),
+		cancelFn:  cancelFn,
+	}
+	duplicatesScans[username] = scan
+	return scan, true
+}
+
+func updateDuplicatesScan(scan *DuplicatesScan, updateFn func(s *DuplicatesScan)) {
+	duplicatesScanMutex.Lock()
+	defer duplicatesScanMutex.Unlock()
+
+	updateFn(scan)
+}
+
+func doDuplicatesScan(ctx context.Context, user dataprovider.User, scan *DuplicatesScan) error {
+	sets, err := findDuplicateFiles(ctx, user, scan)
+	status := DuplicatesScanCompleted
+	if ctx.Err() != nil {
+		status = DuplicatesScanCanceled
+	} else if err != nil {
+		status = DuplicatesScanFailed
+	}
+	updateDuplicatesScan(scan, func(s *DuplicatesScan) {
+		s.EndTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+		s.Status = status
+		s.cancelFn()
+		if status == DuplicatesScanFailed {
+			s.Error = err.Error()
+		}
+		if status != DuplicatesScanCompleted {
+			return
+		}
+		s.DuplicateSets = sets
+		for _, set := range sets {
+			s.WastedSize += set.Size * int64(len(set.Files)-1)
+		}
+	})
+	logger.Debug(logSender, "", "duplicate files scan finished, user: %#v, status: %v, duplicate sets: %v, error: %v",
+		user.Username, status, len(sets), err)
+	return err
+}
+
+// findDuplicateFiles walks the user files and then it hashes the files with the same size
+func findDuplicateFiles(ctx context.Context, user dataprovider.User, scan *DuplicatesScan) ([]DuplicateFileSet, error) {
+	fs, err := user.GetFilesystem("")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to scan for duplicate files, user %#v error creating filesystem: %v",
+			user.Username, err)
+		return nil, err
+	}
+	filesBySize := make(map[int64][][2]string)
+	err = walkUserFiles(user, fs, func(fsPath, virtualPath string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		updateDuplicatesScan(scan, func(s *DuplicatesScan) {
+			s.ScannedFiles++
+		})
+		// empty files are not interesting, they don't use quota
+		if info.Size() > 0 {
+			filesBySize[info.Size()] = append(filesBySize[info.Size()], [2]string{fsPath, virtualPath})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	throttler := newReadThrottler(duplicatesScanConf.Bandwidth)
+	var sets []DuplicateFileSet
+	for size, files := range filesBySize {
+		if len(files) < 2 {
+			continue
+		}
+		filesByHash := make(map[string][]string)
+		for _, f := range files {
+			hash, err := getFileDigest(ctx, fs, f[0], throttler)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				// the file could be deleted or modified while scanning
+				logger.Warn(logSender, "", "unable to hash file %#v for duplicate files scan, user %#v: %v", f[1],
+					user.Username, err)
+				continue
+			}
+			updateDuplicatesScan(scan, func(s *DuplicatesScan) {
+				s.HashedFiles++
+				s.HashedSize += size
+			})
+			filesByHash[hash] = append(filesByHash[hash], f[1])
+		}
+		for hash, paths := range filesByHash {
+			if len(paths) < 2 {
+				continue
+			}
+			sort.Strings(paths)
+			sets = append(sets, DuplicateFileSet{
+				Size:  size,
+				Hash:  hash,
+				Files: paths,
+			})
+		}
+	}
+	// the sets wasting more space first
+	sort.SliceStable(sets, func(i, j int) bool {
+		wastedI := sets[i].Size * int64(len(sets[i].Files)-1)
+		wastedJ := sets[j].Size * int64(len(sets[j].Files)-1)
+		if wastedI == wastedJ {
+			return sets[i].Files[0] < sets[j].Files[0]
+		}
+		return wastedI > wastedJ
+	})
+	return sets, nil
+}
+
+func getFileDigest(ctx context.Context, fs vfs.Fs, name string, throttler *readThrottler) (string, error) {
+	f, r, cancelFn, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	var reader io.Reader
+	if f != nil {
+		defer f.Close()
+		reader = f
+	} else {
+		defer r.Close()
+		reader = io.NewSectionReader(r, 0, 1<<62)
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	h := sha256.New()
+	buf := make([]byte, duplicatesScanBufferSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := reader.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			throttler.wait(ctx, int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// readThrottler limits the read bandwidth, bandwidth is expressed as KB/s
+type readThrottler struct {
+	bandwidth int64
+	start     time.Time
+	readBytes int64
+}
+
+func newReadThrottler(bandwidth int64) *readThrottler {
+	return &readThrottler{
+		bandwidth: bandwidth,
+		start:     time.Now(),
+	}
+}
+
+func (t *readThrottler) wait(ctx context.Context, n int64) {
+	t.readBytes += n
+	if t.bandwidth <= 0 {
+		return
+	}
+	// real and wanted elapsed as milliseconds, bytes as kilobytes
+	realElapsed := time.Since(t.start).Nanoseconds() / 1000000
+	wantedElapsed := 1000 * (t.readBytes / 1000) / t.bandwidth
+	if wantedElapsed > realElapsed {
+		timer := time.NewTimer(time.Duration(wantedElapsed-realElapsed) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/vfs"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := dataprovider.GetFolders(dataProvider)
+	if err == nil {
+		render.JSON(w, r, folders)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func getFolderByID(w http.ResponseWriter, r *http.Request) {
+	folder, err := getFolderFromPath(w, r)
+	if err != nil {
+		return
+	}
+	render.JSON(w, r, folder)
+}
+
+func addFolder(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var folder dataprovider.Folder
+	err := render.DecodeJSON(r.Body, &folder)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddFolder(dataProvider, folder)
+	if err == nil {
+		folder, err = dataprovider.FolderExists(dataProvider, folder.Name)
+		if err == nil {
+			render.JSON(w, r, folder)
+		} else {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		}
+	} else {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	}
+}
+
+func updateFolder(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	folder, err := getFolderFromPath(w, r)
+	if err != nil {
+		return
+	}
+	folderID := folder.ID
+	err = render.DecodeJSON(r.Body, &folder)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if folder.ID != folderID {
+		sendAPIResponse(w, r, err, "folder ID in request body does not match folder ID in path parameter",
+			http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateFolder(dataProvider, folder)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "Folder updated", http.StatusOK)
+	}
+}
+
+func deleteFolder(w http.ResponseWriter, r *http.Request) {
+	folder, err := getFolderFromPath(w, r)
+	if err != nil {
+		return
+	}
+	err = dataprovider.DeleteFolder(dataProvider, folder)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "Folder deleted", http.StatusOK)
+	}
+}
+
+func getFolderQuotaScans(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, sftpd.GetFolderQuotaScans())
+}
+
+func startFolderQuotaScan(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var f dataprovider.Folder
+	err := render.DecodeJSON(r.Body, &f)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	folder, err := dataprovider.FolderExists(dataProvider, f.Name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	startFolderScan(w, r, folder)
+}
+
+func getFolderQuotaScan(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	scan, ok := sftpd.GetFolderQuotaScan(name)
+	if !ok {
+		err := fmt.Errorf("no quota scan in progress for folder %#v", name)
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, scan)
+}
+
+func startFolderScan(w http.ResponseWriter, r *http.Request, folder dataprovider.Folder) {
+	if sftpd.AddFolderQuotaScan(folder.Name) {
+		go doFolderQuotaScan(folder)
+		sendAPIResponse(w, r, nil, "Scan started", http.StatusCreated)
+	} else {
+		sendAPIResponse(w, r, nil, "Another scan is already in progress", http.StatusConflict)
+	}
+}
+
+func doFolderQuotaScan(folder dataprovider.Folder) error {
+	defer sftpd.RemoveFolderQuotaScan(folder.Name)
+	progress := sftpd.GetFolderQuotaScanProgress(folder.Name)
+	progress.SetExpected(folder.UsedQuotaFiles, folder.UsedQuotaSize)
+	fs := vfs.NewOsFs("", folder.MappedPath, nil)
+	numFiles, size, err := vfs.ScanRootDirContentsWithProgress(fs, progress)
+	if err != nil {
+		logger.Warn(logSender, "", "error scanning folder %#v, mapped path %#v: %v", folder.Name, folder.MappedPath, err)
+	} else {
+		err = dataprovider.UpdateFolderQuota(dataProvider, folder.Name, numFiles, size, true)
+		logger.Debug(logSender, "", "folder scanned, name: %#v, error: %v", folder.Name, err)
+	}
+	return err
+}
+
+// getFolderFromPath returns the folder with the ID in the path. The error response
+// is sent if the folder cannot be returned
+func getFolderFromPath(w http.ResponseWriter, r *http.Request) (dataprovider.Folder, error) {
+	folderID, err := strconv.ParseInt(chi.URLParam(r, "folderID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid folderID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return dataprovider.Folder{}, err
+	}
+	folder, err := dataprovider.GetFolderByID(dataProvider, folderID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+	return folder, err
+}
@@ -0,0 +1,29 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
+)
+
+// checkHealth returns 200 only if the data provider is available and the SFTP server is accepting
+// connections, otherwise 503 with the failed check inside the error field.
+// This endpoint does not require authentication so the detailed errors are only logged
+func checkHealth(w http.ResponseWriter, r *http.Request) {
+	if err := dataprovider.GetProviderStatus(dataProvider); err != nil {
+		logger.Warn(logSender, "", "health check failed, data provider unavailable: %v", err)
+		sendAPIResponse(w, r, errors.New("data provider unavailable"), "data provider unavailable",
+			http.StatusServiceUnavailable)
+		return
+	}
+	if err := sftpd.CheckListeners(); err != nil {
+		logger.Warn(logSender, "", "health check failed, SFTP server unavailable: %v", err)
+		sendAPIResponse(w, r, errors.New("SFTP server unavailable"), "SFTP server unavailable",
+			http.StatusServiceUnavailable)
+		return
+	}
+	sendAPIResponse(w, r, nil, "OK", http.StatusOK)
+}
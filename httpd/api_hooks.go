@@ -0,0 +1,60 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/go-chi/render"
+)
+
+const defaultHookTestUsername = "sftpgo_hook_test"
+
+// HookTestRequest defines the synthetic event to send to the configured hooks
+type HookTestRequest struct {
+	// event type, for example upload for actions or add for provider actions
+	Event string `json:"event"`
+	// username for the synthetic user included in the event, the user does not need to exist
+	Username string `json:"username,omitempty"`
+}
+
+func getHookTestRequest(w http.ResponseWriter, r *http.Request) (HookTestRequest, error) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var req HookTestRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		return req, err
+	}
+	if len(req.Username) == 0 {
+		req.Username = defaultHookTestUsername
+	}
+	return req, nil
+}
+
+func testActionHooks(w http.ResponseWriter, r *http.Request) {
+	req, err := getHookTestRequest(w, r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	results, err := sftpd.TestAction(req.Event, dataprovider.GetSyntheticUser(req.Username))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	render.JSON(w, r, results)
+}
+
+func testProviderActionHooks(w http.ResponseWriter, r *http.Request) {
+	req, err := getHookTestRequest(w, r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	results, err := dataprovider.TestAction(req.Event, dataprovider.GetSyntheticUser(req.Username))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, results)
+}
@@ -0,0 +1,40 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/go-chi/render"
+)
+
+// getInactiveUsersReport returns the users the inactivity policy would warn, disable or expire now.
+// The configured policy settings can be overridden using the query parameters, to evaluate a different policy
+func getInactiveUsersReport(w http.ResponseWriter, r *http.Request) {
+	policy := dataprovider.GetInactivityPolicy()
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{name: "inactive_days", value: &policy.InactiveDays},
+		{name: "grace_days", value: &policy.GraceDays},
+		{name: "action", value: &policy.Action},
+	} {
+		if _, ok := r.URL.Query()[param.name]; !ok {
+			continue
+		}
+		value, err := strconv.Atoi(r.URL.Query().Get(param.name))
+		if err != nil {
+			sendAPIResponse(w, r, fmt.Errorf("Invalid %v", param.name), "", http.StatusBadRequest)
+			return
+		}
+		*param.value = value
+	}
+	report, err := dataprovider.GetInactivityReport(dataProvider, getAdminScope(r.Context()), policy)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, report)
+}
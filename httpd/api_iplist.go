@@ -0,0 +1,219 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getIPListEntries(w http.ResponseWriter, r *http.Request) {
+	listType := 0
+	var err error
+	if _, ok := r.URL.Query()["type"]; ok {
+		listType, err = strconv.Atoi(r.URL.Query().Get("type"))
+		if err != nil || (listType != dataprovider.IPListTypeSafe && listType != dataprovider.IPListTypeBlock) {
+			err = errors.New("Invalid type")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := dataprovider.GetIPListEntries(dataProvider, listType)
+	if err == nil {
+		render.JSON(w, r, entries)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func getIPListEntryByID(w http.ResponseWriter, r *http.Request) {
+	entryID, err := strconv.ParseInt(chi.URLParam(r, "entryID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid entryID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	entry, err := dataprovider.GetIPListEntryByID(dataProvider, entryID)
+	if err == nil {
+		render.JSON(w, r, entry)
+	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func addIPListEntry(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var entry dataprovider.IPListEntry
+	err := render.DecodeJSON(r.Body, &entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddIPListEntry(dataProvider, entry)
+	if err == nil {
+		entry, err = dataprovider.IPListEntryExists(dataProvider, entry.IPOrNet)
+		if err == nil {
+			render.JSON(w, r, entry)
+		} else {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		}
+	} else {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	}
+}
+
+func updateIPListEntry(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	entryID, err := strconv.ParseInt(chi.URLParam(r, "entryID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid entryID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	entry, err := dataprovider.GetIPListEntryByID(dataProvider, entryID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	err = render.DecodeJSON(r.Body, &entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if entry.ID != entryID {
+		sendAPIResponse(w, r, err, "entry ID in request body does not match entry ID in path parameter", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateIPListEntry(dataProvider, entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "IP list entry updated", http.StatusOK)
+	}
+}
+
+func deleteIPListEntry(w http.ResponseWriter, r *http.Request) {
+	entryID, err := strconv.ParseInt(chi.URLParam(r, "entryID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid entryID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	entry, err := dataprovider.GetIPListEntryByID(dataProvider, entryID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	err = dataprovider.DeleteIPListEntry(dataProvider, entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	} else {
+		sendAPIResponse(w, r, err, "IP list entry deleted", http.StatusOK)
+	}
+}
+
+func exportIPList(w http.ResponseWriter, r *http.Request) {
+	listType, err := strconv.Atoi(r.URL.Query().Get("type"))
+	if err != nil || (listType != dataprovider.IPListTypeSafe && listType != dataprovider.IPListTypeBlock) {
+		err = errors.New("Invalid type")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		err = errors.New("Invalid format")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	set, err := dataprovider.ExportIPListSet(dataProvider, listType)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	if format == "text" {
+		// one entry for each line, this format can be used as IP list feed by other instances
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, ipOrNet := range set.Entries {
+			fmt.Fprintln(w, ipOrNet)
+		}
+		return
+	}
+	render.JSON(w, r, set)
+}
+
+func importIPList(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, ipListImportBodyLimit)
+	replace := false
+	var err error
+	if _, ok := r.URL.Query()["replace"]; ok {
+		replace, err = strconv.ParseBool(r.URL.Query().Get("replace"))
+		if err != nil {
+			err = errors.New("Invalid replace")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	var set dataprovider.IPListSet
+	err = render.DecodeJSON(r.Body, &set)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	result, err := dataprovider.ImportIPListSet(dataProvider, set, replace)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
+
+func getIPListFeeds(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, dataprovider.GetIPListFeedsStatus())
+}
+
+func checkIPAddress(w http.ResponseWriter, r *http.Request) {
+	result, err := dataprovider.CheckIPAddress(dataProvider, r.URL.Query().Get("ip"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
+
+func unblockIPAddress(w http.ResponseWriter, r *http.Request) {
+	result, err := dataprovider.UnblockIPAddress(dataProvider, r.URL.Query().Get("ip"), "unblocked using the REST API")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
+
+// checkIPLists refuses the requests from the addresses in the block list.
+// The address of the direct peer is checked, the headers that can be set
+// by the client, such as X-Forwarded-For, are not trusted here
+func checkIPLists(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+		if dataprovider.IsIPBlocked(ipAddr) {
+			logger.Debug(logSender, "", "request from blocked IP address %#v refused", ipAddr)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
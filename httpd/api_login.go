@@ -0,0 +1,41 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/go-chi/render"
+)
+
+func simulateLogin(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, loginBodyLimit)
+	var req sftpd.LoginSimulationRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if len(req.Username) > 0 && !checkUsernameInScope(w, r, req.Username) {
+		return
+	}
+	admin, _, _ := r.BasicAuth()
+	ip := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	result, err := sftpd.SimulateLogin(req)
+	if err != nil {
+		logger.LoginSimulationAuditLog(admin, ip, req.Username, "", "", err.Error())
+		if err == sftpd.ErrLoginSimulationRateLimited {
+			sendAPIResponse(w, r, err, "", http.StatusTooManyRequests)
+			return
+		}
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	decision := "denied"
+	if result.Allowed {
+		decision = "allowed"
+	}
+	logger.LoginSimulationAuditLog(admin, ip, req.Username, result.LoginMethod, decision, result.Rule)
+	render.JSON(w, r, result)
+}
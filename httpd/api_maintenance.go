@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -14,16 +15,23 @@ import (
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/go-chi/render"
 )
 
+// name used inside the logs for the backups uploaded as request body
+const uploadedBackupName = "uploaded backup"
+
 func dumpData(w http.ResponseWriter, r *http.Request) {
-	var outputFile, indent string
+	var outputFile, indent, format string
 	if _, ok := r.URL.Query()["output_file"]; ok {
 		outputFile = strings.TrimSpace(r.URL.Query().Get("output_file"))
 	}
 	if _, ok := r.URL.Query()["indent"]; ok {
 		indent = strings.TrimSpace(r.URL.Query().Get("indent"))
 	}
+	if _, ok := r.URL.Query()["format"]; ok {
+		format = r.URL.Query().Get("format")
+	}
 	if len(outputFile) == 0 {
 		sendAPIResponse(w, r, errors.New("Invalid or missing output_file"), "", http.StatusBadRequest)
 		return
@@ -36,36 +44,62 @@ func dumpData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, fmt.Errorf("Invalid output_file %#v", outputFile), "", http.StatusBadRequest)
 		return
 	}
+	format, err := getBackupFormat(format, outputFile)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 	outputFile = filepath.Join(backupsPath, outputFile)
-	logger.Debug(logSender, "", "dumping data to: %#v", outputFile)
+	err = dumpUsers(outputFile, format, indent == "1")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "Data saved", http.StatusOK)
+}
+
+func dumpUsers(outputFile, format string, indent bool) error {
+	logger.Debug(logSender, "", "dumping data to: %#v, format: %v", outputFile, format)
 
 	users, err := dataprovider.DumpUsers(dataProvider)
 	if err != nil {
 		logger.Warn(logSender, "", "dumping data error: %v, output file: %#v", err, outputFile)
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
-		return
+		return err
 	}
-	var dump []byte
-	if indent == "1" {
-		dump, err = json.MarshalIndent(dataprovider.BackupData{
-			Users: users,
-		}, "", "  ")
-	} else {
-		dump, err = json.Marshal(dataprovider.BackupData{
-			Users: users,
-		})
+	plans, err := dataprovider.GetPlans(dataProvider)
+	if err != nil {
+		logger.Warn(logSender, "", "dumping data error: %v, output file: %#v", err, outputFile)
+		return err
 	}
+	templates, err := dataprovider.GetUserTemplates(dataProvider)
+	if err != nil {
+		logger.Warn(logSender, "", "dumping data error: %v, output file: %#v", err, outputFile)
+		return err
+	}
+	folders, err := dataprovider.GetFolders(dataProvider)
+	if err != nil {
+		logger.Warn(logSender, "", "dumping data error: %v, output file: %#v", err, outputFile)
+		return err
+	}
+	for idx := range folders {
+		folders[idx].Users = nil
+	}
+	dump, err := marshalBackup(dataprovider.BackupData{
+		Users:         users,
+		Plans:         plans,
+		UserTemplates: templates,
+		Folders:       folders,
+	}, format, indent)
 	if err == nil {
 		os.MkdirAll(filepath.Dir(outputFile), 0700)
 		err = ioutil.WriteFile(outputFile, dump, 0600)
 	}
 	if err != nil {
 		logger.Warn(logSender, "", "dumping data error: %v, output file: %#v", err, outputFile)
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
-		return
+		return err
 	}
 	logger.Debug(logSender, "", "dumping data completed, output file: %#v, error: %v", outputFile, err)
-	sendAPIResponse(w, r, err, "Data saved", http.StatusOK)
+	return nil
 }
 
 func loadData(w http.ResponseWriter, r *http.Request) {
@@ -74,68 +108,311 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	format, err := getBackupFormat(r.URL.Query().Get("format"), inputFile)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	csvColumns, err := getCSVColumnsMapping(r.URL.Query().Get("csv_columns"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 	if !filepath.IsAbs(inputFile) {
 		sendAPIResponse(w, r, fmt.Errorf("Invalid input_file %#v: it must be an absolute path", inputFile), "", http.StatusBadRequest)
 		return
 	}
-	fi, err := os.Stat(inputFile)
+	f, err := os.Open(inputFile)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	if fi.Size() > maxRestoreSize {
-		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to restore input file: %#v size too big: %v/%v bytes",
-			inputFile, fi.Size(), maxRestoreSize), http.StatusBadRequest)
+	defer f.Close()
+
+	err = restoreBackup(f, inputFile, format, csvColumns, scanQuota, mode)
+	if _, ok := err.(*invalidBackupError); ok {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to parse input file: %#v", inputFile), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
+}
 
-	content, err := ioutil.ReadFile(inputFile)
+// loadDataFromBody restores the backup uploaded as request body. The backup is copied to
+// a temporary file, so it is not loaded in memory, and then restored as for loadData
+func loadDataFromBody(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, loadDataBodyLimit)
+	_, scanQuota, mode, err := getLoaddataOptions(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	format, err := getBackupFormat(r.URL.Query().Get("format"), "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	csvColumns, err := getCSVColumnsMapping(r.URL.Query().Get("csv_columns"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	f, err := ioutil.TempFile("", "sftpgo-loaddata-")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r.Body)
+	if err != nil {
+		if isRequestBodyTooLarge(err) {
+			sendAPIResponse(w, r, err, fmt.Sprintf("The uploaded backup exceeds the maximum allowed size: %v bytes",
+				loadDataBodyLimit.size), http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendAPIResponse(w, r, err, "Unable to read the uploaded backup", http.StatusBadRequest)
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug(logSender, "", "uploaded backup saved to %#v, size: %v bytes, format: %v", f.Name(), size, format)
+	err = restoreBackup(f, uploadedBackupName, format, csvColumns, scanQuota, mode)
+	if _, ok := err.(*invalidBackupError); ok {
+		sendAPIResponse(w, r, err, "Unable to parse the uploaded backup", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
+}
+
+// restoreBackup restores the given backup. The JSON and CSV backups are parsed while they are
+// restored and the users are restored one at a time, so these backups are never fully loaded
+// in memory. The YAML backups are loaded in memory and they are limited to maxYAMLRestoreSize.
+// If the backup cannot be parsed an *invalidBackupError is returned
+func restoreBackup(r io.ReadSeeker, inputFile, format string, csvColumns map[string]string, scanQuota, mode int) error {
+	switch format {
+	case backupFormatCSV:
+		numUsers := 0
+		err := readUsersFromCSV(r, csvColumns, func(user dataprovider.User) error {
+			numUsers++
+			return restoreUser(user, inputFile, scanQuota, mode)
+		})
+		logger.Debug(logSender, "", "backup restored, users: %v", numUsers)
+		return err
+	case backupFormatYAML:
+		content, err := ioutil.ReadAll(io.LimitReader(r, maxYAMLRestoreSize+1))
+		if err != nil {
+			return err
+		}
+		if len(content) > maxYAMLRestoreSize {
+			return &invalidBackupError{err: fmt.Errorf("YAML backup too big, max size: %v bytes, use JSON for bigger backups",
+				maxYAMLRestoreSize)}
+		}
+		dump, err := unmarshalBackup(content, format, csvColumns)
+		if err != nil {
+			return &invalidBackupError{err: err}
+		}
+		return restoreBackupData(dump, inputFile, scanQuota, mode)
+	default:
+		return restoreJSONBackup(r, inputFile, scanQuota, mode)
+	}
+}
+
+// restoreJSONBackup reads the given JSON backup twice: the plans, the folders and the user
+// templates are restored after the first read, the users are restored one at a time while
+// the backup is read again. A malformed backup is detected by the first read, so nothing
+// is restored
+func restoreJSONBackup(r io.ReadSeeker, inputFile string, scanQuota, mode int) error {
 	var dump dataprovider.BackupData
-	err = json.Unmarshal(content, &dump)
+	err := readJSONBackup(r, func(key string, dec *json.Decoder) (bool, error) {
+		switch key {
+		case "plans":
+			return true, decodeJSONBackupValue(dec, &dump.Plans)
+		case "folders":
+			return true, decodeJSONBackupValue(dec, &dump.Folders)
+		case "user_templates":
+			return true, decodeJSONBackupValue(dec, &dump.UserTemplates)
+		}
+		return false, nil
+	})
 	if err != nil {
-		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to parse input file: %#v", inputFile), http.StatusBadRequest)
-		return
+		return err
 	}
+	if err = restoreBackupData(dump, inputFile, scanQuota, mode); err != nil {
+		return err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	numUsers := 0
+	err = readJSONBackup(r, func(key string, dec *json.Decoder) (bool, error) {
+		if key != "users" {
+			return false, nil
+		}
+		return true, readJSONArray(dec, func(dec *json.Decoder) error {
+			var user dataprovider.User
+			if err := decodeJSONBackupValue(dec, &user); err != nil {
+				return err
+			}
+			numUsers++
+			return restoreUser(user, inputFile, scanQuota, mode)
+		})
+	})
+	logger.Debug(logSender, "", "backup restored, users: %v", numUsers)
+	return err
+}
 
-	for _, user := range dump.Users {
-		u, err := dataprovider.UserExists(dataProvider, user.Username)
+// restoreBackupData restores the plans, the folders, the user templates and the users, in this order
+func restoreBackupData(dump dataprovider.BackupData, inputFile string, scanQuota, mode int) error {
+	err := restorePlans(dump.Plans, inputFile, mode)
+	if err != nil {
+		return err
+	}
+	err = restoreFolders(dump.Folders, inputFile, scanQuota, mode)
+	if err != nil {
+		return err
+	}
+	err = restoreUserTemplates(dump.UserTemplates, inputFile, mode)
+	if err != nil {
+		return err
+	}
+	return restoreUsers(dump.Users, inputFile, scanQuota, mode)
+}
+
+// restorePlans must be called before restoreUsers, the restored users could reference the restored plans
+func restorePlans(plans []dataprovider.Plan, inputFile string, mode int) error {
+	for _, plan := range plans {
+		p, err := dataprovider.PlanExists(dataProvider, plan.Name)
 		if err == nil {
 			if mode == 1 {
-				logger.Debug(logSender, "", "loaddata mode 1, existing user %#v not updated", u.Username)
+				logger.Debug(logSender, "", "loaddata mode 1, existing plan %#v not updated", p.Name)
 				continue
 			}
-			user.ID = u.ID
-			user.LastLogin = u.LastLogin
-			user.UsedQuotaSize = u.UsedQuotaSize
-			user.UsedQuotaFiles = u.UsedQuotaFiles
-			err = dataprovider.UpdateUser(dataProvider, user)
-			user.Password = "[redacted]"
-			logger.Debug(logSender, "", "restoring existing user: %+v, dump file: %#v, error: %v", user, inputFile, err)
+			plan.ID = p.ID
+			err = dataprovider.UpdatePlan(dataProvider, plan)
+			logger.Debug(logSender, "", "restoring existing plan: %+v, dump file: %#v, error: %v", plan, inputFile, err)
 		} else {
-			user.LastLogin = 0
-			user.UsedQuotaSize = 0
-			user.UsedQuotaFiles = 0
-			err = dataprovider.AddUser(dataProvider, user)
-			user.Password = "[redacted]"
-			logger.Debug(logSender, "", "adding new user: %+v, dump file: %#v, error: %v", user, inputFile, err)
+			err = dataprovider.AddPlan(dataProvider, plan)
+			logger.Debug(logSender, "", "adding new plan: %+v, dump file: %#v, error: %v", plan, inputFile, err)
 		}
 		if err != nil {
-			sendAPIResponse(w, r, err, "", getRespStatus(err))
-			return
+			return err
+		}
+	}
+	logger.Debug(logSender, "", "backup restored, plans: %v", len(plans))
+	return nil
+}
+
+// restoreFolders must be called before restoreUserTemplates and restoreUsers, the restored user
+// templates and users could reference the restored folders
+func restoreFolders(folders []dataprovider.Folder, inputFile string, scanQuota, mode int) error {
+	for _, folder := range folders {
+		f, err := dataprovider.FolderExists(dataProvider, folder.Name)
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing folder %#v not updated", f.Name)
+				continue
+			}
+			folder.ID = f.ID
+			err = dataprovider.UpdateFolder(dataProvider, folder)
+			logger.Debug(logSender, "", "restoring existing folder: %+v, dump file: %#v, error: %v", folder, inputFile, err)
+		} else {
+			err = dataprovider.AddFolder(dataProvider, folder)
+			logger.Debug(logSender, "", "adding new folder: %+v, dump file: %#v, error: %v", folder, inputFile, err)
+		}
+		if err != nil {
+			return err
 		}
-		if needQuotaScan(scanQuota, &user) {
-			if sftpd.AddQuotaScan(user.Username) {
-				logger.Debug(logSender, "", "starting quota scan for restored user: %#v", user.Username)
-				go doQuotaScan(user)
+		if scanQuota == 1 || (scanQuota == 2 && folder.HasQuotaRestrictions()) {
+			if sftpd.AddFolderQuotaScan(folder.Name) {
+				logger.Debug(logSender, "", "starting quota scan for restored folder: %#v", folder.Name)
+				go doFolderQuotaScan(folder)
 			}
 		}
 	}
-	logger.Debug(logSender, "", "backup restored, users: %v", len(dump.Users))
-	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
+	logger.Debug(logSender, "", "backup restored, folders: %v", len(folders))
+	return nil
+}
+
+func restoreUserTemplates(templates []dataprovider.UserTemplate, inputFile string, mode int) error {
+	for _, template := range templates {
+		t, err := dataprovider.UserTemplateExists(dataProvider, template.Name)
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing user template %#v not updated", t.Name)
+				continue
+			}
+			template.ID = t.ID
+			err = dataprovider.UpdateUserTemplate(dataProvider, template)
+			logger.Debug(logSender, "", "restoring existing user template %#v, dump file: %#v, error: %v", template.Name,
+				inputFile, err)
+		} else {
+			err = dataprovider.AddUserTemplate(dataProvider, template)
+			logger.Debug(logSender, "", "adding new user template %#v, dump file: %#v, error: %v", template.Name,
+				inputFile, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	logger.Debug(logSender, "", "backup restored, user templates: %v", len(templates))
+	return nil
+}
+
+func restoreUsers(users []dataprovider.User, inputFile string, scanQuota, mode int) error {
+	for _, user := range users {
+		if err := restoreUser(user, inputFile, scanQuota, mode); err != nil {
+			return err
+		}
+	}
+	logger.Debug(logSender, "", "backup restored, users: %v", len(users))
+	return nil
+}
+
+func restoreUser(user dataprovider.User, inputFile string, scanQuota, mode int) error {
+	u, err := dataprovider.UserExists(dataProvider, user.Username)
+	if err == nil {
+		if mode == 1 {
+			logger.Debug(logSender, "", "loaddata mode 1, existing user %#v not updated", u.Username)
+			return nil
+		}
+		user.ID = u.ID
+		user.LastLogin = u.LastLogin
+		user.UsedQuotaSize = u.UsedQuotaSize
+		user.UsedQuotaFiles = u.UsedQuotaFiles
+		// the backup replaces the existing user whatever its version
+		user.Version = 0
+		err = dataprovider.UpdateUser(dataProvider, user)
+		user.Password = "[redacted]"
+		logger.Debug(logSender, "", "restoring existing user: %+v, dump file: %#v, error: %v", user, inputFile, err)
+	} else {
+		user.LastLogin = 0
+		user.UsedQuotaSize = 0
+		user.UsedQuotaFiles = 0
+		err = dataprovider.AddUser(dataProvider, user)
+		user.Password = "[redacted]"
+		logger.Debug(logSender, "", "adding new user: %+v, dump file: %#v, error: %v", user, inputFile, err)
+	}
+	if err != nil {
+		return err
+	}
+	if needQuotaScan(scanQuota, &user) {
+		if sftpd.AddQuotaScan(user.Username) {
+			logger.Debug(logSender, "", "starting quota scan for restored user: %#v", user.Username)
+			go doQuotaScan(user)
+		}
+	}
+	return nil
 }
 
 func needQuotaScan(scanQuota int, user *dataprovider.User) bool {
@@ -164,3 +441,22 @@ func getLoaddataOptions(r *http.Request) (string, int, int, error) {
 	}
 	return inputFile, scanQuota, restoreMode, err
 }
+
+func getProviderMigrationReport(w http.ResponseWriter, r *http.Request) {
+	report, err := dataprovider.GetMigrationReport(dataProvider)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, report)
+}
+
+func syncProviderMigration(w http.ResponseWriter, r *http.Request) {
+	logger.Debug(logSender, "", "synchronizing the migration target")
+	report, err := dataprovider.SyncMigrationTarget(dataProvider)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, report)
+}
@@ -0,0 +1,438 @@
+package httpd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// user offboarding status
+const (
+	OffboardingRunning   = "running"
+	OffboardingCompleted = "completed"
+	OffboardingFailed    = "failed"
+)
+
+const (
+	// the configuration snapshots are saved inside this directory, relative to the backups path
+	offboardingSnapshotsDir = "offboarding"
+	offboardingTimeFormat   = "20060102T150405"
+)
+
+var (
+	offboardingConf  OffboardingConfig
+	offboardings     = make(map[string]*UserOffboarding)
+	offboardingMutex sync.RWMutex
+)
+
+// OffboardingConfig defines the configuration for the users offboarding
+type OffboardingConfig struct {
+	// Bucket for the home directory archives. If not configured, the home directories cannot be archived
+	ArchiveBucket OffboardingBucketConfig `json:"archive_bucket" mapstructure:"archive_bucket"`
+}
+
+// OffboardingBucketConfig defines an S3 or Google Cloud Storage bucket for the home directory archives
+type OffboardingBucketConfig struct {
+	// 0 disabled, 1 Amazon S3 compatible, 2 Google Cloud Storage
+	Provider int    `json:"provider" mapstructure:"provider"`
+	Bucket   string `json:"bucket" mapstructure:"bucket"`
+	// the archives are stored with this prefix, if not empty it must not start with "/"
+	KeyPrefix string `json:"key_prefix" mapstructure:"key_prefix"`
+	// S3 specific settings, leave the access key and the secret empty to use the default
+	// credentials chain
+	Region       string `json:"region" mapstructure:"region"`
+	AccessKey    string `json:"access_key" mapstructure:"access_key"`
+	AccessSecret string `json:"access_secret" mapstructure:"access_secret"`
+	Endpoint     string `json:"endpoint" mapstructure:"endpoint"`
+	StorageClass string `json:"storage_class" mapstructure:"storage_class"`
+	// Google Cloud Storage credentials file. This can be an absolute path or a path relative
+	// to the config dir. Leave empty to use the automatic credentials
+	CredentialsFile string `json:"credentials_file" mapstructure:"credentials_file"`
+}
+
+func (c *OffboardingBucketConfig) isEnabled() bool {
+	return c.Provider > 0
+}
+
+func (c *OffboardingBucketConfig) validate(configDir string) error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.Provider != 1 && c.Provider != 2 {
+		return fmt.Errorf("offboarding: invalid archive bucket provider %v", c.Provider)
+	}
+	if len(c.CredentialsFile) > 0 {
+		c.CredentialsFile = getConfigPath(c.CredentialsFile, configDir)
+	}
+	_, err := c.getFilesystem("")
+	if err != nil {
+		return fmt.Errorf("offboarding: invalid archive bucket: %v", err)
+	}
+	return nil
+}
+
+// getFilesystem returns the filesystem for the configured bucket
+func (c *OffboardingBucketConfig) getFilesystem(connectionID string) (vfs.Fs, error) {
+	if c.Provider == 2 {
+		config := vfs.GCSFsConfig{
+			Bucket:         c.Bucket,
+			KeyPrefix:      c.KeyPrefix,
+			CredentialFile: c.CredentialsFile,
+			StorageClass:   c.StorageClass,
+		}
+		if len(c.CredentialsFile) == 0 {
+			config.AutomaticCredentials = 1
+		}
+		return vfs.NewGCSFs(connectionID, os.TempDir(), config)
+	}
+	config := vfs.S3FsConfig{
+		Bucket:       c.Bucket,
+		KeyPrefix:    c.KeyPrefix,
+		Region:       c.Region,
+		AccessKey:    c.AccessKey,
+		Endpoint:     c.Endpoint,
+		StorageClass: c.StorageClass,
+	}
+	if len(c.AccessSecret) > 0 {
+		// the S3 filesystem expects an encrypted secret as for the users
+		secret, err := kms.Encrypt(c.AccessSecret)
+		if err != nil {
+			return nil, err
+		}
+		config.AccessSecret = secret
+	}
+	return vfs.NewS3Fs(connectionID, os.TempDir(), config)
+}
+
+// OffboardingRequest defines the options for a user offboarding
+type OffboardingRequest struct {
+	Username string `json:"username"`
+	// if true the user files are archived inside the configured bucket
+	ArchiveHome bool `json:"archive_home"`
+	// optional reason for the offboarding, it is included in the logs
+	Reason string `json:"reason,omitempty"`
+}
+
+// UserOffboarding defines the status of a user offboarding.
+// The offboarding saves a snapshot of the user configuration inside the backups path, disables
+// the user and revokes its public keys, closes the active connections and optionally archives
+// the user files. If the archive cannot be created the user is restored from the snapshot
+type UserOffboarding struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+	// username used for HTTP basic authentication by the admin that requested the offboarding
+	Actor string `json:"actor,omitempty"`
+	// start and end time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time,omitempty"`
+	// configuration snapshot path relative to the backups path, it can be restored using loaddata
+	Snapshot string `json:"snapshot,omitempty"`
+	// SHA256 fingerprints of the public keys revoked by the offboarding
+	RevokedKeys       []string `json:"revoked_keys,omitempty"`
+	ClosedConnections int      `json:"closed_connections"`
+	// archive path inside the configured bucket, empty if the home was not archived
+	Archive       string `json:"archive,omitempty"`
+	ArchivedFiles int    `json:"archived_files"`
+	ArchivedSize  int64  `json:"archived_size"`
+	Error         string `json:"error,omitempty"`
+	tenant        string
+}
+
+func (o *UserOffboarding) getACopy() UserOffboarding {
+	offboarding := *o
+	offboarding.RevokedKeys = make([]string, len(o.RevokedKeys))
+	copy(offboarding.RevokedKeys, o.RevokedKeys)
+	return offboarding
+}
+
+func getOffboardings(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, getOffboardingsStatus(getAdminScope(r.Context())))
+}
+
+// getOffboardingsStatus returns the running and finished offboardings for the users in the
+// given scope, ordered by username
+func getOffboardingsStatus(scope dataprovider.UserScope) []UserOffboarding {
+	offboardingMutex.RLock()
+	defer offboardingMutex.RUnlock()
+
+	result := make([]UserOffboarding, 0, len(offboardings))
+	for _, o := range offboardings {
+		if scope.IsInScope(o.Username, o.tenant) {
+			result = append(result, o.getACopy())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Username < result[j].Username
+	})
+	return result
+}
+
+func getOffboarding(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	scope := getAdminScope(r.Context())
+	offboardingMutex.RLock()
+	defer offboardingMutex.RUnlock()
+
+	if o, ok := offboardings[username]; ok && scope.IsInScope(o.Username, o.tenant) {
+		render.JSON(w, r, o.getACopy())
+		return
+	}
+	sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+}
+
+func startOffboarding(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var req OffboardingRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if req.ArchiveHome && !offboardingConf.ArchiveBucket.isEnabled() {
+		sendAPIResponse(w, r, errors.New("no archive bucket is configured, the home cannot be archived"), "",
+			http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), req.Username)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	offboarding, ok := addOffboarding(user, req.Reason, getRequestActor(r))
+	if !ok {
+		sendAPIResponse(w, r, nil, "Another offboarding is already in progress", http.StatusConflict)
+		return
+	}
+	go doOffboarding(user.Username, offboarding, req.ArchiveHome)
+	sendAPIResponse(w, r, nil, "Offboarding started", http.StatusCreated)
+}
+
+// addOffboarding adds a new running offboarding for the given user replacing the results of
+// a finished one, if any. Returns false if the user has an offboarding already running
+func addOffboarding(user dataprovider.User, reason, actor string) (*UserOffboarding, bool) {
+	offboardingMutex.Lock()
+	defer offboardingMutex.Unlock()
+
+	if o, ok := offboardings[user.Username]; ok && o.Status == OffboardingRunning {
+		return nil, false
+	}
+	offboarding := &UserOffboarding{
+		Username:  user.Username,
+		Status:    OffboardingRunning,
+		Reason:    reason,
+		Actor:     actor,
+		StartTime: utils.GetTimeAsMsSinceEpoch(time.Now()),
+		tenant:    user.Filters.Tenant,
+	}
+	offboardings[user.Username] = offboarding
+	return offboarding, true
+}
+
+func updateOffboarding(offboarding *UserOffboarding, fn func(o *UserOffboarding)) {
+	offboardingMutex.Lock()
+	defer offboardingMutex.Unlock()
+
+	fn(offboarding)
+}
+
+func doOffboarding(username string, offboarding *UserOffboarding, archiveHome bool) {
+	err := offboardUser(username, offboarding, archiveHome)
+	updateOffboarding(offboarding, func(o *UserOffboarding) {
+		o.EndTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+		if err != nil {
+			o.Status = OffboardingFailed
+			o.Error = err.Error()
+		} else {
+			o.Status = OffboardingCompleted
+		}
+	})
+	if err != nil {
+		logger.Warn(logSender, "", "offboarding failed for user %#v, requested by %#v: %v", username,
+			offboarding.Actor, err)
+		return
+	}
+	logger.Info(logSender, "", "user %#v offboarded, requested by %#v, reason: %#v, snapshot: %#v, archive: %#v, "+
+		"revoked keys: %v, closed connections: %v", username, offboarding.Actor, offboarding.Reason,
+		offboarding.Snapshot, offboarding.Archive, offboarding.RevokedKeys, offboarding.ClosedConnections)
+}
+
+// offboardUser snapshots the user configuration, disables the user, revokes its public keys and
+// closes its connections. If requested, the user files are then archived: if the archive fails
+// the user and the snapshot are restored so the offboarding can be retried
+func offboardUser(username string, offboarding *UserOffboarding, archiveHome bool) error {
+	user, err := dataprovider.GetUserForBackup(dataProvider, username)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	snapshot := filepath.Join(offboardingSnapshotsDir, fmt.Sprintf("%v-%v.json", username, now.Format(offboardingTimeFormat)))
+	snapshotPath := filepath.Join(backupsPath, snapshot)
+	if err = saveOffboardingSnapshot(user, snapshotPath); err != nil {
+		return fmt.Errorf("unable to save the configuration snapshot: %v", err)
+	}
+	offboarded, err := dataprovider.OffboardUser(dataProvider, user)
+	if err != nil {
+		os.Remove(snapshotPath)
+		return err
+	}
+	revokedKeys := []string{}
+	for _, fp := range offboarded.Filters.RevokedKeyFingerprints {
+		if !utils.IsStringInSlice(fp, user.Filters.RevokedKeyFingerprints) {
+			revokedKeys = append(revokedKeys, fp)
+		}
+	}
+	closedConnections := sftpd.CloseUserConnections(username)
+	updateOffboarding(offboarding, func(o *UserOffboarding) {
+		o.Snapshot = snapshot
+		o.RevokedKeys = revokedKeys
+		o.ClosedConnections = closedConnections
+	})
+	if !archiveHome {
+		return nil
+	}
+	archive := fmt.Sprintf("%v-%v.tar.gz", username, now.Format(offboardingTimeFormat))
+	numFiles, size, err := archiveUserFiles(offboarded, archive)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to archive the files for user %#v, restoring the user: %v", username, err)
+		// the version was incremented offboarding the user
+		user.Version = 0
+		if errRestore := dataprovider.UpdateUser(dataProvider, user); errRestore != nil {
+			logger.Warn(logSender, "", "unable to restore user %#v, the snapshot %#v is preserved: %v", username,
+				snapshotPath, errRestore)
+			return fmt.Errorf("unable to archive the user files: %v, the user cannot be restored: %v", err, errRestore)
+		}
+		os.Remove(snapshotPath)
+		updateOffboarding(offboarding, func(o *UserOffboarding) {
+			o.Snapshot = ""
+			o.RevokedKeys = nil
+		})
+		return fmt.Errorf("unable to archive the user files: %v", err)
+	}
+	updateOffboarding(offboarding, func(o *UserOffboarding) {
+		o.Archive = archive
+		o.ArchivedFiles = numFiles
+		o.ArchivedSize = size
+	})
+	return nil
+}
+
+// saveOffboardingSnapshot saves the given user using the same format as the dumps,
+// so it can be restored using loaddata
+func saveOffboardingSnapshot(user dataprovider.User, snapshotPath string) error {
+	dump, err := json.Marshal(dataprovider.BackupData{
+		Users: []dataprovider.User{user},
+	})
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(snapshotPath), 0700)
+	return ioutil.WriteFile(snapshotPath, dump, 0600)
+}
+
+// archiveUserFiles archives the files inside the user home dir and virtual folders into a
+// compressed tar archive stored inside the configured bucket
+func archiveUserFiles(user dataprovider.User, archive string) (int, int64, error) {
+	fs, err := user.GetFilesystem("")
+	if err != nil {
+		return 0, 0, err
+	}
+	archiveFs, err := offboardingConf.ArchiveBucket.getFilesystem("")
+	if err != nil {
+		return 0, 0, err
+	}
+	archivePath, err := archiveFs.ResolvePath("/" + archive)
+	if err != nil {
+		return 0, 0, err
+	}
+	file, w, cancelFn, err := archiveFs.Create(archivePath, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	var dst io.WriteCloser = w
+	if file != nil {
+		dst = file
+	}
+	numFiles := 0
+	var size int64
+	gzw := gzip.NewWriter(dst)
+	tw := tar.NewWriter(gzw)
+	err = walkUserFiles(user, fs, func(fsPath, virtualPath string, info os.FileInfo) error {
+		if err := addOffboardingArchiveEntry(fs, fsPath, strings.TrimPrefix(virtualPath, "/"), info, tw); err != nil {
+			return err
+		}
+		numFiles++
+		size += info.Size()
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gzw.Close()
+	}
+	if err != nil && cancelFn != nil {
+		cancelFn()
+	}
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		if errRemove := archiveFs.Remove(archivePath, false); errRemove != nil && !archiveFs.IsNotExist(errRemove) {
+			logger.Warn(logSender, "", "unable to remove the incomplete archive %#v: %v", archivePath, errRemove)
+		}
+		return 0, 0, err
+	}
+	return numFiles, size, nil
+}
+
+func addOffboardingArchiveEntry(fs vfs.Fs, fsPath, name string, info os.FileInfo, tw *tar.Writer) error {
+	file, r, cancelFn, err := fs.Open(fsPath)
+	if err != nil {
+		return err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var src io.ReadCloser = r
+	if file != nil {
+		src = file
+	}
+	defer src.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, src)
+	return err
+}
@@ -0,0 +1,88 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getUserOverrides(w http.ResponseWriter, r *http.Request) {
+	scope := getAdminScope(r.Context())
+	overrides := []dataprovider.UserOverride{}
+	for _, override := range dataprovider.GetUserOverrides() {
+		if isUsernameInScope(override.Username, scope) {
+			overrides = append(overrides, override)
+		}
+	}
+	render.JSON(w, r, overrides)
+}
+
+func getUserOverride(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !checkUsernameInScope(w, r, username) {
+		return
+	}
+	override, err := dataprovider.GetUserOverride(username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, override)
+}
+
+func addUserOverride(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var override dataprovider.UserOverride
+	err := render.DecodeJSON(r.Body, &override)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if !checkUsernameInScope(w, r, override.Username) {
+		return
+	}
+	override, err = dataprovider.AddUserOverride(dataProvider, override, getRequestActor(r),
+		utils.GetIPFromRemoteAddress(r.RemoteAddr))
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, override)
+}
+
+func deleteUserOverride(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !checkUsernameInScope(w, r, username) {
+		return
+	}
+	err := dataprovider.DeleteUserOverride(username, getRequestActor(r),
+		utils.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	sendAPIResponse(w, r, nil, "User override deleted", http.StatusOK)
+}
+
+func getUserOverridesAudit(w http.ResponseWriter, r *http.Request) {
+	scope := getAdminScope(r.Context())
+	records := []dataprovider.UserOverrideAuditRecord{}
+	for _, record := range dataprovider.GetUserOverridesAuditRecords() {
+		if isUsernameInScope(record.Override.Username, scope) {
+			records = append(records, record)
+		}
+	}
+	render.JSON(w, r, records)
+}
+
+// getRequestActor returns the username used for HTTP basic authentication, if any
+func getRequestActor(r *http.Request) string {
+	username, _, _ := r.BasicAuth()
+	return username
+}
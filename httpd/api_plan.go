@@ -0,0 +1,146 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := dataprovider.GetPlans(dataProvider)
+	if err == nil {
+		render.JSON(w, r, plans)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func getPlanByID(w http.ResponseWriter, r *http.Request) {
+	planID, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid planID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	plan, err := dataprovider.GetPlanByID(dataProvider, planID)
+	if err == nil {
+		render.JSON(w, r, plan)
+	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func addPlan(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var plan dataprovider.Plan
+	err := render.DecodeJSON(r.Body, &plan)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddPlan(dataProvider, plan)
+	if err == nil {
+		plan, err = dataprovider.PlanExists(dataProvider, plan.Name)
+		if err == nil {
+			render.JSON(w, r, plan)
+		} else {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		}
+	} else {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	}
+}
+
+func updatePlan(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	planID, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid planID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	async := false
+	if _, ok := r.URL.Query()["async"]; ok {
+		async, err = strconv.ParseBool(r.URL.Query().Get("async"))
+		if err != nil {
+			err = errors.New("Invalid async")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	plan, err := dataprovider.GetPlanByID(dataProvider, planID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	// empty lists are omitted from the JSON representation, so missing lists mean no restrictions
+	plan.AllowedFsProviders = nil
+	plan.DeniedLoginMethods = nil
+	err = render.DecodeJSON(r.Body, &plan)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if plan.ID != planID {
+		sendAPIResponse(w, r, err, "plan ID in request body does not match plan ID in path parameter", http.StatusBadRequest)
+		return
+	}
+	if async {
+		err = dataprovider.UpdatePlanAsync(dataProvider, plan)
+	} else {
+		err = dataprovider.UpdatePlan(dataProvider, plan)
+	}
+	if err == dataprovider.ErrPlanPropagationInProgress {
+		sendAPIResponse(w, r, err, "", http.StatusConflict)
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else if async {
+		sendAPIResponse(w, r, err, "Plan updated, the assigned users are being updated", http.StatusAccepted)
+	} else {
+		sendAPIResponse(w, r, err, "Plan updated", http.StatusOK)
+	}
+}
+
+func deletePlan(w http.ResponseWriter, r *http.Request) {
+	planID, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid planID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	plan, err := dataprovider.GetPlanByID(dataProvider, planID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	err = dataprovider.DeletePlan(dataProvider, plan)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "Plan deleted", http.StatusOK)
+	}
+}
+
+func getPlanPropagations(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, dataprovider.GetPlanPropagations())
+}
+
+func getPlanPropagation(w http.ResponseWriter, r *http.Request) {
+	if propagation, ok := dataprovider.GetPlanPropagation(chi.URLParam(r, "name")); ok {
+		render.JSON(w, r, propagation)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+}
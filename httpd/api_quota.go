@@ -1,27 +1,66 @@
 package httpd
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"path"
 
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/vfs"
+	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 )
 
+// userFolderQuotaScanRequest defines the virtual folder to scan for a user
+type userFolderQuotaScanRequest struct {
+	VirtualPath string `json:"virtual_path"`
+}
+
 func getQuotaScans(w http.ResponseWriter, r *http.Request) {
-	render.JSON(w, r, sftpd.GetQuotaScans())
+	render.JSON(w, r, filterQuotaScansByScope(sftpd.GetQuotaScans(), getAdminScope(r.Context())))
+}
+
+// filterQuotaScansByScope returns the quota scans for the users included in the given scope
+func filterQuotaScansByScope(scans []sftpd.ActiveQuotaScan, scope dataprovider.UserScope) []sftpd.ActiveQuotaScan {
+	if scope.IsEmpty() {
+		return scans
+	}
+	result := []sftpd.ActiveQuotaScan{}
+	for _, scan := range scans {
+		if isUsernameInScope(scan.Username, scope) {
+			result = append(result, scan)
+		}
+	}
+	return result
+}
+
+func getQuotaScan(w http.ResponseWriter, r *http.Request) {
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), chi.URLParam(r, "username"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	scan, ok := sftpd.GetQuotaScan(user.Username)
+	if !ok {
+		err = fmt.Errorf("no quota scan in progress for user %#v", user.Username)
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, scan)
 }
 
 func startQuotaScan(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limitRequestBody(w, r, apiBodyLimit)
 	var u dataprovider.User
 	err := render.DecodeJSON(r.Body, &u)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	user, err := dataprovider.UserExists(dataProvider, u.Username)
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), u.Username)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusNotFound)
 		return
@@ -34,6 +73,53 @@ func startQuotaScan(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startUserFolderQuotaScan starts a quota scan limited to a virtual folder of the given user.
+// Only the virtual folders referencing a shared folder can be scanned alone, the used quota
+// for the other virtual folders is included in the user one
+func startUserFolderQuotaScan(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), chi.URLParam(r, "username"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	var req userFolderQuotaScanRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if len(req.VirtualPath) == 0 {
+		sendAPIResponse(w, r, errors.New("virtual_path is mandatory"), "", http.StatusBadRequest)
+		return
+	}
+	virtualPath := path.Clean("/" + req.VirtualPath)
+	var virtualFolder *vfs.VirtualFolder
+	for idx := range user.VirtualFolders {
+		if user.VirtualFolders[idx].VirtualPath == virtualPath {
+			virtualFolder = &user.VirtualFolders[idx]
+			break
+		}
+	}
+	if virtualFolder == nil {
+		err = fmt.Errorf("virtual folder %#v not found for user %#v", virtualPath, user.Username)
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	if !virtualFolder.IsShared() {
+		err = fmt.Errorf("the used quota for the virtual folder %#v is included in the user one, start a quota "+
+			"scan for the user instead", virtualPath)
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	folder, err := dataprovider.FolderExists(dataProvider, virtualFolder.Name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	}
+	startFolderScan(w, r, folder)
+}
+
 func doQuotaScan(user dataprovider.User) error {
 	defer sftpd.RemoveQuotaScan(user.Username)
 	fs, err := user.GetFilesystem("")
@@ -41,7 +127,9 @@ func doQuotaScan(user dataprovider.User) error {
 		logger.Warn(logSender, "", "unable scan quota for user %#v error creating filesystem: %v", user.Username, err)
 		return err
 	}
-	numFiles, size, err := fs.ScanRootDirContents()
+	progress := sftpd.GetQuotaScanProgress(user.Username)
+	progress.SetExpected(user.UsedQuotaFiles, user.UsedQuotaSize)
+	numFiles, size, err := vfs.ScanRootDirContentsWithProgress(fs, progress)
 	if err != nil {
 		logger.Warn(logSender, "", "error scanning user home dir %#v: %v", user.Username, err)
 	} else {
@@ -0,0 +1,60 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getTransferReceipts(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	limit := 100
+	offset := 0
+	var err error
+	if _, ok := r.URL.Query()["limit"]; ok {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 0 {
+			sendAPIResponse(w, r, errors.New("Invalid limit"), "", http.StatusBadRequest)
+			return
+		}
+		if limit > 500 {
+			limit = 500
+		}
+	}
+	if _, ok := r.URL.Query()["offset"]; ok {
+		offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
+		if err != nil || offset < 0 {
+			sendAPIResponse(w, r, errors.New("Invalid offset"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	if !checkUsernameInScope(w, r, username) {
+		return
+	}
+	receipts, err := sftpd.GetTransferReceipts(username, limit, offset)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, receipts)
+}
+
+func getTransferReceipt(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if !checkUsernameInScope(w, r, username) {
+		return
+	}
+	receipt, err := sftpd.GetTransferReceipt(username, chi.URLParam(r, "receiptID"))
+	if err == sftpd.ErrReceiptNotFound {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, receipt)
+}
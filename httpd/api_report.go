@@ -0,0 +1,354 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultStaleFilesOlderThanDays = 90
+	defaultStaleFilesTopFiles      = 10
+	maxStaleFilesTopFiles          = 1000
+)
+
+var (
+	staleFilesReportConf       StaleFilesReportConfig
+	staleFilesReportsPath      string
+	staleFilesReportTicker     *time.Ticker
+	staleFilesReportTickerDone chan bool
+	staleFilesReportMutex      sync.Mutex
+)
+
+// StaleFilesReportConfig defines the configuration for the stale files report.
+// The report lists, for each user, the files not modified for the configured number of days,
+// nothing is deleted. It can be generated on demand using the REST API and periodically
+type StaleFilesReportConfig struct {
+	// Interval between two scheduled reports as hours. 0 means disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Files not modified for this number of days are considered stale. This is the default
+	// for the reports generated using the REST API too
+	OlderThanDays int `json:"older_than_days" mapstructure:"older_than_days"`
+	// Number of largest stale files to include, for each user, in the report
+	TopFiles int `json:"top_files" mapstructure:"top_files"`
+	// Directory where the scheduled reports are saved as JSON files.
+	// This can be an absolute path or a path relative to the config dir
+	ReportsPath string `json:"reports_path" mapstructure:"reports_path"`
+}
+
+// StaleFile defines a file not modified for the configured number of days
+type StaleFile struct {
+	// SFTP/SCP path for the file
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// last modification time as unix timestamp in milliseconds
+	ModTime int64 `json:"last_modified"`
+}
+
+// StaleFilesFolder defines the stale files stats for a top level directory or a virtual folder
+type StaleFilesFolder struct {
+	// SFTP/SCP path for the directory or virtual folder
+	Path     string `json:"path"`
+	NumFiles int    `json:"files"`
+	Size     int64  `json:"size"`
+}
+
+// StaleFilesReport defines the stale files report for a user
+type StaleFilesReport struct {
+	Username      string `json:"username"`
+	OlderThanDays int    `json:"older_than_days"`
+	NumFiles      int    `json:"files"`
+	Size          int64  `json:"size"`
+	// stats for the top level directories and the virtual folders containing stale files
+	Folders []StaleFilesFolder `json:"folders"`
+	// the largest stale files
+	TopFiles []StaleFile `json:"top_files"`
+	// report generation time as unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// not empty if the user files cannot be scanned, the report can be partial
+	Error string `json:"error,omitempty"`
+}
+
+func (r *StaleFilesReport) addFile(folder string, file StaleFile, topFiles int) {
+	r.NumFiles++
+	r.Size += file.Size
+	found := false
+	for idx := range r.Folders {
+		if r.Folders[idx].Path == folder {
+			r.Folders[idx].NumFiles++
+			r.Folders[idx].Size += file.Size
+			found = true
+			break
+		}
+	}
+	if !found {
+		r.Folders = append(r.Folders, StaleFilesFolder{
+			Path:     folder,
+			NumFiles: 1,
+			Size:     file.Size,
+		})
+	}
+	r.TopFiles = append(r.TopFiles, file)
+	// we sort and truncate only when needed to avoid sorting for each file
+	if len(r.TopFiles) >= 2*topFiles {
+		r.sortAndTruncate(topFiles)
+	}
+}
+
+func (r *StaleFilesReport) sortAndTruncate(topFiles int) {
+	sort.SliceStable(r.TopFiles, func(i, j int) bool {
+		if r.TopFiles[i].Size == r.TopFiles[j].Size {
+			return r.TopFiles[i].ModTime < r.TopFiles[j].ModTime
+		}
+		return r.TopFiles[i].Size > r.TopFiles[j].Size
+	})
+	if len(r.TopFiles) > topFiles {
+		r.TopFiles = r.TopFiles[:topFiles]
+	}
+}
+
+func (r *StaleFilesReport) finalize(topFiles int) {
+	r.sortAndTruncate(topFiles)
+	sort.SliceStable(r.Folders, func(i, j int) bool {
+		return r.Folders[i].Size > r.Folders[j].Size
+	})
+	if r.Folders == nil {
+		r.Folders = []StaleFilesFolder{}
+	}
+	if r.TopFiles == nil {
+		r.TopFiles = []StaleFile{}
+	}
+}
+
+func getStaleFilesReport(w http.ResponseWriter, r *http.Request) {
+	olderThanDays := staleFilesReportConf.OlderThanDays
+	topFiles := staleFilesReportConf.TopFiles
+	var err error
+	if _, ok := r.URL.Query()["older_than_days"]; ok {
+		olderThanDays, err = strconv.Atoi(r.URL.Query().Get("older_than_days"))
+		if err != nil || olderThanDays <= 0 {
+			err = errors.New("Invalid older_than_days")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["top_files"]; ok {
+		topFiles, err = strconv.Atoi(r.URL.Query().Get("top_files"))
+		if err != nil || topFiles < 0 || topFiles > maxStaleFilesTopFiles {
+			err = fmt.Errorf("Invalid top_files, valid range is 0-%v", maxStaleFilesTopFiles)
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	scope := getAdminScope(r.Context())
+	var users []dataprovider.User
+	if username := r.URL.Query().Get("username"); len(username) > 0 {
+		user, err := dataprovider.UserExistsInScope(dataProvider, scope, username)
+		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+			sendAPIResponse(w, r, err, "", http.StatusNotFound)
+			return
+		} else if err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+			return
+		}
+		users = append(users, user)
+	} else {
+		allUsers, err := dataprovider.DumpUsers(dataProvider)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+			return
+		}
+		for _, user := range allUsers {
+			if scope.IsUserInScope(&user) {
+				users = append(users, user)
+			}
+		}
+	}
+	render.JSON(w, r, generateStaleFilesReports(users, olderThanDays, topFiles))
+}
+
+func generateStaleFilesReports(users []dataprovider.User, olderThanDays, topFiles int) []StaleFilesReport {
+	reports := make([]StaleFilesReport, 0, len(users))
+	for _, user := range users {
+		reports = append(reports, generateUserStaleFilesReport(user, olderThanDays, topFiles))
+	}
+	return reports
+}
+
+func generateUserStaleFilesReport(user dataprovider.User, olderThanDays, topFiles int) StaleFilesReport {
+	now := time.Now()
+	report := StaleFilesReport{
+		Username:      user.Username,
+		OlderThanDays: olderThanDays,
+		Timestamp:     utils.GetTimeAsMsSinceEpoch(now),
+	}
+	err := scanUserStaleFiles(user, &report, now, topFiles)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.finalize(topFiles)
+	return report
+}
+
+func scanUserStaleFiles(user dataprovider.User, report *StaleFilesReport, now time.Time, topFiles int) error {
+	fs, err := user.GetFilesystem("")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to generate the stale files report for user %#v, error creating filesystem: %v",
+			user.Username, err)
+		return err
+	}
+	limit := now.Add(-time.Duration(report.OlderThanDays) * 24 * time.Hour)
+	return walkUserFiles(user, fs, func(fsPath, virtualPath string, info os.FileInfo) error {
+		if !info.ModTime().Before(limit) {
+			return nil
+		}
+		report.addFile(getStaleFilesFolder(user, virtualPath), StaleFile{
+			Path:    virtualPath,
+			Size:    info.Size(),
+			ModTime: utils.GetTimeAsMsSinceEpoch(info.ModTime()),
+		}, topFiles)
+		return nil
+	})
+}
+
+// walkUserFiles calls walkFn for each regular file inside the user home dir and virtual folders.
+// An error walking a root, including the ones returned by walkFn, is logged and the next root is
+// walked, the last error is returned
+func walkUserFiles(user dataprovider.User, fs vfs.Fs, walkFn func(fsPath, virtualPath string, info os.FileInfo) error) error {
+	var walkErr error
+	roots := []string{"/"}
+	for _, v := range user.VirtualFolders {
+		roots = append(roots, v.VirtualPath)
+	}
+	for _, root := range roots {
+		fsRoot, err := fs.ResolvePath(root)
+		if err == nil {
+			err = fs.Walk(fsRoot, func(walkedPath string, info os.FileInfo, err error) error {
+				if err != nil {
+					if fs.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				return walkFn(walkedPath, fs.GetRelativePath(walkedPath), info)
+			})
+		}
+		if err != nil && !fs.IsNotExist(err) {
+			logger.Warn(logSender, "", "error walking path %#v for user %#v: %v", root, user.Username, err)
+			walkErr = err
+		}
+	}
+	return walkErr
+}
+
+// getStaleFilesFolder returns the virtual folder containing the given SFTP path,
+// if any, or its top level directory
+func getStaleFilesFolder(user dataprovider.User, sftpPath string) string {
+	folder := ""
+	for _, v := range user.VirtualFolders {
+		if strings.HasPrefix(sftpPath, v.VirtualPath+"/") && len(v.VirtualPath) > len(folder) {
+			folder = v.VirtualPath
+		}
+	}
+	if len(folder) > 0 {
+		return folder
+	}
+	dir := path.Dir(sftpPath)
+	if dir == "/" || dir == "." {
+		return "/"
+	}
+	return "/" + strings.Split(strings.TrimPrefix(dir, "/"), "/")[0]
+}
+
+func startStaleFilesReportScheduler(conf StaleFilesReportConfig, configDir string) {
+	staleFilesReportConf = conf
+	if staleFilesReportConf.OlderThanDays <= 0 {
+		staleFilesReportConf.OlderThanDays = defaultStaleFilesOlderThanDays
+	}
+	if staleFilesReportConf.TopFiles <= 0 || staleFilesReportConf.TopFiles > maxStaleFilesTopFiles {
+		staleFilesReportConf.TopFiles = defaultStaleFilesTopFiles
+	}
+	staleFilesReportsPath = getConfigPath(staleFilesReportConf.ReportsPath, configDir)
+	if staleFilesReportTicker != nil {
+		staleFilesReportTicker.Stop()
+		staleFilesReportTickerDone <- true
+		staleFilesReportTicker = nil
+	}
+	if staleFilesReportConf.Interval <= 0 {
+		return
+	}
+	logger.Info(logSender, "", "scheduling stale files report each %v hours", staleFilesReportConf.Interval)
+	staleFilesReportTicker = time.NewTicker(time.Duration(staleFilesReportConf.Interval) * time.Hour)
+	staleFilesReportTickerDone = make(chan bool)
+	go func(ticker *time.Ticker, done chan bool) {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				saveStaleFilesReport()
+			}
+		}
+	}(staleFilesReportTicker, staleFilesReportTickerDone)
+}
+
+// saveStaleFilesReport generates the stale files report for all the users
+// and saves it inside the configured reports path
+func saveStaleFilesReport() (string, error) {
+	staleFilesReportMutex.Lock()
+	defer staleFilesReportMutex.Unlock()
+
+	if len(staleFilesReportsPath) == 0 {
+		err := errors.New("invalid reports path")
+		logger.Warn(logSender, "", "unable to save the stale files report: %v", err)
+		return "", err
+	}
+	users, err := dataprovider.DumpUsers(dataProvider)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get the users for the stale files report: %v", err)
+		return "", err
+	}
+	reports := generateStaleFilesReports(users, staleFilesReportConf.OlderThanDays, staleFilesReportConf.TopFiles)
+	numFiles := 0
+	size := int64(0)
+	for _, report := range reports {
+		numFiles += report.NumFiles
+		size += report.Size
+	}
+	err = os.MkdirAll(staleFilesReportsPath, 0700)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to create the stale files reports dir %#v: %v", staleFilesReportsPath, err)
+		return "", err
+	}
+	reportFile := filepath.Join(staleFilesReportsPath, fmt.Sprintf("stale_files_%v.json", time.Now().Format("20060102150405")))
+	data, err := json.Marshal(reports)
+	if err == nil {
+		err = ioutil.WriteFile(reportFile, data, 0600)
+	}
+	if err != nil {
+		logger.Warn(logSender, "", "unable to save the stale files report %#v: %v", reportFile, err)
+		return "", err
+	}
+	logger.Info(logSender, "", "stale files report saved to %#v, users: %v, stale files: %v, size: %v", reportFile,
+		len(reports), numFiles, size)
+	return reportFile, nil
+}
@@ -0,0 +1,221 @@
+package httpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+	"github.com/go-chi/render"
+)
+
+const (
+	syncAuthenticationRealm = "SFTPGo Sync"
+	// default validity for the pre-signed URLs as seconds
+	defaultPresignedURLExpiration = 300
+)
+
+type syncContextKey string
+
+var (
+	syncAPIConf          SyncAPIConfig
+	syncConnectionCtxKey = syncContextKey("sync_connection")
+)
+
+// SyncAPIConfig defines the configuration for the sync API.
+// The sync API allows the SFTPGo users to mirror a local directory tree: the client gets a plan,
+// comparing its manifest with the files on the server, and then it uploads only the changed files.
+// The users authenticate using HTTP basic authentication with their SFTPGo credentials and
+// the same permissions, quota and filters as for SFTP are applied
+type SyncAPIConfig struct {
+	// Set to true to enable the sync API
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Set to true to redirect the downloads and the uploads for the users stored on S3 and
+	// Google Cloud Storage to short-lived pre-signed URLs, so the contents are transferred
+	// directly between the client and the storage backend instead of streaming through SFTPGo
+	PresignedURLs bool `json:"presigned_urls" mapstructure:"presigned_urls"`
+	// Validity for the pre-signed URLs as seconds. 0 means the default, 300 seconds
+	PresignedURLExpiration int `json:"presigned_url_expiration" mapstructure:"presigned_url_expiration"`
+}
+
+func (c *SyncAPIConfig) getPresignedURLExpiration() time.Duration {
+	if c.PresignedURLExpiration <= 0 {
+		return defaultPresignedURLExpiration * time.Second
+	}
+	return time.Duration(c.PresignedURLExpiration) * time.Second
+}
+
+func checkSyncAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			sendSyncUnauthorized(w, r)
+			return
+		}
+		connection, err := sftpd.NewSyncConnection(username, password, r.RemoteAddr)
+		if err != nil {
+			if err == sftpd.ErrSyncAuthentication {
+				sendSyncUnauthorized(w, r)
+				return
+			}
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), syncConnectionCtxKey, connection)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func sendSyncUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(authenticationHeader, fmt.Sprintf("Basic realm=\"%v\"", syncAuthenticationRealm))
+	sendAPIResponse(w, r, errors.New(unauthResponse), "", http.StatusUnauthorized)
+}
+
+func getSyncManifest(w http.ResponseWriter, r *http.Request) {
+	connection := r.Context().Value(syncConnectionCtxKey).(*sftpd.SyncConnection)
+	withHash := false
+	if _, ok := r.URL.Query()["hash"]; ok {
+		var err error
+		withHash, err = strconv.ParseBool(r.URL.Query().Get("hash"))
+		if err != nil {
+			sendAPIResponse(w, r, err, "Invalid hash parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	manifest, err := connection.GetManifest(getSyncRequestPath(r), withHash)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+		return
+	}
+	render.JSON(w, r, manifest)
+}
+
+func getSyncPlan(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, syncManifestBodyLimit)
+	connection := r.Context().Value(syncConnectionCtxKey).(*sftpd.SyncConnection)
+	var manifest []sftpd.SyncManifestEntry
+	err := render.DecodeJSON(r.Body, &manifest)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	plan, err := connection.GetSyncPlan(getSyncRequestPath(r), manifest)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+		return
+	}
+	render.JSON(w, r, plan)
+}
+
+func downloadSyncFile(w http.ResponseWriter, r *http.Request) {
+	connection := r.Context().Value(syncConnectionCtxKey).(*sftpd.SyncConnection)
+	if redirectToPresignedURL(w, r, connection, false) {
+		return
+	}
+	writer := &syncDownloadWriter{w: w}
+	_, err := connection.DownloadFile(getSyncRequestPath(r), writer)
+	if err != nil && !writer.headerWritten {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+	}
+}
+
+func uploadSyncFile(w http.ResponseWriter, r *http.Request) {
+	connection := r.Context().Value(syncConnectionCtxKey).(*sftpd.SyncConnection)
+	if redirectToPresignedURL(w, r, connection, true) {
+		return
+	}
+	var modTime time.Time
+	if _, ok := r.URL.Query()["mtime"]; ok {
+		mtime, err := strconv.ParseInt(r.URL.Query().Get("mtime"), 10, 64)
+		if err != nil || mtime < 0 {
+			sendAPIResponse(w, r, err, "Invalid mtime parameter", http.StatusBadRequest)
+			return
+		}
+		modTime = utils.GetTimeFromMsecSinceEpoch(mtime)
+	}
+	_, err := connection.UploadFile(getSyncRequestPath(r), r.Body, r.ContentLength, modTime)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "File uploaded", http.StatusCreated)
+}
+
+func createSyncDir(w http.ResponseWriter, r *http.Request) {
+	connection := r.Context().Value(syncConnectionCtxKey).(*sftpd.SyncConnection)
+	err := connection.CreateDir(getSyncRequestPath(r))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Directory created", http.StatusCreated)
+}
+
+func removeSyncPath(w http.ResponseWriter, r *http.Request) {
+	connection := r.Context().Value(syncConnectionCtxKey).(*sftpd.SyncConnection)
+	err := connection.Remove(getSyncRequestPath(r))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Removed", http.StatusOK)
+}
+
+// redirectToPresignedURL redirects the request to a pre-signed URL, if enabled and supported,
+// and returns true if the request was handled. An error is sent if the transfer is not allowed
+func redirectToPresignedURL(w http.ResponseWriter, r *http.Request, connection *sftpd.SyncConnection, upload bool) bool {
+	if !syncAPIConf.PresignedURLs {
+		return false
+	}
+	url, err := connection.GetPresignedURL(getSyncRequestPath(r), upload, syncAPIConf.getPresignedURLExpiration())
+	if err == vfs.ErrPresignedURLUnsupported {
+		return false
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSyncRespStatus(err))
+		return true
+	}
+	// 307 preserves the method and the body
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	return true
+}
+
+// syncDownloadWriter writes the response header before the first write, so an error
+// response can still be sent if the download fails before any data is written
+type syncDownloadWriter struct {
+	w             http.ResponseWriter
+	headerWritten bool
+}
+
+func (d *syncDownloadWriter) Write(p []byte) (int, error) {
+	if !d.headerWritten {
+		d.headerWritten = true
+		d.w.Header().Set("Content-Type", "application/octet-stream")
+		d.w.WriteHeader(http.StatusOK)
+	}
+	return d.w.Write(p)
+}
+
+func getSyncRequestPath(r *http.Request) string {
+	return utils.CleanSFTPPath(r.URL.Query().Get("path"))
+}
+
+func getSyncRespStatus(err error) int {
+	if _, ok := err.(*sftpd.SyncRequestError); ok {
+		return http.StatusBadRequest
+	}
+	switch err {
+	case sftpd.ErrSyncPermissionDenied:
+		return http.StatusForbidden
+	case sftpd.ErrSyncNotFound:
+		return http.StatusNotFound
+	case sftpd.ErrSyncQuotaExceeded:
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,209 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getUserTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := dataprovider.GetUserTemplates(dataProvider)
+	if err == nil {
+		for idx := range templates {
+			dataprovider.HideUserSensitiveData(&templates[idx].User)
+		}
+		render.JSON(w, r, templates)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func getUserTemplateByID(w http.ResponseWriter, r *http.Request) {
+	template, err := getUserTemplateFromPath(w, r)
+	if err != nil {
+		return
+	}
+	dataprovider.HideUserSensitiveData(&template.User)
+	render.JSON(w, r, template)
+}
+
+func addUserTemplate(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	var template dataprovider.UserTemplate
+	err := render.DecodeJSON(r.Body, &template)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddUserTemplate(dataProvider, template)
+	if err == nil {
+		template, err = dataprovider.UserTemplateExists(dataProvider, template.Name)
+		if err == nil {
+			dataprovider.HideUserSensitiveData(&template.User)
+			render.JSON(w, r, template)
+		} else {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		}
+	} else {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	}
+}
+
+func updateUserTemplate(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	template, err := getUserTemplateFromPath(w, r)
+	if err != nil {
+		return
+	}
+	templateID := template.ID
+	currentUser := template.User
+	// the template user is replaced, the omitted fields are not merged with the current ones
+	template.User = dataprovider.User{}
+	err = render.DecodeJSON(r.Body, &template)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if template.ID != templateID {
+		sendAPIResponse(w, r, err, "user template ID in request body does not match user template ID in path parameter",
+			http.StatusBadRequest)
+		return
+	}
+	restoreTemplateUserSecrets(&template.User, currentUser)
+	err = dataprovider.UpdateUserTemplate(dataProvider, template)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "User template updated", http.StatusOK)
+	}
+}
+
+func deleteUserTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := getUserTemplateFromPath(w, r)
+	if err != nil {
+		return
+	}
+	err = dataprovider.DeleteUserTemplate(dataProvider, template)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "User template deleted", http.StatusOK)
+	}
+}
+
+// addUserFromTemplate adds a new user built from the template with the ID in the path
+func addUserFromTemplate(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	template, err := getUserTemplateFromPath(w, r)
+	if err != nil {
+		return
+	}
+	var req dataprovider.UserCloneRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	addUserFromRequest(w, r, template.GetUser(req))
+}
+
+// cloneUser adds a new user with the same settings as the user with the ID in the path
+func cloneUser(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid userID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	source, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), userID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	var req dataprovider.UserCloneRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserClone(source, req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to read the GCS credentials for the source user",
+			http.StatusInternalServerError)
+		return
+	}
+	addUserFromRequest(w, r, user)
+}
+
+func addUserFromRequest(w http.ResponseWriter, r *http.Request, user dataprovider.User) {
+	err := dataprovider.AddUserInScope(dataProvider, getAdminScope(r.Context()), user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	user, err = dataprovider.UserExists(dataProvider, user.Username)
+	if err == nil {
+		addWarningHeaders(w, dataprovider.ApplyS3TenantHelpers(user))
+		render.JSON(w, r, dataprovider.HideUserSensitiveData(&user))
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+// getUserTemplateFromPath returns the user template with the ID in the path. The error response
+// is sent if the template cannot be returned
+func getUserTemplateFromPath(w http.ResponseWriter, r *http.Request) (dataprovider.UserTemplate, error) {
+	templateID, err := strconv.ParseInt(chi.URLParam(r, "templateID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid templateID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return dataprovider.UserTemplate{}, err
+	}
+	template, err := dataprovider.GetUserTemplateByID(dataProvider, templateID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+	return template, err
+}
+
+// restoreTemplateUserSecrets restores the current secrets for the template user if the new ones
+// are empty or if they are the values returned to the client, without the decryption key
+func restoreTemplateUserSecrets(user *dataprovider.User, currentUser dataprovider.User) {
+	if user.FsConfig.Provider != currentUser.FsConfig.Provider {
+		return
+	}
+	restoreVirtualFoldersSecrets(user.VirtualFolders, currentUser.VirtualFolders)
+	switch user.FsConfig.Provider {
+	case 1:
+		restoreS3Secrets(&user.FsConfig.S3Config, currentUser.FsConfig.S3Config)
+	case 2:
+		if len(user.FsConfig.GCSConfig.Credentials) == 0 && user.FsConfig.GCSConfig.AutomaticCredentials == 0 {
+			user.FsConfig.GCSConfig.Credentials = currentUser.FsConfig.GCSConfig.Credentials
+		}
+	case 3:
+		current := currentUser.FsConfig.CryptConfig.Passphrase
+		if len(current) > 0 && (utils.RemoveDecryptionKey(current) == user.FsConfig.CryptConfig.Passphrase ||
+			len(user.FsConfig.CryptConfig.Passphrase) == 0) {
+			user.FsConfig.CryptConfig.Passphrase = current
+		}
+	case 4:
+		restoreWebDAVSecrets(&user.FsConfig.WebDAVConfig, currentUser.FsConfig.WebDAVConfig)
+	case 5:
+		restoreHDFSSecrets(&user.FsConfig.HDFSConfig, currentUser.FsConfig.HDFSConfig)
+	case 6:
+		restoreGoogleDriveSecrets(&user.FsConfig.GoogleDriveConfig, currentUser.FsConfig.GoogleDriveConfig)
+	case 7:
+		restoreDropboxSecrets(&user.FsConfig.DropboxConfig, currentUser.FsConfig.DropboxConfig)
+	}
+}
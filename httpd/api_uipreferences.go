@@ -0,0 +1,98 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func getUIPreferences(w http.ResponseWriter, r *http.Request) {
+	preferences, err := uiPreferences.GetAll(getRequestActor(r))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, preferences)
+}
+
+func getUIPreference(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	preferences, err := uiPreferences.GetAll(getRequestActor(r))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	value, ok := preferences[key]
+	if !ok {
+		sendAPIResponse(w, r, errUIPreferenceMissing, "", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, value)
+}
+
+func setUIPreference(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, uiPreferenceBodyLimit)
+	key := chi.URLParam(r, "key")
+	if !isUIPreferenceKeyValid(key) {
+		sendAPIResponse(w, r, fmt.Errorf("invalid key %#v, it must be at most 64 characters long and it can contain "+
+			"only letters, digits, \"_\", \".\" and \"-\"", key), "", http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if isRequestBodyTooLarge(err) {
+			sendAPIResponse(w, r, err, fmt.Sprintf("The preference value exceeds the maximum allowed size: %v bytes",
+				uiPreferenceBodyLimit.size), http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	var value bytes.Buffer
+	if err = json.Compact(&value, body); err != nil {
+		sendAPIResponse(w, r, fmt.Errorf("the preference value must be valid JSON: %v", err), "", http.StatusBadRequest)
+		return
+	}
+	admin := getRequestActor(r)
+	preferences, err := uiPreferences.GetAll(admin)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := preferences[key]; !ok && len(preferences) >= maxUIPreferences {
+		sendAPIResponse(w, r, fmt.Errorf("an admin can save at most %v UI preferences", maxUIPreferences), "",
+			http.StatusBadRequest)
+		return
+	}
+	err = uiPreferences.Set(admin, key, json.RawMessage(value.Bytes()))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	sendAPIResponse(w, r, nil, "UI preference saved", http.StatusOK)
+}
+
+func deleteUIPreference(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	admin := getRequestActor(r)
+	preferences, err := uiPreferences.GetAll(admin)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := preferences[key]; !ok {
+		sendAPIResponse(w, r, errUIPreferenceMissing, "", http.StatusNotFound)
+		return
+	}
+	err = uiPreferences.Delete(admin, key)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	sendAPIResponse(w, r, nil, "UI preference deleted", http.StatusOK)
+}
@@ -1,35 +1,42 @@
 package httpd
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 )
 
 func getUsers(w http.ResponseWriter, r *http.Request) {
-	limit := 100
-	offset := 0
-	order := "ASC"
-	username := ""
+	req := dataprovider.UsersPageRequest{
+		Limit: 100,
+		Order: "ASC",
+	}
 	var err error
 	if _, ok := r.URL.Query()["limit"]; ok {
-		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		req.Limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
 		if err != nil {
 			err = errors.New("Invalid limit")
 			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 			return
 		}
-		if limit > 500 {
-			limit = 500
+		if req.Limit > 500 {
+			req.Limit = 500
 		}
 	}
 	if _, ok := r.URL.Query()["offset"]; ok {
-		offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
+		req.Offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
 		if err != nil {
 			err = errors.New("Invalid offset")
 			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
@@ -37,22 +44,67 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if _, ok := r.URL.Query()["order"]; ok {
-		order = r.URL.Query().Get("order")
-		if order != "ASC" && order != "DESC" {
+		req.Order = r.URL.Query().Get("order")
+		if req.Order != "ASC" && req.Order != "DESC" {
 			err = errors.New("Invalid order")
 			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 			return
 		}
 	}
-	if _, ok := r.URL.Query()["username"]; ok {
-		username = r.URL.Query().Get("username")
+	req.OrderBy = r.URL.Query().Get("order_by")
+	req.Username = r.URL.Query().Get("username")
+	req.Cursor = r.URL.Query().Get("cursor")
+	req.Filter, err = getUsersFilterFromRequest(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
 	}
-	users, err := dataprovider.GetUsers(dataProvider, limit, offset, order, username)
-	if err == nil {
-		render.JSON(w, r, users)
-	} else {
-		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	page, err := dataprovider.GetUsersPage(dataProvider, getAdminScope(r.Context()), req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Set(totalCountHeader, strconv.Itoa(page.Total))
+	if len(page.NextCursor) > 0 {
+		w.Header().Set(nextCursorHeader, page.NextCursor)
 	}
+	if len(page.PrevCursor) > 0 {
+		w.Header().Set(prevCursorHeader, page.PrevCursor)
+	}
+	render.JSON(w, r, page.Users)
+}
+
+// getUsersFilterFromRequest returns the users filters set inside the query string,
+// the missing parameters are not applied
+func getUsersFilterFromRequest(r *http.Request) (dataprovider.UsersFilter, error) {
+	var filter dataprovider.UsersFilter
+	var err error
+	query := r.URL.Query()
+	// the first parse error is returned
+	getInt := func(name string, bitSize int) int64 {
+		if _, ok := query[name]; !ok || err != nil {
+			return 0
+		}
+		value, errParse := strconv.ParseInt(query.Get(name), 10, bitSize)
+		if errParse != nil {
+			err = fmt.Errorf("Invalid %v", name)
+		}
+		return value
+	}
+	if _, ok := query["status"]; ok {
+		status := int(getInt("status", 32))
+		filter.Status = &status
+	}
+	if _, ok := query["fs_provider"]; ok {
+		fsProvider := int(getInt("fs_provider", 32))
+		filter.FsProvider = &fsProvider
+	}
+	filter.ExpiresAfter = getInt("expires_after", 64)
+	filter.ExpiresBefore = getInt("expires_before", 64)
+	filter.MinUsedQuotaSize = getInt("min_used_quota_size", 64)
+	filter.MinQuotaUsagePercent = int(getInt("min_quota_usage_percent", 32))
+	filter.LastLoginOlderThanDays = int(getInt("last_login_older_than_days", 32))
+	return filter, err
 }
 
 func getUserByID(w http.ResponseWriter, r *http.Request) {
@@ -62,8 +114,9 @@ func getUserByID(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	user, err := dataprovider.GetUserByID(dataProvider, userID)
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), userID)
 	if err == nil {
+		setUserETag(w, user)
 		render.JSON(w, r, dataprovider.HideUserSensitiveData(&user))
 	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 		sendAPIResponse(w, r, err, "", http.StatusNotFound)
@@ -73,17 +126,18 @@ func getUserByID(w http.ResponseWriter, r *http.Request) {
 }
 
 func addUser(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limitRequestBody(w, r, apiBodyLimit)
 	var user dataprovider.User
 	err := render.DecodeJSON(r.Body, &user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	err = dataprovider.AddUser(dataProvider, user)
+	err = dataprovider.AddUserInScope(dataProvider, getAdminScope(r.Context()), user)
 	if err == nil {
 		user, err = dataprovider.UserExists(dataProvider, user.Username)
 		if err == nil {
+			addWarningHeaders(w, dataprovider.ApplyS3TenantHelpers(user))
 			render.JSON(w, r, dataprovider.HideUserSensitiveData(&user))
 		} else {
 			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
@@ -94,22 +148,63 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func updateUser(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limitRequestBody(w, r, apiBodyLimit)
 	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
 		err = errors.New("Invalid userID")
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	user, err := dataprovider.GetUserByID(dataProvider, userID)
+	disconnect, err := getDisconnectOption(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	ifMatchVersion, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), userID)
+	currentUsername := user.Username
 	currentPermissions := user.Permissions
 	currentFileExtensions := user.Filters.FileExtensions
-	currentS3AccessSecret := ""
+	currentS3Config := vfs.S3FsConfig{}
 	if user.FsConfig.Provider == 1 {
-		currentS3AccessSecret = user.FsConfig.S3Config.AccessSecret
+		currentS3Config = user.FsConfig.S3Config
+	}
+	currentCryptPassphrase := ""
+	if user.FsConfig.Provider == 3 {
+		currentCryptPassphrase = user.FsConfig.CryptConfig.Passphrase
+	}
+	currentWebDAVConfig := vfs.WebDAVFsConfig{}
+	if user.FsConfig.Provider == 4 {
+		currentWebDAVConfig = user.FsConfig.WebDAVConfig
+	}
+	currentHDFSConfig := vfs.HDFSFsConfig{}
+	if user.FsConfig.Provider == 5 {
+		currentHDFSConfig = user.FsConfig.HDFSConfig
+	}
+	currentGoogleDriveConfig := vfs.GoogleDriveFsConfig{}
+	if user.FsConfig.Provider == 6 {
+		currentGoogleDriveConfig = user.FsConfig.GoogleDriveConfig
+	}
+	currentDropboxConfig := vfs.DropboxFsConfig{}
+	if user.FsConfig.Provider == 7 {
+		currentDropboxConfig = user.FsConfig.DropboxConfig
 	}
 	user.Permissions = make(map[string][]string)
 	user.Filters.FileExtensions = []dataprovider.ExtensionsFilter{}
+	// the WebDAV, HDFS, Google Drive and Dropbox authentication fields are mutually exclusive,
+	// empty values omitted in the request must not be replaced with the current ones
+	user.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
+	user.FsConfig.HDFSConfig = vfs.HDFSFsConfig{}
+	user.FsConfig.GoogleDriveConfig = vfs.GoogleDriveFsConfig{}
+	user.FsConfig.DropboxConfig = vfs.DropboxFsConfig{}
+	// the virtual folders are decoded inside a new slice, so the filesystems omitted in the
+	// request are not merged with the current ones
+	currentVirtualFolders := user.VirtualFolders
+	user.VirtualFolders = nil
 	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 		sendAPIResponse(w, r, err, "", http.StatusNotFound)
 		return
@@ -130,25 +225,195 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	if len(user.Filters.FileExtensions) == 0 {
 		user.Filters.FileExtensions = currentFileExtensions
 	}
-	// we use the new access secret if different from the old one and not empty
+	// we use new virtual folders if passed otherwise the old ones
+	if user.VirtualFolders == nil {
+		user.VirtualFolders = currentVirtualFolders
+	} else {
+		restoreVirtualFoldersSecrets(user.VirtualFolders, currentVirtualFolders)
+	}
 	if user.FsConfig.Provider == 1 {
-		if utils.RemoveDecryptionKey(currentS3AccessSecret) == user.FsConfig.S3Config.AccessSecret ||
-			(len(user.FsConfig.S3Config.AccessSecret) == 0 && len(user.FsConfig.S3Config.AccessKey) > 0) {
-			user.FsConfig.S3Config.AccessSecret = currentS3AccessSecret
+		restoreS3Secrets(&user.FsConfig.S3Config, currentS3Config)
+	}
+	// we use the current passphrase if the new one is empty or if it is the value returned to the client
+	if user.FsConfig.Provider == 3 && len(currentCryptPassphrase) > 0 {
+		if utils.RemoveDecryptionKey(currentCryptPassphrase) == user.FsConfig.CryptConfig.Passphrase ||
+			len(user.FsConfig.CryptConfig.Passphrase) == 0 {
+			user.FsConfig.CryptConfig.Passphrase = currentCryptPassphrase
 		}
 	}
+	if user.FsConfig.Provider == 4 {
+		restoreWebDAVSecrets(&user.FsConfig.WebDAVConfig, currentWebDAVConfig)
+	}
+	if user.FsConfig.Provider == 5 {
+		restoreHDFSSecrets(&user.FsConfig.HDFSConfig, currentHDFSConfig)
+	}
+	if user.FsConfig.Provider == 6 {
+		restoreGoogleDriveSecrets(&user.FsConfig.GoogleDriveConfig, currentGoogleDriveConfig)
+	}
+	if user.FsConfig.Provider == 7 {
+		restoreDropboxSecrets(&user.FsConfig.DropboxConfig, currentDropboxConfig)
+	}
 	if user.ID != userID {
 		sendAPIResponse(w, r, err, "user ID in request body does not match user ID in path parameter", http.StatusBadRequest)
 		return
 	}
-	err = dataprovider.UpdateUser(dataProvider, user)
+	// the version in the request body is ignored, the existing clients could send back a stale
+	// user. The version is checked only if requested using the If-Match header
+	user.Version = ifMatchVersion
+	err = dataprovider.UpdateUserInScope(dataProvider, getAdminScope(r.Context()), user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	} else {
+		if disconnect {
+			disconnectUser(currentUsername)
+		}
+		applyS3TenantHelpersOnUpdate(w, userID, currentS3Config)
 		sendAPIResponse(w, r, err, "User updated", http.StatusOK)
 	}
 }
 
+// patchUser applies a JSON merge patch, as defined in RFC 7396, to an existing user. Only the fields included
+// in the patch are changed, a null value resets the field and the lists are replaced as a whole
+func patchUser(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, apiBodyLimit)
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid userID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	disconnect, err := getDisconnectOption(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	ifMatchVersion, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	var patch map[string]interface{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err = decoder.Decode(&patch); err != nil || patch == nil {
+		sendAPIResponse(w, r, err, "Invalid merge patch, a JSON object is expected", http.StatusBadRequest)
+		return
+	}
+	currentUser, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), userID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	user, err := getPatchedUser(currentUser, patch)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to apply the merge patch", http.StatusBadRequest)
+		return
+	}
+	if user.ID != userID {
+		sendAPIResponse(w, r, err, "user ID in request body does not match user ID in path parameter", http.StatusBadRequest)
+		return
+	}
+	// as for the updates the version inside the patch is ignored, it is checked only if requested
+	// using the If-Match header
+	user.Version = ifMatchVersion
+	err = dataprovider.UpdateUserInScope(dataProvider, getAdminScope(r.Context()), user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		if disconnect {
+			disconnectUser(currentUser.Username)
+		}
+		currentS3Config := vfs.S3FsConfig{}
+		if currentUser.FsConfig.Provider == 1 {
+			currentS3Config = currentUser.FsConfig.S3Config
+		}
+		applyS3TenantHelpersOnUpdate(w, userID, currentS3Config)
+		sendAPIResponse(w, r, err, "User updated", http.StatusOK)
+	}
+}
+
+// getPatchedUser returns a copy of the current user with the merge patch applied. The current secrets
+// are restored if the patch does not change them, as for the updates
+func getPatchedUser(currentUser dataprovider.User, patch map[string]interface{}) (dataprovider.User, error) {
+	user, err := applyUserMergePatch(currentUser, patch)
+	if err != nil {
+		return user, err
+	}
+	if user.FsConfig.Provider == 1 {
+		currentS3Config := vfs.S3FsConfig{}
+		if currentUser.FsConfig.Provider == 1 {
+			currentS3Config = currentUser.FsConfig.S3Config
+		}
+		restoreS3Secrets(&user.FsConfig.S3Config, currentS3Config)
+	}
+	if user.FsConfig.Provider == 3 && currentUser.FsConfig.Provider == 3 {
+		currentPassphrase := currentUser.FsConfig.CryptConfig.Passphrase
+		if utils.RemoveDecryptionKey(currentPassphrase) == user.FsConfig.CryptConfig.Passphrase ||
+			len(user.FsConfig.CryptConfig.Passphrase) == 0 {
+			user.FsConfig.CryptConfig.Passphrase = currentPassphrase
+		}
+	}
+	if user.FsConfig.Provider == 4 && currentUser.FsConfig.Provider == 4 {
+		restoreWebDAVSecrets(&user.FsConfig.WebDAVConfig, currentUser.FsConfig.WebDAVConfig)
+	}
+	if user.FsConfig.Provider == 5 && currentUser.FsConfig.Provider == 5 {
+		restoreHDFSSecrets(&user.FsConfig.HDFSConfig, currentUser.FsConfig.HDFSConfig)
+	}
+	if user.FsConfig.Provider == 6 && currentUser.FsConfig.Provider == 6 {
+		restoreGoogleDriveSecrets(&user.FsConfig.GoogleDriveConfig, currentUser.FsConfig.GoogleDriveConfig)
+	}
+	if user.FsConfig.Provider == 7 && currentUser.FsConfig.Provider == 7 {
+		restoreDropboxSecrets(&user.FsConfig.DropboxConfig, currentUser.FsConfig.DropboxConfig)
+	}
+	restoreVirtualFoldersSecrets(user.VirtualFolders, currentUser.VirtualFolders)
+	return user, nil
+}
+
+// applyUserMergePatch returns a copy of the given user with the merge patch applied
+func applyUserMergePatch(user dataprovider.User, patch map[string]interface{}) (dataprovider.User, error) {
+	var patchedUser dataprovider.User
+	data, err := json.Marshal(user)
+	if err != nil {
+		return patchedUser, err
+	}
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&document); err != nil {
+		return patchedUser, err
+	}
+	data, err = json.Marshal(applyMergePatch(document, patch))
+	if err != nil {
+		return patchedUser, err
+	}
+	err = json.Unmarshal(data, &patchedUser)
+	return patchedUser, err
+}
+
+// applyMergePatch applies the given JSON merge patch to the target document, both decoded as generic values.
+// The target document is modified in place
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = applyMergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
 func deleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
@@ -156,7 +421,12 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	user, err := dataprovider.GetUserByID(dataProvider, userID)
+	disconnect, err := getDisconnectOption(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), userID)
 	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 		sendAPIResponse(w, r, err, "", http.StatusNotFound)
 		return
@@ -164,10 +434,182 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
 	}
-	err = dataprovider.DeleteUser(dataProvider, user)
+	err = dataprovider.DeleteUserInScope(dataProvider, getAdminScope(r.Context()), user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 	} else {
+		if disconnect {
+			disconnectUser(user.Username)
+		}
 		sendAPIResponse(w, r, err, "User deleted", http.StatusOK)
 	}
 }
+
+func getDisconnectOption(r *http.Request) (bool, error) {
+	if _, ok := r.URL.Query()["disconnect"]; ok {
+		disconnect, err := strconv.Atoi(r.URL.Query().Get("disconnect"))
+		if err != nil || disconnect < 0 || disconnect > 1 {
+			return false, errors.New("Invalid disconnect")
+		}
+		return disconnect == 1, nil
+	}
+	return sftpd.IsDisconnectOnUserChangeEnabled(), nil
+}
+
+// getIfMatchVersion returns the user version from the If-Match header, 0 if the header
+// is missing or if it matches any version
+func getIfMatchVersion(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(value, "W/"), `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, errors.New("Invalid If-Match header, the ETag returned for the user is expected")
+	}
+	return version, nil
+}
+
+// setUserETag sets the user version as ETag, the users not updated since the version was
+// introduced have no version
+func setUserETag(w http.ResponseWriter, user dataprovider.User) {
+	if user.Version > 0 {
+		w.Header().Set("ETag", fmt.Sprintf(`"%v"`, user.Version))
+	}
+}
+
+func disconnectUser(username string) {
+	numConnections := sftpd.CloseUserConnections(username)
+	logger.Debug(logSender, "", "connections to close for user %#v: %v", username, numConnections)
+}
+
+// restoreS3Secrets restores the current access secret and session token if the new ones are
+// the values returned to the client, without the decryption key, or if the new access secret
+// is empty. An empty access key means the default AWS credential chain, so the stored secrets
+// are removed
+func restoreS3Secrets(config *vfs.S3FsConfig, currentConfig vfs.S3FsConfig) {
+	if len(config.AccessKey) == 0 {
+		config.AccessSecret = ""
+		config.SessionToken = ""
+		return
+	}
+	if utils.RemoveDecryptionKey(currentConfig.AccessSecret) == config.AccessSecret ||
+		len(config.AccessSecret) == 0 {
+		config.AccessSecret = currentConfig.AccessSecret
+	}
+	if len(currentConfig.SessionToken) > 0 && utils.RemoveDecryptionKey(currentConfig.SessionToken) == config.SessionToken {
+		config.SessionToken = currentConfig.SessionToken
+	}
+}
+
+// restoreVirtualFoldersSecrets restores the current secrets for the S3 virtual folders if the new
+// ones are empty or if they are the values returned to the client, without the decryption key
+func restoreVirtualFoldersSecrets(folders []vfs.VirtualFolder, currentFolders []vfs.VirtualFolder) {
+	for idx := range folders {
+		folder := &folders[idx]
+		if !folder.HasFilesystem() || folder.Filesystem.Provider != 1 {
+			continue
+		}
+		for _, current := range currentFolders {
+			if current.VirtualPath == folder.VirtualPath && current.HasFilesystem() && current.Filesystem.Provider == 1 {
+				restoreS3Secrets(&folder.Filesystem.S3Config, current.Filesystem.S3Config)
+			}
+		}
+	}
+}
+
+// restoreWebDAVSecrets restores the current WebDAV password and bearer token if the new ones
+// are empty or if they are the values returned to the client, without the decryption key
+func restoreWebDAVSecrets(config *vfs.WebDAVFsConfig, currentConfig vfs.WebDAVFsConfig) {
+	if len(currentConfig.Password) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.Password) == config.Password ||
+			(len(config.Password) == 0 && len(config.Username) > 0) {
+			config.Password = currentConfig.Password
+		}
+	}
+	if len(currentConfig.BearerToken) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.BearerToken) == config.BearerToken ||
+			(len(config.BearerToken) == 0 && len(config.Username) == 0) {
+			config.BearerToken = currentConfig.BearerToken
+		}
+	}
+}
+
+// restoreHDFSSecrets restores the current HDFS delegation token if the new one is empty
+// or if it is the value returned to the client, without the decryption key
+func restoreHDFSSecrets(config *vfs.HDFSFsConfig, currentConfig vfs.HDFSFsConfig) {
+	if len(currentConfig.DelegationToken) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.DelegationToken) == config.DelegationToken ||
+			(len(config.DelegationToken) == 0 && len(config.Username) == 0) {
+			config.DelegationToken = currentConfig.DelegationToken
+		}
+	}
+}
+
+// restoreGoogleDriveSecrets restores the current Google Drive credentials, client secret and
+// refresh token if the new ones are empty or if they are the values returned to the client,
+// without the decryption key
+func restoreGoogleDriveSecrets(config *vfs.GoogleDriveFsConfig, currentConfig vfs.GoogleDriveFsConfig) {
+	if len(currentConfig.Credentials) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.Credentials) == config.Credentials ||
+			(len(config.Credentials) == 0 && len(config.RefreshToken) == 0 && len(config.ClientID) == 0) {
+			config.Credentials = currentConfig.Credentials
+		}
+	}
+	if len(currentConfig.ClientSecret) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.ClientSecret) == config.ClientSecret ||
+			(len(config.ClientSecret) == 0 && len(config.ClientID) > 0) {
+			config.ClientSecret = currentConfig.ClientSecret
+		}
+	}
+	if len(currentConfig.RefreshToken) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.RefreshToken) == config.RefreshToken ||
+			(len(config.RefreshToken) == 0 && len(config.Credentials) == 0 && len(config.ClientID) > 0) {
+			config.RefreshToken = currentConfig.RefreshToken
+		}
+	}
+}
+
+// restoreDropboxSecrets restores the current Dropbox access token, refresh token and app secret
+// if the new ones are empty or if they are the values returned to the client, without the
+// decryption key
+func restoreDropboxSecrets(config *vfs.DropboxFsConfig, currentConfig vfs.DropboxFsConfig) {
+	if len(currentConfig.AccessToken) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.AccessToken) == config.AccessToken ||
+			(len(config.AccessToken) == 0 && len(config.RefreshToken) == 0 && len(config.AppKey) == 0) {
+			config.AccessToken = currentConfig.AccessToken
+		}
+	}
+	if len(currentConfig.RefreshToken) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.RefreshToken) == config.RefreshToken ||
+			(len(config.RefreshToken) == 0 && len(config.AccessToken) == 0 && len(config.AppKey) > 0) {
+			config.RefreshToken = currentConfig.RefreshToken
+		}
+	}
+	if len(currentConfig.AppSecret) > 0 {
+		if utils.RemoveDecryptionKey(currentConfig.AppSecret) == config.AppSecret ||
+			(len(config.AppSecret) == 0 && len(config.AppKey) > 0) {
+			config.AppSecret = currentConfig.AppSecret
+		}
+	}
+}
+
+// applyS3TenantHelpersOnUpdate executes the S3 tenants helpers for an updated user if the bucket,
+// the key prefix or the access key changed
+func applyS3TenantHelpersOnUpdate(w http.ResponseWriter, userID int64, previousS3Config vfs.S3FsConfig) {
+	user, err := dataprovider.GetUserByID(dataProvider, userID)
+	if err != nil || user.FsConfig.Provider != 1 {
+		return
+	}
+	s3Config := user.FsConfig.S3Config
+	if s3Config.Bucket != previousS3Config.Bucket || s3Config.KeyPrefix != previousS3Config.KeyPrefix ||
+		s3Config.AccessKey != previousS3Config.AccessKey {
+		addWarningHeaders(w, dataprovider.ApplyS3TenantHelpers(user))
+	}
+}
+
+func addWarningHeaders(w http.ResponseWriter, warnings []string) {
+	for _, warning := range warnings {
+		w.Header().Add(warningHeader, warning)
+	}
+}
@@ -18,8 +18,10 @@ import (
 
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/plugin"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 	"github.com/go-chi/render"
 )
 
@@ -79,6 +81,9 @@ func getRespStatus(err error) int {
 	if _, ok := err.(*dataprovider.MethodDisabledError); ok {
 		return http.StatusForbidden
 	}
+	if err == dataprovider.ErrUserVersionMismatch {
+		return http.StatusConflict
+	}
 	if os.IsNotExist(err) {
 		return http.StatusBadRequest
 	}
@@ -117,14 +122,29 @@ func AddUser(user dataprovider.User, expectedStatusCode int) (dataprovider.User,
 
 // UpdateUser updates an existing user and checks the received HTTP Status code against expectedStatusCode.
 func UpdateUser(user dataprovider.User, expectedStatusCode int) (dataprovider.User, []byte, error) {
+	return UpdateUserWithParams(user, expectedStatusCode, "")
+}
+
+// UpdateUserWithParams updates an existing user and checks the received HTTP Status code against expectedStatusCode.
+// If disconnect is not empty it is sent as "disconnect" query parameter: "1" means that the active connections
+// for the user will be closed after a successful update, "0" means that they will not be closed
+func UpdateUserWithParams(user dataprovider.User, expectedStatusCode int, disconnect string) (dataprovider.User, []byte, error) {
 	var newUser dataprovider.User
 	var body []byte
 	userAsJSON, err := json.Marshal(user)
 	if err != nil {
 		return user, body, err
 	}
-	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(userPath, strconv.FormatInt(user.ID, 10)),
-		bytes.NewBuffer(userAsJSON), "application/json")
+	url, err := url.Parse(buildURLRelativeToBase(userPath, strconv.FormatInt(user.ID, 10)))
+	if err != nil {
+		return user, body, err
+	}
+	if len(disconnect) > 0 {
+		q := url.Query()
+		q.Add("disconnect", disconnect)
+		url.RawQuery = q.Encode()
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, url.String(), bytes.NewBuffer(userAsJSON), "application/json")
 	if err != nil {
 		return user, body, err
 	}
@@ -143,6 +163,32 @@ func UpdateUser(user dataprovider.User, expectedStatusCode int) (dataprovider.Us
 	return newUser, body, err
 }
 
+// PatchUser applies a JSON merge patch to the user with the given ID and checks the received HTTP Status code
+// against expectedStatusCode. The patched user is returned if the patch is applied
+func PatchUser(userID int64, patch map[string]interface{}, expectedStatusCode int) (dataprovider.User, []byte, error) {
+	var newUser dataprovider.User
+	var body []byte
+	patchAsJSON, err := json.Marshal(patch)
+	if err != nil {
+		return newUser, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPatch, buildURLRelativeToBase(userPath, strconv.FormatInt(userID, 10)),
+		bytes.NewBuffer(patchAsJSON), "application/merge-patch+json")
+	if err != nil {
+		return newUser, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newUser, body, err
+	}
+	if err == nil {
+		newUser, body, err = GetUserByID(userID, expectedStatusCode)
+	}
+	return newUser, body, err
+}
+
 // RemoveUser removes an existing user and checks the received HTTP Status code against expectedStatusCode.
 func RemoveUser(user dataprovider.User, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -243,6 +289,44 @@ func StartQuotaScan(user dataprovider.User, expectedStatusCode int) ([]byte, err
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// GetQuotaScan returns the progress for the active quota scan of the given user and checks the received HTTP
+// Status code against expectedStatusCode.
+func GetQuotaScan(username string, expectedStatusCode int) (sftpd.ActiveQuotaScan, []byte, error) {
+	var quotaScan sftpd.ActiveQuotaScan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(quotaScanPath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return quotaScan, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &quotaScan)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return quotaScan, body, err
+}
+
+// StartUserFolderQuotaScan starts a new quota scan for the virtual folder of the given user and checks the
+// received HTTP Status code against expectedStatusCode.
+func StartUserFolderQuotaScan(username, virtualPath string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	reqAsJSON, err := json.Marshal(userFolderQuotaScanRequest{VirtualPath: virtualPath})
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(quotaScanPath, url.PathEscape(username),
+		"folder"), bytes.NewBuffer(reqAsJSON), "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetConnections returns status and stats for active SFTP/SCP connections
 func GetConnections(expectedStatusCode int) ([]sftpd.ConnectionStatus, []byte, error) {
 	var connections []sftpd.ConnectionStatus
@@ -261,6 +345,56 @@ func GetConnections(expectedStatusCode int) ([]sftpd.ConnectionStatus, []byte, e
 	return connections, body, err
 }
 
+// GetFilteredConnections returns status and stats for the active connections matching the given tags
+func GetFilteredConnections(filter sftpd.ConnectionTagsFilter, expectedStatusCode int) ([]sftpd.ConnectionStatus, []byte, error) {
+	var connections []sftpd.ConnectionStatus
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(activeConnectionsPath))
+	if err != nil {
+		return connections, body, err
+	}
+	q := url.Query()
+	if len(filter.ClientSoftware) > 0 {
+		q.Add("client_software", filter.ClientSoftware)
+	}
+	if len(filter.Tenant) > 0 {
+		q.Add("tenant", filter.Tenant)
+	}
+	if len(filter.Label) > 0 {
+		q.Add("label", filter.Label)
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "")
+	if err != nil {
+		return connections, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &connections)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return connections, body, err
+}
+
+// UpdateConnectionLabel sets the label for the active connection identified by connectionID
+func UpdateConnectionLabel(connectionID, label string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	labelAsJSON, err := json.Marshal(map[string]string{"label": label})
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(activeConnectionsPath, connectionID),
+		bytes.NewBuffer(labelAsJSON), "application/json")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // CloseConnection closes an active  connection identified by connectionID
 func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -274,6 +408,27 @@ func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error
 	return body, err
 }
 
+// CloseUserConnections closes all the active connections for the given user and checks the received HTTP Status
+// code against expectedStatusCode.
+func CloseUserConnections(username string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(activeConnectionsPath))
+	if err != nil {
+		return body, err
+	}
+	q := url.Query()
+	q.Add("username", username)
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodDelete, url.String(), nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	body, _ = getResponseBody(resp)
+	return body, err
+}
+
 // GetVersion returns version details
 func GetVersion(expectedStatusCode int) (utils.VersionInfo, []byte, error) {
 	var version utils.VersionInfo
@@ -310,6 +465,73 @@ func GetProviderStatus(expectedStatusCode int) (map[string]interface{}, []byte,
 	return response, body, err
 }
 
+// GetHealthStatus returns the health check response and checks the received HTTP Status code against
+// expectedStatusCode.
+func GetHealthStatus(expectedStatusCode int) (map[string]interface{}, []byte, error) {
+	var response map[string]interface{}
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(healthzPath), nil, "")
+	if err != nil {
+		return response, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && (expectedStatusCode == http.StatusOK || expectedStatusCode == http.StatusServiceUnavailable) {
+		err = render.DecodeJSON(resp.Body, &response)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return response, body, err
+}
+
+// GetProviderMigrationReport returns the consistency report between the data provider in use and
+// the migration target and checks the received HTTP Status code against expectedStatusCode.
+func GetProviderMigrationReport(expectedStatusCode int) (dataprovider.MigrationReport, []byte, error) {
+	return sendProviderMigrationRequest(http.MethodGet, expectedStatusCode)
+}
+
+// SyncProviderMigration synchronizes the migration target with the data provider in use and checks
+// the received HTTP Status code against expectedStatusCode.
+func SyncProviderMigration(expectedStatusCode int) (dataprovider.MigrationReport, []byte, error) {
+	return sendProviderMigrationRequest(http.MethodPost, expectedStatusCode)
+}
+
+func sendProviderMigrationRequest(method string, expectedStatusCode int) (dataprovider.MigrationReport, []byte, error) {
+	var report dataprovider.MigrationReport
+	var body []byte
+	resp, err := sendHTTPRequest(method, buildURLRelativeToBase(providerMigrationPath), nil, "")
+	if err != nil {
+		return report, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &report)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return report, body, err
+}
+
+// GetPluginStatus returns the health status for the configured plugins and checks the received
+// HTTP Status code against expectedStatusCode.
+func GetPluginStatus(expectedStatusCode int) ([]plugin.Status, []byte, error) {
+	var status []plugin.Status
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(pluginStatusPath), nil, "")
+	if err != nil {
+		return status, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &status)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return status, body, err
+}
+
 // Dumpdata requests a backup to outputFile.
 // outputFile is relative to the configured backups_path
 func Dumpdata(outputFile, indent string, expectedStatusCode int) (map[string]interface{}, []byte, error) {
@@ -372,87 +594,1775 @@ func Loaddata(inputFile, scanQuota, mode string, expectedStatusCode int) (map[st
 	return response, body, err
 }
 
-func checkResponse(actual int, expected int) error {
-	if expected != actual {
-		return fmt.Errorf("wrong status code: got %v want %v", actual, expected)
+// LoaddataFromBody restores a backup uploaded as request body and checks the received HTTP Status code
+// against expectedStatusCode. An empty format means JSON
+func LoaddataFromBody(backup io.Reader, format, scanQuota, mode string, expectedStatusCode int) (map[string]interface{}, []byte, error) {
+	var response map[string]interface{}
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(loadDataPath))
+	if err != nil {
+		return response, body, err
 	}
-	return nil
-}
-
-func getResponseBody(resp *http.Response) ([]byte, error) {
-	return ioutil.ReadAll(resp.Body)
-}
-
-func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
-	if len(actual.Password) > 0 {
-		return errors.New("User password must not be visible")
+	q := url.Query()
+	if len(format) > 0 {
+		q.Add("format", format)
 	}
-	if expected.ID <= 0 {
-		if actual.ID <= 0 {
-			return errors.New("actual user ID must be > 0")
-		}
-	} else {
-		if actual.ID != expected.ID {
-			return errors.New("user ID mismatch")
-		}
+	if len(scanQuota) > 0 {
+		q.Add("scan_quota", scanQuota)
 	}
-	if len(expected.Permissions) != len(actual.Permissions) {
-		return errors.New("Permissions mismatch")
+	if len(mode) > 0 {
+		q.Add("mode", mode)
 	}
-	for dir, perms := range expected.Permissions {
-		if actualPerms, ok := actual.Permissions[dir]; ok {
-			for _, v := range actualPerms {
-				if !utils.IsStringInSlice(v, perms) {
-					return errors.New("Permissions contents mismatch")
-				}
-			}
-		} else {
-			return errors.New("Permissions directories mismatch")
-		}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodPost, url.String(), backup, "application/octet-stream")
+	if err != nil {
+		return response, body, err
 	}
-	if err := compareUserFilters(expected, actual); err != nil {
-		return err
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &response)
+	} else {
+		body, _ = getResponseBody(resp)
 	}
-	if err := compareUserFsConfig(expected, actual); err != nil {
-		return err
+	return response, body, err
+}
+
+// GetIPListEntries gets the IP list entries and checks the received HTTP Status code against expectedStatusCode.
+// The results can be filtered specifying a list type, 1 safe list, 2 block list, 0 means no filter
+func GetIPListEntries(listType int, expectedStatusCode int) ([]dataprovider.IPListEntry, []byte, error) {
+	var entries []dataprovider.IPListEntry
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(ipListPath))
+	if err != nil {
+		return entries, body, err
 	}
-	if err := compareUserVirtualFolders(expected, actual); err != nil {
-		return err
+	if listType != 0 {
+		q := url.Query()
+		q.Add("type", strconv.Itoa(listType))
+		url.RawQuery = q.Encode()
 	}
-	return compareEqualsUserFields(expected, actual)
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "")
+	if err != nil {
+		return entries, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &entries)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return entries, body, err
 }
 
-func compareUserVirtualFolders(expected *dataprovider.User, actual *dataprovider.User) error {
-	if len(actual.VirtualFolders) != len(expected.VirtualFolders) {
-		return errors.New("Virtual folders mismatch")
+// GetIPListEntryByID gets an IP list entry by database id and checks the received HTTP Status code against expectedStatusCode.
+func GetIPListEntryByID(entryID int64, expectedStatusCode int) (dataprovider.IPListEntry, []byte, error) {
+	var entry dataprovider.IPListEntry
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(ipListPath, strconv.FormatInt(entryID, 10)), nil, "")
+	if err != nil {
+		return entry, body, err
 	}
-	for _, v := range actual.VirtualFolders {
-		found := false
-		for _, v1 := range expected.VirtualFolders {
-			if path.Clean(v.VirtualPath) == path.Clean(v1.VirtualPath) &&
-				filepath.Clean(v.MappedPath) == filepath.Clean(v1.MappedPath) {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return errors.New("Virtual folders mismatch")
-		}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &entry)
+	} else {
+		body, _ = getResponseBody(resp)
 	}
-	return nil
+	return entry, body, err
 }
 
-func compareUserFsConfig(expected *dataprovider.User, actual *dataprovider.User) error {
-	if expected.FsConfig.Provider != actual.FsConfig.Provider {
-		return errors.New("Fs provider mismatch")
+// AddIPListEntry adds a new IP list entry and checks the received HTTP Status code against expectedStatusCode.
+func AddIPListEntry(entry dataprovider.IPListEntry, expectedStatusCode int) (dataprovider.IPListEntry, []byte, error) {
+	var newEntry dataprovider.IPListEntry
+	var body []byte
+	entryAsJSON, err := json.Marshal(entry)
+	if err != nil {
+		return newEntry, body, err
 	}
-	if err := compareS3Config(expected, actual); err != nil {
-		return err
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(ipListPath), bytes.NewBuffer(entryAsJSON),
+		"application/json")
+	if err != nil {
+		return newEntry, body, err
 	}
-	if err := compareGCSConfig(expected, actual); err != nil {
-		return err
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		body, _ = getResponseBody(resp)
+		return newEntry, body, err
 	}
-	return nil
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newEntry)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkIPListEntry(&entry, &newEntry)
+	}
+	return newEntry, body, err
+}
+
+// UpdateIPListEntry updates an existing IP list entry and checks the received HTTP Status code against expectedStatusCode.
+func UpdateIPListEntry(entry dataprovider.IPListEntry, expectedStatusCode int) (dataprovider.IPListEntry, []byte, error) {
+	var newEntry dataprovider.IPListEntry
+	var body []byte
+	entryAsJSON, err := json.Marshal(entry)
+	if err != nil {
+		return entry, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(ipListPath, strconv.FormatInt(entry.ID, 10)),
+		bytes.NewBuffer(entryAsJSON), "application/json")
+	if err != nil {
+		return entry, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newEntry, body, err
+	}
+	if err == nil {
+		newEntry, body, err = GetIPListEntryByID(entry.ID, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkIPListEntry(&entry, &newEntry)
+	}
+	return newEntry, body, err
+}
+
+// RemoveIPListEntry removes an existing IP list entry and checks the received HTTP Status code against expectedStatusCode.
+func RemoveIPListEntry(entry dataprovider.IPListEntry, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(ipListPath, strconv.FormatInt(entry.ID, 10)), nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// ImportIPListSet imports the given IP list set and checks the received HTTP Status code against expectedStatusCode.
+func ImportIPListSet(set dataprovider.IPListSet, replace bool, expectedStatusCode int) (dataprovider.IPListImportResult, []byte, error) {
+	var result dataprovider.IPListImportResult
+	var body []byte
+	setAsJSON, err := json.Marshal(set)
+	if err != nil {
+		return result, body, err
+	}
+	url, err := url.Parse(buildURLRelativeToBase(ipListImportPath))
+	if err != nil {
+		return result, body, err
+	}
+	if replace {
+		q := url.Query()
+		q.Add("replace", "true")
+		url.RawQuery = q.Encode()
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, url.String(), bytes.NewBuffer(setAsJSON), "application/json")
+	if err != nil {
+		return result, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &result)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return result, body, err
+}
+
+// ExportIPListSet exports the IP list entries of the given type and checks the received HTTP Status code against expectedStatusCode.
+func ExportIPListSet(listType int, expectedStatusCode int) (dataprovider.IPListSet, []byte, error) {
+	var set dataprovider.IPListSet
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(ipListExportPath))
+	if err != nil {
+		return set, body, err
+	}
+	q := url.Query()
+	q.Add("type", strconv.Itoa(listType))
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "")
+	if err != nil {
+		return set, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &set)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return set, body, err
+}
+
+// GetIPListFeeds returns the status for the configured IP list feeds and checks the received HTTP Status code against expectedStatusCode.
+func GetIPListFeeds(expectedStatusCode int) ([]dataprovider.IPListFeedStatus, []byte, error) {
+	var feeds []dataprovider.IPListFeedStatus
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(ipListFeedsPath), nil, "")
+	if err != nil {
+		return feeds, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &feeds)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return feeds, body, err
+}
+
+// CheckIPAddress returns the IP list entries and the external block lists matching the given IP address
+// and checks the received HTTP Status code against expectedStatusCode.
+func CheckIPAddress(ip string, expectedStatusCode int) (dataprovider.IPListCheckResult, []byte, error) {
+	return sendIPAddressRequest(http.MethodGet, ipListCheckPath, ip, expectedStatusCode)
+}
+
+// UnblockIPAddress allows the connections from the given IP address and checks the received HTTP Status code
+// against expectedStatusCode.
+func UnblockIPAddress(ip string, expectedStatusCode int) (dataprovider.IPListCheckResult, []byte, error) {
+	return sendIPAddressRequest(http.MethodPost, ipListUnblockPath, ip, expectedStatusCode)
+}
+
+func sendIPAddressRequest(method, path, ip string, expectedStatusCode int) (dataprovider.IPListCheckResult, []byte, error) {
+	var result dataprovider.IPListCheckResult
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(path))
+	if err != nil {
+		return result, body, err
+	}
+	q := url.Query()
+	q.Add("ip", ip)
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(method, url.String(), nil, "")
+	if err != nil {
+		return result, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &result)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return result, body, err
+}
+
+// GetDefenderHosts returns the hosts with a score or a ban and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetDefenderHosts(expectedStatusCode int) ([]sftpd.DefenderEntry, []byte, error) {
+	var hosts []sftpd.DefenderEntry
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(defenderHostsPath), nil, "")
+	if err != nil {
+		return hosts, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &hosts)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return hosts, body, err
+}
+
+// GetDefenderHost returns the score and the ban for the given IP address and checks the received HTTP
+// Status code against expectedStatusCode.
+func GetDefenderHost(ip string, expectedStatusCode int) (sftpd.DefenderEntry, []byte, error) {
+	var host sftpd.DefenderEntry
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(defenderHostsPath, url.PathEscape(ip)), nil, "")
+	if err != nil {
+		return host, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &host)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return host, body, err
+}
+
+// BanDefenderHost bans the given IP address for the given minutes, 0 means the configured ban time,
+// and checks the received HTTP Status code against expectedStatusCode.
+func BanDefenderHost(ip string, banTime int, expectedStatusCode int) (sftpd.DefenderEntry, []byte, error) {
+	var host sftpd.DefenderEntry
+	var body []byte
+	reqAsJSON, err := json.Marshal(defenderBanRequest{IP: ip, BanTime: banTime})
+	if err != nil {
+		return host, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(defenderBanPath), bytes.NewBuffer(reqAsJSON),
+		"application/json")
+	if err != nil {
+		return host, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &host)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return host, body, err
+}
+
+// UnbanDefenderHost removes the ban and the score for the given IP address and checks the received HTTP
+// Status code against expectedStatusCode.
+func UnbanDefenderHost(ip string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(defenderHostsPath, url.PathEscape(ip)), nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetPlans returns the defined plans and checks the received HTTP Status code against expectedStatusCode.
+func GetPlans(expectedStatusCode int) ([]dataprovider.Plan, []byte, error) {
+	var plans []dataprovider.Plan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(planPath), nil, "")
+	if err != nil {
+		return plans, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &plans)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return plans, body, err
+}
+
+// GetPlanByID gets a plan by database id and checks the received HTTP Status code against expectedStatusCode.
+func GetPlanByID(planID int64, expectedStatusCode int) (dataprovider.Plan, []byte, error) {
+	var plan dataprovider.Plan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(planPath, strconv.FormatInt(planID, 10)), nil, "")
+	if err != nil {
+		return plan, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &plan)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return plan, body, err
+}
+
+// AddPlan adds a new plan and checks the received HTTP Status code against expectedStatusCode.
+func AddPlan(plan dataprovider.Plan, expectedStatusCode int) (dataprovider.Plan, []byte, error) {
+	var newPlan dataprovider.Plan
+	var body []byte
+	planAsJSON, err := json.Marshal(plan)
+	if err != nil {
+		return newPlan, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(planPath), bytes.NewBuffer(planAsJSON),
+		"application/json")
+	if err != nil {
+		return newPlan, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		body, _ = getResponseBody(resp)
+		return newPlan, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newPlan)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkPlan(&plan, &newPlan)
+	}
+	return newPlan, body, err
+}
+
+// UpdatePlan updates an existing plan and checks the received HTTP Status code against expectedStatusCode.
+func UpdatePlan(plan dataprovider.Plan, expectedStatusCode int) (dataprovider.Plan, []byte, error) {
+	var newPlan dataprovider.Plan
+	var body []byte
+	planAsJSON, err := json.Marshal(plan)
+	if err != nil {
+		return plan, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(planPath, strconv.FormatInt(plan.ID, 10)),
+		bytes.NewBuffer(planAsJSON), "application/json")
+	if err != nil {
+		return plan, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newPlan, body, err
+	}
+	if err == nil {
+		newPlan, body, err = GetPlanByID(plan.ID, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkPlan(&plan, &newPlan)
+	}
+	return newPlan, body, err
+}
+
+// UpdatePlanAsync updates an existing plan, the assigned users are updated in background,
+// and checks the received HTTP Status code against expectedStatusCode.
+func UpdatePlanAsync(plan dataprovider.Plan, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	planAsJSON, err := json.Marshal(plan)
+	if err != nil {
+		return body, err
+	}
+	url, err := url.Parse(buildURLRelativeToBase(planPath, strconv.FormatInt(plan.ID, 10)))
+	if err != nil {
+		return body, err
+	}
+	q := url.Query()
+	q.Add("async", "true")
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodPut, url.String(), bytes.NewBuffer(planAsJSON), "application/json")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetPlanPropagations returns the running and finished plan propagations and checks the received
+// HTTP Status code against expectedStatusCode.
+func GetPlanPropagations(expectedStatusCode int) ([]dataprovider.PlanPropagation, []byte, error) {
+	var propagations []dataprovider.PlanPropagation
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(planPropagationPath), nil, "")
+	if err != nil {
+		return propagations, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &propagations)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return propagations, body, err
+}
+
+// GetPlanPropagation gets the last propagation, including the affected users, for the given plan
+// and checks the received HTTP Status code against expectedStatusCode.
+func GetPlanPropagation(planName string, expectedStatusCode int) (dataprovider.PlanPropagation, []byte, error) {
+	var propagation dataprovider.PlanPropagation
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(planPropagationPath, url.PathEscape(planName)),
+		nil, "")
+	if err != nil {
+		return propagation, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &propagation)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return propagation, body, err
+}
+
+// RemovePlan removes an existing plan and checks the received HTTP Status code against expectedStatusCode.
+func RemovePlan(plan dataprovider.Plan, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(planPath, strconv.FormatInt(plan.ID, 10)), nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetUserTemplates returns the defined user templates and checks the received HTTP Status code against expectedStatusCode.
+func GetUserTemplates(expectedStatusCode int) ([]dataprovider.UserTemplate, []byte, error) {
+	var templates []dataprovider.UserTemplate
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userTemplatePath), nil, "")
+	if err != nil {
+		return templates, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &templates)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return templates, body, err
+}
+
+// GetUserTemplateByID gets a user template by database id and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetUserTemplateByID(templateID int64, expectedStatusCode int) (dataprovider.UserTemplate, []byte, error) {
+	var template dataprovider.UserTemplate
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userTemplatePath,
+		strconv.FormatInt(templateID, 10)), nil, "")
+	if err != nil {
+		return template, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &template)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return template, body, err
+}
+
+// AddUserTemplate adds a new user template and checks the received HTTP Status code against expectedStatusCode.
+func AddUserTemplate(template dataprovider.UserTemplate, expectedStatusCode int) (dataprovider.UserTemplate, []byte, error) {
+	var newTemplate dataprovider.UserTemplate
+	var body []byte
+	templateAsJSON, err := json.Marshal(template)
+	if err != nil {
+		return newTemplate, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(userTemplatePath),
+		bytes.NewBuffer(templateAsJSON), "application/json")
+	if err != nil {
+		return newTemplate, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		body, _ = getResponseBody(resp)
+		return newTemplate, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newTemplate)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkUserTemplate(&template, &newTemplate)
+	}
+	return newTemplate, body, err
+}
+
+// UpdateUserTemplate updates an existing user template and checks the received HTTP Status code against
+// expectedStatusCode.
+func UpdateUserTemplate(template dataprovider.UserTemplate, expectedStatusCode int) (dataprovider.UserTemplate, []byte, error) {
+	var newTemplate dataprovider.UserTemplate
+	var body []byte
+	templateAsJSON, err := json.Marshal(template)
+	if err != nil {
+		return template, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(userTemplatePath,
+		strconv.FormatInt(template.ID, 10)), bytes.NewBuffer(templateAsJSON), "application/json")
+	if err != nil {
+		return template, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newTemplate, body, err
+	}
+	if err == nil {
+		newTemplate, body, err = GetUserTemplateByID(template.ID, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkUserTemplate(&template, &newTemplate)
+	}
+	return newTemplate, body, err
+}
+
+// RemoveUserTemplate removes an existing user template and checks the received HTTP Status code against
+// expectedStatusCode.
+func RemoveUserTemplate(template dataprovider.UserTemplate, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(userTemplatePath,
+		strconv.FormatInt(template.ID, 10)), nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetFolders returns the defined shared folders and checks the received HTTP Status code against expectedStatusCode.
+func GetFolders(expectedStatusCode int) ([]dataprovider.Folder, []byte, error) {
+	var folders []dataprovider.Folder
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(folderPath), nil, "")
+	if err != nil {
+		return folders, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &folders)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return folders, body, err
+}
+
+// GetFolderByID gets a shared folder by database id and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetFolderByID(folderID int64, expectedStatusCode int) (dataprovider.Folder, []byte, error) {
+	var folder dataprovider.Folder
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(folderPath, strconv.FormatInt(folderID, 10)),
+		nil, "")
+	if err != nil {
+		return folder, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &folder)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return folder, body, err
+}
+
+// AddFolder adds a new shared folder and checks the received HTTP Status code against expectedStatusCode.
+func AddFolder(folder dataprovider.Folder, expectedStatusCode int) (dataprovider.Folder, []byte, error) {
+	var newFolder dataprovider.Folder
+	var body []byte
+	folderAsJSON, err := json.Marshal(folder)
+	if err != nil {
+		return newFolder, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(folderPath), bytes.NewBuffer(folderAsJSON),
+		"application/json")
+	if err != nil {
+		return newFolder, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		body, _ = getResponseBody(resp)
+		return newFolder, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newFolder)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkFolder(&folder, &newFolder)
+	}
+	return newFolder, body, err
+}
+
+// UpdateFolder updates an existing shared folder and checks the received HTTP Status code against expectedStatusCode.
+func UpdateFolder(folder dataprovider.Folder, expectedStatusCode int) (dataprovider.Folder, []byte, error) {
+	var newFolder dataprovider.Folder
+	var body []byte
+	folderAsJSON, err := json.Marshal(folder)
+	if err != nil {
+		return folder, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(folderPath, strconv.FormatInt(folder.ID, 10)),
+		bytes.NewBuffer(folderAsJSON), "application/json")
+	if err != nil {
+		return folder, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newFolder, body, err
+	}
+	if err == nil {
+		newFolder, body, err = GetFolderByID(folder.ID, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkFolder(&folder, &newFolder)
+	}
+	return newFolder, body, err
+}
+
+// RemoveFolder removes an existing shared folder and checks the received HTTP Status code against expectedStatusCode.
+func RemoveFolder(folder dataprovider.Folder, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(folderPath, strconv.FormatInt(folder.ID, 10)),
+		nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetFoldersQuotaScans gets the active quota scans for the shared folders and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetFoldersQuotaScans(expectedStatusCode int) ([]sftpd.ActiveFolderQuotaScan, []byte, error) {
+	var quotaScans []sftpd.ActiveFolderQuotaScan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(folderQuotaScanPath), nil, "")
+	if err != nil {
+		return quotaScans, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &quotaScans)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return quotaScans, body, err
+}
+
+// StartFolderQuotaScan start a new quota scan for the given shared folder and checks the received HTTP Status code
+// against expectedStatusCode.
+func StartFolderQuotaScan(folder dataprovider.Folder, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	folderAsJSON, err := json.Marshal(folder)
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(folderQuotaScanPath),
+		bytes.NewBuffer(folderAsJSON), "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetFolderQuotaScan returns the progress for the active quota scan of the given shared folder and checks the
+// received HTTP Status code against expectedStatusCode.
+func GetFolderQuotaScan(name string, expectedStatusCode int) (sftpd.ActiveFolderQuotaScan, []byte, error) {
+	var quotaScan sftpd.ActiveFolderQuotaScan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(folderQuotaScanPath, url.PathEscape(name)),
+		nil, "")
+	if err != nil {
+		return quotaScan, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &quotaScan)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return quotaScan, body, err
+}
+
+// AddUserFromTemplate adds a new user built from the given template and checks the received HTTP Status code
+// against expectedStatusCode.
+func AddUserFromTemplate(template dataprovider.UserTemplate, req dataprovider.UserCloneRequest,
+	expectedStatusCode int) (dataprovider.User, []byte, error) {
+	return sendUserCloneRequest(buildURLRelativeToBase(userTemplatePath, strconv.FormatInt(template.ID, 10), "user"),
+		req, expectedStatusCode)
+}
+
+// CloneUser adds a new user with the same settings as the given one and checks the received HTTP Status code
+// against expectedStatusCode.
+func CloneUser(user dataprovider.User, req dataprovider.UserCloneRequest, expectedStatusCode int) (dataprovider.User,
+	[]byte, error) {
+	return sendUserCloneRequest(buildURLRelativeToBase(userPath, strconv.FormatInt(user.ID, 10), "clone"), req,
+		expectedStatusCode)
+}
+
+func sendUserCloneRequest(reqURL string, req dataprovider.UserCloneRequest, expectedStatusCode int) (dataprovider.User,
+	[]byte, error) {
+	var newUser dataprovider.User
+	var body []byte
+	reqAsJSON, err := json.Marshal(req)
+	if err != nil {
+		return newUser, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, reqURL, bytes.NewBuffer(reqAsJSON), "application/json")
+	if err != nil {
+		return newUser, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &newUser)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil && expectedStatusCode == http.StatusOK {
+		if newUser.ID <= 0 {
+			err = errors.New("actual user ID must be > 0")
+		} else if newUser.Username != req.Username {
+			err = errors.New("username mismatch")
+		} else if len(newUser.Password) > 0 {
+			err = errors.New("User password must not be visible")
+		}
+	}
+	return newUser, body, err
+}
+
+// GetUserDefaultsDiff returns the fields of the given user that deviate from its defaults and checks the
+// received HTTP Status code against expectedStatusCode.
+func GetUserDefaultsDiff(user dataprovider.User, expectedStatusCode int) (dataprovider.UserDefaultsDiff, []byte, error) {
+	var diff dataprovider.UserDefaultsDiff
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userDefaultsPath, strconv.FormatInt(user.ID, 10)),
+		nil, "")
+	if err != nil {
+		return diff, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &diff)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return diff, body, err
+}
+
+// ResetUserToDefaults resets the given fields of the given user to their defaults, all the deviating fields
+// if no field is given, and checks the received HTTP Status code against expectedStatusCode.
+func ResetUserToDefaults(user dataprovider.User, fields []string, expectedStatusCode int) (dataprovider.UserDefaultsDiff,
+	[]byte, error) {
+	var diff dataprovider.UserDefaultsDiff
+	var body []byte
+	asJSON, err := json.Marshal(userDefaultsResetRequest{Fields: fields})
+	if err != nil {
+		return diff, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(userDefaultsPath, strconv.FormatInt(user.ID, 10),
+		"reset"), bytes.NewBuffer(asJSON), "application/json")
+	if err != nil {
+		return diff, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &diff)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return diff, body, err
+}
+
+// GetUserOverrides gets the active user overrides and checks the received HTTP Status code against expectedStatusCode.
+func GetUserOverrides(expectedStatusCode int) ([]dataprovider.UserOverride, []byte, error) {
+	var overrides []dataprovider.UserOverride
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userOverridePath), nil, "")
+	if err != nil {
+		return overrides, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &overrides)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return overrides, body, err
+}
+
+// GetUserOverride gets the active override for the given username and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetUserOverride(username string, expectedStatusCode int) (dataprovider.UserOverride, []byte, error) {
+	var override dataprovider.UserOverride
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userOverridePath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return override, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &override)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return override, body, err
+}
+
+// AddUserOverride adds a temporary user override and checks the received HTTP Status code against expectedStatusCode.
+func AddUserOverride(override dataprovider.UserOverride, expectedStatusCode int) (dataprovider.UserOverride, []byte, error) {
+	var newOverride dataprovider.UserOverride
+	var body []byte
+	overrideAsJSON, err := json.Marshal(override)
+	if err != nil {
+		return newOverride, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(userOverridePath),
+		bytes.NewBuffer(overrideAsJSON), "application/json")
+	if err != nil {
+		return newOverride, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &newOverride)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return newOverride, body, err
+}
+
+// RemoveUserOverride removes the active override for the given username and checks the received HTTP Status code
+// against expectedStatusCode.
+func RemoveUserOverride(username string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(userOverridePath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetUserOverridesAudit gets the audit records for the user overrides and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetUserOverridesAudit(expectedStatusCode int) ([]dataprovider.UserOverrideAuditRecord, []byte, error) {
+	var records []dataprovider.UserOverrideAuditRecord
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userOverrideAuditPath), nil, "")
+	if err != nil {
+		return records, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &records)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return records, body, err
+}
+
+// GetStaleFilesReport generates the stale files report and checks the received HTTP Status code
+// against expectedStatusCode. olderThanDays and topFiles are ignored if negative and the configured
+// values are used, username is ignored if empty
+func GetStaleFilesReport(olderThanDays, topFiles int, username string, expectedStatusCode int) ([]StaleFilesReport, []byte, error) {
+	var reports []StaleFilesReport
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(staleFilesReportPath))
+	if err != nil {
+		return reports, body, err
+	}
+	q := url.Query()
+	if olderThanDays >= 0 {
+		q.Add("older_than_days", strconv.Itoa(olderThanDays))
+	}
+	if topFiles >= 0 {
+		q.Add("top_files", strconv.Itoa(topFiles))
+	}
+	if len(username) > 0 {
+		q.Add("username", username)
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "")
+	if err != nil {
+		return reports, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &reports)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return reports, body, err
+}
+
+// GetUsersActivity gets the activity for the users and checks the received HTTP Status code against expectedStatusCode.
+func GetUsersActivity(expectedStatusCode int) ([]sftpd.UserActivity, []byte, error) {
+	var activities []sftpd.UserActivity
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(activityReportPath), nil, "")
+	if err != nil {
+		return activities, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &activities)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return activities, body, err
+}
+
+// GetUserActivity gets the activity for the given user and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetUserActivity(username string, expectedStatusCode int) (sftpd.UserActivity, []byte, error) {
+	var activity sftpd.UserActivity
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(activityReportPath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return activity, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &activity)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return activity, body, err
+}
+
+// GetTransferReceipts gets the transfer receipts for the given user, newest first, and checks the received
+// HTTP Status code against expectedStatusCode.
+// The number of results can be limited specifying a limit.
+// Some results can be skipped specifying an offset.
+func GetTransferReceipts(username string, limit, offset int64, expectedStatusCode int) ([]sftpd.TransferReceipt, []byte, error) {
+	var receipts []sftpd.TransferReceipt
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(transferReceiptsPath, url.PathEscape(username)))
+	if err != nil {
+		return receipts, body, err
+	}
+	q := url.Query()
+	if limit > 0 {
+		q.Add("limit", strconv.FormatInt(limit, 10))
+	}
+	if offset > 0 {
+		q.Add("offset", strconv.FormatInt(offset, 10))
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "")
+	if err != nil {
+		return receipts, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &receipts)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return receipts, body, err
+}
+
+// GetTransferReceipt gets the transfer receipt with the given ID and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetTransferReceipt(username, receiptID string, expectedStatusCode int) (sftpd.TransferReceipt, []byte, error) {
+	var receipt sftpd.TransferReceipt
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(transferReceiptsPath, url.PathEscape(username),
+		url.PathEscape(receiptID)), nil, "")
+	if err != nil {
+		return receipt, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &receipt)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return receipt, body, err
+}
+
+// GetDuplicatesScans gets the duplicate files scans and checks the received HTTP Status code against expectedStatusCode.
+func GetDuplicatesScans(expectedStatusCode int) ([]DuplicatesScan, []byte, error) {
+	var scans []DuplicatesScan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(duplicatesScanPath), nil, "")
+	if err != nil {
+		return scans, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &scans)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return scans, body, err
+}
+
+// GetDuplicatesScan gets the duplicate files scan for the given user and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetDuplicatesScan(username string, expectedStatusCode int) (DuplicatesScan, []byte, error) {
+	var scan DuplicatesScan
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(duplicatesScanPath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return scan, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &scan)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return scan, body, err
+}
+
+// StartDuplicatesScan starts a new duplicate files scan for the given user and checks the received HTTP Status code
+// against expectedStatusCode.
+func StartDuplicatesScan(user dataprovider.User, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	userAsJSON, err := json.Marshal(user)
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(duplicatesScanPath), bytes.NewBuffer(userAsJSON), "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetOffboardings gets the running and finished users offboardings and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetOffboardings(expectedStatusCode int) ([]UserOffboarding, []byte, error) {
+	var offboardings []UserOffboarding
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userOffboardingPath), nil, "")
+	if err != nil {
+		return offboardings, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &offboardings)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return offboardings, body, err
+}
+
+// GetOffboarding gets the offboarding for the given user and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetOffboarding(username string, expectedStatusCode int) (UserOffboarding, []byte, error) {
+	var offboarding UserOffboarding
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(userOffboardingPath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return offboarding, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &offboarding)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return offboarding, body, err
+}
+
+// StartOffboarding starts the offboarding for the given user and checks the received HTTP Status code
+// against expectedStatusCode.
+func StartOffboarding(request OffboardingRequest, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	asJSON, err := json.Marshal(request)
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(userOffboardingPath), bytes.NewBuffer(asJSON), "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// CancelDuplicatesScan cancels a running duplicate files scan, or removes the results of a finished one,
+// for the given user and checks the received HTTP Status code against expectedStatusCode.
+func CancelDuplicatesScan(username string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(duplicatesScanPath, url.PathEscape(username)),
+		nil, "")
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// TestHooks sends a synthetic event to the configured hooks and checks the received HTTP Status code
+// against expectedStatusCode. hook can be "actions" or "provider_actions"
+func TestHooks(hook string, request HookTestRequest, expectedStatusCode int) ([]dataprovider.HookTestResult, []byte, error) {
+	var results []dataprovider.HookTestResult
+	var body []byte
+	asJSON, err := json.Marshal(request)
+	if err != nil {
+		return results, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(hooksTestPath, hook), bytes.NewBuffer(asJSON), "")
+	if err != nil {
+		return results, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &results)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return results, body, err
+}
+
+// SimulateLogin checks the given credentials using a simulated login and returns the result
+func SimulateLogin(request sftpd.LoginSimulationRequest, expectedStatusCode int) (sftpd.LoginSimulationResult, []byte, error) {
+	var result sftpd.LoginSimulationResult
+	var body []byte
+	asJSON, err := json.Marshal(request)
+	if err != nil {
+		return result, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(loginSimulationPath), bytes.NewBuffer(asJSON), "")
+	if err != nil {
+		return result, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &result)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return result, body, err
+}
+
+// GetSyncManifest returns the manifest for the given directory using the sync API and the given user credentials
+func GetSyncManifest(username, password, dirPath string, withHash bool, expectedStatusCode int) ([]sftpd.SyncManifestEntry,
+	[]byte, error) {
+	var manifest []sftpd.SyncManifestEntry
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(syncManifestPath))
+	if err != nil {
+		return manifest, body, err
+	}
+	q := url.Query()
+	q.Add("path", dirPath)
+	q.Add("hash", strconv.FormatBool(withHash))
+	url.RawQuery = q.Encode()
+	resp, err := sendSyncRequest(http.MethodGet, url.String(), nil, username, password)
+	if err != nil {
+		return manifest, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &manifest)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return manifest, body, err
+}
+
+// GetSyncPlan compares the given manifest with the given directory using the sync API and the given
+// user credentials
+func GetSyncPlan(username, password, dirPath string, manifest []sftpd.SyncManifestEntry, expectedStatusCode int) (sftpd.SyncPlan,
+	[]byte, error) {
+	var plan sftpd.SyncPlan
+	var body []byte
+	asJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return plan, body, err
+	}
+	url, err := url.Parse(buildURLRelativeToBase(syncPlanPath))
+	if err != nil {
+		return plan, body, err
+	}
+	q := url.Query()
+	q.Add("path", dirPath)
+	url.RawQuery = q.Encode()
+	resp, err := sendSyncRequest(http.MethodPost, url.String(), bytes.NewBuffer(asJSON), username, password)
+	if err != nil {
+		return plan, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &plan)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return plan, body, err
+}
+
+// UploadSyncFile uploads a file using the sync API and the given user credentials.
+// The modification time is set if mtime is greater than 0
+func UploadSyncFile(username, password, filePath string, contents io.Reader, mtime int64, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(syncFilePath))
+	if err != nil {
+		return body, err
+	}
+	q := url.Query()
+	q.Add("path", filePath)
+	if mtime > 0 {
+		q.Add("mtime", strconv.FormatInt(mtime, 10))
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendSyncRequest(http.MethodPut, url.String(), contents, username, password)
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// DownloadSyncFile downloads a file using the sync API and the given user credentials and returns its contents.
+// If pre-signed URLs are enabled and supported the redirect is followed
+func DownloadSyncFile(username, password, filePath string, expectedStatusCode int) ([]byte, error) {
+	return sendSyncPathRequest(http.MethodGet, syncFilePath, username, password, filePath, expectedStatusCode)
+}
+
+// CreateSyncDir creates a directory using the sync API and the given user credentials
+func CreateSyncDir(username, password, dirPath string, expectedStatusCode int) ([]byte, error) {
+	return sendSyncPathRequest(http.MethodPost, syncDirPath, username, password, dirPath, expectedStatusCode)
+}
+
+// RemoveSyncPath removes a file or an empty directory using the sync API and the given user credentials
+func RemoveSyncPath(username, password, filePath string, expectedStatusCode int) ([]byte, error) {
+	return sendSyncPathRequest(http.MethodDelete, syncFilePath, username, password, filePath, expectedStatusCode)
+}
+
+func sendSyncPathRequest(method, apiPath, username, password, sftpPath string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(apiPath))
+	if err != nil {
+		return body, err
+	}
+	q := url.Query()
+	q.Add("path", sftpPath)
+	url.RawQuery = q.Encode()
+	resp, err := sendSyncRequest(method, url.String(), nil, username, password)
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// sendSyncRequest sends a sync API request authenticated as the given SFTPGo user
+func sendSyncRequest(method, url string, body io.Reader, username, password string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(username) > 0 || len(password) > 0 {
+		req.SetBasicAuth(username, password)
+	}
+	return httpclient.GetHTTPClient().Do(req)
+}
+
+func checkResponse(actual int, expected int) error {
+	if expected != actual {
+		return fmt.Errorf("wrong status code: got %v want %v", actual, expected)
+	}
+	return nil
+}
+
+func getResponseBody(resp *http.Response) ([]byte, error) {
+	return ioutil.ReadAll(resp.Body)
+}
+
+func checkIPListEntry(expected *dataprovider.IPListEntry, actual *dataprovider.IPListEntry) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual IP list entry ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("IP list entry ID mismatch")
+		}
+	}
+	if expected.IPOrNet != actual.IPOrNet {
+		return errors.New("ipornet mismatch")
+	}
+	if expected.Type != actual.Type {
+		return errors.New("type mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	return nil
+}
+
+func checkPlan(expected *dataprovider.Plan, actual *dataprovider.Plan) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual plan ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("plan ID mismatch")
+		}
+	}
+	if expected.Name != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if expected.MaxSessions != actual.MaxSessions || expected.QuotaSize != actual.QuotaSize ||
+		expected.QuotaFiles != actual.QuotaFiles || expected.UploadBandwidth != actual.UploadBandwidth ||
+		expected.DownloadBandwidth != actual.DownloadBandwidth {
+		return errors.New("limits mismatch")
+	}
+	if expected.PasswordBreachCheck != actual.PasswordBreachCheck {
+		return errors.New("password breach check mismatch")
+	}
+	if len(expected.AllowedFsProviders) != len(actual.AllowedFsProviders) {
+		return errors.New("allowed fs providers mismatch")
+	}
+	for idx, fsProvider := range expected.AllowedFsProviders {
+		if actual.AllowedFsProviders[idx] != fsProvider {
+			return errors.New("allowed fs providers contents mismatch")
+		}
+	}
+	if len(expected.DeniedLoginMethods) != len(actual.DeniedLoginMethods) {
+		return errors.New("denied login methods mismatch")
+	}
+	for _, loginMethod := range expected.DeniedLoginMethods {
+		if !utils.IsStringInSlice(loginMethod, actual.DeniedLoginMethods) {
+			return errors.New("denied login methods contents mismatch")
+		}
+	}
+	return nil
+}
+
+func checkFolder(expected *dataprovider.Folder, actual *dataprovider.Folder) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual folder ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("folder ID mismatch")
+		}
+	}
+	if expected.Name != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if filepath.Clean(expected.MappedPath) != actual.MappedPath {
+		return errors.New("mapped path mismatch")
+	}
+	if expected.QuotaSize != actual.QuotaSize || expected.QuotaFiles != actual.QuotaFiles {
+		return errors.New("quota mismatch")
+	}
+	return nil
+}
+
+func checkUserTemplate(expected *dataprovider.UserTemplate, actual *dataprovider.UserTemplate) error {
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual user template ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("user template ID mismatch")
+		}
+	}
+	if expected.Name != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if len(actual.User.Username) > 0 || len(actual.User.Password) > 0 || len(actual.User.PublicKeys) > 0 {
+		return errors.New("the template user must not have username and credentials")
+	}
+	if expected.User.HomeDir != actual.User.HomeDir {
+		return errors.New("home dir mismatch")
+	}
+	if expected.User.FsConfig.Provider != actual.User.FsConfig.Provider {
+		return errors.New("fs provider mismatch")
+	}
+	return nil
+}
+
+func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(actual.Password) > 0 {
+		return errors.New("User password must not be visible")
+	}
+	if expected.ID <= 0 {
+		if actual.ID <= 0 {
+			return errors.New("actual user ID must be > 0")
+		}
+	} else {
+		if actual.ID != expected.ID {
+			return errors.New("user ID mismatch")
+		}
+	}
+	if len(expected.Permissions) != len(actual.Permissions) {
+		return errors.New("Permissions mismatch")
+	}
+	for dir, perms := range expected.Permissions {
+		if actualPerms, ok := actual.Permissions[dir]; ok {
+			for _, v := range actualPerms {
+				if !utils.IsStringInSlice(v, perms) {
+					return errors.New("Permissions contents mismatch")
+				}
+			}
+		} else {
+			return errors.New("Permissions directories mismatch")
+		}
+	}
+	if err := compareUserFilters(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserVirtualFolders(expected, actual); err != nil {
+		return err
+	}
+	return compareEqualsUserFields(expected, actual)
+}
+
+func compareUserVirtualFolders(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(actual.VirtualFolders) != len(expected.VirtualFolders) {
+		return errors.New("Virtual folders mismatch")
+	}
+	for _, v := range actual.VirtualFolders {
+		found := false
+		for _, v1 := range expected.VirtualFolders {
+			if path.Clean(v.VirtualPath) != path.Clean(v1.VirtualPath) || v.HasFilesystem() != v1.HasFilesystem() {
+				continue
+			}
+			if v1.IsShared() {
+				// the mapped path is the shared folder one
+				found = v.Name == v1.Name
+			} else if v.HasFilesystem() {
+				found = compareVirtualFolderFilesystem(v1.Filesystem, v.Filesystem) == nil
+			} else {
+				found = filepath.Clean(v.MappedPath) == filepath.Clean(v1.MappedPath)
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return errors.New("Virtual folders mismatch")
+		}
+	}
+	return nil
+}
+
+func compareVirtualFolderFilesystem(expected *vfs.VirtualFolderFilesystem, actual *vfs.VirtualFolderFilesystem) error {
+	if expected.Provider != actual.Provider {
+		return errors.New("Virtual folder fs provider mismatch")
+	}
+	if expected.S3Config.Bucket != actual.S3Config.Bucket || expected.S3Config.KeyPrefix != actual.S3Config.KeyPrefix ||
+		expected.S3Config.Region != actual.S3Config.Region || expected.S3Config.AccessKey != actual.S3Config.AccessKey {
+		return errors.New("Virtual folder S3 config mismatch")
+	}
+	if expected.GCSConfig.Bucket != actual.GCSConfig.Bucket || expected.GCSConfig.KeyPrefix != actual.GCSConfig.KeyPrefix {
+		return errors.New("Virtual folder GCS config mismatch")
+	}
+	return nil
+}
+
+func compareUserFsConfig(expected *dataprovider.User, actual *dataprovider.User) error {
+	if expected.FsConfig.Provider != actual.FsConfig.Provider {
+		return errors.New("Fs provider mismatch")
+	}
+	if err := compareS3Config(expected, actual); err != nil {
+		return err
+	}
+	if err := compareGCSConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareCryptConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareWebDAVConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareHDFSConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareGoogleDriveConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareDropboxConfig(expected, actual); err != nil {
+		return err
+	}
+	return nil
+}
+
+func compareCryptConfig(expected *dataprovider.User, actual *dataprovider.User) error {
+	expectedPassphrase := expected.FsConfig.CryptConfig.Passphrase
+	actualPassphrase := actual.FsConfig.CryptConfig.Passphrase
+	if len(expectedPassphrase) == 0 {
+		if len(actualPassphrase) > 0 {
+			return errors.New("Crypt passphrase mismatch")
+		}
+		return nil
+	}
+	vals := strings.Split(expectedPassphrase, "$")
+	if strings.HasPrefix(expectedPassphrase, "$aes$") && len(vals) == 4 {
+		if utils.RemoveDecryptionKey(expectedPassphrase) != actualPassphrase {
+			return errors.New("Crypt passphrase mismatch")
+		}
+		return nil
+	}
+	// the passphrase must be returned aes encrypted without the nonce
+	parts := strings.Split(actualPassphrase, "$")
+	if !strings.HasPrefix(actualPassphrase, "$aes$") || len(parts) != 3 {
+		return errors.New("Invalid crypt passphrase")
+	}
+	if len(parts) == len(vals) && expectedPassphrase != actualPassphrase {
+		return errors.New("Crypt encrypted passphrase mismatch")
+	}
+	return nil
+}
+
+func compareWebDAVConfig(expected *dataprovider.User, actual *dataprovider.User) error {
+	if expected.FsConfig.WebDAVConfig.Endpoint != actual.FsConfig.WebDAVConfig.Endpoint {
+		return errors.New("WebDAV endpoint mismatch")
+	}
+	if expected.FsConfig.WebDAVConfig.Username != actual.FsConfig.WebDAVConfig.Username {
+		return errors.New("WebDAV username mismatch")
+	}
+	if err := checkEncryptedSecret("WebDAV", "password", expected.FsConfig.WebDAVConfig.Password,
+		actual.FsConfig.WebDAVConfig.Password); err != nil {
+		return err
+	}
+	if err := checkEncryptedSecret("WebDAV", "bearer token", expected.FsConfig.WebDAVConfig.BearerToken,
+		actual.FsConfig.WebDAVConfig.BearerToken); err != nil {
+		return err
+	}
+	if expected.FsConfig.WebDAVConfig.RootPath != actual.FsConfig.WebDAVConfig.RootPath &&
+		expected.FsConfig.WebDAVConfig.RootPath+"/" != actual.FsConfig.WebDAVConfig.RootPath {
+		return errors.New("WebDAV root path mismatch")
+	}
+	return nil
+}
+
+func compareHDFSConfig(expected *dataprovider.User, actual *dataprovider.User) error {
+	if expected.FsConfig.HDFSConfig.Endpoint != actual.FsConfig.HDFSConfig.Endpoint {
+		return errors.New("HDFS endpoint mismatch")
+	}
+	if expected.FsConfig.HDFSConfig.Username != actual.FsConfig.HDFSConfig.Username {
+		return errors.New("HDFS username mismatch")
+	}
+	if err := checkEncryptedSecret("HDFS", "delegation token", expected.FsConfig.HDFSConfig.DelegationToken,
+		actual.FsConfig.HDFSConfig.DelegationToken); err != nil {
+		return err
+	}
+	if expected.FsConfig.HDFSConfig.RootPath != actual.FsConfig.HDFSConfig.RootPath &&
+		expected.FsConfig.HDFSConfig.RootPath+"/" != actual.FsConfig.HDFSConfig.RootPath {
+		return errors.New("HDFS root path mismatch")
+	}
+	return nil
+}
+
+func compareGoogleDriveConfig(expected *dataprovider.User, actual *dataprovider.User) error {
+	if expected.FsConfig.GoogleDriveConfig.FolderID != actual.FsConfig.GoogleDriveConfig.FolderID {
+		return errors.New("Google Drive folder ID mismatch")
+	}
+	if expected.FsConfig.GoogleDriveConfig.Subject != actual.FsConfig.GoogleDriveConfig.Subject {
+		return errors.New("Google Drive subject mismatch")
+	}
+	if expected.FsConfig.GoogleDriveConfig.ClientID != actual.FsConfig.GoogleDriveConfig.ClientID {
+		return errors.New("Google Drive client ID mismatch")
+	}
+	if expected.FsConfig.GoogleDriveConfig.Endpoint != actual.FsConfig.GoogleDriveConfig.Endpoint {
+		return errors.New("Google Drive endpoint mismatch")
+	}
+	if err := checkEncryptedSecret("Google Drive", "credentials", expected.FsConfig.GoogleDriveConfig.Credentials,
+		actual.FsConfig.GoogleDriveConfig.Credentials); err != nil {
+		return err
+	}
+	if err := checkEncryptedSecret("Google Drive", "client secret", expected.FsConfig.GoogleDriveConfig.ClientSecret,
+		actual.FsConfig.GoogleDriveConfig.ClientSecret); err != nil {
+		return err
+	}
+	return checkEncryptedSecret("Google Drive", "refresh token", expected.FsConfig.GoogleDriveConfig.RefreshToken,
+		actual.FsConfig.GoogleDriveConfig.RefreshToken)
+}
+
+func compareDropboxConfig(expected *dataprovider.User, actual *dataprovider.User) error {
+	if expected.FsConfig.DropboxConfig.RootPath != actual.FsConfig.DropboxConfig.RootPath {
+		return errors.New("Dropbox root path mismatch")
+	}
+	if expected.FsConfig.DropboxConfig.AppKey != actual.FsConfig.DropboxConfig.AppKey {
+		return errors.New("Dropbox app key mismatch")
+	}
+	if expected.FsConfig.DropboxConfig.UploadChunkSize != actual.FsConfig.DropboxConfig.UploadChunkSize {
+		return errors.New("Dropbox upload chunk size mismatch")
+	}
+	if expected.FsConfig.DropboxConfig.Endpoint != actual.FsConfig.DropboxConfig.Endpoint {
+		return errors.New("Dropbox endpoint mismatch")
+	}
+	if err := checkEncryptedSecret("Dropbox", "access token", expected.FsConfig.DropboxConfig.AccessToken,
+		actual.FsConfig.DropboxConfig.AccessToken); err != nil {
+		return err
+	}
+	if err := checkEncryptedSecret("Dropbox", "refresh token", expected.FsConfig.DropboxConfig.RefreshToken,
+		actual.FsConfig.DropboxConfig.RefreshToken); err != nil {
+		return err
+	}
+	return checkEncryptedSecret("Dropbox", "app secret", expected.FsConfig.DropboxConfig.AppSecret,
+		actual.FsConfig.DropboxConfig.AppSecret)
+}
+
+func checkEncryptedSecret(fsName, name, expectedSecret, actualSecret string) error {
+	if len(expectedSecret) == 0 {
+		if len(actualSecret) > 0 {
+			return fmt.Errorf("%v %v mismatch", fsName, name)
+		}
+		return nil
+	}
+	vals := strings.Split(expectedSecret, "$")
+	if strings.HasPrefix(expectedSecret, "$aes$") && len(vals) == 4 {
+		if utils.RemoveDecryptionKey(expectedSecret) != actualSecret {
+			return fmt.Errorf("%v %v mismatch", fsName, name)
+		}
+		return nil
+	}
+	// the secret must be returned aes encrypted without the nonce
+	parts := strings.Split(actualSecret, "$")
+	if !strings.HasPrefix(actualSecret, "$aes$") || len(parts) != 3 {
+		return fmt.Errorf("Invalid %v %v", fsName, name)
+	}
+	if len(parts) == len(vals) && expectedSecret != actualSecret {
+		return fmt.Errorf("%v encrypted %v mismatch", fsName, name)
+	}
+	return nil
 }
 
 func compareS3Config(expected *dataprovider.User, actual *dataprovider.User) error {
@@ -468,9 +2378,28 @@ func compareS3Config(expected *dataprovider.User, actual *dataprovider.User) err
 	if err := checkS3AccessSecret(expected.FsConfig.S3Config.AccessSecret, actual.FsConfig.S3Config.AccessSecret); err != nil {
 		return err
 	}
+	if err := checkEncryptedSecret("S3", "session token", expected.FsConfig.S3Config.SessionToken,
+		actual.FsConfig.S3Config.SessionToken); err != nil {
+		return err
+	}
+	if expected.FsConfig.S3Config.RoleARN != actual.FsConfig.S3Config.RoleARN {
+		return errors.New("S3 role ARN mismatch")
+	}
+	if expected.FsConfig.S3Config.ExternalID != actual.FsConfig.S3Config.ExternalID {
+		return errors.New("S3 external ID mismatch")
+	}
 	if expected.FsConfig.S3Config.Endpoint != actual.FsConfig.S3Config.Endpoint {
 		return errors.New("S3 endpoint mismatch")
 	}
+	if expected.FsConfig.S3Config.ForcePathStyle != actual.FsConfig.S3Config.ForcePathStyle {
+		return errors.New("S3 force path style mismatch")
+	}
+	if expected.FsConfig.S3Config.SkipTLSVerify != actual.FsConfig.S3Config.SkipTLSVerify {
+		return errors.New("S3 skip TLS verify mismatch")
+	}
+	if strings.TrimSpace(expected.FsConfig.S3Config.CABundle) != actual.FsConfig.S3Config.CABundle {
+		return errors.New("S3 CA bundle mismatch")
+	}
 	if expected.FsConfig.S3Config.StorageClass != actual.FsConfig.S3Config.StorageClass {
 		return errors.New("S3 storage class mismatch")
 	}
@@ -480,10 +2409,20 @@ func compareS3Config(expected *dataprovider.User, actual *dataprovider.User) err
 	if expected.FsConfig.S3Config.UploadConcurrency != actual.FsConfig.S3Config.UploadConcurrency {
 		return errors.New("S3 upload concurrency mismatch")
 	}
+	if expected.FsConfig.S3Config.DownloadPartSize != actual.FsConfig.S3Config.DownloadPartSize {
+		return errors.New("S3 download part size mismatch")
+	}
+	if expected.FsConfig.S3Config.DownloadConcurrency != actual.FsConfig.S3Config.DownloadConcurrency {
+		return errors.New("S3 download concurrency mismatch")
+	}
 	if expected.FsConfig.S3Config.KeyPrefix != actual.FsConfig.S3Config.KeyPrefix &&
 		expected.FsConfig.S3Config.KeyPrefix+"/" != actual.FsConfig.S3Config.KeyPrefix {
 		return errors.New("S3 key prefix mismatch")
 	}
+	if err := compareStorageClassRules(expected.FsConfig.S3Config.StorageClassRules,
+		actual.FsConfig.S3Config.StorageClassRules); err != nil {
+		return fmt.Errorf("S3 %v", err)
+	}
 	return nil
 }
 
@@ -494,6 +2433,12 @@ func compareGCSConfig(expected *dataprovider.User, actual *dataprovider.User) er
 	if expected.FsConfig.GCSConfig.StorageClass != actual.FsConfig.GCSConfig.StorageClass {
 		return errors.New("GCS storage class mismatch")
 	}
+	if expected.FsConfig.GCSConfig.DownloadPartSize != actual.FsConfig.GCSConfig.DownloadPartSize {
+		return errors.New("GCS download part size mismatch")
+	}
+	if expected.FsConfig.GCSConfig.DownloadConcurrency != actual.FsConfig.GCSConfig.DownloadConcurrency {
+		return errors.New("GCS download concurrency mismatch")
+	}
 	if expected.FsConfig.GCSConfig.KeyPrefix != actual.FsConfig.GCSConfig.KeyPrefix &&
 		expected.FsConfig.GCSConfig.KeyPrefix+"/" != actual.FsConfig.GCSConfig.KeyPrefix {
 		return errors.New("GCS key prefix mismatch")
@@ -501,6 +2446,36 @@ func compareGCSConfig(expected *dataprovider.User, actual *dataprovider.User) er
 	if expected.FsConfig.GCSConfig.AutomaticCredentials != actual.FsConfig.GCSConfig.AutomaticCredentials {
 		return errors.New("GCS automatic credentials mismatch")
 	}
+	if err := compareStorageClassRules(expected.FsConfig.GCSConfig.StorageClassRules,
+		actual.FsConfig.GCSConfig.StorageClassRules); err != nil {
+		return fmt.Errorf("GCS %v", err)
+	}
+	return nil
+}
+
+func compareStorageClassRules(expected []vfs.StorageClassRule, actual []vfs.StorageClassRule) error {
+	if len(expected) != len(actual) {
+		return errors.New("storage class rules mismatch")
+	}
+	for idx, rule := range expected {
+		if rule.StorageClass != actual[idx].StorageClass {
+			return errors.New("storage class rules storage class mismatch")
+		}
+		if rule.MinSize != actual[idx].MinSize {
+			return errors.New("storage class rules min size mismatch")
+		}
+		if len(rule.Path) > 0 && path.Clean(rule.Path) != actual[idx].Path {
+			return errors.New("storage class rules path mismatch")
+		}
+		if len(rule.Extensions) != len(actual[idx].Extensions) {
+			return errors.New("storage class rules extensions mismatch")
+		}
+		for _, ext := range rule.Extensions {
+			if !utils.IsStringInSlice(strings.ToLower(strings.TrimSpace(ext)), actual[idx].Extensions) {
+				return errors.New("storage class rules extensions mismatch")
+			}
+		}
+	}
 	return nil
 }
 
@@ -557,9 +2532,67 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 			return errors.New("Denied login methods contents mismatch")
 		}
 	}
+	if len(expected.Filters.RevokedKeyFingerprints) != len(actual.Filters.RevokedKeyFingerprints) {
+		return errors.New("Revoked key fingerprints mismatch")
+	}
+	for _, fp := range expected.Filters.RevokedKeyFingerprints {
+		if !utils.IsStringInSlice(fp, actual.Filters.RevokedKeyFingerprints) {
+			return errors.New("Revoked key fingerprints contents mismatch")
+		}
+	}
+	if len(expected.Filters.AllowedKeyAlgorithms) != len(actual.Filters.AllowedKeyAlgorithms) {
+		return errors.New("Allowed key algorithms mismatch")
+	}
+	for _, algo := range expected.Filters.AllowedKeyAlgorithms {
+		if !utils.IsStringInSlice(algo, actual.Filters.AllowedKeyAlgorithms) {
+			return errors.New("Allowed key algorithms contents mismatch")
+		}
+	}
+	if expected.Filters.MinRSAKeySize != actual.Filters.MinRSAKeySize {
+		return errors.New("Min RSA key size mismatch")
+	}
+	if len(expected.Filters.AllowedSSHCommands) != len(actual.Filters.AllowedSSHCommands) {
+		return errors.New("Allowed SSH commands mismatch")
+	}
+	for _, command := range expected.Filters.AllowedSSHCommands {
+		if !utils.IsStringInSlice(command, actual.Filters.AllowedSSHCommands) {
+			return errors.New("Allowed SSH commands contents mismatch")
+		}
+	}
+	if expected.Filters.Tenant != actual.Filters.Tenant {
+		return errors.New("Tenant mismatch")
+	}
+	if expected.Filters.ReadOnly != actual.Filters.ReadOnly {
+		return errors.New("Read only mismatch")
+	}
+	if expected.Filters.FirstLogin.AcceptTerms != actual.Filters.FirstLogin.AcceptTerms ||
+		expected.Filters.FirstLogin.ChangePassword != actual.Filters.FirstLogin.ChangePassword {
+		return errors.New("First login actions mismatch")
+	}
 	if err := compareUserFileExtensionsFilters(expected, actual); err != nil {
 		return err
 	}
+	return compareUserIngestionFolders(expected, actual)
+}
+
+func compareUserIngestionFolders(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.IngestionFolders) != len(actual.Filters.IngestionFolders) {
+		return errors.New("ingestion folders mismatch")
+	}
+	for _, f := range expected.Filters.IngestionFolders {
+		found := false
+		for _, f1 := range actual.Filters.IngestionFolders {
+			if path.Clean(f.Path) == f1.Path {
+				if f.HourlyRollup != f1.HourlyRollup {
+					return errors.New("ingestion folders contents mismatch")
+				}
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("ingestion folders contents mismatch")
+		}
+	}
 	return nil
 }
 
@@ -625,6 +2658,12 @@ func compareEqualsUserFields(expected *dataprovider.User, actual *dataprovider.U
 	if expected.DownloadBandwidth != actual.DownloadBandwidth {
 		return errors.New("DownloadBandwidth mismatch")
 	}
+	if expected.Plan != actual.Plan {
+		return errors.New("Plan mismatch")
+	}
+	if expected.AdditionalInfo != actual.AdditionalInfo {
+		return errors.New("AdditionalInfo mismatch")
+	}
 	if expected.Status != actual.Status {
 		return errors.New("Status mismatch")
 	}
@@ -0,0 +1,65 @@
+package httpd
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// usernames, defined inside the basic auth users file, with the read only auditor profile
+var auditorUsers []string
+
+var (
+	// the auditors can send requests using these methods, unless the path is denied
+	auditorAllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	// paths that modify the server state even if requested using a safe method
+	auditorDeniedPaths = []string{dumpDataPath, loadDataPath}
+	// paths that don't modify the server state even if requested using an unsafe method
+	auditorAllowedPaths []string
+	// paths, including their sub paths, that only modify the auditor's own state
+	auditorAllowedPathPrefixes = []string{uiPreferencesPath}
+	// read only gRPC methods
+	auditorAllowedGRPCMethods = []string{
+		"/sftpgo.admin.Admin/GetUsers",
+		"/sftpgo.admin.Admin/GetUser",
+		"/sftpgo.admin.Admin/GetConnections",
+		"/sftpgo.admin.Admin/GetQuotaScans",
+	}
+)
+
+func isAuditor(username string) bool {
+	return utils.IsStringInSlice(username, auditorUsers)
+}
+
+// isRequestAllowedForAuditor returns true if the given HTTP request does not modify
+// the server state, so it can be served for an auditor
+func isRequestAllowedForAuditor(r *http.Request) bool {
+	if utils.IsStringInSlice(r.URL.Path, auditorAllowedPaths) {
+		return true
+	}
+	if hasPathPrefix(r.URL.Path, auditorAllowedPathPrefixes) {
+		return true
+	}
+	if utils.IsStringInSlice(r.URL.Path, auditorDeniedPaths) {
+		return false
+	}
+	return utils.IsStringInSlice(r.Method, auditorAllowedMethods)
+}
+
+// isGRPCMethodAllowedForAuditor returns true if the given gRPC method does not modify
+// the server state, so it can be served for an auditor
+func isGRPCMethodAllowedForAuditor(fullMethod string) bool {
+	return utils.IsStringInSlice(fullMethod, auditorAllowedGRPCMethods)
+}
+
+// hasPathPrefix returns true if the given URL path is equal to one of the given prefixes
+// or if it is one of their sub paths
+func hasPathPrefix(urlPath string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
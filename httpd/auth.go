@@ -111,6 +111,14 @@ func (p *basicAuthProvider) getHashedPassword(username string) (string, bool) {
 func checkAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !validateCredentials(r) {
+			if username, _, ok := r.BasicAuth(); ok {
+				NotifyAdminEvent(AdminEvent{
+					Event:    AdminEventLoginFailed,
+					Username: username,
+					IP:       utils.GetIPFromRemoteAddress(r.RemoteAddr),
+					Protocol: "HTTP",
+				})
+			}
 			w.Header().Set(authenticationHeader, fmt.Sprintf("Basic realm=\"%v\"", authenticationRealm))
 			if strings.HasPrefix(r.RequestURI, apiPrefix) {
 				sendAPIResponse(w, r, errors.New(unauthResponse), "", http.StatusUnauthorized)
@@ -119,10 +127,34 @@ func checkAuth(next http.Handler) http.Handler {
 			}
 			return
 		}
-		next.ServeHTTP(w, r)
+		if !httpAuth.isEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		username, _, _ := r.BasicAuth()
+		notifyAdminLogin(username, utils.GetIPFromRemoteAddress(r.RemoteAddr), "HTTP")
+		if isAuditor(username) && !isRequestAllowedForAuditor(r) {
+			logger.Debug(logSender, "", "request %v %#v denied for auditor %#v", r.Method, r.URL.Path, username)
+			sendForbiddenResponse(w, r, "the auditors cannot modify the server state")
+			return
+		}
+		if _, ok := adminScopes[username]; ok && !isRequestAllowedForScopedAdmin(r) {
+			logger.Debug(logSender, "", "request %v %#v denied for scoped admin %#v", r.Method, r.URL.Path, username)
+			sendForbiddenResponse(w, r, "this operation is not allowed for the admins restricted to a scope")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withAdminScope(r.Context(), username)))
 	})
 }
 
+func sendForbiddenResponse(w http.ResponseWriter, r *http.Request, message string) {
+	if strings.HasPrefix(r.RequestURI, apiPrefix) {
+		sendAPIResponse(w, r, nil, message, http.StatusForbidden)
+	} else {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+}
+
 func validateCredentials(r *http.Request) bool {
 	if !httpAuth.isEnabled() {
 		return true
@@ -131,6 +163,10 @@ func validateCredentials(r *http.Request) bool {
 	if !ok {
 		return false
 	}
+	return checkPassword(username, password)
+}
+
+func checkPassword(username, password string) bool {
 	if hashedPwd, ok := httpAuth.getHashedPassword(username); ok {
 		if utils.IsStringPrefixInSlice(hashedPwd, bcryptPwdPrefixes) {
 			err := bcrypt.CompareHashAndPassword([]byte(hashedPwd), []byte(password))
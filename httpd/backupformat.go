@@ -0,0 +1,528 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// supported formats for dumpdata and loaddata
+const (
+	backupFormatJSON = "json"
+	backupFormatYAML = "yaml"
+	backupFormatCSV  = "csv"
+)
+
+// separator for the multi valued CSV fields: public keys and permissions
+const csvListSeparator = ";"
+
+// csvUserFields are the user fields supported inside CSV files, in the dump order.
+// The other user fields, for example the filters and the filesystem configuration,
+// cannot be represented as a single CSV column, use the JSON or YAML format for them
+var csvUserFields = []string{"username", "status", "expiration_date", "password", "public_keys", "home_dir", "uid",
+	"gid", "max_sessions", "quota_size", "quota_files", "permissions", "upload_bandwidth", "download_bandwidth", "plan",
+	"additional_info"}
+
+// invalidBackupError is returned if a backup cannot be parsed
+type invalidBackupError struct {
+	err error
+}
+
+func (e *invalidBackupError) Error() string {
+	return e.err.Error()
+}
+
+// getBackupFormat returns the requested backup format. If no format is requested it is
+// detected using the file extension, JSON is the default
+func getBackupFormat(format, fileName string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if len(format) == 0 {
+		switch strings.ToLower(filepath.Ext(fileName)) {
+		case ".yaml", ".yml":
+			return backupFormatYAML, nil
+		case ".csv":
+			return backupFormatCSV, nil
+		default:
+			return backupFormatJSON, nil
+		}
+	}
+	if format == "yml" {
+		format = backupFormatYAML
+	}
+	if !utils.IsStringInSlice(format, []string{backupFormatJSON, backupFormatYAML, backupFormatCSV}) {
+		return "", fmt.Errorf("invalid format %#v", format)
+	}
+	return format, nil
+}
+
+// getCSVColumnsMapping parses a mapping such as "Login=username,Home directory=home_dir".
+// The returned map has the lowercase CSV headers as keys and the user fields as values
+func getCSVColumnsMapping(mapping string) (map[string]string, error) {
+	result := make(map[string]string)
+	if len(strings.TrimSpace(mapping)) == 0 {
+		return result, nil
+	}
+	for _, m := range strings.Split(mapping, ",") {
+		idx := strings.LastIndex(m, "=")
+		if idx <= 0 {
+			return result, fmt.Errorf("invalid CSV column mapping %#v, the format is \"<header>=<field>\"", m)
+		}
+		header := strings.ToLower(strings.TrimSpace(m[:idx]))
+		field := strings.ToLower(strings.TrimSpace(m[idx+1:]))
+		if !utils.IsStringInSlice(field, csvUserFields) {
+			return result, fmt.Errorf("invalid CSV column mapping %#v, unsupported user field %#v", m, field)
+		}
+		result[header] = field
+	}
+	return result, nil
+}
+
+func marshalBackup(dump dataprovider.BackupData, format string, indent bool) ([]byte, error) {
+	switch format {
+	case backupFormatYAML:
+		return marshalBackupAsYAML(dump)
+	case backupFormatCSV:
+		return marshalUsersAsCSV(dump.Users)
+	default:
+		if indent {
+			return json.MarshalIndent(dump, "", "  ")
+		}
+		return json.Marshal(dump)
+	}
+}
+
+func unmarshalBackup(content []byte, format string, csvColumns map[string]string) (dataprovider.BackupData, error) {
+	var dump dataprovider.BackupData
+	var err error
+	switch format {
+	case backupFormatYAML:
+		err = unmarshalBackupFromYAML(content, &dump)
+	case backupFormatCSV:
+		dump.Users, err = unmarshalUsersFromCSV(content, csvColumns)
+	default:
+		err = json.Unmarshal(content, &dump)
+	}
+	return dump, err
+}
+
+// readJSONBackup reads the given JSON backup one top level key at a time. The given function is
+// called for each key and it returns true if it decoded the value, the values not decoded by the
+// function are skipped without loading them in memory. The parsing errors are *invalidBackupError
+func readJSONBackup(r io.Reader, fn func(key string, dec *json.Decoder) (bool, error)) error {
+	dec := json.NewDecoder(r)
+	if err := readJSONDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return &invalidBackupError{err: err}
+		}
+		decoded, err := fn(token.(string), dec)
+		if err != nil {
+			return err
+		}
+		if !decoded {
+			if err = skipJSONValue(dec); err != nil {
+				return err
+			}
+		}
+	}
+	if err := readJSONDelim(dec, '}'); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &invalidBackupError{err: errors.New("unexpected data after the JSON backup")}
+	}
+	return nil
+}
+
+// readJSONArray reads the JSON array, or null, at the current decoder position, the given
+// function is called for each array element and it must decode it
+func readJSONArray(dec *json.Decoder, fn func(dec *json.Decoder) error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return &invalidBackupError{err: err}
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return &invalidBackupError{err: fmt.Errorf("unexpected JSON value %v, an array is expected", token)}
+	}
+	for dec.More() {
+		if err = fn(dec); err != nil {
+			return err
+		}
+	}
+	return readJSONDelim(dec, ']')
+}
+
+// decodeJSONBackupValue decodes the next JSON value from the given decoder
+func decodeJSONBackupValue(dec *json.Decoder, v interface{}) error {
+	if err := dec.Decode(v); err != nil {
+		return &invalidBackupError{err: err}
+	}
+	return nil
+}
+
+func readJSONDelim(dec *json.Decoder, expected json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return &invalidBackupError{err: err}
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != expected {
+		return &invalidBackupError{err: fmt.Errorf("unexpected JSON token %v, %v is expected", token, expected)}
+	}
+	return nil
+}
+
+// skipJSONValue skips the next JSON value one token at a time
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return &invalidBackupError{err: err}
+		}
+		if delim, ok := token.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// marshalBackupAsYAML converts the JSON representation to YAML, so the YAML keys are the
+// same as the JSON ones
+func marshalBackupAsYAML(dump dataprovider.BackupData) ([]byte, error) {
+	data, err := json.Marshal(dump)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(fromJSONValue(value))
+}
+
+func unmarshalBackupFromYAML(content []byte, dump *dataprovider.BackupData) error {
+	var value interface{}
+	if err := yaml.Unmarshal(content, &value); err != nil {
+		return err
+	}
+	value, err := toJSONValue(value)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dump)
+}
+
+// fromJSONValue converts the integral numbers decoded from JSON as float64 to int64,
+// so they are not serialized using the exponent notation
+func fromJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = fromJSONValue(val)
+		}
+	case []interface{}:
+		for idx, val := range v {
+			v[idx] = fromJSONValue(val)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+	return value
+}
+
+// toJSONValue converts the YAML maps, that can have any key type, to maps with string
+// keys, so they can be serialized as JSON
+func toJSONValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for key, val := range v {
+			converted, err := toJSONValue(val)
+			if err != nil {
+				return nil, err
+			}
+			result[fmt.Sprintf("%v", key)] = converted
+		}
+		return result, nil
+	case []interface{}:
+		for idx, val := range v {
+			converted, err := toJSONValue(val)
+			if err != nil {
+				return nil, err
+			}
+			v[idx] = converted
+		}
+	}
+	return value, nil
+}
+
+func marshalUsersAsCSV(users []dataprovider.User) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(csvUserFields); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		record := make([]string, 0, len(csvUserFields))
+		for _, field := range csvUserFields {
+			record = append(record, getCSVUserField(&user, field))
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// unmarshalUsersFromCSV reads the users from a CSV file with a header row, see readUsersFromCSV
+func unmarshalUsersFromCSV(content []byte, csvColumns map[string]string) ([]dataprovider.User, error) {
+	var users []dataprovider.User
+	err := readUsersFromCSV(bytes.NewReader(content), csvColumns, func(user dataprovider.User) error {
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+// readUsersFromCSV reads the users from a CSV file with a header row, one row at a time, and
+// passes each user to the given function. The headers are the user fields, in any order, or the
+// headers mapped to the user fields in csvColumns. The other columns are ignored. Only the fields
+// with a non empty cell are set, the other ones are preserved for the existing users, so a CSV
+// restore cannot remove, for example, the filters. The parsing errors are *invalidBackupError
+func readUsersFromCSV(reader io.Reader, csvColumns map[string]string, fn func(user dataprovider.User) error) error {
+	r := csv.NewReader(reader)
+	r.TrimLeadingSpace = true
+	headers, err := r.Read()
+	if err == io.EOF {
+		return &invalidBackupError{err: fmt.Errorf("the CSV file has no header row")}
+	}
+	if err != nil {
+		return &invalidBackupError{err: err}
+	}
+	columns := make(map[int]string)
+	for idx, header := range headers {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if field, ok := csvColumns[header]; ok {
+			columns[idx] = field
+		} else if utils.IsStringInSlice(header, csvUserFields) {
+			columns[idx] = header
+		} else {
+			logger.Debug(logSender, "", "CSV column %#v ignored, it does not match any user field", header)
+		}
+	}
+	usernameIdx := -1
+	for idx, field := range columns {
+		if field == "username" {
+			usernameIdx = idx
+		}
+	}
+	if usernameIdx < 0 {
+		return &invalidBackupError{err: fmt.Errorf("the CSV file has no username column")}
+	}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &invalidBackupError{err: err}
+		}
+		username := strings.TrimSpace(record[usernameIdx])
+		if len(username) == 0 {
+			continue
+		}
+		user, err := dataprovider.UserExists(dataProvider, username)
+		if err != nil {
+			user = dataprovider.User{
+				Username: username,
+				Status:   1,
+			}
+		}
+		for idx, field := range columns {
+			if len(strings.TrimSpace(record[idx])) == 0 {
+				continue
+			}
+			if err = setCSVUserField(&user, field, record[idx]); err != nil {
+				return &invalidBackupError{err: fmt.Errorf("CSV line %v: %v", line, err)}
+			}
+		}
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+}
+
+func getCSVUserField(user *dataprovider.User, field string) string {
+	switch field {
+	case "username":
+		return user.Username
+	case "status":
+		return strconv.Itoa(user.Status)
+	case "expiration_date":
+		return strconv.FormatInt(user.ExpirationDate, 10)
+	case "password":
+		return user.Password
+	case "public_keys":
+		return strings.Join(user.PublicKeys, csvListSeparator)
+	case "home_dir":
+		return user.HomeDir
+	case "uid":
+		return strconv.Itoa(user.UID)
+	case "gid":
+		return strconv.Itoa(user.GID)
+	case "max_sessions":
+		return strconv.Itoa(user.MaxSessions)
+	case "quota_size":
+		return strconv.FormatInt(user.QuotaSize, 10)
+	case "quota_files":
+		return strconv.Itoa(user.QuotaFiles)
+	case "permissions":
+		var dirs []string
+		for dir := range user.Permissions {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		var perms []string
+		for _, dir := range dirs {
+			perms = append(perms, fmt.Sprintf("%v=%v", dir, strings.Join(user.Permissions[dir], ",")))
+		}
+		return strings.Join(perms, csvListSeparator)
+	case "upload_bandwidth":
+		return strconv.FormatInt(user.UploadBandwidth, 10)
+	case "download_bandwidth":
+		return strconv.FormatInt(user.DownloadBandwidth, 10)
+	case "plan":
+		return user.Plan
+	case "additional_info":
+		return user.AdditionalInfo
+	}
+	return ""
+}
+
+func setCSVUserField(user *dataprovider.User, field, value string) error {
+	value = strings.TrimSpace(value)
+	var err error
+	switch field {
+	case "username":
+		user.Username = value
+	case "status":
+		user.Status, err = getCSVInt(value)
+	case "expiration_date":
+		user.ExpirationDate, err = getCSVDate(value)
+	case "password":
+		user.Password = value
+	case "public_keys":
+		user.PublicKeys = getCSVList(value)
+	case "home_dir":
+		user.HomeDir = value
+	case "uid":
+		user.UID, err = getCSVInt(value)
+	case "gid":
+		user.GID, err = getCSVInt(value)
+	case "max_sessions":
+		user.MaxSessions, err = getCSVInt(value)
+	case "quota_size":
+		user.QuotaSize, err = getCSVInt64(value)
+	case "quota_files":
+		user.QuotaFiles, err = getCSVInt(value)
+	case "permissions":
+		user.Permissions, err = getCSVPermissions(value)
+	case "upload_bandwidth":
+		user.UploadBandwidth, err = getCSVInt64(value)
+	case "download_bandwidth":
+		user.DownloadBandwidth, err = getCSVInt64(value)
+	case "plan":
+		user.Plan = value
+	case "additional_info":
+		user.AdditionalInfo = value
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %v %#v: %v", field, value, err)
+	}
+	return nil
+}
+
+func getCSVInt(value string) (int, error) {
+	return strconv.Atoi(value)
+}
+
+func getCSVInt64(value string) (int64, error) {
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// getCSVDate accepts milliseconds since epoch, as dumped, or a YYYY-MM-DD date, as
+// usually exported from spreadsheets
+func getCSVDate(value string) (int64, error) {
+	if strings.Contains(value, "-") {
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return 0, err
+		}
+		return utils.GetTimeAsMsSinceEpoch(t), nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func getCSVList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, csvListSeparator) {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// getCSVPermissions parses permissions such as "/=*;/dir=list,download"
+func getCSVPermissions(value string) (map[string][]string, error) {
+	permissions := make(map[string][]string)
+	for _, p := range getCSVList(value) {
+		idx := strings.LastIndex(p, "=")
+		if idx <= 0 {
+			return permissions, fmt.Errorf("the format is \"<dir>=<perm1>,<perm2>\"")
+		}
+		var perms []string
+		for _, perm := range strings.Split(p[idx+1:], ",") {
+			perm = strings.TrimSpace(perm)
+			if len(perm) > 0 {
+				perms = append(perms, perm)
+			}
+		}
+		permissions[strings.TrimSpace(p[:idx])] = perms
+	}
+	return permissions, nil
+}
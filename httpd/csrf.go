@@ -0,0 +1,73 @@
+package httpd
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// name for the hidden form field containing the CSRF token
+	csrfFormField = "_form_token"
+	// the tokens are refused after this interval
+	csrfTokenMaxAge = 2 * time.Hour
+)
+
+var (
+	errInvalidCSRFToken = errors.New("invalid or expired form token, please reload the page and try again")
+	csrfSecret          []byte
+	csrfSecretOnce      sync.Once
+)
+
+// getCSRFSecret returns the key used to sign the CSRF tokens. It is randomly generated
+// at startup, so the forms rendered before a restart must be reloaded
+func getCSRFSecret() []byte {
+	csrfSecretOnce.Do(func() {
+		csrfSecret = make([]byte, 32)
+		if _, err := rand.Read(csrfSecret); err != nil {
+			panic(fmt.Sprintf("unable to generate the CSRF secret: %v", err))
+		}
+	})
+	return csrfSecret
+}
+
+func getCSRFTokenSignature(timestamp string) string {
+	mac := hmac.New(sha256.New, getCSRFSecret())
+	mac.Write([]byte(timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// createCSRFToken returns a signed token to include inside the web admin forms
+func createCSRFToken() string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return timestamp + "." + getCSRFTokenSignature(timestamp)
+}
+
+// verifyCSRFToken returns an error if the given token was not generated by this instance or if it is expired
+func verifyCSRFToken(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return errInvalidCSRFToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(getCSRFTokenSignature(parts[0]))) {
+		return errInvalidCSRFToken
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > csrfTokenMaxAge {
+		return errInvalidCSRFToken
+	}
+	return nil
+}
+
+// verifyFormCSRFToken checks the token posted with a web admin form, the form must be already parsed
+func verifyFormCSRFToken(r *http.Request) error {
+	return verifyCSRFToken(r.Form.Get(csrfFormField))
+}
@@ -0,0 +1,144 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/go-chi/chi"
+)
+
+// the custom routes cannot be mounted below these paths
+var reservedRoutePrefixes = []string{"/api", metricsPath, healthzPath, pprofBasePath, webBasePath, webStaticFilesPath}
+
+// CustomRoute defines an additional path served by the HTTP server, the files inside a
+// local directory or the responses of a proxied HTTP server
+type CustomRoute struct {
+	// URL path to mount the route at, for example "/docs". It cannot be "/" and it cannot
+	// overlap with the paths used by the REST API and by the web admin
+	Path string `json:"path" mapstructure:"path"`
+	// Directory with the static files to serve. This can be an absolute path or a path
+	// relative to the config dir. Directory listings are not allowed, an index.html file
+	// is served for the directories containing it
+	Directory string `json:"directory" mapstructure:"directory"`
+	// URL to proxy the requests to, for example "http://127.0.0.1:3000". The route path is
+	// replaced with the path of this URL. Directory and ProxyURL are mutually exclusive
+	ProxyURL string `json:"proxy_url" mapstructure:"proxy_url"`
+	// If enabled, the route requires the same authentication as the REST API
+	RequireAuth bool `json:"require_auth" mapstructure:"require_auth"`
+}
+
+func validateCustomRoutes(routes []CustomRoute, configDir string) ([]CustomRoute, error) {
+	var result []CustomRoute
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("custom routes: invalid path %#v, it must be absolute", route.Path)
+		}
+		route.Path = path.Clean(route.Path)
+		if route.Path == "/" {
+			return nil, errors.New("custom routes: the root path cannot be used")
+		}
+		for _, prefix := range reservedRoutePrefixes {
+			if route.Path == prefix || strings.HasPrefix(route.Path, prefix+"/") {
+				return nil, fmt.Errorf("custom routes: path %#v is reserved", route.Path)
+			}
+		}
+		for _, r := range result {
+			if route.Path == r.Path || strings.HasPrefix(route.Path, r.Path+"/") ||
+				strings.HasPrefix(r.Path, route.Path+"/") {
+				return nil, fmt.Errorf("custom routes: path %#v overlaps with %#v", route.Path, r.Path)
+			}
+		}
+		if (len(route.Directory) == 0) == (len(route.ProxyURL) == 0) {
+			return nil, fmt.Errorf("custom routes: exactly one of directory and proxy_url is required for path %#v",
+				route.Path)
+		}
+		if len(route.Directory) > 0 {
+			route.Directory = getConfigPath(route.Directory, configDir)
+			if len(route.Directory) == 0 {
+				return nil, fmt.Errorf("custom routes: invalid directory for path %#v", route.Path)
+			}
+		} else {
+			u, err := url.Parse(route.ProxyURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				return nil, fmt.Errorf("custom routes: invalid proxy_url %#v for path %#v", route.ProxyURL, route.Path)
+			}
+		}
+		result = append(result, route)
+	}
+	return result, nil
+}
+
+func mountCustomRoutes(router chi.Router, routes []CustomRoute) {
+	for _, route := range routes {
+		route := route
+		router.Group(func(router chi.Router) {
+			if route.RequireAuth {
+				router.Use(checkAuth)
+			}
+			if len(route.Directory) > 0 {
+				logger.Debug(logSender, "", "serving directory %#v at path %#v", route.Directory, route.Path)
+				fileServer(router, route.Path, noListingFileSystem{http.Dir(route.Directory)})
+				return
+			}
+			// the proxy URL is already validated
+			target, _ := url.Parse(route.ProxyURL)
+			logger.Debug(logSender, "", "proxying path %#v to %#v", route.Path, route.ProxyURL)
+			proxy := newCustomRouteProxy(route.Path, target)
+			router.Handle(route.Path, proxy)
+			router.Handle(route.Path+"/*", proxy)
+		})
+	}
+}
+
+// newCustomRouteProxy returns a reverse proxy that replaces the route path with the target path.
+// The Authorization header is removed, the browsers send the admin credentials to the custom
+// routes too and they must not reach the proxied server
+func newCustomRouteProxy(routePath string, target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, routePath), "/")
+		r.URL.RawPath = ""
+		director(r)
+		r.Host = target.Host
+		r.Header.Del("Authorization")
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Warn(logSender, "", "unable to proxy request %#v to %#v: %v", r.URL.Path, target.String(), err)
+		sendAPIResponse(w, r, err, "", http.StatusBadGateway)
+	}
+	return proxy
+}
+
+// noListingFileSystem is an http.FileSystem that refuses the directories without an index.html file
+type noListingFileSystem struct {
+	fs http.FileSystem
+}
+
+func (n noListingFileSystem) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := n.fs.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		index.Close()
+	}
+	return f, nil
+}
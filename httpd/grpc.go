@@ -0,0 +1,713 @@
+package httpd
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/httpd/adminpb"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer implements the gRPC admin API, the exposed operations mirror the REST API ones
+type adminServer struct{}
+
+var grpcServer *grpc.Server
+
+func init() {
+	utils.AddFeature("+grpc")
+}
+
+// startGRPCServer starts the gRPC admin API on the given address.
+// The server uses the HTTP basic auth users file, the TLS certificate and the IP lists
+// configured for the REST API
+func startGRPCServer(address string, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Warn(logSender, "", "error starting gRPC listener on %#v: %v", address, err)
+		return err
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(checkGRPCRequest),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	adminpb.RegisterAdminServer(server, &adminServer{})
+	logger.Info(logSender, "", "gRPC server listening on %#v", address)
+	serverMutex.Lock()
+	grpcServer = server
+	serverMutex.Unlock()
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Warn(logSender, "", "gRPC server on %#v exited: %v", address, err)
+		}
+	}()
+	return nil
+}
+
+func stopGRPCServer() {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if grpcServer != nil {
+		grpcServer.Stop()
+		grpcServer = nil
+	}
+}
+
+// checkGRPCRequest refuses the requests from the addresses in the block list and
+// validates the credentials, sent as basic auth in the "authorization" metadata key,
+// if HTTP authentication is enabled. The auditors can only call the read only methods.
+// The scope for the admin, if any, is added to the context
+func checkGRPCRequest(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ipAddr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		ipAddr = utils.GetIPFromRemoteAddress(p.Addr.String())
+		if dataprovider.IsIPBlocked(ipAddr) {
+			logger.Debug(logSender, "", "gRPC request from blocked IP address %#v refused", ipAddr)
+			return nil, status.Error(codes.PermissionDenied, http.StatusText(http.StatusForbidden))
+		}
+	}
+	if httpAuth.isEnabled() {
+		md, _ := metadata.FromIncomingContext(ctx)
+		r := &http.Request{Header: http.Header{"Authorization": md.Get("authorization")}}
+		username, password, ok := r.BasicAuth()
+		if !ok || !checkPassword(username, password) {
+			if ok {
+				NotifyAdminEvent(AdminEvent{
+					Event:    AdminEventLoginFailed,
+					Username: username,
+					IP:       ipAddr,
+					Protocol: "gRPC",
+				})
+			}
+			return nil, status.Error(codes.Unauthenticated, unauthResponse)
+		}
+		notifyAdminLogin(username, ipAddr, "gRPC")
+		if isAuditor(username) && !isGRPCMethodAllowedForAuditor(info.FullMethod) {
+			logger.Debug(logSender, "", "gRPC method %#v denied for auditor %#v", info.FullMethod, username)
+			return nil, status.Error(codes.PermissionDenied, "the auditors cannot modify the server state")
+		}
+		if _, ok := adminScopes[username]; ok && utils.IsStringInSlice(info.FullMethod, scopedAdminDeniedGRPCMethods) {
+			logger.Debug(logSender, "", "gRPC method %#v denied for scoped admin %#v", info.FullMethod, username)
+			return nil, status.Error(codes.PermissionDenied,
+				"this operation is not allowed for the admins restricted to a scope")
+		}
+		ctx = withAdminScope(ctx, username)
+	}
+	return handler(ctx, req)
+}
+
+func getGRPCError(err error) error {
+	switch err.(type) {
+	case *dataprovider.ValidationError:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case *dataprovider.RecordNotFoundError:
+		return status.Error(codes.NotFound, err.Error())
+	case *dataprovider.MethodDisabledError:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err == dataprovider.ErrUserVersionMismatch {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	if os.IsNotExist(err) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func getGRPCDisconnectOption(mode adminpb.DisconnectMode) bool {
+	switch mode {
+	case adminpb.DisconnectMode_DISCONNECT_YES:
+		return true
+	case adminpb.DisconnectMode_DISCONNECT_NO:
+		return false
+	default:
+		return sftpd.IsDisconnectOnUserChangeEnabled()
+	}
+}
+
+func (s *adminServer) GetUsers(ctx context.Context, req *adminpb.GetUsersRequest) (*adminpb.GetUsersResponse, error) {
+	limit := 100
+	order := "ASC"
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid limit")
+	}
+	if req.Limit > 0 {
+		limit = int(req.Limit)
+		if limit > 500 {
+			limit = 500
+		}
+	}
+	if req.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid offset")
+	}
+	if len(req.Order) > 0 {
+		order = req.Order
+		if order != "ASC" && order != "DESC" {
+			return nil, status.Error(codes.InvalidArgument, "Invalid order")
+		}
+	}
+	users, err := dataprovider.GetUsersInScope(dataProvider, getAdminScope(ctx), limit, int(req.Offset), order,
+		req.Username)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &adminpb.GetUsersResponse{}
+	for _, user := range users {
+		resp.Users = append(resp.Users, userToProto(user))
+	}
+	return resp, nil
+}
+
+func (s *adminServer) GetUser(ctx context.Context, req *adminpb.GetUserRequest) (*adminpb.User, error) {
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(ctx), req.Id)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	return userToProto(dataprovider.HideUserSensitiveData(&user)), nil
+}
+
+func (s *adminServer) AddUser(ctx context.Context, req *adminpb.AddUserRequest) (*adminpb.User, error) {
+	if req.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is mandatory")
+	}
+	user := userFromProto(req.User)
+	err := dataprovider.AddUserInScope(dataProvider, getAdminScope(ctx), user)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	user, err = dataprovider.UserExists(dataProvider, user.Username)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return userToProto(dataprovider.HideUserSensitiveData(&user)), nil
+}
+
+func (s *adminServer) UpdateUser(ctx context.Context, req *adminpb.UpdateUserRequest) (*adminpb.ApiResponse, error) {
+	if req.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is mandatory")
+	}
+	currentUser, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(ctx), req.User.Id)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	user := userFromProto(req.User)
+	// we use the current values for the password, the permissions and the
+	// file extensions if the new ones are empty
+	if len(user.Password) == 0 {
+		user.Password = currentUser.Password
+	}
+	if len(user.Permissions) == 0 {
+		user.Permissions = currentUser.Permissions
+	}
+	if len(user.Filters.FileExtensions) == 0 {
+		user.Filters.FileExtensions = currentUser.Filters.FileExtensions
+	}
+	if user.FsConfig.Provider == 1 {
+		currentS3Config := vfs.S3FsConfig{}
+		if currentUser.FsConfig.Provider == 1 {
+			currentS3Config = currentUser.FsConfig.S3Config
+		}
+		restoreS3Secrets(&user.FsConfig.S3Config, currentS3Config)
+	}
+	if user.FsConfig.Provider == 3 && currentUser.FsConfig.Provider == 3 {
+		currentPassphrase := currentUser.FsConfig.CryptConfig.Passphrase
+		if utils.RemoveDecryptionKey(currentPassphrase) == user.FsConfig.CryptConfig.Passphrase ||
+			len(user.FsConfig.CryptConfig.Passphrase) == 0 {
+			user.FsConfig.CryptConfig.Passphrase = currentPassphrase
+		}
+	}
+	if user.FsConfig.Provider == 4 && currentUser.FsConfig.Provider == 4 {
+		restoreWebDAVSecrets(&user.FsConfig.WebDAVConfig, currentUser.FsConfig.WebDAVConfig)
+	}
+	if user.FsConfig.Provider == 5 && currentUser.FsConfig.Provider == 5 {
+		restoreHDFSSecrets(&user.FsConfig.HDFSConfig, currentUser.FsConfig.HDFSConfig)
+	}
+	if user.FsConfig.Provider == 6 && currentUser.FsConfig.Provider == 6 {
+		restoreGoogleDriveSecrets(&user.FsConfig.GoogleDriveConfig, currentUser.FsConfig.GoogleDriveConfig)
+	}
+	if user.FsConfig.Provider == 7 && currentUser.FsConfig.Provider == 7 {
+		restoreDropboxSecrets(&user.FsConfig.DropboxConfig, currentUser.FsConfig.DropboxConfig)
+	}
+	restoreVirtualFoldersSecrets(user.VirtualFolders, currentUser.VirtualFolders)
+	err = dataprovider.UpdateUserInScope(dataProvider, getAdminScope(ctx), user)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	if getGRPCDisconnectOption(req.Disconnect) {
+		disconnectUser(currentUser.Username)
+	}
+	return &adminpb.ApiResponse{Message: "User updated"}, nil
+}
+
+func (s *adminServer) DeleteUser(ctx context.Context, req *adminpb.DeleteUserRequest) (*adminpb.ApiResponse, error) {
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(ctx), req.Id)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	err = dataprovider.DeleteUserInScope(dataProvider, getAdminScope(ctx), user)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	if getGRPCDisconnectOption(req.Disconnect) {
+		disconnectUser(user.Username)
+	}
+	return &adminpb.ApiResponse{Message: "User deleted"}, nil
+}
+
+func (s *adminServer) GetConnections(ctx context.Context, req *adminpb.GetConnectionsRequest) (*adminpb.GetConnectionsResponse, error) {
+	resp := &adminpb.GetConnectionsResponse{}
+	filter := sftpd.ConnectionTagsFilter{
+		ClientSoftware: strings.TrimSpace(req.GetClientSoftware()),
+		Tenant:         strings.TrimSpace(req.GetTenant()),
+		Label:          strings.TrimSpace(req.GetLabel()),
+	}
+	for _, c := range filterConnectionsByScope(sftpd.GetFilteredConnectionsStats(filter), getAdminScope(ctx)) {
+		conn := &adminpb.Connection{
+			Username:       c.Username,
+			ConnectionId:   c.ConnectionID,
+			ClientVersion:  c.ClientVersion,
+			RemoteAddress:  c.RemoteAddress,
+			ConnectionTime: c.ConnectionTime,
+			LastActivity:   c.LastActivity,
+			Protocol:       c.Protocol,
+			SshCommand:     c.SSHCommand,
+			ClientSoftware: c.ClientSoftware,
+			Tenant:         c.Tenant,
+			Label:          c.Label,
+		}
+		for _, t := range c.Transfers {
+			conn.ActiveTransfers = append(conn.ActiveTransfers, &adminpb.Transfer{
+				OperationType: t.OperationType,
+				Path:          t.Path,
+				StartTime:     t.StartTime,
+				Size:          t.Size,
+				LastActivity:  t.LastActivity,
+				Degraded:      t.Degraded,
+			})
+		}
+		resp.Connections = append(resp.Connections, conn)
+	}
+	return resp, nil
+}
+
+func (s *adminServer) CloseConnection(ctx context.Context, req *adminpb.CloseConnectionRequest) (*adminpb.ApiResponse, error) {
+	if len(req.ConnectionId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "connectionID is mandatory")
+	}
+	if !isConnectionInScope(req.ConnectionId, getAdminScope(ctx)) || !sftpd.CloseActiveConnection(req.ConnectionId) {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return &adminpb.ApiResponse{Message: "Connection closed"}, nil
+}
+
+func (s *adminServer) UpdateConnectionLabel(ctx context.Context, req *adminpb.UpdateConnectionLabelRequest) (*adminpb.ApiResponse, error) {
+	if len(req.ConnectionId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "connectionID is mandatory")
+	}
+	label := strings.TrimSpace(req.Label)
+	if !sftpd.IsValidConnectionLabel(label) {
+		return nil, status.Error(codes.InvalidArgument, "the label cannot be longer than 255 characters")
+	}
+	if !isConnectionInScope(req.ConnectionId, getAdminScope(ctx)) || !sftpd.SetConnectionLabel(req.ConnectionId, label) {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	return &adminpb.ApiResponse{Message: "Connection updated"}, nil
+}
+
+func (s *adminServer) GetQuotaScans(ctx context.Context, req *adminpb.GetQuotaScansRequest) (*adminpb.GetQuotaScansResponse, error) {
+	resp := &adminpb.GetQuotaScansResponse{}
+	for _, scan := range filterQuotaScansByScope(sftpd.GetQuotaScans(), getAdminScope(ctx)) {
+		resp.Scans = append(resp.Scans, &adminpb.QuotaScan{
+			Username:  scan.Username,
+			StartTime: scan.StartTime,
+		})
+	}
+	return resp, nil
+}
+
+func (s *adminServer) StartQuotaScan(ctx context.Context, req *adminpb.StartQuotaScanRequest) (*adminpb.ApiResponse, error) {
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(ctx), req.Username)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if !sftpd.AddQuotaScan(user.Username) {
+		return nil, status.Error(codes.AlreadyExists, "Another scan is already in progress")
+	}
+	go doQuotaScan(user)
+	return &adminpb.ApiResponse{Message: "Scan started"}, nil
+}
+
+func (s *adminServer) DumpData(ctx context.Context, req *adminpb.DumpDataRequest) (*adminpb.ApiResponse, error) {
+	outputFile := strings.TrimSpace(req.OutputFile)
+	if len(outputFile) == 0 || filepath.IsAbs(outputFile) || strings.Contains(outputFile, "..") {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid output_file %#v: it must be a relative path", outputFile)
+	}
+	// the format is detected using the file extension, this cannot fail
+	format, _ := getBackupFormat("", outputFile)
+	err := dumpUsers(filepath.Join(backupsPath, outputFile), format, req.Indent)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	return &adminpb.ApiResponse{Message: "Data saved"}, nil
+}
+
+func (s *adminServer) LoadData(ctx context.Context, req *adminpb.LoadDataRequest) (*adminpb.ApiResponse, error) {
+	inputFile := strings.TrimSpace(req.InputFile)
+	if !filepath.IsAbs(inputFile) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid input_file %#v: it must be an absolute path", inputFile)
+	}
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	defer f.Close()
+	// the format is detected using the file extension, this cannot fail
+	format, _ := getBackupFormat("", inputFile)
+	err = restoreBackup(f, inputFile, format, nil, int(req.ScanQuota), int(req.Mode))
+	if _, ok := err.(*invalidBackupError); ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unable to parse input file %#v: %v", inputFile, err)
+	}
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+	return &adminpb.ApiResponse{Message: "Data restored"}, nil
+}
+
+func userToProto(user dataprovider.User) *adminpb.User {
+	u := &adminpb.User{
+		Id:                user.ID,
+		Status:            int32(user.Status),
+		Username:          user.Username,
+		ExpirationDate:    user.ExpirationDate,
+		Password:          user.Password,
+		PublicKeys:        user.PublicKeys,
+		HomeDir:           user.HomeDir,
+		Uid:               int32(user.UID),
+		Gid:               int32(user.GID),
+		MaxSessions:       int32(user.MaxSessions),
+		QuotaSize:         user.QuotaSize,
+		QuotaFiles:        int32(user.QuotaFiles),
+		Permissions:       make(map[string]*adminpb.Permissions),
+		UsedQuotaSize:     user.UsedQuotaSize,
+		UsedQuotaFiles:    int32(user.UsedQuotaFiles),
+		LastQuotaUpdate:   user.LastQuotaUpdate,
+		UploadBandwidth:   user.UploadBandwidth,
+		DownloadBandwidth: user.DownloadBandwidth,
+		LastLogin:         user.LastLogin,
+		Plan:              user.Plan,
+		AdditionalInfo:    user.AdditionalInfo,
+		CreatedAt:         user.CreatedAt,
+		UpdatedAt:         user.UpdatedAt,
+		Version:           user.Version,
+		Filters: &adminpb.UserFilters{
+			AllowedIp:              user.Filters.AllowedIP,
+			DeniedIp:               user.Filters.DeniedIP,
+			DeniedLoginMethods:     user.Filters.DeniedLoginMethods,
+			RevokedKeyFingerprints: user.Filters.RevokedKeyFingerprints,
+			AllowedKeyAlgorithms:   user.Filters.AllowedKeyAlgorithms,
+			MinRsaKeySize:          int32(user.Filters.MinRSAKeySize),
+			AllowedSshCommands:     user.Filters.AllowedSSHCommands,
+			Tenant:                 user.Filters.Tenant,
+			ReadOnly:               user.Filters.ReadOnly,
+		},
+		Filesystem: &adminpb.Filesystem{
+			Provider:  int32(user.FsConfig.Provider),
+			S3Config:  s3ConfigToProto(user.FsConfig.S3Config),
+			Gcsconfig: gcsConfigToProto(user.FsConfig.GCSConfig),
+			Cryptconfig: &adminpb.CryptConfig{
+				Passphrase: user.FsConfig.CryptConfig.Passphrase,
+			},
+			Webdavconfig: &adminpb.WebDAVConfig{
+				Endpoint:    user.FsConfig.WebDAVConfig.Endpoint,
+				Username:    user.FsConfig.WebDAVConfig.Username,
+				Password:    user.FsConfig.WebDAVConfig.Password,
+				BearerToken: user.FsConfig.WebDAVConfig.BearerToken,
+				RootPath:    user.FsConfig.WebDAVConfig.RootPath,
+			},
+			Hdfsconfig: &adminpb.HDFSConfig{
+				Endpoint:        user.FsConfig.HDFSConfig.Endpoint,
+				Username:        user.FsConfig.HDFSConfig.Username,
+				DelegationToken: user.FsConfig.HDFSConfig.DelegationToken,
+				RootPath:        user.FsConfig.HDFSConfig.RootPath,
+			},
+			Gdriveconfig: &adminpb.GoogleDriveConfig{
+				FolderId:     user.FsConfig.GoogleDriveConfig.FolderID,
+				Credentials:  user.FsConfig.GoogleDriveConfig.Credentials,
+				Subject:      user.FsConfig.GoogleDriveConfig.Subject,
+				ClientId:     user.FsConfig.GoogleDriveConfig.ClientID,
+				ClientSecret: user.FsConfig.GoogleDriveConfig.ClientSecret,
+				RefreshToken: user.FsConfig.GoogleDriveConfig.RefreshToken,
+				Endpoint:     user.FsConfig.GoogleDriveConfig.Endpoint,
+			},
+			Dropboxconfig: &adminpb.DropboxConfig{
+				RootPath:        user.FsConfig.DropboxConfig.RootPath,
+				AccessToken:     user.FsConfig.DropboxConfig.AccessToken,
+				RefreshToken:    user.FsConfig.DropboxConfig.RefreshToken,
+				AppKey:          user.FsConfig.DropboxConfig.AppKey,
+				AppSecret:       user.FsConfig.DropboxConfig.AppSecret,
+				UploadChunkSize: user.FsConfig.DropboxConfig.UploadChunkSize,
+				Endpoint:        user.FsConfig.DropboxConfig.Endpoint,
+			},
+		},
+	}
+	for _, v := range user.VirtualFolders {
+		folder := &adminpb.VirtualFolder{
+			VirtualPath: v.VirtualPath,
+			MappedPath:  v.MappedPath,
+			Name:        v.Name,
+		}
+		if v.HasFilesystem() {
+			folder.Filesystem = &adminpb.Filesystem{
+				Provider:  int32(v.Filesystem.Provider),
+				S3Config:  s3ConfigToProto(v.Filesystem.S3Config),
+				Gcsconfig: gcsConfigToProto(v.Filesystem.GCSConfig),
+			}
+		}
+		u.VirtualFolders = append(u.VirtualFolders, folder)
+	}
+	for dir, perms := range user.Permissions {
+		u.Permissions[dir] = &adminpb.Permissions{Permissions: perms}
+	}
+	for _, f := range user.Filters.FileExtensions {
+		u.Filters.FileExtensions = append(u.Filters.FileExtensions, &adminpb.ExtensionsFilter{
+			Path:              f.Path,
+			AllowedExtensions: f.AllowedExtensions,
+			DeniedExtensions:  f.DeniedExtensions,
+		})
+	}
+	for _, f := range user.Filters.IngestionFolders {
+		u.Filters.IngestionFolders = append(u.Filters.IngestionFolders, &adminpb.IngestionFolder{
+			Path:         f.Path,
+			HourlyRollup: f.HourlyRollup,
+		})
+	}
+	return u
+}
+
+func s3ConfigToProto(config vfs.S3FsConfig) *adminpb.S3Config {
+	return &adminpb.S3Config{
+		Bucket:              config.Bucket,
+		KeyPrefix:           config.KeyPrefix,
+		Region:              config.Region,
+		AccessKey:           config.AccessKey,
+		AccessSecret:        config.AccessSecret,
+		SessionToken:        config.SessionToken,
+		RoleArn:             config.RoleARN,
+		ExternalId:          config.ExternalID,
+		Endpoint:            config.Endpoint,
+		StorageClass:        config.StorageClass,
+		UploadPartSize:      config.UploadPartSize,
+		UploadConcurrency:   int32(config.UploadConcurrency),
+		StorageClassRules:   storageClassRulesToProto(config.StorageClassRules),
+		ForcePathStyle:      config.ForcePathStyle,
+		SkipTlsVerify:       config.SkipTLSVerify,
+		CaBundle:            config.CABundle,
+		DownloadPartSize:    config.DownloadPartSize,
+		DownloadConcurrency: int32(config.DownloadConcurrency),
+	}
+}
+
+func gcsConfigToProto(config vfs.GCSFsConfig) *adminpb.GCSConfig {
+	return &adminpb.GCSConfig{
+		Bucket:               config.Bucket,
+		KeyPrefix:            config.KeyPrefix,
+		Credentials:          config.Credentials,
+		AutomaticCredentials: int32(config.AutomaticCredentials),
+		StorageClass:         config.StorageClass,
+		StorageClassRules:    storageClassRulesToProto(config.StorageClassRules),
+		DownloadPartSize:     config.DownloadPartSize,
+		DownloadConcurrency:  int32(config.DownloadConcurrency),
+	}
+}
+
+func s3ConfigFromProto(config *adminpb.S3Config) vfs.S3FsConfig {
+	return vfs.S3FsConfig{
+		Bucket:              config.GetBucket(),
+		KeyPrefix:           config.GetKeyPrefix(),
+		Region:              config.GetRegion(),
+		AccessKey:           config.GetAccessKey(),
+		AccessSecret:        config.GetAccessSecret(),
+		SessionToken:        config.GetSessionToken(),
+		RoleARN:             config.GetRoleArn(),
+		ExternalID:          config.GetExternalId(),
+		Endpoint:            config.GetEndpoint(),
+		StorageClass:        config.GetStorageClass(),
+		UploadPartSize:      config.GetUploadPartSize(),
+		UploadConcurrency:   int(config.GetUploadConcurrency()),
+		StorageClassRules:   storageClassRulesFromProto(config.GetStorageClassRules()),
+		ForcePathStyle:      config.GetForcePathStyle(),
+		SkipTLSVerify:       config.GetSkipTlsVerify(),
+		CABundle:            config.GetCaBundle(),
+		DownloadPartSize:    config.GetDownloadPartSize(),
+		DownloadConcurrency: int(config.GetDownloadConcurrency()),
+	}
+}
+
+func gcsConfigFromProto(config *adminpb.GCSConfig) vfs.GCSFsConfig {
+	return vfs.GCSFsConfig{
+		Bucket:               config.GetBucket(),
+		KeyPrefix:            config.GetKeyPrefix(),
+		Credentials:          config.GetCredentials(),
+		AutomaticCredentials: int(config.GetAutomaticCredentials()),
+		StorageClass:         config.GetStorageClass(),
+		StorageClassRules:    storageClassRulesFromProto(config.GetStorageClassRules()),
+		DownloadPartSize:     config.GetDownloadPartSize(),
+		DownloadConcurrency:  int(config.GetDownloadConcurrency()),
+	}
+}
+
+func storageClassRulesToProto(rules []vfs.StorageClassRule) []*adminpb.StorageClassRule {
+	var result []*adminpb.StorageClassRule
+	for _, rule := range rules {
+		result = append(result, &adminpb.StorageClassRule{
+			Path:         rule.Path,
+			Extensions:   rule.Extensions,
+			MinSize:      rule.MinSize,
+			StorageClass: rule.StorageClass,
+		})
+	}
+	return result
+}
+
+func storageClassRulesFromProto(rules []*adminpb.StorageClassRule) []vfs.StorageClassRule {
+	var result []vfs.StorageClassRule
+	for _, rule := range rules {
+		result = append(result, vfs.StorageClassRule{
+			Path:         rule.GetPath(),
+			Extensions:   rule.GetExtensions(),
+			MinSize:      rule.GetMinSize(),
+			StorageClass: rule.GetStorageClass(),
+		})
+	}
+	return result
+}
+
+func userFromProto(u *adminpb.User) dataprovider.User {
+	user := dataprovider.User{
+		ID:                u.GetId(),
+		Status:            int(u.GetStatus()),
+		Username:          u.GetUsername(),
+		ExpirationDate:    u.GetExpirationDate(),
+		Password:          u.GetPassword(),
+		PublicKeys:        u.GetPublicKeys(),
+		HomeDir:           u.GetHomeDir(),
+		UID:               int(u.GetUid()),
+		GID:               int(u.GetGid()),
+		MaxSessions:       int(u.GetMaxSessions()),
+		QuotaSize:         u.GetQuotaSize(),
+		QuotaFiles:        int(u.GetQuotaFiles()),
+		Permissions:       make(map[string][]string),
+		UsedQuotaSize:     u.GetUsedQuotaSize(),
+		UsedQuotaFiles:    int(u.GetUsedQuotaFiles()),
+		LastQuotaUpdate:   u.GetLastQuotaUpdate(),
+		UploadBandwidth:   u.GetUploadBandwidth(),
+		DownloadBandwidth: u.GetDownloadBandwidth(),
+		LastLogin:         u.GetLastLogin(),
+		Plan:              u.GetPlan(),
+		AdditionalInfo:    u.GetAdditionalInfo(),
+		CreatedAt:         u.GetCreatedAt(),
+		UpdatedAt:         u.GetUpdatedAt(),
+		Version:           u.GetVersion(),
+		Filters: dataprovider.UserFilters{
+			AllowedIP:              u.GetFilters().GetAllowedIp(),
+			DeniedIP:               u.GetFilters().GetDeniedIp(),
+			DeniedLoginMethods:     u.GetFilters().GetDeniedLoginMethods(),
+			RevokedKeyFingerprints: u.GetFilters().GetRevokedKeyFingerprints(),
+			AllowedKeyAlgorithms:   u.GetFilters().GetAllowedKeyAlgorithms(),
+			MinRSAKeySize:          int(u.GetFilters().GetMinRsaKeySize()),
+			AllowedSSHCommands:     u.GetFilters().GetAllowedSshCommands(),
+			Tenant:                 u.GetFilters().GetTenant(),
+			ReadOnly:               u.GetFilters().GetReadOnly(),
+		},
+		FsConfig: dataprovider.Filesystem{
+			Provider:  int(u.GetFilesystem().GetProvider()),
+			S3Config:  s3ConfigFromProto(u.GetFilesystem().GetS3Config()),
+			GCSConfig: gcsConfigFromProto(u.GetFilesystem().GetGcsconfig()),
+			CryptConfig: vfs.CryptFsConfig{
+				Passphrase: u.GetFilesystem().GetCryptconfig().GetPassphrase(),
+			},
+			WebDAVConfig: vfs.WebDAVFsConfig{
+				Endpoint:    u.GetFilesystem().GetWebdavconfig().GetEndpoint(),
+				Username:    u.GetFilesystem().GetWebdavconfig().GetUsername(),
+				Password:    u.GetFilesystem().GetWebdavconfig().GetPassword(),
+				BearerToken: u.GetFilesystem().GetWebdavconfig().GetBearerToken(),
+				RootPath:    u.GetFilesystem().GetWebdavconfig().GetRootPath(),
+			},
+			HDFSConfig: vfs.HDFSFsConfig{
+				Endpoint:        u.GetFilesystem().GetHdfsconfig().GetEndpoint(),
+				Username:        u.GetFilesystem().GetHdfsconfig().GetUsername(),
+				DelegationToken: u.GetFilesystem().GetHdfsconfig().GetDelegationToken(),
+				RootPath:        u.GetFilesystem().GetHdfsconfig().GetRootPath(),
+			},
+			GoogleDriveConfig: vfs.GoogleDriveFsConfig{
+				FolderID:     u.GetFilesystem().GetGdriveconfig().GetFolderId(),
+				Credentials:  u.GetFilesystem().GetGdriveconfig().GetCredentials(),
+				Subject:      u.GetFilesystem().GetGdriveconfig().GetSubject(),
+				ClientID:     u.GetFilesystem().GetGdriveconfig().GetClientId(),
+				ClientSecret: u.GetFilesystem().GetGdriveconfig().GetClientSecret(),
+				RefreshToken: u.GetFilesystem().GetGdriveconfig().GetRefreshToken(),
+				Endpoint:     u.GetFilesystem().GetGdriveconfig().GetEndpoint(),
+			},
+			DropboxConfig: vfs.DropboxFsConfig{
+				RootPath:        u.GetFilesystem().GetDropboxconfig().GetRootPath(),
+				AccessToken:     u.GetFilesystem().GetDropboxconfig().GetAccessToken(),
+				RefreshToken:    u.GetFilesystem().GetDropboxconfig().GetRefreshToken(),
+				AppKey:          u.GetFilesystem().GetDropboxconfig().GetAppKey(),
+				AppSecret:       u.GetFilesystem().GetDropboxconfig().GetAppSecret(),
+				UploadChunkSize: u.GetFilesystem().GetDropboxconfig().GetUploadChunkSize(),
+				Endpoint:        u.GetFilesystem().GetDropboxconfig().GetEndpoint(),
+			},
+		},
+	}
+	for _, v := range u.GetVirtualFolders() {
+		folder := vfs.VirtualFolder{
+			VirtualPath: v.GetVirtualPath(),
+			MappedPath:  v.GetMappedPath(),
+			Name:        v.GetName(),
+		}
+		if v.GetFilesystem() != nil {
+			folder.Filesystem = &vfs.VirtualFolderFilesystem{
+				Provider:  int(v.GetFilesystem().GetProvider()),
+				S3Config:  s3ConfigFromProto(v.GetFilesystem().GetS3Config()),
+				GCSConfig: gcsConfigFromProto(v.GetFilesystem().GetGcsconfig()),
+			}
+		}
+		user.VirtualFolders = append(user.VirtualFolders, folder)
+	}
+	for dir, perms := range u.GetPermissions() {
+		user.Permissions[dir] = perms.GetPermissions()
+	}
+	for _, f := range u.GetFilters().GetFileExtensions() {
+		user.Filters.FileExtensions = append(user.Filters.FileExtensions, dataprovider.ExtensionsFilter{
+			Path:              f.GetPath(),
+			AllowedExtensions: f.GetAllowedExtensions(),
+			DeniedExtensions:  f.GetDeniedExtensions(),
+		})
+	}
+	for _, f := range u.GetFilters().GetIngestionFolders() {
+		user.Filters.IngestionFolders = append(user.Filters.IngestionFolders, dataprovider.IngestionFolder{
+			Path:         f.GetPath(),
+			HourlyRollup: f.GetHourlyRollup(),
+		})
+	}
+	return user
+}
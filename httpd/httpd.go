@@ -1,9 +1,11 @@
 // Package httpd implements REST API and Web interface for SFTPGo.
 // REST API allows to manage users and quota and to get real time reports for the active connections
 // with possibility of forcibly closing a connection.
-// The OpenAPI 3 schema for the exposed API can be found inside the source tree:
-// https://github.com/drakkan/sftpgo/tree/master/api/schema/openapi.yaml
-// A basic Web interface to manage users and connections is provided too
+// The OpenAPI 3 document for the exposed API is generated from the registered routes
+// and it is served at /api/v1/openapi.yaml.
+// A basic Web interface to manage users and connections is provided too.
+// The management operations can optionally be exposed via a gRPC service too,
+// the proto definitions can be found inside the adminpb sub package
 package httpd
 
 import (
@@ -11,8 +13,10 @@ import (
 	"fmt"
 	"net/http"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/drakkan/sftpgo/assets"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
@@ -26,18 +30,67 @@ const (
 	quotaScanPath         = "/api/v1/quota_scan"
 	userPath              = "/api/v1/user"
 	versionPath           = "/api/v1/version"
+	openAPIPath           = "/api/v1/openapi.yaml"
 	providerStatusPath    = "/api/v1/providerstatus"
+	providerMigrationPath = "/api/v1/providermigration"
+	pluginStatusPath      = "/api/v1/pluginstatus"
 	dumpDataPath          = "/api/v1/dumpdata"
 	loadDataPath          = "/api/v1/loaddata"
+	ipListPath            = "/api/v1/iplist"
+	ipListImportPath      = "/api/v1/iplist/import"
+	ipListExportPath      = "/api/v1/iplist/export"
+	ipListFeedsPath       = "/api/v1/iplist/feeds"
+	ipListCheckPath       = "/api/v1/iplist/check"
+	ipListUnblockPath     = "/api/v1/iplist/unblock"
+	defenderHostsPath     = "/api/v1/defender/hosts"
+	defenderBanPath       = "/api/v1/defender/ban"
+	userBulkPath          = "/api/v1/user_bulk"
+	userOverridePath      = "/api/v1/user_override"
+	userOverrideAuditPath = "/api/v1/user_override_audit"
+	userOffboardingPath   = "/api/v1/user_offboarding"
+	planPath              = "/api/v1/plan"
+	planPropagationPath   = "/api/v1/plan_propagation"
+	userTemplatePath      = "/api/v1/user_template"
+	folderPath            = "/api/v1/folder"
+	folderQuotaScanPath   = "/api/v1/folder_quota_scan"
+	userDefaultsPath      = "/api/v1/user_defaults"
+	staleFilesReportPath  = "/api/v1/report/stale_files"
+	duplicatesScanPath    = "/api/v1/duplicates_scan"
+	activityReportPath    = "/api/v1/report/activity"
+	inactiveUsersPath     = "/api/v1/report/inactive_users"
+	transferReceiptsPath  = "/api/v1/transfer_receipts"
+	hooksTestPath         = "/api/v1/hooks/test"
+	loginSimulationPath   = "/api/v1/login_simulation"
+	uiPreferencesPath     = "/api/v1/ui_preferences"
+	syncManifestPath      = "/api/v1/sync/manifest"
+	syncPlanPath          = "/api/v1/sync/plan"
+	syncFilePath          = "/api/v1/sync/file"
+	syncDirPath           = "/api/v1/sync/dir"
 	metricsPath           = "/metrics"
+	healthzPath           = "/healthz"
 	pprofBasePath         = "/debug"
 	webBasePath           = "/web"
 	webUsersPath          = "/web/users"
 	webUserPath           = "/web/user"
 	webConnectionsPath    = "/web/connections"
+	webIPListPath         = "/web/iplist"
+	webIPListEntryPath    = "/web/iplistentry"
+	webIPListUnblockPath  = "/web/iplist/unblock"
+	webPlansPath          = "/web/plans"
+	webPlanPath           = "/web/plan"
+	webFoldersPath        = "/web/folders"
+	webFolderPath         = "/web/folder"
+	webFolderDeletePath   = "/web/folder/delete"
+	webJobsPath           = "/web/jobs"
 	webStaticFilesPath    = "/static"
-	maxRestoreSize        = 10485760 // 10 MB
-	maxRequestSize        = 1048576  // 1MB
+	// YAML backups are fully loaded in memory to be parsed
+	maxYAMLRestoreSize = 10485760 // 10 MB
+	// response header for the non fatal issues, it is added once for each issue
+	warningHeader = "X-SFTPGo-Warning"
+	// response headers for the paginated lists
+	totalCountHeader = "X-SFTPGo-Total-Count"
+	nextCursorHeader = "X-SFTPGo-Next-Cursor"
+	prevCursorHeader = "X-SFTPGo-Prev-Cursor"
 )
 
 var (
@@ -46,6 +99,8 @@ var (
 	backupsPath  string
 	httpAuth     httpAuthProvider
 	certMgr      *certManager
+	serverMutex  sync.Mutex
+	httpServer   *http.Server
 )
 
 // Conf httpd daemon configuration
@@ -66,12 +121,37 @@ type Conf struct {
 	// htpasswd tool. The supported password formats are bcrypt ($2y$ prefix) and md5 crypt ($apr1$ prefix).
 	// If empty HTTP authentication is disabled
 	AuthUserFile string `json:"auth_user_file" mapstructure:"auth_user_file"`
+	// Usernames, defined inside the auth user file, with the built-in read only auditor profile.
+	// The auditors can view users, connections, events and reports but they cannot modify anything
+	AuditorUsers []string `json:"auditor_users" mapstructure:"auditor_users"`
+	// Scopes restricting the admins, defined inside the auth user file, to a subset of the users
+	AdminScopes []AdminScope `json:"admin_scopes" mapstructure:"admin_scopes"`
 	// If files containing a certificate and matching private key for the server are provided the server will expect
 	// HTTPS connections.
 	// Certificate and key files can be reloaded on demand sending a "SIGHUP" signal on Unix based systems and a
 	// "paramchange" request to the running service on Windows.
 	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
 	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+	// The port used for serving the gRPC admin API. 0 disable the gRPC server. Default: 0.
+	// The gRPC server uses the same authentication, TLS certificate and backups path as the REST API
+	GRPCBindPort int `json:"grpc_bind_port" mapstructure:"grpc_bind_port"`
+	// The address to listen on for the gRPC admin API. A blank value means listen on all available
+	// network interfaces. Default: "127.0.0.1"
+	GRPCBindAddress string `json:"grpc_bind_address" mapstructure:"grpc_bind_address"`
+	// Configuration for the stale files report
+	StaleFilesReport StaleFilesReportConfig `json:"stale_files_report" mapstructure:"stale_files_report"`
+	// Configuration for the duplicate files scans
+	DuplicatesScan DuplicatesScanConfig `json:"duplicates_scan" mapstructure:"duplicates_scan"`
+	// Configuration for the sync API
+	SyncAPI SyncAPIConfig `json:"sync_api" mapstructure:"sync_api"`
+	// Additional paths to serve, for example a landing page or the branding assets
+	CustomRoutes []CustomRoute `json:"custom_routes" mapstructure:"custom_routes"`
+	// Configuration for the users offboarding
+	Offboarding OffboardingConfig `json:"offboarding" mapstructure:"offboarding"`
+	// Outbound webhooks for the admin events, such as the admin logins
+	AdminEvents AdminEventsConfig `json:"admin_events" mapstructure:"admin_events"`
+	// Storage for the web admin UI preferences, for example the users page filters and columns
+	UIPreferences UIPreferencesConfig `json:"ui_preferences" mapstructure:"ui_preferences"`
 }
 
 type apiResponse struct {
@@ -80,12 +160,20 @@ type apiResponse struct {
 	HTTPStatus int    `json:"status"`
 }
 
+func init() {
+	// the Prometheus metrics are exposed by the HTTP server
+	utils.AddFeature("+metrics")
+	if assets.IsEmbedded() {
+		utils.AddFeature("+webui")
+	}
+}
+
 // SetDataProvider sets the data provider to use to fetch the data about users
 func SetDataProvider(provider dataprovider.Provider) {
 	dataProvider = provider
 }
 
-// Initialize configures and starts the HTTP server
+// Initialize configures and starts the HTTP server, it returns nil after StopServer
 func (c Conf) Initialize(configDir string, profiler bool) error {
 	var err error
 	logger.Debug(logSender, "", "initializing HTTP server with config %+v", c)
@@ -101,11 +189,36 @@ func (c Conf) Initialize(configDir string, profiler bool) error {
 	if err != nil {
 		return err
 	}
+	auditorUsers = c.AuditorUsers
+	adminScopes, err = validateAdminScopes(c.AdminScopes)
+	if err != nil {
+		return err
+	}
+	customRoutes, err := validateCustomRoutes(c.CustomRoutes, configDir)
+	if err != nil {
+		return err
+	}
+	if err = c.Offboarding.ArchiveBucket.validate(configDir); err != nil {
+		return err
+	}
+	if err = c.AdminEvents.validate(); err != nil {
+		return err
+	}
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	if assets.IsEmbedded() {
+		logger.Debug(logSender, "", "using the embedded web assets, the files inside %#v and %#v take precedence",
+			templatesPath, staticFilesPath)
+	}
 	loadTemplates(templatesPath)
-	initializeRouter(staticFilesPath, profiler)
-	httpServer := &http.Server{
+	startStaleFilesReportScheduler(c.StaleFilesReport, configDir)
+	duplicatesScanConf = c.DuplicatesScan
+	syncAPIConf = c.SyncAPI
+	offboardingConf = c.Offboarding
+	startAdminEventsNotifier(c.AdminEvents)
+	initializeUIPreferences(c.UIPreferences, configDir)
+	initializeRouter(staticFilesPath, customRoutes, profiler)
+	server := &http.Server{
 		Addr:           fmt.Sprintf("%s:%d", c.BindAddress, c.BindPort),
 		Handler:        router,
 		ReadTimeout:    60 * time.Second,
@@ -113,18 +226,59 @@ func (c Conf) Initialize(configDir string, profiler bool) error {
 		IdleTimeout:    120 * time.Second,
 		MaxHeaderBytes: 1 << 16, // 64KB
 	}
-	if len(certificateFile) > 0 && len(certificateKeyFile) > 0 {
+	useTLS := len(certificateFile) > 0 && len(certificateKeyFile) > 0
+	if useTLS {
 		certMgr, err = newCertManager(certificateFile, certificateKeyFile)
 		if err != nil {
 			return err
 		}
+	}
+	if c.GRPCBindPort > 0 {
+		var grpcTLSConfig *tls.Config
+		if useTLS {
+			grpcTLSConfig = &tls.Config{
+				GetCertificate: certMgr.GetCertificateFunc(),
+			}
+		}
+		err = startGRPCServer(fmt.Sprintf("%s:%d", c.GRPCBindAddress, c.GRPCBindPort), grpcTLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if useTLS {
 		config := &tls.Config{
 			GetCertificate: certMgr.GetCertificateFunc(),
 		}
-		httpServer.TLSConfig = config
-		return httpServer.ListenAndServeTLS("", "")
+		server.TLSConfig = config
+	}
+	serverMutex.Lock()
+	httpServer = server
+	serverMutex.Unlock()
+	if useTLS {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		logger.Info(logSender, "", "HTTP server stopped")
+		return nil
+	}
+	return err
+}
+
+// StopServer stops the HTTP server and the gRPC server, if started.
+// The active requests are interrupted and the Initialize method returns
+func StopServer() error {
+	stopGRPCServer()
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if httpServer == nil {
+		return nil
 	}
-	return httpServer.ListenAndServe()
+	err := httpServer.Close()
+	httpServer = nil
+	return err
 }
 
 // ReloadTLSCertificate reloads the TLS certificate and key from the configured paths
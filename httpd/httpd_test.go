@@ -2,9 +2,17 @@ package httpd_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,8 +22,11 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -24,13 +35,17 @@ import (
 	"github.com/go-chi/render"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/drakkan/sftpgo/config"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/httpd"
+	"github.com/drakkan/sftpgo/httpd/adminpb"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
@@ -42,18 +57,44 @@ const (
 	testPubKey            = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQC03jj0D+djk7pxIf/0OhrxrchJTRZklofJ1NoIu4752Sq02mdXmarMVsqJ1cAjV5LBVy3D1F5U6XW4rppkXeVtd04Pxb09ehtH0pRRPaoHHlALiJt8CoMpbKYMA8b3KXPPriGxgGomvtU2T2RMURSwOZbMtpsugfjYSWenyYX+VORYhylWnSXL961LTyC21ehd6d6QnW9G7E5hYMITMY9TuQZz3bROYzXiTsgN0+g6Hn7exFQp50p45StUMfV/SftCMdCxlxuyGny2CrN/vfjO7xxOo2uv7q1qm10Q46KPWJQv+pgZ/OfL+EDjy07n5QVSKHlbx+2nT4Q0EgOSQaCTYwn3YjtABfIxWwgAFdyj6YlPulCL22qU4MYhDcA6PSBwDdf8hvxBfvsiHdM+JcSHvv8/VeJhk6CmnZxGY0fxBupov27z3yEO8nAg8k+6PaUiW1MSUfuGMF/ktB8LOstXsEPXSszuyXiOv4DaryOXUiSn7bmRqKcEFlJusO6aZP0= nicola@p1"
 	logSender             = "APITesting"
 	userPath              = "/api/v1/user"
+	userBulkPath          = "/api/v1/user_bulk"
 	activeConnectionsPath = "/api/v1/connection"
 	quotaScanPath         = "/api/v1/quota_scan"
+	folderQuotaScanPath   = "/api/v1/folder_quota_scan"
+	duplicatesScanPath    = "/api/v1/duplicates_scan"
+	hooksTestPath         = "/api/v1/hooks/test"
+	loginSimulationPath   = "/api/v1/login_simulation"
+	uiPreferencesPath     = "/api/v1/ui_preferences"
 	versionPath           = "/api/v1/version"
+	openAPIPath           = "/api/v1/openapi.yaml"
+	inactiveUsersPath     = "/api/v1/report/inactive_users"
 	providerStatusPath    = "/api/v1/providerstatus"
 	dumpDataPath          = "/api/v1/dumpdata"
 	loadDataPath          = "/api/v1/loaddata"
+	ipListPath            = "/api/v1/iplist"
+	planPath              = "/api/v1/plan"
+	ipListImportPath      = "/api/v1/iplist/import"
+	ipListExportPath      = "/api/v1/iplist/export"
+	ipListCheckPath       = "/api/v1/iplist/check"
+	defenderHostsPath     = "/api/v1/defender/hosts"
+	defenderBanPath       = "/api/v1/defender/ban"
 	metricsPath           = "/metrics"
+	healthzPath           = "/healthz"
 	pprofPath             = "/debug/pprof/"
 	webBasePath           = "/web"
 	webUsersPath          = "/web/users"
 	webUserPath           = "/web/user"
 	webConnectionsPath    = "/web/connections"
+	webIPListPath         = "/web/iplist"
+	webIPListEntryPath    = "/web/iplistentry"
+	webIPListUnblockPath  = "/web/iplist/unblock"
+	webPlansPath          = "/web/plans"
+	webPlanPath           = "/web/plan"
+	webFoldersPath        = "/web/folders"
+	webFolderPath         = "/web/folder"
+	webFolderDeletePath   = "/web/folder/delete"
+	webJobsPath           = "/web/jobs"
+	grpcAddress           = "127.0.0.1:8082"
 	configDir             = ".."
 	httpsCert             = `-----BEGIN CERTIFICATE-----
 MIICHTCCAaKgAwIBAgIUHnqw7QnB1Bj9oUsNpdb+ZkFPOxMwCgYIKoZIzj0EAwIw
@@ -86,7 +127,9 @@ var (
 	backupsPath        string
 	credentialsPath    string
 	testServer         *httptest.Server
+	ipListFeedServer   *httptest.Server
 	providerDriverName string
+	csrfTokenRegex     = regexp.MustCompile(`name="_form_token" value="([^"]+)"`)
 )
 
 func TestMain(m *testing.M) {
@@ -99,6 +142,21 @@ func TestMain(m *testing.M) {
 	providerConf.CredentialsPath = credentialsPath
 	providerDriverName = providerConf.Driver
 	os.RemoveAll(credentialsPath)
+	ipListFeedServer = startTestIPListFeedServer()
+	providerConf.IPListFeeds = []dataprovider.IPListFeed{
+		{
+			URL:      ipListFeedServer.URL + "/feed",
+			Interval: 60,
+			TTL:      120,
+		},
+		{
+			URL:      ipListFeedServer.URL + "/missing",
+			Interval: 60,
+		},
+	}
+
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(configDir)
 
 	err := dataprovider.Initialize(providerConf, configDir)
 	if err != nil {
@@ -106,13 +164,13 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
-	httpConfig := config.GetHTTPConfig()
-	httpConfig.Initialize(configDir)
-
 	dataProvider := dataprovider.GetProvider()
 	httpdConf := config.GetHTTPDConfig()
 
 	httpdConf.BindPort = 8081
+	httpdConf.GRPCBindPort = 8082
+	httpdConf.SyncAPI.Enabled = true
+	httpdConf.UIPreferences.StoragePath = ""
 	httpd.SetBaseURLAndCredentials("http://127.0.0.1:8081", "", "")
 	backupsPath = filepath.Join(os.TempDir(), "test_backups")
 	httpdConf.BackupsPath = backupsPath
@@ -128,12 +186,14 @@ func TestMain(m *testing.M) {
 	}()
 
 	waitTCPListening(fmt.Sprintf("%s:%d", httpdConf.BindAddress, httpdConf.BindPort))
+	waitTCPListening(fmt.Sprintf("%s:%d", httpdConf.GRPCBindAddress, httpdConf.GRPCBindPort))
 	// now start an https server
 	certPath := filepath.Join(os.TempDir(), "test.crt")
 	keyPath := filepath.Join(os.TempDir(), "test.key")
 	ioutil.WriteFile(certPath, []byte(httpsCert), 0666)
 	ioutil.WriteFile(keyPath, []byte(httpsKey), 0666)
 	httpdConf.BindPort = 8443
+	httpdConf.GRPCBindPort = 0
 	httpdConf.CertificateFile = certPath
 	httpdConf.CertificateKeyFile = keyPath
 
@@ -149,6 +209,7 @@ func TestMain(m *testing.M) {
 	defer testServer.Close()
 
 	exitCode := m.Run()
+	ipListFeedServer.Close()
 	os.Remove(logfilePath)
 	os.RemoveAll(backupsPath)
 	os.RemoveAll(credentialsPath)
@@ -239,6 +300,36 @@ func TestUserStatus(t *testing.T) {
 	}
 }
 
+func TestUserAdditionalInfo(t *testing.T) {
+	u := getTestUser()
+	u.AdditionalInfo = "billing ID: 1234"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with additional info: %v", err)
+	}
+	user.AdditionalInfo = "billing ID: 1234\ncontract: ACME-2020-01"
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user additional info: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.AdditionalInfo != "billing ID: 1234\ncontract: ACME-2020-01" {
+		t.Errorf("additional info does not match: %#v", user.AdditionalInfo)
+	}
+	user.AdditionalInfo = ""
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user additional info: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
 func TestAddUserNoCredentials(t *testing.T) {
 	u := getTestUser()
 	u.Password = ""
@@ -337,6 +428,47 @@ func TestAddUserInvalidFilters(t *testing.T) {
 		t.Errorf("unexpected error adding user with invalid filters: %v", err)
 	}
 	u.Filters.DeniedLoginMethods = []string{}
+	u.Filters.RevokedKeyFingerprints = []string{"SHA256:invalid"}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.RevokedKeyFingerprints = []string{"MD5:e7:6e:7a:6c:a8:3e:d4:bb:0c:6f:2c:0a:9e:a9:14:57"}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.RevokedKeyFingerprints = []string{}
+	u.Filters.AllowedKeyAlgorithms = []string{"ssh-invalid"}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.AllowedKeyAlgorithms = []string{}
+	u.Filters.AllowedSSHCommands = []string{"/usr/bin/scp"}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.AllowedSSHCommands = []string{}
+	u.Filters.MinRSAKeySize = -1
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.MinRSAKeySize = 0
+	u.Filters.Tenant = strings.Repeat("t", 256)
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.Tenant = ""
+	u.Filters.FirstLogin.TermsAcceptedAt = -1
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filters: %v", err)
+	}
+	u.Filters.FirstLogin.TermsAcceptedAt = 0
 	u.Filters.FileExtensions = []dataprovider.ExtensionsFilter{
 		{
 			Path:              "relative",
@@ -375,6 +507,29 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	if err != nil {
 		t.Errorf("unexpected error adding user with invalid extensions filters: %v", err)
 	}
+	u.Filters.FileExtensions = nil
+	u.Filters.IngestionFolders = []dataprovider.IngestionFolder{
+		{
+			Path: "relative",
+		},
+	}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid ingestion folders: %v", err)
+	}
+	u.Filters.IngestionFolders = []dataprovider.IngestionFolder{
+		{
+			Path: "/logs",
+		},
+		{
+			Path:         "/logs/",
+			HourlyRollup: true,
+		},
+	}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid ingestion folders: %v", err)
+	}
 }
 
 func TestAddUserInvalidFsConfig(t *testing.T) {
@@ -410,6 +565,42 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	if err != nil {
 		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
 	}
+	u.FsConfig.S3Config.UploadConcurrency = 0
+	u.FsConfig.S3Config.RoleARN = "invalid role"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
+	}
+	u.FsConfig.S3Config.RoleARN = ""
+	u.FsConfig.S3Config.ExternalID = "external-id"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
+	}
+	u.FsConfig.S3Config.ExternalID = ""
+	u.FsConfig.S3Config.AccessKey = ""
+	u.FsConfig.S3Config.AccessSecret = ""
+	u.FsConfig.S3Config.SessionToken = "session-token"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
+	}
+	u.FsConfig.S3Config.AccessKey = "access-key"
+	u.FsConfig.S3Config.AccessSecret = "access-secret"
+	u.FsConfig.S3Config.SessionToken = ""
+	invalidStorageClassRules := [][]vfs.StorageClassRule{
+		{{Path: "/", MinSize: 1024}},
+		{{StorageClass: "STANDARD_IA", MinSize: -1}},
+		{{StorageClass: "STANDARD_IA", Path: "relative/path"}},
+		{{StorageClass: "STANDARD_IA", Extensions: []string{".zip", " "}}},
+	}
+	for _, rules := range invalidStorageClassRules {
+		u.FsConfig.S3Config.StorageClassRules = rules
+		_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected error adding user with invalid storage class rules %+v: %v", rules, err)
+		}
+	}
 	u = getTestUser()
 	u.FsConfig.Provider = 2
 	u.FsConfig.GCSConfig.Bucket = ""
@@ -437,6 +628,96 @@ func TestAddUserInvalidFsConfig(t *testing.T) {
 	if err != nil {
 		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
 	}
+	u.FsConfig.GCSConfig.Credentials = ""
+	u.FsConfig.GCSConfig.AutomaticCredentials = 1
+	u.FsConfig.GCSConfig.StorageClassRules = []vfs.StorageClassRule{{StorageClass: "COLDLINE", MinSize: -10}}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
+	}
+	u = getTestUser()
+	u.FsConfig.Provider = 3
+	u.FsConfig.CryptConfig.Passphrase = ""
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid fs config: %v", err)
+	}
+	invalidWebDAVConfigs := []vfs.WebDAVFsConfig{
+		{},
+		{Endpoint: "ftp://127.0.0.1/dav"},
+		{Endpoint: "http:///dav"},
+		{Endpoint: "http://127.0.0.1/dav", Password: "password"},
+		{Endpoint: "http://127.0.0.1/dav", Username: "user", BearerToken: "token"},
+		{Endpoint: "http://127.0.0.1/dav", RootPath: "/folder"},
+	}
+	for _, config := range invalidWebDAVConfigs {
+		u = getTestUser()
+		u.FsConfig.Provider = 4
+		u.FsConfig.WebDAVConfig = config
+		_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected error adding user with invalid WebDAV config %+v: %v", config, err)
+		}
+	}
+	invalidHDFSConfigs := []vfs.HDFSFsConfig{
+		{},
+		{Endpoint: "hdfs://namenode:8020"},
+		{Endpoint: "http:///webhdfs/v1"},
+		{Endpoint: "http://namenode:9870/webhdfs/v1", Username: "hdfs", DelegationToken: "token"},
+		{Endpoint: "http://namenode:9870/webhdfs/v1", RootPath: "/data"},
+	}
+	for _, config := range invalidHDFSConfigs {
+		u = getTestUser()
+		u.FsConfig.Provider = 5
+		u.FsConfig.HDFSConfig = config
+		_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected error adding user with invalid HDFS config %+v: %v", config, err)
+		}
+	}
+	serviceAccount := getTestGoogleServiceAccount(t, "")
+	invalidGoogleDriveConfigs := []vfs.GoogleDriveFsConfig{
+		{},
+		{Credentials: "{}"},
+		{Credentials: `{"type":"authorized_user"}`},
+		{Credentials: serviceAccount, RefreshToken: "token"},
+		{Credentials: serviceAccount, ClientID: "id"},
+		{Credentials: serviceAccount, FolderID: "folder/id"},
+		{Credentials: serviceAccount, Endpoint: "ftp://127.0.0.1"},
+		{RefreshToken: "token"},
+		{RefreshToken: "token", ClientID: "id"},
+		{RefreshToken: "token", ClientID: "id", ClientSecret: "secret", Subject: "user@example.com"},
+	}
+	for _, config := range invalidGoogleDriveConfigs {
+		u = getTestUser()
+		u.FsConfig.Provider = 6
+		u.FsConfig.GoogleDriveConfig = config
+		_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected error adding user with invalid Google Drive config %+v: %v", config, err)
+		}
+	}
+	invalidDropboxConfigs := []vfs.DropboxFsConfig{
+		{},
+		{AccessToken: "token", RefreshToken: "token", AppKey: "key"},
+		{AccessToken: "token", AppKey: "key"},
+		{AccessToken: "token", AppSecret: "secret"},
+		{AccessToken: "token", RootPath: "/root"},
+		{AccessToken: "token", UploadChunkSize: -1},
+		{AccessToken: "token", UploadChunkSize: 151},
+		{AccessToken: "token", Endpoint: "ftp://127.0.0.1"},
+		{RefreshToken: "token"},
+		{RefreshToken: "token", AppSecret: "secret"},
+	}
+	for _, config := range invalidDropboxConfigs {
+		u = getTestUser()
+		u.FsConfig.Provider = 7
+		u.FsConfig.DropboxConfig = config
+		_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected error adding user with invalid Dropbox config %+v: %v", config, err)
+		}
+	}
 }
 
 func TestAddUserInvalidVirtualFolders(t *testing.T) {
@@ -565,6 +846,107 @@ func TestAddUserInvalidVirtualFolders(t *testing.T) {
 	}
 }
 
+func TestVirtualFolderFilesystem(t *testing.T) {
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+		MappedPath:  filepath.Join(os.TempDir(), "mapped_dir"),
+	})
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/archive",
+		Filesystem: &vfs.VirtualFolderFilesystem{
+			Provider: 1,
+			S3Config: vfs.S3FsConfig{
+				Bucket:       "archive",
+				Region:       "us-east-1",
+				AccessKey:    "Folder-Access-Key",
+				AccessSecret: "Folder-Access-Secret",
+				Endpoint:     "http://127.0.0.1:9000",
+				KeyPrefix:    "somedir/",
+			},
+		},
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with a virtual folder filesystem: %v", err)
+	}
+	if len(user.VirtualFolders) != 2 {
+		t.Fatalf("unexpected virtual folders: %+v", user.VirtualFolders)
+	}
+	folder := user.VirtualFolders[1]
+	if !folder.HasFilesystem() || len(folder.MappedPath) > 0 {
+		t.Errorf("unexpected virtual folder: %+v", folder)
+	} else {
+		secret := folder.Filesystem.S3Config.AccessSecret
+		if !strings.HasPrefix(secret, "$aes$") || secret != utils.RemoveDecryptionKey(secret) {
+			t.Errorf("the returned access secret must be encrypted without the decryption key: %#v", secret)
+		}
+	}
+	// the returned secret must be preserved on update
+	user.VirtualFolders[1].Filesystem.S3Config.Bucket = "archive1"
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	userFromProvider, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the provider: %v", err)
+	} else {
+		secret := userFromProvider.VirtualFolders[1].Filesystem.S3Config.AccessSecret
+		plain, err := utils.DecryptData(secret)
+		if err != nil || plain != "Folder-Access-Secret" {
+			t.Errorf("unexpected access secret %#v: %v", plain, err)
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	u.VirtualFolders[1].Filesystem.Provider = 3
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with an unsupported virtual folder filesystem: %v", err)
+	}
+	u.VirtualFolders[1].Filesystem.Provider = 1
+	u.VirtualFolders[1].Filesystem.S3Config.Bucket = ""
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with an invalid virtual folder filesystem: %v", err)
+	}
+	u.VirtualFolders[1].Filesystem = &vfs.VirtualFolderFilesystem{
+		Provider: 2,
+		GCSConfig: vfs.GCSFsConfig{
+			Bucket:      "archive",
+			Credentials: base64.StdEncoding.EncodeToString([]byte("{}")),
+		},
+	}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with a GCS virtual folder without automatic credentials: %v", err)
+	}
+	u.VirtualFolders[1].Filesystem.GCSConfig.AutomaticCredentials = 1
+	u.VirtualFolders[1].VirtualPath = "/vdir/archive"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with overlapped virtual folders: %v", err)
+	}
+	u.VirtualFolders[1].VirtualPath = "/archive"
+	u.VirtualFolders[0].VirtualPath = "/archive/vdir"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with overlapped virtual folders: %v", err)
+	}
+	u.VirtualFolders[0].VirtualPath = "/vdir"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with a GCS virtual folder: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
 func TestUserPublicKey(t *testing.T) {
 	u := getTestUser()
 	invalidPubKey := "invalid"
@@ -611,11 +993,23 @@ func TestUpdateUser(t *testing.T) {
 	user.Filters.AllowedIP = []string{"192.168.1.0/24", "192.168.2.0/24"}
 	user.Filters.DeniedIP = []string{"192.168.3.0/24", "192.168.4.0/24"}
 	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPassword}
+	user.Filters.AllowedKeyAlgorithms = []string{"ssh-ed25519", "ssh-rsa"}
+	user.Filters.MinRSAKeySize = 3072
+	user.Filters.AllowedSSHCommands = []string{"scp", "md5sum"}
 	user.Filters.FileExtensions = append(user.Filters.FileExtensions, dataprovider.ExtensionsFilter{
 		Path:              "/subdir",
 		AllowedExtensions: []string{".zip", ".rar"},
 		DeniedExtensions:  []string{".jpg", ".png"},
 	})
+	user.Filters.IngestionFolders = []dataprovider.IngestionFolder{
+		{
+			Path:         "/logs/",
+			HourlyRollup: true,
+		},
+		{
+			Path: "/events",
+		},
+	}
 	user.UploadBandwidth = 1024
 	user.DownloadBandwidth = 512
 	user.VirtualFolders = nil
@@ -645,6 +1039,89 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
+func TestPatchUser(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 3
+	u.FsConfig.CryptConfig.Passphrase = "crypt passphrase"
+	u.Filters.DeniedIP = []string{"192.168.3.0/24"}
+	u.ExpirationDate = utils.GetTimeAsMsSinceEpoch(time.Now().Add(24 * time.Hour))
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	storedUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	patchedUser, _, err := httpd.PatchUser(user.ID, map[string]interface{}{
+		"status":          0,
+		"quota_size":      4096,
+		"expiration_date": nil,
+		"filters": map[string]interface{}{
+			"allowed_ip": []string{"192.168.1.0/24"},
+		},
+	}, http.StatusOK)
+	if err != nil {
+		t.Fatalf("unable to patch user: %v", err)
+	}
+	if patchedUser.Status != 0 || patchedUser.QuotaSize != 4096 || patchedUser.ExpirationDate != 0 {
+		t.Errorf("patch not applied: %+v", patchedUser)
+	}
+	if patchedUser.HomeDir != user.HomeDir || patchedUser.MaxSessions != user.MaxSessions ||
+		len(patchedUser.Permissions["/"]) != len(user.Permissions["/"]) || patchedUser.Version != user.Version+1 {
+		t.Errorf("the fields not included in the patch must be unchanged: %+v", patchedUser)
+	}
+	if len(patchedUser.Filters.AllowedIP) != 1 || len(patchedUser.Filters.DeniedIP) != 1 {
+		t.Errorf("the filters must be merged: %+v", patchedUser.Filters)
+	}
+	patchedStoredUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if patchedStoredUser.Password != storedUser.Password ||
+		patchedStoredUser.FsConfig.CryptConfig.Passphrase != storedUser.FsConfig.CryptConfig.Passphrase {
+		t.Error("the password and the passphrase must be preserved")
+	}
+	// the passphrase returned to the clients is restored too
+	_, _, err = httpd.PatchUser(user.ID, map[string]interface{}{
+		"filesystem": map[string]interface{}{
+			"cryptconfig": map[string]interface{}{
+				"passphrase": utils.RemoveDecryptionKey(storedUser.FsConfig.CryptConfig.Passphrase),
+			},
+		},
+	}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to patch user: %v", err)
+	}
+	patchedStoredUser, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	if patchedStoredUser.FsConfig.CryptConfig.Passphrase != storedUser.FsConfig.CryptConfig.Passphrase {
+		t.Error("the passphrase must be preserved")
+	}
+	_, _, err = httpd.PatchUser(user.ID, map[string]interface{}{"home_dir": "relative_path"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error patching user with an invalid home dir: %v", err)
+	}
+	_, _, err = httpd.PatchUser(user.ID, map[string]interface{}{"permissions": nil}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error patching user without permissions: %v", err)
+	}
+	_, _, err = httpd.PatchUser(user.ID, map[string]interface{}{"id": user.ID + 1}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error patching user with a different id: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+	_, _, err = httpd.PatchUser(user.ID, map[string]interface{}{"status": 1}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error patching a missing user: %v", err)
+	}
+}
+
 func TestUserS3Config(t *testing.T) {
 	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
 	if err != nil {
@@ -661,6 +1138,64 @@ func TestUserS3Config(t *testing.T) {
 	if err != nil {
 		t.Errorf("unable to update user: %v", err)
 	}
+	user.FsConfig.S3Config.SessionToken = "Server-Session-Token"
+	user.FsConfig.S3Config.RoleARN = "arn:aws:iam::123456789012:role/sftpgo"
+	user.FsConfig.S3Config.ExternalID = "external-id"
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	if !strings.HasPrefix(user.FsConfig.S3Config.SessionToken, "$aes$") {
+		t.Errorf("the session token must be encrypted: %#v", user.FsConfig.S3Config.SessionToken)
+	}
+	// the returned session token is preserved on update
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	user.FsConfig.S3Config.DownloadPartSize = 3
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error updating user with an invalid download part size: %v", err)
+	}
+	user.FsConfig.S3Config.DownloadPartSize = 10
+	user.FsConfig.S3Config.DownloadConcurrency = -2
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error updating user with an invalid download concurrency: %v", err)
+	}
+	user.FsConfig.S3Config.DownloadConcurrency = 8
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	user.FsConfig.S3Config.ForcePathStyle = true
+	user.FsConfig.S3Config.SkipTLSVerify = true
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	user.FsConfig.S3Config.CABundle = httpsCert
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error updating user with CA bundle and skip TLS verify: %v", err)
+	}
+	user.FsConfig.S3Config.SkipTLSVerify = false
+	user.FsConfig.S3Config.CABundle = "invalid"
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error updating user with an invalid CA bundle: %v", err)
+	}
+	user.FsConfig.S3Config.CABundle = httpsCert
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	user.FsConfig.S3Config.ForcePathStyle = false
+	user.FsConfig.S3Config.CABundle = ""
+	user.FsConfig.S3Config.SessionToken = ""
+	user.FsConfig.S3Config.RoleARN = ""
+	user.FsConfig.S3Config.ExternalID = ""
 	_, err = httpd.RemoveUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to remove: %v", err)
@@ -693,6 +1228,8 @@ func TestUserS3Config(t *testing.T) {
 	user.FsConfig.S3Config.KeyPrefix = ""
 	user.FsConfig.S3Config.UploadPartSize = 0
 	user.FsConfig.S3Config.UploadConcurrency = 0
+	user.FsConfig.S3Config.DownloadPartSize = 0
+	user.FsConfig.S3Config.DownloadConcurrency = 0
 	user, _, err = httpd.UpdateUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to update user: %v", err)
@@ -750,6 +1287,22 @@ func TestUserGCSConfig(t *testing.T) {
 	if err != nil {
 		t.Errorf("unable to update user: %v", err)
 	}
+	user.FsConfig.GCSConfig.DownloadPartSize = 4
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error updating user with an invalid download part size: %v", err)
+	}
+	user.FsConfig.GCSConfig.DownloadPartSize = 8
+	user.FsConfig.GCSConfig.DownloadConcurrency = -1
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error updating user with an invalid download concurrency: %v", err)
+	}
+	user.FsConfig.GCSConfig.DownloadConcurrency = 4
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
 	user.FsConfig.Provider = 1
 	user.FsConfig.S3Config.Bucket = "test1"
 	user.FsConfig.S3Config.Region = "us-east-1"
@@ -775,15 +1328,396 @@ func TestUserGCSConfig(t *testing.T) {
 	}
 }
 
-func TestUpdateUserNoCredentials(t *testing.T) {
-	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
-	if err != nil {
-		t.Errorf("unable to add user: %v", err)
-	}
-	user.Password = ""
-	user.PublicKeys = []string{}
-	// password and public key will be omitted from json serialization if empty and so they will remain unchanged
-	// and no validation error will be raised
+func TestUserStorageClassRules(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 1
+	u.FsConfig.S3Config.Bucket = "test"
+	u.FsConfig.S3Config.Region = "us-east-1"
+	u.FsConfig.S3Config.StorageClass = "STANDARD"
+	u.FsConfig.S3Config.StorageClassRules = []vfs.StorageClassRule{
+		{
+			Path:         "/backups/../archive/",
+			Extensions:   []string{".ZIP", " .tar.gz"},
+			StorageClass: "GLACIER",
+		},
+		{
+			MinSize:      1073741824,
+			StorageClass: "STANDARD_IA",
+		},
+	}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	rules := user.FsConfig.S3Config.StorageClassRules
+	if len(rules) != 2 {
+		t.Errorf("unexpected storage class rules: %+v", rules)
+	} else {
+		if rules[0].Path != "/archive" {
+			t.Errorf("unexpected rule path: %#v", rules[0].Path)
+		}
+		if !utils.IsStringInSlice(".zip", rules[0].Extensions) || !utils.IsStringInSlice(".tar.gz", rules[0].Extensions) {
+			t.Errorf("unexpected rule extensions: %+v", rules[0].Extensions)
+		}
+	}
+	user.FsConfig.Provider = 2
+	user.FsConfig.GCSConfig.Bucket = "test"
+	user.FsConfig.GCSConfig.AutomaticCredentials = 1
+	user.FsConfig.GCSConfig.StorageClassRules = []vfs.StorageClassRule{
+		{
+			Path:         "/videos",
+			MinSize:      104857600,
+			StorageClass: "NEARLINE",
+		},
+	}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	if len(user.FsConfig.GCSConfig.StorageClassRules) != 1 {
+		t.Errorf("unexpected GCS storage class rules: %+v", user.FsConfig.GCSConfig.StorageClassRules)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserCryptConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 3
+	u.FsConfig.CryptConfig.Passphrase = "crypt passphrase"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	// the returned passphrase is encrypted and redacted, sending it back must preserve the stored one
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	passphrase, err := utils.DecryptData(dataProviderUser.FsConfig.CryptConfig.Passphrase)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored passphrase: %v", err)
+	}
+	if passphrase != "crypt passphrase" {
+		t.Errorf("unexpected passphrase: %#v", passphrase)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+	user.Password = defaultPassword
+	user.ID = 0
+	encryptedPassphrase, _ := utils.EncryptData("crypt passphrase")
+	user.FsConfig.CryptConfig.Passphrase = encryptedPassphrase
+	user, _, err = httpd.AddUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user.FsConfig.Provider = 0
+	user.FsConfig.CryptConfig.Passphrase = ""
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserWebDAVConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 4
+	u.FsConfig.WebDAVConfig.Endpoint = "https://127.0.0.1:8443/remote.php/dav/files/user/"
+	u.FsConfig.WebDAVConfig.Username = "webdav_user"
+	u.FsConfig.WebDAVConfig.Password = "webdav password"
+	u.FsConfig.WebDAVConfig.RootPath = "folder/subfolder"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	if user.FsConfig.WebDAVConfig.RootPath != "folder/subfolder/" {
+		t.Errorf("unexpected root path: %#v", user.FsConfig.WebDAVConfig.RootPath)
+	}
+	// the returned password is encrypted and redacted, sending it back must preserve the stored one
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	password, err := utils.DecryptData(dataProviderUser.FsConfig.WebDAVConfig.Password)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored password: %v", err)
+	}
+	if password != "webdav password" {
+		t.Errorf("unexpected password: %#v", password)
+	}
+	// switch to a bearer token, the password must be removed
+	user.FsConfig.WebDAVConfig.Username = ""
+	user.FsConfig.WebDAVConfig.Password = ""
+	user.FsConfig.WebDAVConfig.BearerToken = "webdav token"
+	user.FsConfig.WebDAVConfig.RootPath = ""
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if len(dataProviderUser.FsConfig.WebDAVConfig.Password) > 0 {
+		t.Errorf("the WebDAV password must be removed")
+	}
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.WebDAVConfig.BearerToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored bearer token: %v", err)
+	}
+	if token != "webdav token" {
+		t.Errorf("unexpected bearer token: %#v", token)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+	user.Password = defaultPassword
+	user.ID = 0
+	encryptedToken, _ := utils.EncryptData("webdav token")
+	user.FsConfig.WebDAVConfig.BearerToken = encryptedToken
+	user, _, err = httpd.AddUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user.FsConfig.Provider = 0
+	user.FsConfig.WebDAVConfig = vfs.WebDAVFsConfig{}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserHDFSConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 5
+	u.FsConfig.HDFSConfig.Endpoint = "http://namenode:9870/webhdfs/v1"
+	u.FsConfig.HDFSConfig.DelegationToken = "hdfs token"
+	u.FsConfig.HDFSConfig.RootPath = "data/partners"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	if user.FsConfig.HDFSConfig.RootPath != "data/partners/" {
+		t.Errorf("unexpected root path: %#v", user.FsConfig.HDFSConfig.RootPath)
+	}
+	// the returned token is encrypted and redacted, sending it back must preserve the stored one
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.HDFSConfig.DelegationToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored delegation token: %v", err)
+	}
+	if token != "hdfs token" {
+		t.Errorf("unexpected delegation token: %#v", token)
+	}
+	// switch to simple authentication, the token must be removed
+	user.FsConfig.HDFSConfig.Username = "hdfs"
+	user.FsConfig.HDFSConfig.DelegationToken = ""
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if len(dataProviderUser.FsConfig.HDFSConfig.DelegationToken) > 0 {
+		t.Errorf("the HDFS delegation token must be removed")
+	}
+	if dataProviderUser.FsConfig.HDFSConfig.Username != "hdfs" {
+		t.Errorf("unexpected username: %#v", dataProviderUser.FsConfig.HDFSConfig.Username)
+	}
+	user.FsConfig.Provider = 0
+	user.FsConfig.HDFSConfig = vfs.HDFSFsConfig{}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserGoogleDriveConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 6
+	u.FsConfig.GoogleDriveConfig.FolderID = "folder_id"
+	u.FsConfig.GoogleDriveConfig.ClientID = "client_id"
+	u.FsConfig.GoogleDriveConfig.ClientSecret = "client secret"
+	u.FsConfig.GoogleDriveConfig.RefreshToken = "refresh token"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	// the returned secrets are encrypted and redacted, sending them back must preserve the stored ones
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	secret, err := utils.DecryptData(dataProviderUser.FsConfig.GoogleDriveConfig.ClientSecret)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored client secret: %v", err)
+	}
+	if secret != "client secret" {
+		t.Errorf("unexpected client secret: %#v", secret)
+	}
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.GoogleDriveConfig.RefreshToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored refresh token: %v", err)
+	}
+	if token != "refresh token" {
+		t.Errorf("unexpected refresh token: %#v", token)
+	}
+	// switch to a service account, the OAuth secrets must be removed
+	serviceAccount := getTestGoogleServiceAccount(t, "")
+	user.FsConfig.GoogleDriveConfig.ClientID = ""
+	user.FsConfig.GoogleDriveConfig.ClientSecret = ""
+	user.FsConfig.GoogleDriveConfig.RefreshToken = ""
+	user.FsConfig.GoogleDriveConfig.Credentials = serviceAccount
+	user.FsConfig.GoogleDriveConfig.Subject = "user@example.com"
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if len(dataProviderUser.FsConfig.GoogleDriveConfig.ClientSecret) > 0 ||
+		len(dataProviderUser.FsConfig.GoogleDriveConfig.RefreshToken) > 0 {
+		t.Errorf("the Google Drive OAuth secrets must be removed")
+	}
+	credentials, err := utils.DecryptData(dataProviderUser.FsConfig.GoogleDriveConfig.Credentials)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored credentials: %v", err)
+	}
+	if credentials != serviceAccount {
+		t.Errorf("unexpected credentials: %#v", credentials)
+	}
+	user.FsConfig.Provider = 0
+	user.FsConfig.GoogleDriveConfig = vfs.GoogleDriveFsConfig{}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserDropboxConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = 7
+	u.FsConfig.DropboxConfig.RootPath = "sftpgo/user/"
+	u.FsConfig.DropboxConfig.RefreshToken = "refresh token"
+	u.FsConfig.DropboxConfig.AppKey = "app_key"
+	u.FsConfig.DropboxConfig.AppSecret = "app secret"
+	u.FsConfig.DropboxConfig.UploadChunkSize = 16
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	// the returned secrets are encrypted and redacted, sending them back must preserve the stored ones
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.DropboxConfig.RefreshToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored refresh token: %v", err)
+	}
+	if token != "refresh token" {
+		t.Errorf("unexpected refresh token: %#v", token)
+	}
+	secret, err := utils.DecryptData(dataProviderUser.FsConfig.DropboxConfig.AppSecret)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored app secret: %v", err)
+	}
+	if secret != "app secret" {
+		t.Errorf("unexpected app secret: %#v", secret)
+	}
+	// switch to a long-lived access token, the refresh token and the app secret must be removed
+	user.FsConfig.DropboxConfig.RefreshToken = ""
+	user.FsConfig.DropboxConfig.AppKey = ""
+	user.FsConfig.DropboxConfig.AppSecret = ""
+	user.FsConfig.DropboxConfig.AccessToken = "access token"
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	dataProviderUser, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if len(dataProviderUser.FsConfig.DropboxConfig.RefreshToken) > 0 ||
+		len(dataProviderUser.FsConfig.DropboxConfig.AppSecret) > 0 {
+		t.Errorf("the Dropbox refresh token and app secret must be removed")
+	}
+	token, err = utils.DecryptData(dataProviderUser.FsConfig.DropboxConfig.AccessToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored access token: %v", err)
+	}
+	if token != "access token" {
+		t.Errorf("unexpected access token: %#v", token)
+	}
+	user.FsConfig.Provider = 0
+	user.FsConfig.DropboxConfig = vfs.DropboxFsConfig{}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUpdateUserNoCredentials(t *testing.T) {
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user.Password = ""
+	user.PublicKeys = []string{}
+	// password and public key will be omitted from json serialization if empty and so they will remain unchanged
+	// and no validation error will be raised
 	_, _, err = httpd.UpdateUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unexpected error updating user with no credentials: %v", err)
@@ -939,10 +1873,15 @@ func TestStartQuotaScan(t *testing.T) {
 }
 
 func TestGetVersion(t *testing.T) {
-	_, _, err := httpd.GetVersion(http.StatusOK)
+	version, _, err := httpd.GetVersion(http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to get version: %v", err)
 	}
+	for _, feature := range []string{"+sqlite", "+bolt", "+s3", "+metrics"} {
+		if !utils.IsStringInSlice(feature, version.Features) {
+			t.Errorf("feature %#v not found in %+v", feature, version.Features)
+		}
+	}
 	_, _, err = httpd.GetVersion(http.StatusInternalServerError)
 	if err == nil {
 		t.Errorf("get version request must succeed, we requested to check a wrong status code")
@@ -960,516 +1899,5046 @@ func TestGetProviderStatus(t *testing.T) {
 	}
 }
 
-func TestGetConnections(t *testing.T) {
-	_, _, err := httpd.GetConnections(http.StatusOK)
+func TestHealthz(t *testing.T) {
+	// the SFTP server is not started for the httpd tests
+	response, _, err := httpd.GetHealthStatus(http.StatusServiceUnavailable)
 	if err != nil {
-		t.Errorf("unable to get sftp connections: %v", err)
+		t.Errorf("unexpected health status: %v", err)
 	}
-	_, _, err = httpd.GetConnections(http.StatusInternalServerError)
+	if response["message"] != "SFTP server unavailable" {
+		t.Errorf("unexpected health response: %+v", response)
+	}
+	_, _, err = httpd.GetHealthStatus(http.StatusOK)
 	if err == nil {
-		t.Errorf("get sftp connections request must succeed, we requested to check a wrong status code")
+		t.Errorf("get health status request must succeed, we requested to check a wrong status code")
 	}
 }
 
-func TestCloseActiveConnection(t *testing.T) {
-	_, err := httpd.CloseConnection("non_existent_id", http.StatusNotFound)
+func TestPluginStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	sleepPath, err := exec.LookPath("sleep")
 	if err != nil {
-		t.Errorf("unexpected error closing non existent sftp connection: %v", err)
+		t.Skip("this test requires the sleep command")
 	}
-}
-
-func TestUserBaseDir(t *testing.T) {
-	dataProvider := dataprovider.GetProvider()
-	dataprovider.Close(dataProvider)
-	config.LoadConfig(configDir, "")
-	providerConf := config.GetProviderConf()
-	providerConf.UsersBaseDir = homeBasePath
-	err := dataprovider.Initialize(providerConf, configDir)
+	status, _, err := httpd.GetPluginStatus(http.StatusOK)
 	if err != nil {
-		t.Errorf("error initializing data provider with users base dir: %v", err)
+		t.Errorf("unable to get plugin status: %v", err)
 	}
-	httpd.SetDataProvider(dataprovider.GetProvider())
-	u := getTestUser()
-	u.HomeDir = ""
-	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
-	if err != nil {
-		t.Errorf("unable to add user: %v", err)
+	if len(status) != 0 {
+		t.Errorf("no plugin is configured, status: %+v", status)
 	}
-	if user.HomeDir != filepath.Join(providerConf.UsersBaseDir, u.Username) {
-		t.Errorf("invalid home dir: %v", user.HomeDir)
+	invalidConfigs := [][]plugin.Config{
+		{{Type: "unknown", Cmd: sleepPath}},
+		{{Type: "notifier", Cmd: "sleep"}},
+		{{Type: "notifier", Cmd: filepath.Dir(sleepPath)}},
+		{{Type: "notifier", Cmd: filepath.Join(homeBasePath, "missing_plugin")}},
+		{{Type: "notifier", Cmd: sleepPath, MaxRestarts: -1}},
 	}
-	_, err = httpd.RemoveUser(user, http.StatusOK)
-	if err != nil {
-		t.Errorf("unable to remove: %v", err)
+	for _, c := range invalidConfigs {
+		if err = plugin.Initialize(c); err == nil {
+			t.Errorf("initializing plugins with an invalid config must fail: %+v", c)
+		}
 	}
-	dataProvider = dataprovider.GetProvider()
-	dataprovider.Close(dataProvider)
-	config.LoadConfig(configDir, "")
-	providerConf = config.GetProviderConf()
-	providerConf.CredentialsPath = credentialsPath
-	os.RemoveAll(credentialsPath)
-	err = dataprovider.Initialize(providerConf, configDir)
+	err = plugin.Initialize([]plugin.Config{
+		{
+			Type: "notifier",
+			Cmd:  sleepPath,
+			Args: []string{"60"},
+		},
+	})
 	if err != nil {
-		t.Errorf("error initializing data provider")
+		t.Errorf("unable to initialize plugins: %v", err)
 	}
-	httpd.SetDataProvider(dataprovider.GetProvider())
-	sftpd.SetDataProvider(dataprovider.GetProvider())
-}
-
-func TestProviderErrors(t *testing.T) {
-	dataProvider := dataprovider.GetProvider()
-	dataprovider.Close(dataProvider)
-	_, _, err := httpd.GetUserByID(0, http.StatusInternalServerError)
-	if err != nil {
-		t.Errorf("get user with provider closed must fail: %v", err)
+	if err = plugin.Initialize(nil); err == nil {
+		t.Error("initializing plugins twice must fail")
 	}
-	_, _, err = httpd.GetUsers(1, 0, defaultUsername, http.StatusInternalServerError)
+	status, _, err = httpd.GetPluginStatus(http.StatusOK)
 	if err != nil {
-		t.Errorf("get users with provider closed must fail: %v", err)
+		t.Errorf("unable to get plugin status: %v", err)
 	}
-	_, _, err = httpd.UpdateUser(dataprovider.User{}, http.StatusInternalServerError)
-	if err != nil {
-		t.Errorf("update user with provider closed must fail: %v", err)
+	if len(status) != 1 || !status[0].Running || status[0].PID <= 0 || status[0].Type != "notifier" {
+		t.Fatalf("unexpected plugin status: %+v", status)
 	}
-	_, err = httpd.RemoveUser(dataprovider.User{}, http.StatusInternalServerError)
+	// simulate a crash, the plugin must be restarted
+	process, err := os.FindProcess(status[0].PID)
 	if err != nil {
-		t.Errorf("delete user with provider closed must fail: %v", err)
+		t.Errorf("unable to find the plugin process: %v", err)
+	} else {
+		process.Kill()
 	}
-	_, _, err = httpd.GetProviderStatus(http.StatusInternalServerError)
-	if err != nil {
-		t.Errorf("get provider status with provider closed must fail: %v", err)
+	restarted := false
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		status, _, err = httpd.GetPluginStatus(http.StatusOK)
+		if err == nil && len(status) == 1 && status[0].Running && status[0].Restarts == 1 {
+			restarted = true
+			break
+		}
 	}
-	_, _, err = httpd.Dumpdata("backup.json", "", http.StatusInternalServerError)
-	if err != nil {
-		t.Errorf("get provider status with provider closed must fail: %v", err)
+	if !restarted {
+		t.Errorf("the plugin must be restarted after a crash, status: %+v", status)
 	}
-	user := getTestUser()
-	user.ID = 1
-	backupData := dataprovider.BackupData{}
-	backupData.Users = append(backupData.Users, user)
-	backupContent, _ := json.Marshal(backupData)
-	backupFilePath := filepath.Join(backupsPath, "backup.json")
-	ioutil.WriteFile(backupFilePath, backupContent, 0666)
-	_, _, err = httpd.Loaddata(backupFilePath, "", "", http.StatusInternalServerError)
-	if err != nil {
-		t.Errorf("get provider status with provider closed must fail: %v", err)
+	if len(status) == 1 && len(status[0].LastError) == 0 {
+		t.Error("the exit error for the crashed plugin must be reported")
 	}
-	os.Remove(backupFilePath)
-	config.LoadConfig(configDir, "")
-	providerConf := config.GetProviderConf()
-	providerConf.CredentialsPath = credentialsPath
-	os.RemoveAll(credentialsPath)
-	err = dataprovider.Initialize(providerConf, configDir)
+	plugin.Stop()
+	status, _, err = httpd.GetPluginStatus(http.StatusOK)
 	if err != nil {
-		t.Errorf("error initializing data provider: %v", err)
+		t.Errorf("unable to get plugin status: %v", err)
+	}
+	if len(status) != 1 || status[0].Running || status[0].PID != 0 {
+		t.Errorf("the plugin must be stopped, status: %+v", status)
+	}
+	_, _, err = httpd.GetPluginStatus(http.StatusInternalServerError)
+	if err == nil {
+		t.Errorf("get plugin status request must succeed, we requested to check a wrong status code")
 	}
-	httpd.SetDataProvider(dataprovider.GetProvider())
-	sftpd.SetDataProvider(dataprovider.GetProvider())
 }
 
-func TestDumpdata(t *testing.T) {
-	dataProvider := dataprovider.GetProvider()
-	dataprovider.Close(dataProvider)
-	config.LoadConfig(configDir, "")
-	providerConf := config.GetProviderConf()
-	err := dataprovider.Initialize(providerConf, configDir)
+func TestGRPCAdminAPI(t *testing.T) {
+	conn, err := grpc.Dial(grpcAddress, grpc.WithInsecure())
 	if err != nil {
-		t.Errorf("error initializing data provider:_%v", err)
+		t.Fatalf("unable to connect to the gRPC server: %v", err)
 	}
-	httpd.SetDataProvider(dataprovider.GetProvider())
-	sftpd.SetDataProvider(dataprovider.GetProvider())
-	_, _, err = httpd.Dumpdata("", "", http.StatusBadRequest)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	defer conn.Close()
+	client := adminpb.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u := &adminpb.User{
+		Username: defaultUsername,
+		Password: defaultPassword,
+		HomeDir:  filepath.Join(homeBasePath, defaultUsername),
+		Status:   1,
+		Permissions: map[string]*adminpb.Permissions{
+			"/": {Permissions: defaultPerms},
+		},
+		VirtualFolders: []*adminpb.VirtualFolder{
+			{
+				VirtualPath: "/vdir",
+				MappedPath:  filepath.Join(os.TempDir(), "mapped_dir"),
+			},
+		},
+		Filters: &adminpb.UserFilters{
+			AllowedIp: []string{"192.168.1.0/24"},
+			FileExtensions: []*adminpb.ExtensionsFilter{
+				{
+					Path:              "/",
+					AllowedExtensions: []string{".zip"},
+				},
+			},
+		},
 	}
-	_, _, err = httpd.Dumpdata(filepath.Join(backupsPath, "backup.json"), "", http.StatusBadRequest)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	_, err = client.AddUser(ctx, &adminpb.AddUserRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("add user without a user must fail with invalid argument: %v", err)
 	}
-	_, _, err = httpd.Dumpdata("../backup.json", "", http.StatusBadRequest)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	_, err = client.AddUser(ctx, &adminpb.AddUserRequest{User: &adminpb.User{Username: defaultUsername}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("add invalid user must fail with invalid argument: %v", err)
 	}
-	_, _, err = httpd.Dumpdata("backup.json", "0", http.StatusOK)
+	added, err := client.AddUser(ctx, &adminpb.AddUserRequest{User: u})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unable to add user: %v", err)
 	}
-	_, _, err = httpd.Dumpdata("backup.json", "1", http.StatusOK)
+	if added.Id == 0 || len(added.Password) > 0 {
+		t.Errorf("unexpected added user: %+v", added)
+	}
+	if len(added.VirtualFolders) != 1 || len(added.Filters.GetFileExtensions()) != 1 || len(added.Filters.GetAllowedIp()) != 1 {
+		t.Errorf("unexpected added user: %+v", added)
+	}
+	user, _, err := httpd.GetUserByID(added.Id, http.StatusOK)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to get user added via gRPC: %v", err)
 	}
-	os.Remove(filepath.Join(backupsPath, "backup.json"))
-	if runtime.GOOS != "windows" {
-		os.Chmod(backupsPath, 0001)
-		_, _, err = httpd.Dumpdata("bck.json", "", http.StatusInternalServerError)
-		if err != nil {
-			t.Errorf("unexpected error: %v", err)
-		}
-		os.Chmod(backupsPath, 0755)
+	if user.Username != defaultUsername || len(user.VirtualFolders) != 1 {
+		t.Errorf("unexpected user: %+v", user)
 	}
-	dataProvider = dataprovider.GetProvider()
-	dataprovider.Close(dataProvider)
-	config.LoadConfig(configDir, "")
-	providerConf = config.GetProviderConf()
-	providerConf.CredentialsPath = credentialsPath
-	os.RemoveAll(credentialsPath)
-	err = dataprovider.Initialize(providerConf, configDir)
+	users, err := client.GetUsers(ctx, &adminpb.GetUsersRequest{Username: defaultUsername})
 	if err != nil {
-		t.Errorf("error initializing data provider: %v", err)
+		t.Errorf("unable to get users: %v", err)
+	} else if len(users.Users) != 1 {
+		t.Errorf("number of users mismatch, expected: 1, actual: %v", len(users.Users))
 	}
-	httpd.SetDataProvider(dataprovider.GetProvider())
-	sftpd.SetDataProvider(dataprovider.GetProvider())
-}
-
-func TestLoaddata(t *testing.T) {
-	user := getTestUser()
-	user.ID = 1
-	user.Username = "test_user_restore"
-	backupData := dataprovider.BackupData{}
-	backupData.Users = append(backupData.Users, user)
-	backupContent, _ := json.Marshal(backupData)
-	backupFilePath := filepath.Join(backupsPath, "backup.json")
-	ioutil.WriteFile(backupFilePath, backupContent, 0666)
-	_, _, err := httpd.Loaddata(backupFilePath, "a", "", http.StatusBadRequest)
+	_, err = client.GetUsers(ctx, &adminpb.GetUsersRequest{Order: "invalid"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("get users with invalid order must fail with invalid argument: %v", err)
+	}
+	_, err = client.GetUsers(ctx, &adminpb.GetUsersRequest{Limit: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("get users with invalid limit must fail with invalid argument: %v", err)
+	}
+	added.QuotaFiles = 100
+	added.Permissions = nil
+	added.Filters.FileExtensions = nil
+	_, err = client.UpdateUser(ctx, &adminpb.UpdateUserRequest{User: added, Disconnect: adminpb.DisconnectMode_DISCONNECT_YES})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to update user: %v", err)
 	}
-	_, _, err = httpd.Loaddata(backupFilePath, "", "a", http.StatusBadRequest)
+	updated, err := client.GetUser(ctx, &adminpb.GetUserRequest{Id: added.Id})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to get user: %v", err)
+	} else {
+		if updated.QuotaFiles != 100 {
+			t.Errorf("quota files not updated: %v", updated.QuotaFiles)
+		}
+		if len(updated.Permissions) != 1 || len(updated.Filters.GetFileExtensions()) != 1 {
+			t.Errorf("empty permissions and file extensions must not be updated: %+v", updated)
+		}
 	}
-	_, _, err = httpd.Loaddata("backup.json", "1", "", http.StatusBadRequest)
+	// the added user version is stale now
+	_, err = client.UpdateUser(ctx, &adminpb.UpdateUserRequest{User: added})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("update user with a stale version must fail with aborted: %v", err)
+	}
+	_, _, err = httpd.GetUsers(0, 0, defaultUsername, http.StatusOK)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to get users: %v", err)
 	}
-	_, _, err = httpd.Loaddata(backupFilePath+"a", "1", "", http.StatusBadRequest)
+	_, err = client.StartQuotaScan(ctx, &adminpb.StartQuotaScanRequest{Username: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("quota scan for a missing user must fail with not found: %v", err)
+	}
+	_, err = client.StartQuotaScan(ctx, &adminpb.StartQuotaScanRequest{Username: defaultUsername})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to start quota scan: %v", err)
 	}
-	if runtime.GOOS != "windows" {
-		os.Chmod(backupFilePath, 0111)
-		_, _, err = httpd.Loaddata(backupFilePath, "1", "", http.StatusInternalServerError)
+	for {
+		scans, err := client.GetQuotaScans(ctx, &adminpb.GetQuotaScansRequest{})
 		if err != nil {
-			t.Errorf("unexpected error: %v", err)
+			t.Errorf("unable to get active quota scans: %v", err)
+			break
 		}
-		os.Chmod(backupFilePath, 0644)
-	}
-	// add user from backup
-	_, _, err = httpd.Loaddata(backupFilePath, "1", "", http.StatusOK)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		if len(scans.Scans) == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	// update user from backup
-	_, _, err = httpd.Loaddata(backupFilePath, "2", "", http.StatusOK)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	_, err = client.DumpData(ctx, &adminpb.DumpDataRequest{OutputFile: "../backup.json"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("dump with an invalid path must fail with invalid argument: %v", err)
 	}
-	users, _, err := httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	_, err = client.DumpData(ctx, &adminpb.DumpDataRequest{OutputFile: "grpc_backup.json", Indent: true})
 	if err != nil {
-		t.Errorf("unable to get users: %v", err)
+		t.Errorf("unable to dump data: %v", err)
 	}
-	if len(users) != 1 {
-		t.Error("Unable to get restored user")
+	backupFilePath := filepath.Join(backupsPath, "grpc_backup.json")
+	_, err = client.LoadData(ctx, &adminpb.LoadDataRequest{InputFile: "grpc_backup.json"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("restore with a relative path must fail with invalid argument: %v", err)
 	}
-	user = users[0]
-	_, err = httpd.RemoveUser(user, http.StatusOK)
+	_, err = client.LoadData(ctx, &adminpb.LoadDataRequest{InputFile: backupFilePath, Mode: 1})
 	if err != nil {
-		t.Errorf("unable to remove user: %v", err)
+		t.Errorf("unable to restore data: %v", err)
 	}
 	os.Remove(backupFilePath)
-	createTestFile(backupFilePath, 10485761)
-	_, _, err = httpd.Loaddata(backupFilePath, "1", "0", http.StatusBadRequest)
+	connections, err := client.GetConnections(ctx, &adminpb.GetConnectionsRequest{})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to get connections: %v", err)
+	} else if len(connections.Connections) != 0 {
+		t.Errorf("unexpected active connections: %+v", connections.Connections)
 	}
-	os.Remove(backupFilePath)
-	createTestFile(backupFilePath, 65535)
-	_, _, err = httpd.Loaddata(backupFilePath, "1", "0", http.StatusBadRequest)
+	_, err = client.CloseConnection(ctx, &adminpb.CloseConnectionRequest{ConnectionId: "non_existent_id"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("close a missing connection must fail with not found: %v", err)
+	}
+	_, err = client.UpdateConnectionLabel(ctx, &adminpb.UpdateConnectionLabelRequest{Label: "label"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("update label without a connection id must fail with invalid argument: %v", err)
+	}
+	_, err = client.UpdateConnectionLabel(ctx, &adminpb.UpdateConnectionLabelRequest{
+		ConnectionId: "non_existent_id",
+		Label:        strings.Repeat("a", 256),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("update connection with an invalid label must fail with invalid argument: %v", err)
+	}
+	_, err = client.UpdateConnectionLabel(ctx, &adminpb.UpdateConnectionLabelRequest{ConnectionId: "non_existent_id"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("update label for a missing connection must fail with not found: %v", err)
+	}
+	_, err = client.DeleteUser(ctx, &adminpb.DeleteUserRequest{Id: added.Id})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to delete user: %v", err)
+	}
+	_, err = client.GetUser(ctx, &adminpb.GetUserRequest{Id: added.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("get a deleted user must fail with not found: %v", err)
+	}
+	_, err = client.DeleteUser(ctx, &adminpb.DeleteUserRequest{Id: added.Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("delete a missing user must fail with not found: %v", err)
 	}
-	os.Remove(backupFilePath)
 }
 
-func TestLoaddataMode(t *testing.T) {
-	user := getTestUser()
-	user.ID = 1
-	user.Username = "test_user_restore"
-	backupData := dataprovider.BackupData{}
-	backupData.Users = append(backupData.Users, user)
-	backupContent, _ := json.Marshal(backupData)
-	backupFilePath := filepath.Join(backupsPath, "backup.json")
-	ioutil.WriteFile(backupFilePath, backupContent, 0666)
-	_, _, err := httpd.Loaddata(backupFilePath, "0", "0", http.StatusOK)
+func TestGetConnections(t *testing.T) {
+	_, _, err := httpd.GetConnections(http.StatusOK)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unable to get sftp connections: %v", err)
 	}
-	users, _, err := httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	_, _, err = httpd.GetConnections(http.StatusInternalServerError)
+	if err == nil {
+		t.Errorf("get sftp connections request must succeed, we requested to check a wrong status code")
+	}
+}
+
+func TestGetFilteredConnections(t *testing.T) {
+	connections, _, err := httpd.GetFilteredConnections(sftpd.ConnectionTagsFilter{
+		ClientSoftware: "WinSCP",
+		Tenant:         "ACME",
+		Label:          "label",
+	}, http.StatusOK)
 	if err != nil {
-		t.Errorf("unable to get users: %v", err)
+		t.Errorf("unable to get filtered connections: %v", err)
 	}
-	if len(users) != 1 {
-		t.Error("Unable to get restored user")
+	if len(connections) != 0 {
+		t.Errorf("unexpected connections: %+v", connections)
 	}
-	user = users[0]
-	oldUploadBandwidth := user.UploadBandwidth
-	user.UploadBandwidth = oldUploadBandwidth + 128
-	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+}
+
+func TestUpdateConnectionLabel(t *testing.T) {
+	_, err := httpd.UpdateConnectionLabel("non_existent_id", "label", http.StatusNotFound)
 	if err != nil {
-		t.Errorf("unable to update user: %v", err)
+		t.Errorf("unexpected error setting the label for a non existent connection: %v", err)
 	}
-	_, _, err = httpd.Loaddata(backupFilePath, "0", "1", http.StatusOK)
+	_, err = httpd.UpdateConnectionLabel("non_existent_id", strings.Repeat("a", 256), http.StatusBadRequest)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Errorf("unexpected error setting an invalid label: %v", err)
 	}
-	users, _, err = httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+}
+
+func TestCloseActiveConnection(t *testing.T) {
+	_, err := httpd.CloseConnection("non_existent_id", http.StatusNotFound)
 	if err != nil {
-		t.Errorf("unable to get users: %v", err)
-	}
-	if len(users) != 1 {
-		t.Error("Unable to get restored user")
+		t.Errorf("unexpected error closing non existent sftp connection: %v", err)
 	}
-	user = users[0]
-	if user.UploadBandwidth == oldUploadBandwidth {
-		t.Error("user must not be modified")
+}
+
+func TestCloseUserConnections(t *testing.T) {
+	_, err := httpd.CloseUserConnections("non_existent_user", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error closing the connections for a non existent user: %v", err)
 	}
-	_, err = httpd.RemoveUser(user, http.StatusOK)
+	_, err = httpd.CloseUserConnections("", http.StatusBadRequest)
 	if err != nil {
-		t.Errorf("unable to remove user: %v", err)
+		t.Errorf("the username must be mandatory: %v", err)
 	}
-	os.Remove(backupFilePath)
 }
 
-func TestHTTPSConnection(t *testing.T) {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+func TestIPListEntryHandling(t *testing.T) {
+	entry := dataprovider.IPListEntry{
+		IPOrNet:     "192.168.1.0/24",
+		Type:        dataprovider.IPListTypeBlock,
+		Description: "test network",
 	}
-	_, err := client.Get("https://localhost:8443" + metricsPath)
-	if err == nil || (!strings.Contains(err.Error(), "certificate is not valid") &&
-		!strings.Contains(err.Error(), "certificate signed by unknown authority")) {
-		t.Errorf("unexpected error: %v", err)
+	entry, _, err := httpd.AddIPListEntry(entry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
 	}
-}
-
-// test using mock http server
+	_, _, err = httpd.AddIPListEntry(entry, http.StatusOK)
+	if err == nil {
+		t.Errorf("adding a duplicate IP list entry must fail")
+	}
+	// the same network with a different notation is a duplicate too
+	_, _, err = httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "192.168.1.27/24", Type: dataprovider.IPListTypeSafe},
+		http.StatusOK)
+	if err == nil {
+		t.Errorf("adding a duplicate IP list entry must fail")
+	}
+	if !dataprovider.IsIPBlocked("192.168.1.12") {
+		t.Errorf("IP address 192.168.1.12 must be blocked")
+	}
+	if dataprovider.IsIPBlocked("192.168.2.12") {
+		t.Errorf("IP address 192.168.2.12 must not be blocked")
+	}
+	safeEntry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "192.168.1.12", Type: dataprovider.IPListTypeSafe},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	if dataprovider.IsIPBlocked("192.168.1.12") {
+		t.Errorf("IP address 192.168.1.12 is in the safe list, it must not be blocked")
+	}
+	if !dataprovider.IsIPBlocked("192.168.1.13") {
+		t.Errorf("IP address 192.168.1.13 must be blocked")
+	}
+	entries, _, err := httpd.GetIPListEntries(0, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get IP list entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("unexpected number of IP list entries: %v", len(entries))
+	}
+	entries, _, err = httpd.GetIPListEntries(dataprovider.IPListTypeSafe, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get IP list entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != safeEntry.ID {
+		t.Errorf("unexpected safe list entries: %+v", entries)
+	}
+	entry.Type = dataprovider.IPListTypeSafe
+	entry.Description = "updated description"
+	_, _, err = httpd.UpdateIPListEntry(entry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update IP list entry: %v", err)
+	}
+	if dataprovider.IsIPBlocked("192.168.1.13") {
+		t.Errorf("IP address 192.168.1.13 must not be blocked after the update")
+	}
+	entry.IPOrNet = "192.168.1.12"
+	_, _, err = httpd.UpdateIPListEntry(entry, http.StatusOK)
+	if err == nil {
+		t.Errorf("updating an IP list entry with a duplicate ipornet must fail")
+	}
+	_, err = httpd.RemoveIPListEntry(safeEntry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	_, err = httpd.RemoveIPListEntry(entry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	_, err = httpd.RemoveIPListEntry(entry, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	_, _, err = httpd.GetIPListEntryByID(entry.ID, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing IP list entry: %v", err)
+	}
+	_, _, err = httpd.UpdateIPListEntry(entry, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error updating a missing IP list entry: %v", err)
+	}
+}
+
+func TestAddIPListEntryInvalid(t *testing.T) {
+	_, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{Type: dataprovider.IPListTypeBlock}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an IP list entry without ipornet: %v", err)
+	}
+	_, _, err = httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "10.8.0.1"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an IP list entry without type: %v", err)
+	}
+	_, _, err = httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "10.8.0.300", Type: dataprovider.IPListTypeBlock},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an IP list entry with an invalid IP: %v", err)
+	}
+	_, _, err = httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "10.8.0.0/33", Type: dataprovider.IPListTypeBlock},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an IP list entry with an invalid network: %v", err)
+	}
+	_, _, err = httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "10.8.0.1", Type: dataprovider.IPListTypeBlock,
+		Description: strings.Repeat("a", 256)}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an IP list entry with a too long description: %v", err)
+	}
+}
+
+func TestIPListBlockedRequest(t *testing.T) {
+	entry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "127.0.0.1", Type: dataprovider.IPListTypeBlock},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	_, _, err = httpd.GetVersion(http.StatusForbidden)
+	if err != nil {
+		t.Errorf("requests from a blocked IP must be refused: %v", err)
+	}
+	// the entry cannot be removed using the REST API, we are blocked
+	err = dataprovider.DeleteIPListEntry(dataprovider.GetProvider(), entry)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	_, _, err = httpd.GetVersion(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get version: %v", err)
+	}
+}
+
+func TestIPListImportExport(t *testing.T) {
+	entry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "172.16.1.0/24", Type: dataprovider.IPListTypeBlock},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	safeEntry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "172.16.2.1", Type: dataprovider.IPListTypeSafe},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	set := dataprovider.IPListSet{
+		Type:        dataprovider.IPListTypeBlock,
+		Entries:     []string{"172.16.1.27/24", "172.16.2.0/24", "172.16.2.1", "172.16.3.1", "172.16.3.1"},
+		Description: "imported",
+	}
+	result, _, err := httpd.ImportIPListSet(set, false, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to import IP list set: %v", err)
+	}
+	if result.Added != 2 || result.Skipped != 2 || result.Removed != 0 {
+		t.Errorf("unexpected import result: %+v", result)
+	}
+	if !dataprovider.IsIPBlocked("172.16.2.2") || !dataprovider.IsIPBlocked("172.16.3.1") {
+		t.Errorf("the imported entries must be blocked")
+	}
+	if dataprovider.IsIPBlocked("172.16.2.1") {
+		t.Errorf("IP address 172.16.2.1 is in the safe list, it must not be blocked")
+	}
+	exported, _, err := httpd.ExportIPListSet(dataprovider.IPListTypeBlock, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to export IP list set: %v", err)
+	}
+	if exported.Type != dataprovider.IPListTypeBlock || len(exported.Entries) != 3 ||
+		!utils.IsStringInSlice("172.16.1.0/24", exported.Entries) || !utils.IsStringInSlice("172.16.2.0/24", exported.Entries) ||
+		!utils.IsStringInSlice("172.16.3.1", exported.Entries) {
+		t.Errorf("unexpected exported set: %+v", exported)
+	}
+	exported, _, err = httpd.ExportIPListSet(dataprovider.IPListTypeSafe, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to export IP list set: %v", err)
+	}
+	if len(exported.Entries) != 1 || exported.Entries[0] != safeEntry.IPOrNet {
+		t.Errorf("unexpected exported set: %+v", exported)
+	}
+	entries, _, err := httpd.GetIPListEntries(dataprovider.IPListTypeBlock, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get IP list entries: %v", err)
+	}
+	for _, e := range entries {
+		if e.ID != entry.ID && e.Description != set.Description {
+			t.Errorf("unexpected description for the imported entry: %+v", e)
+		}
+	}
+	// invalid sets must not change the lists
+	set.Entries = []string{"172.16.4.1", "172.16.4.300", "172.16.5.0/33"}
+	_, body, err := httpd.ImportIPListSet(set, true, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error importing an invalid set: %v", err)
+	}
+	if !strings.Contains(string(body), "172.16.4.300") || !strings.Contains(string(body), "172.16.5.0/33") {
+		t.Errorf("the invalid entries must be reported: %v", string(body))
+	}
+	if dataprovider.IsIPBlocked("172.16.4.1") {
+		t.Errorf("an invalid set must not be imported")
+	}
+	_, _, err = httpd.ImportIPListSet(dataprovider.IPListSet{Type: 3, Entries: []string{"172.16.4.1"}}, false,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error importing a set with an invalid type: %v", err)
+	}
+	_, _, err = httpd.ImportIPListSet(dataprovider.IPListSet{Type: dataprovider.IPListTypeBlock, Entries: []string{""}},
+		false, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error importing a set with an empty entry: %v", err)
+	}
+	_, _, err = httpd.ImportIPListSet(dataprovider.IPListSet{Type: dataprovider.IPListTypeBlock, Entries: []string{"172.16.4.1"},
+		Description: strings.Repeat("a", 256)}, false, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error importing a set with a too long description: %v", err)
+	}
+	_, _, err = httpd.ExportIPListSet(0, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error exporting an invalid list type: %v", err)
+	}
+	// replace mode removes the entries not included in the set
+	set.Entries = []string{"172.16.1.0/24", "172.16.4.1"}
+	result, _, err = httpd.ImportIPListSet(set, true, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to import IP list set: %v", err)
+	}
+	if result.Added != 1 || result.Skipped != 1 || result.Removed != 2 {
+		t.Errorf("unexpected import result: %+v", result)
+	}
+	if dataprovider.IsIPBlocked("172.16.2.2") || dataprovider.IsIPBlocked("172.16.3.1") || !dataprovider.IsIPBlocked("172.16.4.1") {
+		t.Errorf("unexpected block list after replacing the entries")
+	}
+	// the safe list is not affected
+	_, _, err = httpd.GetIPListEntryByID(safeEntry.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("the safe list entry must not be removed: %v", err)
+	}
+	// an empty set in replace mode removes all the entries of the given type
+	result, _, err = httpd.ImportIPListSet(dataprovider.IPListSet{Type: dataprovider.IPListTypeBlock}, true, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to import IP list set: %v", err)
+	}
+	if result.Added != 0 || result.Removed != 2 {
+		t.Errorf("unexpected import result: %+v", result)
+	}
+	_, err = httpd.RemoveIPListEntry(safeEntry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	entries, _, err = httpd.GetIPListEntries(0, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get IP list entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected IP list entries: %+v", entries)
+	}
+}
+
+func TestIPListFeeds(t *testing.T) {
+	var feeds []dataprovider.IPListFeedStatus
+	var err error
+	for i := 0; i < 50; i++ {
+		feeds, _, err = httpd.GetIPListFeeds(http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get IP list feeds: %v", err)
+			break
+		}
+		if len(feeds) == 2 && feeds[0].LastUpdate > 0 && len(feeds[1].LastError) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(feeds) != 2 {
+		t.Fatalf("unexpected IP list feeds: %+v", feeds)
+	}
+	if feeds[0].URL != ipListFeedServer.URL+"/feed" || feeds[0].NumEntries != 2 || feeds[0].LastUpdate == 0 ||
+		feeds[0].ExpiresAt <= feeds[0].LastUpdate || len(feeds[0].LastError) > 0 {
+		t.Errorf("unexpected status for the IP list feed: %+v", feeds[0])
+	}
+	if feeds[1].NumEntries != 0 || feeds[1].LastUpdate != 0 || feeds[1].ExpiresAt != 0 ||
+		!strings.Contains(feeds[1].LastError, "404") {
+		t.Errorf("unexpected status for the missing IP list feed: %+v", feeds[1])
+	}
+	if !dataprovider.IsIPBlocked("203.0.113.25") || !dataprovider.IsIPBlocked("198.51.100.7") {
+		t.Errorf("the addresses in the IP list feed must be blocked")
+	}
+	if dataprovider.IsIPBlocked("198.51.100.8") {
+		t.Errorf("IP address 198.51.100.8 must not be blocked")
+	}
+	// the safe list has precedence over the feeds
+	entry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "203.0.113.25", Type: dataprovider.IPListTypeSafe},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	if dataprovider.IsIPBlocked("203.0.113.25") {
+		t.Errorf("IP address 203.0.113.25 is in the safe list, it must not be blocked")
+	}
+	if !dataprovider.IsIPBlocked("203.0.113.26") {
+		t.Errorf("IP address 203.0.113.26 must be blocked")
+	}
+	// the feed entries are not stored inside the data provider
+	exported, _, err := httpd.ExportIPListSet(dataprovider.IPListTypeBlock, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to export IP list set: %v", err)
+	}
+	if len(exported.Entries) != 0 {
+		t.Errorf("unexpected exported set: %+v", exported)
+	}
+	_, err = httpd.RemoveIPListEntry(entry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+}
+
+func TestIPListCheckAndUnblock(t *testing.T) {
+	result, _, err := httpd.CheckIPAddress("198.51.100.7", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to check IP address: %v", err)
+	}
+	if !result.Blocked || len(result.Feeds) != 1 || result.Feeds[0] != ipListFeedServer.URL+"/feed" ||
+		len(result.SafeEntries) != 0 || len(result.BlockEntries) != 0 {
+		t.Errorf("unexpected check result: %+v", result)
+	}
+	entry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "192.0.2.10", Type: dataprovider.IPListTypeBlock},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	netEntry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "192.0.2.0/24", Type: dataprovider.IPListTypeBlock},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	result, _, err = httpd.CheckIPAddress("192.0.2.10", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to check IP address: %v", err)
+	}
+	if !result.Blocked || len(result.BlockEntries) != 2 || len(result.Feeds) != 0 {
+		t.Errorf("unexpected check result: %+v", result)
+	}
+	_, err = httpd.RemoveIPListEntry(netEntry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	// the block list entry for the single address is removed
+	result, _, err = httpd.UnblockIPAddress("192.0.2.10", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to unblock IP address: %v", err)
+	}
+	if result.Blocked || len(result.BlockEntries) != 0 || len(result.SafeEntries) != 0 {
+		t.Errorf("unexpected unblock result: %+v", result)
+	}
+	_, _, err = httpd.GetIPListEntryByID(entry.ID, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("the block list entry must be removed: %v", err)
+	}
+	if dataprovider.IsIPBlocked("192.0.2.10") {
+		t.Errorf("IP address 192.0.2.10 must not be blocked")
+	}
+	// an address blocked by an external block list is added to the safe list
+	result, _, err = httpd.UnblockIPAddress("198.51.100.7", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to unblock IP address: %v", err)
+	}
+	if result.Blocked || len(result.SafeEntries) != 1 || len(result.Feeds) != 1 {
+		t.Errorf("unexpected unblock result: %+v", result)
+	}
+	if dataprovider.IsIPBlocked("198.51.100.7") {
+		t.Errorf("IP address 198.51.100.7 must not be blocked")
+	}
+	// unblocking an address not blocked is a no-op
+	result, _, err = httpd.UnblockIPAddress("198.51.100.7", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to unblock IP address: %v", err)
+	}
+	if result.Blocked || len(result.SafeEntries) != 1 {
+		t.Errorf("unexpected unblock result: %+v", result)
+	}
+	if len(result.SafeEntries) > 0 {
+		_, err = httpd.RemoveIPListEntry(result.SafeEntries[0], http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove IP list entry: %v", err)
+		}
+	}
+	_, _, err = httpd.CheckIPAddress("192.0.2.300", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error checking an invalid IP address: %v", err)
+	}
+	_, _, err = httpd.UnblockIPAddress("", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error unblocking an invalid IP address: %v", err)
+	}
+}
+
+func TestDefenderHosts(t *testing.T) {
+	hosts, _, err := httpd.GetDefenderHosts(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get defender hosts: %v", err)
+	}
+	if len(hosts) != 0 {
+		t.Errorf("unexpected defender hosts: %+v", hosts)
+	}
+	_, _, err = httpd.GetDefenderHost("::1", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing defender host: %v", err)
+	}
+	_, _, err = httpd.BanDefenderHost("::1", 0, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("banning a host with the defender disabled must fail: %v", err)
+	}
+	_, _, err = httpd.BanDefenderHost("not an IP", 0, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("banning an invalid IP must fail: %v", err)
+	}
+	_, err = httpd.UnbanDefenderHost("::1", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error unbanning a missing defender host: %v", err)
+	}
+}
+
+func TestPlans(t *testing.T) {
+	plan := dataprovider.Plan{
+		Name:               "test_plan",
+		Description:        "test plan",
+		MaxSessions:        2,
+		QuotaSize:          1000,
+		QuotaFiles:         10,
+		UploadBandwidth:    100,
+		DownloadBandwidth:  200,
+		AllowedFsProviders: []int{0, 1},
+		DeniedLoginMethods: []string{dataprovider.SSHLoginMethodKeyboardInteractive},
+	}
+	plan, _, err := httpd.AddPlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add plan: %v", err)
+	}
+	plans, _, err := httpd.GetPlans(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plans: %v", err)
+	}
+	if len(plans) != 1 {
+		t.Errorf("unexpected number of plans: %v", len(plans))
+	}
+	_, _, err = httpd.GetPlanByID(plan.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plan by id: %v", err)
+	}
+	_, _, err = httpd.GetPlanByID(plan.ID+1, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing plan: %v", err)
+	}
+	u := getTestUser()
+	u.Plan = "missing_plan"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a user with a missing plan must fail: %v", err)
+	}
+	u.Plan = plan.Name
+	// the user limits are replaced with the plan ones
+	u.MaxSessions = plan.MaxSessions
+	u.QuotaSize = plan.QuotaSize
+	u.QuotaFiles = plan.QuotaFiles
+	u.UploadBandwidth = plan.UploadBandwidth
+	u.DownloadBandwidth = plan.DownloadBandwidth
+	u.Filters.DeniedLoginMethods = plan.DeniedLoginMethods
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with plan: %v", err)
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("removing a plan assigned to some users must fail: %v", err)
+	}
+	plan.QuotaSize = 2000
+	plan.UploadBandwidth = 0
+	plan.DeniedLoginMethods = nil
+	plan, _, err = httpd.UpdatePlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update plan: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.QuotaSize != plan.QuotaSize || user.UploadBandwidth != plan.UploadBandwidth ||
+		user.DownloadBandwidth != plan.DownloadBandwidth || len(user.Filters.DeniedLoginMethods) > 0 {
+		t.Errorf("the plan update was not applied to the assigned user: %+v", user)
+	}
+	// the user filesystem provider is not allowed for the updated plan, nothing must be changed
+	updatedPlan := plan
+	updatedPlan.QuotaSize = 3000
+	updatedPlan.AllowedFsProviders = []int{1}
+	_, _, err = httpd.UpdatePlan(updatedPlan, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("updating a plan with a filesystem provider not allowed for the assigned users must fail: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.QuotaSize != plan.QuotaSize {
+		t.Errorf("the user must not be updated if the plan update fails, quota size: %v", user.QuotaSize)
+	}
+	p, _, err := httpd.GetPlanByID(plan.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plan by id: %v", err)
+	}
+	if p.QuotaSize != plan.QuotaSize || len(p.AllowedFsProviders) != 2 {
+		t.Errorf("the plan must not be updated: %+v", p)
+	}
+	updatedPlan = plan
+	updatedPlan.Name = "renamed_plan"
+	_, _, err = httpd.UpdatePlan(updatedPlan, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the plan name cannot be changed: %v", err)
+	}
+	user.Plan = ""
+	user.QuotaSize = 0
+	_, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove the plan from the user: %v", err)
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove plan: %v", err)
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error removing a missing plan: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestPlanPropagation(t *testing.T) {
+	plan := dataprovider.Plan{
+		Name:        "async_plan",
+		MaxSessions: 2,
+		QuotaSize:   1000,
+	}
+	plan, _, err := httpd.AddPlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add plan: %v", err)
+	}
+	var users []dataprovider.User
+	for _, username := range []string{"async_plan_user1", "async_plan_user2"} {
+		u := getTestUser()
+		u.Username = username
+		u.Plan = plan.Name
+		u.MaxSessions = plan.MaxSessions
+		u.QuotaSize = plan.QuotaSize
+		user, _, err := httpd.AddUser(u, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to add user with plan: %v", err)
+		}
+		users = append(users, user)
+	}
+	_, _, err = httpd.GetPlanPropagation(plan.Name, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing plan propagation: %v", err)
+	}
+	plan.QuotaSize = 2000
+	plan.DownloadBandwidth = 100
+	_, err = httpd.UpdatePlanAsync(plan, http.StatusAccepted)
+	if err != nil {
+		t.Errorf("unable to update plan: %v", err)
+	}
+	propagation := waitForPlanPropagation(t, plan.Name)
+	if propagation.Status != dataprovider.PlanPropagationCompleted || propagation.TotalUsers != 2 ||
+		propagation.ProcessedUsers != 2 || propagation.NumUpdatedUsers != 2 || len(propagation.UpdatedUsers) != 2 ||
+		propagation.NumFailedUsers != 0 || propagation.EndTime < propagation.StartTime {
+		t.Errorf("unexpected plan propagation: %+v", propagation)
+	}
+	for _, user := range users {
+		u, _, err := httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get user: %v", err)
+		}
+		if u.QuotaSize != plan.QuotaSize || u.DownloadBandwidth != plan.DownloadBandwidth || u.MaxSessions != plan.MaxSessions {
+			t.Errorf("the plan update was not applied to the assigned user: %+v", u)
+		}
+	}
+	propagations, _, err := httpd.GetPlanPropagations(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plan propagations: %v", err)
+	}
+	found := false
+	for _, p := range propagations {
+		if p.PlanName == plan.Name {
+			found = true
+			if p.NumUpdatedUsers != 2 || len(p.UpdatedUsers) > 0 {
+				t.Errorf("unexpected plan propagation: %+v", p)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("the plan propagation must be listed")
+	}
+	// the user filesystem provider is not allowed for the updated plan, nothing must be changed
+	updatedPlan := plan
+	updatedPlan.QuotaSize = 3000
+	updatedPlan.AllowedFsProviders = []int{1}
+	_, err = httpd.UpdatePlanAsync(updatedPlan, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("updating a plan with a filesystem provider not allowed for the assigned users must fail: %v", err)
+	}
+	p, _, err := httpd.GetPlanByID(plan.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plan by id: %v", err)
+	}
+	if p.QuotaSize != plan.QuotaSize || len(p.AllowedFsProviders) != 0 {
+		t.Errorf("the plan must not be updated: %+v", p)
+	}
+	req, _ := http.NewRequest(http.MethodPut, planPath+"/"+strconv.FormatInt(plan.ID, 10)+"?async=a", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	for _, user := range users {
+		_, err = httpd.RemoveUser(user, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove plan: %v", err)
+	}
+}
+
+func TestUserTemplates(t *testing.T) {
+	u := getTestUser()
+	u.HomeDir = filepath.Join(homeBasePath, "%username%")
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir/%username%",
+		MappedPath:  filepath.Join(homeBasePath, "mapped", "%username%"),
+	})
+	template := dataprovider.UserTemplate{
+		Name:        "test_template",
+		Description: "test template",
+		User:        u,
+	}
+	template, _, err := httpd.AddUserTemplate(template, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user template: %v", err)
+	}
+	templates, _, err := httpd.GetUserTemplates(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user templates: %v", err)
+	}
+	if len(templates) != 1 {
+		t.Errorf("unexpected number of user templates: %v", len(templates))
+	}
+	_, _, err = httpd.GetUserTemplateByID(template.ID+1, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing user template: %v", err)
+	}
+	user, _, err := httpd.AddUserFromTemplate(template, dataprovider.UserCloneRequest{
+		Username: "template_user",
+		Password: defaultPassword,
+	}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user from template: %v", err)
+	}
+	if user.HomeDir != filepath.Join(homeBasePath, "template_user") {
+		t.Errorf("the username placeholder was not expanded inside the home dir: %#v", user.HomeDir)
+	}
+	if len(user.VirtualFolders) != 1 || user.VirtualFolders[0].VirtualPath != "/vdir/template_user" ||
+		user.VirtualFolders[0].MappedPath != filepath.Join(homeBasePath, "mapped", "template_user") {
+		t.Errorf("the username placeholder was not expanded inside the virtual folders: %+v", user.VirtualFolders)
+	}
+	_, _, err = httpd.AddUserFromTemplate(template, dataprovider.UserCloneRequest{Password: defaultPassword},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a user from a template without username must fail: %v", err)
+	}
+	template.Description = "updated description"
+	template.User.FsConfig.Provider = 3
+	template.User.FsConfig.CryptConfig.Passphrase = "template passphrase"
+	template, _, err = httpd.UpdateUserTemplate(template, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user template: %v", err)
+	}
+	// an empty passphrase means the current one
+	template.User.FsConfig.CryptConfig.Passphrase = ""
+	template, _, err = httpd.UpdateUserTemplate(template, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user template: %v", err)
+	}
+	if len(template.User.FsConfig.CryptConfig.Passphrase) == 0 {
+		t.Errorf("the template passphrase must be preserved")
+	}
+	cryptUser, _, err := httpd.AddUserFromTemplate(template, dataprovider.UserCloneRequest{
+		Username: "template_crypt_user",
+		Password: defaultPassword,
+	}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user from the updated template: %v", err)
+	}
+	if cryptUser.FsConfig.Provider != 3 || len(cryptUser.FsConfig.CryptConfig.Passphrase) == 0 {
+		t.Errorf("the filesystem was not copied from the template: %+v", cryptUser.FsConfig)
+	}
+	updatedTemplate := template
+	updatedTemplate.Name = "renamed_template"
+	_, _, err = httpd.UpdateUserTemplate(updatedTemplate, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the user template name cannot be changed: %v", err)
+	}
+	invalidTemplate := dataprovider.UserTemplate{
+		Name: "invalid name",
+		User: getTestUser(),
+	}
+	_, _, err = httpd.AddUserTemplate(invalidTemplate, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a user template with an invalid name must fail: %v", err)
+	}
+	invalidTemplate.Name = "invalid_template"
+	invalidTemplate.User.HomeDir = "relative_path"
+	_, _, err = httpd.AddUserTemplate(invalidTemplate, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a user template with a relative home dir must fail: %v", err)
+	}
+	clonedUser, _, err := httpd.CloneUser(user, dataprovider.UserCloneRequest{
+		Username: "cloned_user",
+		Password: defaultPassword,
+	}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to clone user: %v", err)
+	}
+	if clonedUser.HomeDir != filepath.Join(homeBasePath, "cloned_user") {
+		t.Errorf("the username was not replaced inside the home dir: %#v", clonedUser.HomeDir)
+	}
+	if len(clonedUser.VirtualFolders) != 1 || clonedUser.VirtualFolders[0].VirtualPath != "/vdir/cloned_user" ||
+		clonedUser.VirtualFolders[0].MappedPath != filepath.Join(homeBasePath, "mapped", "cloned_user") {
+		t.Errorf("the username was not replaced inside the virtual folders: %+v", clonedUser.VirtualFolders)
+	}
+	// the password is not copied
+	_, _, err = httpd.CloneUser(user, dataprovider.UserCloneRequest{Username: "cloned_user1"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("cloning a user without credentials must fail: %v", err)
+	}
+	missingUser := user
+	missingUser.ID = clonedUser.ID + 100
+	_, _, err = httpd.CloneUser(missingUser, dataprovider.UserCloneRequest{
+		Username: "cloned_user1",
+		Password: defaultPassword,
+	}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error cloning a missing user: %v", err)
+	}
+	_, err = httpd.RemoveUserTemplate(template, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user template: %v", err)
+	}
+	_, err = httpd.RemoveUserTemplate(template, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error removing a missing user template: %v", err)
+	}
+	_, _, err = httpd.AddUserFromTemplate(template, dataprovider.UserCloneRequest{
+		Username: "template_user1",
+		Password: defaultPassword,
+	}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error adding a user from a missing template: %v", err)
+	}
+	// the users created from a template are not removed with the template
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemoveUser(cryptUser, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemoveUser(clonedUser, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove cloned user: %v", err)
+	}
+}
+
+func TestFolders(t *testing.T) {
+	mappedPath := filepath.Join(homeBasePath, "shared_folder")
+	folder := dataprovider.Folder{
+		Name:        "shared",
+		Description: "shared folder",
+		MappedPath:  mappedPath,
+		QuotaFiles:  10,
+	}
+	folder, _, err := httpd.AddFolder(folder, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add folder: %v", err)
+	}
+	_, _, err = httpd.AddFolder(folder, http.StatusOK)
+	if err == nil {
+		t.Errorf("adding a duplicate folder must fail")
+	}
+	_, _, err = httpd.AddFolder(dataprovider.Folder{Name: "relative", MappedPath: "relative_path"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a folder with a relative mapped path must fail: %v", err)
+	}
+	_, _, err = httpd.GetFolderByID(folder.ID+1, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing folder: %v", err)
+	}
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vshared",
+		Name:        folder.Name,
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	if len(user.VirtualFolders) != 1 || user.VirtualFolders[0].MappedPath != mappedPath {
+		t.Errorf("the shared folder mapped path was not applied: %+v", user.VirtualFolders)
+	}
+	u.Username = "missing_folder_user"
+	u.VirtualFolders[0].Name = "missing"
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a user referencing a missing folder must fail: %v", err)
+	}
+	folders, _, err := httpd.GetFolders(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get folders: %v", err)
+	}
+	if len(folders) != 1 || len(folders[0].Users) != 1 || folders[0].Users[0] != user.Username {
+		t.Errorf("unexpected folders: %+v", folders)
+	}
+	folder.Description = "updated description"
+	folder.QuotaSize = 1000
+	folder, _, err = httpd.UpdateFolder(folder, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update folder: %v", err)
+	}
+	updatedFolder := folder
+	updatedFolder.MappedPath = filepath.Join(homeBasePath, "shared_folder1")
+	_, _, err = httpd.UpdateFolder(updatedFolder, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the mapped path for a referenced folder cannot be changed: %v", err)
+	}
+	updatedFolder = folder
+	updatedFolder.Name = "renamed"
+	_, _, err = httpd.UpdateFolder(updatedFolder, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the folder name cannot be changed: %v", err)
+	}
+	_, err = httpd.RemoveFolder(folder, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a referenced folder cannot be removed: %v", err)
+	}
+	err = os.MkdirAll(mappedPath, 0700)
+	if err != nil {
+		t.Errorf("unable to create the folder mapped path: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(mappedPath, "file.txt"), []byte("shared contents"), 0600)
+	if err != nil {
+		t.Errorf("unable to write the test file: %v", err)
+	}
+	_, err = httpd.StartFolderQuotaScan(folder, http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to start folder quota scan: %v", err)
+	}
+	for {
+		scans, _, err := httpd.GetFoldersQuotaScans(http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get folder quota scans: %v", err)
+			break
+		}
+		if len(scans) == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	folder, _, err = httpd.GetFolderByID(folder.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get folder: %v", err)
+	}
+	if folder.UsedQuotaFiles != 1 || folder.UsedQuotaSize != int64(len("shared contents")) {
+		t.Errorf("unexpected folder quota, files: %v size: %v", folder.UsedQuotaFiles, folder.UsedQuotaSize)
+	}
+	_, err = httpd.StartFolderQuotaScan(dataprovider.Folder{Name: "missing"}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error starting a quota scan for a missing folder: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemoveFolder(folder, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove folder: %v", err)
+	}
+	_, err = httpd.RemoveFolder(folder, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error removing a missing folder: %v", err)
+	}
+	os.RemoveAll(mappedPath)
+}
+
+func TestUserFolderQuotaScan(t *testing.T) {
+	mappedPath := filepath.Join(homeBasePath, "shared_folder_scan")
+	folder, _, err := httpd.AddFolder(dataprovider.Folder{Name: "shared_scan", MappedPath: mappedPath},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add folder: %v", err)
+	}
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vshared",
+		Name:        folder.Name,
+	}, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+		MappedPath:  filepath.Join(homeBasePath, "vdir_scan"),
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	err = os.MkdirAll(mappedPath, 0700)
+	if err != nil {
+		t.Errorf("unable to create the folder mapped path: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(mappedPath, "file.txt"), []byte("shared contents"), 0600)
+	if err != nil {
+		t.Errorf("unable to write the test file: %v", err)
+	}
+	_, err = httpd.StartUserFolderQuotaScan(user.Username, "vshared/", http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to start user folder quota scan: %v", err)
+	}
+	for {
+		_, _, err := httpd.GetFolderQuotaScan(folder.Name, http.StatusNotFound)
+		if err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	folder, _, err = httpd.GetFolderByID(folder.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get folder: %v", err)
+	}
+	if folder.UsedQuotaFiles != 1 || folder.UsedQuotaSize != int64(len("shared contents")) {
+		t.Errorf("unexpected folder quota, files: %v size: %v", folder.UsedQuotaFiles, folder.UsedQuotaSize)
+	}
+	_, err = httpd.StartUserFolderQuotaScan(user.Username, "/vdir", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a virtual folder not referencing a shared folder cannot be scanned alone: %v", err)
+	}
+	_, err = httpd.StartUserFolderQuotaScan(user.Username, "/missing", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error starting a quota scan for a missing virtual folder: %v", err)
+	}
+	_, err = httpd.StartUserFolderQuotaScan(user.Username, "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the virtual path is mandatory: %v", err)
+	}
+	_, err = httpd.StartUserFolderQuotaScan("missing_user", "/vshared", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error starting a folder quota scan for a missing user: %v", err)
+	}
+	_, _, err = httpd.GetQuotaScan(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("no quota scan must be active for the user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemoveFolder(folder, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove folder: %v", err)
+	}
+	os.RemoveAll(mappedPath)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestUserDefaultsDiff(t *testing.T) {
+	plan := dataprovider.Plan{
+		Name:               "defaults_plan",
+		MaxSessions:        2,
+		QuotaSize:          1000,
+		DeniedLoginMethods: []string{dataprovider.SSHLoginMethodKeyboardInteractive, dataprovider.SSHLoginMethodPassword},
+	}
+	plan, _, err := httpd.AddPlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add plan: %v", err)
+	}
+	u := getTestUser()
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, _, err = httpd.GetUserDefaultsDiff(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("getting the defaults diff for a user without a plan must fail: %v", err)
+	}
+	_, _, err = httpd.ResetUserToDefaults(user, nil, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("resetting a user without a plan must fail: %v", err)
+	}
+	user.Plan = plan.Name
+	user.MaxSessions = plan.MaxSessions
+	user.QuotaSize = plan.QuotaSize
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	diff, _, err := httpd.GetUserDefaultsDiff(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get the defaults diff: %v", err)
+	}
+	if diff.Source != dataprovider.UserDefaultsSourcePlan || diff.Name != plan.Name || len(diff.Fields) != 0 {
+		t.Errorf("unexpected defaults diff: %+v", diff)
+	}
+	_, _, err = httpd.ResetUserToDefaults(user, []string{"username"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("resetting an unsupported field must fail: %v", err)
+	}
+	missingUser := user
+	missingUser.ID = user.ID + 1000
+	_, _, err = httpd.GetUserDefaultsDiff(missingUser, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting the defaults diff for a missing user: %v", err)
+	}
+	if providerDriverName == dataprovider.SQLiteDataProviderName {
+		// the plan is applied on each user update, so a user can deviate from the plan only if it is
+		// changed bypassing SFTPGo
+		providerConf := config.GetProviderConf()
+		db, err := sql.Open("sqlite3", filepath.Join(configDir, providerConf.Name))
+		if err != nil {
+			t.Errorf("unable to open the database: %v", err)
+		} else {
+			_, err = db.Exec(fmt.Sprintf("UPDATE %v SET max_sessions = 5, quota_size = 3000 WHERE username = ?",
+				providerConf.UsersTable), user.Username)
+			if err != nil {
+				t.Errorf("unable to update the user: %v", err)
+			}
+			db.Close()
+		}
+		diff, _, err = httpd.GetUserDefaultsDiff(user, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get the defaults diff: %v", err)
+		}
+		if len(diff.Fields) != 2 {
+			t.Errorf("unexpected defaults diff: %+v", diff)
+		}
+		diff, _, err = httpd.ResetUserToDefaults(user, []string{"quota_size"}, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to reset the user to the defaults: %v", err)
+		}
+		if len(diff.Fields) != 1 || diff.Fields[0].Field != "max_sessions" {
+			t.Errorf("only the requested field must be reset: %+v", diff)
+		}
+		diff, _, err = httpd.ResetUserToDefaults(user, nil, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to reset the user to the defaults: %v", err)
+		}
+		if len(diff.Fields) != 0 {
+			t.Errorf("all the deviating fields must be reset: %+v", diff)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get user: %v", err)
+		}
+		if user.MaxSessions != plan.MaxSessions || user.QuotaSize != plan.QuotaSize {
+			t.Errorf("the user was not reset to the defaults: %+v", user)
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove plan: %v", err)
+	}
+}
+
+func TestAddPlanInvalid(t *testing.T) {
+	invalidPlans := []dataprovider.Plan{
+		{Name: ""},
+		{Name: "invalid name"},
+		{Name: "invalid/name"},
+		{Name: "plan", QuotaSize: -1},
+		{Name: "plan", MaxSessions: -1},
+		{Name: "plan", AllowedFsProviders: []int{8}},
+		{Name: "plan", DeniedLoginMethods: []string{"invalid"}},
+		{Name: "plan", DeniedLoginMethods: dataprovider.ValidSSHLoginMethods},
+	}
+	for _, plan := range invalidPlans {
+		_, _, err := httpd.AddPlan(plan, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected error adding invalid plan %+v: %v", plan, err)
+		}
+	}
+}
+
+func TestStaleFilesReport(t *testing.T) {
+	u := getTestUser()
+	mappedPath := filepath.Join(os.TempDir(), "stale_mapped")
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+		MappedPath:  mappedPath,
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	staleTime := time.Now().Add(-100 * 24 * time.Hour)
+	files := []struct {
+		path  string
+		size  int64
+		stale bool
+	}{
+		{filepath.Join(user.GetHomeDir(), "file1"), 100, true},
+		{filepath.Join(user.GetHomeDir(), "dir", "sub", "file2"), 200, true},
+		{filepath.Join(user.GetHomeDir(), "dir", "file3"), 50, false},
+		{filepath.Join(mappedPath, "file4"), 300, true},
+	}
+	for _, f := range files {
+		err = createTestFile(f.path, f.size)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		if f.stale {
+			err = os.Chtimes(f.path, staleTime, staleTime)
+			if err != nil {
+				t.Errorf("unable to set file times: %v", err)
+			}
+		}
+	}
+	reports, _, err := httpd.GetStaleFilesReport(30, 2, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get stale files report: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Errorf("unexpected reports: %+v", reports)
+	} else {
+		report := reports[0]
+		if report.Username != user.Username || report.OlderThanDays != 30 || report.NumFiles != 3 || report.Size != 600 {
+			t.Errorf("unexpected report: %+v", report)
+		}
+		if len(report.Folders) != 3 || report.Folders[0].Path != "/vdir" || report.Folders[1].Path != "/dir" ||
+			report.Folders[1].Size != 200 || report.Folders[2].Path != "/" || report.Folders[2].NumFiles != 1 {
+			t.Errorf("unexpected folders: %+v", report.Folders)
+		}
+		if len(report.TopFiles) != 2 || report.TopFiles[0].Path != "/vdir/file4" ||
+			report.TopFiles[1].Path != "/dir/sub/file2" {
+			t.Errorf("unexpected top files: %+v", report.TopFiles)
+		}
+		if len(report.Error) > 0 {
+			t.Errorf("unexpected report error: %v", report.Error)
+		}
+	}
+	reports, _, err = httpd.GetStaleFilesReport(200, -1, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get stale files report: %v", err)
+	}
+	if len(reports) != 1 || reports[0].NumFiles != 0 || len(reports[0].TopFiles) != 0 {
+		t.Errorf("unexpected reports: %+v", reports)
+	}
+	reports, _, err = httpd.GetStaleFilesReport(-1, -1, "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get stale files report: %v", err)
+	}
+	if len(reports) == 0 {
+		t.Error("at least one report is expected")
+	}
+	_, _, err = httpd.GetStaleFilesReport(0, -1, "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.GetStaleFilesReport(-1, 1001, "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.GetStaleFilesReport(-1, -1, "missing_user", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.RemoveAll(mappedPath)
+}
+
+func TestUserActivity(t *testing.T) {
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	activity, _, err := httpd.GetUserActivity(user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user activity: %v", err)
+	}
+	if activity.Username != user.Username || len(activity.Buckets) != 0 {
+		t.Errorf("unexpected activity: %+v", activity)
+	}
+	_, _, err = httpd.GetUsersActivity(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users activity: %v", err)
+	}
+	_, _, err = httpd.GetUserActivity("missing_user", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestDuplicatesScan(t *testing.T) {
+	u := getTestUser()
+	mappedPath := filepath.Join(os.TempDir(), "duplicates_mapped")
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+		MappedPath:  mappedPath,
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, _, err = httpd.GetDuplicatesScan(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	contentA := make([]byte, 1000)
+	rand.Read(contentA)
+	contentB := make([]byte, 500)
+	rand.Read(contentB)
+	files := []struct {
+		path    string
+		content []byte
+	}{
+		{filepath.Join(user.GetHomeDir(), "file1"), contentA},
+		{filepath.Join(user.GetHomeDir(), "dir", "file2"), contentA},
+		{filepath.Join(mappedPath, "file3"), contentA},
+		{filepath.Join(user.GetHomeDir(), "b1"), contentB},
+		{filepath.Join(user.GetHomeDir(), "dir", "b2"), contentB},
+		{filepath.Join(user.GetHomeDir(), "empty1"), nil},
+		{filepath.Join(user.GetHomeDir(), "dir", "empty2"), nil},
+	}
+	for _, f := range files {
+		err = os.MkdirAll(filepath.Dir(f.path), 0777)
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = ioutil.WriteFile(f.path, f.content, 0666)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+	}
+	// same size as the first set but different contents
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "file4"), 1000)
+	if err != nil {
+		t.Errorf("unable to create test file: %v", err)
+	}
+	err = createTestFile(filepath.Join(user.GetHomeDir(), "single"), 200)
+	if err != nil {
+		t.Errorf("unable to create test file: %v", err)
+	}
+	_, err = httpd.StartDuplicatesScan(user, http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to start duplicates scan: %v", err)
+	}
+	scan := waitForDuplicatesScan(t, user.Username)
+	if scan.Status != httpd.DuplicatesScanCompleted || scan.ScannedFiles != 9 || scan.HashedFiles != 6 ||
+		scan.HashedSize != 5000 || scan.WastedSize != 2500 || scan.EndTime == 0 {
+		t.Errorf("unexpected scan: %+v", scan)
+	}
+	if len(scan.DuplicateSets) != 2 {
+		t.Errorf("unexpected duplicate sets: %+v", scan.DuplicateSets)
+	} else {
+		set := scan.DuplicateSets[0]
+		if set.Size != 1000 || len(set.Files) != 3 || set.Files[0] != "/dir/file2" || set.Files[1] != "/file1" ||
+			set.Files[2] != "/vdir/file3" || len(set.Hash) != 64 {
+			t.Errorf("unexpected duplicate set: %+v", set)
+		}
+		set = scan.DuplicateSets[1]
+		if set.Size != 500 || len(set.Files) != 2 || set.Files[0] != "/b1" || set.Files[1] != "/dir/b2" {
+			t.Errorf("unexpected duplicate set: %+v", set)
+		}
+	}
+	scans, _, err := httpd.GetDuplicatesScans(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get duplicates scans: %v", err)
+	}
+	found := false
+	for _, s := range scans {
+		if s.Username == user.Username {
+			found = true
+			if len(s.DuplicateSets) > 0 || s.WastedSize != 2500 {
+				t.Errorf("unexpected scan summary: %+v", s)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("scan for user %#v not found", user.Username)
+	}
+	// the results of a finished scan can be replaced
+	_, err = httpd.StartDuplicatesScan(user, http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to start duplicates scan: %v", err)
+	}
+	scan = waitForDuplicatesScan(t, user.Username)
+	if scan.Status != httpd.DuplicatesScanCompleted || scan.WastedSize != 2500 {
+		t.Errorf("unexpected scan: %+v", scan)
+	}
+	_, err = httpd.CancelDuplicatesScan(user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove duplicates scan: %v", err)
+	}
+	_, err = httpd.CancelDuplicatesScan(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.GetDuplicatesScan(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.StartDuplicatesScan(dataprovider.User{Username: "missing_user"}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.RemoveAll(mappedPath)
+}
+
+func TestSyncAPI(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	mappedPath := filepath.Join(os.TempDir(), "sync_mapped")
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+		MappedPath:  mappedPath,
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.MkdirAll(mappedPath, 0777)
+	_, _, err = httpd.GetSyncManifest(defaultUsername, "wrong", "/", false, http.StatusUnauthorized)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.GetSyncManifest("", "", "/", false, http.StatusUnauthorized)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	manifest, _, err := httpd.GetSyncManifest(defaultUsername, defaultPassword, "/", false, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get sync manifest: %v", err)
+	}
+	if len(manifest) != 1 || manifest[0].Path != "vdir" || manifest[0].Type != sftpd.SyncEntryTypeDir {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	_, err = httpd.CreateSyncDir(defaultUsername, defaultPassword, "/dir", http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to create dir: %v", err)
+	}
+	_, err = httpd.CreateSyncDir(defaultUsername, defaultPassword, "/dir", http.StatusCreated)
+	if err != nil {
+		t.Errorf("creating an existing dir must succeed: %v", err)
+	}
+	_, err = httpd.CreateSyncDir(defaultUsername, defaultPassword, "/vdir", http.StatusCreated)
+	if err != nil {
+		t.Errorf("creating an existing virtual folder must succeed: %v", err)
+	}
+	_, err = httpd.CreateSyncDir(defaultUsername, defaultPassword, "/missing/dir", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	content := []byte("sync file contents")
+	mtime := utils.GetTimeAsMsSinceEpoch(time.Now().Add(-24 * time.Hour))
+	_, err = httpd.UploadSyncFile(defaultUsername, defaultPassword, "/dir/file1", bytes.NewReader(content), mtime,
+		http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to upload file: %v", err)
+	}
+	_, err = httpd.UploadSyncFile(defaultUsername, defaultPassword, "/vdir/file2", bytes.NewReader(content), 0,
+		http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to upload file: %v", err)
+	}
+	_, err = httpd.UploadSyncFile(defaultUsername, defaultPassword, "/dir", bytes.NewReader(content), 0,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("uploading a file over a directory must fail: %v", err)
+	}
+	downloaded, err := httpd.DownloadSyncFile(defaultUsername, defaultPassword, "/vdir/file2", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to download file: %v", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("unexpected downloaded contents: %#v", string(downloaded))
+	}
+	_, err = httpd.DownloadSyncFile(defaultUsername, defaultPassword, "/dir", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("downloading a directory must fail: %v", err)
+	}
+	_, err = httpd.DownloadSyncFile(defaultUsername, defaultPassword, "/dir/missing", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.UsedQuotaFiles != 2 || user.UsedQuotaSize != 2*int64(len(content)) {
+		t.Errorf("unexpected quota, files: %v size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+	}
+	manifest, _, err = httpd.GetSyncManifest(defaultUsername, defaultPassword, "/", true, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get sync manifest: %v", err)
+	}
+	contentHash := fmt.Sprintf("%x", sha256.Sum256(content))
+	if len(manifest) != 4 {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	} else {
+		entry := manifest[1]
+		if entry.Path != "dir/file1" || entry.Type != sftpd.SyncEntryTypeFile || entry.Size != int64(len(content)) ||
+			entry.ModTime/1000 != mtime/1000 || entry.Hash != contentHash {
+			t.Errorf("unexpected manifest entry: %+v", entry)
+		}
+		if manifest[3].Path != "vdir/file2" || manifest[3].Hash != contentHash {
+			t.Errorf("unexpected manifest entry: %+v", manifest[3])
+		}
+	}
+	manifest, _, err = httpd.GetSyncManifest(defaultUsername, defaultPassword, "/dir", false, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get sync manifest: %v", err)
+	}
+	if len(manifest) != 1 || manifest[0].Path != "file1" || len(manifest[0].Hash) > 0 {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	_, _, err = httpd.GetSyncManifest(defaultUsername, defaultPassword, "/dir/file1", false, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.GetSyncManifest(defaultUsername, defaultPassword, "/missing", false, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	clientManifest := []sftpd.SyncManifestEntry{
+		{Path: "dir", Type: sftpd.SyncEntryTypeDir},
+		{Path: "dir/file1", Type: sftpd.SyncEntryTypeFile, Size: int64(len(content)), ModTime: mtime},
+		{Path: "dir/file3", Type: sftpd.SyncEntryTypeFile, Size: 10},
+		{Path: "dir/sub", Type: sftpd.SyncEntryTypeDir},
+		{Path: "vdir", Type: sftpd.SyncEntryTypeDir},
+		{Path: "vdir/file2", Type: sftpd.SyncEntryTypeFile, Size: int64(len(content)), Hash: contentHash},
+	}
+	plan, _, err := httpd.GetSyncPlan(defaultUsername, defaultPassword, "/", clientManifest, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get sync plan: %v", err)
+	}
+	if len(plan.CreateDirs) != 1 || plan.CreateDirs[0] != "dir/sub" || len(plan.Upload) != 1 ||
+		plan.Upload[0] != "dir/file3" || len(plan.Delete) != 0 {
+		t.Errorf("unexpected sync plan: %+v", plan)
+	}
+	clientManifest[1].ModTime = mtime + 5000
+	clientManifest[5].Hash = fmt.Sprintf("%x", sha256.Sum256([]byte("other contents")))
+	clientManifest = clientManifest[1:]
+	plan, _, err = httpd.GetSyncPlan(defaultUsername, defaultPassword, "/", clientManifest, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get sync plan: %v", err)
+	}
+	if len(plan.Upload) != 3 || len(plan.Delete) != 1 || plan.Delete[0] != "dir" {
+		t.Errorf("unexpected sync plan: %+v", plan)
+	}
+	_, _, err = httpd.GetSyncPlan(defaultUsername, defaultPassword, "/", []sftpd.SyncManifestEntry{
+		{Path: "../file", Type: sftpd.SyncEntryTypeFile},
+	}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.GetSyncPlan(defaultUsername, defaultPassword, "/", []sftpd.SyncManifestEntry{
+		{Path: "file", Type: "invalid"},
+	}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.RemoveSyncPath(defaultUsername, defaultPassword, "/dir", http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("removing a not empty dir must fail: %v", err)
+	}
+	_, err = httpd.RemoveSyncPath(defaultUsername, defaultPassword, "/vdir", http.StatusForbidden)
+	if err != nil {
+		t.Errorf("removing a virtual folder must fail: %v", err)
+	}
+	_, err = httpd.RemoveSyncPath(defaultUsername, defaultPassword, "/dir/file1", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove file: %v", err)
+	}
+	_, err = httpd.RemoveSyncPath(defaultUsername, defaultPassword, "/dir/file1", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.RemoveSyncPath(defaultUsername, defaultPassword, "/dir", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove dir: %v", err)
+	}
+	user.QuotaSize = int64(len(content)) + 5
+	user.Permissions["/vdir"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = httpd.UploadSyncFile(defaultUsername, defaultPassword, "/file4", bytes.NewReader(content), 0,
+		http.StatusInsufficientStorage)
+	if err != nil {
+		t.Errorf("upload must fail, quota exceeded: %v", err)
+	}
+	_, err = httpd.UploadSyncFile(defaultUsername, defaultPassword, "/vdir/file2", bytes.NewReader(content), 0,
+		http.StatusForbidden)
+	if err != nil {
+		t.Errorf("upload must fail, permission denied: %v", err)
+	}
+	_, err = httpd.RemoveSyncPath(defaultUsername, defaultPassword, "/vdir/file2", http.StatusForbidden)
+	if err != nil {
+		t.Errorf("remove must fail, permission denied: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != int64(len(content)) {
+		t.Errorf("unexpected quota, files: %v size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+	}
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPassword}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, _, err = httpd.GetSyncManifest(defaultUsername, defaultPassword, "/", false, http.StatusUnauthorized)
+	if err != nil {
+		t.Errorf("password login is denied, the sync API must be refused: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.RemoveAll(mappedPath)
+}
+
+func TestUserOverrides(t *testing.T) {
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	uploadBandwidth := int64(0)
+	quotaFiles := 100
+	override := dataprovider.UserOverride{
+		Username:        user.Username,
+		UploadBandwidth: &uploadBandwidth,
+		Duration:        3600,
+		Reason:          "test reason",
+	}
+	override, _, err = httpd.AddUserOverride(override, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user override: %v", err)
+	}
+	if override.ExpirationDate-override.CreatedAt != 3600*1000 {
+		t.Errorf("unexpected override expiration: %v created at: %v", override.ExpirationDate, override.CreatedAt)
+	}
+	override.QuotaFiles = &quotaFiles
+	override.UploadBandwidth = nil
+	_, _, err = httpd.AddUserOverride(override, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to replace user override: %v", err)
+	}
+	overrides, _, err := httpd.GetUserOverrides(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user overrides: %v", err)
+	}
+	if len(overrides) != 1 {
+		t.Errorf("unexpected number of user overrides: %v", len(overrides))
+	}
+	override, _, err = httpd.GetUserOverride(user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user override: %v", err)
+	}
+	if override.UploadBandwidth != nil || override.QuotaFiles == nil || *override.QuotaFiles != quotaFiles {
+		t.Errorf("the user override was not replaced: %+v", override)
+	}
+	u := user
+	if !dataprovider.ApplyUserOverride(&u) {
+		t.Errorf("the user override must be applied")
+	}
+	if u.QuotaFiles != quotaFiles || u.UploadBandwidth != user.UploadBandwidth {
+		t.Errorf("the user override was not correctly applied: %+v", u)
+	}
+	_, err = httpd.RemoveUserOverride(user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user override: %v", err)
+	}
+	_, err = httpd.RemoveUserOverride(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error removing a missing user override: %v", err)
+	}
+	_, _, err = httpd.GetUserOverride(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a missing user override: %v", err)
+	}
+	u = user
+	if dataprovider.ApplyUserOverride(&u) {
+		t.Errorf("a removed user override must not be applied")
+	}
+	override.Duration = 1
+	_, _, err = httpd.AddUserOverride(override, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user override: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	_, _, err = httpd.GetUserOverride(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("an expired user override must be removed: %v", err)
+	}
+	records, _, err := httpd.GetUserOverridesAudit(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user overrides audit records: %v", err)
+	}
+	var actions []string
+	for _, r := range records {
+		if r.Override.Username == user.Username {
+			actions = append(actions, r.Action)
+		}
+	}
+	expected := []string{dataprovider.OverrideActionAdd, dataprovider.OverrideActionReplace,
+		dataprovider.OverrideActionDelete, dataprovider.OverrideActionAdd, dataprovider.OverrideActionExpire}
+	if strings.Join(actions, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected audit records: %v", actions)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestAddUserOverrideInvalid(t *testing.T) {
+	quotaSize := int64(1000)
+	_, _, err := httpd.AddUserOverride(dataprovider.UserOverride{Username: "unknown user", QuotaSize: &quotaSize,
+		Duration: 60}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error adding an override for a missing user: %v", err)
+	}
+	_, _, err = httpd.AddUserOverride(dataprovider.UserOverride{QuotaSize: &quotaSize, Duration: 60}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an override without username: %v", err)
+	}
+	_, _, err = httpd.AddUserOverride(dataprovider.UserOverride{Username: defaultUsername, Duration: 60},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an override without limits: %v", err)
+	}
+	_, _, err = httpd.AddUserOverride(dataprovider.UserOverride{Username: defaultUsername, QuotaSize: &quotaSize},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an override without duration: %v", err)
+	}
+	negativeSize := int64(-1)
+	_, _, err = httpd.AddUserOverride(dataprovider.UserOverride{Username: defaultUsername, QuotaSize: &negativeSize,
+		Duration: 60}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an override with negative limits: %v", err)
+	}
+	_, _, err = httpd.AddUserOverride(dataprovider.UserOverride{Username: defaultUsername, QuotaSize: &quotaSize,
+		Duration: 60, Reason: strings.Repeat("a", 256)}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding an override with a too long reason: %v", err)
+	}
+}
+
+func TestHooksTest(t *testing.T) {
+	results, _, err := httpd.TestHooks("provider_actions", httpd.HookTestRequest{Event: "add"}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to test hooks: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("no hook is configured, unexpected results: %+v", results)
+	}
+	_, _, err = httpd.TestHooks("provider_actions", httpd.HookTestRequest{Event: "upload"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error testing hooks with an invalid event: %v", err)
+	}
+	_, _, err = httpd.TestHooks("actions", httpd.HookTestRequest{Event: "add"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error testing hooks with an invalid event: %v", err)
+	}
+	_, _, err = httpd.TestHooks("actions", httpd.HookTestRequest{Event: "upload"}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to test hooks: %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user dataprovider.User
+		if err := render.DecodeJSON(r.Body, &user); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "%v %v", r.URL.Query().Get("action"), user.Username)
+	}))
+	defer hookServer.Close()
+	hookCommand := filepath.Join(homeBasePath, "hook_test.sh")
+	err = ioutil.WriteFile(hookCommand, []byte("#!/bin/sh\n\necho \"$SFTPGO_USER_ACTION $SFTPGO_USER_USERNAME\"\n"), 0755)
+	if err != nil {
+		t.Errorf("unable to write the hook command: %v", err)
+	}
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.Actions.Command = hookCommand
+	providerConf.Actions.HTTPNotificationURL = hookServer.URL + "/hook"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with actions: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	results, _, err = httpd.TestHooks("provider_actions", httpd.HookTestRequest{Event: "update", Username: "hook_user"},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to test hooks: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("unexpected results: %+v", results)
+	} else {
+		if results[0].Type != dataprovider.HookTypeCommand || results[0].Hook != hookCommand ||
+			strings.TrimSpace(results[0].Response) != "update hook_user" || len(results[0].Error) > 0 {
+			t.Errorf("unexpected command result: %+v", results[0])
+		}
+		if results[1].Type != dataprovider.HookTypeHTTP || results[1].StatusCode != http.StatusAccepted ||
+			results[1].Response != "update hook_user" || len(results[1].Error) > 0 {
+			t.Errorf("unexpected HTTP result: %+v", results[1])
+		}
+	}
+	results, _, err = httpd.TestHooks("provider_actions", httpd.HookTestRequest{Event: "delete"}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to test hooks: %v", err)
+	}
+	if len(results) != 2 || results[1].Response != "delete sftpgo_hook_test" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	hookServer.Close()
+	results, _, err = httpd.TestHooks("provider_actions", httpd.HookTestRequest{Event: "add"}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to test hooks: %v", err)
+	}
+	if len(results) != 2 || len(results[1].Error) == 0 {
+		t.Errorf("unexpected results, the HTTP hook must fail: %+v", results)
+	}
+	os.Remove(hookCommand)
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestProviderMigration(t *testing.T) {
+	_, _, err := httpd.GetProviderMigrationReport(http.StatusForbidden)
+	if err != nil {
+		t.Errorf("the migration report must fail without a migration target: %v", err)
+	}
+	_, _, err = httpd.SyncProviderMigration(http.StatusForbidden)
+	if err != nil {
+		t.Errorf("the migration sync must fail without a migration target: %v", err)
+	}
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	targetPath := filepath.Join(homeBasePath, "sftpgo_migration_target.db")
+	os.Remove(targetPath)
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.MigrationTarget.Driver = dataprovider.BoltDataProviderName
+	providerConf.MigrationTarget.Name = targetPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with a migration target: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	report, _, err := httpd.GetProviderMigrationReport(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get the migration report: %v", err)
+	}
+	if report.Consistent || report.TargetDriver != dataprovider.BoltDataProviderName ||
+		!utils.IsStringInSlice(user.Username, report.Users.MissingInTarget) {
+		t.Errorf("unexpected migration report: %+v", report)
+	}
+	// the update is written to the migration target too, the missing user is added
+	user.MaxSessions = 5
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	u := getTestUser()
+	u.Username = defaultUsername + "_migration"
+	user1, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	report, _, err = httpd.GetProviderMigrationReport(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get the migration report: %v", err)
+	}
+	if utils.IsStringInSlice(user.Username, report.Users.MissingInTarget) ||
+		utils.IsStringInSlice(user.Username, report.Users.Different) ||
+		utils.IsStringInSlice(user1.Username, report.Users.MissingInTarget) ||
+		utils.IsStringInSlice(user1.Username, report.Users.Different) || report.FailedWrites != 0 {
+		t.Errorf("unexpected migration report: %+v", report)
+	}
+	report, _, err = httpd.SyncProviderMigration(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to sync the migration target: %v", err)
+	}
+	if !report.Consistent || report.Users.Source != report.Users.Target {
+		t.Errorf("the migration report must be consistent after the sync: %+v", report)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user1, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	report, _, err = httpd.GetProviderMigrationReport(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get the migration report: %v", err)
+	}
+	if !report.Consistent {
+		t.Errorf("the migration report must be consistent after removing the users: %+v", report)
+	}
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	os.Remove(targetPath)
+}
+
+func TestSQLProviderTLSFiles(t *testing.T) {
+	rootCert := filepath.Join(homeBasePath, "sftpgo_db_ca.crt")
+	err := ioutil.WriteFile(rootCert, []byte("not a PEM certificate"), 0600)
+	if err != nil {
+		t.Errorf("unable to write the root certificate: %v", err)
+	}
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.Driver = dataprovider.MySQLDataProviderName
+	providerConf.Host = "127.0.0.1"
+	providerConf.Port = 3306
+	providerConf.RootCert = rootCert
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("TLS certificates without sslmode must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.SSLMode = 3
+	providerConf.ConnectionString = "user:pass@tcp(127.0.0.1:3306)/sftpgo"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("TLS certificates with a custom connection string must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.ConnectionString = ""
+	providerConf.ClientCert = rootCert
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("a client certificate without a client key must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.ClientCert = ""
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil || !strings.Contains(err.Error(), "no valid PEM certificate") {
+		t.Errorf("an invalid root certificate must fail: %v", err)
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.Driver = dataprovider.PGSQLDataProviderName
+	providerConf.Port = 5432
+	providerConf.RootCert = "missing_db_ca.crt"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(configDir, "missing_db_ca.crt")) {
+		t.Errorf("a missing root certificate must fail, the path must be relative to the config dir: %v", err)
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	os.Remove(rootCert)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestSQLiteProviderKeyFile(t *testing.T) {
+	if utils.IsStringInSlice("+sqlcipher", utils.GetAppVersion().Features) {
+		t.Skip("this test requires a build without SQLCipher support")
+	}
+	keyFile := filepath.Join(homeBasePath, "sftpgo_db.key")
+	err := ioutil.WriteFile(keyFile, []byte("passphrase"), 0600)
+	if err != nil {
+		t.Errorf("unable to write the key file: %v", err)
+	}
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.Driver = dataprovider.SQLiteDataProviderName
+	providerConf.Name = filepath.Join(homeBasePath, "sftpgo_encrypted.db")
+	providerConf.SQLiteKeyFile = keyFile
+	providerConf.ConnectionString = "file:" + providerConf.Name
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("a key file with a custom connection string must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.ConnectionString = ""
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil || !strings.Contains(err.Error(), "sqlcipher") {
+		t.Errorf("a key file must fail without SQLCipher support: %v", err)
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	os.Remove(keyFile)
+	os.Remove(providerConf.Name)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestInactivityPolicy(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	var users []dataprovider.User
+	for _, inactiveDays := range []int64{100, 70, 10} {
+		u := getTestUser()
+		u.Username = fmt.Sprintf("inactive_user%v", inactiveDays)
+		u.CreatedAt = now - inactiveDays*day
+		user, _, err := httpd.AddUser(u, http.StatusOK)
+		if err != nil {
+			t.Fatalf("unable to add user: %v", err)
+		}
+		users = append(users, user)
+	}
+	getReport := func(query string, expectedStatusCode int) dataprovider.InactivityReport {
+		var report dataprovider.InactivityReport
+		req, _ := http.NewRequest(http.MethodGet, inactiveUsersPath+query, nil)
+		rr := executeRequest(req)
+		checkResponseCode(t, expectedStatusCode, rr.Code)
+		if expectedStatusCode == http.StatusOK {
+			err := render.DecodeJSON(rr.Body, &report)
+			if err != nil {
+				t.Errorf("unable to decode the report: %v", err)
+			}
+		}
+		return report
+	}
+	getReportUsers := func(report dataprovider.InactivityReport) map[string]dataprovider.InactiveUser {
+		result := make(map[string]dataprovider.InactiveUser)
+		for _, u := range report.Users {
+			if strings.HasPrefix(u.Username, "inactive_user") {
+				result[u.Username] = u
+			}
+		}
+		return result
+	}
+	// the policy is disabled by default
+	getReport("", http.StatusBadRequest)
+	getReport("?inactive_days=30&grace_days=30", http.StatusBadRequest)
+	getReport("?inactive_days=30&action=2", http.StatusBadRequest)
+	getReport("?inactive_days=a", http.StatusBadRequest)
+	report := getReport("?inactive_days=90&grace_days=30&action=1", http.StatusOK)
+	reportUsers := getReportUsers(report)
+	if len(reportUsers) != 2 || reportUsers[users[0].Username].Action != dataprovider.InactivityReportExpire ||
+		reportUsers[users[0].Username].InactiveDays != 100 || reportUsers[users[1].Username].Action != dataprovider.InactivityReportWarn {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if report.Policy.InactiveDays != 90 || report.GeneratedAt <= 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	// the report must not modify the users
+	user, _, err := httpd.GetUserByID(users[0].ID, http.StatusOK)
+	if err != nil || user.ExpirationDate != 0 || user.Status != 1 {
+		t.Errorf("unexpected user: %+v, error: %v", user, err)
+	}
+	// a recent login makes the user active again
+	err = dataprovider.UpdateLastLogin(dataprovider.GetProvider(), users[1])
+	if err != nil {
+		t.Errorf("unable to update the last login: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.InactivityPolicy.InactiveDays = 30
+	providerConf.InactivityPolicy.GraceDays = 30
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("an invalid inactivity policy must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.InactivityPolicy.InactiveDays = 90
+	providerConf.InactivityPolicy.GraceDays = 0
+	providerConf.InactivityPolicy.Action = dataprovider.InactivityActionExpire
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Fatalf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	// the first check runs at startup
+	for i := 0; i < 50; i++ {
+		user, _, err = httpd.GetUserByID(users[0].ID, http.StatusOK)
+		if err != nil || user.ExpirationDate > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil || user.ExpirationDate < now || user.Status != 1 {
+		t.Errorf("the inactive user must be expired: %+v, error: %v", user, err)
+	}
+	for _, u := range users[1:] {
+		user, _, err = httpd.GetUserByID(u.ID, http.StatusOK)
+		if err != nil || user.ExpirationDate != 0 {
+			t.Errorf("the active user must not be modified: %+v, error: %v", user, err)
+		}
+	}
+	report = getReport("", http.StatusOK)
+	if len(getReportUsers(report)) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	report = getReport("?inactive_days=80&grace_days=75&action=0", http.StatusOK)
+	reportUsers = getReportUsers(report)
+	if len(reportUsers) != 1 || reportUsers[users[2].Username].Action != dataprovider.InactivityReportWarn {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	for _, u := range users {
+		_, err = httpd.RemoveUser(u, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+}
+
+func TestMemoryProviderPersistence(t *testing.T) {
+	usersFile := filepath.Join(homeBasePath, "sftpgo_memory_users.json")
+	os.Remove(usersFile)
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.Driver = dataprovider.MemoryDataProviderName
+	providerConf.Name = usersFile
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("the memory provider must fail if the users file does not exist and the persistence is disabled")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.MemoryPersistence.Enabled = true
+	providerConf.MemoryPersistence.Interval = -1
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("a negative persistence interval must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.MemoryPersistence.Interval = 1
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing the memory provider with persistence: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	err = dataprovider.UpdateUserQuota(dataprovider.GetProvider(), user, 2, 100, true)
+	if err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	entry, _, err := httpd.AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "172.16.1.0/24", Type: dataprovider.IPListTypeBlock},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	// wait for the periodic save
+	time.Sleep(1500 * time.Millisecond)
+	content, err := ioutil.ReadFile(usersFile)
+	if err != nil {
+		t.Errorf("the users file must be saved periodically: %v", err)
+	}
+	var dump dataprovider.BackupData
+	err = json.Unmarshal(content, &dump)
+	if err != nil || len(dump.Users) != 1 || len(dump.IPListEntries) != 1 {
+		t.Errorf("unexpected saved data: %+v, error: %v", dump, err)
+	}
+	_, err = httpd.RemoveIPListEntry(entry, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	err = dataprovider.Flush()
+	if err != nil {
+		t.Errorf("unable to flush the memory provider: %v", err)
+	}
+	// the data are saved on close too
+	err = dataprovider.UpdateUserQuota(dataprovider.GetProvider(), user, 3, 150, true)
+	if err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing the memory provider with persistence: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	users, _, err := httpd.GetUsers(0, 0, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 || users[0].UsedQuotaFiles != 3 || users[0].UsedQuotaSize != 150 {
+		t.Errorf("the user and the quota usage must be loaded from the saved file: %+v", users)
+	}
+	entries, _, err := httpd.GetIPListEntries(0, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get IP list entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("the removed IP list entry must not be loaded: %+v", entries)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	os.Remove(usersFile)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestSQLUsersCache(t *testing.T) {
+	if providerDriverName != dataprovider.SQLiteDataProviderName {
+		t.Skip("this test requires the sqlite data provider")
+	}
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.UsersCache.TTL = -1
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("a negative users cache ttl must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.UsersCache.TTL = 60
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with the users cache: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, err = dataprovider.CheckUserAndPass(dataprovider.GetProvider(), user.Username, defaultPassword)
+	if err != nil {
+		t.Errorf("unable to check user and password: %v", err)
+	}
+	// disable the user bypassing the data provider, the cached user is still enabled
+	db, err := sql.Open("sqlite3", filepath.Join(configDir, providerConf.Name))
+	if err != nil {
+		t.Errorf("unable to open the database: %v", err)
+	} else {
+		_, err = db.Exec(fmt.Sprintf("UPDATE %v SET status = 0 WHERE username = ?", providerConf.UsersTable), user.Username)
+		if err != nil {
+			t.Errorf("unable to update the user: %v", err)
+		}
+		db.Close()
+	}
+	cachedUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if cachedUser.Status != 1 {
+		t.Error("the user must be read from the cache")
+	}
+	// the internal updates are applied to the cached user
+	err = dataprovider.UpdateUserQuota(dataprovider.GetProvider(), user, 5, 1000, true)
+	if err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	cachedUser, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if cachedUser.Status != 1 || cachedUser.UsedQuotaFiles != 5 || cachedUser.UsedQuotaSize != 1000 {
+		t.Errorf("unexpected cached user: %+v", cachedUser)
+	}
+	// an update using the REST API invalidates the cached user
+	user.Status = 1
+	user.Password = "new password"
+	_, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = dataprovider.CheckUserAndPass(dataprovider.GetProvider(), user.Username, defaultPassword)
+	if err == nil {
+		t.Error("the old password must be rejected after the update")
+	}
+	_, err = dataprovider.CheckUserAndPass(dataprovider.GetProvider(), user.Username, "new password")
+	if err != nil {
+		t.Errorf("unable to check user and password: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err == nil {
+		t.Error("a removed user must not be read from the cache")
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestSQLReadReplica(t *testing.T) {
+	if providerDriverName != dataprovider.SQLiteDataProviderName {
+		t.Skip("this test requires the sqlite data provider")
+	}
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	// the replica is a copy of the database, the changes made to the primary are not replicated
+	replicaPath := filepath.Join(os.TempDir(), "replica.db")
+	data, err := ioutil.ReadFile(filepath.Join(configDir, providerConf.Name))
+	if err != nil {
+		t.Errorf("unable to read the database: %v", err)
+	}
+	err = ioutil.WriteFile(replicaPath, data, 0600)
+	if err != nil {
+		t.Errorf("unable to write the replica database: %v", err)
+	}
+	providerConf.ReadReplicaConnectionString = fmt.Sprintf("file:%v?cache=shared", replicaPath)
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with a read replica: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	user.Password = "new password"
+	user.MaxSessions = 10
+	_, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	// the REST API reads a single user from the primary
+	updatedUser, _, err := httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if updatedUser.MaxSessions != 10 {
+		t.Errorf("the user must be read from the primary database, max sessions: %v", updatedUser.MaxSessions)
+	}
+	// logins and listings are served by the replica
+	_, err = dataprovider.CheckUserAndPass(dataprovider.GetProvider(), user.Username, defaultPassword)
+	if err != nil {
+		t.Errorf("the login must use the replica: %v", err)
+	}
+	users, _, err := httpd.GetUsers(0, 0, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 || users[0].MaxSessions == 10 {
+		t.Errorf("the users listing must use the replica: %+v", users)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	os.Remove(replicaPath)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestS3TenantHelpers(t *testing.T) {
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.S3Tenants.KeyPrefix = "/tenants/%username%/"
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("an invalid S3 tenants key prefix must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	providerConf.S3Tenants.KeyPrefix = "tenants/%username%/"
+	policyFile := filepath.Join(os.TempDir(), "s3tenantpolicy.json")
+	providerConf.S3Tenants.PolicyFile = policyFile
+	err = ioutil.WriteFile(policyFile, []byte(`["%bucket%"]`), 0600)
+	if err != nil {
+		t.Errorf("unable to write policy file: %v", err)
+	}
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("a policy statement that is not a JSON object must fail")
+		dataprovider.Close(dataprovider.GetProvider())
+	}
+	err = ioutil.WriteFile(policyFile, []byte(`{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::123456789012:user/%username%"},`+
+		`"Action":"s3:*","Resource":"arn:aws:s3:::%bucket%/%prefix%*"}`), 0600)
+	if err != nil {
+		t.Errorf("unable to write policy file: %v", err)
+	}
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with a valid policy file: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	os.Remove(policyFile)
+	// the helpers requiring network access are not enabled, the isolation check fails
+	// before any request since the user has no credentials
+	providerConf.S3Tenants.PolicyFile = ""
+	providerConf.S3Tenants.VerifyIsolation = true
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with S3 tenants helpers: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	user := getTestUser()
+	user.FsConfig.Provider = 1
+	user.FsConfig.S3Config.Bucket = "test"
+	user.FsConfig.S3Config.Region = "us-east-1"
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err = render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("unable to decode user: %v", err)
+	}
+	if user.FsConfig.S3Config.KeyPrefix != "tenants/"+user.Username+"/" {
+		t.Errorf("unexpected key prefix %#v", user.FsConfig.S3Config.KeyPrefix)
+	}
+	warnings := rr.Header()["X-Sftpgo-Warning"]
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "no credentials") {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	user.FsConfig.S3Config.KeyPrefix = "other/%username%/"
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if len(rr.Header()["X-Sftpgo-Warning"]) != 1 {
+		t.Errorf("the helpers must be executed if the key prefix changes, warnings: %v", rr.Header()["X-Sftpgo-Warning"])
+	}
+	updatedUser, _, err := httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if updatedUser.FsConfig.S3Config.KeyPrefix != "other/"+user.Username+"/" {
+		t.Errorf("unexpected key prefix %#v", updatedUser.FsConfig.S3Config.KeyPrefix)
+	}
+	user.MaxSessions = 2
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if len(rr.Header()["X-Sftpgo-Warning"]) != 0 {
+		t.Errorf("the helpers must not be executed if the S3 config does not change, warnings: %v",
+			rr.Header()["X-Sftpgo-Warning"])
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestHTTPDataProvider(t *testing.T) {
+	secret := "http provider secret"
+	users := make(map[string]dataprovider.User)
+	var lastID int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		operation := strings.TrimPrefix(r.URL.Path, "/sftpgo/")
+		err := dataprovider.VerifyHTTPProviderRequest([]byte(secret), operation, r.Header, body, time.Minute)
+		if err != nil {
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+			return
+		}
+		var req struct {
+			Username string `json:"username"`
+			ID       int64  `json:"id"`
+		}
+		var user dataprovider.User
+		json.Unmarshal(body, &req)
+		json.Unmarshal(body, &user)
+		switch operation {
+		case "check_availability":
+			w.WriteHeader(http.StatusNoContent)
+		case "add_user":
+			if _, ok := users[user.Username]; ok {
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, map[string]string{"error": "duplicated username"})
+				return
+			}
+			lastID++
+			user.ID = lastID
+			users[user.Username] = user
+			w.WriteHeader(http.StatusNoContent)
+		case "update_user":
+			users[user.Username] = user
+			w.WriteHeader(http.StatusNoContent)
+		case "delete_user":
+			delete(users, user.Username)
+			w.WriteHeader(http.StatusNoContent)
+		case "user_exists", "get_user_by_id", "get_users":
+			for _, u := range users {
+				if u.Username == req.Username || u.ID == req.ID {
+					if operation == "get_users" {
+						render.JSON(w, r, []dataprovider.User{u})
+					} else {
+						render.JSON(w, r, u)
+					}
+					return
+				}
+			}
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, map[string]string{"error": "user not found"})
+		default:
+			render.Status(r, http.StatusNotImplemented)
+			render.JSON(w, r, map[string]string{"error": "not implemented"})
+		}
+	}))
+	defer server.Close()
+
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.Driver = dataprovider.HTTPDataProviderName
+	providerConf.ConnectionString = server.URL + "/sftpgo/"
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("the http provider must fail to initialize without a secret")
+	}
+	providerConf.Password = "wrong secret"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("the http provider must fail to initialize if the requests are not accepted")
+	}
+	providerConf.Password = secret
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing the http provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	if stored, ok := users[user.Username]; !ok || stored.Password == defaultPassword || len(stored.Password) == 0 {
+		t.Errorf("the user must be sent to the external service with an hashed password: %+v", stored)
+	}
+	_, _, err = httpd.AddUser(getTestUser(), http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("adding a duplicated user must fail: %v", err)
+	}
+	user.MaxSessions = 10
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	if user.MaxSessions != 10 {
+		t.Errorf("the user was not updated: %+v", user)
+	}
+	usersList, _, err := httpd.GetUsers(0, 0, defaultUsername, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(usersList) != 1 || len(usersList[0].Password) > 0 {
+		t.Errorf("unexpected users: %+v", usersList)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, _, err = httpd.GetUserByID(user.ID, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("the removed user must not be found: %v", err)
+	}
+	_, _, err = httpd.GetPlans(http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("unexpected error for an operation not implemented by the external service: %v", err)
+	}
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestUserOffboarding(t *testing.T) {
+	u := getTestUser()
+	u.PublicKeys = []string{testPubKey}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, _, err = httpd.GetOffboarding(user.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = httpd.StartOffboarding(httpd.OffboardingRequest{Username: user.Username, ArchiveHome: true},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the home cannot be archived without a configured bucket: %v", err)
+	}
+	_, err = httpd.StartOffboarding(httpd.OffboardingRequest{Username: "missing_user"}, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error offboarding a missing user: %v", err)
+	}
+	_, err = httpd.StartOffboarding(httpd.OffboardingRequest{Username: user.Username, Reason: "test"}, http.StatusCreated)
+	if err != nil {
+		t.Errorf("unable to start the offboarding: %v", err)
+	}
+	offboarding := waitForOffboarding(t, user.Username)
+	if offboarding.Status != httpd.OffboardingCompleted || offboarding.Reason != "test" ||
+		len(offboarding.RevokedKeys) != 1 || len(offboarding.Archive) > 0 {
+		t.Errorf("unexpected offboarding: %+v", offboarding)
+	}
+	offboardings, _, err := httpd.GetOffboardings(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get the offboardings: %v", err)
+	}
+	if len(offboardings) != 1 || offboardings[0].Username != user.Username {
+		t.Errorf("unexpected offboardings: %+v", offboardings)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.Status != 0 || !utils.IsStringInSlice(offboarding.RevokedKeys[0], user.Filters.RevokedKeyFingerprints) {
+		t.Errorf("the user must be disabled and its public keys revoked: %+v", user)
+	}
+	snapshotPath := filepath.Join(backupsPath, offboarding.Snapshot)
+	content, err := ioutil.ReadFile(snapshotPath)
+	if err != nil {
+		t.Errorf("unable to read the configuration snapshot: %v", err)
+	}
+	var snapshot dataprovider.BackupData
+	err = json.Unmarshal(content, &snapshot)
+	if err != nil {
+		t.Errorf("unable to parse the configuration snapshot: %v", err)
+	}
+	if len(snapshot.Users) != 1 || snapshot.Users[0].Status != 1 || len(snapshot.Users[0].Password) == 0 {
+		t.Errorf("unexpected configuration snapshot: %+v", snapshot)
+	}
+	// the snapshot can be restored using loaddata
+	_, _, err = httpd.Loaddata(snapshotPath, "0", "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to restore the configuration snapshot: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.Status != 1 || len(user.Filters.RevokedKeyFingerprints) > 0 {
+		t.Errorf("the user was not restored: %+v", user)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.Remove(snapshotPath)
+}
+
+func TestUserBaseDir(t *testing.T) {
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.UsersBaseDir = homeBasePath
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider with users base dir: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	u := getTestUser()
+	u.HomeDir = ""
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	if user.HomeDir != filepath.Join(providerConf.UsersBaseDir, u.Username) {
+		t.Errorf("invalid home dir: %v", user.HomeDir)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	os.RemoveAll(credentialsPath)
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestProviderErrors(t *testing.T) {
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	_, _, err := httpd.GetUserByID(0, http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("get user with provider closed must fail: %v", err)
+	}
+	_, _, err = httpd.GetUsers(1, 0, defaultUsername, http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("get users with provider closed must fail: %v", err)
+	}
+	_, _, err = httpd.UpdateUser(dataprovider.User{}, http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("update user with provider closed must fail: %v", err)
+	}
+	_, err = httpd.RemoveUser(dataprovider.User{}, http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("delete user with provider closed must fail: %v", err)
+	}
+	_, _, err = httpd.GetProviderStatus(http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("get provider status with provider closed must fail: %v", err)
+	}
+	response, _, err := httpd.GetHealthStatus(http.StatusServiceUnavailable)
+	if err != nil {
+		t.Errorf("get health status with provider closed must fail: %v", err)
+	}
+	if response["message"] != "data provider unavailable" || response["error"] != "data provider unavailable" {
+		t.Errorf("unexpected health response: %+v", response)
+	}
+	_, _, err = httpd.Dumpdata("backup.json", "", http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("get provider status with provider closed must fail: %v", err)
+	}
+	user := getTestUser()
+	user.ID = 1
+	backupData := dataprovider.BackupData{}
+	backupData.Users = append(backupData.Users, user)
+	backupContent, _ := json.Marshal(backupData)
+	backupFilePath := filepath.Join(backupsPath, "backup.json")
+	ioutil.WriteFile(backupFilePath, backupContent, 0666)
+	_, _, err = httpd.Loaddata(backupFilePath, "", "", http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("get provider status with provider closed must fail: %v", err)
+	}
+	os.Remove(backupFilePath)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	os.RemoveAll(credentialsPath)
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestPasswordHashingConfig(t *testing.T) {
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.PasswordHashing.Algo = "md5"
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("unsupported hashing algorithm must fail")
+	}
+	providerConf.PasswordHashing.Algo = dataprovider.HashingAlgoBcrypt
+	providerConf.PasswordHashing.BcryptCost = 32
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("invalid bcrypt cost must fail")
+	}
+	providerConf.PasswordHashing.BcryptCost = 4
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	dbUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if !strings.HasPrefix(dbUser.Password, "$2a$04$") {
+		t.Errorf("unexpected password hash: %#v", dbUser.Password)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestPasswordBreachCheck(t *testing.T) {
+	sum := sha1.Sum([]byte(defaultPassword))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	numRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests++
+		if r.URL.Path != "/range/"+hash[:5] || r.Header.Get("Add-Padding") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%v:3\r\n%v:0\r\n", hash[5:], strings.Repeat("0", 35))
+	}))
+	defer server.Close()
+
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	providerConf.PasswordBreachCheck.Mode = 3
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("invalid password breach check mode must fail")
+	}
+	providerConf.PasswordBreachCheck.Mode = 2
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("offline mode without a hashes file must fail")
+	}
+	providerConf.PasswordBreachCheck.Mode = 1
+	providerConf.PasswordBreachCheck.APIURL = server.URL + "/range/"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	_, _, err = httpd.AddUser(getTestUser(), http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a breached password must be rejected: %v", err)
+	}
+	_, _, err = httpd.AddUser(getTestUser(), http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a breached password must be rejected: %v", err)
+	}
+	if numRequests != 1 {
+		t.Errorf("the range API response must be cached, requests: %v", numRequests)
+	}
+	u := getTestUser()
+	u.Password = ""
+	u.PublicKeys = []string{testPubKey}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user.Password = defaultPassword
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a breached password must be rejected: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// the plans can disable the check or reject the passwords if the check cannot be done
+	plan := dataprovider.Plan{Name: "breach_plan", PasswordBreachCheck: 3}
+	_, _, err = httpd.AddPlan(plan, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding a plan with an invalid password breach check policy: %v", err)
+	}
+	plan.PasswordBreachCheck = 1
+	plan, _, err = httpd.AddPlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add plan: %v", err)
+	}
+	u = getTestUser()
+	u.Plan = plan.Name
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("the password breach check must be disabled for the plan users: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// the range API returns an error for this password
+	u.Password = "a password with a different hash prefix"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("the password must be accepted if the check cannot be done: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	plan.PasswordBreachCheck = 2
+	plan, _, err = httpd.UpdatePlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update plan: %v", err)
+	}
+	_, _, err = httpd.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("the password must be rejected if the check cannot be done for a strict plan: %v", err)
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove plan: %v", err)
+	}
+
+	dataprovider.Close(dataprovider.GetProvider())
+	hashesFile := filepath.Join(os.TempDir(), "pwned_hashes.txt")
+	hashes := []string{hash + ":3", strings.Repeat("0", 40) + ":10", strings.Repeat("F", 40) + ":1",
+		strings.Repeat("8", 40) + ":2", strings.Repeat("3", 40) + ":7"}
+	sort.Strings(hashes)
+	err = ioutil.WriteFile(hashesFile, []byte(strings.Join(hashes, "\r\n")+"\r\n"), 0666)
+	if err != nil {
+		t.Errorf("unable to write the hashes file: %v", err)
+	}
+	providerConf.PasswordBreachCheck.Mode = 2
+	providerConf.PasswordBreachCheck.HashesFile = hashesFile
+	providerConf.PasswordBreachCheck.MinOccurrences = 4
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	// the password appears 3 times, less than the min occurrences
+	user, _, err = httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	dataprovider.Close(dataprovider.GetProvider())
+	providerConf.PasswordBreachCheck.MinOccurrences = 0
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	_, _, err = httpd.AddUser(getTestUser(), http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a breached password must be rejected: %v", err)
+	}
+	u = getTestUser()
+	u.Password = "a password not included in the hashes file"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.Remove(hashesFile)
+
+	dataprovider.Close(dataprovider.GetProvider())
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestDumpdata(t *testing.T) {
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider:_%v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	_, _, err = httpd.Dumpdata("", "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Dumpdata(filepath.Join(backupsPath, "backup.json"), "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Dumpdata("../backup.json", "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Dumpdata("backup.json", "0", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Dumpdata("backup.json", "1", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Remove(filepath.Join(backupsPath, "backup.json"))
+	if runtime.GOOS != "windows" {
+		os.Chmod(backupsPath, 0001)
+		_, _, err = httpd.Dumpdata("bck.json", "", http.StatusInternalServerError)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		os.Chmod(backupsPath, 0755)
+	}
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	providerConf.CredentialsPath = credentialsPath
+	os.RemoveAll(credentialsPath)
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestLoaddata(t *testing.T) {
+	user := getTestUser()
+	user.ID = 1
+	user.Username = "test_user_restore"
+	backupData := dataprovider.BackupData{}
+	backupData.Users = append(backupData.Users, user)
+	backupContent, _ := json.Marshal(backupData)
+	backupFilePath := filepath.Join(backupsPath, "backup.json")
+	ioutil.WriteFile(backupFilePath, backupContent, 0666)
+	_, _, err := httpd.Loaddata(backupFilePath, "a", "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Loaddata(backupFilePath, "", "a", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Loaddata("backup.json", "1", "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.Loaddata(backupFilePath+"a", "1", "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		os.Chmod(backupFilePath, 0111)
+		_, _, err = httpd.Loaddata(backupFilePath, "1", "", http.StatusInternalServerError)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		os.Chmod(backupFilePath, 0644)
+	}
+	// add user from backup
+	_, _, err = httpd.Loaddata(backupFilePath, "1", "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// update user from backup
+	_, _, err = httpd.Loaddata(backupFilePath, "2", "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	users, _, err := httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Error("Unable to get restored user")
+	}
+	user = users[0]
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.Remove(backupFilePath)
+	createTestFile(backupFilePath, 10485761)
+	_, _, err = httpd.Loaddata(backupFilePath, "1", "0", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Remove(backupFilePath)
+	createTestFile(backupFilePath, 65535)
+	_, _, err = httpd.Loaddata(backupFilePath, "1", "0", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Remove(backupFilePath)
+}
+
+func TestLoaddataFromBody(t *testing.T) {
+	user := getTestUser()
+	user.Username = "test_user_upload"
+	user.Plan = "restore_plan"
+	backupData := dataprovider.BackupData{}
+	backupData.Users = append(backupData.Users, user)
+	backupData.Plans = append(backupData.Plans, dataprovider.Plan{
+		Name:        "restore_plan",
+		MaxSessions: 2,
+	})
+	// the users are before the plans inside the JSON backup
+	backupContent, _ := json.Marshal(backupData)
+	_, _, err := httpd.LoaddataFromBody(bytes.NewBuffer(backupContent), "", "0", "0", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to restore the uploaded backup: %v", err)
+	}
+	users, _, err := httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil || len(users) != 1 {
+		t.Errorf("unable to get the restored user: %v", err)
+	} else {
+		if users[0].Plan != "restore_plan" || users[0].MaxSessions != 2 {
+			t.Errorf("unexpected restored user: %+v", users[0])
+		}
+		_, err = httpd.RemoveUser(users[0], http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+	plans, _, err := httpd.GetPlans(http.StatusOK)
+	if err != nil || len(plans) != 1 {
+		t.Errorf("unable to get the restored plan: %v", err)
+	} else {
+		_, err = httpd.RemovePlan(plans[0], http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove plan: %v", err)
+		}
+	}
+	csvContent := "username,password,home_dir,permissions\n" + user.Username + ",password,/tmp/upload,/=*\n"
+	_, _, err = httpd.LoaddataFromBody(strings.NewReader(csvContent), "csv", "0", "0", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to restore the uploaded CSV backup: %v", err)
+	}
+	users, _, err = httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil || len(users) != 1 {
+		t.Errorf("unable to get the user restored from CSV: %v", err)
+	} else {
+		_, err = httpd.RemoveUser(users[0], http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+	// a malformed JSON backup is detected before restoring anything
+	invalidContent := strings.Replace(string(backupContent), `"plans":[`, `"plans":[{"name":1},`, 1)
+	_, _, err = httpd.LoaddataFromBody(strings.NewReader(invalidContent), "", "0", "0", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.LoaddataFromBody(strings.NewReader(string(backupContent)+"}"), "", "0", "0", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	users, _, err = httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil || len(users) != 0 {
+		t.Errorf("no user must be restored from an invalid backup: %v", err)
+	}
+	_, _, err = httpd.LoaddataFromBody(strings.NewReader("{}"), "xml", "0", "0", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = httpd.LoaddataFromBody(strings.NewReader("{}"), "", "a", "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoaddataMode(t *testing.T) {
+	user := getTestUser()
+	user.ID = 1
+	user.Username = "test_user_restore"
+	backupData := dataprovider.BackupData{}
+	backupData.Users = append(backupData.Users, user)
+	backupContent, _ := json.Marshal(backupData)
+	backupFilePath := filepath.Join(backupsPath, "backup.json")
+	ioutil.WriteFile(backupFilePath, backupContent, 0666)
+	_, _, err := httpd.Loaddata(backupFilePath, "0", "0", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	users, _, err := httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Error("Unable to get restored user")
+	}
+	user = users[0]
+	oldUploadBandwidth := user.UploadBandwidth
+	user.UploadBandwidth = oldUploadBandwidth + 128
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, _, err = httpd.Loaddata(backupFilePath, "0", "1", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	users, _, err = httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Error("Unable to get restored user")
+	}
+	user = users[0]
+	if user.UploadBandwidth == oldUploadBandwidth {
+		t.Error("user must not be modified")
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.Remove(backupFilePath)
+}
+
+func TestLoaddataFormats(t *testing.T) {
+	user := getTestUser()
+	user.Username = "test_user_formats"
+	user.QuotaFiles = 100
+	user.Permissions["/sub"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user.Filters.AllowedIP = []string{"192.168.1.0/24"}
+	user, _, err := httpd.AddUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, dumpDataPath+"?output_file=backup.json&format=xml", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	// YAML contains all the user fields
+	_, _, err = httpd.Dumpdata("backup.yaml", "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	backupFilePath := filepath.Join(backupsPath, "backup.yaml")
+	content, err := ioutil.ReadFile(backupFilePath)
+	if err != nil {
+		t.Errorf("unable to read the YAML dump: %v", err)
+	}
+	if !strings.Contains(string(content), "username: "+user.Username) {
+		t.Errorf("unexpected YAML dump: %v", string(content))
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, _, err = httpd.Loaddata(backupFilePath, "0", "0", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	users, _, err := httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil || len(users) != 1 {
+		t.Errorf("unable to get the restored user: %v", err)
+	} else {
+		if users[0].QuotaFiles != user.QuotaFiles || len(users[0].Permissions["/sub"]) != 2 ||
+			len(users[0].Filters.AllowedIP) != 1 {
+			t.Errorf("unexpected restored user: %+v", users[0])
+		}
+		user = users[0]
+	}
+	os.Remove(backupFilePath)
+	// CSV contains the basic user fields only
+	_, _, err = httpd.Dumpdata("backup.csv", "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	backupFilePath = filepath.Join(backupsPath, "backup.csv")
+	content, err = ioutil.ReadFile(backupFilePath)
+	if err != nil {
+		t.Errorf("unable to read the CSV dump: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "username,status,expiration_date,") ||
+		!strings.Contains(string(content), "/=*;/sub=list,download") {
+		t.Errorf("unexpected CSV dump: %v", string(content))
+	}
+	// a spreadsheet with its own headers updates the existing user and adds a new one,
+	// the empty cells preserve the existing values
+	content = []byte("Login,Home Directory,Perms,Expires,Max files,Password,Notes\n" +
+		user.Username + ",,,,10,,existing user\n" +
+		"test_user_csv," + filepath.Join(homeBasePath, "test_user_csv") + ",/=list;/upload=*,2030-01-02,,pwd,new user\n")
+	err = ioutil.WriteFile(backupFilePath, content, 0666)
+	if err != nil {
+		t.Errorf("unable to write the CSV file: %v", err)
+	}
+	q := url.Values{}
+	q.Add("input_file", backupFilePath)
+	q.Add("csv_columns", "Login=username,Home Directory=home_dir,Perms=permissions,Expires=expiration_date,"+
+		"Max files=quota_files")
+	req, _ = http.NewRequest(http.MethodGet, loadDataPath+"?"+q.Encode(), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	users, _, err = httpd.GetUsers(1, 0, user.Username, http.StatusOK)
+	if err != nil || len(users) != 1 {
+		t.Errorf("unable to get the restored user: %v", err)
+	} else if users[0].QuotaFiles != 10 || users[0].HomeDir != user.HomeDir || len(users[0].Permissions["/sub"]) != 2 ||
+		len(users[0].Filters.AllowedIP) != 1 {
+		t.Errorf("the CSV restore must update the non empty fields only: %+v", users[0])
+	}
+	users, _, err = httpd.GetUsers(1, 0, "test_user_csv", http.StatusOK)
+	if err != nil || len(users) != 1 {
+		t.Errorf("unable to get the user added from CSV: %v", err)
+	} else {
+		expirationDate := utils.GetTimeAsMsSinceEpoch(time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC))
+		if users[0].Status != 1 || users[0].ExpirationDate != expirationDate || len(users[0].Permissions["/upload"]) != 1 {
+			t.Errorf("unexpected user added from CSV: %+v", users[0])
+		}
+		_, err = httpd.RemoveUser(users[0], http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+	for _, invalid := range []string{"Home Directory\n/tmp\n", "username,quota_files\nuser,a\n",
+		"username,permissions\nuser,list\n", "username,expiration_date\nuser,2030-13-01\n"} {
+		err = ioutil.WriteFile(backupFilePath, []byte(invalid), 0666)
+		if err != nil {
+			t.Errorf("unable to write the CSV file: %v", err)
+		}
+		_, _, err = httpd.Loaddata(backupFilePath, "0", "0", http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("invalid CSV %#v must fail: %v", invalid, err)
+		}
+	}
+	q.Set("csv_columns", "Login")
+	req, _ = http.NewRequest(http.MethodGet, loadDataPath+"?"+q.Encode(), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	q.Set("csv_columns", "Login=filters")
+	req, _ = http.NewRequest(http.MethodGet, loadDataPath+"?"+q.Encode(), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	q.Set("csv_columns", "")
+	q.Set("format", "xml")
+	req, _ = http.NewRequest(http.MethodGet, loadDataPath+"?"+q.Encode(), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	os.Remove(backupFilePath)
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestHTTPSConnection(t *testing.T) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	_, err := client.Get("https://localhost:8443" + metricsPath)
+	if err == nil || (!strings.Contains(err.Error(), "certificate is not valid") &&
+		!strings.Contains(err.Error(), "certificate signed by unknown authority")) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// test using mock http server
+
+func TestBasicUserHandlingMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	user.MaxSessions = 10
+	user.UploadBandwidth = 128
+	user.Permissions["/"] = []string{dataprovider.PermAny, dataprovider.PermDelete, dataprovider.PermDownload}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+
+	var updatedUser dataprovider.User
+	err = render.DecodeJSON(rr.Body, &updatedUser)
+	if err != nil {
+		t.Errorf("Error decoding updated user: %v", err)
+	}
+	if user.MaxSessions != updatedUser.MaxSessions || user.UploadBandwidth != updatedUser.UploadBandwidth {
+		t.Errorf("Error modifying user actual: %v, %v", updatedUser.MaxSessions, updatedUser.UploadBandwidth)
+	}
+	if len(updatedUser.Permissions["/"]) != 1 {
+		t.Errorf("permissions other than any should be removed")
+	}
+	if !utils.IsStringInSlice(dataprovider.PermAny, updatedUser.Permissions["/"]) {
+		t.Errorf("permissions mismatch")
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestGetUserByIdInvalidParamsMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, userPath+"/0", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAddUserNoUsernameMock(t *testing.T) {
+	user := getTestUser()
+	user.Username = ""
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAddUserInvalidHomeDirMock(t *testing.T) {
+	user := getTestUser()
+	user.HomeDir = "relative_path"
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAddUserInvalidPermsMock(t *testing.T) {
+	user := getTestUser()
+	user.Permissions["/"] = []string{}
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAddUserInvalidJsonMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer([]byte("invalid json")))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestUpdateUserMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	// permissions should not change if empty or nil
+	permissions := user.Permissions
+	user.Permissions = make(map[string][]string)
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var updatedUser dataprovider.User
+	err = render.DecodeJSON(rr.Body, &updatedUser)
+	if err != nil {
+		t.Errorf("Error decoding updated user: %v", err)
+	}
+	for dir, perms := range permissions {
+		if actualPerms, ok := updatedUser.Permissions[dir]; ok {
+			for _, v := range actualPerms {
+				if !utils.IsStringInSlice(v, perms) {
+					t.Error("Permissions contents mismatch")
+				}
+			}
+		} else {
+			t.Error("Permissions directories mismatch")
+		}
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestUpdateUserVersionMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	if user.Version != 1 || user.CreatedAt == 0 || user.UpdatedAt != user.CreatedAt {
+		t.Errorf("unexpected version or timestamps for the added user: %v, %v, %v", user.Version, user.CreatedAt,
+			user.UpdatedAt)
+	}
+	userURL := userPath + "/" + strconv.FormatInt(user.ID, 10)
+	req, _ = http.NewRequest(http.MethodGet, userURL, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if rr.Header().Get("ETag") != `"1"` {
+		t.Errorf("unexpected ETag: %#v", rr.Header().Get("ETag"))
+	}
+	// the version in the request body is ignored
+	user.Version = 10
+	user.MaxSessions = 2
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userURL, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	// a stale version is rejected
+	user.MaxSessions = 3
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userURL, bytes.NewBuffer(userAsJSON))
+	req.Header.Set("If-Match", `"1"`)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, userURL, bytes.NewBuffer(userAsJSON))
+	req.Header.Set("If-Match", "invalid")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, userURL, bytes.NewBuffer(userAsJSON))
+	req.Header.Set("If-Match", `W/"2"`)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userURL, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var updatedUser dataprovider.User
+	err = render.DecodeJSON(rr.Body, &updatedUser)
+	if err != nil {
+		t.Errorf("Error decoding updated user: %v", err)
+	}
+	if updatedUser.MaxSessions != 3 || updatedUser.Version != 3 || updatedUser.CreatedAt != user.CreatedAt ||
+		updatedUser.UpdatedAt < user.UpdatedAt {
+		t.Errorf("unexpected updated user, max sessions: %v, version: %v, created at: %v, updated at: %v",
+			updatedUser.MaxSessions, updatedUser.Version, updatedUser.CreatedAt, updatedUser.UpdatedAt)
+	}
+	if rr.Header().Get("ETag") != `"3"` {
+		t.Errorf("unexpected ETag: %#v", rr.Header().Get("ETag"))
+	}
+	// the listings include the timestamps
+	users, _, err := httpd.GetUsers(0, 0, user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 || users[0].UpdatedAt != updatedUser.UpdatedAt || users[0].Version != 3 {
+		t.Errorf("unexpected users listing: %+v", users)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userURL, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestUserPermissionsMock(t *testing.T) {
+	user := getTestUser()
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/somedir"] = []string{dataprovider.PermAny}
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions[".."] = []string{dataprovider.PermAny}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	user.Permissions["/somedir"] = []string{"invalid"}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	delete(user.Permissions, "/somedir")
+	user.Permissions["/somedir/.."] = []string{dataprovider.PermAny}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	delete(user.Permissions, "/somedir/..")
+	user.Permissions["not_abs_path"] = []string{dataprovider.PermAny}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	delete(user.Permissions, "not_abs_path")
+	user.Permissions["/somedir/../otherdir/"] = []string{dataprovider.PermListItems}
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var updatedUser dataprovider.User
+	err = render.DecodeJSON(rr.Body, &updatedUser)
+	if err != nil {
+		t.Errorf("Error decoding updated user: %v", err)
+	}
+	if val, ok := updatedUser.Permissions["/otherdir"]; ok {
+		if !utils.IsStringInSlice(dataprovider.PermListItems, val) {
+			t.Error("expected permission list not found")
+		}
+		if len(val) != 1 {
+			t.Errorf("Unexpected number of permissions, expected 1, actual: %v", len(val))
+		}
+	} else {
+		t.Errorf("expected dir not found in permissions")
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestUpdateUserInvalidJsonMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer([]byte("Invalid json")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestUpdateUserInvalidParamsMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	user.HomeDir = ""
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	userID := user.ID
+	user.ID = 0
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(userID, 10), bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	user.ID = userID
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/0", bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/a", bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestGetUsersMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=510&offset=0&order=ASC&username="+defaultUsername, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var users []dataprovider.User
+	err = render.DecodeJSON(rr.Body, &users)
+	if err != nil {
+		t.Errorf("Error decoding users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("1 user is expected")
+	}
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=a&offset=0&order=ASC", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=a&order=ASC", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASCa", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestDeleteUserInvalidParamsMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodDelete, userPath+"/0", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetQuotaScansMock(t *testing.T) {
+	req, err := http.NewRequest("GET", quotaScanPath, nil)
+	if err != nil {
+		t.Errorf("error get quota scan: %v", err)
+	}
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestStartDuplicatesScanInvalidJSONMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, duplicatesScanPath, bytes.NewBuffer([]byte("invalid json")))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestHooksTestInvalidJSONMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, hooksTestPath+"/actions", bytes.NewBuffer([]byte("invalid json")))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, hooksTestPath+"/provider_actions", bytes.NewBuffer([]byte("invalid json")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestLoginSimulationMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, loginSimulationPath, bytes.NewBuffer([]byte("invalid json")))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	loginReq := sftpd.LoginSimulationRequest{
+		Username: user.Username,
+		Password: defaultPassword,
+		IP:       "192.168.1.1",
+	}
+	asJSON, _ := json.Marshal(loginReq)
+	req, _ = http.NewRequest(http.MethodPost, loginSimulationPath, bytes.NewBuffer(asJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var result sftpd.LoginSimulationResult
+	err = render.DecodeJSON(rr.Body, &result)
+	if err != nil {
+		t.Errorf("unable to decode login simulation result: %v", err)
+	}
+	if !result.Allowed || len(result.Rule) > 0 || result.LoginMethod != dataprovider.SSHLoginMethodPassword {
+		t.Errorf("unexpected login simulation result: %+v", result)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestStartQuotaScanMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	_, err = os.Stat(user.HomeDir)
+	if err == nil {
+		os.Remove(user.HomeDir)
+	}
+	// simulate a duplicate quota scan
+	userAsJSON = getUserAsJSON(t, user)
+	sftpd.AddQuotaScan(user.Username)
+	req, _ = http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr.Code)
+	sftpd.RemoveQuotaScan(user.Username)
+
+	userAsJSON = getUserAsJSON(t, user)
+	req, _ = http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var scans []sftpd.ActiveQuotaScan
+	err = render.DecodeJSON(rr.Body, &scans)
+	if err != nil {
+		t.Errorf("Error get active scans: %v", err)
+	}
+	for len(scans) > 0 {
+		req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+		err = render.DecodeJSON(rr.Body, &scans)
+		if err != nil {
+			t.Errorf("Error get active scans: %v", err)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	_, err = os.Stat(user.HomeDir)
+	if err != nil && os.IsNotExist(err) {
+		os.MkdirAll(user.HomeDir, 0777)
+	}
+	req, _ = http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err = render.DecodeJSON(rr.Body, &scans)
+	if err != nil {
+		t.Errorf("Error get active scans: %v", err)
+	}
+	for len(scans) > 0 {
+		req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+		err = render.DecodeJSON(rr.Body, &scans)
+		if err != nil {
+			t.Errorf("Error get active scans: %v", err)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestQuotaScanProgressMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	err = os.MkdirAll(user.HomeDir, 0777)
+	if err != nil {
+		t.Errorf("unable to create home dir: %v", err)
+	}
+	testFileContents := []byte("test contents")
+	err = ioutil.WriteFile(filepath.Join(user.HomeDir, "file.txt"), testFileContents, 0600)
+	if err != nil {
+		t.Errorf("unable to write the test file: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, quotaScanPath+"/"+user.Username, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	// simulate a running scan that counted half of the expected size
+	if !sftpd.AddQuotaScan(user.Username) {
+		t.Errorf("unable to add quota scan")
+	}
+	progress := sftpd.GetQuotaScanProgress(user.Username)
+	progress.SetExpected(2, int64(2*len(testFileContents)))
+	numFiles, size, err := vfs.ScanRootDirContentsWithProgress(vfs.NewOsFs("", user.HomeDir, nil), progress)
+	if err != nil || numFiles != 1 || size != int64(len(testFileContents)) {
+		t.Errorf("unexpected scan results, files: %v size: %v err: %v", numFiles, size, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, quotaScanPath+"/"+user.Username, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var scan sftpd.ActiveQuotaScan
+	err = render.DecodeJSON(rr.Body, &scan)
+	if err != nil {
+		t.Errorf("Error get active scan: %v", err)
+	}
+	if scan.Username != user.Username || scan.ScannedFiles != 1 || scan.ScannedSize != int64(len(testFileContents)) {
+		t.Errorf("unexpected scan progress: %+v", scan)
+	}
+	if scan.EstimatedEndTime <= scan.StartTime {
+		t.Errorf("unexpected estimated end time: %+v", scan)
+	}
+	// the scan counted more than expected, the end time is unknown
+	progress.SetExpected(0, int64(len(testFileContents)-1))
+	scan, ok := sftpd.GetQuotaScan(user.Username)
+	if !ok || scan.EstimatedEndTime != 0 || len(scan.GetEstimatedEndTimeAsString()) > 0 {
+		t.Errorf("unexpected scan progress: %+v", scan)
+	}
+	req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var scans []sftpd.ActiveQuotaScan
+	err = render.DecodeJSON(rr.Body, &scans)
+	if err != nil || len(scans) != 1 || scans[0].ScannedFiles != 1 {
+		t.Errorf("unexpected active scans: %+v, err: %v", scans, err)
+	}
+	sftpd.RemoveQuotaScan(user.Username)
+
+	if !sftpd.AddFolderQuotaScan("progress_folder") {
+		t.Errorf("unable to add folder quota scan")
+	}
+	req, _ = http.NewRequest(http.MethodGet, folderQuotaScanPath+"/progress_folder", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var folderScan sftpd.ActiveFolderQuotaScan
+	err = render.DecodeJSON(rr.Body, &folderScan)
+	if err != nil || folderScan.Name != "progress_folder" || folderScan.ScannedFiles != 0 ||
+		folderScan.EstimatedEndTime != 0 {
+		t.Errorf("unexpected folder scan progress: %+v, err: %v", folderScan, err)
+	}
+	sftpd.RemoveFolderQuotaScan("progress_folder")
+	req, _ = http.NewRequest(http.MethodGet, folderQuotaScanPath+"/progress_folder", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodPost, quotaScanPath+"/"+user.Username+"/folder",
+		bytes.NewBuffer([]byte("invalid json")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestStartQuotaScanBadUserMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStartQuotaScanNonExistentUserMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer([]byte("invalid json")))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetVersionMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, versionPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestGetOpenAPIDocumentMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, openAPIPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if rr.Header().Get("Content-Type") != "application/yaml" {
+		t.Errorf("unexpected content type: %#v", rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	for _, operationID := range []string{"get_users", "add_user", "get_openapi"} {
+		if !strings.Contains(body, "operationId: "+operationID+"\n") {
+			t.Errorf("operation %#v not found", operationID)
+		}
+	}
+}
+
+func TestGetUsersPaginationMock(t *testing.T) {
+	var users []dataprovider.User
+	for _, username := range []string{"page_mock_user1", "page_mock_user2", "page_mock_user3"} {
+		u := getTestUser()
+		u.Username = username
+		user, _, err := httpd.AddUser(u, http.StatusOK)
+		if err != nil {
+			t.Fatalf("unable to add user: %v", err)
+		}
+		users = append(users, user)
+	}
+	req, _ := http.NewRequest(http.MethodGet, userPath+"?limit=1&order_by=created_at&order=DESC", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	total, err := strconv.Atoi(rr.Header().Get("X-SFTPGo-Total-Count"))
+	if err != nil || total < len(users) {
+		t.Errorf("unexpected total count: %#v", rr.Header().Get("X-SFTPGo-Total-Count"))
+	}
+	nextCursor := rr.Header().Get("X-SFTPGo-Next-Cursor")
+	if len(nextCursor) == 0 || len(rr.Header().Get("X-SFTPGo-Prev-Cursor")) > 0 {
+		t.Errorf("unexpected cursors: %+v", rr.Header())
+	}
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&order_by=created_at&order=DESC&cursor="+nextCursor, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var page []dataprovider.User
+	err = render.DecodeJSON(rr.Body, &page)
+	if err != nil || len(page) != 1 {
+		t.Errorf("unexpected users: %+v, error: %v", page, err)
+	}
+	if len(rr.Header().Get("X-SFTPGo-Prev-Cursor")) == 0 {
+		t.Errorf("unexpected cursors: %+v", rr.Header())
+	}
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&username=page_mock_user2", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if rr.Header().Get("X-SFTPGo-Total-Count") != "1" || len(rr.Header().Get("X-SFTPGo-Next-Cursor")) > 0 {
+		t.Errorf("unexpected headers: %+v", rr.Header())
+	}
+	for _, query := range []string{"order_by=status", "cursor=invalid", "order=ASC&cursor=" + nextCursor,
+		"offset=1&order_by=created_at&order=DESC&cursor=" + nextCursor, "limit=-1"} {
+		req, _ = http.NewRequest(http.MethodGet, userPath+"?"+query, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	}
+	for _, user := range users {
+		_, err = httpd.RemoveUser(user, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+}
 
-func TestBasicUserHandlingMock(t *testing.T) {
+func TestGetUsersFiltersMock(t *testing.T) {
+	u := getTestUser()
+	u.Username = "filter_mock_user"
+	u.Status = 0
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, userPath+"?username=filter_mock_user&status=0&fs_provider=0", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if rr.Header().Get("X-SFTPGo-Total-Count") != "1" {
+		t.Errorf("unexpected headers: %+v", rr.Header())
+	}
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?username=filter_mock_user&status=1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var users []dataprovider.User
+	err = render.DecodeJSON(rr.Body, &users)
+	if err != nil || len(users) != 0 || rr.Header().Get("X-SFTPGo-Total-Count") != "0" {
+		t.Errorf("unexpected users: %+v, error: %v", users, err)
+	}
+	for _, query := range []string{"status=a", "status=2", "expires_after=a", "expires_before=-1",
+		"expires_after=2&expires_before=1", "fs_provider=a", "fs_provider=10", "min_used_quota_size=a",
+		"min_quota_usage_percent=a", "last_login_older_than_days=a"} {
+		req, _ = http.NewRequest(http.MethodGet, userPath+"?"+query, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestPatchUserMock(t *testing.T) {
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	userURL := userPath + "/" + strconv.FormatInt(user.ID, 10)
+	for _, body := range []string{"invalid json", "null", "[]", `{"status":"a"}`} {
+		req, _ := http.NewRequest(http.MethodPatch, userURL, bytes.NewBuffer([]byte(body)))
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	}
+	req, _ := http.NewRequest(http.MethodPatch, userPath+"/a", bytes.NewBuffer([]byte(`{"status":0}`)))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPatch, userURL+"?disconnect=a", bytes.NewBuffer([]byte(`{"status":0}`)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPatch, userURL, bytes.NewBuffer([]byte(`{"status":0}`)))
+	req.Header.Set("If-Match", "invalid")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPatch, userURL, bytes.NewBuffer([]byte(`{"status":0}`)))
+	req.Header.Set("If-Match", fmt.Sprintf(`"%v"`, user.Version+1))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr.Code)
+	req, _ = http.NewRequest(http.MethodPatch, userURL+"?disconnect=1", bytes.NewBuffer([]byte(`{"status":0}`)))
+	req.Header.Set("If-Match", fmt.Sprintf(`"%v"`, user.Version))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userURL, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var patchedUser dataprovider.User
+	err = render.DecodeJSON(rr.Body, &patchedUser)
+	if err != nil || patchedUser.Status != 0 {
+		t.Errorf("unexpected patched user: %+v, error: %v", patchedUser, err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestBulkUsersOperationsMock(t *testing.T) {
+	type bulkResult struct {
+		Username string `json:"username"`
+		Status   int    `json:"status"`
+		Error    string `json:"error"`
+	}
+	executeBulkRequest := func(operations []map[string]interface{}, expectedStatusCode int) []bulkResult {
+		var results []bulkResult
+		body, err := json.Marshal(operations)
+		if err != nil {
+			t.Fatalf("unable to marshal operations: %v", err)
+		}
+		req, _ := http.NewRequest(http.MethodPost, userBulkPath, bytes.NewBuffer(body))
+		rr := executeRequest(req)
+		checkResponseCode(t, expectedStatusCode, rr.Code)
+		err = render.DecodeJSON(rr.Body, &results)
+		if err != nil || len(results) != len(operations) {
+			t.Errorf("unexpected results: %+v, error: %v", results, err)
+		}
+		return results
+	}
+	var users []dataprovider.User
+	for _, username := range []string{"bulk_user1", "bulk_user2"} {
+		u := getTestUser()
+		u.Username = username
+		u.HomeDir = filepath.Join(homeBasePath, username)
+		user, _, err := httpd.AddUser(u, http.StatusOK)
+		if err != nil {
+			t.Fatalf("unable to add user: %v", err)
+		}
+		users = append(users, user)
+	}
+	newUser := getTestUser()
+	newUser.Username = "bulk_user3"
+	newUser.HomeDir = filepath.Join(homeBasePath, newUser.Username)
+	// nothing is applied if an operation is invalid
+	results := executeBulkRequest([]map[string]interface{}{
+		{"action": "add", "user": newUser},
+		{"action": "update", "username": "bulk_user1", "user": map[string]interface{}{"quota_size": 4096}},
+		{"action": "update", "username": "missing_bulk_user", "user": map[string]interface{}{"quota_size": 4096}},
+		{"action": "disable", "username": "bulk_user1"},
+		{"action": "unknown", "username": "bulk_user2"},
+		{"action": "add", "user": users[1]},
+		{"action": "update", "username": "bulk_user2", "user": map[string]interface{}{"username": "renamed"}},
+		{"action": "update", "username": "bulk_user2", "user": []string{}},
+	}, http.StatusBadRequest)
+	expectedStatuses := []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusNotFound,
+		http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest}
+	for idx, result := range results {
+		if idx < len(expectedStatuses) && result.Status != expectedStatuses[idx] {
+			t.Errorf("unexpected result %v: %+v", idx, result)
+		}
+	}
+	user, _, err := httpd.GetUserByID(users[0].ID, http.StatusOK)
+	if err != nil || user.QuotaSize != users[0].QuotaSize {
+		t.Errorf("the user must not be updated: %+v, error: %v", user, err)
+	}
+	invalidUser := getTestUser()
+	invalidUser.Username = "bulk_user4"
+	invalidUser.HomeDir = "relative_path"
+	results = executeBulkRequest([]map[string]interface{}{
+		{"action": "add", "user": newUser},
+		{"action": "add", "user": invalidUser},
+		{"action": "update", "username": "bulk_user1", "user": map[string]interface{}{"quota_size": 4096}},
+		{"action": "disable", "username": "bulk_user2"},
+	}, http.StatusOK)
+	expectedStatuses = []int{http.StatusOK, http.StatusBadRequest, http.StatusOK, http.StatusOK}
+	for idx, result := range results {
+		if idx < len(expectedStatuses) && result.Status != expectedStatuses[idx] {
+			t.Errorf("unexpected result %v: %+v", idx, result)
+		}
+	}
+	user, _, err = httpd.GetUserByID(users[0].ID, http.StatusOK)
+	if err != nil || user.QuotaSize != 4096 || user.HomeDir != users[0].HomeDir {
+		t.Errorf("the user must be updated: %+v, error: %v", user, err)
+	}
+	user, _, err = httpd.GetUserByID(users[1].ID, http.StatusOK)
+	if err != nil || user.Status != 0 {
+		t.Errorf("the user must be disabled: %+v, error: %v", user, err)
+	}
+	addedUser, err := dataprovider.UserExists(dataprovider.GetProvider(), newUser.Username)
+	if err != nil {
+		t.Errorf("the user must be added: %v", err)
+	}
+	users = append(users, addedUser)
+	_, err = dataprovider.UserExists(dataprovider.GetProvider(), invalidUser.Username)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); !ok {
+		t.Errorf("the invalid user must not be added: %v", err)
+	}
+	for _, body := range []string{"invalid json", "[]", "{}"} {
+		req, _ := http.NewRequest(http.MethodPost, userBulkPath, bytes.NewBuffer([]byte(body)))
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	}
+	req, _ := http.NewRequest(http.MethodPost, userBulkPath+"?disconnect=a", bytes.NewBuffer([]byte(`[{"action":"disable"}]`)))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	for _, query := range []string{"", "disconnect=a&status=0", "dry_run=a&status=0", "status=a"} {
+		req, _ = http.NewRequest(http.MethodDelete, userBulkPath+"?"+query, nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	}
+	// the disabled user has a distinctive expiration date, so the users added by the other tests are not deleted
+	expirationDate := utils.GetTimeAsMsSinceEpoch(time.Now().Add(1000 * time.Hour))
+	_, _, err = httpd.PatchUser(users[1].ID, map[string]interface{}{"expiration_date": expirationDate}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to patch user: %v", err)
+	}
+	deleteQuery := fmt.Sprintf("status=0&expires_after=%v&expires_before=%v", expirationDate, expirationDate)
+	for _, dryRun := range []bool{true, false} {
+		req, _ = http.NewRequest(http.MethodDelete, userBulkPath+"?"+deleteQuery+"&dry_run="+strconv.FormatBool(dryRun), nil)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+		results = nil
+		err = render.DecodeJSON(rr.Body, &results)
+		if err != nil || len(results) != 1 || results[0].Username != "bulk_user2" || results[0].Status != http.StatusOK {
+			t.Errorf("unexpected results: %+v, error: %v", results, err)
+		}
+		expectedStatusCode := http.StatusOK
+		if !dryRun {
+			expectedStatusCode = http.StatusNotFound
+		}
+		_, _, err = httpd.GetUserByID(users[1].ID, expectedStatusCode)
+		if err != nil {
+			t.Errorf("unexpected error, dry run: %v, error: %v", dryRun, err)
+		}
+	}
+	for _, user := range []dataprovider.User{users[0], users[2]} {
+		_, err = httpd.RemoveUser(user, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+}
+
+func TestGetConnectionsMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, activeConnectionsPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestUpdateConnectionLabelMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, activeConnectionsPath+"/connectionID", bytes.NewBuffer([]byte("invalid json")))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDeleteActiveConnectionMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodDelete, activeConnectionsPath+"/connectionID", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+}
+
+func TestNotFoundMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/non/existing/path", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+}
+
+func TestMethodNotAllowedMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, activeConnectionsPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestMetricsMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, metricsPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestPProfEndPointMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, pprofPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestGetWebRootMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusMovedPermanently, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webBasePath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusMovedPermanently, rr.Code)
+}
+
+func TestBasicWebUsersMock(t *testing.T) {
 	user := getTestUser()
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err := render.DecodeJSON(rr.Body, &user)
-	if err != nil {
-		t.Errorf("Error get user: %v", err)
-	}
-	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	user1 := getTestUser()
+	user1.Username += "1"
+	user1AsJSON := getUserAsJSON(t, user1)
+	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(user1AsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err = render.DecodeJSON(rr.Body, &user1)
+	if err != nil {
+		t.Errorf("Error get user1: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, webUsersPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUsersPath+"?qlimit=a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUsersPath+"?qlimit=1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUserPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUserPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUserPath+"/0", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUserPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	b, contentType, _ := getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/0", &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/a", &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user1.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestWebUserAddMock(t *testing.T) {
+	user := getTestUser()
+	user.UploadBandwidth = 32
+	user.DownloadBandwidth = 64
+	user.UID = 1000
+	mappedDir := filepath.Join(os.TempDir(), "mapped")
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	form.Set("home_dir", user.HomeDir)
+	form.Set("password", user.Password)
+	form.Set("status", strconv.Itoa(user.Status))
+	form.Set("expiration_date", "")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", " /subdir::list ,download ")
+	form.Set("virtual_folders", fmt.Sprintf(" /vdir:: %v ", mappedDir))
+	form.Set("allowed_extensions", "/dir1::.jpg,.png")
+	form.Set("denied_extensions", "/dir1::.zip")
+	b, contentType, _ := getMultipartFormData(form, "", "")
+	// test invalid url escape
+	req, _ := http.NewRequest(http.MethodPost, webUserPath+"?a=%2", &b)
+	req.Header.Set("Content-Type", contentType)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("public_keys", testPubKey)
+	form.Set("uid", strconv.FormatInt(int64(user.UID), 10))
+	form.Set("gid", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid gid
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("gid", "0")
+	form.Set("max_sessions", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid max sessions
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("max_sessions", "0")
+	form.Set("quota_size", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid quota size
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("quota_size", "0")
+	form.Set("quota_files", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid quota files
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("quota_files", "0")
+	form.Set("upload_bandwidth", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid upload bandwidth
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("upload_bandwidth", strconv.FormatInt(user.UploadBandwidth, 10))
+	form.Set("download_bandwidth", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid download bandwidth
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
-	user.MaxSessions = 10
-	user.UploadBandwidth = 128
-	user.Permissions["/"] = []string{dataprovider.PermAny, dataprovider.PermDelete, dataprovider.PermDownload}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("download_bandwidth", strconv.FormatInt(user.DownloadBandwidth, 10))
+	form.Set("status", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid status
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-
-	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	form.Set("status", strconv.Itoa(user.Status))
+	form.Set("expiration_date", "123")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid expiration date
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-
-	var updatedUser dataprovider.User
-	err = render.DecodeJSON(rr.Body, &updatedUser)
-	if err != nil {
-		t.Errorf("Error decoding updated user: %v", err)
-	}
-	if user.MaxSessions != updatedUser.MaxSessions || user.UploadBandwidth != updatedUser.UploadBandwidth {
-		t.Errorf("Error modifying user actual: %v, %v", updatedUser.MaxSessions, updatedUser.UploadBandwidth)
-	}
-	if len(updatedUser.Permissions["/"]) != 1 {
-		t.Errorf("permissions other than any should be removed")
-	}
-	if !utils.IsStringInSlice(dataprovider.PermAny, updatedUser.Permissions["/"]) {
-		t.Errorf("permissions mismatch")
-	}
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	form.Set("expiration_date", "")
+	form.Set("allowed_ip", "invalid,ip")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid allowed_ip
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestGetUserByIdInvalidParamsMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, userPath+"/0", nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"/a", nil)
+	form.Set("allowed_ip", "")
+	form.Set("denied_ip", "192.168.1.2") // it should be 192.168.1.2/32
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid denied_ip
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestAddUserNoUsernameMock(t *testing.T) {
-	user := getTestUser()
-	user.Username = ""
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestAddUserInvalidHomeDirMock(t *testing.T) {
-	user := getTestUser()
-	user.HomeDir = "relative_path"
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestAddUserInvalidPermsMock(t *testing.T) {
-	user := getTestUser()
-	user.Permissions["/"] = []string{}
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestAddUserInvalidJsonMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer([]byte("invalid json")))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestUpdateUserMock(t *testing.T) {
-	user := getTestUser()
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err := render.DecodeJSON(rr.Body, &user)
-	if err != nil {
-		t.Errorf("Error get user: %v", err)
-	}
-	// permissions should not change if empty or nil
-	permissions := user.Permissions
-	user.Permissions = make(map[string][]string)
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	form.Set("denied_ip", "")
+	form.Set("min_rsa_key_size", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	// test invalid min RSA key size
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	form.Set("min_rsa_key_size", "3072")
+	form.Set("tenant", " ACME ")
+	form.Set("read_only", "on")
+	form.Set("first_login_accept_terms", "on")
+	form.Add("allowed_key_algorithms", "ssh-rsa")
+	form.Add("allowed_key_algorithms", "ssh-ed25519")
+	form.Add("allowed_ssh_commands", "scp")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	// the user already exists, was created with the above request
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	var updatedUser dataprovider.User
-	err = render.DecodeJSON(rr.Body, &updatedUser)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var users []dataprovider.User
+	err := render.DecodeJSON(rr.Body, &users)
 	if err != nil {
-		t.Errorf("Error decoding updated user: %v", err)
+		t.Errorf("Error decoding users: %v", err)
 	}
-	for dir, perms := range permissions {
-		if actualPerms, ok := updatedUser.Permissions[dir]; ok {
-			for _, v := range actualPerms {
-				if !utils.IsStringInSlice(v, perms) {
-					t.Error("Permissions contents mismatch")
-				}
-			}
-		} else {
-			t.Error("Permissions directories mismatch")
+	if len(users) != 1 {
+		t.Errorf("1 user is expected, actual: %v", len(users))
+	}
+	newUser := users[0]
+	if newUser.UID != user.UID {
+		t.Errorf("uid does not match")
+	}
+	if newUser.UploadBandwidth != user.UploadBandwidth {
+		t.Errorf("upload_bandwidth does not match")
+	}
+	if newUser.DownloadBandwidth != user.DownloadBandwidth {
+		t.Errorf("download_bandwidth does not match")
+	}
+	if !utils.IsStringInSlice(testPubKey, newUser.PublicKeys) {
+		t.Errorf("public_keys does not match")
+	}
+	if val, ok := newUser.Permissions["/subdir"]; ok {
+		if !utils.IsStringInSlice(dataprovider.PermListItems, val) || !utils.IsStringInSlice(dataprovider.PermDownload, val) {
+			t.Error("permssions for /subdir does not match")
 		}
+	} else {
+		t.Errorf("user permissions must contain /somedir, actual: %v", newUser.Permissions)
 	}
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	vfolderFoumd := false
+	for _, v := range newUser.VirtualFolders {
+		if v.VirtualPath == "/vdir" && v.MappedPath == mappedDir {
+			vfolderFoumd = true
+		}
+	}
+	if !vfolderFoumd {
+		t.Errorf("virtual folders must contain /vdir, actual: %+v", newUser.VirtualFolders)
+	}
+	extFilters := newUser.Filters.FileExtensions[0]
+	if !utils.IsStringInSlice(".zip", extFilters.DeniedExtensions) {
+		t.Errorf("unexpected denied extensions: %v", extFilters.DeniedExtensions)
+	}
+	if newUser.Filters.Tenant != "ACME" {
+		t.Errorf("unexpected tenant: %#v", newUser.Filters.Tenant)
+	}
+	if !newUser.Filters.ReadOnly {
+		t.Error("the user must be read only")
+	}
+	if !newUser.Filters.FirstLogin.AcceptTerms || newUser.Filters.FirstLogin.ChangePassword {
+		t.Errorf("unexpected first login actions: %+v", newUser.Filters.FirstLogin)
+	}
+	if len(newUser.Filters.AllowedKeyAlgorithms) != 2 || newUser.Filters.MinRSAKeySize != 3072 {
+		t.Errorf("unexpected public key filters: %v, %v", newUser.Filters.AllowedKeyAlgorithms,
+			newUser.Filters.MinRSAKeySize)
+	}
+	if len(newUser.Filters.AllowedSSHCommands) != 1 || newUser.Filters.AllowedSSHCommands[0] != "scp" {
+		t.Errorf("unexpected allowed SSH commands: %v", newUser.Filters.AllowedSSHCommands)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(newUser.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestUserPermissionsMock(t *testing.T) {
+func TestWebUserUpdateMock(t *testing.T) {
 	user := getTestUser()
-	user.Permissions = make(map[string][]string)
-	user.Permissions["/somedir"] = []string{dataprovider.PermAny}
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	user.Permissions = make(map[string][]string)
-	user.Permissions["/"] = []string{dataprovider.PermAny}
-	user.Permissions[".."] = []string{dataprovider.PermAny}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	user.Permissions = make(map[string][]string)
-	user.Permissions["/"] = []string{dataprovider.PermAny}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 	err := render.DecodeJSON(rr.Body, &user)
 	if err != nil {
 		t.Errorf("Error get user: %v", err)
 	}
-	user.Permissions["/somedir"] = []string{"invalid"}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	delete(user.Permissions, "/somedir")
-	user.Permissions["/somedir/.."] = []string{dataprovider.PermAny}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	delete(user.Permissions, "/somedir/..")
-	user.Permissions["not_abs_path"] = []string{dataprovider.PermAny}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	delete(user.Permissions, "not_abs_path")
-	user.Permissions["/somedir/../otherdir/"] = []string{dataprovider.PermListItems}
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
+	user.MaxSessions = 1
+	user.QuotaFiles = 2
+	user.QuotaSize = 3
+	user.GID = 1000
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	form.Set("home_dir", user.HomeDir)
+	form.Set("uid", "0")
+	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
+	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
+	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
+	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
+	form.Set("upload_bandwidth", "0")
+	form.Set("download_bandwidth", "0")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", "/otherdir :: list ,upload ")
+	form.Set("status", strconv.Itoa(user.Status))
+	form.Set("expiration_date", "2020-01-01 00:00:00")
+	form.Set("allowed_ip", " 192.168.1.3/32, 192.168.2.0/24 ")
+	form.Set("denied_ip", " 10.0.0.2/32 ")
+	form.Set("denied_extensions", "/dir1::.zip")
+	form.Set("ssh_login_methods", dataprovider.SSHLoginMethodKeyboardInteractive)
+	form.Set("ingestion_folders", "/logs::invalid")
+	form.Set("additional_info", "contract: ACME-2020-01")
+	b, contentType, _ := getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	form.Set("ingestion_folders", " /logs::rollup \n/events")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	var updatedUser dataprovider.User
-	err = render.DecodeJSON(rr.Body, &updatedUser)
-	if err != nil {
-		t.Errorf("Error decoding updated user: %v", err)
+	var users []dataprovider.User
+	render.DecodeJSON(rr.Body, &users)
+	if len(users) != 1 {
+		t.Errorf("1 user is expected")
 	}
-	if val, ok := updatedUser.Permissions["/otherdir"]; ok {
-		if !utils.IsStringInSlice(dataprovider.PermListItems, val) {
-			t.Error("expected permission list not found")
-		}
-		if len(val) != 1 {
-			t.Errorf("Unexpected number of permissions, expected 1, actual: %v", len(val))
+	updateUser := users[0]
+	if user.HomeDir != updateUser.HomeDir {
+		t.Errorf("home dir does not match")
+	}
+	if updateUser.AdditionalInfo != "contract: ACME-2020-01" {
+		t.Errorf("additional info does not match: %#v", updateUser.AdditionalInfo)
+	}
+	if user.MaxSessions != updateUser.MaxSessions {
+		t.Errorf("max_sessions does not match")
+	}
+	if user.QuotaFiles != updateUser.QuotaFiles {
+		t.Errorf("quota_files does not match")
+	}
+	if user.QuotaSize != updateUser.QuotaSize {
+		t.Errorf("quota_size does not match")
+	}
+	if user.GID != updateUser.GID {
+		t.Errorf("gid does not match")
+	}
+	if val, ok := updateUser.Permissions["/otherdir"]; ok {
+		if !utils.IsStringInSlice(dataprovider.PermListItems, val) || !utils.IsStringInSlice(dataprovider.PermUpload, val) {
+			t.Error("permssions for /otherdir does not match")
 		}
 	} else {
-		t.Errorf("expected dir not found in permissions")
+		t.Errorf("user permissions must contains /otherdir, actual: %v", updateUser.Permissions)
 	}
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	if !utils.IsStringInSlice("192.168.1.3/32", updateUser.Filters.AllowedIP) {
+		t.Errorf("Allowed IP/Mask does not match: %v", updateUser.Filters.AllowedIP)
+	}
+	if !utils.IsStringInSlice("10.0.0.2/32", updateUser.Filters.DeniedIP) {
+		t.Errorf("Denied IP/Mask does not match: %v", updateUser.Filters.DeniedIP)
+	}
+	if !utils.IsStringInSlice(dataprovider.SSHLoginMethodKeyboardInteractive, updateUser.Filters.DeniedLoginMethods) {
+		t.Errorf("Denied login methods does not match: %v", updateUser.Filters.DeniedLoginMethods)
+	}
+	if !utils.IsStringInSlice(".zip", updateUser.Filters.FileExtensions[0].DeniedExtensions) {
+		t.Errorf("unexpected extensions filter: %+v", updateUser.Filters.FileExtensions)
+	}
+	if len(updateUser.Filters.IngestionFolders) != 2 {
+		t.Errorf("unexpected ingestion folders: %+v", updateUser.Filters.IngestionFolders)
+	} else if updateUser.Filters.IngestionFolders[0].Path != "/logs" || !updateUser.Filters.IngestionFolders[0].HourlyRollup ||
+		updateUser.Filters.IngestionFolders[1].Path != "/events" || updateUser.Filters.IngestionFolders[1].HourlyRollup {
+		t.Errorf("unexpected ingestion folders: %+v", updateUser.Filters.IngestionFolders)
+	}
+	// the user loaded inside the page was modified
+	form.Set("version", strconv.FormatInt(updateUser.Version-1, 10))
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestUpdateUserInvalidJsonMock(t *testing.T) {
-	user := getTestUser()
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	err := render.DecodeJSON(rr.Body, &user)
-	if err != nil {
-		t.Errorf("Error get user: %v", err)
+	if !strings.Contains(rr.Body.String(), dataprovider.ErrUserVersionMismatch.Error()) {
+		t.Errorf("the version mismatch error is expected")
 	}
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer([]byte("Invalid json")))
+	form.Set("version", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("version", strconv.FormatInt(updateUser.Version, 10))
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
 	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestUpdateUserInvalidParamsMock(t *testing.T) {
+func TestWebUserS3Mock(t *testing.T) {
 	user := getTestUser()
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
@@ -1479,40 +6948,95 @@ func TestUpdateUserInvalidParamsMock(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error get user: %v", err)
 	}
-	user.HomeDir = ""
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(user.ID, 10), bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	userID := user.ID
-	user.ID = 0
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/"+strconv.FormatInt(userID, 10), bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	user.ID = userID
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/0", bytes.NewBuffer(userAsJSON))
+	user.FsConfig.Provider = 1
+	user.FsConfig.S3Config.Bucket = "test"
+	user.FsConfig.S3Config.Region = "eu-west-1"
+	user.FsConfig.S3Config.AccessKey = "access-key"
+	user.FsConfig.S3Config.AccessSecret = "access-secret"
+	user.FsConfig.S3Config.Endpoint = "http://127.0.0.1:9000/path?a=b"
+	user.FsConfig.S3Config.StorageClass = "Standard"
+	user.FsConfig.S3Config.KeyPrefix = "somedir/subdir/"
+	user.FsConfig.S3Config.UploadPartSize = 5
+	user.FsConfig.S3Config.UploadConcurrency = 4
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	form.Set("home_dir", user.HomeDir)
+	form.Set("uid", "0")
+	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
+	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
+	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
+	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
+	form.Set("upload_bandwidth", "0")
+	form.Set("download_bandwidth", "0")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", "")
+	form.Set("status", strconv.Itoa(user.Status))
+	form.Set("expiration_date", "2020-01-01 00:00:00")
+	form.Set("allowed_ip", "")
+	form.Set("denied_ip", "")
+	form.Set("fs_provider", "1")
+	form.Set("s3_bucket", user.FsConfig.S3Config.Bucket)
+	form.Set("s3_region", user.FsConfig.S3Config.Region)
+	form.Set("s3_access_key", user.FsConfig.S3Config.AccessKey)
+	form.Set("s3_access_secret", user.FsConfig.S3Config.AccessSecret)
+	form.Set("s3_storage_class", user.FsConfig.S3Config.StorageClass)
+	form.Set("s3_endpoint", user.FsConfig.S3Config.Endpoint)
+	form.Set("s3_key_prefix", user.FsConfig.S3Config.KeyPrefix)
+	form.Set("allowed_extensions", "/dir1::.jpg,.png")
+	form.Set("denied_extensions", "/dir2::.zip")
+	// test invalid s3_upload_part_size
+	form.Set("s3_upload_part_size", "a")
+	b, contentType, _ := getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-	req, _ = http.NewRequest(http.MethodPut, userPath+"/a", bytes.NewBuffer(userAsJSON))
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	// test invalid s3_concurrency
+	form.Set("s3_upload_part_size", strconv.FormatInt(user.FsConfig.S3Config.UploadPartSize, 10))
+	form.Set("s3_upload_concurrency", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("s3_upload_concurrency", strconv.Itoa(user.FsConfig.S3Config.UploadConcurrency))
+	// test invalid download part size and concurrency
+	form.Set("s3_download_part_size", "b")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestGetUsersMock(t *testing.T) {
-	user := getTestUser()
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
+	form.Set("s3_download_part_size", "10")
+	form.Set("s3_download_concurrency", "c")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err := render.DecodeJSON(rr.Body, &user)
-	if err != nil {
-		t.Errorf("Error get user: %v", err)
+	form.Set("s3_download_concurrency", "6")
+	// test invalid storage class rules
+	for _, rules := range []string{"STANDARD_IA::/", "STANDARD_IA::/::.zip::a"} {
+		form.Set("s3_storage_class_rules", rules)
+		b, contentType, _ = getMultipartFormData(form, "", "")
+		req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+		req.Header.Set("Content-Type", contentType)
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
 	}
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=510&offset=0&order=ASC&username="+defaultUsername, nil)
+	// now add the user
+	form.Set("s3_storage_class_rules", "GLACIER::/archive::.zip, .tar::\nSTANDARD_IA::::::1073741824\n")
+	form.Set("s3_session_token", "session-token")
+	form.Set("s3_role_arn", "arn:aws:iam::123456789012:role/sftpgo")
+	form.Set("s3_external_id", "external-id")
+	form.Set("s3_force_path_style", "on")
+	form.Set("s3_ca_bundle", httpsCert)
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 	var users []dataprovider.User
@@ -1523,40 +7047,97 @@ func TestGetUsersMock(t *testing.T) {
 	if len(users) != 1 {
 		t.Errorf("1 user is expected")
 	}
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=a&offset=0&order=ASC", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=a&order=ASC", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASCa", nil)
+	updateUser := users[0]
+	if updateUser.ExpirationDate != 1577836800000 {
+		t.Errorf("invalid expiration date: %v", updateUser.ExpirationDate)
+	}
+	if updateUser.FsConfig.S3Config.Bucket != user.FsConfig.S3Config.Bucket {
+		t.Error("s3 bucket mismatch")
+	}
+	if updateUser.FsConfig.S3Config.Region != user.FsConfig.S3Config.Region {
+		t.Error("s3 region mismatch")
+	}
+	if updateUser.FsConfig.S3Config.AccessKey != user.FsConfig.S3Config.AccessKey {
+		t.Error("s3 access key mismatch")
+	}
+	if !strings.HasPrefix(updateUser.FsConfig.S3Config.AccessSecret, "$aes$") {
+		t.Error("s3 access secret is not encrypted")
+	}
+	if !strings.HasPrefix(updateUser.FsConfig.S3Config.SessionToken, "$aes$") {
+		t.Error("s3 session token is not encrypted")
+	}
+	if updateUser.FsConfig.S3Config.RoleARN != "arn:aws:iam::123456789012:role/sftpgo" {
+		t.Error("s3 role ARN mismatch")
+	}
+	if updateUser.FsConfig.S3Config.ExternalID != "external-id" {
+		t.Error("s3 external ID mismatch")
+	}
+	if !updateUser.FsConfig.S3Config.ForcePathStyle || updateUser.FsConfig.S3Config.SkipTLSVerify {
+		t.Error("s3 path style or skip TLS verify mismatch")
+	}
+	if updateUser.FsConfig.S3Config.CABundle != strings.TrimSpace(httpsCert) {
+		t.Error("s3 CA bundle mismatch")
+	}
+	if updateUser.FsConfig.S3Config.DownloadPartSize != 10 || updateUser.FsConfig.S3Config.DownloadConcurrency != 6 {
+		t.Error("s3 download part size or concurrency mismatch")
+	}
+	if updateUser.FsConfig.S3Config.StorageClass != user.FsConfig.S3Config.StorageClass {
+		t.Error("s3 storage class mismatch")
+	}
+	if updateUser.FsConfig.S3Config.Endpoint != user.FsConfig.S3Config.Endpoint {
+		t.Error("s3 endpoint mismatch")
+	}
+	if updateUser.FsConfig.S3Config.KeyPrefix != user.FsConfig.S3Config.KeyPrefix {
+		t.Error("s3 key prefix mismatch")
+	}
+	if updateUser.FsConfig.S3Config.UploadPartSize != user.FsConfig.S3Config.UploadPartSize {
+		t.Error("s3 upload part size mismatch")
+	}
+	if updateUser.FsConfig.S3Config.UploadConcurrency != user.FsConfig.S3Config.UploadConcurrency {
+		t.Error("s3 upload concurrency mismatch")
+	}
+	rules := updateUser.FsConfig.S3Config.StorageClassRules
+	if len(rules) != 2 {
+		t.Errorf("unexpected storage class rules: %+v", rules)
+	} else {
+		if rules[0].StorageClass != "GLACIER" || rules[0].Path != "/archive" || len(rules[0].Extensions) != 2 ||
+			rules[0].MinSize != 0 {
+			t.Errorf("unexpected storage class rule: %+v", rules[0])
+		}
+		if rules[1].StorageClass != "STANDARD_IA" || rules[1].Path != "" || len(rules[1].Extensions) != 0 ||
+			rules[1].MinSize != 1073741824 {
+			t.Errorf("unexpected storage class rule: %+v", rules[1])
+		}
+	}
+	if len(updateUser.Filters.FileExtensions) != 2 {
+		t.Errorf("unexpected extensions filter: %+v", updateUser.Filters.FileExtensions)
+	}
+	// without an access key the default credential chain is used and the access secret is removed
+	form.Set("s3_access_key", "")
+	form.Set("s3_access_secret", updateUser.FsConfig.S3Config.AccessSecret)
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestDeleteUserInvalidParamsMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodDelete, userPath+"/0", nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/a", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestGetQuotaScansMock(t *testing.T) {
-	req, err := http.NewRequest("GET", quotaScanPath, nil)
+	var userNoKeys dataprovider.User
+	err = render.DecodeJSON(rr.Body, &userNoKeys)
 	if err != nil {
-		t.Errorf("error get quota scan: %v", err)
+		t.Errorf("Error decoding user: %v", err)
 	}
-	rr := executeRequest(req)
+	if len(userNoKeys.FsConfig.S3Config.AccessKey) > 0 || len(userNoKeys.FsConfig.S3Config.AccessSecret) > 0 {
+		t.Errorf("unexpected s3 credentials: %#v, %#v", userNoKeys.FsConfig.S3Config.AccessKey,
+			userNoKeys.FsConfig.S3Config.AccessSecret)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestStartQuotaScanMock(t *testing.T) {
+func TestWebUserGCSMock(t *testing.T) {
 	user := getTestUser()
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
@@ -1566,141 +7147,114 @@ func TestStartQuotaScanMock(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error get user: %v", err)
 	}
-	_, err = os.Stat(user.HomeDir)
-	if err == nil {
-		os.Remove(user.HomeDir)
+	credentialsFilePath := filepath.Join(os.TempDir(), "gcs.json")
+	err = createTestFile(credentialsFilePath, 0)
+	if err != nil {
+		t.Errorf("unable to create credential test file: %v", err)
 	}
-	// simulate a duplicate quota scan
-	userAsJSON = getUserAsJSON(t, user)
-	sftpd.AddQuotaScan(user.Username)
-	req, _ = http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusConflict, rr.Code)
-	sftpd.RemoveQuotaScan(user.Username)
-
-	userAsJSON = getUserAsJSON(t, user)
-	req, _ = http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
+	user.FsConfig.Provider = 2
+	user.FsConfig.GCSConfig.Bucket = "test"
+	user.FsConfig.GCSConfig.KeyPrefix = "somedir/subdir/"
+	user.FsConfig.GCSConfig.StorageClass = "standard"
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	form.Set("home_dir", user.HomeDir)
+	form.Set("uid", "0")
+	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
+	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
+	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
+	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
+	form.Set("upload_bandwidth", "0")
+	form.Set("download_bandwidth", "0")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", "")
+	form.Set("status", strconv.Itoa(user.Status))
+	form.Set("expiration_date", "2020-01-01 00:00:00")
+	form.Set("allowed_ip", "")
+	form.Set("denied_ip", "")
+	form.Set("fs_provider", "2")
+	form.Set("gcs_bucket", user.FsConfig.GCSConfig.Bucket)
+	form.Set("gcs_storage_class", user.FsConfig.GCSConfig.StorageClass)
+	form.Set("gcs_key_prefix", user.FsConfig.GCSConfig.KeyPrefix)
+	form.Set("gcs_download_part_size", "12")
+	form.Set("gcs_download_concurrency", "3")
+	form.Set("allowed_extensions", "/dir1::.jpg,.png")
+	b, contentType, _ := getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr.Code)
-
-	req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	b, contentType, _ = getMultipartFormData(form, "gcs_credential_file", credentialsFilePath)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	var scans []sftpd.ActiveQuotaScan
-	err = render.DecodeJSON(rr.Body, &scans)
+	err = createTestFile(credentialsFilePath, 4096)
 	if err != nil {
-		t.Errorf("Error get active scans: %v", err)
+		t.Errorf("unable to create credential test file: %v", err)
 	}
-	for len(scans) > 0 {
-		req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
-		rr = executeRequest(req)
-		checkResponseCode(t, http.StatusOK, rr.Code)
-		err = render.DecodeJSON(rr.Body, &scans)
-		if err != nil {
-			t.Errorf("Error get active scans: %v", err)
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+	b, contentType, _ = getMultipartFormData(form, "gcs_credential_file", credentialsFilePath)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var users []dataprovider.User
+	render.DecodeJSON(rr.Body, &users)
+	if len(users) != 1 {
+		t.Errorf("1 user is expected")
 	}
-	_, err = os.Stat(user.HomeDir)
-	if err != nil && os.IsNotExist(err) {
-		os.MkdirAll(user.HomeDir, 0777)
+	updateUser := users[0]
+	if updateUser.ExpirationDate != 1577836800000 {
+		t.Errorf("invalid expiration date: %v", updateUser.ExpirationDate)
 	}
-	req, _ = http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
+	if updateUser.FsConfig.Provider != user.FsConfig.Provider {
+		t.Error("fs provider mismatch")
+	}
+	if updateUser.FsConfig.GCSConfig.Bucket != user.FsConfig.GCSConfig.Bucket {
+		t.Error("GCS bucket mismatch")
+	}
+	if updateUser.FsConfig.GCSConfig.StorageClass != user.FsConfig.GCSConfig.StorageClass {
+		t.Error("GCS storage class mismatch")
+	}
+	if updateUser.FsConfig.GCSConfig.KeyPrefix != user.FsConfig.GCSConfig.KeyPrefix {
+		t.Error("GCS key prefix mismatch")
+	}
+	if updateUser.FsConfig.GCSConfig.DownloadPartSize != 12 || updateUser.FsConfig.GCSConfig.DownloadConcurrency != 3 {
+		t.Error("GCS download part size or concurrency mismatch")
+	}
+	if updateUser.Filters.FileExtensions[0].Path != "/dir1" {
+		t.Errorf("unexpected extensions filter: %+v", updateUser.Filters.FileExtensions)
+	}
+	form.Set("gcs_auto_credentials", "on")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusCreated, rr.Code)
-
-	req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err = render.DecodeJSON(rr.Body, &scans)
+	err = render.DecodeJSON(rr.Body, &users)
 	if err != nil {
-		t.Errorf("Error get active scans: %v", err)
+		t.Errorf("Error decoding users: %v", err)
 	}
-	for len(scans) > 0 {
-		req, _ = http.NewRequest(http.MethodGet, quotaScanPath, nil)
-		rr = executeRequest(req)
-		checkResponseCode(t, http.StatusOK, rr.Code)
-		err = render.DecodeJSON(rr.Body, &scans)
-		if err != nil {
-			t.Errorf("Error get active scans: %v", err)
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+	if len(users) != 1 {
+		t.Errorf("1 user is expected")
+	}
+	updateUser = users[0]
+	if updateUser.FsConfig.GCSConfig.AutomaticCredentials != 1 {
+		t.Error("GCS automatic credentials mismatch")
 	}
-
 	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	os.RemoveAll(user.GetHomeDir())
-}
-
-func TestStartQuotaScanBadUserMock(t *testing.T) {
-	user := getTestUser()
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer(userAsJSON))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-}
-
-func TestStartQuotaScanNonExistentUserMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodPost, quotaScanPath, bytes.NewBuffer([]byte("invalid json")))
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
-}
-
-func TestGetVersionMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, versionPath, nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestGetConnectionsMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, activeConnectionsPath, nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestDeleteActiveConnectionMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodDelete, activeConnectionsPath+"/connectionID", nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-}
-
-func TestNotFoundMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/non/existing/path", nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-}
-
-func TestMethodNotAllowedMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodPost, activeConnectionsPath, nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusMethodNotAllowed, rr.Code)
-}
-
-func TestMetricsMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, metricsPath, nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestPProfEndPointMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, pprofPath, nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-}
-
-func TestGetWebRootMock(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/", nil)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusMovedPermanently, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webBasePath, nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusMovedPermanently, rr.Code)
+	os.Remove(credentialsFilePath)
 }
 
-func TestBasicWebUsersMock(t *testing.T) {
+func TestWebUserCryptMock(t *testing.T) {
 	user := getTestUser()
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
@@ -1710,232 +7264,218 @@ func TestBasicWebUsersMock(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error get user: %v", err)
 	}
-	user1 := getTestUser()
-	user1.Username += "1"
-	user1AsJSON := getUserAsJSON(t, user1)
-	req, _ = http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(user1AsJSON))
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	err = render.DecodeJSON(rr.Body, &user1)
-	if err != nil {
-		t.Errorf("Error get user1: %v", err)
-	}
-	req, _ = http.NewRequest(http.MethodGet, webUsersPath, nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webUsersPath+"?qlimit=a", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webUsersPath+"?qlimit=1", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webUserPath, nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webUserPath+"/"+strconv.FormatInt(user.ID, 10), nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webUserPath+"/0", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, webUserPath+"/a", nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
 	form := make(url.Values)
 	form.Set("username", user.Username)
+	form.Set("home_dir", user.HomeDir)
+	form.Set("uid", "0")
+	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
+	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
+	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
+	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
+	form.Set("upload_bandwidth", "0")
+	form.Set("download_bandwidth", "0")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", "")
+	form.Set("status", strconv.Itoa(user.Status))
+	form.Set("expiration_date", "")
+	form.Set("allowed_ip", "")
+	form.Set("denied_ip", "")
+	form.Set("fs_provider", "3")
+	form.Set("allowed_extensions", "")
+	form.Set("denied_extensions", "")
+	// an empty passphrase is not allowed if the user was not using the encrypted filesystem
 	b, contentType, _ := getMultipartFormData(form, "", "")
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("crypt_passphrase", "crypt passphrase")
 	b, contentType, _ = getMultipartFormData(form, "", "")
-	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/0", &b)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusNotFound, rr.Code)
-	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/a", &b)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	// an empty passphrase preserves the stored one
+	form.Set("crypt_passphrase", "")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if dataProviderUser.FsConfig.Provider != 3 {
+		t.Errorf("unexpected fs provider: %v", dataProviderUser.FsConfig.Provider)
+	}
+	passphrase, err := utils.DecryptData(dataProviderUser.FsConfig.CryptConfig.Passphrase)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored passphrase: %v", err)
+	}
+	if passphrase != "crypt passphrase" {
+		t.Errorf("unexpected passphrase: %#v", passphrase)
+	}
 	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user1.ID, 10), nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestWebUserAddMock(t *testing.T) {
+func TestWebUserWebDAVMock(t *testing.T) {
 	user := getTestUser()
-	user.UploadBandwidth = 32
-	user.DownloadBandwidth = 64
-	user.UID = 1000
-	mappedDir := filepath.Join(os.TempDir(), "mapped")
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
 	form := make(url.Values)
 	form.Set("username", user.Username)
 	form.Set("home_dir", user.HomeDir)
-	form.Set("password", user.Password)
+	form.Set("uid", "0")
+	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
+	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
+	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
+	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
+	form.Set("upload_bandwidth", "0")
+	form.Set("download_bandwidth", "0")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", "")
 	form.Set("status", strconv.Itoa(user.Status))
 	form.Set("expiration_date", "")
-	form.Set("permissions", "*")
-	form.Set("sub_dirs_permissions", " /subdir::list ,download ")
-	form.Set("virtual_folders", fmt.Sprintf(" /vdir:: %v ", mappedDir))
-	form.Set("allowed_extensions", "/dir1::.jpg,.png")
-	form.Set("denied_extensions", "/dir1::.zip")
+	form.Set("allowed_ip", "")
+	form.Set("denied_ip", "")
+	form.Set("fs_provider", "4")
+	form.Set("allowed_extensions", "")
+	form.Set("denied_extensions", "")
+	form.Set("webdav_endpoint", "ftp://127.0.0.1/dav")
+	form.Set("webdav_username", "webdav_user")
+	form.Set("webdav_password", "webdav password")
+	form.Set("webdav_root_path", "folder")
+	// invalid endpoint
 	b, contentType, _ := getMultipartFormData(form, "", "")
-	// test invalid url escape
-	req, _ := http.NewRequest(http.MethodPost, webUserPath+"?a=%2", &b)
-	req.Header.Set("Content-Type", contentType)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("public_keys", testPubKey)
-	form.Set("uid", strconv.FormatInt(int64(user.UID), 10))
-	form.Set("gid", "a")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid gid
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("gid", "0")
-	form.Set("max_sessions", "a")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid max sessions
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("max_sessions", "0")
-	form.Set("quota_size", "a")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid quota size
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("quota_size", "0")
-	form.Set("quota_files", "a")
+	form.Set("webdav_endpoint", "http://127.0.0.1:8080/dav")
 	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid quota files
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("quota_files", "0")
-	form.Set("upload_bandwidth", "a")
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	// an empty password preserves the stored one
+	form.Set("webdav_password", "")
 	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid upload bandwidth
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("upload_bandwidth", strconv.FormatInt(user.UploadBandwidth, 10))
-	form.Set("download_bandwidth", "a")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid download bandwidth
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if dataProviderUser.FsConfig.Provider != 4 {
+		t.Errorf("unexpected fs provider: %v", dataProviderUser.FsConfig.Provider)
+	}
+	if dataProviderUser.FsConfig.WebDAVConfig.Endpoint != "http://127.0.0.1:8080/dav" {
+		t.Errorf("unexpected endpoint: %#v", dataProviderUser.FsConfig.WebDAVConfig.Endpoint)
+	}
+	if dataProviderUser.FsConfig.WebDAVConfig.RootPath != "folder/" {
+		t.Errorf("unexpected root path: %#v", dataProviderUser.FsConfig.WebDAVConfig.RootPath)
+	}
+	password, err := utils.DecryptData(dataProviderUser.FsConfig.WebDAVConfig.Password)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored password: %v", err)
+	}
+	if password != "webdav password" {
+		t.Errorf("unexpected password: %#v", password)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("download_bandwidth", strconv.FormatInt(user.DownloadBandwidth, 10))
-	form.Set("status", "a")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid status
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
-	rr = executeRequest(req)
+}
+
+func TestWebUserHDFSMock(t *testing.T) {
+	user := getTestUser()
+	userAsJSON := getUserAsJSON(t, user)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &user)
+	if err != nil {
+		t.Errorf("Error get user: %v", err)
+	}
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	form.Set("home_dir", user.HomeDir)
+	form.Set("uid", "0")
+	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
+	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
+	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
+	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
+	form.Set("upload_bandwidth", "0")
+	form.Set("download_bandwidth", "0")
+	form.Set("permissions", "*")
+	form.Set("sub_dirs_permissions", "")
 	form.Set("status", strconv.Itoa(user.Status))
-	form.Set("expiration_date", "123")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid expiration date
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
 	form.Set("expiration_date", "")
-	form.Set("allowed_ip", "invalid,ip")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid allowed_ip
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
-	req.Header.Set("Content-Type", contentType)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
 	form.Set("allowed_ip", "")
-	form.Set("denied_ip", "192.168.1.2") // it should be 192.168.1.2/32
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	// test invalid denied_ip
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	form.Set("denied_ip", "")
+	form.Set("fs_provider", "5")
+	form.Set("allowed_extensions", "")
+	form.Set("denied_extensions", "")
+	form.Set("hdfs_endpoint", "http://namenode:9870/webhdfs/v1")
+	form.Set("hdfs_username", "hdfs")
+	form.Set("hdfs_delegation_token", "hdfs token")
+	form.Set("hdfs_root_path", "data")
+	// username and delegation token cannot be used together
+	b, contentType, _ := getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	form.Set("denied_ip", "")
+	form.Set("hdfs_username", "")
 	b, contentType, _ = getMultipartFormData(form, "", "")
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusSeeOther, rr.Code)
-	// the user already exists, was created with the above request
+	// an empty token preserves the stored one
+	form.Set("hdfs_delegation_token", "")
 	b, contentType, _ = getMultipartFormData(form, "", "")
-	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
-	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	var users []dataprovider.User
-	err := render.DecodeJSON(rr.Body, &users)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
 	if err != nil {
-		t.Errorf("Error decoding users: %v", err)
-	}
-	if len(users) != 1 {
-		t.Errorf("1 user is expected, actual: %v", len(users))
-	}
-	newUser := users[0]
-	if newUser.UID != user.UID {
-		t.Errorf("uid does not match")
-	}
-	if newUser.UploadBandwidth != user.UploadBandwidth {
-		t.Errorf("upload_bandwidth does not match")
-	}
-	if newUser.DownloadBandwidth != user.DownloadBandwidth {
-		t.Errorf("download_bandwidth does not match")
+		t.Errorf("unable to get user from the data provider: %v", err)
 	}
-	if !utils.IsStringInSlice(testPubKey, newUser.PublicKeys) {
-		t.Errorf("public_keys does not match")
+	if dataProviderUser.FsConfig.Provider != 5 {
+		t.Errorf("unexpected fs provider: %v", dataProviderUser.FsConfig.Provider)
 	}
-	if val, ok := newUser.Permissions["/subdir"]; ok {
-		if !utils.IsStringInSlice(dataprovider.PermListItems, val) || !utils.IsStringInSlice(dataprovider.PermDownload, val) {
-			t.Error("permssions for /subdir does not match")
-		}
-	} else {
-		t.Errorf("user permissions must contain /somedir, actual: %v", newUser.Permissions)
+	if dataProviderUser.FsConfig.HDFSConfig.Endpoint != "http://namenode:9870/webhdfs/v1" {
+		t.Errorf("unexpected endpoint: %#v", dataProviderUser.FsConfig.HDFSConfig.Endpoint)
 	}
-	vfolderFoumd := false
-	for _, v := range newUser.VirtualFolders {
-		if v.VirtualPath == "/vdir" && v.MappedPath == mappedDir {
-			vfolderFoumd = true
-		}
+	if dataProviderUser.FsConfig.HDFSConfig.RootPath != "data/" {
+		t.Errorf("unexpected root path: %#v", dataProviderUser.FsConfig.HDFSConfig.RootPath)
 	}
-	if !vfolderFoumd {
-		t.Errorf("virtual folders must contain /vdir, actual: %+v", newUser.VirtualFolders)
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.HDFSConfig.DelegationToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored delegation token: %v", err)
 	}
-	extFilters := newUser.Filters.FileExtensions[0]
-	if !utils.IsStringInSlice(".zip", extFilters.DeniedExtensions) {
-		t.Errorf("unexpected denied extensions: %v", extFilters.DeniedExtensions)
+	if token != "hdfs token" {
+		t.Errorf("unexpected delegation token: %#v", token)
 	}
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(newUser.ID, 10), nil)
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestWebUserUpdateMock(t *testing.T) {
+func TestWebUserGoogleDriveMock(t *testing.T) {
 	user := getTestUser()
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
@@ -1945,10 +7485,6 @@ func TestWebUserUpdateMock(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error get user: %v", err)
 	}
-	user.MaxSessions = 1
-	user.QuotaFiles = 2
-	user.QuotaSize = 3
-	user.GID = 1000
 	form := make(url.Values)
 	form.Set("username", user.Username)
 	form.Set("home_dir", user.HomeDir)
@@ -1960,67 +7496,69 @@ func TestWebUserUpdateMock(t *testing.T) {
 	form.Set("upload_bandwidth", "0")
 	form.Set("download_bandwidth", "0")
 	form.Set("permissions", "*")
-	form.Set("sub_dirs_permissions", "/otherdir :: list ,upload ")
+	form.Set("sub_dirs_permissions", "")
 	form.Set("status", strconv.Itoa(user.Status))
-	form.Set("expiration_date", "2020-01-01 00:00:00")
-	form.Set("allowed_ip", " 192.168.1.3/32, 192.168.2.0/24 ")
-	form.Set("denied_ip", " 10.0.0.2/32 ")
-	form.Set("denied_extensions", "/dir1::.zip")
-	form.Set("ssh_login_methods", dataprovider.SSHLoginMethodKeyboardInteractive)
+	form.Set("expiration_date", "")
+	form.Set("allowed_ip", "")
+	form.Set("denied_ip", "")
+	form.Set("fs_provider", "6")
+	form.Set("allowed_extensions", "")
+	form.Set("denied_extensions", "")
+	form.Set("gdrive_folder_id", "folder_id")
+	form.Set("gdrive_client_id", "client_id")
+	form.Set("gdrive_client_secret", "client secret")
+	form.Set("gdrive_refresh_token", "refresh token")
+	form.Set("gdrive_subject", "user@example.com")
+	// the subject requires a service account
 	b, contentType, _ := getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("gdrive_subject", "")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusSeeOther, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
+	// empty secrets preserve the stored ones
+	form.Set("gdrive_client_secret", "")
+	form.Set("gdrive_refresh_token", "")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
+	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	var users []dataprovider.User
-	render.DecodeJSON(rr.Body, &users)
-	if len(users) != 1 {
-		t.Errorf("1 user is expected")
-	}
-	updateUser := users[0]
-	if user.HomeDir != updateUser.HomeDir {
-		t.Errorf("home dir does not match")
-	}
-	if user.MaxSessions != updateUser.MaxSessions {
-		t.Errorf("max_sessions does not match")
-	}
-	if user.QuotaFiles != updateUser.QuotaFiles {
-		t.Errorf("quota_files does not match")
-	}
-	if user.QuotaSize != updateUser.QuotaSize {
-		t.Errorf("quota_size does not match")
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
 	}
-	if user.GID != updateUser.GID {
-		t.Errorf("gid does not match")
+	if dataProviderUser.FsConfig.Provider != 6 {
+		t.Errorf("unexpected fs provider: %v", dataProviderUser.FsConfig.Provider)
 	}
-	if val, ok := updateUser.Permissions["/otherdir"]; ok {
-		if !utils.IsStringInSlice(dataprovider.PermListItems, val) || !utils.IsStringInSlice(dataprovider.PermUpload, val) {
-			t.Error("permssions for /otherdir does not match")
-		}
-	} else {
-		t.Errorf("user permissions must contains /otherdir, actual: %v", updateUser.Permissions)
+	if dataProviderUser.FsConfig.GoogleDriveConfig.FolderID != "folder_id" {
+		t.Errorf("unexpected folder ID: %#v", dataProviderUser.FsConfig.GoogleDriveConfig.FolderID)
 	}
-	if !utils.IsStringInSlice("192.168.1.3/32", updateUser.Filters.AllowedIP) {
-		t.Errorf("Allowed IP/Mask does not match: %v", updateUser.Filters.AllowedIP)
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.GoogleDriveConfig.RefreshToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored refresh token: %v", err)
 	}
-	if !utils.IsStringInSlice("10.0.0.2/32", updateUser.Filters.DeniedIP) {
-		t.Errorf("Denied IP/Mask does not match: %v", updateUser.Filters.DeniedIP)
+	if token != "refresh token" {
+		t.Errorf("unexpected refresh token: %#v", token)
 	}
-	if !utils.IsStringInSlice(dataprovider.SSHLoginMethodKeyboardInteractive, updateUser.Filters.DeniedLoginMethods) {
-		t.Errorf("Denied login methods does not match: %v", updateUser.Filters.DeniedLoginMethods)
+	secret, err := utils.DecryptData(dataProviderUser.FsConfig.GoogleDriveConfig.ClientSecret)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored client secret: %v", err)
 	}
-	if !utils.IsStringInSlice(".zip", updateUser.Filters.FileExtensions[0].DeniedExtensions) {
-		t.Errorf("unexpected extensions filter: %+v", updateUser.Filters.FileExtensions)
+	if secret != "client secret" {
+		t.Errorf("unexpected client secret: %#v", secret)
 	}
 	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestWebUserS3Mock(t *testing.T) {
+func TestWebUserDropboxMock(t *testing.T) {
 	user := getTestUser()
 	userAsJSON := getUserAsJSON(t, user)
 	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
@@ -2030,16 +7568,6 @@ func TestWebUserS3Mock(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error get user: %v", err)
 	}
-	user.FsConfig.Provider = 1
-	user.FsConfig.S3Config.Bucket = "test"
-	user.FsConfig.S3Config.Region = "eu-west-1"
-	user.FsConfig.S3Config.AccessKey = "access-key"
-	user.FsConfig.S3Config.AccessSecret = "access-secret"
-	user.FsConfig.S3Config.Endpoint = "http://127.0.0.1:9000/path?a=b"
-	user.FsConfig.S3Config.StorageClass = "Standard"
-	user.FsConfig.S3Config.KeyPrefix = "somedir/subdir/"
-	user.FsConfig.S3Config.UploadPartSize = 5
-	user.FsConfig.S3Config.UploadConcurrency = 4
 	form := make(url.Values)
 	form.Set("username", user.Username)
 	form.Set("home_dir", user.HomeDir)
@@ -2053,201 +7581,710 @@ func TestWebUserS3Mock(t *testing.T) {
 	form.Set("permissions", "*")
 	form.Set("sub_dirs_permissions", "")
 	form.Set("status", strconv.Itoa(user.Status))
-	form.Set("expiration_date", "2020-01-01 00:00:00")
+	form.Set("expiration_date", "")
 	form.Set("allowed_ip", "")
 	form.Set("denied_ip", "")
-	form.Set("fs_provider", "1")
-	form.Set("s3_bucket", user.FsConfig.S3Config.Bucket)
-	form.Set("s3_region", user.FsConfig.S3Config.Region)
-	form.Set("s3_access_key", user.FsConfig.S3Config.AccessKey)
-	form.Set("s3_access_secret", user.FsConfig.S3Config.AccessSecret)
-	form.Set("s3_storage_class", user.FsConfig.S3Config.StorageClass)
-	form.Set("s3_endpoint", user.FsConfig.S3Config.Endpoint)
-	form.Set("s3_key_prefix", user.FsConfig.S3Config.KeyPrefix)
-	form.Set("allowed_extensions", "/dir1::.jpg,.png")
-	form.Set("denied_extensions", "/dir2::.zip")
-	// test invalid s3_upload_part_size
-	form.Set("s3_upload_part_size", "a")
+	form.Set("fs_provider", "7")
+	form.Set("allowed_extensions", "")
+	form.Set("denied_extensions", "")
+	form.Set("dropbox_root_path", "sftpgo")
+	form.Set("dropbox_refresh_token", "refresh token")
+	form.Set("dropbox_app_key", "app_key")
+	form.Set("dropbox_app_secret", "app secret")
+	form.Set("dropbox_upload_chunk_size", "a")
+	// invalid upload chunk size
 	b, contentType, _ := getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	// test invalid s3_concurrency
-	form.Set("s3_upload_part_size", strconv.FormatInt(user.FsConfig.S3Config.UploadPartSize, 10))
-	form.Set("s3_upload_concurrency", "a")
+	form.Set("dropbox_upload_chunk_size", "4")
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr.Code)
-	// now add the user
-	form.Set("s3_upload_concurrency", strconv.Itoa(user.FsConfig.S3Config.UploadConcurrency))
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	// empty secrets preserve the stored ones
+	form.Set("dropbox_refresh_token", "")
+	form.Set("dropbox_app_secret", "")
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
 	req.Header.Set("Content-Type", contentType)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusSeeOther, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
+	dataProviderUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user from the data provider: %v", err)
+	}
+	if dataProviderUser.FsConfig.Provider != 7 {
+		t.Errorf("unexpected fs provider: %v", dataProviderUser.FsConfig.Provider)
+	}
+	if dataProviderUser.FsConfig.DropboxConfig.RootPath != "sftpgo/" {
+		t.Errorf("unexpected root path: %#v", dataProviderUser.FsConfig.DropboxConfig.RootPath)
+	}
+	if dataProviderUser.FsConfig.DropboxConfig.UploadChunkSize != 4 {
+		t.Errorf("unexpected upload chunk size: %v", dataProviderUser.FsConfig.DropboxConfig.UploadChunkSize)
+	}
+	token, err := utils.DecryptData(dataProviderUser.FsConfig.DropboxConfig.RefreshToken)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored refresh token: %v", err)
+	}
+	if token != "refresh token" {
+		t.Errorf("unexpected refresh token: %#v", token)
+	}
+	secret, err := utils.DecryptData(dataProviderUser.FsConfig.DropboxConfig.AppSecret)
+	if err != nil {
+		t.Errorf("unable to decrypt the stored app secret: %v", err)
+	}
+	if secret != "app secret" {
+		t.Errorf("unexpected app secret: %#v", secret)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	var users []dataprovider.User
-	err = render.DecodeJSON(rr.Body, &users)
+}
+
+func TestIPListMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, ipListPath+"?type=a", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListPath+"?type=3", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, ipListPath+"/a", bytes.NewBuffer([]byte("{")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, ipListPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, ipListPath, bytes.NewBuffer([]byte("{")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	entry := dataprovider.IPListEntry{
+		IPOrNet: "10.8.0.0/16",
+		Type:    dataprovider.IPListTypeBlock,
+	}
+	entryAsJSON, _ := json.Marshal(entry)
+	req, _ = http.NewRequest(http.MethodPost, ipListPath, bytes.NewBuffer(entryAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err := render.DecodeJSON(rr.Body, &entry)
 	if err != nil {
-		t.Errorf("Error decoding users: %v", err)
+		t.Errorf("Error get IP list entry: %v", err)
 	}
-	if len(users) != 1 {
-		t.Errorf("1 user is expected")
+	req, _ = http.NewRequest(http.MethodPut, ipListPath+"/"+strconv.FormatInt(entry.ID, 10), bytes.NewBuffer([]byte("{")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, ipListPath+"/"+strconv.FormatInt(entry.ID+1, 10), bytes.NewBuffer(entryAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	otherEntry := entry
+	otherEntry.ID = entry.ID + 1
+	otherEntryAsJSON, _ := json.Marshal(otherEntry)
+	req, _ = http.NewRequest(http.MethodPut, ipListPath+"/"+strconv.FormatInt(entry.ID, 10), bytes.NewBuffer(otherEntryAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	// a request from a blocked address
+	req, _ = http.NewRequest(http.MethodGet, versionPath, nil)
+	req.RemoteAddr = "10.8.1.1:1234"
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	// the address of the direct peer is checked, the X-Real-IP header is ignored here
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Real-IP", "10.8.1.1")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, ipListPath+"/"+strconv.FormatInt(entry.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+}
+
+func TestDefenderMock(t *testing.T) {
+	// the defender is disabled in the test configuration
+	req, _ := http.NewRequest(http.MethodGet, defenderHostsPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var hosts []sftpd.DefenderEntry
+	err := render.DecodeJSON(rr.Body, &hosts)
+	if err != nil {
+		t.Errorf("Error get defender hosts: %v", err)
 	}
-	updateUser := users[0]
-	if updateUser.ExpirationDate != 1577836800000 {
-		t.Errorf("invalid expiration date: %v", updateUser.ExpirationDate)
+	if len(hosts) != 0 {
+		t.Errorf("unexpected defender hosts: %+v", hosts)
 	}
-	if updateUser.FsConfig.S3Config.Bucket != user.FsConfig.S3Config.Bucket {
-		t.Error("s3 bucket mismatch")
+	req, _ = http.NewRequest(http.MethodGet, defenderHostsPath+"/127.0.0.1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, defenderHostsPath+"/invalid", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, defenderHostsPath+"/127.0.0.1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodDelete, defenderHostsPath+"/invalid", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, defenderBanPath, bytes.NewBuffer([]byte("{")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, defenderBanPath, bytes.NewBuffer([]byte(`{"ip":"127.0.0.1"}`)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	if !strings.Contains(rr.Body.String(), sftpd.ErrDefenderDisabled.Error()) {
+		t.Errorf("unexpected response: %v", rr.Body.String())
 	}
-	if updateUser.FsConfig.S3Config.Region != user.FsConfig.S3Config.Region {
-		t.Error("s3 region mismatch")
+}
+
+func TestIPListImportExportMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, ipListExportPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListExportPath+"?type=3", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListExportPath+"?type=2&format=xml", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, ipListImportPath, bytes.NewBuffer([]byte("{")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	set := dataprovider.IPListSet{
+		Type:    dataprovider.IPListTypeBlock,
+		Entries: []string{"10.9.0.0/16", "10.10.1.1"},
 	}
-	if updateUser.FsConfig.S3Config.AccessKey != user.FsConfig.S3Config.AccessKey {
-		t.Error("s3 access key mismatch")
+	setAsJSON, _ := json.Marshal(set)
+	req, _ = http.NewRequest(http.MethodPost, ipListImportPath+"?replace=a", bytes.NewBuffer(setAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, ipListImportPath+"?replace=false", bytes.NewBuffer(setAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	// the text format can be used as IP list feed by other instances
+	req, _ = http.NewRequest(http.MethodGet, ipListExportPath+"?type=2&format=text", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type: %v", rr.Header().Get("Content-Type"))
 	}
-	if !strings.HasPrefix(updateUser.FsConfig.S3Config.AccessSecret, "$aes$") {
-		t.Error("s3 access secret is not encrypted")
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 || !utils.IsStringInSlice("10.9.0.0/16", lines) || !utils.IsStringInSlice("10.10.1.1", lines) {
+		t.Errorf("unexpected text export: %#v", rr.Body.String())
 	}
-	if updateUser.FsConfig.S3Config.StorageClass != user.FsConfig.S3Config.StorageClass {
-		t.Error("s3 storage class mismatch")
+	set.Entries = nil
+	setAsJSON, _ = json.Marshal(set)
+	req, _ = http.NewRequest(http.MethodPost, ipListImportPath+"?replace=1", bytes.NewBuffer(setAsJSON))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var result dataprovider.IPListImportResult
+	err := render.DecodeJSON(rr.Body, &result)
+	if err != nil {
+		t.Errorf("Error get import result: %v", err)
 	}
-	if updateUser.FsConfig.S3Config.Endpoint != user.FsConfig.S3Config.Endpoint {
-		t.Error("s3 endpoint mismatch")
+	if result.Removed != 2 {
+		t.Errorf("unexpected import result: %+v", result)
 	}
-	if updateUser.FsConfig.S3Config.KeyPrefix != user.FsConfig.S3Config.KeyPrefix {
-		t.Error("s3 key prefix mismatch")
+}
+
+func TestWebIPListMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, webIPListPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webIPListEntryPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form := make(url.Values)
+	form.Set("ipornet", "172.16.0.0/12")
+	form.Set("type", "a")
+	form.Set("description", "web entry")
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("type", "3")
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("type", "2")
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListPath+"?type=2", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var entries []dataprovider.IPListEntry
+	err := render.DecodeJSON(rr.Body, &entries)
+	if err != nil {
+		t.Errorf("Error decoding IP list entries: %v", err)
 	}
-	if updateUser.FsConfig.S3Config.UploadPartSize != user.FsConfig.S3Config.UploadPartSize {
-		t.Error("s3 upload part size mismatch")
+	if len(entries) != 1 {
+		t.Errorf("unexpected number of IP list entries: %v", len(entries))
+		return
 	}
-	if updateUser.FsConfig.S3Config.UploadConcurrency != user.FsConfig.S3Config.UploadConcurrency {
-		t.Error("s3 upload concurrency mismatch")
+	entry := entries[0]
+	if entry.IPOrNet != "172.16.0.0/12" || entry.Description != "web entry" {
+		t.Errorf("unexpected IP list entry: %+v", entry)
 	}
-	if len(updateUser.Filters.FileExtensions) != 2 {
-		t.Errorf("unexpected extensions filter: %+v", updateUser.Filters.FileExtensions)
+	req, _ = http.NewRequest(http.MethodGet, webIPListEntryPath+"/"+strconv.FormatInt(entry.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webIPListEntryPath+"/"+strconv.FormatInt(entry.ID+1, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webIPListEntryPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	form.Set("type", "1")
+	form.Set("description", "updated web entry")
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath+"/"+strconv.FormatInt(entry.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	form.Set("type", "b")
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath+"/"+strconv.FormatInt(entry.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("type", "1")
+	form.Set("ipornet", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath+"/"+strconv.FormatInt(entry.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath+"/"+strconv.FormatInt(entry.ID+1, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath+"/a", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListPath+"/"+strconv.FormatInt(entry.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	err = render.DecodeJSON(rr.Body, &entry)
+	if err != nil {
+		t.Errorf("Error decoding IP list entry: %v", err)
 	}
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	if entry.Type != dataprovider.IPListTypeSafe || entry.Description != "updated web entry" {
+		t.Errorf("unexpected IP list entry: %+v", entry)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, ipListPath+"/"+strconv.FormatInt(entry.ID, 10), nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
 }
 
-func TestWebUserGCSMock(t *testing.T) {
-	user := getTestUser()
-	userAsJSON := getUserAsJSON(t, user)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer(userAsJSON))
+func TestWebIPListCheckMock(t *testing.T) {
+	entry := dataprovider.IPListEntry{
+		IPOrNet: "10.11.1.1",
+		Type:    dataprovider.IPListTypeBlock,
+	}
+	entryAsJSON, _ := json.Marshal(entry)
+	req, _ := http.NewRequest(http.MethodPost, ipListPath, bytes.NewBuffer(entryAsJSON))
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err := render.DecodeJSON(rr.Body, &user)
+	req, _ = http.NewRequest(http.MethodGet, webIPListPath+"?ip=10.11.1.1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "10.11.1.1 is blocked") {
+		t.Errorf("the IP address must be reported as blocked")
+	}
+	req, _ = http.NewRequest(http.MethodGet, webIPListPath+"?ip=invalid", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "invalid IP address") {
+		t.Errorf("the invalid IP address must be reported")
+	}
+	// the entry form can be prefilled to add an address to the safe list
+	req, _ = http.NewRequest(http.MethodGet, webIPListEntryPath+"?ipornet=10.11.1.2&type=1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "10.11.1.2") {
+		t.Errorf("the entry form must be prefilled")
+	}
+	form := make(url.Values)
+	form.Set("ip", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webIPListUnblockPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("ip", "10.11.1.1")
+	req, _ = http.NewRequest(http.MethodPost, webIPListUnblockPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	if rr.Header().Get("Location") != webIPListPath+"?ip=10.11.1.1" {
+		t.Errorf("unexpected redirect: %v", rr.Header().Get("Location"))
+	}
+	req, _ = http.NewRequest(http.MethodGet, rr.Header().Get("Location"), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "10.11.1.1 is allowed") {
+		t.Errorf("the IP address must be reported as allowed")
+	}
+	req, _ = http.NewRequest(http.MethodGet, ipListCheckPath+"?ip=10.11.1.1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var result dataprovider.IPListCheckResult
+	err := render.DecodeJSON(rr.Body, &result)
+	if err != nil {
+		t.Errorf("Error decoding check result: %v", err)
+	}
+	if result.Blocked || len(result.BlockEntries) != 0 || len(result.SafeEntries) != 0 {
+		t.Errorf("unexpected check result: %+v", result)
+	}
+}
+
+func TestWebPlansMock(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, webPlansPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webPlanPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	csrfToken := getCSRFTokenFromBody(t, rr.Body.String())
+	form := make(url.Values)
+	form.Set("name", "web_plan")
+	form.Set("description", "web plan")
+	form.Set("max_sessions", "2")
+	form.Set("quota_size", "1048576")
+	form.Set("quota_files", "100")
+	form.Set("upload_bandwidth", "64")
+	form.Set("download_bandwidth", "128")
+	form.Set("password_breach_check", "2")
+	form.Add("fs_providers", "0")
+	form.Add("fs_providers", "1")
+	form.Add("ssh_login_methods", dataprovider.SSHLoginMethodPassword)
+	// the form token is required
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	form.Set("_form_token", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	form.Set("_form_token", csrfToken)
+	form.Set("max_sessions", "a")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("max_sessions", "2")
+	form.Set("password_breach_check", "a")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("password_breach_check", "3")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("password_breach_check", "2")
+	form.Set("name", "invalid name")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("name", "web_plan")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	plans, _, err := httpd.GetPlans(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plans: %v", err)
+	}
+	if len(plans) != 1 {
+		t.Errorf("unexpected number of plans: %v", len(plans))
+		return
+	}
+	plan := plans[0]
+	if plan.Name != "web_plan" || plan.MaxSessions != 2 || plan.QuotaSize != 1048576 || plan.QuotaFiles != 100 ||
+		plan.UploadBandwidth != 64 || plan.DownloadBandwidth != 128 || len(plan.AllowedFsProviders) != 2 ||
+		len(plan.DeniedLoginMethods) != 1 || plan.PasswordBreachCheck != 2 {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+	req, _ = http.NewRequest(http.MethodGet, webPlansPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "web_plan") {
+		t.Errorf("the plan must be listed")
+	}
+	req, _ = http.NewRequest(http.MethodGet, webPlanPath+"/"+strconv.FormatInt(plan.ID, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webPlanPath+"/"+strconv.FormatInt(plan.ID+1, 10), nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webPlanPath+"/a", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	// the plan name cannot be changed
+	form.Set("name", "renamed_plan")
+	form.Set("max_sessions", "3")
+	form.Del("fs_providers")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath+"/"+strconv.FormatInt(plan.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	form.Set("quota_files", "b")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath+"/"+strconv.FormatInt(plan.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("quota_files", "100")
+	form.Set("_form_token", "")
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath+"/"+strconv.FormatInt(plan.ID, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath+"/"+strconv.FormatInt(plan.ID+1, 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webPlanPath+"/a", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	plans, _, err = httpd.GetPlans(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plans: %v", err)
+	}
+	if len(plans) != 1 || plans[0].Name != "web_plan" || plans[0].MaxSessions != 3 || len(plans[0].AllowedFsProviders) != 0 {
+		t.Errorf("unexpected plans: %+v", plans)
+	}
+	// the web admin updates the assigned users in background
+	propagation := waitForPlanPropagation(t, "web_plan")
+	if propagation.Status != dataprovider.PlanPropagationCompleted || propagation.TotalUsers != 0 {
+		t.Errorf("unexpected plan propagation: %+v", propagation)
+	}
+	req, _ = http.NewRequest(http.MethodGet, webPlansPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "completed") {
+		t.Errorf("the plan propagation must be listed")
+	}
+	_, err = httpd.RemovePlan(plan, http.StatusOK)
 	if err != nil {
-		t.Errorf("Error get user: %v", err)
+		t.Errorf("unable to remove plan: %v", err)
 	}
-	credentialsFilePath := filepath.Join(os.TempDir(), "gcs.json")
-	err = createTestFile(credentialsFilePath, 0)
+}
+
+func TestWebFoldersMock(t *testing.T) {
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
 	if err != nil {
-		t.Errorf("unable to create credential test file: %v", err)
+		t.Errorf("unable to add user: %v", err)
 	}
-	user.FsConfig.Provider = 2
-	user.FsConfig.GCSConfig.Bucket = "test"
-	user.FsConfig.GCSConfig.KeyPrefix = "somedir/subdir/"
-	user.FsConfig.GCSConfig.StorageClass = "standard"
+	mappedPath := filepath.Join(os.TempDir(), "web_mapped_dir")
+	req, _ := http.NewRequest(http.MethodGet, webFolderPath+"?username="+user.Username, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	csrfToken := getCSRFTokenFromBody(t, rr.Body.String())
 	form := make(url.Values)
 	form.Set("username", user.Username)
-	form.Set("home_dir", user.HomeDir)
-	form.Set("uid", "0")
-	form.Set("gid", strconv.FormatInt(int64(user.GID), 10))
-	form.Set("max_sessions", strconv.FormatInt(int64(user.MaxSessions), 10))
-	form.Set("quota_size", strconv.FormatInt(user.QuotaSize, 10))
-	form.Set("quota_files", strconv.FormatInt(int64(user.QuotaFiles), 10))
-	form.Set("upload_bandwidth", "0")
-	form.Set("download_bandwidth", "0")
-	form.Set("permissions", "*")
-	form.Set("sub_dirs_permissions", "")
-	form.Set("status", strconv.Itoa(user.Status))
-	form.Set("expiration_date", "2020-01-01 00:00:00")
-	form.Set("allowed_ip", "")
-	form.Set("denied_ip", "")
-	form.Set("fs_provider", "2")
-	form.Set("gcs_bucket", user.FsConfig.GCSConfig.Bucket)
-	form.Set("gcs_storage_class", user.FsConfig.GCSConfig.StorageClass)
-	form.Set("gcs_key_prefix", user.FsConfig.GCSConfig.KeyPrefix)
-	form.Set("allowed_extensions", "/dir1::.jpg,.png")
-	b, contentType, _ := getMultipartFormData(form, "", "")
-	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
-	req.Header.Set("Content-Type", contentType)
+	form.Set("virtual_path", "/vdir")
+	form.Set("mapped_path", mappedPath)
+	req, _ = http.NewRequest(http.MethodPost, webFolderPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	form.Set("_form_token", csrfToken)
+	form.Set("virtual_path", "vdir")
+	req, _ = http.NewRequest(http.MethodPost, webFolderPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	b, contentType, _ = getMultipartFormData(form, "gcs_credential_file", credentialsFilePath)
-	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
-	req.Header.Set("Content-Type", contentType)
+	form.Set("virtual_path", "/vdir")
+	form.Set("username", "missing_user")
+	req, _ = http.NewRequest(http.MethodPost, webFolderPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err = createTestFile(credentialsFilePath, 4096)
-	if err != nil {
-		t.Errorf("unable to create credential test file: %v", err)
-	}
-	b, contentType, _ = getMultipartFormData(form, "gcs_credential_file", credentialsFilePath)
-	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
-	req.Header.Set("Content-Type", contentType)
+	form.Set("username", user.Username)
+	req, _ = http.NewRequest(http.MethodPost, webFolderPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusSeeOther, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if len(user.VirtualFolders) != 1 || user.VirtualFolders[0].VirtualPath != "/vdir" ||
+		user.VirtualFolders[0].MappedPath != mappedPath {
+		t.Errorf("unexpected virtual folders: %+v", user.VirtualFolders)
+	}
+	req, _ = http.NewRequest(http.MethodGet, webFoldersPath, nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	var users []dataprovider.User
-	render.DecodeJSON(rr.Body, &users)
-	if len(users) != 1 {
-		t.Errorf("1 user is expected")
+	if !strings.Contains(rr.Body.String(), mappedPath) {
+		t.Errorf("the virtual folder must be listed")
 	}
-	updateUser := users[0]
-	if updateUser.ExpirationDate != 1577836800000 {
-		t.Errorf("invalid expiration date: %v", updateUser.ExpirationDate)
+	req, _ = http.NewRequest(http.MethodGet, webFolderPath+"?username="+user.Username+"&virtual_path=/vdir", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webFolderPath+"?username="+user.Username+"&virtual_path=/missing", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webFolderPath+"?username=missing_user&virtual_path=/vdir", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	form.Set("original_virtual_path", "/missing")
+	req, _ = http.NewRequest(http.MethodPost, webFolderPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	form.Set("original_virtual_path", "/vdir")
+	form.Set("virtual_path", "/vdir_updated")
+	req, _ = http.NewRequest(http.MethodPost, webFolderPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
 	}
-	if updateUser.FsConfig.Provider != user.FsConfig.Provider {
-		t.Error("fs provider mismatch")
+	if len(user.VirtualFolders) != 1 || user.VirtualFolders[0].VirtualPath != "/vdir_updated" {
+		t.Errorf("unexpected virtual folders: %+v", user.VirtualFolders)
 	}
-	if updateUser.FsConfig.GCSConfig.Bucket != user.FsConfig.GCSConfig.Bucket {
-		t.Error("GCS bucket mismatch")
+	deleteForm := make(url.Values)
+	deleteForm.Set("username", user.Username)
+	deleteForm.Set("virtual_path", "/vdir_updated")
+	req, _ = http.NewRequest(http.MethodPost, webFolderDeletePath, strings.NewReader(deleteForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	deleteForm.Set("_form_token", csrfToken)
+	deleteForm.Set("virtual_path", "/vdir")
+	req, _ = http.NewRequest(http.MethodPost, webFolderDeletePath, strings.NewReader(deleteForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	deleteForm.Set("virtual_path", "/vdir_updated")
+	deleteForm.Set("username", "missing_user")
+	req, _ = http.NewRequest(http.MethodPost, webFolderDeletePath, strings.NewReader(deleteForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	deleteForm.Set("username", user.Username)
+	req, _ = http.NewRequest(http.MethodPost, webFolderDeletePath, strings.NewReader(deleteForm.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
 	}
-	if updateUser.FsConfig.GCSConfig.StorageClass != user.FsConfig.GCSConfig.StorageClass {
-		t.Error("GCS storage class mismatch")
+	if len(user.VirtualFolders) != 0 {
+		t.Errorf("unexpected virtual folders: %+v", user.VirtualFolders)
 	}
-	if updateUser.FsConfig.GCSConfig.KeyPrefix != user.FsConfig.GCSConfig.KeyPrefix {
-		t.Error("GCS key prefix mismatch")
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
 	}
-	if updateUser.Filters.FileExtensions[0].Path != "/dir1" {
-		t.Errorf("unexpected extensions filter: %+v", updateUser.Filters.FileExtensions)
+}
+
+func TestWebJobsMock(t *testing.T) {
+	user, _, err := httpd.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
 	}
-	form.Set("gcs_auto_credentials", "on")
-	b, contentType, _ = getMultipartFormData(form, "", "")
-	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/"+strconv.FormatInt(user.ID, 10), &b)
-	req.Header.Set("Content-Type", contentType)
+	os.MkdirAll(user.GetHomeDir(), 0777)
+	req, _ := http.NewRequest(http.MethodGet, webJobsPath, nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	csrfToken := getCSRFTokenFromBody(t, rr.Body.String())
+	form := make(url.Values)
+	form.Set("username", user.Username)
+	form.Set("action", "duplicates_scan")
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr.Code)
+	form.Set("_form_token", csrfToken)
+	form.Set("action", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	form.Set("action", "duplicates_scan")
+	form.Set("username", "missing_user")
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("username", user.Username)
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusSeeOther, rr.Code)
-	req, _ = http.NewRequest(http.MethodGet, userPath+"?limit=1&offset=0&order=ASC&username="+user.Username, nil)
+	for {
+		scans, _, err := httpd.GetDuplicatesScans(http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get duplicates scans: %v", err)
+			break
+		}
+		if len(scans) == 1 && scans[0].Status != httpd.DuplicatesScanRunning {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	req, _ = http.NewRequest(http.MethodGet, webJobsPath, nil)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	err = render.DecodeJSON(rr.Body, &users)
-	if err != nil {
-		t.Errorf("Error decoding users: %v", err)
+	if !strings.Contains(rr.Body.String(), httpd.DuplicatesScanCompleted) {
+		t.Errorf("the completed duplicate files scan must be listed")
 	}
-	if len(users) != 1 {
-		t.Errorf("1 user is expected")
+	form.Set("action", "cancel_duplicates_scan")
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	form.Set("action", "quota_scan")
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr.Code)
+	for {
+		scans, _, err := httpd.GetQuotaScans(http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get quota scans: %v", err)
+			break
+		}
+		if len(scans) == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
 	}
-	updateUser = users[0]
-	if updateUser.FsConfig.GCSConfig.AutomaticCredentials != 1 {
-		t.Error("GCS automatic credentials mismatch")
+	if !sftpd.AddQuotaScan(user.Username) {
+		t.Errorf("unable to add quota scan")
 	}
-	req, _ = http.NewRequest(http.MethodDelete, userPath+"/"+strconv.FormatInt(user.ID, 10), nil)
+	req, _ = http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
-	os.Remove(credentialsFilePath)
+	req, _ = http.NewRequest(http.MethodGet, webJobsPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), user.Username) {
+		t.Errorf("the active quota scan must be listed")
+	}
+	sftpd.RemoveQuotaScan(user.Username)
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
 }
 
 func TestProviderClosedMock(t *testing.T) {
@@ -2264,6 +8301,41 @@ func TestProviderClosedMock(t *testing.T) {
 	req, _ = http.NewRequest(http.MethodPost, webUserPath+"/0", strings.NewReader(form.Encode()))
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webIPListPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webIPListEntryPath+"/0", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webPlansPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webPlanPath+"/0", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webFoldersPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodPost, webIPListEntryPath+"/0", strings.NewReader(form.Encode()))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListPath+"/0", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, ipListCheckPath+"?ip=10.11.1.1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webIPListPath+"?ip=10.11.1.1", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	form.Set("ip", "10.11.1.1")
+	req, _ = http.NewRequest(http.MethodPost, webIPListUnblockPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
 	config.LoadConfig(configDir, "")
 	providerConf := config.GetProviderConf()
 	providerConf.CredentialsPath = credentialsPath
@@ -2280,6 +8352,99 @@ func TestGetWebConnectionsMock(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodGet, webConnectionsPath, nil)
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webConnectionsPath+"?client_software=WinSCP&tenant=ACME", nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "No matching connection") {
+		t.Errorf("unexpected response for filtered connections: %v", rr.Body.String())
+	}
+}
+
+func TestUIPreferencesMock(t *testing.T) {
+	state := `{"length": 50, "search": {"search": "sales"}, "columns": [{"visible": false}]}`
+	req, _ := http.NewRequest(http.MethodPut, uiPreferencesPath+"/users_table", strings.NewReader(state))
+	req.SetBasicAuth("admin1", "password")
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, uiPreferencesPath+"/users_table", nil)
+	req.SetBasicAuth("admin1", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var value map[string]interface{}
+	err := render.DecodeJSON(rr.Body, &value)
+	if err != nil {
+		t.Errorf("unable to decode the preference value: %v", err)
+	}
+	if value["length"] != float64(50) {
+		t.Errorf("unexpected preference value: %+v", value)
+	}
+	// the preferences are scoped to the admin
+	req, _ = http.NewRequest(http.MethodGet, uiPreferencesPath+"/users_table", nil)
+	req.SetBasicAuth("admin2", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/page.size", strings.NewReader("25"))
+	req.SetBasicAuth("admin1", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, uiPreferencesPath, nil)
+	req.SetBasicAuth("admin1", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	var preferences map[string]interface{}
+	err = render.DecodeJSON(rr.Body, &preferences)
+	if err != nil {
+		t.Errorf("unable to decode the preferences: %v", err)
+	}
+	if len(preferences) != 2 || preferences["page.size"] != float64(25) {
+		t.Errorf("unexpected preferences: %+v", preferences)
+	}
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/invalid%20key", strings.NewReader("25"))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/key", strings.NewReader("{invalid json"))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/key", strings.NewReader(`"`+strings.Repeat("a", 65536)+`"`))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusRequestEntityTooLarge, rr.Code)
+	for i := 0; i < 50; i++ {
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("%v/key%v", uiPreferencesPath, i), strings.NewReader("1"))
+		req.SetBasicAuth("admin2", "password")
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	}
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/key50", strings.NewReader("1"))
+	req.SetBasicAuth("admin2", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	// an existing preference can be replaced
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/key49", strings.NewReader("2"))
+	req.SetBasicAuth("admin2", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	for i := 0; i < 50; i++ {
+		req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("%v/key%v", uiPreferencesPath, i), nil)
+		req.SetBasicAuth("admin2", "password")
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	}
+	for _, key := range []string{"users_table", "page.size"} {
+		req, _ = http.NewRequest(http.MethodDelete, uiPreferencesPath+"/"+key, nil)
+		req.SetBasicAuth("admin1", "password")
+		rr = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, uiPreferencesPath+"/users_table", nil)
+	req.SetBasicAuth("admin1", "password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr.Code)
+	req, _ = http.NewRequest(http.MethodGet, webUsersPath, nil)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if !strings.Contains(rr.Body.String(), "ui_preferences") || !strings.Contains(rr.Body.String(), "users_table") {
+		t.Error("the users page must load the saved table state")
+	}
 }
 
 func TestStaticFilesMock(t *testing.T) {
@@ -2323,6 +8488,44 @@ func getUserAsJSON(t *testing.T, user dataprovider.User) []byte {
 	return json
 }
 
+// getTestGoogleServiceAccount returns a service account JSON key with a newly generated private key
+func getTestGoogleServiceAccount(t *testing.T, tokenURI string) string {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Errorf("unable to generate private key: %v", err)
+		return ""
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Errorf("unable to marshal private key: %v", err)
+		return ""
+	}
+	account := map[string]string{
+		"type":         "service_account",
+		"client_email": "sftpgo@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+	}
+	if len(tokenURI) > 0 {
+		account["token_uri"] = tokenURI
+	}
+	asJSON, err := json.Marshal(account)
+	if err != nil {
+		t.Errorf("unable to marshal service account: %v", err)
+		return ""
+	}
+	return string(asJSON)
+}
+
+// getCSRFTokenFromBody returns the token included inside the forms of the given web page
+func getCSRFTokenFromBody(t *testing.T, body string) string {
+	matches := csrfTokenRegex.FindStringSubmatch(body)
+	if len(matches) != 2 {
+		t.Errorf("unable to find the CSRF token in the web page")
+		return ""
+	}
+	return matches[1]
+}
+
 func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
 	testServer.Config.Handler.ServeHTTP(rr, req)
@@ -2335,6 +8538,54 @@ func checkResponseCode(t *testing.T, expected, actual int) {
 	}
 }
 
+func waitForDuplicatesScan(t *testing.T, username string) httpd.DuplicatesScan {
+	for i := 0; i < 100; i++ {
+		scan, _, err := httpd.GetDuplicatesScan(username, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get duplicates scan: %v", err)
+			return scan
+		}
+		if scan.Status != httpd.DuplicatesScanRunning {
+			return scan
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("duplicates scan for user %#v is still running", username)
+	return httpd.DuplicatesScan{}
+}
+
+func waitForPlanPropagation(t *testing.T, planName string) dataprovider.PlanPropagation {
+	for i := 0; i < 100; i++ {
+		propagation, _, err := httpd.GetPlanPropagation(planName, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get plan propagation: %v", err)
+			return propagation
+		}
+		if propagation.Status != dataprovider.PlanPropagationRunning {
+			return propagation
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("propagation for plan %#v is still running", planName)
+	return dataprovider.PlanPropagation{}
+}
+
+func waitForOffboarding(t *testing.T, username string) httpd.UserOffboarding {
+	for i := 0; i < 100; i++ {
+		offboarding, _, err := httpd.GetOffboarding(username, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get offboarding: %v", err)
+			return offboarding
+		}
+		if offboarding.Status != httpd.OffboardingRunning {
+			return offboarding
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("offboarding for user %#v is still running", username)
+	return httpd.UserOffboarding{}
+}
+
 func createTestFile(path string, size int64) error {
 	baseDir := filepath.Dir(path)
 	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
@@ -2376,3 +8627,14 @@ func getMultipartFormData(values url.Values, fileFieldName, filePath string) (by
 	err := w.Close()
 	return b, w.FormDataContentType(), err
 }
+
+func startTestIPListFeedServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed" {
+			http.NotFound(w, r)
+			return
+		}
+		// the format used by the Spamhaus DROP list and similar feeds
+		fmt.Fprint(w, "; test feed\n\n203.0.113.0/24 ; SBL1\n198.51.100.7\t# single address\ninvalid\n")
+	}))
+}
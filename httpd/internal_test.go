@@ -2,6 +2,8 @@ package httpd
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -10,15 +12,23 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/httpd/adminpb"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 	"github.com/go-chi/chi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -40,6 +50,29 @@ func TestGetRespStatus(t *testing.T) {
 	}
 }
 
+func TestGetDisconnectOption(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, userPath+"/1?disconnect=a", nil)
+	_, err := getDisconnectOption(req)
+	if err == nil {
+		t.Error("invalid disconnect option must fail")
+	}
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/1?disconnect=2", nil)
+	_, err = getDisconnectOption(req)
+	if err == nil {
+		t.Error("out of range disconnect option must fail")
+	}
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/1?disconnect=1", nil)
+	disconnect, err := getDisconnectOption(req)
+	if err != nil || !disconnect {
+		t.Errorf("unexpected disconnect option: %v, error: %v", disconnect, err)
+	}
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/1", nil)
+	disconnect, err = getDisconnectOption(req)
+	if err != nil || disconnect != sftpd.IsDisconnectOnUserChangeEnabled() {
+		t.Errorf("unexpected disconnect option: %v, error: %v", disconnect, err)
+	}
+}
+
 func TestCheckResponse(t *testing.T) {
 	err := checkResponse(http.StatusOK, http.StatusCreated)
 	if err == nil {
@@ -409,6 +442,8 @@ func TestGCSWebInvalidFormFile(t *testing.T) {
 	form := make(url.Values)
 	form.Set("username", "test_username")
 	form.Set("fs_provider", "2")
+	form.Set("gcs_download_part_size", "0")
+	form.Set("gcs_download_concurrency", "0")
 	req, _ := http.NewRequest(http.MethodPost, webUserPath, strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.ParseForm()
@@ -584,6 +619,409 @@ func TestBasicAuth(t *testing.T) {
 	httpAuth, _ = newBasicAuthProvider("")
 }
 
+func TestAuditorUsers(t *testing.T) {
+	oldAuthUsername := authUsername
+	oldAuthPassword := authPassword
+	authUserFile := filepath.Join(os.TempDir(), "http_users.txt")
+	authUserData := []byte("test1:$2y$05$bcHSED7aO1cfLto6ZdDBOOKzlwftslVhtpIkRhAtSa4GuLmk5mola\n" +
+		"test2:$2y$05$bcHSED7aO1cfLto6ZdDBOOKzlwftslVhtpIkRhAtSa4GuLmk5mola\n")
+	ioutil.WriteFile(authUserFile, authUserData, 0666)
+	httpAuth, _ = newBasicAuthProvider(authUserFile)
+	auditorUsers = []string{"test2"}
+	SetBaseURLAndCredentials(httpBaseURL, "test2", "password1")
+	_, _, err := GetUsers(0, 0, "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = GetConnections(http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = AddUser(dataprovider.User{Username: "auditor_test"}, http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = CloseConnection("connectionID", http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = Dumpdata("backup.json", "", http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	resp, _ := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(webUserPath), nil, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("web requests modifying the server state must fail for auditors, status code: %v", resp.StatusCode)
+	}
+	resp, _ = sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(loginSimulationPath), nil, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("the login simulation must be denied for auditors, status code: %v", resp.StatusCode)
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test1", "password1")
+	_, err = CloseConnection("connectionID", http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Remove(authUserFile)
+	auditorUsers = nil
+	SetBaseURLAndCredentials(httpBaseURL, oldAuthUsername, oldAuthPassword)
+	httpAuth, _ = newBasicAuthProvider("")
+}
+
+func TestAdminScopes(t *testing.T) {
+	oldAuthUsername := authUsername
+	oldAuthPassword := authPassword
+	authUserFile := filepath.Join(os.TempDir(), "http_users.txt")
+	authUserData := []byte("test1:$2y$05$bcHSED7aO1cfLto6ZdDBOOKzlwftslVhtpIkRhAtSa4GuLmk5mola\n" +
+		"test2:$2y$05$bcHSED7aO1cfLto6ZdDBOOKzlwftslVhtpIkRhAtSa4GuLmk5mola\n")
+	ioutil.WriteFile(authUserFile, authUserData, 0666)
+	httpAuth, _ = newBasicAuthProvider(authUserFile)
+	var err error
+	adminScopes, err = validateAdminScopes([]AdminScope{
+		{
+			Username:         "test2",
+			Tenants:          []string{" acme "},
+			UsernamePrefixes: []string{"acme_"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to validate admin scopes: %v", err)
+	}
+	getScopeTestUser := func(username, tenant string) dataprovider.User {
+		user := dataprovider.User{
+			Username:    username,
+			Password:    "password",
+			HomeDir:     filepath.Join(os.TempDir(), username),
+			Status:      1,
+			Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+		}
+		user.Filters.Tenant = tenant
+		return user
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test1", "password1")
+	user1, _, err := AddUser(getScopeTestUser("acme_user", ""), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user2, _, err := AddUser(getScopeTestUser("tenant_user", "ACME"), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user3, _, err := AddUser(getScopeTestUser("other_user", "other"), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test2", "password1")
+	users, _, err := GetUsers(0, 0, "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("only the users in scope must be returned, got: %v", len(users))
+	}
+	users, _, err = GetUsers(1, 1, "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 || users[0].Username != user2.Username {
+		t.Errorf("unexpected users: %+v", users)
+	}
+	users, _, err = GetUsers(0, 0, user3.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("the users outside the scope must not be returned: %+v", users)
+	}
+	_, _, err = GetUserByID(user1.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = GetUserByID(user3.ID, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = UpdateUser(user3, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = RemoveUser(user3, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = AddUser(getScopeTestUser("outside_user", ""), http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	user2.Filters.Tenant = "other"
+	_, _, err = UpdateUser(user2, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("a user must not be moved outside the scope: %v", err)
+	}
+	user2.Filters.Tenant = "acme"
+	_, _, err = UpdateUser(user2, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, _, err = Dumpdata("backup.json", "", http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	quotaSize := int64(100)
+	for _, username := range []string{user1.Username, user3.Username} {
+		SetBaseURLAndCredentials(httpBaseURL, "test1", "password1")
+		_, _, err = AddUserOverride(dataprovider.UserOverride{Username: username, QuotaSize: &quotaSize, Duration: 60},
+			http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to add user override: %v", err)
+		}
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test2", "password1")
+	overrides, _, err := GetUserOverrides(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user overrides: %v", err)
+	}
+	if len(overrides) != 1 || overrides[0].Username != user1.Username {
+		t.Errorf("only the overrides for the users in scope must be returned: %+v", overrides)
+	}
+	auditRecords, _, err := GetUserOverridesAudit(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user overrides audit: %v", err)
+	}
+	for _, record := range auditRecords {
+		if record.Override.Username == user3.Username {
+			t.Errorf("the audit records for the users outside the scope must not be returned: %+v", record)
+		}
+	}
+	_, _, err = GetUserOverride(user3.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = AddUserOverride(dataprovider.UserOverride{Username: user3.Username, QuotaSize: &quotaSize, Duration: 60},
+		http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = RemoveUserOverride(user3.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = RemoveUserOverride(user1.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user override: %v", err)
+	}
+	reports, _, err := GetStaleFilesReport(-1, -1, "", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get stale files report: %v", err)
+	}
+	for _, report := range reports {
+		if report.Username == user3.Username {
+			t.Error("the stale files report must not include the users outside the scope")
+		}
+	}
+	_, _, err = GetStaleFilesReport(-1, -1, user3.Username, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = SimulateLogin(sftpd.LoginSimulationRequest{Username: user3.Username, Password: "password"},
+		http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = TestHooks("actions", HookTestRequest{Event: "upload"}, http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = GetPlans(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get plans: %v", err)
+	}
+	_, _, err = AddPlan(dataprovider.Plan{Name: "scoped_plan"}, http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = AddFolder(dataprovider.Folder{Name: "scoped_folder", MappedPath: os.TempDir()}, http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = AddIPListEntry(dataprovider.IPListEntry{IPOrNet: "172.16.1.1", Type: dataprovider.IPListTypeBlock},
+		http.StatusForbidden)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test1", "password1")
+	_, err = RemoveUserOverride(user3.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user override: %v", err)
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test2", "password1")
+	_, err = RemoveUser(user1, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = RemoveUser(user2, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test1", "password1")
+	_, err = RemoveUser(user3, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user1.GetHomeDir())
+	os.RemoveAll(user2.GetHomeDir())
+	os.RemoveAll(user3.GetHomeDir())
+	os.Remove(authUserFile)
+	adminScopes = nil
+	SetBaseURLAndCredentials(httpBaseURL, oldAuthUsername, oldAuthPassword)
+	httpAuth, _ = newBasicAuthProvider("")
+}
+
+func TestValidateAdminScopes(t *testing.T) {
+	_, err := validateAdminScopes([]AdminScope{{Tenants: []string{"acme"}}})
+	if err == nil {
+		t.Error("a scope without username must fail")
+	}
+	_, err = validateAdminScopes([]AdminScope{{Username: "admin"}})
+	if err == nil {
+		t.Error("an empty scope must fail")
+	}
+	_, err = validateAdminScopes([]AdminScope{{Username: "admin", UsernamePrefixes: []string{""}}})
+	if err == nil {
+		t.Error("an empty username prefix must fail")
+	}
+	_, err = validateAdminScopes([]AdminScope{{Username: "admin", Tenants: []string{" "}}})
+	if err == nil {
+		t.Error("an empty tenant must fail")
+	}
+	_, err = validateAdminScopes([]AdminScope{
+		{Username: "admin", Tenants: []string{"acme"}},
+		{Username: "admin", UsernamePrefixes: []string{"acme_"}},
+	})
+	if err == nil {
+		t.Error("duplicate scopes must fail")
+	}
+	scope := dataprovider.UserScope{Tenants: []string{"acme"}}
+	connections := []sftpd.ConnectionStatus{
+		{Username: "user1", Tenant: "ACME"},
+		{Username: "user2"},
+	}
+	if len(filterConnectionsByScope(connections, scope)) != 1 {
+		t.Error("only the connections in scope must be returned")
+	}
+	if len(filterConnectionsByScope(connections, dataprovider.UserScope{})) != 2 {
+		t.Error("all the connections must be returned for an empty scope")
+	}
+	if isConnectionInScope("missing", scope) {
+		t.Error("a missing connection cannot be in scope")
+	}
+}
+
+func TestIsRequestAllowedForAuditor(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, userPath, nil)
+	if !isRequestAllowedForAuditor(req) {
+		t.Error("GET requests must be allowed")
+	}
+	req, _ = http.NewRequest(http.MethodGet, loadDataPath, nil)
+	if isRequestAllowedForAuditor(req) {
+		t.Error("restoring a backup must be denied")
+	}
+	req, _ = http.NewRequest(http.MethodPut, userPath+"/1", nil)
+	if isRequestAllowedForAuditor(req) {
+		t.Error("PUT requests must be denied")
+	}
+	req, _ = http.NewRequest(http.MethodPost, loginSimulationPath, nil)
+	if isRequestAllowedForAuditor(req) {
+		t.Error("the login simulation must be denied")
+	}
+	req, _ = http.NewRequest(http.MethodPost, providerMigrationPath, nil)
+	if isRequestAllowedForAuditor(req) {
+		t.Error("the migration target sync must be denied")
+	}
+	req, _ = http.NewRequest(http.MethodPut, uiPreferencesPath+"/users_table", nil)
+	if !isRequestAllowedForAuditor(req) {
+		t.Error("saving the auditor UI preferences must be allowed")
+	}
+	req, _ = http.NewRequest(http.MethodDelete, uiPreferencesPath+"_other", nil)
+	if isRequestAllowedForAuditor(req) {
+		t.Error("only the UI preferences paths must be allowed")
+	}
+}
+
+func TestFileUIPreferences(t *testing.T) {
+	storagePath := filepath.Join(os.TempDir(), "test_ui_preferences")
+	os.RemoveAll(storagePath)
+	store := newFileUIPreferences(storagePath)
+	preferences, err := store.GetAll("admin")
+	if err != nil || len(preferences) != 0 {
+		t.Errorf("unexpected preferences: %+v, error: %v", preferences, err)
+	}
+	err = store.Set("../admin", "key", json.RawMessage(`{"length":25}`))
+	if err != nil {
+		t.Errorf("unable to save preference: %v", err)
+	}
+	files, _ := ioutil.ReadDir(storagePath)
+	if len(files) != 1 {
+		t.Errorf("unexpected number of preferences files: %v", len(files))
+	}
+	preferences, err = store.GetAll("../admin")
+	if err != nil || string(preferences["key"]) != `{"length":25}` {
+		t.Errorf("unexpected preferences: %+v, error: %v", preferences, err)
+	}
+	preferences, err = store.GetAll("admin")
+	if err != nil || len(preferences) != 0 {
+		t.Errorf("the preferences must be scoped to the admin: %+v, error: %v", preferences, err)
+	}
+	err = store.Delete("../admin", "missing")
+	if err != nil {
+		t.Errorf("removing a missing preference must succeed: %v", err)
+	}
+	err = store.Delete("../admin", "key")
+	if err != nil {
+		t.Errorf("unable to remove preference: %v", err)
+	}
+	files, _ = ioutil.ReadDir(storagePath)
+	if len(files) != 0 {
+		t.Errorf("the preferences file must be removed, files: %v", len(files))
+	}
+	err = ioutil.WriteFile(store.getFilePath("admin"), []byte("invalid json"), 0600)
+	if err != nil {
+		t.Errorf("unable to write file: %v", err)
+	}
+	_, err = store.GetAll("admin")
+	if err == nil {
+		t.Error("invalid preferences must fail")
+	}
+	err = store.Set("admin", "key", json.RawMessage("1"))
+	if err == nil {
+		t.Error("saving a preference must fail if the existing ones are invalid")
+	}
+	os.RemoveAll(storagePath)
+}
+
+func TestInitializeUIPreferences(t *testing.T) {
+	store := uiPreferences
+	uiPreferences = nil
+	initializeUIPreferences(UIPreferencesConfig{}, os.TempDir())
+	if _, ok := uiPreferences.(*memoryUIPreferences); !ok {
+		t.Errorf("unexpected UI preferences store: %T", uiPreferences)
+	}
+	custom := newMemoryUIPreferences()
+	SetUIPreferencesStore(custom)
+	initializeUIPreferences(UIPreferencesConfig{StoragePath: "ui_preferences"}, os.TempDir())
+	if uiPreferences != custom {
+		t.Error("a custom UI preferences store must not be replaced")
+	}
+	uiPreferences = nil
+	initializeUIPreferences(UIPreferencesConfig{StoragePath: "ui_preferences"}, os.TempDir())
+	if p, ok := uiPreferences.(*fileUIPreferences); !ok || p.storagePath != filepath.Join(os.TempDir(), "ui_preferences") {
+		t.Errorf("unexpected UI preferences store: %+v", uiPreferences)
+	}
+	uiPreferences = store
+}
+
 func TestCloseConnectionHandler(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodDelete, activeConnectionsPath+"/connectionID", nil)
 	rctx := chi.NewRouteContext()
@@ -624,3 +1062,1027 @@ func TestQuotaScanInvalidFs(t *testing.T) {
 		t.Error("quota scan with bad fs must fail")
 	}
 }
+
+func TestGetGRPCError(t *testing.T) {
+	var err error
+	err = &dataprovider.MethodDisabledError{}
+	if status.Code(getGRPCError(err)) != codes.PermissionDenied {
+		t.Errorf("unexpected gRPC error: %v", getGRPCError(err))
+	}
+	_, err = os.Stat(filepath.Join(os.TempDir(), "missing_file"))
+	if status.Code(getGRPCError(err)) != codes.InvalidArgument {
+		t.Errorf("unexpected gRPC error: %v", getGRPCError(err))
+	}
+	err = fmt.Errorf("generic error")
+	if status.Code(getGRPCError(err)) != codes.Internal {
+		t.Errorf("unexpected gRPC error: %v", getGRPCError(err))
+	}
+	if !getGRPCDisconnectOption(adminpb.DisconnectMode_DISCONNECT_YES) {
+		t.Error("disconnect option must be true")
+	}
+	if getGRPCDisconnectOption(adminpb.DisconnectMode_DISCONNECT_NO) {
+		t.Error("disconnect option must be false")
+	}
+}
+
+func TestGRPCBasicAuth(t *testing.T) {
+	authUserFile := filepath.Join(os.TempDir(), "grpc_users.txt")
+	authUserData := []byte("test1:$2y$05$bcHSED7aO1cfLto6ZdDBOOKzlwftslVhtpIkRhAtSa4GuLmk5mola\n")
+	ioutil.WriteFile(authUserFile, authUserData, 0666)
+	httpAuth, _ = newBasicAuthProvider(authUserFile)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &adminpb.ApiResponse{}, nil
+	}
+	_, err := checkGRPCRequest(context.Background(), nil, nil, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("request without credentials must fail: %v", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization",
+		"Basic "+base64.StdEncoding.EncodeToString([]byte("test1:wrong_password"))))
+	_, err = checkGRPCRequest(ctx, nil, nil, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("request with wrong password must fail: %v", err)
+	}
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization",
+		"Basic "+base64.StdEncoding.EncodeToString([]byte("test1:password1"))))
+	_, err = checkGRPCRequest(ctx, nil, nil, handler)
+	if err != nil {
+		t.Errorf("request with valid credentials must succeed: %v", err)
+	}
+	auditorUsers = []string{"test1"}
+	_, err = checkGRPCRequest(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/sftpgo.admin.Admin/GetUsers"}, handler)
+	if err != nil {
+		t.Errorf("read only methods must be allowed for auditors: %v", err)
+	}
+	_, err = checkGRPCRequest(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/sftpgo.admin.Admin/DeleteUser"}, handler)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("methods modifying the server state must be denied for auditors: %v", err)
+	}
+	auditorUsers = nil
+	os.Remove(authUserFile)
+	httpAuth, _ = newBasicAuthProvider("")
+}
+
+func TestStaleFilesReportInvalidFs(t *testing.T) {
+	user := dataprovider.User{
+		Username: "test",
+		HomeDir:  os.TempDir(),
+		FsConfig: dataprovider.Filesystem{
+			Provider: 1,
+		},
+	}
+	report := generateUserStaleFilesReport(user, 10, 5)
+	if len(report.Error) == 0 {
+		t.Error("stale files report with bad fs must fail")
+	}
+	if report.Folders == nil || report.TopFiles == nil {
+		t.Errorf("empty lists are expected: %+v", report)
+	}
+}
+
+func TestGetStaleFilesFolder(t *testing.T) {
+	user := dataprovider.User{
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				VirtualPath: "/vdir",
+			},
+			{
+				VirtualPath: "/vdir/sub",
+			},
+		},
+	}
+	tests := map[string]string{
+		"/file":              "/",
+		"/dir/file":          "/dir",
+		"/dir/sub/file":      "/dir",
+		"/vdir/file":         "/vdir",
+		"/vdir/sub/file":     "/vdir/sub",
+		"/vdir/sub/dir/file": "/vdir/sub",
+		"/vdirfile":          "/",
+	}
+	for sftpPath, expected := range tests {
+		if folder := getStaleFilesFolder(user, sftpPath); folder != expected {
+			t.Errorf("unexpected folder for %#v: %#v, expected: %#v", sftpPath, folder, expected)
+		}
+	}
+}
+
+func TestSaveStaleFilesReport(t *testing.T) {
+	savedConf := staleFilesReportConf
+	savedPath := staleFilesReportsPath
+	reportsDir := filepath.Join(os.TempDir(), "stale_reports")
+	startStaleFilesReportScheduler(StaleFilesReportConfig{
+		Interval:    1,
+		ReportsPath: reportsDir,
+	}, os.TempDir())
+	if staleFilesReportTicker == nil {
+		t.Error("the stale files report must be scheduled")
+	}
+	if staleFilesReportConf.OlderThanDays != defaultStaleFilesOlderThanDays ||
+		staleFilesReportConf.TopFiles != defaultStaleFilesTopFiles {
+		t.Errorf("unexpected defaults: %+v", staleFilesReportConf)
+	}
+	reportFile, err := saveStaleFilesReport()
+	if err != nil {
+		t.Errorf("unable to save the stale files report: %v", err)
+	}
+	data, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Errorf("unable to read the stale files report: %v", err)
+	}
+	var reports []StaleFilesReport
+	err = json.Unmarshal(data, &reports)
+	if err != nil {
+		t.Errorf("invalid stale files report: %v", err)
+	}
+	startStaleFilesReportScheduler(StaleFilesReportConfig{}, os.TempDir())
+	if staleFilesReportTicker != nil {
+		t.Error("the stale files report must not be scheduled")
+	}
+	_, err = saveStaleFilesReport()
+	if err == nil {
+		t.Error("saving the stale files report with an empty path must fail")
+	}
+	staleFilesReportConf = savedConf
+	staleFilesReportsPath = savedPath
+	os.RemoveAll(reportsDir)
+}
+
+func TestDuplicatesScanInvalidFs(t *testing.T) {
+	user := dataprovider.User{
+		Username: "test_duplicates_invalid_fs",
+		HomeDir:  os.TempDir(),
+		FsConfig: dataprovider.Filesystem{
+			Provider: 1,
+		},
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	scan, ok := addDuplicatesScan(user.Username, cancelFn)
+	if !ok {
+		t.Fatal("unable to add duplicates scan")
+	}
+	err := doDuplicatesScan(ctx, user, scan)
+	if err == nil {
+		t.Error("duplicates scan with bad fs must fail")
+	}
+	if scan.Status != DuplicatesScanFailed || len(scan.Error) == 0 || scan.EndTime == 0 {
+		t.Errorf("unexpected scan: %+v", scan)
+	}
+	if _, ok := addDuplicatesScan(user.Username, cancelFn); !ok {
+		t.Error("a finished scan must be replaced")
+	}
+	duplicatesScanMutex.Lock()
+	delete(duplicatesScans, user.Username)
+	duplicatesScanMutex.Unlock()
+}
+
+func TestCancelDuplicatesScan(t *testing.T) {
+	savedConf := duplicatesScanConf
+	duplicatesScanConf.Bandwidth = 1
+	user := dataprovider.User{
+		Username: "test_duplicates_cancel",
+		HomeDir:  filepath.Join(os.TempDir(), "duplicates_cancel"),
+	}
+	content := make([]byte, 65536)
+	for _, name := range []string{"file1", "file2"} {
+		err := os.MkdirAll(user.HomeDir, 0777)
+		if err != nil {
+			t.Errorf("unable to create home dir: %v", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(user.HomeDir, name), content, 0666)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	scan, ok := addDuplicatesScan(user.Username, cancelFn)
+	if !ok {
+		t.Fatal("unable to add duplicates scan")
+	}
+	if _, ok := addDuplicatesScan(user.Username, cancelFn); ok {
+		t.Error("a running scan must not be replaced")
+	}
+	done := make(chan error)
+	go func() {
+		done <- doDuplicatesScan(ctx, user, scan)
+	}()
+	router := chi.NewRouter()
+	router.Delete(duplicatesScanPath+"/{username}", cancelDuplicatesScan)
+	req, _ := http.NewRequest(http.MethodDelete, duplicatesScanPath+"/"+user.Username, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Scan canceled") {
+		t.Errorf("unexpected response: %v %v", rr.Code, rr.Body.String())
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("a canceled scan must return an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the scan was not canceled")
+	}
+	duplicatesScanMutex.RLock()
+	if scan.Status != DuplicatesScanCanceled || len(scan.DuplicateSets) > 0 {
+		t.Errorf("unexpected scan: %+v", scan)
+	}
+	duplicatesScanMutex.RUnlock()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Scan removed") {
+		t.Errorf("unexpected response: %v %v", rr.Code, rr.Body.String())
+	}
+	duplicatesScanConf = savedConf
+	os.RemoveAll(user.HomeDir)
+}
+
+func TestReadThrottler(t *testing.T) {
+	throttler := newReadThrottler(0)
+	throttler.wait(context.Background(), 1048576)
+	if throttler.readBytes != 1048576 {
+		t.Errorf("unexpected read bytes: %v", throttler.readBytes)
+	}
+	throttler = newReadThrottler(100)
+	startTime := time.Now()
+	throttler.wait(context.Background(), 20000)
+	if elapsed := time.Since(startTime); elapsed < 150*time.Millisecond {
+		t.Errorf("the read must be throttled, elapsed: %v", elapsed)
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	startTime = time.Now()
+	throttler.wait(ctx, 1048576)
+	if elapsed := time.Since(startTime); elapsed > 1*time.Second {
+		t.Errorf("a canceled wait must return immediately, elapsed: %v", elapsed)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	token := createCSRFToken()
+	if err := verifyCSRFToken(token); err != nil {
+		t.Errorf("the token must be valid: %v", err)
+	}
+	for _, invalidToken := range []string{"", "invalid", token + "a", "1.2.3", "a." + getCSRFTokenSignature("a")} {
+		if err := verifyCSRFToken(invalidToken); err != errInvalidCSRFToken {
+			t.Errorf("the token %#v must be invalid", invalidToken)
+		}
+	}
+	timestamp := fmt.Sprintf("%v", time.Now().Add(-csrfTokenMaxAge-time.Minute).Unix())
+	if err := verifyCSRFToken(timestamp + "." + getCSRFTokenSignature(timestamp)); err != errInvalidCSRFToken {
+		t.Errorf("an expired token must be invalid")
+	}
+	req, _ := http.NewRequest(http.MethodPost, webJobsPath, strings.NewReader(url.Values{csrfFormField: []string{token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := req.ParseForm(); err != nil {
+		t.Errorf("unable to parse form: %v", err)
+	}
+	if err := verifyFormCSRFToken(req); err != nil {
+		t.Errorf("the posted token must be valid: %v", err)
+	}
+}
+
+func TestParseTemplateFiles(t *testing.T) {
+	templatesPath, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(templatesPath)
+	err = ioutil.WriteFile(filepath.Join(templatesPath, "base.html"), []byte(`{{define "base"}}base {{template "content" .}}{{end}}`), 0666)
+	if err != nil {
+		t.Fatalf("unable to write template: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(templatesPath, "page.html"), []byte(`{{template "base" .}}{{define "content"}}page{{end}}`), 0666)
+	if err != nil {
+		t.Fatalf("unable to write template: %v", err)
+	}
+	tmpl, err := parseTemplateFiles(filepath.Join(templatesPath, "base.html"), filepath.Join(templatesPath, "page.html"))
+	if err != nil {
+		t.Fatalf("unable to parse templates: %v", err)
+	}
+	var b strings.Builder
+	err = tmpl.ExecuteTemplate(&b, "page.html", nil)
+	if err != nil || b.String() != "base page" {
+		t.Errorf("unexpected template output %#v, err: %v", b.String(), err)
+	}
+	// a missing template is not embedded too
+	_, err = parseTemplateFiles(filepath.Join(templatesPath, "base.html"), filepath.Join(templatesPath, "missing.html"))
+	if err == nil {
+		t.Error("parsing a missing template must fail")
+	}
+}
+
+func TestOverlayFileSystem(t *testing.T) {
+	dir1, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir2)
+	for _, f := range []struct {
+		path     string
+		contents string
+	}{
+		{filepath.Join(dir1, "a.css"), "a1"},
+		{filepath.Join(dir2, "a.css"), "a2"},
+		{filepath.Join(dir2, "b.css"), "b2"},
+	} {
+		if err = ioutil.WriteFile(f.path, []byte(f.contents), 0666); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+	}
+	fs := overlayFileSystem{http.Dir(dir1), http.Dir(dir2)}
+	for name, expected := range map[string]string{"/a.css": "a1", "/b.css": "b2"} {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Errorf("unable to open %#v: %v", name, err)
+			continue
+		}
+		contents, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || string(contents) != expected {
+			t.Errorf("unexpected contents for %#v: %#v, err: %v", name, string(contents), err)
+		}
+	}
+	_, err = fs.Open("/c.css")
+	if !os.IsNotExist(err) {
+		t.Errorf("unexpected error for a missing file: %v", err)
+	}
+}
+
+func TestValidateCustomRoutes(t *testing.T) {
+	configDir := os.TempDir()
+	invalidRoutes := [][]CustomRoute{
+		{{Path: "docs", Directory: "docs"}},
+		{{Path: "/", Directory: "docs"}},
+		{{Path: "/api/docs", Directory: "docs"}},
+		{{Path: "/web", Directory: "docs"}},
+		{{Path: "/static/", Directory: "docs"}},
+		{{Path: "/healthz", ProxyURL: "http://127.0.0.1:3000"}},
+		{{Path: "/healthz/ready", Directory: "docs"}},
+		{{Path: "/docs", Directory: "docs"}, {Path: "/docs/v1", Directory: "v1"}},
+		{{Path: "/docs"}},
+		{{Path: "/docs", Directory: "docs", ProxyURL: "http://127.0.0.1:3000"}},
+		{{Path: "/docs", Directory: ".."}},
+		{{Path: "/docs", ProxyURL: "ftp://127.0.0.1"}},
+		{{Path: "/docs", ProxyURL: invalidURL}},
+	}
+	for _, routes := range invalidRoutes {
+		if _, err := validateCustomRoutes(routes, configDir); err == nil {
+			t.Errorf("custom routes %+v must be invalid", routes)
+		}
+	}
+	routes, err := validateCustomRoutes([]CustomRoute{
+		{Path: "/docs/", Directory: "docs"},
+		{Path: "/website", ProxyURL: "http://127.0.0.1:3000/site"},
+		{Path: "/webapp", Directory: filepath.Join(os.TempDir(), "webapp")},
+	}, configDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if routes[0].Path != "/docs" || routes[0].Directory != filepath.Join(configDir, "docs") {
+		t.Errorf("unexpected route: %+v", routes[0])
+	}
+	if routes[2].Directory != filepath.Join(os.TempDir(), "webapp") {
+		t.Errorf("unexpected route: %+v", routes[2])
+	}
+}
+
+func TestCustomRouteProxyAuthorization(t *testing.T) {
+	var receivedAuth []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedAuth = r.Header["Authorization"]
+		fmt.Fprint(w, "proxied")
+	}))
+	defer backend.Close()
+
+	r := chi.NewRouter()
+	mountCustomRoutes(r, []CustomRoute{
+		{Path: "/private", ProxyURL: backend.URL, RequireAuth: true},
+		{Path: "/public", ProxyURL: backend.URL},
+	})
+	for _, path := range []string{"/private/index.html", "/public/index.html"} {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("admin", "password")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != "proxied" {
+			t.Errorf("unexpected response for path %#v: %v %#v", path, rr.Code, rr.Body.String())
+		}
+		if len(receivedAuth) > 0 {
+			t.Errorf("the Authorization header must not be forwarded for path %#v: %v", path, receivedAuth)
+		}
+	}
+}
+
+func TestCustomRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "customroute")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create a dir: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("landing page"), 0666)
+	if err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %v", r.Host, r.URL.Path)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	r := chi.NewRouter()
+	mountCustomRoutes(r, []CustomRoute{
+		{Path: "/landing", Directory: dir},
+		{Path: "/proxied", ProxyURL: backend.URL + "/base"},
+		{Path: "/unreachable", ProxyURL: inactiveURL},
+	})
+	for _, test := range []struct {
+		path       string
+		statusCode int
+		body       string
+	}{
+		{"/landing/", http.StatusOK, "landing page"},
+		{"/landing/empty/", http.StatusNotFound, ""},
+		{"/landing/missing.html", http.StatusNotFound, ""},
+		{"/proxied", http.StatusOK, backendURL.Host + " /base/"},
+		{"/proxied/a/b", http.StatusOK, backendURL.Host + " /base/a/b"},
+		{"/unreachable/a", http.StatusBadGateway, ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, test.path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != test.statusCode {
+			t.Errorf("unexpected status code for path %#v: %v", test.path, rr.Code)
+		}
+		if len(test.body) > 0 && rr.Body.String() != test.body {
+			t.Errorf("unexpected body for path %#v: %#v", test.path, rr.Body.String())
+		}
+	}
+}
+
+func TestOffboardingArchiveRollback(t *testing.T) {
+	user := dataprovider.User{
+		Username: "offboarding_rollback",
+		Password: "password",
+		HomeDir:  filepath.Join(os.TempDir(), "offboarding_rollback"),
+		Status:   1,
+		Permissions: map[string][]string{
+			"/": {dataprovider.PermAny},
+		},
+	}
+	err := dataprovider.AddUser(dataProvider, user)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	oldConf := offboardingConf
+	// the bucket is not valid so the archive fails
+	offboardingConf.ArchiveBucket.Provider = 1
+	offboarding, ok := addOffboarding(user, "", "")
+	if !ok {
+		t.Error("unable to add offboarding")
+	}
+	_, ok = addOffboarding(user, "", "")
+	if ok {
+		t.Error("an offboarding is already running for this user")
+	}
+	doOffboarding(user.Username, offboarding, true)
+	if offboarding.Status != OffboardingFailed || len(offboarding.Error) == 0 || len(offboarding.Snapshot) > 0 {
+		t.Errorf("unexpected offboarding: %+v", offboarding)
+	}
+	user, err = dataprovider.UserExists(dataProvider, user.Username)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.Status != 1 {
+		t.Errorf("the user must be restored if the archive fails: %+v", user)
+	}
+	offboardingConf = oldConf
+	err = dataprovider.DeleteUser(dataProvider, user)
+	if err != nil {
+		t.Errorf("unable to delete user: %v", err)
+	}
+	offboardingMutex.Lock()
+	delete(offboardings, user.Username)
+	offboardingMutex.Unlock()
+}
+
+func TestValidateAdminEvents(t *testing.T) {
+	invalidConfigs := []AdminEventsConfig{
+		{Webhooks: []AdminWebhook{{URL: "ftp://127.0.0.1"}}},
+		{Webhooks: []AdminWebhook{{URL: invalidURL}}},
+		{Webhooks: []AdminWebhook{{URL: "http://127.0.0.1", ExecuteOn: []string{"upload"}}}},
+		{Webhooks: []AdminWebhook{{URL: "http://127.0.0.1", Headers: []string{"Authorization"}}}},
+		{LoginNotifyInterval: -1},
+	}
+	for _, c := range invalidConfigs {
+		if err := c.validate(); err == nil {
+			t.Errorf("admin events config %+v must be invalid", c)
+		}
+	}
+	c := AdminEventsConfig{
+		Webhooks: []AdminWebhook{{URL: "https://127.0.0.1/hook", ExecuteOn: []string{AdminEventLogin},
+			Headers: []string{"Authorization: Bearer token"}}},
+	}
+	if err := c.validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAdminEventsWebhooks(t *testing.T) {
+	events := make(chan AdminEvent, 10)
+	headers := make(chan string, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event AdminEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err == nil {
+			headers <- r.Header.Get("X-Token")
+			events <- event
+		}
+	}))
+	defer webhook.Close()
+	oldAuthUsername := authUsername
+	oldAuthPassword := authPassword
+	authUserFile := filepath.Join(os.TempDir(), "http_users.txt")
+	authUserData := []byte("test1:$2y$05$bcHSED7aO1cfLto6ZdDBOOKzlwftslVhtpIkRhAtSa4GuLmk5mola\n")
+	ioutil.WriteFile(authUserFile, authUserData, 0666)
+	httpAuth, _ = newBasicAuthProvider(authUserFile)
+	startAdminEventsNotifier(AdminEventsConfig{
+		Webhooks: []AdminWebhook{
+			{URL: webhook.URL, ExecuteOn: []string{AdminEventLogin, AdminEventLoginFailed}},
+			{URL: webhook.URL + "/reload", ExecuteOn: []string{AdminEventConfigReloaded}, Headers: []string{"X-Token: secret"}},
+		},
+		LoginNotifyInterval: 60,
+	})
+	waitEvent := func(expected string) AdminEvent {
+		select {
+		case event := <-events:
+			if event.Event != expected {
+				t.Errorf("unexpected admin event %+v, expected %#v", event, expected)
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Errorf("admin event %#v not received", expected)
+		}
+		return AdminEvent{}
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test1", "password1")
+	// the second login for the same admin and IP is not notified
+	for i := 0; i < 2; i++ {
+		_, _, err := GetVersion(http.StatusOK)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	event := waitEvent(AdminEventLogin)
+	if event.Username != "test1" || event.Protocol != "HTTP" || event.IP != "127.0.0.1" || event.Timestamp == 0 {
+		t.Errorf("unexpected login event: %+v", event)
+	}
+	SetBaseURLAndCredentials(httpBaseURL, "test1", "invalid")
+	_, _, err := GetVersion(http.StatusUnauthorized)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	event = waitEvent(AdminEventLoginFailed)
+	if event.Username != "test1" {
+		t.Errorf("unexpected login failed event: %+v", event)
+	}
+	if header := <-headers; header != "" {
+		t.Errorf("unexpected header %#v", header)
+	}
+	<-headers
+	NotifyAdminEvent(AdminEvent{Event: AdminEventConfigReloaded})
+	event = waitEvent(AdminEventConfigReloaded)
+	if len(event.Username) > 0 {
+		t.Errorf("unexpected config reloaded event: %+v", event)
+	}
+	if header := <-headers; header != "secret" {
+		t.Errorf("unexpected header %#v", header)
+	}
+	select {
+	case event = <-events:
+		t.Errorf("unexpected admin event: %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+	startAdminEventsNotifier(AdminEventsConfig{})
+	os.Remove(authUserFile)
+	SetBaseURLAndCredentials(httpBaseURL, oldAuthUsername, oldAuthPassword)
+	httpAuth, _ = newBasicAuthProvider("")
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	oldLimit := loadDataBodyLimit
+	loadDataBodyLimit.size = 10
+	req, _ := http.NewRequest(http.MethodPost, loadDataPath, strings.NewReader(`{"users":[], "plans":[]}`))
+	rr := httptest.NewRecorder()
+	loadDataFromBody(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected response: %v %v", rr.Code, rr.Body.String())
+	}
+	loadDataBodyLimit = oldLimit
+	req, _ = http.NewRequest(http.MethodPost, loadDataPath, strings.NewReader(`{"users":[]}`))
+	limitRequestBody(httptest.NewRecorder(), req, loginBodyLimit)
+	content, err := ioutil.ReadAll(req.Body)
+	if err != nil || string(content) != `{"users":[]}` {
+		t.Errorf("unexpected request body %#v: %v", string(content), err)
+	}
+	if !isRequestBodyTooLarge(fmt.Errorf("http: request body too large")) || isRequestBodyTooLarge(nil) {
+		t.Error("unexpected request body too large detection")
+	}
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	for _, backup := range []string{"", "[]", `{"users":{}}`, `{"users":[1]}`, `{"plans":[}`, `{"users":[]}{}`} {
+		err := restoreBackup(strings.NewReader(backup), "", backupFormatJSON, nil, 0, 0)
+		if _, ok := err.(*invalidBackupError); !ok {
+			t.Errorf("backup %#v must be invalid, error: %v", backup, err)
+		}
+	}
+	err := restoreBackup(strings.NewReader(`{"ip_list_entries":[{"ipornet":"192.168.1.1"}],"users":null}`), "",
+		backupFormatJSON, nil, 0, 0)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = restoreBackup(strings.NewReader(strings.Repeat("a", maxYAMLRestoreSize+1)), "", backupFormatYAML, nil, 0, 0)
+	if _, ok := err.(*invalidBackupError); !ok {
+		t.Errorf("a YAML backup exceeding the limit must be invalid, error: %v", err)
+	}
+	err = restoreBackup(strings.NewReader(""), "", backupFormatCSV, nil, 0, 0)
+	if _, ok := err.(*invalidBackupError); !ok {
+		t.Errorf("an empty CSV backup must be invalid, error: %v", err)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	registered := make(map[apiRoute]bool)
+	err := chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, apiPrefix+"/") {
+			registered[apiRoute{method: method, pattern: route}] = true
+			if _, ok := apiOperations[apiRoute{method: method, pattern: route}]; !ok {
+				t.Errorf("missing OpenAPI metadata for route %v %v", method, route)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("unable to walk the routes: %v", err)
+	}
+	operationIDs := make(map[string]bool)
+	for route, operation := range apiOperations {
+		if !registered[route] {
+			t.Errorf("OpenAPI metadata defined for the unregistered route %v %v", route.method, route.pattern)
+		}
+		if operationIDs[operation.id] {
+			t.Errorf("duplicated operation id %#v", operation.id)
+		}
+		operationIDs[operation.id] = true
+	}
+	data, err := generateOpenAPIDocument(router)
+	if err != nil {
+		t.Fatalf("unable to generate the OpenAPI document: %v", err)
+	}
+	var doc openAPIDocument
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatalf("unable to parse the OpenAPI document: %v", err)
+	}
+	if len(doc.Paths) == 0 || doc.Info.Version != utils.GetAppVersion().Version {
+		t.Errorf("unexpected OpenAPI document: %+v", doc.Info)
+	}
+	operation := doc.Paths["/user/{userID}"]["put"]
+	if operation == nil || operation.OperationID != "update_user" || len(operation.Parameters) != 2 {
+		t.Fatalf("unexpected update user operation: %+v", operation)
+	}
+	if operation.Parameters[0].In != "path" || operation.Parameters[1].Name != "disconnect" {
+		t.Errorf("unexpected update user parameters: %+v", operation.Parameters)
+	}
+	if operation.RequestBody.Content["application/json"]["schema"].Ref != "#/components/schemas/User" {
+		t.Errorf("unexpected update user request body: %+v", operation.RequestBody)
+	}
+	user, ok := doc.Components.Schemas["User"]
+	if !ok {
+		t.Fatal("missing user schema")
+	}
+	for _, property := range []string{"id", "username", "permissions", "filesystem", "virtual_folders"} {
+		if _, ok := user.Properties[property]; !ok {
+			t.Errorf("missing user property %#v", property)
+		}
+	}
+	if _, ok := user.Properties["password"]; !ok {
+		t.Error("missing user password property")
+	}
+	operation = doc.Paths["/sync/file"]["get"]
+	if operation == nil || operation.Responses["200"].Content["application/octet-stream"] == nil {
+		t.Errorf("unexpected sync download operation: %+v", operation)
+	}
+	if _, ok := doc.Paths["/openapi.yaml"]["get"]; !ok {
+		t.Error("the OpenAPI document must describe itself")
+	}
+	// the routes without metadata must be documented too
+	testRouter := chi.NewRouter()
+	testRouter.Get(apiPrefix+"/test/{param}", getOpenAPIDocument)
+	testRouter.Get(webBasePath, getOpenAPIDocument)
+	data, err = generateOpenAPIDocument(testRouter)
+	if err != nil {
+		t.Fatalf("unable to generate the OpenAPI document: %v", err)
+	}
+	doc = openAPIDocument{}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatalf("unable to parse the OpenAPI document: %v", err)
+	}
+	if len(doc.Paths) != 1 || len(doc.Paths["/test/{param}"]["get"].Parameters) != 1 {
+		t.Errorf("unexpected paths: %+v", doc.Paths)
+	}
+}
+
+func TestOpenAPISchemaNames(t *testing.T) {
+	type Folder struct {
+		Name   string  `json:"name"`
+		Parent *Folder `json:"parent,omitempty"`
+		Hidden string  `json:"-"`
+		hidden string
+	}
+	g := &openAPIGenerator{
+		schemas: make(map[string]*openAPISchema),
+		names:   make(map[reflect.Type]string),
+	}
+	schema := g.getSchema(reflect.TypeOf([]dataprovider.Folder{}))
+	if schema.Type != "array" || schema.Items.Ref != "#/components/schemas/Folder" {
+		t.Errorf("unexpected schema: %+v", schema)
+	}
+	schema = g.getSchema(reflect.TypeOf(&Folder{}))
+	if schema.Ref != "#/components/schemas/HttpdFolder" {
+		t.Errorf("unexpected schema: %+v", schema)
+	}
+	folder := g.schemas["HttpdFolder"]
+	if len(folder.Properties) != 2 || folder.Properties["parent"].Ref != schema.Ref {
+		t.Errorf("unexpected schema: %+v", folder)
+	}
+	schema = g.getSchema(reflect.TypeOf(map[string]time.Time{}))
+	if schema.Type != "object" || schema.AdditionalProperties.Format != "date-time" {
+		t.Errorf("unexpected schema: %+v", schema)
+	}
+	schema = g.getSchema(reflect.TypeOf([]byte{}))
+	if schema.Type != "string" || schema.Format != "byte" {
+		t.Errorf("unexpected schema: %+v", schema)
+	}
+}
+
+func TestUsersPagination(t *testing.T) {
+	var users []dataprovider.User
+	for _, username := range []string{"page_user_c", "page_user_a", "page_user_e", "page_user_b", "page_user_d"} {
+		user, _, err := AddUser(dataprovider.User{
+			Username:    username,
+			Password:    "password",
+			HomeDir:     filepath.Join(os.TempDir(), username),
+			Status:      1,
+			Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+		}, http.StatusOK)
+		if err != nil {
+			t.Fatalf("unable to add user: %v", err)
+		}
+		users = append(users, user)
+	}
+	getUsernames := func(users []dataprovider.User) []string {
+		var usernames []string
+		for _, user := range users {
+			usernames = append(usernames, user.Username)
+		}
+		return usernames
+	}
+	// the empty scope uses the SQL queries, if supported, the other scope the users list
+	for _, scope := range []dataprovider.UserScope{{}, {UsernamePrefixes: []string{"page_user_"}}} {
+		for _, ordering := range [][]string{{"username", "ASC"}, {"id", "DESC"}, {"last_login", "ASC"}} {
+			req := dataprovider.UsersPageRequest{Limit: 500, OrderBy: ordering[0], Order: ordering[1]}
+			all, err := dataprovider.GetUsersPage(dataProvider, scope, req)
+			if err != nil {
+				t.Fatalf("unable to get users: %v", err)
+			}
+			if all.Total != len(all.Users) || all.Total < len(users) || len(all.NextCursor) > 0 || len(all.PrevCursor) > 0 {
+				t.Errorf("unexpected page for ordering %v: %+v", ordering, all)
+			}
+			expected := getUsernames(all.Users)
+			// walk forward and then backward using the cursors
+			req.Limit = 2
+			var forward []string
+			var lastPage dataprovider.UsersPage
+			for {
+				page, err := dataprovider.GetUsersPage(dataProvider, scope, req)
+				if err != nil {
+					t.Fatalf("unable to get users: %v", err)
+				}
+				if page.Total != all.Total || len(page.Users) == 0 || len(page.Users) > 2 {
+					t.Fatalf("unexpected page for ordering %v: %+v", ordering, page)
+				}
+				if (len(req.Cursor) > 0) != (len(page.PrevCursor) > 0) {
+					t.Errorf("unexpected previous cursor for ordering %v: %#v", ordering, page.PrevCursor)
+				}
+				forward = append(forward, getUsernames(page.Users)...)
+				lastPage = page
+				if len(page.NextCursor) == 0 {
+					break
+				}
+				req.Cursor = page.NextCursor
+			}
+			if strings.Join(forward, ",") != strings.Join(expected, ",") {
+				t.Errorf("unexpected forward walk for ordering %v: %v, expected: %v", ordering, forward, expected)
+			}
+			backward := getUsernames(lastPage.Users)
+			req.Cursor = lastPage.PrevCursor
+			for len(req.Cursor) > 0 {
+				page, err := dataprovider.GetUsersPage(dataProvider, scope, req)
+				if err != nil {
+					t.Fatalf("unable to get users: %v", err)
+				}
+				if len(page.Users) != 2 || len(page.NextCursor) == 0 {
+					t.Fatalf("unexpected page for ordering %v: %+v", ordering, page)
+				}
+				backward = append(getUsernames(page.Users), backward...)
+				req.Cursor = page.PrevCursor
+			}
+			if strings.Join(backward, ",") != strings.Join(expected, ",") {
+				t.Errorf("unexpected backward walk for ordering %v: %v, expected: %v", ordering, backward, expected)
+			}
+			req.Cursor = ""
+			req.Offset = 1
+			page, err := dataprovider.GetUsersPage(dataProvider, scope, req)
+			if err != nil {
+				t.Fatalf("unable to get users: %v", err)
+			}
+			if strings.Join(getUsernames(page.Users), ",") != strings.Join(expected[1:3], ",") ||
+				len(page.PrevCursor) == 0 || len(page.NextCursor) == 0 {
+				t.Errorf("unexpected page for ordering %v and offset 1: %+v", ordering, page)
+			}
+		}
+	}
+	page, err := dataprovider.GetUsersPage(dataProvider, dataprovider.UserScope{UsernamePrefixes: []string{"page_user_"}},
+		dataprovider.UsersPageRequest{Limit: 2})
+	if err != nil {
+		t.Fatalf("unable to get users: %v", err)
+	}
+	if page.Total != 5 || strings.Join(getUsernames(page.Users), ",") != "page_user_a,page_user_b" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+	page, err = dataprovider.GetUsersPage(dataProvider, dataprovider.UserScope{},
+		dataprovider.UsersPageRequest{Limit: 2, Username: "page_user_b"})
+	if err != nil {
+		t.Fatalf("unable to get users: %v", err)
+	}
+	if page.Total != 1 || len(page.Users) != 1 || len(page.NextCursor) > 0 || len(page.PrevCursor) > 0 {
+		t.Errorf("unexpected page: %+v", page)
+	}
+	for _, user := range users {
+		_, err = RemoveUser(user, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+}
+
+func TestUsersFilters(t *testing.T) {
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	dayMs := int64(24 * 60 * 60 * 1000)
+	userA := dataprovider.User{
+		Username:       "filter_user_a",
+		ExpirationDate: now + 10*dayMs,
+		QuotaSize:      1000,
+	}
+	userB := dataprovider.User{
+		Username: "filter_user_b",
+	}
+	userB.FsConfig.Provider = 3
+	userB.FsConfig.CryptConfig.Passphrase = "crypt passphrase"
+	userC := dataprovider.User{
+		Username:       "filter_user_c",
+		ExpirationDate: now + 40*dayMs,
+	}
+	var users []dataprovider.User
+	for _, u := range []dataprovider.User{userA, userB, userC} {
+		u.Password = "password"
+		u.HomeDir = filepath.Join(os.TempDir(), u.Username)
+		u.Status = 1
+		if u.Username == "filter_user_b" {
+			u.Status = 0
+		}
+		u.Permissions = map[string][]string{"/": {dataprovider.PermAny}}
+		user, _, err := AddUser(u, http.StatusOK)
+		if err != nil {
+			t.Fatalf("unable to add user: %v", err)
+		}
+		users = append(users, user)
+	}
+	err := dataprovider.UpdateUserQuota(dataProvider, users[0], 1, 950, true)
+	if err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	err = dataprovider.UpdateUserQuota(dataProvider, users[1], 1, 5000, true)
+	if err != nil {
+		t.Errorf("unable to update quota: %v", err)
+	}
+	err = dataprovider.UpdateLastLogin(dataProvider, users[2])
+	if err != nil {
+		t.Errorf("unable to update last login: %v", err)
+	}
+	status0 := 0
+	status1 := 1
+	localProvider := 0
+	cryptProvider := 3
+	testCases := []struct {
+		filter   dataprovider.UsersFilter
+		expected string
+	}{
+		{dataprovider.UsersFilter{}, "filter_user_a,filter_user_b,filter_user_c"},
+		{dataprovider.UsersFilter{Status: &status0}, "filter_user_b"},
+		{dataprovider.UsersFilter{Status: &status1}, "filter_user_a,filter_user_c"},
+		{dataprovider.UsersFilter{ExpiresBefore: now + 20*dayMs}, "filter_user_a"},
+		{dataprovider.UsersFilter{ExpiresAfter: now + 20*dayMs}, "filter_user_c"},
+		{dataprovider.UsersFilter{ExpiresAfter: now, ExpiresBefore: now + 50*dayMs}, "filter_user_a,filter_user_c"},
+		{dataprovider.UsersFilter{FsProvider: &localProvider}, "filter_user_a,filter_user_c"},
+		{dataprovider.UsersFilter{FsProvider: &cryptProvider}, "filter_user_b"},
+		{dataprovider.UsersFilter{MinUsedQuotaSize: 1000}, "filter_user_b"},
+		{dataprovider.UsersFilter{MinQuotaUsagePercent: 90}, "filter_user_a"},
+		{dataprovider.UsersFilter{MinQuotaUsagePercent: 96}, ""},
+		{dataprovider.UsersFilter{LastLoginOlderThanDays: 1}, "filter_user_a,filter_user_b"},
+		{dataprovider.UsersFilter{Status: &status1, FsProvider: &localProvider, LastLoginOlderThanDays: 1}, "filter_user_a"},
+	}
+	// the empty scope uses the SQL queries, if supported, the other scope the users list
+	for _, scope := range []dataprovider.UserScope{{}, {UsernamePrefixes: []string{"filter_user_"}}} {
+		for _, tc := range testCases {
+			page, err := dataprovider.GetUsersPage(dataProvider, scope, dataprovider.UsersPageRequest{
+				Limit:  500,
+				Filter: tc.filter,
+			})
+			if err != nil {
+				t.Fatalf("unable to get users: %v", err)
+			}
+			if page.Total != len(page.Users) {
+				t.Errorf("unexpected total for filter %+v: %v", tc.filter, page.Total)
+			}
+			var usernames []string
+			for _, user := range page.Users {
+				if strings.HasPrefix(user.Username, "filter_user_") {
+					usernames = append(usernames, user.Username)
+				}
+			}
+			if strings.Join(usernames, ",") != tc.expected {
+				t.Errorf("unexpected users for filter %+v: %v, expected: %v", tc.filter, usernames, tc.expected)
+			}
+		}
+	}
+	invalidStatus := 2
+	invalidProvider := 10
+	for _, filter := range []dataprovider.UsersFilter{{Status: &invalidStatus}, {MinUsedQuotaSize: -1},
+		{ExpiresAfter: now + dayMs, ExpiresBefore: now}, {FsProvider: &invalidProvider}} {
+		_, err = dataprovider.GetUsersPage(dataProvider, dataprovider.UserScope{},
+			dataprovider.UsersPageRequest{Limit: 1, Filter: filter})
+		if _, ok := err.(*dataprovider.ValidationError); !ok {
+			t.Errorf("expected validation error for filter %+v, got: %v", filter, err)
+		}
+	}
+	for _, user := range users {
+		_, err = RemoveUser(user, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to remove user: %v", err)
+		}
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// examples from RFC 7396, Appendix A
+	testCases := [][]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range testCases {
+		var target, patch interface{}
+		if err := json.Unmarshal([]byte(tc[0]), &target); err != nil {
+			t.Fatalf("invalid target %v: %v", tc[0], err)
+		}
+		if err := json.Unmarshal([]byte(tc[1]), &patch); err != nil {
+			t.Fatalf("invalid patch %v: %v", tc[1], err)
+		}
+		result, err := json.Marshal(applyMergePatch(target, patch))
+		if err != nil {
+			t.Errorf("unable to marshal the result: %v", err)
+		}
+		if string(result) != tc[2] {
+			t.Errorf("unexpected result applying %v to %v: %v, expected: %v", tc[1], tc[0], string(result), tc[2])
+		}
+	}
+}
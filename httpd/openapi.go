@@ -0,0 +1,642 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gopkg.in/yaml.v2"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// The OpenAPI document is generated from the routes registered inside the router, so it always
+// matches the exposed API. The operations metadata are defined inside apiOperations while the
+// request and response schemas are generated from the Go types using reflection.
+
+var routeParamRegex = regexp.MustCompile(`{([^}]+)}`)
+
+// apiBinary is used as request or response type for the raw file contents
+type apiBinary struct{}
+
+// apiRoute identifies an API operation, the pattern is the same used to register the route
+type apiRoute struct {
+	method  string
+	pattern string
+}
+
+type apiParameter struct {
+	name        string
+	paramType   string
+	description string
+	required    bool
+}
+
+// apiOperation defines the metadata for an API operation. The request and the response can be a value
+// of the Go type to document or a function, in this case the type of its first return value is used.
+// A nil response means that an apiResponse is returned
+type apiOperation struct {
+	id       string
+	tag      string
+	summary  string
+	params   []apiParameter
+	request  interface{}
+	response interface{}
+	// HTTP status code for the successful requests, default 200
+	status int
+}
+
+var (
+	paginationParams = []apiParameter{
+		{name: "offset", paramType: "integer", description: "Number of items to skip, default 0"},
+		{name: "limit", paramType: "integer", description: "The maximum number of items to return. Max value is 500, default is 100"},
+	}
+	disconnectParam = apiParameter{name: "disconnect", paramType: "integer",
+		description: "1 means that the active connections for the user will be closed after a successful update/delete"}
+	syncPathParam     = apiParameter{name: "path", paramType: "string", description: "SFTP/SCP path", required: true}
+	ipParam           = apiParameter{name: "ip", paramType: "string", description: "IP address", required: true}
+	listTypeParam     = apiParameter{name: "type", paramType: "integer", description: "List type: 1 safe list, 2 block list"}
+	usersFilterParams = []apiParameter{
+		{name: "status", paramType: "integer", description: "Filter by status: 0 disabled, 1 enabled"},
+		{name: "expires_after", paramType: "integer", description: "Return only the users with an expiration date after this unix timestamp in milliseconds"},
+		{name: "expires_before", paramType: "integer", description: "Return only the users with an expiration date before this unix timestamp in milliseconds"},
+		{name: "fs_provider", paramType: "integer", description: "Filter by filesystem provider: 0 local, 1 S3, 2 GCS, 3 encrypted local, 4 WebDAV, 5 HDFS, 6 Google Drive, 7 Dropbox"},
+		{name: "min_used_quota_size", paramType: "integer", description: "Return only the users with a used quota size, as bytes, greater than or equal to this value"},
+		{name: "min_quota_usage_percent", paramType: "integer", description: "Return only the users with a quota size limit and a used quota size greater than or equal to this percentage of the limit"},
+		{name: "last_login_older_than_days", paramType: "integer", description: "Return only the users that never logged in or without a login for this number of days"},
+	}
+	loadDataParams = []apiParameter{
+		{name: "scan_quota", paramType: "integer", description: "0 no quota scan, 1 scan quota, 2 scan quota if the user has quota restrictions"},
+		{name: "mode", paramType: "integer", description: "0 new users are added, existing users are updated. 1 existing users are not modified"},
+		{name: "format", paramType: "string", description: "Backup format: json, yaml or csv"},
+		{name: "csv_columns", paramType: "string", description: "Comma separated mapping between the CSV headers and the user fields"},
+	}
+)
+
+var apiOperations = map[apiRoute]apiOperation{
+	{http.MethodGet, versionPath}: {id: "get_version", tag: "version", summary: "Get version details",
+		response: utils.GetAppVersion},
+	{http.MethodGet, openAPIPath}: {id: "get_openapi", tag: "version", summary: "Get the OpenAPI document for this API",
+		response: apiBinary{}},
+	{http.MethodGet, providerStatusPath}: {id: "get_provider_status", tag: "providerstatus",
+		summary: "Get data provider status"},
+	{http.MethodGet, providerMigrationPath}: {id: "get_provider_migration_report", tag: "providermigration",
+		summary:  "Get the consistency report between the data provider in use and the migration target",
+		response: dataprovider.GetMigrationReport},
+	{http.MethodPost, providerMigrationPath}: {id: "sync_provider_migration", tag: "providermigration",
+		summary:  "Synchronize the migration target with the data provider in use",
+		response: dataprovider.SyncMigrationTarget},
+	{http.MethodGet, pluginStatusPath}: {id: "get_plugin_status", tag: "pluginstatus",
+		summary: "Get the health status for the configured plugins", response: plugin.GetStatus},
+	{http.MethodGet, activeConnectionsPath}: {id: "get_connections", tag: "connections",
+		summary: "Get the active users and info about their uploads/downloads",
+		params: []apiParameter{
+			{name: "client_software", paramType: "string", description: "Return only the connections from this client software"},
+			{name: "tenant", paramType: "string", description: "Return only the connections for users with this tenant"},
+			{name: "label", paramType: "string", description: "Return only the connections with this label"},
+		},
+		response: sftpd.GetConnectionsStats},
+	{http.MethodDelete, activeConnectionsPath}: {id: "close_user_connections", tag: "connections",
+		summary: "Terminate all the active connections for the given user",
+		params: []apiParameter{
+			{name: "username", paramType: "string", description: "The user to disconnect", required: true},
+		}},
+	{http.MethodPut, activeConnectionsPath + "/{connectionID}"}: {id: "update_connection_label", tag: "connections",
+		summary: "Set a human readable label for an active connection", request: connectionLabel{}},
+	{http.MethodDelete, activeConnectionsPath + "/{connectionID}"}: {id: "close_connection", tag: "connections",
+		summary: "Terminate an active connection"},
+	{http.MethodGet, quotaScanPath}: {id: "get_quota_scans", tag: "quota", summary: "Get the active quota scans",
+		response: sftpd.GetQuotaScans},
+	{http.MethodPost, quotaScanPath}: {id: "start_quota_scan", tag: "quota", summary: "Start a new quota scan",
+		request: dataprovider.User{}, status: http.StatusCreated},
+	{http.MethodGet, quotaScanPath + "/{username}"}: {id: "get_quota_scan", tag: "quota",
+		summary: "Get the progress for the active quota scan of the given user", response: sftpd.ActiveQuotaScan{}},
+	{http.MethodPost, quotaScanPath + "/{username}/folder"}: {id: "start_user_folder_quota_scan", tag: "quota",
+		summary: "Start a new quota scan limited to a virtual folder, referencing a shared folder, of the given user",
+		request: userFolderQuotaScanRequest{}, status: http.StatusCreated},
+	{http.MethodGet, userPath}: {id: "get_users", tag: "users", summary: "Returns an array with one or more users",
+		params: append([]apiParameter{
+			{name: "order", paramType: "string", description: "Ordering direction: ASC or DESC"},
+			{name: "order_by", paramType: "string", description: "Field to order the users by: username, id, last_login, expiration_date, used_quota_size or created_at. Default username"},
+			{name: "username", paramType: "string", description: "Filter by username, exact match case sensitive"},
+			{name: "cursor", paramType: "string", description: "Cursor returned inside the X-SFTPGo-Next-Cursor or X-SFTPGo-Prev-Cursor headers. The order and order_by parameters must be the same used for the previous request. Cannot be used with offset"},
+		}, append(usersFilterParams, paginationParams...)...),
+		response: []dataprovider.User{}},
+	{http.MethodPost, userPath}: {id: "add_user", tag: "users", summary: "Adds a new user",
+		request: dataprovider.User{}, response: dataprovider.User{}},
+	{http.MethodGet, userPath + "/{userID}"}: {id: "get_user_by_id", tag: "users", summary: "Find user by ID",
+		response: dataprovider.User{}},
+	{http.MethodPut, userPath + "/{userID}"}: {id: "update_user", tag: "users", summary: "Update an existing user",
+		params: []apiParameter{disconnectParam}, request: dataprovider.User{}},
+	{http.MethodPatch, userPath + "/{userID}"}: {id: "patch_user", tag: "users",
+		summary: "Update the given fields of an existing user using a JSON merge patch",
+		params:  []apiParameter{disconnectParam}, request: dataprovider.User{}},
+	{http.MethodDelete, userPath + "/{userID}"}: {id: "delete_user", tag: "users", summary: "Delete an existing user",
+		params: []apiParameter{disconnectParam}},
+	{http.MethodPost, userPath + "/{userID}/clone"}: {id: "clone_user", tag: "users",
+		summary: "Adds a new user with the same settings as an existing one",
+		request: dataprovider.UserCloneRequest{}, response: dataprovider.User{}},
+	{http.MethodPost, userBulkPath}: {id: "bulk_users_operations", tag: "users",
+		summary: "Adds, updates or disables many users, nothing is applied if an operation is invalid",
+		params:  []apiParameter{disconnectParam}, request: []bulkUserOperation{}, response: []bulkUserResult{}},
+	{http.MethodDelete, userBulkPath}: {id: "bulk_delete_users", tag: "users",
+		summary: "Deletes the users matching the given filters, at least a filter is required",
+		params: append([]apiParameter{disconnectParam,
+			{name: "dry_run", paramType: "boolean", description: "If true the matching users are returned without deleting them"},
+		}, usersFilterParams...),
+		response: []bulkUserResult{}},
+	{http.MethodGet, dumpDataPath}: {id: "dumpdata", tag: "maintenance",
+		summary: "Backup SFTPGo data serializing them as JSON, YAML or CSV",
+		params: []apiParameter{
+			{name: "output_file", paramType: "string", description: "Path for the file to write the serialized data to, relative to the configured backups_path", required: true},
+			{name: "indent", paramType: "integer", description: "1 means format the output JSON"},
+			{name: "format", paramType: "string", description: "Output format: json, yaml or csv"},
+		}},
+	{http.MethodGet, loadDataPath}: {id: "loaddata", tag: "maintenance",
+		summary: "Restore SFTPGo data from a JSON, YAML or CSV backup",
+		params: append([]apiParameter{
+			{name: "input_file", paramType: "string", description: "Absolute path for the file to read the serialized data from", required: true},
+		}, loadDataParams...)},
+	{http.MethodPost, loadDataPath}: {id: "loaddata_upload", tag: "maintenance",
+		summary: "Restore SFTPGo data from a JSON, YAML or CSV backup uploaded as request body",
+		params:  loadDataParams, request: apiBinary{}},
+	{http.MethodGet, ipListPath}: {id: "get_iplist_entries", tag: "iplist",
+		summary: "Returns the entries in the IP safe list and in the IP block list",
+		params:  []apiParameter{listTypeParam}, response: dataprovider.GetIPListEntries},
+	{http.MethodPost, ipListPath}: {id: "add_iplist_entry", tag: "iplist",
+		summary: "Adds a new entry to the IP safe list or to the IP block list",
+		request: dataprovider.IPListEntry{}, response: dataprovider.IPListEntry{}},
+	{http.MethodPost, ipListImportPath}: {id: "import_iplist", tag: "iplist",
+		summary: "Imports a set of IP addresses and networks into the IP safe list or into the IP block list",
+		params: []apiParameter{
+			{name: "replace", paramType: "boolean", description: "If true the existing entries of the imported list type not included in the set are removed"},
+		},
+		request: dataprovider.IPListSet{}, response: dataprovider.ImportIPListSet},
+	{http.MethodGet, ipListExportPath}: {id: "export_iplist", tag: "iplist",
+		summary: "Exports the entries in the IP safe list or in the IP block list",
+		params: []apiParameter{listTypeParam,
+			{name: "format", paramType: "string", description: "Output format: json for an IPListSet object, text for one entry for each line"},
+		},
+		response: dataprovider.ExportIPListSet},
+	{http.MethodGet, ipListFeedsPath}: {id: "get_iplist_feeds", tag: "iplist",
+		summary: "Returns the status for the configured external block lists", response: dataprovider.GetIPListFeedsStatus},
+	{http.MethodGet, ipListCheckPath}: {id: "check_ip_address", tag: "iplist",
+		summary: "Returns the IP list entries and the external block lists matching an IP address",
+		params:  []apiParameter{ipParam}, response: dataprovider.CheckIPAddress},
+	{http.MethodPost, ipListUnblockPath}: {id: "unblock_ip_address", tag: "iplist",
+		summary: "Allows the connections from an IP address", params: []apiParameter{ipParam},
+		response: dataprovider.UnblockIPAddress},
+	{http.MethodGet, ipListPath + "/{entryID}"}: {id: "get_iplist_entry_by_id", tag: "iplist",
+		summary: "Find IP list entry by ID", response: dataprovider.IPListEntry{}},
+	{http.MethodPut, ipListPath + "/{entryID}"}: {id: "update_iplist_entry", tag: "iplist",
+		summary: "Update an existing IP list entry", request: dataprovider.IPListEntry{}},
+	{http.MethodDelete, ipListPath + "/{entryID}"}: {id: "delete_iplist_entry", tag: "iplist",
+		summary: "Delete an existing IP list entry"},
+	{http.MethodGet, defenderHostsPath}: {id: "get_defender_hosts", tag: "defender",
+		summary: "Returns the hosts with a score or a ban", response: []sftpd.DefenderEntry{}},
+	{http.MethodGet, defenderHostsPath + "/{ip}"}: {id: "get_defender_host", tag: "defender",
+		summary: "Returns the score and the ban for an IP address", response: sftpd.DefenderEntry{}},
+	{http.MethodDelete, defenderHostsPath + "/{ip}"}: {id: "unban_defender_host", tag: "defender",
+		summary: "Removes the ban and the score for an IP address"},
+	{http.MethodPost, defenderBanPath}: {id: "ban_defender_host", tag: "defender",
+		summary: "Bans an IP address, the ban time is in minutes and 0 means the configured one",
+		request: defenderBanRequest{}, response: sftpd.DefenderEntry{}},
+	{http.MethodGet, planPath}: {id: "get_plans", tag: "plans", summary: "Returns the defined plans",
+		response: []dataprovider.Plan{}},
+	{http.MethodGet, planPath + "/{planID}"}: {id: "get_plan_by_id", tag: "plans", summary: "Find plan by ID",
+		response: dataprovider.Plan{}},
+	{http.MethodPost, planPath}: {id: "add_plan", tag: "plans", summary: "Adds a new plan",
+		request: dataprovider.Plan{}, response: dataprovider.Plan{}},
+	{http.MethodPut, planPath + "/{planID}"}: {id: "update_plan", tag: "plans",
+		summary: "Update an existing plan and apply it to the assigned users",
+		params: []apiParameter{
+			{name: "async", paramType: "boolean", description: "If true the assigned users are updated in background"},
+		},
+		request: dataprovider.Plan{}},
+	{http.MethodDelete, planPath + "/{planID}"}: {id: "delete_plan", tag: "plans",
+		summary: "Delete an existing plan. A plan assigned to some users cannot be deleted"},
+	{http.MethodGet, planPropagationPath}: {id: "get_plan_propagations", tag: "plans",
+		summary: "Get the plan propagations", response: dataprovider.GetPlanPropagations},
+	{http.MethodGet, planPropagationPath + "/{name}"}: {id: "get_plan_propagation", tag: "plans",
+		summary: "Get the last propagation for the given plan", response: dataprovider.GetPlanPropagation},
+	{http.MethodGet, userTemplatePath}: {id: "get_user_templates", tag: "user templates",
+		summary: "Returns the defined user templates", response: []dataprovider.UserTemplate{}},
+	{http.MethodGet, userTemplatePath + "/{templateID}"}: {id: "get_user_template_by_id", tag: "user templates",
+		summary: "Find user template by ID", response: dataprovider.UserTemplate{}},
+	{http.MethodPost, userTemplatePath}: {id: "add_user_template", tag: "user templates",
+		summary: "Adds a new user template", request: dataprovider.UserTemplate{}, response: dataprovider.UserTemplate{}},
+	{http.MethodPut, userTemplatePath + "/{templateID}"}: {id: "update_user_template", tag: "user templates",
+		summary: "Update an existing user template. The users already created from the template are not changed",
+		request: dataprovider.UserTemplate{}},
+	{http.MethodDelete, userTemplatePath + "/{templateID}"}: {id: "delete_user_template", tag: "user templates",
+		summary: "Delete an existing user template. The users created from the template are not changed"},
+	{http.MethodPost, userTemplatePath + "/{templateID}/user"}: {id: "add_user_from_template", tag: "user templates",
+		summary: "Adds a new user built from a user template", request: dataprovider.UserCloneRequest{},
+		response: dataprovider.User{}},
+	{http.MethodGet, folderPath}: {id: "get_folders", tag: "folders", summary: "Returns the defined shared folders",
+		response: []dataprovider.Folder{}},
+	{http.MethodGet, folderPath + "/{folderID}"}: {id: "get_folder_by_id", tag: "folders",
+		summary: "Find shared folder by ID", response: dataprovider.Folder{}},
+	{http.MethodPost, folderPath}: {id: "add_folder", tag: "folders", summary: "Adds a new shared folder",
+		request: dataprovider.Folder{}, response: dataprovider.Folder{}},
+	{http.MethodPut, folderPath + "/{folderID}"}: {id: "update_folder", tag: "folders",
+		summary: "Update an existing shared folder", request: dataprovider.Folder{}},
+	{http.MethodDelete, folderPath + "/{folderID}"}: {id: "delete_folder", tag: "folders",
+		summary: "Delete an existing shared folder. A folder referenced by some users cannot be deleted"},
+	{http.MethodGet, folderQuotaScanPath}: {id: "get_folder_quota_scans", tag: "quota",
+		summary: "Get the active quota scans for the shared folders", response: sftpd.GetFolderQuotaScans},
+	{http.MethodPost, folderQuotaScanPath}: {id: "start_folder_quota_scan", tag: "quota",
+		summary: "Start a new quota scan for a shared folder", request: dataprovider.Folder{}, status: http.StatusCreated},
+	{http.MethodGet, folderQuotaScanPath + "/{name}"}: {id: "get_folder_quota_scan", tag: "quota",
+		summary:  "Get the progress for the active quota scan of the given shared folder",
+		response: sftpd.ActiveFolderQuotaScan{}},
+	{http.MethodGet, userDefaultsPath + "/{userID}"}: {id: "get_user_defaults_diff", tag: "users",
+		summary: "Get the user fields that deviate from the defaults", response: dataprovider.GetUserDefaultsDiff},
+	{http.MethodPost, userDefaultsPath + "/{userID}/reset"}: {id: "reset_user_to_defaults", tag: "users",
+		summary: "Reset the given user fields to the defaults", request: userDefaultsResetRequest{},
+		response: dataprovider.ResetUserFieldsToDefaults},
+	{http.MethodGet, userOverridePath}: {id: "get_user_overrides", tag: "user overrides",
+		summary: "Returns the active temporary user overrides", response: dataprovider.GetUserOverrides},
+	{http.MethodPost, userOverridePath}: {id: "add_user_override", tag: "user overrides",
+		summary: "Adds a temporary override for the quota and bandwidth limits of an existing user",
+		request: dataprovider.UserOverride{}, response: dataprovider.UserOverride{}},
+	{http.MethodGet, userOverridePath + "/{username}"}: {id: "get_user_override", tag: "user overrides",
+		summary: "Returns the active override for the given username", response: dataprovider.GetUserOverride},
+	{http.MethodDelete, userOverridePath + "/{username}"}: {id: "delete_user_override", tag: "user overrides",
+		summary: "Removes the active override for the given username"},
+	{http.MethodGet, userOverrideAuditPath}: {id: "get_user_overrides_audit", tag: "user overrides",
+		summary: "Returns the audit records for the user overrides, oldest first", response: dataprovider.GetUserOverridesAuditRecords},
+	{http.MethodGet, userOffboardingPath}: {id: "get_offboardings", tag: "users",
+		summary: "Get the users offboardings", response: []UserOffboarding{}},
+	{http.MethodPost, userOffboardingPath}: {id: "start_offboarding", tag: "users",
+		summary: "Start a new user offboarding", request: OffboardingRequest{}, status: http.StatusCreated},
+	{http.MethodGet, userOffboardingPath + "/{username}"}: {id: "get_offboarding", tag: "users",
+		summary: "Get the offboarding for the given user", response: UserOffboarding{}},
+	{http.MethodGet, staleFilesReportPath}: {id: "get_stale_files_report", tag: "reports",
+		summary: "Returns the stale files report",
+		params: []apiParameter{
+			{name: "older_than_days", paramType: "integer", description: "Files not modified for this number of days are considered stale"},
+			{name: "top_files", paramType: "integer", description: "Number of largest stale files to include for each user"},
+			{name: "username", paramType: "string", description: "Generate the report for this user only"},
+		},
+		response: generateStaleFilesReports},
+	{http.MethodGet, duplicatesScanPath}: {id: "get_duplicates_scans", tag: "reports",
+		summary: "Get the duplicate files scans", response: getDuplicatesScansStatus},
+	{http.MethodPost, duplicatesScanPath}: {id: "start_duplicates_scan", tag: "reports",
+		summary: "Start a new duplicate files scan", request: dataprovider.User{}, status: http.StatusCreated},
+	{http.MethodGet, duplicatesScanPath + "/{username}"}: {id: "get_duplicates_scan", tag: "reports",
+		summary: "Get the duplicate files scan for the given user", response: DuplicatesScan{}},
+	{http.MethodDelete, duplicatesScanPath + "/{username}"}: {id: "cancel_duplicates_scan", tag: "reports",
+		summary: "Cancel or remove a duplicate files scan"},
+	{http.MethodGet, activityReportPath}: {id: "get_users_activity", tag: "reports",
+		summary: "Returns the users activity", response: sftpd.GetUsersActivity},
+	{http.MethodGet, activityReportPath + "/{username}"}: {id: "get_user_activity", tag: "reports",
+		summary: "Returns the activity for the given user", response: sftpd.GetUserActivity},
+	{http.MethodGet, inactiveUsersPath}: {id: "get_inactive_users_report", tag: "reports",
+		summary: "Returns the users the inactivity policy would warn, disable or expire now, nothing is modified",
+		params: []apiParameter{
+			{name: "inactive_days", paramType: "integer", description: "Override the configured inactive_days"},
+			{name: "grace_days", paramType: "integer", description: "Override the configured grace_days"},
+			{name: "action", paramType: "integer", description: "Override the configured action: 0 disable, 1 expire"},
+		},
+		response: dataprovider.InactivityReport{}},
+	{http.MethodGet, transferReceiptsPath + "/{username}"}: {id: "get_transfer_receipts", tag: "reports",
+		summary: "Returns the transfer receipts for the given user", params: paginationParams,
+		response: sftpd.GetTransferReceipts},
+	{http.MethodGet, transferReceiptsPath + "/{username}/{receiptID}"}: {id: "get_transfer_receipt", tag: "reports",
+		summary: "Returns a transfer receipt", response: sftpd.GetTransferReceipt},
+	{http.MethodPost, hooksTestPath + "/actions"}: {id: "test_action_hooks", tag: "maintenance",
+		summary: "Test the custom actions hooks", request: HookTestRequest{}, response: sftpd.TestAction},
+	{http.MethodPost, hooksTestPath + "/provider_actions"}: {id: "test_provider_action_hooks", tag: "maintenance",
+		summary: "Test the data provider actions hooks", request: HookTestRequest{}, response: dataprovider.TestAction},
+	{http.MethodPost, loginSimulationPath}: {id: "simulate_login", tag: "users", summary: "Simulate a login",
+		request: sftpd.LoginSimulationRequest{}, response: sftpd.SimulateLogin},
+	{http.MethodGet, uiPreferencesPath}: {id: "get_ui_preferences", tag: "ui preferences",
+		summary:  "Returns all the web admin UI preferences for the requesting admin",
+		response: map[string]json.RawMessage{}},
+	{http.MethodGet, uiPreferencesPath + "/{key}"}: {id: "get_ui_preference", tag: "ui preferences",
+		summary: "Returns a web admin UI preference for the requesting admin", response: json.RawMessage{}},
+	{http.MethodPut, uiPreferencesPath + "/{key}"}: {id: "set_ui_preference", tag: "ui preferences",
+		summary: "Adds or replaces a web admin UI preference for the requesting admin", request: json.RawMessage{}},
+	{http.MethodDelete, uiPreferencesPath + "/{key}"}: {id: "delete_ui_preference", tag: "ui preferences",
+		summary: "Removes a web admin UI preference for the requesting admin"},
+	{http.MethodGet, syncManifestPath}: {id: "get_sync_manifest", tag: "sync",
+		summary: "Get the manifest for a directory tree",
+		params: []apiParameter{syncPathParam,
+			{name: "hash", paramType: "boolean", description: "If true the SHA256 digest is computed for the files"},
+		},
+		response: (*sftpd.SyncConnection).GetManifest},
+	{http.MethodPost, syncPlanPath}: {id: "get_sync_plan", tag: "sync",
+		summary: "Compare a client manifest with a directory tree", params: []apiParameter{syncPathParam},
+		request: []sftpd.SyncManifestEntry{}, response: (*sftpd.SyncConnection).GetSyncPlan},
+	{http.MethodGet, syncFilePath}: {id: "download_sync_file", tag: "sync", summary: "Download a file",
+		params: []apiParameter{syncPathParam}, response: apiBinary{}},
+	{http.MethodPut, syncFilePath}: {id: "upload_sync_file", tag: "sync", summary: "Upload a file",
+		params: []apiParameter{syncPathParam,
+			{name: "mtime", paramType: "integer", description: "Modification time to set as unix timestamp in milliseconds"},
+		},
+		request: apiBinary{}, status: http.StatusCreated},
+	{http.MethodDelete, syncFilePath}: {id: "remove_sync_path", tag: "sync",
+		summary: "Remove a file or an empty directory", params: []apiParameter{syncPathParam}},
+	{http.MethodPost, syncDirPath}: {id: "create_sync_dir", tag: "sync", summary: "Create a directory",
+		params: []apiParameter{syncPathParam}, status: http.StatusCreated},
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `yaml:"openapi"`
+	Info       openAPIInfo                             `yaml:"info"`
+	Servers    []openAPIServer                         `yaml:"servers"`
+	Security   []map[string][]string                   `yaml:"security"`
+	Paths      map[string]map[string]*openAPIOperation `yaml:"paths"`
+	Components openAPIComponents                       `yaml:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	Version     string `yaml:"version"`
+}
+
+type openAPIServer struct {
+	URL string `yaml:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema         `yaml:"schemas"`
+	SecuritySchemes map[string]map[string]interface{} `yaml:"securitySchemes"`
+}
+
+type openAPIOperation struct {
+	Tags        []string                    `yaml:"tags,omitempty"`
+	Summary     string                      `yaml:"summary,omitempty"`
+	OperationID string                      `yaml:"operationId,omitempty"`
+	Parameters  []openAPIParameter          `yaml:"parameters,omitempty"`
+	RequestBody *openAPIContent             `yaml:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `yaml:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `yaml:"name"`
+	In          string         `yaml:"in"`
+	Description string         `yaml:"description,omitempty"`
+	Required    bool           `yaml:"required"`
+	Schema      *openAPISchema `yaml:"schema"`
+}
+
+type openAPIContent struct {
+	Required bool                                 `yaml:"required,omitempty"`
+	Content  map[string]map[string]*openAPISchema `yaml:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                               `yaml:"description"`
+	Content     map[string]map[string]*openAPISchema `yaml:"content,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `yaml:"$ref,omitempty"`
+	Type                 string                    `yaml:"type,omitempty"`
+	Format               string                    `yaml:"format,omitempty"`
+	Items                *openAPISchema            `yaml:"items,omitempty"`
+	Properties           map[string]*openAPISchema `yaml:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `yaml:"additionalProperties,omitempty"`
+}
+
+// openAPIGenerator generates the schemas for the Go types, the structs are added as components
+type openAPIGenerator struct {
+	schemas map[string]*openAPISchema
+	names   map[reflect.Type]string
+}
+
+func getOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	doc, err := generateOpenAPIDocument(router)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(doc) //nolint:errcheck
+}
+
+// generateOpenAPIDocument returns the OpenAPI 3 document, in YAML format, for the API routes
+// registered inside the given router
+func generateOpenAPIDocument(routes chi.Routes) ([]byte, error) {
+	g := &openAPIGenerator{
+		schemas: make(map[string]*openAPISchema),
+		names:   make(map[reflect.Type]string),
+	}
+	doc := openAPIDocument{
+		OpenAPI: "3.0.1",
+		Info: openAPIInfo{
+			Title:       "SFTPGo",
+			Description: "SFTPGo REST API",
+			Version:     utils.GetAppVersion().Version,
+		},
+		Servers:  []openAPIServer{{URL: apiPrefix}},
+		Security: []map[string][]string{{"BasicAuth": {}}},
+		Paths:    make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: g.schemas,
+			SecuritySchemes: map[string]map[string]interface{}{
+				"BasicAuth": {"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+	apiResponseSchema := g.getSchema(reflect.TypeOf(apiResponse{}))
+	err := chi.Walk(routes, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, apiPrefix+"/") {
+			return nil
+		}
+		path := strings.TrimPrefix(route, apiPrefix)
+		if _, ok := doc.Paths[path]; !ok {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(method)] = g.getOperation(apiRoute{method: method, pattern: route},
+			apiResponseSchema)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func (g *openAPIGenerator) getOperation(route apiRoute, apiResponseSchema *openAPISchema) *openAPIOperation {
+	// the routes without metadata are documented anyway, so the document always includes all the routes
+	metadata := apiOperations[route]
+	operation := &openAPIOperation{
+		Summary:     metadata.summary,
+		OperationID: metadata.id,
+		Responses: map[string]*openAPIResponse{
+			"default": {
+				Description: "Error",
+				Content:     map[string]map[string]*openAPISchema{"application/json": {"schema": apiResponseSchema}},
+			},
+		},
+	}
+	if len(metadata.tag) > 0 {
+		operation.Tags = []string{metadata.tag}
+	}
+	for _, match := range routeParamRegex.FindAllStringSubmatch(route.pattern, -1) {
+		operation.Parameters = append(operation.Parameters, openAPIParameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &openAPISchema{Type: "string"},
+		})
+	}
+	for _, param := range metadata.params {
+		operation.Parameters = append(operation.Parameters, openAPIParameter{
+			Name:        param.name,
+			In:          "query",
+			Description: param.description,
+			Required:    param.required,
+			Schema:      &openAPISchema{Type: param.paramType},
+		})
+	}
+	if metadata.request != nil {
+		contentType, schema := g.getContent(metadata.request)
+		operation.RequestBody = &openAPIContent{
+			Required: true,
+			Content:  map[string]map[string]*openAPISchema{contentType: {"schema": schema}},
+		}
+	}
+	status := metadata.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType, schema := "application/json", apiResponseSchema
+	if metadata.response != nil {
+		contentType, schema = g.getContent(metadata.response)
+	}
+	operation.Responses[strconv.Itoa(status)] = &openAPIResponse{
+		Description: "successful operation",
+		Content:     map[string]map[string]*openAPISchema{contentType: {"schema": schema}},
+	}
+	return operation
+}
+
+// getContent returns the content type and the schema for a request or response value
+func (g *openAPIGenerator) getContent(value interface{}) (string, *openAPISchema) {
+	t := reflect.TypeOf(value)
+	if t.Kind() == reflect.Func {
+		t = t.Out(0)
+	}
+	if t == reflect.TypeOf(apiBinary{}) {
+		return "application/octet-stream", &openAPISchema{Type: "string", Format: "binary"}
+	}
+	return "application/json", g.getSchema(t)
+}
+
+func (g *openAPIGenerator) getSchema(t reflect.Type) *openAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		// any JSON value
+		return &openAPISchema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: g.getSchema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.getSchema(t.Elem())}
+	case reflect.Struct:
+		return &openAPISchema{Ref: "#/components/schemas/" + g.addStructSchema(t)}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// addStructSchema adds the schema for the given struct to the components, if missing, and returns its name
+func (g *openAPIGenerator) addStructSchema(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := g.getStructName(t)
+	g.names[t] = name
+	schema := &openAPISchema{
+		Type:       "object",
+		Properties: make(map[string]*openAPISchema),
+	}
+	// add the schema before the properties, so the recursive types are supported
+	g.schemas[name] = schema
+	g.addStructProperties(t, schema)
+	return name
+}
+
+func (g *openAPIGenerator) addStructProperties(t reflect.Type, schema *openAPISchema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && len(name) == 0 {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				g.addStructProperties(fieldType, schema)
+				continue
+			}
+		}
+		if len(field.PkgPath) > 0 {
+			// unexported field
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		schema.Properties[name] = g.getSchema(field.Type)
+	}
+}
+
+// getStructName returns the component name for a struct, the package name is added if different
+// structs have the same name
+func (g *openAPIGenerator) getStructName(t reflect.Type) string {
+	name := t.Name()
+	if len(name) == 0 {
+		name = "Object"
+	}
+	name = strings.ToUpper(name[:1]) + name[1:]
+	candidate := name
+	for i := 1; ; i++ {
+		if _, ok := g.schemas[candidate]; !ok {
+			return candidate
+		}
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		candidate = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		if i > 1 {
+			candidate = fmt.Sprintf("%v%v", candidate, i)
+		}
+	}
+}
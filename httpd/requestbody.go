@@ -0,0 +1,70 @@
+package httpd
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/drakkan/sftpgo/metrics"
+)
+
+// requestBodyLimit defines the maximum request body size for a group of endpoints,
+// the endpoint name is used as label for the request body metrics
+type requestBodyLimit struct {
+	endpoint string
+	size     int64
+}
+
+// request body limits
+var (
+	// login simulation, only credentials are expected
+	loginBodyLimit = requestBodyLimit{endpoint: "login", size: 16384} // 16KB
+	// REST API objects, for example users and plans
+	apiBodyLimit = requestBodyLimit{endpoint: "api", size: 1048576} // 1MB
+	// web admin UI preferences, they are small JSON values such as the users table state
+	uiPreferenceBodyLimit = requestBodyLimit{endpoint: "ui_preference", size: 65536} // 64KB
+	// web admin forms
+	webFormBodyLimit = requestBodyLimit{endpoint: "web_form", size: 1048576} // 1MB
+	// web admin user forms, they can include a GCS credentials file
+	webUserFormBodyLimit  = requestBodyLimit{endpoint: "web_user_form", size: 5242880}  // 5MB
+	ipListImportBodyLimit = requestBodyLimit{endpoint: "iplist_import", size: 10485760} // 10MB
+	syncManifestBodyLimit = requestBodyLimit{endpoint: "sync_manifest", size: 10485760} // 10MB
+	userBulkBodyLimit     = requestBodyLimit{endpoint: "user_bulk", size: 10485760}     // 10MB
+	// uploaded backups, they are not loaded in memory
+	loadDataBodyLimit = requestBodyLimit{endpoint: "loaddata", size: 1073741824} // 1GB
+)
+
+// limitRequestBody limits the request body to the given size, a body exceeding the limit
+// causes a read error. The body size is reported to the metrics once fully read
+func limitRequestBody(w http.ResponseWriter, r *http.Request, limit requestBodyLimit) {
+	r.Body = &limitedRequestBody{
+		ReadCloser: http.MaxBytesReader(w, r.Body, limit.size),
+		limit:      limit,
+	}
+}
+
+type limitedRequestBody struct {
+	io.ReadCloser
+	limit    requestBodyLimit
+	read     int64
+	reported bool
+}
+
+func (b *limitedRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && !b.reported {
+		b.reported = true
+		if err == io.EOF {
+			metrics.HTTPRequestBodyRead(b.limit.endpoint, b.read)
+		} else if b.read >= b.limit.size {
+			metrics.HTTPRequestBodyTooLarge(b.limit.endpoint)
+		}
+	}
+	return n, err
+}
+
+// isRequestBodyTooLarge returns true if the given error is returned reading a request body
+// exceeding the limit
+func isRequestBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/go-chi/chi"
@@ -19,9 +20,10 @@ func GetHTTPRouter() http.Handler {
 	return router
 }
 
-func initializeRouter(staticFilesPath string, profiler bool) {
+func initializeRouter(staticFilesPath string, customRoutes []CustomRoute, profiler bool) {
 	router = chi.NewRouter()
 	router.Use(middleware.RequestID)
+	router.Use(checkIPLists)
 	router.Use(middleware.RealIP)
 	router.Use(logger.NewStructuredLogger(logger.GetLogger()))
 	router.Use(middleware.Recoverer)
@@ -44,6 +46,10 @@ func initializeRouter(staticFilesPath string, profiler bool) {
 		http.Redirect(w, r, webUsersPath, http.StatusMovedPermanently)
 	})
 
+	// the health check does not require authentication, so it can be used by the orchestrators
+	// and the load balancers probes
+	router.Get(healthzPath, checkHealth)
+
 	router.Group(func(router chi.Router) {
 		router.Use(checkAuth)
 
@@ -57,6 +63,8 @@ func initializeRouter(staticFilesPath string, profiler bool) {
 			render.JSON(w, r, utils.GetAppVersion())
 		})
 
+		router.Get(openAPIPath, getOpenAPIDocument)
+
 		router.Get(providerStatusPath, func(w http.ResponseWriter, r *http.Request) {
 			err := dataprovider.GetProviderStatus(dataProvider)
 			if err != nil {
@@ -66,33 +74,140 @@ func initializeRouter(staticFilesPath string, profiler bool) {
 			}
 		})
 
-		router.Get(activeConnectionsPath, func(w http.ResponseWriter, r *http.Request) {
-			render.JSON(w, r, sftpd.GetConnectionsStats())
+		router.Get(providerMigrationPath, getProviderMigrationReport)
+		router.Post(providerMigrationPath, syncProviderMigration)
+
+		router.Get(pluginStatusPath, func(w http.ResponseWriter, r *http.Request) {
+			render.JSON(w, r, plugin.GetStatus())
 		})
 
+		router.Get(activeConnectionsPath, getConnections)
+		router.Delete(activeConnectionsPath, closeUserConnections)
+		router.Put(activeConnectionsPath+"/{connectionID}", updateConnectionLabel)
 		router.Delete(activeConnectionsPath+"/{connectionID}", handleCloseConnection)
 		router.Get(quotaScanPath, getQuotaScans)
 		router.Post(quotaScanPath, startQuotaScan)
+		router.Get(quotaScanPath+"/{username}", getQuotaScan)
+		router.Post(quotaScanPath+"/{username}/folder", startUserFolderQuotaScan)
 		router.Get(userPath, getUsers)
 		router.Post(userPath, addUser)
 		router.Get(userPath+"/{userID}", getUserByID)
 		router.Put(userPath+"/{userID}", updateUser)
+		router.Patch(userPath+"/{userID}", patchUser)
 		router.Delete(userPath+"/{userID}", deleteUser)
+		router.Post(userPath+"/{userID}/clone", cloneUser)
+		router.Post(userBulkPath, bulkUsersOperations)
+		router.Delete(userBulkPath, bulkDeleteUsers)
 		router.Get(dumpDataPath, dumpData)
 		router.Get(loadDataPath, loadData)
+		router.Post(loadDataPath, loadDataFromBody)
+		router.Get(ipListPath, getIPListEntries)
+		router.Post(ipListPath, addIPListEntry)
+		router.Post(ipListImportPath, importIPList)
+		router.Get(ipListExportPath, exportIPList)
+		router.Get(ipListFeedsPath, getIPListFeeds)
+		router.Get(ipListCheckPath, checkIPAddress)
+		router.Post(ipListUnblockPath, unblockIPAddress)
+		router.Get(ipListPath+"/{entryID}", getIPListEntryByID)
+		router.Put(ipListPath+"/{entryID}", updateIPListEntry)
+		router.Delete(ipListPath+"/{entryID}", deleteIPListEntry)
+		router.Get(defenderHostsPath, getDefenderHosts)
+		router.Get(defenderHostsPath+"/{ip}", getDefenderHost)
+		router.Delete(defenderHostsPath+"/{ip}", unbanDefenderHost)
+		router.Post(defenderBanPath, banDefenderHost)
+		router.Get(planPath, getPlans)
+		router.Get(planPath+"/{planID}", getPlanByID)
+		router.Post(planPath, addPlan)
+		router.Put(planPath+"/{planID}", updatePlan)
+		router.Delete(planPath+"/{planID}", deletePlan)
+		router.Get(planPropagationPath, getPlanPropagations)
+		router.Get(planPropagationPath+"/{name}", getPlanPropagation)
+		router.Get(userTemplatePath, getUserTemplates)
+		router.Get(userTemplatePath+"/{templateID}", getUserTemplateByID)
+		router.Post(userTemplatePath, addUserTemplate)
+		router.Put(userTemplatePath+"/{templateID}", updateUserTemplate)
+		router.Delete(userTemplatePath+"/{templateID}", deleteUserTemplate)
+		router.Post(userTemplatePath+"/{templateID}/user", addUserFromTemplate)
+		router.Get(folderPath, getFolders)
+		router.Get(folderPath+"/{folderID}", getFolderByID)
+		router.Post(folderPath, addFolder)
+		router.Put(folderPath+"/{folderID}", updateFolder)
+		router.Delete(folderPath+"/{folderID}", deleteFolder)
+		router.Get(folderQuotaScanPath, getFolderQuotaScans)
+		router.Post(folderQuotaScanPath, startFolderQuotaScan)
+		router.Get(folderQuotaScanPath+"/{name}", getFolderQuotaScan)
+		router.Get(userDefaultsPath+"/{userID}", getUserDefaultsDiff)
+		router.Post(userDefaultsPath+"/{userID}/reset", resetUserToDefaults)
+		router.Get(userOverridePath, getUserOverrides)
+		router.Post(userOverridePath, addUserOverride)
+		router.Get(userOverridePath+"/{username}", getUserOverride)
+		router.Delete(userOverridePath+"/{username}", deleteUserOverride)
+		router.Get(userOverrideAuditPath, getUserOverridesAudit)
+		router.Get(userOffboardingPath, getOffboardings)
+		router.Post(userOffboardingPath, startOffboarding)
+		router.Get(userOffboardingPath+"/{username}", getOffboarding)
+		router.Get(staleFilesReportPath, getStaleFilesReport)
+		router.Get(duplicatesScanPath, getDuplicatesScans)
+		router.Post(duplicatesScanPath, startDuplicatesScan)
+		router.Get(duplicatesScanPath+"/{username}", getDuplicatesScan)
+		router.Delete(duplicatesScanPath+"/{username}", cancelDuplicatesScan)
+		router.Get(activityReportPath, getUsersActivity)
+		router.Get(activityReportPath+"/{username}", getUserActivity)
+		router.Get(inactiveUsersPath, getInactiveUsersReport)
+		router.Get(transferReceiptsPath+"/{username}", getTransferReceipts)
+		router.Get(transferReceiptsPath+"/{username}/{receiptID}", getTransferReceipt)
+		router.Post(hooksTestPath+"/actions", testActionHooks)
+		router.Post(hooksTestPath+"/provider_actions", testProviderActionHooks)
+		router.Post(loginSimulationPath, simulateLogin)
+		router.Get(uiPreferencesPath, getUIPreferences)
+		router.Get(uiPreferencesPath+"/{key}", getUIPreference)
+		router.Put(uiPreferencesPath+"/{key}", setUIPreference)
+		router.Delete(uiPreferencesPath+"/{key}", deleteUIPreference)
 		router.Get(webUsersPath, handleGetWebUsers)
 		router.Get(webUserPath, handleWebAddUserGet)
 		router.Get(webUserPath+"/{userID}", handleWebUpdateUserGet)
 		router.Post(webUserPath, handleWebAddUserPost)
 		router.Post(webUserPath+"/{userID}", handleWebUpdateUserPost)
 		router.Get(webConnectionsPath, handleWebGetConnections)
+		router.Get(webIPListPath, handleGetWebIPList)
+		router.Post(webIPListUnblockPath, handleWebUnblockIPPost)
+		router.Get(webIPListEntryPath, handleWebAddIPListEntryGet)
+		router.Get(webIPListEntryPath+"/{entryID}", handleWebUpdateIPListEntryGet)
+		router.Post(webIPListEntryPath, handleWebAddIPListEntryPost)
+		router.Post(webIPListEntryPath+"/{entryID}", handleWebUpdateIPListEntryPost)
+		router.Get(webPlansPath, handleGetWebPlans)
+		router.Get(webPlanPath, handleWebAddPlanGet)
+		router.Get(webPlanPath+"/{planID}", handleWebUpdatePlanGet)
+		router.Post(webPlanPath, handleWebAddPlanPost)
+		router.Post(webPlanPath+"/{planID}", handleWebUpdatePlanPost)
+		router.Get(webFoldersPath, handleGetWebFolders)
+		router.Get(webFolderPath, handleWebFolderGet)
+		router.Post(webFolderPath, handleWebFolderPost)
+		router.Post(webFolderDeletePath, handleWebDeleteFolderPost)
+		router.Get(webJobsPath, handleGetWebJobs)
+		router.Post(webJobsPath, handleWebJobsPost)
 	})
 
+	if syncAPIConf.Enabled {
+		router.Group(func(router chi.Router) {
+			router.Use(checkSyncAuth)
+
+			router.Get(syncManifestPath, getSyncManifest)
+			router.Post(syncPlanPath, getSyncPlan)
+			router.Get(syncFilePath, downloadSyncFile)
+			router.Put(syncFilePath, uploadSyncFile)
+			router.Delete(syncFilePath, removeSyncPath)
+			router.Post(syncDirPath, createSyncDir)
+		})
+	}
+
 	router.Group(func(router chi.Router) {
 		compressor := middleware.NewCompressor(5)
 		router.Use(compressor.Handler)
-		fileServer(router, webStaticFilesPath, http.Dir(staticFilesPath))
+		fileServer(router, webStaticFilesPath, getStaticFileSystem(staticFilesPath))
 	})
+
+	mountCustomRoutes(router, customRoutes)
 }
 
 func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
@@ -101,7 +216,7 @@ func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, "connectionID is mandatory", http.StatusBadRequest)
 		return
 	}
-	if sftpd.CloseActiveConnection(connectionID) {
+	if isConnectionInScope(connectionID, getAdminScope(r.Context())) && sftpd.CloseActiveConnection(connectionID) {
 		sendAPIResponse(w, r, nil, "Connection closed", http.StatusOK)
 	} else {
 		sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
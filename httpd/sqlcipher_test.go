@@ -0,0 +1,8 @@
+// +build sqlcipher
+
+package httpd_test
+
+import (
+	// go-sqlcipher registers itself as sqlite3 driver, so it cannot be linked together with go-sqlite3
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
@@ -0,0 +1,7 @@
+// +build !sqlcipher
+
+package httpd_test
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
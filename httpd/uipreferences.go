@@ -0,0 +1,208 @@
+package httpd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	// maximum number of preferences for each admin
+	maxUIPreferences = 50
+	// the users page saves its table state, page size, ordering, search filter and visible
+	// columns, using this key
+	uiPreferenceUsersTable = "users_table"
+)
+
+var (
+	uiPreferences          UIPreferencesStore
+	uiPreferenceKeyRegex   = regexp.MustCompile("^[a-zA-Z0-9_.-]{1,64}$")
+	errUIPreferenceMissing = errors.New("UI preference not found")
+)
+
+// UIPreferencesConfig defines the storage for the web admin UI preferences, such as the
+// saved filters, the visible columns and the page sizes for the users page
+type UIPreferencesConfig struct {
+	// Directory where the preferences are saved, a JSON file for each admin.
+	// This can be an absolute path or a path relative to the config dir.
+	// If empty the preferences are kept in memory and they are lost on restart
+	StoragePath string `json:"storage_path" mapstructure:"storage_path"`
+}
+
+// UIPreferencesStore defines the storage for the web admin UI preferences. The preferences
+// are scoped to the admin username, empty if HTTP authentication is disabled, and the values
+// are JSON encoded
+type UIPreferencesStore interface {
+	// GetAll returns all the preferences for the given admin
+	GetAll(admin string) (map[string]json.RawMessage, error)
+	// Set adds or replaces a preference for the given admin
+	Set(admin, key string, value json.RawMessage) error
+	// Delete removes a preference for the given admin, removing a missing preference
+	// is not an error
+	Delete(admin, key string) error
+}
+
+// SetUIPreferencesStore sets a custom storage for the web admin UI preferences.
+// It must be called before Initialize, the configured storage is used otherwise
+func SetUIPreferencesStore(store UIPreferencesStore) {
+	uiPreferences = store
+}
+
+func initializeUIPreferences(config UIPreferencesConfig, configDir string) {
+	if uiPreferences != nil {
+		return
+	}
+	storagePath := getConfigPath(config.StoragePath, configDir)
+	if len(storagePath) == 0 {
+		uiPreferences = newMemoryUIPreferences()
+		return
+	}
+	logger.Debug(logSender, "", "the UI preferences are saved inside %#v", storagePath)
+	uiPreferences = newFileUIPreferences(storagePath)
+}
+
+func isUIPreferenceKeyValid(key string) bool {
+	return uiPreferenceKeyRegex.MatchString(key)
+}
+
+type memoryUIPreferences struct {
+	sync.RWMutex
+	preferences map[string]map[string]json.RawMessage
+}
+
+func newMemoryUIPreferences() *memoryUIPreferences {
+	return &memoryUIPreferences{
+		preferences: make(map[string]map[string]json.RawMessage),
+	}
+}
+
+func (p *memoryUIPreferences) GetAll(admin string) (map[string]json.RawMessage, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	result := make(map[string]json.RawMessage)
+	for k, v := range p.preferences[admin] {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (p *memoryUIPreferences) Set(admin, key string, value json.RawMessage) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.preferences[admin]; !ok {
+		p.preferences[admin] = make(map[string]json.RawMessage)
+	}
+	p.preferences[admin][key] = value
+	return nil
+}
+
+func (p *memoryUIPreferences) Delete(admin, key string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.preferences[admin], key)
+	if len(p.preferences[admin]) == 0 {
+		delete(p.preferences, admin)
+	}
+	return nil
+}
+
+// fileUIPreferences saves the preferences for each admin inside a JSON file, the file
+// name is derived from the hex encoded admin username so any username is safe to use
+type fileUIPreferences struct {
+	sync.Mutex
+	storagePath string
+}
+
+func newFileUIPreferences(storagePath string) *fileUIPreferences {
+	return &fileUIPreferences{
+		storagePath: storagePath,
+	}
+}
+
+func (p *fileUIPreferences) getFilePath(admin string) string {
+	return filepath.Join(p.storagePath, "admin_"+hex.EncodeToString([]byte(admin))+".json")
+}
+
+func (p *fileUIPreferences) load(admin string) (map[string]json.RawMessage, error) {
+	result := make(map[string]json.RawMessage)
+	data, err := ioutil.ReadFile(p.getFilePath(admin))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func (p *fileUIPreferences) save(admin string, preferences map[string]json.RawMessage) error {
+	filePath := p.getFilePath(admin)
+	if len(preferences) == 0 {
+		err := os.Remove(filePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(p.storagePath, 0700)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it, so a failed write does not
+	// corrupt the existing preferences
+	tmpPath := filePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+func (p *fileUIPreferences) GetAll(admin string) (map[string]json.RawMessage, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.load(admin)
+}
+
+func (p *fileUIPreferences) Set(admin, key string, value json.RawMessage) error {
+	p.Lock()
+	defer p.Unlock()
+
+	preferences, err := p.load(admin)
+	if err != nil {
+		return err
+	}
+	preferences[key] = value
+	return p.save(admin, preferences)
+}
+
+func (p *fileUIPreferences) Delete(admin, key string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	preferences, err := p.load(admin)
+	if err != nil {
+		return err
+	}
+	if _, ok := preferences[key]; !ok {
+		return nil
+	}
+	delete(preferences, key)
+	return p.save(admin, preferences)
+}
@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
@@ -25,10 +27,22 @@ const (
 	templateUsers          = "users.html"
 	templateUser           = "user.html"
 	templateConnections    = "connections.html"
+	templateIPList         = "iplist.html"
+	templateIPListEntry    = "iplistentry.html"
+	templatePlans          = "plans.html"
+	templatePlan           = "plan.html"
+	templateFolders        = "folders.html"
+	templateFolder         = "folder.html"
+	templateJobs           = "jobs.html"
 	templateMessage        = "message.html"
 	pageUsersTitle         = "Users"
 	pageConnectionsTitle   = "Connections"
+	pageIPListTitle        = "IP Lists"
+	pagePlansTitle         = "Plans"
+	pageFoldersTitle       = "Virtual folders"
+	pageJobsTitle          = "Jobs"
 	page400Title           = "Bad request"
+	page403Title           = "Forbidden"
 	page404Title           = "Not found"
 	page404Body            = "The page you are looking for does not exist."
 	page500Title           = "Internal Server Error"
@@ -49,31 +63,118 @@ type basePage struct {
 	APIUserURL        string
 	APIConnectionsURL string
 	APIQuotaScanURL   string
+	APIUIPrefsURL     string
 	ConnectionsURL    string
+	IPListURL         string
+	IPListEntryURL    string
+	APIIPListURL      string
+	PlansURL          string
+	PlanURL           string
+	APIPlanURL        string
+	FoldersURL        string
+	FolderURL         string
+	JobsURL           string
 	UsersTitle        string
 	ConnectionsTitle  string
+	IPListTitle       string
+	PlansTitle        string
+	FoldersTitle      string
+	JobsTitle         string
 	Version           string
 }
 
 type usersPage struct {
 	basePage
 	Users []dataprovider.User
+	// key for the saved table state, see the UI preferences API
+	UIPreferenceKey string
 }
 
 type connectionsPage struct {
 	basePage
 	Connections []sftpd.ConnectionStatus
+	Filter      sftpd.ConnectionTagsFilter
 }
 
-type userPage struct {
+type ipListPage struct {
+	basePage
+	Entries []dataprovider.IPListEntry
+	Feeds   []dataprovider.IPListFeedStatus
+	// IP address to check and the related result, if any
+	CheckIP     string
+	CheckResult *dataprovider.IPListCheckResult
+	UnblockURL  string
+	Error       string
+}
+
+type ipListEntryPage struct {
+	basePage
+	IsAdd bool
+	Entry dataprovider.IPListEntry
+	Error string
+}
+
+type plansPage struct {
+	basePage
+	Plans []dataprovider.Plan
+	// last propagation for each plan, the plan name is the key
+	Propagations map[string]*dataprovider.PlanPropagation
+}
+
+type planPage struct {
 	basePage
 	IsAdd                bool
-	User                 dataprovider.User
-	RootPerms            []string
+	Plan                 dataprovider.Plan
 	Error                string
-	ValidPerms           []string
 	ValidSSHLoginMethods []string
-	RootDirPerms         []string
+	CSRFToken            string
+}
+
+// webVirtualFolder is a virtual folder and the user that defines it
+type webVirtualFolder struct {
+	vfs.VirtualFolder
+	Username string
+	UserID   int64
+}
+
+type foldersPage struct {
+	basePage
+	Folders   []webVirtualFolder
+	DeleteURL string
+	CSRFToken string
+	Error     string
+}
+
+type folderPage struct {
+	basePage
+	IsAdd  bool
+	Folder webVirtualFolder
+	// virtual path before the update, empty when adding a new folder
+	OriginalVirtualPath string
+	Error               string
+	CSRFToken           string
+}
+
+type jobsPage struct {
+	basePage
+	QuotaScans      []sftpd.ActiveQuotaScan
+	DuplicatesScans []DuplicatesScan
+	CSRFToken       string
+	Error           string
+}
+
+type userPage struct {
+	basePage
+	IsAdd                    bool
+	User                     dataprovider.User
+	RootPerms                []string
+	Error                    string
+	ValidPerms               []string
+	ValidSSHLoginMethods     []string
+	ValidPublicKeyAlgorithms []string
+	SupportedSSHCommands     []string
+	RootDirPerms             []string
+	Plans                    []dataprovider.Plan
 }
 
 type messagePage struct {
@@ -95,18 +196,60 @@ func loadTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateBase),
 		filepath.Join(templatesPath, templateConnections),
 	}
+	ipListPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateIPList),
+	}
+	ipListEntryPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateIPListEntry),
+	}
+	plansPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templatePlans),
+	}
+	planPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templatePlan),
+	}
+	foldersPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateFolders),
+	}
+	folderPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateFolder),
+	}
+	jobsPaths := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateJobs),
+	}
 	messagePath := []string{
 		filepath.Join(templatesPath, templateBase),
 		filepath.Join(templatesPath, templateMessage),
 	}
-	usersTmpl := utils.LoadTemplate(template.ParseFiles(usersPaths...))
-	userTmpl := utils.LoadTemplate(template.ParseFiles(userPaths...))
-	connectionsTmpl := utils.LoadTemplate(template.ParseFiles(connectionsPaths...))
-	messageTmpl := utils.LoadTemplate(template.ParseFiles(messagePath...))
+	usersTmpl := utils.LoadTemplate(parseTemplateFiles(usersPaths...))
+	userTmpl := utils.LoadTemplate(parseTemplateFiles(userPaths...))
+	connectionsTmpl := utils.LoadTemplate(parseTemplateFiles(connectionsPaths...))
+	ipListTmpl := utils.LoadTemplate(parseTemplateFiles(ipListPaths...))
+	ipListEntryTmpl := utils.LoadTemplate(parseTemplateFiles(ipListEntryPaths...))
+	plansTmpl := utils.LoadTemplate(parseTemplateFiles(plansPaths...))
+	planTmpl := utils.LoadTemplate(parseTemplateFiles(planPaths...))
+	foldersTmpl := utils.LoadTemplate(parseTemplateFiles(foldersPaths...))
+	folderTmpl := utils.LoadTemplate(parseTemplateFiles(folderPaths...))
+	jobsTmpl := utils.LoadTemplate(parseTemplateFiles(jobsPaths...))
+	messageTmpl := utils.LoadTemplate(parseTemplateFiles(messagePath...))
 
 	templates[templateUsers] = usersTmpl
 	templates[templateUser] = userTmpl
 	templates[templateConnections] = connectionsTmpl
+	templates[templateIPList] = ipListTmpl
+	templates[templateIPListEntry] = ipListEntryTmpl
+	templates[templatePlans] = plansTmpl
+	templates[templatePlan] = planTmpl
+	templates[templateFolders] = foldersTmpl
+	templates[templateFolder] = folderTmpl
+	templates[templateJobs] = jobsTmpl
 	templates[templateMessage] = messageTmpl
 }
 
@@ -120,9 +263,23 @@ func getBasePageData(title, currentURL string) basePage {
 		APIUserURL:        userPath,
 		APIConnectionsURL: activeConnectionsPath,
 		APIQuotaScanURL:   quotaScanPath,
+		APIUIPrefsURL:     uiPreferencesPath,
 		ConnectionsURL:    webConnectionsPath,
+		IPListURL:         webIPListPath,
+		IPListEntryURL:    webIPListEntryPath,
+		APIIPListURL:      ipListPath,
+		PlansURL:          webPlansPath,
+		PlanURL:           webPlanPath,
+		APIPlanURL:        planPath,
+		FoldersURL:        webFoldersPath,
+		FolderURL:         webFolderPath,
+		JobsURL:           webJobsPath,
 		UsersTitle:        pageUsersTitle,
 		ConnectionsTitle:  pageConnectionsTitle,
+		IPListTitle:       pageIPListTitle,
+		PlansTitle:        pagePlansTitle,
+		FoldersTitle:      pageFoldersTitle,
+		JobsTitle:         pageJobsTitle,
 		Version:           version.GetVersionAsString(),
 	}
 }
@@ -163,32 +320,71 @@ func renderNotFoundPage(w http.ResponseWriter, err error) {
 	renderMessagePage(w, page404Title, page404Body, http.StatusNotFound, err, "")
 }
 
+func renderForbiddenPage(w http.ResponseWriter, err error) {
+	renderMessagePage(w, page403Title, "", http.StatusForbidden, err, "")
+}
+
 func renderAddUserPage(w http.ResponseWriter, user dataprovider.User, error string) {
 	data := userPage{
-		basePage:             getBasePageData("Add a new user", webUserPath),
-		IsAdd:                true,
-		Error:                error,
-		User:                 user,
-		ValidPerms:           dataprovider.ValidPerms,
-		ValidSSHLoginMethods: dataprovider.ValidSSHLoginMethods,
-		RootDirPerms:         user.GetPermissionsForPath("/"),
+		basePage:                 getBasePageData("Add a new user", webUserPath),
+		IsAdd:                    true,
+		Error:                    error,
+		User:                     user,
+		ValidPerms:               dataprovider.ValidPerms,
+		ValidSSHLoginMethods:     dataprovider.ValidSSHLoginMethods,
+		ValidPublicKeyAlgorithms: dataprovider.ValidPublicKeyAlgorithms,
+		SupportedSSHCommands:     sftpd.GetSupportedSSHCommands(),
+		RootDirPerms:             user.GetPermissionsForPath("/"),
+		Plans:                    getWebPlans(),
 	}
 	renderTemplate(w, templateUser, data)
 }
 
 func renderUpdateUserPage(w http.ResponseWriter, user dataprovider.User, error string) {
 	data := userPage{
-		basePage:             getBasePageData("Update user", fmt.Sprintf("%v/%v", webUserPath, user.ID)),
-		IsAdd:                false,
-		Error:                error,
-		User:                 user,
-		ValidPerms:           dataprovider.ValidPerms,
-		ValidSSHLoginMethods: dataprovider.ValidSSHLoginMethods,
-		RootDirPerms:         user.GetPermissionsForPath("/"),
+		basePage:                 getBasePageData("Update user", fmt.Sprintf("%v/%v", webUserPath, user.ID)),
+		IsAdd:                    false,
+		Error:                    error,
+		User:                     user,
+		ValidPerms:               dataprovider.ValidPerms,
+		ValidSSHLoginMethods:     dataprovider.ValidSSHLoginMethods,
+		ValidPublicKeyAlgorithms: dataprovider.ValidPublicKeyAlgorithms,
+		SupportedSSHCommands:     sftpd.GetSupportedSSHCommands(),
+		RootDirPerms:             user.GetPermissionsForPath("/"),
+		Plans:                    getWebPlans(),
 	}
 	renderTemplate(w, templateUser, data)
 }
 
+// getWebPlans returns the defined plans, the user page is rendered anyway if they cannot be loaded
+func getWebPlans() []dataprovider.Plan {
+	plans, err := dataprovider.GetPlans(dataProvider)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get plans for the user page: %v", err)
+	}
+	return plans
+}
+
+func renderAddIPListEntryPage(w http.ResponseWriter, entry dataprovider.IPListEntry, error string) {
+	data := ipListEntryPage{
+		basePage: getBasePageData("Add a new IP list entry", webIPListEntryPath),
+		IsAdd:    true,
+		Error:    error,
+		Entry:    entry,
+	}
+	renderTemplate(w, templateIPListEntry, data)
+}
+
+func renderUpdateIPListEntryPage(w http.ResponseWriter, entry dataprovider.IPListEntry, error string) {
+	data := ipListEntryPage{
+		basePage: getBasePageData("Update IP list entry", fmt.Sprintf("%v/%v", webIPListEntryPath, entry.ID)),
+		IsAdd:    false,
+		Error:    error,
+		Entry:    entry,
+	}
+	renderTemplate(w, templateIPListEntry, data)
+}
+
 func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 	var virtualFolders []vfs.VirtualFolder
 	formValue := r.Form.Get("virtual_folders")
@@ -206,6 +402,26 @@ func getVirtualFoldersFromPostFields(r *http.Request) []vfs.VirtualFolder {
 	return virtualFolders
 }
 
+// restoreVirtualFoldersFilesystems restores the current filesystem for the posted virtual folders
+// without a mapped path. The filesystem backends cannot be configured using the web admin.
+// The shared folder references are restored too, if the posted mapped path is not changed
+func restoreVirtualFoldersFilesystems(folders []vfs.VirtualFolder, currentFolders []vfs.VirtualFolder) {
+	for idx := range folders {
+		folder := &folders[idx]
+		for _, current := range currentFolders {
+			if current.VirtualPath != folder.VirtualPath {
+				continue
+			}
+			if len(folder.MappedPath) == 0 && current.HasFilesystem() {
+				folder.Filesystem = current.Filesystem
+			}
+			if current.IsShared() && folder.MappedPath == current.MappedPath {
+				folder.Name = current.Name
+			}
+		}
+	}
+}
+
 func getUserPermissionsFromPostFields(r *http.Request) map[string][]string {
 	permissions := make(map[string][]string)
 	permissions["/"] = r.Form["permissions"]
@@ -277,11 +493,49 @@ func getFileExtensionsFromPostField(value string, extesionsType int) []dataprovi
 	return result
 }
 
-func getFiltersFromUserPostFields(r *http.Request) dataprovider.UserFilters {
+func getStorageClassRulesFromPostField(value string) ([]vfs.StorageClassRule, error) {
+	var result []vfs.StorageClassRule
+	for _, cleaned := range getSliceFromDelimitedValues(value, "\n") {
+		fields := strings.Split(cleaned, "::")
+		if len(fields) != 4 {
+			return result, fmt.Errorf("invalid storage class rule %#v", cleaned)
+		}
+		rule := vfs.StorageClassRule{
+			StorageClass: strings.TrimSpace(fields[0]),
+			Path:         strings.TrimSpace(fields[1]),
+			Extensions:   getSliceFromDelimitedValues(fields[2], ","),
+		}
+		if minSize := strings.TrimSpace(fields[3]); len(minSize) > 0 {
+			size, err := strconv.ParseInt(minSize, 10, 64)
+			if err != nil {
+				return result, err
+			}
+			rule.MinSize = size
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func getFiltersFromUserPostFields(r *http.Request) (dataprovider.UserFilters, error) {
 	var filters dataprovider.UserFilters
 	filters.AllowedIP = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
 	filters.DeniedIP = getSliceFromDelimitedValues(r.Form.Get("denied_ip"), ",")
 	filters.DeniedLoginMethods = r.Form["ssh_login_methods"]
+	filters.RevokedKeyFingerprints = getSliceFromDelimitedValues(r.Form.Get("revoked_key_fingerprints"), "\n")
+	filters.AllowedKeyAlgorithms = r.Form["allowed_key_algorithms"]
+	filters.AllowedSSHCommands = r.Form["allowed_ssh_commands"]
+	filters.Tenant = strings.TrimSpace(r.Form.Get("tenant"))
+	filters.ReadOnly = len(r.Form.Get("read_only")) > 0
+	filters.FirstLogin.AcceptTerms = len(r.Form.Get("first_login_accept_terms")) > 0
+	filters.FirstLogin.ChangePassword = len(r.Form.Get("first_login_change_password")) > 0
+	if minRSAKeySize := strings.TrimSpace(r.Form.Get("min_rsa_key_size")); len(minRSAKeySize) > 0 {
+		size, err := strconv.Atoi(minRSAKeySize)
+		if err != nil {
+			return filters, err
+		}
+		filters.MinRSAKeySize = size
+	}
 	allowedExtensions := getFileExtensionsFromPostField(r.Form.Get("allowed_extensions"), 1)
 	deniedExtensions := getFileExtensionsFromPostField(r.Form.Get("denied_extensions"), 2)
 	extensions := []dataprovider.ExtensionsFilter{}
@@ -312,7 +566,28 @@ func getFiltersFromUserPostFields(r *http.Request) dataprovider.UserFilters {
 		extensions = append(extensions, deniedExtensions...)
 	}
 	filters.FileExtensions = extensions
-	return filters
+	ingestionFolders, err := getIngestionFoldersFromPostField(r.Form.Get("ingestion_folders"))
+	filters.IngestionFolders = ingestionFolders
+	return filters, err
+}
+
+func getIngestionFoldersFromPostField(value string) ([]dataprovider.IngestionFolder, error) {
+	var result []dataprovider.IngestionFolder
+	for _, cleaned := range getSliceFromDelimitedValues(value, "\n") {
+		folder := dataprovider.IngestionFolder{
+			Path: cleaned,
+		}
+		if strings.Contains(cleaned, "::") {
+			values := strings.Split(cleaned, "::")
+			if len(values) != 2 || strings.TrimSpace(values[1]) != "rollup" {
+				return result, fmt.Errorf("invalid ingestion folder %#v", cleaned)
+			}
+			folder.Path = strings.TrimSpace(values[0])
+			folder.HourlyRollup = true
+		}
+		result = append(result, folder)
+	}
+	return result, nil
 }
 
 func getFsConfigFromUserPostFields(r *http.Request) (dataprovider.Filesystem, error) {
@@ -326,8 +601,17 @@ func getFsConfigFromUserPostFields(r *http.Request) (dataprovider.Filesystem, er
 		fs.S3Config.Bucket = r.Form.Get("s3_bucket")
 		fs.S3Config.Region = r.Form.Get("s3_region")
 		fs.S3Config.AccessKey = r.Form.Get("s3_access_key")
-		fs.S3Config.AccessSecret = r.Form.Get("s3_access_secret")
+		// without an access key the default AWS credential chain is used
+		if len(fs.S3Config.AccessKey) > 0 {
+			fs.S3Config.AccessSecret = r.Form.Get("s3_access_secret")
+			fs.S3Config.SessionToken = r.Form.Get("s3_session_token")
+		}
+		fs.S3Config.RoleARN = r.Form.Get("s3_role_arn")
+		fs.S3Config.ExternalID = r.Form.Get("s3_external_id")
 		fs.S3Config.Endpoint = r.Form.Get("s3_endpoint")
+		fs.S3Config.ForcePathStyle = len(r.Form.Get("s3_force_path_style")) > 0
+		fs.S3Config.SkipTLSVerify = len(r.Form.Get("s3_skip_tls_verify")) > 0
+		fs.S3Config.CABundle = r.Form.Get("s3_ca_bundle")
 		fs.S3Config.StorageClass = r.Form.Get("s3_storage_class")
 		fs.S3Config.KeyPrefix = r.Form.Get("s3_key_prefix")
 		fs.S3Config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("s3_upload_part_size"), 10, 64)
@@ -338,10 +622,66 @@ func getFsConfigFromUserPostFields(r *http.Request) (dataprovider.Filesystem, er
 		if err != nil {
 			return fs, err
 		}
+		fs.S3Config.DownloadPartSize, err = strconv.ParseInt(r.Form.Get("s3_download_part_size"), 10, 64)
+		if err != nil {
+			return fs, err
+		}
+		fs.S3Config.DownloadConcurrency, err = strconv.Atoi(r.Form.Get("s3_download_concurrency"))
+		if err != nil {
+			return fs, err
+		}
+		fs.S3Config.StorageClassRules, err = getStorageClassRulesFromPostField(r.Form.Get("s3_storage_class_rules"))
+		if err != nil {
+			return fs, err
+		}
+	} else if fs.Provider == 3 {
+		fs.CryptConfig.Passphrase = r.Form.Get("crypt_passphrase")
+	} else if fs.Provider == 4 {
+		fs.WebDAVConfig.Endpoint = r.Form.Get("webdav_endpoint")
+		fs.WebDAVConfig.Username = r.Form.Get("webdav_username")
+		fs.WebDAVConfig.Password = r.Form.Get("webdav_password")
+		fs.WebDAVConfig.BearerToken = r.Form.Get("webdav_bearer_token")
+		fs.WebDAVConfig.RootPath = r.Form.Get("webdav_root_path")
+	} else if fs.Provider == 5 {
+		fs.HDFSConfig.Endpoint = r.Form.Get("hdfs_endpoint")
+		fs.HDFSConfig.Username = r.Form.Get("hdfs_username")
+		fs.HDFSConfig.DelegationToken = r.Form.Get("hdfs_delegation_token")
+		fs.HDFSConfig.RootPath = r.Form.Get("hdfs_root_path")
+	} else if fs.Provider == 6 {
+		fs.GoogleDriveConfig.FolderID = r.Form.Get("gdrive_folder_id")
+		fs.GoogleDriveConfig.Credentials = r.Form.Get("gdrive_credentials")
+		fs.GoogleDriveConfig.Subject = r.Form.Get("gdrive_subject")
+		fs.GoogleDriveConfig.ClientID = r.Form.Get("gdrive_client_id")
+		fs.GoogleDriveConfig.ClientSecret = r.Form.Get("gdrive_client_secret")
+		fs.GoogleDriveConfig.RefreshToken = r.Form.Get("gdrive_refresh_token")
+		fs.GoogleDriveConfig.Endpoint = r.Form.Get("gdrive_endpoint")
+	} else if fs.Provider == 7 {
+		fs.DropboxConfig.RootPath = r.Form.Get("dropbox_root_path")
+		fs.DropboxConfig.AccessToken = r.Form.Get("dropbox_access_token")
+		fs.DropboxConfig.RefreshToken = r.Form.Get("dropbox_refresh_token")
+		fs.DropboxConfig.AppKey = r.Form.Get("dropbox_app_key")
+		fs.DropboxConfig.AppSecret = r.Form.Get("dropbox_app_secret")
+		fs.DropboxConfig.Endpoint = r.Form.Get("dropbox_endpoint")
+		fs.DropboxConfig.UploadChunkSize, err = strconv.ParseInt(r.Form.Get("dropbox_upload_chunk_size"), 10, 64)
+		if err != nil {
+			return fs, err
+		}
 	} else if fs.Provider == 2 {
 		fs.GCSConfig.Bucket = r.Form.Get("gcs_bucket")
 		fs.GCSConfig.StorageClass = r.Form.Get("gcs_storage_class")
 		fs.GCSConfig.KeyPrefix = r.Form.Get("gcs_key_prefix")
+		fs.GCSConfig.StorageClassRules, err = getStorageClassRulesFromPostField(r.Form.Get("gcs_storage_class_rules"))
+		if err != nil {
+			return fs, err
+		}
+		fs.GCSConfig.DownloadPartSize, err = strconv.ParseInt(r.Form.Get("gcs_download_part_size"), 10, 64)
+		if err != nil {
+			return fs, err
+		}
+		fs.GCSConfig.DownloadConcurrency, err = strconv.Atoi(r.Form.Get("gcs_download_concurrency"))
+		if err != nil {
+			return fs, err
+		}
 		autoCredentials := r.Form.Get("gcs_auto_credentials")
 		if len(autoCredentials) > 0 {
 			fs.GCSConfig.AutomaticCredentials = 1
@@ -371,7 +711,7 @@ func getFsConfigFromUserPostFields(r *http.Request) (dataprovider.Filesystem, er
 
 func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 	var user dataprovider.User
-	err := r.ParseMultipartForm(maxRequestSize)
+	err := r.ParseMultipartForm(webUserFormBodyLimit.size)
 	if err != nil {
 		return user, err
 	}
@@ -418,10 +758,22 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 		}
 		expirationDateMillis = utils.GetTimeAsMsSinceEpoch(expirationDate)
 	}
+	// the version is the one of the user loaded in the update page, empty for new users
+	version := int64(0)
+	if versionString := r.Form.Get("version"); len(versionString) > 0 {
+		version, err = strconv.ParseInt(versionString, 10, 64)
+		if err != nil {
+			return user, err
+		}
+	}
 	fsConfig, err := getFsConfigFromUserPostFields(r)
 	if err != nil {
 		return user, err
 	}
+	filters, err := getFiltersFromUserPostFields(r)
+	if err != nil {
+		return user, err
+	}
 	user = dataprovider.User{
 		Username:          r.Form.Get("username"),
 		Password:          r.Form.Get("password"),
@@ -438,13 +790,50 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 		DownloadBandwidth: bandwidthDL,
 		Status:            status,
 		ExpirationDate:    expirationDateMillis,
-		Filters:           getFiltersFromUserPostFields(r),
+		Filters:           filters,
 		FsConfig:          fsConfig,
+		Plan:              r.Form.Get("plan"),
+		AdditionalInfo:    r.Form.Get("additional_info"),
+		Version:           version,
 	}
 	return user, err
 }
 
+func getIPListEntryFromPostFields(r *http.Request) (dataprovider.IPListEntry, error) {
+	var entry dataprovider.IPListEntry
+	err := r.ParseForm()
+	if err != nil {
+		return entry, err
+	}
+	listType, err := strconv.Atoi(r.Form.Get("type"))
+	if err != nil {
+		return entry, err
+	}
+	entry = dataprovider.IPListEntry{
+		IPOrNet:     r.Form.Get("ipornet"),
+		Type:        listType,
+		Description: r.Form.Get("description"),
+	}
+	return entry, nil
+}
+
 func handleGetWebUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := getWebUsers(r)
+	if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	data := usersPage{
+		basePage:        getBasePageData(pageUsersTitle, webUsersPath),
+		Users:           users,
+		UIPreferenceKey: uiPreferenceUsersTable,
+	}
+	renderTemplate(w, templateUsers, data)
+}
+
+// getWebUsers returns all the users. They are loaded in pages, the page size can be
+// configured using the "qlimit" query parameter
+func getWebUsers(r *http.Request) ([]dataprovider.User, error) {
 	limit := defaultUsersQueryLimit
 	if _, ok := r.URL.Query()["qlimit"]; ok {
 		var err error
@@ -454,25 +843,18 @@ func handleGetWebUsers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	var users []dataprovider.User
-	u, err := dataprovider.GetUsers(dataProvider, limit, 0, "ASC", "")
+	scope := getAdminScope(r.Context())
+	u, err := dataprovider.GetUsersInScope(dataProvider, scope, limit, 0, "ASC", "")
 	users = append(users, u...)
 	for len(u) == limit {
-		u, err = dataprovider.GetUsers(dataProvider, limit, len(users), "ASC", "")
+		u, err = dataprovider.GetUsersInScope(dataProvider, scope, limit, len(users), "ASC", "")
 		if err == nil && len(u) > 0 {
 			users = append(users, u...)
 		} else {
 			break
 		}
 	}
-	if err != nil {
-		renderInternalServerErrorPage(w, err)
-		return
-	}
-	data := usersPage{
-		basePage: getBasePageData(pageUsersTitle, webUsersPath),
-		Users:    users,
-	}
-	renderTemplate(w, templateUsers, data)
+	return users, err
 }
 
 func handleWebAddUserGet(w http.ResponseWriter, r *http.Request) {
@@ -485,7 +867,7 @@ func handleWebUpdateUserGet(w http.ResponseWriter, r *http.Request) {
 		renderBadRequestPage(w, err)
 		return
 	}
-	user, err := dataprovider.GetUserByID(dataProvider, id)
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), id)
 	if err == nil {
 		renderUpdateUserPage(w, user, "")
 	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
@@ -496,13 +878,13 @@ func handleWebUpdateUserGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleWebAddUserPost(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limitRequestBody(w, r, webUserFormBodyLimit)
 	user, err := getUserFromPostFields(r)
 	if err != nil {
 		renderAddUserPage(w, user, err.Error())
 		return
 	}
-	err = dataprovider.AddUser(dataProvider, user)
+	err = dataprovider.AddUserInScope(dataProvider, getAdminScope(r.Context()), user)
 	if err == nil {
 		http.Redirect(w, r, webUsersPath, http.StatusSeeOther)
 	} else {
@@ -511,13 +893,13 @@ func handleWebAddUserPost(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleWebUpdateUserPost(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	limitRequestBody(w, r, webUserFormBodyLimit)
 	id, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
 		renderBadRequestPage(w, err)
 		return
 	}
-	user, err := dataprovider.GetUserByID(dataProvider, id)
+	user, err := dataprovider.GetUserByIDInScope(dataProvider, getAdminScope(r.Context()), id)
 	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 		renderNotFoundPage(w, err)
 		return
@@ -534,7 +916,27 @@ func handleWebUpdateUserPost(w http.ResponseWriter, r *http.Request) {
 	if len(updatedUser.Password) == 0 {
 		updatedUser.Password = user.Password
 	}
-	err = dataprovider.UpdateUser(dataProvider, updatedUser)
+	// the first login actions already completed are not required again
+	updatedUser.Filters.FirstLogin.TermsAcceptedAt = user.Filters.FirstLogin.TermsAcceptedAt
+	updatedUser.Filters.FirstLogin.PasswordChangedAt = user.Filters.FirstLogin.PasswordChangedAt
+	if updatedUser.FsConfig.Provider == 3 && user.FsConfig.Provider == 3 &&
+		len(updatedUser.FsConfig.CryptConfig.Passphrase) == 0 {
+		updatedUser.FsConfig.CryptConfig.Passphrase = user.FsConfig.CryptConfig.Passphrase
+	}
+	if updatedUser.FsConfig.Provider == 4 && user.FsConfig.Provider == 4 {
+		restoreWebDAVSecrets(&updatedUser.FsConfig.WebDAVConfig, user.FsConfig.WebDAVConfig)
+	}
+	if updatedUser.FsConfig.Provider == 5 && user.FsConfig.Provider == 5 {
+		restoreHDFSSecrets(&updatedUser.FsConfig.HDFSConfig, user.FsConfig.HDFSConfig)
+	}
+	if updatedUser.FsConfig.Provider == 6 && user.FsConfig.Provider == 6 {
+		restoreGoogleDriveSecrets(&updatedUser.FsConfig.GoogleDriveConfig, user.FsConfig.GoogleDriveConfig)
+	}
+	if updatedUser.FsConfig.Provider == 7 && user.FsConfig.Provider == 7 {
+		restoreDropboxSecrets(&updatedUser.FsConfig.DropboxConfig, user.FsConfig.DropboxConfig)
+	}
+	restoreVirtualFoldersFilesystems(updatedUser.VirtualFolders, user.VirtualFolders)
+	err = dataprovider.UpdateUserInScope(dataProvider, getAdminScope(r.Context()), updatedUser)
 	if err == nil {
 		http.Redirect(w, r, webUsersPath, http.StatusSeeOther)
 	} else {
@@ -543,10 +945,547 @@ func handleWebUpdateUserPost(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleWebGetConnections(w http.ResponseWriter, r *http.Request) {
-	connectionStats := sftpd.GetConnectionsStats()
+	filter := getConnectionTagsFilter(r)
 	data := connectionsPage{
 		basePage:    getBasePageData(pageConnectionsTitle, webConnectionsPath),
-		Connections: connectionStats,
+		Connections: filterConnectionsByScope(sftpd.GetFilteredConnectionsStats(filter), getAdminScope(r.Context())),
+		Filter:      filter,
 	}
 	renderTemplate(w, templateConnections, data)
 }
+
+func renderIPListPage(w http.ResponseWriter, checkIP string, result *dataprovider.IPListCheckResult, error string) {
+	entries, err := dataprovider.GetIPListEntries(dataProvider, 0)
+	if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	data := ipListPage{
+		basePage:    getBasePageData(pageIPListTitle, webIPListPath),
+		Entries:     entries,
+		Feeds:       dataprovider.GetIPListFeedsStatus(),
+		CheckIP:     checkIP,
+		CheckResult: result,
+		UnblockURL:  webIPListUnblockPath,
+		Error:       error,
+	}
+	renderTemplate(w, templateIPList, data)
+}
+
+func handleGetWebIPList(w http.ResponseWriter, r *http.Request) {
+	checkIP := strings.TrimSpace(r.URL.Query().Get("ip"))
+	if len(checkIP) == 0 {
+		renderIPListPage(w, "", nil, "")
+		return
+	}
+	result, err := dataprovider.CheckIPAddress(dataProvider, checkIP)
+	if err != nil {
+		if _, ok := err.(*dataprovider.ValidationError); ok {
+			renderIPListPage(w, checkIP, nil, err.Error())
+		} else {
+			renderInternalServerErrorPage(w, err)
+		}
+		return
+	}
+	renderIPListPage(w, checkIP, &result, "")
+}
+
+func handleWebUnblockIPPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	err := r.ParseForm()
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	checkIP := strings.TrimSpace(r.Form.Get("ip"))
+	result, err := dataprovider.UnblockIPAddress(dataProvider, checkIP, "unblocked using the web admin")
+	if err != nil {
+		if _, ok := err.(*dataprovider.ValidationError); ok {
+			renderIPListPage(w, checkIP, nil, err.Error())
+		} else {
+			renderInternalServerErrorPage(w, err)
+		}
+		return
+	}
+	http.Redirect(w, r, webIPListPath+"?ip="+url.QueryEscape(result.IP), http.StatusSeeOther)
+}
+
+func handleWebAddIPListEntryGet(w http.ResponseWriter, r *http.Request) {
+	entry := dataprovider.IPListEntry{
+		IPOrNet: r.URL.Query().Get("ipornet"),
+		Type:    dataprovider.IPListTypeBlock,
+	}
+	if listType, err := strconv.Atoi(r.URL.Query().Get("type")); err == nil && listType == dataprovider.IPListTypeSafe {
+		entry.Type = listType
+	}
+	renderAddIPListEntryPage(w, entry, "")
+}
+
+func handleWebUpdateIPListEntryGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "entryID"), 10, 64)
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	entry, err := dataprovider.GetIPListEntryByID(dataProvider, id)
+	if err == nil {
+		renderUpdateIPListEntryPage(w, entry, "")
+	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, err)
+	} else {
+		renderInternalServerErrorPage(w, err)
+	}
+}
+
+func handleWebAddIPListEntryPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	entry, err := getIPListEntryFromPostFields(r)
+	if err != nil {
+		renderAddIPListEntryPage(w, entry, err.Error())
+		return
+	}
+	err = dataprovider.AddIPListEntry(dataProvider, entry)
+	if err == nil {
+		http.Redirect(w, r, webIPListPath, http.StatusSeeOther)
+	} else {
+		renderAddIPListEntryPage(w, entry, err.Error())
+	}
+}
+
+func handleWebUpdateIPListEntryPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	id, err := strconv.ParseInt(chi.URLParam(r, "entryID"), 10, 64)
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	entry, err := dataprovider.GetIPListEntryByID(dataProvider, id)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	updatedEntry, err := getIPListEntryFromPostFields(r)
+	if err != nil {
+		renderUpdateIPListEntryPage(w, entry, err.Error())
+		return
+	}
+	updatedEntry.ID = entry.ID
+	err = dataprovider.UpdateIPListEntry(dataProvider, updatedEntry)
+	if err == nil {
+		http.Redirect(w, r, webIPListPath, http.StatusSeeOther)
+	} else {
+		renderUpdateIPListEntryPage(w, entry, err.Error())
+	}
+}
+
+func renderAddPlanPage(w http.ResponseWriter, plan dataprovider.Plan, error string) {
+	data := planPage{
+		basePage:             getBasePageData("Add a new plan", webPlanPath),
+		IsAdd:                true,
+		Error:                error,
+		Plan:                 plan,
+		ValidSSHLoginMethods: dataprovider.ValidSSHLoginMethods,
+		CSRFToken:            createCSRFToken(),
+	}
+	renderTemplate(w, templatePlan, data)
+}
+
+func renderUpdatePlanPage(w http.ResponseWriter, plan dataprovider.Plan, error string) {
+	data := planPage{
+		basePage:             getBasePageData("Update plan", fmt.Sprintf("%v/%v", webPlanPath, plan.ID)),
+		IsAdd:                false,
+		Error:                error,
+		Plan:                 plan,
+		ValidSSHLoginMethods: dataprovider.ValidSSHLoginMethods,
+		CSRFToken:            createCSRFToken(),
+	}
+	renderTemplate(w, templatePlan, data)
+}
+
+func getPlanFromPostFields(r *http.Request) (dataprovider.Plan, error) {
+	var plan dataprovider.Plan
+	err := r.ParseForm()
+	if err != nil {
+		return plan, err
+	}
+	maxSessions, err := strconv.Atoi(r.Form.Get("max_sessions"))
+	if err != nil {
+		return plan, err
+	}
+	quotaSize, err := strconv.ParseInt(r.Form.Get("quota_size"), 10, 64)
+	if err != nil {
+		return plan, err
+	}
+	quotaFiles, err := strconv.Atoi(r.Form.Get("quota_files"))
+	if err != nil {
+		return plan, err
+	}
+	bandwidthUL, err := strconv.ParseInt(r.Form.Get("upload_bandwidth"), 10, 64)
+	if err != nil {
+		return plan, err
+	}
+	bandwidthDL, err := strconv.ParseInt(r.Form.Get("download_bandwidth"), 10, 64)
+	if err != nil {
+		return plan, err
+	}
+	passwordBreachCheck, err := strconv.Atoi(r.Form.Get("password_breach_check"))
+	if err != nil {
+		return plan, err
+	}
+	var fsProviders []int
+	for _, value := range r.Form["fs_providers"] {
+		fsProvider, err := strconv.Atoi(value)
+		if err != nil {
+			return plan, err
+		}
+		fsProviders = append(fsProviders, fsProvider)
+	}
+	plan = dataprovider.Plan{
+		Name:                strings.TrimSpace(r.Form.Get("name")),
+		Description:         r.Form.Get("description"),
+		MaxSessions:         maxSessions,
+		QuotaSize:           quotaSize,
+		QuotaFiles:          quotaFiles,
+		UploadBandwidth:     bandwidthUL,
+		DownloadBandwidth:   bandwidthDL,
+		AllowedFsProviders:  fsProviders,
+		DeniedLoginMethods:  r.Form["ssh_login_methods"],
+		PasswordBreachCheck: passwordBreachCheck,
+	}
+	return plan, nil
+}
+
+func handleGetWebPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := dataprovider.GetPlans(dataProvider)
+	if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	propagations := make(map[string]*dataprovider.PlanPropagation)
+	for _, propagation := range dataprovider.GetPlanPropagations() {
+		p := propagation
+		propagations[propagation.PlanName] = &p
+	}
+	data := plansPage{
+		basePage:     getBasePageData(pagePlansTitle, webPlansPath),
+		Plans:        plans,
+		Propagations: propagations,
+	}
+	renderTemplate(w, templatePlans, data)
+}
+
+func handleWebAddPlanGet(w http.ResponseWriter, r *http.Request) {
+	renderAddPlanPage(w, dataprovider.Plan{}, "")
+}
+
+func handleWebUpdatePlanGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	plan, err := dataprovider.GetPlanByID(dataProvider, id)
+	if err == nil {
+		renderUpdatePlanPage(w, plan, "")
+	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, err)
+	} else {
+		renderInternalServerErrorPage(w, err)
+	}
+}
+
+func handleWebAddPlanPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	plan, err := getPlanFromPostFields(r)
+	if err != nil {
+		renderAddPlanPage(w, plan, err.Error())
+		return
+	}
+	if err = verifyFormCSRFToken(r); err != nil {
+		renderForbiddenPage(w, err)
+		return
+	}
+	err = dataprovider.AddPlan(dataProvider, plan)
+	if err == nil {
+		http.Redirect(w, r, webPlansPath, http.StatusSeeOther)
+	} else {
+		renderAddPlanPage(w, plan, err.Error())
+	}
+}
+
+func handleWebUpdatePlanPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	id, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	plan, err := dataprovider.GetPlanByID(dataProvider, id)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	updatedPlan, err := getPlanFromPostFields(r)
+	if err != nil {
+		renderUpdatePlanPage(w, plan, err.Error())
+		return
+	}
+	if err = verifyFormCSRFToken(r); err != nil {
+		renderForbiddenPage(w, err)
+		return
+	}
+	// the name is referenced by the users and cannot be changed
+	updatedPlan.ID = plan.ID
+	updatedPlan.Name = plan.Name
+	// the assigned users are updated in background, so the form does not wait for all of them
+	err = dataprovider.UpdatePlanAsync(dataProvider, updatedPlan)
+	if err == nil {
+		http.Redirect(w, r, webPlansPath, http.StatusSeeOther)
+	} else {
+		renderUpdatePlanPage(w, plan, err.Error())
+	}
+}
+
+func renderFoldersPage(w http.ResponseWriter, r *http.Request, error string) {
+	users, err := getWebUsers(r)
+	if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	var folders []webVirtualFolder
+	for _, user := range users {
+		for _, folder := range user.VirtualFolders {
+			folders = append(folders, webVirtualFolder{
+				VirtualFolder: folder,
+				Username:      user.Username,
+				UserID:        user.ID,
+			})
+		}
+	}
+	data := foldersPage{
+		basePage:  getBasePageData(pageFoldersTitle, webFoldersPath),
+		Folders:   folders,
+		DeleteURL: webFolderDeletePath,
+		CSRFToken: createCSRFToken(),
+		Error:     error,
+	}
+	renderTemplate(w, templateFolders, data)
+}
+
+func renderFolderPage(w http.ResponseWriter, folder webVirtualFolder, originalVirtualPath, error string) {
+	title := "Add a new virtual folder"
+	if len(originalVirtualPath) > 0 {
+		title = "Update virtual folder"
+	}
+	data := folderPage{
+		basePage:            getBasePageData(title, webFolderPath),
+		IsAdd:               len(originalVirtualPath) == 0,
+		Folder:              folder,
+		OriginalVirtualPath: originalVirtualPath,
+		Error:               error,
+		CSRFToken:           createCSRFToken(),
+	}
+	renderTemplate(w, templateFolder, data)
+}
+
+// getVirtualFolderIndex returns the index of the virtual folder with the given virtual path, -1 if not found
+func getVirtualFolderIndex(user dataprovider.User, virtualPath string) int {
+	for idx, folder := range user.VirtualFolders {
+		if folder.VirtualPath == virtualPath {
+			return idx
+		}
+	}
+	return -1
+}
+
+func handleGetWebFolders(w http.ResponseWriter, r *http.Request) {
+	renderFoldersPage(w, r, "")
+}
+
+// handleWebFolderGet renders the form to add a new virtual folder or, if the username and the
+// virtual path query parameters identify an existing one, the form to update it
+func handleWebFolderGet(w http.ResponseWriter, r *http.Request) {
+	folder := webVirtualFolder{
+		Username: r.URL.Query().Get("username"),
+	}
+	virtualPath := r.URL.Query().Get("virtual_path")
+	if len(virtualPath) == 0 {
+		renderFolderPage(w, folder, "", "")
+		return
+	}
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), folder.Username)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	idx := getVirtualFolderIndex(user, virtualPath)
+	if idx < 0 {
+		renderNotFoundPage(w, fmt.Errorf("virtual folder %#v not found for user %#v", virtualPath, user.Username))
+		return
+	}
+	folder.VirtualFolder = user.VirtualFolders[idx]
+	folder.UserID = user.ID
+	renderFolderPage(w, folder, virtualPath, "")
+}
+
+// handleWebFolderPost adds a virtual folder to a user or updates an existing one,
+// the folder to update is identified by the original virtual path
+func handleWebFolderPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	err := r.ParseForm()
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	if err = verifyFormCSRFToken(r); err != nil {
+		renderForbiddenPage(w, err)
+		return
+	}
+	folder := webVirtualFolder{
+		VirtualFolder: vfs.VirtualFolder{
+			VirtualPath: strings.TrimSpace(r.Form.Get("virtual_path")),
+			MappedPath:  strings.TrimSpace(r.Form.Get("mapped_path")),
+		},
+		Username: strings.TrimSpace(r.Form.Get("username")),
+	}
+	originalVirtualPath := r.Form.Get("original_virtual_path")
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), folder.Username)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderFolderPage(w, folder, originalVirtualPath, err.Error())
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	if len(originalVirtualPath) == 0 {
+		user.VirtualFolders = append(user.VirtualFolders, folder.VirtualFolder)
+	} else {
+		idx := getVirtualFolderIndex(user, originalVirtualPath)
+		if idx < 0 {
+			renderNotFoundPage(w, fmt.Errorf("virtual folder %#v not found for user %#v", originalVirtualPath, user.Username))
+			return
+		}
+		// the filesystem backend, if any, cannot be changed using the web admin
+		if user.VirtualFolders[idx].HasFilesystem() {
+			folder.MappedPath = ""
+			folder.Filesystem = user.VirtualFolders[idx].Filesystem
+		}
+		user.VirtualFolders[idx] = folder.VirtualFolder
+	}
+	err = dataprovider.UpdateUserInScope(dataProvider, getAdminScope(r.Context()), user)
+	if err == nil {
+		http.Redirect(w, r, webFoldersPath, http.StatusSeeOther)
+	} else {
+		renderFolderPage(w, folder, originalVirtualPath, err.Error())
+	}
+}
+
+func handleWebDeleteFolderPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	err := r.ParseForm()
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	if err = verifyFormCSRFToken(r); err != nil {
+		renderForbiddenPage(w, err)
+		return
+	}
+	user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), r.Form.Get("username"))
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, err)
+		return
+	}
+	virtualPath := r.Form.Get("virtual_path")
+	idx := getVirtualFolderIndex(user, virtualPath)
+	if idx < 0 {
+		renderNotFoundPage(w, fmt.Errorf("virtual folder %#v not found for user %#v", virtualPath, user.Username))
+		return
+	}
+	user.VirtualFolders = append(user.VirtualFolders[:idx], user.VirtualFolders[idx+1:]...)
+	err = dataprovider.UpdateUserInScope(dataProvider, getAdminScope(r.Context()), user)
+	if err != nil {
+		renderFoldersPage(w, r, err.Error())
+		return
+	}
+	http.Redirect(w, r, webFoldersPath, http.StatusSeeOther)
+}
+
+func renderJobsPage(w http.ResponseWriter, r *http.Request, error string) {
+	scope := getAdminScope(r.Context())
+	data := jobsPage{
+		basePage:        getBasePageData(pageJobsTitle, webJobsPath),
+		QuotaScans:      filterQuotaScansByScope(sftpd.GetQuotaScans(), scope),
+		DuplicatesScans: getDuplicatesScansStatus(scope),
+		CSRFToken:       createCSRFToken(),
+		Error:           error,
+	}
+	renderTemplate(w, templateJobs, data)
+}
+
+func handleGetWebJobs(w http.ResponseWriter, r *http.Request) {
+	renderJobsPage(w, r, "")
+}
+
+// handleWebJobsPost starts a quota scan or a duplicate files scan for a user or cancels
+// a duplicate files scan, the requested job is defined by the "action" form field
+func handleWebJobsPost(w http.ResponseWriter, r *http.Request) {
+	limitRequestBody(w, r, webFormBodyLimit)
+	err := r.ParseForm()
+	if err != nil {
+		renderBadRequestPage(w, err)
+		return
+	}
+	if err = verifyFormCSRFToken(r); err != nil {
+		renderForbiddenPage(w, err)
+		return
+	}
+	username := strings.TrimSpace(r.Form.Get("username"))
+	action := r.Form.Get("action")
+	switch action {
+	case "quota_scan", "duplicates_scan":
+		user, err := dataprovider.UserExistsInScope(dataProvider, getAdminScope(r.Context()), username)
+		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+			renderJobsPage(w, r, err.Error())
+			return
+		} else if err != nil {
+			renderInternalServerErrorPage(w, err)
+			return
+		}
+		if action == "quota_scan" {
+			if !sftpd.AddQuotaScan(user.Username) {
+				renderJobsPage(w, r, fmt.Sprintf("Another quota scan is already in progress for user %#v", user.Username))
+				return
+			}
+			go doQuotaScan(user)
+		} else if !startUserDuplicatesScan(user) {
+			renderJobsPage(w, r, fmt.Sprintf("Another duplicate files scan is already in progress for user %#v", user.Username))
+			return
+		}
+	case "cancel_duplicates_scan":
+		if !isUsernameInScope(username, getAdminScope(r.Context())) {
+			renderJobsPage(w, r, fmt.Sprintf("No duplicate files scan found for user %#v", username))
+			return
+		}
+		if found, _ := cancelUserDuplicatesScan(username); !found {
+			renderJobsPage(w, r, fmt.Sprintf("No duplicate files scan found for user %#v", username))
+			return
+		}
+	default:
+		renderBadRequestPage(w, fmt.Errorf("invalid action %#v", action))
+		return
+	}
+	http.Redirect(w, r, webJobsPath, http.StatusSeeOther)
+}
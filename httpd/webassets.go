@@ -0,0 +1,71 @@
+package httpd
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/drakkan/sftpgo/assets"
+)
+
+// parseTemplateFiles parses the given template files as template.ParseFiles does. If a file
+// does not exist, the embedded template with the same name is used, if any. This way the
+// embedded templates can be customized overriding only some of them
+func parseTemplateFiles(filenames ...string) (*template.Template, error) {
+	var t *template.Template
+	for _, filename := range filenames {
+		contents, err := readTemplateFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(filename)
+		var tmpl *template.Template
+		if t == nil {
+			t = template.New(name)
+		}
+		if name == t.Name() {
+			tmpl = t
+		} else {
+			tmpl = t.New(name)
+		}
+		if _, err = tmpl.Parse(string(contents)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func readTemplateFile(filename string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err == nil || !os.IsNotExist(err) || !assets.IsEmbedded() {
+		return contents, err
+	}
+	return assets.ReadFile(path.Join("templates", filepath.Base(filename)))
+}
+
+// getStaticFileSystem returns the file system for the static files. The files inside the
+// static files path take precedence over the embedded ones
+func getStaticFileSystem(staticFilesPath string) http.FileSystem {
+	if !assets.IsEmbedded() {
+		return http.Dir(staticFilesPath)
+	}
+	return overlayFileSystem{http.Dir(staticFilesPath), assets.FileSystem("static")}
+}
+
+// overlayFileSystem serves each file from the first file system containing it
+type overlayFileSystem []http.FileSystem
+
+func (o overlayFileSystem) Open(name string) (http.File, error) {
+	var err error
+	for _, fs := range o {
+		var f http.File
+		f, err = fs.Open(name)
+		if err == nil || !os.IsNotExist(err) {
+			return f, err
+		}
+	}
+	return nil, err
+}
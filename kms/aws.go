@@ -0,0 +1,83 @@
+package kms
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awskms "github.com/aws/aws-sdk-go/service/kms"
+)
+
+// AWSKMSConfig defines the configuration to wrap the master keys using AWS KMS
+type AWSKMSConfig struct {
+	// ID, ARN or alias of the KMS key, for example "alias/sftpgo"
+	KeyID string `json:"key_id" mapstructure:"key_id"`
+	// Region of the KMS key. Empty means the default region for the AWS SDK
+	Region string `json:"region" mapstructure:"region"`
+	// Credentials to use. Empty means the default AWS credential chain, for example the IAM role
+	// for EC2 instances or the environment variables
+	AccessKey    string `json:"access_key" mapstructure:"access_key"`
+	AccessSecret string `json:"access_secret" mapstructure:"access_secret"`
+	// Optional endpoint, for example a VPC endpoint
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+}
+
+type awsKMS struct {
+	keyID string
+	svc   *awskms.KMS
+}
+
+func newAWSKMS(c AWSKMSConfig) (*awsKMS, error) {
+	if len(c.KeyID) == 0 {
+		return nil, errors.New("the AWS KMS key ID is required")
+	}
+	awsConfig := aws.NewConfig()
+	if len(c.Region) > 0 {
+		awsConfig.WithRegion(c.Region)
+	}
+	if len(c.AccessKey) > 0 {
+		awsConfig.Credentials = credentials.NewStaticCredentials(c.AccessKey, c.AccessSecret, "")
+	}
+	if len(c.Endpoint) > 0 {
+		awsConfig.Endpoint = aws.String(c.Endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &awsKMS{
+		keyID: c.KeyID,
+		svc:   awskms.New(sess),
+	}, nil
+}
+
+func (w *awsKMS) getName() string {
+	return KeyProviderAWS
+}
+
+func (w *awsKMS) wrap(ctx context.Context, key []byte) ([]byte, error) {
+	out, err := w.svc.EncryptWithContext(ctx, &awskms.EncryptInput{
+		KeyId:     aws.String(w.keyID),
+		Plaintext: key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (w *awsKMS) unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := w.svc.DecryptWithContext(ctx, &awskms.DecryptInput{
+		KeyId:          aws.String(w.keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
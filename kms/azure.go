@@ -0,0 +1,224 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/drakkan/sftpgo/httpclient"
+)
+
+const (
+	azureKeyVaultAPIVersion     = "7.2"
+	azureWrapAlgorithm          = "RSA-OAEP-256"
+	azureDefaultAuthorityHost   = "https://login.microsoftonline.com"
+	azureManagedIdentityURL     = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureManagedIdentityVersion = "2018-02-01"
+)
+
+// AzureKeyVaultConfig defines the configuration to wrap the master keys using an RSA key
+// stored inside Azure Key Vault
+type AzureKeyVaultConfig struct {
+	// Vault URL, for example "https://my-vault.vault.azure.net"
+	VaultURL string `json:"vault_url" mapstructure:"vault_url"`
+	// Name of the RSA key
+	KeyName string `json:"key_name" mapstructure:"key_name"`
+	// Version of the key used to wrap new master keys. Empty means the current version.
+	// The master keys are always unwrapped with the key version used to wrap them
+	KeyVersion string `json:"key_version" mapstructure:"key_version"`
+	// Service principal credentials. If the client secret is empty the managed identity of the
+	// Azure resource is used. The client ID is optional for the managed identity, it is required
+	// for user assigned identities
+	TenantID     string `json:"tenant_id" mapstructure:"tenant_id"`
+	ClientID     string `json:"client_id" mapstructure:"client_id"`
+	ClientSecret string `json:"client_secret" mapstructure:"client_secret"`
+	// Azure Active Directory endpoint. Empty means the Azure public cloud
+	AuthorityHost string `json:"authority_host" mapstructure:"authority_host"`
+}
+
+type azureKeyVault struct {
+	config AzureKeyVaultConfig
+}
+
+// azureWrappedKey is the wrapped master key, the key ID includes the version used to wrap it
+type azureWrappedKey struct {
+	KeyID string `json:"kid"`
+	Value string `json:"value"`
+}
+
+type azureError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func newAzureKeyVault(c AzureKeyVaultConfig) (*azureKeyVault, error) {
+	if len(c.VaultURL) == 0 || len(c.KeyName) == 0 {
+		return nil, errors.New("the Azure Key Vault URL and key name are required")
+	}
+	if len(c.ClientSecret) > 0 && (len(c.TenantID) == 0 || len(c.ClientID) == 0) {
+		return nil, errors.New("the Azure tenant ID and client ID are required to use a client secret")
+	}
+	c.VaultURL = strings.TrimSuffix(c.VaultURL, "/")
+	if len(c.AuthorityHost) == 0 {
+		c.AuthorityHost = azureDefaultAuthorityHost
+	}
+	c.AuthorityHost = strings.TrimSuffix(c.AuthorityHost, "/")
+	return &azureKeyVault{
+		config: c,
+	}, nil
+}
+
+func (w *azureKeyVault) getName() string {
+	return KeyProviderAzure
+}
+
+// getResource returns the resource to request the access token for, for example
+// "https://vault.azure.net" for the vault "https://my-vault.vault.azure.net"
+func (w *azureKeyVault) getResource() string {
+	u, err := url.Parse(w.config.VaultURL)
+	if err != nil {
+		return w.config.VaultURL
+	}
+	host := u.Host
+	if idx := strings.Index(host, "."); idx > 0 {
+		host = host[idx+1:]
+	}
+	return u.Scheme + "://" + host
+}
+
+func (w *azureKeyVault) getAccessToken(ctx context.Context) (string, error) {
+	var req *http.Request
+	var err error
+	if len(w.config.ClientSecret) > 0 {
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", w.config.ClientID)
+		form.Set("client_secret", w.config.ClientSecret)
+		form.Set("scope", w.getResource()+"/.default")
+		tokenURL := fmt.Sprintf("%v/%v/oauth2/v2.0/token", w.config.AuthorityHost, url.PathEscape(w.config.TenantID))
+		req, err = http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		q := url.Values{}
+		q.Set("api-version", azureManagedIdentityVersion)
+		q.Set("resource", w.getResource())
+		if len(w.config.ClientID) > 0 {
+			q.Set("client_id", w.config.ClientID)
+		}
+		req, err = http.NewRequest(http.MethodGet, azureManagedIdentityURL+"?"+q.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = w.doRequest(ctx, req, &token); err != nil {
+		return "", fmt.Errorf("unable to get an access token: %v", err)
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New("unable to get an access token: empty token")
+	}
+	return token.AccessToken, nil
+}
+
+func (w *azureKeyVault) doRequest(ctx context.Context, req *http.Request, result interface{}) error {
+	resp, err := httpclient.GetHTTPClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var azErr azureError
+		if json.Unmarshal(body, &azErr) == nil {
+			if len(azErr.Error.Message) > 0 {
+				return fmt.Errorf("unexpected status code %v: %v", resp.StatusCode, azErr.Error.Message)
+			}
+			if len(azErr.ErrorDescription) > 0 {
+				return fmt.Errorf("unexpected status code %v: %v", resp.StatusCode, azErr.ErrorDescription)
+			}
+		}
+		return fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+	return json.Unmarshal(body, result)
+}
+
+func (w *azureKeyVault) doKeyOperation(ctx context.Context, keyURL, operation string, value []byte) (azureWrappedKey, error) {
+	var result azureWrappedKey
+	token, err := w.getAccessToken(ctx)
+	if err != nil {
+		return result, err
+	}
+	body, err := json.Marshal(map[string]string{
+		"alg":   azureWrapAlgorithm,
+		"value": base64.RawURLEncoding.EncodeToString(value),
+	})
+	if err != nil {
+		return result, err
+	}
+	operationURL := fmt.Sprintf("%v/%v?api-version=%v", keyURL, operation, azureKeyVaultAPIVersion)
+	req, err := http.NewRequest(http.MethodPost, operationURL, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	err = w.doRequest(ctx, req, &result)
+	return result, err
+}
+
+func (w *azureKeyVault) wrap(ctx context.Context, key []byte) ([]byte, error) {
+	keyURL := fmt.Sprintf("%v/keys/%v", w.config.VaultURL, url.PathEscape(w.config.KeyName))
+	if len(w.config.KeyVersion) > 0 {
+		keyURL += "/" + url.PathEscape(w.config.KeyVersion)
+	}
+	result, err := w.doKeyOperation(ctx, keyURL, "wrapkey", key)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.KeyID) == 0 || len(result.Value) == 0 {
+		return nil, errors.New("invalid wrap key response")
+	}
+	return json.Marshal(result)
+}
+
+func (w *azureKeyVault) unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var key azureWrappedKey
+	if err := json.Unmarshal(wrapped, &key); err != nil {
+		return nil, errWrappedKeyFormat
+	}
+	// the key ID is the key URL, we never send the access token to a different vault or key
+	keyPrefix := fmt.Sprintf("%v/keys/%v/", w.config.VaultURL, url.PathEscape(w.config.KeyName))
+	if !strings.HasPrefix(key.KeyID, keyPrefix) || len(key.KeyID) == len(keyPrefix) ||
+		strings.ContainsAny(key.KeyID[len(keyPrefix):], "/?#") {
+		return nil, fmt.Errorf("the master key was wrapped by a different key: %#v", key.KeyID)
+	}
+	value, err := base64.RawURLEncoding.DecodeString(key.Value)
+	if err != nil {
+		return nil, errWrappedKeyFormat
+	}
+	result, err := w.doKeyOperation(ctx, key.KeyID, "unwrapkey", value)
+	if err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(result.Value)
+}
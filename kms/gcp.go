@@ -0,0 +1,72 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+)
+
+// GCPKMSConfig defines the configuration to wrap the master keys using Google Cloud KMS
+type GCPKMSConfig struct {
+	// Resource name of the symmetric key, for example
+	// "projects/my-project/locations/global/keyRings/my-ring/cryptoKeys/sftpgo"
+	KeyName string `json:"key_name" mapstructure:"key_name"`
+	// Path to a service account credentials file, an absolute path or a path relative to the
+	// config dir. Empty means the Application Default Credentials
+	CredentialsFile string `json:"credentials_file" mapstructure:"credentials_file"`
+}
+
+type gcpKMS struct {
+	keyName string
+	svc     *cloudkms.Service
+}
+
+func newGCPKMS(c GCPKMSConfig, configDir string) (*gcpKMS, error) {
+	if len(c.KeyName) == 0 {
+		return nil, errors.New("the Google Cloud KMS key name is required")
+	}
+	var opts []option.ClientOption
+	if len(c.CredentialsFile) > 0 {
+		credentialsFile, err := getKeyPath(c.CredentialsFile, configDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Google Cloud KMS credentials file: %v", err)
+		}
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	svc, err := cloudkms.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &gcpKMS{
+		keyName: c.KeyName,
+		svc:     svc,
+	}, nil
+}
+
+func (w *gcpKMS) getName() string {
+	return KeyProviderGCP
+}
+
+func (w *gcpKMS) wrap(ctx context.Context, key []byte) ([]byte, error) {
+	resp, err := w.svc.Projects.Locations.KeyRings.CryptoKeys.Encrypt(w.keyName, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(key),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+func (w *gcpKMS) unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	resp, err := w.svc.Projects.Locations.KeyRings.CryptoKeys.Decrypt(w.keyName, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(wrapped),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
@@ -0,0 +1,267 @@
+// Package kms manages the master keys used to encrypt the secrets stored inside the data provider,
+// for example the cloud storage credentials. If no master key is configured each secret is encrypted
+// with a random key stored together with the encrypted data, so the secrets are only obfuscated
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	logSender = "kms"
+	// the encrypted secrets have the format "$aes$<key>$<nonce and ciphertext as hex>", for the
+	// master keys <key> is the key ID with this prefix, otherwise it is the random key itself
+	encryptedPrefix    = "$aes$"
+	masterKeyIDPrefix  = "mk-"
+	minMasterKeyLength = 32
+)
+
+var (
+	keysMutex  sync.RWMutex
+	currentKey *masterKey
+	masterKeys map[string]*masterKey
+	// ErrMasterKeyNotFound is returned if a secret is encrypted with an unknown master key
+	ErrMasterKeyNotFound = errors.New("the master key used to encrypt the secret is not configured")
+)
+
+// Config defines the master keys configuration
+type Config struct {
+	// Path to the file with the master key used to encrypt the secrets. The file must contain at least
+	// 32 bytes, for example generated using "openssl rand -hex 32", the AES-256 key is derived from its
+	// content. This can be an absolute path or a path relative to the config dir. Empty means no master key
+	MasterKeyPath string `json:"master_key_path" mapstructure:"master_key_path"`
+	// Paths to the previous master keys. They are only used to decrypt the secrets not yet re-encrypted
+	// with the current master key, see the "rotate-keys" command
+	OldMasterKeyPaths []string `json:"old_master_key_paths" mapstructure:"old_master_key_paths"`
+	// Key management service used to wrap the master keys. If set, the master key files contain the
+	// master keys encrypted by this service, see the "generate-master-key" command, and they are
+	// decrypted at startup, so the plain master keys are never stored on disk. Supported values:
+	// "awskms", "gcpkms", "azurekeyvault". Empty means that the files contain the plain master keys
+	KeyProvider string `json:"key_provider" mapstructure:"key_provider"`
+	// AWS KMS configuration, used if the key provider is "awskms"
+	AWS AWSKMSConfig `json:"aws" mapstructure:"aws"`
+	// Google Cloud KMS configuration, used if the key provider is "gcpkms"
+	GCP GCPKMSConfig `json:"gcp" mapstructure:"gcp"`
+	// Azure Key Vault configuration, used if the key provider is "azurekeyvault"
+	Azure AzureKeyVaultConfig `json:"azure" mapstructure:"azure"`
+}
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+func init() {
+	utils.AddFeature("+kms")
+}
+
+// Initialize loads the configured master keys
+func (c Config) Initialize(configDir string) error {
+	var current *masterKey
+	keys := make(map[string]*masterKey)
+	wrapper, err := c.getKeyWrapper(configDir)
+	if err != nil {
+		return err
+	}
+	if len(c.MasterKeyPath) > 0 {
+		key, err := loadMasterKey(c.MasterKeyPath, configDir, wrapper)
+		if err != nil {
+			return err
+		}
+		current = key
+		keys[key.id] = key
+	} else if len(c.OldMasterKeyPaths) > 0 {
+		return errors.New("the old master keys require a master key")
+	} else if wrapper != nil {
+		return errors.New("the key provider requires a master key")
+	}
+	for _, p := range c.OldMasterKeyPaths {
+		key, err := loadMasterKey(p, configDir, wrapper)
+		if err != nil {
+			return err
+		}
+		keys[key.id] = key
+	}
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	currentKey = current
+	masterKeys = keys
+	if current != nil {
+		logger.Info(logSender, "", "master key loaded, id: %v, old keys: %v", current.id, len(keys)-1)
+	}
+	return nil
+}
+
+func getKeyPath(keyPath, configDir string) (string, error) {
+	if !utils.IsFileInputValid(keyPath) {
+		return "", fmt.Errorf("invalid master key path %#v", keyPath)
+	}
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(configDir, keyPath)
+	}
+	return keyPath, nil
+}
+
+func loadMasterKey(keyPath, configDir string, wrapper keyWrapper) (*masterKey, error) {
+	keyPath, err := getKeyPath(keyPath, configDir)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the master key: %v", err)
+	}
+	content, err = unwrapMasterKey(strings.TrimSpace(string(content)), wrapper)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the master key %#v: %v", keyPath, err)
+	}
+	if len(content) < minMasterKeyLength {
+		return nil, fmt.Errorf("the master key %#v is too short, at least %v bytes are required", keyPath,
+			minMasterKeyLength)
+	}
+	key := sha256.Sum256(content)
+	id := sha256.Sum256(key[:])
+	return &masterKey{
+		id:  masterKeyIDPrefix + hex.EncodeToString(id[:8]),
+		key: key[:],
+	}, nil
+}
+
+func getCurrentKey() *masterKey {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	return currentKey
+}
+
+func getKey(id string) *masterKey {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	return masterKeys[id]
+}
+
+// IsMasterKeyEnabled returns true if a master key is configured
+func IsMasterKeyEnabled() bool {
+	return getCurrentKey() != nil
+}
+
+// IsEncrypted returns true if the given data is an encrypted secret
+func IsEncrypted(data string) bool {
+	return strings.HasPrefix(data, encryptedPrefix) && len(strings.Split(data, "$")) == 4
+}
+
+// IsEncryptedWithMasterKey returns true if the given data is a secret encrypted with the current
+// master key. If no master key is configured it returns true for all the encrypted secrets
+func IsEncryptedWithMasterKey(data string) bool {
+	if !IsEncrypted(data) {
+		return false
+	}
+	key := getCurrentKey()
+	if key == nil {
+		return true
+	}
+	return strings.Split(data, "$")[2] == key.id
+}
+
+// Encrypt encrypts the given data with the current master key or, if no master key is configured,
+// with a random key stored together with the encrypted data
+func Encrypt(data string) (string, error) {
+	key := getCurrentKey()
+	if key == nil {
+		return utils.EncryptData(data)
+	}
+	gcm, err := getGCM(key.key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, []byte(data), nil)
+	return fmt.Sprintf("%v%v$%x", encryptedPrefix, key.id, ciphertext), nil
+}
+
+// EncryptIfNeeded returns the given data encrypted, secrets already encrypted and empty
+// data are returned unchanged
+func EncryptIfNeeded(data string) (string, error) {
+	if len(data) == 0 || IsEncrypted(data) {
+		return data, nil
+	}
+	return Encrypt(data)
+}
+
+// Decrypt decrypts data encrypted using Encrypt, with any of the configured master keys or
+// with the key stored together with the encrypted data
+func Decrypt(data string) (string, error) {
+	if !IsEncrypted(data) {
+		return "", errors.New("data to decrypt is not in the correct format")
+	}
+	vals := strings.Split(data, "$")
+	if !strings.HasPrefix(vals[2], masterKeyIDPrefix) {
+		return utils.DecryptData(data)
+	}
+	key := getKey(vals[2])
+	if key == nil {
+		return "", ErrMasterKeyNotFound
+	}
+	encrypted, err := hex.DecodeString(vals[3])
+	if err != nil {
+		return "", err
+	}
+	gcm, err := getGCM(key.key)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(encrypted) < nonceSize {
+		return "", errors.New("encrypted data is too short")
+	}
+	plaintext, err := gcm.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rotate re-encrypts the given secret with the current master key, if it is not already
+// encrypted with it, and returns true if the secret was re-encrypted. Empty data and
+// plain text data are returned unchanged
+func Rotate(data string) (string, bool, error) {
+	if !IsEncrypted(data) || IsEncryptedWithMasterKey(data) {
+		return data, false, nil
+	}
+	plaintext, err := Decrypt(data)
+	if err != nil {
+		return data, false, err
+	}
+	encrypted, err := Encrypt(plaintext)
+	if err != nil {
+		return data, false, err
+	}
+	return encrypted, true, nil
+}
+
+func getGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
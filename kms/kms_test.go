@@ -0,0 +1,315 @@
+package kms_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func writeKey(t *testing.T, dir, name, content string) string {
+	keyPath := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(keyPath, []byte(content), 0600); err != nil {
+		t.Fatalf("unable to write key: %v", err)
+	}
+	return keyPath
+}
+
+func TestInitialize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeKey(t, dir, "short.key", "short")
+	writeKey(t, dir, "master.key", strings.Repeat("a", 64)+"\n")
+	c := kms.Config{MasterKeyPath: "short.key"}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a short master key must fail")
+	}
+	c = kms.Config{MasterKeyPath: "missing.key"}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a missing master key must fail")
+	}
+	c = kms.Config{OldMasterKeyPaths: []string{"master.key"}}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("old master keys without a master key must fail")
+	}
+	c = kms.Config{MasterKeyPath: "master.key", OldMasterKeyPaths: []string{"missing.key"}}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a missing old master key must fail")
+	}
+	c = kms.Config{MasterKeyPath: "master.key"}
+	if err = c.Initialize(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !kms.IsMasterKeyEnabled() {
+		t.Errorf("the master key must be enabled")
+	}
+	c = kms.Config{}
+	if err = c.Initialize(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if kms.IsMasterKeyEnabled() {
+		t.Errorf("the master key must be disabled")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldKey := writeKey(t, dir, "old.key", strings.Repeat("b", 32))
+	newKey := writeKey(t, dir, "new.key", strings.Repeat("c", 32))
+	secret := "my secret"
+	// without a master key the secrets are encrypted as before
+	c := kms.Config{}
+	if err = c.Initialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	legacy, err := kms.Encrypt(secret)
+	if err != nil {
+		t.Fatalf("unable to encrypt: %v", err)
+	}
+	if !kms.IsEncrypted(legacy) || !kms.IsEncryptedWithMasterKey(legacy) {
+		t.Errorf("unexpected encrypted secret: %#v", legacy)
+	}
+	if _, changed, err := kms.Rotate(legacy); changed || err != nil {
+		t.Errorf("the secrets cannot be rotated without a master key, changed: %v err: %v", changed, err)
+	}
+
+	c = kms.Config{MasterKeyPath: oldKey}
+	if err = c.Initialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encrypted, err := kms.Encrypt(secret)
+	if err != nil {
+		t.Fatalf("unable to encrypt: %v", err)
+	}
+	if !kms.IsEncryptedWithMasterKey(encrypted) || kms.IsEncryptedWithMasterKey(legacy) {
+		t.Errorf("unexpected master key detection")
+	}
+	// the key ID must not be considered a decryption key
+	if utils.RemoveDecryptionKey(encrypted) == encrypted {
+		t.Errorf("unexpected encrypted secret: %#v", encrypted)
+	}
+	for _, data := range []string{encrypted, legacy} {
+		decrypted, err := kms.Decrypt(data)
+		if err != nil || decrypted != secret {
+			t.Errorf("unexpected decrypted secret: %#v, err: %v", decrypted, err)
+		}
+	}
+	if _, err = utils.DecryptData(encrypted); err == nil {
+		t.Errorf("a secret encrypted with a master key cannot be decrypted without it")
+	}
+	if value, err := kms.EncryptIfNeeded(encrypted); err != nil || value != encrypted {
+		t.Errorf("an encrypted secret must be returned unchanged, err: %v", err)
+	}
+	if value, err := kms.EncryptIfNeeded(""); err != nil || value != "" {
+		t.Errorf("an empty secret must be returned unchanged, err: %v", err)
+	}
+	if _, err = kms.Decrypt("plain text"); err == nil {
+		t.Errorf("decrypting plain text must fail")
+	}
+	if _, err = kms.Decrypt(strings.TrimSuffix(encrypted, encrypted[len(encrypted)-4:]) + "0000"); err == nil {
+		t.Errorf("decrypting modified data must fail")
+	}
+
+	// rotate to the new key
+	c = kms.Config{MasterKeyPath: newKey, OldMasterKeyPaths: []string{oldKey}}
+	if err = c.Initialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kms.IsEncryptedWithMasterKey(encrypted) {
+		t.Errorf("the secret is encrypted with an old master key")
+	}
+	for _, data := range []string{encrypted, legacy} {
+		rotated, changed, err := kms.Rotate(data)
+		if err != nil || !changed {
+			t.Fatalf("unable to rotate the secret, changed: %v, err: %v", changed, err)
+		}
+		if !kms.IsEncryptedWithMasterKey(rotated) {
+			t.Errorf("the rotated secret must be encrypted with the current master key")
+		}
+		if _, changed, err = kms.Rotate(rotated); changed || err != nil {
+			t.Errorf("an already rotated secret must be unchanged, changed: %v err: %v", changed, err)
+		}
+		decrypted, err := kms.Decrypt(rotated)
+		if err != nil || decrypted != secret {
+			t.Errorf("unexpected decrypted secret: %#v, err: %v", decrypted, err)
+		}
+	}
+	if value, changed, err := kms.Rotate("plain text"); changed || err != nil || value != "plain text" {
+		t.Errorf("plain text must be unchanged")
+	}
+	// without the old key the secret cannot be decrypted anymore
+	c = kms.Config{MasterKeyPath: newKey}
+	if err = c.Initialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = kms.Decrypt(encrypted); err != kms.ErrMasterKeyNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err = kms.Rotate(encrypted); err == nil {
+		t.Errorf("rotating a secret encrypted with an unknown key must fail")
+	}
+	c = kms.Config{}
+	if err = c.Initialize(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// newAzureKeyVaultServer returns a fake Azure Key Vault that wraps the keys reversing them
+func newAzureKeyVaultServer(t *testing.T) *httptest.Server {
+	reverse := func(value string) string {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Errorf("invalid value: %v", err)
+		}
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tenant/oauth2/v2.0/token":
+			if r.FormValue("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error_description":"invalid client secret"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"token"}`))
+		case r.URL.Path == "/keys/sftpgo/wrapkey" || r.URL.Path == "/keys/sftpgo/v1/unwrapkey":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["alg"] != "RSA-OAEP-256" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			resp, _ := json.Marshal(map[string]string{
+				"kid":   server.URL + "/keys/sftpgo/v1",
+				"value": reverse(req["value"]),
+			})
+			w.Write(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"KeyNotFound","message":"key not found"}}`))
+		}
+	}))
+	return server
+}
+
+func TestKeyProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newAzureKeyVaultServer(t)
+	defer server.Close()
+	httpclient.Config{Timeout: 10}.Initialize(dir)
+
+	c := kms.Config{KeyProvider: "unknown"}
+	if err = c.GenerateMasterKey(dir, "unknown.key"); err == nil {
+		t.Errorf("an unsupported key provider must fail")
+	}
+	c = kms.Config{KeyProvider: kms.KeyProviderAzure}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a key provider without a vault URL must fail")
+	}
+	c.Azure = kms.AzureKeyVaultConfig{
+		VaultURL:      server.URL,
+		KeyName:       "sftpgo",
+		ClientSecret:  "secret",
+		AuthorityHost: server.URL,
+	}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a client secret without a tenant ID must fail")
+	}
+	c.Azure.TenantID = "tenant"
+	c.Azure.ClientID = "client"
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a key provider without a master key must fail")
+	}
+	// the local keys are not wrapped
+	c1 := kms.Config{}
+	if err = c1.GenerateMasterKey(dir, "local.key"); err != nil {
+		t.Fatalf("unable to generate the master key: %v", err)
+	}
+	if err = c1.GenerateMasterKey(dir, "local.key"); err == nil {
+		t.Errorf("an existing master key must not be overwritten")
+	}
+	c1.MasterKeyPath = "local.key"
+	if err = c1.Initialize(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = c.GenerateMasterKey(dir, "wrapped.key"); err != nil {
+		t.Fatalf("unable to generate the master key: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "wrapped.key"))
+	if err != nil {
+		t.Fatalf("unable to read the master key: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "$azurekeyvault$") {
+		t.Errorf("unexpected wrapped master key: %#v", string(content))
+	}
+	c.MasterKeyPath = "wrapped.key"
+	if err = c.Initialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encrypted, err := kms.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("unable to encrypt: %v", err)
+	}
+	// the plain master keys cannot be used with a key provider and vice versa
+	c.OldMasterKeyPaths = []string{"local.key"}
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a plain master key must fail if a key provider is configured")
+	}
+	c1.MasterKeyPath = "wrapped.key"
+	if err = c1.Initialize(dir); err == nil {
+		t.Errorf("a wrapped master key must fail if no key provider is configured")
+	}
+	c.OldMasterKeyPaths = nil
+	c.Azure.ClientSecret = "wrong"
+	if err = c.Initialize(dir); err == nil || !strings.Contains(err.Error(), "invalid client secret") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	c.Azure.ClientSecret = "secret"
+	c.Azure.KeyName = "other"
+	if err = c.Initialize(dir); err == nil {
+		t.Errorf("a master key wrapped by a different key must fail")
+	}
+	c.Azure.KeyName = "sftpgo"
+	if err = c.Initialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decrypted, err := kms.Decrypt(encrypted)
+	if err != nil || decrypted != "secret" {
+		t.Errorf("unexpected decrypted secret: %#v, err: %v", decrypted, err)
+	}
+	c = kms.Config{}
+	if err = c.Initialize(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package kms
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Supported key providers
+const (
+	KeyProviderAWS   = "awskms"
+	KeyProviderGCP   = "gcpkms"
+	KeyProviderAzure = "azurekeyvault"
+)
+
+const (
+	keyProviderTimeout = 30 * time.Second
+	// the wrapped master keys have the format "$<key provider>$<wrapped key as base64>"
+	wrappedKeyPrefix = "$"
+)
+
+var errWrappedKeyFormat = errors.New("the wrapped master key is not in the correct format")
+
+// keyWrapper encrypts and decrypts the master keys using an external key management service
+type keyWrapper interface {
+	getName() string
+	wrap(ctx context.Context, key []byte) ([]byte, error)
+	unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+func (c Config) getKeyWrapper(configDir string) (keyWrapper, error) {
+	switch c.KeyProvider {
+	case "":
+		return nil, nil
+	case KeyProviderAWS:
+		return newAWSKMS(c.AWS)
+	case KeyProviderGCP:
+		return newGCPKMS(c.GCP, configDir)
+	case KeyProviderAzure:
+		return newAzureKeyVault(c.Azure)
+	default:
+		return nil, fmt.Errorf("unsupported key provider %#v", c.KeyProvider)
+	}
+}
+
+func isWrappedMasterKey(content string) bool {
+	for _, provider := range []string{KeyProviderAWS, KeyProviderGCP, KeyProviderAzure} {
+		if strings.HasPrefix(content, wrappedKeyPrefix+provider+"$") {
+			return true
+		}
+	}
+	return false
+}
+
+func unwrapMasterKey(content string, wrapper keyWrapper) ([]byte, error) {
+	if wrapper == nil {
+		if isWrappedMasterKey(content) {
+			return nil, errors.New("the master key is wrapped by a key provider but no key provider is configured")
+		}
+		return []byte(content), nil
+	}
+	prefix := wrappedKeyPrefix + wrapper.getName() + "$"
+	if !strings.HasPrefix(content, prefix) {
+		return nil, fmt.Errorf("the master key is not wrapped by the configured key provider %#v", wrapper.getName())
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(content, prefix))
+	if err != nil {
+		return nil, errWrappedKeyFormat
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), keyProviderTimeout)
+	defer cancel()
+
+	key, err := wrapper.unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap the master key using %#v: %v", wrapper.getName(), err)
+	}
+	return key, nil
+}
+
+// GenerateMasterKey generates a random master key and saves it to the given path, an absolute path
+// or a path relative to the config dir. If a key provider is configured the master key is wrapped
+// by the key provider before saving it. An existing file is never overwritten
+func (c Config) GenerateMasterKey(configDir, keyPath string) error {
+	keyPath, err := getKeyPath(keyPath, configDir)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(keyPath); err == nil {
+		return fmt.Errorf("the file %#v already exists", keyPath)
+	}
+	wrapper, err := c.getKeyWrapper(configDir)
+	if err != nil {
+		return err
+	}
+	random := make([]byte, minMasterKeyLength)
+	if _, err = rand.Read(random); err != nil {
+		return err
+	}
+	content := hex.EncodeToString(random)
+	if wrapper != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), keyProviderTimeout)
+		defer cancel()
+
+		wrapped, err := wrapper.wrap(ctx, []byte(content))
+		if err != nil {
+			return fmt.Errorf("unable to wrap the master key using %#v: %v", wrapper.getName(), err)
+		}
+		content = wrappedKeyPrefix + wrapper.getName() + "$" + base64.StdEncoding.EncodeToString(wrapped)
+	}
+	return ioutil.WriteFile(keyPath, []byte(content+"\n"), 0600)
+}
@@ -0,0 +1,71 @@
+package ldapd
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestEscapeDNValue(t *testing.T) {
+	baseDN, err := ldap.ParseDN("ou=users,dc=sftpgo")
+	if err != nil {
+		t.Fatalf("unable to parse the base DN: %v", err)
+	}
+	s := &server{
+		baseDN:       baseDN,
+		baseDNString: "ou=users,dc=sftpgo",
+	}
+	for _, username := range []string{"user", "user,name", "#user", " user ", "user+a=b", `us\er"<>;`} {
+		dn, err := ldap.ParseDN(s.getUserDN(username))
+		if err != nil {
+			t.Errorf("unable to parse the DN for username %#v: %v", username, err)
+			continue
+		}
+		parsed, ok := s.getUsernameFromDN(dn)
+		if !ok || parsed != username {
+			t.Errorf("unexpected username %#v, expected %#v", parsed, username)
+		}
+	}
+	dn, err := ldap.ParseDN("cn=user,ou=users,dc=sftpgo")
+	if err != nil {
+		t.Fatalf("unable to parse the DN: %v", err)
+	}
+	if _, ok := s.getUsernameFromDN(dn); ok {
+		t.Error("only uid entries are users")
+	}
+	dn, err = ldap.ParseDN("uid=user,ou=other,ou=users,dc=sftpgo")
+	if err != nil {
+		t.Fatalf("unable to parse the DN: %v", err)
+	}
+	if _, ok := s.getUsernameFromDN(dn); ok {
+		t.Error("the users are directly below the base DN")
+	}
+}
+
+func TestMatchSubstrings(t *testing.T) {
+	filter, err := ldap.CompileFilter("(uid=ab*cd*ef)")
+	if err != nil {
+		t.Fatalf("unable to compile filter: %v", err)
+	}
+	for value, expected := range map[string]bool{
+		"abcdef":     true,
+		"ab_cd_ef":   true,
+		"abef":       false,
+		"xabcdef":    false,
+		"abcdefx":    false,
+		"abcdcdefef": true,
+	} {
+		e := &entry{attributes: []entryAttribute{{name: "uid", values: []string{value}}}}
+		if matchFilter(filter, e) != expected {
+			t.Errorf("unexpected match result for %#v, expected: %v", value, expected)
+		}
+	}
+	filter, err = ldap.CompileFilter("(accountStatus=ACT*)")
+	if err != nil {
+		t.Fatalf("unable to compile filter: %v", err)
+	}
+	e := &entry{attributes: []entryAttribute{{name: "accountStatus", values: []string{accountStatusActive}}}}
+	if !matchFilter(filter, e) {
+		t.Error("the account status must be compared case insensitively")
+	}
+}
@@ -0,0 +1,294 @@
+// Package ldapd implements a read only LDAP server exposing the SFTPGo users.
+// It allows legacy appliances that can only consume LDAP to check if an account exists,
+// if it is enabled and which public keys it has, without maintaining a second directory.
+// Only simple bind and search requests are supported, any write request is refused.
+package ldapd
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	logSender = "ldapd"
+	// a client is disconnected if it does not send a request within this time
+	idleTimeout = 2 * time.Minute
+	// the supported requests are small, bigger requests are refused before reading them
+	maxRequestSize = 65536
+)
+
+var (
+	dataProvider   dataprovider.Provider
+	serverMutex    sync.Mutex
+	serverListener net.Listener
+)
+
+// Configuration defines the configuration for the read only LDAP server
+type Configuration struct {
+	// The port used for serving LDAP requests. 0 disables the LDAP server. Default: 0
+	BindPort int `json:"bind_port" mapstructure:"bind_port"`
+	// The address to listen on. A blank value means listen on all available network interfaces. Default: "127.0.0.1"
+	BindAddress string `json:"bind_address" mapstructure:"bind_address"`
+	// The users are exposed as entries directly below this DN, for example
+	// "uid=user1,ou=users,dc=sftpgo"
+	BaseDN string `json:"base_dn" mapstructure:"base_dn"`
+	// DN and password the clients must bind with before searching. If the bind DN is empty the
+	// anonymous searches are allowed
+	BindDN       string `json:"bind_dn" mapstructure:"bind_dn"`
+	BindPassword string `json:"bind_password" mapstructure:"bind_password"`
+	// If files containing a certificate and matching private key for the server are provided the server will expect
+	// LDAPS connections. They can be absolute paths or paths relative to the config dir
+	CertificateFile    string `json:"certificate_file" mapstructure:"certificate_file"`
+	CertificateKeyFile string `json:"certificate_key_file" mapstructure:"certificate_key_file"`
+}
+
+// SetDataProvider sets the data provider used to search the users
+func SetDataProvider(provider dataprovider.Provider) {
+	dataProvider = provider
+}
+
+// Initialize configures and starts the LDAP server, it returns nil after StopServer
+func (c Configuration) Initialize(configDir string) error {
+	baseDN, err := ldap.ParseDN(c.BaseDN)
+	if err != nil || len(baseDN.RDNs) == 0 {
+		return fmt.Errorf("invalid LDAP base DN %#v", c.BaseDN)
+	}
+	var bindDN *ldap.DN
+	if len(c.BindDN) > 0 {
+		bindDN, err = ldap.ParseDN(c.BindDN)
+		if err != nil {
+			return fmt.Errorf("invalid LDAP bind DN %#v: %v", c.BindDN, err)
+		}
+		if len(c.BindPassword) == 0 {
+			return errors.New("the LDAP bind password cannot be empty if a bind DN is configured")
+		}
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindAddress, c.BindPort))
+	if err != nil {
+		return err
+	}
+	certificateFile := getConfigPath(c.CertificateFile, configDir)
+	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	if len(certificateFile) > 0 && len(certificateKeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certificateFile, certificateKeyFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})
+	}
+	serverMutex.Lock()
+	serverListener = listener
+	serverMutex.Unlock()
+	s := &server{
+		baseDN:       baseDN,
+		baseDNString: c.BaseDN,
+		bindDN:       bindDN,
+		bindPassword: c.BindPassword,
+	}
+	logger.Info(logSender, "", "LDAP server listening on %v, base DN: %#v", listener.Addr(), c.BaseDN)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if isStopped() {
+				logger.Info(logSender, "", "LDAP server stopped")
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// StopServer stops accepting new connections, the active connections are not interrupted.
+// The Initialize method returns after this call
+func StopServer() {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if serverListener != nil {
+		serverListener.Close()
+		serverListener = nil
+	}
+}
+
+func isStopped() bool {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	return serverListener == nil
+}
+
+func getConfigPath(name, configDir string) string {
+	if !utils.IsFileInputValid(name) {
+		return ""
+	}
+	if len(name) > 0 && !filepath.IsAbs(name) {
+		return filepath.Join(configDir, name)
+	}
+	return name
+}
+
+type server struct {
+	baseDN       *ldap.DN
+	baseDNString string
+	bindDN       *ldap.DN
+	bindPassword string
+}
+
+// connection holds the state for a client connection, bound is true after a successful bind
+type connection struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	remoteAddr string
+	bound      bool
+}
+
+func (s *server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	c := &connection{
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		remoteAddr: utils.GetIPFromRemoteAddress(conn.RemoteAddr().String()),
+		bound:      s.bindDN == nil,
+	}
+	logger.Debug(logSender, "", "new LDAP connection from %v", c.remoteAddr)
+	for {
+		packet, err := c.readRequest()
+		if err != nil {
+			logger.Debug(logSender, "", "LDAP connection from %v closed: %v", c.remoteAddr, err)
+			return
+		}
+		if len(packet.Children) < 2 {
+			logger.Debug(logSender, "", "invalid LDAP message from %v", c.remoteAddr)
+			return
+		}
+		messageID, ok := packet.Children[0].Value.(int64)
+		if !ok {
+			logger.Debug(logSender, "", "invalid LDAP message ID from %v", c.remoteAddr)
+			return
+		}
+		request := packet.Children[1]
+		if request.ClassType != ber.ClassApplication {
+			return
+		}
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			err = c.writeResult(messageID, ldap.ApplicationBindResponse, s.bind(c, request), "")
+		case ldap.ApplicationSearchRequest:
+			err = s.search(c, messageID, request)
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationAbandonRequest:
+			// the search requests are served synchronously so there is nothing to abandon
+		case ldap.ApplicationModifyRequest, ldap.ApplicationAddRequest, ldap.ApplicationDelRequest,
+			ldap.ApplicationModifyDNRequest, ldap.ApplicationCompareRequest, ldap.ApplicationExtendedRequest:
+			// the responses use the request tag + 1
+			err = c.writeResult(messageID, request.Tag+1, ldap.LDAPResultUnwillingToPerform,
+				"this is a read only directory")
+		default:
+			logger.Debug(logSender, "", "unsupported LDAP request %v from %v", request.Tag, c.remoteAddr)
+			return
+		}
+		if err != nil {
+			logger.Debug(logSender, "", "unable to write LDAP response to %v: %v", c.remoteAddr, err)
+			return
+		}
+	}
+}
+
+func (s *server) bind(c *connection, request *ber.Packet) uint16 {
+	c.bound = s.bindDN == nil
+	if len(request.Children) < 3 {
+		return ldap.LDAPResultProtocolError
+	}
+	if request.Children[2].ClassType != ber.ClassContext || request.Children[2].Tag != 0 {
+		return ldap.LDAPResultAuthMethodNotSupported
+	}
+	name := request.Children[1].Data.String()
+	password := request.Children[2].Data.String()
+	if len(name) == 0 && len(password) == 0 {
+		// anonymous bind
+		if s.bindDN == nil {
+			return ldap.LDAPResultSuccess
+		}
+		return ldap.LDAPResultInvalidCredentials
+	}
+	if s.bindDN != nil {
+		dn, err := ldap.ParseDN(name)
+		if err == nil && dn.Equal(s.bindDN) &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.bindPassword)) == 1 {
+			c.bound = true
+			return ldap.LDAPResultSuccess
+		}
+	}
+	logger.Debug(logSender, "", "LDAP bind failed for DN %#v from %v", name, c.remoteAddr)
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// readRequest reads the next LDAP message, the messages exceeding the maximum size are
+// refused without reading them
+func (c *connection) readRequest() (*ber.Packet, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+		return nil, err
+	}
+	header, err := c.reader.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	length := int64(header[1])
+	if length&0x80 != 0 {
+		size := int(length & 0x7f)
+		if size == 0 || size > 4 {
+			return nil, fmt.Errorf("unsupported LDAP message length encoding: %v bytes", size)
+		}
+		header, err = c.reader.Peek(2 + size)
+		if err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range header[2:] {
+			length = length<<8 | int64(b)
+		}
+	}
+	if length > maxRequestSize {
+		return nil, fmt.Errorf("LDAP message too large: %v bytes", length)
+	}
+	return ber.ReadPacket(c.reader)
+}
+
+func (c *connection) write(messageID int64, response *ber.Packet) error {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	message.AppendChild(response)
+	_, err := c.conn.Write(message.Bytes())
+	return err
+}
+
+func (c *connection) writeResult(messageID int64, responseType ber.Tag, resultCode uint16, message string) error {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, responseType, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(resultCode), ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, ""))
+	return c.write(messageID, response)
+}
@@ -0,0 +1,302 @@
+package ldapd_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/ldapd"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	configDir    = ".."
+	ldapAddress  = "127.0.0.1:2389"
+	baseDN       = "ou=users,dc=sftpgo"
+	bindDN       = "cn=appliance,dc=sftpgo"
+	bindPassword = "appliance_password"
+	testPubKey   = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQC03jj0D+djk7pxIf/0OhrxrchJTRZklofJ1NoIu4752Sq02mdXmarMVsqJ1cAjV5LBVy3D1F5U6XW4rppkXeVtd04Pxb09ehtH0pRRPaoHHlALiJt8CoMpbKYMA8b3KXPPriGxgGomvtU2T2RMURSwOZbMtpsugfjYSWenyYX+VORYhylWnSXL961LTyC21ehd6d6QnW9G7E5hYMITMY9TuQZz3bROYzXiTsgN0+g6Hn7exFQp50p45StUMfV/SftCMdCxlxuyGny2CrN/vfjO7xxOo2uv7q1qm10Q46KPWJQv+pgZ/OfL+EDjy07n5QVSKHlbx+2nT4Q0EgOSQaCTYwn3YjtABfIxWwgAFdyj6YlPulCL22qU4MYhDcA6PSBwDdf8hvxBfvsiHdM+JcSHvv8/VeJhk6CmnZxGY0fxBupov27z3yEO8nAg8k+6PaUiW1MSUfuGMF/ktB8LOstXsEPXSszuyXiOv4DaryOXUiSn7bmRqKcEFlJusO6aZP0= nicola@p1"
+)
+
+func TestMain(m *testing.M) {
+	logFilePath := filepath.Join(configDir, "sftpgo_ldapd_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, zerolog.DebugLevel)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	// the users are added to a memory provider so the other test packages are not affected
+	providerConf.Driver = dataprovider.MemoryDataProviderName
+	providerConf.Name = ""
+	providerConf.CredentialsPath = filepath.Join(os.TempDir(), "ldapd_test_credentials")
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		logger.WarnToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+	ldapd.SetDataProvider(dataprovider.GetProvider())
+	ldapdConf := config.GetLDAPDConfig()
+	ldapdConf.BindPort = 2389
+	ldapdConf.BaseDN = baseDN
+	ldapdConf.BindDN = bindDN
+	ldapdConf.BindPassword = bindPassword
+	go func() {
+		if err := ldapdConf.Initialize(configDir); err != nil {
+			logger.WarnToConsole("could not start LDAP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+	waitTCPListening(ldapAddress)
+
+	exitCode := m.Run()
+	ldapd.StopServer()
+	os.Remove(logFilePath)
+	os.RemoveAll(providerConf.CredentialsPath)
+	os.Exit(exitCode)
+}
+
+func TestInitializeErrors(t *testing.T) {
+	c := ldapd.Configuration{
+		BindPort: 2390,
+		BaseDN:   "invalid base DN",
+	}
+	if err := c.Initialize(configDir); err == nil {
+		t.Error("an invalid base DN must fail")
+	}
+	c.BaseDN = baseDN
+	c.BindDN = bindDN
+	if err := c.Initialize(configDir); err == nil {
+		t.Error("a bind DN without a password must fail")
+	}
+	c.BindPassword = bindPassword
+	c.CertificateFile = "missing.crt"
+	c.CertificateKeyFile = "missing.key"
+	if err := c.Initialize(configDir); err == nil {
+		t.Error("a missing certificate must fail")
+	}
+}
+
+func TestBind(t *testing.T) {
+	conn := getLDAPConnection(t)
+	defer conn.Close()
+	_, err := conn.Search(getSearchRequest(baseDN, "(uid=*)"))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights) {
+		t.Errorf("a search before binding must fail: %v", err)
+	}
+	err = conn.Bind(bindDN, "wrong password")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("a bind with a wrong password must fail: %v", err)
+	}
+	err = conn.UnauthenticatedBind("")
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("an anonymous bind must fail if a bind DN is configured: %v", err)
+	}
+	err = conn.Bind("CN=appliance, DC=sftpgo", bindPassword)
+	if err != nil {
+		t.Errorf("unable to bind: %v", err)
+	}
+	_, err = conn.Search(getSearchRequest(baseDN, "(uid=*)"))
+	if err != nil {
+		t.Errorf("unable to search after binding: %v", err)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	user1 := addTestUser(t, "ldap_user1", 1, 0, []string{testPubKey})
+	user2 := addTestUser(t, "ldap_user2", 0, 0, nil)
+	user3 := addTestUser(t, "ldap_user3", 1, 1, nil)
+	defer dataprovider.DeleteUser(dataprovider.GetProvider(), user1)
+	defer dataprovider.DeleteUser(dataprovider.GetProvider(), user2)
+	defer dataprovider.DeleteUser(dataprovider.GetProvider(), user3)
+
+	conn := getLDAPConnection(t)
+	defer conn.Close()
+	if err := conn.Bind(bindDN, bindPassword); err != nil {
+		t.Fatalf("unable to bind: %v", err)
+	}
+	result, err := conn.Search(getSearchRequest(baseDN, "(uid=ldap_user1)"))
+	if err != nil {
+		t.Errorf("unable to search: %v", err)
+	} else if len(result.Entries) != 1 {
+		t.Errorf("unexpected entries: %+v", result.Entries)
+	} else {
+		entry := result.Entries[0]
+		if entry.DN != "uid=ldap_user1,"+baseDN {
+			t.Errorf("unexpected DN: %v", entry.DN)
+		}
+		if entry.GetAttributeValue("accountStatus") != "active" {
+			t.Errorf("unexpected status: %v", entry.GetAttributeValue("accountStatus"))
+		}
+		keys := entry.GetAttributeValues("sshPublicKey")
+		if len(keys) != 1 || keys[0] != testPubKey {
+			t.Errorf("unexpected public keys: %+v", keys)
+		}
+		if len(entry.GetAttributeValue("userPassword")) > 0 {
+			t.Error("the password must not be exposed")
+		}
+	}
+	result, err = conn.Search(getSearchRequest(baseDN, "(uid=LDAP_USER1)"))
+	if err != nil || len(result.Entries) != 0 {
+		t.Errorf("the username must be case sensitive, err: %v", err)
+	}
+	result, err = conn.Search(getSearchRequest(baseDN, "(&(objectClass=ACCOUNT)(uid=ldap_user*)(!(accountStatus=active)))"))
+	if err != nil {
+		t.Errorf("unable to search: %v", err)
+	} else if len(result.Entries) != 2 {
+		t.Errorf("unexpected entries: %+v", result.Entries)
+	} else {
+		statuses := map[string]string{
+			result.Entries[0].GetAttributeValue("uid"): result.Entries[0].GetAttributeValue("accountStatus"),
+			result.Entries[1].GetAttributeValue("uid"): result.Entries[1].GetAttributeValue("accountStatus"),
+		}
+		if statuses["ldap_user2"] != "disabled" || statuses["ldap_user3"] != "expired" {
+			t.Errorf("unexpected statuses: %+v", statuses)
+		}
+	}
+	result, err = conn.Search(getSearchRequest(baseDN, "(|(uid=ldap_user2)(sshPublicKey=*))"))
+	if err != nil || len(result.Entries) != 2 {
+		t.Errorf("unexpected search result, err: %v", err)
+	}
+	result, err = conn.Search(getSearchRequest(baseDN, "(uid=*_user*3)"))
+	if err != nil || len(result.Entries) != 1 || result.Entries[0].GetAttributeValue("uid") != "ldap_user3" {
+		t.Errorf("unexpected substrings search result, err: %v", err)
+	}
+	result, err = conn.Search(getSearchRequest(baseDN, "(uidNumber>=1000)"))
+	if err != nil || len(result.Entries) != 0 {
+		t.Errorf("unsupported filters must not match, err: %v", err)
+	}
+	// base scope search for a user entry
+	request := ldap.NewSearchRequest("uid=ldap_user1,"+baseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0,
+		false, "(objectClass=*)", []string{"uid"}, nil)
+	result, err = conn.Search(request)
+	if err != nil || len(result.Entries) != 1 {
+		t.Errorf("unexpected base search result, err: %v", err)
+	} else if len(result.Entries[0].Attributes) != 1 || result.Entries[0].GetAttributeValue("uid") != "ldap_user1" {
+		t.Errorf("only the requested attributes must be returned: %+v", result.Entries[0].Attributes)
+	}
+	request.BaseDN = "uid=missing_user," + baseDN
+	_, err = conn.Search(request)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Errorf("a missing user must fail: %v", err)
+	}
+	request.BaseDN = "ou=groups,dc=sftpgo"
+	_, err = conn.Search(request)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Errorf("a search outside the base DN must fail: %v", err)
+	}
+	// a subtree search from a parent DN returns the users too
+	result, err = conn.Search(getSearchRequest("dc=sftpgo", "(uid=ldap_user2)"))
+	if err != nil || len(result.Entries) != 1 {
+		t.Errorf("unexpected subtree search result, err: %v", err)
+	}
+	request = ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0,
+		false, "(uid=ldap_user*)", nil, nil)
+	_, err = conn.Search(request)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		t.Errorf("the size limit must be honored: %v", err)
+	}
+	request.SizeLimit = 3
+	result, err = conn.Search(request)
+	if err != nil || len(result.Entries) != 3 {
+		t.Errorf("unexpected search result with a size limit, err: %v", err)
+	}
+}
+
+func TestWriteOperations(t *testing.T) {
+	conn := getLDAPConnection(t)
+	defer conn.Close()
+	if err := conn.Bind(bindDN, bindPassword); err != nil {
+		t.Fatalf("unable to bind: %v", err)
+	}
+	addRequest := ldap.NewAddRequest("uid=new_user,"+baseDN, nil)
+	addRequest.Attribute("uid", []string{"new_user"})
+	err := conn.Add(addRequest)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Errorf("add requests must be refused: %v", err)
+	}
+	err = conn.Del(ldap.NewDelRequest("uid=new_user,"+baseDN, nil))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Errorf("delete requests must be refused: %v", err)
+	}
+	_, err = conn.Search(getSearchRequest(baseDN, "(uid=*)"))
+	if err != nil {
+		t.Errorf("the connection must be still usable after a refused request: %v", err)
+	}
+}
+
+func TestLargeRequest(t *testing.T) {
+	conn, err := net.Dial("tcp", ldapAddress)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer conn.Close()
+	// a sequence with a 16MB length, the server must close the connection without reading it
+	_, err = conn.Write([]byte{0x30, 0x84, 0x01, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Errorf("unable to write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	_, err = conn.Read(buf)
+	if err == nil {
+		t.Error("the connection must be closed")
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Error("the connection must be closed before the read timeout")
+	}
+}
+
+func addTestUser(t *testing.T, username string, status int, expirationDate int64, publicKeys []string) dataprovider.User {
+	user := dataprovider.User{
+		Username:       username,
+		Password:       "password",
+		PublicKeys:     publicKeys,
+		HomeDir:        filepath.Join(os.TempDir(), username),
+		Status:         status,
+		ExpirationDate: expirationDate,
+		Permissions: map[string][]string{
+			"/": {dataprovider.PermAny},
+		},
+	}
+	err := dataprovider.AddUser(dataprovider.GetProvider(), user)
+	if err != nil {
+		t.Fatalf("unable to add user %#v: %v", username, err)
+	}
+	user, err = dataprovider.UserExists(dataprovider.GetProvider(), username)
+	if err != nil {
+		t.Fatalf("unable to get user %#v: %v", username, err)
+	}
+	return user
+}
+
+func getLDAPConnection(t *testing.T) *ldap.Conn {
+	conn, err := ldap.DialURL(fmt.Sprintf("ldap://%v", ldapAddress))
+	if err != nil {
+		t.Fatalf("unable to connect to the LDAP server: %v", err)
+	}
+	return conn
+}
+
+func getSearchRequest(base, filter string) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, nil, nil)
+}
+
+func waitTCPListening(address string) {
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			logger.WarnToConsole("tcp server %v not listening: %v\n", address, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		logger.InfoToConsole("tcp server %v now listening\n", address)
+		defer conn.Close()
+		break
+	}
+}
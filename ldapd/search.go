@@ -0,0 +1,360 @@
+package ldapd
+
+import (
+	"strings"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	// the users are read from the data provider in pages of this size
+	usersPageSize = 100
+	// values for the accountStatus attribute
+	accountStatusActive   = "active"
+	accountStatusDisabled = "disabled"
+	accountStatusExpired  = "expired"
+)
+
+var (
+	userObjectClasses = []string{"top", "account", "ldapPublicKey"}
+	// the values for these attributes are compared case insensitively, the other ones,
+	// such as the username, are case sensitive as inside SFTPGo
+	caseIgnoreAttributes = []string{"objectclass", "accountstatus"}
+)
+
+// entryAttribute is an attribute of a search result entry
+type entryAttribute struct {
+	name   string
+	values []string
+}
+
+// entry is a search result entry, the attributes are sent in this order
+type entry struct {
+	dn         string
+	attributes []entryAttribute
+}
+
+func (e *entry) getValues(name string) ([]string, bool) {
+	for _, attr := range e.attributes {
+		if strings.EqualFold(attr.name, name) {
+			return attr.values, true
+		}
+	}
+	return nil, false
+}
+
+func (e *entry) toPacket(attributes []string, typesOnly bool) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for _, attr := range e.attributes {
+		if !isAttributeRequested(attr.name, attributes) {
+			continue
+		}
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.name, ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		if !typesOnly {
+			for _, value := range attr.values {
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+		}
+		a.AppendChild(values)
+		attrs.AppendChild(a)
+	}
+	packet.AppendChild(attrs)
+	return packet
+}
+
+// isAttributeRequested returns true if the attribute with the given name must be returned.
+// All the attributes are returned if no attribute or "*" is requested, "1.1" means no attributes
+func isAttributeRequested(name string, attributes []string) bool {
+	if len(attributes) == 0 {
+		return true
+	}
+	for _, attr := range attributes {
+		if attr == "*" || strings.EqualFold(attr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) getUserDN(username string) string {
+	return "uid=" + escapeDNValue(username) + "," + s.baseDNString
+}
+
+func (s *server) getUserEntry(user dataprovider.User) *entry {
+	status := accountStatusActive
+	if user.Status != 1 {
+		status = accountStatusDisabled
+	} else if user.ExpirationDate > 0 && user.ExpirationDate < utils.GetTimeAsMsSinceEpoch(time.Now()) {
+		status = accountStatusExpired
+	}
+	e := &entry{
+		dn: s.getUserDN(user.Username),
+		attributes: []entryAttribute{
+			{name: "objectClass", values: userObjectClasses},
+			{name: "uid", values: []string{user.Username}},
+			{name: "cn", values: []string{user.Username}},
+			{name: "accountStatus", values: []string{status}},
+		},
+	}
+	if len(user.PublicKeys) > 0 {
+		e.attributes = append(e.attributes, entryAttribute{name: "sshPublicKey", values: user.PublicKeys})
+	}
+	return e
+}
+
+// getUsernameFromDN returns the username if the given DN is a user entry, for example
+// "uid=user1,ou=users,dc=sftpgo" with "ou=users,dc=sftpgo" as base DN
+func (s *server) getUsernameFromDN(dn *ldap.DN) (string, bool) {
+	if len(dn.RDNs) != len(s.baseDN.RDNs)+1 || !s.baseDN.AncestorOf(dn) {
+		return "", false
+	}
+	rdn := dn.RDNs[0]
+	if len(rdn.Attributes) != 1 || !strings.EqualFold(rdn.Attributes[0].Type, "uid") {
+		return "", false
+	}
+	return rdn.Attributes[0].Value, true
+}
+
+func (s *server) search(c *connection, messageID int64, request *ber.Packet) error {
+	if !c.bound {
+		return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights,
+			"a successful bind is required before searching")
+	}
+	if len(request.Children) < 8 {
+		return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, "")
+	}
+	baseDN, err := ldap.ParseDN(request.Children[0].Data.String())
+	if err != nil {
+		return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInvalidDNSyntax, "")
+	}
+	scope, _ := request.Children[1].Value.(int64)
+	sizeLimit, _ := request.Children[3].Value.(int64)
+	typesOnly, _ := request.Children[5].Value.(bool)
+	filter := request.Children[6]
+	var attributes []string
+	for _, attr := range request.Children[7].Children {
+		attributes = append(attributes, attr.Data.String())
+	}
+	var users []dataprovider.User
+	if username, ok := s.getUsernameFromDN(baseDN); ok {
+		users, err = dataprovider.GetUsers(dataProvider, 1, 0, "ASC", username)
+		if err == nil && len(users) == 0 {
+			return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject, "")
+		}
+		if scope == ldap.ScopeSingleLevel {
+			users = nil
+		}
+	} else if s.baseDN.Equal(baseDN) || baseDN.AncestorOf(s.baseDN) {
+		if scope == ldap.ScopeWholeSubtree || (scope == ldap.ScopeSingleLevel && s.baseDN.Equal(baseDN)) {
+			return s.searchUsers(c, messageID, filter, attributes, typesOnly, sizeLimit)
+		}
+	} else {
+		return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject, "")
+	}
+	if err != nil {
+		logger.Warn(logSender, "", "unable to search the users: %v", err)
+		return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultOperationsError, "")
+	}
+	for _, user := range users {
+		e := s.getUserEntry(user)
+		if matchFilter(filter, e) {
+			if err = c.write(messageID, e.toPacket(attributes, typesOnly)); err != nil {
+				return err
+			}
+		}
+	}
+	return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")
+}
+
+// searchUsers sends the users matching the given filter. The filters such as "(uid=user1)" are served
+// looking up the single user, the other ones read all the users from the data provider
+func (s *server) searchUsers(c *connection, messageID int64, filter *ber.Packet, attributes []string,
+	typesOnly bool, sizeLimit int64) error {
+	username, isLookup := getFilterUsername(filter)
+	var sent int64
+	offset := 0
+	for {
+		var users []dataprovider.User
+		var err error
+		if isLookup {
+			users, err = dataprovider.GetUsers(dataProvider, 1, 0, "ASC", username)
+		} else {
+			users, err = dataprovider.GetUsers(dataProvider, usersPageSize, offset, "ASC", "")
+		}
+		if err != nil {
+			logger.Warn(logSender, "", "unable to search the users: %v", err)
+			return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultOperationsError, "")
+		}
+		for _, user := range users {
+			e := s.getUserEntry(user)
+			if !matchFilter(filter, e) {
+				continue
+			}
+			if sizeLimit > 0 && sent >= sizeLimit {
+				return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded, "")
+			}
+			if err = c.write(messageID, e.toPacket(attributes, typesOnly)); err != nil {
+				return err
+			}
+			sent++
+		}
+		if isLookup || len(users) < usersPageSize {
+			break
+		}
+		offset += len(users)
+	}
+	logger.Debug(logSender, "", "LDAP search from %v, filter: %#v, entries: %v", c.remoteAddr,
+		getFilterAsString(filter), sent)
+	return c.writeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")
+}
+
+// getFilterUsername returns the username if the filter is an equality match for the uid
+// attribute or an and filter including it
+func getFilterUsername(filter *ber.Packet) (string, bool) {
+	switch filter.Tag {
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) == 2 && strings.EqualFold(filter.Children[0].Data.String(), "uid") {
+			return filter.Children[1].Data.String(), true
+		}
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if username, ok := getFilterUsername(child); ok {
+				return username, true
+			}
+		}
+	}
+	return "", false
+}
+
+func getFilterAsString(filter *ber.Packet) string {
+	result, err := ldap.DecompileFilter(filter)
+	if err != nil {
+		return ""
+	}
+	return result
+}
+
+// matchFilter returns true if the given entry matches the filter. The greater or equal,
+// less or equal and extensible match filters are not supported and they never match
+func matchFilter(filter *ber.Packet, e *entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(child, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(child, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matchFilter(filter.Children[0], e)
+	case ldap.FilterPresent:
+		_, ok := e.getValues(filter.Data.String())
+		return ok
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		name := filter.Children[0].Data.String()
+		values, _ := e.getValues(name)
+		for _, value := range values {
+			if isValueEqual(name, value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		name := filter.Children[0].Data.String()
+		values, _ := e.getValues(name)
+		for _, value := range values {
+			if matchSubstrings(name, value, filter.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func isValueEqual(name, value, assertion string) bool {
+	if utils.IsStringInSlice(strings.ToLower(name), caseIgnoreAttributes) {
+		return strings.EqualFold(value, assertion)
+	}
+	return value == assertion
+}
+
+func matchSubstrings(name, value string, substrings []*ber.Packet) bool {
+	if utils.IsStringInSlice(strings.ToLower(name), caseIgnoreAttributes) {
+		value = strings.ToLower(value)
+	}
+	for _, substring := range substrings {
+		s := substring.Data.String()
+		if utils.IsStringInSlice(strings.ToLower(name), caseIgnoreAttributes) {
+			s = strings.ToLower(s)
+		}
+		switch substring.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ldap.FilterSubstringsAny:
+			idx := strings.Index(value, s)
+			if idx < 0 {
+				return false
+			}
+			value = value[idx+len(s):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+			value = ""
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeDNValue escapes an attribute value to use it inside a DN as described in RFC 4514
+func escapeDNValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case r == '\\' || r == ',' || r == '+' || r == '"' || r == '<' || r == '>' || r == ';' || r == '=':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case (r == '#' || r == ' ') && i == 0:
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == ' ' && i == len(value)-1:
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString("\\00")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -169,6 +169,36 @@ func CommandLog(command, path, target, user, fileMode, connectionID, protocol st
 		Msg("")
 }
 
+// SSHCommandAuditLog logs an SSH exec request, allowed or denied, with the full command line.
+// The denied requests include the reason
+func SSHCommandAuditLog(user, ip, commandLine, decision, reason, connectionID string) {
+	logger.Info().
+		Timestamp().
+		Str("sender", "ssh_exec").
+		Str("username", user).
+		Str("client_ip", ip).
+		Str("command_line", commandLine).
+		Str("decision", decision).
+		Str("reason", reason).
+		Str("connection_id", connectionID).
+		Msg("")
+}
+
+// LoginSimulationAuditLog logs a login simulation requested using the REST API.
+// The result is empty if the simulation was not executed, the reason explains why
+func LoginSimulationAuditLog(admin, ip, user, loginMethod, result, reason string) {
+	logger.Info().
+		Timestamp().
+		Str("sender", "login_simulation").
+		Str("admin", admin).
+		Str("client_ip", ip).
+		Str("username", user).
+		Str("login_method", loginMethod).
+		Str("result", result).
+		Str("reason", reason).
+		Msg("")
+}
+
 // ConnectionFailedLog logs failed attempts to initialize a connection.
 // A connection can fail for an authentication error or other errors such as
 // a client abort or a time out if the login does not happen in two minutes.
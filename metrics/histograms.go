@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// label value used for any value not in the allowed set, this way the cardinality
+// of the histograms is bounded even if unexpected values are reported
+const otherLabelValue = "other"
+
+var (
+	// allowed values for the protocol label
+	histogramProtocols = []string{"SFTP", "SCP", "SSH", "Sync"}
+	// allowed values for the backend label
+	histogramBackends = []string{"local", "crypt", "s3", "gcs", "webdav", "hdfs", "gdrive", "dropbox"}
+	// allowed values for the SFTP request method label
+	histogramSFTPMethods = []string{"Get", "Put", "Open", "Setstat", "Rename", "Rmdir", "Mkdir", "Symlink", "Remove",
+		"List", "Stat", "Readlink"}
+	// allowed values for the HTTP endpoint label, each value is an httpd request body limit
+	histogramHTTPEndpoints = []string{"login", "api", "ui_preference", "web_form", "web_user_form", "iplist_import",
+		"sync_manifest", "user_bulk", "loaddata"}
+
+	// transferSize is the metric that reports the size distribution for the completed transfers
+	transferSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sftpgo_transfer_size_bytes",
+		Help: "The size distribution for the successful transfers as bytes",
+		// from 1 KB to 16 GB
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 13),
+	}, []string{"protocol", "backend", "direction"})
+
+	// transferDuration is the metric that reports the duration distribution for the completed transfers
+	transferDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_transfer_duration_seconds",
+		Help:    "The duration distribution for the successful transfers as seconds",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"protocol", "backend", "direction"})
+
+	// sftpRequestDuration is the metric that reports the latency distribution for the SFTP requests
+	sftpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_sftp_request_duration_seconds",
+		Help:    "The latency distribution for the SFTP requests as seconds, for file transfers it includes the open time only",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"method", "backend"})
+
+	// httpRequestBodySize is the metric that reports the size distribution for the HTTP request bodies
+	httpRequestBodySize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sftpgo_http_request_body_size_bytes",
+		Help: "The size distribution for the fully read HTTP request bodies as bytes",
+		// from 256 bytes to 1 GB
+		Buckets: prometheus.ExponentialBuckets(256, 4, 12),
+	}, []string{"endpoint"})
+
+	// httpRequestBodyTooLarge is the metric that reports the HTTP requests refused because the body exceeds the limit
+	httpRequestBodyTooLarge = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_http_request_body_too_large_total",
+		Help: "The total number of HTTP requests refused because the body exceeds the endpoint limit",
+	}, []string{"endpoint"})
+)
+
+// getLabelValue returns value if it is allowed, "other" otherwise
+func getLabelValue(value string, allowed []string) string {
+	for _, v := range allowed {
+		if v == value {
+			return value
+		}
+	}
+	return otherLabelValue
+}
+
+// TransferObserved updates the size and duration histograms after a successful upload or download
+func TransferObserved(protocol, backend string, transferKind int, size int64, elapsed time.Duration) {
+	direction := "upload"
+	if transferKind != 0 {
+		direction = "download"
+	}
+	protocol = getLabelValue(protocol, histogramProtocols)
+	backend = getLabelValue(backend, histogramBackends)
+	transferSize.WithLabelValues(protocol, backend, direction).Observe(float64(size))
+	transferDuration.WithLabelValues(protocol, backend, direction).Observe(elapsed.Seconds())
+}
+
+// SFTPRequestServed updates the latency histogram after an SFTP request is served
+func SFTPRequestServed(method, backend string, elapsed time.Duration) {
+	method = getLabelValue(method, histogramSFTPMethods)
+	backend = getLabelValue(backend, histogramBackends)
+	sftpRequestDuration.WithLabelValues(method, backend).Observe(elapsed.Seconds())
+}
+
+// HTTPRequestBodyRead updates the size histogram after an HTTP request body is fully read
+func HTTPRequestBodyRead(endpoint string, size int64) {
+	httpRequestBodySize.WithLabelValues(getLabelValue(endpoint, histogramHTTPEndpoints)).Observe(float64(size))
+}
+
+// HTTPRequestBodyTooLarge increments the metric for the HTTP requests with a body exceeding the endpoint limit
+func HTTPRequestBodyTooLarge(endpoint string) {
+	httpRequestBodyTooLarge.WithLabelValues(getLabelValue(endpoint, histogramHTTPEndpoints)).Inc()
+}
@@ -0,0 +1,255 @@
+// Package plugin manages the lifecycle of external plugin processes.
+// The configured plugins are started at boot, monitored and restarted
+// with an exponential backoff if they exit
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	logSender = "plugin"
+	// a plugin that runs for at least this time is considered healthy and its backoff is reset
+	stableRunTime = 60 * time.Second
+	// time to wait for a plugin to exit after the termination signal before killing it
+	stopGracePeriod = 5 * time.Second
+)
+
+var (
+	// SupportedTypes defines the supported plugin types
+	SupportedTypes = []string{"auth", "notifier", "vfs", "provider"}
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 60 * time.Second
+	mutex          sync.RWMutex
+	plugins        []*managedPlugin
+	stopChan       chan struct{}
+	monitors       sync.WaitGroup
+)
+
+// Config defines the configuration for a plugin process
+type Config struct {
+	// Plugin type, supported values: auth, notifier, vfs, provider.
+	// The type is exported to the plugin process using the SFTPGO_PLUGIN_TYPE environment variable
+	Type string `json:"type" mapstructure:"type"`
+	// Absolute path to the plugin executable
+	Cmd string `json:"cmd" mapstructure:"cmd"`
+	// Arguments for the plugin executable
+	Args []string `json:"args" mapstructure:"args"`
+	// Maximum number of automatic restarts after a crash, 0 means unlimited
+	MaxRestarts int `json:"max_restarts" mapstructure:"max_restarts"`
+}
+
+// Status defines the health status for a plugin process
+type Status struct {
+	Type string `json:"type"`
+	Cmd  string `json:"cmd"`
+	// process ID, 0 if the plugin is not running
+	PID     int  `json:"pid"`
+	Running bool `json:"running"`
+	// number of automatic restarts after a crash
+	Restarts int `json:"restarts"`
+	// last start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	// error for the last exit if any
+	LastError string `json:"last_error,omitempty"`
+}
+
+type managedPlugin struct {
+	sync.RWMutex
+	config Config
+	status Status
+	cmd    *exec.Cmd
+}
+
+func init() {
+	utils.AddFeature("+plugins")
+}
+
+func (c *Config) validate() error {
+	if !utils.IsStringInSlice(c.Type, SupportedTypes) {
+		return fmt.Errorf("invalid plugin type %#v, supported types: %v", c.Type, SupportedTypes)
+	}
+	if !filepath.IsAbs(c.Cmd) {
+		return fmt.Errorf("invalid plugin command %#v: must be an absolute path", c.Cmd)
+	}
+	info, err := os.Stat(c.Cmd)
+	if err != nil {
+		return fmt.Errorf("invalid plugin command %#v: %v", c.Cmd, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("invalid plugin command %#v: is a directory", c.Cmd)
+	}
+	if c.MaxRestarts < 0 {
+		return fmt.Errorf("invalid max_restarts %v for plugin %#v", c.MaxRestarts, c.Cmd)
+	}
+	return nil
+}
+
+// Initialize validates the given configurations and starts the plugin processes.
+// The plugins are restarted, using an exponential backoff, if they exit
+func Initialize(configs []Config) error {
+	for idx := range configs {
+		if err := configs[idx].validate(); err != nil {
+			return err
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if stopChan != nil {
+		return errors.New("plugins are already initialized")
+	}
+	stopChan = make(chan struct{})
+	plugins = nil
+	for _, c := range configs {
+		p := &managedPlugin{
+			config: c,
+			status: Status{
+				Type: c.Type,
+				Cmd:  c.Cmd,
+			},
+		}
+		plugins = append(plugins, p)
+		monitors.Add(1)
+		go p.monitor(stopChan)
+	}
+	return nil
+}
+
+// GetStatus returns the health status for the configured plugins
+func GetStatus() []Status {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	status := []Status{}
+	for _, p := range plugins {
+		p.RLock()
+		status = append(status, p.status)
+		p.RUnlock()
+	}
+	return status
+}
+
+// Stop terminates the plugin processes, they will not be restarted.
+// It returns after all the plugin processes have exited
+func Stop() {
+	mutex.Lock()
+	if stopChan != nil {
+		close(stopChan)
+		stopChan = nil
+	}
+	mutex.Unlock()
+
+	monitors.Wait()
+}
+
+func (p *managedPlugin) start() (chan error, error) {
+	cmd := exec.Command(p.config.Cmd, p.config.Args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("SFTPGO_PLUGIN_TYPE=%v", p.config.Type))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	setSysProcAttr(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p.Lock()
+	p.cmd = cmd
+	p.status.PID = cmd.Process.Pid
+	p.status.Running = true
+	p.status.StartTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.Unlock()
+	logger.Info(logSender, "", "plugin %#v started, type: %v, pid: %v", p.config.Cmd, p.config.Type, cmd.Process.Pid)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	return done, nil
+}
+
+func (p *managedPlugin) setExited(err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.cmd = nil
+	p.status.PID = 0
+	p.status.Running = false
+	if err != nil {
+		p.status.LastError = err.Error()
+	} else {
+		p.status.LastError = "exited"
+	}
+}
+
+func (p *managedPlugin) terminate(done chan error) {
+	p.RLock()
+	cmd := p.cmd
+	p.RUnlock()
+	if cmd == nil {
+		return
+	}
+	logger.Debug(logSender, "", "stopping plugin %#v, pid: %v", p.config.Cmd, cmd.Process.Pid)
+	if err := terminateProcess(cmd.Process); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(stopGracePeriod):
+		cmd.Process.Kill()
+		<-done
+	}
+	p.setExited(errors.New("stopped"))
+}
+
+func (p *managedPlugin) monitor(stop chan struct{}) {
+	defer monitors.Done()
+
+	backoff := initialBackoff
+	for {
+		startTime := time.Now()
+		done, err := p.start()
+		if err == nil {
+			select {
+			case err = <-done:
+			case <-stop:
+				p.terminate(done)
+				return
+			}
+		}
+		p.setExited(err)
+		logger.Warn(logSender, "", "plugin %#v exited: %v", p.config.Cmd, err)
+		if time.Since(startTime) >= stableRunTime {
+			backoff = initialBackoff
+		}
+		p.RLock()
+		restarts := p.status.Restarts
+		p.RUnlock()
+		if p.config.MaxRestarts > 0 && restarts >= p.config.MaxRestarts {
+			logger.Warn(logSender, "", "plugin %#v will not be restarted anymore, max restarts reached: %v",
+				p.config.Cmd, restarts)
+			return
+		}
+		logger.Debug(logSender, "", "restarting plugin %#v in %v", p.config.Cmd, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		p.Lock()
+		p.status.Restarts++
+		p.Unlock()
+	}
+}
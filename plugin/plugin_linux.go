@@ -0,0 +1,13 @@
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setSysProcAttr asks the kernel to terminate the plugin process if SFTPGo dies
+func setSysProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Pdeathsig: syscall.SIGTERM,
+	}
+}
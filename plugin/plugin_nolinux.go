@@ -0,0 +1,8 @@
+// +build !linux
+
+package plugin
+
+import "os/exec"
+
+func setSysProcAttr(cmd *exec.Cmd) {
+}
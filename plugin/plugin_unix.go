@@ -0,0 +1,13 @@
+// +build !windows
+
+package plugin
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminateProcess asks the plugin process to exit gracefully
+func terminateProcess(process *os.Process) error {
+	return process.Signal(syscall.SIGTERM)
+}
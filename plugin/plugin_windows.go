@@ -0,0 +1,9 @@
+package plugin
+
+import "os"
+
+// terminateProcess stops the plugin process, graceful termination signals
+// are not available on Windows
+func terminateProcess(process *os.Process) error {
+	return process.Kill()
+}
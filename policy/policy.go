@@ -0,0 +1,210 @@
+// Package policy evaluates the permissions and the filters of a user for the operations
+// on its files. The SFTP and SCP handlers, the SSH commands and the sync API use this package,
+// so the same rules apply to all of them. It can be used by embedders and tests to check what
+// a user is allowed to do without a connection
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+// Op defines an operation on a path
+type Op int
+
+// Supported operations. For each operation the evaluated path is the SFTP path for the item the
+// operation applies to, for example the file to download or the directory to create
+const (
+	// list the contents of a directory
+	OpList Op = iota
+	// get the attributes of a file or directory
+	OpStat
+	// download a file
+	OpDownload
+	// download a directory and its contents, for example using scp -r
+	OpDownloadDir
+	// upload a new file
+	OpUpload
+	// overwrite or truncate an existing file
+	OpOverwrite
+	// remove a file or a symlink
+	OpDeleteFile
+	// remove a directory
+	OpDeleteDir
+	// create a directory
+	OpCreateDir
+	// create a symlink, the path is the symlink to create
+	OpCreateSymlink
+	// change the permissions of a file or directory
+	OpChmod
+	// change the owner and the group of a file or directory
+	OpChown
+	// change the access and modification times of a file or directory
+	OpChtimes
+	// execute a system command, such as git or rsync, inside a directory. System commands
+	// access the filesystem directly, so all the permissions except the symlinks, chmod, chown
+	// and chtimes ones are required for the directory itself
+	OpSystemCommand
+)
+
+// Rules identify the check that denied an operation
+const (
+	RulePermission    = "permission"
+	RuleFileFilter    = "file_filter"
+	RuleReadOnly      = "read_only"
+	RuleVirtualFolder = "virtual_folder"
+	RuleRootDir       = "root_dir"
+)
+
+var opNames = map[Op]string{
+	OpList:          "list",
+	OpStat:          "stat",
+	OpDownload:      "download",
+	OpDownloadDir:   "download dir",
+	OpUpload:        "upload",
+	OpOverwrite:     "overwrite",
+	OpDeleteFile:    "delete file",
+	OpDeleteDir:     "delete dir",
+	OpCreateDir:     "create dir",
+	OpCreateSymlink: "create symlink",
+	OpChmod:         "chmod",
+	OpChown:         "chown",
+	OpChtimes:       "chtimes",
+	OpSystemCommand: "system command",
+}
+
+// String returns a human readable name for the operation
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown operation %d", int(op))
+}
+
+// Decision defines the result for an evaluated operation
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// the check that denied the operation, empty if the operation is allowed
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func allow() Decision {
+	return Decision{Allowed: true}
+}
+
+func deny(rule, format string, v ...interface{}) Decision {
+	return Decision{
+		Allowed: false,
+		Rule:    rule,
+		Reason:  fmt.Sprintf(format, v...),
+	}
+}
+
+// opRule defines how an operation is evaluated
+type opRule struct {
+	// required permissions
+	permissions []string
+	// the permissions are checked for the path itself and not for its parent directory
+	permissionOnPath bool
+	// the operation changes the filesystem
+	isWrite bool
+	// the file extensions filters apply
+	checkFileFilter bool
+	// the operation is not allowed for virtual folders and for the root directory
+	denyVirtualFolder bool
+	denyRootDir       bool
+}
+
+var opRules = map[Op]opRule{
+	OpList:          {permissions: []string{dataprovider.PermListItems}, permissionOnPath: true},
+	OpStat:          {permissions: []string{dataprovider.PermListItems}},
+	OpDownload:      {permissions: []string{dataprovider.PermDownload}, checkFileFilter: true},
+	OpDownloadDir:   {permissions: []string{dataprovider.PermDownload}, permissionOnPath: true},
+	OpUpload:        {permissions: []string{dataprovider.PermUpload}, isWrite: true, checkFileFilter: true},
+	OpOverwrite:     {permissions: []string{dataprovider.PermOverwrite}, isWrite: true, checkFileFilter: true},
+	OpDeleteFile:    {permissions: []string{dataprovider.PermDelete}, isWrite: true, checkFileFilter: true},
+	OpDeleteDir:     {permissions: []string{dataprovider.PermDelete}, isWrite: true, denyVirtualFolder: true, denyRootDir: true},
+	OpCreateDir:     {permissions: []string{dataprovider.PermCreateDirs}, isWrite: true, denyVirtualFolder: true},
+	OpCreateSymlink: {permissions: []string{dataprovider.PermCreateSymlinks}, isWrite: true, denyVirtualFolder: true},
+	OpChmod:         {permissions: []string{dataprovider.PermChmod}, isWrite: true},
+	OpChown:         {permissions: []string{dataprovider.PermChown}, isWrite: true},
+	OpChtimes:       {permissions: []string{dataprovider.PermChtimes}, isWrite: true},
+	OpSystemCommand: {permissions: []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
+		dataprovider.PermListItems, dataprovider.PermOverwrite, dataprovider.PermDelete, dataprovider.PermRename},
+		permissionOnPath: true, isWrite: true},
+}
+
+// EvaluateOp returns if the given user can execute the given operation on the given SFTP path.
+// The quota and the checks that require to access the filesystem, for example if a file exists,
+// are not evaluated
+func EvaluateOp(user *dataprovider.User, op Op, sftpPath string) Decision {
+	rule, ok := opRules[op]
+	if !ok {
+		return deny(RulePermission, "%v is not supported", op)
+	}
+	if rule.isWrite && user.Filters.ReadOnly {
+		return deny(RuleReadOnly, "%v %#v is not allowed, the account is read only", op, sftpPath)
+	}
+	if rule.denyRootDir && sftpPath == "/" {
+		return deny(RuleRootDir, "%v is not allowed for the root directory", op)
+	}
+	if rule.denyVirtualFolder && user.IsVirtualFolder(sftpPath) {
+		return deny(RuleVirtualFolder, "%v %#v is not allowed, it is a virtual folder", op, sftpPath)
+	}
+	permissionPath := sftpPath
+	if !rule.permissionOnPath {
+		permissionPath = path.Dir(sftpPath)
+	}
+	if !user.HasPerms(rule.permissions, permissionPath) {
+		return deny(RulePermission, "%v %#v is not allowed, the %v permissions are required for %#v", op, sftpPath,
+			strings.Join(rule.permissions, ", "), permissionPath)
+	}
+	if rule.checkFileFilter && !user.IsFileAllowed(sftpPath) {
+		return deny(RuleFileFilter, "%v %#v is not allowed by the file extensions filters", op, sftpPath)
+	}
+	return allow()
+}
+
+// EvaluateRename returns if the given user can rename source to target. The rename permission
+// is required for the target directory. The file extensions filters apply to both paths if the
+// source is a file
+func EvaluateRename(user *dataprovider.User, source, target string, isFile bool) Decision {
+	if user.Filters.ReadOnly {
+		return deny(RuleReadOnly, "rename %#v is not allowed, the account is read only", source)
+	}
+	if source == "/" {
+		return deny(RuleRootDir, "rename is not allowed for the root directory")
+	}
+	for _, p := range []string{source, target} {
+		if user.IsVirtualFolder(p) {
+			return deny(RuleVirtualFolder, "rename %#v -> %#v is not allowed, %#v is a virtual folder", source, target, p)
+		}
+	}
+	if !isFile && user.GetSharedFolderForPath(source) != user.GetSharedFolderForPath(target) {
+		return deny(RuleVirtualFolder, "rename %#v -> %#v is not allowed, a directory cannot be moved to a different "+
+			"shared folder", source, target)
+	}
+	if isFile && (!user.IsFileAllowed(source) || !user.IsFileAllowed(target)) {
+		return deny(RuleFileFilter, "rename %#v -> %#v is not allowed by the file extensions filters", source, target)
+	}
+	if !user.HasPerm(dataprovider.PermRename, path.Dir(target)) {
+		return deny(RulePermission, "rename %#v -> %#v is not allowed, the %#v permission is required for %#v", source,
+			target, dataprovider.PermRename, path.Dir(target))
+	}
+	return allow()
+}
+
+// EvaluateOps returns the first denied decision for the given operations on the given path,
+// or an allowed decision if all the operations are allowed
+func EvaluateOps(user *dataprovider.User, ops []Op, sftpPath string) Decision {
+	for _, op := range ops {
+		if d := EvaluateOp(user, op, sftpPath); !d.Allowed {
+			return d
+		}
+	}
+	return allow()
+}
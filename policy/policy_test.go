@@ -0,0 +1,147 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/policy"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func getTestUser() dataprovider.User {
+	user := dataprovider.User{
+		Username: "test_policy",
+		HomeDir:  "/tmp/test_policy",
+		Status:   1,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions["/download"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user.Permissions["/upload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	user.Permissions["/list"] = []string{dataprovider.PermListItems}
+	user.Filters.FileExtensions = []dataprovider.ExtensionsFilter{
+		{
+			Path:              "/",
+			AllowedExtensions: []string{},
+			DeniedExtensions:  []string{".zip"},
+		},
+	}
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+		MappedPath:  "/tmp/vdir",
+	})
+	return user
+}
+
+func TestEvaluateOp(t *testing.T) {
+	user := getTestUser()
+	tests := []struct {
+		op   policy.Op
+		path string
+		rule string
+	}{
+		{policy.OpList, "/list", ""},
+		{policy.OpList, "/", ""},
+		{policy.OpStat, "/list/file.txt", ""},
+		{policy.OpDownload, "/file.txt", ""},
+		{policy.OpDownload, "/file.zip", policy.RuleFileFilter},
+		{policy.OpDownload, "/download/file.txt", ""},
+		{policy.OpDownload, "/upload/file.txt", policy.RulePermission},
+		{policy.OpDownloadDir, "/download", ""},
+		{policy.OpDownloadDir, "/list", policy.RulePermission},
+		{policy.OpUpload, "/upload/file.txt", ""},
+		{policy.OpUpload, "/upload/file.ZIP", policy.RuleFileFilter},
+		{policy.OpUpload, "/download/file.txt", policy.RulePermission},
+		{policy.OpOverwrite, "/file.txt", ""},
+		{policy.OpOverwrite, "/upload/file.txt", policy.RulePermission},
+		{policy.OpDeleteFile, "/file.txt", ""},
+		{policy.OpDeleteFile, "/file.zip", policy.RuleFileFilter},
+		{policy.OpDeleteFile, "/list/file.txt", policy.RulePermission},
+		{policy.OpDeleteDir, "/dir", ""},
+		{policy.OpDeleteDir, "/", policy.RuleRootDir},
+		{policy.OpDeleteDir, "/vdir", policy.RuleVirtualFolder},
+		{policy.OpCreateDir, "/dir", ""},
+		{policy.OpCreateDir, "/vdir", policy.RuleVirtualFolder},
+		{policy.OpCreateDir, "/list/dir", policy.RulePermission},
+		{policy.OpCreateSymlink, "/link", ""},
+		{policy.OpCreateSymlink, "/upload/link", policy.RulePermission},
+		{policy.OpChmod, "/file.txt", ""},
+		{policy.OpChown, "/download/file.txt", policy.RulePermission},
+		{policy.OpChtimes, "/upload", ""},
+		{policy.OpSystemCommand, "/repo", ""},
+		{policy.OpSystemCommand, "/list", policy.RulePermission},
+		{policy.Op(100), "/file.txt", policy.RulePermission},
+	}
+	for _, test := range tests {
+		decision := policy.EvaluateOp(&user, test.op, test.path)
+		if decision.Rule != test.rule || decision.Allowed != (test.rule == "") {
+			t.Errorf("unexpected decision for %v %#v: %+v", test.op, test.path, decision)
+		}
+		if !decision.Allowed && len(decision.Reason) == 0 {
+			t.Errorf("a denied decision must have a reason, op: %v path: %#v", test.op, test.path)
+		}
+	}
+	user.Filters.ReadOnly = true
+	for _, op := range []policy.Op{policy.OpUpload, policy.OpOverwrite, policy.OpDeleteFile, policy.OpDeleteDir,
+		policy.OpCreateDir, policy.OpCreateSymlink, policy.OpChmod, policy.OpChown, policy.OpChtimes, policy.OpSystemCommand} {
+		decision := policy.EvaluateOp(&user, op, "/file.txt")
+		if decision.Allowed || decision.Rule != policy.RuleReadOnly {
+			t.Errorf("%v must be denied for a read only user: %+v", op, decision)
+		}
+	}
+	for _, op := range []policy.Op{policy.OpList, policy.OpStat, policy.OpDownload, policy.OpDownloadDir} {
+		decision := policy.EvaluateOp(&user, op, "/file.txt")
+		if !decision.Allowed {
+			t.Errorf("%v must be allowed for a read only user: %+v", op, decision)
+		}
+	}
+	decision := policy.EvaluateOps(&user, []policy.Op{policy.OpDownload, policy.OpUpload}, "/file.txt")
+	if decision.Allowed || decision.Rule != policy.RuleReadOnly {
+		t.Errorf("unexpected decision: %+v", decision)
+	}
+	user.Filters.ReadOnly = false
+	decision = policy.EvaluateOps(&user, []policy.Op{policy.OpDownload, policy.OpUpload}, "/file.txt")
+	if !decision.Allowed {
+		t.Errorf("unexpected decision: %+v", decision)
+	}
+}
+
+func TestEvaluateRename(t *testing.T) {
+	user := getTestUser()
+	tests := []struct {
+		source string
+		target string
+		isFile bool
+		rule   string
+	}{
+		{"/file.txt", "/file1.txt", true, ""},
+		{"/file.txt", "/file.zip", true, policy.RuleFileFilter},
+		{"/dir.zip", "/dir1.zip", false, ""},
+		{"/", "/dir", false, policy.RuleRootDir},
+		{"/vdir", "/dir", false, policy.RuleVirtualFolder},
+		{"/dir", "/vdir", false, policy.RuleVirtualFolder},
+		{"/file.txt", "/list/file.txt", true, policy.RulePermission},
+		// the rename permission is required for the target directory only
+		{"/list/file.txt", "/file.txt", true, ""},
+	}
+	for _, test := range tests {
+		decision := policy.EvaluateRename(&user, test.source, test.target, test.isFile)
+		if decision.Rule != test.rule || decision.Allowed != (test.rule == "") {
+			t.Errorf("unexpected decision for rename %#v -> %#v: %+v", test.source, test.target, decision)
+		}
+	}
+	user.Filters.ReadOnly = true
+	decision := policy.EvaluateRename(&user, "/file.txt", "/file1.txt", true)
+	if decision.Allowed || decision.Rule != policy.RuleReadOnly {
+		t.Errorf("rename must be denied for a read only user: %+v", decision)
+	}
+}
+
+func TestOpString(t *testing.T) {
+	if policy.OpDownload.String() != "download" {
+		t.Errorf("unexpected name: %v", policy.OpDownload)
+	}
+	if policy.Op(100).String() != "unknown operation 100" {
+		t.Errorf("unexpected name: %v", policy.Op(100))
+	}
+}
@@ -0,0 +1,51 @@
+#!/bin/sh
+# Builds the SFTPGo release binaries, with the web templates and the static files embedded,
+# for multiple platforms. The binaries and a SHA256 checksums file are written to ./dist.
+#
+# The platforms can be customized setting the TARGETS environment variable to a space
+# separated list of os/arch[/arm version] values, for example:
+#
+#   TARGETS="linux/amd64 linux/arm/7" ./scripts/release.sh
+#
+# Cross compiled binaries are built with CGO disabled, so SQLite support is available
+# only for the binary built for the host platform.
+set -e
+
+cd "$(dirname "$0")/.."
+
+TARGETS=${TARGETS:-"linux/amd64 linux/arm64 linux/arm/7 darwin/amd64 windows/amd64 freebsd/amd64"}
+DIST_DIR=dist
+COMMIT=$(git describe --always --dirty)
+DATE=$(date -u +%FT%TZ)
+LDFLAGS="-s -w -X github.com/drakkan/sftpgo/utils.commit=${COMMIT} -X github.com/drakkan/sftpgo/utils.date=${DATE}"
+HOST_TARGET="$(go env GOOS)/$(go env GOARCH)"
+
+go generate ./assets
+rm -rf "${DIST_DIR}"
+mkdir -p "${DIST_DIR}"
+
+for target in ${TARGETS}; do
+	goos=$(echo "${target}" | cut -d/ -f1)
+	goarch=$(echo "${target}" | cut -d/ -f2)
+	goarm=$(echo "${target}" | cut -d/ -f3)
+	output="${DIST_DIR}/sftpgo_${goos}_${goarch}"
+	if [ -n "${goarm}" ]; then
+		output="${output}v${goarm}"
+	fi
+	if [ "${goos}" = "windows" ]; then
+		output="${output}.exe"
+	fi
+	cgo=0
+	if [ "${goos}/${goarch}" = "${HOST_TARGET}" ] && [ -z "${goarm}" ]; then
+		cgo=${CGO_ENABLED:-1}
+	fi
+	echo "building ${output}, CGO enabled: ${cgo}"
+	CGO_ENABLED=${cgo} GOOS=${goos} GOARCH=${goarch} GOARM=${goarm} go build -ldflags "${LDFLAGS}" -o "${output}"
+done
+
+cd "${DIST_DIR}"
+if command -v sha256sum >/dev/null 2>&1; then
+	sha256sum sftpgo_* > sha256sums.txt
+else
+	shasum -a 256 sftpgo_* > sha256sums.txt
+fi
@@ -3,6 +3,7 @@ import argparse
 import base64
 from datetime import datetime
 import json
+import os
 import platform
 import sys
 import time
@@ -33,11 +34,29 @@ class SFTPGoApiRequests:
 	def __init__(self, debug, baseUrl, authType, authUser, authPassword, secure, no_color):
 		self.userPath = urlparse.urljoin(baseUrl, '/api/v1/user')
 		self.quotaScanPath = urlparse.urljoin(baseUrl, '/api/v1/quota_scan')
+		self.folderQuotaScanPath = urlparse.urljoin(baseUrl, '/api/v1/folder_quota_scan')
 		self.activeConnectionsPath = urlparse.urljoin(baseUrl, '/api/v1/connection')
 		self.versionPath = urlparse.urljoin(baseUrl, '/api/v1/version')
 		self.providerStatusPath = urlparse.urljoin(baseUrl, '/api/v1/providerstatus')
+		self.healthzPath = urlparse.urljoin(baseUrl, '/healthz')
+		self.providerMigrationPath = urlparse.urljoin(baseUrl, '/api/v1/providermigration')
+		self.pluginStatusPath = urlparse.urljoin(baseUrl, '/api/v1/pluginstatus')
 		self.dumpDataPath = urlparse.urljoin(baseUrl, '/api/v1/dumpdata')
 		self.loadDataPath = urlparse.urljoin(baseUrl, '/api/v1/loaddata')
+		self.ipListPath = urlparse.urljoin(baseUrl, '/api/v1/iplist')
+		self.defenderPath = urlparse.urljoin(baseUrl, '/api/v1/defender/')
+		self.userOverridePath = urlparse.urljoin(baseUrl, '/api/v1/user_override')
+		self.userBulkPath = urlparse.urljoin(baseUrl, '/api/v1/user_bulk')
+		self.userOverrideAuditPath = urlparse.urljoin(baseUrl, '/api/v1/user_override_audit')
+		self.userOffboardingPath = urlparse.urljoin(baseUrl, '/api/v1/user_offboarding')
+		self.planPath = urlparse.urljoin(baseUrl, '/api/v1/plan')
+		self.planPropagationPath = urlparse.urljoin(baseUrl, '/api/v1/plan_propagation')
+		self.staleFilesReportPath = urlparse.urljoin(baseUrl, '/api/v1/report/stale_files')
+		self.duplicatesScanPath = urlparse.urljoin(baseUrl, '/api/v1/duplicates_scan')
+		self.activityReportPath = urlparse.urljoin(baseUrl, '/api/v1/report/activity')
+		self.inactiveUsersPath = urlparse.urljoin(baseUrl, '/api/v1/report/inactive_users')
+		self.hooksTestPath = urlparse.urljoin(baseUrl, '/api/v1/hooks/test/')
+		self.loginSimulationPath = urlparse.urljoin(baseUrl, '/api/v1/login_simulation')
 		self.debug = debug
 		if authType == 'basic':
 			self.auth = requests.auth.HTTPBasicAuth(authUser, authPassword)
@@ -77,7 +96,18 @@ class SFTPGoApiRequests:
 					s3_region='', s3_access_key='', s3_access_secret='', s3_endpoint='', s3_storage_class='',
 					s3_key_prefix='', gcs_bucket='', gcs_key_prefix='', gcs_storage_class='', gcs_credentials_file='',
 					gcs_automatic_credentials='automatic', denied_login_methods=[], virtual_folders=[],
-					denied_extensions=[], allowed_extensions=[], s3_upload_part_size=0, s3_upload_concurrency=0):
+					denied_extensions=[], allowed_extensions=[], s3_upload_part_size=0, s3_upload_concurrency=0,
+					revoked_key_fingerprints=[], allowed_key_algorithms=[], min_rsa_key_size=0, plan='',
+					crypt_passphrase='', webdav_endpoint='', webdav_username='', webdav_password='',
+					webdav_bearer_token='', webdav_root_path='', hdfs_endpoint='', hdfs_username='',
+					hdfs_delegation_token='', hdfs_root_path='', gdrive_folder_id='', gdrive_credentials_file='',
+					gdrive_subject='', gdrive_client_id='', gdrive_client_secret='', gdrive_refresh_token='',
+					gdrive_endpoint='', dropbox_root_path='', dropbox_access_token='', dropbox_refresh_token='',
+					dropbox_app_key='', dropbox_app_secret='', dropbox_upload_chunk_size=0, dropbox_endpoint='',
+					s3_storage_class_rules=[], gcs_storage_class_rules=[], ingestion_folders=[], s3_session_token='',
+					s3_role_arn='', s3_external_id='', tenant='', s3_force_path_style=False, s3_skip_tls_verify=False,
+					s3_ca_bundle_file='', s3_download_part_size=0, s3_download_concurrency=0, gcs_download_part_size=0,
+					gcs_download_concurrency=0, read_only=False, additional_info='', allowed_ssh_commands=[]):
 		user = {'id':user_id, 'username':username, 'uid':uid, 'gid':gid,
 			'max_sessions':max_sessions, 'quota_size':quota_size, 'quota_files':quota_files,
 			'upload_bandwidth':upload_bandwidth, 'download_bandwidth':download_bandwidth,
@@ -93,15 +123,35 @@ class SFTPGoApiRequests:
 			user.update({'home_dir':home_dir})
 		if permissions:
 			user.update({'permissions':permissions})
+		if plan:
+			user.update({'plan':plan})
+		if additional_info:
+			user.update({'additional_info':additional_info})
 		if virtual_folders:
 			user.update({'virtual_folders':self.buildVirtualFolders(virtual_folders)})
-		if allowed_ip or denied_ip or denied_login_methods or allowed_extensions or denied_extensions:
+		if (allowed_ip or denied_ip or denied_login_methods or allowed_extensions or denied_extensions or
+				revoked_key_fingerprints or allowed_key_algorithms or min_rsa_key_size or ingestion_folders or tenant or
+				read_only or allowed_ssh_commands):
 			user.update({'filters':self.buildFilters(allowed_ip, denied_ip, denied_login_methods, denied_extensions,
-													allowed_extensions)})
+													allowed_extensions, revoked_key_fingerprints, allowed_key_algorithms,
+													min_rsa_key_size, ingestion_folders, tenant, read_only,
+													allowed_ssh_commands)})
 		user.update({'filesystem':self.buildFsConfig(fs_provider, s3_bucket, s3_region, s3_access_key, s3_access_secret,
 													s3_endpoint, s3_storage_class, s3_key_prefix, gcs_bucket,
 													gcs_key_prefix, gcs_storage_class, gcs_credentials_file,
-													gcs_automatic_credentials, s3_upload_part_size, s3_upload_concurrency)})
+													gcs_automatic_credentials, s3_upload_part_size, s3_upload_concurrency,
+													crypt_passphrase, webdav_endpoint, webdav_username, webdav_password,
+													webdav_bearer_token, webdav_root_path, hdfs_endpoint, hdfs_username,
+													hdfs_delegation_token, hdfs_root_path, gdrive_folder_id,
+													gdrive_credentials_file, gdrive_subject, gdrive_client_id,
+													gdrive_client_secret, gdrive_refresh_token, gdrive_endpoint,
+													dropbox_root_path, dropbox_access_token, dropbox_refresh_token,
+													dropbox_app_key, dropbox_app_secret, dropbox_upload_chunk_size,
+													dropbox_endpoint, s3_storage_class_rules, gcs_storage_class_rules,
+													s3_session_token, s3_role_arn, s3_external_id, s3_force_path_style,
+													s3_skip_tls_verify, s3_ca_bundle_file, s3_download_part_size,
+													s3_download_concurrency, gcs_download_part_size,
+													gcs_download_concurrency)})
 		return user
 
 	def buildVirtualFolders(self, vfolders):
@@ -118,6 +168,18 @@ class SFTPGoApiRequests:
 					result.append({"virtual_path":vpath, "mapped_path":mapped_path})
 		return result
 
+	def buildStorageClassRules(self, rules):
+		result = []
+		for r in rules:
+			values = r.split('::')
+			if len(values) == 4 and values[0]:
+				rule = {'storage_class':values[0], 'path':values[1],
+					'extensions':[e.strip() for e in values[2].split(',') if e.strip()]}
+				if values[3]:
+					rule.update({'min_size':int(values[3])})
+				result.append(rule)
+		return result
+
 	def buildPermissions(self, root_perms, subdirs_perms):
 		permissions = {}
 		if root_perms:
@@ -135,7 +197,9 @@ class SFTPGoApiRequests:
 					permissions.update({directory:values})
 		return permissions
 
-	def buildFilters(self, allowed_ip, denied_ip, denied_login_methods, denied_extensions, allowed_extensions):
+	def buildFilters(self, allowed_ip, denied_ip, denied_login_methods, denied_extensions, allowed_extensions,
+					revoked_key_fingerprints, allowed_key_algorithms, min_rsa_key_size, ingestion_folders, tenant='',
+					read_only=False, allowed_ssh_commands=[]):
 		filters = {}
 		if allowed_ip:
 			if len(allowed_ip) == 1 and not allowed_ip[0]:
@@ -152,6 +216,27 @@ class SFTPGoApiRequests:
 				filters.update({'denied_login_methods':[]})
 			else:
 				filters.update({'denied_login_methods':denied_login_methods})
+		if revoked_key_fingerprints:
+			if len(revoked_key_fingerprints) == 1 and not revoked_key_fingerprints[0]:
+				filters.update({'revoked_key_fingerprints':[]})
+			else:
+				filters.update({'revoked_key_fingerprints':revoked_key_fingerprints})
+		if allowed_key_algorithms:
+			if len(allowed_key_algorithms) == 1 and not allowed_key_algorithms[0]:
+				filters.update({'allowed_key_algorithms':[]})
+			else:
+				filters.update({'allowed_key_algorithms':allowed_key_algorithms})
+		if min_rsa_key_size:
+			filters.update({'min_rsa_key_size':min_rsa_key_size})
+		if allowed_ssh_commands:
+			if len(allowed_ssh_commands) == 1 and not allowed_ssh_commands[0]:
+				filters.update({'allowed_ssh_commands':[]})
+			else:
+				filters.update({'allowed_ssh_commands':allowed_ssh_commands})
+		if tenant:
+			filters.update({'tenant':tenant})
+		if read_only:
+			filters.update({'read_only':read_only})
 		extensions_filter = []
 		extensions_denied = []
 		extensions_allowed = []
@@ -200,19 +285,44 @@ class SFTPGoApiRequests:
 			extensions_filter = extensions_denied
 		if allowed_extensions or denied_extensions:
 			filters.update({'file_extensions':extensions_filter})
+		if ingestion_folders:
+			folders = []
+			for f in ingestion_folders:
+				if not f:
+					continue
+				values = f.split('::')
+				folders.append({'path':values[0], 'hourly_rollup':len(values) > 1 and values[1] == 'rollup'})
+			filters.update({'ingestion_folders':folders})
 		return filters
 
 	def buildFsConfig(self, fs_provider, s3_bucket, s3_region, s3_access_key, s3_access_secret, s3_endpoint,
 					s3_storage_class, s3_key_prefix, gcs_bucket, gcs_key_prefix, gcs_storage_class,
-					gcs_credentials_file, gcs_automatic_credentials, s3_upload_part_size, s3_upload_concurrency):
+					gcs_credentials_file, gcs_automatic_credentials, s3_upload_part_size, s3_upload_concurrency,
+					crypt_passphrase, webdav_endpoint, webdav_username, webdav_password, webdav_bearer_token,
+					webdav_root_path, hdfs_endpoint, hdfs_username, hdfs_delegation_token, hdfs_root_path,
+					gdrive_folder_id, gdrive_credentials_file, gdrive_subject, gdrive_client_id, gdrive_client_secret,
+					gdrive_refresh_token, gdrive_endpoint, dropbox_root_path, dropbox_access_token, dropbox_refresh_token,
+					dropbox_app_key, dropbox_app_secret, dropbox_upload_chunk_size, dropbox_endpoint,
+					s3_storage_class_rules, gcs_storage_class_rules, s3_session_token, s3_role_arn, s3_external_id,
+					s3_force_path_style, s3_skip_tls_verify, s3_ca_bundle_file, s3_download_part_size,
+					s3_download_concurrency, gcs_download_part_size, gcs_download_concurrency):
 		fs_config = {'provider':0}
 		if fs_provider == 'S3':
 			s3config = {'bucket':s3_bucket, 'region':s3_region, 'access_key':s3_access_key, 'access_secret':
 					s3_access_secret, 'endpoint':s3_endpoint, 'storage_class':s3_storage_class, 'key_prefix':
-					s3_key_prefix, 'upload_part_size':s3_upload_part_size, 'upload_concurrency':s3_upload_concurrency}
+					s3_key_prefix, 'upload_part_size':s3_upload_part_size, 'upload_concurrency':s3_upload_concurrency,
+					'storage_class_rules':self.buildStorageClassRules(s3_storage_class_rules), 'session_token':
+					s3_session_token, 'role_arn':s3_role_arn, 'external_id':s3_external_id, 'force_path_style':
+					s3_force_path_style, 'skip_tls_verify':s3_skip_tls_verify, 'download_part_size':
+					s3_download_part_size, 'download_concurrency':s3_download_concurrency}
+			if s3_ca_bundle_file:
+				with open(s3_ca_bundle_file) as ca_bundle:
+					s3config.update({'ca_bundle':ca_bundle.read()})
 			fs_config.update({'provider':1, 's3config':s3config})
 		elif fs_provider == 'GCS':
-			gcsconfig = {'bucket':gcs_bucket, 'key_prefix':gcs_key_prefix, 'storage_class':gcs_storage_class}
+			gcsconfig = {'bucket':gcs_bucket, 'key_prefix':gcs_key_prefix, 'storage_class':gcs_storage_class,
+					'storage_class_rules':self.buildStorageClassRules(gcs_storage_class_rules), 'download_part_size':
+					gcs_download_part_size, 'download_concurrency':gcs_download_concurrency}
 			if gcs_automatic_credentials == "automatic":
 				gcsconfig.update({'automatic_credentials':1})
 			else:
@@ -222,11 +332,79 @@ class SFTPGoApiRequests:
 					gcsconfig.update({'credentials':base64.b64encode(creds.read().encode('UTF-8')).decode('UTF-8'),
 									'automatic_credentials':0})
 			fs_config.update({'provider':2, 'gcsconfig':gcsconfig})
+		elif fs_provider == 'Crypt':
+			fs_config.update({'provider':3, 'cryptconfig':{'passphrase':crypt_passphrase}})
+		elif fs_provider == 'WebDAV':
+			webdavconfig = {'endpoint':webdav_endpoint, 'username':webdav_username, 'password':webdav_password,
+						'bearer_token':webdav_bearer_token, 'root_path':webdav_root_path}
+			fs_config.update({'provider':4, 'webdavconfig':webdavconfig})
+		elif fs_provider == 'HDFS':
+			hdfsconfig = {'endpoint':hdfs_endpoint, 'username':hdfs_username, 'delegation_token':hdfs_delegation_token,
+						'root_path':hdfs_root_path}
+			fs_config.update({'provider':5, 'hdfsconfig':hdfsconfig})
+		elif fs_provider == 'GoogleDrive':
+			gdriveconfig = {'folder_id':gdrive_folder_id, 'subject':gdrive_subject, 'client_id':gdrive_client_id,
+						'client_secret':gdrive_client_secret, 'refresh_token':gdrive_refresh_token,
+						'endpoint':gdrive_endpoint}
+			if gdrive_credentials_file:
+				with open(gdrive_credentials_file) as creds:
+					gdriveconfig.update({'credentials':creds.read()})
+			fs_config.update({'provider':6, 'gdriveconfig':gdriveconfig})
+		elif fs_provider == 'Dropbox':
+			dropboxconfig = {'root_path':dropbox_root_path, 'access_token':dropbox_access_token,
+						'refresh_token':dropbox_refresh_token, 'app_key':dropbox_app_key,
+						'app_secret':dropbox_app_secret, 'upload_chunk_size':dropbox_upload_chunk_size,
+						'endpoint':dropbox_endpoint}
+			fs_config.update({'provider':7, 'dropboxconfig':dropboxconfig})
 		return fs_config
 
-	def getUsers(self, limit=100, offset=0, order='ASC', username=''):
-		r = requests.get(self.userPath, params={'limit':limit, 'offset':offset, 'order':order,
-											'username':username}, auth=self.auth, verify=self.verify)
+	def getUsers(self, limit=100, offset=0, order='ASC', username='', order_by='', cursor='', status=None,
+				expires_after=0, expires_before=0, fs_provider='', min_used_quota_size=0, min_quota_usage_percent=0,
+				last_login_older_than_days=0):
+		params = {'limit':limit, 'offset':offset, 'order':order, 'username':username, 'order_by':order_by,
+				'cursor':cursor}
+		params.update(self.buildUsersFilterParams(status, expires_after, expires_before, fs_provider,
+												min_used_quota_size, min_quota_usage_percent, last_login_older_than_days))
+		r = requests.get(self.userPath, params=params, auth=self.auth, verify=self.verify)
+		for header in ['X-SFTPGo-Total-Count', 'X-SFTPGo-Next-Cursor', 'X-SFTPGo-Prev-Cursor']:
+			if header in r.headers:
+				print('{}: {}'.format(header, r.headers[header]))
+		self.printResponse(r)
+
+	def buildUsersFilterParams(self, status=None, expires_after=0, expires_before=0, fs_provider='',
+							min_used_quota_size=0, min_quota_usage_percent=0, last_login_older_than_days=0):
+		params = {}
+		if status is not None:
+			params.update({'status':status})
+		if expires_after > 0:
+			params.update({'expires_after':expires_after})
+		if expires_before > 0:
+			params.update({'expires_before':expires_before})
+		if fs_provider:
+			params.update({'fs_provider':self.getFsProviderAsInt(fs_provider)})
+		if min_used_quota_size > 0:
+			params.update({'min_used_quota_size':min_used_quota_size})
+		if min_quota_usage_percent > 0:
+			params.update({'min_quota_usage_percent':min_quota_usage_percent})
+		if last_login_older_than_days > 0:
+			params.update({'last_login_older_than_days':last_login_older_than_days})
+		return params
+
+	def bulkUsersOperations(self, operations_file, disconnect=None):
+		with open(operations_file, 'r') as f:
+			operations = json.load(f)
+		r = requests.post(self.userBulkPath, params={'disconnect':disconnect}, json=operations, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def bulkDeleteUsers(self, dry_run=False, disconnect=None, status=None, expires_after=0, expires_before=0,
+					fs_provider='', min_used_quota_size=0, min_quota_usage_percent=0, last_login_older_than_days=0):
+		params = {'disconnect':disconnect}
+		if dry_run:
+			params.update({'dry_run':'true'})
+		params.update(self.buildUsersFilterParams(status, expires_after, expires_before, fs_provider,
+												min_used_quota_size, min_quota_usage_percent, last_login_older_than_days))
+		r = requests.delete(self.userBulkPath, params=params, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
 	def getUserByID(self, user_id):
@@ -239,13 +417,32 @@ class SFTPGoApiRequests:
 			s3_access_key='', s3_access_secret='', s3_endpoint='', s3_storage_class='', s3_key_prefix='', gcs_bucket='',
 			gcs_key_prefix='', gcs_storage_class='', gcs_credentials_file='', gcs_automatic_credentials='automatic',
 			denied_login_methods=[], virtual_folders=[], denied_extensions=[], allowed_extensions=[],
-			s3_upload_part_size=0, s3_upload_concurrency=0):
+			s3_upload_part_size=0, s3_upload_concurrency=0, revoked_key_fingerprints=[], allowed_key_algorithms=[],
+			min_rsa_key_size=0, plan='', crypt_passphrase='', webdav_endpoint='', webdav_username='',
+			webdav_password='', webdav_bearer_token='', webdav_root_path='', hdfs_endpoint='', hdfs_username='',
+			hdfs_delegation_token='', hdfs_root_path='', gdrive_folder_id='', gdrive_credentials_file='',
+			gdrive_subject='', gdrive_client_id='', gdrive_client_secret='', gdrive_refresh_token='',
+			gdrive_endpoint='', dropbox_root_path='', dropbox_access_token='', dropbox_refresh_token='',
+			dropbox_app_key='', dropbox_app_secret='', dropbox_upload_chunk_size=0, dropbox_endpoint='',
+			s3_storage_class_rules=[], gcs_storage_class_rules=[], ingestion_folders=[], s3_session_token='', s3_role_arn='',
+			s3_external_id='', tenant='', s3_force_path_style=False, s3_skip_tls_verify=False, s3_ca_bundle_file='',
+			s3_download_part_size=0, s3_download_concurrency=0, gcs_download_part_size=0, gcs_download_concurrency=0,
+			read_only=False, additional_info='', allowed_ssh_commands=[]):
 		u = self.buildUserObject(0, username, password, public_keys, home_dir, uid, gid, max_sessions,
 			quota_size, quota_files, self.buildPermissions(perms, subdirs_permissions), upload_bandwidth, download_bandwidth,
 			status, expiration_date, allowed_ip, denied_ip, fs_provider, s3_bucket, s3_region, s3_access_key,
 			s3_access_secret, s3_endpoint, s3_storage_class, s3_key_prefix, gcs_bucket, gcs_key_prefix, gcs_storage_class,
 			gcs_credentials_file, gcs_automatic_credentials, denied_login_methods, virtual_folders, denied_extensions,
-			allowed_extensions, s3_upload_part_size, s3_upload_concurrency)
+			allowed_extensions, s3_upload_part_size, s3_upload_concurrency, revoked_key_fingerprints,
+			allowed_key_algorithms, min_rsa_key_size, plan, crypt_passphrase, webdav_endpoint, webdav_username,
+			webdav_password, webdav_bearer_token, webdav_root_path, hdfs_endpoint, hdfs_username,
+			hdfs_delegation_token, hdfs_root_path, gdrive_folder_id, gdrive_credentials_file, gdrive_subject,
+			gdrive_client_id, gdrive_client_secret, gdrive_refresh_token, gdrive_endpoint, dropbox_root_path,
+			dropbox_access_token, dropbox_refresh_token, dropbox_app_key, dropbox_app_secret,
+			dropbox_upload_chunk_size, dropbox_endpoint, s3_storage_class_rules, gcs_storage_class_rules,
+			ingestion_folders, s3_session_token, s3_role_arn, s3_external_id, tenant, s3_force_path_style,
+			s3_skip_tls_verify, s3_ca_bundle_file, s3_download_part_size, s3_download_concurrency, gcs_download_part_size,
+			gcs_download_concurrency, read_only, additional_info, allowed_ssh_commands)
 		r = requests.post(self.userPath, json=u, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
@@ -255,28 +452,67 @@ class SFTPGoApiRequests:
 				s3_bucket='', s3_region='', s3_access_key='', s3_access_secret='', s3_endpoint='', s3_storage_class='',
 				s3_key_prefix='', gcs_bucket='', gcs_key_prefix='', gcs_storage_class='', gcs_credentials_file='',
 				gcs_automatic_credentials='automatic', denied_login_methods=[], virtual_folders=[], denied_extensions=[],
-				allowed_extensions=[], s3_upload_part_size=0, s3_upload_concurrency=0):
+				allowed_extensions=[], s3_upload_part_size=0, s3_upload_concurrency=0, disconnect=None,
+				revoked_key_fingerprints=[], allowed_key_algorithms=[], min_rsa_key_size=0, plan='', crypt_passphrase='',
+				webdav_endpoint='', webdav_username='', webdav_password='', webdav_bearer_token='', webdav_root_path='',
+				hdfs_endpoint='', hdfs_username='', hdfs_delegation_token='', hdfs_root_path='', gdrive_folder_id='',
+				gdrive_credentials_file='', gdrive_subject='', gdrive_client_id='', gdrive_client_secret='',
+				gdrive_refresh_token='', gdrive_endpoint='', dropbox_root_path='', dropbox_access_token='',
+				dropbox_refresh_token='', dropbox_app_key='', dropbox_app_secret='', dropbox_upload_chunk_size=0,
+				dropbox_endpoint='', s3_storage_class_rules=[], gcs_storage_class_rules=[], ingestion_folders=[],
+				s3_session_token='', s3_role_arn='', s3_external_id='', tenant='', s3_force_path_style=False,
+				s3_skip_tls_verify=False, s3_ca_bundle_file='', s3_download_part_size=0, s3_download_concurrency=0,
+				gcs_download_part_size=0, gcs_download_concurrency=0, read_only=False,
+				additional_info='', allowed_ssh_commands=[]):
 		u = self.buildUserObject(user_id, username, password, public_keys, home_dir, uid, gid, max_sessions,
 			quota_size, quota_files, self.buildPermissions(perms, subdirs_permissions), upload_bandwidth, download_bandwidth,
 			status, expiration_date, allowed_ip, denied_ip, fs_provider, s3_bucket, s3_region, s3_access_key,
 			s3_access_secret, s3_endpoint, s3_storage_class, s3_key_prefix, gcs_bucket, gcs_key_prefix, gcs_storage_class,
 			gcs_credentials_file, gcs_automatic_credentials, denied_login_methods, virtual_folders, denied_extensions,
-			allowed_extensions, s3_upload_part_size, s3_upload_concurrency)
-		r = requests.put(urlparse.urljoin(self.userPath, 'user/' + str(user_id)), json=u, auth=self.auth, verify=self.verify)
+			allowed_extensions, s3_upload_part_size, s3_upload_concurrency, revoked_key_fingerprints,
+			allowed_key_algorithms, min_rsa_key_size, plan, crypt_passphrase, webdav_endpoint, webdav_username,
+			webdav_password, webdav_bearer_token, webdav_root_path, hdfs_endpoint, hdfs_username,
+			hdfs_delegation_token, hdfs_root_path, gdrive_folder_id, gdrive_credentials_file, gdrive_subject,
+			gdrive_client_id, gdrive_client_secret, gdrive_refresh_token, gdrive_endpoint, dropbox_root_path,
+			dropbox_access_token, dropbox_refresh_token, dropbox_app_key, dropbox_app_secret,
+			dropbox_upload_chunk_size, dropbox_endpoint, s3_storage_class_rules, gcs_storage_class_rules,
+			ingestion_folders, s3_session_token, s3_role_arn, s3_external_id, tenant, s3_force_path_style,
+			s3_skip_tls_verify, s3_ca_bundle_file, s3_download_part_size, s3_download_concurrency, gcs_download_part_size,
+			gcs_download_concurrency, read_only, additional_info, allowed_ssh_commands)
+		r = requests.put(urlparse.urljoin(self.userPath, 'user/' + str(user_id)), params={'disconnect':disconnect},
+						json=u, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def patchUser(self, user_id, patch, disconnect=None):
+		r = requests.patch(urlparse.urljoin(self.userPath, 'user/' + str(user_id)), params={'disconnect':disconnect},
+						data=json.dumps(patch), headers={'Content-Type':'application/merge-patch+json'}, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def deleteUser(self, user_id, disconnect=None):
+		r = requests.delete(urlparse.urljoin(self.userPath, 'user/' + str(user_id)), params={'disconnect':disconnect},
+						auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
-	def deleteUser(self, user_id):
-		r = requests.delete(urlparse.urljoin(self.userPath, 'user/' + str(user_id)), auth=self.auth, verify=self.verify)
+	def getConnections(self, client_software='', tenant='', label=''):
+		r = requests.get(self.activeConnectionsPath, params={'client_software':client_software, 'tenant':tenant,
+						'label':label}, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
-	def getConnections(self):
-		r = requests.get(self.activeConnectionsPath, auth=self.auth, verify=self.verify)
+	def updateConnectionLabel(self, connectionID, label=''):
+		r = requests.put(urlparse.urljoin(self.activeConnectionsPath, 'connection/' + str(connectionID)),
+						json={'label':label}, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
 	def closeConnection(self, connectionID):
 		r = requests.delete(urlparse.urljoin(self.activeConnectionsPath, 'connection/' + str(connectionID)), auth=self.auth)
 		self.printResponse(r)
 
+	def closeUserConnections(self, username):
+		r = requests.delete(self.activeConnectionsPath, params={'username':username}, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
 	def getQuotaScans(self):
 		r = requests.get(self.quotaScanPath, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
@@ -286,6 +522,21 @@ class SFTPGoApiRequests:
 		r = requests.post(self.quotaScanPath, json=u, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
+	def getQuotaScan(self, username):
+		r = requests.get(urlparse.urljoin(self.quotaScanPath, 'quota_scan/' + username),
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def startUserFolderQuotaScan(self, username, virtual_path):
+		r = requests.post(urlparse.urljoin(self.quotaScanPath, 'quota_scan/' + username + '/folder'),
+						json={'virtual_path':virtual_path}, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getFolderQuotaScan(self, name):
+		r = requests.get(urlparse.urljoin(self.folderQuotaScanPath, 'folder_quota_scan/' + name),
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
 	def getVersion(self):
 		r = requests.get(self.versionPath, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
@@ -294,17 +545,326 @@ class SFTPGoApiRequests:
 		r = requests.get(self.providerStatusPath, auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
-	def dumpData(self, output_file, indent):
-		r = requests.get(self.dumpDataPath, params={'output_file':output_file, 'indent':indent},
+	def getHealthStatus(self):
+		r = requests.get(self.healthzPath, verify=self.verify)
+		self.printResponse(r)
+
+	def getProviderMigrationReport(self):
+		r = requests.get(self.providerMigrationPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def syncProviderMigration(self):
+		r = requests.post(self.providerMigrationPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def buildIPListEntryObject(self, entry_id=0, ipornet='', list_type='block', description=''):
+		entry = {'ipornet':ipornet, 'type':self.getIPListTypeAsInt(list_type), 'description':description}
+		if entry_id > 0:
+			entry.update({'id':entry_id})
+		return entry
+
+	def getIPListTypeAsInt(self, list_type):
+		if list_type == 'safe':
+			return 1
+		if list_type == 'block':
+			return 2
+		return 0
+
+	def getIPListEntries(self, list_type=''):
+		params = {}
+		if list_type:
+			params.update({'type':self.getIPListTypeAsInt(list_type)})
+		r = requests.get(self.ipListPath, params=params, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getIPListEntryByID(self, entry_id):
+		r = requests.get(urlparse.urljoin(self.ipListPath, 'iplist/' + str(entry_id)), auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def addIPListEntry(self, ipornet, list_type='block', description=''):
+		e = self.buildIPListEntryObject(0, ipornet, list_type, description)
+		r = requests.post(self.ipListPath, json=e, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def updateIPListEntry(self, entry_id, ipornet, list_type='block', description=''):
+		e = self.buildIPListEntryObject(entry_id, ipornet, list_type, description)
+		r = requests.put(urlparse.urljoin(self.ipListPath, 'iplist/' + str(entry_id)), json=e, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def deleteIPListEntry(self, entry_id):
+		r = requests.delete(urlparse.urljoin(self.ipListPath, 'iplist/' + str(entry_id)), auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def importIPList(self, list_type, entries_file, description='', replace=False):
+		entries = []
+		with open(entries_file) as f:
+			for line in f:
+				line = line.strip()
+				if line and not line.startswith('#') and not line.startswith(';'):
+					entries.append(line.split()[0])
+		s = {'type':self.getIPListTypeAsInt(list_type), 'entries':entries, 'description':description}
+		r = requests.post(urlparse.urljoin(self.ipListPath, 'iplist/import'), params={'replace':replace}, json=s,
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def exportIPList(self, list_type, output_format='json'):
+		params = {'type':self.getIPListTypeAsInt(list_type), 'format':output_format}
+		r = requests.get(urlparse.urljoin(self.ipListPath, 'iplist/export'), params=params, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def getIPListFeeds(self):
+		r = requests.get(urlparse.urljoin(self.ipListPath, 'iplist/feeds'), auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def checkIPAddress(self, ip):
+		r = requests.get(urlparse.urljoin(self.ipListPath, 'iplist/check'), params={'ip':ip}, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def unblockIPAddress(self, ip):
+		r = requests.post(urlparse.urljoin(self.ipListPath, 'iplist/unblock'), params={'ip':ip}, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def getDefenderHosts(self):
+		r = requests.get(urlparse.urljoin(self.defenderPath, 'hosts'), auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getDefenderHost(self, ip):
+		r = requests.get(urlparse.urljoin(self.defenderPath, 'hosts/' + ip), auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def banDefenderHost(self, ip, ban_time=0):
+		r = requests.post(urlparse.urljoin(self.defenderPath, 'ban'), json={'ip':ip, 'ban_time':ban_time},
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def unbanDefenderHost(self, ip):
+		r = requests.delete(urlparse.urljoin(self.defenderPath, 'hosts/' + ip), auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def buildUserOverrideObject(self, username, duration, quota_size=None, quota_files=None, upload_bandwidth=None,
+							download_bandwidth=None, reason=''):
+		override = {'username':username, 'duration':duration}
+		if quota_size is not None:
+			override.update({'quota_size':quota_size})
+		if quota_files is not None:
+			override.update({'quota_files':quota_files})
+		if upload_bandwidth is not None:
+			override.update({'upload_bandwidth':upload_bandwidth})
+		if download_bandwidth is not None:
+			override.update({'download_bandwidth':download_bandwidth})
+		if reason:
+			override.update({'reason':reason})
+		return override
+
+	def getUserOverrides(self):
+		r = requests.get(self.userOverridePath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getUserOverride(self, username):
+		r = requests.get(urlparse.urljoin(self.userOverridePath, 'user_override/' + username),
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def addUserOverride(self, username, duration, quota_size=None, quota_files=None, upload_bandwidth=None,
+					download_bandwidth=None, reason=''):
+		o = self.buildUserOverrideObject(username, duration, quota_size, quota_files, upload_bandwidth,
+										download_bandwidth, reason)
+		r = requests.post(self.userOverridePath, json=o, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def deleteUserOverride(self, username):
+		r = requests.delete(urlparse.urljoin(self.userOverridePath, 'user_override/' + username),
 						auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
-	def loadData(self, input_file, scan_quota, mode):
-		r = requests.get(self.loadDataPath, params={'input_file':input_file, 'scan_quota':scan_quota,
-												'mode':mode},
+	def getUserOverridesAudit(self):
+		r = requests.get(self.userOverrideAuditPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getStaleFilesReport(self, older_than_days=None, top_files=None, username=''):
+		r = requests.get(self.staleFilesReportPath, params={'older_than_days':older_than_days, 'top_files':top_files,
+														'username':username}, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getUsersActivity(self):
+		r = requests.get(self.activityReportPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getUserActivity(self, username):
+		r = requests.get(urlparse.urljoin(self.activityReportPath, 'activity/' + username),
 						auth=self.auth, verify=self.verify)
 		self.printResponse(r)
 
+	def getInactiveUsersReport(self, inactive_days=None, grace_days=None, action=None):
+		r = requests.get(self.inactiveUsersPath, params={'inactive_days':inactive_days, 'grace_days':grace_days,
+																'action':action}, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getDuplicatesScans(self):
+		r = requests.get(self.duplicatesScanPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getDuplicatesScan(self, username):
+		r = requests.get(urlparse.urljoin(self.duplicatesScanPath, 'duplicates_scan/' + username),
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def startDuplicatesScan(self, username):
+		u = self.buildUserObject(0, username)
+		r = requests.post(self.duplicatesScanPath, json=u, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def cancelDuplicatesScan(self, username):
+		r = requests.delete(urlparse.urljoin(self.duplicatesScanPath, 'duplicates_scan/' + username),
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getOffboardings(self):
+		r = requests.get(self.userOffboardingPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getOffboarding(self, username):
+		r = requests.get(urlparse.urljoin(self.userOffboardingPath, 'user_offboarding/' + username),
+						auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def startOffboarding(self, username, archive_home=False, reason=''):
+		offboarding_request = {'username':username, 'archive_home':archive_home}
+		if reason:
+			offboarding_request.update({'reason':reason})
+		r = requests.post(self.userOffboardingPath, json=offboarding_request, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def testHooks(self, hook, event, username=''):
+		test_request = {'event':event}
+		if username:
+			test_request.update({'username':username})
+		r = requests.post(urlparse.urljoin(self.hooksTestPath, hook), json=test_request, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def simulateLogin(self, username, password='', public_key_file='', ip='', port=0):
+		login_request = {'username':username}
+		if password:
+			login_request.update({'password':password})
+		if public_key_file:
+			with open(public_key_file) as pkey:
+				login_request.update({'public_key':pkey.read().strip()})
+		if ip:
+			login_request.update({'ip':ip})
+		if port:
+			login_request.update({'port':port})
+		r = requests.post(self.loginSimulationPath, json=login_request, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def buildPlanObject(self, plan_id=0, name='', description='', max_sessions=0, quota_size=0, quota_files=0,
+					upload_bandwidth=0, download_bandwidth=0, allowed_fs_providers=[], denied_login_methods=[],
+					password_breach_check='default'):
+		plan = {'name':name, 'description':description, 'max_sessions':max_sessions, 'quota_size':quota_size,
+			'quota_files':quota_files, 'upload_bandwidth':upload_bandwidth, 'download_bandwidth':download_bandwidth,
+			'password_breach_check':self.getPasswordBreachCheckAsInt(password_breach_check)}
+		if plan_id > 0:
+			plan.update({'id':plan_id})
+		if allowed_fs_providers:
+			plan.update({'allowed_fs_providers':[self.getFsProviderAsInt(p) for p in allowed_fs_providers]})
+		if denied_login_methods:
+			plan.update({'denied_login_methods':denied_login_methods})
+		return plan
+
+	def getPasswordBreachCheckAsInt(self, password_breach_check):
+		if password_breach_check == 'disabled':
+			return 1
+		if password_breach_check == 'strict':
+			return 2
+		return 0
+
+	def getFsProviderAsInt(self, fs_provider):
+		if fs_provider == 'S3':
+			return 1
+		if fs_provider == 'GCS':
+			return 2
+		if fs_provider == 'Crypt':
+			return 3
+		if fs_provider == 'WebDAV':
+			return 4
+		if fs_provider == 'HDFS':
+			return 5
+		if fs_provider == 'GoogleDrive':
+			return 6
+		if fs_provider == 'Dropbox':
+			return 7
+		return 0
+
+	def getPlans(self):
+		r = requests.get(self.planPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getPlanByID(self, plan_id):
+		r = requests.get(urlparse.urljoin(self.planPath, 'plan/' + str(plan_id)), auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def addPlan(self, name, description='', max_sessions=0, quota_size=0, quota_files=0, upload_bandwidth=0,
+			download_bandwidth=0, allowed_fs_providers=[], denied_login_methods=[], password_breach_check='default'):
+		p = self.buildPlanObject(0, name, description, max_sessions, quota_size, quota_files, upload_bandwidth,
+								download_bandwidth, allowed_fs_providers, denied_login_methods, password_breach_check)
+		r = requests.post(self.planPath, json=p, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def updatePlan(self, plan_id, name, description='', max_sessions=0, quota_size=0, quota_files=0, upload_bandwidth=0,
+				download_bandwidth=0, allowed_fs_providers=[], denied_login_methods=[], async_update=False,
+				password_breach_check='default'):
+		p = self.buildPlanObject(plan_id, name, description, max_sessions, quota_size, quota_files, upload_bandwidth,
+								download_bandwidth, allowed_fs_providers, denied_login_methods, password_breach_check)
+		params = {}
+		if async_update:
+			params.update({'async':'true'})
+		r = requests.put(urlparse.urljoin(self.planPath, 'plan/' + str(plan_id)), params=params, json=p, auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def getPlanPropagations(self):
+		r = requests.get(self.planPropagationPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def getPlanPropagation(self, name):
+		r = requests.get(urlparse.urljoin(self.planPropagationPath, 'plan_propagation/' + name), auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def deletePlan(self, plan_id):
+		r = requests.delete(urlparse.urljoin(self.planPath, 'plan/' + str(plan_id)), auth=self.auth,
+						verify=self.verify)
+		self.printResponse(r)
+
+	def getPluginStatus(self):
+		r = requests.get(self.pluginStatusPath, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def dumpData(self, output_file, indent, output_format):
+		r = requests.get(self.dumpDataPath, params={'output_file':output_file, 'indent':indent,
+												'format':output_format}, auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
+	def loadData(self, input_file, scan_quota, mode, input_format, csv_columns, upload=False):
+		if upload:
+			if not input_format:
+				ext = os.path.splitext(input_file)[1].lower()
+				input_format = 'yaml' if ext in ['.yaml', '.yml'] else 'csv' if ext == '.csv' else 'json'
+			with open(input_file, 'rb') as f:
+				r = requests.post(self.loadDataPath, params={'scan_quota':scan_quota, 'mode':mode,
+												'format':input_format, 'csv_columns':csv_columns}, data=f,
+								headers={'Content-Type':'application/octet-stream'}, auth=self.auth, verify=self.verify)
+		else:
+			r = requests.get(self.loadDataPath, params={'input_file':input_file, 'scan_quota':scan_quota,
+												'mode':mode, 'format':input_format, 'csv_columns':csv_columns},
+							auth=self.auth, verify=self.verify)
+		self.printResponse(r)
+
 
 class ConvertUsers:
 
@@ -481,11 +1041,43 @@ def validDate(s):
 		raise argparse.ArgumentTypeError(msg)
 
 
+def validJSONObject(s):
+	try:
+		value = json.loads(s)
+	except ValueError:
+		value = None
+	if not isinstance(value, dict):
+		msg = 'Not a valid JSON object: "{0}".'.format(s)
+		raise argparse.ArgumentTypeError(msg)
+	return value
+
+
 def getDatetimeAsMillisSinceEpoch(dt):
 	epoch = datetime.fromtimestamp(0)
 	return int((dt - epoch).total_seconds() * 1000)
 
 
+def addUsersFilterArguments(parser):
+	parser.add_argument('--status', type=int, choices=[0, 1], default=None,
+					help='Return only the users with this status. 1 enabled, 0 disabled. Default: any status')
+	parser.add_argument('--expires-after', type=validDate, default='',
+					help='Return only the users expiring after this date as YYYY-MM-DD. Default: %(default)s')
+	parser.add_argument('--expires-before', type=validDate, default='',
+					help='Return only the users expiring before this date as YYYY-MM-DD. Default: %(default)s')
+	parser.add_argument('--fs-provider', type=str, default='', choices=['', 'local', 'S3', 'GCS', 'Crypt',
+					'WebDAV', 'HDFS', 'GoogleDrive', 'Dropbox'], help='Return only the users with this ' +
+					'filesystem provider. Default: any provider')
+	parser.add_argument('--min-used-quota-size', type=int, default=0,
+					help='Return only the users with a used quota size, as bytes, greater than or equal to ' +
+					'this value. Default: %(default)s')
+	parser.add_argument('--min-quota-usage-percent', type=int, default=0,
+					help='Return only the users with a quota size limit and a used quota size greater than or ' +
+					'equal to this percentage of the limit. Default: %(default)s')
+	parser.add_argument('--last-login-older-than-days', type=int, default=0,
+					help='Return only the users that never logged in or without a login for this number of ' +
+					'days. Default: %(default)s')
+
+
 def addCommonUserArguments(parser):
 	parser.add_argument('username', type=str)
 	parser.add_argument('-P', '--password', type=str, default=None, help='Default: %(default)s')
@@ -505,6 +1097,26 @@ def addCommonUserArguments(parser):
 	parser.add_argument('-L', '--denied-login-methods', type=str, nargs='+', default=[],
 					choices=['', 'publickey', 'password', 'keyboard-interactive', 'publickey+password',
 							'publickey+keyboard-interactive'], help='Default: %(default)s')
+	parser.add_argument('--revoked-key-fingerprints', type=str, nargs='+', default=[], help='SHA256 fingerprints of '
+					+'revoked public keys. For example: "SHA256:jZ3j1ZFl7Xmc/rFzvoFQfqDCzYVatZ0WjvSaN7ZT4xo". '
+					+'Default: %(default)s')
+	parser.add_argument('--allowed-key-algorithms', type=str, nargs='+', default=[],
+					choices=['', 'ssh-rsa', 'ssh-dss', 'ecdsa-sha2-nistp256', 'ecdsa-sha2-nistp384', 'ecdsa-sha2-nistp521',
+							'ssh-ed25519', 'sk-ecdsa-sha2-nistp256@openssh.com', 'sk-ssh-ed25519@openssh.com'],
+					help='Public key algorithms allowed for public key authentication. Default: %(default)s')
+	parser.add_argument('--min-rsa-key-size', type=int, default=0, help='Minimum size, in bits, for RSA public keys. '
+					+'0 means no restrictions. Default: %(default)s')
+	parser.add_argument('--allowed-ssh-commands', type=str, nargs='+', default=[], help='SSH commands, for example ' +
+					'"scp", the user can execute. They must be enabled in the SFTP server configuration too. If empty ' +
+					'any enabled command is allowed. Default: %(default)s')
+	parser.add_argument('--plan', type=str, default='', help='Plan name. The plan limits and denied login methods ' +
+					'replace the user ones. Default: %(default)s')
+	parser.add_argument('--tenant', type=str, default='', help='The active connections for the user are tagged with ' +
+					'this tenant. Default: %(default)s')
+	parser.add_argument('--read-only', dest='read_only', action='store_true', help='The whole account is read only ' +
+					'regardless of the granted permissions. Default: %(default)s')
+	parser.add_argument('--additional-info', type=str, default='', help='Free form text, for example billing IDs or ' +
+					'contract references. Default: %(default)s')
 	parser.add_argument('--subdirs-permissions', type=str, nargs='*', default=[], help='Permissions for subdirs. '
 					+'For example: "/somedir::list,download" "/otherdir/subdir::*" Default: %(default)s')
 	parser.add_argument('--virtual-folders', type=str, nargs='*', default=[], help='Virtual folder mapping. For example: '
@@ -528,29 +1140,129 @@ def addCommonUserArguments(parser):
 	parser.add_argument('--allowed-extensions', type=str, nargs='*', default=[], help='Allowed file extensions case insensitive. '
 					+'The format is /dir::ext1,ext2. For example: "/somedir::.jpg,.png" "/otherdir/subdir::.zip,.rar". ' +
 					'Default: %(default)s')
-	parser.add_argument('--fs', type=str, default='local', choices=['local', 'S3', 'GCS'],
+	parser.add_argument('--fs', type=str, default='local', choices=['local', 'S3', 'GCS', 'Crypt', 'WebDAV', 'HDFS',
+					'GoogleDrive', 'Dropbox'],
 					help='Filesystem provider. Default: %(default)s')
 	parser.add_argument('--s3-bucket', type=str, default='', help='Default: %(default)s')
 	parser.add_argument('--s3-key-prefix', type=str, default='', help='Virtual root directory. If non empty only this ' +
 					'directory and its contents will be available. Cannot start with "/". For example "folder/subfolder/".' +
 					' Default: %(default)s')
 	parser.add_argument('--s3-region', type=str, default='', help='Default: %(default)s')
-	parser.add_argument('--s3-access-key', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--s3-access-key', type=str, default='', help='Leave blank to use the default AWS credential chain.' +
+					' Default: %(default)s')
 	parser.add_argument('--s3-access-secret', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--s3-session-token', type=str, default='', help='Optional session token for temporary ' +
+					'credentials, it requires an access key. Default: %(default)s')
+	parser.add_argument('--s3-role-arn', type=str, default='', help='Optional IAM role to assume using STS. ' +
+					'Default: %(default)s')
+	parser.add_argument('--s3-external-id', type=str, default='', help='Optional external ID to use when assuming ' +
+					'the role. Default: %(default)s')
 	parser.add_argument('--s3-endpoint', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--s3-force-path-style', dest='s3_force_path_style', action='store_true', help='Use path-style ' +
+					'requests. Path-style is always used if a custom endpoint is set. Default: %(default)s')
+	parser.add_argument('--s3-skip-tls-verify', dest='s3_skip_tls_verify', action='store_true', help='Skip the TLS ' +
+					'certificate verification for the endpoint. This is insecure. Default: %(default)s')
+	parser.add_argument('--s3-ca-bundle-file', type=str, default='', help='PEM file with the CA certificates to trust ' +
+					'for the endpoint, in addition to the system ones. Default: %(default)s')
 	parser.add_argument('--s3-storage-class', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--ingestion-folders', type=str, nargs='*', default=[], help='Directories optimized for many ' +
+					'small uploads and appends. Add "::rollup" to roll up the files each hour. For example: ' +
+					'"/logs::rollup" "/events". Use "" to remove the existing ones. Default: %(default)s')
+	parser.add_argument('--s3-storage-class-rules', type=str, nargs='*', default=[], help='Rules to choose the ' +
+					'storage class for each upload, the first matching rule wins. The format is ' +
+					'storage_class::path::ext1,ext2::min_size, empty values match any file and min_size is in bytes. ' +
+					'For example: "GLACIER::/archive::.zip,.tar::" "STANDARD_IA::::::1073741824". Default: %(default)s')
 	parser.add_argument('--s3-upload-part-size', type=int, default=0, help='The buffer size for multipart uploads (MB). ' +
 					'Zero means the default (5 MB). Minimum is 5. Default: %(default)s')
 	parser.add_argument('--s3-upload-concurrency', type=int, default=0, help='How many parts are uploaded in parallel. ' +
 					'Zero means the default (2). Default: %(default)s')
+	parser.add_argument('--s3-download-part-size', type=int, default=0, help='The buffer size for multipart downloads ' +
+					'(MB). Zero means the default (5 MB). Minimum is 5. Default: %(default)s')
+	parser.add_argument('--s3-download-concurrency', type=int, default=0, help='How many parts are downloaded in ' +
+					'parallel. Zero means the default (5). Default: %(default)s')
 	parser.add_argument('--gcs-bucket', type=str, default='', help='Default: %(default)s')
 	parser.add_argument('--gcs-key-prefix', type=str, default='', help='Virtual root directory. If non empty only this ' +
 					'directory and its contents will be available. Cannot start with "/". For example "folder/subfolder/".' +
 					' Default: %(default)s')
 	parser.add_argument('--gcs-storage-class', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--gcs-download-part-size', type=int, default=0, help='The size of each range request for ' +
+					'parallel downloads (MB). Zero means the default (5 MB). Minimum is 5. Default: %(default)s')
+	parser.add_argument('--gcs-download-concurrency', type=int, default=0, help='How many parts are downloaded in ' +
+					'parallel. Zero or one means sequential downloads. Default: %(default)s')
+	parser.add_argument('--gcs-storage-class-rules', type=str, nargs='*', default=[], help='Rules to choose the ' +
+					'storage class for each upload, the format is the same as for --s3-storage-class-rules. For example: ' +
+					'"COLDLINE::/archive::.zip,.tar::" "NEARLINE::::::1073741824". Default: %(default)s')
 	parser.add_argument('--gcs-credentials-file', type=str, default='', help='Default: %(default)s')
 	parser.add_argument('--gcs-automatic-credentials', type=str, default='automatic', choices=['explicit', 'automatic'],
 					help='If you provide a credentials file this argument will be setted to "explicit". Default: %(default)s')
+	parser.add_argument('--crypt-passphrase', type=str, default='', help='Passphrase used to derive the file encryption ' +
+					'keys for the "Crypt" filesystem provider. Default: %(default)s')
+	parser.add_argument('--webdav-endpoint', type=str, default='', help='http or https URL for the remote WebDAV ' +
+					'server. Default: %(default)s')
+	parser.add_argument('--webdav-username', type=str, default='', help='Username for basic authentication. ' +
+					'Default: %(default)s')
+	parser.add_argument('--webdav-password', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--webdav-bearer-token', type=str, default='', help='Cannot be used together with a username.' +
+					' Default: %(default)s')
+	parser.add_argument('--webdav-root-path', type=str, default='', help='Virtual root directory. If non empty only ' +
+					'this directory and its contents will be available. Cannot start with "/". For example ' +
+					'"folder/subfolder/". Default: %(default)s')
+	parser.add_argument('--hdfs-endpoint', type=str, default='', help='http or https WebHDFS base URL, for example ' +
+					'"http://namenode:9870/webhdfs/v1". Default: %(default)s')
+	parser.add_argument('--hdfs-username', type=str, default='', help='Hadoop user for simple authentication. ' +
+					'Default: %(default)s')
+	parser.add_argument('--hdfs-delegation-token', type=str, default='', help='Cannot be used together with a ' +
+					'username. Default: %(default)s')
+	parser.add_argument('--hdfs-root-path', type=str, default='', help='Virtual root directory. If non empty only ' +
+					'this directory and its contents will be available. Cannot start with "/". For example ' +
+					'"data/partners/". Default: %(default)s')
+	parser.add_argument('--gdrive-folder-id', type=str, default='', help='ID of the Drive folder to serve. Empty ' +
+					'means the root folder of My Drive. Default: %(default)s')
+	parser.add_argument('--gdrive-credentials-file', type=str, default='', help='Service account JSON key file. ' +
+					'Cannot be used together with a refresh token. Default: %(default)s')
+	parser.add_argument('--gdrive-subject', type=str, default='', help='User to impersonate using the domain-wide ' +
+					'delegation. Default: %(default)s')
+	parser.add_argument('--gdrive-client-id', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--gdrive-client-secret', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--gdrive-refresh-token', type=str, default='', help='OAuth 2.0 refresh token, client ID ' +
+					'and secret are required. Default: %(default)s')
+	parser.add_argument('--gdrive-endpoint', type=str, default='', help='Alternative API endpoint. ' +
+					'Default: %(default)s')
+	parser.add_argument('--dropbox-root-path', type=str, default='', help='Virtual root directory. If non empty only ' +
+					'this folder and its contents will be available. Cannot start with "/". Default: %(default)s')
+	parser.add_argument('--dropbox-access-token', type=str, default='', help='Long-lived OAuth 2.0 access token. ' +
+					'Cannot be used together with a refresh token. Default: %(default)s')
+	parser.add_argument('--dropbox-refresh-token', type=str, default='', help='OAuth 2.0 refresh token, the app key ' +
+					'is required. Default: %(default)s')
+	parser.add_argument('--dropbox-app-key', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('--dropbox-app-secret', type=str, default='', help='Not required for refresh tokens ' +
+					'obtained using PKCE. Default: %(default)s')
+	parser.add_argument('--dropbox-upload-chunk-size', type=int, default=0, help='The chunk size for upload sessions ' +
+					'as MB. 0 means the default (8 MB). Default: %(default)s')
+	parser.add_argument('--dropbox-endpoint', type=str, default='', help='Alternative API endpoint. ' +
+					'Default: %(default)s')
+
+
+def addPlanArguments(parser):
+	parser.add_argument('--description', type=str, default='', help='Default: %(default)s')
+	parser.add_argument('-C', '--max-sessions', type=int, default=0,
+					help='Maximum concurrent sessions. 0 means unlimited. Default: %(default)s')
+	parser.add_argument('-S', '--quota-size', type=int, default=0,
+					help='Maximum size allowed as bytes. 0 means unlimited. Default: %(default)s')
+	parser.add_argument('-F', '--quota-files', type=int, default=0, help='default: %(default)s')
+	parser.add_argument('-U', '--upload-bandwidth', type=int, default=0,
+					help='Maximum upload bandwidth as KB/s, 0 means unlimited. Default: %(default)s')
+	parser.add_argument('-D', '--download-bandwidth', type=int, default=0,
+					help='Maximum download bandwidth as KB/s, 0 means unlimited. Default: %(default)s')
+	parser.add_argument('--allowed-fs-providers', type=str, nargs='+', default=[], choices=['local', 'S3', 'GCS', 'Crypt', 'WebDAV', 'HDFS',
+					'GoogleDrive', 'Dropbox'],
+					help='Only users with these filesystem providers can be assigned to the plan. Default: any')
+	parser.add_argument('-L', '--denied-login-methods', type=str, nargs='+', default=[],
+					choices=['publickey', 'password', 'keyboard-interactive', 'publickey+password',
+							'publickey+keyboard-interactive'], help='Default: %(default)s')
+	parser.add_argument('--password-breach-check', type=str, default='default', choices=['default', 'disabled', 'strict'],
+					help='Password breach check policy for the assigned users. "default" applies the global configuration, ' +
+					'"strict" rejects the passwords if the check cannot be done. Default: %(default)s')
 
 
 if __name__ == '__main__':
@@ -584,10 +1296,24 @@ if __name__ == '__main__':
 
 	parserUpdateUser = subparsers.add_parser('update-user', help='Update an existing user')
 	parserUpdateUser.add_argument('id', type=int, help='User\'s ID to update')
+	parserUpdateUser.add_argument('--disconnect', type=int, choices=[0, 1], default=None,
+							help='0 means the active connections for the user will not be closed. 1 means the active ' +
+							'connections will be closed after a successful update. Default: the server configuration')
 	addCommonUserArguments(parserUpdateUser)
 
+	parserPatchUser = subparsers.add_parser('patch-user', help='Update the given fields of an existing user')
+	parserPatchUser.add_argument('id', type=int, help='User\'s ID to patch')
+	parserPatchUser.add_argument('patch', type=validJSONObject, help='JSON merge patch to apply, for example ' +
+							'\'{"status": 0}\'. A null value resets the field')
+	parserPatchUser.add_argument('--disconnect', type=int, choices=[0, 1], default=None,
+							help='0 means the active connections for the user will not be closed. 1 means the active ' +
+							'connections will be closed after a successful update. Default: the server configuration')
+
 	parserDeleteUser = subparsers.add_parser('delete-user', help='Delete an existing user')
 	parserDeleteUser.add_argument('id', type=int, help='User\'s ID to delete')
+	parserDeleteUser.add_argument('--disconnect', type=int, choices=[0, 1], default=None,
+							help='0 means the active connections for the user will not be closed. 1 means the active ' +
+							'connections will be closed after a successful delete. Default: the server configuration')
 
 	parserGetUsers = subparsers.add_parser('get-users', help='Returns an array with one or more SFTP users')
 	parserGetUsers.add_argument('-L', '--limit', type=int, default=100, choices=range(1, 501),
@@ -596,31 +1322,308 @@ if __name__ == '__main__':
 	parserGetUsers.add_argument('-U', '--username', type=str, default='', help='Default: %(default)s')
 	parserGetUsers.add_argument('-S', '--order', type=str, choices=['ASC', 'DESC'], default='ASC',
 							help='default: %(default)s')
+	parserGetUsers.add_argument('--order-by', type=str, default='', choices=['', 'username', 'id', 'last_login',
+							'expiration_date', 'used_quota_size', 'created_at'], help='Field to order the users by. ' +
+							'Default: username')
+	parserGetUsers.add_argument('--cursor', type=str, default='', help='Cursor returned for a previous page. ' +
+							'The order and the order field must be the same used for the previous page. Default: %(default)s')
+	addUsersFilterArguments(parserGetUsers)
+
+	parserBulkUsersOperations = subparsers.add_parser('bulk-users-operations', help='Add, update or disable ' +
+							'many users in a single request. Nothing is applied if an operation is invalid')
+	parserBulkUsersOperations.add_argument('operations_file', type=str, help='Path to a JSON file with the ' +
+							'operations to apply')
+	parserBulkUsersOperations.add_argument('--disconnect', type=int, choices=[0, 1], default=None,
+							help='0 means the active connections for the updated users will not be closed. 1 means the ' +
+							'active connections will be closed. Default: the server configuration')
+
+	parserBulkDeleteUsers = subparsers.add_parser('bulk-delete-users', help='Delete the users matching the given ' +
+							'filters, at least a filter is required')
+	parserBulkDeleteUsers.add_argument('--dry-run', dest='dry_run', action='store_true', default=False,
+							help='Return the matching users without deleting them. Default: %(default)s')
+	parserBulkDeleteUsers.add_argument('--disconnect', type=int, choices=[0, 1], default=None,
+							help='0 means the active connections for the deleted users will not be closed. 1 means the ' +
+							'active connections will be closed. Default: the server configuration')
+	addUsersFilterArguments(parserBulkDeleteUsers)
 
 	parserGetUserByID = subparsers.add_parser('get-user-by-id', help='Find user by ID')
 	parserGetUserByID.add_argument('id', type=int)
 
 	parserGetConnections = subparsers.add_parser('get-connections',
 													help='Get the active users and info about their uploads/downloads')
+	parserGetConnections.add_argument('--client-software', type=str, default='',
+									help='Return only the connections from this client software, for example WinSCP. '
+									+'Default: %(default)s')
+	parserGetConnections.add_argument('--tenant', type=str, default='',
+									help='Return only the connections for users with this tenant. Default: %(default)s')
+	parserGetConnections.add_argument('--label', type=str, default='',
+									help='Return only the connections with this label. Default: %(default)s')
+
+	parserUpdateConnectionLabel = subparsers.add_parser('update-connection-label',
+													help='Set a human readable label for an active connection')
+	parserUpdateConnectionLabel.add_argument('connectionID', type=str)
+	parserUpdateConnectionLabel.add_argument('label', type=str, nargs='?', default='',
+											help='Empty to remove the current label. Default: %(default)s')
 
 	parserCloseConnection = subparsers.add_parser('close-connection', help='Terminate an active SFTP/SCP connection')
 	parserCloseConnection.add_argument('connectionID', type=str)
 
+	parserCloseUserConnections = subparsers.add_parser('close-user-connections', help='Terminate all the active ' +
+													'connections for the given user')
+	parserCloseUserConnections.add_argument('username', type=str)
+
 	parserGetQuotaScans = subparsers.add_parser('get-quota-scans', help='Get the active quota scans')
 
 	parserStartQuotaScans = subparsers.add_parser('start-quota-scan', help='Start a new quota scan')
 	addCommonUserArguments(parserStartQuotaScans)
 
+	parserGetQuotaScan = subparsers.add_parser('get-quota-scan', help='Get the progress for the active quota scan ' +
+											'of the given user')
+	parserGetQuotaScan.add_argument('username', type=str)
+
+	parserStartUserFolderQuotaScan = subparsers.add_parser('start-user-folder-quota-scan', help='Start a new quota ' +
+														'scan limited to a virtual folder, referencing a shared folder, of the given user')
+	parserStartUserFolderQuotaScan.add_argument('username', type=str)
+	parserStartUserFolderQuotaScan.add_argument('virtual_path', type=str)
+
+	parserGetFolderQuotaScan = subparsers.add_parser('get-folder-quota-scan', help='Get the progress for the active ' +
+													'quota scan of the given shared folder')
+	parserGetFolderQuotaScan.add_argument('name', type=str)
+
 	parserGetVersion = subparsers.add_parser('get-version', help='Get version details')
 
 	parserGetProviderStatus = subparsers.add_parser('get-provider-status', help='Get data provider status')
 
-	parserDumpData = subparsers.add_parser('dumpdata', help='Backup SFTPGo data serializing them as JSON')
+	parserGetHealthStatus = subparsers.add_parser('get-health-status',
+											help='Check the data provider and the SFTP server, no authentication is required')
+
+	parserGetProviderMigrationReport = subparsers.add_parser('get-provider-migration-report',
+													help='Get the consistency report between the data provider in use and the migration target')
+
+	parserSyncProviderMigration = subparsers.add_parser('sync-provider-migration',
+													help='Copy the missing or different objects to the migration target')
+
+	parserGetPluginStatus = subparsers.add_parser('get-plugin-status', help='Get the health status for the configured plugins')
+
+	parserGetIPListEntries = subparsers.add_parser('get-iplist-entries',
+												help='Get the entries in the IP safe list and in the IP block list')
+	parserGetIPListEntries.add_argument('-T', '--type', type=str, choices=['safe', 'block'], default='',
+							help='Filter by list type. Default: no filter')
+
+	parserGetIPListEntryByID = subparsers.add_parser('get-iplist-entry-by-id', help='Find IP list entry by ID')
+	parserGetIPListEntryByID.add_argument('id', type=int)
+
+	parserAddIPListEntry = subparsers.add_parser('add-iplist-entry',
+												help='Add an IP address or network to the safe list or to the block list')
+	parserAddIPListEntry.add_argument('ipornet', type=str, help='IP address or network in CIDR notation')
+	parserAddIPListEntry.add_argument('-T', '--type', type=str, choices=['safe', 'block'], default='block',
+							help='Default: %(default)s')
+	parserAddIPListEntry.add_argument('-D', '--description', type=str, default='', help='Default: %(default)s')
+
+	parserUpdateIPListEntry = subparsers.add_parser('update-iplist-entry', help='Update an existing IP list entry')
+	parserUpdateIPListEntry.add_argument('id', type=int, help='IP list entry ID to update')
+	parserUpdateIPListEntry.add_argument('ipornet', type=str, help='IP address or network in CIDR notation')
+	parserUpdateIPListEntry.add_argument('-T', '--type', type=str, choices=['safe', 'block'], default='block',
+							help='Default: %(default)s')
+	parserUpdateIPListEntry.add_argument('-D', '--description', type=str, default='', help='Default: %(default)s')
+
+	parserDeleteIPListEntry = subparsers.add_parser('delete-iplist-entry', help='Delete an existing IP list entry')
+	parserDeleteIPListEntry.add_argument('id', type=int, help='IP list entry ID to delete')
+
+	parserImportIPList = subparsers.add_parser('import-iplist',
+											help='Import a set of IP addresses and networks into the safe list or into the block list')
+	parserImportIPList.add_argument('type', type=str, choices=['safe', 'block'])
+	parserImportIPList.add_argument('entries_file', type=str,
+							help='Text file with an IP address or a network in CIDR notation for each line')
+	parserImportIPList.add_argument('-D', '--description', type=str, default='',
+							help='Description for the imported entries. Default: %(default)s')
+	parserImportIPList.add_argument('--replace', dest='replace', action='store_true', default=False,
+							help='Remove the existing entries of the same type not included in the file. Default: %(default)s')
+
+	parserExportIPList = subparsers.add_parser('export-iplist', help='Export the entries in the safe list or in the block list')
+	parserExportIPList.add_argument('type', type=str, choices=['safe', 'block'])
+	parserExportIPList.add_argument('-F', '--format', type=str, choices=['json', 'text'], default='json',
+							help='Default: %(default)s')
+
+	parserGetIPListFeeds = subparsers.add_parser('get-iplist-feeds',
+											help='Get the status for the configured external block lists')
+
+	parserCheckIPAddress = subparsers.add_parser('check-ip',
+											help='Get the IP list entries and the external block lists matching an IP address')
+	parserCheckIPAddress.add_argument('ip', type=str)
+
+	parserUnblockIPAddress = subparsers.add_parser('unblock-ip', help='Allow the connections from an IP address')
+	parserUnblockIPAddress.add_argument('ip', type=str)
+
+	parserGetDefenderHosts = subparsers.add_parser('get-defender-hosts',
+											help='Get the hosts with a score or a ban')
+
+	parserGetDefenderHost = subparsers.add_parser('get-defender-host',
+											help='Get the score and the ban for an IP address')
+	parserGetDefenderHost.add_argument('ip', type=str)
+
+	parserBanDefenderHost = subparsers.add_parser('ban-defender-host', help='Ban an IP address')
+	parserBanDefenderHost.add_argument('ip', type=str)
+	parserBanDefenderHost.add_argument('-T', '--ban-time', type=int, default=0,
+							help='Ban time as minutes, 0 means the configured ban time. Default: %(default)s')
+
+	parserUnbanDefenderHost = subparsers.add_parser('unban-defender-host',
+											help='Remove the ban and the score for an IP address')
+	parserUnbanDefenderHost.add_argument('ip', type=str)
+
+	parserGetPlans = subparsers.add_parser('get-plans', help='Get the defined plans')
+
+	parserGetPlanByID = subparsers.add_parser('get-plan-by-id', help='Find plan by ID')
+	parserGetPlanByID.add_argument('id', type=int)
+
+	parserAddPlan = subparsers.add_parser('add-plan', help='Add a new plan')
+	parserAddPlan.add_argument('name', type=str)
+	addPlanArguments(parserAddPlan)
+
+	parserUpdatePlan = subparsers.add_parser('update-plan', help='Update an existing plan. The new limits are applied ' +
+											'to all the assigned users')
+	parserUpdatePlan.add_argument('id', type=int, help='Plan ID to update')
+	parserUpdatePlan.add_argument('name', type=str, help='The plan name cannot be changed')
+	addPlanArguments(parserUpdatePlan)
+	parserUpdatePlan.add_argument('--async', dest='async_update', action='store_true', default=False,
+								help='Update the assigned users in background. Default: %(default)s')
+
+	parserDeletePlan = subparsers.add_parser('delete-plan', help='Delete an existing plan')
+	parserDeletePlan.add_argument('id', type=int, help='Plan ID to delete')
+
+	parserGetPlanPropagations = subparsers.add_parser('get-plan-propagations',
+													help='Get the background jobs applying an updated plan to the assigned users')
+
+	parserGetPlanPropagation = subparsers.add_parser('get-plan-propagation',
+													help='Get the last propagation, with the affected users, for the given plan')
+	parserGetPlanPropagation.add_argument('name', type=str)
+
+	parserGetUserOverrides = subparsers.add_parser('get-user-overrides', help='Get the active temporary user overrides')
+
+	parserGetUserOverride = subparsers.add_parser('get-user-override',
+												help='Get the active temporary override for the given username')
+	parserGetUserOverride.add_argument('username', type=str)
+
+	parserAddUserOverride = subparsers.add_parser('add-user-override',
+												help='Temporarily override the quota and bandwidth limits for an ' +
+												'existing user. An active override for the same user is replaced')
+	parserAddUserOverride.add_argument('username', type=str)
+	parserAddUserOverride.add_argument('duration', type=int, help='Override duration in seconds')
+	parserAddUserOverride.add_argument('-S', '--quota-size', type=int, default=None,
+									help='Quota as size in bytes. 0 means unlimited. If not set the user quota size is ' +
+									'not overridden')
+	parserAddUserOverride.add_argument('-F', '--quota-files', type=int, default=None,
+									help='Quota as number of files. 0 means unlimited. If not set the user quota files ' +
+									'is not overridden')
+	parserAddUserOverride.add_argument('-U', '--upload-bandwidth', type=int, default=None,
+									help='Maximum upload bandwidth as KB/s, 0 means unlimited. If not set the user ' +
+									'upload bandwidth is not overridden')
+	parserAddUserOverride.add_argument('-D', '--download-bandwidth', type=int, default=None,
+									help='Maximum download bandwidth as KB/s, 0 means unlimited. If not set the user ' +
+									'download bandwidth is not overridden')
+	parserAddUserOverride.add_argument('-R', '--reason', type=str, default='', help='Default: %(default)s')
+
+	parserDeleteUserOverride = subparsers.add_parser('delete-user-override',
+												help='Remove the active temporary override for the given username')
+	parserDeleteUserOverride.add_argument('username', type=str)
+
+	parserGetUserOverridesAudit = subparsers.add_parser('get-user-overrides-audit',
+													help='Get the audit records for the temporary user overrides')
+
+	parserGetStaleFilesReport = subparsers.add_parser('get-stale-files-report',
+												help='Get the files not modified for the specified number of days. ' +
+												'Nothing is deleted')
+	parserGetStaleFilesReport.add_argument('-O', '--older-than-days', type=int, default=None,
+										help='If not set the configured value is used')
+	parserGetStaleFilesReport.add_argument('-T', '--top-files', type=int, default=None,
+										help='Number of largest stale files to report for each user. If not set the ' +
+										'configured value is used')
+	parserGetStaleFilesReport.add_argument('-U', '--username', type=str, default='',
+										help='Generate the report for this user only. Default: all users')
+
+	parserGetUsersActivity = subparsers.add_parser('get-users-activity',
+												help='Get the logins and transfers counts, bucketed by hour of the ' +
+												'week, for all the users')
+
+	parserGetUserActivity = subparsers.add_parser('get-user-activity',
+												help='Get the logins and transfers counts, bucketed by hour of the ' +
+												'week, for the given user')
+	parserGetUserActivity.add_argument('username', type=str)
+
+	parserGetInactiveUsersReport = subparsers.add_parser('get-inactive-users-report',
+												help='Get the users the inactivity policy would warn, disable or ' +
+												'expire now. The users are not modified')
+	parserGetInactiveUsersReport.add_argument('--inactive-days', type=int, default=None,
+											help='Override the configured inactive days. Default: the server configuration')
+	parserGetInactiveUsersReport.add_argument('--grace-days', type=int, default=None,
+											help='Override the configured grace days. Default: the server configuration')
+	parserGetInactiveUsersReport.add_argument('--action', type=int, choices=[0, 1], default=None,
+											help='Override the configured action, 0 disable, 1 expire. Default: the server ' +
+											'configuration')
+
+	parserGetDuplicatesScans = subparsers.add_parser('get-duplicates-scans',
+												help='Get the running duplicate files scans and the finished ones')
+
+	parserGetDuplicatesScan = subparsers.add_parser('get-duplicates-scan',
+												help='Get the duplicate files scan, and the duplicate sets, for the ' +
+												'given user')
+	parserGetDuplicatesScan.add_argument('username', type=str)
+
+	parserStartDuplicatesScan = subparsers.add_parser('start-duplicates-scan',
+													help='Start a new duplicate files scan for the given user')
+	parserStartDuplicatesScan.add_argument('username', type=str)
+
+	parserCancelDuplicatesScan = subparsers.add_parser('cancel-duplicates-scan',
+													help='Cancel a running duplicate files scan or remove the ' +
+													'results of a finished one')
+	parserCancelDuplicatesScan.add_argument('username', type=str)
+
+	parserGetOffboardings = subparsers.add_parser('get-offboardings', help='Get the running users offboardings and ' +
+												'the finished ones')
+
+	parserGetOffboarding = subparsers.add_parser('get-offboarding', help='Get the offboarding for the given user')
+	parserGetOffboarding.add_argument('username', type=str)
+
+	parserStartOffboarding = subparsers.add_parser('start-offboarding', help='Snapshot the user configuration, disable ' +
+												'the user, revoke its public keys, close its connections and ' +
+												'optionally archive its files')
+	parserStartOffboarding.add_argument('username', type=str)
+	parserStartOffboarding.add_argument('--archive-home', dest='archive_home', action='store_true', default=False,
+									help='Archive the user files inside the configured bucket. Default: %(default)s')
+	parserStartOffboarding.add_argument('--reason', type=str, default='', help='Reason for the offboarding, it is ' +
+									'included in the logs. Default: %(default)s')
+
+	parserTestHooks = subparsers.add_parser('test-hooks', help='Send a synthetic event to the configured hooks and ' +
+										'get the response, the latency and the error, if any, for each of them')
+	parserTestHooks.add_argument('hook', type=str, choices=['actions', 'provider_actions'])
+	parserTestHooks.add_argument('event', type=str,
+								choices=['download', 'upload', 'delete', 'rename', 'ssh_cmd', 'ssh_exec', 'slow_transfer', 'add',
+										'update', 'offboard'],
+								help='download, upload, delete, rename, ssh_cmd, ssh_exec and slow_transfer are supported for ' +
+								'"actions", add, update, delete and offboard for "provider_actions"')
+	parserTestHooks.add_argument('-U', '--username', type=str, default='', help='Username for the synthetic user ' +
+								'included in the event. Default: sftpgo_hook_test')
+
+	parserSimulateLogin = subparsers.add_parser('simulate-login', help='Check the given credentials using the full ' +
+											'authentication pipeline without opening a session and get the decision ' +
+											'and the check that refused the login, if any')
+	parserSimulateLogin.add_argument('username', type=str)
+	parserSimulateLogin.add_argument('-P', '--password', type=str, default='', help='Default: %(default)s')
+	parserSimulateLogin.add_argument('-K', '--public-key-file', type=str, default='', help='Public key file in ' +
+									'authorized_keys format. Default: %(default)s')
+	parserSimulateLogin.add_argument('--ip', type=str, default='', help='Client IP address. If empty the IP based ' +
+									'filters are not checked. Default: %(default)s')
+	parserSimulateLogin.add_argument('--port', type=int, default=0, help='Port for the SFTP binding to check the ' +
+									'login policy for. 0 means the main binding. Default: %(default)s')
+
+	parserDumpData = subparsers.add_parser('dumpdata', help='Backup SFTPGo data serializing them as JSON, YAML or CSV')
 	parserDumpData.add_argument('output_file', type=str)
 	parserDumpData.add_argument('-I', '--indent', type=int, choices=[0, 1], default=0,
 							help='0 means no indentation. 1 means format the output JSON. Default: %(default)s')
+	parserDumpData.add_argument('-F', '--format', type=str, choices=['json', 'yaml', 'csv'], default='',
+							help='Output format. If not set it is detected using the output file extension')
 
-	parserLoadData = subparsers.add_parser('loaddata', help='Restore SFTPGo data from a JSON backup')
+	parserLoadData = subparsers.add_parser('loaddata', help='Restore SFTPGo data from a JSON, YAML or CSV backup')
 	parserLoadData.add_argument('input_file', type=str)
 	parserLoadData.add_argument('-Q', '--scan-quota', type=int, choices=[0, 1, 2], default=0,
 							help='0 means no quota scan after a user is added/updated. 1 means always scan quota. 2 ' +
@@ -628,6 +1631,13 @@ if __name__ == '__main__':
 	parserLoadData.add_argument('-M', '--mode', type=int, choices=[0, 1], default=0,
 							help='0 means new users are added, existing users are updated. 1 means new users are added,' +
 							' existing users are not modified. Default: %(default)s')
+	parserLoadData.add_argument('-F', '--format', type=str, choices=['json', 'yaml', 'csv'], default='',
+							help='Input format. If not set it is detected using the input file extension')
+	parserLoadData.add_argument('--csv-columns', type=str, default='',
+							help='Mapping between the CSV headers and the user fields, for example ' +
+							'"Login=username,Home directory=home_dir"')
+	parserLoadData.add_argument('--upload', dest='upload', action='store_true',
+							help='Upload the local input file instead of reading it on the server. Default: %(default)s')
 
 	parserConvertUsers = subparsers.add_parser('convert-users', help='Convert users to a JSON format suitable to use ' +
 											'with loadddata')
@@ -663,7 +1673,19 @@ if __name__ == '__main__':
 				args.s3_endpoint, args.s3_storage_class, args.s3_key_prefix, args.gcs_bucket, args.gcs_key_prefix,
 				args.gcs_storage_class, args.gcs_credentials_file, args.gcs_automatic_credentials,
 				args.denied_login_methods, args.virtual_folders, args.denied_extensions, args.allowed_extensions,
-				args.s3_upload_part_size, args.s3_upload_concurrency)
+				args.s3_upload_part_size, args.s3_upload_concurrency, args.revoked_key_fingerprints,
+				args.allowed_key_algorithms, args.min_rsa_key_size, args.plan, args.crypt_passphrase,
+				args.webdav_endpoint, args.webdav_username, args.webdav_password, args.webdav_bearer_token,
+				args.webdav_root_path, args.hdfs_endpoint, args.hdfs_username, args.hdfs_delegation_token,
+				args.hdfs_root_path, args.gdrive_folder_id, args.gdrive_credentials_file, args.gdrive_subject,
+				args.gdrive_client_id, args.gdrive_client_secret, args.gdrive_refresh_token, args.gdrive_endpoint,
+				args.dropbox_root_path, args.dropbox_access_token, args.dropbox_refresh_token, args.dropbox_app_key,
+				args.dropbox_app_secret, args.dropbox_upload_chunk_size, args.dropbox_endpoint,
+				args.s3_storage_class_rules, args.gcs_storage_class_rules, args.ingestion_folders, args.s3_session_token,
+				args.s3_role_arn, args.s3_external_id, args.tenant, args.s3_force_path_style,
+				args.s3_skip_tls_verify, args.s3_ca_bundle_file, args.s3_download_part_size,
+				args.s3_download_concurrency, args.gcs_download_part_size, args.gcs_download_concurrency,
+				args.read_only, args.additional_info, args.allowed_ssh_commands)
 	elif args.command == 'update-user':
 		api.updateUser(args.id, args.username, args.password, args.public_keys, args.home_dir, args.uid, args.gid,
 					args.max_sessions, args.quota_size, args.quota_files, args.permissions, args.upload_bandwidth,
@@ -673,29 +1695,153 @@ if __name__ == '__main__':
 					args.s3_key_prefix, args.gcs_bucket, args.gcs_key_prefix, args.gcs_storage_class,
 					args.gcs_credentials_file, args.gcs_automatic_credentials, args.denied_login_methods,
 					args.virtual_folders, args.denied_extensions, args.allowed_extensions, args.s3_upload_part_size,
-					args.s3_upload_concurrency)
+					args.s3_upload_concurrency, args.disconnect, args.revoked_key_fingerprints,
+					args.allowed_key_algorithms, args.min_rsa_key_size, args.plan, args.crypt_passphrase,
+					args.webdav_endpoint, args.webdav_username, args.webdav_password, args.webdav_bearer_token,
+					args.webdav_root_path, args.hdfs_endpoint, args.hdfs_username, args.hdfs_delegation_token,
+					args.hdfs_root_path, args.gdrive_folder_id, args.gdrive_credentials_file, args.gdrive_subject,
+					args.gdrive_client_id, args.gdrive_client_secret, args.gdrive_refresh_token,
+					args.gdrive_endpoint, args.dropbox_root_path, args.dropbox_access_token, args.dropbox_refresh_token,
+					args.dropbox_app_key, args.dropbox_app_secret, args.dropbox_upload_chunk_size, args.dropbox_endpoint,
+					args.s3_storage_class_rules, args.gcs_storage_class_rules, args.ingestion_folders,
+					args.s3_session_token, args.s3_role_arn, args.s3_external_id, args.tenant,
+					args.s3_force_path_style, args.s3_skip_tls_verify, args.s3_ca_bundle_file,
+					args.s3_download_part_size, args.s3_download_concurrency, args.gcs_download_part_size,
+					args.gcs_download_concurrency, args.read_only, args.additional_info, args.allowed_ssh_commands)
+	elif args.command == 'patch-user':
+		api.patchUser(args.id, args.patch, args.disconnect)
 	elif args.command == 'delete-user':
-		api.deleteUser(args.id)
+		api.deleteUser(args.id, args.disconnect)
 	elif args.command == 'get-users':
-		api.getUsers(args.limit, args.offset, args.order, args.username)
+		api.getUsers(args.limit, args.offset, args.order, args.username, args.order_by, args.cursor, args.status,
+					getDatetimeAsMillisSinceEpoch(args.expires_after), getDatetimeAsMillisSinceEpoch(args.expires_before),
+					args.fs_provider, args.min_used_quota_size, args.min_quota_usage_percent,
+					args.last_login_older_than_days)
+	elif args.command == 'bulk-users-operations':
+		api.bulkUsersOperations(args.operations_file, args.disconnect)
+	elif args.command == 'bulk-delete-users':
+		api.bulkDeleteUsers(args.dry_run, args.disconnect, args.status, getDatetimeAsMillisSinceEpoch(args.expires_after),
+						getDatetimeAsMillisSinceEpoch(args.expires_before), args.fs_provider, args.min_used_quota_size,
+						args.min_quota_usage_percent, args.last_login_older_than_days)
 	elif args.command == 'get-user-by-id':
 		api.getUserByID(args.id)
 	elif args.command == 'get-connections':
-		api.getConnections()
+		api.getConnections(args.client_software, args.tenant, args.label)
+	elif args.command == 'update-connection-label':
+		api.updateConnectionLabel(args.connectionID, args.label)
 	elif args.command == 'close-connection':
 		api.closeConnection(args.connectionID)
+	elif args.command == 'close-user-connections':
+		api.closeUserConnections(args.username)
 	elif args.command == 'get-quota-scans':
 		api.getQuotaScans()
 	elif args.command == 'start-quota-scan':
 		api.startQuotaScan(args.username)
+	elif args.command == 'get-quota-scan':
+		api.getQuotaScan(args.username)
+	elif args.command == 'start-user-folder-quota-scan':
+		api.startUserFolderQuotaScan(args.username, args.virtual_path)
+	elif args.command == 'get-folder-quota-scan':
+		api.getFolderQuotaScan(args.name)
 	elif args.command == 'get-version':
 		api.getVersion()
 	elif args.command == 'get-provider-status':
 		api.getProviderStatus()
+	elif args.command == 'get-health-status':
+		api.getHealthStatus()
+	elif args.command == 'get-provider-migration-report':
+		api.getProviderMigrationReport()
+	elif args.command == 'sync-provider-migration':
+		api.syncProviderMigration()
+	elif args.command == 'get-plugin-status':
+		api.getPluginStatus()
+	elif args.command == 'get-iplist-entries':
+		api.getIPListEntries(args.type)
+	elif args.command == 'get-iplist-entry-by-id':
+		api.getIPListEntryByID(args.id)
+	elif args.command == 'add-iplist-entry':
+		api.addIPListEntry(args.ipornet, args.type, args.description)
+	elif args.command == 'update-iplist-entry':
+		api.updateIPListEntry(args.id, args.ipornet, args.type, args.description)
+	elif args.command == 'delete-iplist-entry':
+		api.deleteIPListEntry(args.id)
+	elif args.command == 'import-iplist':
+		api.importIPList(args.type, args.entries_file, args.description, args.replace)
+	elif args.command == 'export-iplist':
+		api.exportIPList(args.type, args.format)
+	elif args.command == 'get-iplist-feeds':
+		api.getIPListFeeds()
+	elif args.command == 'check-ip':
+		api.checkIPAddress(args.ip)
+	elif args.command == 'unblock-ip':
+		api.unblockIPAddress(args.ip)
+	elif args.command == 'get-defender-hosts':
+		api.getDefenderHosts()
+	elif args.command == 'get-defender-host':
+		api.getDefenderHost(args.ip)
+	elif args.command == 'ban-defender-host':
+		api.banDefenderHost(args.ip, args.ban_time)
+	elif args.command == 'unban-defender-host':
+		api.unbanDefenderHost(args.ip)
+	elif args.command == 'get-plans':
+		api.getPlans()
+	elif args.command == 'get-plan-by-id':
+		api.getPlanByID(args.id)
+	elif args.command == 'add-plan':
+		api.addPlan(args.name, args.description, args.max_sessions, args.quota_size, args.quota_files,
+				args.upload_bandwidth, args.download_bandwidth, args.allowed_fs_providers, args.denied_login_methods,
+				args.password_breach_check)
+	elif args.command == 'update-plan':
+		api.updatePlan(args.id, args.name, args.description, args.max_sessions, args.quota_size, args.quota_files,
+					args.upload_bandwidth, args.download_bandwidth, args.allowed_fs_providers, args.denied_login_methods,
+					args.async_update, args.password_breach_check)
+	elif args.command == 'delete-plan':
+		api.deletePlan(args.id)
+	elif args.command == 'get-plan-propagations':
+		api.getPlanPropagations()
+	elif args.command == 'get-plan-propagation':
+		api.getPlanPropagation(args.name)
+	elif args.command == 'get-user-overrides':
+		api.getUserOverrides()
+	elif args.command == 'get-user-override':
+		api.getUserOverride(args.username)
+	elif args.command == 'add-user-override':
+		api.addUserOverride(args.username, args.duration, args.quota_size, args.quota_files, args.upload_bandwidth,
+						args.download_bandwidth, args.reason)
+	elif args.command == 'delete-user-override':
+		api.deleteUserOverride(args.username)
+	elif args.command == 'get-user-overrides-audit':
+		api.getUserOverridesAudit()
+	elif args.command == 'get-stale-files-report':
+		api.getStaleFilesReport(args.older_than_days, args.top_files, args.username)
+	elif args.command == 'get-users-activity':
+		api.getUsersActivity()
+	elif args.command == 'get-user-activity':
+		api.getUserActivity(args.username)
+	elif args.command == 'get-inactive-users-report':
+		api.getInactiveUsersReport(args.inactive_days, args.grace_days, args.action)
+	elif args.command == 'get-duplicates-scans':
+		api.getDuplicatesScans()
+	elif args.command == 'get-duplicates-scan':
+		api.getDuplicatesScan(args.username)
+	elif args.command == 'start-duplicates-scan':
+		api.startDuplicatesScan(args.username)
+	elif args.command == 'cancel-duplicates-scan':
+		api.cancelDuplicatesScan(args.username)
+	elif args.command == 'get-offboardings':
+		api.getOffboardings()
+	elif args.command == 'get-offboarding':
+		api.getOffboarding(args.username)
+	elif args.command == 'start-offboarding':
+		api.startOffboarding(args.username, args.archive_home, args.reason)
+	elif args.command == 'test-hooks':
+		api.testHooks(args.hook, args.event, args.username)
+	elif args.command == 'simulate-login':
+		api.simulateLogin(args.username, args.password, args.public_key_file, args.ip, args.port)
 	elif args.command == 'dumpdata':
-		api.dumpData(args.output_file, args.indent)
+		api.dumpData(args.output_file, args.indent, args.format)
 	elif args.command == 'loaddata':
-		api.loadData(args.input_file, args.scan_quota, args.mode)
+		api.loadData(args.input_file, args.scan_quota, args.mode, args.format, args.csv_columns, args.upload)
 	elif args.command == 'convert-users':
 		convertUsers = ConvertUsers(args.input_file, args.users_format, args.output_file, args.min_uid, args.max_uid,
 								args.usernames, args.force_uid, args.force_gid)
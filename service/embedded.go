@@ -0,0 +1,181 @@
+package service
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/httpd"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/ldapd"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/sftpd"
+)
+
+var (
+	embeddedMutex   sync.Mutex
+	embeddedStarted bool
+)
+
+// EmbeddedConfig defines the configuration for an SFTPGo server embedded inside another Go application
+type EmbeddedConfig struct {
+	// Base directory for the relative paths, for example the host keys, the SQLite database
+	// and the configuration file
+	ConfigDir string
+	// Name of the configuration file to load, without extension. Empty means that the default
+	// configuration is used
+	ConfigFile string
+	// The following configurations, if not nil, replace the ones loaded from the configuration file
+	SFTPD        *sftpd.Configuration
+	HTTPD        *httpd.Conf
+	DataProvider *dataprovider.Config
+	Plugins      []plugin.Config
+	KMS          *kms.Config
+	LDAPD        *ldapd.Configuration
+	// Path to the log file. Empty disables the logs
+	LogFilePath   string
+	LogMaxSize    int
+	LogMaxBackups int
+	LogMaxAge     int
+	LogCompress   bool
+	LogVerbose    bool
+	// In-process hooks for the file actions and for the users actions. They are executed
+	// for all the actions, even if they are not included in execute_on
+	ActionHooks     []sftpd.ActionHook
+	UserActionHooks []dataprovider.UserActionHook
+}
+
+func (c *EmbeddedConfig) apply() {
+	if len(c.ConfigFile) > 0 {
+		config.LoadConfig(c.ConfigDir, c.ConfigFile)
+	}
+	if c.SFTPD != nil {
+		config.SetSFTPDConfig(*c.SFTPD)
+	}
+	if c.HTTPD != nil {
+		config.SetHTTPDConfig(*c.HTTPD)
+	}
+	if c.DataProvider != nil {
+		config.SetProviderConf(*c.DataProvider)
+	}
+	if c.Plugins != nil {
+		config.SetPluginsConfig(c.Plugins)
+	}
+	if c.KMS != nil {
+		config.SetKMSConfig(*c.KMS)
+	}
+	if c.LDAPD != nil {
+		config.SetLDAPDConfig(*c.LDAPD)
+	}
+	for _, hook := range c.ActionHooks {
+		sftpd.RegisterActionHook(hook)
+	}
+	for _, hook := range c.UserActionHooks {
+		dataprovider.RegisterUserActionHook(hook)
+	}
+}
+
+// Server is an SFTPGo server embedded inside another Go application
+type Server struct {
+	service  *Service
+	done     chan struct{}
+	events   *eventsBroker
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// Start starts an SFTPGo server, embedded inside the calling process, and returns a handle to stop
+// it and to query its connections. The configuration is global for the process, so only one
+// server can be started and it cannot be restarted after Stop
+func Start(c EmbeddedConfig) (*Server, error) {
+	embeddedMutex.Lock()
+	defer embeddedMutex.Unlock()
+
+	if embeddedStarted {
+		return nil, errors.New("an embedded server was already started in this process")
+	}
+	s := &Server{
+		service: &Service{
+			ConfigDir:     c.ConfigDir,
+			ConfigFile:    c.ConfigFile,
+			LogFilePath:   c.LogFilePath,
+			LogMaxSize:    c.LogMaxSize,
+			LogMaxBackups: c.LogMaxBackups,
+			LogMaxAge:     c.LogMaxAge,
+			LogCompress:   c.LogCompress,
+			LogVerbose:    c.LogVerbose,
+			// the SFTP, the HTTP and the LDAP servers send on this channel when they exit
+			Shutdown: make(chan bool, 3),
+			embedded: &c,
+		},
+		done:   make(chan struct{}),
+		events: newEventsBroker(),
+	}
+	if err := s.service.Start(); err != nil {
+		return nil, err
+	}
+	embeddedStarted = true
+	go func() {
+		select {
+		case <-s.service.Shutdown:
+			logger.Warn(logSender, "", "embedded server exited, stopping")
+			s.Stop()
+		case <-s.done:
+		}
+	}()
+	return s, nil
+}
+
+// Stop stops the SFTP, the HTTP and the LDAP servers, closes the active connections and the events
+// subscriptions and releases the data provider resources. It is safe to call Stop more than once
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		sftpd.StopServer()
+		if err := httpd.StopServer(); err != nil {
+			logger.Warn(logSender, "", "unable to stop the HTTP server: %v", err)
+		}
+		ldapd.StopServer()
+		plugin.Stop()
+		s.events.close()
+		if err := dataprovider.Flush(); err != nil {
+			logger.Warn(logSender, "", "unable to save the data provider pending changes: %v", err)
+			s.stopErr = err
+		}
+		if err := dataprovider.Close(dataprovider.GetProvider()); err != nil && s.stopErr == nil {
+			s.stopErr = err
+		}
+		close(s.done)
+		logger.Debug(logSender, "", "embedded server stopped")
+	})
+	return s.stopErr
+}
+
+// Done returns a channel closed when the server stops
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// GetDataProvider returns the data provider, it can be used to manage the users with the
+// dataprovider package functions
+func (s *Server) GetDataProvider() dataprovider.Provider {
+	return dataprovider.GetProvider()
+}
+
+// GetConnections returns the active connections
+func (s *Server) GetConnections() []sftpd.ConnectionStatus {
+	return sftpd.GetConnectionsStats()
+}
+
+// CloseConnection closes the connection with the given ID. It returns false if the connection
+// does not exist
+func (s *Server) CloseConnection(connectionID string) bool {
+	return sftpd.CloseActiveConnection(connectionID)
+}
+
+// CloseUserConnections closes the active connections for the given username, as described
+// for sftpd.CloseUserConnections, and returns their number
+func (s *Server) CloseUserConnections(username string) int {
+	return sftpd.CloseUserConnections(username)
+}
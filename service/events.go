@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/sftpd"
+)
+
+// Supported event sources
+const (
+	// EventSourceFs identifies the file actions: upload, download, delete, rename, ssh_cmd, ssh_exec and slow_transfer
+	EventSourceFs = "fs"
+	// EventSourceProvider identifies the users actions: add, update, delete and offboard
+	EventSourceProvider = "provider"
+)
+
+const defaultEventsBufferSize = 100
+
+// Event is an event notified to the subscribers of an embedded server. The payloads are the
+// same sent to the configured http_notification_url
+type Event struct {
+	// EventSourceFs or EventSourceProvider
+	Source string
+	// Action name, for example "upload" or "add"
+	Action string
+	// File action, set for the EventSourceFs events
+	FsAction *sftpd.ActionNotification
+	// Affected user, set for the EventSourceProvider events. The sensitive data are hidden.
+	// The user is shared among the subscribers and must not be modified
+	User *dataprovider.User
+}
+
+// AsJSON returns the event payload as JSON, as sent to the http_notification_url
+func (e *Event) AsJSON() []byte {
+	if e.FsAction != nil {
+		return e.FsAction.AsJSON()
+	}
+	if e.User != nil {
+		result, _ := json.Marshal(e.User)
+		return result
+	}
+	return nil
+}
+
+// Subscription is a subscription to the events of an embedded server, see Server.Subscribe
+type Subscription struct {
+	// dropped is accessed atomically, it must be the first field for the 64 bit alignment
+	dropped int64
+	// C receives the events, it is closed after Unsubscribe or when the server stops
+	C        <-chan Event
+	ch       chan Event
+	callback func(Event)
+	sources  []string
+	broker   *eventsBroker
+}
+
+// Unsubscribe stops the events delivery. It is safe to call Unsubscribe more than once
+func (s *Subscription) Unsubscribe() {
+	s.broker.remove(s)
+}
+
+// Dropped returns the number of events not delivered because the channel buffer was full
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *Subscription) accept(source string) bool {
+	if len(s.sources) == 0 {
+		return true
+	}
+	for _, src := range s.sources {
+		if src == source {
+			return true
+		}
+	}
+	return false
+}
+
+type eventsBroker struct {
+	sync.RWMutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+	registerOnce  sync.Once
+}
+
+func newEventsBroker() *eventsBroker {
+	return &eventsBroker{
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+func (b *eventsBroker) add(s *Subscription) {
+	// the hooks cannot be removed, we register them only if there are subscribers so the
+	// users to notify are not read back from the data provider if nobody is interested
+	b.registerOnce.Do(func() {
+		sftpd.RegisterActionHook(b.onFsAction)
+		dataprovider.RegisterUserActionHook(b.onUserAction)
+	})
+	b.Lock()
+	defer b.Unlock()
+
+	s.broker = b
+	if b.closed {
+		if s.ch != nil {
+			close(s.ch)
+		}
+		return
+	}
+	b.subscriptions[s] = true
+}
+
+func (b *eventsBroker) remove(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, ok := b.subscriptions[s]; !ok {
+		return
+	}
+	delete(b.subscriptions, s)
+	if s.ch != nil {
+		close(s.ch)
+	}
+}
+
+func (b *eventsBroker) close() {
+	b.Lock()
+	defer b.Unlock()
+
+	for s := range b.subscriptions {
+		delete(b.subscriptions, s)
+		if s.ch != nil {
+			close(s.ch)
+		}
+	}
+	b.closed = true
+}
+
+func (b *eventsBroker) onFsAction(a sftpd.ActionNotification) {
+	b.dispatch(Event{
+		Source:   EventSourceFs,
+		Action:   a.Action,
+		FsAction: &a,
+	})
+}
+
+func (b *eventsBroker) onUserAction(operation string, user dataprovider.User) {
+	dataprovider.HideUserSensitiveData(&user)
+	b.dispatch(Event{
+		Source: EventSourceProvider,
+		Action: operation,
+		User:   &user,
+	})
+}
+
+func (b *eventsBroker) dispatch(e Event) {
+	var callbacks []func(Event)
+
+	b.RLock()
+	for s := range b.subscriptions {
+		if !s.accept(e.Source) {
+			continue
+		}
+		if s.callback != nil {
+			callbacks = append(callbacks, s.callback)
+			continue
+		}
+		// the lock prevents a send on a channel closed by Unsubscribe
+		select {
+		case s.ch <- e:
+		default:
+			if atomic.AddInt64(&s.dropped, 1) == 1 {
+				logger.Warn(logSender, "", "events subscription buffer full, event %#v for source %#v dropped",
+					e.Action, e.Source)
+			}
+		}
+	}
+	b.RUnlock()
+
+	// the callbacks are executed without holding the lock, so they can unsubscribe
+	for _, callback := range callbacks {
+		callback(e)
+	}
+}
+
+// Subscribe returns a subscription that receives, on its channel, the events for the given sources,
+// EventSourceFs and/or EventSourceProvider, no source means all the events. The channel has the
+// given buffer size, 0 means a default size, and the events are dropped, instead of blocking the
+// server, if the subscriber does not keep up
+func (s *Server) Subscribe(bufferSize int, sources ...string) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventsBufferSize
+	}
+	ch := make(chan Event, bufferSize)
+	sub := &Subscription{
+		C:       ch,
+		ch:      ch,
+		sources: sources,
+	}
+	s.events.add(sub)
+	return sub
+}
+
+// SubscribeFunc registers a callback executed for each event for the given sources, no source
+// means all the events. The callback is executed synchronously, inside the goroutine that
+// notifies the event, so it must not block. The returned subscription has no channel
+func (s *Server) SubscribeFunc(callback func(e Event), sources ...string) *Subscription {
+	sub := &Subscription{
+		callback: callback,
+		sources:  sources,
+	}
+	s.events.add(sub)
+	return sub
+}
@@ -14,7 +14,9 @@ import (
 	"github.com/drakkan/sftpgo/config"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/httpd"
+	"github.com/drakkan/sftpgo/ldapd"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/grandcat/zeroconf"
@@ -43,6 +45,8 @@ type Service struct {
 	PortableUser  dataprovider.User
 	Profiler      bool
 	Shutdown      chan bool
+	// configuration for an embedded server, see the Start function
+	embedded *EmbeddedConfig
 }
 
 // Start initializes the service
@@ -60,30 +64,52 @@ func (s *Service) Start() error {
 		if len(s.LogFilePath) == 0 {
 			logger.DisableLogger()
 		}
+	} else if s.embedded != nil && len(s.LogFilePath) == 0 {
+		logger.DisableLogger()
 	}
 	version := utils.GetAppVersion()
-	logger.Info(logSender, "", "starting SFTPGo %v, config dir: %v, config file: %v, log max size: %v log max backups: %v "+
-		"log max age: %v log verbose: %v, log compress: %v, profile: %v", version.GetVersionAsString(), s.ConfigDir, s.ConfigFile,
-		s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose, s.LogCompress, s.Profiler)
+	logger.Info(logSender, "", "starting SFTPGo %v, features: %v, config dir: %v, config file: %v, log max size: %v "+
+		"log max backups: %v log max age: %v log verbose: %v, log compress: %v, profile: %v", version.GetVersionAsString(),
+		version.GetFeaturesAsString(), s.ConfigDir, s.ConfigFile, s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose,
+		s.LogCompress, s.Profiler)
 	// in portable mode we don't read configuration from file
-	if s.PortableMode != 1 {
+	if s.embedded != nil {
+		s.embedded.apply()
+	} else if s.PortableMode != 1 {
 		config.LoadConfig(s.ConfigDir, s.ConfigFile)
 	}
 	providerConf := config.GetProviderConf()
 
-	err := dataprovider.Initialize(providerConf, s.ConfigDir)
+	// the HTTP clients are used by the data provider to download the IP list feeds and by the http provider
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.Initialize(s.ConfigDir)
+
+	// the master keys are required to decrypt the secrets stored inside the data provider
+	err := config.GetKMSConfig().Initialize(s.ConfigDir)
+	if err != nil {
+		logger.Error(logSender, "", "error initializing the master keys: %v", err)
+		logger.ErrorToConsole("error initializing the master keys: %v", err)
+		return err
+	}
+
+	err = dataprovider.Initialize(providerConf, s.ConfigDir)
 	if err != nil {
 		logger.Error(logSender, "", "error initializing data provider: %v", err)
 		logger.ErrorToConsole("error initializing data provider: %v", err)
 		return err
 	}
 
-	httpConfig := config.GetHTTPConfig()
-	httpConfig.Initialize(s.ConfigDir)
+	err = plugin.Initialize(config.GetPluginsConfig())
+	if err != nil {
+		logger.Error(logSender, "", "error initializing plugins: %v", err)
+		logger.ErrorToConsole("error initializing plugins: %v", err)
+		return err
+	}
 
 	dataProvider := dataprovider.GetProvider()
 	sftpdConf := config.GetSFTPDConfig()
 	httpdConf := config.GetHTTPDConfig()
+	ldapdConf := config.GetLDAPDConfig()
 
 	if s.PortableMode == 1 {
 		// create the user for portable mode
@@ -96,6 +122,10 @@ func (s *Service) Start() error {
 
 	sftpd.SetDataProvider(dataProvider)
 
+	services := s.getEnabledServices(sftpdConf, httpdConf, ldapdConf, providerConf)
+	utils.SetEnabledServices(services)
+	logger.Info(logSender, "", "enabled services: %v", strings.Join(services, " "))
+
 	go func() {
 		logger.Debug(logSender, "", "initializing SFTP server with config %+v", sftpdConf)
 		if err := sftpdConf.Initialize(s.ConfigDir); err != nil {
@@ -121,19 +151,90 @@ func (s *Service) Start() error {
 			logger.DebugToConsole("HTTP server not started, disabled in config file")
 		}
 	}
+
+	if ldapdConf.BindPort > 0 {
+		ldapd.SetDataProvider(dataProvider)
+
+		go func() {
+			if err := ldapdConf.Initialize(s.ConfigDir); err != nil {
+				logger.Error(logSender, "", "could not start LDAP server: %v", err)
+				logger.ErrorToConsole("could not start LDAP server: %v", err)
+			}
+			s.Shutdown <- true
+		}()
+	}
 	return nil
 }
 
+// getEnabledServices returns the services enabled at runtime, they are reported in the
+// logs and by the version API
+func (s *Service) getEnabledServices(sftpdConf sftpd.Configuration, httpdConf httpd.Conf,
+	ldapdConf ldapd.Configuration, providerConf dataprovider.Config) []string {
+	services := []string{
+		fmt.Sprintf("sftp:%v:%v", sftpdConf.BindAddress, sftpdConf.BindPort),
+	}
+	if utils.IsStringInSlice("scp", sftpdConf.EnabledSSHCommands) || utils.IsStringInSlice("*", sftpdConf.EnabledSSHCommands) {
+		services = append(services, "scp")
+	}
+	if httpdConf.BindPort > 0 {
+		services = append(services, fmt.Sprintf("http:%v:%v", httpdConf.BindAddress, httpdConf.BindPort))
+		if httpdConf.GRPCBindPort > 0 {
+			services = append(services, fmt.Sprintf("grpc:%v:%v", httpdConf.GRPCBindAddress, httpdConf.GRPCBindPort))
+		}
+		if s.Profiler {
+			services = append(services, "profiler")
+		}
+	}
+	if ldapdConf.BindPort > 0 {
+		services = append(services, fmt.Sprintf("ldap:%v:%v", ldapdConf.BindAddress, ldapdConf.BindPort))
+	}
+	services = append(services, fmt.Sprintf("provider:%v", providerConf.Driver))
+	kmsConfig := config.GetKMSConfig()
+	if len(kmsConfig.KeyProvider) > 0 {
+		services = append(services, fmt.Sprintf("master_key:%v", kmsConfig.KeyProvider))
+	} else if len(kmsConfig.MasterKeyPath) > 0 {
+		services = append(services, "master_key")
+	}
+	if plugins := len(config.GetPluginsConfig()); plugins > 0 {
+		services = append(services, fmt.Sprintf("plugins:%v", plugins))
+	}
+	if s.PortableMode == 1 {
+		services = append(services, "portable")
+	}
+	if s.embedded != nil {
+		services = append(services, "embedded")
+	}
+	return services
+}
+
 // Wait blocks until the service exits
 func (s *Service) Wait() {
 	if s.PortableMode != 1 {
 		registerSigHup()
+		s.registerShutdownSignals()
 	}
 	<-s.Shutdown
+	plugin.Stop()
+}
+
+// registerShutdownSignals stops the service on SIGINT and SIGTERM, so the data provider
+// can save its pending changes before exiting
+func (s *Service) registerShutdownSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		logger.Debug(logSender, "", "Received shutdown request")
+		s.Stop()
+	}()
 }
 
 // Stop terminates the service unblocking the Wait method
 func (s *Service) Stop() {
+	plugin.Stop()
+	if err := dataprovider.Flush(); err != nil {
+		logger.Warn(logSender, "", "unable to save the data provider pending changes: %v", err)
+	}
 	close(s.Shutdown)
 	logger.Debug(logSender, "", "Service stopped")
 }
@@ -171,6 +272,8 @@ func (s *Service) StartPortableMode(sftpdPort int, enabledSSHCommands []string,
 		// dynamic ports starts from 49152
 		sftpdConf.BindPort = 49152 + rand.Intn(15000)
 	}
+	// in portable mode only the main binding is used
+	sftpdConf.Bindings = nil
 	if utils.IsStringInSlice("*", enabledSSHCommands) {
 		sftpdConf.EnabledSSHCommands = sftpd.GetSupportedSSHCommands()
 	} else {
@@ -237,6 +340,14 @@ func (s *Service) getPortableDirToServe() string {
 		dirToServe = s.PortableUser.FsConfig.S3Config.KeyPrefix
 	} else if s.PortableUser.FsConfig.Provider == 2 {
 		dirToServe = s.PortableUser.FsConfig.GCSConfig.KeyPrefix
+	} else if s.PortableUser.FsConfig.Provider == 4 {
+		dirToServe = s.PortableUser.FsConfig.WebDAVConfig.RootPath
+	} else if s.PortableUser.FsConfig.Provider == 5 {
+		dirToServe = s.PortableUser.FsConfig.HDFSConfig.RootPath
+	} else if s.PortableUser.FsConfig.Provider == 6 {
+		dirToServe = s.PortableUser.FsConfig.GoogleDriveConfig.FolderID
+	} else if s.PortableUser.FsConfig.Provider == 7 {
+		dirToServe = s.PortableUser.FsConfig.DropboxConfig.RootPath
 	} else {
 		dirToServe = s.PortableUser.HomeDir
 	}
@@ -85,6 +85,7 @@ loop:
 			logger.Debug(logSender, "", "Received reload request")
 			dataprovider.ReloadConfig()
 			httpd.ReloadTLSCertificate()
+			httpd.NotifyAdminEvent(httpd.AdminEvent{Event: httpd.AdminEventConfigReloaded})
 		default:
 			continue loop
 		}
@@ -20,6 +20,7 @@ func registerSigHup() {
 			logger.Debug(logSender, "", "Received reload request")
 			dataprovider.ReloadConfig()
 			httpd.ReloadTLSCertificate()
+			httpd.NotifyAdminEvent(httpd.AdminEvent{Event: httpd.AdminEventConfigReloaded})
 		}
 	}()
 }
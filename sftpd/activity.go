@@ -0,0 +1,123 @@
+package sftpd
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const hoursPerWeek = 7 * 24
+
+var (
+	activityMutex  sync.RWMutex
+	userActivities = make(map[string]*userActivityCounters)
+)
+
+type userActivityCounters struct {
+	logins    [hoursPerWeek]int64
+	uploads   [hoursPerWeek]int64
+	downloads [hoursPerWeek]int64
+}
+
+// HourlyActivity defines the logins and transfers counts for an hour of the week.
+// Weekday and hour are relative to UTC
+type HourlyActivity struct {
+	// day of the week, 0 is Sunday
+	Weekday int `json:"weekday"`
+	// hour of the day, from 0 to 23
+	Hour      int   `json:"hour"`
+	Logins    int64 `json:"logins"`
+	Uploads   int64 `json:"uploads"`
+	Downloads int64 `json:"downloads"`
+}
+
+// UserActivity defines the activity for a user bucketed by hour of the week.
+// Only the buckets with some activity are included.
+// The counters are kept in memory, so they are reset after a restart
+type UserActivity struct {
+	Username string           `json:"username"`
+	Buckets  []HourlyActivity `json:"buckets"`
+}
+
+func getHourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+func getUserActivityCounters(username string) *userActivityCounters {
+	counters, ok := userActivities[username]
+	if !ok {
+		counters = &userActivityCounters{}
+		userActivities[username] = counters
+	}
+	return counters
+}
+
+func addLoginActivity(username string, t time.Time) {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+
+	getUserActivityCounters(username).logins[getHourOfWeek(t)]++
+}
+
+func addTransferActivity(username string, transferType int, t time.Time) {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+
+	counters := getUserActivityCounters(username)
+	if transferType == transferUpload {
+		counters.uploads[getHourOfWeek(t)]++
+	} else {
+		counters.downloads[getHourOfWeek(t)]++
+	}
+}
+
+func (c *userActivityCounters) getUserActivity(username string) UserActivity {
+	activity := UserActivity{
+		Username: username,
+		Buckets:  []HourlyActivity{},
+	}
+	for i := 0; i < hoursPerWeek; i++ {
+		if c.logins[i] == 0 && c.uploads[i] == 0 && c.downloads[i] == 0 {
+			continue
+		}
+		activity.Buckets = append(activity.Buckets, HourlyActivity{
+			Weekday:   i / 24,
+			Hour:      i % 24,
+			Logins:    c.logins[i],
+			Uploads:   c.uploads[i],
+			Downloads: c.downloads[i],
+		})
+	}
+	return activity
+}
+
+// GetUserActivity returns the activity for the given user.
+// If the user has no recorded activity the buckets list is empty
+func GetUserActivity(username string) UserActivity {
+	activityMutex.RLock()
+	defer activityMutex.RUnlock()
+
+	if counters, ok := userActivities[username]; ok {
+		return counters.getUserActivity(username)
+	}
+	return UserActivity{
+		Username: username,
+		Buckets:  []HourlyActivity{},
+	}
+}
+
+// GetUsersActivity returns the activity for all the users with some recorded activity
+func GetUsersActivity() []UserActivity {
+	activityMutex.RLock()
+	defer activityMutex.RUnlock()
+
+	activities := make([]UserActivity, 0, len(userActivities))
+	for username, counters := range userActivities {
+		activities = append(activities, counters.getUserActivity(username))
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].Username < activities[j].Username
+	})
+	return activities
+}
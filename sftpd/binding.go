@@ -0,0 +1,147 @@
+package sftpd
+
+import (
+	"fmt"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Binding defines an additional address to listen on for SSH connections.
+// A binding can have a stricter login policy than the default one, for example an internet
+// facing binding can allow public key authentication only while an internal one also allows
+// passwords. The IP filters, the proxy protocol and all the other settings are shared
+type Binding struct {
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// The port used for serving SSH requests
+	Port int `json:"port" mapstructure:"port"`
+	// Login methods not allowed for the connections to this binding, in addition to the ones
+	// denied for all the bindings
+	DeniedLoginMethods []string `json:"denied_login_methods" mapstructure:"denied_login_methods"`
+}
+
+// GetAddress returns the binding address in the form host:port
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// bindingLoginPolicy defines the login methods refused, for all the users, on a binding.
+// The policy is evaluated before the user filters
+type bindingLoginPolicy struct {
+	deniedLoginMethods []string
+}
+
+// isLoginMethodDenied returns true if the given login method is not allowed
+func (p *bindingLoginPolicy) isLoginMethodDenied(loginMethod string) bool {
+	return utils.IsStringInSlice(loginMethod, p.deniedLoginMethods)
+}
+
+// isPublicKeyDenied returns true if the public key authentication is not allowed, neither
+// as single authentication method nor as first step for a multi-step authentication
+func (p *bindingLoginPolicy) isPublicKeyDenied() bool {
+	if !p.isLoginMethodDenied(dataprovider.SSHLoginMethodPublicKey) {
+		return false
+	}
+	for _, method := range dataprovider.SSHMultiStepsLoginMethods {
+		if !p.isLoginMethodDenied(method) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterNextAuthMethods removes the second step methods not allowed by the policy
+func (p *bindingLoginPolicy) filterNextAuthMethods(methods []string) []string {
+	var allowed []string
+	for _, method := range methods {
+		if method == dataprovider.SSHLoginMethodPassword &&
+			p.isLoginMethodDenied(dataprovider.SSHLoginMethodKeyAndPassword) {
+			continue
+		}
+		if method == dataprovider.SSHLoginMethodKeyboardInteractive &&
+			p.isLoginMethodDenied(dataprovider.SSHLoginMethodKeyAndKeyboardInt) {
+			continue
+		}
+		allowed = append(allowed, method)
+	}
+	return allowed
+}
+
+// getBindings returns the main binding, defined using bind_address and bind_port, and
+// the additional ones
+func (c Configuration) getBindings() []Binding {
+	bindings := []Binding{
+		{
+			Address: c.BindAddress,
+			Port:    c.BindPort,
+		},
+	}
+	return append(bindings, c.Bindings...)
+}
+
+// getLoginPolicy returns the login policy for the given binding, the login methods denied
+// for all the bindings are included
+func (c Configuration) getLoginPolicy(binding Binding) *bindingLoginPolicy {
+	policy := &bindingLoginPolicy{}
+	for _, methods := range [][]string{c.DeniedLoginMethods, binding.DeniedLoginMethods} {
+		for _, method := range methods {
+			if !utils.IsStringInSlice(method, policy.deniedLoginMethods) {
+				policy.deniedLoginMethods = append(policy.deniedLoginMethods, method)
+			}
+		}
+	}
+	return policy
+}
+
+func (c Configuration) validateBindings() error {
+	ports := make(map[int]bool)
+	for idx, binding := range c.getBindings() {
+		// the main binding is validated as before the additional bindings were introduced
+		if idx > 0 && (binding.Port <= 0 || binding.Port > 65535) {
+			return fmt.Errorf("invalid port %v for binding %#v", binding.Port, binding.GetAddress())
+		}
+		if ports[binding.Port] {
+			return fmt.Errorf("duplicated port %v for binding %#v", binding.Port, binding.GetAddress())
+		}
+		ports[binding.Port] = true
+		policy := c.getLoginPolicy(binding)
+		for _, method := range policy.deniedLoginMethods {
+			if !utils.IsStringInSlice(method, dataprovider.ValidSSHLoginMethods) {
+				return fmt.Errorf("invalid denied login method %#v for binding %#v", method, binding.GetAddress())
+			}
+		}
+		if len(policy.deniedLoginMethods) >= len(dataprovider.ValidSSHLoginMethods) {
+			return fmt.Errorf("invalid login policy for binding %#v: all the login methods are denied",
+				binding.GetAddress())
+		}
+	}
+	return nil
+}
+
+func (c Configuration) setLoginPolicies() {
+	policies := make(map[int]*bindingLoginPolicy)
+	for _, binding := range c.getBindings() {
+		policies[binding.Port] = c.getLoginPolicy(binding)
+	}
+	// port 0 identifies the main binding
+	policies[0] = policies[c.BindPort]
+	loginPoliciesMutex.Lock()
+	defer loginPoliciesMutex.Unlock()
+
+	loginPolicies = policies
+}
+
+// getLoginPolicyForPort returns the login policy for the binding listening on the given port,
+// 0 means the main binding
+func getLoginPolicyForPort(port int) (*bindingLoginPolicy, bool) {
+	loginPoliciesMutex.RLock()
+	defer loginPoliciesMutex.RUnlock()
+
+	policy, ok := loginPolicies[port]
+	if !ok && port == 0 {
+		// the SFTP server is not started
+		return &bindingLoginPolicy{}, true
+	}
+	return policy, ok
+}
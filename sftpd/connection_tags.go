@@ -0,0 +1,81 @@
+package sftpd
+
+import (
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	// maximum length for a connection label
+	maxConnectionLabelLength = 255
+)
+
+// ConnectionTagsFilter defines the tags to match to filter the active connections.
+// Empty fields match any value, the comparison is case insensitive
+type ConnectionTagsFilter struct {
+	ClientSoftware string
+	Tenant         string
+	Label          string
+}
+
+// Match returns true if the given connection matches all the non empty tags
+func (f *ConnectionTagsFilter) Match(c *ConnectionStatus) bool {
+	if len(f.ClientSoftware) > 0 && !strings.EqualFold(f.ClientSoftware, c.ClientSoftware) {
+		return false
+	}
+	if len(f.Tenant) > 0 && !strings.EqualFold(f.Tenant, c.Tenant) {
+		return false
+	}
+	if len(f.Label) > 0 && !strings.EqualFold(f.Label, c.Label) {
+		return false
+	}
+	return true
+}
+
+// GetFilteredConnectionsStats returns the active connections matching the given tags
+func GetFilteredConnectionsStats(filter ConnectionTagsFilter) []ConnectionStatus {
+	stats := []ConnectionStatus{}
+	for _, c := range GetConnectionsStats() {
+		if filter.Match(&c) {
+			stats = append(stats, c)
+		}
+	}
+	return stats
+}
+
+// SetConnectionLabel sets a human readable label for the connection with the given id.
+// An empty label removes the existing one. Returns false if the connection is not found
+func SetConnectionLabel(connectionID, label string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	c, ok := openConnections[connectionID]
+	if !ok {
+		return false
+	}
+	c.label = label
+	openConnections[connectionID] = c
+	c.Log(logger.LevelDebug, logSender, "connection label set to %#v", label)
+	return true
+}
+
+// IsValidConnectionLabel returns true if the given label can be set for a connection
+func IsValidConnectionLabel(label string) bool {
+	return len(label) <= maxConnectionLabelLength
+}
+
+// getClientSoftware returns the client software name from the SSH client version string,
+// for example "WinSCP" for "SSH-2.0-WinSCP_release_5.17.7" or "OpenSSH" for "SSH-2.0-OpenSSH_8.2p1 Ubuntu"
+func getClientSoftware(clientVersion string) string {
+	software := clientVersion
+	for _, prefix := range []string{"SSH-2.0-", "SSH-1.99-"} {
+		if strings.HasPrefix(software, prefix) {
+			software = strings.TrimPrefix(software, prefix)
+			break
+		}
+	}
+	if idx := strings.IndexAny(software, "_-/ "); idx >= 0 {
+		software = software[:idx]
+	}
+	return software
+}
@@ -0,0 +1,321 @@
+package sftpd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// the expired hosts are removed when a new host is added and the tracked hosts exceed this limit
+const defenderCleanupThreshold = 5000
+
+var (
+	defender = &memoryDefender{hosts: make(map[string]*defenderHost)}
+	// ErrDefenderHostNotFound is returned if the requested host has no score and it is not banned
+	ErrDefenderHostNotFound = errors.New("host not found")
+	// ErrDefenderDisabled is returned if a host is banned while the defender is disabled
+	ErrDefenderDisabled = errors.New("the defender is disabled")
+)
+
+// DefenderConfig defines the defender, it bans the hosts with too many failed logins.
+// Each failed login adds a score to the client host, a host is banned if its total score
+// inside the observation time reaches the threshold. The connections from a banned host
+// are refused before the SSH handshake. The scores and the bans are kept in memory
+type DefenderConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Minutes a host is banned for
+	BanTime int `json:"ban_time" mapstructure:"ban_time"`
+	// Minutes the scores are kept for, only the scores inside this window are summed
+	ObservationTime int `json:"observation_time" mapstructure:"observation_time"`
+	// A host is banned when its total score reaches this value
+	Threshold int `json:"threshold" mapstructure:"threshold"`
+	// Score for a failed login with an existing username
+	ScoreValid int `json:"score_valid" mapstructure:"score_valid"`
+	// Score for a failed login with a username that does not exist
+	ScoreInvalid int `json:"score_invalid" mapstructure:"score_invalid"`
+}
+
+func (c *DefenderConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.BanTime <= 0 || c.ObservationTime <= 0 || c.Threshold <= 0 {
+		return errors.New("ban_time, observation_time and threshold must be greater than 0")
+	}
+	if c.ScoreValid < 0 || c.ScoreInvalid < 0 {
+		return errors.New("the scores cannot be negative")
+	}
+	return nil
+}
+
+// DefenderEntry defines a host with a score or a ban
+type DefenderEntry struct {
+	IP string `json:"ip"`
+	// total score inside the observation time
+	Score int `json:"score"`
+	// ban expiration as unix timestamp in milliseconds, 0 if the host is not banned
+	BanTime int64 `json:"ban_time"`
+}
+
+// GetBanTimeAsString returns the ban expiration formatted as YYYY-MM-DD HH:MM:SS
+func (e *DefenderEntry) GetBanTimeAsString() string {
+	if e.BanTime <= 0 {
+		return ""
+	}
+	return utils.GetTimeFromMsecSinceEpoch(e.BanTime).Format("2006-01-02 15:04:05")
+}
+
+type defenderEvent struct {
+	time  time.Time
+	score int
+}
+
+type defenderHost struct {
+	events    []defenderEvent
+	banExpiry time.Time
+}
+
+// getScore returns the total score for the events inside the observation time, the older events are removed
+func (h *defenderHost) getScore(observationStart time.Time) int {
+	score := 0
+	events := h.events[:0]
+	for _, e := range h.events {
+		if e.time.After(observationStart) {
+			events = append(events, e)
+			score += e.score
+		}
+	}
+	h.events = events
+	return score
+}
+
+func (h *defenderHost) isBanned(now time.Time) bool {
+	return h.banExpiry.After(now)
+}
+
+type memoryDefender struct {
+	sync.Mutex
+	config DefenderConfig
+	hosts  map[string]*defenderHost
+}
+
+func (d *memoryDefender) configure(config DefenderConfig) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.config = config
+	d.hosts = make(map[string]*defenderHost)
+}
+
+func (d *memoryDefender) getObservationStart(now time.Time) time.Time {
+	return now.Add(-time.Duration(d.config.ObservationTime) * time.Minute)
+}
+
+// isBanned returns true if the given IP address is banned
+func (d *memoryDefender) isBanned(ip string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.config.Enabled {
+		return false
+	}
+	ip, err := normalizeDefenderIP(ip)
+	if err != nil {
+		return false
+	}
+	if h, ok := d.hosts[ip]; ok {
+		return h.isBanned(time.Now())
+	}
+	return false
+}
+
+// addLoginFailure adds the score for a failed login to the given IP address and bans it if the threshold
+// is reached. The error returned by the data provider is used to find the failed logins for missing users
+func (d *memoryDefender) addLoginFailure(ip string, loginErr error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.config.Enabled {
+		return
+	}
+	ip, err := normalizeDefenderIP(ip)
+	if err != nil {
+		return
+	}
+	score := d.config.ScoreValid
+	if _, ok := loginErr.(*dataprovider.RecordNotFoundError); ok {
+		score = d.config.ScoreInvalid
+	}
+	if score <= 0 {
+		return
+	}
+	now := time.Now()
+	h, ok := d.hosts[ip]
+	if !ok {
+		if len(d.hosts) >= defenderCleanupThreshold {
+			d.cleanup(now)
+		}
+		h = &defenderHost{}
+		d.hosts[ip] = h
+	}
+	if h.isBanned(now) {
+		return
+	}
+	h.events = append(h.events, defenderEvent{time: now, score: score})
+	if total := h.getScore(d.getObservationStart(now)); total >= d.config.Threshold {
+		h.events = nil
+		h.banExpiry = now.Add(time.Duration(d.config.BanTime) * time.Minute)
+		logger.Info(logSender, "", "host %#v banned until %v, score: %v", ip, h.banExpiry.Format(time.RFC3339), total)
+	}
+}
+
+// cleanup removes the hosts without a ban and without events inside the observation time
+func (d *memoryDefender) cleanup(now time.Time) {
+	observationStart := d.getObservationStart(now)
+	for ip, h := range d.hosts {
+		if !h.isBanned(now) && h.getScore(observationStart) == 0 {
+			delete(d.hosts, ip)
+		}
+	}
+}
+
+func (d *memoryDefender) newEntry(ip string, h *defenderHost, now time.Time) DefenderEntry {
+	entry := DefenderEntry{
+		IP:    ip,
+		Score: h.getScore(d.getObservationStart(now)),
+	}
+	if h.isBanned(now) {
+		entry.BanTime = utils.GetTimeAsMsSinceEpoch(h.banExpiry)
+	}
+	return entry
+}
+
+func (d *memoryDefender) getEntries() []DefenderEntry {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	d.cleanup(now)
+	entries := make([]DefenderEntry, 0, len(d.hosts))
+	for ip, h := range d.hosts {
+		entries = append(entries, d.newEntry(ip, h, now))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].IP < entries[j].IP
+	})
+	return entries
+}
+
+func (d *memoryDefender) getEntry(ip string) (DefenderEntry, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if h, ok := d.hosts[ip]; ok {
+		entry := d.newEntry(ip, h, time.Now())
+		if entry.Score > 0 || entry.BanTime > 0 {
+			return entry, nil
+		}
+	}
+	return DefenderEntry{}, ErrDefenderHostNotFound
+}
+
+func (d *memoryDefender) ban(ip string, banTime time.Duration) (DefenderEntry, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.config.Enabled {
+		return DefenderEntry{}, ErrDefenderDisabled
+	}
+	now := time.Now()
+	h, ok := d.hosts[ip]
+	if !ok {
+		h = &defenderHost{}
+		d.hosts[ip] = h
+	}
+	h.events = nil
+	h.banExpiry = now.Add(banTime)
+	logger.Info(logSender, "", "host %#v manually banned until %v", ip, h.banExpiry.Format(time.RFC3339))
+	return d.newEntry(ip, h, now), nil
+}
+
+func (d *memoryDefender) unban(ip string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.hosts[ip]; !ok {
+		return false
+	}
+	delete(d.hosts, ip)
+	logger.Info(logSender, "", "host %#v unbanned, score reset", ip)
+	return true
+}
+
+// normalizeDefenderIP returns the canonical representation for the given IP address
+func normalizeDefenderIP(ip string) (string, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return "", fmt.Errorf("invalid IP address %#v", ip)
+	}
+	return parsedIP.String(), nil
+}
+
+// IsDefenderEnabled returns true if the defender is enabled
+func IsDefenderEnabled() bool {
+	defender.Lock()
+	defer defender.Unlock()
+
+	return defender.config.Enabled
+}
+
+// GetDefenderHosts returns the hosts with a score or a ban, ordered by IP address
+func GetDefenderHosts() []DefenderEntry {
+	return defender.getEntries()
+}
+
+// GetDefenderHost returns the score and the ban for the given IP address.
+// ErrDefenderHostNotFound is returned if the host has no score and it is not banned
+func GetDefenderHost(ip string) (DefenderEntry, error) {
+	normalizedIP, err := normalizeDefenderIP(ip)
+	if err != nil {
+		return DefenderEntry{}, err
+	}
+	return defender.getEntry(normalizedIP)
+}
+
+// BanDefenderHost bans the given IP address for the given number of minutes,
+// 0 means the configured ban time. The score for the host is reset
+func BanDefenderHost(ip string, minutes int) (DefenderEntry, error) {
+	normalizedIP, err := normalizeDefenderIP(ip)
+	if err != nil {
+		return DefenderEntry{}, err
+	}
+	if minutes < 0 {
+		return DefenderEntry{}, errors.New("the ban time cannot be negative")
+	}
+	if minutes == 0 {
+		defender.Lock()
+		minutes = defender.config.BanTime
+		defender.Unlock()
+	}
+	return defender.ban(normalizedIP, time.Duration(minutes)*time.Minute)
+}
+
+// UnbanDefenderHost removes the ban and the score for the given IP address.
+// ErrDefenderHostNotFound is returned if the host has no score and it is not banned
+func UnbanDefenderHost(ip string) error {
+	normalizedIP, err := normalizeDefenderIP(ip)
+	if err != nil {
+		return err
+	}
+	if !defender.unban(normalizedIP) {
+		return ErrDefenderHostNotFound
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package sftpd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const firstLoginTermsAnswer = "yes"
+
+var firstLoginTerms string
+
+// FirstLoginConfig defines how the users complete the actions required on the first login,
+// for example the acceptance of the terms of use or the change of the initial password.
+// The actions are completed using the keyboard interactive authentication
+type FirstLoginConfig struct {
+	// If enabled, the users with pending first login actions can complete them using the keyboard
+	// interactive authentication. If disabled, these users cannot login
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Path to a text file with the terms of use to accept. It is required for the users
+	// that must accept the terms of use.
+	// This can be an absolute path or a path relative to the config dir
+	TermsOfUseFile string `json:"terms_of_use_file" mapstructure:"terms_of_use_file"`
+}
+
+// getFirstLoginTerms returns the terms of use to accept on the first login, if any
+func (c Configuration) getFirstLoginTerms(configDir string) (string, error) {
+	if !c.FirstLogin.Enabled || len(c.FirstLogin.TermsOfUseFile) == 0 {
+		return "", nil
+	}
+	termsFilePath := c.FirstLogin.TermsOfUseFile
+	if !filepath.IsAbs(termsFilePath) {
+		termsFilePath = filepath.Join(configDir, termsFilePath)
+	}
+	content, err := ioutil.ReadFile(termsFilePath)
+	if err != nil {
+		return "", err
+	}
+	terms := strings.TrimSpace(string(content))
+	if len(terms) == 0 {
+		return "", fmt.Errorf("the terms of use file %#v is empty", termsFilePath)
+	}
+	return terms, nil
+}
+
+// isFirstLoginStepRequired returns true if the given user, authenticated using a public key, must
+// complete the pending first login actions using the keyboard interactive authentication as second step
+func (c Configuration) isFirstLoginStepRequired(user dataprovider.User, policy *bindingLoginPolicy) bool {
+	if !c.FirstLogin.Enabled || !user.HasPendingFirstLoginActions() {
+		return false
+	}
+	if policy != nil && policy.isLoginMethodDenied(dataprovider.SSHLoginMethodKeyAndKeyboardInt) {
+		return false
+	}
+	return user.IsLoginMethodAllowed(dataprovider.SSHLoginMethodKeyAndKeyboardInt,
+		[]string{dataprovider.SSHLoginMethodPublicKey})
+}
+
+// hasPendingFirstLoginActions returns true if the user with the given username exists and
+// must complete some first login actions
+func (c Configuration) hasPendingFirstLoginActions(username string) bool {
+	if !c.FirstLogin.Enabled {
+		return false
+	}
+	user, err := dataprovider.UserExists(dataProvider, username)
+	if err != nil {
+		return false
+	}
+	return user.HasPendingFirstLoginActions()
+}
+
+// doFirstLoginActions authenticates the user, if not already authenticated using a public key,
+// and asks to complete the pending first login actions. The updated user is returned
+func (c Configuration) doFirstLoginActions(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge,
+	loginMethod string) (dataprovider.User, error) {
+	var user dataprovider.User
+	var err error
+	username := conn.User()
+	partialSuccessMethods := conn.PartialSuccessMethods()
+	if len(partialSuccessMethods) == 1 {
+		// already authenticated using a public key
+		user, err = dataprovider.UserExists(dataProvider, username)
+	} else {
+		var answers []string
+		answers, err = askFirstLoginQuestions(client, username, "", []string{"Password: "}, []bool{false})
+		if err != nil {
+			return user, err
+		}
+		user, err = dataprovider.CheckUserAndPass(dataProvider, username, answers[0])
+	}
+	if err != nil {
+		return user, err
+	}
+	if !user.IsLoginMethodAllowed(loginMethod, partialSuccessMethods) {
+		return user, fmt.Errorf("Login method %#v is not allowed for user %#v", loginMethod, user.Username)
+	}
+	acceptTerms := false
+	if user.Filters.FirstLogin.IsTermsAcceptancePending() {
+		if len(firstLoginTerms) == 0 {
+			logger.Warn(logSender, "", "user %#v must accept the terms of use but no terms of use file is configured",
+				user.Username)
+			return user, errors.New("the terms of use are not configured")
+		}
+		answers, err := askFirstLoginQuestions(client, username, firstLoginTerms,
+			[]string{fmt.Sprintf("Type %#v to accept the terms of use: ", firstLoginTermsAnswer)}, []bool{true})
+		if err != nil {
+			return user, err
+		}
+		if !strings.EqualFold(strings.TrimSpace(answers[0]), firstLoginTermsAnswer) {
+			return user, errors.New("the terms of use were not accepted")
+		}
+		acceptTerms = true
+	}
+	newPassword := ""
+	if user.Filters.FirstLogin.IsPasswordChangePending() {
+		answers, err := askFirstLoginQuestions(client, username, "You must change your initial password",
+			[]string{"New password: ", "Confirm new password: "}, []bool{false, false})
+		if err != nil {
+			return user, err
+		}
+		if len(answers[0]) == 0 {
+			return user, errors.New("the new password cannot be empty")
+		}
+		if answers[0] != answers[1] {
+			return user, errors.New("the new passwords do not match")
+		}
+		newPassword = answers[0]
+	}
+	return dataprovider.CompleteFirstLoginActions(dataProvider, username, acceptTerms, newPassword)
+}
+
+func askFirstLoginQuestions(client ssh.KeyboardInteractiveChallenge, username, instruction string, questions []string,
+	echos []bool) ([]string, error) {
+	answers, err := client(username, instruction, questions, echos)
+	if err != nil {
+		return answers, err
+	}
+	if len(answers) != len(questions) {
+		return answers, fmt.Errorf("unexpected number of answers, expected: %v, actual: %v", len(questions), len(answers))
+	}
+	return answers, nil
+}
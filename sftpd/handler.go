@@ -4,7 +4,6 @@ import (
 	"io"
 	"net"
 	"os"
-	"path"
 	"sync"
 	"time"
 
@@ -13,6 +12,8 @@ import (
 
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/policy"
 
 	"github.com/pkg/sftp"
 )
@@ -36,6 +37,8 @@ type Connection struct {
 	channel      ssh.Channel
 	command      string
 	fs           vfs.Fs
+	// human readable label set by an administrator
+	label string
 }
 
 // Log outputs a log entry to the configured logger
@@ -46,13 +49,9 @@ func (c Connection) Log(level logger.LogLevel, sender string, format string, v .
 // Fileread creates a reader for a file on the system and returns the reader back.
 func (c Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	updateConnectionActivity(c.ID)
+	defer c.requestServed(request, time.Now())
 
-	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(request.Filepath)) {
-		return nil, sftp.ErrSSHFxPermissionDenied
-	}
-
-	if !c.User.IsFileAllowed(request.Filepath) {
-		c.Log(logger.LevelWarn, logSender, "reading file %#v is not allowed", request.Filepath)
+	if !c.isOpAllowed(policy.OpDownload, request.Filepath) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -89,6 +88,7 @@ func (c Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		lastActivity:   time.Now(),
 		isNewFile:      false,
 		protocol:       c.protocol,
+		backend:        vfs.GetBackendType(c.fs, p),
 		transferError:  nil,
 		isFinished:     false,
 		minWriteOffset: 0,
@@ -102,11 +102,7 @@ func (c Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 // Filewrite handles the write actions for a file on the system.
 func (c Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	updateConnectionActivity(c.ID)
-
-	if !c.User.IsFileAllowed(request.Filepath) {
-		c.Log(logger.LevelWarn, logSender, "writing file %#v is not allowed", request.Filepath)
-		return nil, sftp.ErrSSHFxPermissionDenied
-	}
+	defer c.requestServed(request, time.Now())
 
 	p, err := c.fs.ResolvePath(request.Filepath)
 	if err != nil {
@@ -120,7 +116,7 @@ func (c Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 
 	stat, statErr := c.fs.Stat(p)
 	if c.fs.IsNotExist(statErr) {
-		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(request.Filepath)) {
+		if !c.isOpAllowed(policy.OpUpload, request.Filepath) {
 			return nil, sftp.ErrSSHFxPermissionDenied
 		}
 		return c.handleSFTPUploadToNewFile(p, filePath)
@@ -137,7 +133,14 @@ func (c Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 
-	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(request.Filepath)) {
+	if vfs.IsPartialUpload(stat) {
+		if !c.isOpAllowed(policy.OpUpload, request.Filepath) {
+			return nil, sftp.ErrSSHFxPermissionDenied
+		}
+		return c.handleSFTPUploadToPartialFile(request.Pflags(), p, filePath, stat.Size())
+	}
+
+	if !c.isOpAllowed(policy.OpOverwrite, request.Filepath) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -148,6 +151,7 @@ func (c Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 // or writing to those files.
 func (c Connection) Filecmd(request *sftp.Request) error {
 	updateConnectionActivity(c.ID)
+	defer c.requestServed(request, time.Now())
 
 	p, err := c.fs.ResolvePath(request.Filepath)
 	if err != nil {
@@ -205,6 +209,7 @@ func (c Connection) Filecmd(request *sftp.Request) error {
 // a directory as well as perform file/folder stat calls.
 func (c Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	updateConnectionActivity(c.ID)
+	defer c.requestServed(request, time.Now())
 	p, err := c.fs.ResolvePath(request.Filepath)
 	if err != nil {
 		return nil, vfs.GetSFTPError(c.fs, err)
@@ -212,7 +217,7 @@ func (c Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 
 	switch request.Method {
 	case "List":
-		if !c.User.HasPerm(dataprovider.PermListItems, request.Filepath) {
+		if !c.isOpAllowed(policy.OpList, request.Filepath) {
 			return nil, sftp.ErrSSHFxPermissionDenied
 		}
 
@@ -225,7 +230,7 @@ func (c Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 
 		return listerAt(c.User.AddVirtualDirs(files, request.Filepath)), nil
 	case "Stat":
-		if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(request.Filepath)) {
+		if !c.isOpAllowed(policy.OpStat, request.Filepath) {
 			return nil, sftp.ErrSSHFxPermissionDenied
 		}
 
@@ -242,6 +247,16 @@ func (c Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	}
 }
 
+// requestServed updates the latency metrics for the given SFTP request
+func (c Connection) requestServed(request *sftp.Request, start time.Time) {
+	var fsPath string
+	if _, ok := c.fs.(*vfs.FoldersFs); ok {
+		// the backend depends on the path only if some virtual folders have their own filesystem
+		fsPath, _ = c.fs.ResolvePath(request.Filepath)
+	}
+	metrics.SFTPRequestServed(request.Method, vfs.GetBackendType(c.fs, fsPath), time.Since(start))
+}
+
 func (c Connection) getSFTPCmdTargetPath(requestTarget string) (string, error) {
 	var target string
 	// If a target is provided in this request validate that it is going to the correct
@@ -260,15 +275,16 @@ func (c Connection) handleSFTPSetstat(filePath string, request *sftp.Request) er
 	if setstatMode == 1 {
 		return nil
 	}
-	pathForPerms := request.Filepath
-	if fi, err := c.fs.Lstat(filePath); err == nil {
-		if fi.IsDir() {
-			pathForPerms = path.Dir(request.Filepath)
+	attrFlags := request.AttrFlags()
+	// a setstat request can include the size together with the other attributes. The file is
+	// truncated first, so the requested modification time is not changed by the truncate
+	if attrFlags.Size {
+		if err := c.handleSFTPTruncate(filePath, request); err != nil {
+			return err
 		}
 	}
-	attrFlags := request.AttrFlags()
 	if attrFlags.Permissions {
-		if !c.User.HasPerm(dataprovider.PermChmod, pathForPerms) {
+		if !c.isOpAllowed(policy.OpChmod, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		fileMode := request.Attributes().FileMode()
@@ -279,7 +295,7 @@ func (c Connection) handleSFTPSetstat(filePath string, request *sftp.Request) er
 		logger.CommandLog(chmodLogSender, filePath, "", c.User.Username, fileMode.String(), c.ID, c.protocol, -1, -1, "", "", "")
 		return nil
 	} else if attrFlags.UidGid {
-		if !c.User.HasPerm(dataprovider.PermChown, pathForPerms) {
+		if !c.isOpAllowed(policy.OpChown, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		uid := int(request.Attributes().UID)
@@ -291,7 +307,7 @@ func (c Connection) handleSFTPSetstat(filePath string, request *sftp.Request) er
 		logger.CommandLog(chownLogSender, filePath, "", c.User.Username, "", c.ID, c.protocol, uid, gid, "", "", "")
 		return nil
 	} else if attrFlags.Acmodtime {
-		if !c.User.HasPerm(dataprovider.PermChtimes, pathForPerms) {
+		if !c.isOpAllowed(policy.OpChtimes, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		dateFormat := "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
@@ -311,44 +327,114 @@ func (c Connection) handleSFTPSetstat(filePath string, request *sftp.Request) er
 	return nil
 }
 
-func (c Connection) handleSFTPRename(sourcePath string, targetPath string, request *sftp.Request) error {
-	if c.fs.GetRelativePath(sourcePath) == "/" {
-		c.Log(logger.LevelWarn, logSender, "renaming root dir is not allowed")
+func (c Connection) handleSFTPTruncate(filePath string, request *sftp.Request) error {
+	if !c.isOpAllowed(policy.OpOverwrite, request.Filepath) {
 		return sftp.ErrSSHFxPermissionDenied
 	}
-	if c.User.IsVirtualFolder(request.Filepath) || c.User.IsVirtualFolder(request.Target) {
-		c.Log(logger.LevelWarn, logSender, "renaming a virtual folder is not allowed")
-		return sftp.ErrSSHFxPermissionDenied
+	fi, err := c.fs.Lstat(filePath)
+	if err != nil {
+		c.Log(logger.LevelWarn, logSender, "failed to truncate file %#v: stat error: %+v", filePath, err)
+		return vfs.GetSFTPError(c.fs, err)
 	}
-	if !c.User.IsFileAllowed(request.Filepath) || !c.User.IsFileAllowed(request.Target) {
-		if fi, err := c.fs.Lstat(sourcePath); err == nil && fi.Mode().IsRegular() {
-			c.Log(logger.LevelDebug, logSender, "renaming file is not allowed, source: %#v target: %#v", request.Filepath,
-				request.Target)
-			return sftp.ErrSSHFxPermissionDenied
+	if !fi.Mode().IsRegular() {
+		c.Log(logger.LevelDebug, logSender, "cannot truncate %#v is not a regular file", filePath)
+		return sftp.ErrSSHFxFailure
+	}
+	size := int64(request.Attributes().Size)
+	if size > fi.Size() && !c.hasSizeQuotaForPath(filePath, size-fi.Size()) {
+		c.Log(logger.LevelInfo, logSender, "denying file truncate due to space limit")
+		return sftp.ErrSSHFxFailure
+	}
+	if err := c.fs.Truncate(filePath, size); err != nil {
+		c.Log(logger.LevelWarn, logSender, "failed to truncate file %#v, size: %v, err: %+v", filePath, size, err)
+		return vfs.GetSFTPError(c.fs, err)
+	}
+	logger.CommandLog(truncateLogSender, filePath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "")
+	// update the quota using the size difference, the allocated disk space could change even if the
+	// logical size does not, for example if a sparse file is truncated to its current size
+	if newFi, err := c.fs.Lstat(filePath); err == nil {
+		sizeDiff := vfs.GetFileUsage(c.fs, newFi) - vfs.GetFileUsage(c.fs, fi)
+		if sizeDiff != 0 {
+			c.updatePathQuota(filePath, 0, sizeDiff)
 		}
+	} else {
+		c.Log(logger.LevelWarn, logSender, "unable to update quota after truncating file %#v: stat error: %+v", filePath, err)
 	}
-	if !c.User.HasPerm(dataprovider.PermRename, path.Dir(request.Target)) {
+	return nil
+}
+
+func (c Connection) handleSFTPRename(sourcePath string, targetPath string, request *sftp.Request) error {
+	isFile := false
+	fi, err := c.fs.Lstat(sourcePath)
+	if err == nil && fi.Mode().IsRegular() {
+		isFile = true
+	}
+	if !c.checkDecision(policy.EvaluateRename(&c.User, request.Filepath, request.Target, isFile)) {
 		return sftp.ErrSSHFxPermissionDenied
 	}
+	var targetInfo os.FileInfo
+	sourceFolder := c.User.GetSharedFolderForPath(request.Filepath)
+	targetFolder := c.User.GetSharedFolderForPath(request.Target)
+	if isFile && sourceFolder != targetFolder {
+		if info, err := c.fs.Lstat(targetPath); err == nil && info.Mode().IsRegular() {
+			targetInfo = info
+		}
+	}
+	var xattrs map[string][]byte
+	if isFile && vfs.IsLocalOsFs(c.fs) {
+		xattrs = getXattrsToPreserve(targetPath, c.ID)
+	}
 	if err := c.fs.Rename(sourcePath, targetPath); err != nil {
 		c.Log(logger.LevelWarn, logSender, "failed to rename file, source: %#v target: %#v: %+v", sourcePath, targetPath, err)
 		return vfs.GetSFTPError(c.fs, err)
 	}
+	preserveExtendedAttributes(targetPath, xattrs, c.ID)
+	if isFile && sourceFolder != targetFolder {
+		// the quota for the renamed file moves to the new owner
+		size := vfs.GetFileUsage(c.fs, fi)
+		updateQuota(c.User, sourceFolder, -1, -size)
+		if targetInfo != nil {
+			updateQuota(c.User, targetFolder, 0, size-vfs.GetFileUsage(c.fs, targetInfo))
+		} else {
+			updateQuota(c.User, targetFolder, 1, size)
+		}
+	}
 	logger.CommandLog(renameLogSender, sourcePath, targetPath, c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "")
 	go executeAction(newActionNotification(c.User, operationRename, sourcePath, targetPath, "", 0, nil))
 	return nil
 }
 
-func (c Connection) handleSFTPRmdir(dirPath string, request *sftp.Request) error {
-	if c.fs.GetRelativePath(dirPath) == "/" {
-		c.Log(logger.LevelWarn, logSender, "removing root dir is not allowed")
-		return sftp.ErrSSHFxPermissionDenied
+// getXattrsToPreserve returns the user extended attributes and the POSIX ACLs of an existing local file
+// that is going to be overwritten by a rename, if their preservation is enabled. They must be applied
+// to the renamed file, using preserveExtendedAttributes, after a successful rename
+func getXattrsToPreserve(existingPath, connectionID string) map[string][]byte {
+	if !preserveXattrs {
+		return nil
 	}
-	if c.User.IsVirtualFolder(request.Filepath) {
-		c.Log(logger.LevelWarn, logSender, "removing a virtual folder is not allowed: %#v", request.Filepath)
-		return sftp.ErrSSHFxPermissionDenied
+	if fi, err := os.Lstat(existingPath); err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	xattrs, err := vfs.GetExtendedAttributesToPreserve(existingPath)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get the extended attributes to preserve for %#v: %v",
+			existingPath, err)
+	}
+	return xattrs
+}
+
+// preserveExtendedAttributes sets the extended attributes of the overwritten file to the file that
+// replaced it, the attributes already defined for the new file are preserved
+func preserveExtendedAttributes(filePath string, xattrs map[string][]byte, connectionID string) {
+	if len(xattrs) == 0 {
+		return
+	}
+	if err := vfs.SetExtendedAttributes(filePath, xattrs); err != nil {
+		logger.Warn(logSender, connectionID, "unable to preserve extended attributes for %#v: %v", filePath, err)
 	}
-	if !c.User.HasPerm(dataprovider.PermDelete, path.Dir(request.Filepath)) {
+}
+
+func (c Connection) handleSFTPRmdir(dirPath string, request *sftp.Request) error {
+	if !c.isOpAllowed(policy.OpDeleteDir, request.Filepath) {
 		return sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -377,11 +463,7 @@ func (c Connection) handleSFTPSymlink(sourcePath string, targetPath string, requ
 		c.Log(logger.LevelWarn, logSender, "symlinking root dir is not allowed")
 		return sftp.ErrSSHFxPermissionDenied
 	}
-	if c.User.IsVirtualFolder(request.Target) {
-		c.Log(logger.LevelWarn, logSender, "symlinking a virtual folder is not allowed")
-		return sftp.ErrSSHFxPermissionDenied
-	}
-	if !c.User.HasPerm(dataprovider.PermCreateSymlinks, path.Dir(request.Target)) {
+	if !c.isOpAllowed(policy.OpCreateSymlink, request.Target) {
 		return sftp.ErrSSHFxPermissionDenied
 	}
 	if err := c.fs.Symlink(sourcePath, targetPath); err != nil {
@@ -394,11 +476,7 @@ func (c Connection) handleSFTPSymlink(sourcePath string, targetPath string, requ
 }
 
 func (c Connection) handleSFTPMkdir(dirPath string, request *sftp.Request) error {
-	if !c.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(request.Filepath)) {
-		return sftp.ErrSSHFxPermissionDenied
-	}
-	if c.User.IsVirtualFolder(request.Filepath) {
-		c.Log(logger.LevelWarn, logSender, "mkdir not allowed %#v is virtual folder is not allowed", request.Filepath)
+	if !c.isOpAllowed(policy.OpCreateDir, request.Filepath) {
 		return sftp.ErrSSHFxPermissionDenied
 	}
 	if err := c.fs.Mkdir(dirPath); err != nil {
@@ -412,7 +490,7 @@ func (c Connection) handleSFTPMkdir(dirPath string, request *sftp.Request) error
 }
 
 func (c Connection) handleSFTPRemove(filePath string, request *sftp.Request) error {
-	if !c.User.HasPerm(dataprovider.PermDelete, path.Dir(request.Filepath)) {
+	if !c.isOpAllowed(policy.OpDeleteFile, request.Filepath) {
 		return sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -428,20 +506,17 @@ func (c Connection) handleSFTPRemove(filePath string, request *sftp.Request) err
 		return sftp.ErrSSHFxFailure
 	}
 
-	if !c.User.IsFileAllowed(request.Filepath) {
-		c.Log(logger.LevelDebug, logSender, "removing file %#v is not allowed", filePath)
-		return sftp.ErrSSHFxPermissionDenied
-	}
-
-	size = fi.Size()
+	size = vfs.GetFileUsage(c.fs, fi)
+	// an interrupted upload is not included in the quota
+	isPartialUpload := vfs.IsPartialUpload(fi)
 	if err := c.fs.Remove(filePath, false); err != nil {
 		c.Log(logger.LevelWarn, logSender, "failed to remove a file/symlink %#v: %+v", filePath, err)
 		return vfs.GetSFTPError(c.fs, err)
 	}
 
 	logger.CommandLog(removeLogSender, filePath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "")
-	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
-		dataprovider.UpdateUserQuota(dataProvider, c.User, -1, -size, false)
+	if fi.Mode()&os.ModeSymlink != os.ModeSymlink && !isPartialUpload {
+		c.updatePathQuota(filePath, -1, -size)
 	}
 	go executeAction(newActionNotification(c.User, operationDelete, filePath, "", "", fi.Size(), nil))
 
@@ -449,7 +524,7 @@ func (c Connection) handleSFTPRemove(filePath string, request *sftp.Request) err
 }
 
 func (c Connection) handleSFTPUploadToNewFile(requestPath, filePath string) (io.WriterAt, error) {
-	if !c.hasSpace(true) {
+	if !c.hasSpaceForPath(true, requestPath) {
 		c.Log(logger.LevelInfo, logSender, "denying file write due to space limit")
 		return nil, sftp.ErrSSHFxFailure
 	}
@@ -477,9 +552,12 @@ func (c Connection) handleSFTPUploadToNewFile(requestPath, filePath string) (io.
 		lastActivity:   time.Now(),
 		isNewFile:      true,
 		protocol:       c.protocol,
+		backend:        vfs.GetBackendType(c.fs, requestPath),
 		transferError:  nil,
 		isFinished:     false,
 		minWriteOffset: 0,
+		isIngestion:    c.User.IsInIngestionFolder(c.fs.GetRelativePath(requestPath)),
+		quotaFolder:    c.getQuotaFolder(requestPath),
 		lock:           new(sync.Mutex),
 	}
 	addTransfer(&transfer)
@@ -489,7 +567,7 @@ func (c Connection) handleSFTPUploadToNewFile(requestPath, filePath string) (io.
 func (c Connection) handleSFTPUploadToExistingFile(pflags sftp.FileOpenFlags, requestPath, filePath string,
 	fileSize int64) (io.WriterAt, error) {
 	var err error
-	if !c.hasSpace(false) {
+	if !c.hasSpaceForPath(false, requestPath) {
 		c.Log(logger.LevelInfo, logSender, "denying file write due to space limit")
 		return nil, sftp.ErrSSHFxFailure
 	}
@@ -524,7 +602,7 @@ func (c Connection) handleSFTPUploadToExistingFile(pflags sftp.FileOpenFlags, re
 		minWriteOffset = fileSize
 	} else {
 		if vfs.IsLocalOsFs(c.fs) {
-			dataprovider.UpdateUserQuota(dataProvider, c.User, 0, -fileSize, false)
+			c.updatePathQuota(requestPath, 0, -fileSize)
 		} else {
 			initialSize = fileSize
 		}
@@ -547,16 +625,110 @@ func (c Connection) handleSFTPUploadToExistingFile(pflags sftp.FileOpenFlags, re
 		lastActivity:   time.Now(),
 		isNewFile:      false,
 		protocol:       c.protocol,
+		backend:        vfs.GetBackendType(c.fs, requestPath),
 		transferError:  nil,
 		isFinished:     false,
 		minWriteOffset: minWriteOffset,
 		initialSize:    initialSize,
+		isIngestion:    c.User.IsInIngestionFolder(c.fs.GetRelativePath(requestPath)),
+		quotaFolder:    c.getQuotaFolder(requestPath),
+		lock:           new(sync.Mutex),
+	}
+	addTransfer(&transfer)
+	return &transfer, nil
+}
+
+// handleSFTPUploadToPartialFile handles an upload to a path with an interrupted upload stored inside
+// the upload journal. The interrupted upload is resumed if the client appends to the file,
+// otherwise it is replaced by a new upload
+func (c Connection) handleSFTPUploadToPartialFile(pflags sftp.FileOpenFlags, requestPath, filePath string,
+	resumableSize int64) (io.WriterAt, error) {
+	osFlags := getOSOpenFlags(pflags)
+	if !pflags.Append || osFlags&os.O_TRUNC != 0 {
+		return c.handleSFTPUploadToNewFile(requestPath, filePath)
+	}
+	if !c.hasSpaceForPath(true, requestPath) {
+		c.Log(logger.LevelInfo, logSender, "denying file write due to space limit")
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	file, w, cancelFn, err := c.fs.Create(filePath, osFlags|os.O_APPEND)
+	if err != nil {
+		c.Log(logger.LevelWarn, logSender, "error resuming the interrupted upload %#v: %+v", requestPath, err)
+		return nil, vfs.GetSFTPError(c.fs, err)
+	}
+	c.Log(logger.LevelDebug, logSender, "interrupted upload resumed, file path: %#v initial size: %v", filePath,
+		resumableSize)
+
+	transfer := Transfer{
+		file:           file,
+		writerAt:       w,
+		readerAt:       nil,
+		cancelFn:       cancelFn,
+		path:           requestPath,
+		start:          time.Now(),
+		bytesSent:      0,
+		bytesReceived:  0,
+		user:           c.User,
+		connectionID:   c.ID,
+		transferType:   transferUpload,
+		lastActivity:   time.Now(),
+		isNewFile:      true,
+		protocol:       c.protocol,
+		backend:        vfs.GetBackendType(c.fs, requestPath),
+		transferError:  nil,
+		isFinished:     false,
+		minWriteOffset: resumableSize,
+		resumedSize:    resumableSize,
+		isIngestion:    c.User.IsInIngestionFolder(c.fs.GetRelativePath(requestPath)),
+		quotaFolder:    c.getQuotaFolder(requestPath),
 		lock:           new(sync.Mutex),
 	}
 	addTransfer(&transfer)
 	return &transfer, nil
 }
 
+// isOpAllowed returns true if the connection user can execute the given operation on the
+// given SFTP path, the denied operations are logged
+func (c Connection) isOpAllowed(op policy.Op, sftpPath string) bool {
+	if isTransferOp(op) && isConnectionClosing(c.ID) {
+		c.Log(logger.LevelInfo, c.getLogSender(), "%v denied for path %#v, the connection is closing after a user "+
+			"update/delete", op, sftpPath)
+		return false
+	}
+	return c.checkDecision(policy.EvaluateOp(&c.User, op, sftpPath))
+}
+
+// isTransferOp returns true if the given operation starts a transfer
+func isTransferOp(op policy.Op) bool {
+	switch op {
+	case policy.OpDownload, policy.OpDownloadDir, policy.OpUpload, policy.OpOverwrite, policy.OpSystemCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c Connection) checkDecision(decision policy.Decision) bool {
+	if !decision.Allowed {
+		c.Log(logger.LevelInfo, c.getLogSender(), "operation denied, rule: %v, %v", decision.Rule, decision.Reason)
+	}
+	return decision.Allowed
+}
+
+func (c Connection) getLogSender() string {
+	switch c.protocol {
+	case protocolSCP:
+		return logSenderSCP
+	case protocolSSH:
+		return logSenderSSH
+	case protocolSync:
+		return syncLogSender
+	default:
+		return logSender
+	}
+}
+
 func (c Connection) hasSpace(checkFiles bool) bool {
 	if (checkFiles && c.User.QuotaFiles > 0) || c.User.QuotaSize > 0 {
 		numFile, size, err := dataprovider.GetUsedQuota(dataProvider, c.User.Username)
@@ -568,6 +740,10 @@ func (c Connection) hasSpace(checkFiles bool) bool {
 			c.Log(logger.LevelWarn, logSender, "error getting used quota for %#v: %v", c.User.Username, err)
 			return false
 		}
+		// the batched quota updates for the ingestion folders are not yet applied
+		pendingFiles, pendingSize := ingestionBatch.getPendingQuota(c.User.Username)
+		numFile += pendingFiles
+		size += pendingSize
 		if (checkFiles && c.User.QuotaFiles > 0 && numFile >= c.User.QuotaFiles) ||
 			(c.User.QuotaSize > 0 && size >= c.User.QuotaSize) {
 			c.Log(logger.LevelDebug, logSender, "quota exceed for user %#v, num files: %v/%v, size: %v/%v check files: %v",
@@ -578,6 +754,104 @@ func (c Connection) hasSpace(checkFiles bool) bool {
 	return true
 }
 
+// hasSpaceForPath is like hasSpace but the quota limits for the shared folder that contains
+// the given filesystem path, if any, are checked instead of the user ones
+func (c Connection) hasSpaceForPath(checkFiles bool, fsPath string) bool {
+	return c.hasSpaceForFolder(checkFiles, c.getQuotaFolder(fsPath))
+}
+
+// hasSpaceForFolder checks the quota limits for the given shared folder, the user quota limits
+// are checked if folderName is empty
+func (c Connection) hasSpaceForFolder(checkFiles bool, folderName string) bool {
+	if len(folderName) == 0 {
+		return c.hasSpace(checkFiles)
+	}
+	if dataprovider.GetQuotaTracking() == 0 {
+		return true
+	}
+	folder, err := dataprovider.FolderExists(dataProvider, folderName)
+	if err != nil {
+		c.Log(logger.LevelWarn, logSender, "error getting used quota for folder %#v: %v", folderName, err)
+		return false
+	}
+	if (checkFiles && folder.QuotaFiles > 0 && folder.UsedQuotaFiles >= folder.QuotaFiles) ||
+		(folder.QuotaSize > 0 && folder.UsedQuotaSize >= folder.QuotaSize) {
+		c.Log(logger.LevelDebug, logSender, "quota exceed for folder %#v, num files: %v/%v, size: %v/%v check files: %v",
+			folderName, folder.UsedQuotaFiles, folder.QuotaFiles, folder.UsedQuotaSize, folder.QuotaSize, checkFiles)
+		return false
+	}
+	return true
+}
+
+// hasSizeQuotaForPath returns true if size bytes can be added without exceeding the size
+// quota for the shared folder that contains the given filesystem path or the user size quota
+// if the path is not inside a shared folder
+func (c Connection) hasSizeQuotaForPath(fsPath string, size int64) bool {
+	var quotaSize, usedSize int64
+	folderName := c.getQuotaFolder(fsPath)
+	if len(folderName) == 0 {
+		if c.User.QuotaSize <= 0 {
+			return true
+		}
+		_, used, err := dataprovider.GetUsedQuota(dataProvider, c.User.Username)
+		if err != nil {
+			if _, ok := err.(*dataprovider.MethodDisabledError); ok {
+				c.Log(logger.LevelWarn, logSender, "quota enforcement not possible for user %#v: %v", c.User.Username, err)
+				return true
+			}
+			c.Log(logger.LevelWarn, logSender, "error getting used quota for %#v: %v", c.User.Username, err)
+			return false
+		}
+		_, pendingSize := ingestionBatch.getPendingQuota(c.User.Username)
+		quotaSize = c.User.QuotaSize
+		usedSize = used + pendingSize
+	} else {
+		if dataprovider.GetQuotaTracking() == 0 {
+			return true
+		}
+		folder, err := dataprovider.FolderExists(dataProvider, folderName)
+		if err != nil {
+			c.Log(logger.LevelWarn, logSender, "error getting used quota for folder %#v: %v", folderName, err)
+			return false
+		}
+		if folder.QuotaSize <= 0 {
+			return true
+		}
+		quotaSize = folder.QuotaSize
+		usedSize = folder.UsedQuotaSize
+	}
+	if usedSize+size > quotaSize {
+		c.Log(logger.LevelDebug, logSender, "size quota exceeded for path %#v, used: %v, requested: %v, quota: %v",
+			fsPath, usedSize, size, quotaSize)
+		return false
+	}
+	return true
+}
+
+// getQuotaFolder returns the name of the shared folder that tracks the quota for the given
+// filesystem path, an empty string means that the quota is tracked for the user
+func (c Connection) getQuotaFolder(fsPath string) string {
+	if len(c.User.VirtualFolders) == 0 {
+		return ""
+	}
+	return c.User.GetSharedFolderForPath(c.fs.GetRelativePath(fsPath))
+}
+
+// updatePathQuota updates the quota for the shared folder that contains the given filesystem
+// path or the user quota if the path is not inside a shared folder
+func (c Connection) updatePathQuota(fsPath string, numFiles int, size int64) {
+	updateQuota(c.User, c.getQuotaFolder(fsPath), numFiles, size)
+}
+
+// updateQuota updates the quota for the given shared folder or the user quota if folderName is empty
+func updateQuota(user dataprovider.User, folderName string, numFiles int, size int64) {
+	if len(folderName) > 0 {
+		dataprovider.UpdateFolderQuota(dataProvider, folderName, numFiles, size, false)
+		return
+	}
+	dataprovider.UpdateUserQuota(dataProvider, user, numFiles, size, false)
+}
+
 func (c Connection) close() error {
 	if c.channel != nil {
 		err := c.channel.Close()
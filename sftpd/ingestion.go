@@ -0,0 +1,321 @@
+package sftpd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	ingestionLogSender = "ingestion"
+	// the pending quota updates and upload actions for the ingestion folders are applied
+	// and executed at this interval
+	ingestionFlushInterval = 10 * time.Second
+	// prefix for the roll-up archives, the files with this prefix are never rolled up
+	ingestionRollupPrefix = "rollup-"
+)
+
+var (
+	ingestionBatch        = newIngestionBatcher()
+	ingestionRollupMutex  sync.Mutex
+	ingestionSchedulerRun sync.Once
+)
+
+type pendingQuotaUpdate struct {
+	user     dataprovider.User
+	numFiles int
+	size     int64
+}
+
+type coalescedActionKey struct {
+	username string
+	path     string
+}
+
+// ingestionBatcher collects the quota updates and the upload actions for the
+// uploads inside the ingestion folders
+type ingestionBatcher struct {
+	sync.Mutex
+	quota     map[string]*pendingQuotaUpdate
+	actions   []ActionNotification
+	actionIdx map[coalescedActionKey]int
+}
+
+func newIngestionBatcher() *ingestionBatcher {
+	return &ingestionBatcher{
+		quota:     make(map[string]*pendingQuotaUpdate),
+		actionIdx: make(map[coalescedActionKey]int),
+	}
+}
+
+func (b *ingestionBatcher) addQuotaUpdate(user dataprovider.User, numFiles int, size int64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if update, ok := b.quota[user.Username]; ok {
+		update.user = user
+		update.numFiles += numFiles
+		update.size += size
+		return
+	}
+	b.quota[user.Username] = &pendingQuotaUpdate{
+		user:     user,
+		numFiles: numFiles,
+		size:     size,
+	}
+}
+
+// getPendingQuota returns the quota updates not yet applied for the given user
+func (b *ingestionBatcher) getPendingQuota(username string) (int, int64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if update, ok := b.quota[username]; ok {
+		return update.numFiles, update.size
+	}
+	return 0, 0
+}
+
+// addAction adds an upload action, an action not yet executed for the same file is replaced
+func (b *ingestionBatcher) addAction(a ActionNotification) {
+	b.Lock()
+	defer b.Unlock()
+
+	key := coalescedActionKey{
+		username: a.Username,
+		path:     a.Path,
+	}
+	if idx, ok := b.actionIdx[key]; ok {
+		b.actions[idx] = a
+		return
+	}
+	b.actionIdx[key] = len(b.actions)
+	b.actions = append(b.actions, a)
+}
+
+func (b *ingestionBatcher) flush() {
+	b.Lock()
+	quota := b.quota
+	actions := b.actions
+	b.quota = make(map[string]*pendingQuotaUpdate)
+	b.actions = nil
+	b.actionIdx = make(map[coalescedActionKey]int)
+	b.Unlock()
+
+	for _, update := range quota {
+		if update.numFiles == 0 && update.size == 0 {
+			continue
+		}
+		err := dataprovider.UpdateUserQuota(dataProvider, update.user, update.numFiles, update.size, false)
+		logger.Debug(ingestionLogSender, "", "batched quota update for user %#v, files: %v, size: %v, err: %v",
+			update.user.Username, update.numFiles, update.size, err)
+	}
+	for _, a := range actions {
+		executeAction(a)
+	}
+}
+
+// FlushIngestionBatches applies the pending quota updates and executes the pending
+// upload actions for the ingestion folders
+func FlushIngestionBatches() {
+	ingestionBatch.flush()
+}
+
+func startIngestionScheduler() {
+	ingestionSchedulerRun.Do(func() {
+		go func() {
+			for range time.Tick(ingestionFlushInterval) {
+				FlushIngestionBatches()
+			}
+		}()
+		go func() {
+			for range time.Tick(1 * time.Hour) {
+				RollUpIngestionFolders()
+			}
+		}()
+	})
+}
+
+// RollUpIngestionFolders rolls up, for all the users, the files uploaded before the
+// current hour inside the ingestion folders with hourly roll-up enabled
+func RollUpIngestionFolders() error {
+	ingestionRollupMutex.Lock()
+	defer ingestionRollupMutex.Unlock()
+
+	users, err := dataprovider.DumpUsers(dataProvider)
+	if err != nil {
+		logger.Warn(ingestionLogSender, "", "unable to get the users to roll up: %v", err)
+		return err
+	}
+	before := time.Now().Truncate(time.Hour)
+	for _, user := range users {
+		for _, folder := range user.Filters.IngestionFolders {
+			if !folder.HourlyRollup {
+				continue
+			}
+			if err := rollUpIngestionFolder(user, folder.Path, before); err != nil {
+				logger.Warn(ingestionLogSender, "", "unable to roll up folder %#v for user %#v: %v", folder.Path,
+					user.Username, err)
+			}
+		}
+	}
+	return nil
+}
+
+func rollUpIngestionFolder(user dataprovider.User, folderPath string, before time.Time) error {
+	fs, err := user.GetFilesystem(ingestionLogSender)
+	if err != nil {
+		return err
+	}
+	dirPath, err := fs.ResolvePath(folderPath)
+	if err != nil {
+		return err
+	}
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	hours := make(map[string][]os.FileInfo)
+	for _, info := range entries {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ingestionRollupPrefix) ||
+			!info.ModTime().Before(before) {
+			continue
+		}
+		hour := info.ModTime().UTC().Format("2006010215")
+		hours[hour] = append(hours[hour], info)
+	}
+	var keys []string
+	for hour := range hours {
+		keys = append(keys, hour)
+	}
+	sort.Strings(keys)
+	for _, hour := range keys {
+		if err := rollUpIngestionFiles(user, fs, dirPath, hour, hours[hour]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollUpIngestionFiles archives the given files, inside dirPath, into a compressed tar archive
+// and then removes them
+func rollUpIngestionFiles(user dataprovider.User, fs vfs.Fs, dirPath, hour string, files []os.FileInfo) error {
+	archivePath := fs.Join(dirPath, fmt.Sprintf("%v%v.tar.gz", ingestionRollupPrefix, hour))
+	for idx := 1; ; idx++ {
+		if _, err := fs.Stat(archivePath); err != nil {
+			if fs.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+		archivePath = fs.Join(dirPath, fmt.Sprintf("%v%v-%v.tar.gz", ingestionRollupPrefix, hour, idx))
+	}
+	file, w, cancelFn, err := fs.Create(archivePath, 0)
+	if err != nil {
+		return err
+	}
+	var dst io.WriteCloser = w
+	if file != nil {
+		dst = file
+	}
+	counter := &countingWriter{w: dst}
+	err = writeIngestionArchive(fs, dirPath, files, counter)
+	if err != nil && cancelFn != nil {
+		cancelFn()
+	}
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		if errRemove := fs.Remove(archivePath, false); errRemove != nil && !fs.IsNotExist(errRemove) {
+			logger.Warn(ingestionLogSender, "", "unable to remove the incomplete archive %#v: %v", archivePath, errRemove)
+		}
+		return err
+	}
+	vfs.SetPathPermissions(fs, archivePath, user.GetUID(), user.GetGID())
+	archiveSize := counter.written
+	if info, err := fs.Stat(archivePath); err == nil {
+		archiveSize = vfs.GetFileUsage(fs, info)
+	}
+	numFiles := 1
+	size := archiveSize
+	for _, info := range files {
+		p := fs.Join(dirPath, info.Name())
+		if err := fs.Remove(p, false); err != nil {
+			logger.Warn(ingestionLogSender, "", "unable to remove the rolled up file %#v: %v", p, err)
+			continue
+		}
+		numFiles--
+		size -= vfs.GetFileUsage(fs, info)
+	}
+	updateQuota(user, user.GetSharedFolderForPath(fs.GetRelativePath(dirPath)), numFiles, size)
+	logger.Info(ingestionLogSender, "", "files rolled up for user %#v, archive: %#v, files: %v, size: %v",
+		user.Username, archivePath, len(files), counter.written)
+	return nil
+}
+
+func writeIngestionArchive(fs vfs.Fs, dirPath string, files []os.FileInfo, w io.Writer) error {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	for _, info := range files {
+		if err := addIngestionArchiveEntry(fs, fs.Join(dirPath, info.Name()), info, tw); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+func addIngestionArchiveEntry(fs vfs.Fs, filePath string, info os.FileInfo, tw *tar.Writer) error {
+	file, r, cancelFn, err := fs.Open(filePath)
+	if err != nil {
+		return err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var src io.ReadCloser = r
+	if file != nil {
+		src = file
+	}
+	defer src.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     info.Name(),
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, src)
+	return err
+}
+
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
@@ -2,11 +2,18 @@ package sftpd
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -16,10 +23,13 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/policy"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
 )
 
 type MockChannel struct {
@@ -159,6 +169,41 @@ func TestNewActionNotification(t *testing.T) {
 	}
 }
 
+func TestIngestionBatcher(t *testing.T) {
+	user := dataprovider.User{
+		Username: "username",
+	}
+	b := newIngestionBatcher()
+	b.addQuotaUpdate(user, 1, 100)
+	b.addQuotaUpdate(user, 1, 50)
+	b.addQuotaUpdate(user, 0, -20)
+	numFiles, size := b.getPendingQuota(user.Username)
+	if numFiles != 2 || size != 130 {
+		t.Errorf("unexpected pending quota, files: %v, size: %v", numFiles, size)
+	}
+	numFiles, size = b.getPendingQuota("missing")
+	if numFiles != 0 || size != 0 {
+		t.Errorf("unexpected pending quota, files: %v, size: %v", numFiles, size)
+	}
+	b.addAction(newActionNotification(user, operationUpload, "/logs/file1", "", "", 10, nil))
+	b.addAction(newActionNotification(user, operationUpload, "/logs/file2", "", "", 20, nil))
+	b.addAction(newActionNotification(user, operationUpload, "/logs/file1", "", "", 30, nil))
+	if len(b.actions) != 2 {
+		t.Errorf("upload actions for the same file must be coalesced, actions: %v", len(b.actions))
+	} else if b.actions[0].Path != "/logs/file1" || b.actions[0].FileSize != 30 {
+		t.Errorf("unexpected coalesced action: %+v", b.actions[0])
+	}
+	b.quota = make(map[string]*pendingQuotaUpdate)
+	b.flush()
+	if len(b.actions) != 0 || len(b.actionIdx) != 0 {
+		t.Errorf("pending actions must be empty after flush")
+	}
+	b.addAction(newActionNotification(user, operationUpload, "/logs/file1", "", "", 10, nil))
+	if len(b.actions) != 1 {
+		t.Errorf("unexpected actions: %v", len(b.actions))
+	}
+}
+
 func TestWrongActions(t *testing.T) {
 	actionsCopy := actions
 	badCommand := "/bad/command"
@@ -208,6 +253,41 @@ func TestActionHTTP(t *testing.T) {
 	actions = actionsCopy
 }
 
+func TestActionHooks(t *testing.T) {
+	actionsCopy := actions
+	actions = Actions{
+		ExecuteOn: []string{},
+	}
+	var notified []ActionNotification
+	RegisterActionHook(func(a ActionNotification) {
+		notified = append(notified, a)
+	})
+	user := dataprovider.User{
+		Username: "username",
+	}
+	err := executeAction(newActionNotification(user, operationUpload, "/path", "", "", 10, nil))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = executeAction(newActionNotification(user, operationRename, "/path", "/target", "", 0, nil))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(notified) != 2 {
+		t.Fatalf("the hooks must be executed even if the actions are not in execute_on, notified: %v", len(notified))
+	}
+	if notified[0].Action != operationUpload || notified[0].FileSize != 10 || notified[0].Username != user.Username {
+		t.Errorf("unexpected notification: %+v", notified[0])
+	}
+	if notified[1].Action != operationRename || notified[1].TargetPath != "/target" {
+		t.Errorf("unexpected notification: %+v", notified[1])
+	}
+	actionHooksMutex.Lock()
+	actionHooks = nil
+	actionHooksMutex.Unlock()
+	actions = actionsCopy
+}
+
 func TestRemoveNonexistentTransfer(t *testing.T) {
 	transfer := Transfer{}
 	err := removeTransfer(&transfer)
@@ -430,6 +510,52 @@ func TestMockFsErrors(t *testing.T) {
 	os.Remove(testfile)
 }
 
+func TestSetstatSizeAndTimes(t *testing.T) {
+	u := dataprovider.User{
+		Username:    "test_setstat",
+		HomeDir:     os.TempDir(),
+		Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+	}
+	c := Connection{
+		fs:   vfs.NewOsFs("123", os.TempDir(), nil),
+		User: u,
+	}
+	testfile := filepath.Join(u.HomeDir, "setstat_file")
+	err := ioutil.WriteFile(testfile, []byte("test data"), 0666)
+	if err != nil {
+		t.Fatalf("unable to create test file: %v", err)
+	}
+	defer os.Remove(testfile)
+	modTime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	// size, atime and mtime as sent inside a SSH_FXP_SETSTAT packet
+	attrs := make([]byte, 16)
+	binary.BigEndian.PutUint64(attrs, 4)
+	binary.BigEndian.PutUint32(attrs[8:], uint32(modTime.Unix()))
+	binary.BigEndian.PutUint32(attrs[12:], uint32(modTime.Unix()))
+	request := sftp.NewRequest("Setstat", "/setstat_file")
+	request.Flags = 0x00000001 | 0x00000008
+	request.Attrs = attrs
+	err = c.handleSFTPSetstat(testfile, request)
+	if err != nil {
+		t.Errorf("unexpected setstat error: %v", err)
+	}
+	fi, err := os.Stat(testfile)
+	if err != nil {
+		t.Fatalf("unable to stat test file: %v", err)
+	}
+	if fi.Size() != 4 {
+		t.Errorf("the file must be truncated, size: %v", fi.Size())
+	}
+	if !fi.ModTime().Equal(modTime) {
+		t.Errorf("unexpected modification time: %v, expected: %v", fi.ModTime(), modTime)
+	}
+	c.User.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermChtimes}
+	err = c.handleSFTPSetstat(testfile, request)
+	if err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("the truncate must be denied without the overwrite permission, got: %v", err)
+	}
+}
+
 func TestUploadFiles(t *testing.T) {
 	oldUploadMode := uploadMode
 	uploadMode = uploadModeAtomic
@@ -484,7 +610,7 @@ func TestUploadFiles(t *testing.T) {
 func TestWithInvalidHome(t *testing.T) {
 	u := dataprovider.User{}
 	u.HomeDir = "home_rel_path"
-	_, err := loginUser(u, dataprovider.SSHLoginMethodPassword, "", nil)
+	_, err := loginUser(u, dataprovider.SSHLoginMethodPassword, "", nil, nil)
 	if err == nil {
 		t.Errorf("login a user with an invalid home_dir must fail")
 	}
@@ -521,6 +647,232 @@ func TestSFTPCmdTargetPath(t *testing.T) {
 	}
 }
 
+func TestConnectionTagsFilter(t *testing.T) {
+	versions := map[string]string{
+		"SSH-2.0-WinSCP_release_5.17.7": "WinSCP",
+		"SSH-2.0-OpenSSH_8.2p1 Ubuntu":  "OpenSSH",
+		"SSH-2.0-Cyberduck/7.5.1":       "Cyberduck",
+		"SSH-2.0-JSCH-0.1.54":           "JSCH",
+		"SSH-1.99-PuTTY_Release_0.74":   "PuTTY",
+		"SSH-2.0-Go":                    "Go",
+		"":                              "",
+	}
+	for version, software := range versions {
+		if getClientSoftware(version) != software {
+			t.Errorf("unexpected client software for %#v: %#v", version, getClientSoftware(version))
+		}
+	}
+	c := ConnectionStatus{
+		ClientSoftware: "WinSCP",
+		Tenant:         "ACME",
+	}
+	filter := ConnectionTagsFilter{}
+	if !filter.Match(&c) {
+		t.Error("an empty filter must match any connection")
+	}
+	filter.ClientSoftware = "winscp"
+	filter.Tenant = "acme"
+	if !filter.Match(&c) {
+		t.Error("the filter must match")
+	}
+	filter.Label = "label"
+	if filter.Match(&c) {
+		t.Error("the filter must not match a connection without label")
+	}
+	if SetConnectionLabel("missing_id", "label") {
+		t.Error("set label for a missing connection must fail")
+	}
+	if IsValidConnectionLabel(strings.Repeat("a", maxConnectionLabelLength+1)) {
+		t.Error("the label must be too long")
+	}
+}
+
+func TestVirtualFolderFilesystem(t *testing.T) {
+	u := dataprovider.User{}
+	u.HomeDir = os.TempDir()
+	accessSecret, _ := utils.EncryptData("secret")
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/archive",
+		Filesystem: &vfs.VirtualFolderFilesystem{
+			Provider: 1,
+			S3Config: vfs.S3FsConfig{
+				Bucket:       "archive",
+				Region:       "us-east-1",
+				AccessKey:    "key",
+				AccessSecret: accessSecret,
+				Endpoint:     "http://127.0.0.1:9000",
+				KeyPrefix:    "prefix/",
+			},
+		},
+	})
+	fs, err := u.GetFilesystem("123")
+	if err != nil {
+		t.Fatalf("unable to get the filesystem: %v", err)
+	}
+	if vfs.IsLocalOsFs(fs) {
+		t.Error("a filesystem with a virtual folder backend must not be a local filesystem")
+	}
+	fsPath, err := fs.ResolvePath("/archive/dir/file")
+	if err != nil {
+		t.Errorf("unable to resolve path: %v", err)
+	}
+	if !strings.HasPrefix(fsPath, "[/archive]:") {
+		t.Errorf("unexpected path for the virtual folder backend: %#v", fsPath)
+	}
+	if fs.GetRelativePath(fsPath) != "/archive/dir/file" {
+		t.Errorf("unexpected relative path: %#v", fs.GetRelativePath(fsPath))
+	}
+	if fs.GetRelativePath(fs.Join(fsPath, "sub")) != "/archive/dir/file/sub" {
+		t.Errorf("unexpected relative path for joined path: %#v", fs.GetRelativePath(fs.Join(fsPath, "sub")))
+	}
+	fsPath, err = fs.ResolvePath("/archived/file")
+	if err != nil {
+		t.Errorf("unable to resolve path: %v", err)
+	}
+	if fsPath != filepath.Join(os.TempDir(), "archived", "file") {
+		t.Errorf("unexpected path for the local filesystem: %#v", fsPath)
+	}
+	if fs.GetRelativePath(fsPath) != "/archived/file" {
+		t.Errorf("unexpected relative path: %#v", fs.GetRelativePath(fsPath))
+	}
+	localPath, _ := fs.ResolvePath("/file")
+	archivePath, _ := fs.ResolvePath("/archive/file")
+	if err := fs.Rename(localPath, archivePath); err == nil {
+		t.Error("rename across different filesystems must fail")
+	}
+	if vfs.GetBackendType(fs, archivePath) != "s3" {
+		t.Errorf("unexpected backend type for the virtual folder: %#v", vfs.GetBackendType(fs, archivePath))
+	}
+	if vfs.GetBackendType(fs, localPath) != "local" {
+		t.Errorf("unexpected backend type for the local filesystem: %#v", vfs.GetBackendType(fs, localPath))
+	}
+	u.VirtualFolders[0].Filesystem.S3Config.Bucket = ""
+	_, err = u.GetFilesystem("123")
+	if err == nil {
+		t.Error("get filesystem with an invalid virtual folder backend must fail")
+	}
+}
+
+func TestTransferHistograms(t *testing.T) {
+	file, err := ioutil.TempFile("", "histogram")
+	if err != nil {
+		t.Fatalf("unable to create a temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	transfer := Transfer{
+		file:          file,
+		path:          file.Name(),
+		start:         time.Now(),
+		bytesSent:     2048,
+		bytesReceived: 0,
+		user: dataprovider.User{
+			Username: "testuser",
+		},
+		transferType: transferDownload,
+		lastActivity: time.Now(),
+		protocol:     protocolSCP,
+		backend:      "unsupported backend",
+		lock:         new(sync.Mutex),
+	}
+	err = transfer.Close()
+	if err != nil {
+		t.Errorf("unable to close the transfer: %v", err)
+	}
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather the metrics: %v", err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() != "sftpgo_transfer_size_bytes" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["protocol"] == protocolSCP && labels["backend"] == "other" && labels["direction"] == "download" {
+				found = metric.GetHistogram().GetSampleCount() > 0
+			}
+		}
+	}
+	if !found {
+		t.Error("the transfer size histogram must be updated using the \"other\" backend label")
+	}
+}
+
+func TestSlowTransfers(t *testing.T) {
+	oldSlowTransfers := slowTransfers
+	slowTransfers = SlowTransfersConfig{
+		MinSpeed: 10,
+		Period:   30,
+	}
+	start := time.Now()
+	transfer := Transfer{
+		path:          "slowfile",
+		start:         start,
+		bytesSent:     0,
+		bytesReceived: 0,
+		user: dataprovider.User{
+			Username: "testuser",
+		},
+		transferType: transferUpload,
+		lastActivity: start,
+		protocol:     protocolSFTP,
+		lock:         new(sync.Mutex),
+	}
+	addTransfer(&transfer)
+	// 100 KB in 10 seconds, above the minimum speed
+	transfer.bytesReceived = 100000
+	checkSlowTransfers(start.Add(10 * time.Second))
+	if transfer.speedState.degraded || !transfer.speedState.slowSince.IsZero() {
+		t.Errorf("the transfer must not be slow: %+v", transfer.speedState)
+	}
+	// 50 KB in 10 seconds, below the minimum speed but not for the configured period
+	transfer.bytesReceived = 150000
+	checkSlowTransfers(start.Add(20 * time.Second))
+	if transfer.speedState.degraded {
+		t.Error("the transfer must not be degraded before the configured period")
+	}
+	if !transfer.speedState.slowSince.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("unexpected slow since: %v", transfer.speedState.slowSince)
+	}
+	checkSlowTransfers(start.Add(40 * time.Second))
+	if !transfer.speedState.degraded {
+		t.Error("the transfer must be degraded")
+	}
+	// speed back above the minimum speed
+	transfer.bytesReceived = 400000
+	checkSlowTransfers(start.Add(50 * time.Second))
+	if transfer.speedState.degraded || !transfer.speedState.slowSince.IsZero() {
+		t.Errorf("the transfer must be restored: %+v", transfer.speedState)
+	}
+	// the checks are disabled if the minimum speed is 0
+	slowTransfers.MinSpeed = 0
+	checkSlowTransfers(start.Add(200 * time.Second))
+	if !transfer.speedState.slowSince.IsZero() {
+		t.Errorf("the slow transfers check must be disabled: %+v", transfer.speedState)
+	}
+	err := removeTransfer(&transfer)
+	if err != nil {
+		t.Errorf("unable to remove the transfer: %v", err)
+	}
+	slowTransfers = oldSlowTransfers
+
+	connTransfer := connectionTransfer{
+		OperationType: operationUpload,
+		StartTime:     utils.GetTimeAsMsSinceEpoch(start),
+		Size:          150000,
+		LastActivity:  utils.GetTimeAsMsSinceEpoch(start),
+		Path:          "/slowfile",
+		Degraded:      true,
+	}
+	if !strings.HasSuffix(connTransfer.getConnectionTransferAsString(), "(degraded)") {
+		t.Errorf("the degraded state must be reported: %v", connTransfer.getConnectionTransferAsString())
+	}
+}
+
 func TestGetSFTPErrorFromOSError(t *testing.T) {
 	err := os.ErrNotExist
 	fs := vfs.NewOsFs("", os.TempDir(), nil)
@@ -539,6 +891,77 @@ func TestGetSFTPErrorFromOSError(t *testing.T) {
 	}
 }
 
+func TestConcurrentQuotaScan(t *testing.T) {
+	rootDir := filepath.Join(os.TempDir(), "quotascan")
+	expectedFiles := 0
+	expectedSize := int64(0)
+	dirPath := rootDir
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 3; j++ {
+			subDir := filepath.Join(dirPath, fmt.Sprintf("dir%v", j))
+			err := os.MkdirAll(subDir, 0777)
+			if err != nil {
+				t.Fatalf("unable to create dir: %v", err)
+			}
+			err = ioutil.WriteFile(filepath.Join(subDir, "file"), make([]byte, i+j+1), 0666)
+			if err != nil {
+				t.Fatalf("unable to create file: %v", err)
+			}
+			expectedFiles++
+			expectedSize += int64(i + j + 1)
+		}
+		dirPath = filepath.Join(dirPath, "dir0")
+	}
+	fs := vfs.NewOsFs("", rootDir, nil)
+	for _, workers := range []int{0, 1, 2, 16} {
+		vfs.SetQuotaScanWorkers(workers)
+		numFiles, size, err := fs.ScanRootDirContents()
+		if err != nil {
+			t.Errorf("unable to scan dir with %v workers: %v", workers, err)
+		}
+		if numFiles != expectedFiles || size != expectedSize {
+			t.Errorf("unexpected scan results with %v workers, files: %v/%v, size: %v/%v", workers, numFiles,
+				expectedFiles, size, expectedSize)
+		}
+	}
+	vfs.SetQuotaScanWorkers(0)
+	os.RemoveAll(rootDir)
+}
+
+func TestWindowsACLConfig(t *testing.T) {
+	config := vfs.WindowsACLConfig{
+		Owner:         "invalid owner name",
+		HonorDenyACLs: true,
+	}
+	err := vfs.SetWindowsACLConfig(config)
+	if runtime.GOOS == "windows" {
+		if err == nil {
+			t.Error("setting an invalid owner must fail")
+		}
+	} else if err != nil {
+		t.Errorf("windows ACL config must be ignored, error: %v", err)
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	dirPath := filepath.Join(os.TempDir(), "acltest")
+	err = fs.Mkdir(dirPath)
+	if err != nil {
+		t.Errorf("unable to create dir: %v", err)
+	}
+	vfs.SetPathPermissions(fs, dirPath, 0, 0)
+	_, err = fs.ReadDir(dirPath)
+	if err != nil {
+		t.Errorf("unable to read dir: %v", err)
+	}
+	err = fs.Remove(dirPath, true)
+	if err != nil {
+		t.Errorf("unable to remove dir: %v", err)
+	}
+	err = vfs.SetWindowsACLConfig(vfs.WindowsACLConfig{})
+	if err != nil {
+		t.Errorf("unable to reset windows ACL config: %v", err)
+	}
+}
+
 func TestSetstatModeIgnore(t *testing.T) {
 	originalMode := setstatMode
 	setstatMode = 1
@@ -576,6 +999,158 @@ func TestSupportedSSHCommands(t *testing.T) {
 		if !utils.IsStringInSlice(c, supportedSSHCommands) {
 			t.Errorf("invalid ssh command: %v", c)
 		}
+		if _, ok := sshCommandsPathAccess[c]; !ok {
+			t.Errorf("the path access for ssh command %v is not defined", c)
+		}
+	}
+}
+
+func TestSSHCommandsPathAccess(t *testing.T) {
+	user := dataprovider.User{
+		Username: "test_path_access",
+		HomeDir:  os.TempDir(),
+		Status:   1,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions["/download"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user.Permissions["/upload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	user.Permissions["/list"] = []string{dataprovider.PermListItems}
+	user.Filters.FileExtensions = []dataprovider.ExtensionsFilter{
+		{
+			Path:              "/",
+			AllowedExtensions: []string{},
+			DeniedExtensions:  []string{".zip"},
+		},
+	}
+	fs, _ := user.GetFilesystem("123")
+	connection := Connection{
+		User: user,
+		fs:   fs,
+	}
+	tests := []struct {
+		path     string
+		access   pathAccess
+		expected error
+	}{
+		{"/file.txt", pathAccessNone, nil},
+		{"/file.txt", pathAccessReadFile, nil},
+		{"/file.zip", pathAccessReadFile, errPermissionDenied},
+		{"/download/file.txt", pathAccessReadFile, nil},
+		{"/upload/file.txt", pathAccessReadFile, errPermissionDenied},
+		{"/download", pathAccessReadDir, nil},
+		{"/list", pathAccessReadDir, errPermissionDenied},
+		{"/upload/file.txt", pathAccessCreateFile, nil},
+		{"/upload/file.zip", pathAccessCreateFile, errPermissionDenied},
+		{"/download/file.txt", pathAccessCreateFile, errPermissionDenied},
+		{"/file.txt", pathAccessOverwriteFile, nil},
+		{"/upload/file.txt", pathAccessOverwriteFile, errPermissionDenied},
+		{"/file.zip", pathAccessOverwriteFile, errPermissionDenied},
+		{"/dir", pathAccessCreateDir, nil},
+		{"/list/dir", pathAccessCreateDir, errPermissionDenied},
+		{"/repo", pathAccessFull, nil},
+		{"/list/repo", pathAccessFull, errPermissionDenied},
+	}
+	for _, test := range tests {
+		err := connection.checkPathAccess(test.path, test.access)
+		if err != test.expected {
+			t.Errorf("unexpected result for %v access to %#v: %v", test.access, test.path, err)
+		}
+	}
+	// the quota is checked after the permissions, the user does not exist inside the data
+	// provider so the used quota cannot be checked
+	connection.User.QuotaFiles = 10
+	for _, access := range []pathAccess{pathAccessCreateFile, pathAccessFull} {
+		err := connection.checkPathAccess("/upload/file.txt", access)
+		if access == pathAccessFull && err != errPermissionDenied {
+			t.Errorf("unexpected result for %v access: %v", access, err)
+		}
+		if access == pathAccessCreateFile && err != errQuotaExceeded {
+			t.Errorf("unexpected result for %v access: %v", access, err)
+		}
+	}
+	// overwriting a file does not change the number of files
+	err := connection.checkPathAccess("/file.txt", pathAccessOverwriteFile)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	connection.User.QuotaFiles = 0
+	connection.User.Filters.ReadOnly = true
+	connection.fs, _ = connection.User.GetFilesystem("123")
+	err = connection.checkPathAccess("/repo", pathAccessFull)
+	if err != errPermissionDenied {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTransfersDeniedOnClosingConnection(t *testing.T) {
+	user := dataprovider.User{
+		Username:    "closing_user",
+		HomeDir:     os.TempDir(),
+		Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+	}
+	fs, _ := user.GetFilesystem("closing_id")
+	connection := Connection{
+		ID:   "closing_id",
+		User: user,
+		fs:   fs,
+	}
+	mutex.Lock()
+	closingConnections[connection.ID] = true
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		delete(closingConnections, connection.ID)
+		mutex.Unlock()
+	}()
+	for _, access := range []pathAccess{pathAccessReadFile, pathAccessReadDir, pathAccessCreateFile,
+		pathAccessOverwriteFile, pathAccessFull} {
+		err := connection.checkPathAccess("/file.txt", access)
+		if err != errPermissionDenied {
+			t.Errorf("%v access must be denied on a closing connection, got: %v", access, err)
+		}
+	}
+	if err := connection.checkPathAccess("/dir", pathAccessCreateDir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !connection.isOpAllowed(policy.OpList, "/") {
+		t.Error("listing a directory must be allowed on a closing connection")
+	}
+	connection.ID = "another_id"
+	if !connection.isOpAllowed(policy.OpUpload, "/file.txt") {
+		t.Error("upload must be allowed on a connection that is not closing")
+	}
+}
+
+func TestTailStartOffset(t *testing.T) {
+	cmd := sshCommand{
+		command: tailCommand,
+	}
+	tests := []struct {
+		args     []string
+		expected int64
+		isValid  bool
+	}{
+		{[]string{"file"}, 100, true},
+		{[]string{"-c", "10", "file"}, 90, true},
+		{[]string{"-c", "1000", "file"}, 0, true},
+		{[]string{"-c", "+1", "file"}, 0, true},
+		{[]string{"-c", "+0", "file"}, 0, true},
+		{[]string{"-c", "+51", "file"}, 50, true},
+		{[]string{"-c", "-1", "file"}, 0, false},
+		{[]string{"-c", "a", "file"}, 0, false},
+		{[]string{"-n", "10", "file"}, 0, false},
+		{[]string{"-c", "file"}, 0, false},
+	}
+	for _, test := range tests {
+		cmd.args = test.args
+		offset, err := cmd.tailStartOffset(100)
+		if test.isValid && (err != nil || offset != test.expected) {
+			t.Errorf("unexpected offset for args %v: %v, err: %v", test.args, offset, err)
+		}
+		if !test.isValid && err == nil {
+			t.Errorf("args %v must be invalid", test.args)
+		}
 	}
 }
 
@@ -669,6 +1244,61 @@ func TestSSHParseCommandPayload(t *testing.T) {
 	}
 }
 
+func TestSSHCommandAudit(t *testing.T) {
+	var notified []ActionNotification
+	var notifiedMutex sync.Mutex
+	RegisterActionHook(func(a ActionNotification) {
+		notifiedMutex.Lock()
+		notified = append(notified, a)
+		notifiedMutex.Unlock()
+	})
+	connection := Connection{
+		ID: "audit_id",
+		User: dataprovider.User{
+			Username: "audit_user",
+		},
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2222},
+	}
+	if processSSHCommand([]byte("invalid payload"), &connection, nil, GetSupportedSSHCommands()) {
+		t.Error("an invalid exec request must be denied")
+	}
+	payload := ssh.Marshal(&sshSubsystemExecMsg{Command: "md5sum \\"})
+	if processSSHCommand(payload, &connection, nil, GetSupportedSSHCommands()) {
+		t.Error("an unparsable command must be denied")
+	}
+	payload = ssh.Marshal(&sshSubsystemExecMsg{Command: "scp -t"})
+	if processSSHCommand(payload, &connection, nil, GetSupportedSSHCommands()) {
+		t.Error("scp without a target must be denied")
+	}
+	connection.User.Filters.AllowedSSHCommands = []string{"scp"}
+	payload = ssh.Marshal(&sshSubsystemExecMsg{Command: "md5sum /file"})
+	if processSSHCommand(payload, &connection, nil, GetSupportedSSHCommands()) {
+		t.Error("a command not allowed for the user must be denied")
+	}
+	for i := 0; i < 50; i++ {
+		notifiedMutex.Lock()
+		n := len(notified)
+		notifiedMutex.Unlock()
+		if n >= 4 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	notifiedMutex.Lock()
+	if len(notified) != 4 {
+		t.Errorf("unexpected notifications: %+v", notified)
+	}
+	for _, a := range notified {
+		if a.Action != operationSSHExec || a.Status != 0 || a.Username != "audit_user" {
+			t.Errorf("unexpected notification: %+v", a)
+		}
+	}
+	notifiedMutex.Unlock()
+	actionHooksMutex.Lock()
+	actionHooks = nil
+	actionHooksMutex.Unlock()
+}
+
 func TestSSHCommandErrors(t *testing.T) {
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
@@ -736,6 +1366,19 @@ func TestSSHCommandErrors(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 	cmd.connection.User.Permissions["/"] = []string{dataprovider.PermAny}
+	cmd.connection.User.Filters.ReadOnly = true
+	fs, _ = cmd.connection.User.GetFilesystem("123")
+	if !vfs.IsReadOnlyFs(fs) {
+		t.Error("the filesystem must be read only")
+	}
+	cmd.connection.fs = fs
+	err = cmd.handle()
+	if err != errPermissionDenied {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cmd.connection.User.Filters.ReadOnly = false
+	fs, _ = cmd.connection.User.GetFilesystem("123")
+	cmd.connection.fs = fs
 	cmd.command = "invalid_command"
 	command, err := cmd.getSystemCommand()
 	if err != nil {
@@ -1860,3 +2503,929 @@ func TestProxyProtocolVersion(t *testing.T) {
 		t.Error("get proxy listener with invalid IP must fail")
 	}
 }
+
+func TestServiceIPFilters(t *testing.T) {
+	c := Configuration{
+		AllowedIP: []string{"invalid"},
+	}
+	if err := c.configureIPFilters(); err == nil {
+		t.Error("configuring invalid allowed IP ranges must fail")
+	}
+	c.AllowedIP = []string{"192.168.1.0/24", "10.8.0.0/16"}
+	c.DeniedIP = []string{"192.168.1.128/25"}
+	if err := c.configureIPFilters(); err != nil {
+		t.Errorf("unable to configure IP filters: %v", err)
+	}
+	if !isIPAllowed("192.168.1.1") {
+		t.Error("192.168.1.1 must be allowed")
+	}
+	if !isIPAllowed("10.8.2.1") {
+		t.Error("10.8.2.1 must be allowed")
+	}
+	if isIPAllowed("192.168.1.129") {
+		t.Error("192.168.1.129 must be denied")
+	}
+	if isIPAllowed("172.16.1.1") {
+		t.Error("172.16.1.1 is not in the allowed ranges, it must be denied")
+	}
+	if isIPAllowed("invalid") {
+		t.Error("an invalid IP must be denied if IP filters are configured")
+	}
+	c.AllowedIP = nil
+	c.DeniedIP = []string{"127.0.0.0/8"}
+	if err := c.configureIPFilters(); err != nil {
+		t.Errorf("unable to configure IP filters: %v", err)
+	}
+	if !isIPAllowed("172.16.1.1") {
+		t.Error("172.16.1.1 must be allowed")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer clientConn.Close()
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("unable to accept the connection: %v", err)
+	}
+	// the connection must be closed before the handshake, no server config is needed
+	c.AcceptInboundConnection(serverConn, nil)
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = clientConn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("the connection from a denied IP must be closed, read error: %v", err)
+	}
+	c.DeniedIP = nil
+	if err := c.configureIPFilters(); err != nil {
+		t.Errorf("unable to reset IP filters: %v", err)
+	}
+	if !isIPAllowed("127.0.0.1") {
+		t.Error("127.0.0.1 must be allowed without IP filters")
+	}
+}
+
+func TestRevokedKeys(t *testing.T) {
+	_, privKey1, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	_, privKey2, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	_, privKey3, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	signer1, _ := ssh.NewSignerFromKey(privKey1)
+	signer2, _ := ssh.NewSignerFromKey(privKey2)
+	signer3, _ := ssh.NewSignerFromKey(privKey3)
+	pubKey1 := signer1.PublicKey()
+	pubKey2 := signer2.PublicKey()
+	pubKey3 := signer3.PublicKey()
+	revokedFile := filepath.Join(os.TempDir(), "revoked_keys")
+	content := "# revoked keys\n" + string(ssh.MarshalAuthorizedKey(pubKey1)) + "\n" + ssh.FingerprintSHA256(pubKey2) + "\n"
+	err = ioutil.WriteFile(revokedFile, []byte(content), 0666)
+	if err != nil {
+		t.Fatalf("unable to write revoked keys file: %v", err)
+	}
+	r, err := newRevokedKeys(revokedFile)
+	if err != nil {
+		t.Errorf("unable to load revoked keys: %v", err)
+	}
+	if r.isRevoked(pubKey1) == nil {
+		t.Error("public key 1 must be revoked")
+	}
+	if r.isRevoked(pubKey2) == nil {
+		t.Error("public key 2 must be revoked")
+	}
+	if err = r.isRevoked(pubKey3); err != nil {
+		t.Errorf("public key 3 must not be revoked: %v", err)
+	}
+	// build a KRL with an explicit key section for key 3 and a SHA256 fingerprint section for key 1
+	sha256Hash := sha256.Sum256(pubKey1.Marshal())
+	sha1Hash := sha1.Sum(pubKey2.Marshal())
+	krl := getKRLHeader()
+	krl = append(krl, getKRLSection(krlSectionCertificates, []byte("ignored"))...)
+	krl = append(krl, getKRLSection(krlSectionExplicitKey, ssh.Marshal(struct{ B []byte }{pubKey3.Marshal()}))...)
+	krl = append(krl, getKRLSection(krlSectionFingerprintSHA1, ssh.Marshal(struct{ B []byte }{sha1Hash[:]}))...)
+	krl = append(krl, getKRLSection(krlSectionFingerprintSHA256, ssh.Marshal(struct{ B []byte }{sha256Hash[:]}))...)
+	krl = append(krl, getKRLSection(krlSectionSignature, []byte("signature"))...)
+	// the file size changes so it will be reloaded
+	err = ioutil.WriteFile(revokedFile, krl, 0666)
+	if err != nil {
+		t.Fatalf("unable to write revoked keys file: %v", err)
+	}
+	for _, pubKey := range []ssh.PublicKey{pubKey1, pubKey2, pubKey3} {
+		if r.isRevoked(pubKey) == nil {
+			t.Errorf("public key %v must be revoked", ssh.FingerprintSHA256(pubKey))
+		}
+	}
+	os.Remove(revokedFile)
+	if r.isRevoked(pubKey3) == nil {
+		t.Error("all the public keys must be refused if the revoked keys file cannot be loaded")
+	}
+	c := Configuration{
+		RevokedKeysFile: revokedFile,
+	}
+	if err = c.configureRevokedKeys(os.TempDir()); err == nil {
+		t.Error("configuring a missing revoked keys file must fail")
+	}
+	c.RevokedKeysFile = ""
+	if err = c.configureRevokedKeys(os.TempDir()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if revokedKeysList != nil {
+		t.Error("revoked keys list must be nil if no file is configured")
+	}
+}
+
+func TestConfigureDiskCache(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	c := Configuration{
+		DiskCache: vfs.DiskCacheConfig{
+			Path:    "cache",
+			MaxSize: 0,
+		},
+	}
+	if err = c.configureDiskCache(configDir); err == nil {
+		t.Error("configuring a disk cache without a max size must fail")
+	}
+	c.DiskCache.MaxSize = 10
+	c.DiskCache.MaxFileSize = 20
+	if err = c.configureDiskCache(configDir); err == nil {
+		t.Error("configuring a max file size bigger than the max size must fail")
+	}
+	c.DiskCache.MaxFileSize = 5
+	if err = c.configureDiskCache(configDir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the relative path is resolved using the config dir
+	if _, err = os.Stat(filepath.Join(configDir, "cache", "tmp")); err != nil {
+		t.Errorf("the disk cache directory must be created: %v", err)
+	}
+	c.DiskCache = vfs.DiskCacheConfig{}
+	if err = c.configureDiskCache(configDir); err != nil {
+		t.Errorf("unexpected error disabling the disk cache: %v", err)
+	}
+}
+
+func TestConfigureUploadJournal(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "uploadjournal")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	c := Configuration{
+		UploadJournal: vfs.UploadJournalConfig{
+			Path:   "journal",
+			MaxAge: -1,
+		},
+	}
+	if err = c.configureUploadJournal(configDir); err == nil {
+		t.Error("configuring an upload journal with a negative max age must fail")
+	}
+	c.UploadJournal.MaxAge = 0
+	if err = c.configureUploadJournal(configDir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the relative path is resolved using the config dir
+	if _, err = os.Stat(filepath.Join(configDir, "journal")); err != nil {
+		t.Errorf("the upload journal directory must be created: %v", err)
+	}
+	c.UploadJournal = vfs.UploadJournalConfig{}
+	if err = c.configureUploadJournal(configDir); err != nil {
+		t.Errorf("unexpected error disabling the upload journal: %v", err)
+	}
+}
+
+func TestGetFirstLoginTerms(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "firstlogin")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	c := Configuration{
+		FirstLogin: FirstLoginConfig{
+			Enabled:        true,
+			TermsOfUseFile: "terms",
+		},
+	}
+	if _, err = c.getFirstLoginTerms(configDir); err == nil {
+		t.Error("reading a missing terms of use file must fail")
+	}
+	termsFile := filepath.Join(configDir, "terms")
+	ioutil.WriteFile(termsFile, []byte("\n "), 0666)
+	if _, err = c.getFirstLoginTerms(configDir); err == nil {
+		t.Error("reading an empty terms of use file must fail")
+	}
+	ioutil.WriteFile(termsFile, []byte("terms of use\n"), 0666)
+	terms, err := c.getFirstLoginTerms(configDir)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if terms != "terms of use" {
+		t.Errorf("unexpected terms of use: %#v", terms)
+	}
+	c.FirstLogin.Enabled = false
+	terms, err = c.getFirstLoginTerms(configDir)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(terms) > 0 {
+		t.Error("the terms of use must be empty if the first login actions are disabled")
+	}
+}
+
+func TestFirstLoginStepRequired(t *testing.T) {
+	c := Configuration{}
+	user := dataprovider.User{
+		Username: "test_first_login",
+	}
+	user.Filters.FirstLogin.AcceptTerms = true
+	if c.isFirstLoginStepRequired(user, nil) {
+		t.Error("the first login step must not be required if the first login actions are disabled")
+	}
+	c.FirstLogin.Enabled = true
+	if !c.isFirstLoginStepRequired(user, nil) {
+		t.Error("the first login step must be required")
+	}
+	policy := &bindingLoginPolicy{
+		deniedLoginMethods: []string{dataprovider.SSHLoginMethodKeyAndKeyboardInt},
+	}
+	if c.isFirstLoginStepRequired(user, policy) {
+		t.Error("the first login step must not be required if keyboard interactive is denied for the binding")
+	}
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodKeyAndKeyboardInt}
+	if c.isFirstLoginStepRequired(user, nil) {
+		t.Error("the first login step must not be required if keyboard interactive is denied for the user")
+	}
+	user.Filters.DeniedLoginMethods = nil
+	user.Filters.FirstLogin.TermsAcceptedAt = 1
+	if c.isFirstLoginStepRequired(user, nil) {
+		t.Error("the first login step must not be required if the first login actions are completed")
+	}
+	user.Filters.FirstLogin.ChangePassword = true
+	user.HomeDir = filepath.Join(os.TempDir(), user.Username)
+	rule, err := checkLoginPolicy(user, dataprovider.SSHLoginMethodPassword, "", "", nil, nil)
+	if err == nil || rule != LoginRuleFirstLogin {
+		t.Errorf("the login must be refused, the password change is pending, rule: %#v, err: %v", rule, err)
+	}
+}
+
+func TestResumedUploadPipeOffset(t *testing.T) {
+	r, w, err := pipeat.Pipe()
+	if err != nil {
+		t.Fatalf("unable to create a pipe: %v", err)
+	}
+	transfer := Transfer{
+		writerAt:      w,
+		start:         time.Now(),
+		bytesSent:     0,
+		bytesReceived: 0,
+		user: dataprovider.User{
+			Username: "testuser",
+		},
+		connectionID:   "",
+		transferType:   transferUpload,
+		lastActivity:   time.Now(),
+		isNewFile:      true,
+		protocol:       protocolSFTP,
+		transferError:  nil,
+		isFinished:     false,
+		minWriteOffset: 10,
+		resumedSize:    10,
+		lock:           new(sync.Mutex),
+	}
+	if _, err = transfer.WriteAt([]byte("test"), 10); err != nil {
+		t.Errorf("unexpected write error: %v", err)
+	}
+	// Close waits for the reader, so the pipe must be read in a separate goroutine
+	done := make(chan bool)
+	go func() {
+		// the pipe contains the data written after the resume offset only
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != "test" {
+			t.Errorf("unexpected pipe contents: %#v, err: %v", string(data), err)
+		}
+		r.Close()
+		done <- true
+	}()
+	w.Close()
+	<-done
+}
+
+func TestReceiptsConfig(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "receipts")
+	if err != nil {
+		t.Fatalf("unable to create a temporary dir: %v", err)
+	}
+	defer os.RemoveAll(configDir)
+	// the receipts config is shared with the integration tests
+	savedReceipts := receipts
+	savedReceiptsPath := receiptsPath
+	defer func() {
+		receipts = savedReceipts
+		receiptsPath = savedReceiptsPath
+	}()
+	c := Configuration{
+		Receipts: ReceiptsConfig{
+			ExecuteOn: []string{operationUpload, operationDelete},
+			Path:      "receipts",
+		},
+	}
+	if _, err = c.getReceiptsPath(configDir); err == nil {
+		t.Error("configuring receipts for an unsupported operation must fail")
+	}
+	c.Receipts.ExecuteOn = []string{operationUpload}
+	c.Receipts.Path = ""
+	if _, err = c.getReceiptsPath(configDir); err == nil {
+		t.Error("configuring receipts without a path must fail")
+	}
+	c.Receipts.Path = "receipts"
+	dir, err := c.getReceiptsPath(configDir)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the relative path is resolved using the config dir
+	if dir != filepath.Join(configDir, "receipts") {
+		t.Errorf("unexpected receipts path: %#v", dir)
+	}
+	if _, err = os.Stat(dir); err != nil {
+		t.Errorf("the receipts directory must be created: %v", err)
+	}
+	receipts = c.Receipts
+	receiptsPath = dir
+	if !isReceiptEnabled(operationUpload) || isReceiptEnabled(operationDownload) {
+		t.Error("unexpected receipts operations")
+	}
+	receipt, err := GetTransferReceipt("missing_user", "bq0ecrq8f4v4gokcptvg")
+	if err != ErrReceiptNotFound {
+		t.Errorf("unexpected error: %v, receipt: %+v", err, receipt)
+	}
+	receiptsList, err := GetTransferReceipts("missing_user", 10, 0)
+	if err != nil || len(receiptsList) != 0 {
+		t.Errorf("unexpected receipts: %+v, error: %v", receiptsList, err)
+	}
+}
+
+func TestFsTimeouts(t *testing.T) {
+	err := vfs.SetFsTimeouts(vfs.FsTimeoutsConfig{
+		S3: vfs.OperationTimeouts{Read: -1},
+	})
+	if err == nil {
+		t.Error("negative timeouts must fail")
+	}
+	err = vfs.RunWithTimeout(100*time.Millisecond, func() error {
+		time.Sleep(500 * time.Millisecond)
+		return nil
+	})
+	if err != vfs.ErrOperationTimeout {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = vfs.RunWithTimeout(500*time.Millisecond, func() error {
+		return os.ErrNotExist
+	})
+	if err != os.ErrNotExist {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = vfs.SetFsTimeouts(vfs.FsTimeoutsConfig{
+		S3: vfs.OperationTimeouts{Read: 1, Write: 1},
+	})
+	if err != nil {
+		t.Errorf("unable to set filesystem timeouts: %v", err)
+	}
+	defer vfs.SetFsTimeouts(vfs.FsTimeoutsConfig{})
+
+	isCancelled := false
+	// nothing is written to the pipe, so the read blocks
+	r, w, _ := pipeat.Pipe()
+	transfer := Transfer{
+		readerAt: r,
+		cancelFn: func() {
+			isCancelled = true
+		},
+		start: time.Now(),
+		user: dataprovider.User{
+			Username: "testuser",
+		},
+		transferType: transferDownload,
+		lastActivity: time.Now(),
+		protocol:     protocolSFTP,
+		backend:      "s3",
+		lock:         new(sync.Mutex),
+	}
+	buf := make([]byte, 32768)
+	_, err = transfer.ReadAt(buf, 0)
+	if err != vfs.ErrOperationTimeout {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if transfer.transferError != vfs.ErrOperationTimeout || !isCancelled {
+		t.Error("a timed out read must fail the transfer and cancel the pending backend requests")
+	}
+	// the writer waits for the reader to be closed
+	r.Close()
+	w.Close()
+	// the local filesystem has no timeouts configured
+	transfer.backend = "local"
+	_, err = transfer.ReadAt(buf, 0)
+	if err == nil || err == vfs.ErrOperationTimeout {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNetworkFsMode(t *testing.T) {
+	err := vfs.SetNetworkFsConfig(vfs.NetworkFsConfig{
+		Enabled:    true,
+		RenameMode: 3,
+	})
+	if err == nil {
+		t.Error("invalid rename mode must fail")
+	}
+	err = vfs.SetNetworkFsConfig(vfs.NetworkFsConfig{
+		Enabled:      true,
+		StaleRetries: -1,
+	})
+	if err == nil {
+		t.Error("negative retries must fail")
+	}
+	err = vfs.SetNetworkFsConfig(vfs.NetworkFsConfig{
+		Enabled:      true,
+		StaleRetries: 2,
+		RetryDelay:   10,
+		RenameMode:   vfs.RenameModeReplace,
+	})
+	if err != nil {
+		t.Errorf("unable to set network filesystem config: %v", err)
+	}
+	defer vfs.SetNetworkFsConfig(vfs.NetworkFsConfig{})
+	if !vfs.IsNetworkFsMode() {
+		t.Error("network filesystem mode must be enabled")
+	}
+	testDir := filepath.Join(os.TempDir(), "netfs_test")
+	os.RemoveAll(testDir)
+	err = os.MkdirAll(testDir, 0777)
+	if err != nil {
+		t.Errorf("unable to create test dir: %v", err)
+	}
+	fs := vfs.NewOsFs("", testDir, nil)
+	source := filepath.Join(testDir, "source")
+	target := filepath.Join(testDir, "target")
+	err = ioutil.WriteFile(source, []byte("source"), 0666)
+	if err != nil {
+		t.Errorf("unable to write source file: %v", err)
+	}
+	err = ioutil.WriteFile(target, []byte("target"), 0666)
+	if err != nil {
+		t.Errorf("unable to write target file: %v", err)
+	}
+	err = fs.Rename(source, target)
+	if err != nil {
+		t.Errorf("rename over an existing file must succeed: %v", err)
+	}
+	content, err := ioutil.ReadFile(target)
+	if err != nil || string(content) != "source" {
+		t.Errorf("unexpected target content %#v, err: %v", string(content), err)
+	}
+	if _, err = fs.Stat(source); !fs.IsNotExist(err) {
+		t.Errorf("source file must not exist, err: %v", err)
+	}
+	f, _, _, err := fs.Create(source, 0)
+	if err != nil {
+		t.Errorf("unable to create file: %v", err)
+	} else {
+		f.Close()
+	}
+	err = fs.Remove(source, false)
+	if err != nil {
+		t.Errorf("unable to remove file: %v", err)
+	}
+	_, err = fs.ReadDir(source)
+	if !fs.IsNotExist(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	xattrs, err := vfs.GetExtendedAttributesToPreserve(target)
+	if err != nil || len(xattrs) > 0 {
+		t.Errorf("extended attributes must not be read in network filesystem mode: %+v, err: %v", xattrs, err)
+	}
+	os.RemoveAll(testDir)
+}
+
+func TestTransferChecksum(t *testing.T) {
+	data := []byte("sequential data")
+	expected := sha256.Sum256(data)
+	c := transferChecksum{}
+	c.update(data[:5], 0)
+	c.update(data[5:], 5)
+	if c.getChecksum(int64(len(data))) != hex.EncodeToString(expected[:]) {
+		t.Error("unexpected checksum for sequential data")
+	}
+	if c.getChecksum(int64(len(data))-1) != "" {
+		t.Error("the checksum must be empty if the transferred size does not match")
+	}
+	c = transferChecksum{}
+	c.update(data[5:], 5)
+	if c.getChecksum(int64(len(data))) != "" {
+		t.Error("the checksum must be empty if the transfer does not start from the beginning")
+	}
+	c = transferChecksum{}
+	c.update(data[:5], 0)
+	c.update(data[10:], 10)
+	c.update(data[5:10], 5)
+	if c.getChecksum(int64(len(data))) != "" {
+		t.Error("the checksum must be empty for out of order data")
+	}
+	c = transferChecksum{}
+	emptyHash := sha256.Sum256(nil)
+	if c.getChecksum(0) != hex.EncodeToString(emptyHash[:]) {
+		t.Error("unexpected checksum for an empty file")
+	}
+}
+
+func TestTransferReceiptSignature(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("unable to create signer: %v", err)
+	}
+	receipt := &TransferReceipt{
+		ID:        "bq0ecrq8f4v4gokcptvg",
+		Username:  "user",
+		Operation: operationUpload,
+		Path:      "/tmp/file",
+		Size:      10,
+	}
+	if err = receipt.Verify(); err == nil {
+		t.Error("verifying a receipt without a signature must fail")
+	}
+	if err = receipt.sign(signer); err != nil {
+		t.Fatalf("unable to sign the receipt: %v", err)
+	}
+	if receipt.Signature.Format != ssh.KeyAlgoED25519 {
+		t.Errorf("unexpected signature format: %#v", receipt.Signature.Format)
+	}
+	if err = receipt.Verify(); err != nil {
+		t.Errorf("unable to verify the receipt: %v", err)
+	}
+	receipt.Path = "/tmp/other"
+	if err = receipt.Verify(); err == nil {
+		t.Error("verifying a modified receipt must fail")
+	}
+	receipt.Signature.PublicKey = "invalid"
+	if err = receipt.Verify(); err == nil {
+		t.Error("verifying a receipt with an invalid public key must fail")
+	}
+}
+
+func TestInvalidRevokedKeys(t *testing.T) {
+	blobs := make(map[string]bool)
+	sha1Hashes := make(map[string]bool)
+	sha256Hashes := make(map[string]bool)
+	if parseRevokedKeysText([]byte("SHA256:invalid"), blobs, sha256Hashes) == nil {
+		t.Error("parsing an invalid fingerprint must fail")
+	}
+	if parseRevokedKeysText([]byte("ssh-rsa invalid"), blobs, sha256Hashes) == nil {
+		t.Error("parsing an invalid public key must fail")
+	}
+	header := getKRLHeader()
+	invalidKRLs := [][]byte{
+		[]byte(krlMagic),
+		append([]byte(krlMagic), 0, 0, 0, 2),
+		header[:len(header)-10],
+		header[:len(header)-2],
+		append(getKRLHeader(), getKRLSection(6, nil)...),
+		append(getKRLHeader(), getKRLSection(krlSectionExplicitKey, nil)[:3]...),
+		append(getKRLHeader(), getKRLSection(krlSectionExplicitKey, []byte{0, 0, 0, 5})...),
+		append(getKRLHeader(), getKRLSection(krlSectionFingerprintSHA1, ssh.Marshal(struct{ B []byte }{[]byte("short")}))...),
+		append(getKRLHeader(), getKRLSection(krlSectionFingerprintSHA256, []byte{0, 0, 0, 5})...),
+	}
+	for _, krl := range invalidKRLs {
+		if parseKRL(krl, blobs, sha1Hashes, sha256Hashes) == nil {
+			t.Errorf("parsing an invalid KRL must fail: %v", krl)
+		}
+	}
+}
+
+func TestUserActivity(t *testing.T) {
+	username := "test_user_activity"
+	activity := GetUserActivity(username)
+	if activity.Username != username || activity.Buckets == nil || len(activity.Buckets) > 0 {
+		t.Errorf("unexpected activity: %+v", activity)
+	}
+	// Monday 08:30 UTC
+	loginTime := time.Date(2020, time.June, 29, 8, 30, 0, 0, time.UTC)
+	addLoginActivity(username, loginTime)
+	addLoginActivity(username, loginTime.Add(10*time.Minute))
+	addTransferActivity(username, transferUpload, loginTime)
+	// Sunday 23:59 UTC, same instant in a different timezone
+	transferTime := time.Date(2020, time.July, 5, 23, 59, 0, 0, time.UTC).In(time.FixedZone("UTC+2", 7200))
+	addTransferActivity(username, transferDownload, transferTime)
+	activity = GetUserActivity(username)
+	if len(activity.Buckets) != 2 {
+		t.Fatalf("unexpected activity: %+v", activity)
+	}
+	if activity.Buckets[0] != (HourlyActivity{Weekday: 0, Hour: 23, Downloads: 1}) {
+		t.Errorf("unexpected bucket: %+v", activity.Buckets[0])
+	}
+	if activity.Buckets[1] != (HourlyActivity{Weekday: 1, Hour: 8, Logins: 2, Uploads: 1}) {
+		t.Errorf("unexpected bucket: %+v", activity.Buckets[1])
+	}
+	found := false
+	for _, a := range GetUsersActivity() {
+		if a.Username == username {
+			found = true
+			if len(a.Buckets) != 2 {
+				t.Errorf("unexpected activity: %+v", a)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("activity for user %#v not found", username)
+	}
+	if hourOfWeek := getHourOfWeek(time.Date(2020, time.July, 4, 23, 0, 0, 0, time.UTC)); hourOfWeek != hoursPerWeek-1 {
+		t.Errorf("unexpected hour of week: %v", hourOfWeek)
+	}
+	activityMutex.Lock()
+	delete(userActivities, username)
+	activityMutex.Unlock()
+}
+
+func TestBindingLoginPolicyRules(t *testing.T) {
+	loginPoliciesMutex.RLock()
+	savedPolicies := loginPolicies
+	loginPoliciesMutex.RUnlock()
+	c := Configuration{
+		BindPort:           2022,
+		DeniedLoginMethods: []string{dataprovider.SSHLoginMethodKeyAndKeyboardInt},
+		Bindings: []Binding{
+			{
+				Port: 2023,
+				DeniedLoginMethods: []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
+					dataprovider.SSHLoginMethodKeyAndPassword, dataprovider.SSHLoginMethodKeyAndKeyboardInt},
+			},
+		},
+	}
+	if err := c.validateBindings(); err != nil {
+		t.Errorf("unexpected error validating bindings: %v", err)
+	}
+	bindings := c.getBindings()
+	if len(bindings) != 2 {
+		t.Fatalf("unexpected bindings: %+v", bindings)
+	}
+	policy := c.getLoginPolicy(bindings[0])
+	if policy.isPublicKeyDenied() || policy.isLoginMethodDenied(dataprovider.SSHLoginMethodPassword) {
+		t.Errorf("unexpected policy for the main binding: %+v", policy)
+	}
+	nextMethods := policy.filterNextAuthMethods([]string{dataprovider.SSHLoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive})
+	if len(nextMethods) != 1 || nextMethods[0] != dataprovider.SSHLoginMethodPassword {
+		t.Errorf("unexpected next auth methods: %+v", nextMethods)
+	}
+	policy = c.getLoginPolicy(bindings[1])
+	if len(policy.deniedLoginMethods) != 4 || !policy.isPublicKeyDenied() {
+		t.Errorf("unexpected policy for the additional binding: %+v", policy)
+	}
+	if len(c.DeniedLoginMethods) != 1 {
+		t.Errorf("the global denied login methods must not be modified: %+v", c.DeniedLoginMethods)
+	}
+	c.setLoginPolicies()
+	for _, port := range []int{0, 2022, 2023} {
+		if _, ok := getLoginPolicyForPort(port); !ok {
+			t.Errorf("missing login policy for port %v", port)
+		}
+	}
+	if _, ok := getLoginPolicyForPort(2024); ok {
+		t.Error("login policy for port 2024 must not exist")
+	}
+	c.Bindings[0].Port = c.BindPort
+	if err := c.validateBindings(); err == nil {
+		t.Error("duplicated ports must fail validation")
+	}
+	c.Bindings[0].Port = 2023
+	c.Bindings[0].DeniedLoginMethods = append(c.Bindings[0].DeniedLoginMethods, dataprovider.SSHLoginMethodKeyboardInteractive)
+	if err := c.validateBindings(); err == nil {
+		t.Error("a binding denying all the login methods must fail validation")
+	}
+	loginPoliciesMutex.Lock()
+	loginPolicies = savedPolicies
+	loginPoliciesMutex.Unlock()
+}
+
+func getKRLHeader() []byte {
+	header := []byte(krlMagic)
+	header = append(header, ssh.Marshal(struct {
+		FormatVersion uint32
+		KRLVersion    uint64
+		GeneratedDate uint64
+		Flags         uint64
+		Reserved      string
+		Comment       string
+	}{
+		FormatVersion: krlFormatVersion,
+		KRLVersion:    1,
+		GeneratedDate: uint64(time.Now().Unix()),
+		Reserved:      "",
+		Comment:       "test KRL",
+	})...)
+	return header
+}
+
+func TestSyncPresignedURLUnsupported(t *testing.T) {
+	c := SyncConnection{
+		Connection: Connection{
+			User: dataprovider.User{
+				HomeDir: os.TempDir(),
+			},
+			fs: vfs.NewOsFs("123", os.TempDir(), nil),
+		},
+	}
+	_, err := c.GetPresignedURL("/file", false, time.Minute)
+	if err != vfs.ErrPresignedURLUnsupported {
+		t.Errorf("pre-signed URLs must not be supported for the local filesystem, err: %v", err)
+	}
+	_, err = c.GetPresignedURL("/file", true, time.Minute)
+	if err != vfs.ErrPresignedURLUnsupported {
+		t.Errorf("pre-signed URLs must not be supported for the local filesystem, err: %v", err)
+	}
+	_, err = vfs.GetPresignedURL(c.fs, "/file", http.MethodDelete, time.Minute)
+	if err == nil || err == vfs.ErrPresignedURLUnsupported {
+		t.Errorf("unexpected error for an unsupported method: %v", err)
+	}
+}
+
+func getKRLSection(sectionType byte, data []byte) []byte {
+	return append([]byte{sectionType}, ssh.Marshal(struct{ Data []byte }{data})...)
+}
+
+func TestDefender(t *testing.T) {
+	defender.Lock()
+	savedConfig := defender.config
+	defender.Unlock()
+	defer defender.configure(savedConfig)
+
+	config := DefenderConfig{
+		Enabled:         true,
+		BanTime:         10,
+		ObservationTime: 10,
+		Threshold:       5,
+		ScoreValid:      1,
+		ScoreInvalid:    2,
+	}
+	if err := config.validate(); err != nil {
+		t.Errorf("unexpected error validating defender config: %v", err)
+	}
+	config.Threshold = 0
+	if err := config.validate(); err == nil {
+		t.Error("a zero threshold must fail validation")
+	}
+	config.Threshold = 5
+	config.ScoreInvalid = -1
+	if err := config.validate(); err == nil {
+		t.Error("a negative score must fail validation")
+	}
+	config.ScoreInvalid = 2
+	defender.configure(config)
+	if !IsDefenderEnabled() {
+		t.Error("the defender must be enabled")
+	}
+	ip := "127.0.0.1"
+	defender.addLoginFailure(ip, errors.New("invalid credentials"))
+	defender.addLoginFailure(ip, &dataprovider.RecordNotFoundError{})
+	entry, err := GetDefenderHost(ip)
+	if err != nil {
+		t.Errorf("unexpected error getting defender host: %v", err)
+	}
+	if entry.Score != 3 || entry.BanTime != 0 {
+		t.Errorf("unexpected defender entry: %+v", entry)
+	}
+	if defender.isBanned(ip) {
+		t.Error("the host must not be banned")
+	}
+	defender.addLoginFailure(ip, &dataprovider.RecordNotFoundError{})
+	if !defender.isBanned(ip) {
+		t.Error("the host must be banned")
+	}
+	entry, err = GetDefenderHost(ip)
+	if err != nil {
+		t.Errorf("unexpected error getting defender host: %v", err)
+	}
+	if entry.Score != 0 || entry.BanTime == 0 || len(entry.GetBanTimeAsString()) == 0 {
+		t.Errorf("unexpected defender entry: %+v", entry)
+	}
+	if _, err = GetDefenderHost("::2"); err != ErrDefenderHostNotFound {
+		t.Errorf("unexpected error getting a missing host: %v", err)
+	}
+	if _, err = GetDefenderHost("invalid ip"); err == nil || err == ErrDefenderHostNotFound {
+		t.Errorf("an invalid IP must fail: %v", err)
+	}
+	entry, err = BanDefenderHost("0:0:0:0:0:0:0:2", 0)
+	if err != nil {
+		t.Errorf("unable to ban host: %v", err)
+	}
+	if entry.IP != "::2" || entry.BanTime == 0 {
+		t.Errorf("unexpected defender entry: %+v", entry)
+	}
+	if !defender.isBanned("::2") {
+		t.Error("the manually banned host must be banned")
+	}
+	if _, err = BanDefenderHost("::3", -1); err == nil {
+		t.Error("a negative ban time must fail")
+	}
+	hosts := GetDefenderHosts()
+	if len(hosts) != 2 || hosts[0].IP != "127.0.0.1" || hosts[1].IP != "::2" {
+		t.Errorf("unexpected defender hosts: %+v", hosts)
+	}
+	if err = UnbanDefenderHost(ip); err != nil {
+		t.Errorf("unable to unban host: %v", err)
+	}
+	if defender.isBanned(ip) {
+		t.Error("the unbanned host must not be banned")
+	}
+	if err = UnbanDefenderHost(ip); err != ErrDefenderHostNotFound {
+		t.Errorf("unexpected error unbanning a missing host: %v", err)
+	}
+	// the expired bans and scores are removed
+	defender.Lock()
+	defender.hosts["::2"].banExpiry = time.Now().Add(-time.Minute)
+	defender.Unlock()
+	defender.addLoginFailure(ip, errors.New("invalid credentials"))
+	defender.Lock()
+	defender.hosts[ip].events[0].time = time.Now().Add(-11 * time.Minute)
+	defender.Unlock()
+	if hosts = GetDefenderHosts(); len(hosts) != 0 {
+		t.Errorf("the expired hosts must be removed: %+v", hosts)
+	}
+	defender.configure(DefenderConfig{})
+	if _, err = BanDefenderHost(ip, 10); err != ErrDefenderDisabled {
+		t.Errorf("unexpected error banning a host with the defender disabled: %v", err)
+	}
+	defender.addLoginFailure(ip, errors.New("invalid credentials"))
+	if hosts = GetDefenderHosts(); len(hosts) != 0 {
+		t.Errorf("no host must be tracked with the defender disabled: %+v", hosts)
+	}
+}
+
+func TestCheckListeners(t *testing.T) {
+	listenersMutex.Lock()
+	savedListeners := serverListeners
+	savedStopped := serverStopped
+	serverListeners = nil
+	serverStopped = false
+	listenersMutex.Unlock()
+
+	if err := CheckListeners(); err == nil {
+		t.Error("a server without listeners must not be healthy")
+	}
+	listenersMutex.Lock()
+	serverListeners = []net.Listener{nil}
+	serverStopped = true
+	listenersMutex.Unlock()
+	if err := CheckListeners(); err == nil {
+		t.Error("a stopped server must not be healthy")
+	}
+	listenersMutex.Lock()
+	serverStopped = false
+	listenersMutex.Unlock()
+	if err := CheckListeners(); err != nil {
+		t.Errorf("unexpected error checking listeners: %v", err)
+	}
+
+	listenersMutex.Lock()
+	serverListeners = savedListeners
+	serverStopped = savedStopped
+	listenersMutex.Unlock()
+}
+
+func TestLoginSimulationLimiter(t *testing.T) {
+	limiter := &loginSimulationLimiter{windows: make(map[string]*loginSimulationWindowInfo)}
+	now := time.Now()
+	for i := 0; i < loginSimulationMaxRequests; i++ {
+		if !limiter.isAllowed("user1", now) {
+			t.Errorf("simulation %v must be allowed", i)
+		}
+	}
+	if limiter.isAllowed("user1", now) {
+		t.Error("the simulations exceeding the limit must be refused")
+	}
+	if !limiter.isAllowed("user2", now) {
+		t.Error("the limit must be applied for each username")
+	}
+	if !limiter.isAllowed("user1", now.Add(loginSimulationWindow)) {
+		t.Error("the simulations must be allowed after the window expires")
+	}
+	if len(limiter.windows) != 1 {
+		t.Errorf("the expired windows must be removed, windows: %v", len(limiter.windows))
+	}
+}
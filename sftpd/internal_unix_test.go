@@ -3,8 +3,19 @@
 package sftpd
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"golang.org/x/sys/unix"
 )
 
 func TestWrapCmd(t *testing.T) {
@@ -17,3 +28,136 @@ func TestWrapCmd(t *testing.T) {
 		t.Errorf("unexpected gid")
 	}
 }
+
+func TestPreserveExtendedAttributes(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("extended attributes are not supported on this platform")
+	}
+	existingPath := filepath.Join(os.TempDir(), "xattr_existing")
+	newPath := filepath.Join(os.TempDir(), "xattr_new")
+	for _, p := range []string{existingPath, newPath} {
+		err := ioutil.WriteFile(p, []byte("data"), 0666)
+		if err != nil {
+			t.Fatalf("unable to create test file: %v", err)
+		}
+		defer os.Remove(p)
+	}
+	err := unix.Setxattr(existingPath, "user.sftpgo.test", []byte("existing"), 0)
+	if err != nil {
+		t.Skipf("extended attributes are not supported by the filesystem: %v", err)
+	}
+	err = unix.Setxattr(existingPath, "user.sftpgo.other", []byte("existing"), 0)
+	if err != nil {
+		t.Errorf("unable to set extended attribute: %v", err)
+	}
+	// the trusted namespace requires root privileges
+	hasTrustedXattr := unix.Setxattr(existingPath, "trusted.sftpgo.test", []byte("existing"), 0) == nil
+	// the POSIX ACLs require a filesystem with ACLs support
+	acl := getTestPOSIXACL()
+	hasACL := runtime.GOOS == "linux" && unix.Setxattr(existingPath, "system.posix_acl_access", acl, 0) == nil
+	err = unix.Setxattr(newPath, "user.sftpgo.other", []byte("new"), 0)
+	if err != nil {
+		t.Errorf("unable to set extended attribute: %v", err)
+	}
+	xattrs := getXattrsToPreserve(existingPath, "")
+	if len(xattrs) > 0 {
+		t.Error("extended attributes must not be preserved if preservation is disabled")
+	}
+	preserveXattrs = true
+	defer func() {
+		preserveXattrs = false
+	}()
+	xattrs = getXattrsToPreserve(existingPath, "")
+	expectedXattrs := 2
+	if hasACL {
+		expectedXattrs++
+	}
+	if len(xattrs) != expectedXattrs {
+		t.Errorf("unexpected extended attributes to preserve: %+v", xattrs)
+	}
+	if _, ok := xattrs["trusted.sftpgo.test"]; ok && hasTrustedXattr {
+		t.Error("only the extended attributes in the user namespace and the POSIX ACLs must be preserved")
+	}
+	err = os.Rename(newPath, existingPath)
+	if err != nil {
+		t.Fatalf("unable to rename test file: %v", err)
+	}
+	preserveExtendedAttributes(existingPath, xattrs, "")
+	buf := make([]byte, 64)
+	size, err := unix.Getxattr(existingPath, "user.sftpgo.test", buf)
+	if err != nil {
+		t.Errorf("extended attribute not preserved: %v", err)
+	} else if string(buf[:size]) != "existing" {
+		t.Errorf("unexpected extended attribute value: %#v", string(buf[:size]))
+	}
+	size, err = unix.Getxattr(existingPath, "user.sftpgo.other", buf)
+	if err != nil {
+		t.Errorf("unable to get extended attribute: %v", err)
+	} else if string(buf[:size]) != "new" {
+		t.Errorf("an existing extended attribute must not be overwritten, value: %#v", string(buf[:size]))
+	}
+	if hasACL {
+		size, err = unix.Getxattr(existingPath, "system.posix_acl_access", buf)
+		if err != nil {
+			t.Errorf("POSIX ACL not preserved: %v", err)
+		} else if !bytes.Equal(buf[:size], acl) {
+			t.Errorf("unexpected POSIX ACL: %v", buf[:size])
+		}
+	}
+	// an atomic upload replacing a file created while the upload was in progress
+	tempPath := filepath.Join(os.TempDir(), "xattr_atomic_upload")
+	file, err := os.Create(tempPath)
+	if err != nil {
+		t.Fatalf("unable to create test file: %v", err)
+	}
+	defer os.Remove(tempPath)
+	transfer := Transfer{
+		file:          file,
+		path:          existingPath,
+		start:         time.Now(),
+		bytesReceived: 0,
+		user:          dataprovider.User{Username: "test_xattr"},
+		transferType:  transferUpload,
+		lastActivity:  time.Now(),
+		isNewFile:     true,
+		protocol:      protocolSFTP,
+		lock:          new(sync.Mutex),
+	}
+	addTransfer(&transfer)
+	err = transfer.Close()
+	if err != nil {
+		t.Errorf("unable to close the transfer: %v", err)
+	}
+	size, err = unix.Getxattr(existingPath, "user.sftpgo.test", buf)
+	if err != nil {
+		t.Errorf("extended attribute not preserved after an atomic upload: %v", err)
+	} else if string(buf[:size]) != "existing" {
+		t.Errorf("unexpected extended attribute value: %#v", string(buf[:size]))
+	}
+}
+
+// getTestPOSIXACL returns a POSIX ACL, in the Linux extended attribute format, granting read
+// permission to the user with UID 1000
+func getTestPOSIXACL() []byte {
+	entries := []struct {
+		tag  uint16
+		perm uint16
+		id   uint32
+	}{
+		{0x01, 6, 0xffffffff}, // user owner
+		{0x02, 4, 1000},       // named user
+		{0x04, 4, 0xffffffff}, // group owner
+		{0x10, 4, 0xffffffff}, // mask
+		{0x20, 4, 0xffffffff}, // others
+	}
+	acl := make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(acl, 2)
+	for _, e := range entries {
+		entry := make([]byte, 8)
+		binary.LittleEndian.PutUint16(entry, e.tag)
+		binary.LittleEndian.PutUint16(entry[2:], e.perm)
+		binary.LittleEndian.PutUint32(entry[4:], e.id)
+		acl = append(acl, entry...)
+	}
+	return acl
+}
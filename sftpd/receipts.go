@@ -0,0 +1,335 @@
+package sftpd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/ssh"
+)
+
+const receiptChecksumAlgo = "sha256"
+
+var (
+	receipts       ReceiptsConfig
+	receiptsPath   string
+	receiptSigner  ssh.Signer
+	receiptIDRegex = regexp.MustCompile(`^[0-9a-v]{20}$`)
+	// ErrReceiptNotFound is returned if the requested transfer receipt does not exist
+	ErrReceiptNotFound = errors.New("transfer receipt not found")
+)
+
+// ReceiptsConfig defines the signed receipts for the completed transfers
+type ReceiptsConfig struct {
+	// Transfers to generate a receipt for, valid values are "upload" and "download". Empty to disable
+	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
+	// Directory to store the receipts, a sub directory is created for each user.
+	// This can be an absolute path or a path relative to the config dir
+	Path string `json:"path" mapstructure:"path"`
+}
+
+// TransferReceipt defines a signed proof for a completed upload or download.
+// The signature is computed, using the first configured host key, on the JSON
+// serialization of the receipt without the signature field
+type TransferReceipt struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	Operation    string `json:"operation"`
+	Protocol     string `json:"protocol"`
+	ConnectionID string `json:"connection_id"`
+	// full filesystem path, as for the custom actions
+	Path string `json:"path"`
+	// file size, as for the custom actions
+	Size int64 `json:"size"`
+	// hex encoded checksum of the transferred data, starting from the beginning of the file.
+	// Empty if it cannot be computed, for example for an upload to a cloud storage backend
+	// not written sequentially or for a download resumed from an offset
+	Checksum     string `json:"checksum,omitempty"`
+	ChecksumAlgo string `json:"checksum_algo,omitempty"`
+	// transfer start and end as unix timestamp in milliseconds
+	StartTime int64                     `json:"start_time"`
+	EndTime   int64                     `json:"end_time"`
+	Signature *TransferReceiptSignature `json:"signature,omitempty"`
+}
+
+// TransferReceiptSignature defines the signature for a transfer receipt
+type TransferReceiptSignature struct {
+	// SSH signature format, for example "ssh-ed25519" or "rsa-sha2-256"
+	Format string `json:"format"`
+	// signature blob, base64 encoded inside the JSON serialization
+	Blob []byte `json:"blob"`
+	// public key for the host key used to sign the receipt, in authorized keys format
+	PublicKey string `json:"public_key"`
+}
+
+// getSignedData returns the data covered by the signature
+func (r *TransferReceipt) getSignedData() ([]byte, error) {
+	receipt := *r
+	receipt.Signature = nil
+	return json.Marshal(receipt)
+}
+
+func (r *TransferReceipt) sign(signer ssh.Signer) error {
+	data, err := r.getSignedData()
+	if err != nil {
+		return err
+	}
+	var signature *ssh.Signature
+	// for RSA keys we prefer SHA-256 to the legacy SHA-1 signatures
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2256)
+	} else {
+		signature, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return err
+	}
+	r.Signature = &TransferReceiptSignature{
+		Format:    signature.Format,
+		Blob:      signature.Blob,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+	}
+	return nil
+}
+
+// Verify checks the receipt signature against the embedded public key.
+// The caller must also check that the embedded public key is a trusted host key
+func (r *TransferReceipt) Verify() error {
+	if r.Signature == nil {
+		return errors.New("the receipt is not signed")
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(r.Signature.PublicKey))
+	if err != nil {
+		return fmt.Errorf("invalid receipt public key: %v", err)
+	}
+	data, err := r.getSignedData()
+	if err != nil {
+		return err
+	}
+	return publicKey.Verify(data, &ssh.Signature{
+		Format: r.Signature.Format,
+		Blob:   r.Signature.Blob,
+	})
+}
+
+// transferChecksum computes the checksum for the transferred data while the
+// data is sent or received. It is valid only if the data is transferred
+// sequentially starting from the beginning of the file
+type transferChecksum struct {
+	hash    hash.Hash
+	offset  int64
+	invalid bool
+}
+
+func (c *transferChecksum) update(p []byte, off int64) {
+	if c.invalid || len(p) == 0 {
+		return
+	}
+	if c.hash == nil {
+		if off != 0 {
+			c.invalid = true
+			return
+		}
+		c.hash = sha256.New()
+	}
+	if off != c.offset {
+		c.invalid = true
+		return
+	}
+	c.hash.Write(p)
+	c.offset += int64(len(p))
+}
+
+// getChecksum returns the checksum if the data was transferred sequentially and the
+// transferred size matches the given one
+func (c *transferChecksum) getChecksum(size int64) string {
+	if c.invalid || c.offset != size {
+		return ""
+	}
+	if c.hash == nil {
+		c.hash = sha256.New()
+	}
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// getReceiptsPath validates the receipts configuration and returns the receipts directory,
+// creating it if missing
+func (c Configuration) getReceiptsPath(configDir string) (string, error) {
+	for _, op := range c.Receipts.ExecuteOn {
+		if op != operationUpload && op != operationDownload {
+			return "", fmt.Errorf("invalid transfer receipts operation %#v", op)
+		}
+	}
+	dir := c.Receipts.Path
+	if len(c.Receipts.ExecuteOn) == 0 {
+		return dir, nil
+	}
+	if len(dir) == 0 {
+		return "", errors.New("the transfer receipts path is required")
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(configDir, dir)
+	}
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+func isReceiptEnabled(operation string) bool {
+	return utils.IsStringInSlice(operation, receipts.ExecuteOn)
+}
+
+// createReceipt generates, signs and stores the receipt for the given completed transfer.
+// It must be called after the transfer is finished, the returned receipt is nil if the
+// receipts are disabled for the transfer operation or on error
+func (t *Transfer) createReceipt(operation string, size int64) *TransferReceipt {
+	if t.transferError != nil || !isReceiptEnabled(operation) {
+		return nil
+	}
+	if receiptSigner == nil {
+		logger.Warn(logSender, t.connectionID, "unable to create transfer receipt: no host key available")
+		return nil
+	}
+	now := time.Now()
+	receipt := &TransferReceipt{
+		ID:           xid.New().String(),
+		Username:     t.user.Username,
+		Operation:    operation,
+		Protocol:     t.protocol,
+		ConnectionID: t.connectionID,
+		Path:         t.path,
+		Size:         size,
+		Checksum:     t.getReceiptChecksum(size),
+		StartTime:    utils.GetTimeAsMsSinceEpoch(t.start),
+		EndTime:      utils.GetTimeAsMsSinceEpoch(now),
+	}
+	if len(receipt.Checksum) > 0 {
+		receipt.ChecksumAlgo = receiptChecksumAlgo
+	}
+	if err := receipt.sign(receiptSigner); err != nil {
+		logger.Warn(logSender, t.connectionID, "unable to sign transfer receipt for path %#v: %v", t.path, err)
+		return nil
+	}
+	if err := saveReceipt(receipt); err != nil {
+		logger.Warn(logSender, t.connectionID, "unable to save transfer receipt for path %#v: %v", t.path, err)
+		return nil
+	}
+	logger.Debug(logSender, t.connectionID, "transfer receipt %#v created for path %#v, checksum: %#v", receipt.ID,
+		t.path, receipt.Checksum)
+	return receipt
+}
+
+// getReceiptChecksum returns the checksum computed while transferring the data. If the data
+// was not transferred sequentially the checksum is computed reading the local file, if any
+func (t *Transfer) getReceiptChecksum(size int64) string {
+	if checksum := t.checksum.getChecksum(size); len(checksum) > 0 {
+		return checksum
+	}
+	if t.file == nil || (t.transferType == transferDownload && size != t.expectedSize) {
+		return ""
+	}
+	f, err := os.Open(t.path)
+	if err != nil {
+		logger.Warn(logSender, t.connectionID, "unable to compute the transfer receipt checksum for path %#v: %v",
+			t.path, err)
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil || n != size {
+		logger.Warn(logSender, t.connectionID, "unable to compute the transfer receipt checksum for path %#v, "+
+			"read bytes: %v, expected: %v, error: %v", t.path, n, size, err)
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func getUserReceiptsPath(username string) string {
+	return filepath.Join(receiptsPath, username)
+}
+
+func saveReceipt(receipt *TransferReceipt) error {
+	dir := getUserReceiptsPath(receipt.Username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, receipt.ID+".json"), data, 0600)
+}
+
+// GetTransferReceipt returns the transfer receipt with the given ID for the specified user
+func GetTransferReceipt(username, receiptID string) (TransferReceipt, error) {
+	var receipt TransferReceipt
+	if len(receiptsPath) == 0 || !receiptIDRegex.MatchString(receiptID) {
+		return receipt, ErrReceiptNotFound
+	}
+	data, err := ioutil.ReadFile(filepath.Join(getUserReceiptsPath(username), receiptID+".json"))
+	if os.IsNotExist(err) {
+		return receipt, ErrReceiptNotFound
+	}
+	if err != nil {
+		return receipt, err
+	}
+	err = json.Unmarshal(data, &receipt)
+	return receipt, err
+}
+
+// GetTransferReceipts returns the transfer receipts for the specified user, newest first.
+// The receipts are read starting from the given offset, at most limit receipts are returned
+func GetTransferReceipts(username string, limit, offset int) ([]TransferReceipt, error) {
+	receiptsList := []TransferReceipt{}
+	if len(receiptsPath) == 0 {
+		return receiptsList, nil
+	}
+	files, err := ioutil.ReadDir(getUserReceiptsPath(username))
+	if os.IsNotExist(err) {
+		return receiptsList, nil
+	}
+	if err != nil {
+		return receiptsList, err
+	}
+	var ids []string
+	for _, fi := range files {
+		id := strings.TrimSuffix(fi.Name(), ".json")
+		if fi.Mode().IsRegular() && receiptIDRegex.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	// the IDs are sortable by creation time
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	if offset >= len(ids) {
+		return receiptsList, nil
+	}
+	ids = ids[offset:]
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	for _, id := range ids {
+		receipt, err := GetTransferReceipt(username, id)
+		if err != nil {
+			if err == ErrReceiptNotFound {
+				continue
+			}
+			return receiptsList, err
+		}
+		receiptsList = append(receiptsList, receipt)
+	}
+	return receiptsList, nil
+}
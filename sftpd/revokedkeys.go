@@ -0,0 +1,226 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// KRL format as defined in PROTOCOL.krl inside the OpenSSH source tree
+const (
+	krlMagic                    = "SSHKRL\n\x00"
+	krlFormatVersion            = 1
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+)
+
+var errKRLTruncated = errors.New("truncated KRL")
+
+// revokedKeys is a list of revoked public keys loaded from an OpenSSH style revoked keys file.
+// The file is reloaded if it changes. Both the binary KRL format, generated using "ssh-keygen -k",
+// and a text file containing one public key or one SHA256 fingerprint per line are supported
+type revokedKeys struct {
+	sync.RWMutex
+	path   string
+	info   os.FileInfo
+	blobs  map[string]bool
+	sha1   map[string]bool
+	sha256 map[string]bool
+}
+
+func newRevokedKeys(path string) (*revokedKeys, error) {
+	r := &revokedKeys{
+		path: path,
+	}
+	return r, r.load()
+}
+
+func (r *revokedKeys) isReloadNeeded(info os.FileInfo) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.info == nil || r.info.ModTime() != info.ModTime() || r.info.Size() != info.Size()
+}
+
+func (r *revokedKeys) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if !r.isReloadNeeded(info) {
+		return nil
+	}
+	content, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	blobs := make(map[string]bool)
+	sha1Hashes := make(map[string]bool)
+	sha256Hashes := make(map[string]bool)
+	if bytes.HasPrefix(content, []byte(krlMagic)) {
+		err = parseKRL(content, blobs, sha1Hashes, sha256Hashes)
+	} else {
+		err = parseRevokedKeysText(content, blobs, sha256Hashes)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse revoked keys file %#v: %v", r.path, err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.info = info
+	r.blobs = blobs
+	r.sha1 = sha1Hashes
+	r.sha256 = sha256Hashes
+	logger.Debug(logSender, "", "revoked keys file %#v loaded, keys: %v, fingerprints: %v", r.path, len(blobs),
+		len(sha1Hashes)+len(sha256Hashes))
+	return nil
+}
+
+// isRevoked returns an error if the given public key is revoked.
+// If the revoked keys file cannot be loaded any key is considered as revoked
+func (r *revokedKeys) isRevoked(pubKey ssh.PublicKey) error {
+	if err := r.load(); err != nil {
+		logger.Warn(logSender, "", "unable to load revoked keys, all the public keys will be refused: %v", err)
+		return err
+	}
+	blob := pubKey.Marshal()
+	sha1Hash := sha1.Sum(blob)
+	sha256Hash := sha256.Sum256(blob)
+
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.blobs[string(blob)] || r.sha1[string(sha1Hash[:])] || r.sha256[string(sha256Hash[:])] {
+		return fmt.Errorf("public key %v is revoked", ssh.FingerprintSHA256(pubKey))
+	}
+	return nil
+}
+
+// parseRevokedKeysText parses a text file with one public key, in authorized keys format,
+// or one SHA256 fingerprint per line. Empty lines and lines starting with "#" are ignored
+func parseRevokedKeysText(content []byte, blobs, sha256Hashes map[string]bool) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "SHA256:") {
+			hash, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(line, "SHA256:"))
+			if err != nil || len(hash) != sha256.Size {
+				return fmt.Errorf("invalid fingerprint at line %v", lineNumber)
+			}
+			sha256Hashes[string(hash)] = true
+			continue
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return fmt.Errorf("invalid public key at line %v: %v", lineNumber, err)
+		}
+		blobs[string(pubKey.Marshal())] = true
+	}
+	return scanner.Err()
+}
+
+// parseKRL parses a binary KRL. Certificates sections are ignored since certificates
+// are not supported for public key authentication
+func parseKRL(content []byte, blobs, sha1Hashes, sha256Hashes map[string]bool) error {
+	buf := content[len(krlMagic):]
+	version, buf, ok := parseKRLUint32(buf)
+	if !ok {
+		return errKRLTruncated
+	}
+	if version != krlFormatVersion {
+		return fmt.Errorf("unsupported KRL format version: %v", version)
+	}
+	// krl_version, generated_date and flags
+	if len(buf) < 24 {
+		return errKRLTruncated
+	}
+	buf = buf[24:]
+	// reserved and comment
+	for i := 0; i < 2; i++ {
+		if _, buf, ok = parseKRLString(buf); !ok {
+			return errKRLTruncated
+		}
+	}
+	for len(buf) > 0 {
+		sectionType := buf[0]
+		var data []byte
+		if data, buf, ok = parseKRLString(buf[1:]); !ok {
+			return errKRLTruncated
+		}
+		switch sectionType {
+		case krlSectionCertificates:
+			continue
+		case krlSectionExplicitKey:
+			if err := parseKRLBlobs(data, blobs, 0); err != nil {
+				return err
+			}
+		case krlSectionFingerprintSHA1:
+			if err := parseKRLBlobs(data, sha1Hashes, sha1.Size); err != nil {
+				return err
+			}
+		case krlSectionFingerprintSHA256:
+			if err := parseKRLBlobs(data, sha256Hashes, sha256.Size); err != nil {
+				return err
+			}
+		case krlSectionSignature:
+			// signatures are the last sections and they are not verified
+			return nil
+		default:
+			return fmt.Errorf("unsupported KRL section type: %v", sectionType)
+		}
+	}
+	return nil
+}
+
+// parseKRLBlobs parses a sequence of strings, if size is greater than 0 each string must have this size
+func parseKRLBlobs(data []byte, result map[string]bool, size int) error {
+	for len(data) > 0 {
+		blob, rest, ok := parseKRLString(data)
+		if !ok {
+			return errKRLTruncated
+		}
+		if size > 0 && len(blob) != size {
+			return fmt.Errorf("invalid KRL fingerprint length: %v", len(blob))
+		}
+		result[string(blob)] = true
+		data = rest
+	}
+	return nil
+}
+
+func parseKRLUint32(in []byte) (uint32, []byte, bool) {
+	if len(in) < 4 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint32(in), in[4:], true
+}
+
+func parseKRLString(in []byte) ([]byte, []byte, bool) {
+	length, in, ok := parseKRLUint32(in)
+	if !ok || uint32(len(in)) < length {
+		return nil, nil, false
+	}
+	return in[:length], in[length:], true
+}
@@ -13,7 +13,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
@@ -125,13 +124,7 @@ func (c *scpCommand) handleCreateDir(dirPath string) error {
 		c.sendErrorMessage(err)
 		return err
 	}
-	if !c.connection.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(dirPath)) {
-		c.connection.Log(logger.LevelWarn, logSenderSCP, "error creating dir: %#v, permission denied", dirPath)
-		c.sendErrorMessage(errPermission)
-		return errPermission
-	}
-
-	err = c.createDir(p)
+	err = c.createDir(dirPath, p)
 	if err != nil {
 		return err
 	}
@@ -189,17 +182,10 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *Transfer) err
 }
 
 func (c *scpCommand) handleUploadFile(requestPath, filePath string, sizeToRead int64, isNewFile bool, fileSize int64) error {
-	if !c.connection.hasSpace(true) {
-		err := fmt.Errorf("denying file write due to space limit")
-		c.connection.Log(logger.LevelWarn, logSenderSCP, "error uploading file: %#v, err: %v", filePath, err)
-		c.sendErrorMessage(err)
-		return err
-	}
-
 	initialSize := int64(0)
 	if !isNewFile {
 		if vfs.IsLocalOsFs(c.connection.fs) {
-			dataprovider.UpdateUserQuota(dataProvider, c.connection.User, 0, -fileSize, false)
+			c.connection.updatePathQuota(requestPath, 0, -fileSize)
 		} else {
 			initialSize = fileSize
 		}
@@ -228,10 +214,13 @@ func (c *scpCommand) handleUploadFile(requestPath, filePath string, sizeToRead i
 		lastActivity:   time.Now(),
 		isNewFile:      isNewFile,
 		protocol:       c.connection.protocol,
+		backend:        vfs.GetBackendType(c.connection.fs, requestPath),
 		transferError:  nil,
 		isFinished:     false,
 		minWriteOffset: 0,
 		initialSize:    initialSize,
+		isIngestion:    c.connection.User.IsInIngestionFolder(c.connection.fs.GetRelativePath(requestPath)),
+		quotaFolder:    c.connection.getQuotaFolder(requestPath),
 		lock:           new(sync.Mutex),
 	}
 	addTransfer(&transfer)
@@ -244,11 +233,6 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 
 	updateConnectionActivity(c.connection.ID)
 
-	if !c.connection.User.IsFileAllowed(uploadFilePath) {
-		c.connection.Log(logger.LevelWarn, logSenderSCP, "writing file %#v is not allowed", uploadFilePath)
-		c.sendErrorMessage(errPermission)
-	}
-
 	p, err := c.connection.fs.ResolvePath(uploadFilePath)
 	if err != nil {
 		c.connection.Log(logger.LevelWarn, logSenderSCP, "error uploading file: %#v, err: %v", uploadFilePath, err)
@@ -261,10 +245,8 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 	}
 	stat, statErr := c.connection.fs.Stat(p)
 	if c.connection.fs.IsNotExist(statErr) {
-		if !c.connection.User.HasPerm(dataprovider.PermUpload, path.Dir(uploadFilePath)) {
-			c.connection.Log(logger.LevelWarn, logSenderSCP, "cannot upload file: %#v, permission denied", uploadFilePath)
-			c.sendErrorMessage(errPermission)
-			return errPermission
+		if err = c.checkPathAccess(uploadFilePath, pathAccessCreateFile); err != nil {
+			return err
 		}
 		return c.handleUploadFile(p, filePath, sizeToRead, true, 0)
 	}
@@ -282,10 +264,8 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		return err
 	}
 
-	if !c.connection.User.HasPerm(dataprovider.PermOverwrite, uploadFilePath) {
-		c.connection.Log(logger.LevelWarn, logSenderSCP, "cannot overwrite file: %#v, permission denied", uploadFilePath)
-		c.sendErrorMessage(errPermission)
-		return errPermission
+	if err = c.checkPathAccess(uploadFilePath, pathAccessOverwriteFile); err != nil {
+		return err
 	}
 
 	if isAtomicUploadEnabled() && c.connection.fs.IsAtomicUploadSupported() {
@@ -318,7 +298,7 @@ func (c *scpCommand) sendDownloadProtocolMessages(dirPath string, stat os.FileIn
 
 	dirName := filepath.Base(dirPath)
 	for _, v := range c.connection.User.VirtualFolders {
-		if v.MappedPath == dirPath {
+		if v.MappedPath == dirPath || (v.HasFilesystem() && c.connection.fs.GetRelativePath(dirPath) == v.VirtualPath) {
 			dirName = path.Base(v.VirtualPath)
 			break
 		}
@@ -464,24 +444,15 @@ func (c *scpCommand) handleDownload(filePath string) error {
 	}
 
 	if stat.IsDir() {
-		if !c.connection.User.HasPerm(dataprovider.PermDownload, filePath) {
-			c.connection.Log(logger.LevelWarn, logSenderSCP, "error downloading dir: %#v, permission denied", filePath)
-			c.sendErrorMessage(errPermission)
-			return errPermission
+		if err = c.checkPathAccess(filePath, pathAccessReadDir); err != nil {
+			return err
 		}
 		err = c.handleRecursiveDownload(p, stat)
 		return err
 	}
 
-	if !c.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(filePath)) {
-		c.connection.Log(logger.LevelWarn, logSenderSCP, "error downloading dir: %#v, permission denied", filePath)
-		c.sendErrorMessage(errPermission)
-		return errPermission
-	}
-
-	if !c.connection.User.IsFileAllowed(filePath) {
-		c.connection.Log(logger.LevelWarn, logSenderSCP, "reading file %#v is not allowed", filePath)
-		c.sendErrorMessage(errPermission)
+	if err = c.checkPathAccess(filePath, pathAccessReadFile); err != nil {
+		return err
 	}
 
 	file, r, cancelFn, err := c.connection.fs.Open(p)
@@ -506,6 +477,7 @@ func (c *scpCommand) handleDownload(filePath string) error {
 		lastActivity:   time.Now(),
 		isNewFile:      false,
 		protocol:       c.connection.protocol,
+		backend:        vfs.GetBackendType(c.connection.fs, p),
 		transferError:  nil,
 		isFinished:     false,
 		minWriteOffset: 0,
@@ -592,6 +564,19 @@ func (c *scpCommand) readProtocolMessage() (string, error) {
 	return command.String(), err
 }
 
+// checkPathAccess checks the requested access to the given path and sends the error message,
+// if any. The access is checked using the same rules as the other SSH commands
+func (c *scpCommand) checkPathAccess(sshPath string, access pathAccess) error {
+	err := c.connection.checkPathAccess(sshPath, access)
+	if err == errPermissionDenied {
+		err = errPermission
+	}
+	if err != nil {
+		c.sendErrorMessage(err)
+	}
+	return err
+}
+
 // send an error message and close the channel
 func (c *scpCommand) sendErrorMessage(err error) {
 	c.connection.channel.Write(errMsg)
@@ -641,7 +626,9 @@ func (c *scpCommand) getNextUploadProtocolMessage() (string, error) {
 	return command, err
 }
 
-func (c *scpCommand) createDir(dirPath string) error {
+// createDir creates the given directory if it does not exist, the permissions are checked
+// only if the directory is created as for SFTP
+func (c *scpCommand) createDir(sshPath, dirPath string) error {
 	var err error
 	var isDir bool
 	isDir, err = vfs.IsDirectory(c.connection.fs, dirPath)
@@ -649,6 +636,9 @@ func (c *scpCommand) createDir(dirPath string) error {
 		c.connection.Log(logger.LevelDebug, logSenderSCP, "directory %#v already exists", dirPath)
 		return nil
 	}
+	if err = c.checkPathAccess(sshPath, pathAccessCreateDir); err != nil {
+		return err
+	}
 	if err = c.connection.fs.Mkdir(dirPath); err != nil {
 		c.connection.Log(logger.LevelError, logSenderSCP, "error creating dir %#v: %v", dirPath, err)
 		c.sendErrorMessage(err)
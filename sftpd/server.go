@@ -18,6 +18,7 @@ import (
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 	"github.com/pires/go-proxyproto"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -104,6 +105,9 @@ type Configuration struct {
 	// Absolute path to an external program or an HTTP URL to invoke for keyboard interactive authentication.
 	// Leave empty to disable this authentication mode.
 	KeyboardInteractiveHook string `json:"keyboard_interactive_auth_hook" mapstructure:"keyboard_interactive_auth_hook"`
+	// Actions required on the first login, such as accepting the terms of use or changing the
+	// initial password, are completed using the keyboard interactive authentication
+	FirstLogin FirstLoginConfig `json:"first_login" mapstructure:"first_login"`
 	// Support for HAProxy PROXY protocol.
 	// If you are running SFTPGo behind a proxy server such as HAProxy, AWS ELB or NGNIX, you can enable
 	// the proxy protocol. It provides a convenient way to safely transport connection information
@@ -121,6 +125,58 @@ type Configuration struct {
 	// If proxy protocol is set to 2 and we receive a proxy header from an IP that is not in the list then the
 	// connection will be rejected.
 	ProxyAllowed []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	// If enabled the active connections for a user are closed when the user is updated or deleted
+	// using the REST API. This default can be overridden for each request using the "disconnect"
+	// query parameter
+	DisconnectOnUserChange bool `json:"disconnect_on_user_change" mapstructure:"disconnect_on_user_change"`
+	// Maximum time, as seconds, to wait for in-flight transfers to finish before forcibly closing
+	// a connection for an updated or deleted user. Connections without active transfers are closed
+	// immediately. 0 means no grace period
+	DisconnectGracePeriod int `json:"disconnect_grace_period" mapstructure:"disconnect_grace_period"`
+	// Ownership and access checks to apply, on Windows, to the paths managed using the local filesystem.
+	// On Windows uid and gid are ignored, the configured owner and group, if any, are applied instead
+	WindowsACL vfs.WindowsACLConfig `json:"windows_acl" mapstructure:"windows_acl"`
+	// If enabled, the extended attributes in the user namespace of a local file overwritten by a rename,
+	// or by the rename that completes an atomic upload, are copied to the new file after the rename.
+	// Attributes already defined for the new file are preserved. Supported on Linux and macOS
+	PreserveXattrs bool `json:"preserve_xattrs" mapstructure:"preserve_xattrs"`
+	// Thresholds to detect the transfers that are too slow, for example because of network issues
+	// on the client side. The slow transfers are marked as degraded and a "slow_transfer" action is
+	// executed, if included in the configured actions
+	SlowTransfers SlowTransfersConfig `json:"slow_transfers" mapstructure:"slow_transfers"`
+	// Local disk cache for the files stored on S3 and Google Cloud Storage. The downloaded and the
+	// uploaded files are stored inside the cache and the next downloads are served from the local
+	// copy if the object is not modified
+	DiskCache vfs.DiskCacheConfig `json:"disk_cache" mapstructure:"disk_cache"`
+	// Journal for the multipart uploads to S3. An upload interrupted by an error or by a restart
+	// can be resumed by the client, the interrupted uploads not resumed are aborted
+	UploadJournal vfs.UploadJournalConfig `json:"upload_journal" mapstructure:"upload_journal"`
+	// Timeouts for the filesystem operations for each storage backend, so a hung NFS mount or
+	// a throttled bucket fails a single operation instead of blocking the connection handler
+	FsTimeouts vfs.FsTimeoutsConfig `json:"fs_timeouts" mapstructure:"fs_timeouts"`
+	// Local filesystem mode for home directories on network filesystems such as NFS or CIFS
+	NetworkFs vfs.NetworkFsConfig `json:"network_fs" mapstructure:"network_fs"`
+	// List of IP ranges, in CIDR notation, allowed to connect, for example "192.168.1.0/24".
+	// If not empty, connections from any other address are refused before the SSH handshake.
+	// These filters apply to all the users, the per user filters are evaluated after login
+	AllowedIP []string `json:"allowed_ip" mapstructure:"allowed_ip"`
+	// List of IP ranges, in CIDR notation, not allowed to connect. Denied ranges take precedence
+	// over the allowed ones
+	DeniedIP []string `json:"denied_ip" mapstructure:"denied_ip"`
+	// Path to an OpenSSH style revoked keys file, both the binary KRL format and a text file with
+	// one public key or SHA256 fingerprint per line are supported. Public key authentication with
+	// a revoked key is refused for all the users. The file is reloaded if it changes.
+	// This can be an absolute path or a path relative to the config dir. Empty to disable
+	RevokedKeysFile string `json:"revoked_keys_file" mapstructure:"revoked_keys_file"`
+	// Login methods not allowed for all the bindings, for example "password". The connections using
+	// a denied method are refused before evaluating the user filters
+	DeniedLoginMethods []string `json:"denied_login_methods" mapstructure:"denied_login_methods"`
+	// Additional addresses to listen on, each binding can deny more login methods
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// Signed receipts for the completed transfers, they are signed using the first host key
+	Receipts ReceiptsConfig `json:"receipts" mapstructure:"receipts"`
+	// Bans the hosts with too many failed logins
+	Defender DefenderConfig `json:"defender" mapstructure:"defender"`
 }
 
 // Key contains information about host keys
@@ -146,36 +202,65 @@ func (c Configuration) Initialize(configDir string) error {
 		logger.Warn(logSender, "", "error reading umask, please fix your config file: %v", err)
 		logger.WarnToConsole("error reading umask, please fix your config file: %v", err)
 	}
+	if err = vfs.SetWindowsACLConfig(c.WindowsACL); err != nil {
+		logger.Warn(logSender, "", "error applying windows ACL config, please fix your config file: %v", err)
+		logger.WarnToConsole("error applying windows ACL config, please fix your config file: %v", err)
+	}
+	if err = vfs.SetFsTimeouts(c.FsTimeouts); err != nil {
+		logger.Warn(logSender, "", "invalid filesystem timeouts, please fix your config file: %v", err)
+		logger.WarnToConsole("invalid filesystem timeouts, please fix your config file: %v", err)
+		return err
+	}
+	if err = vfs.SetNetworkFsConfig(c.NetworkFs); err != nil {
+		logger.Warn(logSender, "", "invalid network filesystem config, please fix your config file: %v", err)
+		logger.WarnToConsole("invalid network filesystem config, please fix your config file: %v", err)
+		return err
+	}
+	if err = c.configureDiskCache(configDir); err != nil {
+		logger.Warn(logSender, "", "unable to configure the disk cache: %v", err)
+		logger.WarnToConsole("unable to configure the disk cache: %v", err)
+		return err
+	}
+	if err = c.configureUploadJournal(configDir); err != nil {
+		logger.Warn(logSender, "", "unable to configure the upload journal: %v", err)
+		logger.WarnToConsole("unable to configure the upload journal: %v", err)
+		return err
+	}
+	if err = c.configureIPFilters(); err != nil {
+		logger.Warn(logSender, "", "invalid IP filters: %v", err)
+		logger.WarnToConsole("invalid IP filters: %v", err)
+		return err
+	}
+	if err = c.configureRevokedKeys(configDir); err != nil {
+		logger.Warn(logSender, "", "unable to load revoked keys file: %v", err)
+		logger.WarnToConsole("unable to load revoked keys file: %v", err)
+		return err
+	}
+	if err = c.validateBindings(); err != nil {
+		logger.Warn(logSender, "", "invalid bindings: %v", err)
+		logger.WarnToConsole("invalid bindings: %v", err)
+		return err
+	}
+	if err = c.Defender.validate(); err != nil {
+		logger.Warn(logSender, "", "invalid defender config: %v", err)
+		logger.WarnToConsole("invalid defender config: %v", err)
+		return err
+	}
+	receiptsDir, err := c.getReceiptsPath(configDir)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to configure the transfer receipts: %v", err)
+		logger.WarnToConsole("unable to configure the transfer receipts: %v", err)
+		return err
+	}
+	termsOfUse, err := c.getFirstLoginTerms(configDir)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to configure the first login actions: %v", err)
+		logger.WarnToConsole("unable to configure the first login actions: %v", err)
+		return err
+	}
 	serverConfig := &ssh.ServerConfig{
-		NoClientAuth: false,
-		MaxAuthTries: c.MaxAuthTries,
-		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			sp, err := c.validatePasswordCredentials(conn, pass)
-			if err != nil {
-				return nil, &authenticationError{err: fmt.Sprintf("could not validate password credentials: %v", err)}
-			}
-
-			return sp, nil
-		},
-		PublicKeyCallback: func(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-			sp, err := c.validatePublicKeyCredentials(conn, pubKey.Marshal())
-			if err == ssh.ErrPartialSuccess {
-				return nil, err
-			}
-			if err != nil {
-				return nil, &authenticationError{err: fmt.Sprintf("could not validate public key credentials: %v", err)}
-			}
-
-			return sp, nil
-		},
-		NextAuthMethodsCallback: func(conn ssh.ConnMetadata) []string {
-			var nextMethods []string
-			user, err := dataprovider.UserExists(dataProvider, conn.User())
-			if err == nil {
-				nextMethods = user.GetNextAuthMethods(conn.PartialSuccessMethods())
-			}
-			return nextMethods
-		},
+		NoClientAuth:  false,
+		MaxAuthTries:  c.MaxAuthTries,
 		ServerVersion: fmt.Sprintf("SSH-2.0-%v", c.Banner),
 	}
 
@@ -185,29 +270,135 @@ func (c Configuration) Initialize(configDir string) error {
 	}
 
 	c.configureSecurityOptions(serverConfig)
-	c.configureKeyboardInteractiveAuth(serverConfig)
+	keyboardInteractiveEnabled := c.isKeyboardInteractiveHookValid()
 	c.configureLoginBanner(serverConfig, configDir)
 	c.configureSFTPExtensions()
 	c.checkSSHCommands()
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.BindAddress, c.BindPort))
-	if err != nil {
-		logger.Warn(logSender, "", "error starting listener on address %s:%d: %v", c.BindAddress, c.BindPort, err)
-		return err
+	var listeners []net.Listener
+	var proxyListeners []*proxyproto.Listener
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
 	}
-	proxyListener, err := c.getProxyListener(listener)
-	if err != nil {
-		logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-		return err
+	for _, binding := range c.getBindings() {
+		listener, err := net.Listen("tcp", binding.GetAddress())
+		if err != nil {
+			logger.Warn(logSender, "", "error starting listener on address %v: %v", binding.GetAddress(), err)
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		proxyListener, err := c.getProxyListener(listener)
+		if err != nil {
+			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+			closeListeners()
+			return err
+		}
+		proxyListeners = append(proxyListeners, proxyListener)
 	}
+	listenersMutex.Lock()
+	serverListeners = listeners
+	serverStopped = false
+	listenersMutex.Unlock()
 	actions = c.Actions
+	receipts = c.Receipts
+	defender.configure(c.Defender)
+	receiptsPath = receiptsDir
+	firstLoginTerms = termsOfUse
 	uploadMode = c.UploadMode
 	setstatMode = c.SetstatMode
-	logger.Info(logSender, "", "server listener registered address: %v", listener.Addr().String())
+	disconnectOnUserChange = c.DisconnectOnUserChange
+	disconnectGracePeriod = time.Duration(c.DisconnectGracePeriod) * time.Second
+	preserveXattrs = c.PreserveXattrs
+	c.setLoginPolicies()
 	c.checkIdleTimer()
+	c.checkSlowTransfersTimer()
+	startIngestionScheduler()
+
+	errCh := make(chan error, len(listeners))
+	for idx, binding := range c.getBindings() {
+		bindingConfig := c.getBindingServerConfig(serverConfig, c.getLoginPolicy(binding), keyboardInteractiveEnabled)
+		go func(listener net.Listener, proxyListener *proxyproto.Listener) {
+			errCh <- c.serve(listener, proxyListener, bindingConfig)
+		}(listeners[idx], proxyListeners[idx])
+	}
+	return <-errCh
+}
+
+// getBindingServerConfig returns a copy of the given server config with the authentication
+// callbacks that enforce the specified login policy
+func (c Configuration) getBindingServerConfig(baseConfig *ssh.ServerConfig, policy *bindingLoginPolicy,
+	keyboardInteractiveEnabled bool) *ssh.ServerConfig {
+	serverConfig := *baseConfig
+	if !policy.isLoginMethodDenied(dataprovider.SSHLoginMethodPassword) ||
+		!policy.isLoginMethodDenied(dataprovider.SSHLoginMethodKeyAndPassword) {
+		serverConfig.PasswordCallback = func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			sp, err := c.validatePasswordCredentials(conn, pass, policy)
+			if err != nil {
+				return nil, &authenticationError{err: fmt.Sprintf("could not validate password credentials: %v", err)}
+			}
+
+			return sp, nil
+		}
+	}
+	if !policy.isPublicKeyDenied() {
+		serverConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+			if revokedKeysList != nil {
+				if err := revokedKeysList.isRevoked(pubKey); err != nil {
+					logger.ConnectionFailedLog(conn.User(), utils.GetIPFromRemoteAddress(conn.RemoteAddr().String()),
+						dataprovider.SSHLoginMethodPublicKey, err.Error())
+					return nil, &authenticationError{err: fmt.Sprintf("could not validate public key credentials: %v", err)}
+				}
+			}
+			sp, err := c.validatePublicKeyCredentials(conn, pubKey.Marshal(), policy)
+			if err == ssh.ErrPartialSuccess {
+				return nil, err
+			}
+			if err != nil {
+				return nil, &authenticationError{err: fmt.Sprintf("could not validate public key credentials: %v", err)}
+			}
+
+			return sp, nil
+		}
+	}
+	if (keyboardInteractiveEnabled || c.FirstLogin.Enabled) &&
+		(!policy.isLoginMethodDenied(dataprovider.SSHLoginMethodKeyboardInteractive) ||
+			!policy.isLoginMethodDenied(dataprovider.SSHLoginMethodKeyAndKeyboardInt)) {
+		serverConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			sp, err := c.validateKeyboardInteractiveCredentials(conn, client, policy, keyboardInteractiveEnabled)
+			if err != nil {
+				return nil, &authenticationError{err: fmt.Sprintf("could not validate keyboard interactive credentials: %v", err)}
+			}
+
+			return sp, nil
+		}
+	}
+	serverConfig.NextAuthMethodsCallback = func(conn ssh.ConnMetadata) []string {
+		var nextMethods []string
+		user, err := dataprovider.UserExists(dataProvider, conn.User())
+		if err == nil {
+			nextMethods = user.GetNextAuthMethods(conn.PartialSuccessMethods())
+			if len(conn.PartialSuccessMethods()) == 1 && c.isFirstLoginStepRequired(user, policy) &&
+				!utils.IsStringInSlice(dataprovider.SSHLoginMethodKeyboardInteractive, nextMethods) {
+				nextMethods = append(nextMethods, dataprovider.SSHLoginMethodKeyboardInteractive)
+			}
+			nextMethods = policy.filterNextAuthMethods(nextMethods)
+		}
+		return nextMethods
+	}
+	return &serverConfig
+}
+
+// serve accepts the inbound connections for the given listener, it returns only after StopServer
+func (c Configuration) serve(listener net.Listener, proxyListener *proxyproto.Listener,
+	serverConfig *ssh.ServerConfig) error {
+	logger.Info(logSender, "", "server listener registered address: %v", listener.Addr().String())
 
 	for {
 		var conn net.Conn
+		var err error
 		if proxyListener != nil {
 			conn, err = proxyListener.Accept()
 		} else {
@@ -215,6 +406,9 @@ func (c Configuration) Initialize(configDir string) error {
 		}
 		if conn != nil && err == nil {
 			go c.AcceptInboundConnection(conn, serverConfig)
+		} else if isServerStopped() {
+			logger.Info(logSender, "", "server listener stopped, address: %v", listener.Addr().String())
+			return nil
 		}
 	}
 }
@@ -250,12 +444,67 @@ func (c *Configuration) getProxyListener(listener net.Listener) (*proxyproto.Lis
 	return proxyListener, nil
 }
 
+func (c Configuration) configureIPFilters() error {
+	allowed, err := parseNetworks(c.AllowedIP)
+	if err != nil {
+		return fmt.Errorf("invalid allowed_ip: %v", err)
+	}
+	denied, err := parseNetworks(c.DeniedIP)
+	if err != nil {
+		return fmt.Errorf("invalid denied_ip: %v", err)
+	}
+	allowedNetworks = allowed
+	deniedNetworks = denied
+	return nil
+}
+
+func parseNetworks(ipMasks []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, ipMask := range ipMasks {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(ipMask))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
+
+// isIPAllowed returns false if the given IP address matches a denied network or if
+// allowed networks are defined and none of them matches the IP address
+func isIPAllowed(ipAddr string) bool {
+	if len(allowedNetworks) == 0 && len(deniedNetworks) == 0 {
+		return true
+	}
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range deniedNetworks {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	for _, ipNet := range allowedNetworks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return len(allowedNetworks) == 0
+}
+
 func (c Configuration) checkIdleTimer() {
 	if c.IdleTimeout > 0 {
 		startIdleTimer(time.Duration(c.IdleTimeout) * time.Minute)
 	}
 }
 
+func (c Configuration) checkSlowTransfersTimer() {
+	if c.SlowTransfers.MinSpeed > 0 {
+		startSlowTransfersChecker(c.SlowTransfers)
+	}
+}
+
 func (c Configuration) configureSecurityOptions(serverConfig *ssh.ServerConfig) {
 	if len(c.KexAlgorithms) > 0 {
 		serverConfig.KeyExchanges = c.KexAlgorithms
@@ -268,6 +517,39 @@ func (c Configuration) configureSecurityOptions(serverConfig *ssh.ServerConfig)
 	}
 }
 
+func (c Configuration) configureRevokedKeys(configDir string) error {
+	revokedKeysList = nil
+	if len(c.RevokedKeysFile) == 0 {
+		return nil
+	}
+	revokedKeysFilePath := c.RevokedKeysFile
+	if !filepath.IsAbs(revokedKeysFilePath) {
+		revokedKeysFilePath = filepath.Join(configDir, revokedKeysFilePath)
+	}
+	revoked, err := newRevokedKeys(revokedKeysFilePath)
+	if err != nil {
+		return err
+	}
+	revokedKeysList = revoked
+	return nil
+}
+
+func (c Configuration) configureDiskCache(configDir string) error {
+	diskCacheConfig := c.DiskCache
+	if len(diskCacheConfig.Path) > 0 && !filepath.IsAbs(diskCacheConfig.Path) {
+		diskCacheConfig.Path = filepath.Join(configDir, diskCacheConfig.Path)
+	}
+	return vfs.SetDiskCacheConfig(diskCacheConfig)
+}
+
+func (c Configuration) configureUploadJournal(configDir string) error {
+	uploadJournalConfig := c.UploadJournal
+	if len(uploadJournalConfig.Path) > 0 && !filepath.IsAbs(uploadJournalConfig.Path) {
+		uploadJournalConfig.Path = filepath.Join(configDir, uploadJournalConfig.Path)
+	}
+	return vfs.SetUploadJournalConfig(uploadJournalConfig)
+}
+
 func (c Configuration) configureLoginBanner(serverConfig *ssh.ServerConfig, configDir string) error {
 	var err error
 	if len(c.LoginBannerFile) > 0 {
@@ -290,9 +572,11 @@ func (c Configuration) configureLoginBanner(serverConfig *ssh.ServerConfig, conf
 	return err
 }
 
-func (c Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.ServerConfig) {
+// isKeyboardInteractiveHookValid returns true if the keyboard interactive authentication is configured
+// and the hook is valid
+func (c Configuration) isKeyboardInteractiveHookValid() bool {
 	if len(c.KeyboardInteractiveHook) == 0 {
-		return
+		return false
 	}
 	if !strings.HasPrefix(c.KeyboardInteractiveHook, "http") {
 		if !filepath.IsAbs(c.KeyboardInteractiveHook) {
@@ -300,23 +584,16 @@ func (c Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Server
 				c.KeyboardInteractiveHook)
 			logger.Warn(logSender, "", "invalid keyboard interactive authentication program: %#v must be an absolute path",
 				c.KeyboardInteractiveHook)
-			return
+			return false
 		}
 		_, err := os.Stat(c.KeyboardInteractiveHook)
 		if err != nil {
 			logger.WarnToConsole("invalid keyboard interactive authentication program:: %v", err)
 			logger.Warn(logSender, "", "invalid keyboard interactive authentication program:: %v", err)
-			return
-		}
-	}
-	serverConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
-		sp, err := c.validateKeyboardInteractiveCredentials(conn, client)
-		if err != nil {
-			return nil, &authenticationError{err: fmt.Sprintf("could not validate keyboard interactive credentials: %v", err)}
+			return false
 		}
-
-		return sp, nil
 	}
+	return true
 }
 
 func (c Configuration) configureSFTPExtensions() error {
@@ -330,11 +607,27 @@ func (c Configuration) configureSFTPExtensions() error {
 
 // AcceptInboundConnection handles an inbound connection to the server instance and determines if the request should be served or not.
 func (c Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
+	remoteAddr := conn.RemoteAddr()
+	ipAddr := utils.GetIPFromRemoteAddress(remoteAddr.String())
+	if !isIPAllowed(ipAddr) {
+		logger.Debug(logSender, "", "connection from IP address %#v refused by the IP filters", ipAddr)
+		conn.Close()
+		return
+	}
+	if dataprovider.IsIPBlocked(ipAddr) {
+		logger.Debug(logSender, "", "connection from blocked IP address %#v refused", ipAddr)
+		conn.Close()
+		return
+	}
+	if defender.isBanned(ipAddr) {
+		logger.Debug(logSender, "", "connection from banned IP address %#v refused", ipAddr)
+		conn.Close()
+		return
+	}
 
 	// Before beginning a handshake must be performed on the incoming net.Conn
 	// we'll set a Deadline for handshake to complete, the default is 2 minutes as OpenSSH
 	conn.SetDeadline(time.Now().Add(handshakeTimeout))
-	remoteAddr := conn.RemoteAddr()
 	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
 	if err != nil {
 		logger.Warn(logSender, "", "failed to accept an incoming connection: %v", err)
@@ -379,6 +672,7 @@ func (c Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Server
 	connection.Log(logger.LevelInfo, logSender, "User id: %d, logged in with: %#v, username: %#v, home_dir: %#v remote addr: %#v",
 		user.ID, loginType, user.Username, user.HomeDir, remoteAddr.String())
 	dataprovider.UpdateLastLogin(dataProvider, user)
+	addLoginActivity(user.Username, time.Now())
 
 	go ssh.DiscardRequests(reqs)
 
@@ -450,47 +744,75 @@ func (c Configuration) createHandler(connection Connection) sftp.Handlers {
 	}
 }
 
-func loginUser(user dataprovider.User, loginMethod, publicKey string, conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+func loginUser(user dataprovider.User, loginMethod, publicKey string, conn ssh.ConnMetadata,
+	policy *bindingLoginPolicy) (*ssh.Permissions, error) {
 	connectionID := ""
+	remoteAddr := ""
+	var partialSuccessMethods []string
 	if conn != nil {
 		connectionID = hex.EncodeToString(conn.SessionID())
+		remoteAddr = conn.RemoteAddr().String()
+		partialSuccessMethods = conn.PartialSuccessMethods()
+	}
+	if _, err := checkLoginPolicy(user, loginMethod, connectionID, remoteAddr, partialSuccessMethods, policy); err != nil {
+		return nil, err
+	}
+	if dataprovider.ApplyUserOverride(&user) {
+		logger.Debug(logSender, connectionID, "temporary override applied for user %#v, quota size: %v, quota files: %v, "+
+			"upload bandwidth: %v, download bandwidth: %v", user.Username, user.QuotaSize, user.QuotaFiles,
+			user.UploadBandwidth, user.DownloadBandwidth)
+	}
+
+	json, err := json.Marshal(user)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "error serializing user info: %v, authentication rejected", err)
+		return nil, err
+	}
+	if len(publicKey) > 0 {
+		loginMethod = fmt.Sprintf("%v: %v", loginMethod, publicKey)
+	}
+	p := &ssh.Permissions{}
+	p.Extensions = make(map[string]string)
+	p.Extensions["user"] = string(json)
+	p.Extensions["login_method"] = loginMethod
+	return p, nil
+}
+
+// checkLoginPolicy checks the conditions to satisfy, after a successful authentication, to allow a login.
+// The binding login policy, if any, is evaluated before the user filters.
+// If the login is not allowed the returned rule identifies the refusing check
+func checkLoginPolicy(user dataprovider.User, loginMethod, connectionID, remoteAddr string,
+	partialSuccessMethods []string, policy *bindingLoginPolicy) (string, error) {
+	if err := checkBindingLoginMethod(loginMethod, connectionID, policy); err != nil {
+		return LoginRuleBindingLoginMethod, err
 	}
 	if !filepath.IsAbs(user.HomeDir) {
 		logger.Warn(logSender, connectionID, "user %#v has an invalid home dir: %#v. Home dir must be an absolute path, login not allowed",
 			user.Username, user.HomeDir)
-		return nil, fmt.Errorf("cannot login user with invalid home dir: %#v", user.HomeDir)
+		return LoginRuleHomeDir, fmt.Errorf("cannot login user with invalid home dir: %#v", user.HomeDir)
 	}
 	if user.MaxSessions > 0 {
 		activeSessions := getActiveSessions(user.Username)
 		if activeSessions >= user.MaxSessions {
 			logger.Debug(logSender, "", "authentication refused for user: %#v, too many open sessions: %v/%v", user.Username,
 				activeSessions, user.MaxSessions)
-			return nil, fmt.Errorf("too many open sessions: %v", activeSessions)
+			return LoginRuleMaxSessions, fmt.Errorf("too many open sessions: %v", activeSessions)
 		}
 	}
-	if !user.IsLoginMethodAllowed(loginMethod, conn.PartialSuccessMethods()) {
+	if !user.IsLoginMethodAllowed(loginMethod, partialSuccessMethods) {
 		logger.Debug(logSender, connectionID, "cannot login user %#v, login method %#v is not allowed", user.Username, loginMethod)
-		return nil, fmt.Errorf("Login method %#v is not allowed for user %#v", loginMethod, user.Username)
+		return LoginRuleLoginMethod, fmt.Errorf("Login method %#v is not allowed for user %#v", loginMethod, user.Username)
 	}
-	remoteAddr := conn.RemoteAddr().String()
 	if !user.IsLoginFromAddrAllowed(remoteAddr) {
 		logger.Debug(logSender, connectionID, "cannot login user %#v, remote address is not allowed: %v", user.Username, remoteAddr)
-		return nil, fmt.Errorf("Login for user %#v is not allowed from this address: %v", user.Username, remoteAddr)
+		return LoginRuleUserIPFilter, fmt.Errorf("Login for user %#v is not allowed from this address: %v", user.Username, remoteAddr)
 	}
-
-	json, err := json.Marshal(user)
-	if err != nil {
-		logger.Warn(logSender, connectionID, "error serializing user info: %v, authentication rejected", err)
-		return nil, err
+	if user.HasPendingFirstLoginActions() {
+		logger.Debug(logSender, connectionID, "cannot login user %#v, the first login actions are not completed", user.Username)
+		return LoginRuleFirstLogin, fmt.Errorf("Login for user %#v is not allowed, the first login actions must be completed "+
+			"using keyboard interactive authentication", user.Username)
 	}
-	if len(publicKey) > 0 {
-		loginMethod = fmt.Sprintf("%v: %v", loginMethod, publicKey)
-	}
-	p := &ssh.Permissions{}
-	p.Extensions = make(map[string]string)
-	p.Extensions["user"] = string(json)
-	p.Extensions["login_method"] = loginMethod
-	return p, nil
+	return "", nil
 }
 
 func (c *Configuration) checkSSHCommands() {
@@ -531,7 +853,7 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 			c.Keys = append(c.Keys, Key{PrivateKey: k})
 		}
 	}
-	for _, k := range c.Keys {
+	for idx, k := range c.Keys {
 		privateFile := k.PrivateKey
 		if !filepath.IsAbs(privateFile) {
 			privateFile = filepath.Join(configDir, privateFile)
@@ -550,11 +872,24 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 
 		// Add private key to the server configuration.
 		serverConfig.AddHostKey(private)
+		if idx == 0 {
+			receiptSigner = private
+		}
+	}
+	return nil
+}
+
+// checkBindingLoginMethod returns an error if the login method is denied by the given binding policy
+func checkBindingLoginMethod(loginMethod, connectionID string, policy *bindingLoginPolicy) error {
+	if policy != nil && policy.isLoginMethodDenied(loginMethod) {
+		logger.Debug(logSender, connectionID, "login method %#v is not allowed for this binding", loginMethod)
+		return fmt.Errorf("Login method %#v is not allowed for this binding", loginMethod)
 	}
 	return nil
 }
 
-func (c Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubKey []byte) (*ssh.Permissions, error) {
+func (c Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubKey []byte,
+	policy *bindingLoginPolicy) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
 	var keyID string
@@ -563,21 +898,24 @@ func (c Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubKe
 	connectionID := hex.EncodeToString(conn.SessionID())
 	method := dataprovider.SSHLoginMethodPublicKey
 	if user, keyID, err = dataprovider.CheckUserAndPubKey(dataProvider, conn.User(), pubKey); err == nil {
-		if user.IsPartialAuth(method) {
+		if user.IsPartialAuth(method) || c.isFirstLoginStepRequired(user, policy) {
 			logger.Debug(logSender, connectionID, "user %#v authenticated with partial success", conn.User())
 			return nil, ssh.ErrPartialSuccess
 		}
-		sshPerm, err = loginUser(user, method, keyID, conn)
+		sshPerm, err = loginUser(user, method, keyID, conn, policy)
 	}
 	metrics.AddLoginAttempt(method)
 	if err != nil {
-		logger.ConnectionFailedLog(conn.User(), utils.GetIPFromRemoteAddress(conn.RemoteAddr().String()), method, err.Error())
+		ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+		logger.ConnectionFailedLog(conn.User(), ipAddr, method, err.Error())
+		defender.addLoginFailure(ipAddr, err)
 	}
 	metrics.AddLoginResult(method, err)
 	return sshPerm, err
 }
 
-func (c Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+func (c Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass []byte,
+	policy *bindingLoginPolicy) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
 	var sshPerm *ssh.Permissions
@@ -587,17 +925,23 @@ func (c Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass [
 		method = dataprovider.SSHLoginMethodKeyAndPassword
 	}
 	metrics.AddLoginAttempt(method)
-	if user, err = dataprovider.CheckUserAndPass(dataProvider, conn.User(), string(pass)); err == nil {
-		sshPerm, err = loginUser(user, method, "", conn)
+	// the binding policy is checked before the credentials, the user filters are checked after
+	if err = checkBindingLoginMethod(method, hex.EncodeToString(conn.SessionID()), policy); err == nil {
+		if user, err = dataprovider.CheckUserAndPass(dataProvider, conn.User(), string(pass)); err == nil {
+			sshPerm, err = loginUser(user, method, "", conn, policy)
+		}
 	}
 	if err != nil {
-		logger.ConnectionFailedLog(conn.User(), utils.GetIPFromRemoteAddress(conn.RemoteAddr().String()), method, err.Error())
+		ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+		logger.ConnectionFailedLog(conn.User(), ipAddr, method, err.Error())
+		defender.addLoginFailure(ipAddr, err)
 	}
 	metrics.AddLoginResult(method, err)
 	return sshPerm, err
 }
 
-func (c Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+func (c Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge,
+	policy *bindingLoginPolicy, keyboardInteractiveEnabled bool) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
 	var sshPerm *ssh.Permissions
@@ -607,11 +951,23 @@ func (c Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMetad
 		method = dataprovider.SSHLoginMethodKeyAndKeyboardInt
 	}
 	metrics.AddLoginAttempt(method)
-	if user, err = dataprovider.CheckKeyboardInteractiveAuth(dataProvider, conn.User(), c.KeyboardInteractiveHook, client); err == nil {
-		sshPerm, err = loginUser(user, method, "", conn)
+	if err = checkBindingLoginMethod(method, hex.EncodeToString(conn.SessionID()), policy); err == nil {
+		// the first login actions take precedence over the keyboard interactive hook
+		if c.hasPendingFirstLoginActions(conn.User()) {
+			user, err = c.doFirstLoginActions(conn, client, method)
+		} else if keyboardInteractiveEnabled {
+			user, err = dataprovider.CheckKeyboardInteractiveAuth(dataProvider, conn.User(), c.KeyboardInteractiveHook, client)
+		} else {
+			err = errors.New("keyboard interactive authentication is not enabled")
+		}
+		if err == nil {
+			sshPerm, err = loginUser(user, method, "", conn, policy)
+		}
 	}
 	if err != nil {
-		logger.ConnectionFailedLog(conn.User(), utils.GetIPFromRemoteAddress(conn.RemoteAddr().String()), method, err.Error())
+		ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+		logger.ConnectionFailedLog(conn.User(), ipAddr, method, err.Error())
+		defender.addLoginFailure(ipAddr, err)
 	}
 	metrics.AddLoginResult(method, err)
 	return sshPerm, err
@@ -7,11 +7,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,32 +23,37 @@ import (
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 )
 
 const (
-	logSender           = "sftpd"
-	logSenderSCP        = "scp"
-	logSenderSSH        = "ssh"
-	uploadLogSender     = "Upload"
-	downloadLogSender   = "Download"
-	renameLogSender     = "Rename"
-	rmdirLogSender      = "Rmdir"
-	mkdirLogSender      = "Mkdir"
-	symlinkLogSender    = "Symlink"
-	removeLogSender     = "Remove"
-	chownLogSender      = "Chown"
-	chmodLogSender      = "Chmod"
-	chtimesLogSender    = "Chtimes"
-	sshCommandLogSender = "SSHCommand"
-	operationDownload   = "download"
-	operationUpload     = "upload"
-	operationDelete     = "delete"
-	operationRename     = "rename"
-	operationSSHCmd     = "ssh_cmd"
-	protocolSFTP        = "SFTP"
-	protocolSCP         = "SCP"
-	protocolSSH         = "SSH"
-	handshakeTimeout    = 2 * time.Minute
+	logSender               = "sftpd"
+	logSenderSCP            = "scp"
+	logSenderSSH            = "ssh"
+	uploadLogSender         = "Upload"
+	downloadLogSender       = "Download"
+	renameLogSender         = "Rename"
+	rmdirLogSender          = "Rmdir"
+	mkdirLogSender          = "Mkdir"
+	symlinkLogSender        = "Symlink"
+	removeLogSender         = "Remove"
+	chownLogSender          = "Chown"
+	chmodLogSender          = "Chmod"
+	chtimesLogSender        = "Chtimes"
+	truncateLogSender       = "Truncate"
+	sshCommandLogSender     = "SSHCommand"
+	operationDownload       = "download"
+	operationUpload         = "upload"
+	operationDelete         = "delete"
+	operationRename         = "rename"
+	operationSSHCmd         = "ssh_cmd"
+	operationSSHExec        = "ssh_exec"
+	operationSlowTransfer   = "slow_transfer"
+	protocolSFTP            = "SFTP"
+	protocolSCP             = "SCP"
+	protocolSSH             = "SSH"
+	handshakeTimeout        = 2 * time.Minute
+	disconnectCheckInterval = 500 * time.Millisecond
 )
 
 const (
@@ -55,17 +63,32 @@ const (
 )
 
 var (
-	mutex                sync.RWMutex
-	openConnections      map[string]Connection
-	activeTransfers      []*Transfer
-	idleTimeout          time.Duration
-	activeQuotaScans     []ActiveQuotaScan
-	dataProvider         dataprovider.Provider
-	actions              Actions
-	uploadMode           int
-	setstatMode          int
-	supportedSSHCommands = []string{"scp", "md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum", "cd", "pwd",
-		"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync"}
+	mutex                  sync.RWMutex
+	openConnections        map[string]Connection
+	closingConnections     map[string]bool
+	activeTransfers        []*Transfer
+	idleTimeout            time.Duration
+	activeQuotaScans       []ActiveQuotaScan
+	activeFolderQuotaScans []ActiveFolderQuotaScan
+	dataProvider           dataprovider.Provider
+	actions                Actions
+	uploadMode             int
+	setstatMode            int
+	disconnectOnUserChange bool
+	preserveXattrs         bool
+	disconnectGracePeriod  time.Duration
+	allowedNetworks        []*net.IPNet
+	deniedNetworks         []*net.IPNet
+	revokedKeysList        *revokedKeys
+	actionHooksMutex       sync.RWMutex
+	actionHooks            []ActionHook
+	listenersMutex         sync.Mutex
+	serverListeners        []net.Listener
+	serverStopped          bool
+	loginPoliciesMutex     sync.RWMutex
+	loginPolicies          map[int]*bindingLoginPolicy
+	supportedSSHCommands   = []string{"scp", "md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum", "cd", "pwd",
+		"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", tailCommand}
 	defaultSSHCommands = []string{"md5sum", "sha1sum", "cd", "pwd", "scp"}
 	sshHashCommands    = []string{"md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum"}
 	systemCommands     = []string{"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync"}
@@ -77,6 +100,44 @@ type connectionTransfer struct {
 	Size          int64  `json:"size"`
 	LastActivity  int64  `json:"last_activity"`
 	Path          string `json:"path"`
+	Degraded      bool   `json:"degraded"`
+}
+
+// QuotaScanProgress defines the progress of an active quota scan
+type QuotaScanProgress struct {
+	// number of files counted so far
+	ScannedFiles int `json:"scanned_files"`
+	// bytes counted so far
+	ScannedSize int64 `json:"scanned_size"`
+	// estimated end time as unix timestamp in milliseconds, 0 means unknown.
+	// The estimate is based on the used quota tracked before the scan
+	EstimatedEndTime int64 `json:"estimated_end_time"`
+	progress         *vfs.ScanProgress
+}
+
+// GetEstimatedEndTimeAsString returns the estimated end time formatted as YYYY-MM-DD HH:MM:SS
+func (p *QuotaScanProgress) GetEstimatedEndTimeAsString() string {
+	if p.EstimatedEndTime <= 0 {
+		return ""
+	}
+	return utils.GetTimeFromMsecSinceEpoch(p.EstimatedEndTime).Format("2006-01-02 15:04:05")
+}
+
+// update sets the exported fields from the progress tracked while scanning
+func (p *QuotaScanProgress) update(startTime, now int64) {
+	p.ScannedFiles, p.ScannedSize = p.progress.GetScanned()
+	p.EstimatedEndTime = 0
+	expectedFiles, expectedSize := p.progress.GetExpected()
+	done, total := p.ScannedSize, expectedSize
+	if total <= 0 {
+		done, total = int64(p.ScannedFiles), int64(expectedFiles)
+	}
+	// if the scan already counted more than expected the estimate is unknown
+	if done <= 0 || done >= total || now <= startTime {
+		return
+	}
+	elapsed := float64(now - startTime)
+	p.EstimatedEndTime = startTime + int64(elapsed*float64(total)/float64(done))
 }
 
 // ActiveQuotaScan defines an active quota scan
@@ -85,12 +146,32 @@ type ActiveQuotaScan struct {
 	Username string `json:"username"`
 	// quota scan start time as unix timestamp in milliseconds
 	StartTime int64 `json:"start_time"`
+	QuotaScanProgress
+}
+
+// GetStartTimeAsString returns the scan start time formatted as YYYY-MM-DD HH:MM:SS
+func (s *ActiveQuotaScan) GetStartTimeAsString() string {
+	return utils.GetTimeFromMsecSinceEpoch(s.StartTime).Format("2006-01-02 15:04:05")
+}
+
+// ActiveFolderQuotaScan defines an active quota scan for a shared folder
+type ActiveFolderQuotaScan struct {
+	// Name of the shared folder to which the quota scan refers
+	Name string `json:"name"`
+	// quota scan start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	QuotaScanProgress
+}
+
+// GetStartTimeAsString returns the scan start time formatted as YYYY-MM-DD HH:MM:SS
+func (s *ActiveFolderQuotaScan) GetStartTimeAsString() string {
+	return utils.GetTimeFromMsecSinceEpoch(s.StartTime).Format("2006-01-02 15:04:05")
 }
 
 // Actions to execute on SFTP create, download, delete and rename.
 // An external command can be executed and/or an HTTP notification can be fired
 type Actions struct {
-	// Valid values are download, upload, delete, rename, ssh_cmd. Empty slice to disable
+	// Valid values are download, upload, delete, rename, ssh_cmd, ssh_exec, slow_transfer. Empty slice to disable
 	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
 	// Absolute path to the command to execute, empty to disable
 	Command string `json:"command" mapstructure:"command"`
@@ -118,6 +199,12 @@ type ConnectionStatus struct {
 	Transfers []connectionTransfer `json:"active_transfers"`
 	// for protocol SSH this is the issued command
 	SSHCommand string `json:"ssh_command"`
+	// client software resolved from the client's version string, for example "WinSCP"
+	ClientSoftware string `json:"client_software,omitempty"`
+	// tenant configured for the logged in user, if any
+	Tenant string `json:"tenant,omitempty"`
+	// human readable label set by an administrator, if any
+	Label string `json:"label,omitempty"`
 }
 
 type sshSubsystemExitStatus struct {
@@ -128,7 +215,9 @@ type sshSubsystemExecMsg struct {
 	Command string
 }
 
-type actionNotification struct {
+// ActionNotification defines a notified action, it is sent to the HTTP notification URL as JSON
+// and to the in-process hooks
+type ActionNotification struct {
 	Action     string `json:"action"`
 	Username   string `json:"username"`
 	Path       string `json:"path"`
@@ -139,10 +228,12 @@ type actionNotification struct {
 	Bucket     string `json:"bucket,omitempty"`
 	Endpoint   string `json:"endpoint,omitempty"`
 	Status     int    `json:"status"`
+	// signed receipt for a completed upload or download, if enabled
+	Receipt *TransferReceipt `json:"receipt,omitempty"`
 }
 
 func newActionNotification(user dataprovider.User, operation, filePath, target, sshCmd string, fileSize int64,
-	err error) actionNotification {
+	err error) ActionNotification {
 	bucket := ""
 	endpoint := ""
 	status := 1
@@ -151,11 +242,19 @@ func newActionNotification(user dataprovider.User, operation, filePath, target,
 		endpoint = user.FsConfig.S3Config.Endpoint
 	} else if user.FsConfig.Provider == 2 {
 		bucket = user.FsConfig.GCSConfig.Bucket
+	} else if user.FsConfig.Provider == 4 {
+		endpoint = user.FsConfig.WebDAVConfig.Endpoint
+	} else if user.FsConfig.Provider == 5 {
+		endpoint = user.FsConfig.HDFSConfig.Endpoint
+	} else if user.FsConfig.Provider == 6 {
+		endpoint = user.FsConfig.GoogleDriveConfig.Endpoint
+	} else if user.FsConfig.Provider == 7 {
+		endpoint = user.FsConfig.DropboxConfig.Endpoint
 	}
 	if err != nil {
 		status = 0
 	}
-	return actionNotification{
+	return ActionNotification{
 		Action:     operation,
 		Username:   user.Username,
 		Path:       filePath,
@@ -169,13 +268,15 @@ func newActionNotification(user dataprovider.User, operation, filePath, target,
 	}
 }
 
-func (a *actionNotification) AsJSON() []byte {
+// AsJSON returns the notification as JSON
+func (a *ActionNotification) AsJSON() []byte {
 	res, _ := json.Marshal(a)
 	return res
 }
 
-func (a *actionNotification) AsEnvVars() []string {
-	return []string{fmt.Sprintf("SFTPGO_ACTION=%v", a.Action),
+// AsEnvVars returns the notification as environment variables for the notification command
+func (a *ActionNotification) AsEnvVars() []string {
+	envVars := []string{fmt.Sprintf("SFTPGO_ACTION=%v", a.Action),
 		fmt.Sprintf("SFTPGO_ACTION_USERNAME=%v", a.Username),
 		fmt.Sprintf("SFTPGO_ACTION_PATH=%v", a.Path),
 		fmt.Sprintf("SFTPGO_ACTION_TARGET=%v", a.TargetPath),
@@ -186,10 +287,16 @@ func (a *actionNotification) AsEnvVars() []string {
 		fmt.Sprintf("SFTPGO_ACTION_ENDPOINT=%v", a.Endpoint),
 		fmt.Sprintf("SFTPGO_ACTION_STATUS=%v", a.Status),
 	}
+	if a.Receipt != nil {
+		receipt, _ := json.Marshal(a.Receipt)
+		envVars = append(envVars, fmt.Sprintf("SFTPGO_ACTION_RECEIPT=%v", string(receipt)))
+	}
+	return envVars
 }
 
 func init() {
 	openConnections = make(map[string]Connection)
+	closingConnections = make(map[string]bool)
 }
 
 // GetDefaultSSHCommands returns the SSH commands enabled as default
@@ -223,6 +330,21 @@ func (c ConnectionStatus) GetConnectionInfo() string {
 	return result
 }
 
+// GetTagsAsString returns the non empty connection tags as string
+func (c ConnectionStatus) GetTagsAsString() string {
+	var tags []string
+	if len(c.ClientSoftware) > 0 {
+		tags = append(tags, "Software: "+c.ClientSoftware)
+	}
+	if len(c.Tenant) > 0 {
+		tags = append(tags, "Tenant: "+c.Tenant)
+	}
+	if len(c.Label) > 0 {
+		tags = append(tags, "Label: "+c.Label)
+	}
+	return strings.Join(tags, ". ")
+}
+
 // GetTransfersAsString returns the active transfers as string
 func (c ConnectionStatus) GetTransfersAsString() string {
 	result := ""
@@ -249,6 +371,9 @@ func (t connectionTransfer) getConnectionTransferAsString() string {
 		result += fmt.Sprintf("Size: %#v Elapsed: %#v Speed: \"%.1f KB/s\"", utils.ByteCountSI(t.Size),
 			utils.GetDurationAsString(elapsed), speed)
 	}
+	if t.Degraded {
+		result += " (degraded)"
+	}
 	return result
 }
 
@@ -269,15 +394,43 @@ func getActiveSessions(username string) int {
 	return numSessions
 }
 
-// GetQuotaScans returns the active quota scans
+// GetQuotaScans returns the active quota scans with their progress
 func GetQuotaScans() []ActiveQuotaScan {
 	mutex.RLock()
 	defer mutex.RUnlock()
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
 	scans := make([]ActiveQuotaScan, len(activeQuotaScans))
 	copy(scans, activeQuotaScans)
+	for idx := range scans {
+		scans[idx].update(scans[idx].StartTime, now)
+	}
 	return scans
 }
 
+// GetQuotaScan returns the active quota scan, with its progress, for the given user.
+// Returns false if the user has no quota scan running
+func GetQuotaScan(username string) (ActiveQuotaScan, bool) {
+	for _, s := range GetQuotaScans() {
+		if s.Username == username {
+			return s, true
+		}
+	}
+	return ActiveQuotaScan{}, false
+}
+
+// GetQuotaScanProgress returns the object to use to report the progress for the active
+// quota scan of the given user, nil if the user has no quota scan running
+func GetQuotaScanProgress(username string) *vfs.ScanProgress {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, s := range activeQuotaScans {
+		if s.Username == username {
+			return s.progress
+		}
+	}
+	return nil
+}
+
 // AddQuotaScan add a user to the ones with active quota scans.
 // Returns false if the user has a quota scan already running
 func AddQuotaScan(username string) bool {
@@ -289,8 +442,9 @@ func AddQuotaScan(username string) bool {
 		}
 	}
 	activeQuotaScans = append(activeQuotaScans, ActiveQuotaScan{
-		Username:  username,
-		StartTime: utils.GetTimeAsMsSinceEpoch(time.Now()),
+		Username:          username,
+		StartTime:         utils.GetTimeAsMsSinceEpoch(time.Now()),
+		QuotaScanProgress: QuotaScanProgress{progress: &vfs.ScanProgress{}},
 	})
 	return true
 }
@@ -317,6 +471,83 @@ func RemoveQuotaScan(username string) error {
 	return err
 }
 
+// GetFolderQuotaScans returns the active quota scans for the shared folders with their progress
+func GetFolderQuotaScans() []ActiveFolderQuotaScan {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	scans := make([]ActiveFolderQuotaScan, len(activeFolderQuotaScans))
+	copy(scans, activeFolderQuotaScans)
+	for idx := range scans {
+		scans[idx].update(scans[idx].StartTime, now)
+	}
+	return scans
+}
+
+// GetFolderQuotaScan returns the active quota scan, with its progress, for the given shared folder.
+// Returns false if the folder has no quota scan running
+func GetFolderQuotaScan(name string) (ActiveFolderQuotaScan, bool) {
+	for _, s := range GetFolderQuotaScans() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ActiveFolderQuotaScan{}, false
+}
+
+// GetFolderQuotaScanProgress returns the object to use to report the progress for the active
+// quota scan of the given shared folder, nil if the folder has no quota scan running
+func GetFolderQuotaScanProgress(name string) *vfs.ScanProgress {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, s := range activeFolderQuotaScans {
+		if s.Name == name {
+			return s.progress
+		}
+	}
+	return nil
+}
+
+// AddFolderQuotaScan add a shared folder to the ones with active quota scans.
+// Returns false if the folder has a quota scan already running
+func AddFolderQuotaScan(name string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, s := range activeFolderQuotaScans {
+		if s.Name == name {
+			return false
+		}
+	}
+	activeFolderQuotaScans = append(activeFolderQuotaScans, ActiveFolderQuotaScan{
+		Name:              name,
+		StartTime:         utils.GetTimeAsMsSinceEpoch(time.Now()),
+		QuotaScanProgress: QuotaScanProgress{progress: &vfs.ScanProgress{}},
+	})
+	return true
+}
+
+// RemoveFolderQuotaScan removes a shared folder from the ones with active quota scans
+func RemoveFolderQuotaScan(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var err error
+	indexToRemove := -1
+	for i, s := range activeFolderQuotaScans {
+		if s.Name == name {
+			indexToRemove = i
+			break
+		}
+	}
+	if indexToRemove >= 0 {
+		activeFolderQuotaScans[indexToRemove] = activeFolderQuotaScans[len(activeFolderQuotaScans)-1]
+		activeFolderQuotaScans = activeFolderQuotaScans[:len(activeFolderQuotaScans)-1]
+	} else {
+		logger.Warn(logSender, "", "quota scan to remove not found for folder: %v", name)
+		err = fmt.Errorf("quota scan to remove not found for folder: %v", name)
+	}
+	return err
+}
+
 // CloseActiveConnection closes an active SFTP connection.
 // It returns true on success
 func CloseActiveConnection(connectionID string) bool {
@@ -331,6 +562,113 @@ func CloseActiveConnection(connectionID string) bool {
 	return result
 }
 
+// StopServer stops accepting new connections and closes the active ones. The Initialize method
+// of the running configuration returns after the listeners are closed
+func StopServer() {
+	listenersMutex.Lock()
+	serverStopped = true
+	for _, l := range serverListeners {
+		l.Close()
+	}
+	serverListeners = nil
+	listenersMutex.Unlock()
+
+	var connectionIDs []string
+	mutex.RLock()
+	for id := range openConnections {
+		connectionIDs = append(connectionIDs, id)
+	}
+	mutex.RUnlock()
+	for _, id := range connectionIDs {
+		CloseActiveConnection(id)
+	}
+	logger.Debug(logSender, "", "server stopped, closed connections: %v", len(connectionIDs))
+}
+
+// CheckListeners returns an error if the server is not accepting connections: it is not started yet,
+// it failed to start or it is stopped
+func CheckListeners() error {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	if serverStopped || len(serverListeners) == 0 {
+		return errors.New("the SFTP server is not accepting connections")
+	}
+	return nil
+}
+
+func isServerStopped() bool {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	return serverStopped
+}
+
+// IsDisconnectOnUserChangeEnabled returns true if the active connections for a user
+// must be closed, by default, when the user is updated or deleted
+func IsDisconnectOnUserChangeEnabled() bool {
+	return disconnectOnUserChange
+}
+
+// CloseUserConnections closes the active connections for the given username.
+// Connections without in-flight transfers are closed immediately, the other ones
+// are closed as soon as their transfers end or when the configured grace period expires.
+// New transfers are denied on the connections waiting to be closed.
+// Returns the number of connections that will be closed
+func CloseUserConnections(username string) int {
+	var connections []Connection
+	mutex.Lock()
+	for _, c := range openConnections {
+		if c.User.Username == username {
+			connections = append(connections, c)
+			closingConnections[c.ID] = true
+		}
+	}
+	mutex.Unlock()
+	if len(connections) > 0 {
+		go closeConnectionsAfterTransfers(connections, time.Now().Add(disconnectGracePeriod))
+	}
+	return len(connections)
+}
+
+func closeConnectionsAfterTransfers(connections []Connection, deadline time.Time) {
+	for {
+		var pending []Connection
+		for _, c := range connections {
+			if time.Now().Before(deadline) && hasActiveTransfers(c.ID) {
+				pending = append(pending, c)
+				continue
+			}
+			err := c.close()
+			c.Log(logger.LevelInfo, logSender, "connection closed after user update/delete, close error: %v", err)
+		}
+		if len(pending) == 0 {
+			return
+		}
+		connections = pending
+		time.Sleep(disconnectCheckInterval)
+	}
+}
+
+// isConnectionClosing returns true if the connection with the given ID will be closed
+// after its in-flight transfers
+func isConnectionClosing(connectionID string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return closingConnections[connectionID]
+}
+
+func hasActiveTransfers(connectionID string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, t := range activeTransfers {
+		if t.connectionID == connectionID {
+			return true
+		}
+	}
+	return false
+}
+
 // GetConnectionsStats returns stats for active connections
 func GetConnectionsStats() []ConnectionStatus {
 	mutex.RLock()
@@ -347,6 +685,9 @@ func GetConnectionsStats() []ConnectionStatus {
 			Protocol:       c.protocol,
 			Transfers:      []connectionTransfer{},
 			SSHCommand:     c.command,
+			ClientSoftware: getClientSoftware(c.ClientVersion),
+			Tenant:         c.User.Filters.Tenant,
+			Label:          c.label,
 		}
 		for _, t := range activeTransfers {
 			if t.connectionID == c.ID {
@@ -368,6 +709,7 @@ func GetConnectionsStats() []ConnectionStatus {
 					Size:          size,
 					LastActivity:  utils.GetTimeAsMsSinceEpoch(t.lastActivity),
 					Path:          c.fs.GetRelativePath(t.path),
+					Degraded:      t.speedState.degraded,
 				}
 				conn.Transfers = append(conn.Transfers, connTransfer)
 			}
@@ -421,6 +763,7 @@ func removeConnection(c Connection) {
 	mutex.Lock()
 	defer mutex.Unlock()
 	delete(openConnections, c.ID)
+	delete(closingConnections, c.ID)
 	metrics.UpdateActiveConnectionsSize(len(openConnections))
 	// we have finished to send data here and most of the time the underlying network connection
 	// is already closed. Sometime a client can still be reading the last sended data, so we set
@@ -473,7 +816,7 @@ func isAtomicUploadEnabled() bool {
 	return uploadMode == uploadModeAtomic || uploadMode == uploadModeAtomicWithResume
 }
 
-func executeNotificationCommand(a actionNotification) error {
+func executeNotificationCommand(a ActionNotification) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, actions.Command, a.Action, a.Username, a.Path, a.TargetPath, a.SSHCmd)
@@ -485,8 +828,33 @@ func executeNotificationCommand(a actionNotification) error {
 	return err
 }
 
+// ActionHook is an in-process hook for the actions, see RegisterActionHook
+type ActionHook func(a ActionNotification)
+
+// RegisterActionHook registers a hook executed, inside the SFTPGo process, for each action.
+// The hooks are executed for all the actions, even if they are not included in execute_on,
+// so they can be used without configuring a command or an HTTP notification URL.
+// A hook must not block, the actions are notified in the order they are registered
+func RegisterActionHook(hook ActionHook) {
+	actionHooksMutex.Lock()
+	defer actionHooksMutex.Unlock()
+
+	actionHooks = append(actionHooks, hook)
+}
+
+func executeActionHooks(a ActionNotification) {
+	actionHooksMutex.RLock()
+	hooks := actionHooks
+	actionHooksMutex.RUnlock()
+
+	for _, hook := range hooks {
+		hook(a)
+	}
+}
+
 // executed in a goroutine
-func executeAction(a actionNotification) error {
+func executeAction(a ActionNotification) error {
+	executeActionHooks(a)
 	if !utils.IsStringInSlice(a.Action, actions.ExecuteOn) {
 		return nil
 	}
@@ -521,3 +889,37 @@ func executeAction(a actionNotification) error {
 	}
 	return err
 }
+
+// TestAction executes the configured actions using a synthetic event of the given type.
+// The hooks are executed synchronously and even if the event is not included in execute_on
+func TestAction(action string, user dataprovider.User) ([]dataprovider.HookTestResult, error) {
+	if !utils.IsStringInSlice(action, []string{operationDownload, operationUpload, operationDelete, operationRename,
+		operationSSHCmd, operationSSHExec, operationSlowTransfer}) {
+		return nil, fmt.Errorf("invalid action %#v", action)
+	}
+	filePath := filepath.Join(user.HomeDir, "sftpgo_hook_test.dat")
+	target := ""
+	sshCmd := ""
+	fileSize := int64(0)
+	switch action {
+	case operationRename:
+		target = filepath.Join(user.HomeDir, "sftpgo_hook_test_renamed.dat")
+	case operationSSHCmd:
+		sshCmd = "md5sum"
+	case operationSSHExec:
+		filePath = ""
+		sshCmd = "scp -t /sftpgo_hook_test.dat"
+	default:
+		fileSize = 65535
+	}
+	a := newActionNotification(user, action, filePath, target, sshCmd, fileSize, nil)
+	results := []dataprovider.HookTestResult{}
+	if len(actions.Command) > 0 {
+		results = append(results, dataprovider.ExecuteCommandHookTest(actions.Command, 30*time.Second,
+			[]string{a.Action, a.Username, a.Path, a.TargetPath, a.SSHCmd}, a.AsEnvVars()))
+	}
+	if len(actions.HTTPNotificationURL) > 0 {
+		results = append(results, dataprovider.ExecuteHTTPHookTest(actions.HTTPNotificationURL, a.AsJSON()))
+	}
+	return results, nil
+}
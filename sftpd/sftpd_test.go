@@ -1,33 +1,47 @@
 package sftpd_test
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"io/ioutil"
 	"math"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
 
 	"golang.org/x/crypto/ssh"
 
@@ -38,6 +52,8 @@ import (
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
 	"github.com/pkg/sftp"
 	"github.com/rs/zerolog"
 )
@@ -46,6 +62,7 @@ const (
 	logSender       = "sftpdTesting"
 	sftpServerAddr  = "127.0.0.1:2022"
 	defaultUsername = "test_user_sftp"
+	ldapTestBaseDN  = "ou=people,dc=example,dc=com"
 	defaultPassword = "test_password"
 	testPubKey      = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQC03jj0D+djk7pxIf/0OhrxrchJTRZklofJ1NoIu4752Sq02mdXmarMVsqJ1cAjV5LBVy3D1F5U6XW4rppkXeVtd04Pxb09ehtH0pRRPaoHHlALiJt8CoMpbKYMA8b3KXPPriGxgGomvtU2T2RMURSwOZbMtpsugfjYSWenyYX+VORYhylWnSXL961LTyC21ehd6d6QnW9G7E5hYMITMY9TuQZz3bROYzXiTsgN0+g6Hn7exFQp50p45StUMfV/SftCMdCxlxuyGny2CrN/vfjO7xxOo2uv7q1qm10Q46KPWJQv+pgZ/OfL+EDjy07n5QVSKHlbx+2nT4Q0EgOSQaCTYwn3YjtABfIxWwgAFdyj6YlPulCL22qU4MYhDcA6PSBwDdf8hvxBfvsiHdM+JcSHvv8/VeJhk6CmnZxGY0fxBupov27z3yEO8nAg8k+6PaUiW1MSUfuGMF/ktB8LOstXsEPXSszuyXiOv4DaryOXUiSn7bmRqKcEFlJusO6aZP0= nicola@p1"
 	testPubKey1     = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQCd60+/j+y8f0tLftihWV1YN9RSahMI9btQMDIMqts/jeNbD8jgoogM3nhF7KxfcaMKURuD47KC4Ey6iAJUJ0sWkSNNxOcIYuvA+5MlspfZDsa8Ag76Fe1vyz72WeHMHMeh/hwFo2TeIeIXg480T1VI6mzfDrVp2GzUx0SS0dMsQBjftXkuVR8YOiOwMCAH2a//M1OrvV7d/NBk6kBN0WnuIBb2jKm15PAA7+jQQG7tzwk2HedNH3jeL5GH31xkSRwlBczRK0xsCQXehAlx6cT/e/s44iJcJTHfpPKoSk6UAhPJYe7Z1QnuoawY9P9jQaxpyeImBZxxUEowhjpj2avBxKdRGBVK8R7EL8tSOeLbhdyWe5Mwc1+foEbq9Zz5j5Kd+hn3Wm1UnsGCrXUUUoZp1jnlNl0NakCto+5KmqnT9cHxaY+ix2RLUWAZyVFlRq71OYux1UHJnEJPiEI1/tr4jFBSL46qhQZv/TfpkfVW8FLz0lErfqu0gQEZnNHr3Fc= nicola@p1"
@@ -106,18 +123,32 @@ var (
 )
 
 func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+// runTests starts the test servers and runs the tests, the deferred cleanups run before
+// returning the exit code
+func runTests(m *testing.M) int {
 	logFilePath = filepath.Join(configDir, "sftpgo_sftpd_test.log")
-	loginBannerFileName := "login_banner"
-	loginBannerFile := filepath.Join(configDir, loginBannerFileName)
+	// the files referenced by the configuration are written outside the config dir
+	testFilesDir, err := ioutil.TempDir("", "sftpd_test")
+	if err != nil {
+		logger.WarnToConsole("unable to create the test files dir: %v", err)
+		return 1
+	}
+	defer os.RemoveAll(testFilesDir)
+	loginBannerFile := filepath.Join(testFilesDir, "login_banner")
 	ioutil.WriteFile(loginBannerFile, []byte("simple login banner\n"), 0777)
+	termsOfUseFile := filepath.Join(testFilesDir, "terms_of_use")
+	ioutil.WriteFile(termsOfUseFile, []byte("test terms of use\n"), 0777)
 	logger.InitLogger(logFilePath, 5, 1, 28, false, zerolog.DebugLevel)
 	config.LoadConfig(configDir, "")
 	providerConf := config.GetProviderConf()
 
-	err := dataprovider.Initialize(providerConf, configDir)
+	err = dataprovider.Initialize(providerConf, configDir)
 	if err != nil {
 		logger.Warn(logSender, "", "error initializing data provider: %v", err)
-		os.Exit(1)
+		return 1
 	}
 
 	httpConfig := config.GetHTTPConfig()
@@ -132,7 +163,11 @@ func TestMain(m *testing.M) {
 	sftpdConf.Ciphers = []string{"chacha20-poly1305@openssh.com", "aes128-gcm@openssh.com",
 		"aes256-ctr"}
 	sftpdConf.MACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"}
-	sftpdConf.LoginBannerFile = loginBannerFileName
+	sftpdConf.LoginBannerFile = loginBannerFile
+	sftpdConf.FirstLogin = sftpd.FirstLoginConfig{
+		Enabled:        true,
+		TermsOfUseFile: termsOfUseFile,
+	}
 	// we need to test all supported ssh commands
 	sftpdConf.EnabledSSHCommands = []string{"*"}
 	// we run the test cases with UploadMode atomic and resume support. The non atomic code path
@@ -140,6 +175,10 @@ func TestMain(m *testing.M) {
 	// work in non atomic mode too
 	sftpdConf.UploadMode = 2
 	homeBasePath = os.TempDir()
+	sftpdConf.Receipts = sftpd.ReceiptsConfig{
+		ExecuteOn: []string{"upload", "download"},
+		Path:      filepath.Join(homeBasePath, "receipts"),
+	}
 	var scriptArgs string
 	if runtime.GOOS == "windows" {
 		scriptArgs = "%*"
@@ -233,16 +272,34 @@ func TestMain(m *testing.M) {
 
 	waitTCPListening(fmt.Sprintf("%s:%d", sftpdConf.BindAddress, sftpdConf.BindPort))
 
+	sftpdConf.BindPort = 2226
+	sftpdConf.ProxyProtocol = 0
+	sftpdConf.Bindings = []sftpd.Binding{
+		{
+			Address:            sftpdConf.BindAddress,
+			Port:               2227,
+			DeniedLoginMethods: []string{dataprovider.SSHLoginMethodPassword},
+		},
+	}
+	go func() {
+		logger.Debug(logSender, "", "initializing SFTP server with config %+v", sftpdConf)
+		if err := sftpdConf.Initialize(configDir); err != nil {
+			logger.Error(logSender, "", "could not start SFTP server: %v", err)
+		}
+	}()
+
+	waitTCPListening(fmt.Sprintf("%s:%d", sftpdConf.BindAddress, sftpdConf.BindPort))
+	waitTCPListening(sftpdConf.Bindings[0].GetAddress())
+
 	exitCode := m.Run()
 	os.Remove(logFilePath)
-	os.Remove(loginBannerFile)
 	os.Remove(pubKeyPath)
 	os.Remove(privateKeyPath)
 	os.Remove(gitWrapPath)
 	os.Remove(extAuthPath)
 	os.Remove(preLoginPath)
 	os.Remove(keyIntAuthPath)
-	os.Exit(exitCode)
+	return exitCode
 }
 
 func TestInitialization(t *testing.T) {
@@ -273,6 +330,47 @@ func TestInitialization(t *testing.T) {
 	if err == nil {
 		t.Error("Inizialize must fail, proxy IP allowed is invalid")
 	}
+	sftpdConf.ProxyProtocol = 0
+	sftpdConf.Bindings = []sftpd.Binding{
+		{
+			Port: 4444,
+		},
+	}
+	err = sftpdConf.Initialize(configDir)
+	if err == nil {
+		t.Error("Inizialize must fail, binding port is duplicated")
+	}
+	sftpdConf.Bindings[0].Port = 0
+	err = sftpdConf.Initialize(configDir)
+	if err == nil {
+		t.Error("Inizialize must fail, binding port is invalid")
+	}
+	sftpdConf.Bindings[0].Port = 4445
+	sftpdConf.Bindings[0].DeniedLoginMethods = []string{"invalid"}
+	err = sftpdConf.Initialize(configDir)
+	if err == nil {
+		t.Error("Inizialize must fail, binding login method is invalid")
+	}
+	sftpdConf.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive}
+	sftpdConf.Bindings[0].DeniedLoginMethods = dataprovider.SSHMultiStepsLoginMethods
+	err = sftpdConf.Initialize(configDir)
+	if err == nil {
+		t.Error("Inizialize must fail, all the login methods are denied for the binding")
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	if err := sftpd.CheckListeners(); err != nil {
+		t.Errorf("the SFTP server must be accepting connections: %v", err)
+	}
+	response, _, err := httpd.GetHealthStatus(http.StatusOK)
+	if err != nil {
+		t.Errorf("unexpected health status: %v", err)
+	}
+	if response["message"] != "OK" {
+		t.Errorf("unexpected health response: %+v", response)
+	}
 }
 
 func TestBasicSFTPHandling(t *testing.T) {
@@ -372,6 +470,46 @@ func TestProxyProtocol(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
+func TestBindingLoginPolicy(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClientWithAddr(user, usePubKey, "127.0.0.1:2226")
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	_, err = getSftpClientWithAddr(user, usePubKey, "127.0.0.1:2227")
+	if err == nil {
+		t.Error("password login must be denied for this binding")
+	}
+	httpd.RemoveUser(user, http.StatusOK)
+	usePubKey = true
+	user, _, err = httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err = getSftpClientWithAddr(user, usePubKey, "127.0.0.1:2227")
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	httpd.RemoveUser(user, http.StatusOK)
+	os.RemoveAll(user.GetHomeDir())
+}
+
 func TestUploadResume(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -674,7 +812,7 @@ func TestStat(t *testing.T) {
 		}
 		err = client.Truncate(testFileName, 0)
 		if err != nil {
-			t.Errorf("truncate must be silently ignored: %v", err)
+			t.Errorf("truncate error: %v", err)
 		}
 		os.Remove(testFilePath)
 	}
@@ -1159,6 +1297,285 @@ func TestLoginUserStatus(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
+func TestLoginBlockedIP(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	// the REST API is used by the test client too, so the entry is managed using the data provider
+	dataProvider := dataprovider.GetProvider()
+	entry := dataprovider.IPListEntry{
+		IPOrNet: "127.0.0.0/8",
+		Type:    dataprovider.IPListTypeBlock,
+	}
+	err = dataprovider.AddIPListEntry(dataProvider, entry)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	entry, err = dataprovider.IPListEntryExists(dataProvider, entry.IPOrNet)
+	if err != nil {
+		t.Errorf("unable to get IP list entry: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("login from a blocked IP must fail")
+		defer client.Close()
+	}
+	safeEntry := dataprovider.IPListEntry{
+		IPOrNet: "127.0.0.1",
+		Type:    dataprovider.IPListTypeSafe,
+	}
+	err = dataprovider.AddIPListEntry(dataProvider, safeEntry)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	safeEntry, err = dataprovider.IPListEntryExists(dataProvider, safeEntry.IPOrNet)
+	if err != nil {
+		t.Errorf("unable to get IP list entry: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("login from an IP in the safe list must succeed: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("sftp client with valid credentials must work")
+		}
+	}
+	err = dataprovider.DeleteIPListEntry(dataProvider, safeEntry)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	err = dataprovider.DeleteIPListEntry(dataProvider, entry)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestLoginSimulation(t *testing.T) {
+	u := getTestUser(true)
+	u.Password = defaultPassword
+	u.Filters.DeniedIP = []string{"10.8.0.0/16"}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	checkResult := func(req sftpd.LoginSimulationRequest, loginMethod string, allowed bool, rule string) {
+		result, _, err := httpd.SimulateLogin(req, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to simulate login: %v", err)
+			return
+		}
+		if result.LoginMethod != loginMethod || result.Allowed != allowed || result.Rule != rule {
+			t.Errorf("unexpected login simulation result: %+v, request: %+v", result, req)
+		}
+	}
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword},
+		dataprovider.SSHLoginMethodPassword, true, "")
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: "wrong"},
+		dataprovider.SSHLoginMethodPassword, false, sftpd.LoginRuleCredentials)
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword, Port: 2226},
+		dataprovider.SSHLoginMethodPassword, true, "")
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword, Port: 2227},
+		dataprovider.SSHLoginMethodPassword, false, sftpd.LoginRuleBindingLoginMethod)
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey, Port: 2227},
+		dataprovider.SSHLoginMethodPublicKey, true, "")
+	_, _, err = httpd.SimulateLogin(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword,
+		Port: 2228}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("simulating a login for a missing binding must fail: %v", err)
+	}
+	checkResult(sftpd.LoginSimulationRequest{Username: "missing user", Password: defaultPassword},
+		dataprovider.SSHLoginMethodPassword, false, sftpd.LoginRuleCredentials)
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey},
+		dataprovider.SSHLoginMethodPublicKey, true, "")
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey1},
+		dataprovider.SSHLoginMethodPublicKey, false, sftpd.LoginRuleCredentials)
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword, IP: "10.8.1.1"},
+		dataprovider.SSHLoginMethodPassword, false, sftpd.LoginRuleUserIPFilter)
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword, IP: "10.9.1.1"},
+		dataprovider.SSHLoginMethodPassword, true, "")
+	// the simulation must not change the last login
+	result, _, err := httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if result.LastLogin != 0 {
+		t.Errorf("a simulated login must not update the last login")
+	}
+	dataProvider := dataprovider.GetProvider()
+	entry := dataprovider.IPListEntry{
+		IPOrNet: "10.9.1.1",
+		Type:    dataprovider.IPListTypeBlock,
+	}
+	err = dataprovider.AddIPListEntry(dataProvider, entry)
+	if err != nil {
+		t.Errorf("unable to add IP list entry: %v", err)
+	}
+	entry, err = dataprovider.IPListEntryExists(dataProvider, entry.IPOrNet)
+	if err != nil {
+		t.Errorf("unable to get IP list entry: %v", err)
+	}
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword, IP: "10.9.1.1"},
+		"", false, sftpd.LoginRuleBlockedIP)
+	err = dataprovider.DeleteIPListEntry(dataProvider, entry)
+	if err != nil {
+		t.Errorf("unable to remove IP list entry: %v", err)
+	}
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPassword}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, Password: defaultPassword},
+		dataprovider.SSHLoginMethodPassword, false, sftpd.LoginRuleLoginMethod)
+	// only multi-step authentication is allowed
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPublicKey, dataprovider.SSHLoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey},
+		dataprovider.SSHLoginMethodPublicKey, false, sftpd.LoginRuleSecondStep)
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey, Password: defaultPassword},
+		dataprovider.SSHLoginMethodKeyAndPassword, true, "")
+	checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey, Password: "wrong"},
+		dataprovider.SSHLoginMethodKeyAndPassword, false, sftpd.LoginRuleCredentials)
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodKeyboardInteractive}
+	user.MaxSessions = 1
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	client, err := getSftpClient(user, true)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		checkResult(sftpd.LoginSimulationRequest{Username: defaultUsername, PublicKey: testPubKey},
+			dataprovider.SSHLoginMethodPublicKey, false, sftpd.LoginRuleMaxSessions)
+	}
+	for _, req := range []sftpd.LoginSimulationRequest{
+		{Username: defaultUsername},
+		{Password: defaultPassword},
+		{Username: defaultUsername, PublicKey: "invalid key"},
+		{Username: defaultUsername, Password: defaultPassword, IP: "not an IP"},
+	} {
+		_, _, err = httpd.SimulateLogin(req, http.StatusBadRequest)
+		if err != nil {
+			t.Errorf("unexpected status for invalid login simulation request %+v: %v", req, err)
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestLoginRevokedKey(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPubKey))
+	if err != nil {
+		t.Errorf("unable to parse public key: %v", err)
+	}
+	u.Filters.RevokedKeyFingerprints = []string{ssh.FingerprintSHA256(pubKey)}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("login with a revoked public key must fail")
+		defer client.Close()
+	}
+	// revoke a different key
+	pubKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(testPubKey1))
+	if err != nil {
+		t.Errorf("unable to parse public key: %v", err)
+	}
+	user.Filters.RevokedKeyFingerprints = []string{ssh.FingerprintSHA256(pubKey)}
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestLoginKeyRestrictions(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.AllowedKeyAlgorithms = []string{ssh.KeyAlgoED25519}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("login with a not allowed key algorithm must fail")
+		defer client.Close()
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPubKey))
+	if err != nil {
+		t.Errorf("unable to parse public key: %v", err)
+	}
+	keySize := pubKey.(ssh.CryptoPublicKey).CryptoPublicKey().(*rsa.PublicKey).N.BitLen()
+	user.Filters.AllowedKeyAlgorithms = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSA}
+	user.Filters.MinRSAKeySize = keySize + 1
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("login with an RSA key smaller than the minimum allowed size must fail")
+		defer client.Close()
+	}
+	user.Filters.MinRSAKeySize = keySize
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
 func TestLoginUserExpiration(t *testing.T) {
 	usePubKey := true
 	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
@@ -1479,30 +1896,168 @@ func TestLoginKeyboardInteractiveAuth(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
-func TestPreLoginScript(t *testing.T) {
+func TestFirstLoginActions(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("this test is not available on Windows")
 	}
-	usePubKey := true
-	u := getTestUser(usePubKey)
-	dataProvider := dataprovider.GetProvider()
-	dataprovider.Close(dataProvider)
-	config.LoadConfig(configDir, "")
-	providerConf := config.GetProviderConf()
-	ioutil.WriteFile(preLoginPath, getPreLoginScriptContent(u, false), 0755)
-	providerConf.PreLoginHook = preLoginPath
-	err := dataprovider.Initialize(providerConf, configDir)
-	if err != nil {
-		t.Errorf("error initializing data provider")
-	}
-	httpd.SetDataProvider(dataprovider.GetProvider())
-	sftpd.SetDataProvider(dataprovider.GetProvider())
-
+	newPassword := "new_test_password"
+	u := getTestUser(false)
+	u.Filters.FirstLogin.AcceptTerms = true
+	u.Filters.FirstLogin.ChangePassword = true
 	user, _, err := httpd.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
-	client, err := getSftpClient(u, usePubKey)
+	_, err = getSftpClient(user, false)
+	if err == nil {
+		t.Error("password login must fail, the first login actions are pending")
+	}
+	getAnswers := func(acceptTerms string, passwords ...string) func(string, string, []string, []bool) ([]string, error) {
+		return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			if len(questions) == 1 && questions[0] == "Password: " {
+				return []string{defaultPassword}, nil
+			}
+			if len(questions) == 1 {
+				if !strings.Contains(instruction, "test terms of use") {
+					return nil, fmt.Errorf("unexpected instruction: %#v", instruction)
+				}
+				return []string{acceptTerms}, nil
+			}
+			return passwords, nil
+		}
+	}
+	authMethods := []ssh.AuthMethod{
+		ssh.KeyboardInteractive(getAnswers("no", newPassword, newPassword)),
+	}
+	_, err = getCustomAuthSftpClient(user, authMethods)
+	if err == nil {
+		t.Error("keyboard interactive login must fail, the terms of use are not accepted")
+	}
+	authMethods = []ssh.AuthMethod{
+		ssh.KeyboardInteractive(getAnswers("yes", newPassword, "mismatch")),
+	}
+	_, err = getCustomAuthSftpClient(user, authMethods)
+	if err == nil {
+		t.Error("keyboard interactive login must fail, the new passwords do not match")
+	}
+	authMethods = []ssh.AuthMethod{
+		ssh.KeyboardInteractive(getAnswers("yes", defaultPassword, defaultPassword)),
+	}
+	_, err = getCustomAuthSftpClient(user, authMethods)
+	if err == nil {
+		t.Error("keyboard interactive login must fail, the new password must be different")
+	}
+	authMethods = []ssh.AuthMethod{
+		ssh.KeyboardInteractive(getAnswers("yes", newPassword, newPassword)),
+	}
+	client, err := getCustomAuthSftpClient(user, authMethods)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.ReadDir(".")
+		if err != nil {
+			t.Errorf("unable to read remote dir: %v", err)
+		}
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.Filters.FirstLogin.TermsAcceptedAt == 0 || user.Filters.FirstLogin.PasswordChangedAt == 0 {
+		t.Errorf("the first login actions must be completed: %+v", user.Filters.FirstLogin)
+	}
+	user.Password = newPassword
+	client, err = getSftpClient(user, false)
+	if err != nil {
+		t.Errorf("unable to login with the new password: %v", err)
+	} else {
+		defer client.Close()
+	}
+	user.Password = defaultPassword
+	_, err = getSftpClient(user, false)
+	if err == nil {
+		t.Error("login with the initial password must fail")
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestFirstLoginActionsWithPublicKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	u := getTestUser(true)
+	u.Filters.FirstLogin.AcceptTerms = true
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, err = getSftpClient(user, true)
+	if err == nil {
+		t.Error("public key login must fail, the first login actions are pending")
+	}
+	key, _ := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	authMethods := []ssh.AuthMethod{
+		ssh.PublicKeys(key),
+		ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			// the password is not required after a public key authentication
+			if len(questions) != 1 || !strings.Contains(instruction, "test terms of use") {
+				return nil, fmt.Errorf("unexpected questions: %v", questions)
+			}
+			return []string{"yes"}, nil
+		}),
+	}
+	client, err := getCustomAuthSftpClient(user, authMethods)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	client, err = getSftpClient(user, true)
+	if err != nil {
+		t.Errorf("public key login must succeed after accepting the terms of use: %v", err)
+	} else {
+		defer client.Close()
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestPreLoginScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	ioutil.WriteFile(preLoginPath, getPreLoginScriptContent(u, false), 0755)
+	providerConf.PreLoginHook = preLoginPath
+	err := dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(u, usePubKey)
 	if err != nil {
 		t.Errorf("unable to create sftp client: %v", err)
 	} else {
@@ -1599,6 +2154,187 @@ func TestPreLoginUserCreation(t *testing.T) {
 	os.Remove(preLoginPath)
 }
 
+func TestLDAPLogin(t *testing.T) {
+	ldapServer, err := startLDAPTestServer(map[string]string{defaultUsername: defaultPassword})
+	if err != nil {
+		t.Fatalf("unable to start the LDAP test server: %v", err)
+	}
+	defer ldapServer.Close()
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.UsersBaseDir = homeBasePath
+	providerConf.LDAP.URL = "ldap://" + ldapServer.listener.Addr().String()
+	providerConf.LDAP.BaseDN = ldapTestBaseDN
+	providerConf.LDAP.SearchFilter = "(uid)"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("LDAP search filter without placeholder must fail")
+	}
+	providerConf.LDAP.SearchFilter = "(&(objectClass=posixAccount)(uid=%s))"
+	providerConf.LDAP.DefaultPermissions = []string{"invalid"}
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("invalid LDAP default permissions must fail")
+	}
+	providerConf.LDAP.DefaultPermissions = allPerms
+	providerConf.PreLoginHook = preLoginPath
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err == nil {
+		t.Error("LDAP and pre-login hook are mutually exclusive")
+	}
+	providerConf.PreLoginHook = ""
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+
+	u := getTestUser(false)
+	// the user does not exist in the data provider, it will be added at the first login
+	client, err := getSftpClient(u, false)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	users, _, err := httpd.GetUsers(0, 0, defaultUsername, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("the user must be added after the first LDAP login")
+	}
+	user := users[0]
+	if user.HomeDir != filepath.Join(homeBasePath, defaultUsername) {
+		t.Errorf("unexpected home dir: %#v", user.HomeDir)
+	}
+	u.Password = "wrong password"
+	_, err = getSftpClient(u, false)
+	if err == nil {
+		t.Error("login with a wrong password must fail")
+	}
+	// local settings are preserved and the local password is ignored
+	user.Password = "local password"
+	user.PublicKeys = []string{testPubKey}
+	user.QuotaFiles = 100
+	_, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	u.Password = defaultPassword
+	client, err = getSftpClient(u, false)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	u.Password = user.Password
+	_, err = getSftpClient(u, false)
+	if err == nil {
+		t.Error("login with the local password must fail")
+	}
+	client, err = getSftpClient(u, true)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if user.QuotaFiles != 100 {
+		t.Errorf("local settings must not be overwritten, quota files: %v", user.QuotaFiles)
+	}
+	// the account is removed from LDAP, the login must fail for all the authentication methods
+	ldapServer.removeUser(defaultUsername)
+	_, err = getSftpClient(u, true)
+	if err == nil {
+		t.Error("public key login must fail if the user does not exist in LDAP")
+	}
+	u.Password = defaultPassword
+	_, err = getSftpClient(u, false)
+	if err == nil {
+		t.Error("password login must fail if the user does not exist in LDAP")
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+}
+
+func TestLDAPServerUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	ldapURL := "ldap://" + listener.Addr().String()
+	listener.Close()
+	usePubKey := true
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	dataProvider := dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf := config.GetProviderConf()
+	providerConf.LDAP.URL = ldapURL
+	providerConf.LDAP.BaseDN = ldapTestBaseDN
+	providerConf.LDAP.SearchFilter = "(uid=%s)"
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider: %v", err)
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	_, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Error("login must fail if the LDAP server is not available")
+	}
+	dataProvider = dataprovider.GetProvider()
+	dataprovider.Close(dataProvider)
+	config.LoadConfig(configDir, "")
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir)
+	if err != nil {
+		t.Errorf("error initializing data provider")
+	}
+	httpd.SetDataProvider(dataprovider.GetProvider())
+	sftpd.SetDataProvider(dataprovider.GetProvider())
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
 func TestLoginExternalAuthPwdAndPubKey(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("this test is not available on Windows")
@@ -2035,26 +2771,172 @@ func TestMaxSessions(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
-func TestQuotaFileReplace(t *testing.T) {
+func TestConnectionTags(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
-	u.QuotaFiles = 1000
+	u.Filters.Tenant = "ACME"
 	user, _, err := httpd.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
-	os.RemoveAll(user.GetHomeDir())
-	testFileSize := int64(65535)
-	testFileName := "test_file.dat"
-	testFilePath := filepath.Join(homeBasePath, testFileName)
 	client, err := getSftpClient(user, usePubKey)
 	if err != nil {
 		t.Errorf("unable to create sftp client: %v", err)
 	} else {
 		defer client.Close()
-		expectedQuotaSize := user.UsedQuotaSize + testFileSize
-		expectedQuotaFiles := user.UsedQuotaFiles + 1
-		err = createTestFile(testFilePath, testFileSize)
+		_, err = client.ReadDir(".")
+		if err != nil {
+			t.Errorf("unable to read remote dir: %v", err)
+		}
+		stats, _, err := httpd.GetFilteredConnections(sftpd.ConnectionTagsFilter{
+			ClientSoftware: "go",
+			Tenant:         "acme",
+		}, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get connections: %v", err)
+		}
+		if len(stats) != 1 {
+			t.Fatalf("unexpected connections: %+v", stats)
+		}
+		if stats[0].ClientSoftware != "Go" || stats[0].Tenant != "ACME" || len(stats[0].Label) > 0 {
+			t.Errorf("unexpected connection tags: %+v", stats[0])
+		}
+		_, err = httpd.UpdateConnectionLabel(stats[0].ConnectionID, " incident 42 ", http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to set the connection label: %v", err)
+		}
+		stats, _, err = httpd.GetFilteredConnections(sftpd.ConnectionTagsFilter{Label: "Incident 42"}, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get connections: %v", err)
+		}
+		if len(stats) != 1 || stats[0].Label != "incident 42" {
+			t.Errorf("unexpected connections: %+v", stats)
+		}
+		stats, _, err = httpd.GetFilteredConnections(sftpd.ConnectionTagsFilter{ClientSoftware: "WinSCP"}, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get connections: %v", err)
+		}
+		if len(stats) != 0 {
+			t.Errorf("unexpected connections: %+v", stats)
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestDisconnectOnUserUpdate(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.ReadDir(".")
+		if err != nil {
+			t.Errorf("unable to read remote dir: %v", err)
+		}
+		user.MaxSessions = 2
+		_, _, err = httpd.UpdateUserWithParams(user, http.StatusOK, "0")
+		if err != nil {
+			t.Errorf("unable to update user: %v", err)
+		}
+		if len(sftpd.GetConnectionsStats()) != 1 {
+			t.Errorf("the connection must not be closed")
+		}
+		user.MaxSessions = 0
+		_, _, err = httpd.UpdateUserWithParams(user, http.StatusOK, "1")
+		if err != nil {
+			t.Errorf("unable to update user: %v", err)
+		}
+		waitForNoActiveTransfer()
+		_, err = client.ReadDir(".")
+		if err == nil {
+			t.Errorf("read dir must fail, the connection was closed after the user update")
+		}
+	}
+	_, _, err = httpd.UpdateUserWithParams(user, http.StatusBadRequest, "2")
+	if err != nil {
+		t.Errorf("unexpected update user result: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestCloseUserConnections(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client1, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client1.Close()
+		client2, err := getSftpClient(user, usePubKey)
+		if err != nil {
+			t.Errorf("unable to create sftp client: %v", err)
+		} else {
+			defer client2.Close()
+			if len(sftpd.GetConnectionsStats()) != 2 {
+				t.Errorf("unexpected number of connections: %v", len(sftpd.GetConnectionsStats()))
+			}
+			_, err = httpd.CloseUserConnections(user.Username, http.StatusOK)
+			if err != nil {
+				t.Errorf("unable to close the user connections: %v", err)
+			}
+			waitForNoActiveTransfer()
+			_, err = client1.ReadDir(".")
+			if err == nil {
+				t.Errorf("read dir must fail, the connection was closed")
+			}
+			_, err = client2.ReadDir(".")
+			if err == nil {
+				t.Errorf("read dir must fail, the connection was closed")
+			}
+			_, err = httpd.CloseUserConnections(user.Username, http.StatusNotFound)
+			if err != nil {
+				t.Errorf("unexpected error closing the connections for a user without connections: %v", err)
+			}
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestQuotaFileReplace(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 1000
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	testFileSize := int64(65535)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		expectedQuotaSize := user.UsedQuotaSize + testFileSize
+		expectedQuotaFiles := user.UsedQuotaFiles + 1
+		err = createTestFile(testFilePath, testFileSize)
 		if err != nil {
 			t.Errorf("unable to create test file: %v", err)
 		}
@@ -2109,20 +2991,1246 @@ func TestQuotaFileReplace(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
-func TestQuotaScan(t *testing.T) {
+func TestIngestionFolder(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 1000
+	u.Filters.IngestionFolders = []dataprovider.IngestionFolder{
+		{
+			Path:         "/logs",
+			HourlyRollup: true,
+		},
+	}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	testFileSize := int64(1024)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = client.MkdirAll(path.Join("logs", "sub"))
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			err = sftpUploadFile(testFilePath, path.Join("logs", fmt.Sprintf("log%v.txt", i)), testFileSize, client)
+			if err != nil {
+				t.Errorf("file upload error: %v", err)
+			}
+		}
+		// files inside sub directories are batched but not rolled up
+		err = sftpUploadFile(testFilePath, path.Join("logs", "sub", "log.txt"), testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		sftpd.FlushIngestionBatches()
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 4 || user.UsedQuotaSize != 4*testFileSize {
+			t.Errorf("unexpected quota, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		// files uploaded within the current hour must not be rolled up
+		err = sftpd.RollUpIngestionFolders()
+		if err != nil {
+			t.Errorf("unable to roll up ingestion folders: %v", err)
+		}
+		files, err := client.ReadDir("logs")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 4 {
+			t.Errorf("unexpected files: %v", len(files))
+		}
+		modTime := time.Now().Add(-2 * time.Hour)
+		for i := 0; i < 3; i++ {
+			err = os.Chtimes(filepath.Join(user.GetHomeDir(), "logs", fmt.Sprintf("log%v.txt", i)), modTime, modTime)
+			if err != nil {
+				t.Errorf("unable to change mod time: %v", err)
+			}
+		}
+		err = sftpd.RollUpIngestionFolders()
+		if err != nil {
+			t.Errorf("unable to roll up ingestion folders: %v", err)
+		}
+		files, err = client.ReadDir("logs")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		var archiveName string
+		for _, info := range files {
+			if strings.HasPrefix(info.Name(), "rollup-") {
+				archiveName = info.Name()
+			}
+		}
+		if len(files) != 2 || archiveName != fmt.Sprintf("rollup-%v.tar.gz", modTime.UTC().Format("2006010215")) {
+			t.Errorf("unexpected files after roll up: %v, archive name: %#v", len(files), archiveName)
+		} else {
+			entries, err := getTarGzEntries(filepath.Join(user.GetHomeDir(), "logs", archiveName))
+			if err != nil {
+				t.Errorf("unable to read the roll up archive: %v", err)
+			}
+			if len(entries) != 3 {
+				t.Errorf("unexpected archive entries: %v", entries)
+			}
+			info, err := os.Stat(filepath.Join(user.GetHomeDir(), "logs", archiveName))
+			if err != nil {
+				t.Errorf("unable to stat the roll up archive: %v", err)
+			}
+			user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+			if err != nil {
+				t.Errorf("error getting user: %v", err)
+			}
+			if user.UsedQuotaFiles != 2 || user.UsedQuotaSize != testFileSize+info.Size() {
+				t.Errorf("unexpected quota after roll up, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+			}
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.Remove(testFilePath)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestCryptFs(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaSize = 6553600
+	u.FsConfig.Provider = 3
+	u.FsConfig.CryptConfig.Passphrase = "crypt passphrase"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		// two full chunks and a partial one
+		testFileSize := int64(131073)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		// the encrypted file is written asynchronously so we cannot check its size immediately
+		err = sftpUploadFile(testFilePath, testFileName, 0, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = waitForCryptUpload(client, testFileName, testFileSize)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		plainContent, _ := ioutil.ReadFile(testFilePath)
+		encryptedContent, err := ioutil.ReadFile(filepath.Join(user.GetHomeDir(), testFileName))
+		if err != nil {
+			t.Errorf("unable to read the encrypted file: %v", err)
+		}
+		if int64(len(encryptedContent)) <= testFileSize || bytes.Contains(encryptedContent, plainContent[:1024]) {
+			t.Error("the uploaded file is not encrypted")
+		}
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		initialHash, _ := computeHashForFile(sha256.New(), testFilePath)
+		downloadedFileHash, _ := computeHashForFile(sha256.New(), localDownloadPath)
+		if initialHash != downloadedFileHash {
+			t.Errorf("downloaded file hash does not match the uploaded one")
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected quota, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		// the chunks are decrypted on demand, so any offset can be read
+		remoteFile, err := client.Open(testFileName)
+		if err != nil {
+			t.Errorf("unable to open the encrypted file: %v", err)
+		} else {
+			buf := make([]byte, 100)
+			_, err = remoteFile.Seek(65500, io.SeekStart)
+			if err != nil {
+				t.Errorf("unable to seek: %v", err)
+			}
+			n, err := io.ReadFull(remoteFile, buf)
+			if err != nil || n != len(buf) || !bytes.Equal(buf, plainContent[65500:65600]) {
+				t.Errorf("unexpected data read at offset, n: %v, err: %v", n, err)
+			}
+			remoteFile.Close()
+		}
+		// the temporary files for the uploads in progress are not listed and not included in the quota
+		err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), ".sftpgo-crypt.stale."+testFileName), []byte("data"), 0666)
+		if err != nil {
+			t.Errorf("unable to create the temporary upload file: %v", err)
+		}
+		entries, err := client.ReadDir(".")
+		if err != nil || len(entries) != 1 || entries[0].Name() != testFileName {
+			t.Errorf("unexpected directory listing: %+v, err: %v", entries, err)
+		}
+		_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+		if err != nil {
+			t.Errorf("error starting quota scan: %v", err)
+		}
+		err = waitQuotaScans()
+		if err != nil {
+			t.Errorf("error waiting for active quota scans: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected quota after scan, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		err = client.Truncate(testFileName, 10)
+		if err == nil {
+			t.Error("truncating an encrypted file to a non zero size must fail")
+		}
+		// the KDF parameters are stored in the header: an excessive memory cost must be refused
+		corruptedContent := make([]byte, len(encryptedContent))
+		copy(corruptedContent, encryptedContent)
+		copy(corruptedContent[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+		err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), "corrupted.dat"), corruptedContent, 0666)
+		if err != nil {
+			t.Errorf("unable to write the corrupted file: %v", err)
+		}
+		err = sftpDownloadFile("corrupted.dat", localDownloadPath, testFileSize, client)
+		if err == nil {
+			t.Error("downloading a file with invalid KDF parameters must fail")
+		}
+		os.Remove(testFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestUserActivity(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	logins, uploads, downloads := getUserActivityTotals(t, user.Username)
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		newLogins, newUploads, newDownloads := getUserActivityTotals(t, user.Username)
+		if newLogins != logins+1 || newUploads != uploads+1 || newDownloads != downloads+1 {
+			t.Errorf("unexpected activity, logins: %v/%v, uploads: %v/%v, downloads: %v/%v", logins, newLogins,
+				uploads, newUploads, downloads, newDownloads)
+		}
+		os.Remove(testFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestTransferReceipts(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	// remove the receipts generated by the previous tests for the same username
+	os.RemoveAll(filepath.Join(homeBasePath, "receipts", user.Username))
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(131072)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		expectedHash, _ := computeHashForFile(sha256.New(), testFilePath)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		receipts, _, err := httpd.GetTransferReceipts(user.Username, 0, 0, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get transfer receipts: %v", err)
+		}
+		if len(receipts) != 2 {
+			t.Errorf("unexpected number of receipts: %v", len(receipts))
+		} else {
+			// newest first
+			if receipts[0].Operation != "download" || receipts[1].Operation != "upload" {
+				t.Errorf("unexpected receipts operations: %#v, %#v", receipts[0].Operation, receipts[1].Operation)
+			}
+			for _, receipt := range receipts {
+				if receipt.Username != user.Username || receipt.Protocol != "SFTP" || receipt.Size != testFileSize {
+					t.Errorf("unexpected receipt: %+v", receipt)
+				}
+				if receipt.Path != filepath.Join(user.GetHomeDir(), testFileName) {
+					t.Errorf("unexpected receipt path: %#v", receipt.Path)
+				}
+				if receipt.Checksum != expectedHash || receipt.ChecksumAlgo != "sha256" {
+					t.Errorf("unexpected receipt checksum: %#v, expected: %#v", receipt.Checksum, expectedHash)
+				}
+				if receipt.EndTime < receipt.StartTime {
+					t.Errorf("unexpected receipt times: %v, %v", receipt.StartTime, receipt.EndTime)
+				}
+				err = receipt.Verify()
+				if err != nil {
+					t.Errorf("unable to verify receipt signature: %v", err)
+				}
+				receipt.Size++
+				err = receipt.Verify()
+				if err == nil {
+					t.Error("a modified receipt must not be verified")
+				}
+			}
+			receipt, _, err := httpd.GetTransferReceipt(user.Username, receipts[1].ID, http.StatusOK)
+			if err != nil {
+				t.Errorf("unable to get transfer receipt: %v", err)
+			}
+			if receipt.ID != receipts[1].ID || receipt.Signature == nil || receipt.Verify() != nil {
+				t.Errorf("unexpected receipt: %+v", receipt)
+			}
+			receipts, _, err = httpd.GetTransferReceipts(user.Username, 1, 1, http.StatusOK)
+			if err != nil {
+				t.Errorf("unable to get transfer receipts: %v", err)
+			}
+			if len(receipts) != 1 || receipts[0].ID != receipt.ID {
+				t.Errorf("unexpected receipts: %+v", receipts)
+			}
+			_, _, err = httpd.GetTransferReceipt(user.Username, "invalid", http.StatusNotFound)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			_, _, err = httpd.GetTransferReceipt(user.Username, "bq0ecrq8f4v4gokcptvg", http.StatusNotFound)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		os.Remove(testFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// the receipts are kept after the user is deleted
+	receipts, _, err := httpd.GetTransferReceipts(user.Username, 0, 0, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get transfer receipts: %v", err)
+	}
+	if len(receipts) != 2 {
+		t.Errorf("unexpected number of receipts: %v", len(receipts))
+	}
+	os.RemoveAll(filepath.Join(homeBasePath, "receipts", user.Username))
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestWebDAVFs(t *testing.T) {
+	webDAVRoot := filepath.Join(homeBasePath, "webdav_root")
+	os.RemoveAll(webDAVRoot)
+	err := os.MkdirAll(webDAVRoot, 0777)
+	if err != nil {
+		t.Errorf("unable to create the WebDAV root dir: %v", err)
+	}
+	webDAVServer := startTestWebDAVServer(webDAVRoot, "/dav", "webdav_user", "webdav_password")
+	defer webDAVServer.Close()
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.FsConfig.Provider = 4
+	u.FsConfig.WebDAVConfig.Endpoint = webDAVServer.URL + "/dav"
+	u.FsConfig.WebDAVConfig.Username = "webdav_user"
+	u.FsConfig.WebDAVConfig.Password = "webdav_password"
+	u.FsConfig.WebDAVConfig.RootPath = "users/test/"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		// the root path is created on login
+		if _, err = os.Stat(filepath.Join(webDAVRoot, "users", "test")); err != nil {
+			t.Errorf("the root path was not created on the WebDAV server: %v", err)
+		}
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(131073)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		// the file is uploaded asynchronously so we cannot check its size immediately
+		err = sftpUploadFile(testFilePath, testFileName, 0, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = waitForCryptUpload(client, testFileName, testFileSize)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		initialHash, _ := computeHashForFile(sha256.New(), testFilePath)
+		remoteHash, _ := computeHashForFile(sha256.New(), filepath.Join(webDAVRoot, "users", "test", testFileName))
+		if initialHash != remoteHash {
+			t.Errorf("the file stored on the WebDAV server does not match the uploaded one")
+		}
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		downloadedFileHash, _ := computeHashForFile(sha256.New(), localDownloadPath)
+		if initialHash != downloadedFileHash {
+			t.Errorf("downloaded file hash does not match the uploaded one")
+		}
+		err = client.Mkdir("sub dir")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = client.Rename(testFileName, path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to rename file: %v", err)
+		}
+		files, err := client.ReadDir("/")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != "sub dir" || !files[0].IsDir() {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		files, err = client.ReadDir("sub dir")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != testFileName || files[0].Size() != testFileSize {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+		if err != nil {
+			t.Errorf("error starting quota scan: %v", err)
+		}
+		err = waitQuotaScans()
+		if err != nil {
+			t.Errorf("error waiting for active quota scans: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected quota after scan, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		err = client.Symlink(path.Join("sub dir", testFileName), "link")
+		if err == nil {
+			t.Error("symlinks must not be supported on WebDAV")
+		}
+		err = client.RemoveDirectory("sub dir")
+		if err == nil {
+			t.Error("removing a non empty dir must fail")
+		}
+		err = client.Remove(path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to remove file: %v", err)
+		}
+		err = client.RemoveDirectory("sub dir")
+		if err != nil {
+			t.Errorf("unable to remove dir: %v", err)
+		}
+		_, err = client.Stat("sub dir")
+		if err == nil {
+			t.Error("the removed dir must not exist")
+		}
+		os.Remove(testFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// wrong credentials
+	u.FsConfig.WebDAVConfig.Password = "wrong password"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.ReadDir("/")
+		if err == nil {
+			t.Error("reading a dir with wrong WebDAV credentials must fail")
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.RemoveAll(webDAVRoot)
+}
+
+func TestHDFSFs(t *testing.T) {
+	hdfsRoot := filepath.Join(homeBasePath, "hdfs_root")
+	os.RemoveAll(hdfsRoot)
+	err := os.MkdirAll(hdfsRoot, 0777)
+	if err != nil {
+		t.Errorf("unable to create the HDFS root dir: %v", err)
+	}
+	hdfsServer := startTestWebHDFSServer(hdfsRoot, "hdfs_user")
+	defer hdfsServer.Close()
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 1
+	u.FsConfig.Provider = 5
+	u.FsConfig.HDFSConfig.Endpoint = hdfsServer.URL + "/webhdfs/v1"
+	u.FsConfig.HDFSConfig.Username = "hdfs_user"
+	u.FsConfig.HDFSConfig.RootPath = "data/partners/"
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		// the root path is created on login
+		if _, err = os.Stat(filepath.Join(hdfsRoot, "data", "partners")); err != nil {
+			t.Errorf("the root path was not created inside HDFS: %v", err)
+		}
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(131073)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		// the file is uploaded asynchronously so we cannot check its size immediately
+		err = sftpUploadFile(testFilePath, testFileName, 0, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = waitForCryptUpload(client, testFileName, testFileSize)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		initialHash, _ := computeHashForFile(sha256.New(), testFilePath)
+		remoteHash, _ := computeHashForFile(sha256.New(), filepath.Join(hdfsRoot, "data", "partners", testFileName))
+		if initialHash != remoteHash {
+			t.Errorf("the file stored inside HDFS does not match the uploaded one")
+		}
+		// the quota is enforced as for any other filesystem
+		err = sftpUploadFile(testFilePath, testFileName+"_1", 0, client)
+		if err == nil {
+			t.Error("upload must fail, the files quota is exceeded")
+		}
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		downloadedFileHash, _ := computeHashForFile(sha256.New(), localDownloadPath)
+		if initialHash != downloadedFileHash {
+			t.Errorf("downloaded file hash does not match the uploaded one")
+		}
+		modTime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+		err = client.Chtimes(testFileName, modTime, modTime)
+		if err != nil {
+			t.Errorf("unable to change file times: %v", err)
+		}
+		info, err := client.Stat(testFileName)
+		if err != nil {
+			t.Errorf("unable to stat file: %v", err)
+		} else if !info.ModTime().Equal(modTime) {
+			t.Errorf("unexpected modification time: %v, expected: %v", info.ModTime(), modTime)
+		}
+		err = client.Mkdir("sub dir")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = client.Mkdir("sub dir")
+		if err == nil {
+			t.Error("creating an existing dir must fail")
+		}
+		err = client.Rename(testFileName, path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to rename file: %v", err)
+		}
+		files, err := client.ReadDir("/")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != "sub dir" || !files[0].IsDir() {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		files, err = client.ReadDir("sub dir")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != testFileName || files[0].Size() != testFileSize {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+		if err != nil {
+			t.Errorf("error starting quota scan: %v", err)
+		}
+		err = waitQuotaScans()
+		if err != nil {
+			t.Errorf("error waiting for active quota scans: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected quota after scan, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		err = client.Symlink(path.Join("sub dir", testFileName), "link")
+		if err == nil {
+			t.Error("symlinks must not be supported on HDFS")
+		}
+		err = client.RemoveDirectory("sub dir")
+		if err == nil {
+			t.Error("removing a non empty dir must fail")
+		}
+		err = client.Remove(path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to remove file: %v", err)
+		}
+		err = client.RemoveDirectory("sub dir")
+		if err != nil {
+			t.Errorf("unable to remove dir: %v", err)
+		}
+		_, err = client.Stat("sub dir")
+		if err == nil {
+			t.Error("the removed dir must not exist")
+		}
+		os.Remove(testFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// the Hadoop user is not allowed
+	u.FsConfig.HDFSConfig.Username = "other_user"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.ReadDir("/")
+		if err == nil {
+			t.Error("reading a dir with a not allowed Hadoop user must fail")
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.RemoveAll(hdfsRoot)
+}
+
+func TestGoogleDriveFs(t *testing.T) {
+	driveServer := startTestGoogleDriveServer("root_folder_id", "user@example.com")
+	defer driveServer.Close()
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 1
+	u.FsConfig.Provider = 6
+	u.FsConfig.GoogleDriveConfig.FolderID = "root_folder_id"
+	u.FsConfig.GoogleDriveConfig.Credentials = getTestGoogleServiceAccount(t, driveServer.URL+"/token")
+	u.FsConfig.GoogleDriveConfig.Subject = "user@example.com"
+	u.FsConfig.GoogleDriveConfig.Endpoint = driveServer.URL
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(131073)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		// the file is uploaded asynchronously so we cannot check its size immediately
+		err = sftpUploadFile(testFilePath, testFileName, 0, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = waitForCryptUpload(client, testFileName, testFileSize)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		// the quota is enforced as for any other filesystem
+		err = sftpUploadFile(testFilePath, testFileName+"_1", 0, client)
+		if err == nil {
+			t.Error("upload must fail, the files quota is exceeded")
+		}
+		initialHash, _ := computeHashForFile(sha256.New(), testFilePath)
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		downloadedFileHash, _ := computeHashForFile(sha256.New(), localDownloadPath)
+		if initialHash != downloadedFileHash {
+			t.Errorf("downloaded file hash does not match the uploaded one")
+		}
+		modTime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+		err = client.Chtimes(testFileName, modTime, modTime)
+		if err != nil {
+			t.Errorf("unable to change file times: %v", err)
+		}
+		info, err := client.Stat(testFileName)
+		if err != nil {
+			t.Errorf("unable to stat file: %v", err)
+		} else if !info.ModTime().Equal(modTime) {
+			t.Errorf("unexpected modification time: %v, expected: %v", info.ModTime(), modTime)
+		}
+		err = client.Mkdir("sub dir's")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = client.Mkdir("sub dir's")
+		if err == nil {
+			t.Error("creating an existing dir must fail")
+		}
+		err = client.Rename(testFileName, path.Join("sub dir's", testFileName))
+		if err != nil {
+			t.Errorf("unable to rename file: %v", err)
+		}
+		files, err := client.ReadDir("/")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != "sub dir's" || !files[0].IsDir() {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		files, err = client.ReadDir("sub dir's")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != testFileName || files[0].Size() != testFileSize {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+		if err != nil {
+			t.Errorf("error starting quota scan: %v", err)
+		}
+		err = waitQuotaScans()
+		if err != nil {
+			t.Errorf("error waiting for active quota scans: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected quota after scan, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		err = client.Symlink(path.Join("sub dir's", testFileName), "link")
+		if err == nil {
+			t.Error("symlinks must not be supported on Google Drive")
+		}
+		err = client.RemoveDirectory("sub dir's")
+		if err == nil {
+			t.Error("removing a non empty dir must fail")
+		}
+		err = client.Remove(path.Join("sub dir's", testFileName))
+		if err != nil {
+			t.Errorf("unable to remove file: %v", err)
+		}
+		err = client.RemoveDirectory("sub dir's")
+		if err != nil {
+			t.Errorf("unable to remove dir: %v", err)
+		}
+		_, err = client.Stat("sub dir's")
+		if err == nil {
+			t.Error("the removed dir must not exist")
+		}
+		os.Remove(testFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// the service account is not allowed to impersonate this user
+	u.FsConfig.GoogleDriveConfig.Subject = "other@example.com"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		defer client.Close()
+		_, err = client.ReadDir("/")
+		if err == nil {
+			t.Error("reading a dir with a not allowed subject must fail")
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestDropboxFs(t *testing.T) {
+	var uploadSessions int32
+	dropboxServer := startTestDropboxServer("refresh token", "sftpgo", &uploadSessions)
+	defer dropboxServer.Close()
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.FsConfig.Provider = 7
+	u.FsConfig.DropboxConfig.RootPath = "sftpgo/"
+	u.FsConfig.DropboxConfig.RefreshToken = "refresh token"
+	u.FsConfig.DropboxConfig.AppKey = "app_key"
+	u.FsConfig.DropboxConfig.UploadChunkSize = 1
+	u.FsConfig.DropboxConfig.Endpoint = dropboxServer.URL
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		// the file name is not ASCII, it must be escaped inside the Dropbox-API-Arg header
+		testFileName := "test filè.dat"
+		testFilePath := filepath.Join(homeBasePath, "test_file_dropbox.dat")
+		// bigger than the upload chunk size, an upload session is needed
+		testFileSize := int64(2621441)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, 0, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		// the file is committed asynchronously when the upload session finishes
+		err = waitForCryptUpload(client, testFileName, testFileSize)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		if atomic.LoadInt32(&uploadSessions) != 1 {
+			t.Errorf("unexpected upload sessions: %v", atomic.LoadInt32(&uploadSessions))
+		}
+		smallFileName := "small.dat"
+		smallFilePath := filepath.Join(homeBasePath, smallFileName)
+		smallFileSize := int64(65535)
+		err = createTestFile(smallFilePath, smallFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(smallFilePath, smallFileName, 0, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = waitForCryptUpload(client, smallFileName, smallFileSize)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		// files not bigger than a chunk are uploaded using a single request
+		if atomic.LoadInt32(&uploadSessions) != 1 {
+			t.Errorf("unexpected upload sessions: %v", atomic.LoadInt32(&uploadSessions))
+		}
+		initialHash, _ := computeHashForFile(sha256.New(), testFilePath)
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		downloadedFileHash, _ := computeHashForFile(sha256.New(), localDownloadPath)
+		if initialHash != downloadedFileHash {
+			t.Errorf("downloaded file hash does not match the uploaded one")
+		}
+		modTime := time.Now().Add(-24 * time.Hour)
+		err = client.Chtimes(testFileName, modTime, modTime)
+		if err == nil {
+			t.Error("changing the modification time must not be supported on Dropbox")
+		}
+		err = client.Mkdir("sub dir")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = client.Mkdir("sub dir")
+		if err == nil {
+			t.Error("creating an existing dir must fail")
+		}
+		err = client.Mkdir(path.Join("missing", "sub dir"))
+		if err == nil {
+			t.Error("creating a dir inside a missing dir must fail")
+		}
+		err = client.Rename(testFileName, path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to rename file: %v", err)
+		}
+		files, err := client.ReadDir("/")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 2 {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		files, err = client.ReadDir("sub dir")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		if len(files) != 1 || files[0].Name() != testFileName || files[0].Size() != testFileSize {
+			t.Errorf("unexpected dir contents: %+v", files)
+		}
+		// an existing target file is replaced
+		err = client.Rename(smallFileName, path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to rename file: %v", err)
+		}
+		info, err := client.Stat(path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to stat file: %v", err)
+		} else if info.Size() != smallFileSize {
+			t.Errorf("unexpected size after rename: %v", info.Size())
+		}
+		_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+		if err != nil {
+			t.Errorf("error starting quota scan: %v", err)
+		}
+		err = waitQuotaScans()
+		if err != nil {
+			t.Errorf("error waiting for active quota scans: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != smallFileSize {
+			t.Errorf("unexpected quota after scan, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		err = client.Symlink(path.Join("sub dir", testFileName), "link")
+		if err == nil {
+			t.Error("symlinks must not be supported on Dropbox")
+		}
+		err = client.RemoveDirectory("sub dir")
+		if err == nil {
+			t.Error("removing a non empty dir must fail")
+		}
+		err = client.Remove(path.Join("sub dir", testFileName))
+		if err != nil {
+			t.Errorf("unable to remove file: %v", err)
+		}
+		err = client.RemoveDirectory("sub dir")
+		if err != nil {
+			t.Errorf("unable to remove dir: %v", err)
+		}
+		_, err = client.Stat("sub dir")
+		if err == nil {
+			t.Error("the removed dir must not exist")
+		}
+		os.Remove(testFilePath)
+		os.Remove(smallFilePath)
+		os.Remove(localDownloadPath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// an access token cannot be obtained using this refresh token
+	u.FsConfig.DropboxConfig.RefreshToken = "invalid refresh token"
+	user, _, err = httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		defer client.Close()
+		_, err = client.ReadDir("/")
+		if err == nil {
+			t.Error("reading a dir with an invalid refresh token must fail")
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestActionHooksTest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	for _, event := range []string{"download", "upload", "delete", "rename", "ssh_cmd", "slow_transfer"} {
+		results, _, err := httpd.TestHooks("actions", httpd.HookTestRequest{Event: event}, http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to test hooks for event %#v: %v", event, err)
+		}
+		if len(results) != 2 {
+			t.Errorf("unexpected results for event %#v: %+v", event, results)
+			continue
+		}
+		if results[0].Type != dataprovider.HookTypeCommand || len(results[0].Error) > 0 {
+			t.Errorf("unexpected command result for event %#v: %+v", event, results[0])
+		}
+		// nothing is listening on the configured notification URL
+		if results[1].Type != dataprovider.HookTypeHTTP || results[1].StatusCode != 0 || len(results[1].Error) == 0 {
+			t.Errorf("unexpected HTTP result for event %#v: %+v", event, results[1])
+		}
+	}
+	_, _, err := httpd.TestHooks("actions", httpd.HookTestRequest{Event: "invalid"}, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error testing hooks with an invalid event: %v", err)
+	}
+}
+
+func TestQuotaScan(t *testing.T) {
+	usePubKey := false
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	testFileSize := int64(65535)
+	expectedQuotaSize := user.UsedQuotaSize + testFileSize
+	expectedQuotaFiles := user.UsedQuotaFiles + 1
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// create user with the same home dir, so there is at least an untracked file
+	user, _, err = httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+	if err != nil {
+		t.Errorf("error starting quota scan: %v", err)
+	}
+	err = waitQuotaScans()
+	if err != nil {
+		t.Errorf("error waiting for active quota scans: %v", err)
+	}
+	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("error getting user: %v", err)
+	}
+	if expectedQuotaFiles != user.UsedQuotaFiles {
+		t.Errorf("quota files does not match after scan, expected: %v, actual: %v", expectedQuotaFiles, user.UsedQuotaFiles)
+	}
+	if expectedQuotaSize != user.UsedQuotaSize {
+		t.Errorf("quota size does not match after scan, expected: %v, actual: %v", expectedQuotaSize, user.UsedQuotaSize)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestTruncateQuota(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaSize = 104857600
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileSize := int64(65535)
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		truncatedSize := int64(100)
+		err = client.Truncate(testFileName, truncatedSize)
+		if err != nil {
+			t.Errorf("truncate error: %v", err)
+		}
+		fi, err := client.Stat(testFileName)
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		} else if fi.Size() != truncatedSize {
+			t.Errorf("unexpected size after truncate, expected: %v, actual: %v", truncatedSize, fi.Size())
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != truncatedSize {
+			t.Errorf("quota does not match after truncate, files: %v, size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		// extending a file using truncate creates a sparse file
+		sparseSize := int64(10485760)
+		err = client.Truncate(testFileName, sparseSize)
+		if err != nil {
+			t.Errorf("truncate error: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaSize != sparseSize {
+			t.Errorf("quota size does not match after truncate, expected: %v, actual: %v", sparseSize, user.UsedQuotaSize)
+		}
+		// the size increase does not fit in the remaining quota
+		err = client.Truncate(testFileName, u.QuotaSize+1)
+		if err == nil {
+			t.Error("truncate exceeding the size quota must fail")
+		}
+		fi, err = client.Stat(testFileName)
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		} else if fi.Size() != sparseSize {
+			t.Errorf("unexpected size after a denied truncate, expected: %v, actual: %v", sparseSize, fi.Size())
+		}
+		err = client.Truncate("missing_file", 0)
+		if err == nil {
+			t.Error("truncating a missing file must fail")
+		}
+		err = client.Mkdir("adir")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = client.Truncate("adir", 0)
+		if err == nil {
+			t.Error("truncating a directory must fail")
+		}
+		if runtime.GOOS != "windows" {
+			vfs.SetUseAllocatedSize(true)
+			_, err = httpd.StartQuotaScan(user, http.StatusCreated)
+			if err != nil {
+				t.Errorf("error starting quota scan: %v", err)
+			}
+			err = waitQuotaScans()
+			if err != nil {
+				t.Errorf("error waiting for active quota scans: %v", err)
+			}
+			vfs.SetUseAllocatedSize(false)
+			user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+			if err != nil {
+				t.Errorf("error getting user: %v", err)
+			}
+			if user.UsedQuotaSize >= sparseSize {
+				t.Errorf("the allocated size must be used for sparse files, quota size: %v", user.UsedQuotaSize)
+			}
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestTruncatePermissions(t *testing.T) {
 	usePubKey := false
-	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	u := getTestUser(usePubKey)
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload, dataprovider.PermDownload}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
-	testFileSize := int64(65535)
-	expectedQuotaSize := user.UsedQuotaSize + testFileSize
-	expectedQuotaFiles := user.UsedQuotaFiles + 1
 	client, err := getSftpClient(user, usePubKey)
 	if err != nil {
 		t.Errorf("unable to create sftp client: %v", err)
 	} else {
 		defer client.Close()
+		testFileSize := int64(65535)
 		testFileName := "test_file.dat"
 		testFilePath := filepath.Join(homeBasePath, testFileName)
 		err = createTestFile(testFilePath, testFileSize)
@@ -2133,39 +4241,16 @@ func TestQuotaScan(t *testing.T) {
 		if err != nil {
 			t.Errorf("file upload error: %v", err)
 		}
+		err = client.Truncate(testFileName, 0)
+		if err == nil {
+			t.Error("truncate without overwrite permission must fail")
+		}
 		os.Remove(testFilePath)
 	}
 	_, err = httpd.RemoveUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to remove user: %v", err)
 	}
-	// create user with the same home dir, so there is at least an untracked file
-	user, _, err = httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
-	if err != nil {
-		t.Errorf("unable to add user: %v", err)
-	}
-	_, err = httpd.StartQuotaScan(user, http.StatusCreated)
-	if err != nil {
-		t.Errorf("error starting quota scan: %v", err)
-	}
-	err = waitQuotaScans()
-	if err != nil {
-		t.Errorf("error waiting for active quota scans: %v", err)
-	}
-	user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
-	if err != nil {
-		t.Errorf("error getting user: %v", err)
-	}
-	if expectedQuotaFiles != user.UsedQuotaFiles {
-		t.Errorf("quota files does not match after scan, expected: %v, actual: %v", expectedQuotaFiles, user.UsedQuotaFiles)
-	}
-	if expectedQuotaSize != user.UsedQuotaSize {
-		t.Errorf("quota size does not match after scan, expected: %v, actual: %v", expectedQuotaSize, user.UsedQuotaSize)
-	}
-	_, err = httpd.RemoveUser(user, http.StatusOK)
-	if err != nil {
-		t.Errorf("unable to remove user: %v", err)
-	}
 	os.RemoveAll(user.GetHomeDir())
 }
 
@@ -2225,6 +4310,51 @@ func TestQuotaSize(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
+func TestQuotaUserOverride(t *testing.T) {
+	usePubKey := false
+	testFileSize := int64(65535)
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	quotaFiles := 1
+	_, _, err = httpd.AddUserOverride(dataprovider.UserOverride{Username: user.Username, QuotaFiles: &quotaFiles,
+		Duration: 3600}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user override: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName+".1", testFileSize, client)
+		if err == nil {
+			t.Errorf("the overridden quota is exceeded, file upload must fail")
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = httpd.RemoveUserOverride(user.Username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user override: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
 func TestBandwidthAndConnections(t *testing.T) {
 	usePubKey := false
 	testFileSize := int64(131072)
@@ -2374,6 +4504,111 @@ func TestExtensionsFilters(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
+func TestReadOnlyUser(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	testFileSize := int64(65535)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+	err = createTestFile(testFilePath, testFileSize)
+	if err != nil {
+		t.Errorf("unable to create test file: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = client.Mkdir("testdir")
+		if err != nil {
+			t.Errorf("error mkdir: %v", err)
+		}
+	}
+	user.Filters.ReadOnly = true
+	user, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	if !user.Filters.ReadOnly {
+		t.Error("the user must be read only")
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file download error: %v", err)
+		}
+		_, err = client.ReadDir("/")
+		if err != nil {
+			t.Errorf("unable to read dir: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err == nil {
+			t.Error("file overwrite must fail for a read only user")
+		}
+		err = sftpUploadFile(testFilePath, testFileName+"1", testFileSize, client)
+		if err == nil {
+			t.Error("file upload must fail for a read only user")
+		}
+		err = client.Rename(testFileName, testFileName+"1")
+		if err == nil {
+			t.Error("rename must fail for a read only user")
+		}
+		err = client.Remove(testFileName)
+		if err == nil {
+			t.Error("remove must fail for a read only user")
+		}
+		err = client.RemoveDirectory("testdir")
+		if err == nil {
+			t.Error("rmdir must fail for a read only user")
+		}
+		err = client.Mkdir("testdir1")
+		if err == nil {
+			t.Error("mkdir must fail for a read only user")
+		}
+		err = client.Symlink(testFileName, testFileName+".link")
+		if err == nil {
+			t.Error("symlink must fail for a read only user")
+		}
+		err = client.Chmod(testFileName, 0600)
+		if err == nil {
+			t.Error("chmod must fail for a read only user")
+		}
+		err = client.Chtimes(testFileName, time.Now(), time.Now())
+		if err == nil {
+			t.Error("chtimes must fail for a read only user")
+		}
+		err = client.Truncate(testFileName, 0)
+		if err == nil {
+			t.Error("truncate must fail for a read only user")
+		}
+		info, err := client.Stat(testFileName)
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		} else if info.Size() != testFileSize {
+			t.Errorf("the file must be unchanged, size: %v", info.Size())
+		}
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.Remove(testFilePath)
+	os.Remove(localDownloadPath)
+	os.RemoveAll(user.GetHomeDir())
+}
+
 func TestVirtualFolders(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -2504,6 +4739,110 @@ func TestVirtualFoldersQuota(t *testing.T) {
 	os.RemoveAll(mappedPath2)
 }
 
+func TestSharedFolderQuota(t *testing.T) {
+	usePubKey := false
+	mappedPath := filepath.Join(os.TempDir(), "shared_vdir")
+	vdirPath := "/shared"
+	folder, _, err := httpd.AddFolder(dataprovider.Folder{
+		Name:       "shared_quota",
+		MappedPath: mappedPath,
+		QuotaFiles: 1,
+	}, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add folder: %v", err)
+	}
+	os.MkdirAll(mappedPath, 0777)
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: vdirPath,
+		Name:        folder.Name,
+	})
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFileSize := int64(65535)
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, path.Join(vdirPath, testFileName), testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		// the folder quota is exceeded
+		err = sftpUploadFile(testFilePath, path.Join(vdirPath, testFileName+"1"), testFileSize, client)
+		if err == nil {
+			t.Errorf("upload to a shared folder over quota must fail")
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 1 || user.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected user quota, files: %v size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		folder, _, err = httpd.GetFolderByID(folder.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting folder: %v", err)
+		}
+		if folder.UsedQuotaFiles != 1 || folder.UsedQuotaSize != testFileSize {
+			t.Errorf("unexpected folder quota, files: %v size: %v", folder.UsedQuotaFiles, folder.UsedQuotaSize)
+		}
+		// the quota for a renamed file moves to the new owner
+		err = client.Rename(path.Join(vdirPath, testFileName), testFileName+"_moved")
+		if err != nil {
+			t.Errorf("unable to rename file: %v", err)
+		}
+		user, _, err = httpd.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 2 || user.UsedQuotaSize != 2*testFileSize {
+			t.Errorf("unexpected user quota after rename, files: %v size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		folder, _, err = httpd.GetFolderByID(folder.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting folder: %v", err)
+		}
+		if folder.UsedQuotaFiles != 0 || folder.UsedQuotaSize != 0 {
+			t.Errorf("unexpected folder quota after rename, files: %v size: %v", folder.UsedQuotaFiles,
+				folder.UsedQuotaSize)
+		}
+		err = client.Mkdir(path.Join(vdirPath, "dir"))
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = client.Rename(path.Join(vdirPath, "dir"), "dir")
+		if err == nil {
+			t.Errorf("moving a directory outside a shared folder must fail")
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = httpd.RemoveFolder(folder, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove folder: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+	os.RemoveAll(mappedPath)
+}
+
 func TestMissingFile(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -2805,27 +5144,68 @@ func TestPasswordsHashBcrypt(t *testing.T) {
 	bcryptClearPwd := "secret"
 	usePubKey := false
 	u := getTestUser(usePubKey)
-	u.Password = bcryptPwd
+	u.Password = bcryptPwd
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user.Password = bcryptClearPwd
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to login with bcrypt password: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir with bcrypt password: %v", err)
+		}
+	}
+	user.Password = bcryptPwd
+	_, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("login with wrong password must fail")
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestPasswordsHashUpgrade(t *testing.T) {
+	pbkdf2Pwd := "$pbkdf2-sha256$150000$E86a9YMX3zC7$R5J62hsSq+pYw00hLLPKBbcGXmq7fj5+/M0IFoYtZbo="
+	pbkdf2ClearPwd := "password"
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Password = pbkdf2Pwd
 	user, _, err := httpd.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
-	user.Password = bcryptClearPwd
+	user.Password = pbkdf2ClearPwd
 	client, err := getSftpClient(user, usePubKey)
 	if err != nil {
-		t.Errorf("unable to login with bcrypt password: %v", err)
+		t.Errorf("unable to login with pkkdf2 sha256 password: %v", err)
+	} else {
+		client.Close()
+	}
+	dbUser, err := dataprovider.UserExists(dataprovider.GetProvider(), user.Username)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	if !strings.HasPrefix(dbUser.Password, "$argon2id$") {
+		t.Errorf("password hash not upgraded: %#v", dbUser.Password)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to login with upgraded password hash: %v", err)
 	} else {
 		defer client.Close()
 		_, err = client.Getwd()
 		if err != nil {
-			t.Errorf("unable to get working dir with bcrypt password: %v", err)
+			t.Errorf("unable to get working dir with upgraded password hash: %v", err)
 		}
 	}
-	user.Password = bcryptPwd
-	_, err = getSftpClient(user, usePubKey)
-	if err == nil {
-		t.Errorf("login with wrong password must fail")
-	}
 	_, err = httpd.RemoveUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to remove user: %v", err)
@@ -4259,6 +6639,72 @@ func TestSSHCommands(t *testing.T) {
 	}
 }
 
+func TestSSHCommandsAllowList(t *testing.T) {
+	usePubKey := false
+	var execNotifications []sftpd.ActionNotification
+	var notificationsMutex sync.Mutex
+	sftpd.RegisterActionHook(func(a sftpd.ActionNotification) {
+		if a.Action == "ssh_exec" {
+			notificationsMutex.Lock()
+			execNotifications = append(execNotifications, a)
+			notificationsMutex.Unlock()
+		}
+	})
+	u := getTestUser(usePubKey)
+	u.Filters.AllowedSSHCommands = []string{"md5sum", "pwd"}
+	user, _, err := httpd.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	_, err = runSSHCommand("md5sum", user, usePubKey)
+	if err != nil {
+		t.Errorf("md5sum must be allowed: %v", err)
+	}
+	_, err = runSSHCommand("sha1sum", user, usePubKey)
+	if err == nil {
+		t.Error("sha1sum must be denied")
+	}
+	_, err = runSSHCommand("ls -la", user, usePubKey)
+	if err == nil {
+		t.Error("a command not enabled must be denied")
+	}
+	for i := 0; i < 50; i++ {
+		notificationsMutex.Lock()
+		n := len(execNotifications)
+		notificationsMutex.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	notificationsMutex.Lock()
+	notifications := make(map[string]int)
+	for _, a := range execNotifications {
+		if a.Username == user.Username {
+			notifications[a.SSHCmd] = a.Status
+		}
+	}
+	notificationsMutex.Unlock()
+	if status, ok := notifications["md5sum"]; !ok || status != 1 {
+		t.Errorf("md5sum must be notified as allowed: %+v", notifications)
+	}
+	if status, ok := notifications["sha1sum"]; !ok || status != 0 {
+		t.Errorf("sha1sum must be notified as denied: %+v", notifications)
+	}
+	if status, ok := notifications["ls -la"]; !ok || status != 0 {
+		t.Errorf("ls must be notified as denied: %+v", notifications)
+	}
+	user.Filters.AllowedSSHCommands = []string{"md5 sum"}
+	_, _, err = httpd.UpdateUser(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("invalid allowed SSH commands must fail: %v", err)
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
 func TestSSHFileHash(t *testing.T) {
 	usePubKey := true
 	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
@@ -4321,6 +6767,77 @@ func TestSSHFileHash(t *testing.T) {
 	os.RemoveAll(user.GetHomeDir())
 }
 
+func TestSSHTail(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpd.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	testFileName := "test_tail.log"
+	testFilePath := filepath.Join(user.GetHomeDir(), testFileName)
+	err = os.MkdirAll(user.GetHomeDir(), 0777)
+	if err != nil {
+		t.Errorf("unable to create home dir: %v", err)
+	}
+	err = ioutil.WriteFile(testFilePath, []byte("line1\n"), 0666)
+	if err != nil {
+		t.Errorf("unable to create test file: %v", err)
+	}
+	_, err = runSSHCommand("sftpgo-tail -c", user, usePubKey)
+	if err == nil {
+		t.Errorf("tail with invalid args must fail")
+	}
+	_, err = runSSHCommand("sftpgo-tail -n 1 "+testFileName, user, usePubKey)
+	if err == nil {
+		t.Errorf("tail with invalid args must fail")
+	}
+	_, err = runSSHCommand("sftpgo-tail missing.log", user, usePubKey)
+	if err == nil {
+		t.Errorf("tail for a missing file must fail")
+	}
+	conn, session, stdout, err := startSSHCommand("sftpgo-tail -c +1 "+testFileName, usePubKey)
+	if err != nil {
+		t.Errorf("unable to start the tail command: %v", err)
+	} else {
+		out, err := readSSHCommandOutput(stdout, len("line1\n"))
+		if err != nil || out != "line1\n" {
+			t.Errorf("unexpected tail output: %#v, err: %v", out, err)
+		}
+		f, err := os.OpenFile(testFilePath, os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			t.Errorf("unable to open test file: %v", err)
+		} else {
+			f.Write([]byte("line2\n"))
+			f.Close()
+		}
+		out, err = readSSHCommandOutput(stdout, len("line2\n"))
+		if err != nil || out != "line2\n" {
+			t.Errorf("unexpected tail output: %#v, err: %v", out, err)
+		}
+		stats := sftpd.GetConnectionsStats()
+		if len(stats) != 1 || len(stats[0].Transfers) != 1 {
+			t.Errorf("the tail command must be an active transfer: %+v", stats)
+		}
+		session.Close()
+		conn.Close()
+	}
+	// without the download permission tail must fail
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	_, _, err = httpd.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = runSSHCommand("sftpgo-tail "+testFileName, user, usePubKey)
+	if err == nil {
+		t.Errorf("tail without download permission must fail")
+	}
+	_, err = httpd.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
 func TestBasicGitCommands(t *testing.T) {
 	if len(gitPath) == 0 || len(sshPath) == 0 {
 		t.Skip("git and/or ssh command not found, unable to execute this test")
@@ -5297,6 +7814,77 @@ func runSSHCommand(command string, user dataprovider.User, usePubKey bool) ([]by
 	return stdout.Bytes(), err
 }
 
+// startSSHCommand starts the given command and returns without waiting for the command to finish
+func startSSHCommand(command string, usePubKey bool) (*ssh.Client, *ssh.Session, io.Reader, error) {
+	config := &ssh.ClientConfig{
+		User: defaultUsername,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+	}
+	if usePubKey {
+		key, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(key)}
+	} else {
+		config.Auth = []ssh.AuthMethod{ssh.Password(defaultPassword)}
+	}
+	conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err == nil {
+		err = session.Start(command)
+	}
+	if err != nil {
+		session.Close()
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return conn, session, stdout, nil
+}
+
+func readSSHCommandOutput(r io.Reader, size int) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		buf := make([]byte, size)
+		_, err := io.ReadFull(r, buf)
+		c <- result{out: string(buf), err: err}
+	}()
+	select {
+	case res := <-c:
+		return res.out, res.err
+	case <-time.After(5 * time.Second):
+		return "", errors.New("timeout reading the command output")
+	}
+}
+
+func getUserActivityTotals(t *testing.T, username string) (int64, int64, int64) {
+	var logins, uploads, downloads int64
+	activity, _, err := httpd.GetUserActivity(username, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user activity: %v", err)
+	}
+	for _, bucket := range activity.Buckets {
+		logins += bucket.Logins
+		uploads += bucket.Uploads
+		downloads += bucket.Downloads
+	}
+	return logins, uploads, downloads
+}
+
 func getSftpClientWithAddr(user dataprovider.User, usePubKey bool, addr string) (*sftp.Client, error) {
 	var sftpClient *sftp.Client
 	config := &ssh.ClientConfig{
@@ -5401,6 +7989,31 @@ func appendToTestFile(path string, size int64) error {
 	return nil
 }
 
+func getTarGzEntries(name string) ([]string, error) {
+	var entries []string
+	f, err := os.Open(name)
+	if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return entries, err
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, hdr.Name)
+	}
+	return entries, nil
+}
+
 func sftpUploadFile(localSourcePath string, remoteDestPath string, expectedSize int64, client *sftp.Client) error {
 	srcFile, err := os.Open(localSourcePath)
 	if err != nil {
@@ -5593,6 +8206,717 @@ func computeHashForFile(hasher hash.Hash, path string) (string, error) {
 	return hash, err
 }
 
+func waitForCryptUpload(client *sftp.Client, remotePath string, expectedSize int64) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		var fi os.FileInfo
+		fi, err = client.Stat(remotePath)
+		if err == nil {
+			if fi.Size() == expectedSize {
+				return nil
+			}
+			err = fmt.Errorf("uploaded file size does not match, actual: %v, expected: %v", fi.Size(), expectedSize)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// startTestWebDAVServer starts a minimal WebDAV server that serves root under prefix,
+// only the methods used by the WebDAV filesystem are implemented
+func startTestWebDAVServer(root, prefix, username, password string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		getLocalPath := func(p string) string {
+			return filepath.Join(root, filepath.FromSlash(path.Clean("/"+strings.TrimPrefix(p, prefix))))
+		}
+		localPath := getLocalPath(r.URL.Path)
+		switch r.Method {
+		case "PROPFIND":
+			fi, err := os.Stat(localPath)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			infos := []os.FileInfo{fi}
+			names := []string{""}
+			if fi.IsDir() && r.Header.Get("Depth") == "1" {
+				contents, _ := ioutil.ReadDir(localPath)
+				for _, info := range contents {
+					infos = append(infos, info)
+					names = append(names, info.Name())
+				}
+			}
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">`)
+			for i, info := range infos {
+				href := (&url.URL{Path: path.Join(r.URL.Path, names[i])}).EscapedPath()
+				resourceType := ""
+				if info.IsDir() {
+					resourceType = "<d:collection/>"
+				}
+				fmt.Fprintf(w, "<d:response><d:href>%v</d:href><d:propstat><d:prop><d:resourcetype>%v</d:resourcetype>"+
+					"<d:getcontentlength>%v</d:getcontentlength><d:getlastmodified>%v</d:getlastmodified></d:prop>"+
+					"<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>", href, resourceType, info.Size(),
+					info.ModTime().UTC().Format(http.TimeFormat))
+			}
+			fmt.Fprint(w, "</d:multistatus>")
+		case http.MethodGet:
+			f, err := os.Open(localPath)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			defer f.Close()
+			io.Copy(w, f)
+		case http.MethodPut:
+			f, err := os.Create(localPath)
+			if err != nil {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			defer f.Close()
+			if _, err = io.Copy(f, r.Body); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "MKCOL":
+			if err := os.Mkdir(localPath, 0777); err != nil {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "MOVE":
+			destination, err := url.Parse(r.Header.Get("Destination"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err = os.Rename(localPath, getLocalPath(destination.Path)); err != nil {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			if _, err := os.Stat(localPath); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			os.RemoveAll(localPath)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// startTestWebHDFSServer starts a minimal WebHDFS server that serves root, only the operations
+// used by the HDFS filesystem are implemented. OPEN and CREATE are redirected to the server itself
+// as a name node does with the data nodes
+func startTestWebHDFSServer(root, username string) *httptest.Server {
+	const prefix = "/webhdfs/v1"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendJSON := func(statusCode int, result interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			json.NewEncoder(w).Encode(result)
+		}
+		sendRemoteException := func(statusCode int, exception, message string) {
+			sendJSON(statusCode, map[string]interface{}{
+				"RemoteException": map[string]string{
+					"exception": exception,
+					"message":   message,
+				},
+			})
+		}
+		getFileStatus := func(name string, info os.FileInfo) map[string]interface{} {
+			fileType := "FILE"
+			if info.IsDir() {
+				fileType = "DIRECTORY"
+			}
+			return map[string]interface{}{
+				"pathSuffix":       name,
+				"type":             fileType,
+				"length":           info.Size(),
+				"modificationTime": utils.GetTimeAsMsSinceEpoch(info.ModTime()),
+			}
+		}
+		query := r.URL.Query()
+		if query.Get("user.name") != username {
+			sendRemoteException(http.StatusForbidden, "AccessControlException", "permission denied")
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		getLocalPath := func(p string) string {
+			return filepath.Join(root, filepath.FromSlash(path.Clean("/"+strings.TrimPrefix(p, prefix))))
+		}
+		localPath := getLocalPath(r.URL.Path)
+		op := query.Get("op")
+		if (op == "OPEN" || op == "CREATE") && query.Get("datanode") != "true" {
+			query.Set("datanode", "true")
+			w.Header().Set("Location", fmt.Sprintf("http://%v%v?%v", r.Host, r.URL.Path, query.Encode()))
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		switch op {
+		case "GETFILESTATUS":
+			fi, err := os.Stat(localPath)
+			if err != nil {
+				sendRemoteException(http.StatusNotFound, "FileNotFoundException", "file not found")
+				return
+			}
+			sendJSON(http.StatusOK, map[string]interface{}{"FileStatus": getFileStatus("", fi)})
+		case "LISTSTATUS":
+			fi, err := os.Stat(localPath)
+			if err != nil {
+				sendRemoteException(http.StatusNotFound, "FileNotFoundException", "file not found")
+				return
+			}
+			statuses := []map[string]interface{}{}
+			if fi.IsDir() {
+				contents, _ := ioutil.ReadDir(localPath)
+				for _, info := range contents {
+					statuses = append(statuses, getFileStatus(info.Name(), info))
+				}
+			} else {
+				statuses = append(statuses, getFileStatus("", fi))
+			}
+			sendJSON(http.StatusOK, map[string]interface{}{
+				"FileStatuses": map[string]interface{}{"FileStatus": statuses},
+			})
+		case "GETCONTENTSUMMARY":
+			fileCount := 0
+			length := int64(0)
+			err := filepath.Walk(localPath, func(walkedPath string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() {
+					fileCount++
+					length += info.Size()
+				}
+				return err
+			})
+			if err != nil {
+				sendRemoteException(http.StatusNotFound, "FileNotFoundException", "file not found")
+				return
+			}
+			sendJSON(http.StatusOK, map[string]interface{}{
+				"ContentSummary": map[string]interface{}{"fileCount": fileCount, "length": length},
+			})
+		case "OPEN":
+			f, err := os.Open(localPath)
+			if err != nil {
+				sendRemoteException(http.StatusNotFound, "FileNotFoundException", "file not found")
+				return
+			}
+			defer f.Close()
+			w.Header().Set("Content-Type", "application/octet-stream")
+			io.Copy(w, f)
+		case "CREATE":
+			f, err := os.Create(localPath)
+			if err != nil {
+				sendRemoteException(http.StatusNotFound, "FileNotFoundException", "parent not found")
+				return
+			}
+			defer f.Close()
+			if _, err = io.Copy(f, r.Body); err != nil {
+				sendRemoteException(http.StatusInternalServerError, "IOException", err.Error())
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "MKDIRS":
+			err := os.MkdirAll(localPath, 0777)
+			sendJSON(http.StatusOK, map[string]bool{"boolean": err == nil})
+		case "RENAME":
+			err := os.Rename(localPath, getLocalPath(prefix+query.Get("destination")))
+			sendJSON(http.StatusOK, map[string]bool{"boolean": err == nil})
+		case "DELETE":
+			if _, err := os.Stat(localPath); err != nil {
+				sendJSON(http.StatusOK, map[string]bool{"boolean": false})
+				return
+			}
+			if err := os.Remove(localPath); err != nil {
+				sendRemoteException(http.StatusForbidden, "PathIsNotEmptyDirectoryException", "directory is not empty")
+				return
+			}
+			sendJSON(http.StatusOK, map[string]bool{"boolean": true})
+		case "SETTIMES":
+			atime, _ := strconv.ParseInt(query.Get("accesstime"), 10, 64)
+			mtime, _ := strconv.ParseInt(query.Get("modificationtime"), 10, 64)
+			err := os.Chtimes(localPath, utils.GetTimeFromMsecSinceEpoch(atime), utils.GetTimeFromMsecSinceEpoch(mtime))
+			if err != nil {
+				sendRemoteException(http.StatusNotFound, "FileNotFoundException", "file not found")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "TRUNCATE":
+			size, _ := strconv.ParseInt(query.Get("newlength"), 10, 64)
+			err := os.Truncate(localPath, size)
+			sendJSON(http.StatusOK, map[string]bool{"boolean": err == nil})
+		default:
+			sendRemoteException(http.StatusBadRequest, "IllegalArgumentException", "invalid operation")
+		}
+	}))
+}
+
+// getTestGoogleServiceAccount returns a service account JSON key with a newly generated private key
+func getTestGoogleServiceAccount(t *testing.T, tokenURI string) string {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Errorf("unable to generate private key: %v", err)
+		return ""
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Errorf("unable to marshal private key: %v", err)
+		return ""
+	}
+	asJSON, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sftpgo@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		"token_uri":    tokenURI,
+	})
+	if err != nil {
+		t.Errorf("unable to marshal service account: %v", err)
+		return ""
+	}
+	return string(asJSON)
+}
+
+// startTestGoogleDriveServer starts a minimal in memory Drive API server, only the requests used
+// by the Google Drive filesystem are implemented. The access tokens are issued only for service
+// account assertions impersonating the given subject
+func startTestGoogleDriveServer(rootID, subject string) *httptest.Server {
+	const accessToken = "test_access_token"
+	type driveFile struct {
+		ID           string `json:"id"`
+		Name         string `json:"name"`
+		MimeType     string `json:"mimeType"`
+		Size         string `json:"size,omitempty"`
+		ModifiedTime string `json:"modifiedTime"`
+		parent       string
+		data         []byte
+	}
+	var mu sync.Mutex
+	lastID := 0
+	files := map[string]*driveFile{
+		rootID: {ID: rootID, Name: "root", MimeType: "application/vnd.google-apps.folder",
+			ModifiedTime: time.Now().UTC().Format(time.RFC3339)},
+	}
+	listQuery := regexp.MustCompile(`^'([^']*)' in parents(?: and name = '((?:[^'\\]|\\.)*)')? and trashed = false$`)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendJSON := func(statusCode int, result interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			json.NewEncoder(w).Encode(result)
+		}
+		sendError := func(statusCode int, reason, message string) {
+			sendJSON(statusCode, map[string]interface{}{
+				"error": map[string]interface{}{
+					"code":    statusCode,
+					"message": message,
+					"errors":  []map[string]string{{"reason": reason, "message": message}},
+				},
+			})
+		}
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			var claims map[string]interface{}
+			if len(parts) == 3 {
+				payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+				json.Unmarshal(payload, &claims)
+			}
+			if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || claims["sub"] != subject {
+				sendJSON(http.StatusUnauthorized, map[string]string{
+					"error":             "unauthorized_client",
+					"error_description": "Client is unauthorized to retrieve access tokens using this method",
+				})
+				return
+			}
+			sendJSON(http.StatusOK, map[string]interface{}{"access_token": accessToken, "expires_in": 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+accessToken {
+			sendError(http.StatusUnauthorized, "authError", "Invalid Credentials")
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		isUpload := strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files")
+		fileID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/upload"), "/drive/v3/files")
+		fileID = strings.TrimPrefix(fileID, "/")
+		query := r.URL.Query()
+		if len(fileID) == 0 {
+			switch r.Method {
+			case http.MethodGet:
+				matches := listQuery.FindStringSubmatch(query.Get("q"))
+				if matches == nil {
+					sendError(http.StatusBadRequest, "invalid", "Invalid Value")
+					return
+				}
+				name := strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(matches[2])
+				result := []*driveFile{}
+				for _, f := range files {
+					if f.parent == matches[1] && (len(name) == 0 || f.Name == name) {
+						result = append(result, f)
+					}
+				}
+				sendJSON(http.StatusOK, map[string]interface{}{"files": result})
+			case http.MethodPost:
+				var metadata struct {
+					Name     string   `json:"name"`
+					Parents  []string `json:"parents"`
+					MimeType string   `json:"mimeType"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil || len(metadata.Parents) != 1 {
+					sendError(http.StatusBadRequest, "invalid", "Invalid Value")
+					return
+				}
+				if _, ok := files[metadata.Parents[0]]; !ok {
+					sendError(http.StatusNotFound, "notFound", "File not found")
+					return
+				}
+				lastID++
+				f := &driveFile{
+					ID:           fmt.Sprintf("file_%v", lastID),
+					Name:         metadata.Name,
+					MimeType:     metadata.MimeType,
+					ModifiedTime: time.Now().UTC().Format(time.RFC3339Nano),
+					parent:       metadata.Parents[0],
+				}
+				if len(f.MimeType) == 0 {
+					f.MimeType = "application/octet-stream"
+					f.Size = "0"
+				}
+				files[f.ID] = f
+				sendJSON(http.StatusOK, f)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+		f, ok := files[fileID]
+		if !ok {
+			sendError(http.StatusNotFound, "notFound", "File not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if query.Get("alt") == "media" {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(f.data)
+				return
+			}
+			sendJSON(http.StatusOK, f)
+		case http.MethodPatch:
+			if isUpload {
+				// the contents are sent as the second part of a multipart/related body, after the metadata
+				var data []byte
+				mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if err == nil && mediaType == "multipart/related" && query.Get("uploadType") == "multipart" {
+					mr := multipart.NewReader(r.Body, params["boundary"])
+					_, err = mr.NextPart()
+					if err == nil {
+						var part *multipart.Part
+						part, err = mr.NextPart()
+						if err == nil {
+							data, err = ioutil.ReadAll(part)
+						}
+					}
+				} else if err == nil {
+					err = fmt.Errorf("unsupported upload type %#v", query.Get("uploadType"))
+				}
+				if err != nil {
+					sendError(http.StatusBadRequest, "invalid", err.Error())
+					return
+				}
+				f.data = data
+				f.Size = strconv.Itoa(len(data))
+				f.ModifiedTime = time.Now().UTC().Format(time.RFC3339Nano)
+				sendJSON(http.StatusOK, f)
+				return
+			}
+			var metadata map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+				sendError(http.StatusBadRequest, "invalid", "Invalid Value")
+				return
+			}
+			if newParent := query.Get("addParents"); len(newParent) > 0 {
+				if query.Get("removeParents") != f.parent {
+					sendError(http.StatusBadRequest, "invalid", "Invalid parents")
+					return
+				}
+				f.parent = newParent
+			}
+			if name, ok := metadata["name"]; ok {
+				f.Name = name
+			}
+			if modTime, ok := metadata["modifiedTime"]; ok {
+				f.ModifiedTime = modTime
+			}
+			sendJSON(http.StatusOK, f)
+		case http.MethodDelete:
+			delete(files, fileID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// startTestDropboxServer starts a minimal in memory Dropbox API server, only the requests used
+// by the Dropbox filesystem are implemented. The access tokens are issued only for the given
+// refresh token and the folder rootPath is created at startup. The started upload sessions
+// are counted in uploadSessions
+func startTestDropboxServer(refreshToken, rootPath string, uploadSessions *int32) *httptest.Server {
+	const accessToken = "test_access_token"
+	type dropboxEntry struct {
+		Tag            string `json:".tag"`
+		Name           string `json:"name"`
+		PathDisplay    string `json:"path_display"`
+		Size           int64  `json:"size,omitempty"`
+		ClientModified string `json:"client_modified,omitempty"`
+		ServerModified string `json:"server_modified,omitempty"`
+		data           []byte
+	}
+	var mu sync.Mutex
+	entries := map[string]*dropboxEntry{
+		"/" + strings.ToLower(rootPath): {Tag: "folder", Name: rootPath, PathDisplay: "/" + rootPath},
+	}
+	sessions := make(map[string][]byte)
+	cursors := make(map[string][]*dropboxEntry)
+	lastID := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendJSON := func(statusCode int, result interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			json.NewEncoder(w).Encode(result)
+		}
+		sendError := func(summary string) {
+			sendJSON(http.StatusConflict, map[string]interface{}{"error_summary": summary})
+		}
+		if r.URL.Path == "/oauth2/token" {
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != refreshToken ||
+				r.Form.Get("client_id") != "app_key" {
+				sendJSON(http.StatusBadRequest, map[string]string{
+					"error":             "invalid_grant",
+					"error_description": "refresh token is malformed",
+				})
+				return
+			}
+			sendJSON(http.StatusOK, map[string]interface{}{"access_token": accessToken, "expires_in": 14400,
+				"token_type": "bearer"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+accessToken {
+			sendJSON(http.StatusUnauthorized, map[string]interface{}{"error_summary": "invalid_access_token/"})
+			return
+		}
+		var args struct {
+			Path      string          `json:"path"`
+			FromPath  string          `json:"from_path"`
+			ToPath    string          `json:"to_path"`
+			Recursive bool            `json:"recursive"`
+			Limit     int             `json:"limit"`
+			Cursor    json.RawMessage `json:"cursor"`
+			Commit    struct {
+				Path string `json:"path"`
+			} `json:"commit"`
+		}
+		var body []byte
+		if apiArg := r.Header.Get("Dropbox-API-Arg"); len(apiArg) > 0 {
+			for _, c := range apiArg {
+				if c > 127 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			if err := json.Unmarshal([]byte(apiArg), &args); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ = ioutil.ReadAll(r.Body)
+		} else if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+
+		getParent := func(p string) string {
+			parent := path.Dir(p)
+			if parent == "/" {
+				return ""
+			}
+			return parent
+		}
+		// listEntries returns the entries inside the given folder, sorted by path
+		listEntries := func(folder string, recursive bool) []*dropboxEntry {
+			var result []*dropboxEntry
+			for key, entry := range entries {
+				if getParent(key) == folder || (recursive && strings.HasPrefix(key, folder+"/")) {
+					result = append(result, entry)
+				}
+			}
+			sort.Slice(result, func(i, j int) bool {
+				return result[i].PathDisplay < result[j].PathDisplay
+			})
+			return result
+		}
+		sendPage := func(contents []*dropboxEntry, limit int) {
+			// the pages are small to test the pagination
+			if limit <= 0 || limit > 2 {
+				limit = 2
+			}
+			result := map[string]interface{}{"entries": contents, "has_more": false, "cursor": ""}
+			if len(contents) > limit {
+				lastID++
+				cursor := fmt.Sprintf("cursor_%v", lastID)
+				cursors[cursor] = contents[limit:]
+				result["entries"] = contents[:limit]
+				result["has_more"] = true
+				result["cursor"] = cursor
+			}
+			sendJSON(http.StatusOK, result)
+		}
+		createFile := func(p string, data []byte) {
+			now := time.Now().UTC().Format(time.RFC3339)
+			entries[strings.ToLower(p)] = &dropboxEntry{Tag: "file", Name: path.Base(p), PathDisplay: p,
+				Size: int64(len(data)), ClientModified: now, ServerModified: now, data: data}
+		}
+		checkSession := func() ([]byte, string, bool) {
+			var cursor struct {
+				SessionID string `json:"session_id"`
+				Offset    int    `json:"offset"`
+			}
+			json.Unmarshal(args.Cursor, &cursor)
+			data, ok := sessions[cursor.SessionID]
+			if !ok || len(data) != cursor.Offset {
+				sendError("incorrect_offset/")
+				return nil, "", false
+			}
+			return data, cursor.SessionID, true
+		}
+		key := strings.ToLower(args.Path)
+		switch strings.TrimPrefix(r.URL.Path, "/2/") {
+		case "files/get_metadata":
+			entry, ok := entries[key]
+			if !ok {
+				sendError("path/not_found/")
+				return
+			}
+			sendJSON(http.StatusOK, entry)
+		case "files/list_folder":
+			if entry, ok := entries[key]; len(key) > 0 && (!ok || entry.Tag != "folder") {
+				sendError("path/not_found/")
+				return
+			}
+			sendPage(listEntries(key, args.Recursive), args.Limit)
+		case "files/list_folder/continue":
+			var cursor string
+			json.Unmarshal(args.Cursor, &cursor)
+			contents, ok := cursors[cursor]
+			if !ok {
+				sendError("reset/")
+				return
+			}
+			delete(cursors, cursor)
+			sendPage(contents, 0)
+		case "files/create_folder_v2":
+			if _, ok := entries[key]; ok {
+				sendError("path/conflict/folder/")
+				return
+			}
+			entries[key] = &dropboxEntry{Tag: "folder", Name: path.Base(args.Path), PathDisplay: args.Path}
+			sendJSON(http.StatusOK, map[string]interface{}{"metadata": entries[key]})
+		case "files/delete_v2":
+			entry, ok := entries[key]
+			if !ok {
+				sendError("path_lookup/not_found/")
+				return
+			}
+			for _, child := range listEntries(key, true) {
+				delete(entries, strings.ToLower(child.PathDisplay))
+			}
+			delete(entries, key)
+			sendJSON(http.StatusOK, map[string]interface{}{"metadata": entry})
+		case "files/move_v2":
+			fromKey := strings.ToLower(args.FromPath)
+			entry, ok := entries[fromKey]
+			if !ok {
+				sendError("from_lookup/not_found/")
+				return
+			}
+			if _, ok := entries[strings.ToLower(args.ToPath)]; ok {
+				sendError("to/conflict/file/")
+				return
+			}
+			for _, child := range listEntries(fromKey, true) {
+				delete(entries, strings.ToLower(child.PathDisplay))
+				child.PathDisplay = args.ToPath + strings.TrimPrefix(child.PathDisplay, entry.PathDisplay)
+				entries[strings.ToLower(child.PathDisplay)] = child
+			}
+			delete(entries, fromKey)
+			entry.Name = path.Base(args.ToPath)
+			entry.PathDisplay = args.ToPath
+			entries[strings.ToLower(args.ToPath)] = entry
+			sendJSON(http.StatusOK, map[string]interface{}{"metadata": entry})
+		case "files/download":
+			entry, ok := entries[key]
+			if !ok {
+				sendError("path/not_found/")
+				return
+			}
+			if entry.Tag != "file" {
+				sendError("path/not_file/")
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(entry.data)
+		case "files/upload":
+			createFile(args.Path, body)
+			sendJSON(http.StatusOK, entries[key])
+		case "files/upload_session/start":
+			atomic.AddInt32(uploadSessions, 1)
+			lastID++
+			sessionID := fmt.Sprintf("session_%v", lastID)
+			sessions[sessionID] = body
+			sendJSON(http.StatusOK, map[string]string{"session_id": sessionID})
+		case "files/upload_session/append_v2":
+			data, sessionID, ok := checkSession()
+			if !ok {
+				return
+			}
+			sessions[sessionID] = append(data, body...)
+			sendJSON(http.StatusOK, nil)
+		case "files/upload_session/finish":
+			data, sessionID, ok := checkSession()
+			if !ok {
+				return
+			}
+			delete(sessions, sessionID)
+			createFile(args.Commit.Path, append(data, body...))
+			sendJSON(http.StatusOK, entries[strings.ToLower(args.Commit.Path)])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
 func waitForNoActiveTransfer() {
 	for len(sftpd.GetConnectionsStats()) > 0 {
 		time.Sleep(100 * time.Millisecond)
@@ -5769,3 +9093,118 @@ func printLatestLogs(maxNumberOfLines int) {
 		logger.DebugToConsole(line)
 	}
 }
+
+// ldapTestServer is a minimal LDAP server supporting simple bind and search requests,
+// users maps the uid to the password
+type ldapTestServer struct {
+	sync.Mutex
+	listener net.Listener
+	users    map[string]string
+}
+
+func startLDAPTestServer(users map[string]string) (*ldapTestServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &ldapTestServer{
+		listener: listener,
+		users:    users,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConnection(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *ldapTestServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *ldapTestServer) removeUser(uid string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.users, uid)
+}
+
+func (s *ldapTestServer) checkPassword(dn, password string) bool {
+	s.Lock()
+	defer s.Unlock()
+	if len(dn) == 0 && len(password) == 0 {
+		// anonymous bind
+		return true
+	}
+	for uid, pwd := range s.users {
+		if dn == fmt.Sprintf("uid=%v,%v", uid, ldapTestBaseDN) && password == pwd {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ldapTestServer) search(filter string) []string {
+	s.Lock()
+	defer s.Unlock()
+	var result []string
+	for uid := range s.users {
+		if strings.Contains(filter, fmt.Sprintf("(uid=%v)", uid)) {
+			result = append(result, fmt.Sprintf("uid=%v,%v", uid, ldapTestBaseDN))
+		}
+	}
+	return result
+}
+
+func (s *ldapTestServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			resultCode := ldap.LDAPResultInvalidCredentials
+			if s.checkPassword(request.Children[1].Data.String(), request.Children[2].Data.String()) {
+				resultCode = ldap.LDAPResultSuccess
+			}
+			conn.Write(getLDAPResponse(messageID, ldap.ApplicationBindResponse, resultCode).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(request.Children[6])
+			if err != nil {
+				return
+			}
+			for _, dn := range s.search(filter) {
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				entry.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, ""))
+				conn.Write(getLDAPMessage(messageID, entry).Bytes())
+			}
+			conn.Write(getLDAPResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func getLDAPMessage(messageID int64, response *ber.Packet) *ber.Packet {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	message.AppendChild(response)
+	return message
+}
+
+func getLDAPResponse(messageID int64, responseType ber.Tag, resultCode int) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, responseType, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return getLDAPMessage(messageID, response)
+}
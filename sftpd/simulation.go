@@ -0,0 +1,211 @@
+package sftpd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// login rules, they identify the check that refused a login
+const (
+	LoginRuleServerIPFilter     = "server_ip_filter"
+	LoginRuleBlockedIP          = "blocked_ip"
+	LoginRuleBannedIP           = "banned_ip"
+	LoginRuleBindingLoginMethod = "binding_login_method"
+	LoginRuleCredentials        = "credentials"
+	LoginRuleSecondStep         = "second_step_required"
+	LoginRuleHomeDir            = "home_dir"
+	LoginRuleMaxSessions        = "max_sessions"
+	LoginRuleLoginMethod        = "login_method"
+	LoginRuleUserIPFilter       = "user_ip_filter"
+	LoginRuleFirstLogin         = "first_login_actions"
+)
+
+const (
+	// maximum number of login simulations for the same username inside loginSimulationWindow
+	loginSimulationMaxRequests = 30
+	loginSimulationWindow      = time.Minute
+)
+
+// ErrLoginSimulationRateLimited is returned if too many logins are simulated for the same username
+var ErrLoginSimulationRateLimited = errors.New("too many login simulations for this username, please retry later")
+
+var simulationLimiter = &loginSimulationLimiter{windows: make(map[string]*loginSimulationWindowInfo)}
+
+type loginSimulationWindowInfo struct {
+	start    time.Time
+	requests int
+}
+
+// loginSimulationLimiter limits the login simulations for each username, so the simulation
+// cannot be used to guess passwords
+type loginSimulationLimiter struct {
+	sync.Mutex
+	windows map[string]*loginSimulationWindowInfo
+}
+
+// isAllowed returns true if a new simulation is allowed for the given username and counts it
+func (l *loginSimulationLimiter) isAllowed(username string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	for k, w := range l.windows {
+		if now.Sub(w.start) >= loginSimulationWindow {
+			delete(l.windows, k)
+		}
+	}
+	w, ok := l.windows[username]
+	if !ok {
+		w = &loginSimulationWindowInfo{start: now}
+		l.windows[username] = w
+	}
+	if w.requests >= loginSimulationMaxRequests {
+		return false
+	}
+	w.requests++
+	return true
+}
+
+// LoginSimulationRequest defines the credentials to check using a simulated login.
+// A public key, a password or both are required. If both are provided the public key
+// is checked first, as SSH clients do
+type LoginSimulationRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	// public key in authorized_keys format
+	PublicKey string `json:"public_key,omitempty"`
+	// client IP address, if empty the IP based filters are not checked
+	IP string `json:"ip,omitempty"`
+	// port for the binding to simulate the login for, if 0 the main binding is used
+	Port int `json:"port,omitempty"`
+}
+
+// LoginSimulationResult defines the result for a simulated login
+type LoginSimulationResult struct {
+	Username string `json:"username"`
+	// the last login method checked
+	LoginMethod string `json:"login_method"`
+	Allowed     bool   `json:"allowed"`
+	// the check that refused the login, empty if the login is allowed
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+	// SHA256 fingerprint and comment for the matching public key, if any
+	PublicKeyID string `json:"public_key_id,omitempty"`
+}
+
+// SimulateLogin runs the login checks for the given credentials against the users stored in the
+// data provider. The simulation has no side effects: the LDAP server, the external authentication
+// and the pre-login hooks are not used, the password hashes are not upgraded, no filesystem is
+// created and no connection is added. The simulations are rate limited for each username.
+// Keyboard interactive authentication cannot be simulated
+func SimulateLogin(req LoginSimulationRequest) (LoginSimulationResult, error) {
+	result := LoginSimulationResult{
+		Username: req.Username,
+	}
+	if len(req.Username) == 0 {
+		return result, errors.New("username is required")
+	}
+	if len(req.Password) == 0 && len(req.PublicKey) == 0 {
+		return result, errors.New("a password or a public key is required")
+	}
+	if !simulationLimiter.isAllowed(req.Username, time.Now()) {
+		logger.Warn(logSender, "", "login simulation refused for user %#v: rate limit exceeded", req.Username)
+		return result, ErrLoginSimulationRateLimited
+	}
+	policy, ok := getLoginPolicyForPort(req.Port)
+	if !ok {
+		return result, fmt.Errorf("no binding for port %v", req.Port)
+	}
+	var pubKey ssh.PublicKey
+	if len(req.PublicKey) > 0 {
+		var err error
+		pubKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+		if err != nil {
+			return result, fmt.Errorf("invalid public key: %v", err)
+		}
+	}
+	remoteAddr := ""
+	if len(req.IP) > 0 {
+		if net.ParseIP(req.IP) == nil {
+			return result, fmt.Errorf("invalid IP address %#v", req.IP)
+		}
+		remoteAddr = net.JoinHostPort(req.IP, "0")
+		if !isIPAllowed(req.IP) {
+			result.refuse(LoginRuleServerIPFilter, "connection refused by the IP filters")
+			return result, nil
+		}
+		if dataprovider.IsIPBlocked(req.IP) {
+			result.refuse(LoginRuleBlockedIP, "connection refused, the IP address is blocked")
+			return result, nil
+		}
+		if defender.isBanned(req.IP) {
+			result.refuse(LoginRuleBannedIP, "connection refused, the IP address is banned by the defender")
+			return result, nil
+		}
+	}
+	var partialSuccessMethods []string
+	if pubKey != nil {
+		result.LoginMethod = dataprovider.SSHLoginMethodPublicKey
+		if policy.isPublicKeyDenied() {
+			result.refuse(LoginRuleBindingLoginMethod, "public key authentication is not allowed for this binding")
+			return result, nil
+		}
+		user, keyID, err := dataprovider.SimulateUserAndPubKey(dataProvider, req.Username, pubKey.Marshal())
+		if err != nil {
+			result.refuse(LoginRuleCredentials, err.Error())
+			return result, nil
+		}
+		result.PublicKeyID = keyID
+		if !user.IsPartialAuth(result.LoginMethod) {
+			result.checkLoginPolicy(user, remoteAddr, nil, policy)
+			return result, nil
+		}
+		if len(req.Password) == 0 {
+			result.refuse(LoginRuleSecondStep, "public key accepted, a second authentication step is required")
+			return result, nil
+		}
+		partialSuccessMethods = []string{dataprovider.SSHLoginMethodPublicKey}
+	}
+	result.LoginMethod = dataprovider.SSHLoginMethodPassword
+	if len(partialSuccessMethods) == 1 {
+		result.LoginMethod = dataprovider.SSHLoginMethodKeyAndPassword
+	}
+	if err := checkBindingLoginMethod(result.LoginMethod, "", policy); err != nil {
+		result.refuse(LoginRuleBindingLoginMethod, err.Error())
+		return result, nil
+	}
+	user, err := dataprovider.SimulateUserAndPass(dataProvider, req.Username, req.Password)
+	if err != nil {
+		result.refuse(LoginRuleCredentials, err.Error())
+		return result, nil
+	}
+	result.checkLoginPolicy(user, remoteAddr, partialSuccessMethods, policy)
+	return result, nil
+}
+
+func (r *LoginSimulationResult) checkLoginPolicy(user dataprovider.User, remoteAddr string, partialSuccessMethods []string,
+	policy *bindingLoginPolicy) {
+	rule, err := checkLoginPolicy(user, r.LoginMethod, "", remoteAddr, partialSuccessMethods, policy)
+	if err != nil {
+		r.refuse(rule, err.Error())
+		return
+	}
+	r.Allowed = true
+	r.Message = "login allowed"
+	logger.Debug(logSender, "", "simulated login allowed for user %#v, login method: %#v", r.Username, r.LoginMethod)
+}
+
+func (r *LoginSimulationResult) refuse(rule, message string) {
+	r.Allowed = false
+	r.Rule = rule
+	r.Message = message
+	logger.Debug(logSender, "", "simulated login refused for user %#v, login method: %#v, rule: %v, message: %v",
+		r.Username, r.LoginMethod, rule, message)
+}
@@ -0,0 +1,93 @@
+package sftpd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// the active transfers speed is sampled at this interval
+const slowTransfersCheckInterval = 5 * time.Second
+
+var (
+	slowTransfers           SlowTransfersConfig
+	slowTransfersCheckerRun sync.Once
+)
+
+// SlowTransfersConfig defines the thresholds to detect the slow transfers
+type SlowTransfersConfig struct {
+	// Minimum speed, as KB/s, for an active transfer. 0 means disabled
+	MinSpeed int `json:"min_speed" mapstructure:"min_speed"`
+	// Time, as seconds, the speed must remain below the minimum speed before
+	// the transfer is marked as degraded
+	Period int `json:"period" mapstructure:"period"`
+}
+
+// slowTransferState tracks the speed samples for an active transfer
+type slowTransferState struct {
+	sampleTime  time.Time
+	sampleBytes int64
+	// zero if the last sampled speed was above the minimum speed
+	slowSince time.Time
+	degraded  bool
+}
+
+func startSlowTransfersChecker(config SlowTransfersConfig) {
+	slowTransfers = config
+	slowTransfersCheckerRun.Do(func() {
+		go func() {
+			for range time.Tick(slowTransfersCheckInterval) {
+				CheckSlowTransfers()
+			}
+		}()
+	})
+}
+
+// CheckSlowTransfers samples the speed for the active transfers and marks as degraded
+// the ones below the configured minimum speed for the configured period.
+// A degraded transfer is restored as soon as its speed goes back above the minimum speed
+func CheckSlowTransfers() {
+	checkSlowTransfers(time.Now())
+}
+
+func checkSlowTransfers(now time.Time) {
+	if slowTransfers.MinSpeed <= 0 {
+		return
+	}
+	period := time.Duration(slowTransfers.Period) * time.Second
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, t := range activeTransfers {
+		state := &t.speedState
+		if state.sampleTime.IsZero() {
+			state.sampleTime = t.start
+		}
+		bytes := t.bytesSent + t.bytesReceived
+		elapsed := now.Sub(state.sampleTime)
+		if elapsed <= 0 {
+			continue
+		}
+		speed := float64(bytes-state.sampleBytes) / 1000 / elapsed.Seconds()
+		if speed >= float64(slowTransfers.MinSpeed) {
+			if state.degraded {
+				logger.Info(logSender, t.connectionID, "transfer for path %#v is no longer degraded, speed: %.1f KB/s",
+					t.path, speed)
+			}
+			state.slowSince = time.Time{}
+			state.degraded = false
+		} else {
+			if state.slowSince.IsZero() {
+				state.slowSince = state.sampleTime
+			}
+			if !state.degraded && now.Sub(state.slowSince) >= period {
+				state.degraded = true
+				logger.Warn(logSender, t.connectionID, "transfer for path %#v is degraded, speed: %.1f KB/s below "+
+					"%v KB/s since %v", t.path, speed, slowTransfers.MinSpeed, now.Sub(state.slowSince))
+				go executeAction(newActionNotification(t.user, operationSlowTransfer, t.path, "", "", bytes, nil))
+			}
+		}
+		state.sampleTime = now
+		state.sampleBytes = bytes
+	}
+}
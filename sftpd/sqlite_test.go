@@ -0,0 +1,7 @@
+// +build !sqlcipher
+
+package sftpd_test
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
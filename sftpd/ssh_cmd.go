@@ -43,40 +43,68 @@ type systemCommand struct {
 
 func processSSHCommand(payload []byte, connection *Connection, channel ssh.Channel, enabledSSHCommands []string) bool {
 	var msg sshSubsystemExecMsg
-	if err := ssh.Unmarshal(payload, &msg); err == nil {
-		name, args, err := parseCommandPayload(msg.Command)
-		connection.Log(logger.LevelDebug, logSenderSSH, "new ssh command: %#v args: %v num args: %v user: %v, error: %v",
-			name, args, len(args), connection.User.Username, err)
-		if err == nil && utils.IsStringInSlice(name, enabledSSHCommands) {
-			connection.command = msg.Command
-			if name == "scp" && len(args) >= 2 {
-				connection.protocol = protocolSCP
-				connection.channel = channel
-				scpCommand := scpCommand{
-					sshCommand: sshCommand{
-						command:    name,
-						connection: *connection,
-						args:       args},
-				}
-				go scpCommand.handle()
-				return true
-			}
-			if name != "scp" {
-				connection.protocol = protocolSSH
-				connection.channel = channel
-				sshCommand := sshCommand{
-					command:    name,
-					connection: *connection,
-					args:       args,
-				}
-				go sshCommand.handle()
-				return true
-			}
-		} else {
-			connection.Log(logger.LevelInfo, logSenderSSH, "ssh command not enabled/supported: %#v", name)
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		auditSSHCommand(connection, "", fmt.Errorf("invalid exec request: %v", err))
+		return false
+	}
+	name, args, err := parseCommandPayload(msg.Command)
+	connection.Log(logger.LevelDebug, logSenderSSH, "new ssh command: %#v args: %v num args: %v user: %v, error: %v",
+		name, args, len(args), connection.User.Username, err)
+	if err != nil {
+		auditSSHCommand(connection, msg.Command, fmt.Errorf("unable to parse the command: %v", err))
+		return false
+	}
+	if !utils.IsStringInSlice(name, enabledSSHCommands) {
+		connection.Log(logger.LevelInfo, logSenderSSH, "ssh command not enabled/supported: %#v", name)
+		auditSSHCommand(connection, msg.Command, errors.New("command not enabled"))
+		return false
+	}
+	if !connection.User.IsSSHCommandAllowed(name) {
+		connection.Log(logger.LevelInfo, logSenderSSH, "ssh command %#v not allowed for user %#v", name,
+			connection.User.Username)
+		auditSSHCommand(connection, msg.Command, errors.New("command not allowed for the user"))
+		return false
+	}
+	if name == "scp" && len(args) < 2 {
+		auditSSHCommand(connection, msg.Command, errors.New("invalid scp arguments"))
+		return false
+	}
+	auditSSHCommand(connection, msg.Command, nil)
+	connection.command = msg.Command
+	connection.channel = channel
+	if name == "scp" {
+		connection.protocol = protocolSCP
+		scpCommand := scpCommand{
+			sshCommand: sshCommand{
+				command:    name,
+				connection: *connection,
+				args:       args},
 		}
+		go scpCommand.handle()
+		return true
+	}
+	connection.protocol = protocolSSH
+	sshCommand := sshCommand{
+		command:    name,
+		connection: *connection,
+		args:       args,
+	}
+	go sshCommand.handle()
+	return true
+}
+
+// auditSSHCommand logs the decision for an SSH exec request, including the full command line,
+// and notifies it using the ssh_exec action. A nil error means the command is allowed
+func auditSSHCommand(connection *Connection, commandLine string, err error) {
+	decision := "allowed"
+	reason := ""
+	if err != nil {
+		decision = "denied"
+		reason = err.Error()
 	}
-	return false
+	logger.SSHCommandAuditLog(connection.User.Username, utils.GetIPFromRemoteAddress(connection.RemoteAddr.String()),
+		commandLine, decision, reason, connection.ID)
+	go executeAction(newActionNotification(connection.User, operationSSHExec, "", "", commandLine, 0, err))
 }
 
 func (c *sshCommand) handle() error {
@@ -91,6 +119,8 @@ func (c *sshCommand) handle() error {
 			return c.sendErrorResponse(err)
 		}
 		return c.executeSystemCommand(command)
+	} else if c.command == tailCommand {
+		return c.handleTail()
 	} else if c.command == "cd" {
 		c.sendExitStatus(nil)
 	} else if c.command == "pwd" {
@@ -129,17 +159,13 @@ func (c *sshCommand) handleHashCommands() error {
 		response = fmt.Sprintf("%x  -\n", h.Sum(nil))
 	} else {
 		sshPath := c.getDestPath()
-		if !c.connection.User.IsFileAllowed(sshPath) {
-			c.connection.Log(logger.LevelInfo, logSenderSSH, "hash not allowed for file %#v", sshPath)
-			return c.sendErrorResponse(errPermissionDenied)
+		if err := c.connection.checkPathAccess(sshPath, sshCommandsPathAccess[c.command]); err != nil {
+			return c.sendErrorResponse(err)
 		}
 		fsPath, err := c.connection.fs.ResolvePath(sshPath)
 		if err != nil {
 			return c.sendErrorResponse(err)
 		}
-		if !c.connection.User.HasPerm(dataprovider.PermListItems, sshPath) {
-			return c.sendErrorResponse(errPermissionDenied)
-		}
 		hash, err := computeHashForFile(h, fsPath)
 		if err != nil {
 			return c.sendErrorResponse(err)
@@ -155,13 +181,10 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	if !vfs.IsLocalOsFs(c.connection.fs) {
 		return c.sendErrorResponse(errUnsupportedConfig)
 	}
-	if c.connection.User.QuotaFiles > 0 && c.connection.User.UsedQuotaFiles > c.connection.User.QuotaFiles {
-		return c.sendErrorResponse(errQuotaExceeded)
-	}
-	perms := []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs, dataprovider.PermListItems,
-		dataprovider.PermOverwrite, dataprovider.PermDelete, dataprovider.PermRename}
-	if !c.connection.User.HasPerms(perms, c.getDestPath()) {
-		return c.sendErrorResponse(errPermissionDenied)
+	// system commands don't use the vfs so they require full access, they are denied for read
+	// only filesystems too
+	if err := c.connection.checkPathAccess(c.getDestPath(), sshCommandsPathAccess[c.command]); err != nil {
+		return c.sendErrorResponse(err)
 	}
 
 	stdin, err := command.cmd.StdinPipe()
@@ -208,6 +231,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 			lastActivity:   time.Now(),
 			isNewFile:      false,
 			protocol:       c.connection.protocol,
+			backend:        vfs.GetBackendType(c.connection.fs, command.realPath),
 			transferError:  nil,
 			isFinished:     false,
 			minWriteOffset: 0,
@@ -236,6 +260,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 			lastActivity:   time.Now(),
 			isNewFile:      false,
 			protocol:       c.connection.protocol,
+			backend:        vfs.GetBackendType(c.connection.fs, command.realPath),
 			transferError:  nil,
 			isFinished:     false,
 			minWriteOffset: 0,
@@ -265,6 +290,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 			lastActivity:   time.Now(),
 			isNewFile:      false,
 			protocol:       c.connection.protocol,
+			backend:        vfs.GetBackendType(c.connection.fs, command.realPath),
 			transferError:  nil,
 			isFinished:     false,
 			minWriteOffset: 0,
@@ -387,6 +413,9 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 }
 
 func (c *sshCommand) rescanHomeDir() error {
+	if folderName := c.connection.User.GetSharedFolderForPath(c.getDestPath()); len(folderName) > 0 {
+		return c.rescanSharedFolder(folderName)
+	}
 	quotaTracking := dataprovider.GetQuotaTracking()
 	if (!c.connection.User.HasQuotaRestrictions() && quotaTracking == 2) || quotaTracking == 0 {
 		return nil
@@ -395,7 +424,9 @@ func (c *sshCommand) rescanHomeDir() error {
 	var numFiles int
 	var size int64
 	if AddQuotaScan(c.connection.User.Username) {
-		numFiles, size, err = c.connection.fs.ScanRootDirContents()
+		progress := GetQuotaScanProgress(c.connection.User.Username)
+		progress.SetExpected(c.connection.User.UsedQuotaFiles, c.connection.User.UsedQuotaSize)
+		numFiles, size, err = vfs.ScanRootDirContentsWithProgress(c.connection.fs, progress)
 		if err != nil {
 			c.connection.Log(logger.LevelWarn, logSenderSSH, "error scanning user home dir %#v: %v", c.connection.User.HomeDir, err)
 		} else {
@@ -408,6 +439,39 @@ func (c *sshCommand) rescanHomeDir() error {
 	return err
 }
 
+// rescanSharedFolder updates the used quota for the given shared folder, system commands
+// executed inside a shared folder can only change its contents
+func (c *sshCommand) rescanSharedFolder(folderName string) error {
+	if dataprovider.GetQuotaTracking() == 0 {
+		return nil
+	}
+	folder, err := dataprovider.FolderExists(dataProvider, folderName)
+	if err != nil {
+		c.connection.Log(logger.LevelWarn, logSenderSSH, "unable to get folder %#v: %v", folderName, err)
+		return err
+	}
+	if !folder.HasQuotaRestrictions() && dataprovider.GetQuotaTracking() == 2 {
+		return nil
+	}
+	if AddFolderQuotaScan(folder.Name) {
+		var numFiles int
+		var size int64
+		progress := GetFolderQuotaScanProgress(folder.Name)
+		progress.SetExpected(folder.UsedQuotaFiles, folder.UsedQuotaSize)
+		fs := vfs.NewOsFs(c.connection.ID, folder.MappedPath, nil)
+		numFiles, size, err = vfs.ScanRootDirContentsWithProgress(fs, progress)
+		if err != nil {
+			c.connection.Log(logger.LevelWarn, logSenderSSH, "error scanning folder %#v: %v", folder.MappedPath, err)
+		} else {
+			err := dataprovider.UpdateFolderQuota(dataProvider, folder.Name, numFiles, size, true)
+			c.connection.Log(logger.LevelDebug, logSenderSSH, "folder %#v scanned, dir: %#v, error: %v",
+				folder.Name, folder.MappedPath, err)
+		}
+		RemoveFolderQuotaScan(folder.Name)
+	}
+	return err
+}
+
 // for the supported command, the path, if any, is the last argument
 func (c *sshCommand) getDestPath() string {
 	if len(c.args) == 0 {
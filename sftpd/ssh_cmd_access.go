@@ -0,0 +1,103 @@
+package sftpd
+
+import (
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/policy"
+)
+
+// pathAccess defines the access to a path required by an SSH command
+type pathAccess int
+
+const (
+	// no path is accessed
+	pathAccessNone pathAccess = iota
+	// read an existing file
+	pathAccessReadFile
+	// read a directory and its contents
+	pathAccessReadDir
+	// create a new file
+	pathAccessCreateFile
+	// overwrite an existing file
+	pathAccessOverwriteFile
+	// create a directory
+	pathAccessCreateDir
+	// full access, required by the system commands: they access the filesystem directly,
+	// so we cannot check the single operations
+	pathAccessFull
+)
+
+func (a pathAccess) String() string {
+	switch a {
+	case pathAccessReadFile:
+		return "read file"
+	case pathAccessReadDir:
+		return "read dir"
+	case pathAccessCreateFile:
+		return "create file"
+	case pathAccessOverwriteFile:
+		return "overwrite file"
+	case pathAccessCreateDir:
+		return "create dir"
+	case pathAccessFull:
+		return "full"
+	default:
+		return "none"
+	}
+}
+
+// sshCommandsPathAccess defines the access required by each supported SSH command for the path
+// in its last argument. scp checks the access for each transferred file and directory.
+// A new SSH command must be added here, the conformance tests fail otherwise
+var sshCommandsPathAccess = map[string]pathAccess{
+	"cd":                 pathAccessNone,
+	"pwd":                pathAccessNone,
+	"scp":                pathAccessNone,
+	"md5sum":             pathAccessReadFile,
+	"sha1sum":            pathAccessReadFile,
+	"sha256sum":          pathAccessReadFile,
+	"sha384sum":          pathAccessReadFile,
+	"sha512sum":          pathAccessReadFile,
+	tailCommand:          pathAccessReadFile,
+	"git-receive-pack":   pathAccessFull,
+	"git-upload-pack":    pathAccessFull,
+	"git-upload-archive": pathAccessFull,
+	"rsync":              pathAccessFull,
+}
+
+// checkPathAccess checks the permissions, the file filters and the quota for the requested access
+// to the given SFTP path. All the SSH commands, scp included, use this method so the same rules
+// apply to all of them and to SFTP. It returns errPermissionDenied or errQuotaExceeded
+func (c Connection) checkPathAccess(sshPath string, access pathAccess) error {
+	var op policy.Op
+	checkSpace := false
+	checkFiles := true
+	switch access {
+	case pathAccessReadFile:
+		op = policy.OpDownload
+	case pathAccessReadDir:
+		op = policy.OpDownloadDir
+	case pathAccessCreateFile:
+		op = policy.OpUpload
+		checkSpace = true
+	case pathAccessOverwriteFile:
+		op = policy.OpOverwrite
+		checkSpace = true
+		checkFiles = false
+	case pathAccessCreateDir:
+		op = policy.OpCreateDir
+	case pathAccessFull:
+		op = policy.OpSystemCommand
+		checkSpace = true
+	default:
+		return nil
+	}
+	if !c.isOpAllowed(op, sshPath) {
+		return errPermissionDenied
+	}
+	if checkSpace && !c.hasSpaceForFolder(checkFiles, c.User.GetSharedFolderForPath(sshPath)) {
+		c.Log(logger.LevelInfo, c.getLogSender(), "%v access denied for path %#v, command: %#v, error: %v", access,
+			sshPath, c.command, errQuotaExceeded)
+		return errQuotaExceeded
+	}
+	return nil
+}
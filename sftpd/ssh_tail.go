@@ -0,0 +1,183 @@
+package sftpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	tailCommand = "sftpgo-tail"
+	// interval to check the followed file for new bytes
+	tailPollInterval = 1 * time.Second
+	// if the followed file does not grow we check that the client is still connected at this interval
+	tailKeepAliveInterval = 15 * time.Second
+)
+
+var errTailInvalidArgs = errors.New("usage: sftpgo-tail [-c <bytes>|+<offset>] <path>")
+
+// tailStartOffset returns the offset to start to follow the file with the given size.
+// Without the -c option only the bytes appended after the command start are sent.
+// "-c N" sends the last N bytes too, "-c +N" starts from byte N, counting from 1, as the tail command
+func (c *sshCommand) tailStartOffset(size int64) (int64, error) {
+	switch len(c.args) {
+	case 1:
+		return size, nil
+	case 3:
+		if c.args[0] != "-c" {
+			return 0, errTailInvalidArgs
+		}
+		value := c.args[1]
+		fromStart := strings.HasPrefix(value, "+")
+		n, err := strconv.ParseInt(strings.TrimPrefix(value, "+"), 10, 64)
+		if err != nil || n < 0 {
+			return 0, errTailInvalidArgs
+		}
+		if fromStart {
+			if n > 0 {
+				n--
+			}
+			return n, nil
+		}
+		if n > size {
+			return 0, nil
+		}
+		return size - n, nil
+	default:
+		return 0, errTailInvalidArgs
+	}
+}
+
+func (c *sshCommand) handleTail() error {
+	if !vfs.IsLocalOsFs(c.connection.fs) {
+		return c.sendErrorResponse(errUnsupportedConfig)
+	}
+	if len(c.args) != 1 && len(c.args) != 3 {
+		return c.sendErrorResponse(errTailInvalidArgs)
+	}
+	sshPath := c.getDestPath()
+	if err := c.connection.checkPathAccess(sshPath, sshCommandsPathAccess[c.command]); err != nil {
+		return c.sendErrorResponse(err)
+	}
+	fsPath, err := c.connection.fs.ResolvePath(sshPath)
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+	if !info.Mode().IsRegular() {
+		return c.sendErrorResponse(fmt.Errorf("%#v is not a regular file", sshPath))
+	}
+	offset, err := c.tailStartOffset(info.Size())
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+
+	transfer := Transfer{
+		file:          nil,
+		path:          fsPath,
+		start:         time.Now(),
+		bytesSent:     0,
+		bytesReceived: 0,
+		user:          c.connection.User,
+		connectionID:  c.connection.ID,
+		transferType:  transferDownload,
+		lastActivity:  time.Now(),
+		isNewFile:     false,
+		protocol:      c.connection.protocol,
+		backend:       vfs.GetBackendType(c.connection.fs, fsPath),
+		transferError: nil,
+		isFinished:    false,
+		lock:          new(sync.Mutex),
+	}
+	addTransfer(&transfer)
+	err = c.followFile(&transfer, fsPath, offset)
+	removeTransfer(&transfer)
+	elapsed := time.Since(transfer.start).Nanoseconds() / 1000000
+	logger.TransferLog(downloadLogSender, fsPath, elapsed, transfer.bytesSent, c.connection.User.Username,
+		c.connection.ID, c.connection.protocol)
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+	c.sendExitStatus(nil)
+	return nil
+}
+
+// followFile sends the bytes appended to the file until the client disconnects or
+// the file is removed. If the file is truncated or replaced, for example by a log rotation,
+// it is followed from the beginning
+func (c *sshCommand) followFile(transfer *Transfer, fsPath string, offset int64) error {
+	file, err := os.Open(fsPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+	}()
+	current, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 32768)
+	lastCheck := time.Now()
+	for {
+		info, err := os.Stat(fsPath)
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(info, current) {
+			c.connection.Log(logger.LevelDebug, logSenderSSH, "file %#v replaced, restart from the beginning", fsPath)
+			file.Close()
+			file, err = os.Open(fsPath)
+			if err != nil {
+				return err
+			}
+			if current, err = file.Stat(); err != nil {
+				return err
+			}
+			offset = 0
+		} else if info.Size() < offset {
+			c.connection.Log(logger.LevelDebug, logSenderSSH, "file %#v truncated, restart from the beginning", fsPath)
+			offset = 0
+		}
+		for offset < info.Size() {
+			n, err := file.ReadAt(buf, offset)
+			if n > 0 {
+				if _, errWrite := c.connection.channel.Write(buf[:n]); errWrite != nil {
+					return nil
+				}
+				offset += int64(n)
+				transfer.bytesSent += int64(n)
+				transfer.lastActivity = time.Now()
+				updateConnectionActivity(c.connection.ID)
+				lastCheck = time.Now()
+				transfer.handleThrottle()
+			}
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+		}
+		if time.Since(lastCheck) > tailKeepAliveInterval {
+			// an error means that the channel is closed
+			if _, err := c.connection.channel.SendRequest("keepalive@sftpgo", true, nil); err != nil {
+				c.connection.Log(logger.LevelDebug, logSenderSSH, "client disconnected, stop following %#v", fsPath)
+				return nil
+			}
+			lastCheck = time.Now()
+		}
+		time.Sleep(tailPollInterval)
+	}
+}
@@ -0,0 +1,731 @@
+package sftpd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/policy"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// manifest entry types
+const (
+	SyncEntryTypeFile = "file"
+	SyncEntryTypeDir  = "dir"
+)
+
+const (
+	protocolSync      = "Sync"
+	syncLogSender     = "Sync"
+	syncBufferSize    = 32768
+	syncMtimeTruncate = time.Second
+)
+
+// errors returned by the sync API
+var (
+	ErrSyncAuthentication   = errors.New("authentication failed")
+	ErrSyncPermissionDenied = errors.New("permission denied")
+	ErrSyncNotFound         = errors.New("no such file or directory")
+	ErrSyncQuotaExceeded    = errors.New("denying write due to space limit")
+)
+
+// SyncRequestError is returned if a sync API request is not valid
+type SyncRequestError struct {
+	err string
+}
+
+// Error returns the error as string
+func (e *SyncRequestError) Error() string {
+	return e.err
+}
+
+// SyncManifestEntry defines a file or a directory inside a synchronized tree
+type SyncManifestEntry struct {
+	// path relative to the synchronized directory, "/" is used as separator
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// size as bytes, always 0 for directories
+	Size int64 `json:"size"`
+	// last modification time as unix timestamp in milliseconds
+	ModTime int64 `json:"mtime"`
+	// hex encoded SHA256 digest for the file contents, if requested
+	Hash string `json:"hash,omitempty"`
+}
+
+// SyncPlan defines the operations needed to mirror a client tree on the server
+type SyncPlan struct {
+	// directories to create, parents first
+	CreateDirs []string `json:"create_dirs"`
+	// files missing on the server or with different contents
+	Upload []string `json:"upload"`
+	// files and directories not included in the client manifest, children first
+	Delete []string `json:"delete"`
+}
+
+// SyncConnection defines an authenticated session for the sync API.
+// The operations are executed using the same permission, quota and actions checks as
+// for SFTP and SCP
+type SyncConnection struct {
+	Connection
+}
+
+// NewSyncConnection authenticates the user with the given credentials and returns a session
+// for the sync API. The login is refused if the password login method is not allowed for the user
+func NewSyncConnection(username, password, remoteAddr string) (*SyncConnection, error) {
+	connectionID, err := getSyncConnectionID()
+	if err != nil {
+		return nil, err
+	}
+	if defender.isBanned(utils.GetIPFromRemoteAddress(remoteAddr)) {
+		logger.Debug(logSender, connectionID, "sync API login from banned IP address %#v refused", remoteAddr)
+		return nil, ErrSyncAuthentication
+	}
+	method := dataprovider.SSHLoginMethodPassword
+	metrics.AddLoginAttempt(method)
+	user, err := dataprovider.CheckUserAndPass(dataProvider, username, password)
+	if err == nil {
+		_, err = checkLoginPolicy(user, method, connectionID, remoteAddr, nil, nil)
+	}
+	metrics.AddLoginResult(method, err)
+	if err != nil {
+		ipAddr := utils.GetIPFromRemoteAddress(remoteAddr)
+		logger.ConnectionFailedLog(username, ipAddr, method, err.Error())
+		defender.addLoginFailure(ipAddr, err)
+		return nil, ErrSyncAuthentication
+	}
+	dataprovider.ApplyUserOverride(&user)
+	fs, err := user.GetFilesystem(connectionID)
+	if err != nil {
+		logger.Warn(syncLogSender, connectionID, "could not create filesystem for user %#v: %v", user.Username, err)
+		return nil, err
+	}
+	c := &SyncConnection{
+		Connection: Connection{
+			ID:           connectionID,
+			User:         user,
+			StartTime:    time.Now(),
+			lastActivity: time.Now(),
+			protocol:     protocolSync,
+			fs:           fs,
+		},
+	}
+	c.fs.CheckRootPath(user.Username, user.GetUID(), user.GetGID())
+	c.Log(logger.LevelDebug, syncLogSender, "user %#v authenticated for the sync API, remote addr: %#v", user.Username,
+		remoteAddr)
+	return c, nil
+}
+
+// GetManifest returns the files and the directories inside the given directory.
+// The files hashes are computed only if requested and only for the directories
+// with the download permission
+func (c *SyncConnection) GetManifest(dirPath string, withHash bool) ([]SyncManifestEntry, error) {
+	dirPath = utils.CleanSFTPPath(dirPath)
+	if !c.isOpAllowed(policy.OpList, dirPath) {
+		return nil, ErrSyncPermissionDenied
+	}
+	p, err := c.fs.ResolvePath(dirPath)
+	if err != nil {
+		return nil, c.getSyncError(err)
+	}
+	isDir, err := vfs.IsDirectory(c.fs, p)
+	if err != nil {
+		return nil, c.getSyncError(err)
+	}
+	if !isDir {
+		return nil, &SyncRequestError{err: fmt.Sprintf("%#v is not a directory", dirPath)}
+	}
+	entries := make(map[string]SyncManifestEntry)
+	err = c.walkDir(dirPath, func(virtualPath string, info os.FileInfo) error {
+		entry := SyncManifestEntry{
+			Path:    strings.TrimPrefix(virtualPath[len(dirPath):], "/"),
+			ModTime: utils.GetTimeAsMsSinceEpoch(info.ModTime()),
+		}
+		if info.IsDir() {
+			entry.Type = SyncEntryTypeDir
+		} else {
+			entry.Type = SyncEntryTypeFile
+			entry.Size = info.Size()
+			if withHash && policy.EvaluateOp(&c.User, policy.OpDownload, virtualPath).Allowed {
+				hash, err := c.getFileHash(virtualPath)
+				if err != nil {
+					return err
+				}
+				entry.Hash = hash
+			}
+		}
+		entries[entry.Path] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest := make([]SyncManifestEntry, 0, len(entries))
+	for _, entry := range entries {
+		manifest = append(manifest, entry)
+	}
+	sort.Slice(manifest, func(i, j int) bool {
+		return manifest[i].Path < manifest[j].Path
+	})
+	return manifest, nil
+}
+
+// GetSyncPlan compares the given client manifest with the contents of the given directory.
+// Files with the same size and modification time are considered unchanged, if the client
+// provides a hash the contents are compared instead
+func (c *SyncConnection) GetSyncPlan(dirPath string, clientEntries []SyncManifestEntry) (SyncPlan, error) {
+	plan := SyncPlan{
+		CreateDirs: []string{},
+		Upload:     []string{},
+		Delete:     []string{},
+	}
+	dirPath = utils.CleanSFTPPath(dirPath)
+	for idx := range clientEntries {
+		if err := validateSyncManifestEntry(&clientEntries[idx]); err != nil {
+			return plan, err
+		}
+	}
+	serverEntries, err := c.GetManifest(dirPath, false)
+	if err != nil {
+		return plan, err
+	}
+	existing := make(map[string]SyncManifestEntry)
+	for _, entry := range serverEntries {
+		existing[entry.Path] = entry
+	}
+	wanted := make(map[string]bool)
+	for _, entry := range clientEntries {
+		wanted[entry.Path] = true
+		serverEntry, ok := existing[entry.Path]
+		if ok && serverEntry.Type != entry.Type {
+			plan.Delete = append(plan.Delete, entry.Path)
+			ok = false
+		}
+		if entry.Type == SyncEntryTypeDir {
+			if !ok {
+				plan.CreateDirs = append(plan.CreateDirs, entry.Path)
+			}
+			continue
+		}
+		if !ok || c.isFileChanged(path.Join(dirPath, entry.Path), entry, serverEntry) {
+			plan.Upload = append(plan.Upload, entry.Path)
+		}
+	}
+	for _, entry := range serverEntries {
+		if !wanted[entry.Path] {
+			plan.Delete = append(plan.Delete, entry.Path)
+		}
+	}
+	sort.Strings(plan.CreateDirs)
+	sort.Strings(plan.Upload)
+	sort.Sort(sort.Reverse(sort.StringSlice(plan.Delete)))
+	return plan, nil
+}
+
+// UploadFile stores the contents read from reader inside the given file.
+// size is the expected file size, if known, otherwise -1. An upload exceeding the quota is
+// refused before creating the file if the size is known.
+// If modTime is not zero and the user has the chtimes permission the modification
+// time is set after the upload. Returns the number of bytes written
+func (c *SyncConnection) UploadFile(filePath string, reader io.Reader, size int64, modTime time.Time) (int64, error) {
+	filePath = utils.CleanSFTPPath(filePath)
+	p, err := c.fs.ResolvePath(filePath)
+	if err != nil {
+		return 0, c.getSyncError(err)
+	}
+	targetPath := p
+	if isAtomicUploadEnabled() && c.fs.IsAtomicUploadSupported() {
+		targetPath = c.fs.GetAtomicUploadPath(p)
+	}
+	isNewFile := true
+	fileSize := int64(0)
+	stat, err := c.fs.Stat(p)
+	if err == nil {
+		if stat.IsDir() {
+			c.Log(logger.LevelWarn, syncLogSender, "attempted to open a directory for writing to: %#v", p)
+			return 0, &SyncRequestError{err: fmt.Sprintf("%#v is a directory", filePath)}
+		}
+		if !c.isOpAllowed(policy.OpOverwrite, filePath) {
+			return 0, ErrSyncPermissionDenied
+		}
+		isNewFile = false
+		fileSize = stat.Size()
+	} else if c.fs.IsNotExist(err) {
+		if !c.isOpAllowed(policy.OpUpload, filePath) {
+			return 0, ErrSyncPermissionDenied
+		}
+	} else {
+		c.Log(logger.LevelError, syncLogSender, "error performing file stat %#v: %v", p, err)
+		return 0, c.getSyncError(err)
+	}
+	maxWriteSize := c.getMaxWriteSize(p, fileSize)
+	if !c.hasSpaceForPath(isNewFile, p) || maxWriteSize < 0 || (maxWriteSize > 0 && size > maxWriteSize) {
+		c.Log(logger.LevelInfo, syncLogSender, "denying file write due to space limit")
+		return 0, ErrSyncQuotaExceeded
+	}
+	transfer, err := c.getUploadTransfer(p, targetPath, isNewFile, fileSize)
+	if err != nil {
+		return 0, err
+	}
+	written, err := c.writeTransfer(transfer, reader, maxWriteSize)
+	if errClose := transfer.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return written, err
+	}
+	if !modTime.IsZero() && policy.EvaluateOp(&c.User, policy.OpChtimes, filePath).Allowed {
+		if err := c.fs.Chtimes(p, modTime, modTime); err != nil {
+			c.Log(logger.LevelDebug, syncLogSender, "unable to set the modification time for %#v: %v", filePath, err)
+		}
+	}
+	return written, nil
+}
+
+// DownloadFile writes the contents of the given file to writer and returns the number of bytes written
+func (c *SyncConnection) DownloadFile(filePath string, writer io.Writer) (int64, error) {
+	p, _, err := c.checkDownload(filePath)
+	if err != nil {
+		return 0, err
+	}
+	file, r, cancelFn, err := c.fs.Open(p)
+	if err != nil {
+		c.Log(logger.LevelWarn, syncLogSender, "could not open file %#v for reading: %v", p, err)
+		return 0, c.getSyncError(err)
+	}
+	transfer := &Transfer{
+		file:          file,
+		readerAt:      r,
+		writerAt:      nil,
+		cancelFn:      cancelFn,
+		path:          p,
+		start:         time.Now(),
+		bytesSent:     0,
+		bytesReceived: 0,
+		user:          c.User,
+		connectionID:  c.ID,
+		transferType:  transferDownload,
+		lastActivity:  time.Now(),
+		isNewFile:     false,
+		protocol:      c.protocol,
+		backend:       vfs.GetBackendType(c.fs, p),
+		transferError: nil,
+		isFinished:    false,
+		lock:          new(sync.Mutex),
+	}
+	addTransfer(transfer)
+	var written int64
+	buf := make([]byte, syncBufferSize)
+	for {
+		n, err := transfer.ReadAt(buf, written)
+		if n > 0 {
+			if _, errWrite := writer.Write(buf[:n]); errWrite != nil {
+				transfer.TransferError(errWrite)
+				err = errWrite
+			} else {
+				written += int64(n)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			if errClose := transfer.Close(); err == nil {
+				err = errClose
+			}
+			return written, err
+		}
+	}
+}
+
+// GetPresignedURL returns a short-lived signed URL to download, or upload if upload is true, the given
+// file directly from the storage backend. The same permission and file filters checks as for the streamed
+// transfers are applied. vfs.ErrPresignedURLUnsupported is returned if the filesystem does not support
+// pre-signed URLs or if the transfer must be streamed through SFTPGo to enforce the user's quota or
+// bandwidth limits: the caller should stream the transfer in this case
+func (c *SyncConnection) GetPresignedURL(filePath string, upload bool, expires time.Duration) (string, error) {
+	if !vfs.IsPresignedURLSupported(c.fs) {
+		return "", vfs.ErrPresignedURLUnsupported
+	}
+	var p string
+	var err error
+	method := http.MethodGet
+	if upload {
+		if c.User.HasQuotaRestrictions() || c.User.UploadBandwidth > 0 {
+			return "", vfs.ErrPresignedURLUnsupported
+		}
+		p, err = c.checkUpload(filePath)
+		method = http.MethodPut
+	} else {
+		if c.User.DownloadBandwidth > 0 {
+			return "", vfs.ErrPresignedURLUnsupported
+		}
+		p, _, err = c.checkDownload(filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	url, err := vfs.GetPresignedURL(c.fs, p, method, expires)
+	if err != nil {
+		c.Log(logger.LevelWarn, syncLogSender, "unable to generate a pre-signed URL for %#v: %v", p, err)
+		return "", err
+	}
+	c.Log(logger.LevelInfo, syncLogSender, "pre-signed URL generated for %#v, method: %v, expires in: %v", p, method, expires)
+	return url, nil
+}
+
+// checkDownload returns the filesystem path and the info for the given file if it can be downloaded
+func (c *SyncConnection) checkDownload(filePath string) (string, os.FileInfo, error) {
+	filePath = utils.CleanSFTPPath(filePath)
+	if !c.isOpAllowed(policy.OpDownload, filePath) {
+		return "", nil, ErrSyncPermissionDenied
+	}
+	p, err := c.fs.ResolvePath(filePath)
+	if err != nil {
+		return "", nil, c.getSyncError(err)
+	}
+	info, err := c.fs.Stat(p)
+	if err != nil {
+		return "", nil, c.getSyncError(err)
+	}
+	if info.IsDir() {
+		return "", nil, &SyncRequestError{err: fmt.Sprintf("%#v is a directory", filePath)}
+	}
+	return p, info, nil
+}
+
+// checkUpload returns the filesystem path for the given file if it can be uploaded
+func (c *SyncConnection) checkUpload(filePath string) (string, error) {
+	filePath = utils.CleanSFTPPath(filePath)
+	p, err := c.fs.ResolvePath(filePath)
+	if err != nil {
+		return "", c.getSyncError(err)
+	}
+	stat, err := c.fs.Stat(p)
+	if err == nil {
+		if stat.IsDir() {
+			return "", &SyncRequestError{err: fmt.Sprintf("%#v is a directory", filePath)}
+		}
+		if !c.isOpAllowed(policy.OpOverwrite, filePath) {
+			return "", ErrSyncPermissionDenied
+		}
+	} else if c.fs.IsNotExist(err) {
+		if !c.isOpAllowed(policy.OpUpload, filePath) {
+			return "", ErrSyncPermissionDenied
+		}
+	} else {
+		return "", c.getSyncError(err)
+	}
+	return p, nil
+}
+
+// CreateDir creates the given directory, no error is returned if the directory already exists
+func (c *SyncConnection) CreateDir(dirPath string) error {
+	dirPath = utils.CleanSFTPPath(dirPath)
+	p, err := c.fs.ResolvePath(dirPath)
+	if err != nil {
+		return c.getSyncError(err)
+	}
+	if isDir, err := vfs.IsDirectory(c.fs, p); err == nil {
+		if isDir {
+			return nil
+		}
+		return &SyncRequestError{err: fmt.Sprintf("%#v is not a directory", dirPath)}
+	}
+	if !c.isOpAllowed(policy.OpCreateDir, dirPath) {
+		return ErrSyncPermissionDenied
+	}
+	if err := c.fs.Mkdir(p); err != nil {
+		c.Log(logger.LevelWarn, syncLogSender, "error creating dir: %#v error: %v", dirPath, err)
+		return c.getSyncError(err)
+	}
+	vfs.SetPathPermissions(c.fs, p, c.User.GetUID(), c.User.GetGID())
+
+	logger.CommandLog(mkdirLogSender, p, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "")
+	return nil
+}
+
+// Remove removes the given file or empty directory
+func (c *SyncConnection) Remove(filePath string) error {
+	filePath = utils.CleanSFTPPath(filePath)
+	p, err := c.fs.ResolvePath(filePath)
+	if err != nil {
+		return c.getSyncError(err)
+	}
+	fi, err := c.fs.Lstat(p)
+	if err != nil {
+		c.Log(logger.LevelWarn, syncLogSender, "failed to remove %#v: stat error: %v", p, err)
+		return c.getSyncError(err)
+	}
+	if fi.IsDir() && fi.Mode()&os.ModeSymlink != os.ModeSymlink {
+		if !c.isOpAllowed(policy.OpDeleteDir, filePath) {
+			return ErrSyncPermissionDenied
+		}
+		if err := c.fs.Remove(p, true); err != nil {
+			c.Log(logger.LevelWarn, syncLogSender, "failed to remove directory %#v: %v", p, err)
+			return c.getSyncError(err)
+		}
+		logger.CommandLog(rmdirLogSender, p, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "")
+		return nil
+	}
+	if !c.isOpAllowed(policy.OpDeleteFile, filePath) {
+		return ErrSyncPermissionDenied
+	}
+	size := vfs.GetFileUsage(c.fs, fi)
+	if err := c.fs.Remove(p, false); err != nil {
+		c.Log(logger.LevelWarn, syncLogSender, "failed to remove a file/symlink %#v: %v", p, err)
+		return c.getSyncError(err)
+	}
+	logger.CommandLog(removeLogSender, p, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "")
+	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
+		c.updatePathQuota(p, -1, -size)
+	}
+	go executeAction(newActionNotification(c.User, operationDelete, p, "", "", fi.Size(), nil))
+	return nil
+}
+
+func (c *SyncConnection) getUploadTransfer(requestPath, filePath string, isNewFile bool, fileSize int64) (*Transfer, error) {
+	if !isNewFile && isAtomicUploadEnabled() && c.fs.IsAtomicUploadSupported() {
+		if err := c.fs.Rename(requestPath, filePath); err != nil {
+			c.Log(logger.LevelWarn, syncLogSender, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %v",
+				requestPath, filePath, err)
+			return nil, c.getSyncError(err)
+		}
+	}
+	initialSize := int64(0)
+	if !isNewFile {
+		if vfs.IsLocalOsFs(c.fs) {
+			c.updatePathQuota(requestPath, 0, -fileSize)
+		} else {
+			initialSize = fileSize
+		}
+	}
+	file, w, cancelFn, err := c.fs.Create(filePath, 0)
+	if err != nil {
+		c.Log(logger.LevelWarn, syncLogSender, "error creating file %#v: %v", requestPath, err)
+		return nil, c.getSyncError(err)
+	}
+
+	vfs.SetPathPermissions(c.fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	transfer := &Transfer{
+		file:           file,
+		writerAt:       w,
+		readerAt:       nil,
+		cancelFn:       cancelFn,
+		path:           requestPath,
+		start:          time.Now(),
+		bytesSent:      0,
+		bytesReceived:  0,
+		user:           c.User,
+		connectionID:   c.ID,
+		transferType:   transferUpload,
+		lastActivity:   time.Now(),
+		isNewFile:      isNewFile,
+		protocol:       c.protocol,
+		backend:        vfs.GetBackendType(c.fs, requestPath),
+		transferError:  nil,
+		isFinished:     false,
+		minWriteOffset: 0,
+		initialSize:    initialSize,
+		isIngestion:    c.User.IsInIngestionFolder(c.fs.GetRelativePath(requestPath)),
+		quotaFolder:    c.getQuotaFolder(requestPath),
+		lock:           new(sync.Mutex),
+	}
+	addTransfer(transfer)
+	return transfer, nil
+}
+
+// getMaxWriteSize returns the maximum allowed size for an upload to the given filesystem path, 0 means
+// unlimited and a negative value means that the quota is exceeded. The size for an overwritten file can be reused
+func (c *SyncConnection) getMaxWriteSize(fsPath string, fileSize int64) int64 {
+	var quotaSize, usedSize int64
+	if folderName := c.getQuotaFolder(fsPath); len(folderName) > 0 {
+		folder, err := dataprovider.FolderExists(dataProvider, folderName)
+		if err != nil {
+			// hasSpaceForPath already logged the error and it decides if the upload is allowed
+			return 0
+		}
+		quotaSize = folder.QuotaSize
+		usedSize = folder.UsedQuotaSize
+	} else {
+		if c.User.QuotaSize <= 0 {
+			return 0
+		}
+		_, size, err := dataprovider.GetUsedQuota(dataProvider, c.User.Username)
+		if err != nil {
+			// hasSpaceForPath already logged the error and it decides if the upload is allowed
+			return 0
+		}
+		_, pendingSize := ingestionBatch.getPendingQuota(c.User.Username)
+		quotaSize = c.User.QuotaSize
+		usedSize = size + pendingSize
+	}
+	if quotaSize <= 0 {
+		return 0
+	}
+	maxWriteSize := quotaSize - usedSize + fileSize
+	if maxWriteSize <= 0 {
+		return -1
+	}
+	return maxWriteSize
+}
+
+// writeTransfer copies the uploaded contents to the transfer, maxWriteSize 0 means unlimited
+func (c *SyncConnection) writeTransfer(transfer *Transfer, reader io.Reader, maxWriteSize int64) (int64, error) {
+	var written int64
+	buf := make([]byte, syncBufferSize)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if maxWriteSize > 0 && written+int64(n) > maxWriteSize {
+				transfer.TransferError(ErrSyncQuotaExceeded)
+				return written, ErrSyncQuotaExceeded
+			}
+			if _, errWrite := transfer.WriteAt(buf[:n], written); errWrite != nil {
+				return written, errWrite
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			transfer.TransferError(err)
+			return written, err
+		}
+	}
+}
+
+// walkDir calls walkFn for each file and directory inside dirPath, virtual folders included.
+// Symlinks and not allowed files are skipped
+func (c *SyncConnection) walkDir(dirPath string, walkFn func(virtualPath string, info os.FileInfo) error) error {
+	roots := []string{dirPath}
+	for _, v := range c.User.VirtualFolders {
+		if strings.HasPrefix(v.VirtualPath, dirPath+"/") || (dirPath == "/" && v.VirtualPath != "/") {
+			roots = append(roots, v.VirtualPath)
+		}
+	}
+	for idx, root := range roots {
+		fsRoot, err := c.fs.ResolvePath(root)
+		if err != nil {
+			return c.getSyncError(err)
+		}
+		err = c.fs.Walk(fsRoot, func(walkedPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			virtualPath := c.fs.GetRelativePath(walkedPath)
+			if virtualPath == dirPath || info.Mode()&os.ModeSymlink == os.ModeSymlink {
+				return nil
+			}
+			if !info.IsDir() && !c.User.IsFileAllowed(virtualPath) {
+				return nil
+			}
+			return walkFn(virtualPath, info)
+		})
+		if err != nil {
+			// the requested directory must exist, missing virtual folders are ignored
+			if idx > 0 && c.fs.IsNotExist(err) {
+				continue
+			}
+			c.Log(logger.LevelWarn, syncLogSender, "error walking path %#v: %v", root, err)
+			return c.getSyncError(err)
+		}
+	}
+	return nil
+}
+
+func (c *SyncConnection) isFileChanged(virtualPath string, clientEntry, serverEntry SyncManifestEntry) bool {
+	if clientEntry.Size != serverEntry.Size {
+		return true
+	}
+	if len(clientEntry.Hash) > 0 && policy.EvaluateOp(&c.User, policy.OpDownload, virtualPath).Allowed {
+		hash, err := c.getFileHash(virtualPath)
+		if err != nil {
+			c.Log(logger.LevelDebug, syncLogSender, "unable to hash file %#v: %v", virtualPath, err)
+			return true
+		}
+		return !strings.EqualFold(hash, clientEntry.Hash)
+	}
+	clientMtime := utils.GetTimeFromMsecSinceEpoch(clientEntry.ModTime).Truncate(syncMtimeTruncate)
+	serverMtime := utils.GetTimeFromMsecSinceEpoch(serverEntry.ModTime).Truncate(syncMtimeTruncate)
+	return !clientMtime.Equal(serverMtime)
+}
+
+func (c *SyncConnection) getFileHash(virtualPath string) (string, error) {
+	p, err := c.fs.ResolvePath(virtualPath)
+	if err != nil {
+		return "", c.getSyncError(err)
+	}
+	f, r, cancelFn, err := c.fs.Open(p)
+	if err != nil {
+		return "", c.getSyncError(err)
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var reader io.Reader
+	if f != nil {
+		defer f.Close()
+		reader = f
+	} else {
+		defer r.Close()
+		reader = io.NewSectionReader(r, 0, 1<<62)
+	}
+	h := sha256.New()
+	if _, err := io.CopyBuffer(h, reader, make([]byte, syncBufferSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *SyncConnection) getSyncError(err error) error {
+	if c.fs.IsNotExist(err) {
+		return ErrSyncNotFound
+	}
+	if c.fs.IsPermission(err) {
+		return ErrSyncPermissionDenied
+	}
+	return err
+}
+
+func validateSyncManifestEntry(entry *SyncManifestEntry) error {
+	if entry.Type != SyncEntryTypeFile && entry.Type != SyncEntryTypeDir {
+		return &SyncRequestError{err: fmt.Sprintf("invalid type %#v for manifest entry %#v", entry.Type, entry.Path)}
+	}
+	cleaned := path.Clean(strings.TrimPrefix(entry.Path, "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return &SyncRequestError{err: fmt.Sprintf("invalid manifest entry path %#v", entry.Path)}
+	}
+	entry.Path = cleaned
+	if entry.Type == SyncEntryTypeDir {
+		entry.Size = 0
+		entry.Hash = ""
+	} else if entry.Size < 0 {
+		return &SyncRequestError{err: fmt.Sprintf("invalid size %v for manifest entry %#v", entry.Size, entry.Path)}
+	}
+	return nil
+}
+
+func getSyncConnectionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
-	"github.com/eikenb/pipeat"
+	"github.com/drakkan/sftpgo/vfs"
 )
 
 const (
@@ -27,8 +27,8 @@ var (
 // It implements the io Reader and Writer interface to handle files downloads and uploads
 type Transfer struct {
 	file           *os.File
-	writerAt       *pipeat.PipeWriterAt
-	readerAt       *pipeat.PipeReaderAt
+	writerAt       vfs.PipeWriter
+	readerAt       vfs.PipeReader
 	cancelFn       func()
 	path           string
 	start          time.Time
@@ -40,11 +40,17 @@ type Transfer struct {
 	lastActivity   time.Time
 	isNewFile      bool
 	protocol       string
+	backend        string
 	transferError  error
 	isFinished     bool
 	minWriteOffset int64
 	expectedSize   int64
 	initialSize    int64
+	resumedSize    int64
+	isIngestion    bool
+	quotaFolder    string
+	speedState     slowTransferState
+	checksum       transferChecksum
 	lock           *sync.Mutex
 }
 
@@ -69,15 +75,12 @@ func (t *Transfer) TransferError(err error) {
 // It handles download bandwidth throttling too
 func (t *Transfer) ReadAt(p []byte, off int64) (n int, err error) {
 	t.lastActivity = time.Now()
-	var readed int
-	var e error
-	if t.readerAt != nil {
-		readed, e = t.readerAt.ReadAt(p, off)
-	} else {
-		readed, e = t.file.ReadAt(p, off)
-	}
+	readed, e := t.readWithTimeout(p, off)
 	t.lock.Lock()
 	t.bytesSent += int64(readed)
+	if isReceiptEnabled(operationDownload) {
+		t.checksum.update(p[:readed], off)
+	}
 	t.lock.Unlock()
 	if e != nil && e != io.EOF {
 		t.TransferError(e)
@@ -96,15 +99,12 @@ func (t *Transfer) WriteAt(p []byte, off int64) (n int, err error) {
 		t.TransferError(err)
 		return 0, err
 	}
-	var written int
-	var e error
-	if t.writerAt != nil {
-		written, e = t.writerAt.WriteAt(p, off)
-	} else {
-		written, e = t.file.WriteAt(p, off)
-	}
+	written, e := t.writeWithTimeout(p, off)
 	t.lock.Lock()
 	t.bytesReceived += int64(written)
+	if isReceiptEnabled(operationUpload) {
+		t.checksum.update(p[:written], off)
+	}
 	t.lock.Unlock()
 	if e != nil {
 		t.TransferError(e)
@@ -114,6 +114,64 @@ func (t *Transfer) WriteAt(p []byte, off int64) (n int, err error) {
 	return written, e
 }
 
+func (t *Transfer) read(p []byte, off int64) (int, error) {
+	if t.readerAt != nil {
+		return t.readerAt.ReadAt(p, off)
+	}
+	return t.file.ReadAt(p, off)
+}
+
+func (t *Transfer) write(p []byte, off int64) (int, error) {
+	if t.writerAt != nil {
+		// the pipe starts at the resume offset, if any
+		return t.writerAt.WriteAt(p, off-t.minWriteOffset)
+	}
+	return t.file.WriteAt(p, off)
+}
+
+// readWithTimeout reads from the underlying file respecting the read timeout configured
+// for the storage backend. A timed out read keeps running in background, so it uses a
+// private buffer: p could be reused by the caller while the read is still in progress
+func (t *Transfer) readWithTimeout(p []byte, off int64) (int, error) {
+	timeout := vfs.GetBackendTimeouts(t.backend).GetRead()
+	if timeout <= 0 {
+		return t.read(p, off)
+	}
+	buf := make([]byte, len(p))
+	var n int
+	err := vfs.RunWithTimeout(timeout, func() error {
+		var err error
+		n, err = t.read(buf, off)
+		return err
+	})
+	if err == vfs.ErrOperationTimeout {
+		return 0, err
+	}
+	copy(p, buf[:n])
+	return n, err
+}
+
+// writeWithTimeout writes to the underlying file respecting the write timeout configured
+// for the storage backend, the data are copied for the same reason as for readWithTimeout
+func (t *Transfer) writeWithTimeout(p []byte, off int64) (int, error) {
+	timeout := vfs.GetBackendTimeouts(t.backend).GetWrite()
+	if timeout <= 0 {
+		return t.write(p, off)
+	}
+	buf := make([]byte, len(p))
+	copy(buf, p)
+	var n int
+	err := vfs.RunWithTimeout(timeout, func() error {
+		var err error
+		n, err = t.write(buf, off)
+		return err
+	})
+	if err == vfs.ErrOperationTimeout {
+		return 0, err
+	}
+	return n, err
+}
+
 // Close it is called when the transfer is completed.
 // It closes the underlying file, logs the transfer info, updates the user quota (for uploads)
 // and executes any defined action.
@@ -132,9 +190,18 @@ func (t *Transfer) Close() error {
 		numFiles = 1
 	}
 	metrics.TransferCompleted(t.bytesSent, t.bytesReceived, t.transferType, t.transferError)
+	if t.transferError == nil {
+		metrics.TransferObserved(t.protocol, t.backend, t.transferType, t.bytesSent+t.bytesReceived, time.Since(t.start))
+	}
+	addTransferActivity(t.user.Username, t.transferType, time.Now())
 	if t.transferType == transferUpload && t.file != nil && t.file.Name() != t.path {
 		if t.transferError == nil || uploadMode == uploadModeAtomicWithResume {
+			// a file could be created at the target path while the upload is in progress
+			xattrs := getXattrsToPreserve(t.path, t.connectionID)
 			err = os.Rename(t.file.Name(), t.path)
+			if err == nil {
+				preserveExtendedAttributes(t.path, xattrs, t.connectionID)
+			}
 			logger.Debug(logSender, t.connectionID, "atomic upload completed, rename: %#v -> %#v, error: %v",
 				t.file.Name(), t.path, err)
 		} else {
@@ -150,11 +217,19 @@ func (t *Transfer) Close() error {
 	elapsed := time.Since(t.start).Nanoseconds() / 1000000
 	if t.transferType == transferDownload {
 		logger.TransferLog(downloadLogSender, t.path, elapsed, t.bytesSent, t.user.Username, t.connectionID, t.protocol)
-		go executeAction(newActionNotification(t.user, operationDownload, t.path, "", "", t.bytesSent, t.transferError))
+		notification := newActionNotification(t.user, operationDownload, t.path, "", "", t.bytesSent, t.transferError)
+		notification.Receipt = t.createReceipt(operationDownload, t.bytesSent)
+		go executeAction(notification)
 	} else {
 		logger.TransferLog(uploadLogSender, t.path, elapsed, t.bytesReceived, t.user.Username, t.connectionID, t.protocol)
-		go executeAction(newActionNotification(t.user, operationUpload, t.path, "", "", t.bytesReceived+t.minWriteOffset,
-			t.transferError))
+		notification := newActionNotification(t.user, operationUpload, t.path, "", "", t.bytesReceived+t.minWriteOffset,
+			t.transferError)
+		notification.Receipt = t.createReceipt(operationUpload, notification.FileSize)
+		if t.isIngestion {
+			ingestionBatch.addAction(notification)
+		} else {
+			go executeAction(notification)
+		}
 	}
 	if t.transferError != nil {
 		logger.Warn(logSender, t.connectionID, "transfer error: %v, path: %#v", t.transferError, t.path)
@@ -185,7 +260,12 @@ func (t *Transfer) updateQuota(numFiles int) bool {
 		return false
 	}
 	if t.transferType == transferUpload && (numFiles != 0 || t.bytesReceived > 0) {
-		dataprovider.UpdateUserQuota(dataProvider, t.user, numFiles, t.bytesReceived-t.initialSize, false)
+		sizeDiff := t.bytesReceived + t.resumedSize - t.initialSize
+		if t.isIngestion && len(t.quotaFolder) == 0 {
+			ingestionBatch.addQuotaUpdate(t.user, numFiles, sizeDiff)
+		} else {
+			updateQuota(t.user, t.quotaFolder, numFiles, sizeDiff)
+		}
 		return true
 	}
 	return false
@@ -260,6 +340,7 @@ func (t *Transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader, maxWrite
 	t.transferError = err
 	if t.bytesSent > 0 || t.bytesReceived > 0 || err != nil {
 		metrics.TransferCompleted(t.bytesSent, t.bytesReceived, t.transferType, t.transferError)
+		addTransferActivity(t.user.Username, t.transferType, time.Now())
 	}
 	return written, err
 }
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Create model UserTemplate
+--
+CREATE TABLE `user_templates` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `name` varchar(255) NOT NULL UNIQUE, `description` varchar(255) NULL, `template` longtext NOT NULL);
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 6;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Create model Folder
+--
+CREATE TABLE `folders` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `name` varchar(255) NOT NULL UNIQUE, `description` varchar(255) NULL, `mapped_path` varchar(512) NOT NULL, `quota_size` bigint NOT NULL, `quota_files` integer NOT NULL, `used_quota_size` bigint NOT NULL, `used_quota_files` integer NOT NULL, `last_quota_update` bigint NOT NULL);
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 7;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Add field additional_info to user
+--
+ALTER TABLE `users` ADD COLUMN `additional_info` longtext NULL;
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 8;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Add field virtual_folders to user
+--
+ALTER TABLE "users" ADD COLUMN "virtual_folders" text NULL;
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 2;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Change the password field type to text
+--
+ALTER TABLE "users" ALTER COLUMN "password" TYPE text USING "password"::text;
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 3;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Create model IPListEntry
+--
+CREATE TABLE "ip_lists" ("id" serial NOT NULL PRIMARY KEY, "ipornet" varchar(50) NOT NULL UNIQUE, "type" integer NOT NULL, "description" varchar(255) NULL);
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 4;
+COMMIT;
\ No newline at end of file
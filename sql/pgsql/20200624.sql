@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Add fields created_at, updated_at and version to user
+--
+ALTER TABLE "users" ADD COLUMN "created_at" bigint DEFAULT 0 NOT NULL, ADD COLUMN "updated_at" bigint DEFAULT 0 NOT NULL, ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 9;
+COMMIT;
\ No newline at end of file
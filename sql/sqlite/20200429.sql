@@ -0,0 +1,12 @@
+BEGIN;
+--
+-- Create model Plan and add field plan to user
+--
+CREATE TABLE "plans" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "name" varchar(255) NOT NULL UNIQUE, "description" varchar(255) NULL, "max_sessions" integer NOT NULL, "quota_size" bigint NOT NULL, "quota_files" integer NOT NULL, "upload_bandwidth" integer NOT NULL, "download_bandwidth" integer NOT NULL, "fs_providers" text NULL, "denied_login_methods" text NULL);
+ALTER TABLE "users" ADD COLUMN "plan" varchar(255) NULL;
+CREATE INDEX "users_plan_idx" ON "users" ("plan");
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 5;
+COMMIT;
\ No newline at end of file
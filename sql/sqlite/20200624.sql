@@ -0,0 +1,12 @@
+BEGIN;
+--
+-- Add fields created_at, updated_at and version to user
+--
+ALTER TABLE "users" ADD COLUMN "created_at" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "users" ADD COLUMN "updated_at" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "users" ADD COLUMN "version" bigint NOT NULL DEFAULT 0;
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 9;
+COMMIT;
\ No newline at end of file
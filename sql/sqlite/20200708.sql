@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Add field password_breach_check to plan
+--
+ALTER TABLE "plans" ADD COLUMN "password_breach_check" integer NOT NULL DEFAULT 0;
+---
+--- Update the schema version
+---
+UPDATE schema_version SET version = 10;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+// +build !sqlcipher
+
+package main
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
@@ -63,7 +63,13 @@ func GetTimeFromMsecSinceEpoch(msec int64) time.Time {
 
 // GetAppVersion returns VersionInfo struct
 func GetAppVersion() VersionInfo {
-	return versionInfo
+	featuresLock.RLock()
+	defer featuresLock.RUnlock()
+
+	v := versionInfo
+	v.Features = append([]string{}, features...)
+	v.Services = append([]string{}, services...)
+	return v
 }
 
 // GetDurationAsString returns a string representation for a time.Duration
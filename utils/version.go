@@ -1,11 +1,20 @@
 package utils
 
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
 const version = "0.9.6-dev"
 
 var (
-	commit      = ""
-	date        = ""
-	versionInfo VersionInfo
+	commit       = ""
+	date         = ""
+	versionInfo  VersionInfo
+	featuresLock sync.RWMutex
+	features     []string
+	services     []string
 )
 
 // VersionInfo defines version details
@@ -13,6 +22,10 @@ type VersionInfo struct {
 	Version    string `json:"version"`
 	BuildDate  string `json:"build_date"`
 	CommitHash string `json:"commit_hash"`
+	// Features compiled inside the binary, for example "+sqlite" or "+s3"
+	Features []string `json:"features"`
+	// Services enabled at runtime, for example "sftp" or "grpc". Empty if the services are not started
+	Services []string `json:"services"`
 }
 
 // GetVersionAsString returns the string representation of the VersionInfo struct
@@ -27,6 +40,31 @@ func (v *VersionInfo) GetVersionAsString() string {
 	return versionString
 }
 
+// GetFeaturesAsString returns the compiled-in features as a space separated string
+func (v *VersionInfo) GetFeaturesAsString() string {
+	return strings.Join(v.Features, " ")
+}
+
+// AddFeature adds a feature compiled inside the binary, it is called from the init functions
+// of the packages that implement optional features
+func AddFeature(feature string) {
+	featuresLock.Lock()
+	defer featuresLock.Unlock()
+
+	if !IsStringInSlice(feature, features) {
+		features = append(features, feature)
+		sort.Strings(features)
+	}
+}
+
+// SetEnabledServices sets the services enabled at runtime
+func SetEnabledServices(enabled []string) {
+	featuresLock.Lock()
+	defer featuresLock.Unlock()
+
+	services = append([]string(nil), enabled...)
+}
+
 func init() {
 	versionInfo = VersionInfo{
 		Version:    version,
@@ -0,0 +1,17 @@
+// +build !windows
+
+package vfs
+
+// Windows ACLs are not supported on this platform, the configuration is ignored
+
+func applyWindowsACLConfig(config WindowsACLConfig) error {
+	return nil
+}
+
+func setPathOwnership(name string) error {
+	return nil
+}
+
+func checkDenyACL(name string, accessMask uint32) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	accessDeniedACEType = 0x1
+	aceInheritOnly      = 0x8
+	// sid offset inside an ACCESS_DENIED_ACE: ACE_HEADER (4 bytes) + ACCESS_MASK (4 bytes)
+	aceSidOffset = 8
+	everyoneSID  = "S-1-1-0"
+)
+
+type aclHeader struct {
+	aclRevision byte
+	sbz1        byte
+	aclSize     uint16
+	aceCount    uint16
+	sbz2        uint16
+}
+
+type aceHeader struct {
+	aceType  byte
+	aceFlags byte
+	aceSize  uint16
+}
+
+var (
+	ownerSID    *windows.SID
+	groupSID    *windows.SID
+	everyoneSid *windows.SID
+)
+
+func applyWindowsACLConfig(config WindowsACLConfig) error {
+	var err error
+	ownerSID, err = getSID(config.Owner)
+	if err != nil {
+		return fmt.Errorf("invalid owner %#v: %v", config.Owner, err)
+	}
+	groupSID, err = getSID(config.Group)
+	if err != nil {
+		return fmt.Errorf("invalid group %#v: %v", config.Group, err)
+	}
+	everyoneSid, err = windows.StringToSid(everyoneSID)
+	return err
+}
+
+// getSID returns the SID for the given account name or string SID.
+// A nil SID is returned for an empty account
+func getSID(account string) (*windows.SID, error) {
+	if len(account) == 0 {
+		return nil, nil
+	}
+	if sid, err := windows.StringToSid(account); err == nil {
+		return sid, nil
+	}
+	sid, _, _, err := windows.LookupSID("", account)
+	return sid, err
+}
+
+func setPathOwnership(name string) error {
+	var secInfo windows.SECURITY_INFORMATION
+	if ownerSID != nil {
+		secInfo |= windows.OWNER_SECURITY_INFORMATION
+	}
+	if groupSID != nil {
+		secInfo |= windows.GROUP_SECURITY_INFORMATION
+	}
+	if secInfo == 0 {
+		return nil
+	}
+	return windows.SetNamedSecurityInfo(name, windows.SE_FILE_OBJECT, secInfo, ownerSID, groupSID, nil, nil)
+}
+
+// checkDenyACL returns a permission error if the DACL for the given path, or for its
+// nearest existing parent directory, contains an access denied entry, matching the
+// requested access mask, for the configured owner, the configured group or "Everyone"
+func checkDenyACL(name string, accessMask uint32) error {
+	if !windowsACLConfig.HonorDenyACLs {
+		return nil
+	}
+	p := name
+	for {
+		if _, err := os.Lstat(p); err == nil {
+			break
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return nil
+		}
+		p = parent
+	}
+	sd, err := windows.GetNamedSecurityInfo(p, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil || dacl == nil {
+		// no DACL means full access
+		return nil
+	}
+	header := (*aclHeader)(unsafe.Pointer(dacl))
+	offset := unsafe.Sizeof(*header)
+	for i := uint16(0); i < header.aceCount; i++ {
+		ace := (*aceHeader)(unsafe.Pointer(uintptr(unsafe.Pointer(dacl)) + offset))
+		if ace.aceType == accessDeniedACEType && ace.aceFlags&aceInheritOnly == 0 {
+			mask := *(*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(ace)) + 4))
+			sid := (*windows.SID)(unsafe.Pointer(uintptr(unsafe.Pointer(ace)) + aceSidOffset))
+			if mask&expandGenericAccess(accessMask) != 0 && isDeniedSID(sid) {
+				return &os.PathError{Op: "access", Path: name, Err: os.ErrPermission}
+			}
+		}
+		offset += uintptr(ace.aceSize)
+	}
+	return nil
+}
+
+func isDeniedSID(sid *windows.SID) bool {
+	for _, s := range []*windows.SID{ownerSID, groupSID, everyoneSid} {
+		if s != nil && windows.EqualSid(s, sid) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandGenericAccess adds the generic rights that include the requested specific ones
+func expandGenericAccess(accessMask uint32) uint32 {
+	mask := accessMask | windows.GENERIC_ALL
+	if accessMask&fileReadData != 0 {
+		mask |= windows.GENERIC_READ
+	}
+	if accessMask&(fileWriteData|fileAppendData) != 0 {
+		mask |= windows.GENERIC_WRITE
+	}
+	return mask
+}
@@ -0,0 +1,426 @@
+package vfs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// cryptFsName is the name for the local encrypted Fs implementation
+	cryptFsName = "cryptfs"
+	// encrypted files starts with a header containing the format version, the KDF parameters
+	// and salt used to derive the master key from the user passphrase and the salt used to
+	// derive the file key from the master key
+	cryptFormatVersion = 2
+	// the master key is derived using argon2id, the parameters are stored inside each header
+	// so they can be changed without breaking the existing files
+	cryptKDFArgon2ID = 1
+	cryptKDFTime     = 3
+	cryptKDFMemory   = 64 * 1024
+	cryptKDFThreads  = 4
+	cryptKDFSaltSize = 16
+	// KDF identifier, time (uint32), memory in KiB (uint32), threads (uint8) and salt
+	cryptKDFParamsSize = 1 + 4 + 4 + 1 + cryptKDFSaltSize
+	// limits for the KDF parameters read from the headers
+	cryptKDFMaxTime   = 16
+	cryptKDFMaxMemory = 1024 * 1024
+	cryptSaltSize     = 32
+	cryptHeaderSize   = 1 + cryptKDFParamsSize + cryptSaltSize
+	// the file contents are encrypted using AES-256-GCM in chunks of cryptChunkSize bytes,
+	// each encrypted chunk includes the authentication tag
+	cryptChunkSize          = 65536
+	cryptTagSize            = 16
+	cryptEncryptedChunkSize = cryptChunkSize + cryptTagSize
+	cryptUploadPrefix       = ".sftpgo-crypt."
+	// uploads can write up to cryptUploadMaxAhead bytes after the data already encrypted,
+	// the writes beyond this limit wait for the encryption
+	cryptUploadMaxAhead = 8 * 1024 * 1024
+)
+
+var errInvalidEncryptedFile = errors.New("invalid or corrupted encrypted file")
+
+// CryptFsConfig defines the configuration for the local encrypted filesystem
+type CryptFsConfig struct {
+	// passphrase used to derive the encryption key for each file. It is stored encrypted
+	// inside the data provider
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// CryptFs is a Fs implementation that stores the files inside the local filesystem
+// encrypting their contents using a key derived from the configured passphrase.
+// File and directory names are not encrypted
+type CryptFs struct {
+	*OsFs
+	passphrase []byte
+	keys       *cryptMasterKeys
+}
+
+// cryptMasterKeys caches the master keys derived from the passphrase, the derivation
+// is intentionally expensive
+type cryptMasterKeys struct {
+	sync.Mutex
+	// KDF parameters and salt for the new files, the salt is generated for each Fs
+	params []byte
+	// derived master keys by KDF parameters and salt
+	keys map[string][]byte
+}
+
+func init() {
+	utils.AddFeature("+crypt")
+}
+
+// NewCryptFs returns a CryptFs object that allows to interact with an encrypted local filesystem
+func NewCryptFs(connectionID, rootDir string, config CryptFsConfig) (Fs, error) {
+	if err := ValidateCryptFsConfig(&config); err != nil {
+		return nil, err
+	}
+	passphrase := config.Passphrase
+	if kms.IsEncrypted(passphrase) {
+		var err error
+		passphrase, err = kms.Decrypt(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	params := make([]byte, cryptKDFParamsSize)
+	params[0] = cryptKDFArgon2ID
+	binary.BigEndian.PutUint32(params[1:], cryptKDFTime)
+	binary.BigEndian.PutUint32(params[5:], cryptKDFMemory)
+	params[9] = cryptKDFThreads
+	if _, err := io.ReadFull(rand.Reader, params[10:]); err != nil {
+		return nil, err
+	}
+	return &CryptFs{
+		OsFs: &OsFs{
+			name:         cryptFsName,
+			connectionID: connectionID,
+			rootDir:      rootDir,
+		},
+		passphrase: []byte(passphrase),
+		keys: &cryptMasterKeys{
+			params: params,
+			keys:   make(map[string][]byte),
+		},
+	}, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs CryptFs) Name() string {
+	return cryptFsName
+}
+
+// Stat returns a FileInfo describing the named file, the size is the decrypted one
+func (fs CryptFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.OsFs.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return newCryptFsFileInfo(info), nil
+}
+
+// Lstat returns a FileInfo describing the named file, the size is the decrypted one
+func (fs CryptFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.OsFs.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return newCryptFsFileInfo(info), nil
+}
+
+// Open opens the named file for reading, the chunks are decrypted in memory when they
+// are read, so the plain text never reaches the disk
+func (fs CryptFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	if err := checkDenyACL(name, fileReadData); err != nil {
+		return nil, nil, nil, err
+	}
+	f, err := openWithTimeout(fsTimeouts.Local.GetOpen(), func() (*os.File, error) {
+		return openLocalFile(name, os.O_RDONLY, 0)
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, err := fs.newCryptReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return nil, r, nil, nil
+}
+
+// Create creates or opens the named file for writing. The contents are encrypted and
+// written to a temporary file that replaces the named one when the upload completes,
+// so readers never see a partial file. The temporary file is hidden from the listings.
+// The plain text is buffered in memory until it is encrypted
+func (fs CryptFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	if err := checkDenyACL(name, fileWriteData); err != nil {
+		return nil, nil, nil, err
+	}
+	tempName := filepath.Join(filepath.Dir(name), cryptUploadPrefix+xid.New().String()+"."+filepath.Base(name))
+	f, err := openWithTimeout(fsTimeouts.Local.GetOpen(), func() (*os.File, error) {
+		return openLocalFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := newCryptPipe()
+	ctx, ctxCancelFn := context.WithCancel(context.Background())
+	cancelFn := func() {
+		ctxCancelFn()
+		p.abort(ctx.Err())
+	}
+	go func() {
+		defer ctxCancelFn()
+		n, err := fs.encrypt(ctx, f, p)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			// the upload was canceled after the last chunk was received
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = renameLocal(tempName, name)
+		}
+		if err != nil {
+			os.Remove(tempName)
+		}
+		p.closeRead(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %v", name, n, err)
+	}()
+	return nil, p, cancelFn, nil
+}
+
+// Truncate changes the size of the named file.
+// Only truncating to 0 bytes is supported for encrypted files
+func (fs CryptFs) Truncate(name string, size int64) error {
+	if size != 0 {
+		return fmt.Errorf("truncate to %v bytes is not supported for encrypted files", size)
+	}
+	return fs.OsFs.Truncate(name, size)
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries, the sizes are the decrypted ones.
+// The temporary files for the uploads in progress are not listed
+func (fs CryptFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	list, err := fs.OsFs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]os.FileInfo, 0, len(list))
+	for _, info := range list {
+		if isCryptUploadTempFile(info) {
+			continue
+		}
+		result = append(result, newCryptFsFileInfo(info))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns true if upload resume is supported.
+// Upload resume is not supported for encrypted files
+func (CryptFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Uploads are always atomic for encrypted files, the temporary file is handled
+// inside Create
+func (CryptFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their decrypted size
+func (fs CryptFs) ScanRootDirContents() (int, int64, error) {
+	return fs.scanRootDirContents(nil)
+}
+
+// scanRootDirContents overrides the embedded OsFs one, the decrypted sizes are counted
+func (fs CryptFs) scanRootDirContents(progress *ScanProgress) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	err := filepath.Walk(fs.rootDir, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && !isCryptUploadTempFile(info) {
+			fileSize := getCryptDecryptedSize(info.Size())
+			numFiles++
+			size += fileSize
+			progress.add(1, fileSize)
+		}
+		return nil
+	})
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, the sizes are the decrypted ones.
+// The temporary files for the uploads in progress are skipped
+func (CryptFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if info != nil {
+			if isCryptUploadTempFile(info) {
+				return nil
+			}
+			info = newCryptFsFileInfo(info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// ValidateCryptFsConfig returns nil if the specified encrypted filesystem config is valid, otherwise an error
+func ValidateCryptFsConfig(config *CryptFsConfig) error {
+	if len(config.Passphrase) == 0 {
+		return errors.New("passphrase cannot be empty")
+	}
+	return nil
+}
+
+// getMasterKey returns the master key derived from the passphrase using the given KDF parameters
+// and salt. The key is derived once for each Fs and cached
+func (fs CryptFs) getMasterKey(params []byte) ([]byte, error) {
+	fs.keys.Lock()
+	defer fs.keys.Unlock()
+
+	if key, ok := fs.keys.keys[string(params)]; ok {
+		return key, nil
+	}
+	time := binary.BigEndian.Uint32(params[1:])
+	memory := binary.BigEndian.Uint32(params[5:])
+	threads := params[9]
+	if params[0] != cryptKDFArgon2ID || time == 0 || time > cryptKDFMaxTime || memory == 0 ||
+		memory > cryptKDFMaxMemory || threads == 0 {
+		return nil, errInvalidEncryptedFile
+	}
+	key := argon2.IDKey(fs.passphrase, params[10:], time, memory, threads, 32)
+	fs.keys.keys[string(params)] = key
+	return key, nil
+}
+
+// getAEAD returns the cipher for the file with the given header. The file key is derived
+// from the master key and the file salt
+func (fs CryptFs) getAEAD(header []byte) (cipher.AEAD, error) {
+	masterKey, err := fs.getMasterKey(header[1 : 1+cryptKDFParamsSize])
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	salt := header[1+cryptKDFParamsSize:]
+	if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, salt, []byte(cryptFsName)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt reads the plain text from src and writes the encrypted contents to dst.
+// It returns the number of plain text bytes
+func (fs CryptFs) encrypt(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	header := make([]byte, cryptHeaderSize)
+	header[0] = cryptFormatVersion
+	copy(header[1:], fs.keys.params)
+	if _, err := io.ReadFull(rand.Reader, header[1+cryptKDFParamsSize:]); err != nil {
+		return 0, err
+	}
+	aead, err := fs.getAEAD(header)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = dst.Write(header); err != nil {
+		return 0, err
+	}
+	var written int64
+	var seq uint64
+	buf := make([]byte, cryptChunkSize, cryptEncryptedChunkSize)
+	for {
+		if err = ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := io.ReadFull(src, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+		// the last chunk is always shorter than cryptChunkSize, it can be empty
+		isLast := n < cryptChunkSize
+		sealed := aead.Seal(buf[:0], getCryptNonce(seq, isLast), buf[:n], nil)
+		if _, err = dst.Write(sealed); err != nil {
+			return written, err
+		}
+		written += int64(n)
+		if isLast {
+			return written, nil
+		}
+		seq++
+		buf = buf[:cryptChunkSize]
+	}
+}
+
+// getCryptNonce returns the nonce for the chunk with the given sequence number.
+// The last chunk uses a different nonce so a truncated file cannot be decrypted
+func getCryptNonce(seq uint64, isLast bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, seq)
+	if isLast {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// getCryptDecryptedSize returns the plain text size for an encrypted file of the given size
+func getCryptDecryptedSize(size int64) int64 {
+	if size <= cryptHeaderSize {
+		return 0
+	}
+	size -= cryptHeaderSize
+	lastChunkSize := size % cryptEncryptedChunkSize
+	if lastChunkSize < cryptTagSize {
+		// invalid size, the file is corrupted
+		return 0
+	}
+	return (size/cryptEncryptedChunkSize)*cryptChunkSize + lastChunkSize - cryptTagSize
+}
+
+// isCryptUploadTempFile returns true if info is the temporary file for an upload in progress
+func isCryptUploadTempFile(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && strings.HasPrefix(info.Name(), cryptUploadPrefix)
+}
+
+// cryptFsFileInfo reports the decrypted size for regular files
+type cryptFsFileInfo struct {
+	os.FileInfo
+}
+
+func newCryptFsFileInfo(info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+	return cryptFsFileInfo{FileInfo: info}
+}
+
+// Size returns the decrypted size
+func (fi cryptFsFileInfo) Size() int64 {
+	return getCryptDecryptedSize(fi.FileInfo.Size())
+}
@@ -0,0 +1,273 @@
+package vfs
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// cryptReader decrypts the chunks of an encrypted file when they are read.
+// Any offset can be read and the plain text is never written to disk
+type cryptReader struct {
+	f    *os.File
+	aead cipher.AEAD
+	// size of the encrypted chunks, the header is excluded
+	size int64
+	// offset for Read
+	offset int64
+	// the last decrypted chunk, SFTP reads are usually smaller than a chunk
+	mu         sync.Mutex
+	chunkIdx   int64
+	chunkPlain []byte
+}
+
+func (fs CryptFs) newCryptReader(f *os.File) (*cryptReader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &cryptReader{
+		f:        f,
+		chunkIdx: -1,
+	}
+	if info.Size() == 0 {
+		// an empty file, for example a truncated one
+		return r, nil
+	}
+	header := make([]byte, cryptHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || header[0] != cryptFormatVersion {
+		return nil, errInvalidEncryptedFile
+	}
+	r.aead, err = fs.getAEAD(header)
+	if err != nil {
+		return nil, err
+	}
+	r.size = info.Size() - cryptHeaderSize
+	return r, nil
+}
+
+// ReadAt reads len(p) plain text bytes starting at offset off
+func (r *cryptReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset %v", off)
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		plain, err := r.readChunk(pos / cryptChunkSize)
+		if err != nil {
+			return n, err
+		}
+		chunkOffset := int(pos % cryptChunkSize)
+		if chunkOffset >= len(plain) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], plain[chunkOffset:])
+	}
+	return n, nil
+}
+
+// Read reads the plain text sequentially
+func (r *cryptReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Close closes the encrypted file
+func (r *cryptReader) Close() error {
+	return r.f.Close()
+}
+
+// readChunk returns the plain text for the chunk with the given index.
+// The last chunk is authenticated with a different nonce, so a truncated
+// file cannot be decrypted
+func (r *cryptReader) readChunk(idx int64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if idx == r.chunkIdx {
+		return r.chunkPlain, nil
+	}
+	if r.aead == nil {
+		return nil, io.EOF
+	}
+	lastIdx := r.size / cryptEncryptedChunkSize
+	lastChunkSize := r.size % cryptEncryptedChunkSize
+	if lastChunkSize < cryptTagSize {
+		// the last chunk is missing
+		return nil, errInvalidEncryptedFile
+	}
+	if idx > lastIdx {
+		return nil, io.EOF
+	}
+	isLast := idx == lastIdx
+	buf := make([]byte, cryptEncryptedChunkSize)
+	if isLast {
+		buf = buf[:lastChunkSize]
+	}
+	n, err := r.f.ReadAt(buf, cryptHeaderSize+idx*cryptEncryptedChunkSize)
+	if n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = errInvalidEncryptedFile
+		}
+		return nil, err
+	}
+	plain, err := r.aead.Open(buf[:0], getCryptNonce(uint64(idx), isLast), buf, nil)
+	if err != nil {
+		return nil, errInvalidEncryptedFile
+	}
+	r.chunkIdx = idx
+	r.chunkPlain = plain
+	return plain, nil
+}
+
+// cryptPipe connects the uploads to the encryption goroutine without temporary files.
+// The plain text can be written at any offset and it is buffered in memory until the
+// encryption goroutine reads it sequentially. Writes starting more than
+// cryptUploadMaxAhead bytes after the read offset wait for the reader
+type cryptPipe struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	chunks      map[int64][]byte
+	readOffset  int64
+	writeOffset int64
+	// set when the writer is closed
+	writeErr error
+	// set when the upload is canceled
+	abortErr error
+	// set when the encryption goroutine is done
+	readErr   error
+	uploadErr error
+	done      chan struct{}
+}
+
+func newCryptPipe() *cryptPipe {
+	p := &cryptPipe{
+		chunks: make(map[int64][]byte),
+		done:   make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// WriteAt buffers a copy of p to be encrypted
+func (p *cryptPipe) WriteAt(b []byte, off int64) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for off > p.readOffset && off+int64(len(b)) > p.readOffset+cryptUploadMaxAhead && p.getError() == nil {
+		p.cond.Wait()
+	}
+	if err := p.getError(); err != nil {
+		return 0, err
+	}
+	if p.writeErr != nil {
+		return 0, io.ErrClosedPipe
+	}
+	if off < p.readOffset {
+		if off+int64(len(b)) <= p.readOffset {
+			return 0, fmt.Errorf("cannot write at offset %v, the data up to %v are already encrypted", off, p.readOffset)
+		}
+		// the first part overlaps the data already encrypted
+		skip := p.readOffset - off
+		p.chunks[p.readOffset] = append([]byte(nil), b[skip:]...)
+	} else {
+		p.chunks[off] = append([]byte(nil), b...)
+	}
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+// Write buffers a copy of p after the previously written data
+func (p *cryptPipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	off := p.writeOffset
+	p.writeOffset += int64(len(b))
+	p.mu.Unlock()
+
+	return p.WriteAt(b, off)
+}
+
+// Close signals that all the data are written and waits for the encryption
+// goroutine, the upload error, if any, is returned
+func (p *cryptPipe) Close() error {
+	p.mu.Lock()
+	if p.writeErr == nil {
+		p.writeErr = io.EOF
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	<-p.done
+	return p.uploadErr
+}
+
+// Read returns the buffered data sequentially, it is used by the encryption goroutine
+func (p *cryptPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.abortErr != nil {
+			return 0, p.abortErr
+		}
+		if data, ok := p.chunks[p.readOffset]; ok {
+			n := copy(b, data)
+			delete(p.chunks, p.readOffset)
+			if n < len(data) {
+				p.chunks[p.readOffset+int64(n)] = data[n:]
+			}
+			p.readOffset += int64(n)
+			p.cond.Broadcast()
+			return n, nil
+		}
+		if p.writeErr != nil {
+			if len(p.chunks) > 0 {
+				return 0, errors.New("incomplete upload, some data are missing")
+			}
+			return 0, p.writeErr
+		}
+		p.cond.Wait()
+	}
+}
+
+// abort cancels the upload, the pending reads and writes return err
+func (p *cryptPipe) abort(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.abortErr == nil {
+		p.abortErr = err
+	}
+	p.cond.Broadcast()
+}
+
+// closeRead is called by the encryption goroutine when it is done, the buffered
+// data are released and the writers are unblocked
+func (p *cryptPipe) closeRead(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.uploadErr = err
+	p.readErr = err
+	if p.readErr == nil {
+		p.readErr = io.ErrClosedPipe
+	}
+	p.chunks = nil
+	p.cond.Broadcast()
+	close(p.done)
+}
+
+func (p *cryptPipe) getError() error {
+	if p.abortErr != nil {
+		return p.abortErr
+	}
+	return p.readErr
+}
@@ -0,0 +1,6 @@
+package vfs
+
+import "syscall"
+
+// flag to open files bypassing the page cache
+const directIOFlag = syscall.O_DIRECT
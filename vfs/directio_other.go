@@ -0,0 +1,6 @@
+// +build !linux
+
+package vfs
+
+// direct I/O is not supported on this platform
+const directIOFlag = 0
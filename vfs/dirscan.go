@@ -0,0 +1,168 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// number of directory entries to read for each batch
+const dirScanBatchSize = 1024
+
+var quotaScanWorkers int
+
+// SetQuotaScanWorkers sets the maximum number of directories that can be scanned
+// concurrently while computing the used quota for the local filesystem.
+// 0 means the number of the available CPUs, 1 disables concurrent scans
+func SetQuotaScanWorkers(workers int) {
+	quotaScanWorkers = workers
+}
+
+func getQuotaScanWorkers() int {
+	if quotaScanWorkers > 0 {
+		return quotaScanWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// ScanProgress tracks the files and the bytes counted by a running quota scan.
+// It is safe for concurrent use and a nil ScanProgress ignores the updates
+type ScanProgress struct {
+	numFiles      int64
+	size          int64
+	expectedFiles int64
+	expectedSize  int64
+}
+
+// SetExpected sets the number of files and the size the scan is expected to find,
+// usually the previously tracked used quota. They are used to estimate the remaining time
+func (p *ScanProgress) SetExpected(numFiles int, size int64) {
+	if p == nil {
+		return
+	}
+	atomic.StoreInt64(&p.expectedFiles, int64(numFiles))
+	atomic.StoreInt64(&p.expectedSize, size)
+}
+
+// GetScanned returns the number of files and the bytes counted so far
+func (p *ScanProgress) GetScanned() (int, int64) {
+	if p == nil {
+		return 0, 0
+	}
+	return int(atomic.LoadInt64(&p.numFiles)), atomic.LoadInt64(&p.size)
+}
+
+// GetExpected returns the number of files and the size the scan is expected to find
+func (p *ScanProgress) GetExpected() (int, int64) {
+	if p == nil {
+		return 0, 0
+	}
+	return int(atomic.LoadInt64(&p.expectedFiles)), atomic.LoadInt64(&p.expectedSize)
+}
+
+func (p *ScanProgress) add(numFiles int, size int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.numFiles, int64(numFiles))
+	atomic.AddInt64(&p.size, size)
+}
+
+// progressScanner is implemented by the filesystems able to report the files counted
+// while the quota scan is running
+type progressScanner interface {
+	scanRootDirContents(progress *ScanProgress) (int, int64, error)
+}
+
+// ScanRootDirContentsWithProgress is like fs.ScanRootDirContents but it also updates the given
+// progress. The filesystems that cannot report partial results update it when the scan ends
+func ScanRootDirContentsWithProgress(fs Fs, progress *ScanProgress) (int, int64, error) {
+	if scanner, ok := fs.(progressScanner); ok {
+		return scanner.scanRootDirContents(progress)
+	}
+	numFiles, size, err := fs.ScanRootDirContents()
+	if err == nil {
+		progress.add(numFiles, size)
+	}
+	return numFiles, size, err
+}
+
+// dirScanner computes the number of regular files and their size inside a
+// directory tree. Sub directories are scanned in new goroutines while the
+// configured number of workers is not exceeded, inline otherwise
+type dirScanner struct {
+	numFiles  int64
+	size      int64
+	workers   chan struct{}
+	wg        sync.WaitGroup
+	errOnce   sync.Once
+	err       error
+	isStopped int32
+	progress  *ScanProgress
+}
+
+func newDirScanner(workers int, progress *ScanProgress) *dirScanner {
+	return &dirScanner{
+		// the calling goroutine is a worker too
+		workers:  make(chan struct{}, workers-1),
+		progress: progress,
+	}
+}
+
+func (s *dirScanner) scan(root string) (int, int64, error) {
+	s.scanDir(root)
+	s.wg.Wait()
+	return int(atomic.LoadInt64(&s.numFiles)), atomic.LoadInt64(&s.size), s.err
+}
+
+func (s *dirScanner) setError(err error) {
+	s.errOnce.Do(func() {
+		s.err = err
+		atomic.StoreInt32(&s.isStopped, 1)
+	})
+}
+
+func (s *dirScanner) scanDir(dirname string) {
+	if atomic.LoadInt32(&s.isStopped) == 1 {
+		return
+	}
+	var subDirs []string
+	err := readDirEntries(dirname, func(name string, isDir, isRegular bool, usage int64) {
+		if isRegular {
+			atomic.AddInt64(&s.numFiles, 1)
+			atomic.AddInt64(&s.size, usage)
+			s.progress.add(1, usage)
+		} else if isDir {
+			subDirs = append(subDirs, filepath.Join(dirname, name))
+		}
+	})
+	if err != nil {
+		s.setError(err)
+		return
+	}
+	for _, dir := range subDirs {
+		select {
+		case s.workers <- struct{}{}:
+			s.wg.Add(1)
+			go func(dir string) {
+				defer func() {
+					<-s.workers
+					s.wg.Done()
+				}()
+				s.scanDir(dir)
+			}(dir)
+		default:
+			s.scanDir(dir)
+		}
+	}
+}
+
+// getLocalFileUsage returns the size to use for quota accounting for a local file
+func getLocalFileUsage(info os.FileInfo) int64 {
+	if useAllocatedSize {
+		return getAllocatedSize(info)
+	}
+	return info.Size()
+}
@@ -0,0 +1,74 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// size of the buffer used to read directory entries using getdents
+const direntBufferSize = 64 * 1024
+
+// readDirEntries calls fn for each entry inside dirname, symlinks are not followed.
+// Entries removed while reading the directory are skipped.
+// Directory entries are read in batches using getdents and each entry is
+// stat'ed relative to the directory file descriptor, this avoids to resolve
+// the full path for each entry
+func readDirEntries(dirname string, fn func(name string, isDir, isRegular bool, usage int64)) error {
+	fd, err := openDir(dirname)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: dirname, Err: err}
+	}
+	defer unix.Close(fd)
+
+	buf := make([]byte, direntBufferSize)
+	names := make([]string, 0, dirScanBatchSize)
+	for {
+		n, err := unix.ReadDirent(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return &os.PathError{Op: "readdirent", Path: dirname, Err: err}
+		}
+		if n <= 0 {
+			return nil
+		}
+		_, _, names = unix.ParseDirent(buf[:n], -1, names[:0])
+		for _, name := range names {
+			var stat unix.Stat_t
+			err = fstatat(fd, name, &stat)
+			if err == unix.ENOENT {
+				continue
+			}
+			if err != nil {
+				return &os.PathError{Op: "lstat", Path: filepath.Join(dirname, name), Err: err}
+			}
+			usage := int64(stat.Size)
+			if useAllocatedSize {
+				usage = int64(stat.Blocks) * 512
+			}
+			fileType := stat.Mode & unix.S_IFMT
+			fn(name, fileType == unix.S_IFDIR, fileType == unix.S_IFREG, usage)
+		}
+	}
+}
+
+func openDir(dirname string) (int, error) {
+	for {
+		fd, err := unix.Open(dirname, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != unix.EINTR {
+			return fd, err
+		}
+	}
+}
+
+func fstatat(dirfd int, name string, stat *unix.Stat_t) error {
+	for {
+		err := unix.Fstatat(dirfd, name, stat, unix.AT_SYMLINK_NOFOLLOW)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
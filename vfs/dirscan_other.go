@@ -0,0 +1,30 @@
+// +build !linux
+
+package vfs
+
+import (
+	"io"
+	"os"
+)
+
+// readDirEntries calls fn for each entry inside dirname, symlinks are not followed.
+// Entries removed while reading the directory are skipped
+func readDirEntries(dirname string, fn func(name string, isDir, isRegular bool, usage int64)) error {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		entries, err := f.Readdir(dirScanBatchSize)
+		for _, info := range entries {
+			fn(info.Name(), info.IsDir(), info.Mode().IsRegular(), getLocalFileUsage(info))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
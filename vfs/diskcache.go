@@ -0,0 +1,410 @@
+package vfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/eikenb/pipeat"
+)
+
+const (
+	diskCacheLogSender = "diskCache"
+	// the partially written files are stored inside this sub directory
+	diskCacheTempDir = "tmp"
+)
+
+var (
+	cloudDiskCache       *diskCache
+	errDiskCacheTooLarge = errors.New("the file is too large to be cached")
+)
+
+// DiskCacheConfig defines the local disk cache for the files stored on the cloud
+// storage backends, S3 and GCS
+type DiskCacheConfig struct {
+	// Path to the cache directory, empty to disable the cache
+	Path string `json:"path" mapstructure:"path"`
+	// Maximum cache size as MB, the least recently used files are removed
+	// to stay within this limit
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Maximum size, as MB, for a cached file. 0 means the files are limited by the max cache size only
+	MaxFileSize int64 `json:"max_file_size" mapstructure:"max_file_size"`
+}
+
+// SetDiskCacheConfig enables the local disk cache for the cloud storage backends.
+// The files already cached inside the configured directory are reused
+func SetDiskCacheConfig(config DiskCacheConfig) error {
+	cloudDiskCache = nil
+	if len(config.Path) == 0 {
+		return nil
+	}
+	if !filepath.IsAbs(config.Path) {
+		return fmt.Errorf("invalid disk cache path %#v, it must be absolute", config.Path)
+	}
+	if config.MaxSize <= 0 {
+		return fmt.Errorf("invalid disk cache max size: %v", config.MaxSize)
+	}
+	if config.MaxFileSize < 0 || config.MaxFileSize > config.MaxSize {
+		return fmt.Errorf("invalid disk cache max file size: %v", config.MaxFileSize)
+	}
+	cache, err := newDiskCache(config)
+	if err != nil {
+		return err
+	}
+	cloudDiskCache = cache
+	return nil
+}
+
+type diskCacheEntry struct {
+	key  string
+	size int64
+	elem *list.Element
+}
+
+// diskCache stores the files inside a local directory, the file name is an hash of the backend
+// and of the object name, the modification time is the one of the object. The least recently
+// used files are evicted if the max size is exceeded
+type diskCache struct {
+	sync.Mutex
+	dir         string
+	maxSize     int64
+	maxFileSize int64
+	size        int64
+	entries     map[string]*diskCacheEntry
+	// the front element is the most recently used
+	lru *list.List
+}
+
+func newDiskCache(config DiskCacheConfig) (*diskCache, error) {
+	c := &diskCache{
+		dir:         config.Path,
+		maxSize:     config.MaxSize * 1024 * 1024,
+		maxFileSize: config.MaxFileSize * 1024 * 1024,
+		entries:     make(map[string]*diskCacheEntry),
+		lru:         list.New(),
+	}
+	if c.maxFileSize == 0 {
+		c.maxFileSize = c.maxSize
+	}
+	tempDir := filepath.Join(c.dir, diskCacheTempDir)
+	// files left by an interrupted download or upload cannot be completed
+	if err := os.RemoveAll(tempDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tempDir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		c.addEntry(fi.Name(), fi.Size())
+	}
+	c.evict(0)
+	logger.Debug(diskCacheLogSender, "", "disk cache initialized, path: %#v, files: %v, size: %v", c.dir,
+		len(c.entries), c.size)
+	return c, nil
+}
+
+// getDiskCacheKey returns the cache key for the object with the given name stored on the given backend
+func getDiskCacheKey(backend, name string) string {
+	h := sha256.Sum256([]byte(backend + "\x00" + name))
+	return hex.EncodeToString(h[:])
+}
+
+// addEntry adds or replaces the entry for the given key, the mutex must be locked
+func (c *diskCache) addEntry(key string, size int64) {
+	c.removeEntry(key)
+	entry := &diskCacheEntry{
+		key:  key,
+		size: size,
+	}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.size += size
+}
+
+// removeEntry removes the entry for the given key, the mutex must be locked
+func (c *diskCache) removeEntry(key string) {
+	if entry, ok := c.entries[key]; ok {
+		c.lru.Remove(entry.elem)
+		delete(c.entries, key)
+		c.size -= entry.size
+	}
+}
+
+// evict removes the least recently used files until there is room for size bytes, the mutex must be locked
+func (c *diskCache) evict(size int64) {
+	for c.size+size > c.maxSize && c.lru.Len() > 0 {
+		entry := c.lru.Back().Value.(*diskCacheEntry)
+		c.removeEntry(entry.key)
+		err := os.Remove(filepath.Join(c.dir, entry.key))
+		logger.Debug(diskCacheLogSender, "", "evicted cached file %#v, size: %v, err: %v", entry.key, entry.size, err)
+	}
+}
+
+// open returns the cached file for the given key if its size and modification time
+// match the given ones, nil otherwise
+func (c *diskCache) open(key string, size int64, modTime time.Time) *os.File {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	p := filepath.Join(c.dir, key)
+	fi, err := os.Stat(p)
+	if err != nil || entry.size != size || fi.Size() != size || fi.ModTime().Unix() != modTime.Unix() {
+		// the object was modified, the cached file is stale
+		c.removeEntry(key)
+		os.Remove(p)
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		logger.Warn(diskCacheLogSender, "", "unable to open cached file %#v: %v", p, err)
+		return nil
+	}
+	c.lru.MoveToFront(entry.elem)
+	return f
+}
+
+// remove removes the cached file for the given key, if any
+func (c *diskCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.entries[key]; ok {
+		c.removeEntry(key)
+		os.Remove(filepath.Join(c.dir, key))
+	}
+}
+
+// newWriter returns a writer to store the file for the given key. expectedSize is -1 if unknown.
+// nil is returned if the file is too large to be cached
+func (c *diskCache) newWriter(key string, expectedSize int64) *diskCacheWriter {
+	if expectedSize > c.maxFileSize {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Join(c.dir, diskCacheTempDir), "cache")
+	if err != nil {
+		logger.Warn(diskCacheLogSender, "", "unable to create a temporary file for key %#v: %v", key, err)
+		return nil
+	}
+	return &diskCacheWriter{
+		cache: c,
+		key:   key,
+		file:  f,
+	}
+}
+
+// commit moves the completed file to the cache, evicting the least recently used files if required
+func (c *diskCache) commit(key, tempPath string, size int64, modTime time.Time) error {
+	if err := os.Chtimes(tempPath, modTime, modTime); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.removeEntry(key)
+	c.evict(size)
+	if err := os.Rename(tempPath, filepath.Join(c.dir, key)); err != nil {
+		return err
+	}
+	c.addEntry(key, size)
+	return nil
+}
+
+// diskCacheWriter writes a file to cache to a temporary file inside the cache directory.
+// Write errors are not returned to the callers, the file is simply not cached
+type diskCacheWriter struct {
+	sync.Mutex
+	cache  *diskCache
+	key    string
+	file   *os.File
+	offset int64
+	// the end offset for the written data
+	size int64
+	err  error
+}
+
+func (w *diskCacheWriter) WriteAt(p []byte, off int64) {
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return
+	}
+	if off+int64(len(p)) > w.cache.maxFileSize {
+		w.err = errDiskCacheTooLarge
+		return
+	}
+	n, err := w.file.WriteAt(p, off)
+	if off+int64(n) > w.size {
+		w.size = off + int64(n)
+	}
+	w.err = err
+}
+
+func (w *diskCacheWriter) Write(p []byte) {
+	w.Lock()
+	offset := w.offset
+	w.offset += int64(len(p))
+	w.Unlock()
+	w.WriteAt(p, offset)
+}
+
+// Close stores the written file inside the cache if the transfer succeeded and the written size
+// matches the object size, modTime is the object modification time
+func (w *diskCacheWriter) Close(transferErr error, size int64, modTime time.Time) {
+	w.Lock()
+	defer w.Unlock()
+	name := w.file.Name()
+	err := w.file.Close()
+	if w.err == nil {
+		w.err = err
+	}
+	if w.err == nil {
+		w.err = transferErr
+	}
+	if w.err == nil && w.size != size {
+		w.err = fmt.Errorf("written size %v does not match the object size %v", w.size, size)
+	}
+	if w.err == nil {
+		w.err = w.cache.commit(w.key, name, size, modTime)
+	}
+	if w.err != nil {
+		os.Remove(name)
+	}
+	logger.Debug(diskCacheLogSender, "", "cache update completed, key: %#v, size: %v, err: %v", w.key, size, w.err)
+}
+
+type downloadWriter interface {
+	io.Writer
+	io.WriterAt
+}
+
+// getDownloadWriter returns a writer for the download pipe that copies the written data
+// to the given cache writer too, if any
+func getDownloadWriter(w *pipeat.PipeWriterAt, cacheWriter *diskCacheWriter) downloadWriter {
+	if cacheWriter == nil {
+		return w
+	}
+	return &diskCacheWriterAt{
+		PipeWriterAt: w,
+		cacheWriter:  cacheWriter,
+	}
+}
+
+// diskCacheWriterAt is a PipeWriterAt that copies the downloaded data to the disk cache
+type diskCacheWriterAt struct {
+	*pipeat.PipeWriterAt
+	cacheWriter *diskCacheWriter
+}
+
+func (w *diskCacheWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.PipeWriterAt.WriteAt(p, off)
+	if n > 0 {
+		w.cacheWriter.WriteAt(p[:n], off)
+	}
+	return n, err
+}
+
+func (w *diskCacheWriterAt) Write(p []byte) (int, error) {
+	n, err := w.PipeWriterAt.Write(p)
+	if n > 0 {
+		w.cacheWriter.Write(p[:n])
+	}
+	return n, err
+}
+
+// diskCacheReader copies the data read from the upload pipe to the disk cache
+type diskCacheReader struct {
+	*pipeat.PipeReaderAt
+	cacheWriter *diskCacheWriter
+}
+
+func (r *diskCacheReader) Read(p []byte) (int, error) {
+	n, err := r.PipeReaderAt.Read(p)
+	if n > 0 {
+		r.cacheWriter.Write(p[:n])
+	}
+	return n, err
+}
+
+// openCachedFile returns the cached file for the object with the given name if it is
+// cached and up to date, nil otherwise. The object is checked using fs.Stat
+func openCachedFile(fs Fs, backend, name string) (*os.File, os.FileInfo) {
+	cache := cloudDiskCache
+	if cache == nil {
+		return nil, nil
+	}
+	fi, err := fs.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil, nil
+	}
+	f := cache.open(getDiskCacheKey(backend, name), fi.Size(), fi.ModTime())
+	if f != nil {
+		fsLog(fs, logger.LevelDebug, "serving path %#v from the disk cache, size: %v", name, fi.Size())
+	}
+	return f, fi
+}
+
+// getDownloadCacheWriter returns a diskCacheWriter to populate the cache while downloading
+// the object with the given info, nil if the object cannot be cached
+func getDownloadCacheWriter(backend, name string, info os.FileInfo) *diskCacheWriter {
+	cache := cloudDiskCache
+	if cache == nil || info == nil {
+		return nil
+	}
+	return cache.newWriter(getDiskCacheKey(backend, name), info.Size())
+}
+
+// getUploadCacheWriter returns a diskCacheWriter to populate the cache while uploading
+// the object with the given name, nil if the disk cache is disabled
+func getUploadCacheWriter(backend, name string) *diskCacheWriter {
+	cache := cloudDiskCache
+	if cache == nil || len(name) == 0 || name[len(name)-1] == '/' {
+		return nil
+	}
+	return cache.newWriter(getDiskCacheKey(backend, name), -1)
+}
+
+// closeUploadCacheWriter stores the uploaded file inside the cache, the size and the
+// modification time are read from the uploaded object
+func closeUploadCacheWriter(fs Fs, w *diskCacheWriter, name string, uploadErr error) {
+	if w == nil {
+		return
+	}
+	var size int64
+	var modTime time.Time
+	if uploadErr == nil {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			uploadErr = err
+		} else {
+			size = fi.Size()
+			modTime = fi.ModTime()
+		}
+	}
+	w.Close(uploadErr, size, modTime)
+}
+
+// removeCachedFile removes the object with the given name from the disk cache, if any
+func removeCachedFile(backend, name string) {
+	cache := cloudDiskCache
+	if cache == nil {
+		return
+	}
+	cache.remove(getDiskCacheKey(backend, name))
+}
@@ -0,0 +1,817 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/eikenb/pipeat"
+)
+
+const (
+	dropboxDefaultAPIEndpoint     = "https://api.dropboxapi.com"
+	dropboxDefaultContentEndpoint = "https://content.dropboxapi.com"
+	dropboxTagFile                = "file"
+	dropboxTagFolder              = "folder"
+	// default size, as MB, for the upload session chunks
+	dropboxDefaultUploadChunkSize = 8
+	// files/upload and each upload session request cannot exceed 150 MB
+	dropboxMaxUploadChunkSize = 150
+)
+
+// DropboxFsConfig defines the configuration for Dropbox based filesystem.
+// A long-lived OAuth 2.0 access token or a refresh token, together with the app key
+// used to obtain it, can be used to access the Dropbox
+type DropboxFsConfig struct {
+	// Path, inside the Dropbox, to use as root directory. It cannot start with "/",
+	// empty means the Dropbox root
+	RootPath string `json:"root_path,omitempty"`
+	// OAuth 2.0 access token, it is used as is and so it must not expire.
+	// It cannot be used together with the refresh token
+	AccessToken string `json:"access_token,omitempty"`
+	// OAuth 2.0 refresh token, the short-lived access tokens are requested as needed
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Key and secret for the app that obtained the refresh token.
+	// The secret is not required for refresh tokens obtained using PKCE
+	AppKey    string `json:"app_key,omitempty"`
+	AppSecret string `json:"app_secret,omitempty"`
+	// Size (in MB) for the chunks used in upload sessions. Smaller files are uploaded
+	// using a single request. Each chunk is buffered in memory before uploading it.
+	// 0 means the default (8 MB), the maximum allowed value is 150
+	UploadChunkSize int64 `json:"upload_chunk_size,omitempty"`
+	// Dropbox API base URL, it is used for both the API and the content requests.
+	// If empty https://api.dropboxapi.com and https://content.dropboxapi.com will be used
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// DropboxFs is a Fs implementation for Dropbox.
+// The Dropbox API v2 is used, files bigger than the upload chunk size are uploaded using
+// upload sessions
+type DropboxFs struct {
+	connectionID   string
+	localTempDir   string
+	config         DropboxFsConfig
+	chunkSize      int64
+	client         *http.Client
+	tokenSource    *dropboxTokenSource
+	ctxTimeout     time.Duration
+	ctxLongTimeout time.Duration
+}
+
+type dropboxMetadata struct {
+	Tag            string    `json:".tag"`
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	ClientModified time.Time `json:"client_modified"`
+	ServerModified time.Time `json:"server_modified"`
+}
+
+func init() {
+	utils.AddFeature("+dropbox")
+}
+
+func (m *dropboxMetadata) isDir() bool {
+	return m.Tag == dropboxTagFolder
+}
+
+// getModTime returns the modification time set by the client, if any
+func (m *dropboxMetadata) getModTime() time.Time {
+	if !m.ClientModified.IsZero() {
+		return m.ClientModified
+	}
+	return m.ServerModified
+}
+
+type dropboxListFolderResult struct {
+	Entries []dropboxMetadata `json:"entries"`
+	Cursor  string            `json:"cursor"`
+	HasMore bool              `json:"has_more"`
+}
+
+type dropboxError struct {
+	op         string
+	name       string
+	statusCode int
+	summary    string
+}
+
+func (e *dropboxError) Error() string {
+	if len(e.summary) > 0 {
+		return fmt.Sprintf("%v %#v: %v %v", e.op, e.name, e.statusCode, e.summary)
+	}
+	return fmt.Sprintf("%v %#v: %v %v", e.op, e.name, e.statusCode, http.StatusText(e.statusCode))
+}
+
+// dropboxTokenSource returns the OAuth 2.0 access tokens. If a refresh token is configured
+// the access tokens are cached until they expire
+type dropboxTokenSource struct {
+	sync.Mutex
+	client       *http.Client
+	tokenURL     string
+	appKey       string
+	appSecret    string
+	refreshToken string
+	accessToken  string
+	expiresAt    time.Time
+}
+
+// NewDropboxFs returns a DropboxFs object that allows to interact with Dropbox
+func NewDropboxFs(connectionID, localTempDir string, config DropboxFsConfig) (Fs, error) {
+	fs := DropboxFs{
+		connectionID:   connectionID,
+		localTempDir:   localTempDir,
+		config:         config,
+		client:         &http.Client{},
+		ctxTimeout:     30 * time.Second,
+		ctxLongTimeout: 300 * time.Second,
+	}
+	if err := ValidateDropboxFsConfig(&fs.config); err != nil {
+		return fs, err
+	}
+	var err error
+	for _, secret := range []*string{&fs.config.AccessToken, &fs.config.RefreshToken, &fs.config.AppSecret} {
+		if len(*secret) > 0 {
+			*secret, err = kms.Decrypt(*secret)
+			if err != nil {
+				return fs, err
+			}
+		}
+	}
+	fs.chunkSize = fs.config.UploadChunkSize
+	if fs.chunkSize == 0 {
+		fs.chunkSize = dropboxDefaultUploadChunkSize
+	}
+	fs.chunkSize *= 1024 * 1024
+	fs.tokenSource = &dropboxTokenSource{
+		client:       fs.client,
+		tokenURL:     fs.getEndpoint(dropboxDefaultAPIEndpoint) + "/oauth2/token",
+		appKey:       fs.config.AppKey,
+		appSecret:    fs.config.AppSecret,
+		refreshToken: fs.config.RefreshToken,
+		accessToken:  fs.config.AccessToken,
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs DropboxFs) Name() string {
+	return fmt.Sprintf("DropboxFs root: %#v", fs.getDropboxPath("/"))
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs DropboxFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs DropboxFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.Dropbox.GetStat(), fs.ctxTimeout)
+	defer cancelFn()
+	metadata, err := fs.getMetadata(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.getFileInfo(path.Base(name), metadata), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs DropboxFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs DropboxFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	args := map[string]interface{}{
+		"path": fs.getDropboxPath(name),
+	}
+	resp, err := fs.doContentRequest(ctx, "files/download", args, nil, "open", name)
+	if err != nil {
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.AsyncWriterPipeInDir(fs.localTempDir)
+	if err != nil {
+		resp.Body.Close()
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	go func() {
+		defer cancelFn()
+		defer resp.Body.Close()
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing.
+// The contents are uploaded asynchronously, the file is committed when the upload completes
+func (fs DropboxFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.Dropbox.GetOpen(), fs.ctxTimeout)
+	metadata, err := fs.getMetadata(ctx, name)
+	if err == nil && metadata.isDir() {
+		err = &dropboxError{op: "create", name: name, statusCode: http.StatusBadRequest, summary: "is a directory"}
+	} else if fs.IsNotExist(err) {
+		err = fs.checkParentDir(ctx, name, "create")
+	}
+	cancelFn()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn = context.WithCancel(context.Background())
+	go func() {
+		defer cancelFn()
+		n, err := fs.upload(ctx, r, name)
+		r.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, size: %v, err: %v", name, n, err)
+	}()
+	return nil, w, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// An existing target file is removed before renaming
+func (fs DropboxFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	targetMetadata, err := fs.getMetadata(ctx, target)
+	if err == nil {
+		if targetMetadata.isDir() {
+			return &dropboxError{op: "rename", name: target, statusCode: http.StatusConflict,
+				summary: "the target is an existing directory"}
+		}
+		// a case only rename refers to the same file, Dropbox paths are case insensitive
+		if !strings.EqualFold(fs.getDropboxPath(source), fs.getDropboxPath(target)) {
+			if err = fs.deletePath(ctx, target); err != nil {
+				return err
+			}
+		}
+	} else if !fs.IsNotExist(err) {
+		return err
+	}
+	args := map[string]interface{}{
+		"from_path":  fs.getDropboxPath(source),
+		"to_path":    fs.getDropboxPath(target),
+		"autorename": false,
+	}
+	var result interface{}
+	return fs.doRPCRequest(ctx, "files/move_v2", args, &result, "rename", source)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs DropboxFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	metadata, err := fs.getMetadata(ctx, name)
+	if err != nil {
+		return err
+	}
+	if metadata.isDir() {
+		result, err := fs.listFolder(ctx, name, false, 1)
+		if err != nil {
+			return err
+		}
+		if len(result.Entries) > 0 {
+			return &dropboxError{op: "remove", name: name, statusCode: http.StatusConflict,
+				summary: "the directory is not empty"}
+		}
+	}
+	return fs.deletePath(ctx, name)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs DropboxFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	// Dropbox creates the missing parent folders, we don't want this
+	if err := fs.checkParentDir(ctx, name, "mkdir"); err != nil {
+		return err
+	}
+	args := map[string]interface{}{
+		"path":       fs.getDropboxPath(name),
+		"autorename": false,
+	}
+	var result interface{}
+	return fs.doRPCRequest(ctx, "files/create_folder_v2", args, &result, "mkdir", name)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (DropboxFs) Symlink(source, target string) error {
+	return errors.New("403 symlinks are not supported")
+}
+
+// Chown changes the numeric uid and gid of the named file.
+// Silently ignored.
+func (DropboxFs) Chown(name string, uid int, gid int) error {
+	return nil
+}
+
+// Chmod changes the mode of the named file to mode.
+// Silently ignored.
+func (DropboxFs) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file.
+// Dropbox allows to set the modification time only while uploading the file
+func (DropboxFs) Chtimes(name string, atime, mtime time.Time) error {
+	return errors.New("403 chtimes is not supported")
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base implementation
+func (DropboxFs) Truncate(name string, size int64) error {
+	return errors.New("403 truncate is not supported")
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs DropboxFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.Dropbox.GetList(), fs.ctxLongTimeout)
+	defer cancelFn()
+	result, err := fs.listFolder(ctx, dirname, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		if entry.Tag != dropboxTagFile && entry.Tag != dropboxTagFolder {
+			continue
+		}
+		infos = append(infos, fs.getFileInfo(entry.Name, entry))
+	}
+	return infos, nil
+}
+
+// IsUploadResumeSupported returns true if upload resume is supported.
+// SFTP Resume is not supported on Dropbox
+func (DropboxFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Dropbox commits the file when the upload completes, so the uploads are already atomic
+// and a temporary file is not needed
+func (DropboxFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (DropboxFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*dropboxError); ok {
+		return e.statusCode == http.StatusNotFound
+	}
+	return strings.Contains(err.Error(), "404")
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (DropboxFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*dropboxError); ok {
+		return e.statusCode == http.StatusUnauthorized || e.statusCode == http.StatusForbidden
+	}
+	return strings.Contains(err.Error(), "403")
+}
+
+// CheckRootPath checks that the configured root path exists and it is accessible
+func (fs DropboxFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, nil)
+	osFs.CheckRootPath(username, uid, gid)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	// list the root folder, the metadata cannot be requested for the Dropbox root
+	_, err := fs.listFolder(ctx, "/", false, 1)
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to access the root path %#v for user %#v: %v", fs.getDropboxPath("/"),
+			username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in the root path,
+// and their size. The root path contents are listed recursively
+func (fs DropboxFs) ScanRootDirContents() (int, int64, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	numFiles := 0
+	size := int64(0)
+	result, err := fs.listFolder(ctx, "/", true, 0)
+	if err != nil {
+		return numFiles, size, err
+	}
+	for _, entry := range result.Entries {
+		if entry.Tag == dropboxTagFile {
+			numFiles++
+			size += entry.Size
+		}
+	}
+	return numFiles, size, nil
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. The folder contents are listed for each directory
+func (fs DropboxFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	root = strings.TrimSuffix(root, "/")
+	info, err := fs.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = fs.walk(root, info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// Dropbox uploads are already atomic, we never call this method for Dropbox
+func (DropboxFs) GetAtomicUploadPath(name string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTP users
+func (DropboxFs) GetRelativePath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// Join joins any number of path elements into a single path
+func (DropboxFs) Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// ResolvePath returns the matching filesystem path for the specified sftp path
+func (DropboxFs) ResolvePath(sftpPath string) (string, error) {
+	return strings.TrimPrefix(path.Clean("/"+sftpPath), "/"), nil
+}
+
+func (fs DropboxFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	contents, err := fs.ReadDir(name)
+	err1 := walkFn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range contents {
+		err = fs.walk(fs.Join(name, fi.Name()), fi, walkFn)
+		if err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (DropboxFs) getFileInfo(name string, metadata dropboxMetadata) os.FileInfo {
+	return NewFileInfo(name, metadata.isDir(), metadata.Size, metadata.getModTime())
+}
+
+// getDropboxPath returns the Dropbox path for the given filesystem path.
+// The Dropbox root is identified by an empty string
+func (fs DropboxFs) getDropboxPath(name string) string {
+	p := path.Join("/", fs.config.RootPath, name)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+func (fs DropboxFs) getMetadata(ctx context.Context, name string) (dropboxMetadata, error) {
+	var metadata dropboxMetadata
+	dropboxPath := fs.getDropboxPath(name)
+	if len(dropboxPath) == 0 {
+		// the metadata are not available for the Dropbox root
+		metadata.Tag = dropboxTagFolder
+		metadata.Name = "/"
+		return metadata, nil
+	}
+	args := map[string]interface{}{
+		"path": dropboxPath,
+	}
+	err := fs.doRPCRequest(ctx, "files/get_metadata", args, &metadata, "stat", name)
+	return metadata, err
+}
+
+// checkParentDir returns an error if the parent directory for the given path does not exist
+func (fs DropboxFs) checkParentDir(ctx context.Context, name, op string) error {
+	parent, err := fs.getMetadata(ctx, path.Dir(path.Clean("/"+name)))
+	if err != nil {
+		return err
+	}
+	if !parent.isDir() {
+		return &dropboxError{op: op, name: name, statusCode: http.StatusNotFound}
+	}
+	return nil
+}
+
+// listFolder returns the contents of the given folder. If limit is greater than 0 only
+// the first page, with at most limit entries, is returned
+func (fs DropboxFs) listFolder(ctx context.Context, name string, recursive bool, limit int) (dropboxListFolderResult, error) {
+	var contents dropboxListFolderResult
+	args := map[string]interface{}{
+		"path":      fs.getDropboxPath(name),
+		"recursive": recursive,
+	}
+	if limit > 0 {
+		args["limit"] = limit
+	}
+	route := "files/list_folder"
+	for {
+		var result dropboxListFolderResult
+		err := fs.doRPCRequest(ctx, route, args, &result, "list", name)
+		if err != nil {
+			return contents, err
+		}
+		contents.Entries = append(contents.Entries, result.Entries...)
+		contents.Cursor = result.Cursor
+		contents.HasMore = result.HasMore
+		if !result.HasMore || limit > 0 {
+			return contents, nil
+		}
+		route = "files/list_folder/continue"
+		args = map[string]interface{}{
+			"cursor": result.Cursor,
+		}
+	}
+}
+
+func (fs DropboxFs) deletePath(ctx context.Context, name string) error {
+	args := map[string]interface{}{
+		"path": fs.getDropboxPath(name),
+	}
+	var result interface{}
+	return fs.doRPCRequest(ctx, "files/delete_v2", args, &result, "remove", name)
+}
+
+// upload uploads the contents read from the given reader. Files not bigger than the chunk
+// size are uploaded using a single request, otherwise an upload session is used
+func (fs DropboxFs) upload(ctx context.Context, reader io.Reader, name string) (int64, error) {
+	buf := make([]byte, fs.chunkSize)
+	n, err := io.ReadFull(reader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		args := fs.getCommitInfo(name)
+		resp, err := fs.doContentRequest(ctx, "files/upload", args, buf[:n], "upload", name)
+		if err != nil {
+			return 0, err
+		}
+		return int64(n), resp.Body.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	var session struct {
+		SessionID string `json:"session_id"`
+	}
+	err = fs.doUploadSessionRequest(ctx, "files/upload_session/start", map[string]interface{}{}, buf[:n],
+		&session, name)
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(n)
+	for {
+		n, err = io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return offset, err
+		}
+		cursor := map[string]interface{}{
+			"session_id": session.SessionID,
+			"offset":     offset,
+		}
+		if err != nil {
+			// this is the last chunk, it can be empty
+			args := map[string]interface{}{
+				"cursor": cursor,
+				"commit": fs.getCommitInfo(name),
+			}
+			var result dropboxMetadata
+			err = fs.doUploadSessionRequest(ctx, "files/upload_session/finish", args, buf[:n], &result, name)
+			return offset + int64(n), err
+		}
+		args := map[string]interface{}{
+			"cursor": cursor,
+		}
+		var result interface{}
+		err = fs.doUploadSessionRequest(ctx, "files/upload_session/append_v2", args, buf[:n], &result, name)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(n)
+		fsLog(fs, logger.LevelDebug, "upload session chunk uploaded, path: %#v, offset: %v", name, offset)
+	}
+}
+
+func (fs DropboxFs) getCommitInfo(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"path":       fs.getDropboxPath(name),
+		"mode":       "overwrite",
+		"autorename": false,
+		"mute":       true,
+	}
+}
+
+func (fs DropboxFs) doUploadSessionRequest(ctx context.Context, route string, args interface{}, chunk []byte,
+	result interface{}, name string) error {
+	resp, err := fs.doContentRequest(ctx, route, args, chunk, "upload", name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (fs DropboxFs) getEndpoint(defaultEndpoint string) string {
+	if len(fs.config.Endpoint) > 0 {
+		return strings.TrimSuffix(fs.config.Endpoint, "/")
+	}
+	return defaultEndpoint
+}
+
+// doRPCRequest sends a request to an RPC endpoint, the arguments and the result are JSON encoded
+func (fs DropboxFs) doRPCRequest(ctx context.Context, route string, args, result interface{}, op, name string) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	resp, err := fs.doRequest(ctx, fs.getEndpoint(dropboxDefaultAPIEndpoint)+"/2/"+route, bytes.NewReader(body),
+		headers, op, name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// doContentRequest sends a request to a content endpoint, the arguments are sent inside the
+// Dropbox-API-Arg header and the response body contains the file contents, if any
+func (fs DropboxFs) doContentRequest(ctx context.Context, route string, args interface{}, body []byte,
+	op, name string) (*http.Response, error) {
+	apiArg, err := getDropboxAPIArg(args)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{
+		"Dropbox-API-Arg": apiArg,
+	}
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+		headers["Content-Type"] = "application/octet-stream"
+	}
+	return fs.doRequest(ctx, fs.getEndpoint(dropboxDefaultContentEndpoint)+"/2/"+route, reqBody, headers, op, name)
+}
+
+// doRequest sends an authenticated Dropbox API request.
+// A non nil error is returned for any response status code not in the 2xx range
+func (fs DropboxFs) doRequest(ctx context.Context, requestURL string, body io.Reader, headers map[string]string,
+	op, name string) (*http.Response, error) {
+	accessToken, err := fs.tokenSource.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	e := &dropboxError{op: op, name: name, statusCode: resp.StatusCode}
+	var apiErr struct {
+		ErrorSummary string `json:"error_summary"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 65536)).Decode(&apiErr); err == nil {
+		e.summary = apiErr.ErrorSummary
+	}
+	if resp.StatusCode == http.StatusConflict {
+		// endpoint specific errors, for example "path/not_found/..", are returned using 409
+		if strings.Contains(e.summary, "/not_found/") {
+			e.statusCode = http.StatusNotFound
+		} else if strings.Contains(e.summary, "/no_write_permission/") ||
+			strings.Contains(e.summary, "/restricted_content/") {
+			e.statusCode = http.StatusForbidden
+		}
+	}
+	return nil, e
+}
+
+// getAccessToken returns a valid access token. If a refresh token is configured a new access
+// token is requested if the cached one is expired or it is about to expire
+func (t *dropboxTokenSource) getAccessToken(ctx context.Context) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.refreshToken) == 0 {
+		return t.accessToken, nil
+	}
+	if len(t.accessToken) > 0 && time.Now().Add(1*time.Minute).Before(t.expiresAt) {
+		return t.accessToken, nil
+	}
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", t.refreshToken)
+	form.Set("client_id", t.appKey)
+	if len(t.appSecret) > 0 {
+		form.Set("client_secret", t.appSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 65536)).Decode(&result)
+	if resp.StatusCode != http.StatusOK || err != nil || len(result.AccessToken) == 0 {
+		// an access token cannot be obtained using the configured refresh token
+		io.Copy(ioutil.Discard, resp.Body)
+		return "", &dropboxError{op: "token", name: t.tokenURL, statusCode: http.StatusUnauthorized,
+			summary: fmt.Sprintf("unable to get an access token, status code: %v %v %v", resp.StatusCode,
+				result.Error, result.ErrorDescription)}
+	}
+	t.accessToken = result.AccessToken
+	t.expiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return t.accessToken, nil
+}
+
+// getDropboxAPIArg returns the JSON encoded arguments to send inside the Dropbox-API-Arg header.
+// HTTP headers cannot contain non ASCII characters so they are escaped
+func getDropboxAPIArg(args interface{}) (string, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, r := range string(data) {
+		if r < 0x80 {
+			sb.WriteRune(r)
+			continue
+		}
+		if r > 0xffff {
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&sb, "\\u%04x\\u%04x", r1, r2)
+			continue
+		}
+		fmt.Fprintf(&sb, "\\u%04x", r)
+	}
+	return sb.String(), nil
+}
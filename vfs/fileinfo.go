@@ -12,6 +12,8 @@ type FileInfo struct {
 	modTime     time.Time
 	mode        os.FileMode
 	sys         interface{}
+	// true for an interrupted upload that can be resumed
+	partialUpload bool
 }
 
 // NewFileInfo creates file info.
@@ -58,3 +60,12 @@ func (fi FileInfo) IsDir() bool {
 func (fi FileInfo) Sys() interface{} {
 	return fi.getFileInfoSys()
 }
+
+// IsPartialUpload returns true if the given file info is for an interrupted upload that
+// can be resumed. A partial upload is not yet visible as a file on the storage backend
+func IsPartialUpload(info os.FileInfo) bool {
+	if fi, ok := info.(FileInfo); ok {
+		return fi.partialUpload
+	}
+	return false
+}
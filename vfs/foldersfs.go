@@ -0,0 +1,333 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	// foldersFsName is the name for the Fs implementation routing the virtual folders to their backends
+	foldersFsName = "foldersfs"
+)
+
+var errCrossFsOperation = errors.New("the source and the target must be on the same filesystem")
+
+// folderFs is a virtual folder stored on its own filesystem backend
+type folderFs struct {
+	virtualPath string
+	// prefix for the filesystem paths managed by this backend
+	fsPathPrefix string
+	fs           Fs
+}
+
+// FoldersFs is a Fs implementation that routes the paths inside the virtual folders with their
+// own filesystem backend to the matching Fs, any other path is handled by the local filesystem.
+// The filesystem paths returned for a virtual folder backend are prefixed with the virtual path,
+// for example "[/archive]:/prefix/file.txt", so they can be routed to the same backend
+type FoldersFs struct {
+	connectionID string
+	rootFs       Fs
+	folders      []folderFs
+}
+
+// NewFoldersFs returns a local filesystem for the given root directory if none of the given
+// virtual folders has its own filesystem backend, a FoldersFs otherwise
+func NewFoldersFs(connectionID, rootDir string, virtualFolders []VirtualFolder) (Fs, error) {
+	var localFolders []VirtualFolder
+	var folders []folderFs
+	for _, v := range virtualFolders {
+		if !v.HasFilesystem() {
+			localFolders = append(localFolders, v)
+			continue
+		}
+		fs, err := newVirtualFolderFs(connectionID, rootDir, *v.Filesystem)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the filesystem for virtual folder %#v: %v", v.VirtualPath, err)
+		}
+		folders = append(folders, folderFs{
+			virtualPath:  v.VirtualPath,
+			fsPathPrefix: "[" + v.VirtualPath + "]:",
+			fs:           fs,
+		})
+	}
+	rootFs := NewOsFs(connectionID, rootDir, localFolders)
+	if len(folders) == 0 {
+		return rootFs, nil
+	}
+	return &FoldersFs{
+		connectionID: connectionID,
+		rootFs:       rootFs,
+		folders:      folders,
+	}, nil
+}
+
+func newVirtualFolderFs(connectionID, localTempDir string, config VirtualFolderFilesystem) (Fs, error) {
+	switch config.Provider {
+	case 1:
+		return NewS3Fs(connectionID, localTempDir, config.S3Config)
+	case 2:
+		return NewGCSFs(connectionID, localTempDir, config.GCSConfig)
+	default:
+		return nil, fmt.Errorf("unsupported filesystem provider %v", config.Provider)
+	}
+}
+
+// route returns the backend and the backend path for the given filesystem path.
+// folder is nil if the path is handled by the local filesystem
+func (fs *FoldersFs) route(name string) (Fs, string, *folderFs) {
+	for idx := range fs.folders {
+		folder := &fs.folders[idx]
+		if strings.HasPrefix(name, folder.fsPathPrefix) {
+			return folder.fs, strings.TrimPrefix(name, folder.fsPathPrefix), folder
+		}
+	}
+	return fs.rootFs, name, nil
+}
+
+// getFsPath returns the filesystem path to expose for the given backend path
+func (f *folderFs) getFsPath(name string) string {
+	if f == nil {
+		return name
+	}
+	return f.fsPathPrefix + name
+}
+
+// Name returns the name for the Fs implementation
+func (fs *FoldersFs) Name() string {
+	return foldersFsName
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs *FoldersFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *FoldersFs) Stat(name string) (os.FileInfo, error) {
+	backend, p, _ := fs.route(name)
+	return backend.Stat(p)
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *FoldersFs) Lstat(name string) (os.FileInfo, error) {
+	backend, p, _ := fs.route(name)
+	return backend.Lstat(p)
+}
+
+// Open opens the named file for reading
+func (fs *FoldersFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	backend, p, _ := fs.route(name)
+	return backend.Open(p)
+}
+
+// Create creates or opens the named file for writing
+func (fs *FoldersFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	backend, p, _ := fs.route(name)
+	return backend.Create(p, flag)
+}
+
+// Rename renames (moves) source to target. Source and target must be on the same backend
+func (fs *FoldersFs) Rename(source, target string) error {
+	sourceFs, sourcePath, sourceFolder := fs.route(source)
+	_, targetPath, targetFolder := fs.route(target)
+	if sourceFolder != targetFolder {
+		return errCrossFsOperation
+	}
+	return sourceFs.Rename(sourcePath, targetPath)
+}
+
+// Remove removes the named file or (empty) directory
+func (fs *FoldersFs) Remove(name string, isDir bool) error {
+	backend, p, _ := fs.route(name)
+	return backend.Remove(p, isDir)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *FoldersFs) Mkdir(name string) error {
+	backend, p, _ := fs.route(name)
+	return backend.Mkdir(p)
+}
+
+// Symlink creates source as a symbolic link to target. Source and target must be on the same backend
+func (fs *FoldersFs) Symlink(source, target string) error {
+	sourceFs, sourcePath, sourceFolder := fs.route(source)
+	_, targetPath, targetFolder := fs.route(target)
+	if sourceFolder != targetFolder {
+		return errCrossFsOperation
+	}
+	return sourceFs.Symlink(sourcePath, targetPath)
+}
+
+// Chown changes the numeric uid and gid of the named file
+func (fs *FoldersFs) Chown(name string, uid int, gid int) error {
+	backend, p, _ := fs.route(name)
+	return backend.Chown(p, uid, gid)
+}
+
+// Chmod changes the mode of the named file to mode
+func (fs *FoldersFs) Chmod(name string, mode os.FileMode) error {
+	backend, p, _ := fs.route(name)
+	return backend.Chmod(p, mode)
+}
+
+// Chtimes changes the access and modification times of the named file
+func (fs *FoldersFs) Chtimes(name string, atime, mtime time.Time) error {
+	backend, p, _ := fs.route(name)
+	return backend.Chtimes(p, atime, mtime)
+}
+
+// Truncate changes the size of the named file
+func (fs *FoldersFs) Truncate(name string, size int64) error {
+	backend, p, _ := fs.route(name)
+	return backend.Truncate(p, size)
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries
+func (fs *FoldersFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	backend, p, _ := fs.route(dirname)
+	return backend.ReadDir(p)
+}
+
+// IsUploadResumeSupported returns true if upload resume is supported by the local
+// filesystem and by all the virtual folders backends
+func (fs *FoldersFs) IsUploadResumeSupported() bool {
+	for _, folder := range fs.folders {
+		if !folder.fs.IsUploadResumeSupported() {
+			return false
+		}
+	}
+	return fs.rootFs.IsUploadResumeSupported()
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported by the local
+// filesystem and by all the virtual folders backends
+func (fs *FoldersFs) IsAtomicUploadSupported() bool {
+	for _, folder := range fs.folders {
+		if !folder.fs.IsAtomicUploadSupported() {
+			return false
+		}
+	}
+	return fs.rootFs.IsAtomicUploadSupported()
+}
+
+// CheckRootPath creates the root directory and the missing parent directories for
+// the virtual folders if they don't exist
+func (fs *FoldersFs) CheckRootPath(username string, uid int, gid int) bool {
+	for _, folder := range fs.folders {
+		folder.fs.CheckRootPath(username, uid, gid)
+	}
+	return fs.rootFs.CheckRootPath(username, uid, gid)
+}
+
+// ResolvePath returns the matching filesystem path for the specified sftp path
+func (fs *FoldersFs) ResolvePath(sftpPath string) (string, error) {
+	sftpPath = utils.CleanSFTPPath(sftpPath)
+	// the dirs are returned in reverse order, the first match is the nearest folder.
+	// The virtual folders cannot be nested so we don't need to check the local ones
+	for _, dir := range utils.GetDirsForSFTPPath(sftpPath) {
+		for idx := range fs.folders {
+			folder := &fs.folders[idx]
+			if dir != folder.virtualPath {
+				continue
+			}
+			p, err := folder.fs.ResolvePath(path.Join("/", strings.TrimPrefix(sftpPath, folder.virtualPath)))
+			if err != nil {
+				return "", err
+			}
+			return folder.getFsPath(p), nil
+		}
+	}
+	return fs.rootFs.ResolvePath(sftpPath)
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (fs *FoldersFs) IsNotExist(err error) bool {
+	for _, folder := range fs.folders {
+		if folder.fs.IsNotExist(err) {
+			return true
+		}
+	}
+	return fs.rootFs.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (fs *FoldersFs) IsPermission(err error) bool {
+	for _, folder := range fs.folders {
+		if folder.fs.IsPermission(err) {
+			return true
+		}
+	}
+	return fs.rootFs.IsPermission(err)
+}
+
+// ScanRootDirContents returns the number of files and their size for the root
+// directory and all the virtual folders
+func (fs *FoldersFs) ScanRootDirContents() (int, int64, error) {
+	return fs.scanRootDirContents(nil)
+}
+
+func (fs *FoldersFs) scanRootDirContents(progress *ScanProgress) (int, int64, error) {
+	numFiles, size, err := ScanRootDirContentsWithProgress(fs.rootFs, progress)
+	if err != nil {
+		return numFiles, size, err
+	}
+	for _, folder := range fs.folders {
+		num, s, err := ScanRootDirContentsWithProgress(folder.fs, progress)
+		if err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to scan contents for virtual folder %#v: %v", folder.virtualPath, err)
+			return numFiles, size, err
+		}
+		numFiles += num
+		size += s
+	}
+	return numFiles, size, nil
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *FoldersFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	backend, p, folder := fs.route(root)
+	if folder == nil {
+		return backend.Walk(p, walkFn)
+	}
+	return backend.Walk(p, func(walkedPath string, info os.FileInfo, err error) error {
+		return walkFn(folder.getFsPath(walkedPath), info, err)
+	})
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (fs *FoldersFs) GetAtomicUploadPath(name string) string {
+	backend, p, folder := fs.route(name)
+	return folder.getFsPath(backend.GetAtomicUploadPath(p))
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTP users
+func (fs *FoldersFs) GetRelativePath(name string) string {
+	backend, p, folder := fs.route(name)
+	if folder == nil {
+		return backend.GetRelativePath(p)
+	}
+	return path.Join(folder.virtualPath, backend.GetRelativePath(p))
+}
+
+// Join joins any number of path elements into a single path
+func (fs *FoldersFs) Join(elem ...string) string {
+	if len(elem) == 0 {
+		return fs.rootFs.Join(elem...)
+	}
+	backend, p, folder := fs.route(elem[0])
+	elems := append([]string{p}, elem[1:]...)
+	return folder.getFsPath(backend.Join(elems...))
+}
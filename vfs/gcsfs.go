@@ -2,18 +2,24 @@ package vfs
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/eikenb/pipeat"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
@@ -26,6 +32,9 @@ var (
 	gcsDefaultFieldsSelection = []string{"Name", "Size", "Deleted", "Updated"}
 )
 
+// gcsDefaultDownloadPartSize is the part size used for parallel downloads if none is configured
+const gcsDefaultDownloadPartSize = 1024 * 1024 * 5
+
 // GCSFsConfig defines the configuration for Google Cloud Storage based filesystem
 type GCSFsConfig struct {
 	Bucket string `json:"bucket,omitempty"`
@@ -40,6 +49,15 @@ type GCSFsConfig struct {
 	Credentials          string `json:"credentials,omitempty"`
 	AutomaticCredentials int    `json:"automatic_credentials,omitempty"`
 	StorageClass         string `json:"storage_class,omitempty"`
+	// Rules to choose the storage class for each upload, the first matching rule wins.
+	// If no rule matches StorageClass is used
+	StorageClassRules []StorageClassRule `json:"storage_class_rules,omitempty"`
+	// The buffer size (in MB) for each range request used for parallel downloads.
+	// The minimum allowed part size is 5MB, zero means the default (5MB)
+	DownloadPartSize int64 `json:"download_part_size,omitempty"`
+	// How many parts are downloaded in parallel. Zero or one means that the objects
+	// are downloaded sequentially using a single request
+	DownloadConcurrency int `json:"download_concurrency,omitempty"`
 }
 
 // GCSFs is a Fs implementation for Google Cloud Storage.
@@ -52,6 +70,10 @@ type GCSFs struct {
 	ctxLongTimeout time.Duration
 }
 
+func init() {
+	utils.AddFeature("+gcs")
+}
+
 // NewGCSFs returns an GCSFs object that allows to interact with Google Cloud Storage
 func NewGCSFs(connectionID, localTempDir string, config GCSFsConfig) (Fs, error) {
 	var err error
@@ -65,15 +87,42 @@ func NewGCSFs(connectionID, localTempDir string, config GCSFsConfig) (Fs, error)
 	if err = ValidateGCSFsConfig(&fs.config, fs.config.CredentialFile); err != nil {
 		return fs, err
 	}
+	if fs.config.DownloadPartSize == 0 {
+		fs.config.DownloadPartSize = gcsDefaultDownloadPartSize
+	} else {
+		fs.config.DownloadPartSize *= 1024 * 1024
+	}
 	ctx := context.Background()
 	if fs.config.AutomaticCredentials > 0 {
 		fs.svc, err = storage.NewClient(ctx)
 	} else {
-		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsFile(fs.config.CredentialFile))
+		var credentials []byte
+		credentials, err = ReadGCSCredentials(fs.config.CredentialFile)
+		if err != nil {
+			return fs, err
+		}
+		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON(credentials))
 	}
 	return fs, err
 }
 
+// ReadGCSCredentials reads the given GCS credentials file, the credentials are decrypted if
+// they were encrypted with a master key
+func ReadGCSCredentials(credentialsFilePath string) ([]byte, error) {
+	content, err := ioutil.ReadFile(credentialsFilePath)
+	if err != nil {
+		return content, err
+	}
+	if !kms.IsEncrypted(string(content)) {
+		return content, nil
+	}
+	credentials, err := kms.Decrypt(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the GCS credentials: %v", err)
+	}
+	return []byte(credentials), nil
+}
+
 // Name returns the name for the Fs implementation
 func (fs GCSFs) Name() string {
 	return fmt.Sprintf("GCSFs bucket: %#v", fs.config.Bucket)
@@ -100,7 +149,7 @@ func (fs GCSFs) Stat(name string) (os.FileInfo, error) {
 	}
 	prefix := fs.getPrefixForStat(name)
 	query := &storage.Query{Prefix: prefix, Delimiter: "/"}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	ctx, cancelFn := getOperationContext(fsTimeouts.GCS.GetStat(), fs.ctxTimeout)
 	defer cancelFn()
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	it := bkt.Objects(ctx, query)
@@ -140,7 +189,11 @@ func (fs GCSFs) Lstat(name string) (os.FileInfo, error) {
 }
 
 // Open opens the named file for reading
-func (fs GCSFs) Open(name string) (*os.File, *pipeat.PipeReaderAt, func(), error) {
+func (fs GCSFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	cachedFile, info := openCachedFile(fs, fs.getDiskCacheBackend(), name)
+	if cachedFile != nil {
+		return cachedFile, nil, nil, nil
+	}
 	r, w, err := pipeat.AsyncWriterPipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -148,26 +201,124 @@ func (fs GCSFs) Open(name string) (*os.File, *pipeat.PipeReaderAt, func(), error
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	obj := bkt.Object(name)
 	ctx, cancelFn := context.WithCancel(context.Background())
+	cacheWriter := getDownloadCacheWriter(fs.getDiskCacheBackend(), name, info)
+	closeCacheWriter := func(err error) {
+		if cacheWriter != nil {
+			cacheWriter.Close(err, info.Size(), info.ModTime())
+		}
+	}
+	if fs.config.DownloadConcurrency > 1 {
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			r.Close()
+			w.Close()
+			closeCacheWriter(err)
+			cancelFn()
+			return nil, nil, nil, err
+		}
+		if attrs.Size > fs.config.DownloadPartSize {
+			// all the parts must be read from the same object generation
+			obj = obj.Generation(attrs.Generation)
+			go func() {
+				defer cancelFn()
+				n, err := fs.downloadParts(ctx, obj, getDownloadWriter(w, cacheWriter), attrs.Size)
+				w.CloseWithError(err)
+				closeCacheWriter(err)
+				fsLog(fs, logger.LevelDebug, "parallel download completed, path: %#v size: %v, err: %v", name, n, err)
+				metrics.GCSTransferCompleted(n, 1, err)
+			}()
+			return nil, r, cancelFn, nil
+		}
+	}
 	objectReader, err := obj.NewReader(ctx)
 	if err != nil {
 		r.Close()
 		w.Close()
+		closeCacheWriter(err)
 		cancelFn()
 		return nil, nil, nil, err
 	}
 	go func() {
 		defer cancelFn()
 		defer objectReader.Close()
-		n, err := io.Copy(w, objectReader)
+		n, err := io.Copy(getDownloadWriter(w, cacheWriter), objectReader)
 		w.CloseWithError(err)
+		closeCacheWriter(err)
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
 		metrics.GCSTransferCompleted(n, 1, err)
 	}()
 	return nil, r, cancelFn, nil
 }
 
+// downloadParts downloads the object with the given size using parallel range requests.
+// Each part is written at its offset, so the parts can complete out of order
+func (fs GCSFs) downloadParts(ctx context.Context, obj *storage.ObjectHandle, w io.WriterAt, size int64) (int64, error) {
+	ctx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var written int64
+	var downloadErr error
+	offsets := make(chan int64)
+
+	for i := 0; i < fs.config.DownloadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, fs.config.DownloadPartSize)
+			for offset := range offsets {
+				n, err := fs.downloadPart(ctx, obj, w, buf, offset, size)
+				mu.Lock()
+				written += n
+				if err != nil && downloadErr == nil {
+					downloadErr = err
+					// stop the other pending requests
+					cancelFn()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for offset := int64(0); offset < size; offset += fs.config.DownloadPartSize {
+		select {
+		case offsets <- offset:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(offsets)
+	wg.Wait()
+
+	if downloadErr == nil && written < size {
+		downloadErr = ctx.Err()
+	}
+	return written, downloadErr
+}
+
+func (fs GCSFs) downloadPart(ctx context.Context, obj *storage.ObjectHandle, w io.WriterAt, buf []byte,
+	offset, size int64) (int64, error) {
+	length := size - offset
+	if length > int64(len(buf)) {
+		length = int64(len(buf))
+	}
+	objectReader, err := obj.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer objectReader.Close()
+	n, err := io.ReadFull(objectReader, buf[:length])
+	if err != nil {
+		return 0, err
+	}
+	n, err = w.WriteAt(buf[:n], offset)
+	return int64(n), err
+}
+
 // Create creates or opens the named file for writing
-func (fs GCSFs) Create(name string, flag int) (*os.File, *pipeat.PipeWriterAt, func(), error) {
+func (fs GCSFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -176,15 +327,29 @@ func (fs GCSFs) Create(name string, flag int) (*os.File, *pipeat.PipeWriterAt, f
 	obj := bkt.Object(name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	objectWriter := obj.NewWriter(ctx)
-	if len(fs.config.StorageClass) > 0 {
-		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
-	}
+	removeCachedFile(fs.getDiskCacheBackend(), name)
+	cacheWriter := getUploadCacheWriter(fs.getDiskCacheBackend(), name)
 	go func() {
 		defer cancelFn()
-		defer objectWriter.Close()
-		n, err := io.Copy(objectWriter, r)
+		storageClass := getStorageClassForUpload(fs.config.StorageClassRules, fs.config.StorageClass,
+			fs.GetRelativePath(name), r)
+		if len(storageClass) > 0 {
+			objectWriter.ObjectAttrs.StorageClass = storageClass
+		}
+		var body io.Reader = r
+		if cacheWriter != nil {
+			body = &diskCacheReader{PipeReaderAt: r, cacheWriter: cacheWriter}
+		}
+		n, err := io.Copy(objectWriter, body)
+		// the object is created when the writer is closed, so it must be closed before updating the disk cache
+		closeErr := objectWriter.Close()
+		if err == nil {
+			err = closeErr
+		}
 		r.CloseWithError(err)
-		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %v", name, n, err)
+		closeUploadCacheWriter(fs, cacheWriter, name, err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, storage class: %#v, readed bytes: %v, err: %v",
+			name, storageClass, n, err)
 		metrics.GCSTransferCompleted(n, 0, err)
 	}()
 	return nil, w, cancelFn, nil
@@ -231,6 +396,7 @@ func (fs GCSFs) Rename(source, target string) error {
 	if err != nil {
 		return err
 	}
+	removeCachedFile(fs.getDiskCacheBackend(), target)
 	return fs.Remove(source, fi.IsDir())
 }
 
@@ -252,9 +418,17 @@ func (fs GCSFs) Remove(name string, isDir bool) error {
 	defer cancelFn()
 	err := fs.svc.Bucket(fs.config.Bucket).Object(name).Delete(ctx)
 	metrics.GCSDeleteObjectCompleted(err)
+	if err == nil && !isDir {
+		removeCachedFile(fs.getDiskCacheBackend(), name)
+	}
 	return err
 }
 
+// getDiskCacheBackend returns the backend identifier for the disk cache keys
+func (fs GCSFs) getDiskCacheBackend() string {
+	return "gcs:" + fs.config.Bucket
+}
+
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs GCSFs) Mkdir(name string) error {
 	_, err := fs.Stat(name)
@@ -294,6 +468,12 @@ func (GCSFs) Chtimes(name string, atime, mtime time.Time) error {
 	return errors.New("403 chtimes is not supported")
 }
 
+// Truncate changes the size of the named file.
+// Truncate is not supported for GCS
+func (GCSFs) Truncate(name string, size int64) error {
+	return errors.New("403 truncate is not supported")
+}
+
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
@@ -307,7 +487,7 @@ func (fs GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 		}
 	}
 	query := &storage.Query{Prefix: prefix, Delimiter: "/"}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	ctx, cancelFn := getOperationContext(fsTimeouts.GCS.GetList(), fs.ctxTimeout)
 	defer cancelFn()
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	it := bkt.Objects(ctx, query)
@@ -423,6 +603,47 @@ func (fs GCSFs) ScanRootDirContents() (int, int64, error) {
 	return numFiles, size, err
 }
 
+// Walk calls walkFn for each object whose name starts with the prefix identified by root.
+// Directories are not walked in lexical order and filepath.SkipDir is not supported
+func (fs GCSFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	prefix := strings.TrimPrefix(root, "/")
+	if len(prefix) > 0 && prefix != "." && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if prefix == "." {
+		prefix = ""
+	}
+	query := &storage.Query{Prefix: prefix}
+	err := query.SetAttrSelection(gcsDefaultFieldsSelection)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	bkt := fs.svc.Bucket(fs.config.Bucket)
+	it := bkt.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			metrics.GCSListObjectsCompleted(err)
+			return err
+		}
+		if !attrs.Deleted.IsZero() {
+			continue
+		}
+		isDir := strings.HasSuffix(attrs.Name, "/")
+		err = walkFn(attrs.Name, NewFileInfo(path.Base(attrs.Name), isDir, attrs.Size, attrs.Updated), nil)
+		if err != nil {
+			return err
+		}
+	}
+	metrics.GCSListObjectsCompleted(nil)
+	return nil
+}
+
 // GetAtomicUploadPath returns the path to use for an atomic upload.
 // S3 uploads are already atomic, we never call this method for S3
 func (GCSFs) GetAtomicUploadPath(name string) string {
@@ -492,6 +713,38 @@ func (fs *GCSFs) checkIfBucketExists() error {
 	return err
 }
 
+// GetPresignedURL returns a signed URL to download, using GET, or upload, using PUT, the given
+// object. The URL is signed using the service account private key, so the automatic
+// credentials cannot be used
+func (fs GCSFs) GetPresignedURL(name, method string, expires time.Duration) (string, error) {
+	if fs.config.AutomaticCredentials > 0 {
+		return "", errors.New("pre-signed URLs require service account credentials, automatic credentials are not supported")
+	}
+	content, err := ReadGCSCredentials(fs.config.CredentialFile)
+	if err != nil {
+		return "", err
+	}
+	var serviceAccount struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err = json.Unmarshal(content, &serviceAccount); err != nil {
+		return "", err
+	}
+	if len(serviceAccount.ClientEmail) == 0 || len(serviceAccount.PrivateKey) == 0 {
+		return "", errors.New("pre-signed URLs require service account credentials with a private key")
+	}
+	url, err := storage.SignedURL(fs.config.Bucket, name, &storage.SignedURLOptions{
+		GoogleAccessID: serviceAccount.ClientEmail,
+		PrivateKey:     []byte(serviceAccount.PrivateKey),
+		Method:         method,
+		Expires:        time.Now().Add(expires),
+	})
+	fsLog(fs, logger.LevelDebug, "pre-signed URL generated, method: %v path: %#v expires: %v err: %v", method, name,
+		expires, err)
+	return url, err
+}
+
 func (fs *GCSFs) getPrefixForStat(name string) string {
 	prefix := path.Dir(name)
 	if prefix == "/" || prefix == "." || len(prefix) == 0 {
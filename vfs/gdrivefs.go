@@ -0,0 +1,640 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/eikenb/pipeat"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	gdriveFolderMimeType = "application/vnd.google-apps.folder"
+	gdriveFileFields     = "id,name,mimeType,size,modifiedTime"
+)
+
+// GoogleDriveFsConfig defines the configuration for Google Drive based filesystem.
+// A service account, optionally using the domain-wide delegation, or an OAuth 2.0
+// refresh token can be used to access the Drive
+type GoogleDriveFsConfig struct {
+	// ID of the Drive folder to use as root directory, it can be a folder inside a shared drive.
+	// If empty the root folder for the "My Drive" of the authenticated user will be used
+	FolderID string `json:"folder_id,omitempty"`
+	// Service account JSON key
+	Credentials string `json:"credentials,omitempty"`
+	// Google Workspace user to impersonate using the domain-wide delegation, it can be set
+	// only together with the service account credentials. If empty the Drive of the service
+	// account itself will be used
+	Subject string `json:"subject,omitempty"`
+	// OAuth 2.0 client ID and secret for the application that obtained the refresh token
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// OAuth 2.0 refresh token with the Drive scope. It cannot be used together with
+	// the service account credentials
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Drive API base URL. If empty https://www.googleapis.com will be used.
+	// It can be changed, for example, to use the Private Google Access endpoints
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// GoogleDriveFs is a Fs implementation for Google Drive.
+// The Drive API v3 is used, files and folders are resolved by name starting from the
+// configured root folder. Google Docs files are listed but they cannot be downloaded
+type GoogleDriveFs struct {
+	connectionID   string
+	localTempDir   string
+	config         GoogleDriveFsConfig
+	rootID         string
+	svc            *drive.Service
+	ctxTimeout     time.Duration
+	ctxLongTimeout time.Duration
+}
+
+func init() {
+	utils.AddFeature("+gdrive")
+}
+
+type gdriveError struct {
+	op         string
+	name       string
+	statusCode int
+	reason     string
+	message    string
+}
+
+func (e *gdriveError) Error() string {
+	if len(e.message) > 0 {
+		return fmt.Sprintf("%v %#v: %v %v: %v", e.op, e.name, e.statusCode, e.reason, e.message)
+	}
+	return fmt.Sprintf("%v %#v: %v %v", e.op, e.name, e.statusCode, http.StatusText(e.statusCode))
+}
+
+// NewGoogleDriveFs returns a GoogleDriveFs object that allows to interact with Google Drive
+func NewGoogleDriveFs(connectionID, localTempDir string, config GoogleDriveFsConfig) (Fs, error) {
+	fs := GoogleDriveFs{
+		connectionID:   connectionID,
+		localTempDir:   localTempDir,
+		config:         config,
+		ctxTimeout:     30 * time.Second,
+		ctxLongTimeout: 300 * time.Second,
+	}
+	if err := ValidateGoogleDriveFsConfig(&fs.config); err != nil {
+		return fs, err
+	}
+	var err error
+	for _, secret := range []*string{&fs.config.Credentials, &fs.config.ClientSecret, &fs.config.RefreshToken} {
+		if len(*secret) > 0 {
+			*secret, err = kms.Decrypt(*secret)
+			if err != nil {
+				return fs, err
+			}
+		}
+	}
+	fs.rootID = fs.config.FolderID
+	if len(fs.rootID) == 0 {
+		fs.rootID = "root"
+	}
+	ctx := context.Background()
+	tokenSource, err := getGoogleDriveTokenSource(ctx, fs.config)
+	if err != nil {
+		return fs, err
+	}
+	opts := []option.ClientOption{option.WithHTTPClient(oauth2.NewClient(ctx, tokenSource))}
+	if len(fs.config.Endpoint) > 0 {
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(fs.config.Endpoint, "/")+"/drive/v3/"))
+	}
+	fs.svc, err = drive.NewService(ctx, opts...)
+	return fs, err
+}
+
+// Name returns the name for the Fs implementation
+func (fs GoogleDriveFs) Name() string {
+	return fmt.Sprintf("GoogleDriveFs folder: %#v", fs.rootID)
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs GoogleDriveFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs GoogleDriveFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.GoogleDrive.GetStat(), fs.ctxTimeout)
+	defer cancelFn()
+	file, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.getFileInfo(path.Base(name), file), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs GoogleDriveFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs GoogleDriveFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	file, err := fs.resolve(ctx, name)
+	if err == nil && isGoogleDriveFolder(file) {
+		err = &gdriveError{op: "open", name: name, statusCode: http.StatusBadRequest, message: "is a directory"}
+	}
+	if err != nil {
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	resp, err := fs.svc.Files.Get(file.Id).SupportsAllDrives(true).Context(ctx).Download()
+	if err != nil {
+		cancelFn()
+		return nil, nil, nil, getGoogleDriveError(err, "open", name)
+	}
+	r, w, err := pipeat.AsyncWriterPipeInDir(fs.localTempDir)
+	if err != nil {
+		resp.Body.Close()
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	go func() {
+		defer cancelFn()
+		defer resp.Body.Close()
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing.
+// The file metadata is created before returning, the contents are uploaded asynchronously
+func (fs GoogleDriveFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.GoogleDrive.GetOpen(), fs.ctxTimeout)
+	file, err := fs.resolve(ctx, name)
+	if err == nil && isGoogleDriveFolder(file) {
+		err = &gdriveError{op: "create", name: name, statusCode: http.StatusBadRequest, message: "is a directory"}
+	} else if fs.IsNotExist(err) {
+		file, err = fs.createFile(ctx, name, "")
+	}
+	cancelFn()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn = context.WithCancel(context.Background())
+	go func() {
+		defer cancelFn()
+		_, err := fs.svc.Files.Update(file.Id, &drive.File{}).SupportsAllDrives(true).Context(ctx).
+			Media(r, googleapi.ContentType("application/octet-stream")).Do()
+		err = getGoogleDriveError(err, "upload", name)
+		r.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, err: %v", name, err)
+	}()
+	return nil, w, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Drive allows files with the same name inside a folder, so an existing target file
+// is removed before renaming
+func (fs GoogleDriveFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	file, err := fs.resolve(ctx, source)
+	if err != nil {
+		return err
+	}
+	targetFile, err := fs.resolve(ctx, target)
+	if err == nil {
+		if isGoogleDriveFolder(targetFile) {
+			return &gdriveError{op: "rename", name: target, statusCode: http.StatusConflict,
+				message: "the target is an existing directory"}
+		}
+		if err = fs.deleteFile(ctx, targetFile.Id, target); err != nil {
+			return err
+		}
+	} else if !fs.IsNotExist(err) {
+		return err
+	}
+	call := fs.svc.Files.Update(file.Id, &drive.File{Name: path.Base(target)})
+	if path.Dir(fs.getDrivePath(source)) != path.Dir(fs.getDrivePath(target)) {
+		sourceParent, err := fs.resolve(ctx, path.Dir(fs.getDrivePath(source)))
+		if err != nil {
+			return err
+		}
+		targetParent, err := fs.resolve(ctx, path.Dir(fs.getDrivePath(target)))
+		if err != nil {
+			return err
+		}
+		call.AddParents(targetParent.Id).RemoveParents(sourceParent.Id)
+	}
+	return fs.updateMetadata(ctx, call, "rename", source)
+}
+
+// Remove removes the named file or (empty) directory.
+// The files are permanently deleted, they are not moved to the trash
+func (fs GoogleDriveFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	file, err := fs.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if isGoogleDriveFolder(file) {
+		contents, err := fs.listFolder(ctx, file.Id, "", name)
+		if err != nil {
+			return err
+		}
+		if len(contents) > 0 {
+			return &gdriveError{op: "remove", name: name, statusCode: http.StatusConflict,
+				message: "the directory is not empty"}
+		}
+	}
+	return fs.deleteFile(ctx, file.Id, name)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs GoogleDriveFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	_, err := fs.resolve(ctx, name)
+	if !fs.IsNotExist(err) {
+		if err == nil {
+			return fmt.Errorf("directory %#v already exists", name)
+		}
+		return err
+	}
+	_, err = fs.createFile(ctx, name, gdriveFolderMimeType)
+	return err
+}
+
+// Symlink creates source as a symbolic link to target.
+func (GoogleDriveFs) Symlink(source, target string) error {
+	return errors.New("403 symlinks are not supported")
+}
+
+// Chown changes the numeric uid and gid of the named file.
+// Silently ignored.
+func (GoogleDriveFs) Chown(name string, uid int, gid int) error {
+	return nil
+}
+
+// Chmod changes the mode of the named file to mode.
+// Silently ignored.
+func (GoogleDriveFs) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file.
+// Drive stores the modification time only
+func (fs GoogleDriveFs) Chtimes(name string, atime, mtime time.Time) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	file, err := fs.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	call := fs.svc.Files.Update(file.Id, &drive.File{ModifiedTime: mtime.UTC().Format(time.RFC3339Nano)})
+	return fs.updateMetadata(ctx, call, "chtimes", name)
+}
+
+// Truncate changes the size of the named file.
+// Truncate by path is not supported, while truncating an opened
+// file is handled inside base implementation
+func (GoogleDriveFs) Truncate(name string, size int64) error {
+	return errors.New("403 truncate is not supported")
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs GoogleDriveFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.GoogleDrive.GetList(), fs.ctxLongTimeout)
+	defer cancelFn()
+	dir, err := fs.resolve(ctx, dirname)
+	if err != nil {
+		return nil, err
+	}
+	if !isGoogleDriveFolder(dir) {
+		return nil, &gdriveError{op: "readdir", name: dirname, statusCode: http.StatusBadRequest,
+			message: "not a directory"}
+	}
+	contents, err := fs.listFolder(ctx, dir.Id, "", dirname)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(contents))
+	for _, file := range contents {
+		infos = append(infos, fs.getFileInfo(file.Name, file))
+	}
+	return infos, nil
+}
+
+// IsUploadResumeSupported returns true if upload resume is supported.
+// SFTP Resume is not supported on Google Drive
+func (GoogleDriveFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// The file metadata is created before uploading the contents, so the uploads are not atomic
+func (GoogleDriveFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (GoogleDriveFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*gdriveError); ok {
+		return e.statusCode == http.StatusNotFound
+	}
+	return strings.Contains(err.Error(), "404")
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (GoogleDriveFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*gdriveError); ok {
+		// Drive reports the exceeded rate limits using 403 too
+		return e.statusCode == http.StatusUnauthorized ||
+			(e.statusCode == http.StatusForbidden && !strings.HasSuffix(e.reason, "LimitExceeded"))
+	}
+	return strings.Contains(err.Error(), "403")
+}
+
+// CheckRootPath checks that the configured root folder exists and it is accessible
+func (fs GoogleDriveFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, nil)
+	osFs.CheckRootPath(username, uid, gid)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	root, err := fs.getFile(ctx, fs.rootID, "/")
+	if err == nil && !isGoogleDriveFolder(root) {
+		err = errors.New("the root is not a folder")
+	}
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to access the root folder %#v for user %#v: %v", fs.rootID,
+			username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in the root folder,
+// and their size
+func (fs GoogleDriveFs) ScanRootDirContents() (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	err := fs.Walk("", func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			numFiles++
+			size += info.Size()
+		}
+		return nil
+	})
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. The folder contents are listed for each directory
+func (fs GoogleDriveFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	root = strings.TrimSuffix(root, "/")
+	info, err := fs.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = fs.walk(root, info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// Google Drive uploads are not atomic, we never call this method for Google Drive
+func (GoogleDriveFs) GetAtomicUploadPath(name string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTP users
+func (fs GoogleDriveFs) GetRelativePath(name string) string {
+	return fs.getDrivePath(name)
+}
+
+// Join joins any number of path elements into a single path
+func (GoogleDriveFs) Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// ResolvePath returns the matching filesystem path for the specified sftp path
+func (fs GoogleDriveFs) ResolvePath(sftpPath string) (string, error) {
+	return strings.TrimPrefix(fs.getDrivePath(sftpPath), "/"), nil
+}
+
+func (fs GoogleDriveFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	contents, err := fs.ReadDir(name)
+	err1 := walkFn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range contents {
+		err = fs.walk(fs.Join(name, fi.Name()), fi, walkFn)
+		if err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (GoogleDriveFs) getFileInfo(name string, file *drive.File) os.FileInfo {
+	modTime, _ := time.Parse(time.RFC3339, file.ModifiedTime)
+	return NewFileInfo(name, isGoogleDriveFolder(file), file.Size, modTime)
+}
+
+// getDrivePath returns the path, relative to the root folder, for the given filesystem path
+func (GoogleDriveFs) getDrivePath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// resolve returns the Drive file for the given path, the path is resolved by name
+// starting from the root folder. If a folder contains more files with the same name
+// the first one is returned
+func (fs GoogleDriveFs) resolve(ctx context.Context, name string) (*drive.File, error) {
+	file := &drive.File{
+		Id:       fs.rootID,
+		Name:     "/",
+		MimeType: gdriveFolderMimeType,
+	}
+	for _, elem := range strings.Split(fs.getDrivePath(name), "/") {
+		if len(elem) == 0 {
+			continue
+		}
+		if !isGoogleDriveFolder(file) {
+			return file, &gdriveError{op: "stat", name: name, statusCode: http.StatusNotFound}
+		}
+		contents, err := fs.listFolder(ctx, file.Id, elem, name)
+		if err != nil {
+			return file, err
+		}
+		if len(contents) == 0 {
+			return file, &gdriveError{op: "stat", name: name, statusCode: http.StatusNotFound}
+		}
+		file = contents[0]
+	}
+	return file, nil
+}
+
+// listFolder returns the files inside the folder with the given ID, filtered by name if not empty
+func (fs GoogleDriveFs) listFolder(ctx context.Context, folderID, fileName, name string) ([]*drive.File, error) {
+	var files []*drive.File
+	query := fmt.Sprintf("'%v' in parents", escapeGoogleDriveQuery(folderID))
+	if len(fileName) > 0 {
+		query += fmt.Sprintf(" and name = '%v'", escapeGoogleDriveQuery(fileName))
+	}
+	query += " and trashed = false"
+	call := fs.svc.Files.List().Q(query).PageSize(1000).IncludeItemsFromAllDrives(true).SupportsAllDrives(true).
+		Fields(googleapi.Field(fmt.Sprintf("nextPageToken,files(%v)", gdriveFileFields)))
+	err := call.Pages(ctx, func(page *drive.FileList) error {
+		files = append(files, page.Files...)
+		return nil
+	})
+	return files, getGoogleDriveError(err, "list", name)
+}
+
+func (fs GoogleDriveFs) getFile(ctx context.Context, fileID, name string) (*drive.File, error) {
+	file, err := fs.svc.Files.Get(fileID).Fields(gdriveFileFields).SupportsAllDrives(true).Context(ctx).Do()
+	return file, getGoogleDriveError(err, "stat", name)
+}
+
+// createFile creates the metadata for a new file or folder inside the parent folder for the given path
+func (fs GoogleDriveFs) createFile(ctx context.Context, name, mimeType string) (*drive.File, error) {
+	parent, err := fs.resolve(ctx, path.Dir(fs.getDrivePath(name)))
+	if err != nil {
+		return nil, err
+	}
+	if !isGoogleDriveFolder(parent) {
+		return nil, &gdriveError{op: "create", name: name, statusCode: http.StatusNotFound}
+	}
+	metadata := &drive.File{
+		Name:     path.Base(name),
+		Parents:  []string{parent.Id},
+		MimeType: mimeType,
+	}
+	file, err := fs.svc.Files.Create(metadata).Fields(gdriveFileFields).SupportsAllDrives(true).Context(ctx).Do()
+	return file, getGoogleDriveError(err, "create", name)
+}
+
+func (fs GoogleDriveFs) updateMetadata(ctx context.Context, call *drive.FilesUpdateCall, op, name string) error {
+	_, err := call.Fields(gdriveFileFields).SupportsAllDrives(true).Context(ctx).Do()
+	return getGoogleDriveError(err, op, name)
+}
+
+func (fs GoogleDriveFs) deleteFile(ctx context.Context, fileID, name string) error {
+	err := fs.svc.Files.Delete(fileID).SupportsAllDrives(true).Context(ctx).Do()
+	return getGoogleDriveError(err, "remove", name)
+}
+
+func isGoogleDriveFolder(file *drive.File) bool {
+	return file.MimeType == gdriveFolderMimeType
+}
+
+// getGoogleDriveTokenSource returns the token source for the configured credentials, the access
+// tokens are cached until they expire
+func getGoogleDriveTokenSource(ctx context.Context, config GoogleDriveFsConfig) (oauth2.TokenSource, error) {
+	if len(config.Credentials) == 0 {
+		oauthConfig := &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{drive.DriveScope},
+		}
+		return oauthConfig.TokenSource(ctx, &oauth2.Token{RefreshToken: config.RefreshToken}), nil
+	}
+	if len(config.Subject) > 0 {
+		// domain-wide delegation
+		jwtConfig, err := google.JWTConfigFromJSON([]byte(config.Credentials), drive.DriveScope)
+		if err != nil {
+			return nil, err
+		}
+		jwtConfig.Subject = config.Subject
+		return jwtConfig.TokenSource(ctx), nil
+	}
+	credentials, err := google.CredentialsFromJSON(ctx, []byte(config.Credentials), drive.DriveScope)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.TokenSource, nil
+}
+
+// getGoogleDriveError converts the Drive API errors and the errors returned if an access token
+// cannot be obtained to gdriveError
+func getGoogleDriveError(err error, op, name string) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		e := &gdriveError{op: op, name: name, statusCode: apiErr.Code, message: apiErr.Message}
+		if len(apiErr.Errors) > 0 {
+			e.reason = apiErr.Errors[0].Reason
+		}
+		return e
+	}
+	var tokenErr *oauth2.RetrieveError
+	if errors.As(err, &tokenErr) {
+		return &gdriveError{op: op, name: name, statusCode: http.StatusUnauthorized,
+			message: fmt.Sprintf("unable to get an access token: %v", tokenErr)}
+	}
+	return err
+}
+
+// validateGoogleServiceAccount returns an error if the given credentials are not a service account JSON key
+func validateGoogleServiceAccount(credentials string) error {
+	jwtConfig, err := google.JWTConfigFromJSON([]byte(credentials))
+	if err != nil {
+		return fmt.Errorf("invalid service account credentials: %v", err)
+	}
+	if len(jwtConfig.Email) == 0 || len(jwtConfig.PrivateKey) == 0 {
+		return errors.New("invalid service account credentials: client_email and private_key are required")
+	}
+	return nil
+}
+
+// escapeGoogleDriveQuery escapes a string value to use inside a Drive search query
+func escapeGoogleDriveQuery(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
@@ -0,0 +1,573 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/eikenb/pipeat"
+)
+
+// HDFSFsConfig defines the configuration for a Hadoop Distributed File System accessed using the WebHDFS REST API
+type HDFSFsConfig struct {
+	// WebHDFS base URL, for example http://namenode:9870/webhdfs/v1
+	Endpoint string `json:"endpoint,omitempty"`
+	// Hadoop user for simple authentication, it is sent as "user.name" query parameter
+	Username string `json:"username,omitempty"`
+	// delegation token, it is sent as "delegation" query parameter.
+	// It cannot be used together with a username
+	DelegationToken string `json:"delegation_token,omitempty"`
+	// RootPath is similar to a chroot directory for local filesystem.
+	// If specified the SFTP user will only see the contents of this
+	// HDFS directory. The root path, if not empty, must not start
+	// with "/" and must end with "/", it is relative to the HDFS root.
+	// If empty the whole HDFS namespace will be available
+	RootPath string `json:"root_path,omitempty"`
+}
+
+// HDFSFs is a Fs implementation for the Hadoop Distributed File System.
+// The WebHDFS REST API is used, so no Hadoop client library is required
+type HDFSFs struct {
+	connectionID   string
+	localTempDir   string
+	config         HDFSFsConfig
+	endpoint       *url.URL
+	client         *http.Client
+	ctxTimeout     time.Duration
+	ctxLongTimeout time.Duration
+}
+
+type hdfsFileStatus struct {
+	PathSuffix       string `json:"pathSuffix"`
+	Type             string `json:"type"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"`
+}
+
+type hdfsError struct {
+	op         string
+	name       string
+	statusCode int
+	exception  string
+	message    string
+}
+
+func init() {
+	utils.AddFeature("+hdfs")
+}
+
+func (e *hdfsError) Error() string {
+	if len(e.exception) > 0 {
+		return fmt.Sprintf("%v %#v: %v %v: %v", e.op, e.name, e.statusCode, e.exception, e.message)
+	}
+	return fmt.Sprintf("%v %#v: %v %v", e.op, e.name, e.statusCode, http.StatusText(e.statusCode))
+}
+
+// NewHDFSFs returns an HDFSFs object that allows to interact with an HDFS cluster
+func NewHDFSFs(connectionID, localTempDir string, config HDFSFsConfig) (Fs, error) {
+	fs := HDFSFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		config:       config,
+		client: &http.Client{
+			// WebHDFS redirects reads and writes to the data nodes, we follow the redirects
+			// ourself since the request body cannot be sent twice
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		ctxTimeout:     30 * time.Second,
+		ctxLongTimeout: 300 * time.Second,
+	}
+	if err := ValidateHDFSFsConfig(&fs.config); err != nil {
+		return fs, err
+	}
+	var err error
+	if len(fs.config.DelegationToken) > 0 {
+		fs.config.DelegationToken, err = kms.Decrypt(fs.config.DelegationToken)
+		if err != nil {
+			return fs, err
+		}
+	}
+	fs.endpoint, err = url.Parse(fs.config.Endpoint)
+	return fs, err
+}
+
+// Name returns the name for the Fs implementation
+func (fs HDFSFs) Name() string {
+	return fmt.Sprintf("HDFSFs endpoint: %#v", fs.config.Endpoint)
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs HDFSFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs HDFSFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.HDFS.GetStat(), fs.ctxTimeout)
+	defer cancelFn()
+	var result struct {
+		FileStatus hdfsFileStatus `json:"FileStatus"`
+	}
+	err := fs.doJSONRequest(ctx, http.MethodGet, "GETFILESTATUS", name, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return fs.getFileInfo(path.Base(name), result.FileStatus), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs HDFSFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs HDFSFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	r, w, err := pipeat.AsyncWriterPipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	resp, err := fs.doRequest(ctx, http.MethodGet, "OPEN", name, nil, nil)
+	if err == nil && resp.StatusCode == http.StatusTemporaryRedirect {
+		resp, err = fs.followRedirect(ctx, resp, http.MethodGet, "OPEN", name, nil)
+	}
+	if err != nil {
+		r.Close()
+		w.Close()
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	go func() {
+		defer cancelFn()
+		defer resp.Body.Close()
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs HDFSFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	go func() {
+		defer cancelFn()
+		// the name node replies with a redirect to the data node that will receive the file contents
+		params := url.Values{}
+		params.Set("overwrite", "true")
+		resp, err := fs.doRequest(ctx, http.MethodPut, "CREATE", name, params, nil)
+		if err == nil {
+			if resp.StatusCode == http.StatusTemporaryRedirect {
+				// the HTTP client closes the request body, the pipe must be closed only once
+				// and with the upload error, if any
+				resp, err = fs.followRedirect(ctx, resp, http.MethodPut, "CREATE", name, ioutil.NopCloser(r))
+			} else {
+				resp.Body.Close()
+				err = &hdfsError{op: "CREATE", name: name, statusCode: resp.StatusCode,
+					message: "no redirect to a data node received"}
+			}
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		r.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, err: %v", name, err)
+	}()
+	return nil, w, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// HDFS does not replace an existing target, so an existing target file is removed before renaming
+func (fs HDFSFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	fi, err := fs.Stat(target)
+	if err == nil && !fi.IsDir() {
+		if err = fs.Remove(target, false); err != nil {
+			return err
+		}
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	params := url.Values{}
+	params.Set("destination", fs.getHDFSPath(target))
+	return fs.doBooleanRequest(ctx, http.MethodPut, "RENAME", source, params)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs HDFSFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	params := url.Values{}
+	params.Set("recursive", "false")
+	err := fs.doBooleanRequest(ctx, http.MethodDelete, "DELETE", name, params)
+	if e, ok := err.(*hdfsError); ok && e.statusCode == http.StatusOK {
+		// DELETE returns false if the path does not exist
+		e.statusCode = http.StatusNotFound
+	}
+	return err
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs HDFSFs) Mkdir(name string) error {
+	// MKDIRS succeeds for existing directories too
+	_, err := fs.Stat(name)
+	if !fs.IsNotExist(err) {
+		if err == nil {
+			return fmt.Errorf("directory %#v already exists", name)
+		}
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	return fs.doBooleanRequest(ctx, http.MethodPut, "MKDIRS", name, nil)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (HDFSFs) Symlink(source, target string) error {
+	return errors.New("403 symlinks are not supported")
+}
+
+// Chown changes the numeric uid and gid of the named file.
+// Silently ignored.
+func (HDFSFs) Chown(name string, uid int, gid int) error {
+	return nil
+}
+
+// Chmod changes the mode of the named file to mode.
+// Silently ignored.
+func (HDFSFs) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs HDFSFs) Chtimes(name string, atime, mtime time.Time) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	params := url.Values{}
+	params.Set("accesstime", strconv.FormatInt(utils.GetTimeAsMsSinceEpoch(atime), 10))
+	params.Set("modificationtime", strconv.FormatInt(utils.GetTimeAsMsSinceEpoch(mtime), 10))
+	resp, err := fs.doRequest(ctx, http.MethodPut, "SETTIMES", name, params, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Truncate changes the size of the named file.
+// The data nodes could complete the truncate asynchronously
+func (fs HDFSFs) Truncate(name string, size int64) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	params := url.Values{}
+	params.Set("newlength", strconv.FormatInt(size, 10))
+	resp, err := fs.doRequest(ctx, http.MethodPost, "TRUNCATE", name, params, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs HDFSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	ctx, cancelFn := getOperationContext(fsTimeouts.HDFS.GetList(), fs.ctxLongTimeout)
+	defer cancelFn()
+	var result struct {
+		FileStatuses struct {
+			FileStatus []hdfsFileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	err := fs.doJSONRequest(ctx, http.MethodGet, "LISTSTATUS", dirname, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(result.FileStatuses.FileStatus))
+	for _, status := range result.FileStatuses.FileStatus {
+		// for files LISTSTATUS returns the file itself with an empty path suffix
+		if len(status.PathSuffix) == 0 {
+			continue
+		}
+		infos = append(infos, fs.getFileInfo(status.PathSuffix, status))
+	}
+	return infos, nil
+}
+
+// IsUploadResumeSupported returns true if upload resume is supported.
+// SFTP Resume is not supported on HDFS
+func (HDFSFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// HDFS uploads are not atomic, the file is visible while it is being written
+func (HDFSFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (HDFSFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*hdfsError); ok {
+		return e.statusCode == http.StatusNotFound || e.exception == "FileNotFoundException"
+	}
+	return strings.Contains(err.Error(), "404")
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (HDFSFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*hdfsError); ok {
+		return e.statusCode == http.StatusForbidden || e.statusCode == http.StatusUnauthorized ||
+			e.exception == "AccessControlException" || e.exception == "SecurityException"
+	}
+	return strings.Contains(err.Error(), "403")
+}
+
+// CheckRootPath creates the configured root path, if any, if it does not exists
+func (fs HDFSFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, nil)
+	osFs.CheckRootPath(username, uid, gid)
+	if len(fs.config.RootPath) == 0 {
+		return true
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	// MKDIRS creates the missing parents and it succeeds if the directory already exists
+	err := fs.doBooleanRequest(ctx, http.MethodPut, "MKDIRS", fs.config.RootPath, nil)
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to create root path %#v for user %#v: %v", fs.config.RootPath,
+			username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in the root path,
+// and their size
+func (fs HDFSFs) ScanRootDirContents() (int, int64, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	var result struct {
+		ContentSummary struct {
+			FileCount int   `json:"fileCount"`
+			Length    int64 `json:"length"`
+		} `json:"ContentSummary"`
+	}
+	err := fs.doJSONRequest(ctx, http.MethodGet, "GETCONTENTSUMMARY", fs.config.RootPath, nil, &result)
+	return result.ContentSummary.FileCount, result.ContentSummary.Length, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. A LISTSTATUS request is sent for each directory
+func (fs HDFSFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	root = strings.TrimSuffix(root, "/")
+	info, err := fs.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = fs.walk(root, info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// HDFS uploads are not atomic, we never call this method for HDFS
+func (HDFSFs) GetAtomicUploadPath(name string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTP users
+func (fs HDFSFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if len(fs.config.RootPath) > 0 {
+		if !strings.HasPrefix(rel, "/"+fs.config.RootPath) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, "/"+fs.config.RootPath))
+	}
+	return rel
+}
+
+// Join joins any number of path elements into a single path
+func (HDFSFs) Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// ResolvePath returns the matching filesystem path for the specified sftp path
+func (fs HDFSFs) ResolvePath(sftpPath string) (string, error) {
+	if !path.IsAbs(sftpPath) {
+		sftpPath = path.Clean("/" + sftpPath)
+	}
+	return fs.Join(fs.config.RootPath, strings.TrimPrefix(sftpPath, "/")), nil
+}
+
+func (fs HDFSFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	contents, err := fs.ReadDir(name)
+	err1 := walkFn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range contents {
+		err = fs.walk(fs.Join(name, fi.Name()), fi, walkFn)
+		if err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (HDFSFs) getFileInfo(name string, status hdfsFileStatus) os.FileInfo {
+	return NewFileInfo(name, status.Type == "DIRECTORY", status.Length,
+		utils.GetTimeFromMsecSinceEpoch(status.ModificationTime))
+}
+
+// getHDFSPath returns the absolute HDFS path for the given filesystem path
+func (HDFSFs) getHDFSPath(name string) string {
+	return path.Join("/", name)
+}
+
+// getURL returns the WebHDFS URL for the given operation and path
+func (fs HDFSFs) getURL(op, name string, params url.Values) string {
+	u := *fs.endpoint
+	u.Path = path.Join("/", u.Path, fs.getHDFSPath(name))
+	u.RawPath = ""
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set("op", op)
+	if len(fs.config.DelegationToken) > 0 {
+		q.Set("delegation", fs.config.DelegationToken)
+	} else if len(fs.config.Username) > 0 {
+		q.Set("user.name", fs.config.Username)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// doRequest sends a WebHDFS request, redirects are returned to the caller.
+// A non nil error is returned for any other response status code not in the 2xx range
+func (fs HDFSFs) doRequest(ctx context.Context, method, op, name string, params url.Values,
+	body io.Reader) (*http.Response, error) {
+	return fs.sendRequest(ctx, method, fs.getURL(op, name, params), op, name, body)
+}
+
+func (fs HDFSFs) followRedirect(ctx context.Context, resp *http.Response, method, op, name string,
+	body io.Reader) (*http.Response, error) {
+	location := resp.Header.Get("Location")
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if len(location) == 0 {
+		return nil, &hdfsError{op: op, name: name, statusCode: resp.StatusCode, message: "empty redirect location"}
+	}
+	resp, err := fs.sendRequest(ctx, method, location, op, name, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTemporaryRedirect {
+		resp.Body.Close()
+		return nil, &hdfsError{op: op, name: name, statusCode: resp.StatusCode, message: "too many redirects"}
+	}
+	return resp, nil
+}
+
+func (fs HDFSFs) sendRequest(ctx context.Context, method, requestURL, op, name string,
+	body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTemporaryRedirect || (resp.StatusCode >= 200 && resp.StatusCode <= 299) {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	e := &hdfsError{op: op, name: name, statusCode: resp.StatusCode}
+	var remoteErr struct {
+		RemoteException struct {
+			Exception string `json:"exception"`
+			Message   string `json:"message"`
+		} `json:"RemoteException"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 65536)).Decode(&remoteErr); err == nil {
+		e.exception = remoteErr.RemoteException.Exception
+		e.message = remoteErr.RemoteException.Message
+	}
+	return nil, e
+}
+
+func (fs HDFSFs) doJSONRequest(ctx context.Context, method, op, name string, params url.Values,
+	result interface{}) error {
+	resp, err := fs.doRequest(ctx, method, op, name, params, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// doBooleanRequest sends a request for an operation that returns a boolean result,
+// a false result is returned as error
+func (fs HDFSFs) doBooleanRequest(ctx context.Context, method, op, name string, params url.Values) error {
+	var result struct {
+		Boolean bool `json:"boolean"`
+	}
+	err := fs.doJSONRequest(ctx, method, op, name, params, &result)
+	if err != nil {
+		return err
+	}
+	if !result.Boolean {
+		return &hdfsError{op: op, name: name, statusCode: http.StatusOK, message: "operation failed"}
+	}
+	return nil
+}
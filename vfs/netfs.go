@@ -0,0 +1,173 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// supported rename modes for the local filesystem on network mounts
+const (
+	// use the rename system call
+	RenameModeNative = iota
+	// remove an existing target file before renaming. Some SMB servers refuse to
+	// replace an existing file
+	RenameModeReplace
+	// use the rename system call and fallback to copy and delete if the network
+	// filesystem does not support it, for example across different exports
+	RenameModeCopyFallback
+)
+
+var netFsConfig NetworkFsConfig
+
+// NetworkFsConfig defines the local filesystem mode for home directories on network filesystems
+// such as NFS or CIFS
+type NetworkFsConfig struct {
+	// Enable the network filesystem mode for the local filesystem
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Number of retries for the operations failing with a stale file handle error (ESTALE)
+	StaleRetries int `json:"stale_retries" mapstructure:"stale_retries"`
+	// Delay between retries, in milliseconds
+	RetryDelay int `json:"retry_delay" mapstructure:"retry_delay"`
+	// Open the transferred files with O_DIRECT bypassing the client page cache, Linux only.
+	// Enable it only if all the home directories are on network filesystems: local filesystems
+	// require aligned I/O for direct access
+	DirectIO bool `json:"direct_io" mapstructure:"direct_io"`
+	// Rename semantics, 0 native, 1 replace, 2 native with copy and delete fallback
+	RenameMode int `json:"rename_mode" mapstructure:"rename_mode"`
+}
+
+func (c *NetworkFsConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.StaleRetries < 0 || c.RetryDelay < 0 {
+		return errors.New("stale retries and retry delay cannot be negative")
+	}
+	if c.RenameMode < RenameModeNative || c.RenameMode > RenameModeCopyFallback {
+		return fmt.Errorf("invalid rename mode %v", c.RenameMode)
+	}
+	if c.DirectIO && directIOFlag == 0 {
+		return errors.New("direct I/O is not supported on this platform")
+	}
+	return nil
+}
+
+// SetNetworkFsConfig sets the network filesystem mode for the local filesystem
+func SetNetworkFsConfig(config NetworkFsConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	netFsConfig = config
+	return nil
+}
+
+// IsNetworkFsMode returns true if the network filesystem mode is enabled for the local filesystem
+func IsNetworkFsMode() bool {
+	return netFsConfig.Enabled
+}
+
+func isStaleHandleError(err error) bool {
+	return errors.Is(err, syscall.ESTALE)
+}
+
+// isUnsupportedError returns true if the error reports an operation not supported by the filesystem
+func isUnsupportedError(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS)
+}
+
+// retryOnStale executes fn and, in network filesystem mode, retries it while it fails
+// with a stale file handle error
+func retryOnStale(fn func() error) error {
+	err := fn()
+	if !netFsConfig.Enabled {
+		return err
+	}
+	for i := 0; i < netFsConfig.StaleRetries && isStaleHandleError(err); i++ {
+		time.Sleep(time.Duration(netFsConfig.RetryDelay) * time.Millisecond)
+		err = fn()
+	}
+	return err
+}
+
+// ignoreUnsupported returns nil, in network filesystem mode, if err reports an unsupported operation.
+// For example CIFS mounts without unix extensions do not support ownership and times changes
+func ignoreUnsupported(err error) error {
+	if netFsConfig.Enabled && isUnsupportedError(err) {
+		return nil
+	}
+	return err
+}
+
+// openLocalFile opens a local file for a transfer using the network filesystem mode, if enabled
+func openLocalFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if netFsConfig.Enabled && netFsConfig.DirectIO {
+		flag |= directIOFlag
+	}
+	var f *os.File
+	err := retryOnStale(func() error {
+		var err error
+		f, err = os.OpenFile(name, flag, perm)
+		return err
+	})
+	return f, err
+}
+
+// renameLocal renames source to target using the configured rename mode
+func renameLocal(source, target string) error {
+	if !netFsConfig.Enabled {
+		return os.Rename(source, target)
+	}
+	if netFsConfig.RenameMode == RenameModeReplace {
+		if info, err := os.Lstat(target); err == nil && info.Mode().IsRegular() {
+			if err = retryOnStale(func() error { return os.Remove(target) }); err != nil {
+				return err
+			}
+		}
+	}
+	err := retryOnStale(func() error { return os.Rename(source, target) })
+	if err != nil && netFsConfig.RenameMode == RenameModeCopyFallback &&
+		(errors.Is(err, syscall.EXDEV) || isUnsupportedError(err)) {
+		return copyAndRemove(source, target, err)
+	}
+	return err
+}
+
+// copyAndRemove copies the source file to target and then removes source.
+// Directories are not copied, for them renameErr is returned
+func copyAndRemove(source, target string, renameErr error) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return renameErr
+	}
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	// preserving the modification time is best effort
+	os.Chtimes(target, info.ModTime(), info.ModTime())
+	return os.Remove(source)
+}
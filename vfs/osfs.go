@@ -10,7 +10,6 @@ import (
 
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
-	"github.com/eikenb/pipeat"
 	"github.com/rs/xid"
 )
 
@@ -49,46 +48,89 @@ func (fs OsFs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (OsFs) Stat(name string) (os.FileInfo, error) {
-	return os.Stat(name)
+	var info os.FileInfo
+	err := RunWithTimeout(fsTimeouts.Local.GetStat(), func() error {
+		return retryOnStale(func() error {
+			var err error
+			info, err = os.Stat(name)
+			return err
+		})
+	})
+	if err != nil {
+		// after a timeout info could be set in background
+		return nil, err
+	}
+	return info, nil
 }
 
 // Lstat returns a FileInfo describing the named file
 func (OsFs) Lstat(name string) (os.FileInfo, error) {
-	return os.Lstat(name)
+	var info os.FileInfo
+	err := RunWithTimeout(fsTimeouts.Local.GetStat(), func() error {
+		return retryOnStale(func() error {
+			var err error
+			info, err = os.Lstat(name)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
 // Open opens the named file for reading
-func (OsFs) Open(name string) (*os.File, *pipeat.PipeReaderAt, func(), error) {
-	f, err := os.Open(name)
+func (OsFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	if err := checkDenyACL(name, fileReadData); err != nil {
+		return nil, nil, nil, err
+	}
+	f, err := openWithTimeout(fsTimeouts.Local.GetOpen(), func() (*os.File, error) {
+		return openLocalFile(name, os.O_RDONLY, 0)
+	})
 	return f, nil, nil, err
 }
 
 // Create creates or opens the named file for writing
-func (OsFs) Create(name string, flag int) (*os.File, *pipeat.PipeWriterAt, func(), error) {
+func (OsFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
 	var err error
 	var f *os.File
-	if flag == 0 {
-		f, err = os.Create(name)
-	} else {
-		f, err = os.OpenFile(name, flag, 0666)
-
+	if err = checkDenyACL(name, fileWriteData); err != nil {
+		return nil, nil, nil, err
 	}
+	f, err = openWithTimeout(fsTimeouts.Local.GetOpen(), func() (*os.File, error) {
+		if flag == 0 {
+			flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		}
+		return openLocalFile(name, flag, 0666)
+	})
 	return f, nil, nil, err
 }
 
 // Rename renames (moves) source to target
 func (OsFs) Rename(source, target string) error {
-	return os.Rename(source, target)
+	if err := checkDenyACL(source, accessDelete); err != nil {
+		return err
+	}
+	if err := checkDenyACL(target, fileWriteData); err != nil {
+		return err
+	}
+	return renameLocal(source, target)
 }
 
 // Remove removes the named file or (empty) directory.
 func (OsFs) Remove(name string, isDir bool) error {
-	return os.Remove(name)
+	if err := checkDenyACL(name, accessDelete); err != nil {
+		return err
+	}
+	return retryOnStale(func() error { return os.Remove(name) })
 }
 
 // Mkdir creates a new directory with the specified name and default permissions
 func (OsFs) Mkdir(name string) error {
-	return os.Mkdir(name, 0777)
+	if err := checkDenyACL(name, fileAppendData); err != nil {
+		return err
+	}
+	return retryOnStale(func() error { return os.Mkdir(name, 0777) })
 }
 
 // Symlink creates source as a symbolic link to target.
@@ -98,28 +140,45 @@ func (OsFs) Symlink(source, target string) error {
 
 // Chown changes the numeric uid and gid of the named file.
 func (OsFs) Chown(name string, uid int, gid int) error {
-	return os.Chown(name, uid, gid)
+	return ignoreUnsupported(os.Chown(name, uid, gid))
 }
 
 // Chmod changes the mode of the named file to mode
 func (OsFs) Chmod(name string, mode os.FileMode) error {
-	return os.Chmod(name, mode)
+	return ignoreUnsupported(os.Chmod(name, mode))
 }
 
 // Chtimes changes the access and modification times of the named file
 func (OsFs) Chtimes(name string, atime, mtime time.Time) error {
-	return os.Chtimes(name, atime, mtime)
+	return ignoreUnsupported(os.Chtimes(name, atime, mtime))
+}
+
+// Truncate changes the size of the named file
+func (OsFs) Truncate(name string, size int64) error {
+	if err := checkDenyACL(name, fileWriteData); err != nil {
+		return err
+	}
+	return retryOnStale(func() error { return os.Truncate(name, size) })
 }
 
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (OsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	f, err := os.Open(dirname)
-	if err != nil {
+	if err := checkDenyACL(dirname, fileReadData); err != nil {
 		return nil, err
 	}
-	list, err := f.Readdir(-1)
-	f.Close()
+	var list []os.FileInfo
+	err := RunWithTimeout(fsTimeouts.Local.GetList(), func() error {
+		return retryOnStale(func() error {
+			f, err := os.Open(dirname)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			list, err = f.Readdir(-1)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -171,11 +230,18 @@ func (fs OsFs) CheckRootPath(username string, uid int, gid int) bool {
 }
 
 // ScanRootDirContents returns the number of files contained in a directory and
-// their size
+// their size. The shared folders are not included, their quota is tracked separately
 func (fs OsFs) ScanRootDirContents() (int, int64, error) {
-	numFiles, size, err := fs.getDirSize(fs.rootDir)
+	return fs.scanRootDirContents(nil)
+}
+
+func (fs OsFs) scanRootDirContents(progress *ScanProgress) (int, int64, error) {
+	numFiles, size, err := fs.getDirSize(fs.rootDir, progress)
 	for _, v := range fs.virtualFolders {
-		num, s, err := fs.getDirSize(v.MappedPath)
+		if v.IsShared() {
+			continue
+		}
+		num, s, err := fs.getDirSize(v.MappedPath, progress)
 		if err != nil {
 			if fs.IsNotExist(err) {
 				fsLog(fs, logger.LevelWarn, "unable to scan contents for non-existent mapped path: %#v", v.MappedPath)
@@ -189,6 +255,12 @@ func (fs OsFs) ScanRootDirContents() (int, int64, error) {
 	return numFiles, size, err
 }
 
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (OsFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
 // GetAtomicUploadPath returns the path to use for an atomic upload
 func (OsFs) GetAtomicUploadPath(name string) string {
 	dir := filepath.Dir(name)
@@ -368,21 +440,12 @@ func (fs *OsFs) createMissingDirs(filePath string, uid, gid int) error {
 	return nil
 }
 
-func (fs *OsFs) getDirSize(dirname string) (int, int64, error) {
+func (fs *OsFs) getDirSize(dirname string, progress *ScanProgress) (int, int64, error) {
 	numFiles := 0
 	size := int64(0)
 	isDir, err := IsDirectory(fs, dirname)
 	if err == nil && isDir {
-		err = filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info != nil && info.Mode().IsRegular() {
-				size += info.Size()
-				numFiles++
-			}
-			return err
-		})
+		numFiles, size, err = newDirScanner(getQuotaScanWorkers(), progress).scan(dirname)
 	}
 	return numFiles, size, err
 }
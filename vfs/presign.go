@@ -0,0 +1,38 @@
+package vfs
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrPresignedURLUnsupported is returned if the filesystem cannot generate pre-signed URLs
+var ErrPresignedURLUnsupported = errors.New("pre-signed URLs are not supported for this filesystem")
+
+// PresignedURLFs is implemented by the cloud storage backends that can generate short-lived
+// signed URLs, so the clients can download and upload objects directly without streaming
+// the contents through SFTPGo
+type PresignedURLFs interface {
+	// GetPresignedURL returns a signed URL for the object with the given filesystem path.
+	// method must be http.MethodGet for downloads or http.MethodPut for uploads
+	GetPresignedURL(name, method string, expires time.Duration) (string, error)
+}
+
+// GetPresignedURL returns a signed URL for the given filesystem path if the filesystem
+// supports pre-signed URLs, ErrPresignedURLUnsupported otherwise
+func GetPresignedURL(fs Fs, name, method string, expires time.Duration) (string, error) {
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", errors.New("unsupported method for a pre-signed URL: " + method)
+	}
+	presigner, ok := fs.(PresignedURLFs)
+	if !ok {
+		return "", ErrPresignedURLUnsupported
+	}
+	return presigner.GetPresignedURL(name, method, expires)
+}
+
+// IsPresignedURLSupported returns true if the filesystem can generate pre-signed URLs
+func IsPresignedURLSupported(fs Fs) bool {
+	_, ok := fs.(PresignedURLFs)
+	return ok
+}
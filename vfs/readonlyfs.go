@@ -0,0 +1,178 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// ReadOnlyFs is a Fs implementation that wraps another Fs and refuses any operation that
+// modifies the filesystem contents. The read operations are handled by the wrapped Fs.
+// The Fs name is the wrapped one, so the backend specific features are not affected
+type ReadOnlyFs struct {
+	fs Fs
+}
+
+// NewReadOnlyFs returns a read only Fs for the given filesystem
+func NewReadOnlyFs(fs Fs) Fs {
+	if _, ok := fs.(*ReadOnlyFs); ok {
+		return fs
+	}
+	return &ReadOnlyFs{
+		fs: fs,
+	}
+}
+
+// IsReadOnlyFs returns true if fs refuses any operation that modifies the filesystem contents
+func IsReadOnlyFs(fs Fs) bool {
+	_, ok := fs.(*ReadOnlyFs)
+	return ok
+}
+
+func (fs *ReadOnlyFs) deny(op, name string) error {
+	fsLog(fs, logger.LevelInfo, "operation %#v denied for path %#v, the filesystem is read only", op, name)
+	return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+}
+
+// Name returns the name for the wrapped Fs implementation
+func (fs *ReadOnlyFs) Name() string {
+	return fs.fs.Name()
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs *ReadOnlyFs) ConnectionID() string {
+	return fs.fs.ConnectionID()
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *ReadOnlyFs) Stat(name string) (os.FileInfo, error) {
+	return fs.fs.Stat(name)
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *ReadOnlyFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.fs.Lstat(name)
+}
+
+// Open opens the named file for reading
+func (fs *ReadOnlyFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	return fs.fs.Open(name)
+}
+
+// Create always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	return nil, nil, nil, fs.deny("create", name)
+}
+
+// Rename always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Rename(source, target string) error {
+	return fs.deny("rename", source)
+}
+
+// Remove always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Remove(name string, isDir bool) error {
+	return fs.deny("remove", name)
+}
+
+// Mkdir always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Mkdir(name string) error {
+	return fs.deny("mkdir", name)
+}
+
+// Symlink always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Symlink(source, target string) error {
+	return fs.deny("symlink", target)
+}
+
+// Chown always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Chown(name string, uid int, gid int) error {
+	return fs.deny("chown", name)
+}
+
+// Chmod always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Chmod(name string, mode os.FileMode) error {
+	return fs.deny("chmod", name)
+}
+
+// Chtimes always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.deny("chtimes", name)
+}
+
+// Truncate always fails, the filesystem is read only
+func (fs *ReadOnlyFs) Truncate(name string, size int64) error {
+	return fs.deny("truncate", name)
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries
+func (fs *ReadOnlyFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return fs.fs.ReadDir(dirname)
+}
+
+// IsUploadResumeSupported returns false, uploads are not allowed
+func (fs *ReadOnlyFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns false, uploads are not allowed
+func (fs *ReadOnlyFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// CheckRootPath checks the root directory using the wrapped Fs. The root directory
+// is created if missing, this way a read only user can login as any other user
+func (fs *ReadOnlyFs) CheckRootPath(username string, uid int, gid int) bool {
+	return fs.fs.CheckRootPath(username, uid, gid)
+}
+
+// ResolvePath returns the matching filesystem path for the specified sftp path
+func (fs *ReadOnlyFs) ResolvePath(sftpPath string) (string, error) {
+	return fs.fs.ResolvePath(sftpPath)
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (fs *ReadOnlyFs) IsNotExist(err error) bool {
+	return fs.fs.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (fs *ReadOnlyFs) IsPermission(err error) bool {
+	return os.IsPermission(err) || fs.fs.IsPermission(err)
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *ReadOnlyFs) ScanRootDirContents() (int, int64, error) {
+	return fs.fs.ScanRootDirContents()
+}
+
+func (fs *ReadOnlyFs) scanRootDirContents(progress *ScanProgress) (int, int64, error) {
+	return ScanRootDirContentsWithProgress(fs.fs, progress)
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *ReadOnlyFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.fs.Walk(root, walkFn)
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (fs *ReadOnlyFs) GetAtomicUploadPath(name string) string {
+	return fs.fs.GetAtomicUploadPath(name)
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTP users
+func (fs *ReadOnlyFs) GetRelativePath(name string) string {
+	return fs.fs.GetRelativePath(name)
+}
+
+// Join joins any number of path elements into a single path
+func (fs *ReadOnlyFs) Join(elem ...string) string {
+	return fs.fs.Join(elem...)
+}
@@ -1,20 +1,30 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
@@ -34,6 +44,14 @@ type S3FsConfig struct {
 	Region       string `json:"region,omitempty"`
 	AccessKey    string `json:"access_key,omitempty"`
 	AccessSecret string `json:"access_secret,omitempty"`
+	// Optional session token for temporary credentials, it requires an access key
+	SessionToken string `json:"session_token,omitempty"`
+	// ARN of an IAM role to assume using AWS STS. The role is assumed using the access key,
+	// if any, or the default AWS credential chain. The temporary credentials are automatically
+	// refreshed before they expire
+	RoleARN string `json:"role_arn,omitempty"`
+	// Optional external ID to use when assuming the role
+	ExternalID   string `json:"external_id,omitempty"`
 	Endpoint     string `json:"endpoint,omitempty"`
 	StorageClass string `json:"storage_class,omitempty"`
 	// The buffer size (in MB) to use for multipart uploads. The minimum allowed part size is 5MB,
@@ -46,8 +64,28 @@ type S3FsConfig struct {
 	UploadPartSize int64 `json:"upload_part_size,omitempty"`
 	// How many parts are uploaded in parallel
 	UploadConcurrency int `json:"upload_concurrency,omitempty"`
+	// The buffer size (in MB) to use for multipart downloads. The minimum allowed part size is 5MB,
+	// and if this value is set to zero, the default value (5MB) for the AWS SDK will be used.
+	DownloadPartSize int64 `json:"download_part_size,omitempty"`
+	// How many parts are downloaded in parallel. If this value is set to zero, the default value (5)
+	// for the AWS SDK will be used
+	DownloadConcurrency int `json:"download_concurrency,omitempty"`
+	// Rules to choose the storage class for each upload, the first matching rule wins.
+	// If no rule matches StorageClass is used
+	StorageClassRules []StorageClassRule `json:"storage_class_rules,omitempty"`
+	// Use path-style requests, for example "https://endpoint/bucket/key", instead of
+	// virtual hosted-style requests. Path-style is always used if a custom endpoint is set
+	ForcePathStyle bool `json:"force_path_style"`
+	// Skip the TLS certificate verification for the endpoint. This is insecure and it should
+	// be used for testing only, setting a CA bundle is the preferred way to trust a private PKI
+	SkipTLSVerify bool `json:"skip_tls_verify"`
+	// PEM encoded CA certificates to trust, in addition to the system ones, for the endpoint
+	CABundle string `json:"ca_bundle,omitempty"`
 }
 
+// session name used to assume the configured IAM roles, it is visible in AWS CloudTrail
+const s3RoleSessionName = "SFTPGo"
+
 // S3Fs is a Fs implementation for Amazon S3 compatible object storage.
 type S3Fs struct {
 	connectionID   string
@@ -58,6 +96,10 @@ type S3Fs struct {
 	ctxLongTimeout time.Duration
 }
 
+func init() {
+	utils.AddFeature("+s3")
+}
+
 // NewS3Fs returns an S3Fs object that allows to interact with an s3 compatible
 // object storage
 func NewS3Fs(connectionID, localTempDir string, config S3FsConfig) (Fs, error) {
@@ -78,17 +120,40 @@ func NewS3Fs(connectionID, localTempDir string, config S3FsConfig) (Fs, error) {
 	}
 
 	if len(fs.config.AccessSecret) > 0 {
-		accessSecret, err := utils.DecryptData(fs.config.AccessSecret)
+		accessSecret, err := kms.Decrypt(fs.config.AccessSecret)
 		if err != nil {
 			return fs, err
 		}
 		fs.config.AccessSecret = accessSecret
-		awsConfig.Credentials = credentials.NewStaticCredentials(fs.config.AccessKey, fs.config.AccessSecret, "")
+		if len(fs.config.SessionToken) > 0 {
+			sessionToken, err := kms.Decrypt(fs.config.SessionToken)
+			if err != nil {
+				return fs, err
+			}
+			fs.config.SessionToken = sessionToken
+		}
+		awsConfig.Credentials = credentials.NewStaticCredentials(fs.config.AccessKey, fs.config.AccessSecret,
+			fs.config.SessionToken)
+	} else {
+		fsLog(fs, logger.LevelDebug, "no access key provided, using the default AWS credential chain")
 	}
 
 	if len(fs.config.Endpoint) > 0 {
 		awsConfig.Endpoint = aws.String(fs.config.Endpoint)
 		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	} else if fs.config.ForcePathStyle {
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	if fs.config.SkipTLSVerify || len(fs.config.CABundle) > 0 {
+		client, err := getS3HTTPClient(fs.config)
+		if err != nil {
+			return fs, err
+		}
+		if fs.config.SkipTLSVerify {
+			fsLog(fs, logger.LevelWarn, "TLS certificate verification is disabled for bucket %#v", fs.config.Bucket)
+		}
+		awsConfig.HTTPClient = client
 	}
 
 	if fs.config.UploadPartSize == 0 {
@@ -99,6 +164,14 @@ func NewS3Fs(connectionID, localTempDir string, config S3FsConfig) (Fs, error) {
 	if fs.config.UploadConcurrency == 0 {
 		fs.config.UploadConcurrency = 2
 	}
+	if fs.config.DownloadPartSize == 0 {
+		fs.config.DownloadPartSize = s3manager.DefaultDownloadPartSize
+	} else {
+		fs.config.DownloadPartSize *= 1024 * 1024
+	}
+	if fs.config.DownloadConcurrency == 0 {
+		fs.config.DownloadConcurrency = s3manager.DefaultDownloadConcurrency
+	}
 
 	sessOpts := session.Options{
 		Config:            *awsConfig,
@@ -108,10 +181,45 @@ func NewS3Fs(connectionID, localTempDir string, config S3FsConfig) (Fs, error) {
 	if err != nil {
 		return fs, err
 	}
+	if len(fs.config.RoleARN) > 0 {
+		// the credentials configured above are used to assume the role
+		sessOpts.Config.Credentials = stscreds.NewCredentials(sess, fs.config.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = s3RoleSessionName
+			if len(fs.config.ExternalID) > 0 {
+				p.ExternalID = aws.String(fs.config.ExternalID)
+			}
+		})
+		sess, err = session.NewSessionWithOptions(sessOpts)
+		if err != nil {
+			return fs, err
+		}
+		fsLog(fs, logger.LevelDebug, "using the credentials for the assumed role %#v", fs.config.RoleARN)
+	}
 	fs.svc = s3.New(sess)
 	return fs, nil
 }
 
+// getS3HTTPClient returns an HTTP client that trusts the configured CA bundle or
+// that skips the TLS certificate verification
+func getS3HTTPClient(config S3FsConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.SkipTLSVerify,
+	}
+	if len(config.CABundle) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM([]byte(config.CABundle)) {
+			return nil, errors.New("unable to parse the CA bundle")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
 // Name returns the name for the Fs implementation
 func (fs S3Fs) Name() string {
 	return fmt.Sprintf("S3Fs bucket: %#v", fs.config.Bucket)
@@ -144,7 +252,7 @@ func (fs S3Fs) Stat(name string) (os.FileInfo, error) {
 			prefix += "/"
 		}
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	ctx, cancelFn := getOperationContext(fsTimeouts.S3.GetStat(), fs.ctxTimeout)
 	defer cancelFn()
 	err := fs.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket:    aws.String(fs.config.Bucket),
@@ -170,6 +278,9 @@ func (fs S3Fs) Stat(name string) (os.FileInfo, error) {
 	})
 	metrics.S3ListObjectsCompleted(err)
 	if err == nil && len(result.Name()) == 0 {
+		if info, ok := fs.getPartialUploadInfo(name); ok {
+			return info, nil
+		}
 		err = errors.New("404 no such file or directory")
 	}
 	return result, err
@@ -181,50 +292,81 @@ func (fs S3Fs) Lstat(name string) (os.FileInfo, error) {
 }
 
 // Open opens the named file for reading
-func (fs S3Fs) Open(name string) (*os.File, *pipeat.PipeReaderAt, func(), error) {
+func (fs S3Fs) Open(name string) (*os.File, PipeReader, func(), error) {
+	cachedFile, info := openCachedFile(fs, fs.getDiskCacheBackend(), name)
+	if cachedFile != nil {
+		return cachedFile, nil, nil, nil
+	}
 	r, w, err := pipeat.AsyncWriterPipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	ctx, cancelFn := context.WithCancel(context.Background())
 	downloader := s3manager.NewDownloaderWithClient(fs.svc)
+	cacheWriter := getDownloadCacheWriter(fs.getDiskCacheBackend(), name, info)
 	go func() {
 		defer cancelFn()
 		key := name
-		n, err := downloader.DownloadWithContext(ctx, w, &s3.GetObjectInput{
+		n, err := downloader.DownloadWithContext(ctx, getDownloadWriter(w, cacheWriter), &s3.GetObjectInput{
 			Bucket: aws.String(fs.config.Bucket),
 			Key:    aws.String(key),
+		}, func(d *s3manager.Downloader) {
+			d.Concurrency = fs.config.DownloadConcurrency
+			d.PartSize = fs.config.DownloadPartSize
 		})
 		w.CloseWithError(err)
+		if cacheWriter != nil {
+			cacheWriter.Close(err, info.Size(), info.ModTime())
+		}
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
 		metrics.S3TransferCompleted(n, 1, err)
 	}()
 	return nil, r, cancelFn, nil
 }
 
-// Create creates or opens the named file for writing
-func (fs S3Fs) Create(name string, flag int) (*os.File, *pipeat.PipeWriterAt, func(), error) {
+// Create creates or opens the named file for writing.
+// If the upload journal is enabled, os.O_APPEND resumes an interrupted upload
+func (fs S3Fs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	if journal := cloudUploadJournal; journal != nil {
+		id := fs.getUploadJournalID(name)
+		if journal.acquire(id) {
+			return fs.createJournaledUpload(journal, id, name, flag)
+		}
+		if flag&os.O_APPEND != 0 {
+			return nil, nil, nil, fmt.Errorf("unable to resume the upload for %#v: another upload is in progress", name)
+		}
+		fsLog(fs, logger.LevelInfo, "another upload for %#v is in progress, this upload cannot be resumed", name)
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	ctx, cancelFn := context.WithCancel(context.Background())
 	uploader := s3manager.NewUploaderWithClient(fs.svc)
+	removeCachedFile(fs.getDiskCacheBackend(), name)
+	cacheWriter := getUploadCacheWriter(fs.getDiskCacheBackend(), name)
 	go func() {
 		defer cancelFn()
 		key := name
+		storageClass := getStorageClassForUpload(fs.config.StorageClassRules, fs.config.StorageClass,
+			fs.GetRelativePath(name), r)
+		var body io.Reader = r
+		if cacheWriter != nil {
+			body = &diskCacheReader{PipeReaderAt: r, cacheWriter: cacheWriter}
+		}
 		response, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 			Bucket:       aws.String(fs.config.Bucket),
 			Key:          aws.String(key),
-			Body:         r,
-			StorageClass: utils.NilIfEmpty(fs.config.StorageClass),
+			Body:         body,
+			StorageClass: utils.NilIfEmpty(storageClass),
 		}, func(u *s3manager.Uploader) {
 			u.Concurrency = fs.config.UploadConcurrency
 			u.PartSize = fs.config.UploadPartSize
 		})
 		r.CloseWithError(err)
-		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, response: %v, readed bytes: %v, err: %+v",
-			name, response, r.GetReadedBytes(), err)
+		closeUploadCacheWriter(fs, cacheWriter, name, err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, storage class: %#v, response: %v, readed bytes: %v, err: %+v",
+			name, storageClass, response, r.GetReadedBytes(), err)
 		metrics.S3TransferCompleted(r.GetReadedBytes(), 0, err)
 	}()
 	return nil, w, cancelFn, nil
@@ -239,7 +381,6 @@ func (fs S3Fs) Create(name string, flag int) (*os.File, *pipeat.PipeWriterAt, fu
 // multipart copy or wait for this pull request to be merged:
 //
 // https://github.com/aws/aws-sdk-go/pull/2653
-//
 func (fs S3Fs) Rename(source, target string) error {
 	if source == target {
 		return nil
@@ -275,11 +416,16 @@ func (fs S3Fs) Rename(source, target string) error {
 	if err != nil {
 		return err
 	}
+	removeCachedFile(fs.getDiskCacheBackend(), target)
 	return fs.Remove(source, fi.IsDir())
 }
 
 // Remove removes the named file or (empty) directory.
+// An interrupted upload for the named file, if any, is aborted
 func (fs S3Fs) Remove(name string, isDir bool) error {
+	if !isDir {
+		fs.abortJournaledUpload(name)
+	}
 	if isDir {
 		contents, err := fs.ReadDir(name)
 		if err != nil {
@@ -299,9 +445,17 @@ func (fs S3Fs) Remove(name string, isDir bool) error {
 		Key:    aws.String(name),
 	})
 	metrics.S3DeleteObjectCompleted(err)
+	if err == nil && !isDir {
+		removeCachedFile(fs.getDiskCacheBackend(), name)
+	}
 	return err
 }
 
+// getDiskCacheBackend returns the backend identifier for the disk cache keys
+func (fs S3Fs) getDiskCacheBackend() string {
+	return "s3:" + fs.config.Endpoint + "/" + fs.config.Bucket
+}
+
 // Mkdir creates a new directory with the specified name and default permissions
 func (fs S3Fs) Mkdir(name string) error {
 	_, err := fs.Stat(name)
@@ -341,6 +495,12 @@ func (S3Fs) Chtimes(name string, atime, mtime time.Time) error {
 	return errors.New("403 chtimes is not supported")
 }
 
+// Truncate changes the size of the named file.
+// Truncate is not supported for S3
+func (S3Fs) Truncate(name string, size int64) error {
+	return errors.New("403 truncate is not supported")
+}
+
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
@@ -353,7 +513,7 @@ func (fs S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			prefix += "/"
 		}
 	}
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	ctx, cancelFn := getOperationContext(fsTimeouts.S3.GetList(), fs.ctxTimeout)
 	defer cancelFn()
 	err := fs.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket:    aws.String(fs.config.Bucket),
@@ -380,7 +540,8 @@ func (fs S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 }
 
 // IsUploadResumeSupported returns true if upload resume is supported.
-// SFTP Resume is not supported on S3
+// SFTP Resume is not supported on S3, only the interrupted uploads stored
+// inside the upload journal can be resumed
 func (S3Fs) IsUploadResumeSupported() bool {
 	return false
 }
@@ -455,6 +616,37 @@ func (fs S3Fs) ScanRootDirContents() (int, int64, error) {
 	return numFiles, size, err
 }
 
+// Walk calls walkFn for each object whose key starts with the prefix identified by root.
+// Directories are not walked in lexical order and filepath.SkipDir is not supported
+func (fs S3Fs) Walk(root string, walkFn filepath.WalkFunc) error {
+	prefix := strings.TrimPrefix(root, "/")
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var walkErr error
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	err := fs.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, fileObject := range page.Contents {
+			isDir := strings.HasSuffix(*fileObject.Key, "/")
+			name := path.Base(*fileObject.Key)
+			walkErr = walkFn("/"+*fileObject.Key, NewFileInfo(name, isDir, *fileObject.Size, *fileObject.LastModified), nil)
+			if walkErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	metrics.S3ListObjectsCompleted(err)
+	if walkErr != nil {
+		return walkErr
+	}
+	return err
+}
+
 // GetAtomicUploadPath returns the path to use for an atomic upload.
 // S3 uploads are already atomic, we never call this method for S3
 func (S3Fs) GetAtomicUploadPath(name string) string {
@@ -520,6 +712,27 @@ func (fs *S3Fs) isEqual(s3Key *string, sftpName string) bool {
 	return false
 }
 
+// GetPresignedURL returns a signed URL to download, using GET, or upload, using PUT, the given
+// object. The storage class is not included in the signature for uploads, so the bucket default is used
+func (fs S3Fs) GetPresignedURL(name, method string, expires time.Duration) (string, error) {
+	var req *request.Request
+	if method == http.MethodPut {
+		req, _ = fs.svc.PutObjectRequest(&s3.PutObjectInput{
+			Bucket: aws.String(fs.config.Bucket),
+			Key:    aws.String(name),
+		})
+	} else {
+		req, _ = fs.svc.GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String(fs.config.Bucket),
+			Key:    aws.String(name),
+		})
+	}
+	url, err := req.Presign(expires)
+	fsLog(fs, logger.LevelDebug, "pre-signed URL generated, method: %v path: %#v expires: %v err: %v", method, name,
+		expires, err)
+	return url, err
+}
+
 func (fs *S3Fs) checkIfBucketExists() error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -539,3 +752,338 @@ func (fs *S3Fs) getObjectDetails(key string) (*s3.HeadObjectOutput, error) {
 	}
 	return fs.svc.HeadObjectWithContext(ctx, input)
 }
+
+// getUploadJournalID returns the journal ID for the named object, an interrupted upload
+// is resumed only using the same credentials
+func (fs S3Fs) getUploadJournalID(name string) string {
+	return getUploadJournalID(fs.getDiskCacheBackend(), fs.config.AccessKey+"\x00"+fs.config.RoleARN, name)
+}
+
+// getJournalConfig returns the filesystem configuration to store inside the upload journal,
+// the secrets are encrypted
+func (fs S3Fs) getJournalConfig() (S3FsConfig, error) {
+	config := fs.config
+	config.UploadPartSize = 0
+	config.DownloadPartSize = 0
+	config.StorageClassRules = nil
+	var err error
+	if len(config.AccessSecret) > 0 {
+		if config.AccessSecret, err = kms.Encrypt(config.AccessSecret); err != nil {
+			return config, err
+		}
+	}
+	if len(config.SessionToken) > 0 {
+		if config.SessionToken, err = kms.Encrypt(config.SessionToken); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// getPartialUploadInfo returns the file info for an interrupted upload for the named object,
+// the size is the one the upload can be resumed from
+func (fs S3Fs) getPartialUploadInfo(name string) (FileInfo, bool) {
+	journal := cloudUploadJournal
+	if journal == nil {
+		return FileInfo{}, false
+	}
+	id := fs.getUploadJournalID(name)
+	if journal.isActive(id) {
+		return FileInfo{}, false
+	}
+	entry, err := journal.load(id)
+	if err != nil {
+		return FileInfo{}, false
+	}
+	info := NewFileInfo(name, false, entry.getResumableSize(), utils.GetTimeFromMsecSinceEpoch(entry.UpdatedAt))
+	info.partialUpload = true
+	return info, true
+}
+
+// abortJournaledUpload aborts the interrupted upload for the named object, if any
+func (fs S3Fs) abortJournaledUpload(name string) {
+	journal := cloudUploadJournal
+	if journal == nil {
+		return
+	}
+	id := fs.getUploadJournalID(name)
+	if !journal.acquire(id) {
+		return
+	}
+	defer journal.release(id)
+
+	fs.abortJournalEntry(journal, id, name)
+}
+
+// abortJournalEntry aborts the upload stored inside the journal with the given ID, if any.
+// The upload ID must be already acquired
+func (fs S3Fs) abortJournalEntry(journal *uploadJournal, id, name string) {
+	entry, err := journal.load(id)
+	if err != nil {
+		return
+	}
+	err = fs.abortMultipartUpload(entry)
+	fsLog(fs, logger.LevelDebug, "interrupted upload for %#v aborted, upload ID: %#v, err: %v", name, entry.UploadID, err)
+	if err == nil {
+		journal.remove(id)
+	}
+}
+
+// abortMultipartUpload aborts the given upload, an upload that does not exist anymore
+// is considered aborted
+func (fs S3Fs) abortMultipartUpload(entry *uploadJournalEntry) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	_, err := fs.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(entry.Name),
+		UploadId: aws.String(entry.UploadID),
+	})
+	if isNoSuchUploadError(err) {
+		return nil
+	}
+	return err
+}
+
+func isNoSuchUploadError(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == s3.ErrCodeNoSuchUpload
+	}
+	return false
+}
+
+// createJournaledUpload starts a multipart upload, or resumes an interrupted one, storing the
+// state inside the upload journal. The upload ID must be already acquired
+func (fs S3Fs) createJournaledUpload(journal *uploadJournal, id, name string, flag int) (*os.File, PipeWriter, func(), error) {
+	var entry *uploadJournalEntry
+	var err error
+	if flag&os.O_APPEND != 0 {
+		entry, err = fs.getUploadToResume(journal, id, name)
+	} else {
+		// a new upload replaces an interrupted one
+		fs.abortJournalEntry(journal, id, name)
+	}
+	if err != nil {
+		journal.release(id)
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		journal.release(id)
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	removeCachedFile(fs.getDiskCacheBackend(), name)
+	go func() {
+		defer cancelFn()
+		defer journal.release(id)
+
+		var resumedSize int64
+		if entry != nil {
+			resumedSize = entry.getResumableSize()
+		}
+		err := fs.uploadJournaled(ctx, journal, id, name, entry, r)
+		r.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "journaled upload completed, path: %#v, resumed from: %v, readed bytes: %v, err: %+v",
+			name, resumedSize, r.GetReadedBytes(), err)
+		metrics.S3TransferCompleted(r.GetReadedBytes(), 0, err)
+	}()
+	return nil, w, cancelFn, nil
+}
+
+// getUploadToResume returns the interrupted upload to resume. The uploaded parts are checked
+// and, if some parts are missing, the upload cannot be resumed from the size already returned
+// by Stat, so an error is returned and the client can retry from the updated size
+func (fs S3Fs) getUploadToResume(journal *uploadJournal, id, name string) (*uploadJournalEntry, error) {
+	entry, err := journal.load(id)
+	if err != nil {
+		return nil, fmt.Errorf("no interrupted upload to resume for %#v: %v", name, err)
+	}
+	resumableSize := entry.getResumableSize()
+	uploadedParts := make(map[int64]string)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	err = fs.svc.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(entry.Name),
+		UploadId: aws.String(entry.UploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, p := range page.Parts {
+			uploadedParts[aws.Int64Value(p.PartNumber)] = aws.StringValue(p.ETag)
+		}
+		return true
+	})
+	if err != nil {
+		if isNoSuchUploadError(err) {
+			journal.remove(id)
+		}
+		return nil, fmt.Errorf("unable to resume the upload for %#v: %v", name, err)
+	}
+	var parts []uploadJournalPart
+	for _, part := range entry.Parts {
+		if etag, ok := uploadedParts[part.Number]; ok && etag == part.ETag {
+			parts = append(parts, part)
+		}
+	}
+	entry.Parts = parts
+	if entry.getResumableSize() != resumableSize {
+		if err = journal.save(entry); err != nil {
+			fsLog(fs, logger.LevelWarn, "unable to save the journal entry for %#v: %v", name, err)
+		}
+		return nil, fmt.Errorf("unable to resume the upload for %#v: some parts are missing, the size to resume from "+
+			"changed from %v to %v", name, resumableSize, entry.getResumableSize())
+	}
+	return entry, nil
+}
+
+// startJournaledUpload starts a new multipart upload and stores it inside the journal
+func (fs S3Fs) startJournaledUpload(ctx context.Context, journal *uploadJournal, id, name,
+	storageClass string) (*uploadJournalEntry, error) {
+	config, err := fs.getJournalConfig()
+	if err != nil {
+		return nil, err
+	}
+	out, err := fs.svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(name),
+		StorageClass: utils.NilIfEmpty(storageClass),
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry := &uploadJournalEntry{
+		id:           id,
+		Name:         name,
+		UploadID:     aws.StringValue(out.UploadId),
+		PartSize:     fs.config.UploadPartSize,
+		StorageClass: storageClass,
+		S3Config:     config,
+		CreatedAt:    utils.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	if err = journal.save(entry); err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to save the journal entry for %#v, aborting the upload: %v", name, err)
+		if errAbort := fs.abortMultipartUpload(entry); errAbort != nil {
+			fsLog(fs, logger.LevelWarn, "unable to abort the upload for %#v: %v", name, errAbort)
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// uploadJournaled uploads the data read from the pipe as parts of the given multipart upload,
+// a new upload is started if entry is nil. Each uploaded part is stored inside the journal.
+// If the upload fails or it is canceled the uploaded parts are preserved so the upload can
+// be resumed, it is completed when the whole pipe is read
+func (fs S3Fs) uploadJournaled(ctx context.Context, journal *uploadJournal, id, name string,
+	entry *uploadJournalEntry, r *pipeat.PipeReaderAt) error {
+	var err error
+	if entry == nil {
+		storageClass := getStorageClassForUpload(fs.config.StorageClassRules, fs.config.StorageClass,
+			fs.GetRelativePath(name), r)
+		entry, err = fs.startJournaledUpload(ctx, journal, id, name, storageClass)
+		if err != nil {
+			return err
+		}
+	}
+	partNumber := int64(len(entry.getResumableParts())) + 1
+	var uploadErr error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	guard := make(chan struct{}, fs.config.UploadConcurrency)
+
+	for {
+		buf := make([]byte, entry.PartSize)
+		n, errRead := io.ReadFull(r, buf)
+		if n > 0 || partNumber == 1 {
+			guard <- struct{}{}
+			wg.Add(1)
+			go func(number int64, data []byte) {
+				defer func() {
+					<-guard
+					wg.Done()
+				}()
+				part, err := fs.uploadPart(ctx, entry, number, data)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					if uploadErr == nil {
+						uploadErr = err
+					}
+					return
+				}
+				entry.addPart(part)
+				if err = journal.save(entry); err != nil {
+					fsLog(fs, logger.LevelWarn, "unable to save the journal entry for %#v: %v", name, err)
+				}
+			}(partNumber, buf[:n])
+			partNumber++
+		}
+		if errRead == io.EOF || errRead == io.ErrUnexpectedEOF {
+			break
+		}
+		mu.Lock()
+		if errRead != nil && uploadErr == nil {
+			uploadErr = errRead
+		}
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+	}
+	wg.Wait()
+
+	if uploadErr == nil {
+		uploadErr = ctx.Err()
+	}
+	if uploadErr != nil {
+		fsLog(fs, logger.LevelInfo, "upload for %#v interrupted, it can be resumed from size %v, upload ID: %#v, err: %v",
+			name, entry.getResumableSize(), entry.UploadID, uploadErr)
+		return uploadErr
+	}
+	var completedParts []*s3.CompletedPart
+	for _, part := range entry.Parts {
+		if part.Number >= partNumber {
+			// uploaded before the upload was resumed
+			break
+		}
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+	}
+	completeCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	_, err = fs.svc.CompleteMultipartUploadWithContext(completeCtx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(entry.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	journal.remove(id)
+	return nil
+}
+
+func (fs S3Fs) uploadPart(ctx context.Context, entry *uploadJournalEntry, number int64, data []byte) (uploadJournalPart, error) {
+	out, err := fs.svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(fs.config.Bucket),
+		Key:           aws.String(entry.Name),
+		UploadId:      aws.String(entry.UploadID),
+		PartNumber:    aws.Int64(number),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return uploadJournalPart{}, err
+	}
+	return uploadJournalPart{
+		Number: number,
+		ETag:   aws.StringValue(out.ETag),
+		Size:   int64(len(data)),
+	}, nil
+}
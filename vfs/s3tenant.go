@@ -0,0 +1,154 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ErrS3PrefixNotIsolated is returned if the credentials for a key prefix can access objects outside it
+var ErrS3PrefixNotIsolated = errors.New("the credentials can access objects outside the key prefix")
+
+// the bucket policy is read, modified and written back, so we serialize the updates
+// done by this instance
+var s3PolicyLock sync.Mutex
+
+// newS3TenantFs returns an S3Fs for the bucket in the given config. If useOwnCredentials
+// is false the credentials in config are ignored and the default AWS credential chain,
+// usually with more privileges than the tenant ones, is used
+func newS3TenantFs(config S3FsConfig, useOwnCredentials bool) (*S3Fs, error) {
+	if !useOwnCredentials {
+		config.AccessKey = ""
+		config.AccessSecret = ""
+		config.SessionToken = ""
+		config.RoleARN = ""
+		config.ExternalID = ""
+	}
+	fs, err := NewS3Fs("", "", config)
+	if err != nil {
+		return nil, err
+	}
+	s3Fs := fs.(S3Fs)
+	return &s3Fs, nil
+}
+
+// CreateS3KeyPrefix creates the "folder" object for the key prefix in the given config,
+// using the default AWS credential chain
+func CreateS3KeyPrefix(config S3FsConfig) error {
+	if len(config.KeyPrefix) == 0 {
+		return errors.New("the key prefix is empty")
+	}
+	fs, err := newS3TenantFs(config, false)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	_, err = fs.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Key:    aws.String(fs.config.KeyPrefix),
+		Body:   bytes.NewReader(nil),
+	})
+	return err
+}
+
+// ApplyS3KeyPrefixPolicy adds the given statement, with the given statement ID, to the policy
+// for the bucket in config, using the default AWS credential chain. An existing statement with
+// the same ID is replaced, the other statements are preserved
+func ApplyS3KeyPrefixPolicy(config S3FsConfig, sid string, statement map[string]interface{}) error {
+	fs, err := newS3TenantFs(config, false)
+	if err != nil {
+		return err
+	}
+	s3PolicyLock.Lock()
+	defer s3PolicyLock.Unlock()
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	policy := map[string]interface{}{
+		"Version": "2012-10-17",
+	}
+	out, err := fs.svc.GetBucketPolicyWithContext(ctx, &s3.GetBucketPolicyInput{
+		Bucket: aws.String(fs.config.Bucket),
+	})
+	if err == nil {
+		if err = json.Unmarshal([]byte(aws.StringValue(out.Policy)), &policy); err != nil {
+			return fmt.Errorf("unable to parse the current bucket policy: %v", err)
+		}
+	} else if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchBucketPolicy" {
+		return err
+	}
+	var statements []interface{}
+	switch v := policy["Statement"].(type) {
+	case []interface{}:
+		statements = v
+	case map[string]interface{}:
+		statements = []interface{}{v}
+	}
+	newStatement := make(map[string]interface{})
+	for k, v := range statement {
+		newStatement[k] = v
+	}
+	newStatement["Sid"] = sid
+	newStatements := []interface{}{newStatement}
+	for _, s := range statements {
+		if m, ok := s.(map[string]interface{}); ok && m["Sid"] == sid {
+			continue
+		}
+		newStatements = append(newStatements, s)
+	}
+	policy["Statement"] = newStatements
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = fs.svc.PutBucketPolicyWithContext(ctx, &s3.PutBucketPolicyInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Policy: aws.String(string(data)),
+	})
+	return err
+}
+
+// CheckS3KeyPrefixIsolation verifies, using the credentials in config, that the key prefix can
+// be listed while the bucket root cannot. ErrS3PrefixNotIsolated is returned if the bucket
+// root can be listed
+func CheckS3KeyPrefixIsolation(config S3FsConfig) error {
+	if len(config.AccessKey) == 0 && len(config.RoleARN) == 0 {
+		return errors.New("no credentials configured, the default AWS credential chain is used")
+	}
+	fs, err := newS3TenantFs(config, true)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	_, err = fs.svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(fs.config.Bucket),
+		Prefix:    aws.String(fs.config.KeyPrefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(1),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list the key prefix: %v", err)
+	}
+	_, err = fs.svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(fs.config.Bucket),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(1),
+	})
+	if err == nil {
+		return ErrS3PrefixNotIsolated
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" {
+		return nil
+	}
+	return fmt.Errorf("unable to check the bucket root: %v", err)
+}
@@ -0,0 +1,117 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/eikenb/pipeat"
+)
+
+// StorageClassRule defines a rule to choose the storage class for an upload
+// based on the file path, extension and size.
+// A rule matches if all its conditions match, empty conditions always match.
+// The rules are evaluated in order and the first matching one is used, if no rule
+// matches the storage class defined for the filesystem is used
+type StorageClassRule struct {
+	// SFTP path, the rule applies to the files inside this directory and its sub directories
+	Path string `json:"path,omitempty"`
+	// Case insensitive file extensions, for example ".zip"
+	Extensions []string `json:"extensions,omitempty"`
+	// Minimum file size as bytes. To evaluate this condition the upload to the cloud storage
+	// is delayed until at least min_size bytes are received or the file is closed: the received
+	// bytes are buffered in the local temporary directory
+	MinSize int64 `json:"min_size,omitempty"`
+	// Storage class to use for the matching files
+	StorageClass string `json:"storage_class"`
+}
+
+func (r *StorageClassRule) matchPath(sftpPath string) bool {
+	if len(r.Path) == 0 || r.Path == "/" {
+		return true
+	}
+	return sftpPath == r.Path || strings.HasPrefix(sftpPath, r.Path+"/")
+}
+
+func (r *StorageClassRule) matchExtension(sftpPath string) bool {
+	if len(r.Extensions) == 0 {
+		return true
+	}
+	name := strings.ToLower(path.Base(sftpPath))
+	for _, ext := range r.Extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// getStorageClassForUpload returns the storage class for the upload to the given SFTP path.
+// If a rule with a size condition needs to be evaluated it blocks until enough data are
+// written to the pipe or the writer is closed
+func getStorageClassForUpload(rules []StorageClassRule, defaultClass, sftpPath string, r *pipeat.PipeReaderAt) string {
+	for idx := range rules {
+		rule := &rules[idx]
+		if !rule.matchPath(sftpPath) || !rule.matchExtension(sftpPath) {
+			continue
+		}
+		if rule.MinSize > 0 && !hasMinSize(r, rule.MinSize) {
+			continue
+		}
+		return rule.StorageClass
+	}
+	return defaultClass
+}
+
+// hasMinSize returns true if at least size bytes can be read from the pipe.
+// ReadAt does not change the offset used by Read
+func hasMinSize(r *pipeat.PipeReaderAt, size int64) bool {
+	buf := make([]byte, 1)
+	n, _ := r.ReadAt(buf, size-1)
+	return n == 1
+}
+
+func validateStorageClassRules(rules []StorageClassRule) error {
+	for idx := range rules {
+		rule := &rules[idx]
+		if len(rule.StorageClass) == 0 {
+			return errors.New("storage class rules: storage_class cannot be empty")
+		}
+		if rule.MinSize < 0 {
+			return fmt.Errorf("storage class rules: invalid min_size %v", rule.MinSize)
+		}
+		if len(rule.Path) > 0 {
+			if !path.IsAbs(rule.Path) {
+				return fmt.Errorf("storage class rules: invalid path %#v, it must be absolute", rule.Path)
+			}
+			rule.Path = path.Clean(rule.Path)
+		}
+		var extensions []string
+		for _, ext := range rule.Extensions {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if len(ext) == 0 {
+				return errors.New("storage class rules: extensions cannot contain empty values")
+			}
+			extensions = append(extensions, ext)
+		}
+		rule.Extensions = extensions
+	}
+	return nil
+}
+
+// CopyStorageClassRules returns a deep copy of the given rules
+func CopyStorageClassRules(rules []StorageClassRule) []StorageClassRule {
+	result := make([]StorageClassRule, 0, len(rules))
+	for _, rule := range rules {
+		extensions := make([]string, len(rule.Extensions))
+		copy(extensions, rule.Extensions)
+		result = append(result, StorageClassRule{
+			Path:         rule.Path,
+			Extensions:   extensions,
+			MinSize:      rule.MinSize,
+			StorageClass: rule.StorageClass,
+		})
+	}
+	return result
+}
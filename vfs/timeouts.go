@@ -0,0 +1,180 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrOperationTimeout is returned if a filesystem operation does not complete within the configured timeout
+var ErrOperationTimeout = errors.New("filesystem operation timed out")
+
+var fsTimeouts FsTimeoutsConfig
+
+// OperationTimeouts defines the timeouts, in seconds, for the filesystem operations.
+// 0 means no timeout for the local filesystem and the built-in default for the other backends
+type OperationTimeouts struct {
+	// stat for a single file or directory
+	Stat int `json:"stat" mapstructure:"stat"`
+	// directory listing
+	List int `json:"list" mapstructure:"list"`
+	// open or create a file for a transfer
+	Open int `json:"open" mapstructure:"open"`
+	// each single read while downloading a file
+	Read int `json:"read" mapstructure:"read"`
+	// each single write while uploading a file
+	Write int `json:"write" mapstructure:"write"`
+}
+
+func (t *OperationTimeouts) validate() error {
+	if t.Stat < 0 || t.List < 0 || t.Open < 0 || t.Read < 0 || t.Write < 0 {
+		return errors.New("timeouts cannot be negative")
+	}
+	return nil
+}
+
+// GetStat returns the stat timeout
+func (t OperationTimeouts) GetStat() time.Duration {
+	return time.Duration(t.Stat) * time.Second
+}
+
+// GetList returns the directory listing timeout
+func (t OperationTimeouts) GetList() time.Duration {
+	return time.Duration(t.List) * time.Second
+}
+
+// GetOpen returns the open timeout
+func (t OperationTimeouts) GetOpen() time.Duration {
+	return time.Duration(t.Open) * time.Second
+}
+
+// GetRead returns the timeout for a single read
+func (t OperationTimeouts) GetRead() time.Duration {
+	return time.Duration(t.Read) * time.Second
+}
+
+// GetWrite returns the timeout for a single write
+func (t OperationTimeouts) GetWrite() time.Duration {
+	return time.Duration(t.Write) * time.Second
+}
+
+// FsTimeoutsConfig defines the filesystem operation timeouts for each storage backend.
+// A hung NFS mount or a throttled bucket fails a single operation instead of blocking
+// the connection handler
+type FsTimeoutsConfig struct {
+	// local filesystem, encrypted local filesystem included
+	Local       OperationTimeouts `json:"local" mapstructure:"local"`
+	S3          OperationTimeouts `json:"s3" mapstructure:"s3"`
+	GCS         OperationTimeouts `json:"gcs" mapstructure:"gcs"`
+	WebDAV      OperationTimeouts `json:"webdav" mapstructure:"webdav"`
+	HDFS        OperationTimeouts `json:"hdfs" mapstructure:"hdfs"`
+	GoogleDrive OperationTimeouts `json:"gdrive" mapstructure:"gdrive"`
+	Dropbox     OperationTimeouts `json:"dropbox" mapstructure:"dropbox"`
+}
+
+// SetFsTimeouts sets the filesystem operation timeouts
+func SetFsTimeouts(config FsTimeoutsConfig) error {
+	backends := map[string]*OperationTimeouts{
+		"local":   &config.Local,
+		"s3":      &config.S3,
+		"gcs":     &config.GCS,
+		"webdav":  &config.WebDAV,
+		"hdfs":    &config.HDFS,
+		"gdrive":  &config.GoogleDrive,
+		"dropbox": &config.Dropbox,
+	}
+	for name, t := range backends {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("invalid %v filesystem timeouts: %v", name, err)
+		}
+	}
+	fsTimeouts = config
+	return nil
+}
+
+// GetBackendTimeouts returns the operation timeouts for the given storage backend,
+// as returned by GetBackendType
+func GetBackendTimeouts(backend string) OperationTimeouts {
+	switch backend {
+	case "local", "crypt":
+		return fsTimeouts.Local
+	case "s3":
+		return fsTimeouts.S3
+	case "gcs":
+		return fsTimeouts.GCS
+	case "webdav":
+		return fsTimeouts.WebDAV
+	case "hdfs":
+		return fsTimeouts.HDFS
+	case "gdrive":
+		return fsTimeouts.GoogleDrive
+	case "dropbox":
+		return fsTimeouts.Dropbox
+	default:
+		return OperationTimeouts{}
+	}
+}
+
+// getOperationContext returns a context that expires after the configured timeout or
+// after the given default timeout if no timeout is configured
+func getOperationContext(timeout, defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithDeadline(context.Background(), time.Now().Add(timeout))
+}
+
+// RunWithTimeout executes fn and returns ErrOperationTimeout if it does not complete within
+// the given timeout, 0 means no timeout. Blocking system calls cannot be interrupted, so
+// after a timeout fn keeps running in background and its result is discarded
+func RunWithTimeout(timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrOperationTimeout
+	}
+}
+
+// openWithTimeout opens a local file using the given function. A file opened after
+// the timeout is closed as soon as the open completes
+func openWithTimeout(timeout time.Duration, openFn func() (*os.File, error)) (*os.File, error) {
+	if timeout <= 0 {
+		return openFn()
+	}
+	type openResult struct {
+		file *os.File
+		err  error
+	}
+	done := make(chan openResult, 1)
+	go func() {
+		f, err := openFn()
+		done <- openResult{file: f, err: err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.file, res.err
+	case <-timer.C:
+		go func() {
+			if res := <-done; res.file != nil {
+				res.file.Close()
+			}
+		}()
+		return nil, ErrOperationTimeout
+	}
+}
@@ -0,0 +1,303 @@
+package vfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	uploadJournalLogSender = "uploadJournal"
+	uploadJournalExt       = ".json"
+	// how often the interrupted uploads are checked to abort the expired ones
+	uploadJournalCheckInterval = 1 * time.Hour
+)
+
+var cloudUploadJournal *uploadJournal
+
+// UploadJournalConfig defines the journal for the multipart uploads to S3. The state of the
+// uploads in progress is stored inside the journal, so an upload interrupted by an error or
+// by a restart can be resumed by the client, uploading to the same path, or aborted
+type UploadJournalConfig struct {
+	// Path to the journal directory, empty to disable the journal
+	Path string `json:"path" mapstructure:"path"`
+	// Hours to keep an interrupted upload, after that the upload is aborted. 0 means 24
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+}
+
+// SetUploadJournalConfig enables the journal for the multipart uploads to S3.
+// The uploads interrupted before a restart and not yet expired can be resumed
+func SetUploadJournalConfig(config UploadJournalConfig) error {
+	if cloudUploadJournal != nil {
+		cloudUploadJournal.stop()
+		cloudUploadJournal = nil
+	}
+	if len(config.Path) == 0 {
+		return nil
+	}
+	if !filepath.IsAbs(config.Path) {
+		return fmt.Errorf("invalid upload journal path %#v, it must be absolute", config.Path)
+	}
+	if config.MaxAge < 0 {
+		return fmt.Errorf("invalid upload journal max age: %v", config.MaxAge)
+	}
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return err
+	}
+	maxAge := config.MaxAge
+	if maxAge == 0 {
+		maxAge = 24
+	}
+	journal := &uploadJournal{
+		dir:    config.Path,
+		maxAge: time.Duration(maxAge) * time.Hour,
+		active: make(map[string]bool),
+		done:   make(chan bool),
+	}
+	go journal.checkExpiredLoop()
+	cloudUploadJournal = journal
+	return nil
+}
+
+// uploadJournalPart is an uploaded part
+type uploadJournalPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// uploadJournalEntry is the state of a multipart upload
+type uploadJournalEntry struct {
+	id           string
+	Name         string              `json:"name"`
+	UploadID     string              `json:"upload_id"`
+	PartSize     int64               `json:"part_size"`
+	StorageClass string              `json:"storage_class,omitempty"`
+	Parts        []uploadJournalPart `json:"parts"`
+	// filesystem configuration, the secrets are encrypted. It is used to abort the expired uploads
+	S3Config  S3FsConfig `json:"s3config"`
+	CreatedAt int64      `json:"created_at"`
+	UpdatedAt int64      `json:"updated_at"`
+}
+
+// addPart adds or replaces the given part, the parts are sorted by number
+func (e *uploadJournalEntry) addPart(part uploadJournalPart) {
+	idx := sort.Search(len(e.Parts), func(i int) bool {
+		return e.Parts[i].Number >= part.Number
+	})
+	if idx < len(e.Parts) && e.Parts[idx].Number == part.Number {
+		e.Parts[idx] = part
+		return
+	}
+	e.Parts = append(e.Parts, uploadJournalPart{})
+	copy(e.Parts[idx+1:], e.Parts[idx:])
+	e.Parts[idx] = part
+}
+
+// getResumableParts returns the contiguous parts, starting from the first one, with the
+// configured part size. A smaller part can only be the last one so it is uploaded again
+func (e *uploadJournalEntry) getResumableParts() []uploadJournalPart {
+	var parts []uploadJournalPart
+	for idx, part := range e.Parts {
+		if part.Number != int64(idx+1) || part.Size != e.PartSize {
+			break
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// getResumableSize returns the size an interrupted upload can be resumed from
+func (e *uploadJournalEntry) getResumableSize() int64 {
+	return int64(len(e.getResumableParts())) * e.PartSize
+}
+
+func (e *uploadJournalEntry) isExpired(maxAge time.Duration) bool {
+	return time.Since(utils.GetTimeFromMsecSinceEpoch(e.UpdatedAt)) > maxAge
+}
+
+// uploadJournal stores an entry for each multipart upload inside a local directory, the file name
+// is an hash of the backend, of the credentials and of the object name
+type uploadJournal struct {
+	sync.Mutex
+	dir    string
+	maxAge time.Duration
+	// the uploads in progress inside this process
+	active map[string]bool
+	done   chan bool
+}
+
+// getUploadJournalID returns the journal ID for the object with the given name stored on the
+// given backend. The credentials are included so an upload is resumed only by the same credentials
+func getUploadJournalID(backend, credentials, name string) string {
+	h := sha256.Sum256([]byte(backend + "\x00" + credentials + "\x00" + name))
+	return hex.EncodeToString(h[:])
+}
+
+func (j *uploadJournal) getEntryPath(id string) string {
+	return filepath.Join(j.dir, id+uploadJournalExt)
+}
+
+// acquire marks the upload with the given ID as in progress, it returns false if another upload
+// for the same object is already in progress
+func (j *uploadJournal) acquire(id string) bool {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.active[id] {
+		return false
+	}
+	j.active[id] = true
+	return true
+}
+
+func (j *uploadJournal) release(id string) {
+	j.Lock()
+	defer j.Unlock()
+
+	delete(j.active, id)
+}
+
+func (j *uploadJournal) isActive(id string) bool {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.active[id]
+}
+
+func (j *uploadJournal) load(id string) (*uploadJournalEntry, error) {
+	data, err := ioutil.ReadFile(j.getEntryPath(id))
+	if err != nil {
+		return nil, err
+	}
+	entry := &uploadJournalEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	entry.id = id
+	return entry, nil
+}
+
+// save writes the given entry to a temporary file and then renames it, so a crash never
+// leaves a partially written entry
+func (j *uploadJournal) save(entry *uploadJournalEntry) error {
+	entry.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(j.dir, entry.id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), j.getEntryPath(entry.id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (j *uploadJournal) remove(id string) {
+	if err := os.Remove(j.getEntryPath(id)); err != nil && !os.IsNotExist(err) {
+		logger.Warn(uploadJournalLogSender, "", "unable to remove the journal entry %#v: %v", id, err)
+	}
+}
+
+func (j *uploadJournal) stop() {
+	close(j.done)
+}
+
+func (j *uploadJournal) checkExpiredLoop() {
+	j.checkExpired()
+	ticker := time.NewTicker(uploadJournalCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.checkExpired()
+		}
+	}
+}
+
+// checkExpired aborts the interrupted uploads not resumed within the max age
+func (j *uploadJournal) checkExpired() {
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		logger.Warn(uploadJournalLogSender, "", "unable to read the journal directory: %v", err)
+		return
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			// left by a crash while saving an entry
+			if time.Since(fi.ModTime()) > j.maxAge {
+				os.Remove(filepath.Join(j.dir, name))
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, uploadJournalExt) {
+			continue
+		}
+		j.checkExpiredEntry(strings.TrimSuffix(name, uploadJournalExt))
+	}
+}
+
+func (j *uploadJournal) checkExpiredEntry(id string) {
+	// an upload in progress is never expired
+	if !j.acquire(id) {
+		return
+	}
+	defer j.release(id)
+
+	entry, err := j.load(id)
+	if err != nil {
+		logger.Warn(uploadJournalLogSender, "", "unable to load the journal entry %#v: %v", id, err)
+		return
+	}
+	if !entry.isExpired(j.maxAge) {
+		return
+	}
+	if err = abortExpiredUpload(entry); err != nil {
+		logger.Warn(uploadJournalLogSender, "", "unable to abort the expired upload for %#v, it will be retried: %v",
+			entry.Name, err)
+		return
+	}
+	logger.Info(uploadJournalLogSender, "", "expired upload for %#v aborted, upload ID: %#v", entry.Name, entry.UploadID)
+	j.remove(id)
+}
+
+// abortExpiredUpload aborts the given upload using the filesystem configuration stored
+// inside the journal entry
+func abortExpiredUpload(entry *uploadJournalEntry) error {
+	fs, err := NewS3Fs("", "", entry.S3Config)
+	if err != nil {
+		return err
+	}
+	return fs.(S3Fs).abortMultipartUpload(entry)
+}
@@ -0,0 +1,17 @@
+// +build !windows
+
+package vfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// getAllocatedSize returns the disk space allocated for the given file.
+// st_blocks is always expressed in 512 bytes units
+func getAllocatedSize(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Blocks) * 512
+	}
+	return info.Size()
+}
@@ -0,0 +1,9 @@
+package vfs
+
+import "os"
+
+// getAllocatedSize returns the logical file size, the allocated disk space
+// is not available in the file info on Windows
+func getAllocatedSize(info os.FileInfo) int64 {
+	return info.Size()
+}
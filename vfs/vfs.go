@@ -2,27 +2,98 @@
 package vfs
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
-	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
 )
 
+// access rights, as defined for Windows ACLs, checked before accessing local paths
+const (
+	fileReadData   = 0x1
+	fileWriteData  = 0x2
+	fileAppendData = 0x4
+	accessDelete   = 0x10000
+)
+
+var (
+	windowsACLConfig WindowsACLConfig
+	useAllocatedSize bool
+)
+
+// WindowsACLConfig defines the ownership and the access checks to apply, on Windows, to
+// the paths managed using the local filesystem. It is ignored on other operating systems
+type WindowsACLConfig struct {
+	// Account name or SID to set as owner for new files and directories.
+	// Leave empty to keep the default owner, the account running SFTPGo
+	Owner string `json:"owner" mapstructure:"owner"`
+	// Group name or SID to set as primary group for new files and directories.
+	// Leave empty to keep the default group
+	Group string `json:"group" mapstructure:"group"`
+	// If enabled, the access denied entries defined for the configured owner, the configured
+	// group or "Everyone" are honored: a path is not accessed if the requested access is denied
+	HonorDenyACLs bool `json:"honor_deny_acls" mapstructure:"honor_deny_acls"`
+}
+
+// SetWindowsACLConfig sets the ownership and the access checks to apply on Windows
+// to the paths managed using the local filesystem
+func SetWindowsACLConfig(config WindowsACLConfig) error {
+	windowsACLConfig = config
+	return applyWindowsACLConfig(config)
+}
+
+// SetUseAllocatedSize sets if the disk space allocated for local files, instead of their
+// logical size, must be used for quota accounting
+func SetUseAllocatedSize(enabled bool) {
+	useAllocatedSize = enabled
+}
+
+// GetFileUsage returns the size to use for quota accounting for the given file.
+// This is the allocated disk space for local files if enabled and supported,
+// the logical size otherwise
+func GetFileUsage(fs Fs, info os.FileInfo) int64 {
+	if IsLocalOsFs(fs) {
+		return getLocalFileUsage(info)
+	}
+	return info.Size()
+}
+
+// PipeReader is the reader returned by Open for backends that stream the file
+// contents instead of exposing a local file
+type PipeReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// PipeWriter is the writer returned by Create for backends that stream the file
+// contents instead of exposing a local file.
+// Close returns after the backend has completed the upload
+type PipeWriter interface {
+	io.Writer
+	io.WriterAt
+	io.Closer
+}
+
 // Fs defines the interface for filesystem backends
 type Fs interface {
 	Name() string
 	ConnectionID() string
 	Stat(name string) (os.FileInfo, error)
 	Lstat(name string) (os.FileInfo, error)
-	Open(name string) (*os.File, *pipeat.PipeReaderAt, func(), error)
-	Create(name string, flag int) (*os.File, *pipeat.PipeWriterAt, func(), error)
+	Open(name string) (*os.File, PipeReader, func(), error)
+	Create(name string, flag int) (*os.File, PipeWriter, func(), error)
 	Rename(source, target string) error
 	Remove(name string, isDir bool) error
 	Mkdir(name string) error
@@ -30,6 +101,7 @@ type Fs interface {
 	Chown(name string, uid int, gid int) error
 	Chmod(name string, mode os.FileMode) error
 	Chtimes(name string, atime, mtime time.Time) error
+	Truncate(name string, size int64) error
 	ReadDir(dirname string) ([]os.FileInfo, error)
 	IsUploadResumeSupported() bool
 	IsAtomicUploadSupported() bool
@@ -38,6 +110,7 @@ type Fs interface {
 	IsNotExist(err error) bool
 	IsPermission(err error) bool
 	ScanRootDirContents() (int, int64, error)
+	Walk(root string, walkFn filepath.WalkFunc) error
 	GetAtomicUploadPath(name string) string
 	GetRelativePath(name string) string
 	Join(elem ...string) string
@@ -49,9 +122,36 @@ type Fs interface {
 // it must be a sub directory. The parent directory for the specified virtual
 // path must exist. SFTPGo will try to automatically create any missing
 // parent directory for the configured virtual folders at user login.
+// A virtual folder can also be stored on its own filesystem backend, for example
+// an S3 bucket, in this case the mapped path is ignored.
+// A virtual folder can reference a shared folder defined inside the data provider,
+// in this case the mapped path is the shared folder one and the used quota is tracked
+// for the shared folder instead of the user
 type VirtualFolder struct {
 	VirtualPath string `json:"virtual_path"`
 	MappedPath  string `json:"mapped_path"`
+	// optional name for the referenced shared folder
+	Name string `json:"name,omitempty"`
+	// optional filesystem backend for this folder, if nil the folder is stored inside the mapped path
+	Filesystem *VirtualFolderFilesystem `json:"filesystem,omitempty"`
+}
+
+// IsShared returns true if the folder references a shared folder
+func (v *VirtualFolder) IsShared() bool {
+	return len(v.Name) > 0
+}
+
+// HasFilesystem returns true if the folder is stored on its own filesystem backend
+func (v *VirtualFolder) HasFilesystem() bool {
+	return v.Filesystem != nil && v.Filesystem.Provider != 0
+}
+
+// VirtualFolderFilesystem defines the filesystem backend for a virtual folder
+type VirtualFolderFilesystem struct {
+	// 0 local mapped path, 1 Amazon S3 compatible, 2 Google Cloud Storage
+	Provider  int         `json:"provider"`
+	S3Config  S3FsConfig  `json:"s3config,omitempty"`
+	GCSConfig GCSFsConfig `json:"gcsconfig,omitempty"`
 }
 
 // IsDirectory checks if a path exists and is a directory
@@ -80,6 +180,37 @@ func IsLocalOsFs(fs Fs) bool {
 	return fs.Name() == osFsName
 }
 
+// GetBackendType returns a short name for the storage backend that handles the given
+// filesystem path, for example "local" or "s3". The returned values are bounded, so they
+// can be used to partition the metrics
+func GetBackendType(fs Fs, name string) string {
+	switch v := fs.(type) {
+	case *FoldersFs:
+		backend, _, _ := v.route(name)
+		return GetBackendType(backend, name)
+	case *ReadOnlyFs:
+		return GetBackendType(v.fs, name)
+	case *CryptFs:
+		return "crypt"
+	case *OsFs:
+		return "local"
+	case S3Fs:
+		return "s3"
+	case GCSFs:
+		return "gcs"
+	case WebDAVFs:
+		return "webdav"
+	case HDFSFs:
+		return "hdfs"
+	case GoogleDriveFs:
+		return "gdrive"
+	case DropboxFs:
+		return "dropbox"
+	default:
+		return "other"
+	}
+}
+
 // ValidateS3FsConfig returns nil if the specified s3 config is valid, otherwise an error
 func ValidateS3FsConfig(config *S3FsConfig) error {
 	if len(config.Bucket) == 0 {
@@ -94,6 +225,15 @@ func ValidateS3FsConfig(config *S3FsConfig) error {
 	if len(config.AccessSecret) == 0 && len(config.AccessKey) > 0 {
 		return errors.New("access_secret cannot be empty with access_key not empty")
 	}
+	if len(config.SessionToken) > 0 && len(config.AccessKey) == 0 {
+		return errors.New("session_token cannot be used without access_key")
+	}
+	if len(config.RoleARN) > 0 && !strings.HasPrefix(config.RoleARN, "arn:") {
+		return fmt.Errorf("invalid role_arn %#v", config.RoleARN)
+	}
+	if len(config.ExternalID) > 0 && len(config.RoleARN) == 0 {
+		return errors.New("external_id cannot be used without role_arn")
+	}
 	if len(config.KeyPrefix) > 0 {
 		if strings.HasPrefix(config.KeyPrefix, "/") {
 			return errors.New("key_prefix cannot start with /")
@@ -109,7 +249,19 @@ func ValidateS3FsConfig(config *S3FsConfig) error {
 	if config.UploadConcurrency < 0 {
 		return fmt.Errorf("invalid upload concurrency: %v", config.UploadConcurrency)
 	}
-	return nil
+	if err := validateDownloadSettings(config.DownloadPartSize, config.DownloadConcurrency); err != nil {
+		return err
+	}
+	config.CABundle = strings.TrimSpace(config.CABundle)
+	if len(config.CABundle) > 0 {
+		if config.SkipTLSVerify {
+			return errors.New("ca_bundle cannot be used with skip_tls_verify")
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(config.CABundle)) {
+			return errors.New("ca_bundle does not contain any valid PEM encoded certificate")
+		}
+	}
+	return validateStorageClassRules(config.StorageClassRules)
 }
 
 // ValidateGCSFsConfig returns nil if the specified GCS config is valid, otherwise an error
@@ -135,14 +287,189 @@ func ValidateGCSFsConfig(config *GCSFsConfig, credentialsFilePath string) error
 			return errors.New("credentials cannot be empty")
 		}
 	}
+	if err := validateDownloadSettings(config.DownloadPartSize, config.DownloadConcurrency); err != nil {
+		return err
+	}
+	return validateStorageClassRules(config.StorageClassRules)
+}
+
+func validateDownloadSettings(partSize int64, concurrency int) error {
+	if partSize != 0 && partSize < 5 {
+		return errors.New("download_part_size cannot be != 0 and lower than 5 (MB)")
+	}
+	if concurrency < 0 {
+		return fmt.Errorf("invalid download concurrency: %v", concurrency)
+	}
 	return nil
 }
 
+// ValidateWebDAVFsConfig returns nil if the specified WebDAV config is valid, otherwise an error
+func ValidateWebDAVFsConfig(config *WebDAVFsConfig) error {
+	if len(config.Endpoint) == 0 {
+		return errors.New("endpoint cannot be empty")
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid endpoint %#v, it must be an http or https URL", config.Endpoint)
+	}
+	if len(config.Password) > 0 && len(config.Username) == 0 {
+		return errors.New("username cannot be empty with password not empty")
+	}
+	if len(config.BearerToken) > 0 && len(config.Username) > 0 {
+		return errors.New("bearer_token cannot be used together with username and password")
+	}
+	if len(config.RootPath) > 0 {
+		if strings.HasPrefix(config.RootPath, "/") {
+			return errors.New("root_path cannot start with /")
+		}
+		config.RootPath = path.Clean(config.RootPath)
+		if !strings.HasSuffix(config.RootPath, "/") {
+			config.RootPath += "/"
+		}
+	}
+	return nil
+}
+
+// ValidateHDFSFsConfig returns nil if the specified HDFS config is valid, otherwise an error
+func ValidateHDFSFsConfig(config *HDFSFsConfig) error {
+	if len(config.Endpoint) == 0 {
+		return errors.New("endpoint cannot be empty")
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid endpoint %#v, it must be an http or https WebHDFS URL", config.Endpoint)
+	}
+	if len(config.DelegationToken) > 0 && len(config.Username) > 0 {
+		return errors.New("delegation_token cannot be used together with username")
+	}
+	if len(config.RootPath) > 0 {
+		if strings.HasPrefix(config.RootPath, "/") {
+			return errors.New("root_path cannot start with /")
+		}
+		config.RootPath = path.Clean(config.RootPath)
+		if !strings.HasSuffix(config.RootPath, "/") {
+			config.RootPath += "/"
+		}
+	}
+	return nil
+}
+
+// ValidateGoogleDriveFsConfig returns nil if the specified Google Drive config is valid, otherwise an error
+func ValidateGoogleDriveFsConfig(config *GoogleDriveFsConfig) error {
+	config.FolderID = strings.TrimSpace(config.FolderID)
+	if strings.ContainsAny(config.FolderID, "/' ") {
+		return fmt.Errorf("invalid folder_id %#v", config.FolderID)
+	}
+	if len(config.Credentials) > 0 && len(config.RefreshToken) > 0 {
+		return errors.New("credentials cannot be used together with refresh_token")
+	}
+	if len(config.Credentials) > 0 {
+		if len(config.ClientID) > 0 || len(config.ClientSecret) > 0 {
+			return errors.New("client_id and client_secret cannot be used together with credentials")
+		}
+		// the credentials are validated only if they are not encrypted yet
+		if !kms.IsEncrypted(config.Credentials) {
+			if err := validateGoogleServiceAccount(config.Credentials); err != nil {
+				return err
+			}
+		}
+	} else {
+		if len(config.RefreshToken) == 0 {
+			return errors.New("service account credentials or a refresh token are required")
+		}
+		if len(config.ClientID) == 0 || len(config.ClientSecret) == 0 {
+			return errors.New("client_id and client_secret are required to use a refresh token")
+		}
+		if len(config.Subject) > 0 {
+			return errors.New("subject can be used only together with the service account credentials")
+		}
+	}
+	if len(config.Endpoint) > 0 {
+		u, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("invalid endpoint %#v, it must be an http or https URL", config.Endpoint)
+		}
+	}
+	return nil
+}
+
+// ValidateDropboxFsConfig returns nil if the specified Dropbox config is valid, otherwise an error
+func ValidateDropboxFsConfig(config *DropboxFsConfig) error {
+	if len(config.AccessToken) > 0 && len(config.RefreshToken) > 0 {
+		return errors.New("access_token cannot be used together with refresh_token")
+	}
+	if len(config.AccessToken) == 0 && len(config.RefreshToken) == 0 {
+		return errors.New("an access token or a refresh token is required")
+	}
+	if len(config.RefreshToken) > 0 {
+		if len(config.AppKey) == 0 {
+			return errors.New("app_key is required to use a refresh token")
+		}
+	} else if len(config.AppKey) > 0 || len(config.AppSecret) > 0 {
+		return errors.New("app_key and app_secret can be used only together with refresh_token")
+	}
+	if len(config.RootPath) > 0 {
+		if strings.HasPrefix(config.RootPath, "/") {
+			return errors.New("root_path cannot start with /")
+		}
+		config.RootPath = path.Clean(config.RootPath)
+		if !strings.HasSuffix(config.RootPath, "/") {
+			config.RootPath += "/"
+		}
+	}
+	if config.UploadChunkSize < 0 || config.UploadChunkSize > dropboxMaxUploadChunkSize {
+		return fmt.Errorf("invalid upload_chunk_size %v, it must be between 1 and %v (MB) or 0 for the default",
+			config.UploadChunkSize, dropboxMaxUploadChunkSize)
+	}
+	if len(config.Endpoint) > 0 {
+		u, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("invalid endpoint %#v, it must be an http or https URL", config.Endpoint)
+		}
+	}
+	return nil
+}
+
+// GetExtendedAttributesToPreserve returns the extended attributes in the user namespace and the
+// POSIX ACLs for the given local path. It returns nil in network filesystem mode and on platforms
+// without extended attributes support
+func GetExtendedAttributesToPreserve(name string) (map[string][]byte, error) {
+	if IsNetworkFsMode() {
+		return nil, nil
+	}
+	return getXattrsToPreserve(name)
+}
+
+// SetExtendedAttributes sets the given extended attributes for the given local path.
+// Attributes already defined for the path are preserved, the POSIX ACLs that cannot be set
+// because of missing privileges or filesystem support are skipped
+func SetExtendedAttributes(name string, attrs map[string][]byte) error {
+	if IsNetworkFsMode() {
+		return nil
+	}
+	return setXattrs(name, attrs)
+}
+
 // SetPathPermissions calls fs.Chown.
-// It does nothing for local filesystem on windows
+// For local filesystem on windows the configured owner and group, if any, are applied
 func SetPathPermissions(fs Fs, path string, uid int, gid int) {
 	if IsLocalOsFs(fs) {
 		if runtime.GOOS == "windows" {
+			if err := setPathOwnership(path); err != nil {
+				fsLog(fs, logger.LevelWarn, "error setting ownership for path %v: %v", path, err)
+			}
 			return
 		}
 	}
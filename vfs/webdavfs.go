@@ -0,0 +1,533 @@
+package vfs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/eikenb/pipeat"
+)
+
+const webDAVPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// WebDAVFsConfig defines the configuration for a remote WebDAV server based filesystem,
+// for example Nextcloud or ownCloud
+type WebDAVFsConfig struct {
+	// WebDAV endpoint URL, for example https://cloud.example.com/remote.php/dav/files/username
+	Endpoint string `json:"endpoint,omitempty"`
+	// username and password for basic authentication
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// token for bearer authentication, it cannot be used together with basic authentication
+	BearerToken string `json:"bearer_token,omitempty"`
+	// RootPath is similar to a chroot directory for local filesystem.
+	// If specified the SFTP user will only see the contents of this
+	// directory, relative to the endpoint. The root path, if not empty,
+	// must not start with "/" and must end with "/".
+	// If empty the whole endpoint contents will be available
+	RootPath string `json:"root_path,omitempty"`
+}
+
+// WebDAVFs is a Fs implementation for remote WebDAV servers.
+type WebDAVFs struct {
+	connectionID   string
+	localTempDir   string
+	config         WebDAVFsConfig
+	endpoint       *url.URL
+	client         *http.Client
+	ctxTimeout     time.Duration
+	ctxLongTimeout time.Duration
+}
+
+type webDAVMultistatus struct {
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+type webDAVResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+type webDAVPropstat struct {
+	Prop struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+	} `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type webDAVError struct {
+	method     string
+	name       string
+	statusCode int
+}
+
+func init() {
+	utils.AddFeature("+webdav")
+}
+
+func (e *webDAVError) Error() string {
+	return fmt.Sprintf("%v %#v: %v %v", e.method, e.name, e.statusCode, http.StatusText(e.statusCode))
+}
+
+// NewWebDAVFs returns a WebDAVFs object that allows to interact with a remote WebDAV server
+func NewWebDAVFs(connectionID, localTempDir string, config WebDAVFsConfig) (Fs, error) {
+	fs := WebDAVFs{
+		connectionID:   connectionID,
+		localTempDir:   localTempDir,
+		config:         config,
+		client:         &http.Client{},
+		ctxTimeout:     30 * time.Second,
+		ctxLongTimeout: 300 * time.Second,
+	}
+	if err := ValidateWebDAVFsConfig(&fs.config); err != nil {
+		return fs, err
+	}
+	var err error
+	if len(fs.config.Password) > 0 {
+		fs.config.Password, err = kms.Decrypt(fs.config.Password)
+		if err != nil {
+			return fs, err
+		}
+	}
+	if len(fs.config.BearerToken) > 0 {
+		fs.config.BearerToken, err = kms.Decrypt(fs.config.BearerToken)
+		if err != nil {
+			return fs, err
+		}
+	}
+	fs.endpoint, err = url.Parse(fs.config.Endpoint)
+	return fs, err
+}
+
+// Name returns the name for the Fs implementation
+func (fs WebDAVFs) Name() string {
+	return fmt.Sprintf("WebDAVFs endpoint: %#v", fs.config.Endpoint)
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs WebDAVFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs WebDAVFs) Stat(name string) (os.FileInfo, error) {
+	infos, err := fs.propfind(name, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, &webDAVError{method: "PROPFIND", name: name, statusCode: http.StatusNotFound}
+	}
+	info := infos[0]
+	return NewFileInfo(path.Base(name), info.IsDir(), info.Size(), info.ModTime()), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs WebDAVFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs WebDAVFs) Open(name string) (*os.File, PipeReader, func(), error) {
+	r, w, err := pipeat.AsyncWriterPipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	resp, err := fs.doRequest(ctx, http.MethodGet, name, false, nil, nil)
+	if err != nil {
+		r.Close()
+		w.Close()
+		cancelFn()
+		return nil, nil, nil, err
+	}
+	go func() {
+		defer cancelFn()
+		defer resp.Body.Close()
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs WebDAVFs) Create(name string, flag int) (*os.File, PipeWriter, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	go func() {
+		defer cancelFn()
+		// the HTTP client closes the request body, the pipe must be closed only once
+		// and with the upload error, if any
+		resp, err := fs.doRequest(ctx, http.MethodPut, name, false, ioutil.NopCloser(r), nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		r.CloseWithError(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, err: %v", name, err)
+	}()
+	return nil, w, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// Directories are moved by the WebDAV server including their contents
+func (fs WebDAVFs) Rename(source, target string) error {
+	if source == target {
+		return nil
+	}
+	fi, err := fs.Stat(source)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+	headers := map[string]string{
+		"Destination": fs.getURL(target, fi.IsDir()),
+		"Overwrite":   "T",
+	}
+	resp, err := fs.doRequest(ctx, "MOVE", source, fi.IsDir(), nil, headers)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs WebDAVFs) Remove(name string, isDir bool) error {
+	if isDir {
+		contents, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(contents) > 0 {
+			return fmt.Errorf("Cannot remove non empty directory: %#v", name)
+		}
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	resp, err := fs.doRequest(ctx, "DELETE", name, isDir, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs WebDAVFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	resp, err := fs.doRequest(ctx, "MKCOL", name, true, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Symlink creates source as a symbolic link to target.
+func (WebDAVFs) Symlink(source, target string) error {
+	return errors.New("403 symlinks are not supported")
+}
+
+// Chown changes the numeric uid and gid of the named file.
+// Silently ignored.
+func (WebDAVFs) Chown(name string, uid int, gid int) error {
+	return nil
+}
+
+// Chmod changes the mode of the named file to mode.
+// Silently ignored.
+func (WebDAVFs) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file.
+// Chtimes is not supported for WebDAV
+func (WebDAVFs) Chtimes(name string, atime, mtime time.Time) error {
+	return errors.New("403 chtimes is not supported")
+}
+
+// Truncate changes the size of the named file.
+// Truncate is not supported for WebDAV
+func (WebDAVFs) Truncate(name string, size int64) error {
+	return errors.New("403 truncate is not supported")
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs WebDAVFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	infos, err := fs.propfind(dirname, "1")
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, &webDAVError{method: "PROPFIND", name: dirname, statusCode: http.StatusNotFound}
+	}
+	// the first entry is the directory itself
+	return infos[1:], nil
+}
+
+// IsUploadResumeSupported returns true if upload resume is supported.
+// SFTP Resume is not supported on WebDAV
+func (WebDAVFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+// The WebDAV servers usually store the uploaded files only after a
+// successful upload, we don't need to upload to a temporary file
+func (WebDAVFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (WebDAVFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*webDAVError); ok {
+		return e.statusCode == http.StatusNotFound
+	}
+	return strings.Contains(err.Error(), "404")
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (WebDAVFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*webDAVError); ok {
+		return e.statusCode == http.StatusForbidden || e.statusCode == http.StatusUnauthorized
+	}
+	return strings.Contains(err.Error(), "403")
+}
+
+// CheckRootPath creates the configured root path, if any, if it does not exists
+func (fs WebDAVFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, nil)
+	osFs.CheckRootPath(username, uid, gid)
+	dir := ""
+	for _, name := range strings.Split(strings.TrimSuffix(fs.config.RootPath, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+		dir = path.Join(dir, name)
+		_, err := fs.Stat(dir)
+		if fs.IsNotExist(err) {
+			err = fs.Mkdir(dir)
+			fsLog(fs, logger.LevelDebug, "root path %#v for user %#v does not exist, try to create, mkdir error: %v",
+				dir, username, err)
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in the root path,
+// and their size
+func (fs WebDAVFs) ScanRootDirContents() (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	err := fs.Walk(fs.config.RootPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			numFiles++
+			size += info.Size()
+		}
+		return nil
+	})
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. A PROPFIND request is sent for each directory
+func (fs WebDAVFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	root = strings.TrimSuffix(root, "/")
+	info, err := fs.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = fs.walk(root, info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+// WebDAV uploads are already atomic, we never call this method for WebDAV
+func (WebDAVFs) GetAtomicUploadPath(name string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTP users
+func (fs WebDAVFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if len(fs.config.RootPath) > 0 {
+		if !strings.HasPrefix(rel, "/"+fs.config.RootPath) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, "/"+fs.config.RootPath))
+	}
+	return rel
+}
+
+// Join joins any number of path elements into a single path
+func (WebDAVFs) Join(elem ...string) string {
+	return strings.TrimPrefix(path.Join(elem...), "/")
+}
+
+// ResolvePath returns the matching filesystem path for the specified sftp path
+func (fs WebDAVFs) ResolvePath(sftpPath string) (string, error) {
+	if !path.IsAbs(sftpPath) {
+		sftpPath = path.Clean("/" + sftpPath)
+	}
+	return fs.Join(fs.config.RootPath, strings.TrimPrefix(sftpPath, "/")), nil
+}
+
+func (fs WebDAVFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	contents, err := fs.ReadDir(name)
+	err1 := walkFn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range contents {
+		err = fs.walk(fs.Join(name, fi.Name()), fi, walkFn)
+		if err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getURL returns the escaped URL for the given path, directories URL end with "/"
+func (fs WebDAVFs) getURL(name string, isDir bool) string {
+	u := *fs.endpoint
+	u.Path = path.Join("/", u.Path, name)
+	if isDir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+func (fs WebDAVFs) doRequest(ctx context.Context, method, name string, isDir bool, body io.Reader,
+	headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, fs.getURL(name, isDir), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if len(fs.config.BearerToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+fs.config.BearerToken)
+	} else if len(fs.config.Username) > 0 {
+		req.SetBasicAuth(fs.config.Username, fs.config.Password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &webDAVError{method: method, name: name, statusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// propfind returns the file infos for the given path and, if depth is "1", for its
+// direct children. The first returned info is for the requested path
+func (fs WebDAVFs) propfind(name, depth string) ([]os.FileInfo, error) {
+	timeout := fsTimeouts.WebDAV.GetStat()
+	if depth != "0" {
+		timeout = fsTimeouts.WebDAV.GetList()
+	}
+	ctx, cancelFn := getOperationContext(timeout, fs.ctxTimeout)
+	defer cancelFn()
+	headers := map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}
+	resp, err := fs.doRequest(ctx, "PROPFIND", name, depth != "0", strings.NewReader(webDAVPropfindBody), headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms webDAVMultistatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		return nil, err
+	}
+	requestPath := strings.TrimSuffix(path.Join("/", fs.endpoint.Path, name), "/")
+	var self os.FileInfo
+	var result []os.FileInfo
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			fsLog(fs, logger.LevelWarn, "invalid href %#v in PROPFIND response for %#v: %v", r.Href, name, err)
+			continue
+		}
+		hrefPath := strings.TrimSuffix(href.Path, "/")
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			isDir := ps.Prop.ResourceType.Collection != nil
+			size, _ := strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			modTime, _ := http.ParseTime(ps.Prop.LastModified)
+			if hrefPath == requestPath {
+				self = NewFileInfo(path.Base(name), isDir, size, modTime)
+			} else {
+				result = append(result, NewFileInfo(path.Base(hrefPath), isDir, size, modTime))
+			}
+			break
+		}
+	}
+	if self == nil {
+		return nil, nil
+	}
+	return append([]os.FileInfo{self}, result...), nil
+}
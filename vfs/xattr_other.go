@@ -0,0 +1,12 @@
+// +build !linux,!darwin
+
+package vfs
+
+// extended attributes are not supported on this platform
+func getXattrsToPreserve(name string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattrs(name string, attrs map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+// +build linux darwin
+
+package vfs
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"golang.org/x/sys/unix"
+)
+
+// the extended attributes in this namespace and the POSIX ACLs are preserved, the other
+// namespaces are reserved to the system or require additional privileges
+const userXattrPrefix = "user."
+
+// posixACLXattrs are the extended attributes used on Linux to store the POSIX ACLs. Setting them
+// requires the ownership of the file or the CAP_FOWNER capability and a filesystem with ACLs support
+var posixACLXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+func isXattrToPreserve(attr string) bool {
+	return strings.HasPrefix(attr, userXattrPrefix) || utils.IsStringInSlice(attr, posixACLXattrs)
+}
+
+// getXattrsToPreserve returns the extended attributes in the user namespace and the POSIX ACLs
+// for the given path
+func getXattrsToPreserve(name string) (map[string][]byte, error) {
+	names, err := listXattrs(name)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	attrs := make(map[string][]byte)
+	for _, attr := range names {
+		if !isXattrToPreserve(attr) {
+			continue
+		}
+		value, err := getXattr(name, attr)
+		if err != nil {
+			return nil, err
+		}
+		attrs[attr] = value
+	}
+	return attrs, nil
+}
+
+// setXattrs sets the given extended attributes for the given path.
+// Attributes already defined for the path are not overwritten. The POSIX ACLs that cannot be set,
+// because of missing privileges or filesystem support, are skipped
+func setXattrs(name string, attrs map[string][]byte) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	existingNames, err := listXattrs(name)
+	if err != nil {
+		return err
+	}
+	for attr, value := range attrs {
+		if utils.IsStringInSlice(attr, existingNames) {
+			continue
+		}
+		if err = unix.Setxattr(name, attr, value, 0); err != nil {
+			if utils.IsStringInSlice(attr, posixACLXattrs) && (err == unix.EPERM || err == unix.ENOTSUP ||
+				err == unix.EOPNOTSUPP) {
+				logger.Debug(osFsName, "", "unable to set %#v for %#v: %v", attr, name, err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func listXattrs(name string) ([]string, error) {
+	size, err := unix.Listxattr(name, nil)
+	if err != nil || size <= 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(name, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range bytes.Split(buf[:size], []byte{0}) {
+		if len(n) > 0 {
+			names = append(names, string(n))
+		}
+	}
+	return names, nil
+}
+
+func getXattr(name, attr string) ([]byte, error) {
+	size, err := unix.Getxattr(name, attr, nil)
+	if err != nil || size <= 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(name, attr, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}